@@ -14,6 +14,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/openai/openai-go v1.12.0
 	golang.org/x/crypto v0.42.0
+	golang.org/x/net v0.44.0
 )
 
 require (
@@ -44,7 +45,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.5.0 // indirect
-	golang.org/x/net v0.44.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
 	golang.org/x/text v0.29.0 // indirect
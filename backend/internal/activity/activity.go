@@ -0,0 +1,212 @@
+package activity
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// Event types shown in a project's activity feed
+const (
+	EventConversationCreated  = "conversation_created"
+	EventConversationDeleted  = "conversation_deleted"
+	EventConversationPinned   = "conversation_pinned"
+	EventConversationUnpinned = "conversation_unpinned"
+	EventToolExecutionFailed  = "tool_execution_failed"
+	EventDatasourceCreated    = "datasource_created"
+	EventDatasourceUpdated    = "datasource_updated"
+	EventParticipantAdded     = "participant_added"
+)
+
+// EventTypes lists every event type, for validating filters
+var EventTypes = []string{
+	EventConversationCreated,
+	EventConversationDeleted,
+	EventConversationPinned,
+	EventConversationUnpinned,
+	EventToolExecutionFailed,
+	EventDatasourceCreated,
+	EventDatasourceUpdated,
+	EventParticipantAdded,
+}
+
+// Entity types an event refers to
+const (
+	EntityConversation = "conversation"
+	EntityDatasource   = "datasource"
+)
+
+// MessageType is the WebSocket message broadcast to the project room for each recorded event
+const MessageType = "activity_event"
+
+const (
+	// DefaultPageSize is how many events a page holds when no limit is asked for
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page of events that may be asked for
+	MaxPageSize = 200
+)
+
+// ErrInvalidCursor is returned when a cursor is not an event of the project
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Event is something a user, or a tool acting for them, did in a project
+type Event struct {
+	ID          string                 `json:"id"`
+	ProjectID   string                 `json:"project_id"`
+	ActorUserID string                 `json:"actor_user_id,omitempty"`
+	EventType   string                 `json:"event_type"`
+	EntityType  string                 `json:"entity_type,omitempty"`
+	EntityID    string                 `json:"entity_id,omitempty"`
+	Payload     map[string]interface{} `json:"payload"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// IsEventType reports whether eventType is one of EventTypes
+func IsEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// PinEventType returns the event type of pinning or unpinning a conversation
+func PinEventType(pinned bool) string {
+	if pinned {
+		return EventConversationPinned
+	}
+	return EventConversationUnpinned
+}
+
+// Insert saves an event
+func Insert(ctx context.Context, db tools.DBConnection, event Event) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode event payload: %w", err)
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO project_events (id, project_id, actor_user_id, event_type, entity_type, entity_id, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		event.ID, event.ProjectID, nullable(event.ActorUserID), event.EventType,
+		nullable(event.EntityType), nullable(event.EntityID), string(payload), event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save event: %w", err)
+	}
+	return nil
+}
+
+// ListOptions selects a page of a project's events, newest first
+type ListOptions struct {
+	EventTypes []string // Empty lists every type
+	Cursor     string   // NextCursor of the previous page; empty starts with the newest event
+	Limit      int      // 0 uses DefaultPageSize
+}
+
+// Size returns the number of events the page holds at most
+func (o ListOptions) Size() int {
+	switch {
+	case o.Limit <= 0:
+		return DefaultPageSize
+	case o.Limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return o.Limit
+	}
+}
+
+// Page is one page of a project's events. NextCursor is set when older events remain.
+type Page struct {
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	HasMore    bool    `json:"has_more"`
+}
+
+// List returns a page of a project's events, newest first. Events sharing a
+// created_at are ordered by id, so a page boundary between them neither skips
+// nor repeats any.
+func List(ctx context.Context, db tools.DBConnection, projectID string, options ListOptions) (*Page, error) {
+	query := `SELECT id, project_id, actor_user_id, event_type, entity_type, entity_id, payload, created_at
+		FROM project_events
+		WHERE project_id = $1`
+	args := []interface{}{projectID}
+
+	if options.Cursor != "" {
+		var found int
+		err := db.QueryRow(ctx, "SELECT COUNT(*) FROM project_events WHERE id = $1 AND project_id = $2",
+			options.Cursor, projectID).Scan(&found)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up cursor: %w", err)
+		}
+		if found == 0 {
+			return nil, ErrInvalidCursor
+		}
+		query += ` AND (created_at < (SELECT b.created_at FROM project_events b WHERE b.id = $2)
+			OR (created_at = (SELECT b.created_at FROM project_events b WHERE b.id = $2) AND id < $2))`
+		args = append(args, options.Cursor)
+	}
+	if len(options.EventTypes) > 0 {
+		query += " AND event_type IN ("
+		for i, eventType := range options.EventTypes {
+			if i > 0 {
+				query += ", "
+			}
+			args = append(args, eventType)
+			query += fmt.Sprintf("$%d", len(args))
+		}
+		query += ")"
+	}
+	args = append(args, options.Size()+1)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	page := &Page{Events: []Event{}}
+	for rows.Next() {
+		var event Event
+		var actor, entityType, entityID, payload sql.NullString
+		if err := rows.Scan(&event.ID, &event.ProjectID, &actor, &event.EventType,
+			&entityType, &entityID, &payload, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		event.ActorUserID = actor.String
+		event.EntityType = entityType.String
+		event.EntityID = entityID.String
+		if payload.Valid && payload.String != "" {
+			if err := json.Unmarshal([]byte(payload.String), &event.Payload); err != nil {
+				return nil, fmt.Errorf("failed to decode payload of event %s: %w", event.ID, err)
+			}
+		}
+		if event.Payload == nil {
+			event.Payload = map[string]interface{}{}
+		}
+		page.Events = append(page.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	if len(page.Events) > options.Size() {
+		page.Events = page.Events[:options.Size()]
+		page.HasMore = true
+		page.NextCursor = page.Events[len(page.Events)-1].ID
+	}
+	return page, nil
+}
+
+// nullable stores empty strings as NULL
+func nullable(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,197 @@
+package activity
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+)
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1", "project-2")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+// recordingHub keeps every message broadcast to a project room
+type recordingHub struct {
+	mutex    sync.Mutex
+	projects []string
+	messages []messages.WebSocketMessage
+}
+
+func (h *recordingHub) BroadcastToProject(projectID string, message interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.projects = append(h.projects, projectID)
+	h.messages = append(h.messages, message.(messages.WebSocketMessage))
+}
+
+// insertEvents saves count events of a project, one second apart from at
+func insertEvents(t *testing.T, conn tools.DBConnection, projectID, eventType string, at time.Time, count int) []Event {
+	t.Helper()
+
+	var events []Event
+	for i := 0; i < count; i++ {
+		event := Event{
+			ID:        fmt.Sprintf("%s-%s-%d", projectID, eventType, i),
+			ProjectID: projectID,
+			EventType: eventType,
+			CreatedAt: at.Add(time.Duration(i) * time.Second),
+		}
+		if err := Insert(context.Background(), conn, event); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestRecorderSavesAndBroadcastsEvents(t *testing.T) {
+	conn := newTestDB(t)
+	hub := &recordingHub{}
+	recorder := NewRecorder(conn, hub, 0)
+	recorder.Start()
+
+	if !recorder.Record(Event{
+		ProjectID:   "project-1",
+		ActorUserID: "user-1",
+		EventType:   EventDatasourceCreated,
+		EntityType:  EntityDatasource,
+		EntityID:    "datasource-1",
+		Payload:     map[string]interface{}{"name": "warehouse"},
+	}) {
+		t.Fatal("Expected the event to be queued")
+	}
+	if recorder.Record(Event{EventType: EventDatasourceCreated}) {
+		t.Error("Expected an event without a project to be dropped")
+	}
+	var missing *Recorder
+	if missing.Record(Event{ProjectID: "project-1", EventType: EventDatasourceCreated}) {
+		t.Error("Expected a nil recorder to drop events")
+	}
+	recorder.Stop()
+
+	page, err := List(context.Background(), conn, "project-1", ListOptions{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.Events) != 1 {
+		t.Fatalf("Expected 1 saved event, got %d", len(page.Events))
+	}
+	saved := page.Events[0]
+	if saved.ID == "" || saved.ActorUserID != "user-1" || saved.EntityID != "datasource-1" || saved.Payload["name"] != "warehouse" {
+		t.Errorf("Unexpected saved event: %+v", saved)
+	}
+
+	if len(hub.messages) != 1 || hub.projects[0] != "project-1" {
+		t.Fatalf("Expected one broadcast to project-1, got %v", hub.projects)
+	}
+	if message := hub.messages[0]; message.Type != MessageType || message.Data.(Event).ID != saved.ID {
+		t.Errorf("Unexpected broadcast: %+v", message)
+	}
+}
+
+func TestRecorderDropsEventsWhenTheQueueIsFull(t *testing.T) {
+	conn := newTestDB(t)
+	recorder := NewRecorder(conn, nil, 1)
+
+	// Not started, so the first event fills the queue
+	if !recorder.Record(Event{ProjectID: "project-1", EventType: EventConversationCreated}) {
+		t.Fatal("Expected the first event to be queued")
+	}
+	if recorder.Record(Event{ProjectID: "project-1", EventType: EventConversationDeleted}) {
+		t.Error("Expected the second event to be dropped")
+	}
+
+	// Stop still writes what was queued
+	recorder.Start()
+	recorder.Stop()
+	page, err := List(context.Background(), conn, "project-1", ListOptions{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].EventType != EventConversationCreated {
+		t.Errorf("Expected only the queued event to be saved, got %+v", page.Events)
+	}
+}
+
+func TestListPagesThroughEvents(t *testing.T) {
+	conn := newTestDB(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	created := insertEvents(t, conn, "project-1", EventConversationCreated, start, 3)
+	failed := insertEvents(t, conn, "project-1", EventToolExecutionFailed, start, 2)
+	insertEvents(t, conn, "project-2", EventConversationCreated, start, 1)
+
+	// Newest first; events sharing a created_at are ordered by id
+	want := []string{created[2].ID, failed[1].ID, created[1].ID, failed[0].ID, created[0].ID}
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("Pagination did not end")
+		}
+		page, err := List(context.Background(), conn, "project-1", ListOptions{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, event := range page.Events {
+			got = append(got, event.ID)
+		}
+		if !page.HasMore {
+			if page.NextCursor != "" {
+				t.Errorf("Expected no cursor on the last page, got %s", page.NextCursor)
+			}
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+
+	page, err := List(context.Background(), conn, "project-1", ListOptions{EventTypes: []string{EventToolExecutionFailed}})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[0].ID != failed[1].ID || page.HasMore {
+		t.Errorf("Expected the two tool failures, got %+v", page.Events)
+	}
+
+	// A cursor from another project is rejected
+	if _, err := List(context.Background(), conn, "project-2", ListOptions{Cursor: created[0].ID}); err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestPrunerDeletesOldEvents(t *testing.T) {
+	conn := newTestDB(t)
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	insertEvents(t, conn, "project-1", EventConversationCreated, now.Add(-DefaultRetention-time.Hour), 5)
+	recent := insertEvents(t, conn, "project-1", EventConversationPinned, now.Add(-time.Hour), 1)
+
+	pruner := NewPruner(conn, 0, 2)
+	pruner.now = func() time.Time { return now }
+	pruned, err := pruner.PruneOnce(context.Background())
+	if err != nil {
+		t.Fatalf("PruneOnce failed: %v", err)
+	}
+	if pruned != 5 {
+		t.Errorf("Expected 5 events pruned in batches, got %d", pruned)
+	}
+
+	page, err := List(context.Background(), conn, "project-1", ListOptions{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].ID != recent[0].ID {
+		t.Errorf("Expected only the recent event to remain, got %+v", page.Events)
+	}
+}
@@ -0,0 +1,120 @@
+package activity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// DefaultRetention is how long events stay in the activity feed
+	DefaultRetention = 90 * 24 * time.Hour
+	// DefaultPruneBatchSize limits how many events one delete statement touches
+	DefaultPruneBatchSize = 500
+)
+
+// Pruner deletes events older than the retention period, in batches so the
+// project_events table is never locked for long
+type Pruner struct {
+	db        tools.DBConnection
+	retention time.Duration
+	batchSize int
+	now       func() time.Time
+}
+
+// NewPruner creates a pruner; non-positive values fall back to the defaults
+func NewPruner(db tools.DBConnection, retention time.Duration, batchSize int) *Pruner {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultPruneBatchSize
+	}
+	return &Pruner{db: db, retention: retention, batchSize: batchSize, now: time.Now}
+}
+
+// Run prunes old events every interval until ctx is cancelled
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pruned, err := p.PruneOnce(ctx); err != nil {
+			log.Printf("Activity event pruning failed after %d events: %v", pruned, err)
+		} else if pruned > 0 {
+			log.Printf("Pruned %d old activity events", pruned)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneOnce deletes all events past retention, batch by batch, and returns how many were removed
+func (p *Pruner) PruneOnce(ctx context.Context) (int, error) {
+	cutoff := p.now().UTC().Add(-p.retention)
+	total := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		found, pruned, err := p.pruneBatch(ctx, cutoff)
+		total += pruned
+		if err != nil {
+			return total, err
+		}
+		if found < p.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// pruneBatch deletes up to batchSize events created before cutoff.
+// It returns how many candidates were found and how many were actually deleted.
+func (p *Pruner) pruneBatch(ctx context.Context, cutoff time.Time) (int, int, error) {
+	rows, err := p.db.Query(ctx,
+		"SELECT id FROM project_events WHERE created_at < $1 ORDER BY created_at LIMIT $2",
+		cutoff, p.batchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find old events: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan event id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	result, err := p.db.Exec(ctx,
+		"DELETE FROM project_events WHERE id IN ("+strings.Join(placeholders, ", ")+")", ids...)
+	if err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete events: %w", err)
+	}
+
+	affected, _ := result.RowsAffected()
+	return len(ids), int(affected), nil
+}
@@ -0,0 +1,110 @@
+package activity
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+)
+
+// DefaultQueueSize is how many events may wait to be written
+const DefaultQueueSize = 1000
+
+// Recorder writes events from a bounded in-memory queue and broadcasts each one
+// to its project room once saved. When the queue is full new events are dropped
+// rather than blocking the operation that recorded them.
+type Recorder struct {
+	db  tools.DBConnection
+	hub messages.Hub // nil only saves events
+
+	queue chan Event
+	stop  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+	now   func() time.Time
+}
+
+// NewRecorder creates a recorder; a non-positive queue size uses the default.
+// Call Start to begin writing.
+func NewRecorder(db tools.DBConnection, hub messages.Hub, queueSize int) *Recorder {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Recorder{
+		db:    db,
+		hub:   hub,
+		queue: make(chan Event, queueSize),
+		stop:  make(chan struct{}),
+		now:   time.Now,
+	}
+}
+
+// Start launches the writer
+func (r *Recorder) Start() {
+	r.wg.Add(1)
+	go r.worker()
+}
+
+// Stop writes the events still queued and stops the writer
+func (r *Recorder) Stop() {
+	r.once.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+// Record queues an event, giving it an ID and timestamp, and returns false if
+// it was dropped. It never blocks, and a nil recorder drops every event.
+func (r *Recorder) Record(event Event) bool {
+	if r == nil || event.ProjectID == "" {
+		return false
+	}
+	event.ID = uuid.New().String()
+	event.CreatedAt = r.now().UTC()
+	if event.Payload == nil {
+		event.Payload = map[string]interface{}{}
+	}
+	select {
+	case r.queue <- event:
+		return true
+	default:
+		log.Printf("Activity queue full, dropping %s event of project %s", event.EventType, event.ProjectID)
+		return false
+	}
+}
+
+func (r *Recorder) worker() {
+	defer r.wg.Done()
+	for {
+		select {
+		case event := <-r.queue:
+			r.write(event)
+		case <-r.stop:
+			for {
+				select {
+				case event := <-r.queue:
+					r.write(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write saves an event and tells the project room about it
+func (r *Recorder) write(event Event) {
+	if err := Insert(context.Background(), r.db, event); err != nil {
+		log.Printf("Failed to record %s event of project %s: %v", event.EventType, event.ProjectID, err)
+		return
+	}
+	if r.hub != nil {
+		r.hub.BroadcastToProject(event.ProjectID, messages.WebSocketMessage{
+			Type:      MessageType,
+			Data:      event,
+			Timestamp: event.CreatedAt.UnixMilli(),
+		})
+	}
+}
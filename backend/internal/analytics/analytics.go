@@ -0,0 +1,229 @@
+// Package analytics aggregates platform activity for the admin and tenant
+// dashboards: conversations, messages, estimated tokens, tool executions and
+// active users over a time range, optionally limited to one client.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// DefaultCacheTTL is how long an overview is reused for the same parameters
+const DefaultCacheTTL = 60 * time.Second
+
+// charsPerToken is the estimate used while token usage is not recorded
+const charsPerToken = 4
+
+// Filter selects the activity an overview covers: From inclusive, To exclusive
+type Filter struct {
+	From     time.Time
+	To       time.Time
+	ClientID string // Empty for every client
+}
+
+func (f Filter) key() string {
+	return fmt.Sprintf("%d|%d|%s", f.From.Unix(), f.To.Unix(), f.ClientID)
+}
+
+// TokenUsage counts tokens in messages; Estimated is set when they are derived
+// from message lengths rather than reported by the model
+type TokenUsage struct {
+	Total     int64 `json:"total"`
+	Estimated bool  `json:"estimated"`
+}
+
+// ToolUsage counts executions of one tool that ended in one status
+type ToolUsage struct {
+	Tool   string `json:"tool"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// Overview is the activity in a Filter's range
+type Overview struct {
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	ClientID       string           `json:"client_id,omitempty"`
+	Conversations  int64            `json:"conversations"`
+	MessagesByRole map[string]int64 `json:"messages_by_role"`
+	Tokens         TokenUsage       `json:"tokens"`
+	ToolExecutions []ToolUsage      `json:"tool_executions"`
+	ActiveUsers    int64            `json:"active_users"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+	Cached         bool             `json:"cached"`
+}
+
+// Reporter computes overviews and caches them per filter
+type Reporter struct {
+	db  tools.DBConnection
+	ttl time.Duration
+	now func() time.Time
+
+	mutex sync.Mutex
+	cache map[string]*Overview
+}
+
+// NewReporter creates a reporter; a non-positive ttl disables caching
+func NewReporter(db tools.DBConnection, ttl time.Duration) *Reporter {
+	return &Reporter{db: db, ttl: ttl, now: time.Now, cache: make(map[string]*Overview)}
+}
+
+// Overview returns the cached overview for the filter if it is fresh,
+// otherwise runs the aggregate queries
+func (r *Reporter) Overview(ctx context.Context, filter Filter) (*Overview, error) {
+	filter.From, filter.To = filter.From.UTC(), filter.To.UTC()
+	key := filter.key()
+
+	r.mutex.Lock()
+	if cached, exists := r.cache[key]; exists && r.now().Sub(cached.GeneratedAt) < r.ttl {
+		r.mutex.Unlock()
+		overview := *cached
+		overview.Cached = true
+		return &overview, nil
+	}
+	r.mutex.Unlock()
+
+	overview, err := r.compute(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Drop stale entries so one-off ranges do not accumulate
+	for k, cached := range r.cache {
+		if r.now().Sub(cached.GeneratedAt) >= r.ttl {
+			delete(r.cache, k)
+		}
+	}
+	if r.ttl > 0 {
+		stored := *overview
+		r.cache[key] = &stored
+	}
+	return overview, nil
+}
+
+func (r *Reporter) compute(ctx context.Context, filter Filter) (*Overview, error) {
+	overview := &Overview{
+		From:           filter.From,
+		To:             filter.To,
+		ClientID:       filter.ClientID,
+		MessagesByRole: map[string]int64{},
+		ToolExecutions: []ToolUsage{},
+		Tokens:         TokenUsage{Estimated: true},
+		GeneratedAt:    r.now(),
+	}
+
+	args := []interface{}{filter.From, filter.To}
+	clientScope := ""
+	if filter.ClientID != "" {
+		clientScope = " AND u.client_id = $3"
+		args = append(args, filter.ClientID)
+	}
+
+	err := r.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.created_at >= $1 AND c.created_at < $2`+clientScope,
+		args...).Scan(&overview.Conversations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count conversations: %w", err)
+	}
+
+	// Messages are attributed to the owner of their conversation
+	messagesFrom := `FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN users u ON u.id = c.user_id
+		WHERE m.created_at >= $1 AND m.created_at < $2` + clientScope
+
+	rows, err := r.db.Query(ctx,
+		`SELECT m.role, COUNT(*), COALESCE(SUM(LENGTH(m.content)), 0) `+messagesFrom+` GROUP BY m.role`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	var chars int64
+	for rows.Next() {
+		var role string
+		var count, length int64
+		if err := rows.Scan(&role, &count, &length); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read message counts: %w", err)
+		}
+		overview.MessagesByRole[role] = count
+		chars += length
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	overview.Tokens.Total = chars / charsPerToken
+
+	err = r.db.QueryRow(ctx,
+		`SELECT COUNT(DISTINCT c.user_id) `+messagesFrom+` AND m.role = 'user'`,
+		args...).Scan(&overview.ActiveUsers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count active users: %w", err)
+	}
+
+	if overview.ToolExecutions, err = r.toolUsage(ctx, messagesFrom, args); err != nil {
+		return nil, err
+	}
+	return overview, nil
+}
+
+// toolUsage counts the tool calls stored on assistant messages by tool and status
+func (r *Reporter) toolUsage(ctx context.Context, messagesFrom string, args []interface{}) ([]ToolUsage, error) {
+	rows, err := r.db.Query(ctx, `SELECT m.tool_calls `+messagesFrom+` AND m.tool_calls IS NOT NULL`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tool calls: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[ToolUsage]int64{}
+	for rows.Next() {
+		var raw sql.NullString
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("failed to read tool calls: %w", err)
+		}
+		var calls []struct {
+			Status   string `json:"status"`
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		if !raw.Valid || json.Unmarshal([]byte(raw.String), &calls) != nil {
+			continue
+		}
+		for _, call := range calls {
+			status := call.Status
+			if status == "" {
+				status = "unknown"
+			}
+			counts[ToolUsage{Tool: call.Function.Name, Status: status}]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load tool calls: %w", err)
+	}
+
+	usage := make([]ToolUsage, 0, len(counts))
+	for group, count := range counts {
+		group.Count = count
+		usage = append(usage, group)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Tool != usage[j].Tool {
+			return usage[i].Tool < usage[j].Tool
+		}
+		return usage[i].Status < usage[j].Status
+	})
+	return usage, nil
+}
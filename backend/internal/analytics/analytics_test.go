@@ -0,0 +1,134 @@
+package analytics
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+var day = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// setupAnalyticsDB seeds two clients: client A has two users active on day and
+// one conversation outside the range, client B a single exchange
+func setupAnalyticsDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "analytics.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { zdb.Close() })
+
+	ctx := context.Background()
+	early := day.AddDate(0, 0, -10)
+	seed := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"CREATE TABLE users (id TEXT PRIMARY KEY, client_id TEXT)", nil},
+		{"CREATE TABLE conversations (id TEXT PRIMARY KEY, user_id TEXT, created_at TIMESTAMP)", nil},
+		{"CREATE TABLE messages (id TEXT PRIMARY KEY, conversation_id TEXT, role TEXT, content TEXT, tool_calls TEXT, created_at TIMESTAMP)", nil},
+		{"INSERT INTO users VALUES ('a1', 'client-a'), ('a2', 'client-a'), ('a3', 'client-a'), ('b1', 'client-b')", nil},
+		{"INSERT INTO conversations VALUES ('ca1', 'a1', $1), ('ca2', 'a2', $1), ('ca3', 'a3', $2), ('cb1', 'b1', $1)",
+			[]interface{}{day, early}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m1', 'ca1', 'user', '12345678', $1), ('m3', 'ca2', 'user', 'abcd', $1), ('m5', 'cb1', 'user', 'xxxxxxxx', $1), ('m6', 'ca3', 'user', 'old', $2)",
+			[]interface{}{day, early}},
+		{`INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES
+			('m2', 'ca1', 'assistant', '1234', '[{"id":"t1","function":{"name":"database_query"},"status":"completed"},{"id":"t2","function":{"name":"database_query"},"status":"failed"}]', $1),
+			('m4', 'ca2', 'assistant', '1234', '[{"id":"t3","function":{"name":"database_query"},"status":"completed"},{"id":"t4","function":{"name":"http_request"},"status":"completed"}]', $1)`,
+			[]interface{}{day}},
+	}
+	for _, s := range seed {
+		if _, err := zdb.Execute(ctx, s.query, s.args...); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+	}
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+func TestOverviewAggregatesActivity(t *testing.T) {
+	reporter := NewReporter(setupAnalyticsDB(t), DefaultCacheTTL)
+	ctx := context.Background()
+	window := Filter{From: day.Add(-time.Hour), To: day.Add(time.Hour)}
+
+	all, err := reporter.Overview(ctx, window)
+	if err != nil {
+		t.Fatalf("Overview failed: %v", err)
+	}
+	if all.Conversations != 3 || all.ActiveUsers != 3 {
+		t.Errorf("Expected 3 conversations and 3 active users, got %d and %d", all.Conversations, all.ActiveUsers)
+	}
+	if want := map[string]int64{"user": 3, "assistant": 2}; !reflect.DeepEqual(all.MessagesByRole, want) {
+		t.Errorf("Expected messages %v, got %v", want, all.MessagesByRole)
+	}
+	// 28 characters at 4 per token
+	if all.Tokens != (TokenUsage{Total: 7, Estimated: true}) {
+		t.Errorf("Unexpected tokens: %+v", all.Tokens)
+	}
+	wantTools := []ToolUsage{
+		{Tool: "database_query", Status: "completed", Count: 2},
+		{Tool: "database_query", Status: "failed", Count: 1},
+		{Tool: "http_request", Status: "completed", Count: 1},
+	}
+	if !reflect.DeepEqual(all.ToolExecutions, wantTools) {
+		t.Errorf("Expected tool usage %+v, got %+v", wantTools, all.ToolExecutions)
+	}
+
+	window.ClientID = "client-b"
+	scoped, err := reporter.Overview(ctx, window)
+	if err != nil {
+		t.Fatalf("Overview failed: %v", err)
+	}
+	if scoped.Conversations != 1 || scoped.ActiveUsers != 1 || scoped.MessagesByRole["user"] != 1 ||
+		scoped.MessagesByRole["assistant"] != 0 || len(scoped.ToolExecutions) != 0 || scoped.Tokens.Total != 2 {
+		t.Errorf("Expected only client B's activity, got %+v", scoped)
+	}
+
+	// The range excludes the older conversation of client A
+	wide, err := reporter.Overview(ctx, Filter{From: day.AddDate(0, 0, -30), To: day.Add(time.Hour), ClientID: "client-a"})
+	if err != nil {
+		t.Fatalf("Overview failed: %v", err)
+	}
+	if wide.Conversations != 3 || wide.ActiveUsers != 3 {
+		t.Errorf("Expected 3 conversations and users for client A over 30 days, got %d and %d", wide.Conversations, wide.ActiveUsers)
+	}
+}
+
+func TestOverviewIsCachedPerFilter(t *testing.T) {
+	conn := setupAnalyticsDB(t)
+	reporter := NewReporter(conn, time.Minute)
+	now := day
+	reporter.now = func() time.Time { return now }
+	ctx := context.Background()
+	window := Filter{From: day.Add(-time.Hour), To: day.Add(time.Hour)}
+
+	first, err := reporter.Overview(ctx, window)
+	if err != nil || first.Cached {
+		t.Fatalf("Expected a fresh overview, got %+v, %v", first, err)
+	}
+	if _, err := conn.Exec(ctx, "INSERT INTO conversations VALUES ('new', 'b1', $1)", day); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+
+	cached, _ := reporter.Overview(ctx, window)
+	if !cached.Cached || cached.Conversations != first.Conversations {
+		t.Errorf("Expected the cached overview, got %+v", cached)
+	}
+	other, _ := reporter.Overview(ctx, Filter{From: window.From, To: window.To, ClientID: "client-b"})
+	if other.Cached || other.Conversations != 2 {
+		t.Errorf("Expected a fresh overview for another client, got %+v", other)
+	}
+
+	now = now.Add(time.Minute)
+	expired, _ := reporter.Overview(ctx, window)
+	if expired.Cached || expired.Conversations != first.Conversations+1 {
+		t.Errorf("Expected a recomputed overview after the TTL, got %+v", expired)
+	}
+}
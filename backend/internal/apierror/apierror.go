@@ -0,0 +1,289 @@
+// Package apierror holds the catalog of stable error codes returned by the
+// REST API and sent in WebSocket error messages. Each code has one HTTP status
+// and a message template that can be translated; clients branch on the code and
+// show the message.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/requestid"
+)
+
+// Authentication and authorization
+const (
+	CodeAuthRequired           = "AUTH_REQUIRED"
+	CodeAuthSessionExpired     = "AUTH_SESSION_EXPIRED"
+	CodeAuthInvalidCredentials = "AUTH_INVALID_CREDENTIALS"
+	CodeAuthAccountInactive    = "AUTH_ACCOUNT_INACTIVE"
+	CodeAuthTokenInvalid       = "AUTH_TOKEN_INVALID"
+	CodeAdminRequired          = "ADMIN_REQUIRED"
+	CodeClientAdminRequired    = "CLIENT_ADMIN_REQUIRED"
+	CodeForbidden              = "FORBIDDEN"
+	CodeAPIKeyInvalid          = "API_KEY_INVALID"
+	CodeAPIKeyForbidden        = "API_KEY_FORBIDDEN"
+)
+
+// Tenancy and resources
+const (
+	CodeClientNotResolved            = "CLIENT_NOT_RESOLVED"
+	CodeClientIDInvalid              = "CLIENT_ID_INVALID"
+	CodeClientNotFound               = "CLIENT_NOT_FOUND"
+	CodeClientSlugTaken              = "CLIENT_SLUG_TAKEN"
+	CodeDomainNotFound               = "DOMAIN_NOT_FOUND"
+	CodeDomainTaken                  = "DOMAIN_TAKEN"
+	CodeUserAlreadyExists            = "USER_ALREADY_EXISTS"
+	CodeUserNotFound                 = "USER_NOT_FOUND"
+	CodeProjectNotFound              = "PROJECT_NOT_FOUND"
+	CodeDatasourceNotFound           = "DATASOURCE_NOT_FOUND"
+	CodeConversationNotFound         = "CONVERSATION_NOT_FOUND"
+	CodeParticipantExists            = "PARTICIPANT_EXISTS"
+	CodeMessageNotFound              = "MESSAGE_NOT_FOUND"
+	CodeDraftNotFound                = "DRAFT_NOT_FOUND"
+	CodeSchemaSnapshotNotFound       = "SCHEMA_SNAPSHOT_NOT_FOUND"
+	CodeAPIKeyNotFound               = "API_KEY_NOT_FOUND"
+	CodeShareNotFound                = "SHARE_NOT_FOUND"
+	CodeToolCallNotFound             = "TOOL_CALL_NOT_FOUND"
+	CodeToolResultNotFound           = "TOOL_RESULT_NOT_FOUND"
+	CodeTemplateNotFound             = "TEMPLATE_NOT_FOUND"
+	CodeTemplateNameTaken            = "TEMPLATE_NAME_TAKEN"
+	CodeNotificationSettingsNotFound = "NOTIFICATION_SETTINGS_NOT_FOUND"
+	CodeTenantJobNotFound            = "TENANT_JOB_NOT_FOUND"
+	CodeExportNotReady               = "EXPORT_NOT_READY" // details: status
+	CodeContentFilterNotFound        = "CONTENT_FILTER_NOT_FOUND"
+	CodeScheduleNotFound             = "SCHEDULE_NOT_FOUND"
+	CodeTableNotFound                = "TABLE_NOT_FOUND" // details: table
+)
+
+// Request validation
+const (
+	CodeInvalidRequestBody       = "INVALID_REQUEST_BODY"
+	CodeFieldRequired            = "FIELD_REQUIRED"     // details: field
+	CodeFieldOutOfRange          = "FIELD_OUT_OF_RANGE" // details: field, min
+	CodeFieldInvalid             = "FIELD_INVALID"      // details: field
+	CodeInvalidMessage           = "INVALID_MESSAGE"    // details: type, field, reason
+	CodeMessageTooLong           = "MESSAGE_TOO_LONG"   // details: field, limit, length
+	CodeInvalidFeedback          = "INVALID_FEEDBACK"
+	CodeTemplateInvalid          = "TEMPLATE_INVALID"           // details: field, reason
+	CodeTemplateVariablesMissing = "TEMPLATE_VARIABLES_MISSING" // details: variables
+	CodeToolParametersInvalid    = "TOOL_PARAMETERS_INVALID"    // details: tool, parameter, reason
+	CodeDomainInvalid            = "DOMAIN_INVALID"             // details: domain, reason
+	CodeBrandingInvalid          = "BRANDING_INVALID"           // details: field, reason
+	CodePurgeConfirmationInvalid = "PURGE_CONFIRMATION_INVALID"
+	CodeContentFilterInvalid     = "CONTENT_FILTER_INVALID" // details: field, reason
+	CodeDatasourceBundleInvalid  = "DATASOURCE_BUNDLE_INVALID" // details: reason
+	CodeScheduleInvalid          = "SCHEDULE_INVALID"          // details: field, reason
+)
+
+// Chat and streaming
+const (
+	CodeTokenLimitExceeded      = "TOKEN_LIMIT_EXCEEDED"
+	CodeRateLimited             = "RATE_LIMITED" // details: limit_per_minute
+	CodeVisitorForbidden        = "VISITOR_FORBIDDEN"
+	CodeNotInProject            = "NOT_IN_PROJECT"
+	CodeUnsupportedProtocol     = "UNSUPPORTED_PROTOCOL_VERSION"
+	CodePinLimitReached         = "PIN_LIMIT_REACHED" // details: limit
+	CodeConversationOwnerOnly   = "CONVERSATION_OWNER_ONLY"
+	CodeStreamNotFound          = "STREAM_NOT_FOUND"
+	CodeStreamSeqInvalid        = "STREAM_SEQ_INVALID"
+	CodeStreamAlreadyActive     = "STREAM_ALREADY_ACTIVE"
+	CodeNotInterrupted          = "CONVERSATION_NOT_INTERRUPTED"
+	CodeQueueTimeout            = "QUEUE_TIMEOUT"
+	CodeQueueFull               = "QUEUE_FULL"
+	CodeLLMConfigUnavailable    = "LLM_CONFIG_UNAVAILABLE"
+	CodeLLMNotConfigured        = "LLM_NOT_CONFIGURED"
+	CodeLLMRateLimited          = "LLM_RATE_LIMITED" // details: retry_after_seconds
+	CodeLLMRequestFailed        = "LLM_REQUEST_FAILED"
+	CodeDatasourceUnavailable   = "DATASOURCE_UNAVAILABLE"
+	CodeTokenBudgetExhausted    = "TOKEN_BUDGET_EXHAUSTED" // details: limit, resets_at
+	CodeMessageProcessingFailed = "MESSAGE_PROCESSING_FAILED"
+	CodeExportUnavailable       = "EXPORT_UNAVAILABLE"
+	CodeModelNotAllowed         = "MODEL_NOT_ALLOWED"     // details: model
+	CodeToolUnavailable         = "TOOL_UNAVAILABLE"      // details: tool
+	CodeToolHasSideEffects      = "TOOL_HAS_SIDE_EFFECTS" // details: tool
+	CodeForkTooLarge            = "FORK_TOO_LARGE"        // details: limit, message_count
+	CodeDatasourceImportRejected = "DATASOURCE_IMPORT_REJECTED" // details: conflicts, invalid, results
+	CodeLastClientAdmin          = "LAST_CLIENT_ADMIN"
+)
+
+// Concurrent edits
+const (
+	CodeVersionRequired = "VERSION_REQUIRED"
+	CodeVersionConflict = "VERSION_CONFLICT" // details: current
+)
+
+// Server failures
+const (
+	CodeDatabaseError = "DATABASE_ERROR"
+	CodeSaveFailed    = "SAVE_FAILED"
+	CodeInternal      = "INTERNAL_ERROR"
+)
+
+// statuses maps every code to its HTTP status; codes missing here are 500s
+var statuses = map[string]int{
+	CodeAuthRequired:           http.StatusUnauthorized,
+	CodeAuthSessionExpired:     http.StatusUnauthorized,
+	CodeAuthInvalidCredentials: http.StatusUnauthorized,
+	CodeAuthAccountInactive:    http.StatusUnauthorized,
+	CodeAuthTokenInvalid:       http.StatusUnauthorized,
+	CodeAdminRequired:          http.StatusForbidden,
+	CodeClientAdminRequired:    http.StatusForbidden,
+	CodeForbidden:              http.StatusForbidden,
+	CodeAPIKeyInvalid:          http.StatusUnauthorized,
+	CodeAPIKeyForbidden:        http.StatusForbidden,
+
+	CodeClientNotResolved:            http.StatusBadRequest,
+	CodeClientIDInvalid:              http.StatusBadRequest,
+	CodeClientNotFound:               http.StatusNotFound,
+	CodeClientSlugTaken:              http.StatusConflict,
+	CodeDomainNotFound:               http.StatusNotFound,
+	CodeDomainTaken:                  http.StatusConflict,
+	CodeUserAlreadyExists:            http.StatusConflict,
+	CodeUserNotFound:                 http.StatusNotFound,
+	CodeProjectNotFound:              http.StatusNotFound,
+	CodeDatasourceNotFound:           http.StatusNotFound,
+	CodeConversationNotFound:         http.StatusNotFound,
+	CodeParticipantExists:            http.StatusConflict,
+	CodeMessageNotFound:              http.StatusNotFound,
+	CodeDraftNotFound:                http.StatusNotFound,
+	CodeSchemaSnapshotNotFound:       http.StatusNotFound,
+	CodeAPIKeyNotFound:               http.StatusNotFound,
+	CodeShareNotFound:                http.StatusNotFound,
+	CodeToolCallNotFound:             http.StatusNotFound,
+	CodeToolResultNotFound:           http.StatusNotFound,
+	CodeTemplateNotFound:             http.StatusNotFound,
+	CodeTemplateNameTaken:            http.StatusConflict,
+	CodeNotificationSettingsNotFound: http.StatusNotFound,
+	CodeTenantJobNotFound:            http.StatusNotFound,
+	CodeExportNotReady:               http.StatusConflict,
+	CodeContentFilterNotFound:        http.StatusNotFound,
+	CodeScheduleNotFound:             http.StatusNotFound,
+	CodeTableNotFound:                http.StatusNotFound,
+
+	CodeInvalidRequestBody:       http.StatusBadRequest,
+	CodeFieldRequired:            http.StatusBadRequest,
+	CodeFieldOutOfRange:          http.StatusBadRequest,
+	CodeFieldInvalid:             http.StatusBadRequest,
+	CodeInvalidMessage:           http.StatusBadRequest,
+	CodeMessageTooLong:           http.StatusRequestEntityTooLarge,
+	CodeInvalidFeedback:          http.StatusBadRequest,
+	CodeTemplateInvalid:          http.StatusBadRequest,
+	CodeTemplateVariablesMissing: http.StatusBadRequest,
+	CodeToolParametersInvalid:    http.StatusBadRequest,
+	CodeDomainInvalid:            http.StatusBadRequest,
+	CodeBrandingInvalid:          http.StatusBadRequest,
+	CodePurgeConfirmationInvalid: http.StatusBadRequest,
+	CodeContentFilterInvalid:     http.StatusBadRequest,
+	CodeDatasourceBundleInvalid:  http.StatusBadRequest,
+	CodeScheduleInvalid:          http.StatusBadRequest,
+
+	CodeTokenLimitExceeded:      http.StatusTooManyRequests,
+	CodeRateLimited:             http.StatusTooManyRequests,
+	CodeVisitorForbidden:        http.StatusForbidden,
+	CodeNotInProject:            http.StatusBadRequest,
+	CodeUnsupportedProtocol:     http.StatusBadRequest,
+	CodePinLimitReached:         http.StatusConflict,
+	CodeConversationOwnerOnly:   http.StatusForbidden,
+	CodeStreamNotFound:          http.StatusNotFound,
+	CodeStreamSeqInvalid:        http.StatusBadRequest,
+	CodeStreamAlreadyActive:     http.StatusConflict,
+	CodeNotInterrupted:          http.StatusConflict,
+	CodeQueueTimeout:            http.StatusServiceUnavailable,
+	CodeQueueFull:               http.StatusServiceUnavailable,
+	CodeLLMConfigUnavailable:    http.StatusServiceUnavailable,
+	CodeLLMNotConfigured:        http.StatusServiceUnavailable,
+	CodeLLMRateLimited:          http.StatusTooManyRequests,
+	CodeLLMRequestFailed:        http.StatusBadGateway,
+	CodeDatasourceUnavailable:   http.StatusBadGateway,
+	CodeTokenBudgetExhausted:    http.StatusTooManyRequests,
+	CodeMessageProcessingFailed: http.StatusInternalServerError,
+	CodeExportUnavailable:       http.StatusServiceUnavailable,
+	CodeModelNotAllowed:         http.StatusForbidden,
+	CodeToolUnavailable:         http.StatusConflict,
+	CodeToolHasSideEffects:      http.StatusConflict,
+	CodeForkTooLarge:            http.StatusConflict,
+	CodeDatasourceImportRejected: http.StatusConflict,
+	CodeLastClientAdmin:          http.StatusConflict,
+
+	CodeVersionRequired: http.StatusPreconditionRequired,
+	CodeVersionConflict: http.StatusConflict,
+
+	CodeDatabaseError: http.StatusInternalServerError,
+	CodeSaveFailed:    http.StatusInternalServerError,
+	CodeInternal:      http.StatusInternalServerError,
+}
+
+// Error is the {code, message, details} body of an error response
+type Error struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// New builds the error for a code with its message in lang. Details fill the
+// {placeholders} of the message template and are returned to the client.
+func New(code, lang string, details map[string]interface{}) *Error {
+	return &Error{Code: code, Message: Message(code, lang, details), Details: details}
+}
+
+// Status returns the HTTP status for a code
+func Status(code string) int {
+	if status, exists := statuses[code]; exists {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Codes lists every code in the catalog
+func Codes() []string {
+	codes := make([]string, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// Respond writes the error for a code with its catalog status, in the language
+// negotiated from the request's Accept-Language. The message is also sent as
+// "error" for callers that predate the codes, and the request's ID as
+// request_id so error reports can quote it.
+func Respond(c *gin.Context, code string, details map[string]interface{}) {
+	apiErr := New(code, Language(c), details)
+	body := gin.H{"error": apiErr.Message, "code": apiErr.Code, "message": apiErr.Message}
+	if len(apiErr.Details) > 0 {
+		body["details"] = apiErr.Details
+	}
+	if requestID := requestid.FromGin(c); requestID != "" {
+		body["request_id"] = requestID
+	}
+	c.JSON(Status(code), body)
+}
+
+// Abort is Respond for middleware that stops the handler chain
+func Abort(c *gin.Context, code string, details map[string]interface{}) {
+	Respond(c, code, details)
+	c.Abort()
+}
+
+// Language returns the language negotiated from a request's Accept-Language
+func Language(c *gin.Context) string {
+	return Negotiate(c.GetHeader("Accept-Language"))
+}
+
+// fill replaces {key} placeholders with the matching details
+func fill(template string, details map[string]interface{}) string {
+	if len(details) == 0 || !strings.Contains(template, "{") {
+		return template
+	}
+	for key, value := range details {
+		template = strings.ReplaceAll(template, "{"+key+"}", fmt.Sprint(value))
+	}
+	return template
+}
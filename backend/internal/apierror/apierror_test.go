@@ -0,0 +1,124 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCatalogIsComplete(t *testing.T) {
+	for _, code := range Codes() {
+		if code != strings.ToUpper(code) {
+			t.Errorf("Expected %s to be upper case", code)
+		}
+		for lang, bundle := range bundles {
+			if bundle[code] == "" {
+				t.Errorf("Expected a %s message for %s", lang, code)
+			}
+		}
+	}
+	for lang, bundle := range bundles {
+		for code := range bundle {
+			if _, exists := statuses[code]; !exists {
+				t.Errorf("Expected a status for %s, which has a %s message", code, lang)
+			}
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	if Status(CodeDatasourceNotFound) != http.StatusNotFound || Status(CodeAuthSessionExpired) != http.StatusUnauthorized {
+		t.Errorf("Unexpected catalog statuses")
+	}
+	if Status("NOT_A_CODE") != http.StatusInternalServerError {
+		t.Errorf("Expected unknown codes to be 500s")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                               "en",
+		"id":                             "id",
+		"id-ID":                          "id",
+		"ID-id":                          "id",
+		"en-US,id;q=0.9":                 "en",
+		"fr-FR,id;q=0.8,en;q=0.5":        "id",
+		"fr, de;q=0.9":                   "en",
+		"en;q=0.2, id;q=0.7":             "id",
+		"id;q=0, en;q=0.1":               "en",
+		"*":                              "en",
+		"id;q=abc, en":                   "en",
+		" id-ID ; q=0.9 , en-GB ; q=0.8": "id",
+	} {
+		if got := Negotiate(header); got != expected {
+			t.Errorf("Negotiate(%q) = %q, expected %q", header, got, expected)
+		}
+	}
+}
+
+func TestMessageFillsDetailsAndFallsBack(t *testing.T) {
+	details := map[string]interface{}{"field": "max_concurrent_streams", "min": 1}
+	if got := Message(CodeFieldOutOfRange, "en", details); got != "max_concurrent_streams must be at least 1" {
+		t.Errorf("Unexpected English message %q", got)
+	}
+	if got := Message(CodeFieldOutOfRange, "id", details); got != "max_concurrent_streams minimal 1" {
+		t.Errorf("Unexpected Indonesian message %q", got)
+	}
+	if got := Message(CodeDatasourceNotFound, "fr", nil); got != "Datasource not found" {
+		t.Errorf("Expected English for an unbundled language, got %q", got)
+	}
+	if got := Message("NOT_A_CODE", "en", nil); got != "NOT_A_CODE" {
+		t.Errorf("Expected an unknown code to be its own message, got %q", got)
+	}
+}
+
+func TestTranslatorHook(t *testing.T) {
+	SetTranslator(func(lang, code string) (string, bool) {
+		if lang == "fr" && code == CodeDatasourceNotFound {
+			return "Source de données introuvable", true
+		}
+		return "", false
+	})
+	defer SetTranslator(nil)
+
+	if got := Negotiate("fr-FR,en;q=0.5"); got != "fr" {
+		t.Errorf("Expected any language to be negotiable with a translator, got %q", got)
+	}
+	if got := Message(CodeDatasourceNotFound, "fr", nil); got != "Source de données introuvable" {
+		t.Errorf("Expected the translation, got %q", got)
+	}
+	if got := Message(CodeClientNotFound, "fr", nil); got != "Client not found" {
+		t.Errorf("Expected English when the translator declines, got %q", got)
+	}
+	if got := Message(CodeClientNotFound, "id", nil); got != "Klien tidak ditemukan" {
+		t.Errorf("Expected the bundle when the translator declines, got %q", got)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	c.Request.Header.Set("Accept-Language", "id")
+
+	Abort(c, CodeFieldRequired, map[string]interface{}{"field": "name"})
+
+	if w.Code != http.StatusBadRequest || !c.IsAborted() {
+		t.Fatalf("Expected an aborted 400, got %d", w.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid body: %v", err)
+	}
+	if body["code"] != CodeFieldRequired || body["message"] != "name wajib diisi" || body["error"] != "name wajib diisi" {
+		t.Errorf("Unexpected body: %v", body)
+	}
+	if details, _ := body["details"].(map[string]interface{}); details["field"] != "name" {
+		t.Errorf("Expected the details to be returned, got %v", body["details"])
+	}
+}
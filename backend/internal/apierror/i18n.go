@@ -0,0 +1,275 @@
+package apierror
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLanguage is used when the caller accepts no language with a bundle
+const DefaultLanguage = "en"
+
+// Translator returns the message template for a code in a language, or false
+// to fall back to the bundled messages. Templates use the same {placeholders}.
+type Translator func(lang, code string) (string, bool)
+
+var (
+	translatorMutex sync.RWMutex
+	translator      Translator
+)
+
+// SetTranslator installs a hook consulted before the bundled messages, for
+// deployments that localize into languages not bundled here. nil removes it.
+func SetTranslator(t Translator) {
+	translatorMutex.Lock()
+	defer translatorMutex.Unlock()
+	translator = t
+}
+
+// Message returns the message for a code in lang, falling back to English
+func Message(code, lang string, details map[string]interface{}) string {
+	translatorMutex.RLock()
+	t := translator
+	translatorMutex.RUnlock()
+
+	if t != nil {
+		if template, ok := t(lang, code); ok {
+			return fill(template, details)
+		}
+	}
+	if template, ok := bundles[lang][code]; ok {
+		return fill(template, details)
+	}
+	if template, ok := bundles[DefaultLanguage][code]; ok {
+		return fill(template, details)
+	}
+	return code
+}
+
+// Negotiate picks the language with the highest weight in an Accept-Language
+// header. Region subtags are dropped ("id-ID" is "id"). With no translator
+// installed only bundled languages are chosen; otherwise any language is, and
+// the translator decides what it can serve.
+func Negotiate(acceptLanguage string) string {
+	translatorMutex.RLock()
+	anyLanguage := translator != nil
+	translatorMutex.RUnlock()
+
+	type weighted struct {
+		lang   string
+		weight float64
+	}
+	var candidates []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil || parsed <= 0 {
+				continue
+			}
+			weight = parsed
+		}
+		if _, bundled := bundles[lang]; bundled || anyLanguage {
+			candidates = append(candidates, weighted{lang, weight})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].weight > candidates[j].weight })
+	return candidates[0].lang
+}
+
+// bundles holds the message templates shipped with the server
+var bundles = map[string]map[string]string{
+	"en": {
+		CodeAuthRequired:           "Authentication required",
+		CodeAuthSessionExpired:     "Invalid or expired session",
+		CodeAuthInvalidCredentials: "Invalid credentials",
+		CodeAuthAccountInactive:    "User account is inactive",
+		CodeAuthTokenInvalid:       "Invalid authentication token",
+		CodeAdminRequired:          "Admin access required",
+		CodeClientAdminRequired:    "Only your client's admins can manage users",
+		CodeForbidden:              "Access denied",
+		CodeAPIKeyInvalid:          "Invalid or revoked API key",
+		CodeAPIKeyForbidden:        "API keys cannot access this endpoint",
+
+		CodeClientNotResolved:            "Invalid client",
+		CodeClientIDInvalid:              "Invalid client ID format",
+		CodeClientNotFound:               "Client not found",
+		CodeClientSlugTaken:              "Client slug already exists",
+		CodeDomainNotFound:               "Domain not found",
+		CodeDomainTaken:                  "Domain already exists",
+		CodeUserAlreadyExists:            "User already exists",
+		CodeUserNotFound:                 "User not found",
+		CodeProjectNotFound:              "Project not found or no access",
+		CodeDatasourceNotFound:           "Datasource not found",
+		CodeConversationNotFound:         "Conversation not found",
+		CodeParticipantExists:            "User is already in this conversation",
+		CodeMessageNotFound:              "Message not found",
+		CodeDraftNotFound:                "Draft not found or already approved or discarded",
+		CodeSchemaSnapshotNotFound:       "Schema snapshot not found",
+		CodeAPIKeyNotFound:               "API key not found",
+		CodeShareNotFound:                "Shared conversation not found or no longer available",
+		CodeToolCallNotFound:             "Tool call not found",
+		CodeToolResultNotFound:           "Tool result not found or no longer available",
+		CodeTemplateNotFound:             "Template not found",
+		CodeTemplateNameTaken:            "A template with this name already exists",
+		CodeNotificationSettingsNotFound: "Notification settings not found",
+		CodeTenantJobNotFound:            "Export or purge job not found",
+		CodeExportNotReady:               "The export is {status} and cannot be downloaded",
+		CodeContentFilterNotFound:        "Content filter not found",
+		CodeScheduleNotFound:             "Schedule not found",
+		CodeTableNotFound:                "Table {table} not found",
+
+		CodeInvalidRequestBody:       "Invalid JSON format",
+		CodeFieldRequired:            "{field} is required",
+		CodeFieldOutOfRange:          "{field} must be at least {min}",
+		CodeFieldInvalid:             "Invalid {field}",
+		CodeInvalidMessage:           "Invalid {type} message",
+		CodeMessageTooLong:           "Message is too long ({length} characters); the limit is {limit} characters",
+		CodeInvalidFeedback:          "Invalid feedback",
+		CodeTemplateInvalid:          "Invalid template {field}: {reason}",
+		CodeTemplateVariablesMissing: "Missing template variables: {variables}",
+		CodeToolParametersInvalid:    "Invalid parameter {parameter} for tool {tool}: {reason}",
+		CodeDomainInvalid:            "Invalid domain {domain}: {reason}",
+		CodeBrandingInvalid:          "Invalid branding {field}: {reason}",
+		CodePurgeConfirmationInvalid: "The purge confirmation token is invalid or has expired",
+		CodeContentFilterInvalid:     "Invalid content filter {field}: {reason}",
+		CodeDatasourceBundleInvalid:  "Invalid datasource bundle: {reason}",
+		CodeScheduleInvalid:          "Invalid schedule {field}: {reason}",
+
+		CodeTokenLimitExceeded:      "Token limit exceeded",
+		CodeRateLimited:             "Too many messages, please wait a moment",
+		CodeVisitorForbidden:        "Widget visitors cannot change project",
+		CodeNotInProject:            "Join a project first",
+		CodeUnsupportedProtocol:     "Unsupported protocol version {protocol_version}",
+		CodePinLimitReached:         "Too many pinned conversations",
+		CodeConversationOwnerOnly:   "Only the conversation owner can do this",
+		CodeStreamNotFound:          "No active stream for this conversation",
+		CodeStreamSeqInvalid:        "last_seq is ahead of the stream",
+		CodeStreamAlreadyActive:     "A response is already being generated for this conversation",
+		CodeNotInterrupted:          "This conversation has no interrupted response to resume",
+		CodeQueueTimeout:            "Timed out waiting for a free response slot",
+		CodeQueueFull:               "Too many requests are waiting; try again shortly",
+		CodeLLMConfigUnavailable:    "Failed to load LLM configuration",
+		CodeLLMNotConfigured:        "The assistant is not set up for your organization yet; please contact your administrator",
+		CodeLLMRateLimited:          "The AI provider is busy; try again in {retry_after_seconds} seconds",
+		CodeLLMRequestFailed:        "The AI provider could not answer the request",
+		CodeDatasourceUnavailable:   "The datasource could not be inspected",
+		CodeTokenBudgetExhausted:    "The daily budget of {limit} tokens is used up; it resets at {resets_at}",
+		CodeMessageProcessingFailed: "Failed to process message",
+		CodeExportUnavailable:       "Export is not available",
+		CodeModelNotAllowed:         "Model {model} is not available to this client",
+		CodeToolUnavailable:         "Tool {tool} is not available in this project",
+		CodeToolHasSideEffects:      "Tool {tool} can modify data; pass force=true to run it again",
+		CodeForkTooLarge:            "Conversations with more than {limit} messages cannot be forked",
+		CodeDatasourceImportRejected: "Nothing was imported: {conflicts} datasources conflict and {invalid} are invalid",
+		CodeLastClientAdmin:          "The client must keep at least one active admin",
+
+		CodeVersionRequired: "Send the version you are updating in If-Match or the version field",
+		CodeVersionConflict: "Someone else changed this since you loaded it; review the current version and try again",
+
+		CodeDatabaseError: "Database error",
+		CodeSaveFailed:    "Failed to save changes",
+		CodeInternal:      "Internal server error",
+	},
+	"id": {
+		CodeAuthRequired:           "Autentikasi diperlukan",
+		CodeAuthSessionExpired:     "Sesi tidak valid atau sudah kedaluwarsa",
+		CodeAuthInvalidCredentials: "Kredensial tidak valid",
+		CodeAuthAccountInactive:    "Akun pengguna tidak aktif",
+		CodeAuthTokenInvalid:       "Token autentikasi tidak valid",
+		CodeAdminRequired:          "Akses admin diperlukan",
+		CodeClientAdminRequired:    "Hanya admin klien Anda yang dapat mengelola pengguna",
+		CodeForbidden:              "Akses ditolak",
+		CodeAPIKeyInvalid:          "Kunci API tidak valid atau telah dicabut",
+		CodeAPIKeyForbidden:        "Kunci API tidak dapat mengakses endpoint ini",
+
+		CodeClientNotResolved:            "Klien tidak valid",
+		CodeClientIDInvalid:              "Format ID klien tidak valid",
+		CodeClientNotFound:               "Klien tidak ditemukan",
+		CodeClientSlugTaken:              "Slug klien sudah digunakan",
+		CodeDomainNotFound:               "Domain tidak ditemukan",
+		CodeDomainTaken:                  "Domain sudah terdaftar",
+		CodeUserAlreadyExists:            "Pengguna sudah terdaftar",
+		CodeUserNotFound:                 "Pengguna tidak ditemukan",
+		CodeProjectNotFound:              "Proyek tidak ditemukan atau tidak dapat diakses",
+		CodeDatasourceNotFound:           "Sumber data tidak ditemukan",
+		CodeConversationNotFound:         "Percakapan tidak ditemukan",
+		CodeParticipantExists:            "Pengguna sudah ada dalam percakapan ini",
+		CodeMessageNotFound:              "Pesan tidak ditemukan",
+		CodeDraftNotFound:                "Draf tidak ditemukan atau sudah disetujui atau dibuang",
+		CodeSchemaSnapshotNotFound:       "Snapshot skema tidak ditemukan",
+		CodeAPIKeyNotFound:               "Kunci API tidak ditemukan",
+		CodeShareNotFound:                "Percakapan yang dibagikan tidak ditemukan atau sudah tidak tersedia",
+		CodeToolCallNotFound:             "Pemanggilan tool tidak ditemukan",
+		CodeToolResultNotFound:           "Hasil tool tidak ditemukan atau sudah tidak tersedia",
+		CodeTemplateNotFound:             "Template tidak ditemukan",
+		CodeTemplateNameTaken:            "Template dengan nama ini sudah ada",
+		CodeNotificationSettingsNotFound: "Pengaturan notifikasi tidak ditemukan",
+		CodeTenantJobNotFound:            "Tugas ekspor atau penghapusan tidak ditemukan",
+		CodeExportNotReady:               "Ekspor berstatus {status} dan belum dapat diunduh",
+		CodeContentFilterNotFound:        "Filter konten tidak ditemukan",
+		CodeScheduleNotFound:             "Jadwal tidak ditemukan",
+		CodeTableNotFound:                "Tabel {table} tidak ditemukan",
+
+		CodeInvalidRequestBody:       "Format JSON tidak valid",
+		CodeFieldRequired:            "{field} wajib diisi",
+		CodeFieldOutOfRange:          "{field} minimal {min}",
+		CodeFieldInvalid:             "{field} tidak valid",
+		CodeInvalidMessage:           "Pesan {type} tidak valid",
+		CodeMessageTooLong:           "Pesan terlalu panjang ({length} karakter); batasnya {limit} karakter",
+		CodeInvalidFeedback:          "Umpan balik tidak valid",
+		CodeTemplateInvalid:          "{field} template tidak valid: {reason}",
+		CodeTemplateVariablesMissing: "Variabel template belum diisi: {variables}",
+		CodeToolParametersInvalid:    "Parameter {parameter} untuk tool {tool} tidak valid: {reason}",
+		CodeDomainInvalid:            "Domain {domain} tidak valid: {reason}",
+		CodeBrandingInvalid:          "Branding {field} tidak valid: {reason}",
+		CodePurgeConfirmationInvalid: "Token konfirmasi penghapusan tidak valid atau sudah kedaluwarsa",
+		CodeContentFilterInvalid:     "{field} filter konten tidak valid: {reason}",
+		CodeDatasourceBundleInvalid:  "Bundel datasource tidak valid: {reason}",
+		CodeScheduleInvalid:          "{field} jadwal tidak valid: {reason}",
+
+		CodeTokenLimitExceeded:      "Batas token terlampaui",
+		CodeRateLimited:             "Terlalu banyak pesan, mohon tunggu sebentar",
+		CodeVisitorForbidden:        "Pengunjung widget tidak dapat berpindah proyek",
+		CodeNotInProject:            "Bergabunglah dengan proyek terlebih dahulu",
+		CodeUnsupportedProtocol:     "Versi protokol {protocol_version} tidak didukung",
+		CodePinLimitReached:         "Terlalu banyak percakapan yang disematkan",
+		CodeConversationOwnerOnly:   "Hanya pemilik percakapan yang dapat melakukan ini",
+		CodeStreamNotFound:          "Tidak ada stream aktif untuk percakapan ini",
+		CodeStreamSeqInvalid:        "last_seq melebihi posisi stream",
+		CodeStreamAlreadyActive:     "Respons untuk percakapan ini sedang dibuat",
+		CodeNotInterrupted:          "Percakapan ini tidak memiliki respons terputus untuk dilanjutkan",
+		CodeQueueTimeout:            "Waktu habis saat menunggu slot respons",
+		CodeQueueFull:               "Terlalu banyak permintaan yang menunggu; coba lagi sebentar lagi",
+		CodeLLMConfigUnavailable:    "Gagal memuat konfigurasi LLM",
+		CodeLLMNotConfigured:        "Asisten belum diatur untuk organisasi Anda; silakan hubungi administrator Anda",
+		CodeLLMRateLimited:          "Penyedia AI sedang sibuk; coba lagi dalam {retry_after_seconds} detik",
+		CodeLLMRequestFailed:        "Penyedia AI tidak dapat menjawab permintaan",
+		CodeDatasourceUnavailable:   "Sumber data tidak dapat diperiksa",
+		CodeTokenBudgetExhausted:    "Anggaran harian {limit} token sudah habis; anggaran direset pada {resets_at}",
+		CodeMessageProcessingFailed: "Gagal memproses pesan",
+		CodeExportUnavailable:       "Ekspor tidak tersedia",
+		CodeModelNotAllowed:         "Model {model} tidak tersedia untuk klien ini",
+		CodeToolUnavailable:         "Tool {tool} tidak tersedia di proyek ini",
+		CodeToolHasSideEffects:      "Tool {tool} dapat mengubah data; kirim force=true untuk menjalankannya lagi",
+		CodeForkTooLarge:            "Percakapan dengan lebih dari {limit} pesan tidak dapat dicabangkan",
+		CodeDatasourceImportRejected: "Tidak ada yang diimpor: {conflicts} datasource bentrok dan {invalid} tidak valid",
+		CodeLastClientAdmin:          "Klien harus memiliki setidaknya satu admin aktif",
+
+		CodeVersionRequired: "Kirim versi yang Anda perbarui di If-Match atau kolom version",
+		CodeVersionConflict: "Orang lain mengubah data ini sejak Anda memuatnya; periksa versi terbaru lalu coba lagi",
+
+		CodeDatabaseError: "Kesalahan basis data",
+		CodeSaveFailed:    "Gagal menyimpan perubahan",
+		CodeInternal:      "Terjadi kesalahan pada server",
+	},
+}
@@ -0,0 +1,215 @@
+// Package apikeys issues and verifies the API keys other services use to call
+// the chat and conversations API. A key acts as the user who created it, within
+// the key's client and, for project keys, only on its project. Keys are shown
+// once when created; only their SHA-256 is stored.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Prefix marks API keys so they can be told apart from session and widget tokens
+const Prefix = "zlay_"
+
+// displayPrefixLength is how much of a key is kept to identify it in listings
+const displayPrefixLength = len(Prefix) + 8
+
+var (
+	// ErrInvalidKey is returned for unknown and revoked keys, and keys whose creator is inactive
+	ErrInvalidKey = errors.New("invalid or revoked API key")
+	// ErrKeyNotFound is returned when revoking a key outside the caller's scope
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// Key is an API key without its secret
+type Key struct {
+	ID         string     `json:"id"`
+	ClientID   string     `json:"client_id"`
+	ProjectID  *string    `json:"project_id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	CreatedBy  string     `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Scope is the identity a request authenticated with an API key acts as
+type Scope struct {
+	KeyID     string
+	ClientID  string
+	ProjectID string // Empty for client-level keys
+	UserID    string // The key's creator
+	Username  string
+	KeyName   string
+}
+
+// AllowsProject reports whether the key may act on a project
+func (s *Scope) AllowsProject(projectID string) bool {
+	return s.ProjectID == "" || s.ProjectID == projectID
+}
+
+// IsKey reports whether a bearer token looks like an API key
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Hash returns the stored form of a key, encoded like session token hashes
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// generate returns a new random key
+func generate() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Create issues a key for a client, or for one of its projects when projectID
+// is not empty. The returned secret is not stored and cannot be shown again.
+func Create(ctx context.Context, db tools.DBConnection, clientID, projectID, name, createdBy string) (*Key, string, error) {
+	secret, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &Key{
+		ID:        uuid.New().String(),
+		ClientID:  clientID,
+		Name:      name,
+		KeyPrefix: secret[:displayPrefixLength],
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+	if projectID != "" {
+		key.ProjectID = &projectID
+	}
+
+	_, err = db.Exec(ctx,
+		`INSERT INTO api_keys (id, client_id, project_id, name, key_prefix, key_hash, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.ClientID, key.ProjectID, key.Name, key.KeyPrefix, Hash(secret), key.CreatedBy, key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return key, secret, nil
+}
+
+// ListProject returns the active keys of a project
+func ListProject(ctx context.Context, db tools.DBConnection, clientID, projectID string) ([]Key, error) {
+	return list(ctx, db,
+		"WHERE client_id = $1 AND project_id = $2 AND revoked_at IS NULL", clientID, projectID)
+}
+
+// ListClient returns the active client-level keys a user created
+func ListClient(ctx context.Context, db tools.DBConnection, clientID, userID string) ([]Key, error) {
+	return list(ctx, db,
+		"WHERE client_id = $1 AND project_id IS NULL AND created_by = $2 AND revoked_at IS NULL", clientID, userID)
+}
+
+func list(ctx context.Context, db tools.DBConnection, where string, args ...interface{}) ([]Key, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, client_id, project_id, name, key_prefix, created_by, last_used_at, created_at
+		FROM api_keys `+where+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	for rows.Next() {
+		var key Key
+		var projectID sql.NullString
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.ClientID, &projectID, &key.Name, &key.KeyPrefix,
+			&key.CreatedBy, &lastUsedAt, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read API key: %w", err)
+		}
+		if projectID.Valid {
+			key.ProjectID = &projectID.String
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeProject revokes a key of a project
+func RevokeProject(ctx context.Context, db tools.DBConnection, clientID, projectID, keyID string) error {
+	return revoke(ctx, db, "AND project_id = $4", keyID, clientID, projectID)
+}
+
+// RevokeClient revokes a client-level key the user created
+func RevokeClient(ctx context.Context, db tools.DBConnection, clientID, userID, keyID string) error {
+	return revoke(ctx, db, "AND project_id IS NULL AND created_by = $4", keyID, clientID, userID)
+}
+
+func revoke(ctx context.Context, db tools.DBConnection, scope string, keyID, clientID, owner string) error {
+	if _, err := uuid.Parse(keyID); err != nil {
+		return ErrKeyNotFound
+	}
+	result, err := db.Exec(ctx,
+		`UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND client_id = $3 `+scope+` AND revoked_at IS NULL`,
+		time.Now().UTC(), keyID, clientID, owner)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a key to the scope it acts in. Revocation takes effect
+// on the next request since keys are looked up every time.
+func Authenticate(ctx context.Context, db tools.DBConnection, secret string) (*Scope, error) {
+	if !IsKey(secret) {
+		return nil, ErrInvalidKey
+	}
+
+	var scope Scope
+	var projectID sql.NullString
+	err := db.QueryRow(ctx,
+		`SELECT k.id, k.client_id, k.project_id, k.created_by, u.username, k.name
+		FROM api_keys k
+		JOIN users u ON u.id = k.created_by AND u.client_id = k.client_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND u.is_active = true`,
+		Hash(secret)).Scan(&scope.KeyID, &scope.ClientID, &projectID, &scope.UserID, &scope.Username, &scope.KeyName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidKey
+		}
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	scope.ProjectID = projectID.String
+	return &scope, nil
+}
+
+// TouchLastUsed records that a key was used without holding up the request
+func TouchLastUsed(db tools.DBConnection, keyID string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := db.Exec(ctx, "UPDATE api_keys SET last_used_at = $1 WHERE id = $2", time.Now().UTC(), keyID); err != nil {
+			log.Printf("Failed to record use of API key %s: %v", keyID, err)
+		}
+	}()
+}
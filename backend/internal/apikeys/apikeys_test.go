@@ -0,0 +1,139 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func setupKeysDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-a", "user-a", "project-a")
+	dbtest.Seed(t, zdb,
+		"UPDATE users SET username = 'alice' WHERE id = 'user-a'",
+		"INSERT INTO users (id, client_id, username, password_hash, is_active) VALUES ('user-off', 'client-a', 'gone', 'x', false)",
+	)
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+func TestHashIsStableAndHidesTheKey(t *testing.T) {
+	key, err := generate()
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if !IsKey(key) {
+		t.Errorf("Expected generated key %q to carry the %q prefix", key, Prefix)
+	}
+	if Hash(key) != Hash(key) {
+		t.Error("Expected Hash to be deterministic")
+	}
+	if strings.Contains(Hash(key), strings.TrimPrefix(key, Prefix)) {
+		t.Error("Expected the hash not to contain the key")
+	}
+
+	other, _ := generate()
+	if other == key || Hash(other) == Hash(key) {
+		t.Error("Expected distinct keys and hashes")
+	}
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	conn := setupKeysDB(t)
+	ctx := context.Background()
+
+	key, secret, err := Create(ctx, conn, "client-a", "project-a", "CI", "user-a")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, key.KeyPrefix) {
+		t.Errorf("Expected display prefix %q to start the secret", key.KeyPrefix)
+	}
+
+	var stored string
+	if err := conn.QueryRow(ctx, "SELECT key_hash FROM api_keys WHERE id = $1", key.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read stored key: %v", err)
+	}
+	if stored != Hash(secret) || stored == secret {
+		t.Errorf("Expected only the hash to be stored, got %q", stored)
+	}
+
+	scope, err := Authenticate(ctx, conn, secret)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if scope.KeyID != key.ID || scope.ClientID != "client-a" || scope.ProjectID != "project-a" ||
+		scope.UserID != "user-a" || scope.Username != "alice" {
+		t.Errorf("Unexpected scope: %+v", scope)
+	}
+	if !scope.AllowsProject("project-a") || scope.AllowsProject("project-b") {
+		t.Error("Expected the project key to allow only its project")
+	}
+
+	if _, err := Authenticate(ctx, conn, secret+"x"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for an unknown key, got %v", err)
+	}
+
+	// Keys of inactive users stop working
+	_, inactive, err := Create(ctx, conn, "client-a", "", "Old", "user-off")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := Authenticate(ctx, conn, inactive); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for an inactive creator, got %v", err)
+	}
+}
+
+func TestRevokeTakesEffectImmediately(t *testing.T) {
+	conn := setupKeysDB(t)
+	ctx := context.Background()
+
+	projectKey, projectSecret, err := Create(ctx, conn, "client-a", "project-a", "CI", "user-a")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	clientKey, clientSecret, err := Create(ctx, conn, "client-a", "", "Scripts", "user-a")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// A key is only revoked through the scope it belongs to
+	if err := RevokeProject(ctx, conn, "client-a", "project-b", projectKey.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound revoking from another project, got %v", err)
+	}
+	if err := RevokeClient(ctx, conn, "client-a", "user-a", projectKey.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound revoking a project key as a client key, got %v", err)
+	}
+	if err := RevokeClient(ctx, conn, "client-b", "user-a", clientKey.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound revoking from another client, got %v", err)
+	}
+
+	if err := RevokeProject(ctx, conn, "client-a", "project-a", projectKey.ID); err != nil {
+		t.Fatalf("RevokeProject failed: %v", err)
+	}
+	if _, err := Authenticate(ctx, conn, projectSecret); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected the revoked key to be rejected, got %v", err)
+	}
+	if _, err := Authenticate(ctx, conn, clientSecret); err != nil {
+		t.Errorf("Expected the other key to keep working, got %v", err)
+	}
+	if err := RevokeProject(ctx, conn, "client-a", "project-a", projectKey.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound revoking twice, got %v", err)
+	}
+
+	keys, err := ListClient(ctx, conn, "client-a", "user-a")
+	if err != nil {
+		t.Fatalf("ListClient failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != clientKey.ID || keys[0].ProjectID != nil {
+		t.Errorf("Expected only the client key listed, got %+v", keys)
+	}
+	if keys, _ := ListProject(ctx, conn, "client-a", "project-a"); len(keys) != 0 {
+		t.Errorf("Expected revoked keys to be hidden, got %+v", keys)
+	}
+}
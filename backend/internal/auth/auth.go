@@ -0,0 +1,195 @@
+// Package auth resolves session tokens to the user behind them. It is the one
+// place that decides whether a session is valid, shared by the HTTP middleware
+// and the WebSocket handshake: the token must match a session, the session must
+// not have reached its expires_at, and its user must be active.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+var (
+	// ErrNoSession is returned for an empty token and for tokens matching no session
+	ErrNoSession = errors.New("no session")
+	// ErrExpired is returned once the current time reaches the session's expires_at
+	ErrExpired = errors.New("session expired")
+	// ErrInactive is returned when the session's user has been deactivated
+	ErrInactive = errors.New("user account is inactive")
+)
+
+// DefaultCacheTTL is how long a resolved session is reused before it is looked up again
+const DefaultCacheTTL = 30 * time.Second
+
+// SessionUser is a valid session and the user it belongs to
+type SessionUser struct {
+	SessionID string
+	UserID    string
+	ClientID  string
+	Username  string
+	CreatedAt time.Time // When the user was created
+	ExpiresAt time.Time // When the session expires
+	// ImpersonatedBy is the root user who issued the session via POST /api/admin/impersonate
+	ImpersonatedBy string
+}
+
+// HashToken returns the hash a session token is stored under
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ResolveSession looks up the session of token in the database
+func ResolveSession(ctx context.Context, zdb *db.Database, token string) (*SessionUser, error) {
+	return resolve(ctx, zdb, token, time.Now())
+}
+
+func resolve(ctx context.Context, zdb *db.Database, token string, now time.Time) (*SessionUser, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+
+	row, err := zdb.QueryRow(ctx,
+		`SELECT s.id, s.expires_at, s.impersonated_by, u.id, u.client_id, u.username, u.is_active, u.created_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1`,
+		HashToken(token))
+	if errors.Is(err, db.ErrNoRows) || (err == nil && len(row.Values) < 8) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	session := &SessionUser{}
+	session.SessionID, _ = row.Values[0].AsString()
+	expiresAt, ok := row.Values[1].AsTimestamp()
+	if !ok {
+		return nil, fmt.Errorf("invalid session expiry %v", row.Values[1].Data)
+	}
+	session.ExpiresAt = expiresAt.Time
+	session.ImpersonatedBy, _ = row.Values[2].AsString()
+	session.UserID, _ = row.Values[3].AsString()
+	session.ClientID, _ = row.Values[4].AsString()
+	session.Username, _ = row.Values[5].AsString()
+	isActive, _ := row.Values[6].AsBool()
+	if createdAt, ok := row.Values[7].AsTimestamp(); ok {
+		session.CreatedAt = createdAt.Time
+	}
+
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	if !isActive {
+		return nil, ErrInactive
+	}
+	return session, nil
+}
+
+// Resolver resolves sessions through a short-lived in-memory cache so every
+// request is not a sessions join. Only valid sessions are cached, and a
+// cached session still expires at its own expires_at. Logging out, revoking
+// a session or deactivating a user must invalidate the cache, or the session
+// keeps working for up to the cache TTL.
+type Resolver struct {
+	db  *db.Database
+	ttl time.Duration // 0 disables the cache
+	now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]cachedSession // By token hash
+}
+
+type cachedSession struct {
+	session  SessionUser
+	cachedAt time.Time
+}
+
+// NewResolver creates a resolver caching sessions for ttl; 0 disables the cache
+func NewResolver(zdb *db.Database, ttl time.Duration) *Resolver {
+	return &Resolver{
+		db:      zdb,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cachedSession),
+	}
+}
+
+// Resolve returns the session of token, from the cache when it is fresh
+func (r *Resolver) Resolve(ctx context.Context, token string) (*SessionUser, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+	now := r.now()
+	tokenHash := HashToken(token)
+
+	if r.ttl > 0 {
+		r.mutex.Lock()
+		entry, ok := r.entries[tokenHash]
+		if ok && now.Sub(entry.cachedAt) >= r.ttl {
+			delete(r.entries, tokenHash)
+			ok = false
+		}
+		r.mutex.Unlock()
+		if ok {
+			if !now.Before(entry.session.ExpiresAt) {
+				r.InvalidateToken(token)
+				return nil, ErrExpired
+			}
+			session := entry.session
+			return &session, nil
+		}
+	}
+
+	session, err := resolve(ctx, r.db, token, now)
+	if err != nil {
+		return nil, err
+	}
+	if r.ttl > 0 {
+		r.mutex.Lock()
+		// Stale entries of tokens no longer used are dropped here
+		for key, entry := range r.entries {
+			if now.Sub(entry.cachedAt) >= r.ttl {
+				delete(r.entries, key)
+			}
+		}
+		r.entries[tokenHash] = cachedSession{session: *session, cachedAt: now}
+		r.mutex.Unlock()
+	}
+	return session, nil
+}
+
+// InvalidateToken drops the cached session of token
+func (r *Resolver) InvalidateToken(token string) {
+	r.mutex.Lock()
+	delete(r.entries, HashToken(token))
+	r.mutex.Unlock()
+}
+
+// InvalidateSession drops the cached session with the given ID
+func (r *Resolver) InvalidateSession(sessionID string) {
+	r.invalidate(func(session *SessionUser) bool { return session.SessionID == sessionID })
+}
+
+// InvalidateUser drops every cached session of a user
+func (r *Resolver) InvalidateUser(userID string) {
+	r.invalidate(func(session *SessionUser) bool { return session.UserID == userID })
+}
+
+func (r *Resolver) invalidate(match func(*SessionUser) bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for tokenHash, entry := range r.entries {
+		if match(&entry.session) {
+			delete(r.entries, tokenHash)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/dbtest"
+)
+
+// testNow is the fixed time sessions are resolved at
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestDatabase(t *testing.T) *db.Database {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1")
+	dbtest.Seed(t, zdb,
+		"UPDATE users SET username = 'alice' WHERE id = 'user-1'",
+		"INSERT INTO users (id, client_id, username, password_hash, is_active) VALUES ('user-2', 'client-1', 'bob', 'x', false)",
+	)
+
+	ctx := context.Background()
+	for _, session := range []struct {
+		id, userID, token string
+		expiresAt         time.Time
+	}{
+		{"session-1", "user-1", "valid-token", testNow.Add(time.Hour)},
+		{"session-2", "user-1", "boundary-token", testNow},
+		{"session-3", "user-2", "inactive-token", testNow.Add(time.Hour)},
+		{"session-4", "user-1", "short-token", testNow.Add(10 * time.Second)},
+	} {
+		if _, err := zdb.Execute(ctx, "INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at) VALUES ($1, 'client-1', $2, $3, $4)",
+			session.id, session.userID, HashToken(session.token), session.expiresAt); err != nil {
+			t.Fatalf("Failed to insert session: %v", err)
+		}
+	}
+	return zdb
+}
+
+func TestResolveSession(t *testing.T) {
+	zdb := newTestDatabase(t)
+	ctx := context.Background()
+
+	session, err := resolve(ctx, zdb, "valid-token", testNow)
+	if err != nil {
+		t.Fatalf("Expected the session to resolve, got %v", err)
+	}
+	if session.SessionID != "session-1" || session.UserID != "user-1" || session.ClientID != "client-1" || session.Username != "alice" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"empty token", "", testNow, ErrNoSession},
+		{"unknown token", "unknown-token", testNow, ErrNoSession},
+		{"just before expiry", "boundary-token", testNow.Add(-time.Nanosecond), nil},
+		{"at expiry", "boundary-token", testNow, ErrExpired},
+		{"inactive user", "inactive-token", testNow, ErrInactive},
+		// An expired session reports the expiry even when its user is inactive too
+		{"inactive user after expiry", "inactive-token", testNow.Add(2 * time.Hour), ErrExpired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := resolve(ctx, zdb, tc.token, tc.now); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestResolverCachesValidSessions(t *testing.T) {
+	zdb := newTestDatabase(t)
+	ctx := context.Background()
+	resolver := NewResolver(zdb, 30*time.Second)
+	now := testNow
+	resolver.now = func() time.Time { return now }
+
+	if _, err := resolver.Resolve(ctx, "valid-token"); err != nil {
+		t.Fatalf("Expected the session to resolve, got %v", err)
+	}
+	if _, err := resolver.Resolve(ctx, "inactive-token"); !errors.Is(err, ErrInactive) {
+		t.Fatalf("Expected ErrInactive, got %v", err)
+	}
+	if _, err := zdb.Execute(ctx, "DELETE FROM sessions WHERE id = 'session-1'"); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+
+	// The deleted session is served from the cache until the TTL runs out
+	now = now.Add(29 * time.Second)
+	if _, err := resolver.Resolve(ctx, "valid-token"); err != nil {
+		t.Errorf("Expected the cached session, got %v", err)
+	}
+	now = now.Add(time.Second)
+	if _, err := resolver.Resolve(ctx, "valid-token"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Expected ErrNoSession once the cache entry is stale, got %v", err)
+	}
+}
+
+func TestResolverHonoursExpiryAndInvalidation(t *testing.T) {
+	zdb := newTestDatabase(t)
+	ctx := context.Background()
+	resolver := NewResolver(zdb, time.Minute)
+	now := testNow
+	resolver.now = func() time.Time { return now }
+
+	// A cached session still expires at its own expires_at
+	if _, err := resolver.Resolve(ctx, "short-token"); err != nil {
+		t.Fatalf("Expected the session to resolve, got %v", err)
+	}
+	now = now.Add(10 * time.Second)
+	if _, err := resolver.Resolve(ctx, "short-token"); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired from the cache, got %v", err)
+	}
+
+	// Each case recreates session-1, resolves it and deletes it again
+	if _, err := zdb.Execute(ctx, "DELETE FROM sessions WHERE id = 'session-1'"); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	for _, invalidate := range []func(){
+		func() { resolver.InvalidateToken("valid-token") },
+		func() { resolver.InvalidateSession("session-1") },
+		func() { resolver.InvalidateUser("user-1") },
+	} {
+		if _, err := zdb.Execute(ctx, "INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at) VALUES ('session-1', 'client-1', 'user-1', $1, $2)",
+			HashToken("valid-token"), testNow.Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to insert session: %v", err)
+		}
+		if _, err := resolver.Resolve(ctx, "valid-token"); err != nil {
+			t.Fatalf("Expected the session to resolve, got %v", err)
+		}
+		if _, err := zdb.Execute(ctx, "DELETE FROM sessions WHERE id = 'session-1'"); err != nil {
+			t.Fatalf("Failed to delete session: %v", err)
+		}
+		invalidate()
+		if _, err := resolver.Resolve(ctx, "valid-token"); !errors.Is(err, ErrNoSession) {
+			t.Errorf("Expected ErrNoSession after invalidating, got %v", err)
+		}
+	}
+}
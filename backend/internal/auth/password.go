@@ -0,0 +1,24 @@
+package auth
+
+import "golang.org/x/crypto/bcrypt"
+
+// HashPassword returns the hash stored in users.password_hash
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a hash made by HashPassword
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// IsPasswordHash reports whether hash was made by HashPassword. Placeholder
+// hashes such as the one seeded by early schemas are not, and match no password.
+func IsPasswordHash(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
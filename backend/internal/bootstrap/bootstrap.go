@@ -0,0 +1,223 @@
+// Package bootstrap prepares an empty database for its first login: the
+// system client with the root user, a default client reachable through an
+// optional domain, and a demo project owned by root. Everything is created in
+// one transaction, and rows that already exist are left alone, so running it
+// again changes nothing.
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/domains"
+	"zlay-backend/internal/tools"
+)
+
+// Names of the rows bootstrap creates
+const (
+	SystemClientName = "System"
+	SystemClientSlug = "system"
+	RootUsername     = "root"
+	DemoProjectName  = "Demo Project"
+
+	DefaultClientName = "Default"
+	DefaultClientSlug = "default"
+)
+
+// ErrClientsExist is returned when the database already has clients and Force is not set
+var ErrClientsExist = errors.New("clients already exist; bootstrap with --force to add what is missing")
+
+// Options configures a bootstrap run; zero values use the defaults
+type Options struct {
+	ClientName   string
+	ClientSlug   string
+	Domain       string // Mapped to the default client when set
+	RootPassword string // Generated when empty
+	Force        bool   // Run even though clients exist
+}
+
+// Result describes the rows bootstrap found or created
+type Result struct {
+	SystemClientID string
+	ClientID       string
+	RootUserID     string
+	ProjectID      string
+	// GeneratedPassword is root's new password when one was generated; it is
+	// not stored anywhere else and must be shown to the operator
+	GeneratedPassword string
+	// Created lists what this run created, e.g. "client default"; empty when
+	// everything already existed
+	Created []string
+}
+
+// Run creates whatever of the bootstrap rows is missing. An existing root
+// keeps its password unless its hash is a placeholder that matches no password.
+func Run(ctx context.Context, db tools.DBConnection, options Options) (*Result, error) {
+	if options.ClientName = strings.TrimSpace(options.ClientName); options.ClientName == "" {
+		options.ClientName = DefaultClientName
+	}
+	if options.ClientSlug = strings.TrimSpace(options.ClientSlug); options.ClientSlug == "" {
+		options.ClientSlug = DefaultClientSlug
+	}
+	if options.Domain = strings.TrimSpace(options.Domain); options.Domain != "" {
+		normalized, err := domains.Normalize(options.Domain)
+		if err != nil {
+			return nil, err
+		}
+		options.Domain = normalized
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var clients int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM clients").Scan(&clients); err != nil {
+		return nil, fmt.Errorf("failed to count clients: %w", err)
+	}
+	if clients > 0 && !options.Force {
+		return nil, ErrClientsExist
+	}
+
+	result := &Result{}
+	if result.SystemClientID, err = ensureClient(ctx, tx, result, SystemClientName, SystemClientSlug); err != nil {
+		return nil, err
+	}
+	if result.ClientID, err = ensureClient(ctx, tx, result, options.ClientName, options.ClientSlug); err != nil {
+		return nil, err
+	}
+	if err := ensureRoot(ctx, tx, result, options.RootPassword); err != nil {
+		return nil, err
+	}
+	if options.Domain != "" {
+		if err := ensureDomain(ctx, tx, result, options.Domain); err != nil {
+			return nil, err
+		}
+	}
+	if err := ensureDemoProject(ctx, tx, result); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit bootstrap: %w", err)
+	}
+	return result, nil
+}
+
+// ensureClient returns the ID of the client with the given slug, creating it if needed
+func ensureClient(ctx context.Context, tx *sql.Tx, result *Result, name, slug string) (string, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, "SELECT id FROM clients WHERE slug = $1", slug).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to look up client %s: %w", slug, err)
+	}
+
+	id = uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO clients (id, name, slug, is_active, created_at) VALUES ($1, $2, $3, true, CURRENT_TIMESTAMP)",
+		id, name, slug); err != nil {
+		return "", fmt.Errorf("failed to create client %s: %w", slug, err)
+	}
+	result.Created = append(result.Created, "client "+slug)
+	return id, nil
+}
+
+// ensureRoot creates root in the system client, or gives an existing root with
+// a placeholder hash a usable password
+func ensureRoot(ctx context.Context, tx *sql.Tx, result *Result, password string) error {
+	var hash string
+	err := tx.QueryRowContext(ctx, "SELECT id, password_hash FROM users WHERE client_id = $1 AND username = $2",
+		result.SystemClientID, RootUsername).Scan(&result.RootUserID, &hash)
+	if err == nil && auth.IsPasswordHash(hash) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to look up root: %w", err)
+	}
+	exists := err == nil
+
+	if password == "" {
+		if password, err = generatePassword(); err != nil {
+			return err
+		}
+		result.GeneratedPassword = password
+	}
+	if hash, err = auth.HashPassword(password); err != nil {
+		return fmt.Errorf("failed to hash root password: %w", err)
+	}
+
+	if exists {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2", hash, result.RootUserID); err != nil {
+			return fmt.Errorf("failed to set root password: %w", err)
+		}
+		result.Created = append(result.Created, "root password")
+		return nil
+	}
+	result.RootUserID = uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO users (id, client_id, username, password_hash, is_active, created_at) VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP)",
+		result.RootUserID, result.SystemClientID, RootUsername, hash); err != nil {
+		return fmt.Errorf("failed to create root: %w", err)
+	}
+	result.Created = append(result.Created, "user root")
+	return nil
+}
+
+// ensureDomain maps the domain to the default client unless it is already mapped
+func ensureDomain(ctx context.Context, tx *sql.Tx, result *Result, domain string) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM domains WHERE domain = $1", domain).Scan(&count); err != nil {
+		return fmt.Errorf("failed to look up domain %s: %w", domain, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO domains (id, client_id, domain, normalized_domain, is_active, created_at) VALUES ($1, $2, $3, $3, true, CURRENT_TIMESTAMP)",
+		uuid.New().String(), result.ClientID, domain); err != nil {
+		return fmt.Errorf("failed to create domain %s: %w", domain, err)
+	}
+	result.Created = append(result.Created, "domain "+domain)
+	return nil
+}
+
+// ensureDemoProject gives root a project to start chatting in
+func ensureDemoProject(ctx context.Context, tx *sql.Tx, result *Result) error {
+	err := tx.QueryRowContext(ctx, "SELECT id FROM projects WHERE user_id = $1 AND name = $2 LIMIT 1",
+		result.RootUserID, DemoProjectName).Scan(&result.ProjectID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to look up demo project: %w", err)
+	}
+
+	result.ProjectID = uuid.New().String()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP)",
+		result.ProjectID, result.RootUserID, DemoProjectName, "Created by bootstrap to try out chat"); err != nil {
+		return fmt.Errorf("failed to create demo project: %w", err)
+	}
+	result.Created = append(result.Created, "project "+DemoProjectName)
+	return nil
+}
+
+func generatePassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate root password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,141 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	return &tools.ZlayDBAdapter{DB: dbtest.Open(t)}
+}
+
+func count(t *testing.T, conn tools.DBConnection, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	return n
+}
+
+func TestBootstrapCreatesRows(t *testing.T) {
+	conn := newTestDB(t)
+	ctx := context.Background()
+
+	result, err := Run(ctx, conn, Options{ClientName: "Acme", ClientSlug: "acme", Domain: " Chat.Example.com "})
+	if err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	expected := []string{"client system", "client acme", "user root", "domain chat.example.com", "project Demo Project"}
+	if !reflect.DeepEqual(result.Created, expected) {
+		t.Errorf("Expected %v created, got %v", expected, result.Created)
+	}
+
+	var clientID, hash string
+	if err := conn.QueryRow(ctx, "SELECT client_id, password_hash FROM users WHERE username = 'root'").Scan(&clientID, &hash); err != nil {
+		t.Fatalf("Expected root to exist: %v", err)
+	}
+	if clientID != result.SystemClientID {
+		t.Errorf("Expected root in the system client")
+	}
+	if result.GeneratedPassword == "" || !auth.CheckPassword(hash, result.GeneratedPassword) {
+		t.Errorf("Expected root to log in with the generated password")
+	}
+	if n := count(t, conn, "SELECT COUNT(*) FROM clients WHERE slug = 'acme' AND name = 'Acme' AND is_active = true"); n != 1 {
+		t.Errorf("Expected the named default client, got %d", n)
+	}
+	if n := count(t, conn, "SELECT COUNT(*) FROM domains WHERE domain = 'chat.example.com' AND client_id = $1", result.ClientID); n != 1 {
+		t.Errorf("Expected the domain mapped to the default client, got %d", n)
+	}
+	if n := count(t, conn, "SELECT COUNT(*) FROM projects WHERE id = $1 AND user_id = $2", result.ProjectID, result.RootUserID); n != 1 {
+		t.Errorf("Expected the demo project owned by root, got %d", n)
+	}
+}
+
+func TestBootstrapIsIdempotent(t *testing.T) {
+	conn := newTestDB(t)
+	ctx := context.Background()
+
+	first, err := Run(ctx, conn, Options{RootPassword: "correct horse", Domain: "localhost"})
+	if err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	if first.GeneratedPassword != "" {
+		t.Errorf("Expected ROOT_PASSWORD to be used, got a generated one")
+	}
+
+	if _, err := Run(ctx, conn, Options{}); !errors.Is(err, ErrClientsExist) {
+		t.Fatalf("Expected ErrClientsExist without Force, got %v", err)
+	}
+
+	second, err := Run(ctx, conn, Options{RootPassword: "other", Domain: "localhost", Force: true})
+	if err != nil {
+		t.Fatalf("Forced bootstrap failed: %v", err)
+	}
+	if len(second.Created) != 0 || second.RootUserID != first.RootUserID || second.ProjectID != first.ProjectID ||
+		second.ClientID != first.ClientID {
+		t.Errorf("Expected a second run to find the first run's rows, got %+v", second)
+	}
+	for table, expected := range map[string]int{"clients": 2, "users": 1, "domains": 1, "projects": 1} {
+		if n := count(t, conn, "SELECT COUNT(*) FROM "+table); n != expected {
+			t.Errorf("Expected %d %s, got %d", expected, table, n)
+		}
+	}
+
+	var hash string
+	conn.QueryRow(ctx, "SELECT password_hash FROM users WHERE id = $1", first.RootUserID).Scan(&hash)
+	if !auth.CheckPassword(hash, "correct horse") {
+		t.Error("Expected an existing root to keep its password")
+	}
+}
+
+func TestBootstrapRepairsPlaceholderRootPassword(t *testing.T) {
+	conn := newTestDB(t)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"INSERT INTO clients (id, name, slug, is_active) VALUES ('client-system', 'System', 'system', true)",
+		"INSERT INTO users (id, client_id, username, password_hash, is_active) VALUES ('user-root', 'client-system', 'root', '2qULuXcLmuJ2JeqwuEazZbnKk', true)",
+	} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+	}
+
+	result, err := Run(ctx, conn, Options{RootPassword: "s3cret", Force: true})
+	if err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	if result.RootUserID != "user-root" || result.SystemClientID != "client-system" {
+		t.Errorf("Expected the seeded system client and root to be reused, got %+v", result)
+	}
+	var hash string
+	conn.QueryRow(ctx, "SELECT password_hash FROM users WHERE id = 'user-root'").Scan(&hash)
+	if !auth.CheckPassword(hash, "s3cret") {
+		t.Error("Expected the placeholder hash to be replaced")
+	}
+}
+
+func TestBootstrapIsTransactional(t *testing.T) {
+	conn := newTestDB(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "DROP TABLE projects"); err != nil {
+		t.Fatalf("Failed to drop projects: %v", err)
+	}
+
+	if _, err := Run(ctx, conn, Options{Domain: "localhost"}); err == nil {
+		t.Fatal("Expected the missing projects table to fail the bootstrap")
+	}
+	for _, table := range []string{"clients", "users", "domains"} {
+		if n := count(t, conn, "SELECT COUNT(*) FROM "+table); n != 0 {
+			t.Errorf("Expected no %s after a failed bootstrap, got %d", table, n)
+		}
+	}
+}
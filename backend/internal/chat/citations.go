@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// CitationOpen and CitationClose surround the tool call ID of a citation marker
+	CitationOpen  = "【tool:"
+	CitationClose = "】"
+	// maxCitationIDLength bounds the ID of a marker; longer runs are not markers
+	maxCitationIDLength = 128
+	// maxCitationArgumentChars caps the arguments listed for each citable tool call
+	maxCitationArgumentChars = 300
+
+	citationDirective = "When a statement in your answer relies on one of the tool results below, put its marker, " +
+		"such as " + CitationOpen + "<tool_call_id>" + CitationClose + ", right after the statement. " +
+		"Only cite these tool calls, with their IDs exactly as given:\n"
+)
+
+// Citation is a marker in a reply pointing at the tool call whose result
+// informed it. Start and End are the offsets of the marker in the reply's
+// content, in characters (Unicode code points).
+type Citation struct {
+	ToolCallID string `json:"tool_call_id"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+}
+
+// ParseCitations checks the citation markers in a reply against the tool call
+// IDs it may cite. Markers naming other IDs, empty and unterminated markers are
+// removed, as is a marker repeating the one right before it. It returns the
+// content without them and a Citation for each marker kept, in order.
+func ParseCitations(content string, valid map[string]bool) (string, []Citation) {
+	if !strings.Contains(content, CitationOpen) {
+		return content, nil
+	}
+
+	var out strings.Builder
+	var citations []Citation
+	written := 0 // Characters written to out
+	rest := content
+	for {
+		i := strings.Index(rest, CitationOpen)
+		if i < 0 {
+			out.WriteString(rest)
+			break
+		}
+		out.WriteString(rest[:i])
+		written += utf8.RuneCountInString(rest[:i])
+		rest = rest[i+len(CitationOpen):]
+
+		n := citationIDLength(rest)
+		if n > maxCitationIDLength || !strings.HasPrefix(rest[n:], CitationClose) {
+			// Unterminated: drop the opening and the ID it started
+			rest = rest[n:]
+			continue
+		}
+		id := rest[:n]
+		rest = rest[n+len(CitationClose):]
+		if !valid[id] {
+			continue
+		}
+		if last := len(citations) - 1; last >= 0 && citations[last].ToolCallID == id && citations[last].End == written {
+			continue
+		}
+
+		marker := CitationOpen + id + CitationClose
+		out.WriteString(marker)
+		start := written
+		written += utf8.RuneCountInString(marker)
+		citations = append(citations, Citation{ToolCallID: id, Start: start, End: written})
+	}
+	return out.String(), citations
+}
+
+// citationIDLength returns the length in bytes of the ID s starts with: the
+// characters up to whitespace or a marker bracket
+func citationIDLength(s string) int {
+	for i, r := range s {
+		if unicode.IsSpace(r) || r == '【' || r == '】' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// citableToolCalls returns the completed tool calls of the assistant messages
+// in history, which a reply may cite
+func citableToolCalls(history []*Message) []ToolCall {
+	var calls []ToolCall
+	seen := make(map[string]bool)
+	for _, msg := range history {
+		if msg.Role != "assistant" {
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			if call.Status == "completed" && call.ID != "" && !seen[call.ID] {
+				seen[call.ID] = true
+				calls = append(calls, call)
+			}
+		}
+	}
+	return calls
+}
+
+// withCitations prepares a reply of a project with citations enabled: the
+// tool calls it may cite are recorded on req, and a system message listing
+// them is appended to history. history is returned as is when the project has
+// citations off or there is nothing to cite.
+func (s *chatService) withCitations(ctx context.Context, req *ChatRequest, history []*Message) []*Message {
+	if !s.citationsEnabled(ctx, req.ProjectID) {
+		return history
+	}
+	calls := citableToolCalls(history)
+	req.citableToolCalls = make(map[string]bool, len(calls))
+	if len(calls) == 0 {
+		return history
+	}
+
+	var directive strings.Builder
+	directive.WriteString(citationDirective)
+	for _, call := range calls {
+		req.citableToolCalls[call.ID] = true
+		fmt.Fprintf(&directive, "- %s%s%s %s %s\n", CitationOpen, call.ID, CitationClose, call.Function.Name, citationArguments(call))
+	}
+	return append(history, &Message{
+		ID:             "citations-" + req.ConversationID,
+		ConversationID: req.ConversationID,
+		Role:           "system",
+		Content:        strings.TrimSuffix(directive.String(), "\n"),
+	})
+}
+
+// citationsEnabled reports whether a project's replies cite tool results. A
+// project whose setting cannot be read is treated as having citations off.
+func (s *chatService) citationsEnabled(ctx context.Context, projectID string) bool {
+	var enabled bool
+	if err := s.db.QueryRow(ctx, "SELECT citations_enabled FROM projects WHERE id = $1", projectID).Scan(&enabled); err != nil {
+		log.Printf("Failed to read the citation setting of project %s, replying without citations: %v", projectID, err)
+		return false
+	}
+	return enabled
+}
+
+// citationArguments describes a tool call's arguments for the directive,
+// truncated to maxCitationArgumentChars
+func citationArguments(call ToolCall) string {
+	arguments, ok := call.Function.Arguments.(string)
+	if !ok {
+		encoded, _ := json.Marshal(call.Function.Arguments)
+		arguments = string(encoded)
+	}
+	if runes := []rune(arguments); len(runes) > maxCitationArgumentChars {
+		arguments = string(runes[:maxCitationArgumentChars]) + "…"
+	}
+	return arguments
+}
+
+// finalizeCitations keeps the valid citation markers of a finished reply and
+// records them in its metadata. The reply's own tool calls may be cited too.
+func finalizeCitations(req *ChatRequest, msg *Message) []Citation {
+	valid := make(map[string]bool, len(req.citableToolCalls)+len(msg.ToolCalls))
+	for id := range req.citableToolCalls {
+		valid[id] = true
+	}
+	for _, call := range msg.ToolCalls {
+		valid[call.ID] = true
+	}
+
+	content, citations := ParseCitations(msg.Content, valid)
+	msg.Content = content
+	if len(citations) > 0 {
+		msg.Metadata["citations"] = citations
+	}
+	return citations
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+func TestParseCitations(t *testing.T) {
+	valid := map[string]bool{"call-1": true, "call-2": true}
+
+	for _, tt := range []struct {
+		name, content, want string
+		citations           []Citation
+	}{
+		{"no markers", "Revenue rose.", "Revenue rose.", nil},
+		{"valid markers", "Up【tool:call-1】, down【tool:call-2】.", "Up【tool:call-1】, down【tool:call-2】.",
+			[]Citation{{"call-1", 2, 15}, {"call-2", 21, 34}}},
+		{"unknown ID", "Up【tool:call-9】.", "Up.", nil},
+		{"empty ID", "Up【tool:】.", "Up.", nil},
+		{"unterminated", "Up【tool:call-1 and more", "Up and more", nil},
+		{"unterminated at the end", "Up【tool:call-1", "Up", nil},
+		{"nested opening", "Up【tool:【tool:call-1】.", "Up【tool:call-1】.", []Citation{{"call-1", 2, 15}}},
+		{"overlong ID", "Up【tool:" + strings.Repeat("x", maxCitationIDLength+1) + "】.", "Up】.", nil},
+		{"repeated marker", "Up【tool:call-1】【tool:call-1】.", "Up【tool:call-1】.", []Citation{{"call-1", 2, 15}}},
+		{"repeat after an invalid one", "Up【tool:call-1】【tool:bad】【tool:call-1】.", "Up【tool:call-1】.", []Citation{{"call-1", 2, 15}}},
+		{"same ID cited twice", "Up【tool:call-1】, and up【tool:call-1】.", "Up【tool:call-1】, and up【tool:call-1】.",
+			[]Citation{{"call-1", 2, 15}, {"call-1", 23, 36}}},
+		{"multibyte text", "Naik 📈【tool:call-1】.", "Naik 📈【tool:call-1】.", []Citation{{"call-1", 6, 19}}},
+	} {
+		content, citations := ParseCitations(tt.content, valid)
+		if content != tt.want {
+			t.Errorf("%s: expected content %q, got %q", tt.name, tt.want, content)
+		}
+		if !reflect.DeepEqual(citations, tt.citations) {
+			t.Errorf("%s: expected citations %+v, got %+v", tt.name, tt.citations, citations)
+		}
+		for _, citation := range citations {
+			if marker := string([]rune(content)[citation.Start:citation.End]); marker != CitationOpen+citation.ToolCallID+CitationClose {
+				t.Errorf("%s: offsets of %+v point at %q", tt.name, citation, marker)
+			}
+		}
+	}
+}
+
+func TestRepliesCiteToolResults(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "UPDATE projects SET citations_enabled = true WHERE id = 'project-1'"); err != nil {
+		t.Fatalf("Failed to enable citations: %v", err)
+	}
+	toolCalls, _ := MarshalToolCalls([]ToolCall{{ID: "call-1", Type: "function", Status: "completed",
+		Function: ToolCallFunction{Name: "sql_query", Arguments: `{"query":"SELECT SUM(total) FROM orders"}`}}})
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, created_at, tool_calls) VALUES ('conv-1-m2', 'conv-1', 'assistant', '', $1, $2)",
+		time.Now().UTC(), string(toolCalls)); err != nil {
+		t.Fatalf("Failed to insert the tool call: %v", err)
+	}
+
+	// The first marker is split across chunks; the second names a call the reply never saw
+	client := &scriptedLLMClient{chunks: []string{"There are 42 orders【to", "ol:call-1】 worth 9k【tool:call-9】."}}
+	service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+	if err := service.ProcessUserMessage(userMessageRequest("")); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	want := "There are 42 orders【tool:call-1】 worth 9k."
+	var content, metadata string
+	if err := conn.QueryRow(ctx, "SELECT content, metadata FROM messages WHERE conversation_id = 'conv-1' AND role = 'assistant' AND id != 'conv-1-m2'").Scan(&content, &metadata); err != nil {
+		t.Fatalf("Failed to load the reply: %v", err)
+	}
+	if content != want {
+		t.Errorf("Expected the saved reply %q, got %q", want, content)
+	}
+	var decoded struct {
+		Citations []Citation `json:"citations"`
+	}
+	json.Unmarshal([]byte(metadata), &decoded)
+	if wantCitations := []Citation{{"call-1", 19, 32}}; !reflect.DeepEqual(decoded.Citations, wantCitations) {
+		t.Errorf("Expected citations %+v in the metadata, got %s", wantCitations, metadata)
+	}
+
+	frames := hub.eventsOfType("assistant_response")
+	done := frames[len(frames)-1].Data
+	if done["done"] != true || done["content"] != want {
+		t.Errorf("Expected the completion frame to carry the final reply, got %v", done)
+	}
+	if citations, _ := done["citations"].([]interface{}); len(citations) != 1 {
+		t.Errorf("Expected one citation in the completion frame, got %v", done["citations"])
+	}
+}
+
+func TestRepliesWithoutCitations(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+
+	// Without a projects table the setting cannot be read, so the reply is left alone
+	client := &scriptedLLMClient{chunks: []string{"There are 42 orders【tool:call-9】."}}
+	service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+	if err := service.ProcessUserMessage(userMessageRequest("")); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	frames := hub.eventsOfType("assistant_response")
+	if done := frames[len(frames)-1].Data; done["citations"] != nil {
+		t.Errorf("Expected no citations with citations off, got %v", done["citations"])
+	}
+	var content string
+	if err := conn.QueryRow(context.Background(), "SELECT content FROM messages WHERE conversation_id = 'conv-1' AND role = 'assistant'").Scan(&content); err != nil {
+		t.Fatalf("Failed to load the reply: %v", err)
+	}
+	if content != "There are 42 orders【tool:call-9】." {
+		t.Errorf("Expected the reply saved as generated, got %q", content)
+	}
+}
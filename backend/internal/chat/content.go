@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// DefaultMaxMessageChars is the longest user message accepted by default
+	DefaultMaxMessageChars = 32000
+	// attachmentPreviewChars is how much of an attached paste stays in the message
+	attachmentPreviewChars = 1000
+)
+
+var ErrEmptyMessage = errors.New("message content is empty")
+
+// MessageTooLongError reports a message over the character limit
+type MessageTooLongError struct {
+	Limit  int
+	Length int
+}
+
+func (e *MessageTooLongError) Error() string {
+	return fmt.Sprintf("message is %d characters long; the limit is %d", e.Length, e.Limit)
+}
+
+// MessagePolicy is how user message content is checked before it is stored or
+// sent to the LLM. With AttachOversized, messages over MaxChars are saved as a
+// project file under FilesDir and replaced by a preview that references it.
+type MessagePolicy struct {
+	MaxChars        int
+	AttachOversized bool
+	FilesDir        string
+}
+
+func (p MessagePolicy) maxChars() int {
+	if p.MaxChars <= 0 {
+		return DefaultMaxMessageChars
+	}
+	return p.MaxChars
+}
+
+// NormalizeContent replaces invalid UTF-8, strips control characters other
+// than newlines and tabs and trims surrounding whitespace. Lengths are counted
+// in characters (runes), not bytes.
+func NormalizeContent(content string, maxChars int) (string, error) {
+	content = strings.ToValidUTF8(content, "\uFFFD")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, content)
+	content = strings.TrimSpace(content)
+
+	if content == "" {
+		return "", ErrEmptyMessage
+	}
+	if length := utf8.RuneCountInString(content); maxChars > 0 && length > maxChars {
+		return content, &MessageTooLongError{Limit: maxChars, Length: length}
+	}
+	return content, nil
+}
+
+// TruncateRunes returns the first n characters of s without splitting a
+// multi-byte character
+func TruncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// Prepare normalizes a user message for projectID. An oversized message is
+// rejected with a *MessageTooLongError unless the policy attaches it, in which
+// case the returned content is the preview that references the new file.
+// Messages without a project cannot be attached and are always rejected.
+func (p MessagePolicy) Prepare(ctx context.Context, db tools.DBConnection, userID, projectID, content string) (string, error) {
+	content, err := NormalizeContent(content, p.maxChars())
+	var tooLong *MessageTooLongError
+	if !errors.As(err, &tooLong) || !p.AttachOversized || projectID == "" {
+		return content, err
+	}
+
+	fileID := uuid.New().String()
+	filename := fmt.Sprintf("message-%s.txt", time.Now().UTC().Format("20060102-150405"))
+	if err := p.writeAttachment(fileID, content); err != nil {
+		return "", fmt.Errorf("failed to store message attachment: %w", err)
+	}
+	if _, err := db.Exec(ctx,
+		"INSERT INTO project_files (id, project_id, user_id, filename, content_type, size_bytes, created_at) VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)",
+		fileID, projectID, userID, filename, "text/plain; charset=utf-8", len(content)); err != nil {
+		p.removeAttachment(fileID)
+		return "", fmt.Errorf("failed to save message attachment: %w", err)
+	}
+
+	note := fmt.Sprintf("[The full message (%d characters) was attached as %s (file_id: %s). Use the file_read tool to read it.]",
+		tooLong.Length, filename, fileID)
+	previewChars := attachmentPreviewChars
+	if limit := p.maxChars() - utf8.RuneCountInString(note) - 3; limit < previewChars {
+		previewChars = limit
+	}
+	if previewChars <= 0 {
+		return note, nil
+	}
+	return TruncateRunes(content, previewChars) + "…\n\n" + note, nil
+}
+
+func (p MessagePolicy) writeAttachment(fileID, content string) error {
+	path, err := tools.ProjectFilePath(p.FilesDir, fileID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.FilesDir, 0o750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o640)
+}
+
+func (p MessagePolicy) removeAttachment(fileID string) {
+	if path, err := tools.ProjectFilePath(p.FilesDir, fileID); err == nil {
+		os.Remove(path)
+	}
+}
+
+// ContentErrorCode maps a Prepare error to its apierror code and details.
+// messageType and field name the request and the field that carried the content.
+func ContentErrorCode(err error, messageType, field string) (string, map[string]interface{}) {
+	var tooLong *MessageTooLongError
+	switch {
+	case errors.Is(err, ErrEmptyMessage):
+		return apierror.CodeInvalidMessage, map[string]interface{}{"type": messageType, "field": field, "reason": "is required"}
+	case errors.As(err, &tooLong):
+		return apierror.CodeMessageTooLong, map[string]interface{}{"field": field, "limit": tooLong.Limit, "length": tooLong.Length}
+	default:
+		return apierror.CodeSaveFailed, nil
+	}
+}
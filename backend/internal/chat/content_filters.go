@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"context"
+	"log"
+
+	"zlay-backend/internal/contentfilter"
+)
+
+// contentFilters loads the content filters of the request's client. A reply
+// whose filters cannot be loaded is streamed unfiltered rather than failing.
+func (s *chatService) contentFilters(ctx context.Context, req *ChatRequest) *contentfilter.Pipeline {
+	if req.ClientID == "" {
+		return nil
+	}
+	pipeline, err := contentfilter.Load(ctx, s.db, req.ClientID)
+	if err != nil {
+		log.Printf("Failed to load content filters of client %s, streaming unfiltered: %v", req.ClientID, err)
+		return nil
+	}
+	return pipeline
+}
+
+// recordRedactions notes in the message's metadata how many matches the
+// content filters replaced, in total and per filter ID
+func recordRedactions(msg *Message, redactor *contentfilter.Stream) {
+	total := redactor.Total()
+	if total == 0 {
+		return
+	}
+	msg.Metadata["redactions"] = total
+	msg.Metadata["redactions_by_filter"] = redactor.Redactions()
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/tools"
+)
+
+func TestStreamedReplyIsRedacted(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "INSERT INTO clients (id, name, slug) VALUES ('client-2', 'client-2', 'client-2')"); err != nil {
+		t.Fatalf("Failed to insert client: %v", err)
+	}
+	if _, err := conn.Exec(ctx,
+		`INSERT INTO content_filters (id, client_id, name, kind, pattern, replacement, enabled, position, created_at, updated_at) VALUES
+		('filter-email', 'client-1', 'Emails', 'email', NULL, '[EMAIL]', true, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+		('filter-word', 'client-1', 'Words', 'regex', '(?i)\bheck\b', '****', true, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+		('filter-other', 'client-2', 'Other', 'regex', 'Reach', 'X', true, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("Failed to insert filters: %v", err)
+	}
+
+	// The address and the word are split across chunks
+	client := &scriptedLLMClient{chunks: []string{"Reach jane.do", "e@example.com, what the he", "ck."}}
+	service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+	req := userMessageRequest("")
+	req.ClientID = "client-1"
+	req.FlushPolicy = StreamFlushPolicy{Chars: 1}
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	want := "Reach [EMAIL], what the ****."
+	var streamed strings.Builder
+	for _, frame := range hub.eventsOfType("assistant_response") {
+		// Every frame's content is a prefix of the redacted reply, so nothing leaks before a match completes
+		if accumulated, _ := frame.Data["content"].(string); !strings.HasPrefix(want, accumulated) {
+			t.Errorf("Expected a prefix of %q, got %q", want, accumulated)
+		}
+		delta, _ := frame.Data["delta"].(string)
+		streamed.WriteString(delta)
+	}
+	if streamed.String() != want {
+		t.Errorf("Expected the streamed reply %q, got %q", want, streamed.String())
+	}
+
+	var content, metadata string
+	if err := conn.QueryRow(ctx, "SELECT content, metadata FROM messages WHERE conversation_id = 'conv-1' AND role = 'assistant'").Scan(&content, &metadata); err != nil {
+		t.Fatalf("Failed to load the reply: %v", err)
+	}
+	if content != want {
+		t.Errorf("Expected the saved reply %q, got %q", want, content)
+	}
+	var decoded struct {
+		Redactions         int            `json:"redactions"`
+		RedactionsByFilter map[string]int `json:"redactions_by_filter"`
+	}
+	json.Unmarshal([]byte(metadata), &decoded)
+	if decoded.Redactions != 2 || decoded.RedactionsByFilter["filter-email"] != 1 || decoded.RedactionsByFilter["filter-word"] != 1 {
+		t.Errorf("Expected the redactions in the metadata, got %s", metadata)
+	}
+}
@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeContentLengthBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		limit   int
+		tooLong bool
+	}{
+		{"at limit", strings.Repeat("a", 10), 10, false},
+		{"over limit", strings.Repeat("a", 11), 10, true},
+		{"multi-byte at limit", strings.Repeat("é", 10), 10, false},
+		{"multi-byte over limit", strings.Repeat("日", 11), 10, true},
+		{"emoji at limit", strings.Repeat("😀", 10), 10, false},
+		{"surrounding whitespace is not counted", "  " + strings.Repeat("a", 10) + "\n\n", 10, false},
+	} {
+		content, err := NormalizeContent(tc.content, tc.limit)
+		var tooLong *MessageTooLongError
+		if errors.As(err, &tooLong) != tc.tooLong {
+			t.Errorf("%s: expected too long %v, got %v", tc.name, tc.tooLong, err)
+			continue
+		}
+		if tc.tooLong && (tooLong.Limit != tc.limit || tooLong.Length != utf8.RuneCountInString(tc.content)) {
+			t.Errorf("%s: unexpected error %+v", tc.name, tooLong)
+		}
+		if !tc.tooLong && content != strings.TrimSpace(tc.content) {
+			t.Errorf("%s: unexpected content %q", tc.name, content)
+		}
+	}
+}
+
+func TestNormalizeContentStripsControlCharacters(t *testing.T) {
+	content, err := NormalizeContent("  he\x00llo\r\n\tw\x1borld\x7f ", 100)
+	if err != nil || content != "hello\n\tworld" {
+		t.Errorf("Expected control characters removed, got %q, %v", content, err)
+	}
+	content, err = NormalizeContent("bad \xff byte", 100)
+	if err != nil || !utf8.ValidString(content) {
+		t.Errorf("Expected invalid UTF-8 to be replaced, got %q, %v", content, err)
+	}
+
+	for _, empty := range []string{"", "   ", "\x00\x01\n\t", "\r\n"} {
+		if _, err := NormalizeContent(empty, 100); !errors.Is(err, ErrEmptyMessage) {
+			t.Errorf("Expected %q to be empty, got %v", empty, err)
+		}
+	}
+}
+
+func TestTruncateRunesKeepsWholeCharacters(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"héllo", 2, "hé"},
+		{"日本語", 2, "日本"},
+		{"😀😀😀", 1, "😀"},
+		{"abc", 5, "abc"},
+		{"abc", 0, ""},
+	} {
+		got := TruncateRunes(tc.s, tc.n)
+		if got != tc.want || !utf8.ValidString(got) {
+			t.Errorf("TruncateRunes(%q, %d) = %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestMessagePolicyAttachesOversizedMessages(t *testing.T) {
+	conn := setupRetentionDB(t)
+	ctx := context.Background()
+
+	long := strings.Repeat("日本語", 400)
+	policy := MessagePolicy{MaxChars: 500}
+	var tooLong *MessageTooLongError
+	if _, err := policy.Prepare(ctx, conn, "user-1", "project-1", long); !errors.As(err, &tooLong) {
+		t.Fatalf("Expected the message to be rejected without attachments, got %v", err)
+	}
+
+	policy.AttachOversized = true
+	policy.FilesDir = t.TempDir()
+	if _, err := policy.Prepare(ctx, conn, "user-1", "", long); !errors.As(err, &tooLong) {
+		t.Errorf("Expected a message without a project to be rejected, got %v", err)
+	}
+
+	content, err := policy.Prepare(ctx, conn, "user-1", "project-1", long)
+	if err != nil {
+		t.Fatalf("Expected the message to be attached, got %v", err)
+	}
+	if length := utf8.RuneCountInString(content); length > policy.MaxChars || !utf8.ValidString(content) {
+		t.Errorf("Expected a valid preview within the limit, got %d characters", length)
+	}
+
+	var fileID, projectID string
+	var size int
+	if err := conn.QueryRow(ctx, "SELECT id, project_id, size_bytes FROM project_files").Scan(&fileID, &projectID, &size); err != nil {
+		t.Fatalf("Expected a project file, got %v", err)
+	}
+	if projectID != "project-1" || size != len(long) || !strings.Contains(content, fileID) {
+		t.Errorf("Unexpected attachment %s in %s of %d bytes for %q", fileID, projectID, size, content)
+	}
+	stored, err := os.ReadFile(filepath.Join(policy.FilesDir, fileID))
+	if err != nil || string(stored) != long {
+		t.Errorf("Expected the full message to be stored, got %d bytes, %v", len(stored), err)
+	}
+}
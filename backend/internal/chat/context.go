@@ -0,0 +1,302 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	zdb "zlay-backend/internal/db"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	// replyTokenBudget is the completion budget requested from the LLM
+	replyTokenBudget = 4000
+	// maxHistoryMessages bounds how many recent messages are loaded for context
+	maxHistoryMessages = 500
+	// messageOverheadTokens approximates the role and framing cost of each message
+	messageOverheadTokens = 4
+	// summaryTokenBudget caps the synthetic summary message
+	summaryTokenBudget = 500
+	// summaryMessageTokens caps each message in the transcript sent for summarization
+	summaryMessageTokens = 500
+	// defaultContextWindow is used when the LLM client cannot report its window
+	defaultContextWindow = 4096
+	// DefaultToolResultTokenLimit is how many tokens a tool result may take in the
+	// prompt before it is replaced by a digest, for clients without their own limit
+	DefaultToolResultTokenLimit = 2000
+
+	summaryPrefix             = "Summary of the earlier conversation:\n"
+	truncatedToolResultMarker = "\n[tool result truncated]"
+	summaryInstructions       = "Summarize the conversation below for an assistant that will continue it. " +
+		"Keep facts, decisions, open questions, table and column names, and query results the user relied on. " +
+		"Reply with the summary only."
+)
+
+// contextSizer is implemented by LLM clients that can estimate token counts
+type contextSizer interface {
+	EstimateTokens(text string) (int, error)
+	GetContextWindow() int
+}
+
+// buildContext picks the most recent messages that fit the model's context window.
+// When older messages have to be dropped, a system message carrying a rolling
+// summary of the dropped portion is put in front of them. Results of tool runs
+// over toolResultLimit tokens are digested; 0 uses DefaultToolResultTokenLimit.
+func (s *chatService) buildContext(ctx context.Context, conversationID string, history []*Message, toolResultLimit int) []*Message {
+	budget := s.contextBudget()
+	selected, dropped := s.selectRecentMessages(history, budget, toolResultLimit)
+	if len(dropped) == 0 {
+		return selected
+	}
+
+	// Make room for the summary and select again
+	summaryTokens := min(summaryTokenBudget, budget/4)
+	selected, dropped = s.selectRecentMessages(history, budget-summaryTokens, toolResultLimit)
+
+	summary, err := s.conversationSummary(ctx, conversationID, dropped, summaryTokens)
+	if err != nil {
+		log.Printf("Failed to summarize %d dropped messages for conversation %s: %v", len(dropped), conversationID, err)
+		return selected
+	}
+	if summary == "" {
+		return selected
+	}
+
+	last := dropped[len(dropped)-1]
+	summaryMsg := &Message{
+		ID:             "summary-" + last.ID,
+		ConversationID: conversationID,
+		Role:           "system",
+		Content:        summaryPrefix + s.truncateToTokens(summary, summaryTokens-messageOverheadTokens),
+		CreatedAt:      last.CreatedAt,
+	}
+	return append([]*Message{summaryMsg}, selected...)
+}
+
+// selectRecentMessages walks history from the newest message back until the budget is
+// spent. It returns the kept messages in chronological order and the older ones it had to drop.
+// The newest message is always kept. Messages the model is never sent are left
+// out without counting against the budget. Tool results get at most a quarter of
+// the budget, or toolResultLimit tokens when that is less.
+func (s *chatService) selectRecentMessages(history []*Message, budget, toolResultLimit int) (selected, dropped []*Message) {
+	if toolResultLimit <= 0 {
+		toolResultLimit = DefaultToolResultTokenLimit
+	}
+	toolResultTokens := min(budget/4, toolResultLimit)
+	used := 0
+
+	i := len(history) - 1
+	for ; i >= 0; i-- {
+		if !sentToModel(history[i]) {
+			continue
+		}
+		msg := s.truncateToolResult(history[i], toolResultTokens)
+
+		cost := s.messageTokens(msg)
+		if used+cost > budget && len(selected) > 0 {
+			break
+		}
+		used += cost
+		selected = append(selected, msg)
+	}
+
+	for l, r := 0, len(selected)-1; l < r; l, r = l+1, r-1 {
+		selected[l], selected[r] = selected[r], selected[l]
+	}
+	return selected, history[:i+1]
+}
+
+// sentToModel reports whether convertToOpenAIMessages passes a message on to the model
+func sentToModel(msg *Message) bool {
+	return msg.Role == "user" || msg.Role == "assistant" || msg.Role == "system"
+}
+
+// truncateToolResult returns a copy of a tool run message whose result is cut
+// down to limit tokens; other messages are returned as they are. Query results
+// are replaced by a digest of their rows first, so the model still sees the row
+// count, column types and statistics; whatever is still too long is cut. The
+// stored message and what clients were sent are left as they are.
+func (s *chatService) truncateToolResult(msg *Message, limit int) *Message {
+	description, result, ok := splitToolRunContent(msg)
+	if !ok || s.estimateTokens(result) <= limit {
+		return msg
+	}
+
+	truncated := *msg
+	if digested, ok := tools.DigestToolResult([]byte(result), tools.DefaultDigestSampleRows); ok {
+		result = string(digested)
+		if s.estimateTokens(result) <= limit {
+			truncated.Content = description + result
+			return &truncated
+		}
+	}
+	truncated.Content = description + s.truncateToTokens(result, limit) + truncatedToolResultMarker
+	return &truncated
+}
+
+// truncateToTokens cuts text to roughly limit tokens on a rune boundary
+func (s *chatService) truncateToTokens(text string, limit int) string {
+	tokens := s.estimateTokens(text)
+	if tokens <= limit {
+		return text
+	}
+	if limit <= 0 {
+		return ""
+	}
+
+	cut := len(text) * limit / tokens
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
+
+func (s *chatService) messageTokens(msg *Message) int {
+	return s.estimateTokens(msg.Content) + messageOverheadTokens
+}
+
+func (s *chatService) estimateTokens(text string) int {
+	if sizer, ok := s.llmClient.(contextSizer); ok {
+		if tokens, err := sizer.EstimateTokens(text); err == nil {
+			return tokens
+		}
+	}
+	return len(text)/4 + 1
+}
+
+// replyTokens is the completion budget, capped at half of the context window
+func (s *chatService) replyTokens() int {
+	return min(replyTokenBudget, s.contextWindow()/2)
+}
+
+// contextBudget is the number of prompt tokens left after reserving the reply budget
+func (s *chatService) contextBudget() int {
+	return s.contextWindow() - s.replyTokens()
+}
+
+func (s *chatService) contextWindow() int {
+	if sizer, ok := s.llmClient.(contextSizer); ok {
+		if window := sizer.GetContextWindow(); window > 0 {
+			return window
+		}
+	}
+	return defaultContextWindow
+}
+
+// conversationSummary returns the summary of the dropped messages, generating and
+// caching it if this exact prefix of the conversation has not been summarized yet
+func (s *chatService) conversationSummary(ctx context.Context, conversationID string, dropped []*Message, maxTokens int) (string, error) {
+	last := dropped[len(dropped)-1]
+
+	var summary string
+	err := s.db.QueryRow(ctx,
+		"SELECT summary FROM conversation_summaries WHERE conversation_id = $1 AND last_message_id = $2",
+		conversationID, last.ID).Scan(&summary)
+	if err == nil {
+		return summary, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to load conversation summary: %w", err)
+	}
+
+	// Roll forward from the newest summary that covers part of the dropped messages
+	previous, covered, err := s.previousSummary(ctx, conversationID, dropped)
+	if err != nil {
+		return "", err
+	}
+
+	summary, err = s.summarize(ctx, previous, dropped[covered:], maxTokens)
+	if err != nil {
+		return "", err
+	}
+
+	// A summary another request stored meanwhile is just as good
+	if _, err := s.db.Exec(ctx,
+		"INSERT INTO conversation_summaries (conversation_id, last_message_id, summary, created_at) VALUES ($1, $2, $3, $4)",
+		conversationID, last.ID, summary, time.Now()); err != nil && !zdb.IsUniqueViolation(err) {
+		log.Printf("Failed to cache conversation summary for %s: %v", conversationID, err)
+		return summary, nil
+	}
+	if _, err := s.db.Exec(ctx,
+		"DELETE FROM conversation_summaries WHERE conversation_id = $1 AND last_message_id <> $2",
+		conversationID, last.ID); err != nil {
+		log.Printf("Failed to remove stale conversation summaries for %s: %v", conversationID, err)
+	}
+
+	return summary, nil
+}
+
+// previousSummary finds a stored summary ending inside dropped and returns it with
+// the number of dropped messages it already covers
+func (s *chatService) previousSummary(ctx context.Context, conversationID string, dropped []*Message) (string, int, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT last_message_id, summary FROM conversation_summaries WHERE conversation_id = $1 ORDER BY created_at DESC",
+		conversationID)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load previous summaries: %w", err)
+	}
+	defer rows.Close()
+
+	positions := make(map[string]int, len(dropped))
+	for i, msg := range dropped {
+		positions[msg.ID] = i
+	}
+
+	for rows.Next() {
+		var lastMessageID, summary string
+		if err := rows.Scan(&lastMessageID, &summary); err != nil {
+			return "", 0, err
+		}
+		if i, ok := positions[lastMessageID]; ok {
+			return summary, i + 1, nil
+		}
+	}
+	return "", 0, rows.Err()
+}
+
+// summarize asks the LLM for a summary of messages, folding in the previous summary
+func (s *chatService) summarize(ctx context.Context, previous string, messages []*Message, maxTokens int) (string, error) {
+	if len(messages) == 0 {
+		return previous, nil
+	}
+
+	// Keep the newest lines when the transcript itself is too large to send
+	inputBudget := s.contextWindow() - maxTokens - s.estimateTokens(summaryInstructions) - s.estimateTokens(previous)
+	var lines []string
+	for i := len(messages) - 1; i >= 0; i-- {
+		line := fmt.Sprintf("%s: %s", messages[i].Role, s.truncateToTokens(messages[i].Content, summaryMessageTokens))
+		inputBudget -= s.estimateTokens(line)
+		if inputBudget < 0 && len(lines) > 0 {
+			break
+		}
+		lines = append([]string{line}, lines...)
+	}
+
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Earlier summary:\n" + previous + "\n\nLater messages:\n")
+	}
+	transcript.WriteString(strings.Join(lines, "\n"))
+
+	resp, err := s.llmClient.Chat(ctx, &llm.LLMRequest{
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(summaryInstructions),
+			openai.UserMessage(transcript.String()),
+		},
+		MaxTokens:   maxTokens,
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+	return strings.TrimSpace(resp.Content), nil
+}
@@ -0,0 +1,269 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// wordTokenizerClient counts one token per word and records summary requests
+type wordTokenizerClient struct {
+	fakeLLMClient
+	window int
+
+	mutex     sync.Mutex
+	summaries []string // transcript of every summary request
+}
+
+func (w *wordTokenizerClient) EstimateTokens(text string) (int, error) {
+	return len(strings.Fields(text)), nil
+}
+
+func (w *wordTokenizerClient) GetContextWindow() int { return w.window }
+
+func (w *wordTokenizerClient) Chat(ctx context.Context, req *llm.LLMRequest) (*llm.LLMResponse, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.summaries = append(w.summaries, req.Messages[len(req.Messages)-1].OfUser.Content.OfString.Value)
+	return &llm.LLMResponse{Content: fmt.Sprintf("summary %d", len(w.summaries))}, nil
+}
+
+func (w *wordTokenizerClient) summaryCount() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.summaries)
+}
+
+func setupContextService(t *testing.T, client llm.LLMClient) (*chatService, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	// Summaries reference the last message they cover
+	for _, msg := range sixWordMessages(10) {
+		if _, err := conn.Exec(context.Background(),
+			"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, $3, $4, $5)",
+			msg.ID, msg.ConversationID, msg.Role, msg.Content, msg.CreatedAt); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+	return &chatService{db: conn, llmClient: client}, conn
+}
+
+// sixWordMessages builds n messages of six words each, so each costs ten tokens
+func sixWordMessages(n int) []*Message {
+	start := time.Now().Add(-time.Hour)
+	var messages []*Message
+	for i := 1; i <= n; i++ {
+		role := "user"
+		if i%2 == 0 {
+			role = "assistant"
+		}
+		messages = append(messages, &Message{
+			ID:             fmt.Sprintf("m%d", i),
+			ConversationID: "conv-1",
+			Role:           role,
+			Content:        fmt.Sprintf("message %d one two three four", i),
+			CreatedAt:      start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return messages
+}
+
+func messageIDs(messages []*Message) string {
+	var ids []string
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestBuildContextKeepsEverythingThatFits(t *testing.T) {
+	// Window of 100 leaves a 50 token prompt budget: five ten-token messages
+	client := &wordTokenizerClient{window: 100}
+	service, _ := setupContextService(t, client)
+
+	built := service.buildContext(context.Background(), "conv-1", sixWordMessages(5), 0)
+	if got := messageIDs(built); got != "m1,m2,m3,m4,m5" {
+		t.Errorf("Expected all messages in order, got %s", got)
+	}
+	if client.summaryCount() != 0 {
+		t.Error("No summary should be generated when nothing is dropped")
+	}
+}
+
+func TestBuildContextOnlyBudgetsMessagesSentToTheModel(t *testing.T) {
+	client := &wordTokenizerClient{window: 100}
+	service, _ := setupContextService(t, client)
+
+	// A tool message is never sent, so it must not push the older messages out
+	history := sixWordMessages(5)
+	tool := &Message{ID: "tool-1", ConversationID: "conv-1", Role: "tool", Content: strings.Repeat("row ", 40)}
+	history = append(history[:3], append([]*Message{tool}, history[3:]...)...)
+
+	built := service.buildContext(context.Background(), "conv-1", history, 0)
+	if got := messageIDs(built); got != "m1,m2,m3,m4,m5" {
+		t.Errorf("Expected every message sent to the model kept, got %s", got)
+	}
+	if len(service.convertToOpenAIMessages(built)) != len(built) {
+		t.Error("Expected every selected message to be sent")
+	}
+	if client.summaryCount() != 0 {
+		t.Error("No summary should be generated when nothing is dropped")
+	}
+}
+
+func TestBuildContextKeepsRecentMessagesAndSummarizesTheRest(t *testing.T) {
+	client := &wordTokenizerClient{window: 100}
+	service, conn := setupContextService(t, client)
+	history := sixWordMessages(8)
+
+	// 12 tokens are reserved for the summary, leaving room for the newest three
+	built := service.buildContext(context.Background(), "conv-1", history, 0)
+	if got := messageIDs(built); got != "summary-m5,m6,m7,m8" {
+		t.Fatalf("Unexpected context selection: %s", got)
+	}
+	if built[0].Role != "system" || !strings.HasSuffix(built[0].Content, "summary 1") {
+		t.Errorf("Expected a system message with the summary, got %+v", built[0])
+	}
+	if !strings.Contains(client.summaries[0], "message 1 one") || !strings.Contains(client.summaries[0], "message 5 one") {
+		t.Errorf("Expected the dropped messages in the summary request, got %q", client.summaries[0])
+	}
+
+	// The same dropped prefix is served from the cache
+	service.buildContext(context.Background(), "conv-1", history, 0)
+	if client.summaryCount() != 1 {
+		t.Errorf("Expected the cached summary to be reused, got %d summary requests", client.summaryCount())
+	}
+
+	// Two more messages roll the summary forward from the cached one
+	history = sixWordMessages(10)
+	built = service.buildContext(context.Background(), "conv-1", history, 0)
+	if got := messageIDs(built); got != "summary-m7,m8,m9,m10" {
+		t.Fatalf("Unexpected context selection after new messages: %s", got)
+	}
+	rolled := client.summaries[1]
+	if !strings.Contains(rolled, "summary 1") || strings.Contains(rolled, "message 5 one") || !strings.Contains(rolled, "message 7 one") {
+		t.Errorf("Expected only new messages on top of the previous summary, got %q", rolled)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversation_summaries"); n != 1 {
+		t.Errorf("Expected stale summaries to be removed, got %d rows", n)
+	}
+}
+
+// toolRunMessage is the message RunTool adds to a conversation for a run of database_query
+func toolRunMessage(id string, result *tools.ToolResult) *Message {
+	msg := NewMessage("conv-1", "system", toolRunContent(&ToolRun{Tool: "database_query", Params: map[string]interface{}{}, Result: result}), "user-1", "project-1")
+	msg.ID = id
+	msg.Metadata["tool_run"] = map[string]interface{}{"tool": "database_query", "status": result.Status, "run_by": "user-1"}
+	return msg
+}
+
+func TestBuildContextTruncatesOversizedToolResults(t *testing.T) {
+	client := &wordTokenizerClient{window: 200}
+	service, _ := setupContextService(t, client)
+
+	history := sixWordMessages(2)
+	history = append(history, toolRunMessage("run-1", &tools.ToolResult{Status: "completed", Data: map[string]interface{}{"output": strings.Repeat("row ", 200)}}))
+	history = append(history, &Message{ID: "m3", ConversationID: "conv-1", Role: "user", Content: "and now"})
+	stored := history[2].Content
+
+	built := service.buildContext(context.Background(), "conv-1", history, 0)
+	if got := messageIDs(built); got != "m1,m2,run-1,m3" {
+		t.Fatalf("Expected the tool result to be truncated rather than dropped, got %s", got)
+	}
+
+	run := built[2]
+	if !strings.HasPrefix(run.Content, "The user ran the database_query tool") || !strings.HasSuffix(run.Content, strings.TrimSpace(truncatedToolResultMarker)) {
+		t.Errorf("Expected the run's description and a truncation marker, got %q", run.Content)
+	}
+	if tokens, _ := client.EstimateTokens(run.Content); tokens > 40 {
+		t.Errorf("Expected the tool result to be cut to about a quarter of the budget, got %d tokens", tokens)
+	}
+	if history[2].Content != stored {
+		t.Error("Truncation must not modify the stored message")
+	}
+}
+
+func TestBuildContextDigestsOversizedQueryResults(t *testing.T) {
+	client := &wordTokenizerClient{window: 100000}
+	service, _ := setupContextService(t, client)
+
+	rows := make([]map[string]interface{}, 500)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"id": i + 1, "name": fmt.Sprintf("customer number %d", i+1), "total": float64(i % 10)}
+	}
+	history := []*Message{
+		{ID: "m1", ConversationID: "conv-1", Role: "user", Content: "list the customers"},
+		toolRunMessage("run-1", &tools.ToolResult{Status: "completed", Data: map[string]interface{}{
+			"result": map[string]interface{}{"type": "select", "columns": []string{"id", "name", "total"}, "rows": rows, "count": len(rows)},
+		}}),
+	}
+	stored := history[1].Content
+
+	// Under the default limit the result goes in whole
+	if built := service.buildContext(context.Background(), "conv-1", history, 0); built[1].Content != stored {
+		t.Fatalf("Expected the result kept under the default limit, got %.200s", built[1].Content)
+	}
+
+	// The digest is what the model is sent
+	converted := service.convertToOpenAIMessages(service.buildContext(context.Background(), "conv-1", history, 200))
+	if len(converted) != 2 || converted[1].OfSystem == nil {
+		t.Fatalf("Expected the tool run sent as a system message, got %+v", converted)
+	}
+	sent := converted[1].OfSystem.Content.OfString.Value
+	if !strings.HasPrefix(sent, "The user ran the database_query tool with parameters {}"+toolRunResultLabel) {
+		t.Errorf("Expected the run's description kept, got %.200s", sent)
+	}
+	for _, want := range []string{`"row_count":500`, `"name":"total","type":"number"`, `"max":9`, `"mean":4.5`, "narrower query", "customer number 10"} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("Expected %s in the digest, got %s", want, sent)
+		}
+	}
+	if strings.Contains(sent, "customer number 11") || strings.HasSuffix(sent, strings.TrimSpace(truncatedToolResultMarker)) {
+		t.Errorf("Expected only the first rows, untruncated, got %s", sent)
+	}
+	if history[1].Content != stored {
+		t.Error("Digesting must not modify the stored message")
+	}
+}
+
+func TestToolRunContentDigestsOversizedResults(t *testing.T) {
+	rows := make([]map[string]interface{}, 2000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"id": i + 1, "name": fmt.Sprintf("customer number %d", i+1)}
+	}
+	msg := toolRunMessage("run-1", &tools.ToolResult{Status: "completed", Data: map[string]interface{}{
+		"result": map[string]interface{}{"type": "select", "columns": []string{"id", "name"}, "rows": rows, "count": len(rows)},
+	}})
+
+	_, result, ok := splitToolRunContent(msg)
+	if !ok || !json.Valid([]byte(result)) || !strings.Contains(result, `"row_count":2000`) {
+		t.Errorf("Expected the stored result to be a digest, got %.200s", msg.Content)
+	}
+}
+
+func TestConvertedContextStartsWithSummary(t *testing.T) {
+	client := &wordTokenizerClient{window: 100}
+	service, _ := setupContextService(t, client)
+
+	converted := service.convertToOpenAIMessages(service.buildContext(context.Background(), "conv-1", sixWordMessages(8), 0))
+	if len(converted) != 4 {
+		t.Fatalf("Expected 4 messages, got %d", len(converted))
+	}
+	system := converted[0].OfSystem
+	if system == nil || !strings.HasPrefix(system.Content.OfString.Value, summaryPrefix) {
+		t.Errorf("Expected the first message to be the summary, got %+v", converted[0])
+	}
+	if converted[3].OfAssistant == nil {
+		t.Error("Expected the newest assistant message last")
+	}
+}
@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStreamAlreadyActive is returned when a conversation already has a response streaming
+	ErrStreamAlreadyActive = errors.New("a response is already streaming for this conversation")
+	// ErrInvalidClientMessageID is returned when client_message_id is not a UUID
+	ErrInvalidClientMessageID = errors.New("client_message_id must be a UUID")
+)
+
+const (
+	// recentMessageTTL is how long client message IDs stay in the in-memory cache
+	recentMessageTTL = 10 * time.Minute
+	// maxRecentMessages caps the in-memory cache; the unique index covers anything evicted
+	maxRecentMessages = 10000
+)
+
+// DuplicateMessageError is returned when a user message with the same
+// client_message_id was already accepted for the conversation
+type DuplicateMessageError struct {
+	ConversationID  string
+	ClientMessageID string
+	MessageID       string // ID of the stored message, empty if it is still being saved
+}
+
+func (e *DuplicateMessageError) Error() string {
+	return fmt.Sprintf("duplicate message %s in conversation %s", e.ClientMessageID, e.ConversationID)
+}
+
+// recentMessageIDs remembers recently accepted client message IDs so resends
+// after a reconnect are rejected without a database round trip
+type recentMessageIDs struct {
+	mutex   sync.Mutex
+	entries map[string]recentMessage
+	now     func() time.Time
+}
+
+type recentMessage struct {
+	messageID string
+	seenAt    time.Time
+}
+
+func newRecentMessageIDs() *recentMessageIDs {
+	return &recentMessageIDs{
+		entries: make(map[string]recentMessage),
+		now:     time.Now,
+	}
+}
+
+// claim records a client message ID; it returns false and the stored message ID
+// if the ID was already claimed within the TTL
+func (r *recentMessageIDs) claim(conversationID, clientMessageID string) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	key := conversationID + "/" + clientMessageID
+	if entry, exists := r.entries[key]; exists && now.Sub(entry.seenAt) < recentMessageTTL {
+		return entry.messageID, false
+	}
+
+	if len(r.entries) >= maxRecentMessages {
+		r.pruneLocked(now)
+	}
+	r.entries[key] = recentMessage{seenAt: now}
+	return "", true
+}
+
+// setMessageID attaches the stored message ID to a claimed client message ID
+func (r *recentMessageIDs) setMessageID(conversationID, clientMessageID, messageID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := conversationID + "/" + clientMessageID
+	if entry, exists := r.entries[key]; exists {
+		entry.messageID = messageID
+		r.entries[key] = entry
+	}
+}
+
+// release forgets a claim so the client can retry a message that was not saved
+func (r *recentMessageIDs) release(conversationID, clientMessageID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.entries, conversationID+"/"+clientMessageID)
+}
+
+func (r *recentMessageIDs) pruneLocked(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range r.entries {
+		if now.Sub(entry.seenAt) >= recentMessageTTL {
+			delete(r.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.seenAt.Before(oldest) {
+			oldestKey, oldest = key, entry.seenAt
+		}
+	}
+	// Still full: drop the oldest entry
+	if len(r.entries) >= maxRecentMessages {
+		delete(r.entries, oldestKey)
+	}
+}
@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// fakeLLMClient streams a fixed reply; when block is set, StreamChat waits on it
+type fakeLLMClient struct {
+	started chan struct{}
+	block   chan struct{}
+	delay   time.Duration
+}
+
+func (f *fakeLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.block != nil {
+		<-f.block
+	}
+	time.Sleep(f.delay)
+	if err := callback(&llm.StreamingChunk{Content: "Hello"}); err != nil {
+		return err
+	}
+	return callback(&llm.StreamingChunk{Done: true})
+}
+
+func (f *fakeLLMClient) Chat(ctx context.Context, req *llm.LLMRequest) (*llm.LLMResponse, error) {
+	return &llm.LLMResponse{Content: "Hello"}, nil
+}
+
+func (f *fakeLLMClient) SetModel(model string) error { return nil }
+
+func (f *fakeLLMClient) GetModel() string { return "fake" }
+
+type fakeHub struct{}
+
+func (fakeHub) BroadcastToProject(projectID string, message interface{}) {}
+
+func setupDedupeService(t *testing.T, client llm.LLMClient) (*chatService, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+
+	return NewChatService(conn, fakeHub{}, client, tools.NewToolRegistry()), conn
+}
+
+func userMessageRequest(clientMessageID string) *ChatRequest {
+	return &ChatRequest{
+		ConversationID:  "conv-1",
+		Content:         "How many orders?",
+		UserID:          "user-1",
+		ProjectID:       "project-1",
+		ClientMessageID: clientMessageID,
+	}
+}
+
+func countUserMessages(t *testing.T, conn tools.DBConnection) int {
+	t.Helper()
+	return countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'conv-1' AND role = 'user'")
+}
+
+func TestProcessUserMessageRejectsDoubleSend(t *testing.T) {
+	service, conn := setupDedupeService(t, &fakeLLMClient{})
+	baseline := countUserMessages(t, conn)
+	clientID := "6f1c2d3e-4a5b-4c6d-8e9f-0a1b2c3d4e5f"
+
+	if err := service.ProcessUserMessage(userMessageRequest(clientID)); err != nil {
+		t.Fatalf("First send failed: %v", err)
+	}
+
+	err := service.ProcessUserMessage(userMessageRequest(clientID))
+	var duplicate *DuplicateMessageError
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("Expected DuplicateMessageError on resend, got %v", err)
+	}
+	if duplicate.MessageID == "" {
+		t.Error("Expected duplicate error to carry the stored message ID")
+	}
+
+	// A fresh instance has an empty cache, so the database lookup must catch the resend
+	other := NewChatService(conn, fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+	if err := other.ProcessUserMessage(userMessageRequest(clientID)); !errors.As(err, &duplicate) {
+		t.Errorf("Expected DuplicateMessageError from database lookup, got %v", err)
+	}
+
+	if got := countUserMessages(t, conn) - baseline; got != 1 {
+		t.Errorf("Expected exactly one stored user message, got %d", got)
+	}
+
+	if err := service.ProcessUserMessage(userMessageRequest("not-a-uuid")); !errors.Is(err, ErrInvalidClientMessageID) {
+		t.Errorf("Expected ErrInvalidClientMessageID, got %v", err)
+	}
+}
+
+func TestProcessUserMessageRejectsConcurrentStream(t *testing.T) {
+	client := &fakeLLMClient{started: make(chan struct{}, 1), block: make(chan struct{})}
+	service, conn := setupDedupeService(t, client)
+	baseline := countUserMessages(t, conn)
+	firstID := "11111111-1111-4111-8111-111111111111"
+	secondID := "22222222-2222-4222-8222-222222222222"
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.WithLLMClient(client).ProcessUserMessage(userMessageRequest(firstID))
+	}()
+	<-client.started
+
+	// A resend of the in-flight message is a duplicate, a different message is refused
+	var duplicate *DuplicateMessageError
+	if err := service.ProcessUserMessage(userMessageRequest(firstID)); !errors.As(err, &duplicate) {
+		t.Errorf("Expected DuplicateMessageError for in-flight resend, got %v", err)
+	}
+	if err := service.WithLLMClient(client).ProcessUserMessage(userMessageRequest(secondID)); !errors.Is(err, ErrStreamAlreadyActive) {
+		t.Errorf("Expected ErrStreamAlreadyActive, got %v", err)
+	}
+
+	close(client.block)
+	client.started = nil
+	if err := <-done; err != nil {
+		t.Fatalf("First send failed: %v", err)
+	}
+
+	// The refused message was not consumed and can be sent once the stream ends
+	if err := service.ProcessUserMessage(userMessageRequest(secondID)); err != nil {
+		t.Fatalf("Retry after stream finished failed: %v", err)
+	}
+	if got := countUserMessages(t, conn) - baseline; got != 2 {
+		t.Errorf("Expected 2 stored user messages, got %d", got)
+	}
+}
+
+func TestProcessUserMessageConcurrentDuplicates(t *testing.T) {
+	service, conn := setupDedupeService(t, &fakeLLMClient{})
+	baseline := countUserMessages(t, conn)
+	clientID := "33333333-3333-4333-8333-333333333333"
+
+	const senders = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- service.ProcessUserMessage(userMessageRequest(clientID))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	accepted := 0
+	for err := range errs {
+		var duplicate *DuplicateMessageError
+		switch {
+		case err == nil:
+			accepted++
+		case errors.As(err, &duplicate), errors.Is(err, ErrStreamAlreadyActive):
+		default:
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("Expected exactly one accepted send, got %d", accepted)
+	}
+	if got := countUserMessages(t, conn) - baseline; got != 1 {
+		t.Errorf("Expected exactly one stored user message, got %d", got)
+	}
+}
+
+func TestRecentMessageIDsExpire(t *testing.T) {
+	recent := newRecentMessageIDs()
+	now := time.Now()
+	recent.now = func() time.Time { return now }
+
+	if _, ok := recent.claim("conv-1", "a"); !ok {
+		t.Fatal("Expected first claim to succeed")
+	}
+	if _, ok := recent.claim("conv-1", "a"); ok {
+		t.Error("Expected second claim to fail")
+	}
+	if _, ok := recent.claim("conv-2", "a"); !ok {
+		t.Error("Client message IDs are scoped to a conversation")
+	}
+
+	now = now.Add(recentMessageTTL)
+	if _, ok := recent.claim("conv-1", "a"); !ok {
+		t.Error("Expected claim to succeed after the TTL")
+	}
+}
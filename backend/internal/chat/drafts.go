@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// Message statuses. A reply generated while its conversation has drafts
+// enabled is saved as a draft: participants watch it stream and can load it,
+// but it is left out of the history later replies are built from, exports,
+// share links and forks until an editor approves it, possibly edited, or
+// discards it.
+const (
+	MessageStatusFinal = "final"
+	MessageStatusDraft = "draft"
+)
+
+var (
+	// ErrDraftNotFound is returned for a message that is not a draft of a
+	// conversation the user takes part in, including one already approved or discarded
+	ErrDraftNotFound = errors.New("draft not found")
+	// ErrEditorRequired is returned when a user below the editor role manages drafts
+	ErrEditorRequired = errors.New("only project editors can manage drafts")
+)
+
+// DraftReadyData is sent when a draft reply has finished generating
+type DraftReadyData struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Content        string `json:"content"`
+}
+
+// DraftApprovedData is sent when a draft becomes part of the conversation;
+// Message is the final message, with the edited content if it was edited
+type DraftApprovedData struct {
+	ConversationID string   `json:"conversation_id"`
+	Message        *Message `json:"message"`
+}
+
+// DraftDiscardedData is sent when a draft is deleted without being approved
+type DraftDiscardedData struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+}
+
+// SetConversationDraftsEnabled turns draft mode on or off for a conversation
+// the user takes part in within their client and returns the updated
+// conversation. Only editors of the conversation's project may change it. A
+// version other than 0 is the one the change was based on; when the
+// conversation has moved past it a *VersionConflictError is returned.
+func SetConversationDraftsEnabled(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string, enabled bool, version int64) (*Conversation, error) {
+	return updateConversation(ctx, db, userID, clientID, conversationID, "drafts_enabled", enabled, version,
+		func(conv *Conversation) error {
+			return requireEditor(ctx, db, userID, conv.ProjectID)
+		})
+}
+
+// requireEditor returns ErrEditorRequired unless the user is an editor or owner of the project
+func requireEditor(ctx context.Context, db tools.DBConnection, userID, projectID string) error {
+	role, err := tools.NewDBPermissionChecker(db).GetProjectRole(ctx, userID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to load project role: %w", err)
+	}
+	if role < tools.RoleEditor {
+		return ErrEditorRequired
+	}
+	return nil
+}
+
+// draftsEnabled reports whether replies to the conversation are saved as drafts
+func (s *chatService) draftsEnabled(ctx context.Context, conversationID string) bool {
+	var enabled bool
+	err := s.db.QueryRow(ctx, "SELECT drafts_enabled FROM conversations WHERE id = $1", conversationID).Scan(&enabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		// Saving the reply as final could put it in front of the model unreviewed
+		log.Printf("Failed to read draft mode of conversation %s, saving the reply as a draft: %v", conversationID, err)
+		return true
+	}
+	return enabled
+}
+
+// loadDraft reads a draft of a non-deleted conversation the user takes part
+// in, checking that the user is an editor of its project
+func (s *chatService) loadDraft(ctx context.Context, userID, messageID string) (*Message, error) {
+	draft := &Message{Status: MessageStatusDraft}
+	var metadataJSON, toolCallsJSON []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT m.id, m.conversation_id, m.role, m.content, m.metadata, m.tool_calls, m.created_at, c.project_id
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1 AND m.status = 'draft' AND c.deleted_at IS NULL AND `+isParticipantCondition(2),
+		messageID, userID).Scan(
+		&draft.ID, &draft.ConversationID, &draft.Role, &draft.Content,
+		&metadataJSON, &toolCallsJSON, &draft.CreatedAt, &draft.ProjectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load draft: %w", err)
+	}
+	decodeMessageJSON(draft, metadataJSON, toolCallsJSON)
+	if err := requireEditor(ctx, s.db, userID, draft.ProjectID); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// ApproveDraft makes a draft reply part of the conversation, so later replies
+// see it. content, when not nil, replaces what was generated and records the
+// user in the edited_by and edited_at metadata. The final message is broadcast
+// to the project as draft_approved and returned.
+func (s *chatService) ApproveDraft(ctx context.Context, userID, messageID string, content *string) (*Message, error) {
+	draft, err := s.loadDraft(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	draft.Metadata["approved_by"] = userID
+	draft.Metadata["approved_at"] = now
+	if content != nil {
+		draft.Content = *content
+		draft.Metadata["edited_by"] = userID
+		draft.Metadata["edited_at"] = now
+	}
+	metadataJSON, err := MarshalMetadata(draft.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Of two editors approving at once, only the first finds the draft
+	result, err := s.db.Exec(ctx,
+		"UPDATE messages SET content = $1, metadata = $2, status = 'final' WHERE id = $3 AND status = 'draft'",
+		draft.Content, metadataJSON, draft.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve draft: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrDraftNotFound
+	}
+	draft.Status = MessageStatusFinal
+
+	// The approved reply sits among messages the cache already holds
+	s.history.Invalidate(draft.ConversationID)
+	s.indexMessage(&ChatRequest{ConversationID: draft.ConversationID, ProjectID: draft.ProjectID}, draft)
+	s.hub.BroadcastToProject(draft.ProjectID, WebSocketMessage{
+		Type:      "draft_approved",
+		Data:      DraftApprovedData{ConversationID: draft.ConversationID, Message: draft},
+		Timestamp: time.Now().UnixMilli(),
+	})
+	return draft, nil
+}
+
+// DiscardDraft deletes a draft reply without it ever joining the
+// conversation, broadcasts draft_discarded to the project and returns the
+// discarded draft. The tokens spent generating it stay counted.
+func (s *chatService) DiscardDraft(ctx context.Context, userID, messageID string) (*Message, error) {
+	draft, err := s.loadDraft(ctx, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec(ctx, "DELETE FROM messages WHERE id = $1 AND status = 'draft'", draft.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discard draft: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrDraftNotFound
+	}
+
+	s.hub.BroadcastToProject(draft.ProjectID, WebSocketMessage{
+		Type:      "draft_discarded",
+		Data:      DraftDiscardedData{ConversationID: draft.ConversationID, MessageID: draft.ID},
+		Timestamp: time.Now().UnixMilli(),
+	})
+	return draft, nil
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"zlay-backend/internal/tools"
+)
+
+// tokenCountingConnection stands in for the sender's connection and its token budget
+type tokenCountingConnection struct {
+	used atomic.Int64
+}
+
+func (c *tokenCountingConnection) GetTokenUsage() (int64, int64, int64) {
+	used := c.used.Load()
+	return used, 1000000, 1000000 - used
+}
+
+func (c *tokenCountingConnection) addTokens(tokens int64) bool {
+	c.used.Add(tokens)
+	return true
+}
+
+// setupDraftsService returns a service for conv-1, owned by user-1 in their
+// project-1 with drafts enabled; user-2 takes part in it without being an editor
+func setupDraftsService(t *testing.T) (*chatService, *promptRecordingClient, *recordingHub, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	insertConversation(t, conn, "conv-1", nil)
+	for _, stmt := range []string{
+		"INSERT INTO conversation_participants (conversation_id, user_id, role) VALUES ('conv-1', 'user-2', 'participant')",
+	} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+	if _, err := SetConversationDraftsEnabled(ctx, conn, "user-1", "client-1", "conv-1", true, 0); err != nil {
+		t.Fatalf("SetConversationDraftsEnabled failed: %v", err)
+	}
+
+	client := &promptRecordingClient{tokensPerReply: 7}
+	hub := &recordingHub{connections: map[string]bool{}}
+	return NewChatService(conn, hub, client, tools.NewToolRegistry()), client, hub, conn
+}
+
+// latestReply returns the ID, content and status of the newest assistant message
+func latestReply(t *testing.T, conn tools.DBConnection) (id, content, status string) {
+	t.Helper()
+
+	if err := conn.QueryRow(context.Background(),
+		"SELECT id, content, status FROM messages WHERE role = 'assistant' ORDER BY created_at DESC LIMIT 1").
+		Scan(&id, &content, &status); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	return id, content, status
+}
+
+// lastEvent returns the last message of the type the hub recorded, or nil
+func lastEvent(hub *recordingHub, messageType string) *recordedEvent {
+	events := hub.eventsOfType(messageType)
+	if len(events) == 0 {
+		return nil
+	}
+	return &events[len(events)-1]
+}
+
+func TestDraftsNeverReachTheNextPrompt(t *testing.T) {
+	service, client, hub, conn := setupDraftsService(t)
+
+	budget := &tokenCountingConnection{}
+	if err := service.ProcessUserMessage(&ChatRequest{
+		ConversationID: "conv-1",
+		Content:        "How many orders?",
+		UserID:         "user-1",
+		ProjectID:      "project-1",
+		AddTokensFunc:  budget.addTokens,
+		Connection:     budget,
+	}); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	draftID, content, status := latestReply(t, conn)
+	if status != MessageStatusDraft || content != "Reply 1" {
+		t.Fatalf("Expected the reply saved as a draft, got %q with status %q", content, status)
+	}
+	if used, _, _ := budget.GetTokenUsage(); used != 7 {
+		t.Errorf("Expected the draft's tokens to be counted, got %d", used)
+	}
+	ready := lastEvent(hub, "draft_ready")
+	if ready == nil || ready.Data["message_id"] != draftID || ready.Data["content"] != "Reply 1" {
+		t.Errorf("Expected draft_ready for %s, got %+v", draftID, ready)
+	}
+
+	// Neither the cached history nor a fresh load holds the draft
+	for _, service := range []ChatService{service, NewChatService(conn, hub, client, tools.NewToolRegistry())} {
+		sendTurn(t, service, "conv-1", "And last week?")
+		for _, line := range client.lastPrompt() {
+			if strings.HasPrefix(line, "assistant:") {
+				t.Errorf("Expected no draft in the prompt, got %v", client.lastPrompt())
+			}
+		}
+	}
+}
+
+func TestApproveDraftWithEdits(t *testing.T) {
+	service, client, hub, conn := setupDraftsService(t)
+	ctx := context.Background()
+	sendTurn(t, service, "conv-1", "How many orders?")
+	draftID, _, _ := latestReply(t, conn)
+
+	edited := "There were 42 orders."
+	if _, err := service.ApproveDraft(ctx, "user-2", draftID, &edited); !errors.Is(err, ErrEditorRequired) {
+		t.Errorf("Expected a participant below editor to be refused, got %v", err)
+	}
+
+	approved, err := service.ApproveDraft(ctx, "user-1", draftID, &edited)
+	if err != nil {
+		t.Fatalf("ApproveDraft failed: %v", err)
+	}
+	if approved.Status != MessageStatusFinal || approved.Content != edited {
+		t.Errorf("Expected the edited final message, got %+v", approved)
+	}
+	if approved.Metadata["edited_by"] != "user-1" || approved.Metadata["edited_at"] == nil {
+		t.Errorf("Expected the edit recorded in metadata, got %v", approved.Metadata)
+	}
+	if _, content, status := latestReply(t, conn); content != edited || status != MessageStatusFinal {
+		t.Errorf("Expected the edit stored as final, got %q with status %q", content, status)
+	}
+	if event := lastEvent(hub, "draft_approved"); event == nil {
+		t.Error("Expected draft_approved to be broadcast")
+	} else if message, _ := event.Data["message"].(map[string]interface{}); message["content"] != edited {
+		t.Errorf("Expected the final message in draft_approved, got %v", event.Data)
+	}
+
+	// The approved reply joins the history the next prompt is built from
+	sendTurn(t, service, "conv-1", "And last week?")
+	want := []string{"user: hello", "user: How many orders?", "assistant: " + edited, "user: And last week?"}
+	if prompt := client.lastPrompt(); strings.Join(prompt, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the approved reply in the prompt\n%v\ngot\n%v", want, prompt)
+	}
+
+	if _, err := service.ApproveDraft(ctx, "user-1", draftID, nil); !errors.Is(err, ErrDraftNotFound) {
+		t.Errorf("Expected an approved draft not to be approved again, got %v", err)
+	}
+}
+
+func TestDiscardDraft(t *testing.T) {
+	service, client, hub, conn := setupDraftsService(t)
+	ctx := context.Background()
+	sendTurn(t, service, "conv-1", "How many orders?")
+	draftID, _, _ := latestReply(t, conn)
+
+	if _, err := service.DiscardDraft(ctx, "user-1", draftID); err != nil {
+		t.Fatalf("DiscardDraft failed: %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE id = $1", draftID); n != 0 {
+		t.Errorf("Expected the draft to be deleted, %d rows left", n)
+	}
+	if event := lastEvent(hub, "draft_discarded"); event == nil || event.Data["message_id"] != draftID {
+		t.Errorf("Expected draft_discarded for %s, got %+v", draftID, event)
+	}
+	if _, err := service.DiscardDraft(ctx, "user-1", draftID); !errors.Is(err, ErrDraftNotFound) {
+		t.Errorf("Expected a discarded draft to be gone, got %v", err)
+	}
+
+	sendTurn(t, service, "conv-1", "Try again")
+	want := []string{"user: hello", "user: How many orders?", "user: Try again"}
+	if prompt := client.lastPrompt(); strings.Join(prompt, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the discarded draft left out\n%v\ngot\n%v", want, prompt)
+	}
+}
+
+func TestSetConversationDraftsEnabledRequiresAnEditor(t *testing.T) {
+	_, _, _, conn := setupDraftsService(t)
+
+	_, err := SetConversationDraftsEnabled(context.Background(), conn, "user-2", "client-1", "conv-1", false, 0)
+	if !errors.Is(err, ErrEditorRequired) {
+		t.Errorf("Expected a participant below editor to be refused, got %v", err)
+	}
+	conv, err := SetConversationDraftsEnabled(context.Background(), conn, "user-1", "client-1", "conv-1", false, 0)
+	if err != nil {
+		t.Fatalf("SetConversationDraftsEnabled failed: %v", err)
+	}
+	if conv.DraftsEnabled || conv.Version != 3 {
+		t.Errorf("Expected drafts off at version 3, got %+v", conv)
+	}
+}
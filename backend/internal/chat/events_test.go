@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/embeddings"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+// recordedEvent is one message seen by recordingHub
+type recordedEvent struct {
+	Type   string
+	Target string // "project", "conn:<id>" or "user:<id>"
+	Data   map[string]interface{}
+}
+
+// recordingHub records every message in send order, including targeted sends
+type recordingHub struct {
+	mutex       sync.Mutex
+	events      []recordedEvent
+	connections map[string]bool
+}
+
+func (h *recordingHub) record(target string, message interface{}) {
+	raw, _ := json.Marshal(message)
+	var decoded struct {
+		Type string                 `json:"type"`
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(raw, &decoded)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events = append(h.events, recordedEvent{Type: decoded.Type, Target: target, Data: decoded.Data})
+}
+
+func (h *recordingHub) BroadcastToProject(projectID string, message interface{}) {
+	h.record("project", message)
+}
+
+func (h *recordingHub) SendToConnectionID(connectionID string, message interface{}) bool {
+	if !h.connections[connectionID] {
+		return false
+	}
+	h.record("conn:"+connectionID, message)
+	return true
+}
+
+func (h *recordingHub) SendToUser(projectID, userID string, message interface{}) int {
+	h.record("user:"+userID, message)
+	return 1
+}
+
+func (h *recordingHub) eventsOfType(types ...string) []recordedEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	wanted := make(map[string]bool)
+	for _, t := range types {
+		wanted[t] = true
+	}
+	var out []recordedEvent
+	for _, e := range h.events {
+		if wanted[e.Type] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestStreamEmitsThinkingAndFirstTokenInOrder(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{"conn-1": true}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, hub, &fakeLLMClient{delay: 20 * time.Millisecond}, tools.NewToolRegistry())
+
+	before := metrics.Latency(MetricTimeToFirstToken).Snapshot().Count
+
+	req := userMessageRequest("")
+	req.ConnectionID = "conn-1"
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	events := hub.eventsOfType("assistant_thinking", "assistant_first_token", "assistant_response")
+	if len(events) < 3 {
+		t.Fatalf("Expected thinking, first token and response events, got %+v", events)
+	}
+	if events[0].Type != "assistant_thinking" || events[1].Type != "assistant_first_token" {
+		t.Fatalf("Unexpected event order: %s, %s", events[0].Type, events[1].Type)
+	}
+	if events[len(events)-1].Type != "assistant_response" || events[len(events)-1].Data["done"] != true {
+		t.Errorf("Expected the final event to be the done response, got %+v", events[len(events)-1])
+	}
+
+	thinking, firstToken := events[0], events[1]
+	if thinking.Data["model"] != "fake" || thinking.Data["conversation_id"] != "conv-1" {
+		t.Errorf("Unexpected thinking payload: %+v", thinking.Data)
+	}
+	if _, ok := thinking.Data["queue_position"]; ok {
+		t.Error("queue_position should be omitted when the request was not queued")
+	}
+	if ttft, _ := firstToken.Data["ttft_ms"].(float64); ttft < 20 {
+		t.Errorf("Expected ttft_ms of at least 20, got %v", firstToken.Data["ttft_ms"])
+	}
+	if firstToken.Data["message_id"] != thinking.Data["message_id"] {
+		t.Error("Thinking and first token events should reference the same assistant message")
+	}
+
+	// Indicators go to the stream's connection, not the project room
+	for _, e := range []recordedEvent{thinking, firstToken} {
+		if e.Target != "conn:conn-1" {
+			t.Errorf("Expected %s to target conn-1, got %s", e.Type, e.Target)
+		}
+	}
+
+	if after := metrics.Latency(MetricTimeToFirstToken).Snapshot().Count; after != before+1 {
+		t.Errorf("Expected one time-to-first-token sample, got %d", after-before)
+	}
+}
+
+func TestStreamIndicatorsFallBackToUserConnections(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, hub, &fakeLLMClient{}, tools.NewToolRegistry())
+
+	// The originating connection has gone away
+	req := userMessageRequest("")
+	req.ConnectionID = "conn-gone"
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	for _, e := range hub.eventsOfType("assistant_thinking", "assistant_first_token") {
+		if e.Target != "user:user-1" {
+			t.Errorf("Expected %s to fall back to the user's connections, got %s", e.Type, e.Target)
+		}
+	}
+}
+
+// recordingPublisher collects webhook events
+type recordingPublisher struct {
+	mutex  sync.Mutex
+	events []webhooks.Event
+}
+
+func (p *recordingPublisher) Publish(event webhooks.Event) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.events = append(p.events, event)
+	return true
+}
+
+func TestCompletedStreamPublishesWebhookEvent(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, hub, &fakeLLMClient{}, tools.NewToolRegistry())
+	publisher := &recordingPublisher{}
+	service.SetEventPublisher(publisher)
+
+	req := userMessageRequest("")
+	req.ClientID = "client-1"
+	if err := service.WithLLMClient(&fakeLLMClient{}).ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	if len(publisher.events) != 1 {
+		t.Fatalf("Expected one event, got %+v", publisher.events)
+	}
+	event := publisher.events[0]
+	if event.Type != webhooks.EventConversationCompleted || event.ClientID != "client-1" || event.ProjectID != "project-1" ||
+		event.Data["conversation_id"] != "conv-1" || event.Data["message_id"] == "" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+// embeddingLLMClient is a fakeLLMClient whose provider can embed, but fails to
+type embeddingLLMClient struct {
+	fakeLLMClient
+	embedded chan string
+}
+
+func (c *embeddingLLMClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c.embedded <- texts[0]
+	return nil, errors.New("embeddings endpoint unavailable")
+}
+
+func (c *embeddingLLMClient) EmbeddingModel() string { return "fake-embedding" }
+
+func TestSavedAssistantMessageIsQueuedForEmbedding(t *testing.T) {
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, &fakeLLMClient{}, tools.NewToolRegistry())
+	indexer := embeddings.NewIndexer(embeddings.NewStore(conn), embeddings.Options{})
+	indexer.Start()
+	t.Cleanup(indexer.Stop)
+	service.SetEmbeddingIndexer(indexer)
+
+	client := &embeddingLLMClient{embedded: make(chan string, 1)}
+	if err := service.WithLLMClient(client).ProcessUserMessage(userMessageRequest("")); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	select {
+	case text := <-client.embedded:
+		if text != "Hello" {
+			t.Errorf("Expected the assistant reply embedded, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the assistant message to be embedded")
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'conv-1' AND role = 'assistant'"); n != 1 {
+		t.Errorf("Expected the assistant message saved despite the embedding failure, got %d", n)
+	}
+}
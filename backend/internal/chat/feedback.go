@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	zdb "zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+// MaxFeedbackCommentLength is the longest comment, in characters, accepted with a rating
+const MaxFeedbackCommentLength = 2000
+
+// Feedback grouping for FeedbackStats
+const (
+	FeedbackGroupNone = ""
+	FeedbackGroupDay  = "day"
+)
+
+var (
+	// ErrMessageNotFound is returned when a message does not exist or is not in a
+	// conversation the user can access
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrInvalidFeedback is returned for a rating other than -1 or +1, or an oversized comment
+	ErrInvalidFeedback = errors.New("invalid feedback")
+)
+
+// MessageFeedback is a user's rating of a message
+type MessageFeedback struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	UserID         string    `json:"user_id"`
+	Rating         int       `json:"rating"` // -1 or +1
+	Comment        string    `json:"comment,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// FeedbackCounts aggregates ratings; Ratio is the share of positive ratings
+type FeedbackCounts struct {
+	Positive int     `json:"positive"`
+	Negative int     `json:"negative"`
+	Total    int     `json:"total"`
+	Ratio    float64 `json:"ratio"`
+}
+
+// FeedbackBucket holds the counts for one period, e.g. a day as 2006-01-02
+type FeedbackBucket struct {
+	Period string `json:"period"`
+	FeedbackCounts
+}
+
+// FeedbackSummary is the feedback of a client over a time range
+type FeedbackSummary struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	GroupBy string           `json:"group_by,omitempty"`
+	Totals  FeedbackCounts   `json:"totals"`
+	Buckets []FeedbackBucket `json:"buckets,omitempty"`
+}
+
+func (c *FeedbackCounts) add(rating int) {
+	if rating > 0 {
+		c.Positive++
+	} else {
+		c.Negative++
+	}
+	c.Total++
+	c.Ratio = float64(c.Positive) / float64(c.Total)
+}
+
+// SaveMessageFeedback records a user's rating of a message, replacing any earlier
+// rating by the same user. The message must be in one of the user's own,
+// non-deleted conversations within their client.
+func SaveMessageFeedback(ctx context.Context, db tools.DBConnection, userID, clientID, messageID string, rating int, comment string) (*MessageFeedback, error) {
+	if rating != 1 && rating != -1 {
+		return nil, fmt.Errorf("%w: rating must be -1 or 1", ErrInvalidFeedback)
+	}
+	comment = strings.TrimSpace(comment)
+	if utf8.RuneCountInString(comment) > MaxFeedbackCommentLength {
+		return nil, fmt.Errorf("%w: comment exceeds %d characters", ErrInvalidFeedback, MaxFeedbackCommentLength)
+	}
+
+	feedback := &MessageFeedback{MessageID: messageID, UserID: userID, Rating: rating, Comment: comment}
+	err := db.QueryRow(ctx,
+		`SELECT m.conversation_id, c.project_id
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN users u ON u.id = c.user_id
+		WHERE m.id = $1 AND c.user_id = $2 AND u.client_id = $3 AND c.deleted_at IS NULL`,
+		messageID, userID, clientID).Scan(&feedback.ConversationID, &feedback.ProjectID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up message: %w", err)
+	}
+
+	// Update the existing rating, or insert one; a concurrent first rating
+	// makes the insert fail and is updated instead
+	now := time.Now().UTC()
+	feedback.UpdatedAt = now
+	updated, err := updateFeedback(ctx, db, feedback)
+	if err == nil && !updated {
+		feedback.CreatedAt = now
+		_, err = db.Exec(ctx,
+			`INSERT INTO message_feedback (message_id, conversation_id, user_id, rating, comment, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+			messageID, feedback.ConversationID, userID, rating, nullableString(comment), now)
+		if zdb.IsUniqueViolation(err) {
+			_, err = updateFeedback(ctx, db, feedback)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	return feedback, nil
+}
+
+// updateFeedback changes an existing rating and loads its created_at; it
+// reports false when the user has not rated the message yet
+func updateFeedback(ctx context.Context, db tools.DBConnection, feedback *MessageFeedback) (bool, error) {
+	result, err := db.Exec(ctx,
+		"UPDATE message_feedback SET rating = $1, comment = $2, updated_at = $3 WHERE message_id = $4 AND user_id = $5",
+		feedback.Rating, nullableString(feedback.Comment), feedback.UpdatedAt, feedback.MessageID, feedback.UserID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	err = db.QueryRow(ctx,
+		"SELECT created_at FROM message_feedback WHERE message_id = $1 AND user_id = $2",
+		feedback.MessageID, feedback.UserID).Scan(&feedback.CreatedAt)
+	return err == nil, err
+}
+
+// ConversationFeedback returns every rating in a conversation keyed by message ID
+func ConversationFeedback(ctx context.Context, db tools.DBConnection, conversationID string) (map[string][]MessageFeedback, error) {
+	rows, err := db.Query(ctx,
+		`SELECT message_id, user_id, rating, comment, created_at, updated_at
+		FROM message_feedback WHERE conversation_id = $1 ORDER BY updated_at ASC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feedback: %w", err)
+	}
+	defer rows.Close()
+
+	feedback := make(map[string][]MessageFeedback)
+	for rows.Next() {
+		f := MessageFeedback{ConversationID: conversationID}
+		var comment sql.NullString
+		if err := rows.Scan(&f.MessageID, &f.UserID, &f.Rating, &comment, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan feedback: %w", err)
+		}
+		f.Comment = comment.String
+		feedback[f.MessageID] = append(feedback[f.MessageID], f)
+	}
+	return feedback, rows.Err()
+}
+
+// FeedbackStats counts a client's ratings last changed in [from, to). With
+// FeedbackGroupDay the counts are also split per UTC day, oldest first.
+// Ratings are bucketed here rather than in SQL so the query stays portable.
+func FeedbackStats(ctx context.Context, db tools.DBConnection, clientID string, from, to time.Time, groupBy string) (*FeedbackSummary, error) {
+	if groupBy != FeedbackGroupNone && groupBy != FeedbackGroupDay {
+		return nil, fmt.Errorf("unsupported group_by: %s", groupBy)
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT f.rating, f.updated_at
+		FROM message_feedback f
+		JOIN users u ON u.id = f.user_id
+		WHERE u.client_id = $1 AND f.updated_at >= $2 AND f.updated_at < $3
+		ORDER BY f.updated_at ASC`,
+		clientID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feedback: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &FeedbackSummary{From: from, To: to, GroupBy: groupBy}
+	for rows.Next() {
+		var rating int
+		var updatedAt time.Time
+		if err := rows.Scan(&rating, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan feedback: %w", err)
+		}
+		summary.Totals.add(rating)
+
+		if groupBy == FeedbackGroupDay {
+			period := updatedAt.UTC().Format("2006-01-02")
+			if n := len(summary.Buckets); n == 0 || summary.Buckets[n-1].Period != period {
+				summary.Buckets = append(summary.Buckets, FeedbackBucket{Period: period})
+			}
+			summary.Buckets[len(summary.Buckets)-1].add(rating)
+		}
+	}
+	return summary, rows.Err()
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
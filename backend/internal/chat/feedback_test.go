@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+func setupFeedbackDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"INSERT INTO clients (id, name, slug) VALUES ('client-2', 'client-2', 'client-2')",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-3', 'client-2', 'user-3', 'x')",
+	} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+	insertConversation(t, conn, "conv-1", nil)
+	insertConversation(t, conn, "deleted", time.Now().UTC())
+	return conn
+}
+
+func TestSaveMessageFeedbackUpserts(t *testing.T) {
+	conn := setupFeedbackDB(t)
+	ctx := context.Background()
+
+	first, err := SaveMessageFeedback(ctx, conn, "user-1", "client-1", "conv-1-m1", 1, "  helpful ")
+	if err != nil {
+		t.Fatalf("SaveMessageFeedback failed: %v", err)
+	}
+	if first.ConversationID != "conv-1" || first.ProjectID != "project-1" || first.Comment != "helpful" {
+		t.Errorf("Unexpected feedback: %+v", first)
+	}
+
+	// Changing the rating replaces the row and keeps its creation time
+	second, err := SaveMessageFeedback(ctx, conn, "user-1", "client-1", "conv-1-m1", -1, "")
+	if err != nil {
+		t.Fatalf("Second SaveMessageFeedback failed: %v", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) || second.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("Expected created_at to be kept, got %v then %v", first.CreatedAt, second.CreatedAt)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM message_feedback"); n != 1 {
+		t.Fatalf("Expected one feedback row, got %d", n)
+	}
+
+	feedback, err := ConversationFeedback(ctx, conn, "conv-1")
+	if err != nil {
+		t.Fatalf("ConversationFeedback failed: %v", err)
+	}
+	got := feedback["conv-1-m1"]
+	if len(got) != 1 || got[0].Rating != -1 || got[0].Comment != "" {
+		t.Errorf("Expected the updated rating without a comment, got %+v", got)
+	}
+}
+
+func TestSaveMessageFeedbackAuthorization(t *testing.T) {
+	conn := setupFeedbackDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name                      string
+		userID, clientID, message string
+		rating                    int
+		want                      error
+	}{
+		{"other user in the same client", "user-2", "client-1", "conv-1-m1", 1, ErrMessageNotFound},
+		{"user of another client", "user-3", "client-2", "conv-1-m1", 1, ErrMessageNotFound},
+		{"owner claiming another client", "user-1", "client-2", "conv-1-m1", 1, ErrMessageNotFound},
+		{"deleted conversation", "user-1", "client-1", "deleted-m1", 1, ErrMessageNotFound},
+		{"unknown message", "user-1", "client-1", "missing", 1, ErrMessageNotFound},
+		{"zero rating", "user-1", "client-1", "conv-1-m1", 0, ErrInvalidFeedback},
+		{"out of range rating", "user-1", "client-1", "conv-1-m1", 5, ErrInvalidFeedback},
+	}
+	for _, tt := range tests {
+		if _, err := SaveMessageFeedback(ctx, conn, tt.userID, tt.clientID, tt.message, tt.rating, ""); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM message_feedback"); n != 0 {
+		t.Errorf("Expected no feedback to be stored, got %d rows", n)
+	}
+}
+
+func TestFeedbackStatsGroupsByDayPerClient(t *testing.T) {
+	conn := setupFeedbackDB(t)
+	ctx := context.Background()
+
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, f := range []struct {
+		message, user string
+		rating        int
+		at            time.Time
+	}{
+		{"a", "user-1", 1, day},
+		{"b", "user-1", -1, day.Add(time.Hour)},
+		{"c", "user-2", 1, day.Add(24 * time.Hour)},
+		{"d", "user-3", -1, day}, // another client
+	} {
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO messages (id, conversation_id, role, content) VALUES ($1, 'conv-1', 'assistant', 'reply')", f.message); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO message_feedback (message_id, conversation_id, user_id, rating, created_at, updated_at) VALUES ($1, 'conv-1', $2, $3, $4, $4)",
+			f.message, f.user, f.rating, f.at); err != nil {
+			t.Fatalf("Failed to insert feedback: %v", err)
+		}
+	}
+
+	summary, err := FeedbackStats(ctx, conn, "client-1", day.Add(-time.Hour), day.Add(48*time.Hour), FeedbackGroupDay)
+	if err != nil {
+		t.Fatalf("FeedbackStats failed: %v", err)
+	}
+	if summary.Totals.Total != 3 || summary.Totals.Positive != 2 || summary.Totals.Negative != 1 {
+		t.Errorf("Unexpected totals: %+v", summary.Totals)
+	}
+	if len(summary.Buckets) != 2 || summary.Buckets[0].Period != "2024-05-01" || summary.Buckets[0].Ratio != 0.5 ||
+		summary.Buckets[1].Period != "2024-05-02" || summary.Buckets[1].Ratio != 1 {
+		t.Errorf("Unexpected buckets: %+v", summary.Buckets)
+	}
+
+	if _, err := FeedbackStats(ctx, conn, "client-1", day, day, "month"); err == nil {
+		t.Error("Expected an error for an unsupported group_by")
+	}
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultStreamFlushChars is how many characters accumulate before a partial send
+	DefaultStreamFlushChars = 200
+	// DefaultStreamFlushInterval is how long content waits before a partial send
+	DefaultStreamFlushInterval = 250 * time.Millisecond
+)
+
+// StreamFlushPolicy decides when streamed content is sent as an
+// assistant_response frame: once Chars characters have accumulated since the
+// last frame or Interval has passed since it, whichever comes first. Zero
+// values fall back to the service's policy.
+type StreamFlushPolicy struct {
+	Chars    int
+	Interval time.Duration
+}
+
+// withDefaults fills unset fields from fallback
+func (p StreamFlushPolicy) withDefaults(fallback StreamFlushPolicy) StreamFlushPolicy {
+	if p.Chars <= 0 {
+		p.Chars = fallback.Chars
+	}
+	if p.Interval <= 0 {
+		p.Interval = fallback.Interval
+	}
+	return p
+}
+
+// streamFlusher applies a StreamFlushPolicy to the chunks of one stream. The
+// interval is checked when a chunk arrives rather than with a timer, so content
+// waits for the next chunk, or the final one, after a pause.
+type streamFlusher struct {
+	policy    StreamFlushPolicy
+	now       func() time.Time
+	pending   int // characters received since the last frame
+	lastFlush time.Time
+	flushed   bool // whether a frame with content has been sent
+}
+
+func newStreamFlusher(policy StreamFlushPolicy, now func() time.Time) *streamFlusher {
+	return &streamFlusher{policy: policy, now: now}
+}
+
+// add records a chunk's content and reports whether a frame should be sent.
+// The first content and the final chunk are always sent.
+func (f *streamFlusher) add(content string, done bool) bool {
+	f.pending += utf8.RuneCountInString(content)
+	now := f.now()
+
+	flush := done ||
+		(!f.flushed && f.pending > 0) ||
+		f.pending >= f.policy.Chars ||
+		(f.pending > 0 && now.Sub(f.lastFlush) >= f.policy.Interval)
+	if !flush {
+		return false
+	}
+	if f.pending > 0 {
+		f.flushed = true
+	}
+	f.pending = 0
+	f.lastFlush = now
+	return true
+}
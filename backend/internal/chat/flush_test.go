@@ -0,0 +1,137 @@
+package chat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// flushStep is a chunk arriving after a pause
+type flushStep struct {
+	after   time.Duration
+	content string
+	done    bool
+}
+
+// flushFrames feeds steps through a flusher on a fake clock and returns the
+// content of each frame it would send
+func flushFrames(policy StreamFlushPolicy, steps []flushStep) []string {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	flusher := newStreamFlusher(policy, func() time.Time { return now })
+
+	var frames []string
+	var pending strings.Builder
+	for _, step := range steps {
+		now = now.Add(step.after)
+		pending.WriteString(step.content)
+		if flusher.add(step.content, step.done) {
+			frames = append(frames, pending.String())
+			pending.Reset()
+		}
+	}
+	return frames
+}
+
+func TestStreamFlusherFrameBoundaries(t *testing.T) {
+	policy := StreamFlushPolicy{Chars: 10, Interval: 250 * time.Millisecond}
+	ms := time.Millisecond
+
+	tests := []struct {
+		name  string
+		steps []flushStep
+		want  []string
+	}{
+		{
+			name: "fast model flushes on size",
+			steps: []flushStep{
+				{ms, "He", false}, {ms, "llo ", false}, {ms, "wor", false}, {ms, "ld, ", false},
+				{ms, "how ", false}, {ms, "are", false}, {ms, " you", false}, {0, "", true},
+			},
+			want: []string{"He", "llo world, ", "how are you", ""},
+		},
+		{
+			name: "slow model flushes on time",
+			steps: []flushStep{
+				{ms, "A", false}, {100 * ms, "b", false}, {100 * ms, "c", false}, {100 * ms, "d", false},
+				{100 * ms, "e", false}, {100 * ms, "f", false}, {0, "", true},
+			},
+			want: []string{"A", "bcd", "ef"},
+		},
+		{
+			name: "chunk larger than the size is sent whole",
+			steps: []flushStep{
+				{ms, "x", false}, {ms, strings.Repeat("y", 25), false}, {ms, "z", false}, {ms, "", true},
+			},
+			want: []string{"x", strings.Repeat("y", 25), "z"},
+		},
+		{
+			name: "size counts characters, not bytes",
+			steps: []flushStep{
+				{ms, "é", false}, {ms, "ééééé", false}, {ms, "éééé", false}, {ms, "é", false}, {ms, "", true},
+			},
+			want: []string{"é", strings.Repeat("é", 10), ""},
+		},
+		{
+			name:  "chunks without content do not flush before the first content",
+			steps: []flushStep{{time.Second, "", false}, {time.Second, "", false}, {ms, "Hi", false}, {ms, "", true}},
+			want:  []string{"Hi", ""},
+		},
+		{
+			name:  "stream without content still gets a final frame",
+			steps: []flushStep{{ms, "", false}, {ms, "", true}},
+			want:  []string{""},
+		},
+		{
+			name:  "pause without new content sends nothing",
+			steps: []flushStep{{ms, "Hi", false}, {time.Second, "", false}, {time.Second, "!", false}, {0, "", true}},
+			want:  []string{"Hi", "!", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := flushFrames(policy, tt.steps); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: frames %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStreamFlushPolicyDefaults(t *testing.T) {
+	server := StreamOptions{}.withDefaults().Flush
+	if server.Chars != DefaultStreamFlushChars || server.Interval != DefaultStreamFlushInterval {
+		t.Errorf("Unexpected server defaults: %+v", server)
+	}
+
+	// A client setting only its size keeps the server's interval
+	client := StreamFlushPolicy{Chars: 50}.withDefaults(server)
+	if client.Chars != 50 || client.Interval != DefaultStreamFlushInterval {
+		t.Errorf("Unexpected client policy: %+v", client)
+	}
+}
+
+func TestStreamUsesClientFlushPolicy(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	client := &scriptedLLMClient{chunks: []string{"one ", "two ", "three ", "four"}}
+	service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	req := userMessageRequest("")
+	req.FlushPolicy = StreamFlushPolicy{Chars: 8}
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	var deltas []string
+	for _, frame := range hub.eventsOfType("assistant_response") {
+		deltas = append(deltas, frame.Data["delta"].(string))
+	}
+	// The last frame is the completion message, which carries no delta
+	if want := []string{"one ", "two three ", "four", ""}; !reflect.DeepEqual(deltas, want) {
+		t.Errorf("Expected frames %q, got %q", want, deltas)
+	}
+}
@@ -0,0 +1,257 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// DefaultMaxForkMessages is how many messages a fork may copy by default
+const DefaultMaxForkMessages = 1000
+
+// ForkTooLargeError is returned when a fork would copy more than its message limit
+type ForkTooLargeError struct {
+	Limit int
+}
+
+func (e *ForkTooLargeError) Error() string {
+	return fmt.Sprintf("a fork can copy at most %d messages", e.Limit)
+}
+
+// ForkErrorCode maps a ForkConversation or ListForks error to its apierror code and details
+func ForkErrorCode(err error) (string, map[string]interface{}) {
+	var tooLarge *ForkTooLargeError
+	switch {
+	case errors.Is(err, ErrConversationNotFound):
+		return apierror.CodeConversationNotFound, nil
+	case errors.Is(err, ErrMessageNotFound):
+		return apierror.CodeMessageNotFound, nil
+	case errors.As(err, &tooLarge):
+		return apierror.CodeForkTooLarge, map[string]interface{}{"limit": tooLarge.Limit}
+	default:
+		return apierror.CodeDatabaseError, nil
+	}
+}
+
+// ForkConversation copies a non-deleted conversation the user takes part in,
+// up to and including atMessageID, into a new conversation owned by the user
+// in the same project. Copies get new IDs and keep their content, tool_calls,
+// tool executions and timestamps; their metadata records the message they
+// were copied from under "forked_from". Drafts are not copied. Forks copying more than limit messages are refused with
+// a *ForkTooLargeError; limit <= 0 uses DefaultMaxForkMessages.
+func ForkConversation(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID, atMessageID string, limit int) (fork *Conversation, err error) {
+	if limit <= 0 {
+		limit = DefaultMaxForkMessages
+	}
+	// Result files copied for a fork that is not committed are removed
+	var copiedFiles []string
+	defer func() {
+		if err != nil {
+			for _, path := range copiedFiles {
+				removeToolResult(path)
+			}
+		}
+	}()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var projectID, title string
+	var model, language sql.NullString
+	var temperature sql.NullFloat64
+	var maxTokens sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT c.project_id, c.title, c.model, c.temperature, c.max_tokens, c.language
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.deleted_at IS NULL AND `+isParticipantCondition(3),
+		conversationID, clientID, userID).Scan(&projectID, &title, &model, &temperature, &maxTokens, &language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+
+	var exists int
+	err = tx.QueryRowContext(ctx,
+		"SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2 AND status <> 'draft'",
+		atMessageID, conversationID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up message: %w", err)
+	}
+
+	copies, err := readForkMessages(ctx, tx, conversationID, atMessageID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation := NewConversation(projectID, userID, title, "completed")
+	conversation.ForkedFromConversationID = &conversationID
+	if language.Valid {
+		conversation.Language = &language.String
+	}
+	settings := settingsFromColumns(model, temperature, maxTokens)
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO conversations (id, project_id, user_id, title, status, model, temperature, max_tokens,
+			forked_from_conversation_id, language, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		conversation.ID, conversation.ProjectID, conversation.UserID, conversation.Title, conversation.Status,
+		settings.Model, settings.Temperature, settings.MaxTokens, conversationID, conversation.Language,
+		conversation.CreatedAt, conversation.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create fork: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO conversation_participants (conversation_id, user_id, role, added_at)
+		VALUES ($1, $2, $3, $4)`,
+		conversation.ID, userID, ParticipantRoleOwner, conversation.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to add conversation owner: %w", err)
+	}
+
+	for _, msg := range copies {
+		metadata, _, err := UnmarshalMetadata(msg.metadata)
+		if err != nil {
+			log.Printf("Message %s: %v", msg.id, err)
+		}
+		metadata["forked_from"] = map[string]interface{}{
+			"conversation_id": conversationID,
+			"message_id":      msg.id,
+		}
+		metadataJSON, err := MarshalMetadata(metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		// tool_calls are copied byte for byte so results and reruns survive as stored
+		copyID := uuid.New().String()
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at, user_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			copyID, conversation.ID, msg.role, msg.content,
+			metadataJSON, msg.toolCalls, msg.createdAt, msg.senderID); err != nil {
+			return nil, fmt.Errorf("failed to copy message: %w", err)
+		}
+		if len(msg.toolCalls) > 0 {
+			written, err := copyToolExecutions(ctx, tx, msg.id, copyID, conversation.ID)
+			copiedFiles = append(copiedFiles, written...)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit fork: %w", err)
+	}
+
+	conversation.Model, conversation.Temperature, conversation.MaxTokens = settings.Model, settings.Temperature, settings.MaxTokens
+	for _, msg := range copies {
+		if msg.role == "user" || msg.role == "assistant" {
+			conversation.MessageCount++
+			if preview := []rune(msg.content); len(preview) > 0 {
+				if len(preview) > 120 {
+					preview = preview[:120]
+				}
+				conversation.LastMessagePreview = string(preview)
+			}
+		}
+	}
+	return conversation, nil
+}
+
+// forkMessage is a message row as read for copying
+type forkMessage struct {
+	id        string
+	role      string
+	content   string
+	metadata  []byte
+	toolCalls []byte
+	createdAt time.Time
+	senderID  sql.NullString
+}
+
+// readForkMessages reads the messages of a conversation up to and including
+// atMessageID, in order. It stops reading once more than limit have been seen.
+func readForkMessages(ctx context.Context, tx *sql.Tx, conversationID, atMessageID string, limit int) ([]forkMessage, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, role, content, metadata, tool_calls, created_at, user_id
+		FROM messages WHERE conversation_id = $1 AND status <> 'draft'
+		ORDER BY created_at ASC, id ASC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	defer rows.Close()
+
+	var copies []forkMessage
+	for rows.Next() {
+		if len(copies) == limit {
+			return nil, &ForkTooLargeError{Limit: limit}
+		}
+		var msg forkMessage
+		if err := rows.Scan(&msg.id, &msg.role, &msg.content, &msg.metadata, &msg.toolCalls, &msg.createdAt, &msg.senderID); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		copies = append(copies, msg)
+		if msg.id == atMessageID {
+			return copies, rows.Close()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return nil, ErrMessageNotFound
+}
+
+// ListForks returns the forks of a non-deleted conversation the user takes
+// part in, newest first. Only forks the user also takes part in are listed.
+func ListForks(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) ([]*Conversation, error) {
+	var exists int
+	err := db.QueryRow(ctx,
+		`SELECT 1 FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.deleted_at IS NULL AND `+isParticipantCondition(3),
+		conversationID, clientID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT `+conversationColumns("c.")+`
+		FROM conversations c
+		WHERE c.forked_from_conversation_id = $1 AND c.deleted_at IS NULL AND `+isParticipantCondition(2)+`
+		ORDER BY c.created_at DESC, c.id DESC`,
+		conversationID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list forks: %w", err)
+	}
+	defer rows.Close()
+
+	forks := []*Conversation{}
+	for rows.Next() {
+		fork, err := scanConversation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fork: %w", err)
+		}
+		fork.ForkedFromConversationID = &conversationID
+		forks = append(forks, fork)
+	}
+	return forks, rows.Err()
+}
@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+const forkToolCalls = `[{"id":"call-1","type":"function","function":{"name":"database_query","arguments":{"query":"SELECT 1"}},"status":"completed","result":{"rows":[[1]]},"reruns":[{"status":"completed","duration_ms":12}]}]`
+
+// insertForkConversation adds a conversation of user-1 with n messages a
+// second apart, alternating user and assistant; the first reply carries tool calls
+func insertForkConversation(t *testing.T, conn tools.DBConnection, id string, n int) {
+	t.Helper()
+
+	ctx := context.Background()
+	base := time.Now().UTC().Add(-time.Hour)
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status, model, created_at, updated_at) VALUES ($1, 'Original', 'user-1', 'project-1', 'completed', 'gpt-4o', $2, $2)",
+		id, base); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		role, toolCalls, senderID := "user", "[]", interface{}("user-1")
+		if i%2 == 1 {
+			role, senderID = "assistant", nil
+			if i == 1 {
+				toolCalls = forkToolCalls
+			}
+		}
+		if _, err := conn.Exec(ctx,
+			`INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at, user_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			fmt.Sprintf("%s-m%d", id, i), id, role, fmt.Sprintf("message %d", i),
+			`{"model":"gpt-4o"}`, toolCalls, base.Add(time.Duration(i)*time.Second), senderID); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+}
+
+func TestForkConversationCopiesUpToMessage(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	insertForkConversation(t, conn, "conv-1", 6)
+	ctx := context.Background()
+
+	fork, err := ForkConversation(ctx, conn, "user-1", "client-1", "conv-1", "conv-1-m3", 0)
+	if err != nil {
+		t.Fatalf("ForkConversation failed: %v", err)
+	}
+	if fork.ID == "conv-1" || fork.ProjectID != "project-1" || fork.UserID != "user-1" || fork.Title != "Original" {
+		t.Errorf("Unexpected fork %+v", fork)
+	}
+	if fork.ForkedFromConversationID == nil || *fork.ForkedFromConversationID != "conv-1" {
+		t.Errorf("Expected the fork to point at conv-1, got %v", fork.ForkedFromConversationID)
+	}
+	if fork.Model == nil || *fork.Model != "gpt-4o" || fork.MessageCount != 4 || fork.LastMessagePreview != "message 3" {
+		t.Errorf("Expected the fork to keep the model and summarize 4 messages, got %+v", fork)
+	}
+
+	rows, err := conn.Query(ctx,
+		"SELECT id, conversation_id, role, content, metadata, tool_calls, created_at, user_id, status FROM messages WHERE conversation_id = $1 ORDER BY created_at ASC, id ASC",
+		fork.ID)
+	if err != nil {
+		t.Fatalf("Failed to read fork: %v", err)
+	}
+	defer rows.Close()
+	messages, _, err := scanMessages(rows)
+	if err != nil {
+		t.Fatalf("Failed to scan fork: %v", err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 copied messages, got %d", len(messages))
+	}
+	for i, msg := range messages {
+		sourceID := fmt.Sprintf("conv-1-m%d", i)
+		if msg.ID == sourceID || msg.Content != fmt.Sprintf("message %d", i) {
+			t.Errorf("Message %d: expected a new ID and the original content, got %s %q", i, msg.ID, msg.Content)
+		}
+		forkedFrom, _ := msg.Metadata["forked_from"].(map[string]interface{})
+		if forkedFrom["conversation_id"] != "conv-1" || forkedFrom["message_id"] != sourceID || msg.Metadata["model"] != "gpt-4o" {
+			t.Errorf("Message %d: expected metadata to keep model and note %s, got %v", i, sourceID, msg.Metadata)
+		}
+	}
+	if messages[0].UserID != "user-1" || messages[1].UserID != "" {
+		t.Errorf("Expected senders to be kept, got %q and %q", messages[0].UserID, messages[1].UserID)
+	}
+
+	// tool_calls are copied as stored, results and reruns included
+	var copied string
+	if err := conn.QueryRow(ctx, "SELECT tool_calls FROM messages WHERE id = $1", messages[1].ID).Scan(&copied); err != nil {
+		t.Fatalf("Failed to read tool calls: %v", err)
+	}
+	if copied != forkToolCalls {
+		t.Errorf("Expected tool_calls to copy intact, got %s", copied)
+	}
+	if len(messages[1].ToolCalls) != 1 || len(messages[1].ToolCalls[0].Reruns) != 1 || messages[1].ToolCalls[0].Result["rows"] == nil {
+		t.Errorf("Expected the copied tool call to decode with its result and rerun, got %+v", messages[1].ToolCalls)
+	}
+
+	// The original is untouched and lists the fork
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'conv-1'"); n != 6 {
+		t.Errorf("Expected the original to keep 6 messages, got %d", n)
+	}
+	forks, err := ListForks(ctx, conn, "user-1", "client-1", "conv-1")
+	if err != nil || len(forks) != 1 || forks[0].ID != fork.ID {
+		t.Fatalf("Expected conv-1 to list the fork, got %v (%v)", forks, err)
+	}
+
+	service := &chatService{db: conn}
+	conversations, err := service.GetConversations("user-1", "project-1")
+	if err != nil {
+		t.Fatalf("GetConversations failed: %v", err)
+	}
+	for _, conv := range conversations {
+		isFork := conv.ForkedFromConversationID != nil
+		if isFork != (conv.ID == fork.ID) {
+			t.Errorf("%s: unexpected fork indicator %v", conv.ID, conv.ForkedFromConversationID)
+		}
+	}
+}
+
+func TestForkConversationPermissions(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	insertForkConversation(t, conn, "conv-1", 4)
+	insertForkConversation(t, conn, "conv-2", 2)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "UPDATE conversations SET deleted_at = $1 WHERE id = 'conv-2'", time.Now().UTC()); err != nil {
+		t.Fatalf("Failed to delete conversation: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		clientID       string
+		conversationID string
+		atMessageID    string
+		want           error
+	}{
+		{"teammate who is not a participant", "user-2", "client-1", "conv-1", "conv-1-m1", ErrConversationNotFound},
+		{"user of another client", "user-3", "client-2", "conv-1", "conv-1-m1", ErrConversationNotFound},
+		{"owner claiming another client", "user-1", "client-2", "conv-1", "conv-1-m1", ErrConversationNotFound},
+		{"deleted conversation", "user-1", "client-1", "conv-2", "conv-2-m1", ErrConversationNotFound},
+		{"message of another conversation", "user-1", "client-1", "conv-1", "conv-2-m1", ErrMessageNotFound},
+		{"unknown message", "user-1", "client-1", "conv-1", "missing", ErrMessageNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := ForkConversation(ctx, conn, tt.userID, tt.clientID, tt.conversationID, tt.atMessageID, 0); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if _, err := ListForks(ctx, conn, "user-2", "client-1", "conv-1"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected a non-participant not to list forks, got %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations"); n != 2 {
+		t.Errorf("Expected refused forks to create nothing, got %d conversations", n)
+	}
+
+	// A participant forks into a conversation of their own; the owner does not see it
+	if _, err := AddParticipant(ctx, conn, "user-1", "client-1", "conv-1", "user-2"); err != nil {
+		t.Fatalf("AddParticipant failed: %v", err)
+	}
+	fork, err := ForkConversation(ctx, conn, "user-2", "client-1", "conv-1", "conv-1-m1", 0)
+	if err != nil {
+		t.Fatalf("Participant fork failed: %v", err)
+	}
+	if fork.UserID != "user-2" {
+		t.Errorf("Expected the fork to be owned by user-2, got %s", fork.UserID)
+	}
+	if ok, _ := IsParticipant(ctx, conn, fork.ID, "user-1"); ok {
+		t.Error("Expected the original owner not to take part in the fork")
+	}
+	if forks, err := ListForks(ctx, conn, "user-1", "client-1", "conv-1"); err != nil || len(forks) != 0 {
+		t.Errorf("Expected the owner to see no forks, got %v (%v)", forks, err)
+	}
+	if forks, err := ListForks(ctx, conn, "user-2", "client-1", "conv-1"); err != nil || len(forks) != 1 {
+		t.Errorf("Expected user-2 to see their fork, got %v (%v)", forks, err)
+	}
+}
+
+func TestForkConversationEnforcesLimit(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	insertForkConversation(t, conn, "conv-1", 6)
+	ctx := context.Background()
+
+	_, err := ForkConversation(ctx, conn, "user-1", "client-1", "conv-1", "conv-1-m4", 4)
+	var tooLarge *ForkTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 4 {
+		t.Fatalf("Expected ForkTooLargeError with limit 4, got %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations"); n != 1 {
+		t.Errorf("Expected no fork to be created, got %d conversations", n)
+	}
+
+	// Forking at a message within the limit copies only what comes before it
+	if _, err := ForkConversation(ctx, conn, "user-1", "client-1", "conv-1", "conv-1-m3", 4); err != nil {
+		t.Errorf("Expected a fork of exactly the limit to succeed, got %v", err)
+	}
+}
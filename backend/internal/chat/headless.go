@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultHeadlessGrace is how long a reply keeps generating after its request
+// ended while no connection receives it
+const DefaultHeadlessGrace = 2 * time.Minute
+
+// persistTimeout bounds the saves made after a reply ends, which run on their
+// own context so a cancelled request does not lose them
+const persistTimeout = 5 * time.Second
+
+// ErrStreamAbandoned is the cause of a reply cancelled because no connection
+// received it for the headless grace period
+var ErrStreamAbandoned = errors.New("no connection received the reply")
+
+// ErrServiceStopped is the cause of a reply cancelled because the server is shutting down
+var ErrServiceStopped = errors.New("chat service stopped")
+
+// Stop cancels every reply still generating; call once when the server shuts down
+func (s *chatService) Stop() {
+	s.stop(ErrServiceStopped)
+}
+
+// headlessContext returns the context a reply is generated on. It is not
+// cancelled with parent: once parent is done, the reply keeps running while a
+// connection is attached to the stream, so a page refresh that resumes it does
+// not stop generation, and is cancelled with ErrStreamAbandoned once it has had
+// no connection for the headless grace period. Stop cancels it at once.
+func (s *chatService) headlessContext(parent context.Context, streamState *StreamState) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	stopWatching := context.AfterFunc(s.lifetime, func() {
+		cancel(context.Cause(s.lifetime))
+	})
+	grace := s.streamOptions.HeadlessGrace
+
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		for {
+			attached, changed := streamState.attachment()
+			var expired <-chan time.Time
+			var timer *time.Timer
+			if attached == 0 {
+				timer = time.NewTimer(grace)
+				expired = timer.C
+			}
+			select {
+			case <-changed:
+				if timer != nil {
+					timer.Stop()
+				}
+			case <-expired:
+				cancel(ErrStreamAbandoned)
+				return
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	}()
+
+	return ctx, func() {
+		stopWatching()
+		cancel(context.Canceled)
+	}
+}
+
+// persistContext returns a short context for saving what a reply produced,
+// independent of whether the reply's own context was cancelled
+func persistContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), persistTimeout)
+}
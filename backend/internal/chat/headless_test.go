@@ -0,0 +1,233 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// waitingLLMClient streams "Hello", then waits for finish before streaming
+// " world", or returns when its context is cancelled. onDone runs right before
+// the final chunk.
+type waitingLLMClient struct {
+	scriptedLLMClient
+	streaming chan struct{}
+	finish    chan struct{}
+	onDone    func()
+}
+
+func newWaitingLLMClient() *waitingLLMClient {
+	return &waitingLLMClient{streaming: make(chan struct{}), finish: make(chan struct{})}
+}
+
+func (f *waitingLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	if err := callback(&llm.StreamingChunk{Content: "Hello"}); err != nil {
+		return err
+	}
+	close(f.streaming)
+	select {
+	case <-f.finish:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := callback(&llm.StreamingChunk{Content: " world"}); err != nil {
+		return err
+	}
+	if f.onDone != nil {
+		f.onDone()
+	}
+	return callback(&llm.StreamingChunk{Done: true})
+}
+
+func setupHeadlessService(t *testing.T, client llm.LLMClient, grace time.Duration) (*chatService, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, client, tools.NewToolRegistry())
+	service.SetStreamOptions(StreamOptions{HeadlessGrace: grace})
+	return service, conn
+}
+
+// processInBackground runs ProcessUserMessage with ctx and returns its result channel
+func processInBackground(service *chatService, ctx context.Context, connectionID string) <-chan error {
+	req := userMessageRequest("")
+	req.Context = ctx
+	req.ConnectionID = connectionID
+	result := make(chan error, 1)
+	go func() { result <- service.ProcessUserMessage(req) }()
+	return result
+}
+
+func waitForResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("ProcessUserMessage did not return")
+		return nil
+	}
+}
+
+func lastReply(t *testing.T, service *chatService) *Message {
+	t.Helper()
+	details, err := service.GetConversation("conv-1", "user-1")
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	for i := len(details.Messages) - 1; i >= 0; i-- {
+		if details.Messages[i].Role == "assistant" {
+			return details.Messages[i]
+		}
+	}
+	return nil
+}
+
+func conversationStatus(t *testing.T, conn tools.DBConnection) string {
+	t.Helper()
+	var status string
+	if err := conn.QueryRow(context.Background(), "SELECT status FROM conversations WHERE id = 'conv-1'").Scan(&status); err != nil {
+		t.Fatalf("Failed to load status: %v", err)
+	}
+	return status
+}
+
+func TestDisconnectBeforeProcessingSavesNothing(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, conn := setupHeadlessService(t, client, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForResult(t, processInBackground(service, ctx, "conn-1")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a message from a closed connection to be dropped, got %v", err)
+	}
+	// Only the message insertConversation added
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages"); n != 1 {
+		t.Errorf("Expected no new messages, got %d", n-1)
+	}
+}
+
+func TestDisconnectMidStreamCancelsAfterGrace(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, conn := setupHeadlessService(t, client, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := processInBackground(service, ctx, "conn-1")
+	<-client.streaming
+	start := time.Now()
+	cancel()
+	service.DetachConnectionFromStream("conv-1", "conn-1")
+
+	err := waitForResult(t, result)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the reply to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the reply to run for the grace period, cancelled after %s", elapsed)
+	}
+
+	// The partial reply is saved although the request's context is gone
+	reply := lastReply(t, service)
+	if reply == nil || reply.Content != "Hello" {
+		t.Fatalf("Expected the partial reply to be saved, got %+v", reply)
+	}
+	if reply.Metadata["interrupted"] != true || reply.Metadata["interrupted_reason"] != ErrStreamAbandoned.Error() {
+		t.Errorf("Expected the reply to be marked abandoned, got %+v", reply.Metadata)
+	}
+	if status := conversationStatus(t, conn); status != "interrupted" {
+		t.Errorf("Expected the conversation to be interrupted, got %q", status)
+	}
+}
+
+func TestReattachedStreamOutlivesItsRequest(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, conn := setupHeadlessService(t, client, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := processInBackground(service, ctx, "conn-1")
+	<-client.streaming
+
+	// A refresh: the old connection goes away and the new one resumes the stream
+	cancel()
+	service.DetachConnectionFromStream("conv-1", "conn-1")
+	if err := service.AttachConnectionToStream("conv-1", "conn-2", "user-1"); err != nil {
+		t.Fatalf("AttachConnectionToStream failed: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	close(client.finish)
+
+	if err := waitForResult(t, result); err != nil {
+		t.Fatalf("Expected the reply to complete, got %v", err)
+	}
+	if reply := lastReply(t, service); reply == nil || reply.Content != "Hello world" || reply.Metadata["interrupted"] != nil {
+		t.Errorf("Expected the complete reply, got %+v", reply)
+	}
+	if status := conversationStatus(t, conn); status != "completed" {
+		t.Errorf("Expected the conversation to be completed, got %q", status)
+	}
+}
+
+func TestStreamCancelledOnceLastConnectionLeaves(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, _ := setupHeadlessService(t, client, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := processInBackground(service, ctx, "conn-1")
+	<-client.streaming
+	cancel()
+	service.AttachConnectionToStream("conv-1", "conn-2", "user-1")
+	service.DetachConnectionFromStream("conv-1", "conn-1")
+
+	// Attached: the reply keeps running past the grace period
+	select {
+	case err := <-result:
+		t.Fatalf("Expected the reply to keep running while a connection receives it, got %v", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	service.DetachConnectionFromStream("conv-1", "conn-2")
+	if err := waitForResult(t, result); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the reply to be cancelled, got %v", err)
+	}
+}
+
+func TestStopCancelsAttachedStreams(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, _ := setupHeadlessService(t, client, time.Minute)
+
+	result := processInBackground(service, context.Background(), "conn-1")
+	<-client.streaming
+	service.Stop()
+
+	if err := waitForResult(t, result); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the reply to be cancelled, got %v", err)
+	}
+	if reply := lastReply(t, service); reply == nil || reply.Metadata["interrupted_reason"] != ErrServiceStopped.Error() {
+		t.Errorf("Expected the partial reply to be saved as stopped, got %+v", reply)
+	}
+}
+
+func TestDisconnectAtCompletionStillSavesReply(t *testing.T) {
+	client := newWaitingLLMClient()
+	service, conn := setupHeadlessService(t, client, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.onDone = cancel
+	close(client.finish)
+	if err := waitForResult(t, processInBackground(service, ctx, "conn-1")); err != nil {
+		t.Fatalf("Expected the reply to complete, got %v", err)
+	}
+
+	reply := lastReply(t, service)
+	if reply == nil || reply.Content != "Hello world" {
+		t.Fatalf("Expected the complete reply to be saved, got %+v", reply)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM message_metrics WHERE message_id = $1", reply.ID); n != 1 {
+		t.Errorf("Expected the reply's metrics to be recorded, got %d rows", n)
+	}
+}
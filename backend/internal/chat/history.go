@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// DefaultMessagePageSize is how many messages a page of history holds when no limit is asked for
+	DefaultMessagePageSize = 50
+	// MaxMessagePageSize is the largest page of history that may be asked for
+	MaxMessagePageSize = 200
+)
+
+// MessagePage selects a page of a conversation's history: the newest Limit
+// messages older than BeforeMessageID, or the newest Limit messages without it
+type MessagePage struct {
+	BeforeMessageID string
+	Limit           int // 0 uses DefaultMessagePageSize
+}
+
+// Size returns the number of messages the page holds at most
+func (p MessagePage) Size() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultMessagePageSize
+	case p.Limit > MaxMessagePageSize:
+		return MaxMessagePageSize
+	default:
+		return p.Limit
+	}
+}
+
+// MessageTotalsQuery selects whether the page's BeforeMessageID is one of a
+// conversation's messages and how many messages it has, as two integers
+func MessageTotalsQuery(conversationID string, page MessagePage) (string, []interface{}) {
+	return `SELECT COALESCE(SUM(CASE WHEN id = $1 THEN 1 ELSE 0 END), 0), COUNT(*)
+		FROM messages WHERE conversation_id = $2`, []interface{}{page.BeforeMessageID, conversationID}
+}
+
+// MessagePageQuery selects id, conversation_id, role, content, metadata,
+// tool_calls, created_at, user_id and status for a page of history, newest first and
+// one more than the page size, so PageRows can tell whether older messages
+// remain. Messages sharing a created_at are ordered by id, so a page boundary
+// between them neither skips nor repeats any.
+func MessagePageQuery(conversationID string, page MessagePage) (string, []interface{}) {
+	query := `SELECT id, conversation_id, role, content, metadata, tool_calls, created_at, user_id, status
+		FROM messages
+		WHERE conversation_id = $1`
+	args := []interface{}{conversationID}
+	if page.BeforeMessageID != "" {
+		query += ` AND (created_at < (SELECT b.created_at FROM messages b WHERE b.id = $2)
+			OR (created_at = (SELECT b.created_at FROM messages b WHERE b.id = $2) AND id < $2))`
+		args = append(args, page.BeforeMessageID)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args)+1)
+	args = append(args, page.Size()+1)
+	return query, args
+}
+
+// PageRows trims the rows of a MessagePageQuery to the page size and puts
+// them oldest first, reporting whether older messages remain
+func PageRows[T any](rows []T, page MessagePage) ([]T, bool) {
+	hasMore := len(rows) > page.Size()
+	if hasMore {
+		rows = rows[:page.Size()]
+	}
+	ordered := make([]T, len(rows))
+	for i, row := range rows {
+		ordered[len(rows)-1-i] = row
+	}
+	return ordered, hasMore
+}
+
+// GetConversationPage is GetConversation with one page of the conversation's
+// history instead of all of it. HasMore reports whether older messages remain
+// and TotalMessageCount counts every message, whichever page was loaded.
+func (s *chatService) GetConversationPage(conversationID, userID string, page MessagePage) (*ConversationDetails, error) {
+	ctx := context.Background()
+	conn := s.reader()
+
+	details, err := s.loadConversation(ctx, conn, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var total, cursorFound int64
+	query, args := MessageTotalsQuery(conversationID, page)
+	if err := conn.QueryRow(ctx, query, args...).Scan(&cursorFound, &total); err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	// A page before a message of another conversation is as if it did not exist
+	if page.BeforeMessageID != "" && cursorFound == 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	query, args = MessagePageQuery(conversationID, page)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+	messages, legacy, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	s.migrateLegacyMessages(ctx, legacy)
+
+	details.Messages, details.HasMore = PageRows(messages, page)
+	if err := attachToolExecutions(ctx, conn, conversationID, details.Messages); err != nil {
+		return nil, err
+	}
+	details.TotalMessageCount = int(total)
+	return details, nil
+}
+
+// scanMessages reads the rows of a query selecting the columns of
+// MessagePageQuery. legacy holds the messages whose JSON should be rewritten.
+func scanMessages(rows *sql.Rows) (messages, legacy []*Message, err error) {
+	for rows.Next() {
+		msg, isLegacy, err := scanMessage(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		messages = append(messages, msg)
+		if isLegacy {
+			legacy = append(legacy, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return messages, legacy, nil
+}
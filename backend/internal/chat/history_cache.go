@@ -0,0 +1,241 @@
+package chat
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// DefaultHistoryCacheConversations bounds how many conversations' history is cached
+	DefaultHistoryCacheConversations = 1000
+	// DefaultHistoryCacheBytes bounds the estimated size of all cached history
+	DefaultHistoryCacheBytes = 64 * 1024 * 1024
+
+	// historyMessageOverhead is added to each message's content and JSON sizes
+	// to estimate what it holds in memory
+	historyMessageOverhead = 256
+)
+
+// HistoryCache keeps the recent messages of active conversations, decoded as
+// getConversationHistory returns them, so a reply does not read and decode
+// the conversation's history again for every turn. A conversation is loaded
+// from the database on its first turn; the messages saved after that are
+// appended. Writes that change or remove stored messages any other way drop
+// the conversation, as does eviction of the least recently used one once
+// either limit is reached.
+//
+// The cache only sees writes made by this process. It is safe for concurrent
+// use, and a nil *HistoryCache caches nothing.
+type HistoryCache struct {
+	mutex            sync.Mutex
+	maxConversations int
+	maxBytes         int64
+	entries          map[string]*list.Element // Values are *historyEntry
+	recency          *list.List               // Most recently used first
+	bytes            int64
+	// Loads in flight by conversation; a write to the conversation withdraws
+	// the load's token so it does not store what it read before the write
+	loading   map[string]uint64
+	nextToken uint64
+
+	hits, misses, evictions int64
+}
+
+type historyEntry struct {
+	conversationID string
+	messages       []*Message // Chronological, at most maxHistoryMessages
+	sizes          []int64    // Estimated size of each message
+	bytes          int64
+}
+
+// HistoryCacheStats are counts for the admin status page
+type HistoryCacheStats struct {
+	Conversations int     `json:"conversations"`
+	Bytes         int64   `json:"bytes"`
+	MaxBytes      int64   `json:"max_bytes"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Evictions     int64   `json:"evictions"`
+}
+
+// NewHistoryCache creates a cache holding at most maxConversations
+// conversations and about maxBytes of messages. A non-positive limit returns
+// nil, which caches nothing.
+func NewHistoryCache(maxConversations int, maxBytes int64) *HistoryCache {
+	if maxConversations <= 0 || maxBytes <= 0 {
+		return nil
+	}
+	return &HistoryCache{
+		maxConversations: maxConversations,
+		maxBytes:         maxBytes,
+		entries:          make(map[string]*list.Element),
+		recency:          list.New(),
+		loading:          make(map[string]uint64),
+	}
+}
+
+// get returns a copy of a conversation's cached history. On a miss it returns
+// a token for store, which caches the history the caller then loads.
+func (c *HistoryCache) get(conversationID string) ([]*Message, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[conversationID]; ok {
+		c.hits++
+		c.recency.MoveToFront(element)
+		// Callers append system messages; they must not write into the cached array
+		messages := element.Value.(*historyEntry).messages
+		return append([]*Message(nil), messages...), 0, true
+	}
+	c.misses++
+	c.nextToken++
+	c.loading[conversationID] = c.nextToken
+	return nil, c.nextToken, false
+}
+
+// store caches history loaded after a miss, unless the conversation was
+// written to since the miss. sizes holds each message's estimated size.
+func (c *HistoryCache) store(conversationID string, token uint64, messages []*Message, sizes []int64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.loading[conversationID] != token {
+		return
+	}
+	delete(c.loading, conversationID)
+	if _, ok := c.entries[conversationID]; ok {
+		return
+	}
+
+	entry := &historyEntry{conversationID: conversationID, messages: messages, sizes: sizes}
+	for _, size := range sizes {
+		entry.bytes += size
+	}
+	if entry.bytes > c.maxBytes {
+		return
+	}
+	c.entries[conversationID] = c.recency.PushFront(entry)
+	c.bytes += entry.bytes
+	c.evict()
+}
+
+// abandon withdraws the token of a load that failed
+func (c *HistoryCache) abandon(conversationID string, token uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.loading[conversationID] == token {
+		delete(c.loading, conversationID)
+	}
+}
+
+// append adds a newly saved message to its conversation's cached history. A
+// message older than the newest cached one drops the conversation instead,
+// since its place in the history is not known.
+func (c *HistoryCache) append(msg *Message, size int64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.loading, msg.ConversationID)
+	element, ok := c.entries[msg.ConversationID]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*historyEntry)
+	if n := len(entry.messages); n > 0 && msg.CreatedAt.Before(entry.messages[n-1].CreatedAt) {
+		c.remove(element)
+		return
+	}
+
+	// Copy on append so slices already returned by get are left as they were
+	messages := make([]*Message, 0, len(entry.messages)+1)
+	sizes := make([]int64, 0, len(entry.sizes)+1)
+	messages = append(append(messages, entry.messages...), msg)
+	sizes = append(append(sizes, entry.sizes...), size)
+	bytes := entry.bytes + size
+	for len(messages) > maxHistoryMessages {
+		bytes -= sizes[0]
+		messages, sizes = messages[1:], sizes[1:]
+	}
+	c.bytes += bytes - entry.bytes
+	entry.messages, entry.sizes, entry.bytes = messages, sizes, bytes
+	c.recency.MoveToFront(element)
+	c.evict()
+}
+
+// Invalidate drops a conversation's cached history. Call it after changing or
+// deleting the conversation's stored messages other than by saving a new one.
+func (c *HistoryCache) Invalidate(conversationID string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.loading, conversationID)
+	if element, ok := c.entries[conversationID]; ok {
+		c.remove(element)
+	}
+}
+
+// Stats returns the cache's size and hit counts
+func (c *HistoryCache) Stats() HistoryCacheStats {
+	if c == nil {
+		return HistoryCacheStats{}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := HistoryCacheStats{
+		Conversations: len(c.entries),
+		Bytes:         c.bytes,
+		MaxBytes:      c.maxBytes,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// evict drops the least recently used conversations until both limits hold;
+// the caller holds the mutex
+func (c *HistoryCache) evict() {
+	for len(c.entries) > c.maxConversations || c.bytes > c.maxBytes {
+		oldest := c.recency.Back()
+		if oldest == nil {
+			return
+		}
+		c.remove(oldest)
+		c.evictions++
+	}
+}
+
+// remove drops one conversation; the caller holds the mutex
+func (c *HistoryCache) remove(element *list.Element) {
+	entry := c.recency.Remove(element).(*historyEntry)
+	delete(c.entries, entry.conversationID)
+	c.bytes -= entry.bytes
+}
+
+// historyMessageSize estimates the memory a decoded message holds from the
+// sizes of its stored columns
+func historyMessageSize(msg *Message, metadataJSON, toolCallsJSON []byte) int64 {
+	return int64(len(msg.ID) + len(msg.ConversationID) + len(msg.Role) + len(msg.Content) +
+		len(metadataJSON) + len(toolCallsJSON) + historyMessageOverhead)
+}
@@ -0,0 +1,261 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// historyCountingConn counts the history queries run through a connection
+type historyCountingConn struct {
+	tools.DBConnection
+	historyQueries atomic.Int32
+}
+
+func (c *historyCountingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if query == historyQuery {
+		c.historyQueries.Add(1)
+	}
+	return c.DBConnection.Query(ctx, query, args...)
+}
+
+// promptRecordingClient replies with the number of its call and records the
+// role and content of the conversation messages in every prompt it is sent,
+// reporting tokensPerReply tokens used for each reply
+type promptRecordingClient struct {
+	fakeLLMClient
+	mutex          sync.Mutex
+	prompts        [][]string
+	tokensPerReply int
+}
+
+func (c *promptRecordingClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	var prompt []string
+	for _, message := range req.Messages {
+		var decoded struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}
+		raw, _ := json.Marshal(message)
+		json.Unmarshal(raw, &decoded)
+		if decoded.Role != "system" {
+			prompt = append(prompt, decoded.Role+": "+decoded.Content)
+		}
+	}
+	c.mutex.Lock()
+	c.prompts = append(c.prompts, prompt)
+	reply := fmt.Sprintf("Reply %d", len(c.prompts))
+	c.mutex.Unlock()
+
+	if err := callback(&llm.StreamingChunk{Content: reply, TokensUsed: c.tokensPerReply}); err != nil {
+		return err
+	}
+	return callback(&llm.StreamingChunk{Done: true})
+}
+
+func (c *promptRecordingClient) lastPrompt() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.prompts[len(c.prompts)-1]
+}
+
+func setupHistoryCacheService(t *testing.T, client llm.LLMClient) (*chatService, *historyCountingConn) {
+	t.Helper()
+
+	conn := &historyCountingConn{DBConnection: setupRetentionDB(t)}
+	insertConversation(t, conn, "conv-1", nil)
+	return NewChatService(conn, fakeHub{}, client, tools.NewToolRegistry()), conn
+}
+
+func sendTurn(t *testing.T, service ChatService, conversationID, content string) {
+	t.Helper()
+
+	if err := service.ProcessUserMessage(&ChatRequest{
+		ConversationID: conversationID,
+		Content:        content,
+		UserID:         "user-1",
+		ProjectID:      "project-1",
+	}); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+}
+
+func TestProcessUserMessageReusesCachedHistory(t *testing.T) {
+	client := &promptRecordingClient{}
+	service, conn := setupHistoryCacheService(t, client)
+
+	sendTurn(t, service, "conv-1", "How many orders?")
+	if n := conn.historyQueries.Load(); n != 1 {
+		t.Fatalf("Expected the first turn to load history once, got %d queries", n)
+	}
+
+	// The next turn goes through a per-client copy, as the WebSocket handler does
+	conn.historyQueries.Store(0)
+	sendTurn(t, service.WithLLMClient(client), "conv-1", "And last week?")
+	if n := conn.historyQueries.Load(); n != 0 {
+		t.Errorf("Expected no history queries on the second turn, got %d", n)
+	}
+
+	want := []string{"user: hello", "user: How many orders?", "assistant: Reply 1", "user: And last week?"}
+	if prompt := client.lastPrompt(); strings.Join(prompt, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the second prompt to hold the appended messages\n%v\ngot\n%v", want, prompt)
+	}
+
+	stats := service.history.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 || stats.Conversations != 1 || stats.Bytes <= 0 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+}
+
+func TestHistoryCacheIsDroppedWhenMessagesChange(t *testing.T) {
+	client := &promptRecordingClient{}
+	service, conn := setupHistoryCacheService(t, client)
+	sendTurn(t, service, "conv-1", "How many orders?")
+
+	// Rewriting a stored message, as a resumed reply does, drops the conversation
+	reply := &Message{ID: "conv-1-m1", ConversationID: "conv-1", Role: "user", Content: "hello again", CreatedAt: time.Now().UTC().Add(-time.Minute)}
+	if err := service.replaceMessage(context.Background(), reply); err != nil {
+		t.Fatalf("replaceMessage failed: %v", err)
+	}
+	conn.historyQueries.Store(0)
+	sendTurn(t, service, "conv-1", "And last week?")
+	if n := conn.historyQueries.Load(); n != 1 {
+		t.Errorf("Expected history to be loaded again, got %d queries", n)
+	}
+	if prompt := client.lastPrompt(); prompt[0] != "user: hello again" {
+		t.Errorf("Expected the rewritten message in the prompt, got %v", prompt)
+	}
+
+	// So does deleting the conversation
+	if err := service.DeleteConversation("conv-1", "user-1"); err != nil {
+		t.Fatalf("DeleteConversation failed: %v", err)
+	}
+	if stats := service.history.Stats(); stats.Conversations != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected the deleted conversation to be dropped, got %+v", stats)
+	}
+}
+
+func TestHistoryCacheServesConcurrentConversations(t *testing.T) {
+	client := &promptRecordingClient{}
+	service, conn := setupHistoryCacheService(t, client)
+	conversations := []string{"conv-1", "conv-2", "conv-3", "conv-4"}
+	for _, id := range conversations[1:] {
+		insertConversation(t, conn, id, nil)
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range conversations {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			service := service.WithLLMClient(client)
+			for turn := 0; turn < 3; turn++ {
+				sendTurn(t, service, id, fmt.Sprintf("%s turn %d", id, turn))
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if n := conn.historyQueries.Load(); n != int32(len(conversations)) {
+		t.Errorf("Expected one history load per conversation, got %d", n)
+	}
+	for _, id := range conversations {
+		history, err := service.getConversationHistory(context.Background(), id, "user-1")
+		if err != nil {
+			t.Fatalf("getConversationHistory failed: %v", err)
+		}
+		if len(history) != 7 {
+			t.Fatalf("Expected 7 messages in %s, got %d", id, len(history))
+		}
+		for _, msg := range history {
+			if msg.ConversationID != id {
+				t.Errorf("Message %s of %s cached for %s", msg.ID, msg.ConversationID, id)
+			}
+		}
+		if last := history[5]; last.Role != "user" || last.Content != id+" turn 2" {
+			t.Errorf("Expected the last user message of %s, got %+v", id, last)
+		}
+	}
+}
+
+func cachedMessage(conversationID, id string, at time.Time) *Message {
+	return &Message{ID: id, ConversationID: conversationID, Role: "user", Content: id, CreatedAt: at}
+}
+
+func TestHistoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewHistoryCache(2, 1000)
+	at := time.Now()
+	load := func(id string, size int64) {
+		if _, token, ok := cache.get(id); !ok {
+			cache.store(id, token, []*Message{cachedMessage(id, id+"-m1", at)}, []int64{size})
+		}
+	}
+
+	load("a", 100)
+	load("b", 100)
+	load("a", 100) // a is now the most recently used
+	load("c", 100)
+	if _, _, ok := cache.get("b"); ok {
+		t.Error("Expected b to be evicted beyond two conversations")
+	}
+	if _, _, ok := cache.get("a"); !ok {
+		t.Error("Expected a to be kept")
+	}
+
+	// Appends count towards the byte limit too
+	cache.append(cachedMessage("a", "a-m2", at.Add(time.Second)), 850)
+	if stats := cache.Stats(); stats.Conversations != 1 || stats.Bytes != 1050-100 || stats.Evictions != 2 {
+		t.Errorf("Expected c evicted for bytes, got %+v", stats)
+	}
+
+	// History too large for the cache is not stored
+	load("d", 2000)
+	if _, _, ok := cache.get("d"); ok {
+		t.Error("Expected oversized history not to be cached")
+	}
+}
+
+func TestHistoryCacheKeepsWritesMadeDuringALoad(t *testing.T) {
+	cache := NewHistoryCache(10, 1<<20)
+	at := time.Now()
+
+	// A message saved while the history is being read is missing from what was read
+	_, token, _ := cache.get("a")
+	cache.append(cachedMessage("a", "a-m2", at.Add(time.Second)), 10)
+	cache.store("a", token, []*Message{cachedMessage("a", "a-m1", at)}, []int64{10})
+	if _, _, ok := cache.get("a"); ok {
+		t.Error("Expected history read before a write not to be cached")
+	}
+
+	// A returned slice does not change when messages are appended after it
+	_, token, _ = cache.get("b")
+	cache.store("b", token, []*Message{cachedMessage("b", "b-m1", at)}, []int64{10})
+	before, _, _ := cache.get("b")
+	cache.append(cachedMessage("b", "b-m2", at.Add(time.Second)), 10)
+	after, _, _ := cache.get("b")
+	if len(before) != 1 || len(after) != 2 || after[1].ID != "b-m2" {
+		t.Errorf("Expected 1 then 2 messages, got %d and %d", len(before), len(after))
+	}
+
+	// A message older than the cached ones cannot be placed, so the entry is dropped
+	cache.append(cachedMessage("b", "b-m0", at.Add(-time.Second)), 10)
+	if _, _, ok := cache.get("b"); ok {
+		t.Error("Expected an out of order message to drop the conversation")
+	}
+
+	var disabled *HistoryCache
+	disabled.append(cachedMessage("c", "c-m1", at), 10)
+	disabled.Invalidate("c")
+	if _, _, ok := disabled.get("c"); ok || disabled.Stats() != (HistoryCacheStats{}) {
+		t.Error("Expected a nil cache to cache nothing")
+	}
+}
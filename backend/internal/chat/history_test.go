@@ -0,0 +1,178 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// countingConn counts the statements run through a connection
+type countingConn struct {
+	tools.DBConnection
+	queries atomic.Int32
+}
+
+func (c *countingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.queries.Add(1)
+	return c.DBConnection.Query(ctx, query, args...)
+}
+
+func (c *countingConn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	c.queries.Add(1)
+	return c.DBConnection.QueryRow(ctx, query, args...)
+}
+
+func (c *countingConn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	c.queries.Add(1)
+	return c.DBConnection.Exec(ctx, query, args...)
+}
+
+// seedHistory adds n more messages to conv-1 after the one insertConversation
+// added, three to a timestamp so page boundaries fall between equal times
+func seedHistory(t *testing.T, service *chatService, n int) {
+	t.Helper()
+
+	start := time.Now().UTC().Add(time.Hour)
+	for i := 0; i < n; i++ {
+		if _, err := service.db.Exec(context.Background(),
+			"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, 'conv-1', 'user', $2, $3)",
+			fmt.Sprintf("msg-%04d", i), fmt.Sprintf("message %d", i), start.Add(time.Duration(i/3)*time.Second)); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+}
+
+func TestGetConversationPageWalksHistory(t *testing.T) {
+	service, _ := setupHeadlessService(t, &scriptedLLMClient{}, time.Minute)
+	seedHistory(t, service, 299)
+
+	var walked []string
+	page := MessagePage{Limit: 40}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("Paging did not end")
+		}
+		details, err := service.GetConversationPage("conv-1", "user-1", page)
+		if err != nil {
+			t.Fatalf("GetConversationPage failed: %v", err)
+		}
+		if details.TotalMessageCount != 300 {
+			t.Errorf("Expected a total of 300 messages, got %d", details.TotalMessageCount)
+		}
+		if len(details.Messages) == 0 || len(details.Messages) > 40 {
+			t.Fatalf("Expected 1 to 40 messages in a page, got %d", len(details.Messages))
+		}
+
+		ids := make([]string, len(details.Messages))
+		for i, msg := range details.Messages {
+			ids[i] = msg.ID
+		}
+		walked = append(ids, walked...)
+		if !details.HasMore {
+			break
+		}
+		page.BeforeMessageID = details.Messages[0].ID
+	}
+
+	// Every message once, oldest first
+	if len(walked) != 300 {
+		t.Fatalf("Expected to walk 300 messages, got %d", len(walked))
+	}
+	if walked[0] != "conv-1-m1" {
+		t.Errorf("Expected the first message first, got %s", walked[0])
+	}
+	for i, id := range walked[1:] {
+		if want := fmt.Sprintf("msg-%04d", i); id != want {
+			t.Fatalf("Expected %s at position %d, got %s", want, i+1, id)
+		}
+	}
+}
+
+func TestGetConversationPageLimits(t *testing.T) {
+	service, _ := setupHeadlessService(t, &scriptedLLMClient{}, time.Minute)
+	seedHistory(t, service, 299)
+
+	details, err := service.GetConversationPage("conv-1", "user-1", MessagePage{})
+	if err != nil {
+		t.Fatalf("GetConversationPage failed: %v", err)
+	}
+	if len(details.Messages) != DefaultMessagePageSize || !details.HasMore {
+		t.Errorf("Expected the newest %d messages with more to load, got %d (has_more %v)", DefaultMessagePageSize, len(details.Messages), details.HasMore)
+	}
+	if last := details.Messages[len(details.Messages)-1].ID; last != "msg-0298" {
+		t.Errorf("Expected the page to end with the newest message, got %s", last)
+	}
+
+	details, err = service.GetConversationPage("conv-1", "user-1", MessagePage{Limit: 1000})
+	if err != nil {
+		t.Fatalf("GetConversationPage failed: %v", err)
+	}
+	if len(details.Messages) != MaxMessagePageSize {
+		t.Errorf("Expected the page to be capped at %d messages, got %d", MaxMessagePageSize, len(details.Messages))
+	}
+}
+
+func TestGetConversationPageUnknownCursor(t *testing.T) {
+	service, conn := setupHeadlessService(t, &scriptedLLMClient{}, time.Minute)
+	insertConversation(t, conn, "conv-2", nil)
+
+	for _, cursor := range []string{"missing", "conv-2-m1"} {
+		if _, err := service.GetConversationPage("conv-1", "user-1", MessagePage{BeforeMessageID: cursor}); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound before %q, got %v", cursor, err)
+		}
+	}
+	if _, err := service.GetConversationPage("conv-1", "user-2", MessagePage{}); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound for another user, got %v", err)
+	}
+}
+
+func TestReadsUseTheReadConnection(t *testing.T) {
+	service, conn := setupHeadlessService(t, &scriptedLLMClient{}, time.Minute)
+	primary := &countingConn{DBConnection: conn}
+	replica := &countingConn{DBConnection: conn}
+	service.db = primary
+	service.SetReadConnection(replica)
+
+	reads := map[string]func() error{
+		"GetConversations": func() error {
+			conversations, err := service.GetConversations("user-1", "project-1")
+			if err == nil && len(conversations) != 1 {
+				err = fmt.Errorf("expected conv-1, got %d conversations", len(conversations))
+			}
+			return err
+		},
+		"GetConversation": func() error { _, err := service.GetConversation("conv-1", "user-1"); return err },
+		"GetConversationPage": func() error {
+			_, err := service.GetConversationPage("conv-1", "user-1", MessagePage{Limit: 10})
+			return err
+		},
+	}
+	for name, read := range reads {
+		primaryBefore, replicaBefore := primary.queries.Load(), replica.queries.Load()
+		if err := read(); err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if primary.queries.Load() != primaryBefore || replica.queries.Load() == replicaBefore {
+			t.Errorf("%s: expected only the read connection to be used", name)
+		}
+	}
+
+	// Merging a live stream needs the replies it saved, so it reads the primary
+	replicaBefore := replica.queries.Load()
+	if _, err := service.LoadStreamingConversation("conv-1", "user-1"); err != nil {
+		t.Fatalf("LoadStreamingConversation failed: %v", err)
+	}
+	if replica.queries.Load() != replicaBefore || primary.queries.Load() == 0 {
+		t.Error("Expected LoadStreamingConversation to read the primary")
+	}
+
+	if copy := service.WithLLMClient(&scriptedLLMClient{}).(*chatService); copy.reader() != replica {
+		t.Error("Expected WithLLMClient copies to keep the read connection")
+	}
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"zlay-backend/internal/langdetect"
+	"zlay-backend/internal/tools"
+)
+
+// languageDirective is the system message put in front of the context of a
+// conversation with a pinned language
+const languageDirective = "Respond in %s, even when earlier messages or tool results use another language."
+
+// ErrUnsupportedLanguage is returned when a conversation is pinned to a language
+// the detector does not know
+var ErrUnsupportedLanguage = errors.New("unsupported language")
+
+// SetConversationLanguage pins the language of a non-deleted conversation the
+// user takes part in within their client and returns the updated conversation.
+// An empty language unpins it, so the next user message is detected again. A
+// version other than 0 is the one the change was based on; when the
+// conversation has moved past it a *VersionConflictError is returned.
+func SetConversationLanguage(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID, language string, version int64) (*Conversation, error) {
+	if language != "" && !langdetect.Supported(language) {
+		return nil, ErrUnsupportedLanguage
+	}
+
+	var value interface{}
+	if language != "" {
+		value = language
+	}
+	return updateConversation(ctx, db, userID, clientID, conversationID, "language", value, version, nil)
+}
+
+// conversationLanguage returns the language pinned on a conversation. When none
+// is pinned yet, content is detected and a confident guess is pinned; a
+// language chosen meanwhile by a participant is kept.
+func (s *chatService) conversationLanguage(ctx context.Context, conversationID, content string) string {
+	var language sql.NullString
+	err := s.db.QueryRow(ctx, "SELECT language FROM conversations WHERE id = $1", conversationID).Scan(&language)
+	if err != nil {
+		log.Printf("Failed to read the language of conversation %s: %v", conversationID, err)
+		return ""
+	}
+	if language.Valid || content == "" {
+		return language.String
+	}
+
+	detected := langdetect.Detect(content)
+	if detected == "" {
+		return ""
+	}
+	if _, err := s.db.Exec(ctx,
+		"UPDATE conversations SET language = $1 WHERE id = $2 AND language IS NULL",
+		detected, conversationID); err != nil {
+		log.Printf("Failed to pin the language of conversation %s: %v", conversationID, err)
+	}
+	return detected
+}
+
+// withLanguageDirective puts a system message telling the model to respond in
+// language in front of history; history is returned as is without a language
+func withLanguageDirective(conversationID string, history []*Message, language string) []*Message {
+	name := langdetect.Name(language)
+	if name == "" {
+		return history
+	}
+	directive := &Message{
+		ID:             "language-" + conversationID,
+		ConversationID: conversationID,
+		Role:           "system",
+		Content:        fmt.Sprintf(languageDirective, name),
+	}
+	return append([]*Message{directive}, history...)
+}
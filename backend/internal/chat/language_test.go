@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/langdetect"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+func setupLanguageService(t *testing.T) (*chatService, *requestRecordingClient, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	client := &requestRecordingClient{}
+	return NewChatService(conn, fakeHub{}, client, tools.NewToolRegistry()), client, conn
+}
+
+func sendUserMessage(t *testing.T, service *chatService, content string) {
+	t.Helper()
+
+	req := userMessageRequest("")
+	req.Content = content
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+}
+
+func storedLanguage(t *testing.T, conn tools.DBConnection) string {
+	t.Helper()
+
+	var language *string
+	if err := conn.QueryRow(context.Background(), "SELECT language FROM conversations WHERE id = 'conv-1'").Scan(&language); err != nil {
+		t.Fatalf("Failed to read language: %v", err)
+	}
+	if language == nil {
+		return ""
+	}
+	return *language
+}
+
+// languageDirectiveOf returns the language directive leading an LLM request, or ""
+func languageDirectiveOf(req *llm.LLMRequest) string {
+	if len(req.Messages) == 0 || req.Messages[0].OfSystem == nil {
+		return ""
+	}
+	content := req.Messages[0].OfSystem.Content.OfString.Value
+	if !strings.HasPrefix(content, "Respond in ") {
+		return ""
+	}
+	return content
+}
+
+func TestProcessUserMessagePinsTheFirstDetectedLanguage(t *testing.T) {
+	service, client, conn := setupLanguageService(t)
+
+	// Too short to tell, so nothing is pinned yet
+	sendUserMessage(t, service, "ok")
+	if language := storedLanguage(t, conn); language != "" {
+		t.Errorf("Expected no language for a short message, got %q", language)
+	}
+	if directive := languageDirectiveOf(client.lastRequest(t)); directive != "" {
+		t.Errorf("Expected no directive without a language, got %q", directive)
+	}
+
+	sendUserMessage(t, service, "Tolong tampilkan total penjualan per wilayah untuk bulan ini dan bandingkan dengan bulan lalu.")
+	if language := storedLanguage(t, conn); language != langdetect.Indonesian {
+		t.Fatalf("Expected the conversation pinned to id, got %q", language)
+	}
+	if directive := languageDirectiveOf(client.lastRequest(t)); !strings.HasPrefix(directive, "Respond in Bahasa Indonesia") {
+		t.Errorf("Expected a Bahasa Indonesia directive, got %q", directive)
+	}
+
+	// A later message in another language does not move the pin
+	sendUserMessage(t, service, "Now show me the same numbers for the previous quarter, grouped by city.")
+	if language := storedLanguage(t, conn); language != langdetect.Indonesian {
+		t.Errorf("Expected the first detected language to stay pinned, got %q", language)
+	}
+	if directive := languageDirectiveOf(client.lastRequest(t)); !strings.HasPrefix(directive, "Respond in Bahasa Indonesia") {
+		t.Errorf("Expected the Bahasa Indonesia directive to remain, got %q", directive)
+	}
+}
+
+func TestSetConversationLanguageOverridesDetection(t *testing.T) {
+	service, client, conn := setupLanguageService(t)
+	ctx := context.Background()
+
+	conv, err := SetConversationLanguage(ctx, conn, "user-1", "client-1", "conv-1", langdetect.English, 0)
+	if err != nil {
+		t.Fatalf("SetConversationLanguage failed: %v", err)
+	}
+	if conv.Language == nil || *conv.Language != langdetect.English {
+		t.Errorf("Expected the updated conversation to carry en, got %v", conv.Language)
+	}
+
+	sendUserMessage(t, service, "Kenapa pendapatan bulan lalu turun? Tolong jelaskan penyebabnya secara singkat.")
+	if language := storedLanguage(t, conn); language != langdetect.English {
+		t.Errorf("Expected the chosen language to win over detection, got %q", language)
+	}
+	if directive := languageDirectiveOf(client.lastRequest(t)); !strings.HasPrefix(directive, "Respond in English") {
+		t.Errorf("Expected an English directive, got %q", directive)
+	}
+
+	if _, err := SetConversationLanguage(ctx, conn, "user-1", "client-1", "conv-1", "fr", 0); !errors.Is(err, ErrUnsupportedLanguage) {
+		t.Errorf("Expected ErrUnsupportedLanguage, got %v", err)
+	}
+	if _, err := SetConversationLanguage(ctx, conn, "user-3", "client-2", "conv-1", langdetect.English, 0); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound for another client, got %v", err)
+	}
+
+	// Clearing the language lets the next message be detected again
+	conv, err = SetConversationLanguage(ctx, conn, "user-1", "client-1", "conv-1", "", 0)
+	if err != nil {
+		t.Fatalf("Clearing the language failed: %v", err)
+	}
+	if conv.Language != nil {
+		t.Errorf("Expected no language after clearing, got %q", *conv.Language)
+	}
+}
@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// Latency grouping for LatencyStats
+const (
+	LatencyGroupNone  = ""
+	LatencyGroupModel = "model"
+	LatencyGroupDay   = "day"
+)
+
+// MessageTiming is how long the model took to produce a reply: TTFTMs until
+// the first content or tool call, TotalMs until the stream finished
+type MessageTiming struct {
+	Model      string `json:"model"`
+	TTFTMs     int64  `json:"ttft_ms"`
+	TotalMs    int64  `json:"total_ms"`
+	ChunkCount int    `json:"chunk_count"`
+}
+
+// applyTo stores the timing in a message's metadata
+func (t MessageTiming) applyTo(metadata map[string]interface{}) {
+	metadata["model"] = t.Model
+	metadata["ttft_ms"] = t.TTFTMs
+	metadata["total_ms"] = t.TotalMs
+	metadata["chunk_count"] = t.ChunkCount
+}
+
+// StreamTimer measures one LLM stream. Call Chunk for every chunk received
+// and Finish once the stream has ended.
+type StreamTimer struct {
+	now    func() time.Time
+	start  time.Time
+	first  time.Time
+	chunks int
+}
+
+// NewStreamTimer starts timing a stream now
+func NewStreamTimer(now func() time.Time) *StreamTimer {
+	return &StreamTimer{now: now, start: now()}
+}
+
+// Chunk records a chunk; only chunks with content or tool calls count
+func (t *StreamTimer) Chunk(chunk *llm.StreamingChunk) {
+	if chunk.Content == "" && chunk.ToolCalls == nil {
+		return
+	}
+	if t.chunks == 0 {
+		t.first = t.now()
+	}
+	t.chunks++
+}
+
+// Finish returns the stream's timing. A stream without content reports its
+// total time as its time to first token.
+func (t *StreamTimer) Finish(model string) MessageTiming {
+	end := t.now()
+	first := t.first
+	if t.chunks == 0 {
+		first = end
+	}
+	return MessageTiming{
+		Model:      model,
+		TTFTMs:     first.Sub(t.start).Milliseconds(),
+		TotalMs:    end.Sub(t.start).Milliseconds(),
+		ChunkCount: t.chunks,
+	}
+}
+
+// recordMessageMetrics stores a reply's timing for LatencyStats
+func recordMessageMetrics(ctx context.Context, db tools.DBConnection, msg *Message, timing MessageTiming) error {
+	createdAt := msg.CreatedAt.UTC()
+	_, err := db.Exec(ctx,
+		`INSERT INTO message_metrics (message_id, conversation_id, model, ttft_ms, total_ms, chunk_count, day, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		msg.ID, msg.ConversationID, timing.Model, timing.TTFTMs, timing.TotalMs, timing.ChunkCount,
+		createdAt.Format("2006-01-02"), createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to record message metrics: %w", err)
+	}
+	return nil
+}
+
+// LatencyPercentiles are nearest-rank percentiles in milliseconds
+type LatencyPercentiles struct {
+	P50 int64 `json:"p50_ms"`
+	P95 int64 `json:"p95_ms"`
+}
+
+// LatencyBucket aggregates the replies of one model or UTC day (2006-01-02);
+// Group is empty without grouping
+type LatencyBucket struct {
+	Group string             `json:"group"`
+	Count int64              `json:"count"`
+	TTFT  LatencyPercentiles `json:"ttft"`
+	Total LatencyPercentiles `json:"total"`
+}
+
+// LatencySummary is the reply latency of a client over a time range
+type LatencySummary struct {
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	GroupBy string          `json:"group_by,omitempty"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// LatencyStats computes p50 and p95 time to first token and total time of a
+// client's replies created in [from, to), ordered by group. Replies are
+// attributed to the owner of their conversation. Percentiles are picked in
+// SQL by rank, which avoids percentile functions that differ per database.
+func LatencyStats(ctx context.Context, db tools.DBConnection, clientID string, from, to time.Time, groupBy string) (*LatencySummary, error) {
+	var group, partition string
+	switch groupBy {
+	case LatencyGroupNone:
+		group = "''"
+	case LatencyGroupModel:
+		group, partition = "mm.model", "PARTITION BY mm.model "
+	case LatencyGroupDay:
+		group, partition = "mm.day", "PARTITION BY mm.day "
+	default:
+		return nil, fmt.Errorf("unsupported group_by: %s", groupBy)
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT grp, COUNT(*),
+			MIN(CASE WHEN ttft_rank * 100 >= 50 * n THEN ttft_ms END),
+			MIN(CASE WHEN ttft_rank * 100 >= 95 * n THEN ttft_ms END),
+			MIN(CASE WHEN total_rank * 100 >= 50 * n THEN total_ms END),
+			MIN(CASE WHEN total_rank * 100 >= 95 * n THEN total_ms END)
+		FROM (
+			SELECT `+group+` AS grp, mm.ttft_ms, mm.total_ms,
+				ROW_NUMBER() OVER (`+partition+`ORDER BY mm.ttft_ms) AS ttft_rank,
+				ROW_NUMBER() OVER (`+partition+`ORDER BY mm.total_ms) AS total_rank,
+				COUNT(*) OVER (`+partition+`) AS n
+			FROM message_metrics mm
+			JOIN conversations c ON c.id = mm.conversation_id
+			JOIN users u ON u.id = c.user_id
+			WHERE u.client_id = $1 AND mm.created_at >= $2 AND mm.created_at < $3
+		) ranked
+		GROUP BY grp
+		ORDER BY grp`,
+		clientID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latency: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &LatencySummary{From: from, To: to, GroupBy: groupBy, Buckets: []LatencyBucket{}}
+	for rows.Next() {
+		var bucket LatencyBucket
+		if err := rows.Scan(&bucket.Group, &bucket.Count,
+			&bucket.TTFT.P50, &bucket.TTFT.P95, &bucket.Total.P50, &bucket.Total.P95); err != nil {
+			return nil, fmt.Errorf("failed to scan latency: %w", err)
+		}
+		summary.Buckets = append(summary.Buckets, bucket)
+	}
+	return summary, rows.Err()
+}
@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// delayedLLMClient streams its chunks, advancing a fake clock by delays[i]
+// before chunk i; the last delay comes before the end of the stream
+type delayedLLMClient struct {
+	scriptedLLMClient
+	clock  *time.Time
+	delays []time.Duration
+}
+
+func (f *delayedLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	for i, content := range f.chunks {
+		*f.clock = f.clock.Add(f.delays[i])
+		if err := callback(&llm.StreamingChunk{Content: content}); err != nil {
+			return err
+		}
+	}
+	*f.clock = f.clock.Add(f.delays[len(f.chunks)])
+	return callback(&llm.StreamingChunk{Done: true})
+}
+
+func TestStreamRecordsTiming(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	clock := time.Now()
+	client := &delayedLLMClient{
+		scriptedLLMClient: scriptedLLMClient{chunks: []string{"Hello ", "there"}},
+		clock:             &clock,
+		delays:            []time.Duration{300 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond},
+	}
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, client, tools.NewToolRegistry())
+	service.now = func() time.Time { return clock }
+
+	if err := service.ProcessUserMessage(userMessageRequest("")); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	details, err := service.GetConversation("conv-1", "user-1")
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	reply := details.Messages[len(details.Messages)-1]
+	// Metadata round-trips through JSON, so the numbers come back as float64
+	if reply.Metadata["ttft_ms"] != float64(300) || reply.Metadata["total_ms"] != float64(600) || reply.Metadata["chunk_count"] != float64(2) {
+		t.Errorf("Unexpected timing metadata: %+v", reply.Metadata)
+	}
+
+	var ttft, total, chunks int64
+	if err := conn.QueryRow(context.Background(),
+		"SELECT ttft_ms, total_ms, chunk_count FROM message_metrics WHERE message_id = $1", reply.ID).Scan(&ttft, &total, &chunks); err != nil {
+		t.Fatalf("Expected the reply's metrics to be recorded: %v", err)
+	}
+	if ttft != 300 || total != 600 || chunks != 2 {
+		t.Errorf("Unexpected metrics: ttft %d, total %d, chunks %d", ttft, total, chunks)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	insertConversation(t, conn, "conv-1", nil)
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status) VALUES ('conv-other', 'Other', 'user-3', 'project-2', 'completed')"); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	record := func(id, conversationID, model string, ttft, total int64, at time.Time) {
+		msg := &Message{ID: id, ConversationID: conversationID, CreatedAt: at}
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, 'assistant', 'reply', $3)", id, conversationID, at); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		if err := recordMessageMetrics(ctx, conn, msg, MessageTiming{Model: model, TTFTMs: ttft, TotalMs: total, ChunkCount: 1}); err != nil {
+			t.Fatalf("recordMessageMetrics failed: %v", err)
+		}
+	}
+	// gpt: ttft 100..1000 on day 1; mini: 50 and 70 on day 2
+	for i := int64(1); i <= 10; i++ {
+		record("gpt-"+string(rune('a'+i)), "conv-1", "gpt", i*100, i*1000, day1)
+	}
+	record("mini-1", "conv-1", "mini", 50, 500, day2)
+	record("mini-2", "conv-1", "mini", 70, 700, day2)
+	// Another client's replies and replies outside the range are left out
+	record("other-1", "conv-other", "gpt", 9000, 9000, day1)
+	record("late-1", "conv-1", "gpt", 9000, 9000, day2.Add(48*time.Hour))
+
+	from, to := day1.Add(-time.Hour), day2.Add(time.Hour)
+	tests := []struct {
+		groupBy string
+		want    []LatencyBucket
+	}{
+		{LatencyGroupNone, []LatencyBucket{
+			{Group: "", Count: 12, TTFT: LatencyPercentiles{P50: 400, P95: 1000}, Total: LatencyPercentiles{P50: 4000, P95: 10000}},
+		}},
+		{LatencyGroupModel, []LatencyBucket{
+			{Group: "gpt", Count: 10, TTFT: LatencyPercentiles{P50: 500, P95: 1000}, Total: LatencyPercentiles{P50: 5000, P95: 10000}},
+			{Group: "mini", Count: 2, TTFT: LatencyPercentiles{P50: 50, P95: 70}, Total: LatencyPercentiles{P50: 500, P95: 700}},
+		}},
+		{LatencyGroupDay, []LatencyBucket{
+			{Group: "2026-03-01", Count: 10, TTFT: LatencyPercentiles{P50: 500, P95: 1000}, Total: LatencyPercentiles{P50: 5000, P95: 10000}},
+			{Group: "2026-03-02", Count: 2, TTFT: LatencyPercentiles{P50: 50, P95: 70}, Total: LatencyPercentiles{P50: 500, P95: 700}},
+		}},
+	}
+	for _, tt := range tests {
+		summary, err := LatencyStats(ctx, conn, "client-1", from, to, tt.groupBy)
+		if err != nil {
+			t.Fatalf("group_by %q: LatencyStats failed: %v", tt.groupBy, err)
+		}
+		if len(summary.Buckets) != len(tt.want) {
+			t.Fatalf("group_by %q: expected %+v, got %+v", tt.groupBy, tt.want, summary.Buckets)
+		}
+		for i, bucket := range summary.Buckets {
+			if bucket != tt.want[i] {
+				t.Errorf("group_by %q: expected %+v, got %+v", tt.groupBy, tt.want[i], bucket)
+			}
+		}
+	}
+
+	if _, err := LatencyStats(ctx, conn, "client-1", from, to, "user"); err == nil || !strings.Contains(err.Error(), "group_by") {
+		t.Errorf("Expected an unsupported grouping to fail, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxConcurrentStreams is used when a client has no limit configured
+	DefaultMaxConcurrentStreams = 3
+	// DefaultMaxQueuedStreams is how many requests may wait per client
+	DefaultMaxQueuedStreams = 10
+	// DefaultQueueTimeout is how long a request may wait for a stream slot
+	DefaultQueueTimeout = 60 * time.Second
+)
+
+var (
+	// ErrQueueFull is returned when a client's stream queue is at capacity
+	ErrQueueFull = errors.New("too many queued requests for this client")
+	// ErrQueueTimeout is returned when a queued request did not get a slot in time
+	ErrQueueTimeout = errors.New("timed out waiting for a free stream slot")
+)
+
+// StreamLimiter caps concurrent LLM streams per client. Requests over the limit
+// wait in a bounded per-client FIFO queue until a slot frees up or they time out.
+type StreamLimiter struct {
+	mutex         sync.Mutex
+	clients       map[string]*clientStreams
+	maxQueueDepth int
+	queueTimeout  time.Duration
+
+	// cooldowns holds when clients paused by Cooldown may stream again
+	cooldowns map[string]time.Time
+	now       func() time.Time
+}
+
+type clientStreams struct {
+	active int
+	queue  []*queuedStream
+}
+
+type queuedStream struct {
+	ready    chan struct{}
+	granted  bool
+	onQueued func(position int)
+}
+
+// NewStreamLimiter creates a limiter; non-positive values fall back to the defaults
+func NewStreamLimiter(maxQueueDepth int, queueTimeout time.Duration) *StreamLimiter {
+	if maxQueueDepth <= 0 {
+		maxQueueDepth = DefaultMaxQueuedStreams
+	}
+	if queueTimeout <= 0 {
+		queueTimeout = DefaultQueueTimeout
+	}
+	return &StreamLimiter{
+		clients:       make(map[string]*clientStreams),
+		maxQueueDepth: maxQueueDepth,
+		queueTimeout:  queueTimeout,
+		cooldowns:     make(map[string]time.Time),
+		now:           time.Now,
+	}
+}
+
+// Cooldown refuses new streams for the client for d, such as while its LLM
+// provider is rate limiting it. A longer cooldown already running is kept.
+func (l *StreamLimiter) Cooldown(clientID string, d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	until := l.now().Add(d)
+	if until.After(l.cooldowns[clientID]) {
+		l.cooldowns[clientID] = until
+	}
+}
+
+// CooldownRemaining returns how long the client's cooldown has left, zero when
+// it has none
+func (l *StreamLimiter) CooldownRemaining(clientID string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	until, exists := l.cooldowns[clientID]
+	if !exists {
+		return 0
+	}
+	remaining := until.Sub(l.now())
+	if remaining <= 0 {
+		delete(l.cooldowns, clientID)
+		return 0
+	}
+	return remaining
+}
+
+// Acquire takes a stream slot for the client, waiting in the queue if all slots are busy.
+// onQueued is called with the 1-based queue position when the request is queued and
+// again whenever it moves up. The returned release func must be called exactly when
+// the stream ends, however it ends; calling it more than once is safe.
+func (l *StreamLimiter) Acquire(ctx context.Context, clientID string, limit int, onQueued func(position int)) (func(), error) {
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentStreams
+	}
+
+	l.mutex.Lock()
+	streams, exists := l.clients[clientID]
+	if !exists {
+		streams = &clientStreams{}
+		l.clients[clientID] = streams
+	}
+
+	if streams.active < limit && len(streams.queue) == 0 {
+		streams.active++
+		l.mutex.Unlock()
+		return l.releaseFunc(clientID, limit), nil
+	}
+
+	if len(streams.queue) >= l.maxQueueDepth {
+		l.mutex.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	waiter := &queuedStream{ready: make(chan struct{}), onQueued: onQueued}
+	streams.queue = append(streams.queue, waiter)
+	position := len(streams.queue)
+	l.mutex.Unlock()
+
+	if onQueued != nil {
+		onQueued(position)
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter.ready:
+		return l.releaseFunc(clientID, limit), nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mutex.Lock()
+	if waiter.granted {
+		// A slot was handed over while we were timing out; keep it
+		l.mutex.Unlock()
+		return l.releaseFunc(clientID, limit), nil
+	}
+	moved, start := l.removeWaiterLocked(clientID, waiter)
+	l.mutex.Unlock()
+	notifyPositions(moved, start)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrQueueTimeout
+}
+
+// Stats returns the active and queued stream counts for a client
+func (l *StreamLimiter) Stats(clientID string) (active, queued int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if streams, exists := l.clients[clientID]; exists {
+		return streams.active, len(streams.queue)
+	}
+	return 0, 0
+}
+
+// Totals returns the active and queued stream counts across all clients
+func (l *StreamLimiter) Totals() (active, queued int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, streams := range l.clients {
+		active += streams.active
+		queued += len(streams.queue)
+	}
+	return active, queued
+}
+
+func (l *StreamLimiter) releaseFunc(clientID string, limit int) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(clientID, limit) })
+	}
+}
+
+// release frees a slot and hands it to the next queued request
+func (l *StreamLimiter) release(clientID string, limit int) {
+	l.mutex.Lock()
+	streams, exists := l.clients[clientID]
+	if !exists {
+		l.mutex.Unlock()
+		return
+	}
+
+	streams.active--
+	var moved []*queuedStream
+	for streams.active < limit && len(streams.queue) > 0 {
+		next := streams.queue[0]
+		streams.queue = streams.queue[1:]
+		next.granted = true
+		streams.active++
+		close(next.ready)
+		moved = streams.queue
+	}
+	moved = append([]*queuedStream(nil), moved...)
+
+	if streams.active <= 0 && len(streams.queue) == 0 {
+		delete(l.clients, clientID)
+	}
+	l.mutex.Unlock()
+
+	notifyPositions(moved, 1)
+}
+
+// removeWaiterLocked drops a waiter that gave up and returns the waiters behind it
+// along with the new position of the first of them
+func (l *StreamLimiter) removeWaiterLocked(clientID string, waiter *queuedStream) ([]*queuedStream, int) {
+	streams, exists := l.clients[clientID]
+	if !exists {
+		return nil, 0
+	}
+
+	for i, w := range streams.queue {
+		if w == waiter {
+			streams.queue = append(streams.queue[:i], streams.queue[i+1:]...)
+			behind := append([]*queuedStream(nil), streams.queue[i:]...)
+			if streams.active <= 0 && len(streams.queue) == 0 {
+				delete(l.clients, clientID)
+			}
+			return behind, i + 1
+		}
+	}
+	return nil, 0
+}
+
+// notifyPositions tells waiters their new queue position, starting at start. Positions
+// come from a snapshot, so a notification may briefly lag a concurrent change.
+func notifyPositions(waiters []*queuedStream, start int) {
+	for i, w := range waiters {
+		if w.onQueued != nil {
+			w.onQueued(start + i)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/tools"
+)
+
+// gatedLLMClient blocks every stream until the gate is closed and tracks peak concurrency
+type gatedLLMClient struct {
+	gate    chan struct{}
+	started chan struct{}
+	active  int32
+	peak    int32
+}
+
+func (g *gatedLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	n := atomic.AddInt32(&g.active, 1)
+	defer atomic.AddInt32(&g.active, -1)
+	for {
+		peak := atomic.LoadInt32(&g.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&g.peak, peak, n) {
+			break
+		}
+	}
+	g.started <- struct{}{}
+	<-g.gate
+	return callback(&llm.StreamingChunk{Content: "ok", Done: true})
+}
+
+func (g *gatedLLMClient) Chat(ctx context.Context, req *llm.LLMRequest) (*llm.LLMResponse, error) {
+	return &llm.LLMResponse{}, nil
+}
+
+func (g *gatedLLMClient) SetModel(model string) error { return nil }
+
+func (g *gatedLLMClient) GetModel() string { return "gated" }
+
+func TestStreamLimiterQueuesInOrder(t *testing.T) {
+	limiter := NewStreamLimiter(5, time.Second)
+	ctx := context.Background()
+
+	release1, err := limiter.Acquire(ctx, "client-1", 1, nil)
+	if err != nil {
+		t.Fatalf("First acquire failed: %v", err)
+	}
+
+	var order []int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		queued := make(chan struct{})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var once sync.Once
+			release, err := limiter.Acquire(ctx, "client-1", 1, func(int) { once.Do(func() { close(queued) }) })
+			if err != nil {
+				t.Errorf("Queued acquire %d failed: %v", i, err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+			release()
+		}(i)
+		<-queued // enqueue strictly in order
+	}
+
+	if active, queued := limiter.Stats("client-1"); active != 1 || queued != 3 {
+		t.Errorf("Expected 1 active and 3 queued, got %d and %d", active, queued)
+	}
+
+	release1()
+	release1() // releasing twice must not free a second slot
+	wg.Wait()
+
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Errorf("Expected FIFO order, got %v", order)
+	}
+	if active, queued := limiter.Stats("client-1"); active != 0 || queued != 0 {
+		t.Errorf("Expected limiter to be empty, got %d active and %d queued", active, queued)
+	}
+}
+
+func TestStreamLimiterQueueFullAndTimeout(t *testing.T) {
+	limiter := NewStreamLimiter(1, 50*time.Millisecond)
+	ctx := context.Background()
+
+	release, _ := limiter.Acquire(ctx, "client-1", 1, nil)
+	defer release()
+
+	// Another client is not affected by client-1's limit
+	otherRelease, err := limiter.Acquire(ctx, "client-2", 1, nil)
+	if err != nil {
+		t.Fatalf("Expected independent limit per client, got %v", err)
+	}
+	otherRelease()
+
+	timedOut := make(chan error, 1)
+	queued := make(chan struct{}, 1)
+	go func() {
+		_, err := limiter.Acquire(ctx, "client-1", 1, func(int) { queued <- struct{}{} })
+		timedOut <- err
+	}()
+	<-queued
+
+	if _, err := limiter.Acquire(ctx, "client-1", 1, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if err := <-timedOut; !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("Expected ErrQueueTimeout, got %v", err)
+	}
+	if _, queuedCount := limiter.Stats("client-1"); queuedCount != 0 {
+		t.Errorf("Timed out request should leave the queue, %d still queued", queuedCount)
+	}
+}
+
+func setupLimiterService(t *testing.T, client llm.LLMClient, conversations int) (*chatService, *recordingHub, tools.DBConnection) {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	for i := 1; i <= conversations; i++ {
+		insertConversation(t, conn, fmt.Sprintf("conv-%d", i), nil)
+	}
+	hub := &recordingHub{connections: map[string]bool{}}
+	return NewChatService(conn, hub, client, tools.NewToolRegistry()), hub, conn
+}
+
+func limitedRequest(i int) *ChatRequest {
+	return &ChatRequest{
+		ConversationID:       fmt.Sprintf("conv-%d", i),
+		Content:              "hi",
+		UserID:               "user-1",
+		ProjectID:            "project-1",
+		ClientID:             "client-1",
+		MaxConcurrentStreams: 2,
+	}
+}
+
+func TestProcessUserMessageStressRespectsClientLimit(t *testing.T) {
+	const requests = 6
+	client := &gatedLLMClient{gate: make(chan struct{}), started: make(chan struct{}, requests)}
+	service, hub, _ := setupLimiterService(t, client, requests)
+	service.SetStreamLimiter(NewStreamLimiter(requests, 5*time.Second))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 1; i <= requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- service.WithLLMClient(client).ProcessUserMessage(limitedRequest(i))
+		}(i)
+	}
+
+	// Two streams start, the rest wait in the queue
+	<-client.started
+	<-client.started
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, queued := service.streamLimiter.Stats("client-1")
+		if queued == requests-2 && len(hub.eventsOfType("message_queued")) >= requests-2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for requests to queue")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	queuedEvents := hub.eventsOfType("message_queued")
+	if len(queuedEvents) < requests-2 {
+		t.Errorf("Expected at least %d message_queued events, got %d", requests-2, len(queuedEvents))
+	}
+	for _, e := range queuedEvents {
+		if position, _ := e.Data["queue_position"].(float64); position < 1 || position > requests-2 {
+			t.Errorf("Unexpected queue position %v", e.Data["queue_position"])
+		}
+	}
+
+	close(client.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Request failed: %v", err)
+		}
+	}
+
+	if peak := atomic.LoadInt32(&client.peak); peak != 2 {
+		t.Errorf("Expected at most 2 concurrent streams, peak was %d", peak)
+	}
+	if active, queued := service.streamLimiter.Stats("client-1"); active != 0 || queued != 0 {
+		t.Errorf("Expected all slots to be released, got %d active and %d queued", active, queued)
+	}
+}
+
+func TestProcessUserMessageQueueTimeoutResetsStatus(t *testing.T) {
+	client := &gatedLLMClient{gate: make(chan struct{}), started: make(chan struct{}, 3)}
+	service, _, conn := setupLimiterService(t, client, 3)
+	service.SetStreamLimiter(NewStreamLimiter(5, 50*time.Millisecond))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			service.ProcessUserMessage(limitedRequest(i))
+		}(i)
+		<-client.started
+	}
+
+	if err := service.ProcessUserMessage(limitedRequest(3)); !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("Expected ErrQueueTimeout, got %v", err)
+	}
+	var status string
+	if err := conn.QueryRow(context.Background(), "SELECT status FROM conversations WHERE id = 'conv-3'").Scan(&status); err != nil {
+		t.Fatalf("Failed to read status: %v", err)
+	}
+	if status != "interrupted" {
+		t.Errorf("Expected status to be reset to interrupted, got %q", status)
+	}
+
+	close(client.gate)
+	wg.Wait()
+}
+
+func TestProcessUserMessageReleasesSlotOnFailure(t *testing.T) {
+	service, _, _ := setupLimiterService(t, &failingLLMClient{}, 1)
+
+	if err := service.ProcessUserMessage(limitedRequest(1)); err == nil {
+		t.Fatal("Expected the stream to fail")
+	}
+	if active, queued := service.streamLimiter.Stats("client-1"); active != 0 || queued != 0 {
+		t.Errorf("Expected slot to be released after failure, got %d active and %d queued", active, queued)
+	}
+}
+
+// failingLLMClient fails every stream
+type failingLLMClient struct{ fakeLLMClient }
+
+func (f *failingLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	return errors.New("upstream unavailable")
+}
+
+// rateLimitedLLMClient is refused every stream by a rate-limiting provider
+type rateLimitedLLMClient struct {
+	fakeLLMClient
+	calls int32
+}
+
+func (f *rateLimitedLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	atomic.AddInt32(&f.calls, 1)
+	return &llm.RateLimitedError{RetryAfter: 5 * time.Second, Err: errors.New("429 Too Many Requests")}
+}
+
+func TestStreamLimiterCooldownKeepsTheLongest(t *testing.T) {
+	limiter := NewStreamLimiter(5, time.Second)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	limiter.Cooldown("client-1", 10*time.Second)
+	limiter.Cooldown("client-1", 2*time.Second)
+	if remaining := limiter.CooldownRemaining("client-1"); remaining != 10*time.Second {
+		t.Errorf("Expected the longer cooldown to be kept, got %s", remaining)
+	}
+	if remaining := limiter.CooldownRemaining("client-2"); remaining != 0 {
+		t.Errorf("Expected other clients not to cool down, got %s", remaining)
+	}
+
+	now = now.Add(11 * time.Second)
+	if remaining := limiter.CooldownRemaining("client-1"); remaining != 0 {
+		t.Errorf("Expected the cooldown to be over, got %s", remaining)
+	}
+}
+
+func TestProcessUserMessageRateLimited(t *testing.T) {
+	for _, cooldown := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cooldown %t", cooldown), func(t *testing.T) {
+			client := &rateLimitedLLMClient{}
+			service, hub, conn := setupLimiterService(t, client, 2)
+			service.SetStreamLimiter(NewStreamLimiter(5, time.Second))
+			service.SetRateLimitCooldown(cooldown)
+			before := metrics.Counter(MetricLLMRateLimited).Snapshot()["client-1"].Count
+
+			var rateLimited *llm.RateLimitedError
+			if err := service.ProcessUserMessage(limitedRequest(1)); !errors.As(err, &rateLimited) {
+				t.Fatalf("Expected a RateLimitedError, got %v", err)
+			}
+			var status string
+			if err := conn.QueryRow(context.Background(), "SELECT status FROM conversations WHERE id = 'conv-1'").Scan(&status); err != nil {
+				t.Fatalf("Failed to read status: %v", err)
+			}
+			if status != "completed" {
+				t.Errorf("Expected status completed, got %q", status)
+			}
+			events := hub.eventsOfType("rate_limited")
+			if len(events) != 1 || events[0].Data["conversation_id"] != "conv-1" || events[0].Data["retry_after_seconds"] != float64(5) {
+				t.Fatalf("Expected one rate_limited event with the retry delay, got %+v", events)
+			}
+			if after := metrics.Counter(MetricLLMRateLimited).Snapshot()["client-1"].Count; after != before+1 {
+				t.Errorf("Expected the rate limit to be counted, got %d then %d", before, after)
+			}
+
+			// A cooldown refuses the client's next message without calling the provider
+			err := service.ProcessUserMessage(limitedRequest(2))
+			if !errors.As(err, &rateLimited) {
+				t.Fatalf("Expected a RateLimitedError, got %v", err)
+			}
+			wantCalls := int32(2)
+			if cooldown {
+				wantCalls = 1
+				if seconds := rateLimited.RetryAfterSeconds(); seconds < 1 || seconds > 5 {
+					t.Errorf("Expected the remaining cooldown, got %d seconds", seconds)
+				}
+			}
+			if calls := atomic.LoadInt32(&client.calls); calls != wantCalls {
+				t.Errorf("Expected %d provider calls, got %d", wantCalls, calls)
+			}
+			if events := hub.eventsOfType("rate_limited"); len(events) != 2 {
+				t.Errorf("Expected a rate_limited event for each message, got %d", len(events))
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"zlay-backend/internal/tools"
+)
+
+// MessageSchemaVersion is the version of the metadata and tool_calls JSON
+// written to messages. Rows without it were written before it was introduced.
+const MessageSchemaVersion = 1
+
+// maxJSONEncodings is how many layers of string encoding are unwrapped from a
+// stored value before it is considered malformed
+const maxJSONEncodings = 3
+
+// ErrMalformedMessageJSON is returned when a stored metadata or tool_calls
+// value cannot be decoded
+var ErrMalformedMessageJSON = errors.New("malformed message JSON")
+
+// MessageMetadata is the metadata of a message. Stored metadata also carries
+// schema_version, which is removed when it is decoded.
+type MessageMetadata map[string]interface{}
+
+// persistedToolCall is a tool call as stored in messages.tool_calls
+type persistedToolCall struct {
+	SchemaVersion int `json:"schema_version"`
+	ToolCall
+}
+
+// MarshalMetadata encodes metadata for the messages table; nil is stored as
+// an empty object
+func MarshalMetadata(metadata MessageMetadata) ([]byte, error) {
+	stored := make(map[string]interface{}, len(metadata)+1)
+	for key, value := range metadata {
+		stored[key] = value
+	}
+	stored["schema_version"] = MessageSchemaVersion
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return encoded, nil
+}
+
+// UnmarshalMetadata decodes stored metadata. NULL decodes to empty metadata.
+// legacy reports a value stored without schema_version, as JSON null or
+// string-encoded one or more times, which MarshalMetadata would write differently.
+func UnmarshalMetadata(raw []byte) (metadata MessageMetadata, legacy bool, err error) {
+	value, legacy, err := unwrapStoredJSON(raw)
+	if err != nil {
+		return MessageMetadata{}, false, fmt.Errorf("%w: metadata %v", ErrMalformedMessageJSON, err)
+	}
+	if value == nil {
+		return MessageMetadata{}, legacy, nil
+	}
+	if value[0] != '{' {
+		return MessageMetadata{}, false, fmt.Errorf("%w: metadata is %s, not an object", ErrMalformedMessageJSON, jsonKind(value))
+	}
+
+	metadata = MessageMetadata{}
+	if err := json.Unmarshal(value, &metadata); err != nil {
+		return MessageMetadata{}, false, fmt.Errorf("%w: metadata: %v", ErrMalformedMessageJSON, err)
+	}
+	if _, ok := metadata["schema_version"]; !ok {
+		legacy = true
+	}
+	delete(metadata, "schema_version")
+	return metadata, legacy, nil
+}
+
+// MarshalToolCalls encodes tool calls for the messages table; nil is stored
+// as an empty array
+func MarshalToolCalls(calls []ToolCall) ([]byte, error) {
+	stored := make([]persistedToolCall, len(calls))
+	for i, call := range calls {
+		stored[i] = persistedToolCall{SchemaVersion: MessageSchemaVersion, ToolCall: call}
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tool calls: %w", err)
+	}
+	return encoded, nil
+}
+
+// UnmarshalToolCalls decodes stored tool calls. NULL decodes to none. legacy
+// reports a value that MarshalToolCalls would write differently, as for
+// UnmarshalMetadata.
+func UnmarshalToolCalls(raw []byte) (calls []ToolCall, legacy bool, err error) {
+	value, legacy, err := unwrapStoredJSON(raw)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: tool_calls %v", ErrMalformedMessageJSON, err)
+	}
+	if value == nil {
+		return nil, legacy, nil
+	}
+	if value[0] != '[' {
+		return nil, false, fmt.Errorf("%w: tool_calls is %s, not an array", ErrMalformedMessageJSON, jsonKind(value))
+	}
+
+	var stored []persistedToolCall
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, false, fmt.Errorf("%w: tool_calls: %v", ErrMalformedMessageJSON, err)
+	}
+	calls = make([]ToolCall, len(stored))
+	for i, call := range stored {
+		if call.SchemaVersion == 0 {
+			legacy = true
+		}
+		calls[i] = call.ToolCall
+	}
+	return calls, legacy, nil
+}
+
+// unwrapStoredJSON returns the JSON value of a stored column, nil for NULL and
+// JSON null. Values stored as JSON strings holding JSON are unwrapped and
+// reported as legacy, as are JSON null and an empty value.
+func unwrapStoredJSON(raw []byte) (json.RawMessage, bool, error) {
+	if raw == nil {
+		return nil, false, nil
+	}
+	value := bytes.TrimSpace(raw)
+	legacy := false
+	for encodings := 0; ; encodings++ {
+		if len(value) == 0 || bytes.Equal(value, []byte("null")) {
+			return nil, true, nil
+		}
+		if !json.Valid(value) {
+			return nil, false, errors.New("is not valid JSON")
+		}
+		if value[0] != '"' {
+			return value, legacy, nil
+		}
+		if encodings == maxJSONEncodings {
+			return nil, false, fmt.Errorf("is string-encoded more than %d times", maxJSONEncodings)
+		}
+		var inner string
+		if err := json.Unmarshal(value, &inner); err != nil {
+			return nil, false, err
+		}
+		value = bytes.TrimSpace([]byte(inner))
+		legacy = true
+	}
+}
+
+// jsonKind names the type of a valid JSON value for error messages
+func jsonKind(value json.RawMessage) string {
+	switch value[0] {
+	case '{':
+		return "an object"
+	case '[':
+		return "an array"
+	case '"':
+		return "a string"
+	case 't', 'f':
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+// decodeMessageJSON fills msg.Metadata and msg.ToolCalls from their stored
+// JSON. Values that cannot be decoded are logged with the message ID and left
+// empty. It reports whether the row should be rewritten: both values decoded
+// and at least one was stored in a legacy shape.
+func decodeMessageJSON(msg *Message, metadata, toolCalls []byte) bool {
+	var metadataLegacy, toolCallsLegacy bool
+	var metadataErr, toolCallsErr error
+	msg.Metadata, metadataLegacy, metadataErr = UnmarshalMetadata(metadata)
+	if metadataErr != nil {
+		log.Printf("Message %s: %v", msg.ID, metadataErr)
+	}
+	msg.ToolCalls, toolCallsLegacy, toolCallsErr = UnmarshalToolCalls(toolCalls)
+	if toolCallsErr != nil {
+		log.Printf("Message %s: %v", msg.ID, toolCallsErr)
+	}
+	return metadataErr == nil && toolCallsErr == nil && (metadataLegacy || toolCallsLegacy)
+}
+
+// DecodeMessageJSON is decodeMessageJSON for readers outside the package that
+// scan messages themselves
+func DecodeMessageJSON(msg *Message, metadata, toolCalls []byte) (legacy bool) {
+	return decodeMessageJSON(msg, metadata, toolCalls)
+}
+
+// RewriteMessageJSON stores a message's decoded metadata and tool calls again
+// in the current schema
+func RewriteMessageJSON(ctx context.Context, db tools.DBConnection, msg *Message) error {
+	metadata, err := MarshalMetadata(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	toolCalls, err := MarshalToolCalls(msg.ToolCalls)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, "UPDATE messages SET metadata = $1, tool_calls = $2 WHERE id = $3",
+		metadata, toolCalls, msg.ID); err != nil {
+		return fmt.Errorf("failed to rewrite message %s: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// SetMessageJSONMigration makes readers rewrite messages whose metadata or
+// tool_calls were stored in a legacy shape the first time they are read
+func (s *chatService) SetMessageJSONMigration(enabled bool) {
+	s.migrateMessageJSON = enabled
+}
+
+// migrateLegacyMessages rewrites messages read in a legacy shape when
+// SetMessageJSONMigration is enabled. Failures are logged; the messages have
+// already been decoded for the reader.
+func (s *chatService) migrateLegacyMessages(ctx context.Context, legacy []*Message) {
+	if !s.migrateMessageJSON {
+		return
+	}
+	for _, msg := range legacy {
+		if err := RewriteMessageJSON(ctx, s.db, msg); err != nil {
+			log.Printf("Failed to migrate message JSON: %v", err)
+		}
+	}
+}
+
+// DefaultRepairBatchSize is how many messages RepairMessageJSON reads at a time
+const DefaultRepairBatchSize = 500
+
+// MessageJSONReport counts what RepairMessageJSON found
+type MessageJSONReport struct {
+	Scanned   int `json:"scanned"`
+	Legacy    int `json:"legacy"`    // Decoded but stored in a legacy shape
+	Malformed int `json:"malformed"` // Metadata or tool_calls could not be decoded
+	Repaired  int `json:"repaired"`  // Rewritten in the current schema
+	// IDs of the malformed messages
+	MalformedIDs []string `json:"malformed_ids,omitempty"`
+}
+
+// RepairMessageJSON scans every message for metadata and tool_calls that are
+// malformed or stored in a legacy shape. With fix, legacy rows are rewritten
+// in the current schema, and a value that cannot be decoded is replaced with
+// an empty one, its raw text kept in metadata under malformed_metadata or
+// malformed_tool_calls. Without fix nothing is written.
+func RepairMessageJSON(ctx context.Context, db tools.DBConnection, fix bool, batchSize int) (*MessageJSONReport, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultRepairBatchSize
+	}
+
+	report := &MessageJSONReport{}
+	after := ""
+	for {
+		batch, err := readRepairBatch(ctx, db, after, batchSize)
+		if err != nil {
+			return report, err
+		}
+		for _, row := range batch {
+			report.Scanned++
+			msg := &Message{ID: row.id}
+			var metadataLegacy, toolCallsLegacy bool
+			var metadataErr, toolCallsErr error
+			msg.Metadata, metadataLegacy, metadataErr = UnmarshalMetadata(row.metadata)
+			msg.ToolCalls, toolCallsLegacy, toolCallsErr = UnmarshalToolCalls(row.toolCalls)
+
+			switch {
+			case metadataErr != nil || toolCallsErr != nil:
+				report.Malformed++
+				report.MalformedIDs = append(report.MalformedIDs, row.id)
+				if metadataErr != nil {
+					log.Printf("Message %s: %v", row.id, metadataErr)
+					msg.Metadata = MessageMetadata{"malformed_metadata": string(row.metadata)}
+				}
+				if toolCallsErr != nil {
+					log.Printf("Message %s: %v", row.id, toolCallsErr)
+					msg.Metadata["malformed_tool_calls"] = string(row.toolCalls)
+				}
+			case metadataLegacy || toolCallsLegacy:
+				report.Legacy++
+			default:
+				continue
+			}
+
+			if fix {
+				if err := RewriteMessageJSON(ctx, db, msg); err != nil {
+					return report, err
+				}
+				report.Repaired++
+			}
+		}
+		if len(batch) < batchSize {
+			return report, nil
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+// repairRow is the stored JSON of one message
+type repairRow struct {
+	id        string
+	metadata  []byte
+	toolCalls []byte
+}
+
+// readRepairBatch reads up to limit messages with IDs after the given one, in ID order
+func readRepairBatch(ctx context.Context, db tools.DBConnection, after string, limit int) ([]repairRow, error) {
+	// IDs are UUIDs on PostgreSQL, so the first batch has no lower bound rather than ''
+	query, args := "SELECT id, metadata, tool_calls FROM messages ORDER BY id ASC LIMIT $1", []interface{}{limit}
+	if after != "" {
+		query, args = "SELECT id, metadata, tool_calls FROM messages WHERE id > $1 ORDER BY id ASC LIMIT $2", []interface{}{after, limit}
+	}
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []repairRow
+	for rows.Next() {
+		var row repairRow
+		if err := rows.Scan(&row.id, &row.metadata, &row.toolCalls); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		batch = append(batch, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return batch, nil
+}
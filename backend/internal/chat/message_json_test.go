@@ -0,0 +1,266 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Stored metadata in every shape earlier versions and broken writers left behind
+var metadataFixtures = []struct {
+	name   string
+	raw    []byte
+	want   string // "model" after decoding, "" for none
+	legacy bool
+	err    bool
+}{
+	{"NULL", nil, "", false, false},
+	{"current", []byte(`{"model":"gpt-4o","schema_version":1}`), "gpt-4o", false, false},
+	{"unversioned object", []byte(`{"model":"gpt-4o"}`), "gpt-4o", true, false},
+	{"empty object", []byte(`{}`), "", true, false},
+	{"JSON null", []byte(`null`), "", true, false},
+	{"empty", []byte(``), "", true, false},
+	{"whitespace", []byte("  \n"), "", true, false},
+	{"string null", []byte(`"null"`), "", true, false},
+	{"double-encoded", []byte(`"{\"model\":\"gpt-4o\"}"`), "gpt-4o", true, false},
+	{"triple-encoded", []byte(`"\"{\\\"model\\\":\\\"gpt-4o\\\"}\""`), "gpt-4o", true, false},
+	{"invalid JSON", []byte(`{"model":`), "", false, true},
+	{"double-encoded invalid JSON", []byte(`"{\"model\":"`), "", false, true},
+	{"array", []byte(`[{"model":"gpt-4o"}]`), "", false, true},
+	{"number", []byte(`42`), "", false, true},
+	{"plain string", []byte(`"gpt-4o"`), "", false, true},
+}
+
+func TestUnmarshalMetadataFixtures(t *testing.T) {
+	for _, tt := range metadataFixtures {
+		metadata, legacy, err := UnmarshalMetadata(tt.raw)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if err != nil && !errors.Is(err, ErrMalformedMessageJSON) {
+			t.Errorf("%s: expected ErrMalformedMessageJSON, got %v", tt.name, err)
+		}
+		if legacy != tt.legacy {
+			t.Errorf("%s: expected legacy %v, got %v", tt.name, tt.legacy, legacy)
+		}
+		if metadata == nil {
+			t.Errorf("%s: expected empty metadata rather than nil", tt.name)
+		}
+		if model, _ := metadata["model"].(string); model != tt.want {
+			t.Errorf("%s: expected model %q, got %v", tt.name, tt.want, metadata)
+		}
+		if _, ok := metadata["schema_version"]; ok {
+			t.Errorf("%s: expected schema_version to be removed, got %v", tt.name, metadata)
+		}
+	}
+}
+
+// Stored tool_calls in every shape earlier versions and broken writers left behind
+var toolCallFixtures = []struct {
+	name   string
+	raw    []byte
+	want   int
+	legacy bool
+	err    bool
+}{
+	{"NULL", nil, 0, false, false},
+	{"current", []byte(`[{"schema_version":1,"id":"call-1","type":"function","function":{"name":"system_info","arguments":{}},"status":"completed","result":{"ok":true}}]`), 1, false, false},
+	{"current empty", []byte(`[]`), 0, false, false},
+	{"unversioned", []byte(`[{"id":"call-1","type":"function","function":{"name":"system_info","arguments":{}}}]`), 1, true, false},
+	{"partly versioned", []byte(`[{"schema_version":1,"id":"call-1"},{"id":"call-2"}]`), 2, true, false},
+	{"JSON null", []byte(`null`), 0, true, false},
+	{"empty", []byte(``), 0, true, false},
+	{"string null", []byte(`"null"`), 0, true, false},
+	{"double-encoded", []byte(`"[{\"id\":\"call-1\"}]"`), 1, true, false},
+	{"triple-encoded", []byte(`"\"[{\\\"id\\\":\\\"call-1\\\"}]\""`), 1, true, false},
+	{"invalid JSON", []byte(`[{"id":"call-1"`), 0, false, true},
+	{"object", []byte(`{"id":"call-1"}`), 0, false, true},
+	{"result of the wrong type", []byte(`[{"id":"call-1","result":"done"}]`), 0, false, true},
+	{"encoded too often", []byte(`"\"\\\"\\\\\\\"[]\\\\\\\"\\\"\""`), 0, false, true},
+}
+
+func TestUnmarshalToolCallsFixtures(t *testing.T) {
+	for _, tt := range toolCallFixtures {
+		calls, legacy, err := UnmarshalToolCalls(tt.raw)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+		if err != nil && !errors.Is(err, ErrMalformedMessageJSON) {
+			t.Errorf("%s: expected ErrMalformedMessageJSON, got %v", tt.name, err)
+		}
+		if legacy != tt.legacy {
+			t.Errorf("%s: expected legacy %v, got %v", tt.name, tt.legacy, legacy)
+		}
+		if len(calls) != tt.want {
+			t.Errorf("%s: expected %d tool calls, got %+v", tt.name, tt.want, calls)
+		}
+	}
+}
+
+func TestMessageJSONRoundTrip(t *testing.T) {
+	metadata, err := MarshalMetadata(MessageMetadata{"model": "gpt-4o"})
+	if err != nil {
+		t.Fatalf("MarshalMetadata failed: %v", err)
+	}
+	if decoded, legacy, err := UnmarshalMetadata(metadata); err != nil || legacy || decoded["model"] != "gpt-4o" {
+		t.Errorf("Expected metadata to round-trip as current, got %v %v %v", decoded, legacy, err)
+	}
+	if empty, _ := MarshalMetadata(nil); string(empty) != `{"schema_version":1}` {
+		t.Errorf("Expected nil metadata to store the version alone, got %s", empty)
+	}
+
+	calls := []ToolCall{*NewToolCall("call-1", "function", "system_info", map[string]interface{}{})}
+	calls[0].Result = map[string]interface{}{"ok": true}
+	toolCalls, err := MarshalToolCalls(calls)
+	if err != nil {
+		t.Fatalf("MarshalToolCalls failed: %v", err)
+	}
+	var stored []map[string]interface{}
+	if err := json.Unmarshal(toolCalls, &stored); err != nil || len(stored) != 1 || stored[0]["schema_version"] != float64(1) || stored[0]["id"] != "call-1" {
+		t.Errorf("Expected a flat versioned tool call, got %s", toolCalls)
+	}
+	if decoded, legacy, err := UnmarshalToolCalls(toolCalls); err != nil || legacy || len(decoded) != 1 || decoded[0].Result["ok"] != true {
+		t.Errorf("Expected tool calls to round-trip as current, got %+v %v %v", decoded, legacy, err)
+	}
+	if empty, _ := MarshalToolCalls(nil); string(empty) != `[]` {
+		t.Errorf("Expected nil tool calls to store an empty array, got %s", empty)
+	}
+}
+
+func TestGetConversationMigratesLegacyMessageJSON(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		conn := setupParticipantsDB(t)
+		ctx := context.Background()
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conv-1', 'Legacy', 'user-1', 'project-1', 'completed', $1, $1)",
+			time.Now().UTC()); err != nil {
+			t.Fatalf("Failed to insert conversation: %v", err)
+		}
+		stored := map[string][2]string{
+			"m-current":   {`{"schema_version":1}`, `[]`},
+			"m-legacy":    {`"{\"model\":\"gpt-4o\"}"`, `[{"id":"call-1","type":"function","function":{"name":"system_info","arguments":{}}}]`},
+			"m-malformed": {`{"model":`, `[]`},
+		}
+		for id, values := range stored {
+			if _, err := conn.Exec(ctx,
+				"INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at) VALUES ($1, 'conv-1', 'assistant', 'hi', $2, $3, $4)",
+				id, values[0], values[1], time.Now().UTC()); err != nil {
+				t.Fatalf("Failed to insert message: %v", err)
+			}
+		}
+
+		service := &chatService{db: conn, llmClient: &scriptedLLMClient{}}
+		service.SetMessageJSONMigration(enabled)
+		details, err := service.GetConversation("conv-1", "user-1")
+		if err != nil {
+			t.Fatalf("GetConversation failed: %v", err)
+		}
+		for _, msg := range details.Messages {
+			switch msg.ID {
+			case "m-legacy":
+				if msg.Metadata["model"] != "gpt-4o" || len(msg.ToolCalls) != 1 {
+					t.Errorf("Expected the legacy message to decode, got %v %+v", msg.Metadata, msg.ToolCalls)
+				}
+			case "m-malformed":
+				if len(msg.Metadata) != 0 {
+					t.Errorf("Expected malformed metadata to read as empty, got %v", msg.Metadata)
+				}
+			}
+		}
+
+		var metadata, toolCalls string
+		if err := conn.QueryRow(ctx, "SELECT metadata, tool_calls FROM messages WHERE id = 'm-legacy'").Scan(&metadata, &toolCalls); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		_, metadataLegacy, _ := UnmarshalMetadata([]byte(metadata))
+		_, toolCallsLegacy, _ := UnmarshalToolCalls([]byte(toolCalls))
+		if migrated := !metadataLegacy && !toolCallsLegacy; migrated != enabled {
+			t.Errorf("Migration %v: expected the legacy row to be rewritten %v, got %s %s", enabled, enabled, metadata, toolCalls)
+		}
+		// Malformed rows are left for RepairMessageJSON
+		if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE id = 'm-malformed' AND metadata = $1", `{"model":`); n != 1 {
+			t.Errorf("Migration %v: expected the malformed row to be left alone", enabled)
+		}
+	}
+}
+
+func TestRepairMessageJSON(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conv-1', 'Legacy', 'user-1', 'project-1', 'completed', $1, $1)",
+		time.Now().UTC()); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+	// Every metadata fixture is paired with current tool_calls, and every tool_calls fixture with current metadata
+	wantLegacy, wantMalformed := 0, 0
+	insert := func(id string, metadata, toolCalls []byte) {
+		var metadataArg, toolCallsArg interface{}
+		if metadata != nil {
+			metadataArg = string(metadata)
+		}
+		if toolCalls != nil {
+			toolCallsArg = string(toolCalls)
+		}
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at) VALUES ($1, 'conv-1', 'assistant', 'hi', $2, $3, $4)",
+			id, metadataArg, toolCallsArg, time.Now().UTC()); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+	for i, tt := range metadataFixtures {
+		insert("meta-"+string(rune('a'+i)), tt.raw, []byte(`[]`))
+		switch {
+		case tt.err:
+			wantMalformed++
+		case tt.legacy:
+			wantLegacy++
+		}
+	}
+	for i, tt := range toolCallFixtures {
+		insert("tool-"+string(rune('a'+i)), []byte(`{"schema_version":1}`), tt.raw)
+		switch {
+		case tt.err:
+			wantMalformed++
+		case tt.legacy:
+			wantLegacy++
+		}
+	}
+	total := len(metadataFixtures) + len(toolCallFixtures)
+
+	// A dry run with small batches reports without writing
+	report, err := RepairMessageJSON(ctx, conn, false, 4)
+	if err != nil {
+		t.Fatalf("RepairMessageJSON failed: %v", err)
+	}
+	if report.Scanned != total || report.Legacy != wantLegacy || report.Malformed != wantMalformed || report.Repaired != 0 {
+		t.Fatalf("Expected %d scanned, %d legacy and %d malformed, got %+v", total, wantLegacy, wantMalformed, report)
+	}
+	if len(report.MalformedIDs) != wantMalformed {
+		t.Errorf("Expected the malformed IDs to be listed, got %v", report.MalformedIDs)
+	}
+
+	report, err = RepairMessageJSON(ctx, conn, true, 4)
+	if err != nil {
+		t.Fatalf("RepairMessageJSON failed: %v", err)
+	}
+	if report.Repaired != wantLegacy+wantMalformed {
+		t.Errorf("Expected %d rows to be repaired, got %+v", wantLegacy+wantMalformed, report)
+	}
+
+	// Everything now reads as current, and the malformed values are kept in metadata
+	report, err = RepairMessageJSON(ctx, conn, false, 0)
+	if err != nil || report.Legacy != 0 || report.Malformed != 0 || report.Scanned != total {
+		t.Errorf("Expected a repaired table to be clean, got %+v (%v)", report, err)
+	}
+	var metadata string
+	if err := conn.QueryRow(ctx, "SELECT metadata FROM messages WHERE id = 'tool-l'").Scan(&metadata); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	decoded, _, _ := UnmarshalMetadata([]byte(metadata))
+	if decoded["malformed_tool_calls"] != `{"id":"call-1"}` {
+		t.Errorf("Expected the malformed tool_calls to be kept, got %v", decoded)
+	}
+}
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// DefaultTemperature is used when a conversation does not override it
+	DefaultTemperature = 0.7
+	// MaxTemperature is the highest temperature a conversation may ask for
+	MaxTemperature = 2.0
+	// MaxReplyTokens is the highest max_tokens a conversation may ask for; it
+	// can lower the reply budget but not raise it past what context building reserves
+	MaxReplyTokens = replyTokenBudget
+)
+
+var (
+	ErrModelNotAllowed       = errors.New("model is not allowed for this client")
+	ErrTemperatureOutOfRange = fmt.Errorf("temperature must be between 0 and %g", MaxTemperature)
+	ErrMaxTokensOutOfRange   = fmt.Errorf("max_tokens must be between 1 and %d", MaxReplyTokens)
+)
+
+// ModelSettings are a conversation's overrides of the client's LLM settings;
+// nil fields use the client defaults
+type ModelSettings struct {
+	Model       *string  `json:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+// EffectiveModelSettings are the settings a conversation's replies are generated with
+type EffectiveModelSettings struct {
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens"`
+}
+
+// Validate checks the overrides against the client's default model and the
+// allowlist of other models its users may pick. The default model is always
+// allowed; an empty model is the same as none.
+func (m ModelSettings) Validate(defaultModel string, allowedModels []string) error {
+	if m.Model != nil && *m.Model != "" && *m.Model != defaultModel {
+		allowed := false
+		for _, model := range allowedModels {
+			if model == *m.Model {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrModelNotAllowed
+		}
+	}
+	if m.Temperature != nil && (*m.Temperature < 0 || *m.Temperature > MaxTemperature) {
+		return ErrTemperatureOutOfRange
+	}
+	if m.MaxTokens != nil && (*m.MaxTokens < 1 || *m.MaxTokens > MaxReplyTokens) {
+		return ErrMaxTokensOutOfRange
+	}
+	return nil
+}
+
+// Resolve fills the settings left unset from the defaults. maxTokens caps the
+// override so a conversation cannot ask for more than the reply budget.
+func (m ModelSettings) Resolve(defaultModel string, maxTokens int) EffectiveModelSettings {
+	effective := EffectiveModelSettings{
+		Model:       defaultModel,
+		Temperature: DefaultTemperature,
+		MaxTokens:   maxTokens,
+	}
+	if m.Model != nil && *m.Model != "" {
+		effective.Model = *m.Model
+	}
+	if m.Temperature != nil {
+		effective.Temperature = *m.Temperature
+	}
+	if m.MaxTokens != nil && *m.MaxTokens < maxTokens {
+		effective.MaxTokens = *m.MaxTokens
+	}
+	return effective
+}
+
+// conversationSettings loads a conversation's model overrides
+func (s *chatService) conversationSettings(ctx context.Context, conversationID string) (ModelSettings, error) {
+	var model sql.NullString
+	var temperature sql.NullFloat64
+	var maxTokens sql.NullInt64
+	err := s.db.QueryRow(ctx,
+		"SELECT model, temperature, max_tokens FROM conversations WHERE id = $1",
+		conversationID).Scan(&model, &temperature, &maxTokens)
+	if err != nil {
+		return ModelSettings{}, err
+	}
+	return settingsFromColumns(model, temperature, maxTokens), nil
+}
+
+// effectiveSettings resolves a conversation's overrides against the service's LLM client
+func (s *chatService) effectiveSettings(settings ModelSettings) EffectiveModelSettings {
+	return settings.Resolve(s.llmClient.GetModel(), s.replyTokens())
+}
+
+func settingsFromColumns(model sql.NullString, temperature sql.NullFloat64, maxTokens sql.NullInt64) ModelSettings {
+	var settings ModelSettings
+	if model.Valid && strings.TrimSpace(model.String) != "" {
+		settings.Model = &model.String
+	}
+	if temperature.Valid {
+		settings.Temperature = &temperature.Float64
+	}
+	if maxTokens.Valid {
+		n := int(maxTokens.Int64)
+		settings.MaxTokens = &n
+	}
+	return settings
+}
+
+// SettingsErrorCode maps a Validate error to its apierror code and details
+func SettingsErrorCode(err error, settings ModelSettings) (string, map[string]interface{}) {
+	switch {
+	case errors.Is(err, ErrModelNotAllowed):
+		return apierror.CodeModelNotAllowed, map[string]interface{}{"model": *settings.Model}
+	case errors.Is(err, ErrTemperatureOutOfRange):
+		return apierror.CodeFieldInvalid, map[string]interface{}{"field": "temperature", "min": 0, "max": MaxTemperature}
+	case errors.Is(err, ErrMaxTokensOutOfRange):
+		return apierror.CodeFieldInvalid, map[string]interface{}{"field": "max_tokens", "min": 1, "max": MaxReplyTokens}
+	default:
+		return apierror.CodeInternal, nil
+	}
+}
+
+// InsertConversation stores a new conversation with its model overrides and
+// records the creator as its owner
+func InsertConversation(ctx context.Context, db tools.DBConnection, userID, projectID, title string, settings ModelSettings) (*Conversation, error) {
+	conversation := NewConversation(projectID, userID, title, "completed")
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO conversations (id, project_id, user_id, title, status, model, temperature, max_tokens, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err = tx.ExecContext(ctx, query,
+		conversation.ID, conversation.ProjectID, conversation.UserID,
+		conversation.Title, conversation.Status, settings.Model, settings.Temperature, settings.MaxTokens,
+		conversation.CreatedAt, conversation.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO conversation_participants (conversation_id, user_id, role, added_at)
+		VALUES ($1, $2, $3, $4)`,
+		conversation.ID, userID, ParticipantRoleOwner, conversation.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to add conversation owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit conversation: %w", err)
+	}
+
+	conversation.Model, conversation.Temperature, conversation.MaxTokens = settings.Model, settings.Temperature, settings.MaxTokens
+	return conversation, nil
+}
@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// requestRecordingClient records the LLM requests it streams
+type requestRecordingClient struct {
+	fakeLLMClient
+
+	mutex    sync.Mutex
+	requests []*llm.LLMRequest
+}
+
+func (r *requestRecordingClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	r.mutex.Lock()
+	r.requests = append(r.requests, req)
+	r.mutex.Unlock()
+	return r.fakeLLMClient.StreamChat(ctx, req, callback)
+}
+
+func (r *requestRecordingClient) lastRequest(t *testing.T) *llm.LLMRequest {
+	t.Helper()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.requests) == 0 {
+		t.Fatal("Expected an LLM request")
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+func stringPtr(s string) *string  { return &s }
+func floatPtr(f float64) *float64 { return &f }
+func intPtr(n int) *int           { return &n }
+
+func TestModelSettingsValidate(t *testing.T) {
+	allowed := []string{"gpt-4o-mini", "gpt-4o"}
+
+	cases := []struct {
+		name     string
+		settings ModelSettings
+		want     error
+	}{
+		{"no overrides", ModelSettings{}, nil},
+		{"default model", ModelSettings{Model: stringPtr("default-model")}, nil},
+		{"allowed model", ModelSettings{Model: stringPtr("gpt-4o")}, nil},
+		{"model not in allowlist", ModelSettings{Model: stringPtr("o1-pro")}, ErrModelNotAllowed},
+		{"temperature in range", ModelSettings{Temperature: floatPtr(0)}, nil},
+		{"temperature too high", ModelSettings{Temperature: floatPtr(2.5)}, ErrTemperatureOutOfRange},
+		{"max tokens zero", ModelSettings{MaxTokens: intPtr(0)}, ErrMaxTokensOutOfRange},
+		{"max tokens over budget", ModelSettings{MaxTokens: intPtr(MaxReplyTokens + 1)}, ErrMaxTokensOutOfRange},
+	}
+	for _, tc := range cases {
+		if err := tc.settings.Validate("default-model", allowed); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// An empty allowlist still permits the client's default model
+	if err := (ModelSettings{Model: stringPtr("gpt-4o")}).Validate("default-model", nil); !errors.Is(err, ErrModelNotAllowed) {
+		t.Errorf("Expected ErrModelNotAllowed with an empty allowlist, got %v", err)
+	}
+}
+
+func TestModelSettingsResolve(t *testing.T) {
+	defaults := ModelSettings{}.Resolve("default-model", 1000)
+	if defaults != (EffectiveModelSettings{Model: "default-model", Temperature: DefaultTemperature, MaxTokens: 1000}) {
+		t.Errorf("Unexpected defaults: %+v", defaults)
+	}
+
+	overridden := ModelSettings{Model: stringPtr("gpt-4o"), Temperature: floatPtr(0.2), MaxTokens: intPtr(500)}.Resolve("default-model", 1000)
+	if overridden != (EffectiveModelSettings{Model: "gpt-4o", Temperature: 0.2, MaxTokens: 500}) {
+		t.Errorf("Unexpected overrides: %+v", overridden)
+	}
+
+	// max_tokens can lower the reply budget but not raise it
+	if capped := (ModelSettings{MaxTokens: intPtr(3000)}).Resolve("default-model", 1000); capped.MaxTokens != 1000 {
+		t.Errorf("Expected max_tokens capped at 1000, got %d", capped.MaxTokens)
+	}
+}
+
+func TestStreamUsesConversationOverrides(t *testing.T) {
+	client := &requestRecordingClient{}
+	conn := setupRetentionDB(t)
+	service := NewChatService(conn, fakeHub{}, client, tools.NewToolRegistry())
+	ctx := context.Background()
+
+	settings := ModelSettings{Model: stringPtr("gpt-4o"), Temperature: floatPtr(0.25), MaxTokens: intPtr(256)}
+	conversation, err := InsertConversation(ctx, conn, "user-1", "project-1", "Overrides", settings)
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+	if conversation.Model == nil || *conversation.Model != "gpt-4o" {
+		t.Errorf("Expected stored model override, got %v", conversation.Model)
+	}
+
+	req := &ChatRequest{ConversationID: conversation.ID, UserID: "user-1", ProjectID: "project-1"}
+	if err := service.streamLLMResponse(ctx, req, nil, nil); err != nil {
+		t.Fatalf("streamLLMResponse failed: %v", err)
+	}
+	llmReq := client.lastRequest(t)
+	if llmReq.Model != "gpt-4o" || llmReq.MaxTokens != 256 || llmReq.Temperature != 0.25 {
+		t.Errorf("Expected overrides in LLM request, got model %q, max_tokens %d, temperature %v",
+			llmReq.Model, llmReq.MaxTokens, llmReq.Temperature)
+	}
+
+	details, err := service.GetConversation(conversation.ID, "user-1")
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	if details.Settings == nil || details.Settings.Model != "gpt-4o" || details.Settings.MaxTokens != 256 {
+		t.Errorf("Expected effective settings in details, got %+v", details.Settings)
+	}
+}
+
+func TestStreamFallsBackToClientDefaults(t *testing.T) {
+	client := &requestRecordingClient{}
+	conn := setupRetentionDB(t)
+	service := NewChatService(conn, fakeHub{}, client, tools.NewToolRegistry())
+	ctx := context.Background()
+
+	conversation, err := InsertConversation(ctx, conn, "user-1", "project-1", "Defaults", ModelSettings{})
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+
+	req := &ChatRequest{ConversationID: conversation.ID, UserID: "user-1", ProjectID: "project-1"}
+	if err := service.streamLLMResponse(ctx, req, nil, nil); err != nil {
+		t.Fatalf("streamLLMResponse failed: %v", err)
+	}
+	llmReq := client.lastRequest(t)
+	if llmReq.Model != "fake" || llmReq.MaxTokens != service.replyTokens() || llmReq.Temperature != DefaultTemperature {
+		t.Errorf("Expected client defaults in LLM request, got model %q, max_tokens %d, temperature %v",
+			llmReq.Model, llmReq.MaxTokens, llmReq.Temperature)
+	}
+}
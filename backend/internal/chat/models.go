@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -13,11 +14,14 @@ type Message struct {
 	ConversationID string            `json:"conversation_id" db:"conversation_id"`
 	Role         string            `json:"role" db:"role"` // user, assistant, system
 	Content      string            `json:"content" db:"content"`
-	Metadata     map[string]interface{} `json:"metadata" db:"metadata"`
+	Metadata     MessageMetadata   `json:"metadata" db:"metadata"`
 	ToolCalls    []ToolCall        `json:"tool_calls,omitempty" db:"tool_calls"`
 	CreatedAt    time.Time         `json:"created_at" db:"created_at"`
 	UserID       string            `json:"user_id,omitempty" db:"user_id"`
 	ProjectID    string            `json:"project_id,omitempty" db:"project_id"`
+	ClientMessageID string         `json:"client_message_id,omitempty" db:"client_message_id"`
+	Status       string            `json:"status,omitempty" db:"status"` // final, or draft until approved; see drafts.go
+	Feedback     []MessageFeedback `json:"feedback,omitempty" db:"-"` // Loaded for exports only
 }
 
 // ToolCall represents a function/tool call from the LLM
@@ -28,6 +32,7 @@ type ToolCall struct {
 	Status   string                 `json:"status,omitempty" db:"status"` // pending, executing, completed, failed
 	Result   map[string]interface{} `json:"result,omitempty" db:"result"`
 	Error    string                 `json:"error,omitempty" db:"error"`
+	Reruns   []ToolCallRerun        `json:"reruns,omitempty" db:"reruns"` // Re-executions requested through the API
 }
 
 // ToolCallFunction represents a function call
@@ -42,22 +47,43 @@ type Conversation struct {
 	ProjectID string    `json:"project_id" db:"project_id"`
 	UserID   string    `json:"user_id" db:"user_id"`
 	Title    string    `json:"title" db:"title"`
-	Status   string    `json:"status" db:"status"` // processing, completed, interrupted
+	Status   string    `json:"status" db:"status"` // queued, processing, completed, interrupted
+	Pinned   bool       `json:"pinned" db:"pinned"`
+	PinnedAt *time.Time `json:"pinned_at,omitempty" db:"pinned_at"`
+	// Overrides of the client's LLM settings; nil uses the client default
+	Model       *string  `json:"model,omitempty" db:"model"`
+	Temperature *float64 `json:"temperature,omitempty" db:"temperature"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" db:"max_tokens"`
+	// The conversation this one was forked from; nil when it is not a fork
+	ForkedFromConversationID *string `json:"forked_from_conversation_id,omitempty" db:"forked_from_conversation_id"`
+	// Language code the assistant is told to respond in; nil until detected or chosen
+	Language *string `json:"language,omitempty" db:"language"`
+	// Replies are saved as drafts that an editor approves or discards
+	DraftsEnabled bool `json:"drafts_enabled" db:"drafts_enabled"`
+	// Set by GetConversations and GetConversation: user and assistant messages, and the start of the latest one with content
+	MessageCount       int    `json:"message_count"`
+	LastMessagePreview string `json:"last_message_preview"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Incremented by pinning and settings changes, which name the version they were based on
+	Version int64 `json:"version" db:"version"`
 }
 
-// ToolExecution represents a tool execution record
-type ToolExecution struct {
-	ID              string        `json:"id" db:"id"`
-	MessageID       string        `json:"message_id" db:"message_id"`
-	ToolName        string        `json:"tool_name" db:"tool_name"`
-	ToolParameters  string        `json:"tool_parameters" db:"tool_parameters"` // JSON string
-	ToolResult      string        `json:"tool_result,omitempty" db:"tool_result"` // JSON string
-	ExecutionStatus string        `json:"execution_status" db:"execution_status"` // pending, executing, completed, failed
-	ExecutionTimeMs int           `json:"execution_time_ms,omitempty" db:"execution_time_ms"`
-	CreatedAt       time.Time     `json:"created_at" db:"created_at"`
-}
+// ConversationSummaryColumns and ConversationSummaryJoin add message_count and
+// last_message_preview (the first 120 characters of the latest message with
+// content) to a query over conversations c. Counts come from one grouped scan of
+// messages rather than a query per conversation; tool and system messages are
+// left out of both.
+const (
+	ConversationSummaryColumns = `COALESCE(mc.message_count, 0),
+			(SELECT SUBSTR(m.content, 1, 120) FROM messages m
+			WHERE m.conversation_id = c.id AND m.role IN ('user', 'assistant') AND m.content <> ''
+			ORDER BY m.created_at DESC, m.id DESC LIMIT 1)`
+	ConversationSummaryJoin = `LEFT JOIN (
+			SELECT conversation_id, COUNT(*) AS message_count FROM messages
+			WHERE role IN ('user', 'assistant') GROUP BY conversation_id
+		) mc ON mc.conversation_id = c.id`
+)
 
 // ChatRequest represents an incoming chat request
 type ChatRequest struct {
@@ -67,6 +93,13 @@ type ChatRequest struct {
 	ClientID      string `json:"client_id"`
 	ProjectID     string `json:"project_id"`
 	ConnectionID  string `json:"connection_id"`
+	ClientMessageID string `json:"client_message_id,omitempty"` // Client-generated UUID used to drop resends
+	MaxConcurrentStreams int `json:"-"` // Client's stream limit; 0 uses the default
+	FlushPolicy StreamFlushPolicy `json:"-"` // Client's streaming cadence; zero values use the server's
+	ToolResultTokenLimit int `json:"-"` // Client's limit before tool results are digested; 0 uses the default
+	// Lifetime of the request, such as the sender's connection; nil never ends.
+	// The reply keeps generating for a grace period after it ends.
+	Context context.Context `json:"-"`
 	
 	// Token tracking function (optional)
 	AddTokensFunc func(tokens int64) bool
@@ -75,6 +108,42 @@ type ChatRequest struct {
 	Connection interface {
 		GetTokenUsage() (used int64, limit int64, remaining int64)
 	}
+
+	// Tool call IDs the reply may cite; nil when the project has citations off
+	citableToolCalls map[string]bool
+}
+
+// AssistantThinkingData is sent once a response starts generating, before the first token.
+// QueuePosition is set while the request is waiting for a free stream slot.
+type AssistantThinkingData struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id,omitempty"`
+	Model          string `json:"model"`
+	QueuePosition  int    `json:"queue_position,omitempty"`
+}
+
+// MessageQueuedData is sent while a request waits for a free stream slot
+type MessageQueuedData struct {
+	ConversationID  string `json:"conversation_id"`
+	ClientMessageID string `json:"client_message_id,omitempty"`
+	QueuePosition   int    `json:"queue_position"`
+}
+
+// RateLimitedData is sent when a reply could not be generated because the
+// client's LLM provider is rate limiting it; retry after RetryAfterSeconds
+type RateLimitedData struct {
+	ConversationID    string `json:"conversation_id"`
+	ClientMessageID   string `json:"client_message_id,omitempty"`
+	Model             string `json:"model,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+// AssistantFirstTokenData is sent when the first chunk of a response arrives
+type AssistantFirstTokenData struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Model          string `json:"model"`
+	TTFTMs         int64  `json:"ttft_ms"`
 }
 
 // ChatResponse represents a streaming chat response
@@ -94,6 +163,11 @@ type ConversationDetails struct {
 	Conversation *Conversation `json:"conversation"`
 	Messages     []*Message     `json:"messages"`
 	ToolStatus   map[string]string `json:"tool_status,omitempty"`
+	Settings     *EffectiveModelSettings `json:"settings,omitempty"` // What replies are generated with
+	// Messages holds one page of history when loaded by GetConversationPage:
+	// HasMore reports older messages, TotalMessageCount counts them all
+	HasMore           bool `json:"has_more"`
+	TotalMessageCount int  `json:"total_message_count"`
 }
 
 // Helper functions
@@ -108,6 +182,7 @@ func NewMessage(conversationID, role, content, userID, projectID string) *Messag
 		Metadata:       make(map[string]interface{}),
 		ToolCalls:       make([]ToolCall, 0),
 		CreatedAt:       time.Now(),
+		Status:          MessageStatusFinal,
 		UserID:         userID,
 		ProjectID:      projectID,
 	}
@@ -127,6 +202,7 @@ func NewConversation(projectID, userID, title, status string) *Conversation {
 		Status:    status,
 		CreatedAt: now,
 		UpdatedAt: now,
+		Version:   1,
 	}
 }
 
@@ -143,18 +219,6 @@ func NewToolCall(toolID, toolType, name string, arguments interface{}) *ToolCall
 	}
 }
 
-// NewToolExecution creates a new tool execution instance
-func NewToolExecution(messageID, toolName, toolParameters string) *ToolExecution {
-	return &ToolExecution{
-		ID:              uuid.New().String(),
-		MessageID:       messageID,
-		ToolName:        toolName,
-		ToolParameters:  toolParameters,
-		ExecutionStatus: "pending",
-		CreatedAt:       time.Now(),
-	}
-}
-
 // WebSocketMessage represents a WebSocket message
 type WebSocketMessage struct {
 	Type      string      `json:"type"`
@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	zdb "zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+// Participant roles. The user who creates a conversation is its owner; only
+// the owner adds participants and deletes the conversation.
+const (
+	ParticipantRoleOwner       = "owner"
+	ParticipantRoleParticipant = "participant"
+)
+
+var (
+	// ErrNotConversationOwner is returned when a participant tries an owner-only action
+	ErrNotConversationOwner = errors.New("only the conversation owner can do this")
+	// ErrAlreadyParticipant is returned when adding a user who is already in the conversation
+	ErrAlreadyParticipant = errors.New("user is already a participant")
+	// ErrParticipantUserNotFound is returned when the user to add is not an active member of the client
+	ErrParticipantUserNotFound = errors.New("user not found")
+)
+
+// ConversationParticipant is a user who can read and post to a conversation
+type ConversationParticipant struct {
+	ConversationID string    `json:"conversation_id"`
+	UserID         string    `json:"user_id"`
+	Username       string    `json:"username"`
+	Role           string    `json:"role"`
+	AddedAt        time.Time `json:"added_at"`
+}
+
+// ParticipantErrorCode maps an AddParticipant or ListParticipants error to its apierror code
+func ParticipantErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrConversationNotFound):
+		return apierror.CodeConversationNotFound
+	case errors.Is(err, ErrNotConversationOwner):
+		return apierror.CodeConversationOwnerOnly
+	case errors.Is(err, ErrAlreadyParticipant):
+		return apierror.CodeParticipantExists
+	case errors.Is(err, ErrParticipantUserNotFound):
+		return apierror.CodeUserNotFound
+	default:
+		return apierror.CodeDatabaseError
+	}
+}
+
+// isParticipantCondition matches conversations c the user bound to placeholder n
+// created or was added to
+func isParticipantCondition(n int) string {
+	return fmt.Sprintf(`(c.user_id = $%d OR EXISTS (
+		SELECT 1 FROM conversation_participants cp WHERE cp.conversation_id = c.id AND cp.user_id = $%d))`, n, n)
+}
+
+// AddParticipant adds a user of the same client to one of the owner's
+// non-deleted conversations. Visitors and inactive users cannot be added.
+func AddParticipant(ctx context.Context, db tools.DBConnection, ownerID, clientID, conversationID, userID string) (*ConversationParticipant, error) {
+	var conversationOwner string
+	err := db.QueryRow(ctx,
+		`SELECT c.user_id
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.deleted_at IS NULL AND `+isParticipantCondition(3),
+		conversationID, clientID, ownerID).Scan(&conversationOwner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	if conversationOwner != ownerID {
+		return nil, ErrNotConversationOwner
+	}
+
+	participant := ConversationParticipant{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Role:           ParticipantRoleParticipant,
+		AddedAt:        time.Now().UTC(),
+	}
+	err = db.QueryRow(ctx,
+		`SELECT username FROM users
+		WHERE id = $1 AND client_id = $2 AND is_active = true AND is_visitor = false`,
+		userID, clientID).Scan(&participant.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrParticipantUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if userID == conversationOwner {
+		return nil, ErrAlreadyParticipant
+	}
+
+	if _, err := db.Exec(ctx,
+		`INSERT INTO conversation_participants (conversation_id, user_id, role, added_at)
+		VALUES ($1, $2, $3, $4)`,
+		conversationID, userID, participant.Role, participant.AddedAt); err != nil {
+		if zdb.IsUniqueViolation(err) {
+			return nil, ErrAlreadyParticipant
+		}
+		return nil, fmt.Errorf("failed to add participant: %w", err)
+	}
+	return &participant, nil
+}
+
+// ListParticipants returns the participants of a non-deleted conversation the
+// user takes part in, owner first
+func ListParticipants(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) ([]ConversationParticipant, error) {
+	var exists int
+	err := db.QueryRow(ctx,
+		`SELECT 1 FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.deleted_at IS NULL AND `+isParticipantCondition(3),
+		conversationID, clientID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT cp.conversation_id, cp.user_id, u.username, cp.role, cp.added_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = $1
+		ORDER BY CASE WHEN cp.role = 'owner' THEN 0 ELSE 1 END, cp.added_at ASC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list participants: %w", err)
+	}
+	defer rows.Close()
+
+	participants := []ConversationParticipant{}
+	for rows.Next() {
+		var p ConversationParticipant
+		if err := rows.Scan(&p.ConversationID, &p.UserID, &p.Username, &p.Role, &p.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan participant: %w", err)
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// ConversationParticipantIDs returns the IDs of everyone in a conversation,
+// including its owner
+func ConversationParticipantIDs(ctx context.Context, db tools.DBConnection, conversationID string) ([]string, error) {
+	rows, err := db.Query(ctx,
+		`SELECT user_id FROM conversations WHERE id = $1
+		UNION
+		SELECT user_id FROM conversation_participants WHERE conversation_id = $1`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list participants: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan participant: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// IsParticipant reports whether the user can read and post to a non-deleted conversation
+func IsParticipant(ctx context.Context, db tools.DBConnection, conversationID, userID string) (bool, error) {
+	var exists int
+	err := db.QueryRow(ctx,
+		`SELECT 1 FROM conversations c WHERE c.id = $1 AND c.deleted_at IS NULL AND `+isParticipantCondition(2),
+		conversationID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check participant: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,157 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"zlay-backend/internal/tools"
+)
+
+// setupParticipantsDB adds users to the retention schema: user-1 and user-2 of
+// client-1, user-3 of client-2 and a visitor of client-1
+func setupParticipantsDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"UPDATE users SET username = 'ana' WHERE id = 'user-1'",
+		"UPDATE users SET username = 'budi' WHERE id = 'user-2'",
+		"INSERT INTO clients (id, name, slug) VALUES ('client-2', 'client-2', 'client-2')",
+		`INSERT INTO users (id, client_id, username, password_hash, is_active, is_visitor) VALUES
+			('user-3', 'client-2', 'citra', 'x', true, false),
+			('visitor-1', 'client-1', 'visitor', 'x', true, true)`,
+	} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+	return conn
+}
+
+func TestAddParticipant(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	conversation, err := InsertConversation(ctx, conn, "user-1", "project-1", "Shared", ModelSettings{})
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ownerID string
+		userID  string
+		want    error
+	}{
+		{"outsider adds", "user-2", "user-1", ErrConversationNotFound},
+		{"user of another client", "user-1", "user-3", ErrParticipantUserNotFound},
+		{"visitor", "user-1", "visitor-1", ErrParticipantUserNotFound},
+		{"owner adds themselves", "user-1", "user-1", ErrAlreadyParticipant},
+		{"teammate", "user-1", "user-2", nil},
+		{"teammate again", "user-1", "user-2", ErrAlreadyParticipant},
+		{"participant adds", "user-2", "user-1", ErrNotConversationOwner},
+	}
+	for _, tt := range tests {
+		participant, err := AddParticipant(ctx, conn, tt.ownerID, "client-1", conversation.ID, tt.userID)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if err == nil && (participant.Username != "budi" || participant.Role != ParticipantRoleParticipant) {
+			t.Errorf("%s: unexpected participant %+v", tt.name, participant)
+		}
+	}
+
+	participants, err := ListParticipants(ctx, conn, "user-2", "client-1", conversation.ID)
+	if err != nil {
+		t.Fatalf("ListParticipants failed: %v", err)
+	}
+	if len(participants) != 2 || participants[0].UserID != "user-1" || participants[0].Role != ParticipantRoleOwner || participants[1].UserID != "user-2" {
+		t.Errorf("Expected the owner then the teammate, got %+v", participants)
+	}
+	if _, err := ListParticipants(ctx, conn, "user-3", "client-2", conversation.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected another client's user not to see the participants, got %v", err)
+	}
+}
+
+func TestTwoUserCollaboration(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	conversation, err := InsertConversation(ctx, conn, "user-1", "project-1", "Shared", ModelSettings{})
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+	if _, err := AddParticipant(ctx, conn, "user-1", "client-1", conversation.ID, "user-2"); err != nil {
+		t.Fatalf("AddParticipant failed: %v", err)
+	}
+
+	hub := &recordingHub{connections: map[string]bool{"conn-1": true, "conn-2": true}}
+	client := &scriptedLLMClient{chunks: resumeChunks(3)}
+	service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+
+	// The participant sees the conversation; a user outside it does not
+	if conversations, err := service.GetConversations("user-2", "project-1"); err != nil || len(conversations) != 1 {
+		t.Fatalf("Expected the participant to list the conversation, got %v, %v", conversations, err)
+	}
+	if _, err := service.GetConversation(conversation.ID, "user-3"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected an outsider to get ErrConversationNotFound, got %v", err)
+	}
+
+	// The owner reconnects mid-stream while the participant's message is answered
+	client.afterChunk = func(sent int) {
+		if sent != 1 {
+			return
+		}
+		if _, err := service.ResumeStream(conversation.ID, "user-1", "conn-1", 0); err != nil {
+			t.Errorf("Expected the owner to resume the participant's stream, got %v", err)
+		}
+		if _, err := service.ResumeStream(conversation.ID, "user-3", "conn-3", 0); !errors.Is(err, ErrStreamNotFound) {
+			t.Errorf("Expected an outsider's resume to fail, got %v", err)
+		}
+		if snapshot, err := service.GetActiveStreamingMessage(conversation.ID, "user-1"); err != nil || !snapshot.HasParticipant("user-2") {
+			t.Errorf("Expected the owner to see the stream, got %+v, %v", snapshot, err)
+		}
+	}
+	if err := service.ProcessUserMessage(&ChatRequest{
+		ConversationID: conversation.ID,
+		Content:        "What changed this week?",
+		UserID:         "user-2",
+		ProjectID:      "project-1",
+		ConnectionID:   "conn-2",
+	}); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	// Indicators reach the sender's connection and the owner, who had none attached yet
+	thinking := hub.eventsOfType("assistant_thinking")
+	targets := map[string]bool{}
+	for _, e := range thinking {
+		targets[e.Target] = true
+	}
+	if len(thinking) != 2 || !targets["conn:conn-2"] || !targets["user:user-1"] {
+		t.Errorf("Expected thinking on conn-2 and for user-1, got %+v", thinking)
+	}
+
+	// Both users read the exchange, with the sender recorded on the user message
+	for _, userID := range []string{"user-1", "user-2"} {
+		details, err := service.GetConversation(conversation.ID, userID)
+		if err != nil {
+			t.Fatalf("%s: GetConversation failed: %v", userID, err)
+		}
+		if len(details.Messages) != 2 || details.Messages[0].UserID != "user-2" || details.Messages[1].UserID != "" {
+			t.Errorf("%s: expected the participant's message and an unattributed reply, got %+v", userID, details.Messages)
+		}
+	}
+
+	// Users outside the conversation cannot post, participants cannot delete
+	outsider := &ChatRequest{ConversationID: conversation.ID, Content: "Hi", UserID: "user-3", ProjectID: "project-1"}
+	if err := service.ProcessUserMessage(outsider); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected an outsider's message to be rejected, got %v", err)
+	}
+	if err := service.DeleteConversation(conversation.ID, "user-2"); !errors.Is(err, ErrNotConversationOwner) {
+		t.Errorf("Expected the participant's delete to be refused, got %v", err)
+	}
+	if err := service.DeleteConversation(conversation.ID, "user-1"); err != nil {
+		t.Errorf("Expected the owner to delete, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// MaxPinnedConversations is how many conversations a user may pin in one project
+const MaxPinnedConversations = 10
+
+// ErrPinLimitReached is returned when pinning would exceed MaxPinnedConversations
+var ErrPinLimitReached = fmt.Errorf("at most %d conversations can be pinned per project", MaxPinnedConversations)
+
+// SetConversationPinned pins or unpins one of the user's own, non-deleted
+// conversations within their client and returns the updated conversation.
+// Pinning an already pinned conversation keeps its original pinned_at. A
+// version other than 0 is the one the change was based on; when the
+// conversation has moved past it a *VersionConflictError is returned.
+func SetConversationPinned(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string, pinned bool, version int64) (*Conversation, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := scanConversation(tx.QueryRowContext(ctx,
+		`SELECT `+conversationColumns("c.")+`
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND c.user_id = $2 AND u.client_id = $3 AND c.deleted_at IS NULL`,
+		conversationID, userID, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	if version != 0 && version != current.Version {
+		return nil, &VersionConflictError{Current: current}
+	}
+	projectID, wasPinned := current.ProjectID, current.Pinned
+
+	if pinned != wasPinned {
+		var pinnedAt interface{}
+		if pinned {
+			var count int
+			err := tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM conversations
+				WHERE user_id = $1 AND project_id = $2 AND pinned = true AND deleted_at IS NULL`,
+				userID, projectID).Scan(&count)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count pinned conversations: %w", err)
+			}
+			if count >= MaxPinnedConversations {
+				return nil, ErrPinLimitReached
+			}
+			pinnedAt = time.Now().UTC()
+		}
+
+		// Another pin may have landed between the read and this update
+		result, err := tx.ExecContext(ctx,
+			"UPDATE conversations SET pinned = $1, pinned_at = $2, version = version + 1 WHERE id = $3 AND version = $4",
+			pinned, pinnedAt, conversationID, current.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update conversation: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			if current, err = scanConversation(tx.QueryRowContext(ctx,
+				"SELECT "+conversationColumns("")+" FROM conversations WHERE id = $1", conversationID)); err != nil {
+				return nil, fmt.Errorf("failed to read conversation: %w", err)
+			}
+			return nil, &VersionConflictError{Current: current}
+		}
+	}
+
+	conv, err := scanConversation(tx.QueryRowContext(ctx,
+		"SELECT "+conversationColumns("")+" FROM conversations WHERE id = $1", conversationID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pin: %w", err)
+	}
+	return conv, nil
+}
+
+// scanConversation scans the columns of conversationColumns
+func scanConversation(row interface{ Scan(...interface{}) error }) (*Conversation, error) {
+	var conv Conversation
+	var pinnedAt sql.NullTime
+	var language sql.NullString
+	if err := row.Scan(
+		&conv.ID, &conv.ProjectID, &conv.UserID, &conv.Title, &conv.Status,
+		&conv.Pinned, &pinnedAt, &language, &conv.DraftsEnabled, &conv.CreatedAt, &conv.UpdatedAt, &conv.Version,
+	); err != nil {
+		return nil, err
+	}
+	if pinnedAt.Valid {
+		conv.PinnedAt = &pinnedAt.Time
+	}
+	if language.Valid {
+		conv.Language = &language.String
+	}
+	return &conv, nil
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func setupPinDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1", "project-2")
+	dbtest.Seed(t, zdb, "INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-2', 'client-1', 'user-2', 'x')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+// insertPinConversations adds n conversations, conv-0 being the least recently updated
+func insertPinConversations(t *testing.T, conn tools.DBConnection, userID, projectID string, n int) {
+	t.Helper()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		updatedAt := base.Add(time.Duration(i) * time.Minute)
+		if _, err := conn.Exec(context.Background(),
+			"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ($1, 'Chat', $2, $3, 'completed', $4, $4)",
+			fmt.Sprintf("%s-%s-conv-%d", userID, projectID, i), userID, projectID, updatedAt); err != nil {
+			t.Fatalf("Failed to insert conversation: %v", err)
+		}
+	}
+}
+
+func TestGetConversationsListsPinnedFirst(t *testing.T) {
+	conn := setupPinDB(t)
+	insertPinConversations(t, conn, "user-1", "project-1", 5)
+	service := &chatService{db: conn}
+	ctx := context.Background()
+
+	for _, id := range []string{"user-1-project-1-conv-1", "user-1-project-1-conv-3"} {
+		if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", id, true, 0); err != nil {
+			t.Fatalf("Pinning %s failed: %v", id, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	conversations, err := service.GetConversations("user-1", "project-1")
+	if err != nil {
+		t.Fatalf("GetConversations failed: %v", err)
+	}
+	want := []string{"conv-3", "conv-1", "conv-4", "conv-2", "conv-0"}
+	if len(conversations) != len(want) {
+		t.Fatalf("Expected %d conversations, got %d", len(want), len(conversations))
+	}
+	for i, conv := range conversations {
+		if conv.ID != "user-1-project-1-"+want[i] {
+			t.Errorf("Position %d: expected %s, got %s", i, want[i], conv.ID)
+		}
+	}
+	if !conversations[0].Pinned || conversations[0].PinnedAt == nil || conversations[2].Pinned {
+		t.Errorf("Expected pinned flags on the pinned conversations only, got %+v", conversations[:3])
+	}
+
+	// Unpinning returns a conversation to its updated_at position
+	conv, err := SetConversationPinned(ctx, conn, "user-1", "client-1", "user-1-project-1-conv-3", false, 0)
+	if err != nil || conv.Pinned || conv.PinnedAt != nil {
+		t.Fatalf("Expected conv-3 to be unpinned, got %+v (%v)", conv, err)
+	}
+	conversations, _ = service.GetConversations("user-1", "project-1")
+	if conversations[0].ID != "user-1-project-1-conv-1" || conversations[1].ID != "user-1-project-1-conv-4" {
+		t.Errorf("Unexpected order after unpinning: %s, %s", conversations[0].ID, conversations[1].ID)
+	}
+}
+
+func TestSetConversationPinnedEnforcesLimit(t *testing.T) {
+	conn := setupPinDB(t)
+	insertPinConversations(t, conn, "user-1", "project-1", MaxPinnedConversations+1)
+	insertPinConversations(t, conn, "user-1", "project-2", 1)
+	insertPinConversations(t, conn, "user-2", "project-1", 1)
+	ctx := context.Background()
+
+	for i := 0; i < MaxPinnedConversations; i++ {
+		id := fmt.Sprintf("user-1-project-1-conv-%d", i)
+		if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", id, true, 0); err != nil {
+			t.Fatalf("Pinning %s failed: %v", id, err)
+		}
+	}
+
+	last := fmt.Sprintf("user-1-project-1-conv-%d", MaxPinnedConversations)
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", last, true, 0); !errors.Is(err, ErrPinLimitReached) {
+		t.Fatalf("Expected ErrPinLimitReached, got %v", err)
+	}
+
+	// Re-pinning a pinned conversation is not a new pin
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", "user-1-project-1-conv-0", true, 0); err != nil {
+		t.Errorf("Expected re-pinning to succeed, got %v", err)
+	}
+	// The cap is per user and project
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", "user-1-project-2-conv-0", true, 0); err != nil {
+		t.Errorf("Expected a pin in another project to succeed, got %v", err)
+	}
+	if _, err := SetConversationPinned(ctx, conn, "user-2", "client-1", "user-2-project-1-conv-0", true, 0); err != nil {
+		t.Errorf("Expected another user's pin to succeed, got %v", err)
+	}
+
+	// Unpinning frees a slot
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", "user-1-project-1-conv-0", false, 0); err != nil {
+		t.Fatalf("Unpinning failed: %v", err)
+	}
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", last, true, 0); err != nil {
+		t.Errorf("Expected pinning to succeed after unpinning, got %v", err)
+	}
+
+	// Only the owner within their client may pin
+	for _, caller := range [][2]string{{"user-2", "client-1"}, {"user-1", "client-2"}} {
+		if _, err := SetConversationPinned(ctx, conn, caller[0], caller[1], "user-1-project-1-conv-1", false, 0); !errors.Is(err, ErrConversationNotFound) {
+			t.Errorf("%v: expected ErrConversationNotFound, got %v", caller, err)
+		}
+	}
+}
+
+func TestSetConversationPinnedDetectsConflicts(t *testing.T) {
+	conn := setupPinDB(t)
+	insertPinConversations(t, conn, "user-1", "project-1", 1)
+	ctx := context.Background()
+	id := "user-1-project-1-conv-0"
+
+	// Two callers saw version 1; the first pin lands
+	conv, err := SetConversationPinned(ctx, conn, "user-1", "client-1", id, true, 1)
+	if err != nil || !conv.Pinned || conv.Version != 2 {
+		t.Fatalf("Expected the pin at version 2, got %+v (%v)", conv, err)
+	}
+
+	// The second is refused with the conversation as the first left it
+	var conflict *VersionConflictError
+	if _, err := SetConversationPinned(ctx, conn, "user-1", "client-1", id, false, 1); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a VersionConflictError, got %v", err)
+	}
+	if !conflict.Current.Pinned || conflict.Current.Version != 2 {
+		t.Errorf("Expected the pinned conversation at version 2, got %+v", conflict.Current)
+	}
+
+	// The language shares the version, and 0 skips the check
+	if _, err := SetConversationLanguage(ctx, conn, "user-1", "client-1", id, "id", 1); !errors.As(err, &conflict) {
+		t.Errorf("Expected a stale language edit to conflict, got %v", err)
+	}
+	if conv, err := SetConversationPinned(ctx, conn, "user-1", "client-1", id, false, 0); err != nil || conv.Pinned || conv.Version != 3 {
+		t.Errorf("Expected an unchecked unpin at version 3, got %+v (%v)", conv, err)
+	}
+}
@@ -0,0 +1,407 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	zdb "zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+// Retention modes a project can choose for messages past its retention period
+const (
+	// RetentionModeDelete removes old messages with their tool executions and embeddings
+	RetentionModeDelete = "delete"
+	// RetentionModeRedact replaces the content of old messages with RedactedContent,
+	// keeping their role and timestamps
+	RetentionModeRedact = "redact"
+)
+
+const (
+	// RedactedContent replaces the content of messages redacted by a retention policy
+	RedactedContent = "[redacted by retention policy]"
+	// DefaultRetentionBatchSize limits how many messages one retention statement touches
+	DefaultRetentionBatchSize = 500
+)
+
+// Statuses of a retention run
+const (
+	RetentionRunRunning   = "running"
+	RetentionRunCompleted = "completed"
+	RetentionRunFailed    = "failed"
+)
+
+// retentionVerbs describes what each retention mode does to a message
+var retentionVerbs = map[string]string{
+	RetentionModeDelete: "deleted",
+	RetentionModeRedact: "redacted",
+}
+
+// ValidRetentionMode reports whether mode is a retention mode a project can choose
+func ValidRetentionMode(mode string) bool {
+	_, ok := retentionVerbs[mode]
+	return ok
+}
+
+// RetentionRun is one enforcement of a project's retention policy
+type RetentionRun struct {
+	ID                    string     `json:"id"`
+	ProjectID             string     `json:"project_id"`
+	Status                string     `json:"status"`
+	Mode                  string     `json:"mode"`
+	RetentionDays         int        `json:"retention_days"`
+	Cutoff                time.Time  `json:"cutoff"`
+	MessagesProcessed     int        `json:"messages_processed"`
+	ConversationsArchived int        `json:"conversations_archived"`
+	Error                 *string    `json:"error"`
+	StartedAt             time.Time  `json:"started_at"`
+	FinishedAt            *time.Time `json:"finished_at"`
+}
+
+// LatestRetentionRun returns the most recent retention run of a project, or nil when it never ran
+func LatestRetentionRun(ctx context.Context, db tools.DBConnection, projectID string) (*RetentionRun, error) {
+	run := &RetentionRun{}
+	var runErr sql.NullString
+	var finishedAt sql.NullTime
+	err := db.QueryRow(ctx,
+		`SELECT id, project_id, status, mode, retention_days, cutoff, messages_processed, conversations_archived, error, started_at, finished_at
+		FROM project_retention_runs WHERE project_id = $1 ORDER BY started_at DESC LIMIT 1`,
+		projectID).Scan(&run.ID, &run.ProjectID, &run.Status, &run.Mode, &run.RetentionDays, &run.Cutoff,
+		&run.MessagesProcessed, &run.ConversationsArchived, &runErr, &run.StartedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention run: %w", err)
+	}
+	if runErr.Valid {
+		run.Error = &runErr.String
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, nil
+}
+
+// retentionPolicy is the retention configured on a project
+type retentionPolicy struct {
+	projectID    string
+	days         int
+	mode         string
+	exemptPinned bool
+}
+
+// RetentionEnforcer removes messages older than their project's retention
+// period, deleting or redacting them as the project chooses. Conversations
+// left without any original message are archived. Work is done in batches,
+// each in its own transaction, and every project run is recorded in
+// project_retention_runs.
+type RetentionEnforcer struct {
+	db        tools.DBConnection
+	batchSize int
+	now       func() time.Time
+	history   *HistoryCache // Conversations whose messages were removed are dropped from it
+}
+
+// NewRetentionEnforcer creates an enforcer; a non-positive batchSize uses DefaultRetentionBatchSize
+func NewRetentionEnforcer(db tools.DBConnection, batchSize int) *RetentionEnforcer {
+	if batchSize <= 0 {
+		batchSize = DefaultRetentionBatchSize
+	}
+	return &RetentionEnforcer{db: db, batchSize: batchSize, now: time.Now}
+}
+
+// SetHistoryCache sets the history cache of the chat service, so replies do
+// not see messages the enforcer removed
+func (e *RetentionEnforcer) SetHistoryCache(cache *HistoryCache) {
+	e.history = cache
+}
+
+// Run enforces retention policies every interval until ctx is cancelled
+func (e *RetentionEnforcer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if processed, err := e.EnforceOnce(ctx); err != nil {
+			log.Printf("Message retention failed after %d messages: %v", processed, err)
+		} else if processed > 0 {
+			log.Printf("Message retention removed or redacted %d messages", processed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnforceOnce applies the policy of every active project that has one and
+// returns how many messages were deleted or redacted. A failing project is
+// recorded and logged without stopping the others; the last error is returned.
+func (e *RetentionEnforcer) EnforceOnce(ctx context.Context) (int, error) {
+	policies, err := e.policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	var lastErr error
+	for _, policy := range policies {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		run, err := e.enforceProject(ctx, policy)
+		if run != nil {
+			total += run.MessagesProcessed
+		}
+		if err != nil {
+			log.Printf("Message retention failed for project %s: %v", policy.projectID, err)
+			lastErr = err
+		}
+	}
+	return total, lastErr
+}
+
+// policies returns the retention policy of every active project that has one
+func (e *RetentionEnforcer) policies(ctx context.Context) ([]retentionPolicy, error) {
+	rows, err := e.db.Query(ctx,
+		`SELECT id, retention_days, retention_mode, retention_exempt_pinned FROM projects
+		WHERE is_active = true AND retention_days IS NOT NULL AND retention_days > 0 AND retention_mode IS NOT NULL
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []retentionPolicy
+	for rows.Next() {
+		var policy retentionPolicy
+		if err := rows.Scan(&policy.projectID, &policy.days, &policy.mode, &policy.exemptPinned); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		if !ValidRetentionMode(policy.mode) {
+			log.Printf("Skipping project %s with unknown retention mode %q", policy.projectID, policy.mode)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// enforceProject removes a project's messages past its retention period batch
+// by batch and records the run
+func (e *RetentionEnforcer) enforceProject(ctx context.Context, policy retentionPolicy) (*RetentionRun, error) {
+	now := e.now().UTC()
+	run := &RetentionRun{
+		ID:            uuid.New().String(),
+		ProjectID:     policy.projectID,
+		Status:        RetentionRunRunning,
+		Mode:          policy.mode,
+		RetentionDays: policy.days,
+		Cutoff:        now.Add(-time.Duration(policy.days) * 24 * time.Hour),
+		StartedAt:     now,
+	}
+	if _, err := e.db.Exec(ctx,
+		`INSERT INTO project_retention_runs (id, project_id, status, mode, retention_days, cutoff, messages_processed, conversations_archived, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 0, 0, $7)`,
+		run.ID, run.ProjectID, run.Status, run.Mode, run.RetentionDays, run.Cutoff, run.StartedAt); err != nil {
+		return nil, fmt.Errorf("failed to record retention run: %w", err)
+	}
+
+	runErr := e.processProject(ctx, policy, run)
+
+	finishedAt := e.now().UTC()
+	run.FinishedAt = &finishedAt
+	run.Status = RetentionRunCompleted
+	var errText interface{}
+	if runErr != nil {
+		run.Status = RetentionRunFailed
+		message := runErr.Error()
+		run.Error = &message
+		errText = message
+	}
+	// The run is finished even when ctx was cancelled, so its status is not left running
+	if _, err := e.db.Exec(context.WithoutCancel(ctx),
+		`UPDATE project_retention_runs SET status = $1, messages_processed = $2, conversations_archived = $3, error = $4, finished_at = $5
+		WHERE id = $6`,
+		run.Status, run.MessagesProcessed, run.ConversationsArchived, errText, finishedAt, run.ID); err != nil {
+		log.Printf("Failed to record the end of retention run %s: %v", run.ID, err)
+	}
+
+	if run.MessagesProcessed > 0 || run.ConversationsArchived > 0 {
+		log.Printf("Retention for project %s: %d messages %s, %d conversations archived",
+			policy.projectID, run.MessagesProcessed, retentionVerbs[policy.mode], run.ConversationsArchived)
+	}
+	return run, runErr
+}
+
+// processProject runs batches until none is full, counting progress on run
+func (e *RetentionEnforcer) processProject(ctx context.Context, policy retentionPolicy, run *RetentionRun) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		found, conversationIDs, err := e.processBatch(ctx, policy, run.Cutoff)
+		if err != nil {
+			return err
+		}
+		run.MessagesProcessed += found
+		if found > 0 {
+			log.Printf("Retention for project %s: %d messages %s so far", policy.projectID, run.MessagesProcessed, retentionVerbs[policy.mode])
+		}
+
+		archived, err := e.archiveEmptied(ctx, policy, conversationIDs)
+		run.ConversationsArchived += archived
+		if err != nil {
+			return err
+		}
+		if found < e.batchSize {
+			return nil
+		}
+	}
+}
+
+// processBatch deletes or redacts up to batchSize messages of the project
+// created before cutoff. It returns how many messages it processed and the
+// conversations they belong to.
+func (e *RetentionEnforcer) processBatch(ctx context.Context, policy retentionPolicy, cutoff time.Time) (int, []interface{}, error) {
+	query := `SELECT m.id, m.conversation_id FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.project_id = $1 AND m.created_at < $2`
+	args := []interface{}{policy.projectID, cutoff}
+	if policy.mode == RetentionModeRedact {
+		query += " AND m.content <> $3"
+		args = append(args, RedactedContent)
+	}
+	if policy.exemptPinned {
+		query += " AND c.pinned = false"
+	}
+	query += fmt.Sprintf(" ORDER BY m.created_at, m.id LIMIT $%d", len(args)+1)
+	args = append(args, e.batchSize)
+
+	rows, err := e.db.Query(ctx, query, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to find old messages: %w", err)
+	}
+	var messageIDs, conversationIDs []interface{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var messageID, conversationID string
+		if err := rows.Scan(&messageID, &conversationID); err != nil {
+			rows.Close()
+			return 0, nil, fmt.Errorf("failed to scan message id: %w", err)
+		}
+		messageIDs = append(messageIDs, messageID)
+		if !seen[conversationID] {
+			seen[conversationID] = true
+			conversationIDs = append(conversationIDs, conversationID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	if len(messageIDs) == 0 {
+		return 0, nil, nil
+	}
+
+	ids := "(" + strings.Join(zdb.Placeholders(len(messageIDs), 1), ", ") + ")"
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resultFiles, err := messageToolResultFiles(ctx, tx, ids, messageIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM message_embeddings WHERE message_id IN "+ids, messageIDs...); err != nil {
+		return 0, nil, fmt.Errorf("failed to delete message embeddings: %w", err)
+	}
+
+	if policy.mode == RetentionModeDelete {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM tool_executions WHERE message_id IN "+ids, messageIDs...); err != nil {
+			return 0, nil, fmt.Errorf("failed to delete tool executions: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE id IN "+ids, messageIDs...); err != nil {
+			return 0, nil, fmt.Errorf("failed to delete messages: %w", err)
+		}
+	} else {
+		// Tool executions keep their name, status and timing but not their data
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE tool_executions SET arguments = NULL, result = NULL, result_file_path = NULL, error = NULL WHERE message_id IN "+ids,
+			messageIDs...); err != nil {
+			return 0, nil, fmt.Errorf("failed to redact tool executions: %w", err)
+		}
+		redactIDs := "(" + strings.Join(zdb.Placeholders(len(messageIDs), 2), ", ") + ")"
+		if _, err := tx.ExecContext(ctx, "UPDATE messages SET content = $1 WHERE id IN "+redactIDs,
+			append([]interface{}{RedactedContent}, messageIDs...)...); err != nil {
+			return 0, nil, fmt.Errorf("failed to redact messages: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	for _, path := range resultFiles {
+		removeToolResult(path)
+	}
+	for _, id := range conversationIDs {
+		e.history.Invalidate(id.(string))
+	}
+	return len(messageIDs), conversationIDs, nil
+}
+
+// archiveEmptied archives those of conversationIDs that have no original
+// message left, noting why, and returns how many it archived
+func (e *RetentionEnforcer) archiveEmptied(ctx context.Context, policy retentionPolicy, conversationIDs []interface{}) (int, error) {
+	if len(conversationIDs) == 0 {
+		return 0, nil
+	}
+
+	note := fmt.Sprintf("Every message was %s by the project's %d-day retention policy on %s",
+		retentionVerbs[policy.mode], policy.days, e.now().UTC().Format("2006-01-02"))
+	args := append([]interface{}{note, e.now().UTC(), RedactedContent}, conversationIDs...)
+	result, err := e.db.Exec(ctx,
+		`UPDATE conversations SET status = 'archived', retention_note = $1, updated_at = $2
+		WHERE status <> 'processing' AND status <> 'archived'
+		AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = conversations.id AND m.content <> $3)
+		AND id IN (`+strings.Join(zdb.Placeholders(len(conversationIDs), 4), ", ")+")",
+		args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive conversations: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return int(affected), nil
+}
+
+// messageToolResultFiles returns the result files of the tool executions of the given messages
+func messageToolResultFiles(ctx context.Context, tx *sql.Tx, ids string, messageIDs []interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT result_file_path FROM tool_executions WHERE result_file_path IS NOT NULL AND message_id IN "+ids,
+		messageIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tool results: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan tool result path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// retentionNow is the fake clock of the retention tests
+var retentionNow = time.Date(2026, 6, 1, 3, 0, 0, 0, time.UTC)
+
+// setupProjectRetentionDB adds projects with retention policies, embeddings and
+// retention runs to the retention schema. project-1 keeps messages 180 days in
+// the given mode; project-2 has no policy.
+func setupProjectRetentionDB(t *testing.T, mode string, exemptPinned bool) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx,
+		"UPDATE projects SET retention_days = 180, retention_mode = $1, retention_exempt_pinned = $2 WHERE id = 'project-1'",
+		mode, exemptPinned); err != nil {
+		t.Fatalf("Failed to set the retention policy: %v", err)
+	}
+	return conn
+}
+
+// seedRetentionConversation inserts a conversation with one message per age, in days
+func seedRetentionConversation(t *testing.T, conn tools.DBConnection, id, projectID string, pinned bool, ages ...int) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status, pinned, created_at, updated_at) VALUES ($1, 'Test', 'user-1', $2, 'completed', $3, $4, $4)",
+		id, projectID, pinned, retentionNow.AddDate(-1, 0, 0)); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+	for i, age := range ages {
+		messageID := id + "-m" + string(rune('a'+i))
+		createdAt := retentionNow.AddDate(0, 0, -age).Add(time.Duration(i) * time.Second)
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, 'user', 'secret figures', $3)",
+			messageID, id, createdAt); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO message_embeddings (message_id, conversation_id, project_id, model, dimensions, embedding, created_at) VALUES ($1, $2, $3, 'test', 2, '[1,0]', $4)",
+			messageID, id, projectID, createdAt); err != nil {
+			t.Fatalf("Failed to insert embedding: %v", err)
+		}
+	}
+}
+
+func newTestRetentionEnforcer(conn tools.DBConnection, batchSize int) *RetentionEnforcer {
+	enforcer := NewRetentionEnforcer(conn, batchSize)
+	enforcer.now = func() time.Time { return retentionNow }
+	return enforcer
+}
+
+func TestRetentionDeletesOldMessagesInBatches(t *testing.T) {
+	conn := setupProjectRetentionDB(t, RetentionModeDelete, false)
+	ctx := context.Background()
+	seedRetentionConversation(t, conn, "old", "project-1", false, 400, 300, 200, 190, 181)
+	seedRetentionConversation(t, conn, "mixed", "project-1", false, 200, 10)
+	seedRetentionConversation(t, conn, "other", "project-2", false, 400)
+
+	resultFile := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(resultFile, []byte(`{"rows":[]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write tool result: %v", err)
+	}
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, status, result_file_path, started_at) VALUES ('call-1', 'old-ma', 'old', 'database_query', 'completed', $1, $2)",
+		resultFile, retentionNow.AddDate(-1, 0, 0)); err != nil {
+		t.Fatalf("Failed to insert tool execution: %v", err)
+	}
+
+	processed, err := newTestRetentionEnforcer(conn, 2).EnforceOnce(ctx)
+	if err != nil {
+		t.Fatalf("EnforceOnce failed: %v", err)
+	}
+	if processed != 6 {
+		t.Errorf("Expected 6 deleted messages, got %d", processed)
+	}
+
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'old'"); n != 0 {
+		t.Errorf("Expected every old message deleted, %d remain", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'mixed'"); n != 1 {
+		t.Errorf("Expected the recent message kept, got %d messages", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'other'"); n != 1 {
+		t.Errorf("Expected a project without a policy untouched, got %d messages", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM message_embeddings WHERE project_id = 'project-1'"); n != 1 {
+		t.Errorf("Expected only the recent message's embedding kept, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM tool_executions"); n != 0 {
+		t.Errorf("Expected the tool execution deleted, got %d", n)
+	}
+	if _, err := os.Stat(resultFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the tool result file removed, got %v", err)
+	}
+
+	// Only the conversation left without messages is archived, with a note
+	var status, note string
+	if err := conn.QueryRow(ctx, "SELECT status, retention_note FROM conversations WHERE id = 'old'").Scan(&status, &note); err != nil {
+		t.Fatalf("Failed to read conversation: %v", err)
+	}
+	if status != "archived" || !strings.Contains(note, "180-day retention policy") {
+		t.Errorf("Expected the emptied conversation archived with a note, got %q %q", status, note)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations WHERE status = 'archived'"); n != 1 {
+		t.Errorf("Expected 1 archived conversation, got %d", n)
+	}
+
+	run, err := LatestRetentionRun(ctx, conn, "project-1")
+	if err != nil || run == nil {
+		t.Fatalf("Expected a recorded run, got %v (err %v)", run, err)
+	}
+	if run.Status != RetentionRunCompleted || run.MessagesProcessed != 6 || run.ConversationsArchived != 1 ||
+		run.Mode != RetentionModeDelete || run.FinishedAt == nil || !run.Cutoff.Equal(retentionNow.AddDate(0, 0, -180)) {
+		t.Errorf("Unexpected run %+v", run)
+	}
+	if run, err := LatestRetentionRun(ctx, conn, "project-2"); err != nil || run != nil {
+		t.Errorf("Expected no run for a project without a policy, got %v (err %v)", run, err)
+	}
+}
+
+func TestRetentionRedactsContent(t *testing.T) {
+	conn := setupProjectRetentionDB(t, RetentionModeRedact, false)
+	ctx := context.Background()
+	seedRetentionConversation(t, conn, "old", "project-1", false, 365, 200)
+	seedRetentionConversation(t, conn, "mixed", "project-1", false, 365, 1)
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result, started_at) VALUES ('call-1', 'old-ma', 'old', 'database_query', '{\"sql\":\"SELECT 1\"}', 'completed', '{\"rows\":[[1]]}', $1)",
+		retentionNow.AddDate(-1, 0, 0)); err != nil {
+		t.Fatalf("Failed to insert tool execution: %v", err)
+	}
+
+	enforcer := newTestRetentionEnforcer(conn, 500)
+	processed, err := enforcer.EnforceOnce(ctx)
+	if err != nil {
+		t.Fatalf("EnforceOnce failed: %v", err)
+	}
+	if processed != 3 {
+		t.Errorf("Expected 3 redacted messages, got %d", processed)
+	}
+
+	// Redacted messages keep their role and timestamp
+	var role, content string
+	var createdAt time.Time
+	if err := conn.QueryRow(ctx, "SELECT role, content, created_at FROM messages WHERE id = 'old-ma'").Scan(&role, &content, &createdAt); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if role != "user" || content != RedactedContent || !createdAt.Equal(retentionNow.AddDate(0, 0, -365)) {
+		t.Errorf("Expected a redacted user message at its original time, got %q %q %s", role, content, createdAt)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE content = 'secret figures'"); n != 1 {
+		t.Errorf("Expected only the recent message left as written, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages"); n != 4 {
+		t.Errorf("Expected redaction to keep every message, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM message_embeddings"); n != 1 {
+		t.Errorf("Expected embeddings of redacted messages deleted, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM tool_executions WHERE tool_name = 'database_query' AND result IS NULL AND arguments IS NULL"); n != 1 {
+		t.Errorf("Expected the tool execution kept without its data, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations WHERE status = 'archived'"); n != 1 {
+		t.Errorf("Expected only the fully redacted conversation archived, got %d", n)
+	}
+
+	// Redacted messages are not processed again
+	processed, err = enforcer.EnforceOnce(ctx)
+	if err != nil || processed != 0 {
+		t.Errorf("Expected a second run to find nothing, got %d (err %v)", processed, err)
+	}
+}
+
+func TestRetentionExemptsPinnedConversations(t *testing.T) {
+	conn := setupProjectRetentionDB(t, RetentionModeDelete, true)
+	ctx := context.Background()
+	seedRetentionConversation(t, conn, "pinned", "project-1", true, 400)
+	seedRetentionConversation(t, conn, "unpinned", "project-1", false, 400)
+
+	processed, err := newTestRetentionEnforcer(conn, 500).EnforceOnce(ctx)
+	if err != nil {
+		t.Fatalf("EnforceOnce failed: %v", err)
+	}
+	if processed != 1 {
+		t.Errorf("Expected 1 deleted message, got %d", processed)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = 'pinned'"); n != 1 {
+		t.Errorf("Expected the pinned conversation's message kept, got %d", n)
+	}
+
+	// Without the exemption pinned conversations are treated like any other
+	if _, err := conn.Exec(ctx, "UPDATE projects SET retention_exempt_pinned = false WHERE id = 'project-1'"); err != nil {
+		t.Fatalf("Failed to update project: %v", err)
+	}
+	if processed, err := newTestRetentionEnforcer(conn, 500).EnforceOnce(ctx); err != nil || processed != 1 {
+		t.Errorf("Expected the pinned message deleted, got %d (err %v)", processed, err)
+	}
+}
@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	msglib "zlay-backend/internal/messages"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrStreamNotFound is returned by ResumeStream when the conversation has no
+	// stream in memory for the user; the client should reload the conversation
+	ErrStreamNotFound = errors.New("no active stream for conversation")
+	// ErrStreamSeqAhead is returned when last_seq is beyond the last frame sent
+	ErrStreamSeqAhead = errors.New("last_seq is ahead of the stream")
+)
+
+// StreamResume is the replay sent in answer to resume_stream: the content of
+// every frame after FromSeq up to and including Seq
+type StreamResume struct {
+	ConversationID string
+	MessageID      string
+	FromSeq        int64
+	Seq            int64
+	Delta          string
+	Content        string // Full content up to Seq, sent to clients without stream_delta
+	Done           bool
+}
+
+// recordFrame assigns the next sequence number to an assistant_response frame
+// carrying the accumulated content and returns it with the frame's delta
+func (st *StreamState) recordFrame(content string, done bool) (int64, string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if len(st.seqOffsets) == 0 {
+		st.seqOffsets = []int{0}
+	}
+	previous := st.seqOffsets[len(st.seqOffsets)-1]
+	if len(content) < previous {
+		previous = len(content)
+	}
+	st.seq++
+	st.seqOffsets = append(st.seqOffsets, len(content))
+	st.sentContent = content
+	if done {
+		st.doneSeq = st.seq
+	}
+	return st.seq, content[previous:]
+}
+
+// currentSeq returns the sequence number of the last frame sent
+func (st *StreamState) currentSeq() int64 {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.seq
+}
+
+// resumeFrom builds the replay for a connection that received frames up to
+// lastSeq; the caller holds st.mu
+func (st *StreamState) resumeFrom(lastSeq int64) (*StreamResume, error) {
+	if lastSeq < 0 || lastSeq > st.seq {
+		return nil, fmt.Errorf("%w: last_seq %d, stream at %d", ErrStreamSeqAhead, lastSeq, st.seq)
+	}
+
+	start := 0
+	if lastSeq > 0 {
+		start = st.seqOffsets[lastSeq]
+	}
+	if start > len(st.sentContent) {
+		start = len(st.sentContent)
+	}
+
+	return &StreamResume{
+		ConversationID: st.conversationID,
+		MessageID:      st.messageID,
+		FromSeq:        lastSeq,
+		Seq:            st.seq,
+		Delta:          st.sentContent[start:],
+		Content:        st.sentContent,
+		Done:           st.doneSeq > 0,
+	}, nil
+}
+
+// ResumeStream replays what a reconnecting connection missed after lastSeq and
+// attaches it to the stream; any participant of the conversation may resume.
+// Frames sent while the replay is built may arrive again; clients drop any
+// frame whose seq they already hold.
+func (s *chatService) ResumeStream(conversationID, userID, connectionID string, lastSeq int64) (*StreamResume, error) {
+	streamState, exists := s.streamState(conversationID)
+	if !exists || !streamState.hasParticipant(userID) {
+		return nil, ErrStreamNotFound
+	}
+
+	streamState.mu.Lock()
+	resume, err := streamState.resumeFrom(lastSeq)
+	if err == nil {
+		streamState.attachLocked(connectionID, userID)
+		streamState.ackedSeqs[connectionID] = lastSeq
+	}
+	streamState.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Resumed stream %s on connection %s from seq %d to %d", conversationID, connectionID, lastSeq, resume.Seq)
+	return resume, nil
+}
+
+// Message builds the assistant_response frame carrying the replay
+func (r *StreamResume) Message() interface{} {
+	return newStreamFrame(&msglib.WebSocketMessage{
+		Type: "assistant_response",
+		Data: gin.H{
+			"conversation_id": r.ConversationID,
+			"message_id":      r.MessageID,
+			"content":         r.Content,
+			"delta":           r.Delta,
+			"seq":             r.Seq,
+			"from_seq":        r.FromSeq,
+			"resumed":         true,
+			"done":            r.Done,
+			"timestamp":       time.Now().UnixMilli(),
+		},
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// streamFrame is an assistant_response whose full content is dropped for
+// connections that negotiated msglib.CapabilityStreamDelta
+type streamFrame struct {
+	message *msglib.WebSocketMessage
+}
+
+func newStreamFrame(message *msglib.WebSocketMessage) *streamFrame {
+	return &streamFrame{message: message}
+}
+
+// MarshalJSON encodes the full frame for hubs that do not know about capabilities
+func (f *streamFrame) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.message)
+}
+
+// ForRecipient implements msglib.RecipientMessage
+func (f *streamFrame) ForRecipient(hasCapability func(capability string) bool) interface{} {
+	data, ok := f.message.Data.(gin.H)
+	if !ok || !hasCapability(msglib.CapabilityStreamDelta) {
+		return f.message
+	}
+
+	trimmed := make(gin.H, len(data))
+	for key, value := range data {
+		if key != "content" {
+			trimmed[key] = value
+		}
+	}
+	message := *f.message
+	message.Data = trimmed
+	return &message
+}
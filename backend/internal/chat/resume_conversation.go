@@ -0,0 +1,160 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"zlay-backend/internal/llm"
+)
+
+// ErrNotInterrupted is returned by ResumeConversation when the conversation's
+// last reply was not interrupted, so there is nothing to resume
+var ErrNotInterrupted = errors.New("conversation has no interrupted reply")
+
+// Values of the "resumed" metadata of a reply generated by ResumeConversation
+const (
+	ResumeContinued   = "continued"
+	ResumeRegenerated = "regenerated"
+)
+
+// resumedReply is the interrupted reply a resumed stream writes over
+type resumedReply struct {
+	message    *Message
+	continuing bool // Keep the partial content and continue after it
+}
+
+// restart returns the reply to stream into: the interrupted message's id with
+// its partial content when continuing, or no content when regenerating
+func (r *resumedReply) restart() *Message {
+	reply := *r.message
+	reply.Metadata = map[string]interface{}{"resumed": ResumeRegenerated}
+	reply.ToolCalls = make([]ToolCall, 0)
+	reply.CreatedAt = time.Now()
+	if r.continuing {
+		reply.Metadata["resumed"] = ResumeContinued
+	} else {
+		reply.Content = ""
+	}
+	return &reply
+}
+
+// ResumeConversation generates the reply of an interrupted conversation again,
+// without a new user message. The context is rebuilt from the history up to
+// and including the last user message. An interrupted partial reply is
+// continued from where it stopped when the LLM client supports an assistant
+// prefix, and regenerated from scratch otherwise; either way it keeps its
+// message id, so clients replace its content in place. A conversation
+// interrupted before any of the reply was saved gets a new reply.
+func (s *chatService) ResumeConversation(req *ChatRequest) error {
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	participant, err := IsParticipant(ctx, s.db, req.ConversationID, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to check conversation access: %w", err)
+	}
+	if !participant {
+		return ErrConversationNotFound
+	}
+
+	// A reply still generating, here or headless, is not resumed a second time
+	if err := s.reserveStream(req.ConversationID); err != nil {
+		return err
+	}
+	defer s.releaseStream(req.ConversationID)
+
+	var status string
+	err = s.db.QueryRow(ctx, "SELECT status FROM conversations WHERE id = $1 AND deleted_at IS NULL", req.ConversationID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load conversation status: %w", err)
+	}
+	if status != "interrupted" {
+		return ErrNotInterrupted
+	}
+
+	history, err := s.getConversationHistory(ctx, req.ConversationID, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation history: %w", err)
+	}
+	partial, history, err := interruptedReply(history)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.acquireStreamSlot(ctx, req)
+	if err != nil {
+		s.resetAfterRejection(req, err)
+		return err
+	}
+	defer release()
+
+	history = s.buildContext(ctx, req.ConversationID, history, req.ToolResultTokenLimit)
+	history = withLanguageDirective(req.ConversationID, history, s.conversationLanguage(ctx, req.ConversationID, ""))
+	history = s.withCitations(ctx, req, history)
+	messages := s.convertToOpenAIMessages(history)
+
+	var resumed *resumedReply
+	if partial != nil {
+		resumed = &resumedReply{message: partial}
+		if prefixer, ok := s.llmClient.(llm.AssistantPrefixer); ok && prefixer.SupportsAssistantPrefix() &&
+			partial.Content != "" && len(partial.ToolCalls) == 0 {
+			resumed.continuing = true
+			messages = append(messages, llm.AssistantPrefix(partial.Content))
+		}
+		log.Printf("Resuming interrupted reply %s of conversation %s (continue: %t)", partial.ID, req.ConversationID, resumed.continuing)
+	}
+
+	availableTools := s.toolRegistry.GetAvailableTools(req.ProjectID)
+	return s.streamReply(ctx, req, messages, s.convertTools(availableTools), resumed)
+}
+
+// interruptedReply splits history, oldest first, into the interrupted reply it
+// ends with and the history before it. A history ending with a user message
+// has no reply to replace and is returned whole.
+func interruptedReply(history []*Message) (*Message, []*Message, error) {
+	if len(history) == 0 {
+		return nil, nil, ErrNotInterrupted
+	}
+	last := history[len(history)-1]
+	switch {
+	case last.Role == "user":
+		return nil, history, nil
+	case last.Role == "assistant" && last.Metadata["interrupted"] == true:
+		if len(history) < 2 {
+			return nil, nil, ErrNotInterrupted
+		}
+		return last, history[:len(history)-1], nil
+	default:
+		return nil, nil, ErrNotInterrupted
+	}
+}
+
+// replaceMessage overwrites the saved message with msg's id
+func (s *chatService) replaceMessage(ctx context.Context, msg *Message) error {
+	toolCallsJSON, err := MarshalToolCalls(msg.ToolCalls)
+	if err != nil {
+		return err
+	}
+	metadataJSON, err := MarshalMetadata(msg.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx,
+		"UPDATE messages SET content = $1, metadata = $2, tool_calls = $3, created_at = $4 WHERE id = $5",
+		msg.Content, metadataJSON, toolCallsJSON, msg.CreatedAt, msg.ID)
+	s.history.Invalidate(msg.ConversationID)
+	return err
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// prefixLLMClient is a scriptedLLMClient whose provider may continue an
+// assistant prefix; it keeps the last request it was sent
+type prefixLLMClient struct {
+	scriptedLLMClient
+	prefix  bool
+	request *llm.LLMRequest
+}
+
+func (f *prefixLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	f.request = req
+	return f.scriptedLLMClient.StreamChat(ctx, req, callback)
+}
+
+func (f *prefixLLMClient) SupportsAssistantPrefix() bool { return f.prefix }
+
+// interruptConversation leaves conv-1 interrupted with a partial reply saved
+// after its user message, as a cancelled stream does
+func interruptConversation(t *testing.T, conn tools.DBConnection, content string) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at) VALUES ('partial-1', 'conv-1', 'assistant', $1, $2, '[]', $3)",
+		content, `{"interrupted":true,"interrupted_reason":"no connection received the reply"}`, time.Now().UTC().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to insert partial reply: %v", err)
+	}
+	setConversationStatus(t, conn, "interrupted")
+}
+
+func setConversationStatus(t *testing.T, conn tools.DBConnection, status string) {
+	t.Helper()
+	if _, err := conn.Exec(context.Background(), "UPDATE conversations SET status = $1 WHERE id = 'conv-1'", status); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+}
+
+// lastLLMMessage returns the JSON of the last message sent to the LLM
+func lastLLMMessage(t *testing.T, req *llm.LLMRequest) string {
+	t.Helper()
+	if req == nil || len(req.Messages) == 0 {
+		t.Fatal("Expected the LLM to be called with messages")
+	}
+	last := req.Messages[len(req.Messages)-1]
+	raw, err := json.Marshal(last)
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	return string(raw)
+}
+
+// completedMessageID returns the message_id of the completion broadcast
+func completedMessageID(hub *recordingHub) string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for _, event := range hub.events {
+		if event.Type == "assistant_response" && event.Target == "project" && event.Data["done"] == true {
+			id, _ := event.Data["message_id"].(string)
+			return id
+		}
+	}
+	return ""
+}
+
+func TestResumeConversationContinuesPartialReply(t *testing.T) {
+	client := &prefixLLMClient{scriptedLLMClient: scriptedLLMClient{chunks: []string{" is 42."}}, prefix: true}
+	service, conn := setupHeadlessService(t, client, time.Minute)
+	interruptConversation(t, conn, "The answer")
+
+	if err := service.ResumeConversation(userMessageRequest("")); err != nil {
+		t.Fatalf("ResumeConversation failed: %v", err)
+	}
+
+	// The partial reply is sent back as a prefix after the user message
+	raw := lastLLMMessage(t, client.request)
+	if !strings.Contains(raw, `"role":"assistant"`) || !strings.Contains(raw, `"prefix":true`) || !strings.Contains(raw, "The answer") {
+		t.Errorf("Expected the partial reply as an assistant prefix, got %s", raw)
+	}
+	if n := len(client.request.Messages); n != 2 {
+		t.Errorf("Expected the user message and the prefix, got %d messages", n)
+	}
+
+	reply := lastReply(t, service)
+	if reply == nil || reply.ID != "partial-1" || reply.Content != "The answer is 42." {
+		t.Fatalf("Expected the partial reply to be continued in place, got %+v", reply)
+	}
+	if reply.Metadata["resumed"] != ResumeContinued || reply.Metadata["interrupted"] != nil {
+		t.Errorf("Expected the reply to be marked continued, got %+v", reply.Metadata)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE role = 'assistant'"); n != 1 {
+		t.Errorf("Expected one reply, got %d", n)
+	}
+	if id := completedMessageID(service.hub.(*recordingHub)); id != "partial-1" {
+		t.Errorf("Expected the completion to carry the partial reply's id, got %q", id)
+	}
+	if status := conversationStatus(t, conn); status != "completed" {
+		t.Errorf("Expected the conversation to be completed, got %q", status)
+	}
+}
+
+func TestResumeConversationRegeneratesWithoutPrefix(t *testing.T) {
+	client := &prefixLLMClient{scriptedLLMClient: scriptedLLMClient{chunks: []string{"Forty-two."}}}
+	service, conn := setupHeadlessService(t, client, time.Minute)
+	interruptConversation(t, conn, "The answer")
+
+	if err := service.ResumeConversation(userMessageRequest("")); err != nil {
+		t.Fatalf("ResumeConversation failed: %v", err)
+	}
+
+	// The context ends with the user message, as when the reply first started
+	if raw := lastLLMMessage(t, client.request); !strings.Contains(raw, `"role":"user"`) || !strings.Contains(raw, "hello") {
+		t.Errorf("Expected the context to end with the user message, got %s", raw)
+	}
+
+	reply := lastReply(t, service)
+	if reply == nil || reply.ID != "partial-1" || reply.Content != "Forty-two." {
+		t.Fatalf("Expected the partial reply to be replaced in place, got %+v", reply)
+	}
+	if reply.Metadata["resumed"] != ResumeRegenerated {
+		t.Errorf("Expected the reply to be marked regenerated, got %+v", reply.Metadata)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE role = 'assistant'"); n != 1 {
+		t.Errorf("Expected one reply, got %d", n)
+	}
+	if status := conversationStatus(t, conn); status != "completed" {
+		t.Errorf("Expected the conversation to be completed, got %q", status)
+	}
+}
+
+func TestResumeConversationWithoutPartialReply(t *testing.T) {
+	client := &prefixLLMClient{scriptedLLMClient: scriptedLLMClient{chunks: []string{"Forty-two."}}, prefix: true}
+	service, conn := setupHeadlessService(t, client, time.Minute)
+	setConversationStatus(t, conn, "interrupted")
+
+	if err := service.ResumeConversation(userMessageRequest("")); err != nil {
+		t.Fatalf("ResumeConversation failed: %v", err)
+	}
+	if reply := lastReply(t, service); reply == nil || reply.Content != "Forty-two." || reply.Metadata["resumed"] != nil {
+		t.Errorf("Expected a new reply to the user message, got %+v", reply)
+	}
+}
+
+func TestResumeConversationRefused(t *testing.T) {
+	client := &prefixLLMClient{scriptedLLMClient: scriptedLLMClient{chunks: []string{"Forty-two."}}}
+	service, conn := setupHeadlessService(t, client, time.Minute)
+	interruptConversation(t, conn, "The answer")
+
+	// A reply already generating for the conversation
+	if err := service.reserveStream("conv-1"); err != nil {
+		t.Fatalf("reserveStream failed: %v", err)
+	}
+	if err := service.ResumeConversation(userMessageRequest("")); !errors.Is(err, ErrStreamAlreadyActive) {
+		t.Errorf("Expected ErrStreamAlreadyActive, got %v", err)
+	}
+	service.releaseStream("conv-1")
+
+	// A conversation that is not interrupted
+	setConversationStatus(t, conn, "completed")
+	if err := service.ResumeConversation(userMessageRequest("")); !errors.Is(err, ErrNotInterrupted) {
+		t.Errorf("Expected ErrNotInterrupted for a completed conversation, got %v", err)
+	}
+
+	// An interrupted conversation whose last reply is complete
+	if _, err := conn.Exec(context.Background(), "UPDATE messages SET metadata = '{}' WHERE id = 'partial-1'"); err != nil {
+		t.Fatalf("Failed to complete the reply: %v", err)
+	}
+	setConversationStatus(t, conn, "interrupted")
+	if err := service.ResumeConversation(userMessageRequest("")); !errors.Is(err, ErrNotInterrupted) {
+		t.Errorf("Expected ErrNotInterrupted for a complete reply, got %v", err)
+	}
+
+	outsider := userMessageRequest("")
+	outsider.UserID = "user-2"
+	if err := service.ResumeConversation(outsider); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound for another user, got %v", err)
+	}
+	if client.request != nil {
+		t.Error("Expected no refused resume to call the LLM")
+	}
+}
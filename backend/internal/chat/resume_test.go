@@ -0,0 +1,167 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/llm"
+	msglib "zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scriptedLLMClient streams the given chunks, calling afterChunk with the number
+// of chunks sent so far
+type scriptedLLMClient struct {
+	chunks     []string
+	afterChunk func(sent int)
+}
+
+func (f *scriptedLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	for i, content := range f.chunks {
+		if err := callback(&llm.StreamingChunk{Content: content}); err != nil {
+			return err
+		}
+		if f.afterChunk != nil {
+			f.afterChunk(i + 1)
+		}
+	}
+	return callback(&llm.StreamingChunk{Done: true})
+}
+
+func (f *scriptedLLMClient) Chat(ctx context.Context, req *llm.LLMRequest) (*llm.LLMResponse, error) {
+	return &llm.LLMResponse{Content: strings.Join(f.chunks, "")}, nil
+}
+
+func (f *scriptedLLMClient) SetModel(model string) error { return nil }
+
+func (f *scriptedLLMClient) GetModel() string { return "fake" }
+
+// resumeChunks are DefaultStreamFlushChars characters each, so every chunk is
+// sent as its own frame
+func resumeChunks(n int) []string {
+	chunks := make([]string, n)
+	for i := range chunks {
+		chunks[i] = strings.Repeat(string(rune('a'+i)), DefaultStreamFlushChars)
+	}
+	return chunks
+}
+
+func TestResumeStreamReconstructsContent(t *testing.T) {
+	chunks := resumeChunks(4)
+	want := strings.Join(chunks, "")
+	lastSeq := int64(len(chunks) + 1) // One frame per chunk plus the done frame
+
+	// The client loses the connection after frame disconnectAt and resumes on a new
+	// connection once the stream has reached frame reconnectAt
+	for disconnectAt := int64(0); disconnectAt <= lastSeq; disconnectAt++ {
+		for reconnectAt := disconnectAt; reconnectAt <= lastSeq; reconnectAt++ {
+			hub := &recordingHub{connections: map[string]bool{}}
+			conn := setupRetentionDB(t)
+			insertConversation(t, conn, "conv-1", nil)
+
+			client := &scriptedLLMClient{chunks: chunks}
+			service := NewChatService(conn, hub, client, tools.NewToolRegistry())
+
+			var resume *StreamResume
+			resumeAt := func() {
+				var err error
+				if resume, err = service.ResumeStream("conv-1", "user-1", "conn-2", disconnectAt); err != nil {
+					t.Fatalf("disconnect %d, reconnect %d: ResumeStream failed: %v", disconnectAt, reconnectAt, err)
+				}
+			}
+			client.afterChunk = func(sent int) {
+				if int64(sent) == reconnectAt {
+					resumeAt()
+				}
+			}
+			if err := service.ProcessUserMessage(userMessageRequest("")); err != nil {
+				t.Fatalf("ProcessUserMessage failed: %v", err)
+			}
+			if resume == nil {
+				// Reconnected after the stream finished
+				resumeAt()
+			}
+
+			var got strings.Builder
+			for _, frame := range hub.eventsOfType("assistant_response") {
+				if seq := int64(frame.Data["seq"].(float64)); seq <= disconnectAt {
+					got.WriteString(frame.Data["delta"].(string))
+				}
+			}
+			got.WriteString(resume.Delta)
+			for _, frame := range hub.eventsOfType("assistant_response") {
+				if seq := int64(frame.Data["seq"].(float64)); seq > resume.Seq {
+					got.WriteString(frame.Data["delta"].(string))
+				}
+			}
+
+			if got.String() != want {
+				t.Errorf("disconnect %d, reconnect %d: reconstructed %q, want %q", disconnectAt, reconnectAt, got.String(), want)
+			}
+			if resume.Content != want[:len(resume.Content)] {
+				t.Errorf("disconnect %d, reconnect %d: replay content is not a prefix of the reply", disconnectAt, reconnectAt)
+			}
+		}
+	}
+}
+
+func TestResumeStreamRejectsUnknownStreamsAndSequences(t *testing.T) {
+	hub := &recordingHub{connections: map[string]bool{}}
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	service := NewChatService(conn, hub, &scriptedLLMClient{chunks: resumeChunks(2)}, tools.NewToolRegistry())
+
+	if _, err := service.ResumeStream("conv-1", "user-1", "conn-2", 0); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("Expected ErrStreamNotFound before streaming, got %v", err)
+	}
+	if err := service.ProcessUserMessage(userMessageRequest("")); err != nil {
+		t.Fatalf("ProcessUserMessage failed: %v", err)
+	}
+
+	if _, err := service.ResumeStream("conv-1", "user-2", "conn-2", 0); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("Expected another user's resume to fail, got %v", err)
+	}
+	if _, err := service.ResumeStream("conv-1", "user-1", "conn-2", 4); !errors.Is(err, ErrStreamSeqAhead) {
+		t.Errorf("Expected ErrStreamSeqAhead, got %v", err)
+	}
+
+	resume, err := service.ResumeStream("conv-1", "user-1", "conn-2", 1)
+	if err != nil {
+		t.Fatalf("ResumeStream failed: %v", err)
+	}
+	if !resume.Done || resume.Seq != 3 || resume.Delta != strings.Repeat("b", DefaultStreamFlushChars) {
+		t.Errorf("Unexpected replay: %+v", resume)
+	}
+
+	state, _ := service.GetStreamState("conv-1")
+	if !slices.Contains(state.ActiveConnectionIDs, "conn-2") || state.AckedSeqs["conn-2"] != 1 {
+		t.Errorf("Expected conn-2 to be attached with acked seq 1, got %v / %v", state.ActiveConnectionIDs, state.AckedSeqs)
+	}
+}
+
+func TestStreamFrameDropsContentForDeltaClients(t *testing.T) {
+	frame := (&StreamResume{ConversationID: "conv-1", Seq: 2, Delta: "lo", Content: "Hello"}).Message()
+	recipient, ok := frame.(msglib.RecipientMessage)
+	if !ok {
+		t.Fatal("Expected stream frames to adapt to the recipient")
+	}
+
+	legacy := recipient.ForRecipient(func(string) bool { return false }).(*msglib.WebSocketMessage)
+	if data := legacy.Data.(gin.H); data["content"] != "Hello" || data["delta"] != "lo" {
+		t.Errorf("Expected legacy clients to get content and delta, got %+v", data)
+	}
+
+	delta := recipient.ForRecipient(func(c string) bool { return c == msglib.CapabilityStreamDelta }).(*msglib.WebSocketMessage)
+	data := delta.Data.(gin.H)
+	if _, hasContent := data["content"]; hasContent || data["seq"] != int64(2) {
+		t.Errorf("Expected stream_delta clients to get seq and delta only, got %+v", data)
+	}
+	if _, stillThere := legacy.Data.(gin.H)["content"]; !stillThere {
+		t.Error("Trimming the frame must not modify the shared message")
+	}
+}
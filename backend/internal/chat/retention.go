@@ -0,0 +1,206 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist, belongs to
+// another user, or is not in the state the operation expects
+var ErrConversationNotFound = errors.New("conversation not found")
+
+const (
+	// DefaultRetention is how long soft-deleted conversations can be restored
+	DefaultRetention = 30 * 24 * time.Hour
+	// DefaultPurgeBatchSize limits how many conversations one purge statement touches
+	DefaultPurgeBatchSize = 100
+)
+
+// PurgeConversation permanently deletes a conversation, its messages and the
+// results of its tool executions, regardless of whether it has been soft-deleted
+func PurgeConversation(ctx context.Context, db tools.DBConnection, conversationID string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resultFiles, err := toolResultFiles(ctx, tx, "id = $1", conversationID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tool_executions WHERE conversation_id = $1", conversationID); err != nil {
+		return fmt.Errorf("failed to delete tool executions: %w", err)
+	}
+	// Delete messages first (foreign key constraint)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE conversation_id = $1", conversationID); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM conversations WHERE id = $1", conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrConversationNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, path := range resultFiles {
+		removeToolResult(path)
+	}
+	return nil
+}
+
+// ConversationPurger permanently removes conversations that were soft-deleted
+// longer ago than the retention period. Work is done in small batches, each in
+// its own transaction, so the conversations table is never locked for long.
+type ConversationPurger struct {
+	db        tools.DBConnection
+	retention time.Duration
+	batchSize int
+	now       func() time.Time
+	history   *HistoryCache // Purged conversations are dropped from it
+}
+
+// NewConversationPurger creates a purger; non-positive values fall back to the defaults
+func NewConversationPurger(db tools.DBConnection, retention time.Duration, batchSize int) *ConversationPurger {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+	return &ConversationPurger{
+		db:        db,
+		retention: retention,
+		batchSize: batchSize,
+		now:       time.Now,
+	}
+}
+
+// SetHistoryCache sets the history cache of the chat service, which must not
+// keep serving purged conversations
+func (p *ConversationPurger) SetHistoryCache(cache *HistoryCache) {
+	p.history = cache
+}
+
+// Run purges expired conversations every interval until ctx is cancelled
+func (p *ConversationPurger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.PurgeOnce(ctx); err != nil {
+			log.Printf("Conversation purge failed after %d conversations: %v", purged, err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted conversations", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce deletes all conversations past retention, batch by batch, and returns how many were removed
+func (p *ConversationPurger) PurgeOnce(ctx context.Context) (int, error) {
+	cutoff := p.now().Add(-p.retention)
+	total := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		found, purged, err := p.purgeBatch(ctx, cutoff)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+		if found < p.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// purgeBatch deletes up to batchSize expired conversations with their messages
+// and tool executions.
+// It returns how many candidates were found and how many were actually purged.
+func (p *ConversationPurger) purgeBatch(ctx context.Context, cutoff time.Time) (int, int, error) {
+	rows, err := p.db.Query(ctx,
+		"SELECT id FROM conversations WHERE deleted_at IS NOT NULL AND deleted_at < $1 ORDER BY deleted_at LIMIT $2",
+		cutoff, p.batchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find expired conversations: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan conversation id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	// Re-check deleted_at in both statements so a conversation restored since the select is kept
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	expired := fmt.Sprintf("id IN (%s) AND deleted_at IS NOT NULL AND deleted_at < $%d", strings.Join(placeholders, ", "), len(ids)+1)
+	args := append(ids, cutoff)
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return len(ids), 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resultFiles, err := toolResultFiles(ctx, tx, expired, args...)
+	if err != nil {
+		return len(ids), 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tool_executions WHERE conversation_id IN (SELECT id FROM conversations WHERE "+expired+")", args...); err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete tool executions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE "+expired+")", args...); err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete messages: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM conversations WHERE "+expired, args...)
+	if err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete conversations: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return len(ids), 0, err
+	}
+	for _, path := range resultFiles {
+		removeToolResult(path)
+	}
+	for _, id := range ids {
+		p.history.Invalidate(id.(string))
+	}
+
+	affected, _ := result.RowsAffected()
+	return len(ids), int(affected), nil
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func setupRetentionDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1", "project-2")
+	dbtest.Seed(t, zdb, "INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-2', 'client-1', 'user-2', 'x')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+func insertConversation(t *testing.T, conn tools.DBConnection, id string, deletedAt interface{}) {
+	t.Helper()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at, deleted_at) VALUES ($1, 'Test', 'user-1', 'project-1', 'completed', $2, $2, $3)",
+		id, now, deletedAt); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, 'user', 'hello', $3)",
+		id+"-m1", id, now); err != nil {
+		t.Fatalf("Failed to insert message: %v", err)
+	}
+}
+
+func countRows(t *testing.T, conn tools.DBConnection, query string, args ...interface{}) int {
+	t.Helper()
+
+	var n int
+	if err := conn.QueryRow(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("Count query failed: %v", err)
+	}
+	return n
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	conn := setupRetentionDB(t)
+	service := &chatService{db: conn}
+	insertConversation(t, conn, "conv-1", nil)
+
+	if err := service.DeleteConversation("conv-1", "user-2"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound for another user, got %v", err)
+	}
+	if err := service.DeleteConversation("conv-1", "user-1"); err != nil {
+		t.Fatalf("DeleteConversation failed: %v", err)
+	}
+
+	// Deleted conversations disappear from list and get, but messages are kept
+	if conversations, err := service.GetConversations("user-1", "project-1"); err != nil || len(conversations) != 0 {
+		t.Errorf("Expected no listed conversations, got %d (err %v)", len(conversations), err)
+	}
+	if _, err := service.GetConversation("conv-1", "user-1"); err == nil {
+		t.Error("Expected deleted conversation to be hidden from GetConversation")
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE conversation_id = $1", "conv-1"); n != 1 {
+		t.Errorf("Expected messages to be kept after soft delete, got %d", n)
+	}
+
+	if err := service.RestoreConversation("conv-1", "user-1", "client-1", 0); err != nil {
+		t.Fatalf("RestoreConversation failed: %v", err)
+	}
+	if conversations, err := service.GetConversations("user-1", "project-1"); err != nil || len(conversations) != 1 {
+		t.Errorf("Expected restored conversation to be listed, got %d (err %v)", len(conversations), err)
+	}
+	if err := service.RestoreConversation("conv-1", "user-1", "client-1", 0); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound restoring a live conversation, got %v", err)
+	}
+}
+
+func TestRestoreConversationWithinRetention(t *testing.T) {
+	conn := setupRetentionDB(t)
+	service := &chatService{db: conn}
+	now := time.Now()
+	insertConversation(t, conn, "recent", now.Add(-time.Hour))
+	insertConversation(t, conn, "expired", now.Add(-48*time.Hour))
+
+	if err := service.RestoreConversation("expired", "user-1", "client-1", 24*time.Hour); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound past retention, got %v", err)
+	}
+	if err := service.RestoreConversation("recent", "user-1", "client-2", 24*time.Hour); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound from another client, got %v", err)
+	}
+	if err := service.RestoreConversation("recent", "user-1", "client-1", 24*time.Hour); err != nil {
+		t.Fatalf("RestoreConversation failed: %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations WHERE id = 'recent' AND deleted_at IS NULL AND version = 2"); n != 1 {
+		t.Error("Expected the restore to clear deleted_at and bump the version")
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations WHERE id = 'expired' AND deleted_at IS NOT NULL"); n != 1 {
+		t.Error("Expected the expired conversation to stay deleted")
+	}
+}
+
+func TestConversationPurgerRemovesExpiredInBatches(t *testing.T) {
+	conn := setupRetentionDB(t)
+	now := time.Now().UTC()
+
+	for i, id := range []string{"old-1", "old-2", "old-3", "old-4", "old-5"} {
+		insertConversation(t, conn, id, now.Add(-40*24*time.Hour).Add(time.Duration(i)*time.Minute))
+	}
+	insertConversation(t, conn, "recent", now.Add(-time.Hour))
+	insertConversation(t, conn, "live", nil)
+
+	purger := NewConversationPurger(conn, 30*24*time.Hour, 2)
+	purger.now = func() time.Time { return now }
+
+	purged, err := purger.PurgeOnce(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeOnce failed: %v", err)
+	}
+	if purged != 5 {
+		t.Errorf("Expected 5 purged conversations, got %d", purged)
+	}
+
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM conversations"); n != 2 {
+		t.Errorf("Expected 2 remaining conversations, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages"); n != 2 {
+		t.Errorf("Expected messages of purged conversations to be removed, got %d remaining", n)
+	}
+}
+
+func TestPurgeConversation(t *testing.T) {
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+
+	if err := PurgeConversation(context.Background(), conn, "conv-1"); err != nil {
+		t.Fatalf("PurgeConversation failed: %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages"); n != 0 {
+		t.Errorf("Expected messages to be removed, got %d", n)
+	}
+	if err := PurgeConversation(context.Background(), conn, "conv-1"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound, got %v", err)
+	}
+}
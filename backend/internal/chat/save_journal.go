@@ -0,0 +1,390 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/notify"
+)
+
+// Writes a save journal entry can hold
+const (
+	JournalInsertMessage      = "insert_message"
+	JournalReplaceMessage     = "replace_message" // A resumed reply
+	JournalConversationStatus = "conversation_status"
+)
+
+const (
+	// DefaultSaveJournalMaxAge is how long a journaled write is retried before it is abandoned
+	DefaultSaveJournalMaxAge = 24 * time.Hour
+	// saveRetryBaseDelay is the wait before a journaled write is first retried; it doubles per attempt
+	saveRetryBaseDelay = 5 * time.Second
+	// saveRetryMaxDelay caps the wait between retries of a journaled write
+	saveRetryMaxDelay = 5 * time.Minute
+	// SaveRetryInterval is how often RunSaveRetrier looks for writes due a retry
+	SaveRetryInterval = time.Second
+
+	// MetricSaveJournaled names the counter of writes the database refused and the journal kept, per client
+	MetricSaveJournaled = "chat_save_journaled"
+	// MetricSaveAbandoned names the counter of journaled writes given up on, per client
+	MetricSaveAbandoned = "chat_save_abandoned"
+)
+
+// JournalEntry is a message or conversation status write the database
+// refused, kept on disk until it is accepted
+type JournalEntry struct {
+	ID             string    `json:"id"`
+	Kind           string    `json:"kind"`
+	ClientID       string    `json:"client_id,omitempty"`
+	Message        *Message  `json:"message,omitempty"` // JournalInsertMessage and JournalReplaceMessage
+	ConversationID string    `json:"conversation_id,omitempty"`
+	UserID         string    `json:"user_id,omitempty"`
+	Status         string    `json:"status,omitempty"` // JournalConversationStatus
+	FailedAt       time.Time `json:"failed_at"`
+	Error          string    `json:"error"`
+
+	// Retry state; an entry read back at startup is due right away
+	attempts    int
+	nextAttempt time.Time
+}
+
+// SaveJournal is an append-only file of JSON lines holding writes that failed,
+// so a reply the user watched stream by is not lost to a database hiccup.
+// Appends are fsynced and safe for concurrent streams.
+type SaveJournal struct {
+	path   string
+	maxAge time.Duration
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	file    *os.File
+	entries []*JournalEntry
+}
+
+// OpenSaveJournal opens the journal at path, creating it and its directory if
+// needed, and loads the entries left by the previous process. A non-positive
+// maxAge uses DefaultSaveJournalMaxAge.
+func OpenSaveJournal(path string, maxAge time.Duration) (*SaveJournal, error) {
+	if maxAge <= 0 {
+		maxAge = DefaultSaveJournalMaxAge
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create save journal directory: %w", err)
+	}
+
+	journal := &SaveJournal{path: path, maxAge: maxAge, now: time.Now}
+	if err := journal.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open save journal: %w", err)
+	}
+	journal.file = file
+	return journal, nil
+}
+
+// load reads the entries already in the journal file, skipping torn lines
+func (j *SaveJournal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read save journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ID == "" {
+			log.Printf("Skipping unreadable save journal line in %s: %v", j.path, err)
+			continue
+		}
+		j.entries = append(j.entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read save journal: %w", err)
+	}
+	return nil
+}
+
+// Append durably records a failed write
+func (j *SaveJournal) Append(entry *JournalEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.FailedAt.IsZero() {
+		entry.FailedAt = j.now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode save journal entry: %w", err)
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to save journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync save journal: %w", err)
+	}
+	entry.nextAttempt = j.now().Add(saveRetryBaseDelay)
+	j.entries = append(j.entries, entry)
+	return nil
+}
+
+// Len returns how many writes are waiting to be retried
+func (j *SaveJournal) Len() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.entries)
+}
+
+// Close closes the journal file; entries not replayed yet stay in it
+func (j *SaveJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
+
+// due returns the entries whose retry is due
+func (j *SaveJournal) due(now time.Time) []*JournalEntry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var due []*JournalEntry
+	for _, entry := range j.entries {
+		if !now.Before(entry.nextAttempt) {
+			due = append(due, entry)
+		}
+	}
+	return due
+}
+
+// retryLater schedules the next attempt of an entry with exponential backoff
+func (j *SaveJournal) retryLater(entry *JournalEntry, now time.Time, cause error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry.Error = cause.Error()
+	entry.attempts++
+	delay := saveRetryBaseDelay << min(entry.attempts, 16)
+	entry.nextAttempt = now.Add(min(delay, saveRetryMaxDelay))
+}
+
+// remove drops finished entries and rewrites the file with the rest, which
+// truncates it once nothing is left. Entries appended meanwhile are kept.
+func (j *SaveJournal) remove(finished map[string]bool) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	kept := j.entries[:0]
+	for _, entry := range j.entries {
+		if !finished[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+	for i := len(kept); i < len(j.entries); i++ {
+		j.entries[i] = nil
+	}
+	j.entries = kept
+
+	temp := j.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite save journal: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range kept {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			writer.Write(append(line, '\n'))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to rewrite save journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync save journal: %w", err)
+	}
+	file.Close()
+	if err := os.Rename(temp, j.path); err != nil {
+		return fmt.Errorf("failed to replace save journal: %w", err)
+	}
+
+	// Appends go to the new file from now on
+	reopened, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen save journal: %w", err)
+	}
+	j.file.Close()
+	j.file = reopened
+	return nil
+}
+
+// abandon keeps an entry given up on in the journal's .abandoned file for operators
+func (j *SaveJournal) abandon(entry *JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path+".abandoned", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// SetSaveJournal keeps message and conversation status writes the database
+// refuses in journal, to be replayed by ReplaySaveJournal
+func (s *chatService) SetSaveJournal(journal *SaveJournal) {
+	s.journal = journal
+}
+
+// journalWrite records a failed write and reports whether it was kept
+func (s *chatService) journalWrite(entry *JournalEntry, cause error) bool {
+	if s.journal == nil {
+		return false
+	}
+	entry.Error = cause.Error()
+	if err := s.journal.Append(entry); err != nil {
+		log.Printf("❌ FAILED TO JOURNAL %s: %v", entry.Kind, err)
+		return false
+	}
+	metrics.Counter(MetricSaveJournaled).Inc(entry.ClientID)
+	log.Printf("Journaled %s %s for retry after: %v", entry.Kind, entry.ID, cause)
+	return true
+}
+
+// journalMessage records a message the database refused to store
+func (s *chatService) journalMessage(clientID, kind string, msg *Message, cause error) bool {
+	saved := *msg
+	return s.journalWrite(&JournalEntry{
+		Kind:           kind,
+		ClientID:       clientID,
+		Message:        &saved,
+		ConversationID: msg.ConversationID,
+	}, cause)
+}
+
+// ReplaySaveJournal retries the journaled writes that are due. Writes the
+// database accepts are dropped from the journal; writes older than the
+// journal's max age are abandoned and reported to operators. It returns how
+// many writes were replayed and how many are still waiting.
+func (s *chatService) ReplaySaveJournal(ctx context.Context) (int, int) {
+	if s.journal == nil {
+		return 0, 0
+	}
+
+	now := s.journal.now()
+	finished := make(map[string]bool)
+	replayed := 0
+	for _, entry := range s.journal.due(now) {
+		err := s.replayJournalEntry(ctx, entry)
+		switch {
+		case err == nil:
+			finished[entry.ID] = true
+			replayed++
+		case now.Sub(entry.FailedAt) >= s.journal.maxAge:
+			finished[entry.ID] = true
+			s.abandonJournalEntry(entry, err)
+		default:
+			s.journal.retryLater(entry, now, err)
+		}
+	}
+	if len(finished) > 0 {
+		if err := s.journal.remove(finished); err != nil {
+			log.Printf("❌ FAILED TO COMPACT SAVE JOURNAL: %v", err)
+		}
+	}
+	return replayed, s.journal.Len()
+}
+
+// RunSaveRetrier replays the save journal every interval until ctx is cancelled
+func (s *chatService) RunSaveRetrier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if replayed, remaining := s.ReplaySaveJournal(ctx); replayed > 0 {
+			log.Printf("Replayed %d journaled writes, %d still waiting", replayed, remaining)
+		}
+	}
+}
+
+// replayJournalEntry applies one journaled write. Replays are idempotent: a
+// message stored meanwhile is not inserted twice, and a status only applies to
+// a conversation not updated since the write failed.
+func (s *chatService) replayJournalEntry(ctx context.Context, entry *JournalEntry) error {
+	switch entry.Kind {
+	case JournalInsertMessage:
+		if entry.Message == nil {
+			return nil
+		}
+		var count int
+		if err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM messages WHERE id = $1", entry.Message.ID).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return s.saveMessage(ctx, entry.Message)
+	case JournalReplaceMessage:
+		if entry.Message == nil {
+			return nil
+		}
+		return s.replaceMessage(ctx, entry.Message)
+	case JournalConversationStatus:
+		_, err := s.db.Exec(ctx,
+			`UPDATE conversations SET status = $1, updated_at = $2
+			WHERE id = $3 AND deleted_at IS NULL AND updated_at <= $2`,
+			entry.Status, entry.FailedAt, entry.ConversationID)
+		return err
+	}
+	log.Printf("Dropping save journal entry %s of unknown kind %q", entry.ID, entry.Kind)
+	return nil
+}
+
+// abandonJournalEntry alerts operators to a write that never made it to the database
+func (s *chatService) abandonJournalEntry(entry *JournalEntry, cause error) {
+	entry.Error = cause.Error()
+	log.Printf("❌ save_abandoned: %s %s of conversation %s failed since %s: %v",
+		entry.Kind, entry.ID, entry.ConversationID, entry.FailedAt.Format(time.RFC3339), cause)
+	metrics.Counter(MetricSaveAbandoned).Inc(entry.ClientID)
+	if err := s.journal.abandon(entry); err != nil {
+		log.Printf("❌ FAILED TO KEEP ABANDONED WRITE %s: %v", entry.ID, err)
+	}
+	if s.notifier != nil {
+		s.notifier.SaveAbandoned(notify.SaveFailure{
+			ClientID:       entry.ClientID,
+			ConversationID: entry.ConversationID,
+			Kind:           entry.Kind,
+			FailedAt:       entry.FailedAt,
+			Error:          entry.Error,
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/tools"
+)
+
+// outageConn refuses every write while down, as a database that lost its
+// disk or its primary would; reads keep working
+type outageConn struct {
+	tools.DBConnection
+	down atomic.Bool
+}
+
+func (c *outageConn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c.down.Load() {
+		return nil, errors.New("database is unavailable")
+	}
+	return c.DBConnection.Exec(ctx, query, args...)
+}
+
+// setupJournalService returns a service whose writes fail until the outage is
+// over, journaling them to a file under a test clock
+func setupJournalService(t *testing.T, maxAge time.Duration) (*chatService, *outageConn, *SaveJournal, *time.Time) {
+	t.Helper()
+
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	outage := &outageConn{DBConnection: conn}
+	service := NewChatService(outage, fakeHub{}, &scriptedLLMClient{chunks: []string{"Hello", " world"}}, tools.NewToolRegistry())
+
+	journal, err := OpenSaveJournal(filepath.Join(t.TempDir(), "journal", "save_journal.jsonl"), maxAge)
+	if err != nil {
+		t.Fatalf("OpenSaveJournal failed: %v", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	clock := time.Now().UTC().Add(time.Minute)
+	journal.now = func() time.Time { return clock }
+	service.SetSaveJournal(journal)
+	return service, outage, journal, &clock
+}
+
+// journalEntries reads the entries in a journal file
+func journalEntries(t *testing.T, path string) []JournalEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Journal holds an unreadable line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestFailedSavesAreJournaledAndReplayed(t *testing.T) {
+	service, outage, journal, clock := setupJournalService(t, time.Hour)
+	before := metrics.Counter(MetricSaveJournaled).Snapshot()["client-journal"].Count
+
+	outage.down.Store(true)
+	req := userMessageRequest("")
+	req.ClientID = "client-journal"
+	if err := service.ProcessUserMessage(req); err != nil {
+		t.Fatalf("ProcessUserMessage failed during the outage: %v", err)
+	}
+
+	// The question, the streamed reply and the final status all wait on disk
+	entries := journalEntries(t, journal.path)
+	var question, reply *Message
+	var statuses []string
+	for _, entry := range entries {
+		switch {
+		case entry.Kind == JournalInsertMessage && entry.Message.Role == "user":
+			question = entry.Message
+		case entry.Kind == JournalInsertMessage && entry.Message.Role == "assistant":
+			reply = entry.Message
+		case entry.Kind == JournalConversationStatus:
+			statuses = append(statuses, entry.Status)
+		}
+		if entry.Error != "database is unavailable" {
+			t.Errorf("Expected the journal to keep the cause, got %q", entry.Error)
+		}
+	}
+	if question == nil || question.Content != "How many orders?" {
+		t.Fatalf("Expected the user message in the journal, got %+v", entries)
+	}
+	if reply == nil || reply.Content != "Hello world" {
+		t.Fatalf("Expected the streamed reply in the journal, got %+v", entries)
+	}
+	if len(statuses) == 0 || statuses[len(statuses)-1] != "completed" {
+		t.Errorf("Expected the completed status in the journal, got %v", statuses)
+	}
+	// Status updates do not know their client, so only the messages count against it
+	if after := metrics.Counter(MetricSaveJournaled).Snapshot()["client-journal"].Count; after != before+2 {
+		t.Errorf("Expected 2 journaled saves counted, got %d", after-before)
+	}
+
+	// Retries back off while the database is still down
+	*clock = clock.Add(saveRetryBaseDelay)
+	if replayed, remaining := service.ReplaySaveJournal(context.Background()); replayed != 0 || remaining != len(entries) {
+		t.Fatalf("Expected nothing replayed during the outage, got %d replayed and %d remaining", replayed, remaining)
+	}
+
+	outage.down.Store(false)
+	*clock = clock.Add(saveRetryMaxDelay)
+	if replayed, remaining := service.ReplaySaveJournal(context.Background()); replayed != len(entries) || remaining != 0 {
+		t.Fatalf("Expected every write replayed, got %d replayed and %d remaining", replayed, remaining)
+	}
+	if info, err := os.Stat(journal.path); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty journal after replay, got %v, %v", info, err)
+	}
+
+	conn := outage.DBConnection
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE id = $1 AND content = 'How many orders?'", question.ID); n != 1 {
+		t.Errorf("Expected the user message stored once, got %d", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE id = $1 AND content = 'Hello world'", reply.ID); n != 1 {
+		t.Errorf("Expected the reply stored once, got %d", n)
+	}
+	if status := conversationStatus(t, conn); status != "completed" {
+		t.Errorf("Expected the journaled status applied, got %q", status)
+	}
+
+	// Replaying an entry whose write already landed does not store it twice
+	if err := journal.Append(&JournalEntry{Kind: JournalInsertMessage, Message: reply, ConversationID: "conv-1"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	*clock = clock.Add(saveRetryMaxDelay)
+	if replayed, _ := service.ReplaySaveJournal(context.Background()); replayed != 1 {
+		t.Fatalf("Expected the duplicate entry to be dropped as replayed, got %d", replayed)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM messages WHERE id = $1", reply.ID); n != 1 {
+		t.Errorf("Expected the reply stored once after a duplicate replay, got %d", n)
+	}
+}
+
+func TestJournaledStatusDoesNotOverwriteNewerUpdate(t *testing.T) {
+	service, outage, journal, clock := setupJournalService(t, time.Hour)
+
+	outage.down.Store(true)
+	if err := service.UpdateConversationStatus("conv-1", "user-1", "processing"); err != nil {
+		t.Fatalf("Expected a journaled status update to succeed, got %v", err)
+	}
+	outage.down.Store(false)
+
+	// The conversation moved on before the journal was replayed
+	if _, err := outage.Exec(context.Background(),
+		"UPDATE conversations SET status = 'completed', updated_at = $1 WHERE id = 'conv-1'", clock.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to update conversation: %v", err)
+	}
+	*clock = clock.Add(saveRetryBaseDelay)
+	if replayed, remaining := service.ReplaySaveJournal(context.Background()); replayed != 1 || remaining != 0 {
+		t.Fatalf("Expected the status entry replayed, got %d replayed and %d remaining", replayed, remaining)
+	}
+	if status := conversationStatus(t, outage.DBConnection); status != "completed" {
+		t.Errorf("Expected the newer status kept, got %q", status)
+	}
+	if journal.Len() != 0 {
+		t.Errorf("Expected an empty journal, got %d entries", journal.Len())
+	}
+}
+
+func TestSaveJournalSurvivesRestart(t *testing.T) {
+	service, outage, journal, _ := setupJournalService(t, time.Hour)
+
+	outage.down.Store(true)
+	msg := &Message{ID: "msg-restart", ConversationID: "conv-1", Role: "assistant", Content: "Kept", CreatedAt: time.Now().UTC()}
+	if !service.journalMessage("client-1", JournalInsertMessage, msg, errors.New("connection reset")) {
+		t.Fatal("Expected the message to be journaled")
+	}
+	journal.Close()
+
+	// A new process replays the entries left behind right away
+	reopened, err := OpenSaveJournal(journal.path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenSaveJournal failed: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 1 {
+		t.Fatalf("Expected 1 entry after reopening, got %d", reopened.Len())
+	}
+	outage.down.Store(false)
+	service.SetSaveJournal(reopened)
+	if replayed, remaining := service.ReplaySaveJournal(context.Background()); replayed != 1 || remaining != 0 {
+		t.Fatalf("Expected the entry replayed at startup, got %d replayed and %d remaining", replayed, remaining)
+	}
+	if n := countRows(t, outage.DBConnection, "SELECT COUNT(*) FROM messages WHERE id = 'msg-restart'"); n != 1 {
+		t.Errorf("Expected the message stored, got %d", n)
+	}
+}
+
+func TestSaveJournalAbandonsOldEntries(t *testing.T) {
+	service, outage, journal, clock := setupJournalService(t, time.Hour)
+	before := metrics.Counter(MetricSaveAbandoned).Snapshot()["client-abandon"].Count
+
+	outage.down.Store(true)
+	msg := &Message{ID: "msg-lost", ConversationID: "conv-1", Role: "assistant", Content: "Lost", CreatedAt: time.Now().UTC()}
+	if !service.journalMessage("client-abandon", JournalInsertMessage, msg, errors.New("connection reset")) {
+		t.Fatal("Expected the message to be journaled")
+	}
+
+	*clock = clock.Add(time.Hour)
+	if replayed, remaining := service.ReplaySaveJournal(context.Background()); replayed != 0 || remaining != 0 {
+		t.Fatalf("Expected the entry abandoned, got %d replayed and %d remaining", replayed, remaining)
+	}
+	if after := metrics.Counter(MetricSaveAbandoned).Snapshot()["client-abandon"].Count; after != before+1 {
+		t.Errorf("Expected 1 abandoned save counted, got %d", after-before)
+	}
+	abandoned := journalEntries(t, journal.path+".abandoned")
+	if len(abandoned) != 1 || abandoned[0].Message == nil || abandoned[0].Message.Content != "Lost" {
+		t.Errorf("Expected the abandoned message kept for operators, got %+v", abandoned)
+	}
+	if abandoned[0].Error != "database is unavailable" {
+		t.Errorf("Expected the last failure recorded, got %q", abandoned[0].Error)
+	}
+	if entries := journalEntries(t, journal.path); len(entries) != 0 {
+		t.Errorf("Expected the journal emptied, got %+v", entries)
+	}
+}
@@ -2,83 +2,155 @@ package chat
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/embeddings"
 	"zlay-backend/internal/llm"
+	"zlay-backend/internal/metrics"
 	msglib "zlay-backend/internal/messages"
+	"zlay-backend/internal/notify"
+	"zlay-backend/internal/requestid"
 	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/openai/openai-go"
 )
 
-// StreamState tracks active streaming conversations
-type StreamState struct {
-	ConversationID     string    `json:"conversation_id"`
-	UserID           string    `json:"user_id"`
-	ProjectID        string    `json:"project_id"`
-	MessageID        string    `json:"message_id"`
-	CurrentContent   string    `json:"current_content"`
-	StartTime       time.Time `json:"start_time"`
-	LastChunk       time.Time `json:"last_chunk"`
-	IsActive        bool      `json:"is_active"`
-	
-	// 🔄 NEW: Track active connections for this stream
-	ActiveConnectionIDs map[string]bool `json:"active_connection_ids"`
-	Mutex              sync.RWMutex   `json:"-"`
-	
-	// 🔄 NEW: Track all connections that ever joined this stream (for persistence)
-	AllConnectionIDs    map[string]bool `json:"all_connection_ids"`
-}
+// MetricTimeToFirstToken names the latency recorder for time from stream start to first LLM chunk
+const MetricTimeToFirstToken = "llm_time_to_first_token"
+
+// MetricLLMRateLimited names the counter of rate-limited LLM requests per client
+const MetricLLMRateLimited = "llm_rate_limited"
 
 // ChatService interface defines chat operations
 type ChatService interface {
 	ProcessUserMessage(req *ChatRequest) error
-	CreateConversation(userID, projectID, title string) (*Conversation, error)
+	CreateConversation(userID, projectID, title string, settings ModelSettings) (*Conversation, error)
 	GetConversations(userID, projectID string) ([]*Conversation, error)
 	GetConversation(conversationID, userID string) (*ConversationDetails, error)
+	GetConversationPage(conversationID, userID string, page MessagePage) (*ConversationDetails, error)
 	DeleteConversation(conversationID, userID string) error
+	RestoreConversation(conversationID, userID, clientID string, retention time.Duration) error
 	WithLLMClient(llmClient llm.LLMClient) ChatService
 	
 	// 🔄 NEW: Streaming state management
-	GetStreamState(conversationID string) (*StreamState, error)
-	GetAllActiveStreams() map[string]*StreamState
+	GetStreamState(conversationID string) (*StreamStateSnapshot, error)
+	GetAllActiveStreams() map[string]StreamStateSnapshot
 	ClearStreamState(conversationID string) error
 	GetConversationStatus(conversationID, userID string) (gin.H, error)
 	
 	// 🔄 NEW: Connection management for streaming
-	AttachConnectionToStream(conversationID, connectionID string) error
+	AttachConnectionToStream(conversationID, connectionID, userID string) error
 	DetachConnectionFromStream(conversationID, connectionID string) error
 	SendStreamToActiveConnections(conversationID string, message interface{}) error
+	ResumeStream(conversationID, userID, connectionID string, lastSeq int64) (*StreamResume, error)
+	ResumeConversation(req *ChatRequest) error
 	
 	// 🔄 NEW: Load streaming conversation (including partial messages)
 	LoadStreamingConversation(conversationID, userID string) (*ConversationDetails, error)
 	
 	// 🔄 NEW: Get only the active streaming message from memory
-	GetActiveStreamingMessage(conversationID, userID string) (*StreamState, error)
+	GetActiveStreamingMessage(conversationID, userID string) (*StreamStateSnapshot, error)
 	
 	// 🔄 NEW: Update conversation status
 	UpdateConversationStatus(conversationID, userID, status string) error
+
+	// Read-only view of a conversation through a share link
+	GetConversationForShare(ctx context.Context, token string) (*SharedConversation, error)
+
+	// Drafts of conversations in draft mode, for project editors
+	ApproveDraft(ctx context.Context, userID, messageID string, content *string) (*Message, error)
+	DiscardDraft(ctx context.Context, userID, messageID string) (*Message, error)
 }
 
 // chatService implements ChatService interface
 type chatService struct {
 	db           tools.DBConnection
+	// Serves listings and history when set; see reader
+	readDB       tools.DBConnection
 	hub          msglib.Hub
 	llmClient    llm.LLMClient
 	toolRegistry tools.ToolRegistry
 	
-	// 🔄 NEW: Streaming state tracking
+	// 🔄 NEW: Streaming state tracking. Shared by reference with every
+	// WithLLMClient copy and guarded by streamingMutex
 	activeStreams map[string]*StreamState
-	streamingMutex sync.RWMutex
+	streamingMutex *sync.RWMutex
+
+	// Conversations whose user message is being processed; guarded by streamingMutex
+	pendingStreams map[string]bool
+	// Recently accepted client message IDs for fast duplicate detection
+	recentMessages *recentMessageIDs
+	// Decoded recent messages of active conversations; nil reads history every turn
+	history *HistoryCache
+	// Per-client cap on concurrent LLM streams
+	streamLimiter *StreamLimiter
+	// Receives lifecycle events for outbound webhooks; nil disables them
+	events webhooks.Publisher
+	// Records failed tool executions in the project activity feed; nil disables it
+	activity *activity.Recorder
+	// Emails operators about repeated LLM failures; nil disables it
+	notifier *notify.Notifier
+	// Embeds saved assistant messages for conversation search; nil disables it
+	embeddings *embeddings.Indexer
+	// How often partial content is sent and how long completed streams stay resumable
+	streamOptions StreamOptions
+	// Rewrite messages read with legacy metadata or tool_calls JSON
+	migrateMessageJSON bool
+	// Where tool results too large for tool_executions are written
+	toolResults ToolResultStorage
+	// Keeps message and status writes the database refused for replay; nil loses them
+	journal *SaveJournal
+	// Pause a client's streams for the delay its rate-limited provider asks for
+	rateLimitCooldown bool
+	// Clock for the abandoned conversation sweep; replaced in tests
+	now func() time.Time
+	// Cancelled by Stop, which cancels every reply still generating
+	lifetime context.Context
+	stop     context.CancelCauseFunc
+}
+
+const (
+	// DefaultStreamRetention is how long a completed stream stays in memory for resume
+	DefaultStreamRetention = 30 * time.Second
+)
+
+// StreamOptions tunes streaming; zero values use the defaults
+type StreamOptions struct {
+	// Used for clients without their own policy
+	Flush     StreamFlushPolicy
+	Retention time.Duration
+	// How long a reply keeps generating with no connection receiving it once its request ended
+	HeadlessGrace time.Duration
+}
+
+func (o StreamOptions) withDefaults() StreamOptions {
+	o.Flush = o.Flush.withDefaults(StreamFlushPolicy{
+		Chars:    DefaultStreamFlushChars,
+		Interval: DefaultStreamFlushInterval,
+	})
+	if o.Retention <= 0 {
+		o.Retention = DefaultStreamRetention
+	}
+	if o.HeadlessGrace <= 0 {
+		o.HeadlessGrace = DefaultHeadlessGrace
+	}
+	return o
 }
 
 	// 🔄 NEW: Initialize streaming state tracking when creating chat service
 func NewChatService(db tools.DBConnection, hub msglib.Hub, llmClient llm.LLMClient, toolRegistry tools.ToolRegistry) *chatService {
+	lifetime, stop := context.WithCancelCause(context.Background())
 	return &chatService{
 		db:           db,
 		hub:          hub,
@@ -86,52 +158,151 @@ func NewChatService(db tools.DBConnection, hub msglib.Hub, llmClient llm.LLMClie
 		toolRegistry: toolRegistry,
 		
 		// 🔄 NEW: Initialize streaming tracking
-		activeStreams: make(map[string]*StreamState),
+		activeStreams:  make(map[string]*StreamState),
+		streamingMutex: &sync.RWMutex{},
+		pendingStreams: make(map[string]bool),
+		recentMessages: newRecentMessageIDs(),
+		history:        NewHistoryCache(DefaultHistoryCacheConversations, DefaultHistoryCacheBytes),
+		streamLimiter:  NewStreamLimiter(DefaultMaxQueuedStreams, DefaultQueueTimeout),
+		streamOptions:  StreamOptions{}.withDefaults(),
+		now:            time.Now,
+		lifetime:       lifetime,
+		stop:           stop,
+	}
+}
+
+// SetStreamLimiter replaces the per-client stream limiter; call before serving requests
+func (s *chatService) SetStreamLimiter(limiter *StreamLimiter) {
+	s.streamLimiter = limiter
+}
+
+// SetStreamOptions replaces the streaming options; call before serving requests
+func (s *chatService) SetStreamOptions(options StreamOptions) {
+	s.streamOptions = options.withDefaults()
+}
+
+// SetHistoryCache replaces the conversation history cache; nil disables it.
+// Call it before serving requests.
+func (s *chatService) SetHistoryCache(cache *HistoryCache) {
+	s.history = cache
+}
+
+// SetReadConnection sets the connection, such as a read replica, that serves
+// conversation listings and history. Call it before serving requests.
+func (s *chatService) SetReadConnection(conn tools.DBConnection) {
+	s.readDB = conn
+}
+
+// reader returns the connection for reads that tolerate replica lag. Writes,
+// and reads that must see a write made earlier in the same request, use s.db.
+func (s *chatService) reader() tools.DBConnection {
+	if s.readDB != nil {
+		return s.readDB
+	}
+	return s.db
+}
+
+// SetRateLimitCooldown sets whether a client rate limited by its LLM provider
+// gets no new streams until the delay the provider asked for has passed
+func (s *chatService) SetRateLimitCooldown(enabled bool) {
+	s.rateLimitCooldown = enabled
+}
+
+// SetEventPublisher sets where conversation lifecycle events are published for webhooks
+func (s *chatService) SetEventPublisher(events webhooks.Publisher) {
+	s.events = events
+}
+
+// SetActivityRecorder sets where project activity events are recorded
+func (s *chatService) SetActivityRecorder(recorder *activity.Recorder) {
+	s.activity = recorder
+}
+
+// SetNotifier sets who is told about LLM stream failures for operator emails
+func (s *chatService) SetNotifier(notifier *notify.Notifier) {
+	s.notifier = notifier
+}
+
+// SetEmbeddingIndexer sets where saved assistant messages are queued for embedding
+func (s *chatService) SetEmbeddingIndexer(indexer *embeddings.Indexer) {
+	s.embeddings = indexer
+}
+
+// indexMessage queues a saved assistant message for embedding with the client's
+// LLM provider, if it can embed. Drafts are indexed once approved. It never
+// blocks or fails the caller.
+func (s *chatService) indexMessage(req *ChatRequest, msg *Message) {
+	if s.embeddings == nil || msg.Status == MessageStatusDraft {
+		return
+	}
+	embedder, ok := s.llmClient.(llm.Embedder)
+	if !ok {
+		return
 	}
+	s.embeddings.Enqueue(embedder, embeddings.Message{
+		ID:             msg.ID,
+		ConversationID: msg.ConversationID,
+		ProjectID:      req.ProjectID,
+		Content:        msg.Content,
+	})
 }
 
-// WithLLMClient returns a new chat service instance with the specified LLM client
+// publishEvent hands a lifecycle event to the webhook publisher without blocking
+func (s *chatService) publishEvent(eventType string, req *ChatRequest, data map[string]interface{}) {
+	if s.events == nil || req.ClientID == "" {
+		return
+	}
+	data["conversation_id"] = req.ConversationID
+	data["user_id"] = req.UserID
+	s.events.Publish(webhooks.NewEvent(eventType, req.ClientID, req.ProjectID, data))
+}
+
+// reportLLMFailure counts a failed stream towards the client's failure email
+func (s *chatService) reportLLMFailure(req *ChatRequest, model string, err error) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.LLMFailed(req.ClientID, notify.LLMFailure{
+		At:             time.Now(),
+		ConversationID: req.ConversationID,
+		ProjectID:      req.ProjectID,
+		Model:          model,
+		Error:          err.Error(),
+	})
+}
+
+// WithLLMClient returns a new chat service instance with the specified LLM client.
+// Streaming state and duplicate detection are shared with the original service so
+// streams started through either instance are visible to both.
 func (s *chatService) WithLLMClient(llmClient llm.LLMClient) ChatService {
-	// Create a copy of the service with the new LLM client
 	newService := &chatService{
 		db:           s.db,
+		readDB:       s.readDB,
 		hub:          s.hub,
 		llmClient:    llmClient,
 		toolRegistry: s.toolRegistry,
-		
-		// 🔄 NEW: Copy streaming state
-		activeStreams: make(map[string]*StreamState),
-	}
-	
-	// Copy existing streaming state
-	s.streamingMutex.RLock()
-	for convID, streamState := range s.activeStreams {
-		// Create deep copy with connection tracking
-		streamCopy := &StreamState{
-			ConversationID:     streamState.ConversationID,
-			UserID:            streamState.UserID,
-			ProjectID:         streamState.ProjectID,
-			MessageID:         streamState.MessageID,
-			CurrentContent:     streamState.CurrentContent,
-			StartTime:         streamState.StartTime,
-			LastChunk:         streamState.LastChunk,
-			IsActive:          streamState.IsActive,
-			ActiveConnectionIDs: make(map[string]bool),
-			AllConnectionIDs:    make(map[string]bool),  // 🔄 NEW: Copy all connections
-			Mutex:             sync.RWMutex{},
-		}
-		
-		// Copy existing connection IDs
-		streamState.Mutex.RLock()
-		for connID := range streamState.AllConnectionIDs {  // 🔄 NEW: Copy all connections
-			streamCopy.AllConnectionIDs[connID] = true
-		}
-		streamState.Mutex.RUnlock()
-		
-		newService.activeStreams[convID] = streamCopy
+
+		activeStreams:  s.activeStreams,
+		streamingMutex: s.streamingMutex,
+		pendingStreams: s.pendingStreams,
+		recentMessages: s.recentMessages,
+		history:        s.history,
+		streamLimiter:  s.streamLimiter,
+		events:         s.events,
+		activity:       s.activity,
+		notifier:       s.notifier,
+		embeddings:     s.embeddings,
+		streamOptions:  s.streamOptions,
+		toolResults:    s.toolResults,
+		journal:        s.journal,
+		now:            s.now,
+
+		migrateMessageJSON: s.migrateMessageJSON,
+		rateLimitCooldown:  s.rateLimitCooldown,
+		lifetime:       s.lifetime,
+		stop:           s.stop,
 	}
-	s.streamingMutex.RUnlock()
-	
+
 	// Cast to interface type to satisfy return signature
 	return ChatService(newService)
 }
@@ -144,20 +315,74 @@ func (s *chatService) ProcessUserMessage(req *ChatRequest) error {
 	log.Printf("   • Project ID: %s", req.ProjectID)
 	log.Printf("   • Content: \"%s\"", req.Content)
 	log.Printf("   • Connection ID: %s", req.ConnectionID)
+	log.Printf("   • Request ID: %s", requestid.From(req.Context))
 	log.Printf("   • Content Length: %d chars", len(req.Content))
 
-	ctx := context.Background()
+	// Work before the reply starts ends with the request; the reply itself may outlive it
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Only the conversation's participants may post to it
+	participant, err := IsParticipant(ctx, s.db, req.ConversationID, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to check conversation access: %w", err)
+	}
+	if !participant {
+		return ErrConversationNotFound
+	}
+
+	// Reject resends of a message that was already accepted (e.g. after a reconnect)
+	if req.ClientMessageID != "" {
+		if _, err := uuid.Parse(req.ClientMessageID); err != nil {
+			return ErrInvalidClientMessageID
+		}
+		if err := s.claimClientMessageID(ctx, req.ConversationID, req.ClientMessageID); err != nil {
+			return err
+		}
+	}
+
+	// Only one response may stream per conversation at a time
+	if err := s.reserveStream(req.ConversationID); err != nil {
+		if req.ClientMessageID != "" {
+			s.recentMessages.release(req.ConversationID, req.ClientMessageID)
+		}
+		return err
+	}
+	defer s.releaseStream(req.ConversationID)
 
 	// Create and save user message
 	log.Printf("💾 CREATING AND SAVING USER MESSAGE...")
 	userMsg := NewMessage(req.ConversationID, "user", req.Content, req.UserID, req.ProjectID)
+	userMsg.ClientMessageID = req.ClientMessageID
 	log.Printf("   • Message ID: %s", userMsg.ID)
 	log.Printf("   • Role: %s", userMsg.Role)
 	log.Printf("   • Created At: %s", userMsg.CreatedAt.Format(time.RFC3339))
 
+	userMsgJournaled := false
 	if err := s.saveMessage(ctx, userMsg); err != nil {
-		log.Printf("❌ FAILED TO SAVE USER MESSAGE: %v", err)
-		return fmt.Errorf("failed to save user message: %w", err)
+		if req.ClientMessageID != "" {
+			// The unique index catches duplicates the cache missed (e.g. another instance)
+			if existingID, found := s.findMessageByClientID(ctx, req.ConversationID, req.ClientMessageID); found {
+				return &DuplicateMessageError{ConversationID: req.ConversationID, ClientMessageID: req.ClientMessageID, MessageID: existingID}
+			}
+		}
+		requestid.Logf(ctx, "❌ FAILED TO SAVE USER MESSAGE: %v", err)
+		// A journaled message is stored once the database recovers, so the reply goes on
+		if !s.journalMessage(req.ClientID, JournalInsertMessage, userMsg, err) {
+			if req.ClientMessageID != "" {
+				s.recentMessages.release(req.ConversationID, req.ClientMessageID)
+			}
+			return fmt.Errorf("failed to save user message: %w", err)
+		}
+		userMsgJournaled = true
+	}
+	if req.ClientMessageID != "" {
+		s.recentMessages.setMessageID(req.ConversationID, req.ClientMessageID, userMsg.ID)
 	}
 	log.Printf("✅ USER MESSAGE SAVED SUCCESSFULLY")
 
@@ -171,24 +396,44 @@ func (s *chatService) ProcessUserMessage(req *ChatRequest) error {
 		},
 		Timestamp: time.Now().UnixMilli(),
 	}
-	s.hub.BroadcastToProject(req.ProjectID, broadcastMsg)
+	s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, broadcastMsg))
 	log.Printf("✅ USER MESSAGE BROADCASTED")
 
+	// Wait for one of the client's stream slots; the slot is freed however the stream ends
+	release, err := s.acquireStreamSlot(ctx, req)
+	if err != nil {
+		s.resetAfterRejection(req, err)
+		return err
+	}
+	defer release()
+
 	// Get conversation history for context
 	log.Printf("📚 FETCHING CONVERSATION HISTORY FOR CONTEXT...")
 	history, err := s.getConversationHistory(ctx, req.ConversationID, req.UserID)
 	if err != nil {
-		log.Printf("❌ FAILED TO GET CONVERSATION HISTORY: %v", err)
+		requestid.Logf(ctx, "❌ FAILED TO GET CONVERSATION HISTORY: %v", err)
 		return fmt.Errorf("failed to get conversation history: %w", err)
 	}
 	log.Printf("✅ CONVERSATION HISTORY LOADED: %d messages", len(history))
+	if userMsgJournaled {
+		// Not in the database yet, but the reply must answer it
+		history = append(history, userMsg)
+	}
+
+	// Fit the most recent messages into the model's context window
+	history = s.buildContext(ctx, req.ConversationID, history, req.ToolResultTokenLimit)
+
+	// Keep replies in the conversation's language, pinned from its first detectable message
+	language := s.conversationLanguage(ctx, req.ConversationID, req.Content)
+	history = withLanguageDirective(req.ConversationID, history, language)
+	history = s.withCitations(ctx, req, history)
 
 	// Get available tools for this project
 	log.Printf("🔧 FETCHING AVAILABLE TOOLS FOR PROJECT %s", req.ProjectID)
 	availableTools := s.toolRegistry.GetAvailableTools(req.ProjectID)
 	log.Printf("✅ TOOLS LOADED: %d tools available", len(availableTools))
 	for i, tool := range availableTools {
-		log.Printf("   • Tool %d: %s - %s", i+1, tool.Name, tool.Description)
+		log.Printf("   • Tool %d: %s - %s", i+1, tool.Name(), tool.Description())
 	}
 
 	// Convert messages to OpenAI format
@@ -201,46 +446,26 @@ func (s *chatService) ProcessUserMessage(req *ChatRequest) error {
 	return s.streamLLMResponse(ctx, req, openaiMessages, s.convertTools(availableTools))
 }
 
-// CreateConversation creates a new conversation
-func (s *chatService) CreateConversation(userID, projectID, title string) (*Conversation, error) {
-	ctx := context.Background()
-	conversation := NewConversation(projectID, userID, title, "completed")
-
-	// Save to database
-	query := `
-		INSERT INTO conversations (id, project_id, user_id, title, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, project_id, user_id, title, status, created_at, updated_at
-	`
-
-	var conv Conversation
-	err := s.db.QueryRow(ctx, query,
-		conversation.ID, conversation.ProjectID, conversation.UserID,
-		conversation.Title, conversation.Status, conversation.CreatedAt, conversation.UpdatedAt,
-	).Scan(
-		&conv.ID, &conv.ProjectID, &conv.UserID,
-		&conv.Title, &conv.Status, &conv.CreatedAt, &conv.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create conversation: %w", err)
-	}
-
-	return &conv, nil
+// CreateConversation creates a new conversation. settings are stored as given;
+// callers validate them against the client's allowlist first.
+func (s *chatService) CreateConversation(userID, projectID, title string, settings ModelSettings) (*Conversation, error) {
+	return InsertConversation(context.Background(), s.db, userID, projectID, title, settings)
 }
 
-// GetConversations retrieves all conversations for a user and project
+// GetConversations retrieves the conversations a user takes part in within a project
 func (s *chatService) GetConversations(userID, projectID string) ([]*Conversation, error) {
 	ctx := context.Background()
 
 	query := `
-		SELECT id, project_id, user_id, title, status, created_at, updated_at
-		FROM conversations
-		WHERE user_id = $1 AND project_id = $2
-		ORDER BY updated_at DESC
+		SELECT c.id, c.project_id, c.user_id, c.title, c.status, c.pinned, c.pinned_at, c.forked_from_conversation_id, c.language,
+			c.drafts_enabled, c.created_at, c.updated_at, c.version, ` + ConversationSummaryColumns + `
+		FROM conversations c
+		` + ConversationSummaryJoin + `
+		WHERE ` + isParticipantCondition(1) + ` AND c.project_id = $2 AND c.deleted_at IS NULL
+		ORDER BY c.pinned DESC, c.pinned_at DESC, c.updated_at DESC
 	`
 
-	rows, err := s.db.Query(ctx, query, userID, projectID)
+	rows, err := s.reader().Query(ctx, query, userID, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get conversations: %w", err)
 	}
@@ -249,129 +474,224 @@ func (s *chatService) GetConversations(userID, projectID string) ([]*Conversatio
 	var conversations []*Conversation
 	for rows.Next() {
 		var conv Conversation
+		var pinnedAt sql.NullTime
+		var forkedFrom, language, preview sql.NullString
 		if err := rows.Scan(
-			&conv.ID, &conv.ProjectID, &conv.UserID,
-			&conv.Title, &conv.Status, &conv.CreatedAt, &conv.UpdatedAt,
+			&conv.ID, &conv.ProjectID, &conv.UserID, &conv.Title, &conv.Status,
+			&conv.Pinned, &pinnedAt, &forkedFrom, &language, &conv.DraftsEnabled, &conv.CreatedAt, &conv.UpdatedAt, &conv.Version,
+			&conv.MessageCount, &preview,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan conversation: %w", err)
 		}
+		if pinnedAt.Valid {
+			conv.PinnedAt = &pinnedAt.Time
+		}
+		if forkedFrom.Valid {
+			conv.ForkedFromConversationID = &forkedFrom.String
+		}
+		if language.Valid {
+			conv.Language = &language.String
+		}
+		conv.LastMessagePreview = preview.String
 		conversations = append(conversations, &conv)
 	}
 
 	return conversations, nil
 }
 
-// GetConversation retrieves a specific conversation with messages
+// GetConversation retrieves a conversation the user takes part in, with its messages
 func (s *chatService) GetConversation(conversationID, userID string) (*ConversationDetails, error) {
-	ctx := context.Background()
-
-	// Get conversation details
-	convQuery := `
-		SELECT id, project_id, user_id, title, status, created_at, updated_at
-		FROM conversations
-		WHERE id = $1 AND user_id = $2
-	`
-
-	var conversation Conversation
-	err := s.db.QueryRow(ctx, convQuery, conversationID, userID).Scan(
-		&conversation.ID, &conversation.ProjectID, &conversation.UserID,
-		&conversation.Title, &conversation.Status, &conversation.CreatedAt, &conversation.UpdatedAt,
-	)
+	return s.getConversation(context.Background(), s.reader(), conversationID, userID)
+}
 
+// getConversation is GetConversation reading from conn. Legacy JSON is still
+// rewritten on the primary.
+func (s *chatService) getConversation(ctx context.Context, conn tools.DBConnection, conversationID, userID string) (*ConversationDetails, error) {
+	details, err := s.loadConversation(ctx, conn, conversationID, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conversation: %w", err)
+		return nil, err
 	}
 
 	// Get messages for conversation
 	msgQuery := `
-		SELECT id, conversation_id, role, content, metadata, tool_calls, created_at
+		SELECT id, conversation_id, role, content, metadata, tool_calls, created_at, user_id, status
 		FROM messages
 		WHERE conversation_id = $1
-		ORDER BY created_at ASC
+		ORDER BY created_at ASC, id ASC
 	`
 
-	rows, err := s.db.Query(ctx, msgQuery, conversationID)
+	rows, err := conn.Query(ctx, msgQuery, conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []*Message
-	for rows.Next() {
-		var msg Message
-		var toolCallsJSON []byte
-		var metadataJSON []byte
+	messages, legacy, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	s.migrateLegacyMessages(ctx, legacy)
+	if err := attachToolExecutions(ctx, conn, conversationID, messages); err != nil {
+		return nil, err
+	}
+	details.Messages = messages
+	details.TotalMessageCount = len(messages)
+	return details, nil
+}
 
-		if err := rows.Scan(
-			&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content,
-			&metadataJSON, &toolCallsJSON, &msg.CreatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
+// loadConversation loads a conversation the user takes part in from conn, with
+// its message summary and effective model settings but without messages
+func (s *chatService) loadConversation(ctx context.Context, conn tools.DBConnection, conversationID, userID string) (*ConversationDetails, error) {
+	convQuery := `
+		SELECT c.id, c.project_id, c.user_id, c.title, c.status, c.model, c.temperature, c.max_tokens, c.language, c.drafts_enabled, c.created_at, c.updated_at, c.version,
+			(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND m.role IN ('user', 'assistant')),
+			(SELECT SUBSTR(m.content, 1, 120) FROM messages m
+			WHERE m.conversation_id = c.id AND m.role IN ('user', 'assistant') AND m.content <> ''
+			ORDER BY m.created_at DESC, m.id DESC LIMIT 1)
+		FROM conversations c
+		WHERE c.id = $1 AND ` + isParticipantCondition(2) + ` AND c.deleted_at IS NULL
+	`
 
-		// Parse JSON fields
-		if len(metadataJSON) > 0 {
-			json.Unmarshal(metadataJSON, &msg.Metadata)
-		}
-		if len(toolCallsJSON) > 0 {
-			json.Unmarshal(toolCallsJSON, &msg.ToolCalls)
-		}
+	var conversation Conversation
+	var model, language, preview sql.NullString
+	var temperature sql.NullFloat64
+	var maxTokens sql.NullInt64
+	err := conn.QueryRow(ctx, convQuery, conversationID, userID).Scan(
+		&conversation.ID, &conversation.ProjectID, &conversation.UserID,
+		&conversation.Title, &conversation.Status, &model, &temperature, &maxTokens, &language, &conversation.DraftsEnabled,
+		&conversation.CreatedAt, &conversation.UpdatedAt, &conversation.Version,
+		&conversation.MessageCount, &preview,
+	)
 
-		messages = append(messages, &msg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
 	}
+	conversation.LastMessagePreview = preview.String
+	if language.Valid {
+		conversation.Language = &language.String
+	}
+	settings := settingsFromColumns(model, temperature, maxTokens)
+	conversation.Model, conversation.Temperature, conversation.MaxTokens = settings.Model, settings.Temperature, settings.MaxTokens
 
+	effective := s.effectiveSettings(settings)
 	return &ConversationDetails{
 		Conversation: &conversation,
-		Messages:     messages,
+		Messages:     []*Message{},
 		ToolStatus:   make(map[string]string),
+		Settings:     &effective,
 	}, nil
 }
 
-// DeleteConversation deletes a conversation and its messages
+// scanMessage reads one row selecting the columns of MessagePageQuery,
+// reporting whether its JSON was stored in a legacy shape
+func scanMessage(rows *sql.Rows) (*Message, bool, error) {
+	var msg Message
+	var toolCallsJSON []byte
+	var metadataJSON []byte
+	var senderID sql.NullString
+
+	if err := rows.Scan(
+		&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content,
+		&metadataJSON, &toolCallsJSON, &msg.CreatedAt, &senderID, &msg.Status,
+	); err != nil {
+		return nil, false, fmt.Errorf("failed to scan message: %w", err)
+	}
+	msg.UserID = senderID.String
+
+	legacy := decodeMessageJSON(&msg, metadataJSON, toolCallsJSON)
+	return &msg, legacy, nil
+}
+
+// DeleteConversation soft-deletes a conversation. Messages are kept so the
+// conversation can be restored until the purge job removes it after the retention period.
+// Only the owner can delete; other participants get ErrNotConversationOwner.
 func (s *chatService) DeleteConversation(conversationID, userID string) error {
 	ctx := context.Background()
 
-	tx, err := s.db.Begin(ctx)
+	result, err := s.db.Exec(ctx,
+		"UPDATE conversations SET deleted_at = $1 WHERE id = $2 AND user_id = $3 AND deleted_at IS NULL",
+		time.Now(), conversationID, userID)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		if participant, _ := IsParticipant(ctx, s.db, conversationID, userID); participant {
+			return ErrNotConversationOwner
+		}
+		return ErrConversationNotFound
 	}
-	defer tx.Rollback()
+	s.history.Invalidate(conversationID)
 
-	// Delete messages first (foreign key constraint)
-	_, err = tx.Exec("DELETE FROM messages WHERE conversation_id = $1", conversationID)
-	if err != nil {
-		return fmt.Errorf("failed to delete messages: %w", err)
+	// Stop tools still running for the deleted conversation
+	if s.toolRegistry == nil {
+		return nil
+	}
+	if cancelled := s.toolRegistry.CancelConversationTools(conversationID); cancelled > 0 {
+		log.Printf("Cancelled %d tool executions of deleted conversation %s", cancelled, conversationID)
+	}
+	return nil
+}
+
+// RestoreConversation undoes a soft delete of a conversation of the user in
+// the client. Conversations deleted longer than retention ago are left to the
+// purger; a non-positive retention falls back to DefaultRetention.
+func (s *chatService) RestoreConversation(conversationID, userID, clientID string, retention time.Duration) error {
+	ctx := context.Background()
+	if retention <= 0 {
+		retention = DefaultRetention
 	}
 
-	// Delete conversation
-	_, err = tx.Exec("DELETE FROM conversations WHERE id = $1 AND user_id = $2", conversationID, userID)
+	result, err := s.db.Exec(ctx,
+		`UPDATE conversations SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL AND deleted_at >= $3
+		AND user_id IN (SELECT id FROM users WHERE client_id = $4)`,
+		conversationID, userID, time.Now().Add(-retention), clientID)
 	if err != nil {
-		return fmt.Errorf("failed to delete conversation: %w", err)
+		return fmt.Errorf("failed to restore conversation: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrConversationNotFound
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-// UpdateConversationStatus updates the status of a conversation
+// UpdateConversationStatus updates the status of a conversation the user takes part in
 func (s *chatService) UpdateConversationStatus(conversationID, userID, status string) error {
 	ctx := context.Background()
 	
 	query := `
 		UPDATE conversations 
 		SET status = $1, updated_at = $2
-		WHERE id = $3 AND user_id = $4
+		WHERE id = $3 AND deleted_at IS NULL AND (user_id = $4 OR EXISTS (
+			SELECT 1 FROM conversation_participants cp WHERE cp.conversation_id = conversations.id AND cp.user_id = $4))
 	`
 	
 	_, err := s.db.Exec(ctx, query, status, time.Now(), conversationID, userID)
 	if err != nil {
+		// A journaled status is applied once the database recovers
+		if s.journalWrite(&JournalEntry{Kind: JournalConversationStatus, ConversationID: conversationID, UserID: userID, Status: status}, err) {
+			return nil
+		}
 		return fmt.Errorf("failed to update conversation status: %w", err)
 	}
 	
 	return nil
 }
 
-// streamLLMResponse streams LLM response to WebSocket
+// streamLLMResponse streams LLM response to WebSocket. Generation and tool
+// calls run on a headlessContext derived from ctx; what the reply produced is
+// saved on a persistContext, so it is kept even when generation was cancelled.
 func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, messages []openai.ChatCompletionMessageParamUnion, tools []Tool) error {
+	return s.streamReply(ctx, req, messages, tools, nil)
+}
+
+// streamReply is streamLLMResponse into a new reply, or into the interrupted
+// reply being resumed when resumed is set
+func (s *chatService) streamReply(ctx context.Context, req *ChatRequest, messages []openai.ChatCompletionMessageParamUnion, tools []Tool, resumed *resumedReply) error {
 	log.Printf("🌊 streamLLMResponse CALLED:")
 	log.Printf("   • Conversation ID: %s", req.ConversationID)
 	log.Printf("   • User ID: %s", req.UserID)
@@ -379,6 +699,7 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 	log.Printf("   • Messages Count: %d", len(messages))
 	log.Printf("   • Tools Count: %d", len(tools))
 	log.Printf("   • Connection ID: %s", req.ConnectionID)
+	log.Printf("   • Request ID: %s", requestid.From(ctx))
 
 	// Set conversation status to processing when streaming starts
 	log.Printf("📊 SETTING CONVERSATION STATUS TO 'processing'...")
@@ -401,29 +722,48 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 		})
 	}
 
+	// Apply the conversation's model overrides over the client defaults
+	settings, settingsErr := s.conversationSettings(ctx, req.ConversationID)
+	if settingsErr != nil {
+		log.Printf("Failed to load model settings for conversation %s, using defaults: %v", req.ConversationID, settingsErr)
+	}
+	effective := s.effectiveSettings(settings)
+
 	// Create LLM request
 	llmReq := &llm.LLMRequest{
 		Messages:    messages,
 		Tools:       openaiTools,
-		MaxTokens:   4000,
-		Temperature: 0.7,
+		Model:       effective.Model,
+		MaxTokens:   effective.MaxTokens,
+		Temperature: float32(effective.Temperature),
 	}
 
-	// Create assistant message placeholder
+	// The client's content filters redact the reply before it is sent or saved
+	redactor := s.contentFilters(ctx, req).NewStream()
+
+	// Create assistant message placeholder; a resumed reply keeps its id and row
 	assistantMsg := NewMessage(req.ConversationID, "assistant", "", req.UserID, req.ProjectID)
+	storeReply, replyKind := s.saveMessage, JournalInsertMessage
+	if resumed != nil {
+		assistantMsg = resumed.restart()
+		storeReply, replyKind = s.replaceMessage, JournalReplaceMessage
+	} else if s.draftsEnabled(ctx, req.ConversationID) {
+		// Streamed as usual, but kept out of later turns until an editor approves it
+		assistantMsg.Status = MessageStatusDraft
+	}
 
 	// 🔄 NEW: Initialize streaming state tracking
-	streamState := &StreamState{
-		ConversationID:     req.ConversationID,
-		UserID:            req.UserID,
-		ProjectID:         req.ProjectID,
-		MessageID:         assistantMsg.ID,
-		CurrentContent:     "",
-		StartTime:         time.Now(),
-		IsActive:          true,
-		ActiveConnectionIDs: make(map[string]bool),
-		AllConnectionIDs:    make(map[string]bool),  // 🔄 NEW: Track all connections
-		Mutex:             sync.RWMutex{},
+	streamState := newStreamState(req.ConversationID, req.UserID, req.ProjectID, assistantMsg.ID)
+	if assistantMsg.Content != "" {
+		// A continued reply is streamed whole, so clients replace the partial content
+		streamState.appendContent(assistantMsg.Content)
+	}
+
+	// Everyone in the conversation receives the reply, not only the sender
+	if participantIDs, err := ConversationParticipantIDs(ctx, s.db, req.ConversationID); err != nil {
+		log.Printf("Failed to load participants of conversation %s, streaming to the sender only: %v", req.ConversationID, err)
+	} else {
+		streamState.setParticipants(participantIDs)
 	}
 
 	// 🔄 NEW: Add streaming state to tracking BEFORE creating callback
@@ -433,29 +773,47 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 	
 	// 🔄 NEW: Add the originating connection to active connections for this stream
 	if req.ConnectionID != "" {
-		streamState.Mutex.Lock()
-		streamState.ActiveConnectionIDs[req.ConnectionID] = true
-		streamState.AllConnectionIDs[req.ConnectionID] = true  // 🔄 NEW: Track all connections
-		streamState.Mutex.Unlock()
+		streamState.attach(req.ConnectionID, req.UserID)
 		log.Printf("🔄 Added connection %s to stream %s", req.ConnectionID, req.ConversationID)
 	}
 	
 	log.Printf("🔄 Started tracking streaming state for conversation: %s", req.ConversationID)
 
+	streamCtx, stopStream := s.headlessContext(ctx, streamState)
+	defer stopStream()
+
+	// Let the UI show a thinking indicator until the first token arrives
+	model := effective.Model
+	s.sendToStreamRecipients(streamState, msglib.ForRequest(ctx, WebSocketMessage{
+		Type: "assistant_thinking",
+		Data: AssistantThinkingData{
+			ConversationID: req.ConversationID,
+			MessageID:      assistantMsg.ID,
+			Model:          model,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	}))
+	firstTokenSent := false
+
 	// Start streaming response
 	streamStarted := false
-	tokenCount := 0
-	lastSentLength := 0
+	flusher := newStreamFlusher(req.FlushPolicy.withDefaults(s.streamOptions.Flush), s.now)
+	timer := NewStreamTimer(s.now)
+	budgetExceeded := false
 
 	callback := func(chunk *llm.StreamingChunk) error {
-		// 🔥 DETAILED LOGGING: Log every chunk received from LLM
-		log.Printf("📦 LLM CHUNK RECEIVED:")
-		log.Printf("   • Content: \"%s\"", chunk.Content)
-		log.Printf("   • Content Length: %d", len(chunk.Content))
-		log.Printf("   • Done: %t", chunk.Done)
-		log.Printf("   • Tokens Used: %d", chunk.TokensUsed)
-		log.Printf("   • Tool Calls: %v", chunk.ToolCalls)
-		log.Printf("   • Stream Started: %t", streamStarted)
+		// 🔥 DETAILED LOGGING: Log every chunk received from LLM when stream debugging is on
+		if llm.DebugStream {
+			log.Printf("📦 LLM CHUNK RECEIVED:")
+			log.Printf("   • Content: \"%s\"", chunk.Content)
+			log.Printf("   • Content Length: %d", len(chunk.Content))
+			log.Printf("   • Done: %t", chunk.Done)
+			log.Printf("   • Tokens Used: %d", chunk.TokensUsed)
+			log.Printf("   • Tool Calls: %v", chunk.ToolCalls)
+			log.Printf("   • Stream Started: %t", streamStarted)
+		}
+
+		timer.Chunk(chunk)
 
 		// Track token usage
 		var chunkTokens int64 = 0
@@ -463,6 +821,23 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 			chunkTokens = int64(chunk.TokensUsed)
 		}
 
+		// Report time to first token once, before the first chunk is forwarded
+		if !firstTokenSent && (chunk.Content != "" || chunk.ToolCalls != nil) {
+			firstTokenSent = true
+			ttft := time.Since(streamState.StartTime())
+			metrics.Latency(MetricTimeToFirstToken).Observe(ttft)
+			s.sendToStreamRecipients(streamState, msglib.ForRequest(ctx, WebSocketMessage{
+				Type: "assistant_first_token",
+				Data: AssistantFirstTokenData{
+					ConversationID: req.ConversationID,
+					MessageID:      assistantMsg.ID,
+					Model:          model,
+					TTFTMs:         ttft.Milliseconds(),
+				},
+				Timestamp: time.Now().UnixMilli(),
+			}))
+		}
+
 		// Log first chunk and completion
 		if !streamStarted && chunk.Content != "" {
 			log.Printf("🎯 Chat service: Starting to stream chunk to WebSocket for conversation %s", req.ConversationID)
@@ -473,37 +848,19 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 			log.Printf("🎯 Chat service: Final chunk processed, broadcasting to WebSocket for conversation %s", req.ConversationID)
 		}
 
-		// 🔄 CRITICAL: Update the streaming state stored in activeStreams map
-		if chunk.Content != "" {
-			s.streamingMutex.RLock()
-			if activeStream, exists := s.activeStreams[req.ConversationID]; exists {
-				activeStream.CurrentContent += chunk.Content
-				activeStream.LastChunk = time.Now()
-				// Also update local reference for consistency
-				streamState.CurrentContent = activeStream.CurrentContent
-				streamState.LastChunk = activeStream.LastChunk
-				
-				// Count tokens (rough estimation: 1 token ≈ 4 characters)
-				tokenCount += len(chunk.Content) / 4
-				if len(chunk.Content) % 4 != 0 {
-					tokenCount += 1
-				}
-				
-				// 🔥 DEBUG: Log content updates
-				log.Printf("🔥 DEBUG: Updated streaming content for %s: '%s' (total length: %d, token count: %d)", 
-					req.ConversationID, activeStream.CurrentContent, len(activeStream.CurrentContent), tokenCount)
-			} else {
-				// Fallback: update local state if not in map
-				streamState.CurrentContent += chunk.Content
-				streamState.LastChunk = time.Now()
-				// Count tokens for fallback
-				tokenCount += len(chunk.Content) / 4
-				if len(chunk.Content) % 4 != 0 {
-					tokenCount += 1
-				}
-				log.Printf("🔥 DEBUG: Stream state not found in map, updated local state")
-			}
-			s.streamingMutex.RUnlock()  // 🔥 FIX: Use RUnlock() for RLock()
+		// Filters hold back the end of the content until no match can span it
+		content := redactor.Write(chunk.Content)
+		if chunk.Done {
+			content += redactor.Flush()
+		}
+
+		// 🔄 CRITICAL: Update the streaming state so reconnecting clients see the partial reply
+		if content != "" {
+			accumulated := streamState.appendContent(content)
+
+			// 🔥 DEBUG: Log content updates
+			log.Printf("🔥 DEBUG: Updated streaming content for %s: '%s' (total length: %d)",
+				req.ConversationID, accumulated, len(accumulated))
 		}
 
 		// Check token limit using connection reference
@@ -518,14 +875,19 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 					errorResponse := msglib.NewWebSocketMessage(
 						"error",
 						gin.H{
-							"error": "Token limit exceeded",
-							"code": "TOKEN_LIMIT_EXCEEDED",
+							"error": apierror.Message(apierror.CodeTokenLimitExceeded, apierror.DefaultLanguage, nil),
+							"code": apierror.CodeTokenLimitExceeded,
 							"conversation_id": req.ConversationID,
 						},
 						tokensUsed, tokensLimit, tokensRemaining,
 					)
 					errorResponse.Timestamp = time.Now().UnixMilli()
-					s.hub.BroadcastToProject(req.ProjectID, errorResponse)
+					s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, errorResponse))
+					s.publishEvent(webhooks.EventTokenBudgetExceeded, req, map[string]interface{}{
+						"tokens_used":  tokensUsed,
+						"tokens_limit": tokensLimit,
+					})
+					budgetExceeded = true
 					return fmt.Errorf("token limit exceeded for connection %s", req.ConnectionID)
 				}
 				// Get updated token usage after adding tokens
@@ -537,38 +899,26 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 		}
 
 		// Accumulate content
-		if chunk.Content != "" {
-			assistantMsg.Content += chunk.Content
+		if content != "" {
+			assistantMsg.Content += content
 			assistantMsg.CreatedAt = time.Now()
 		}
 
-		// 🔄 NEW: Determine if we should send accumulated content (every 30 tokens or on completion)
-		// Send when: first chunk, every 30 tokens, OR when remaining tokens would never trigger another batch
-	shouldSend := chunk.Done || (tokenCount > 0 && tokenCount % 30 == 0) || (!streamStarted && chunk.Content != "")
+		// Send the first content at once, then whenever the flush policy's size or
+		// interval is reached, and always on completion
+		shouldSend := flusher.add(content, chunk.Done)
 		
 		if shouldSend {
 			// Get accumulated content from stream state
-			s.streamingMutex.RLock()
-			var accumulatedContent string
-			if activeStream, exists := s.activeStreams[req.ConversationID]; exists {
-				accumulatedContent = activeStream.CurrentContent
-			} else {
-				accumulatedContent = streamState.CurrentContent
-			}
-			s.streamingMutex.RUnlock()
+			accumulatedContent := streamState.Content()
 
 			// Calculate how much new content we're sending
-			newContent := ""
-			if len(accumulatedContent) > lastSentLength {
-				newContent = accumulatedContent[lastSentLength:]
-				lastSentLength = len(accumulatedContent)
-			}
+			seq, newContent := streamState.recordFrame(accumulatedContent, chunk.Done)
 
 			// 🔥 DETAILED LOGGING: Create WebSocket response message with accumulated content
 			log.Printf("📨 CREATING WEBSOCKET RESPONSE MESSAGE:")
 			log.Printf("   • Conversation ID: %s", req.ConversationID)
 			log.Printf("   • Should Send: %t", shouldSend)
-			log.Printf("   • Token Count: %d", tokenCount)
 			log.Printf("   • Accumulated Content: \"%s\"", accumulatedContent)
 			log.Printf("   • New Content Since Last Send: \"%s\"", newContent)
 			log.Printf("   • New Content Length: %d", len(newContent))
@@ -582,6 +932,8 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 				Data: gin.H{
 					"conversation_id": req.ConversationID,
 					"content":         accumulatedContent, // 🔄 Send accumulated content from stream state
+					"delta":           newContent,
+					"seq":             seq,
 					"message_id":      assistantMsg.ID,
 					"timestamp":       time.Now().UnixMilli(),
 					"done":            chunk.Done,
@@ -610,32 +962,35 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 			}
 
 			if chunk.Done {
+				if chunk.Estimated {
+					response.Data.(gin.H)["tokens_estimated"] = true
+				}
 				log.Printf("📡 BROADCASTING FINAL ACCUMULATED CHUNK TO WEBSOCKET:")
 				log.Printf("   • Final Accumulated Content: '%s'", accumulatedContent)
 				log.Printf("   • Content Length: %d", len(accumulatedContent))
 				log.Printf("   • Done: %t", chunk.Done)
 				log.Printf("   • Tokens Used: %d", tokensUsed)
-			} else if tokenCount % 30 == 0 {
-				log.Printf("📡 BROADCASTING 30-TOKEN ACCUMULATED CHUNK TO WEBSOCKET:")
+			} else {
+				log.Printf("📡 BROADCASTING PARTIAL ACCUMULATED CHUNK TO WEBSOCKET:")
 				log.Printf("   • Accumulated Content: '%s'", accumulatedContent)
 				log.Printf("   • Content Length: %d", len(accumulatedContent))
-				log.Printf("   • Token Count: %d", tokenCount)
 				log.Printf("   • Tokens Used: %d", tokensUsed)
 			}
 				
 			// 🔄 NEW: Send only to active connections for this stream
 			log.Printf("🎯 SENDING ACCUMULATED CONTENT TO ACTIVE CONNECTIONS FOR STREAM %s", req.ConversationID)
-			if err := s.SendStreamToActiveConnections(req.ConversationID, &response); err != nil {
+			frame := msglib.ForRequest(req.Context, newStreamFrame(response))
+			if err := s.SendStreamToActiveConnections(req.ConversationID, frame); err != nil {
 				log.Printf("❌ ERROR SENDING STREAM TO ACTIVE CONNECTIONS: %v", err)
 				log.Printf("🔄 FALLING BACK TO PROJECT BROADCAST...")
 				// Fallback to project broadcast if targeted send fails
-				s.hub.BroadcastToProject(req.ProjectID, &response)
+				s.hub.BroadcastToProject(req.ProjectID, frame)
 				log.Printf("✅ FALLBACK PROJECT BROADCAST COMPLETED")
 			} else {
 				log.Printf("✅ ACCUMULATED STREAM SENT TO ACTIVE CONNECTIONS SUCCESSFULLY")
 			}
 		} else {
-			log.Printf("⏸️ NOT SENDING - Token count: %d (next send at %d)", tokenCount, ((tokenCount/30)+1)*30)
+			log.Printf("⏸️ NOT SENDING - %d characters pending", flusher.pending)
 		}
 		
 		return nil
@@ -649,16 +1004,46 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 	log.Printf("     - Temperature: %f", llmReq.Temperature)
 	log.Printf("     - Tools Count: %d", len(llmReq.Tools))
 	
-	err := s.llmClient.StreamChat(ctx, llmReq, callback)
+	err := s.llmClient.StreamChat(streamCtx, llmReq, callback)
 
 	if err != nil {
 		// 🔄 NEW: Clear streaming state on error
-		log.Printf("❌ LLM STREAMING FAILED: %v", err)
+		requestid.Logf(ctx, "❌ LLM STREAMING FAILED: %v", err)
 		s.streamingMutex.Lock()
 		delete(s.activeStreams, req.ConversationID)
 		s.streamingMutex.Unlock()
 		log.Printf("🔄 CLEARED STREAMING STATE DUE TO ERROR: %s", req.ConversationID)
 		
+		// Cancellations and exhausted token budgets are not provider failures
+		if streamCtx.Err() == nil && !budgetExceeded {
+			s.reportLLMFailure(req, model, err)
+		}
+
+		// Keep what was generated before the reply was cancelled
+		if streamCtx.Err() != nil {
+			log.Printf("Reply to conversation %s cancelled: %v", req.ConversationID, context.Cause(streamCtx))
+			assistantMsg.Content += redactor.Flush()
+			recordRedactions(assistantMsg, redactor)
+			if assistantMsg.Content != "" {
+				s.savePartialReply(ctx, req, assistantMsg, timer.Finish(model), context.Cause(streamCtx), storeReply, replyKind)
+			}
+		}
+
+		// A rate-limited request generated nothing, so there is nothing to resume:
+		// the project is told when to retry and the client's streams may pause
+		var rateLimited *llm.RateLimitedError
+		if streamCtx.Err() == nil && errors.As(err, &rateLimited) {
+			metrics.Counter(MetricLLMRateLimited).Inc(req.ClientID)
+			if s.rateLimitCooldown && s.streamLimiter != nil && req.ClientID != "" {
+				s.streamLimiter.Cooldown(req.ClientID, rateLimited.Delay())
+			}
+			if updateErr := s.UpdateConversationStatus(req.ConversationID, req.UserID, "completed"); updateErr != nil {
+				log.Printf("Failed to update conversation status to completed: %v", updateErr)
+			}
+			s.sendRateLimited(req, model, rateLimited)
+			return err
+		}
+
 		// Update conversation status to interrupted when streaming fails
 		if updateErr := s.UpdateConversationStatus(req.ConversationID, req.UserID, "interrupted"); updateErr != nil {
 			log.Printf("Failed to update conversation status to interrupted: %v", updateErr)
@@ -677,46 +1062,69 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 			},
 			Timestamp: time.Now().UnixMilli(),
 		}
-		s.hub.BroadcastToProject(req.ProjectID, errorResponse)
+		s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, errorResponse))
 		return err
 	}
 
 	log.Printf("✅ LLM STREAMING COMPLETED SUCCESSFULLY")
+	timing := timer.Finish(model)
+	timing.applyTo(assistantMsg.Metadata)
+	recordRedactions(assistantMsg, redactor)
+	if s.notifier != nil {
+		s.notifier.LLMSucceeded(req.ClientID)
+	}
 	log.Printf("   • Final Message Content Length: %d", len(assistantMsg.Content))
 	log.Printf("   • Tool Calls Count: %d", len(assistantMsg.ToolCalls))
 
 	// Process tool calls if any
 	if len(assistantMsg.ToolCalls) > 0 {
 		log.Printf("🔧 PROCESSING %d TOOL CALLS", len(assistantMsg.ToolCalls))
-		if err := s.processToolCalls(ctx, req, assistantMsg); err != nil {
-			log.Printf("❌ ERROR PROCESSING TOOL CALLS: %v", err)
+		if err := s.processToolCalls(streamCtx, req, assistantMsg); err != nil {
+			requestid.Logf(ctx, "❌ ERROR PROCESSING TOOL CALLS: %v", err)
 		} else {
 			log.Printf("✅ TOOL CALLS PROCESSED SUCCESSFULLY")
 		}
 	}
 
+	// Keep only the citations of tool calls the reply could see; markers split
+	// across chunks are whole again by now
+	var citations []Citation
+	if req.citableToolCalls != nil {
+		citations = finalizeCitations(req, assistantMsg)
+	}
+
 	// Save complete assistant message
 	log.Printf("💾 SAVING COMPLETE ASSISTANT MESSAGE...")
-	if err := s.saveMessage(ctx, assistantMsg); err != nil {
-		log.Printf("❌ FAILED TO SAVE ASSISTANT MESSAGE: %v", err)
+	saveCtx, cancelSave := persistContext(ctx)
+	if err := storeReply(saveCtx, assistantMsg); err != nil {
+		requestid.Logf(ctx, "❌ FAILED TO SAVE ASSISTANT MESSAGE: %v", err)
+		s.journalMessage(req.ClientID, replyKind, assistantMsg, err)
 	} else {
 		log.Printf("✅ ASSISTANT MESSAGE SAVED SUCCESSFULLY")
+		s.indexMessage(req, assistantMsg)
+		if err := recordMessageMetrics(saveCtx, s.db, assistantMsg, timing); err != nil {
+			log.Printf("Failed to record timing of message %s: %v", assistantMsg.ID, err)
+		}
 	}
+	cancelSave()
 
 	// 🔄 NEW: Mark streaming as completed but keep it available for frontend
 	s.streamingMutex.Lock()
 	if streamState, exists := s.activeStreams[req.ConversationID]; exists {
-		streamState.IsActive = false
+		streamState.markCompleted()
 		log.Printf("🔄 MARKED STREAM AS COMPLETED BUT KEEPING IN MEMORY: %s", req.ConversationID)
 		
-		// Schedule cleanup after 30 seconds
-		go func(conversationID string) {
-			time.Sleep(30 * time.Second)
+		// Schedule cleanup once the retention window has passed
+		go func(conversationID string, completed *StreamState, retention time.Duration) {
+			time.Sleep(retention)
 			s.streamingMutex.Lock()
-			delete(s.activeStreams, conversationID)
+			// A newer stream for the same conversation may have replaced this one
+			if s.activeStreams[conversationID] == completed {
+				delete(s.activeStreams, conversationID)
+			}
 			s.streamingMutex.Unlock()
-			log.Printf("🧹 CLEANED UP COMPLETED STREAM AFTER 30s: %s", conversationID)
-		}(req.ConversationID)
+			log.Printf("🧹 CLEANED UP COMPLETED STREAM AFTER %s: %s", retention, conversationID)
+		}(req.ConversationID, streamState, s.streamOptions.Retention)
 	}
 	s.streamingMutex.Unlock()
 	
@@ -727,6 +1135,9 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 	} else {
 		log.Printf("✅ CONVERSATION STATUS UPDATED TO completed")
 	}
+	s.publishEvent(webhooks.EventConversationCompleted, req, map[string]interface{}{
+		"message_id": assistantMsg.ID,
+	})
 
 	// Send completion message
 	log.Printf("📡 SENDING COMPLETION MESSAGE...")
@@ -738,16 +1149,55 @@ func (s *chatService) streamLLMResponse(ctx context.Context, req *ChatRequest, m
 			"message_id":      assistantMsg.ID,
 			"timestamp":       time.Now().Format(time.RFC3339),
 			"done":            true,
+			"seq":             streamState.currentSeq(), // Repeats the final frame's seq; carries no delta
+			"delta":           "",
 		},
 	}
+	if req.citableToolCalls != nil {
+		// The content the citation offsets refer to, which may differ from the streamed deltas
+		completionResponse.Data.(gin.H)["content"] = assistantMsg.Content
+		completionResponse.Data.(gin.H)["citations"] = citations
+	}
 	log.Printf("📡 BROADCASTING COMPLETION MESSAGE TO PROJECT %s", req.ProjectID)
-	s.hub.BroadcastToProject(req.ProjectID, completionResponse)
+	s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, completionResponse))
 	log.Printf("✅ COMPLETION MESSAGE BROADCASTED")
 
+	if assistantMsg.Status == MessageStatusDraft {
+		s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, WebSocketMessage{
+			Type: "draft_ready",
+			Data: DraftReadyData{
+				ConversationID: req.ConversationID,
+				MessageID:      assistantMsg.ID,
+				Content:        assistantMsg.Content,
+			},
+			Timestamp: time.Now().UnixMilli(),
+		}))
+	}
+
 	log.Printf("🎉 STREAMLLMRESPONSE COMPLETED SUCCESSFULLY FOR CONVERSATION: %s", req.ConversationID)
 	return nil
 }
 
+// savePartialReply saves the content of a reply that was cancelled while
+// generating, marked as interrupted, on a context of its own, with store
+func (s *chatService) savePartialReply(ctx context.Context, req *ChatRequest, assistantMsg *Message, timing MessageTiming, cause error, store func(context.Context, *Message) error, kind string) {
+	timing.applyTo(assistantMsg.Metadata)
+	assistantMsg.Metadata["interrupted"] = true
+	assistantMsg.Metadata["interrupted_at"] = time.Now().UTC().Format(time.RFC3339)
+	if cause != nil {
+		assistantMsg.Metadata["interrupted_reason"] = cause.Error()
+	}
+
+	saveCtx, cancel := persistContext(ctx)
+	defer cancel()
+	if err := store(saveCtx, assistantMsg); err != nil {
+		log.Printf("Failed to save partial reply %s: %v", assistantMsg.ID, err)
+		s.journalMessage(req.ClientID, kind, assistantMsg, err)
+		return
+	}
+	s.indexMessage(req, assistantMsg)
+}
+
 // processToolCalls executes pending tool calls
 func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, assistantMsg *Message) error {
 	for _, toolCall := range assistantMsg.ToolCalls {
@@ -757,7 +1207,23 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 
 		// Update status to executing
 		assistantMsg.UpdateToolCallStatus(toolCall.ID, "executing", "", "")
-		s.hub.BroadcastToProject(req.ProjectID, WebSocketMessage{
+		execution := &ToolExecution{
+			ID:             toolCall.ID,
+			MessageID:      assistantMsg.ID,
+			ConversationID: req.ConversationID,
+			ToolName:       toolCall.Function.Name,
+			Arguments:      toolCall.Function.Arguments,
+			Status:         "executing",
+			StartedAt:      time.Now(),
+		}
+		recordCtx, cancelRecord := persistContext(ctx)
+		recorded := true
+		if err := StartToolExecution(recordCtx, s.db, execution); err != nil {
+			requestid.Logf(ctx, "Tool call %s of message %s: %v", toolCall.ID, assistantMsg.ID, err)
+			recorded = false
+		}
+		cancelRecord()
+		s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, WebSocketMessage{
 			Type: "tool_execution_started",
 			Data: gin.H{
 				"tool_name":       toolCall.Function.Name,
@@ -766,14 +1232,26 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 				"message_id":      assistantMsg.ID,
 			},
 			Timestamp: time.Now().UnixMilli(),
-		})
+		}))
 
 		// Execute tool
 		args, ok := toolCall.Function.Arguments.(map[string]interface{})
 		if !ok {
 			args = make(map[string]interface{})
 		}
-		result, err := s.toolRegistry.ExecuteTool(ctx, req.UserID, req.ProjectID, toolCall.Function.Name, args)
+		toolCtx := tools.WithExecutionSource(ctx, req.ClientID, tools.SourceLLM)
+		toolCtx = tools.WithToolCall(tools.WithConversation(toolCtx, req.ConversationID), toolCall.ID)
+		result, err := s.toolRegistry.ExecuteTool(toolCtx, req.UserID, req.ProjectID, toolCall.Function.Name, args)
+		if err == nil {
+			// Timed-out and cancelled executions are reported as failures
+			err = result.InterruptionError()
+		}
+		// Keep the result so later tool calls, such as charts, can reference it by tool_call_id
+		if err == nil {
+			if store := s.toolRegistry.Results(); store != nil {
+				store.Put(req.ConversationID, toolCall.ID, result)
+			}
+		}
 
 		var status string
 		var resultJSON string
@@ -789,12 +1267,32 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 			}
 		}
 
-		// Update tool call status
-		assistantMsg.UpdateToolCallStatus(toolCall.ID, status, resultJSON, "")
+		// The message keeps only the status of a recorded execution; its result
+		// stays embedded when the execution could not be recorded
+		if recorded {
+			execution.Status = status
+			var result json.RawMessage
+			if err != nil {
+				execution.Error = err.Error()
+			} else {
+				result = json.RawMessage(resultJSON)
+			}
+			recordCtx, cancelRecord := persistContext(ctx)
+			if recordErr := FinishToolExecution(recordCtx, s.db, s.toolResults, execution, result); recordErr != nil {
+				requestid.Logf(ctx, "Tool call %s of message %s: %v", toolCall.ID, assistantMsg.ID, recordErr)
+				recorded = false
+			}
+			cancelRecord()
+		}
+		if recorded {
+			assistantMsg.UpdateToolCallStatus(toolCall.ID, status, "", "")
+		} else {
+			assistantMsg.UpdateToolCallStatus(toolCall.ID, status, resultJSON, "")
+		}
 
 		// Broadcast tool execution result
 		if status == "completed" {
-			s.hub.BroadcastToProject(req.ProjectID, WebSocketMessage{
+			s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, WebSocketMessage{
 				Type:      "tool_execution_completed",
 				Timestamp: time.Now().UnixMilli(),
 				Data: gin.H{
@@ -804,9 +1302,9 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 					"result":          json.RawMessage(resultJSON),
 					"success":         true,
 				},
-			})
+			}))
 		} else if status == "failed" {
-			s.hub.BroadcastToProject(req.ProjectID, WebSocketMessage{
+			s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, WebSocketMessage{
 				Type:      "tool_execution_failed",
 				Timestamp: time.Now().UnixMilli(),
 				Data: gin.H{
@@ -814,8 +1312,33 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 					"tool_call_id":    toolCall.ID,
 					"conversation_id": req.ConversationID,
 					"error":           resultJSON,
-					"error_code":      "EXECUTION_ERROR",
+					"error_code":      toolErrorCode(err),
 				},
+			}))
+			s.publishEvent(webhooks.EventToolExecutionFailed, req, map[string]interface{}{
+				"message_id":   assistantMsg.ID,
+				"tool_name":    toolCall.Function.Name,
+				"tool_call_id": toolCall.ID,
+				"error":        err.Error(),
+				"error_code":   toolErrorCode(err),
+			})
+			payload := map[string]interface{}{
+				"tool_name":    toolCall.Function.Name,
+				"tool_call_id": toolCall.ID,
+				"error":        err.Error(),
+				"error_code":   toolErrorCode(err),
+			}
+			// Lets the feed say which datasource a query failed on
+			if datasourceID, _ := args["datasource_id"].(string); datasourceID != "" {
+				payload["datasource_id"] = datasourceID
+			}
+			s.activity.Record(activity.Event{
+				ProjectID:   req.ProjectID,
+				ActorUserID: req.UserID,
+				EventType:   activity.EventToolExecutionFailed,
+				EntityType:  activity.EntityConversation,
+				EntityID:    req.ConversationID,
+				Payload:     payload,
 			})
 		}
 	}
@@ -823,6 +1346,22 @@ func (s *chatService) processToolCalls(ctx context.Context, req *ChatRequest, as
 	return nil
 }
 
+// toolErrorCode maps a tool execution error to the error code sent to clients
+func toolErrorCode(err error) string {
+	switch {
+	case errors.Is(err, tools.ErrToolDisabled):
+		return "TOOL_DISABLED"
+	case errors.Is(err, tools.ErrToolAccessDenied):
+		return "PERMISSION_DENIED"
+	case errors.Is(err, tools.ErrToolTimeout):
+		return "TOOL_TIMEOUT"
+	case errors.Is(err, tools.ErrToolCancelled):
+		return "TOOL_CANCELLED"
+	default:
+		return "EXECUTION_ERROR"
+	}
+}
+
 // broadcastToolStatus sends tool execution status to clients
 func (s *chatService) broadcastToolStatus(projectID, conversationID, messageID string, index int, toolCall ToolCall) {
 	toolStatus := WebSocketMessage{
@@ -841,8 +1380,9 @@ func (s *chatService) broadcastToolStatus(projectID, conversationID, messageID s
 func (s *chatService) LoadStreamingConversation(conversationID, userID string) (*ConversationDetails, error) {
 	log.Printf("🔥 DEBUG: LoadStreamingConversation called for conv: %s, user: %s", conversationID, userID)
 	
-	// First, get the complete conversation from database (this gets all saved history)
-	dbDetails, err := s.GetConversation(conversationID, userID)
+	// First, get the complete conversation from database (this gets all saved history).
+	// It is merged with the live stream, whose saved messages a replica may not have yet.
+	dbDetails, err := s.getConversation(context.Background(), s.db, conversationID, userID)
 	if err != nil {
 		log.Printf("🔥 ERROR: Failed to get conversation from database: %v", err)
 		return nil, fmt.Errorf("failed to get conversation from database: %w", err)
@@ -855,9 +1395,7 @@ func (s *chatService) LoadStreamingConversation(conversationID, userID string) (
 	}
 	
 	// Then, check if there's an active streaming state
-	s.streamingMutex.RLock()
-	streamState, hasStream := s.activeStreams[conversationID]
-	s.streamingMutex.RUnlock()
+	streamState, hasStream := s.streamSnapshot(conversationID)
 	
 	var contentLength int
 	if hasStream {
@@ -877,7 +1415,6 @@ func (s *chatService) LoadStreamingConversation(conversationID, userID string) (
 			Role:          "assistant",
 			Content:        streamState.CurrentContent,
 			CreatedAt:      streamState.StartTime,
-			UserID:        streamState.UserID,
 			ProjectID:     streamState.ProjectID,
 		}
 		
@@ -918,39 +1455,251 @@ func (s *chatService) LoadStreamingConversation(conversationID, userID string) (
 
 // Helper methods
 
+// claimClientMessageID checks the recent-ID cache, then the database, for an earlier
+// message with the same client_message_id and claims the ID if it is new
+func (s *chatService) claimClientMessageID(ctx context.Context, conversationID, clientMessageID string) error {
+	if existingID, ok := s.recentMessages.claim(conversationID, clientMessageID); !ok {
+		return &DuplicateMessageError{ConversationID: conversationID, ClientMessageID: clientMessageID, MessageID: existingID}
+	}
+
+	if existingID, found := s.findMessageByClientID(ctx, conversationID, clientMessageID); found {
+		s.recentMessages.setMessageID(conversationID, clientMessageID, existingID)
+		return &DuplicateMessageError{ConversationID: conversationID, ClientMessageID: clientMessageID, MessageID: existingID}
+	}
+
+	return nil
+}
+
+// findMessageByClientID looks up a stored message by its client-generated ID
+func (s *chatService) findMessageByClientID(ctx context.Context, conversationID, clientMessageID string) (string, bool) {
+	var messageID string
+	err := s.db.QueryRow(ctx,
+		"SELECT id FROM messages WHERE conversation_id = $1 AND client_message_id = $2",
+		conversationID, clientMessageID).Scan(&messageID)
+	if err != nil {
+		return "", false
+	}
+	return messageID, true
+}
+
+// reserveStream marks a conversation as busy, failing if a response is already being generated for it
+func (s *chatService) reserveStream(conversationID string) error {
+	s.streamingMutex.Lock()
+	defer s.streamingMutex.Unlock()
+
+	if s.pendingStreams[conversationID] {
+		return ErrStreamAlreadyActive
+	}
+	if streamState, exists := s.activeStreams[conversationID]; exists && streamState.IsActive() {
+		return ErrStreamAlreadyActive
+	}
+	s.pendingStreams[conversationID] = true
+	return nil
+}
+
+// streamRecipientHub is implemented by hubs that can address individual connections
+type streamRecipientHub interface {
+	SendToConnectionID(connectionID string, message interface{}) bool
+	SendToUser(projectID, userID string, message interface{}) int
+}
+
+// sendToStreamRecipients sends a message to the stream's active connections and to the
+// project connections of every participant with none of those; it never broadcasts to the room
+func (s *chatService) sendToStreamRecipients(streamState *StreamState, message interface{}) {
+	hub, ok := s.hub.(streamRecipientHub)
+	if !ok {
+		return
+	}
+
+	delivered := make(map[string]bool)
+	for _, connID := range streamState.activeConnectionIDs() {
+		if hub.SendToConnectionID(connID, message) {
+			delivered[streamState.connectionUser(connID)] = true
+		}
+	}
+	for _, userID := range streamState.participantIDs() {
+		if !delivered[userID] {
+			hub.SendToUser(streamState.ProjectID(), userID, message)
+		}
+	}
+}
+
+// acquireStreamSlot takes a concurrent stream slot for the request's client.
+// While queued, the sender receives message_queued events with its position.
+// During the client's rate-limit cooldown it fails with a *llm.RateLimitedError.
+func (s *chatService) acquireStreamSlot(ctx context.Context, req *ChatRequest) (func(), error) {
+	if s.streamLimiter == nil || req.ClientID == "" {
+		return func() {}, nil
+	}
+	if remaining := s.streamLimiter.CooldownRemaining(req.ClientID); remaining > 0 {
+		return nil, &llm.RateLimitedError{RetryAfter: remaining, Err: errors.New("waiting out the LLM provider's rate limit")}
+	}
+
+	// Position updates can arrive from the goroutine releasing a slot
+	var markQueued sync.Once
+	onQueued := func(position int) {
+		markQueued.Do(func() {
+			if err := s.UpdateConversationStatus(req.ConversationID, req.UserID, "queued"); err != nil {
+				log.Printf("Failed to update conversation status to queued: %v", err)
+			}
+		})
+		s.sendToRequester(req, WebSocketMessage{
+			Type: "message_queued",
+			Data: MessageQueuedData{
+				ConversationID:  req.ConversationID,
+				ClientMessageID: req.ClientMessageID,
+				QueuePosition:   position,
+			},
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+
+	return s.streamLimiter.Acquire(ctx, req.ClientID, req.MaxConcurrentStreams, onQueued)
+}
+
+// resetAfterRejection resets the status of a conversation whose request got no
+// stream slot. A request refused during a rate-limit cooldown leaves nothing to
+// resume, so the project is told when to retry instead.
+func (s *chatService) resetAfterRejection(req *ChatRequest, err error) {
+	status := "interrupted"
+	var rateLimited *llm.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		status = "completed"
+		s.sendRateLimited(req, "", rateLimited)
+	}
+	if updateErr := s.UpdateConversationStatus(req.ConversationID, req.UserID, status); updateErr != nil {
+		log.Printf("Failed to reset conversation status after queue rejection: %v", updateErr)
+	}
+}
+
+// sendRateLimited tells the project that a reply could not be generated
+// because the client's LLM provider is rate limiting it, and when to retry
+func (s *chatService) sendRateLimited(req *ChatRequest, model string, rateLimited *llm.RateLimitedError) {
+	s.hub.BroadcastToProject(req.ProjectID, msglib.ForRequest(req.Context, WebSocketMessage{
+		Type: "rate_limited",
+		Data: RateLimitedData{
+			ConversationID:    req.ConversationID,
+			ClientMessageID:   req.ClientMessageID,
+			Model:             model,
+			RetryAfterSeconds: rateLimited.RetryAfterSeconds(),
+		},
+		Timestamp: time.Now().UnixMilli(),
+	}))
+}
+
+// sendToRequester sends a message to the connection that made the request, or to the user's
+// other connections in the project if it has gone away
+func (s *chatService) sendToRequester(req *ChatRequest, message interface{}) {
+	hub, ok := s.hub.(streamRecipientHub)
+	if !ok {
+		return
+	}
+	message = msglib.ForRequest(req.Context, message)
+	if req.ConnectionID != "" && hub.SendToConnectionID(req.ConnectionID, message) {
+		return
+	}
+	hub.SendToUser(req.ProjectID, req.UserID, message)
+}
+
+// releaseStream clears a reservation made by reserveStream
+func (s *chatService) releaseStream(conversationID string) {
+	s.streamingMutex.Lock()
+	delete(s.pendingStreams, conversationID)
+	s.streamingMutex.Unlock()
+}
+
 func (s *chatService) saveMessage(ctx context.Context, msg *Message) error {
-	toolCallsJSON, _ := json.Marshal(msg.ToolCalls)
-	metadataJSON, _ := json.Marshal(msg.Metadata)
+	toolCallsJSON, err := MarshalToolCalls(msg.ToolCalls)
+	if err != nil {
+		log.Printf("Message %s: %v", msg.ID, err)
+		return err
+	}
+	metadataJSON, err := MarshalMetadata(msg.Metadata)
+	if err != nil {
+		log.Printf("Message %s: %v", msg.ID, err)
+		return err
+	}
+
+	var clientMessageID interface{}
+	if msg.ClientMessageID != "" {
+		clientMessageID = msg.ClientMessageID
+	}
+	// Only user messages record their sender; replies belong to the conversation
+	var senderID interface{}
+	if msg.Role == "user" && msg.UserID != "" {
+		senderID = msg.UserID
+	}
+
+	status := msg.Status
+	if status == "" {
+		status = MessageStatusFinal
+	}
 
 	query := `
-		INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at, client_message_id, user_id, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
 
-	_, err := s.db.Exec(ctx, query,
+	_, err = s.db.Exec(ctx, query,
 		msg.ID, msg.ConversationID, msg.Role, msg.Content,
-		metadataJSON, toolCallsJSON, msg.CreatedAt,
+		metadataJSON, toolCallsJSON, msg.CreatedAt, clientMessageID, senderID, status,
 	)
+	if err != nil {
+		return err
+	}
+	if status == MessageStatusDraft {
+		// Drafts join the history once approved
+		return nil
+	}
 
-	return err
+	// Cache the message as the history query would read it back, which also
+	// keeps later changes to msg out of the cache
+	stored := &Message{ID: msg.ID, ConversationID: msg.ConversationID, Role: msg.Role, Content: msg.Content, CreatedAt: msg.CreatedAt}
+	decodeMessageJSON(stored, metadataJSON, toolCallsJSON)
+	s.history.append(stored, historyMessageSize(stored, metadataJSON, toolCallsJSON))
+	return nil
 }
 
-func (s *chatService) getConversationHistory(ctx context.Context, conversationID, userID string) ([]*Message, error) {
-	query := `
+// historyQuery reads the most recent messages of a conversation, newest
+// first, leaving out drafts awaiting approval
+const historyQuery = `
 		SELECT id, conversation_id, role, content, metadata, tool_calls, created_at
 		FROM messages
-		WHERE conversation_id = $1
-		ORDER BY created_at ASC
-		LIMIT 50 -- Limit context window
+		WHERE conversation_id = $1 AND status <> 'draft'
+		ORDER BY created_at DESC
+		LIMIT $2
 	`
 
-	rows, err := s.db.Query(ctx, query, conversationID)
+// getConversationHistory returns the most recent messages of a conversation in
+// chronological order, from the history cache when it holds the conversation.
+// Callers may append to the slice but must not change the messages.
+func (s *chatService) getConversationHistory(ctx context.Context, conversationID, userID string) ([]*Message, error) {
+	cached, token, ok := s.history.get(conversationID)
+	if ok {
+		return cached, nil
+	}
+
+	messages, sizes, err := s.loadConversationHistory(ctx, conversationID)
 	if err != nil {
+		s.history.abandon(conversationID, token)
 		return nil, err
 	}
+	s.history.store(conversationID, token, messages, sizes)
+	// The cached slice is the caller's no more than one returned by get
+	return append([]*Message(nil), messages...), nil
+}
+
+// loadConversationHistory reads history from the database, with the estimated
+// size of each message for the history cache
+func (s *chatService) loadConversationHistory(ctx context.Context, conversationID string) ([]*Message, []int64, error) {
+	rows, err := s.db.Query(ctx, historyQuery, conversationID, maxHistoryMessages)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
-	var messages []*Message
+	var messages, legacy []*Message
+	var sizes []int64
 	for rows.Next() {
 		var msg Message
 		var toolCallsJSON []byte
@@ -960,20 +1709,27 @@ func (s *chatService) getConversationHistory(ctx context.Context, conversationID
 			&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content,
 			&metadataJSON, &toolCallsJSON, &msg.CreatedAt,
 		); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		if len(metadataJSON) > 0 {
-			json.Unmarshal(metadataJSON, &msg.Metadata)
+		if decodeMessageJSON(&msg, metadataJSON, toolCallsJSON) {
+			legacy = append(legacy, &msg)
 		}
-		if len(toolCallsJSON) > 0 {
-			json.Unmarshal(toolCallsJSON, &msg.ToolCalls)
-		}
-
 		messages = append(messages, &msg)
+		sizes = append(sizes, historyMessageSize(&msg, metadataJSON, toolCallsJSON))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.migrateLegacyMessages(ctx, legacy)
+
+	// Rows come newest first; return them in chronological order
+	for l, r := 0, len(messages)-1; l < r; l, r = l+1, r-1 {
+		messages[l], messages[r] = messages[r], messages[l]
+		sizes[l], sizes[r] = sizes[r], sizes[l]
 	}
 
-	return messages, nil
+	return messages, sizes, nil
 }
 
 func (s *chatService) convertToOpenAIMessages(messages []*Message) []openai.ChatCompletionMessageParamUnion {
@@ -983,7 +1739,7 @@ func (s *chatService) convertToOpenAIMessages(messages []*Message) []openai.Chat
 	// TODO: Implement system message generation based on project
 
 	for _, msg := range messages {
-		if msg.Role == "user" || msg.Role == "assistant" || msg.Role == "system" {
+		if sentToModel(msg) {
 			if msg.Role == "user" {
 				openaiMessages = append(openaiMessages, openai.UserMessage(msg.Content))
 			} else if msg.Role == "assistant" {
@@ -1005,23 +1761,16 @@ func (s *chatService) convertToOpenAIMessages(messages []*Message) []openai.Chat
 	return openaiMessages
 }
 
+// convertTools describes the available tools to the model, each with the JSON
+// Schema of its parameters
 func (s *chatService) convertTools(availableTools []tools.Tool) []Tool {
 	var convertedTools []Tool
 
 	for _, tool := range availableTools {
-		// Convert tool parameters map
-		parameters := make(map[string]interface{})
-		for k, v := range tool.Parameters() {
-			parameters[k] = map[string]interface{}{
-				"type":        "string",
-				"description": v.Description,
-			}
-		}
-
 		convertedTool := Tool{
 			Name:        tool.Name(),
 			Description: tool.Description(),
-			Parameters:  parameters,
+			Parameters:  tools.ToolParameterSchema(tool),
 			Type:        "function",
 		}
 		convertedTools = append(convertedTools, convertedTool)
@@ -1035,11 +1784,11 @@ func (s *chatService) convertTools(availableTools []tools.Tool) []Tool {
 // GetConversationStatus returns detailed conversation status including streaming state
 func (s *chatService) GetConversationStatus(conversationID, userID string) (gin.H, error) {
 	// Check database for conversation existence
-	// First verify conversation exists and belongs to user
+	// First verify conversation exists and the user takes part in it
 	conversationQuery := `
-		SELECT id, title, created_at, updated_at 
-		FROM conversations 
-		WHERE id = $1 AND user_id = $2`
+		SELECT c.id, c.title, c.created_at, c.updated_at 
+		FROM conversations c 
+		WHERE c.id = $1 AND ` + isParticipantCondition(2) + ` AND c.deleted_at IS NULL`
 	
 	rows, err := s.db.Query(context.Background(), conversationQuery, conversationID, userID)
 	if err != nil {
@@ -1062,9 +1811,7 @@ func (s *chatService) GetConversationStatus(conversationID, userID string) (gin.
 	var currentContent string = ""
 	var startTime time.Time
 	
-	s.streamingMutex.RLock()
-	streamState, hasStream := s.activeStreams[conversationID]
-	s.streamingMutex.RUnlock()
+	streamState, hasStream := s.streamSnapshot(conversationID)
 	
 	if hasStream && streamState.IsActive {
 		isProcessing = true
@@ -1084,26 +1831,23 @@ func (s *chatService) GetConversationStatus(conversationID, userID string) (gin.
 }
 
 // GetStreamState returns the current streaming state for a conversation
-func (s *chatService) GetStreamState(conversationID string) (*StreamState, error) {
-	s.streamingMutex.RLock()
-	defer s.streamingMutex.RUnlock()
-	
-	streamState, exists := s.activeStreams[conversationID]
+func (s *chatService) GetStreamState(conversationID string) (*StreamStateSnapshot, error) {
+	snapshot, exists := s.streamSnapshot(conversationID)
 	if !exists {
 		return nil, fmt.Errorf("no active stream for conversation: %s", conversationID)
 	}
 	
-	return streamState, nil
+	return &snapshot, nil
 }
 
 // GetAllActiveStreams returns all currently active streaming conversations
-func (s *chatService) GetAllActiveStreams() map[string]*StreamState {
+func (s *chatService) GetAllActiveStreams() map[string]StreamStateSnapshot {
 	s.streamingMutex.RLock()
 	defer s.streamingMutex.RUnlock()
 	
-	result := make(map[string]*StreamState)
+	result := make(map[string]StreamStateSnapshot, len(s.activeStreams))
 	for convID, streamState := range s.activeStreams {
-		result[convID] = streamState
+		result[convID] = streamState.Snapshot()
 	}
 	
 	return result
@@ -1119,8 +1863,8 @@ func (s *chatService) ClearStreamState(conversationID string) error {
 	return nil
 }
 
-// 🔄 NEW: AttachConnectionToStream adds a connection to active stream
-func (s *chatService) AttachConnectionToStream(conversationID, connectionID string) error {
+// 🔄 NEW: AttachConnectionToStream adds a user's connection to active stream
+func (s *chatService) AttachConnectionToStream(conversationID, connectionID, userID string) error {
 	s.streamingMutex.Lock()
 	defer s.streamingMutex.Unlock()
 	
@@ -1129,10 +1873,7 @@ func (s *chatService) AttachConnectionToStream(conversationID, connectionID stri
 		return fmt.Errorf("no active stream for conversation: %s", conversationID)
 	}
 	
-	streamState.Mutex.Lock()
-	streamState.ActiveConnectionIDs[connectionID] = true
-	streamState.AllConnectionIDs[connectionID] = true  // 🔄 NEW: Track all connections
-	streamState.Mutex.Unlock()
+	streamState.attach(connectionID, userID)
 	
 	log.Printf("Attached connection %s to stream %s", connectionID, conversationID)
 	return nil
@@ -1148,10 +1889,7 @@ func (s *chatService) DetachConnectionFromStream(conversationID, connectionID st
 		return fmt.Errorf("no active stream for conversation: %s", conversationID)
 	}
 	
-	streamState.Mutex.Lock()
-	delete(streamState.ActiveConnectionIDs, connectionID)
-	remainingConnections := len(streamState.ActiveConnectionIDs)
-	streamState.Mutex.Unlock()
+	remainingConnections := streamState.detach(connectionID)
 	
 	log.Printf("Detached connection %s from stream %s, remaining connections: %d", connectionID, conversationID, remainingConnections)
 	
@@ -1181,11 +1919,7 @@ func (s *chatService) GetActiveConnectionsForStream(conversationID string) int {
 		return 0
 	}
 	
-	streamState.Mutex.RLock()
-	count := len(streamState.ActiveConnectionIDs)
-	streamState.Mutex.RUnlock()
-	
-	return count
+	return len(streamState.activeConnectionIDs())
 }
 
 // 🔄 NEW: SendStreamToActiveConnections sends a message only to connections tracking this stream
@@ -1198,50 +1932,28 @@ func getMapKeys(m map[string]interface{}) []string {
 	return keys
 }
 
-// Helper function to get active stream keys for logging
-func getActiveStreamKeys(streams map[string]*StreamState) []string {
-	keys := make([]string, 0, len(streams))
-	for k := range streams {
-		keys = append(keys, k)
-	}
-	return keys
-}
-
 func (s *chatService) SendStreamToActiveConnections(conversationID string, message interface{}) error {
 	log.Printf("🎯 SendStreamToActiveConnections CALLED:")
 	log.Printf("   • Conversation ID: %s", conversationID)
 	log.Printf("   • Message Type: %T", message)
 
-	s.streamingMutex.RLock()
-	streamState, exists := s.activeStreams[conversationID]
-	s.streamingMutex.RUnlock()
+	streamState, exists := s.streamState(conversationID)
 	
 	if !exists {
 		log.Printf("❌ NO ACTIVE STREAM FOUND FOR CONVERSATION: %s", conversationID)
-		log.Printf("   • Available Streams: %v", getActiveStreamKeys(s.activeStreams))
+		log.Printf("   • Available Streams: %v", s.activeStreamKeys())
 		return fmt.Errorf("no active stream for conversation: %s", conversationID)
 	}
 
 	log.Printf("✅ ACTIVE STREAM FOUND:")
 	log.Printf("   • Stream Exists: %t", exists)
-	log.Printf("   • Active Connections Count: %d", len(streamState.ActiveConnectionIDs))
-	log.Printf("   • All Connections Count: %d", len(streamState.AllConnectionIDs))
 	
-	streamState.Mutex.RLock()
-	activeConnectionIDs := make([]string, 0, len(streamState.ActiveConnectionIDs))
-	for connID := range streamState.ActiveConnectionIDs {
-		activeConnectionIDs = append(activeConnectionIDs, connID)
-	}
-	streamState.Mutex.RUnlock()
+	activeConnectionIDs := streamState.activeConnectionIDs()
+	log.Printf("   • Active Connections Count: %d", len(activeConnectionIDs))
 	
 	// 🔄 CRITICAL FIX: If no active connections but stream is still alive, use project broadcast fallback
 	if len(activeConnectionIDs) == 0 {
-		streamState.Mutex.RLock()
-		allConnectionIDs := make([]string, 0, len(streamState.AllConnectionIDs))
-		for connID := range streamState.AllConnectionIDs {
-			allConnectionIDs = append(allConnectionIDs, connID)
-		}
-		streamState.Mutex.RUnlock()
+		allConnectionIDs := streamState.allConnectionIDs()
 		
 		log.Printf("⚠️ NO ACTIVE CONNECTIONS FOR STREAM %s", conversationID)
 		log.Printf("   • Active Connection IDs: %v", activeConnectionIDs)
@@ -1249,9 +1961,9 @@ func (s *chatService) SendStreamToActiveConnections(conversationID string, messa
 		log.Printf("🔄 USING PROJECT BROADCAST FALLBACK...")
 		
 		// Fallback to project broadcast if we have any connections that ever joined this stream
-		if len(streamState.AllConnectionIDs) > 0 {
-			log.Printf("📡 BROADCASTING TO PROJECT %s", streamState.ProjectID)
-			s.hub.BroadcastToProject(streamState.ProjectID, message)
+		if len(allConnectionIDs) > 0 {
+			log.Printf("📡 BROADCASTING TO PROJECT %s", streamState.ProjectID())
+			s.hub.BroadcastToProject(streamState.ProjectID(), message)
 			log.Printf("✅ PROJECT BROADCAST COMPLETED")
 			return nil
 		}
@@ -1285,7 +1997,7 @@ func (s *chatService) SendStreamToActiveConnections(conversationID string, messa
 	} else {
 		log.Printf("🔄 FALLING BACK TO PROJECT BROADCAST")
 		// Fallback to project broadcast
-		s.hub.BroadcastToProject(streamState.ProjectID, message)
+		s.hub.BroadcastToProject(streamState.ProjectID(), message)
 		log.Printf("✅ PROJECT BROADCAST COMPLETED")
 	}
 	
@@ -1294,16 +2006,16 @@ func (s *chatService) SendStreamToActiveConnections(conversationID string, messa
 }
 
 // GetActiveStreamingMessage returns only the active streaming message from memory
-func (s *chatService) GetActiveStreamingMessage(conversationID, userID string) (*StreamState, error) {
-	s.streamingMutex.RLock()
-	streamState, exists := s.activeStreams[conversationID]
+func (s *chatService) GetActiveStreamingMessage(conversationID, userID string) (*StreamStateSnapshot, error) {
+	allStreams := s.GetAllActiveStreams()
+	streamState, exists := allStreams[conversationID]
 	
 	// Log all active streams in memory (no filtering)
 	log.Printf("🔍 DEBUG: All active streams in memory during lookup for %s:", conversationID)
-	if len(s.activeStreams) == 0 {
+	if len(allStreams) == 0 {
 		log.Printf("   • No active streams found in memory")
 	} else {
-		for convID, state := range s.activeStreams {
+		for convID, state := range allStreams {
 			log.Printf("   • Stream %s:", convID)
 			log.Printf("     - ConversationID: %s", state.ConversationID)
 			log.Printf("     - UserID: %s", state.UserID)
@@ -1318,8 +2030,6 @@ func (s *chatService) GetActiveStreamingMessage(conversationID, userID string) (
 		}
 	}
 	
-	s.streamingMutex.RUnlock()
-	
 	log.Printf("🔍 DEBUG: StreamState lookup for conversation %s:", conversationID)
 	log.Printf("   • Exists: %t", exists)
 	if exists {
@@ -1340,8 +2050,8 @@ func (s *chatService) GetActiveStreamingMessage(conversationID, userID string) (
 		return nil, fmt.Errorf("no active stream for conversation: %s", conversationID)
 	}
 	
-	// Verify the stream belongs to the requesting user
-	if streamState.UserID != userID {
+	// Verify the requesting user takes part in the stream's conversation
+	if !streamState.HasParticipant(userID) {
 		return nil, fmt.Errorf("stream does not belong to user: %s", userID)
 	}
 	
@@ -1354,5 +2064,5 @@ func (s *chatService) GetActiveStreamingMessage(conversationID, userID string) (
 	log.Printf("🔄 Returning streaming message for conversation %s (status: %s, content length: %d)", 
 		conversationID, status, len(streamState.CurrentContent))
 	
-	return streamState, nil
+	return &streamState, nil
 }
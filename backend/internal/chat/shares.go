@@ -0,0 +1,265 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// ErrShareNotFound is returned for unknown, revoked and expired share tokens,
+// and when revoking a share of someone else's conversation
+var ErrShareNotFound = errors.New("share not found")
+
+// Share is a read-only link to a conversation. Only the hash of its token is
+// stored, so Token is only set on the share CreateShare returns.
+type Share struct {
+	ID             string     `json:"id"`
+	ConversationID string     `json:"conversation_id"`
+	Token          string     `json:"token,omitempty"`
+	IncludeTools   bool       `json:"include_tools"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	CreatedBy      string     `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SharedConversation is what a share link shows: the transcript without user,
+// project or client identifiers
+type SharedConversation struct {
+	Title        string           `json:"title"`
+	Status       string           `json:"status"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	IncludeTools bool             `json:"include_tools"`
+	ExpiresAt    *time.Time       `json:"expires_at"`
+	Messages     []*SharedMessage `json:"messages"`
+}
+
+// SharedMessage is a message of a shared conversation. Tool calls are only
+// listed when the share includes tools.
+type SharedMessage struct {
+	ID        string     `json:"id"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// generateShareToken returns a new random, URL-safe share token
+func generateShareToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashShareToken returns the stored form of a share token, encoded like
+// session token and API key hashes
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ownsConversation checks that a conversation is one of the user's own,
+// non-deleted conversations within their client
+func ownsConversation(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) error {
+	var id string
+	err := db.QueryRow(ctx,
+		`SELECT c.id
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND c.user_id = $2 AND u.client_id = $3 AND c.deleted_at IS NULL`,
+		conversationID, userID, clientID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	return nil
+}
+
+// CreateShare creates a share link for one of the user's conversations. A nil
+// expiresAt makes a link that works until it is revoked.
+func CreateShare(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string, includeTools bool, expiresAt *time.Time) (*Share, error) {
+	if err := ownsConversation(ctx, db, userID, clientID, conversationID); err != nil {
+		return nil, err
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+	share := &Share{
+		ID:             uuid.New().String(),
+		ConversationID: conversationID,
+		Token:          token,
+		IncludeTools:   includeTools,
+		ExpiresAt:      expiresAt,
+		CreatedBy:      userID,
+		CreatedAt:      time.Now().UTC(),
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO conversation_shares (id, conversation_id, token_hash, include_tools, expires_at, created_by, revoked, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, false, $7)`,
+		share.ID, share.ConversationID, hashShareToken(share.Token), share.IncludeTools, share.ExpiresAt, share.CreatedBy, share.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share: %w", err)
+	}
+	return share, nil
+}
+
+// ListShares returns the shares of one of the user's conversations that have
+// not been revoked, newest first. Expired shares are included; tokens are not.
+func ListShares(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) ([]Share, error) {
+	if err := ownsConversation(ctx, db, userID, clientID, conversationID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT id, conversation_id, include_tools, expires_at, created_by, created_at
+		FROM conversation_shares
+		WHERE conversation_id = $1 AND revoked = false
+		ORDER BY created_at DESC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		var share Share
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&share.ID, &share.ConversationID, &share.IncludeTools,
+			&expiresAt, &share.CreatedBy, &share.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan share: %w", err)
+		}
+		if expiresAt.Valid {
+			share.ExpiresAt = &expiresAt.Time
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeShares revokes one share of the user's conversation, or all of them
+// when shareID is empty, and returns how many were revoked
+func RevokeShares(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID, shareID string) (int64, error) {
+	if err := ownsConversation(ctx, db, userID, clientID, conversationID); err != nil {
+		return 0, err
+	}
+
+	query := "UPDATE conversation_shares SET revoked = true WHERE conversation_id = $1 AND revoked = false"
+	args := []interface{}{conversationID}
+	if shareID != "" {
+		query += " AND id = $2"
+		args = append(args, shareID)
+	}
+	result, err := db.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke share: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke share: %w", err)
+	}
+	if shareID != "" && revoked == 0 {
+		return 0, ErrShareNotFound
+	}
+	return revoked, nil
+}
+
+// GetConversationForShare loads the conversation a share token points to.
+// It does not check who is asking: the token is the permission. Revoked and
+// expired shares and deleted conversations are reported as ErrShareNotFound.
+func (s *chatService) GetConversationForShare(ctx context.Context, token string) (*SharedConversation, error) {
+	if token == "" {
+		return nil, ErrShareNotFound
+	}
+
+	var conversationID string
+	var expiresAt sql.NullTime
+	shared := &SharedConversation{}
+	err := s.db.QueryRow(ctx,
+		`SELECT c.id, c.title, c.status, c.created_at, c.updated_at, sh.include_tools, sh.expires_at
+		FROM conversation_shares sh
+		JOIN conversations c ON c.id = sh.conversation_id
+		WHERE sh.token_hash = $1 AND sh.revoked = false AND c.deleted_at IS NULL`,
+		hashShareToken(token)).Scan(&conversationID, &shared.Title, &shared.Status, &shared.CreatedAt, &shared.UpdatedAt,
+		&shared.IncludeTools, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up share: %w", err)
+	}
+	if expiresAt.Valid {
+		if !s.now().Before(expiresAt.Time) {
+			return nil, ErrShareNotFound
+		}
+		shared.ExpiresAt = &expiresAt.Time
+	}
+
+	var executions ToolExecutions
+	if shared.IncludeTools {
+		if executions, err = LoadToolExecutions(ctx, s.db, conversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, role, content, metadata, tool_calls, created_at
+		FROM messages
+		WHERE conversation_id = $1 AND status <> 'draft'
+		ORDER BY created_at ASC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	shared.Messages = []*SharedMessage{}
+	for rows.Next() {
+		var msg SharedMessage
+		var metadataJSON, toolCallsJSON []byte
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &metadataJSON, &toolCallsJSON, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+
+		// Tool messages and the results of tools run directly hold raw tool output
+		if !shared.IncludeTools {
+			if msg.Role == "tool" || isToolRunMessage(msg.ID, metadataJSON) {
+				continue
+			}
+		} else if calls, _, err := UnmarshalToolCalls(toolCallsJSON); err != nil {
+			log.Printf("Message %s: %v", msg.ID, err)
+		} else {
+			executions.Apply(msg.ID, calls)
+			msg.ToolCalls = calls
+		}
+		shared.Messages = append(shared.Messages, &msg)
+	}
+	return shared, rows.Err()
+}
+
+// isToolRunMessage reports whether stored metadata is that of a message RunTool
+// added; unreadable metadata counts as one, so it is left out of shares
+func isToolRunMessage(messageID string, metadataJSON []byte) bool {
+	metadata, _, err := UnmarshalMetadata(metadataJSON)
+	if err != nil {
+		log.Printf("Message %s: %v", messageID, err)
+		return true
+	}
+	_, ok := metadata["tool_run"]
+	return ok
+}
@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+func setupSharesDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	conn := setupRetentionDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	ctx := context.Background()
+	toolCalls := `[{"id":"call-1","type":"function","function":{"name":"database_query","arguments":"{\"query\":\"SELECT 1\"}"},"status":"completed","result":{"rows":[{"secret":"value"}]}}]`
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ('conv-1-m2', 'conv-1', 'assistant', 'Checking.', $1, $2)",
+			[]interface{}{toolCalls, time.Now().UTC().Add(time.Second)}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('conv-1-m3', 'conv-1', 'tool', '[{\"secret\":\"value\"}]', $1)",
+			[]interface{}{time.Now().UTC().Add(2 * time.Second)}},
+		// The result of a tool run directly, as RunTool stores it
+		{"INSERT INTO messages (id, conversation_id, role, content, metadata, created_at) VALUES ('conv-1-run', 'conv-1', 'system', 'Result of database_query: [{\"secret\":\"value\"}]', $1, $2)",
+			[]interface{}{`{"tool_run":{"tool_name":"database_query"}}`, time.Now().UTC().Add(3 * time.Second)}},
+	} {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to set up shares: %v", err)
+		}
+	}
+	return conn
+}
+
+func TestGetConversationForShareStripsToolResults(t *testing.T) {
+	conn := setupSharesDB(t)
+	ctx := context.Background()
+	service := NewChatService(conn, fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+
+	plain, err := CreateShare(ctx, conn, "user-1", "client-1", "conv-1", false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create share: %v", err)
+	}
+	shared, err := service.GetConversationForShare(ctx, plain.Token)
+	if err != nil {
+		t.Fatalf("Failed to load shared conversation: %v", err)
+	}
+	if len(shared.Messages) != 2 {
+		t.Fatalf("Expected the tool and tool run messages to be left out, got %d messages", len(shared.Messages))
+	}
+	for _, msg := range shared.Messages {
+		if msg.Role == "tool" || len(msg.ToolCalls) != 0 || strings.Contains(msg.Content, "secret") {
+			t.Errorf("Expected no tool output without include_tools, got %+v", msg)
+		}
+	}
+
+	withTools, _ := CreateShare(ctx, conn, "user-1", "client-1", "conv-1", true, nil)
+	shared, err = service.GetConversationForShare(ctx, withTools.Token)
+	if err != nil || len(shared.Messages) != 4 || len(shared.Messages[1].ToolCalls) != 1 || shared.Messages[1].ToolCalls[0].Result == nil {
+		t.Errorf("Expected tool calls and results with include_tools, got %+v, %v", shared, err)
+	}
+}
+
+func TestShareTokensAreStoredHashed(t *testing.T) {
+	conn := setupSharesDB(t)
+	ctx := context.Background()
+	service := NewChatService(conn, fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+
+	share, err := CreateShare(ctx, conn, "user-1", "client-1", "conv-1", false, nil)
+	if err != nil || share.Token == "" {
+		t.Fatalf("Expected the token on the created share, got %+v, %v", share, err)
+	}
+	var stored string
+	if err := conn.QueryRow(ctx, "SELECT token_hash FROM conversation_shares WHERE id = $1", share.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read share: %v", err)
+	}
+	if stored != hashShareToken(share.Token) {
+		t.Errorf("Expected the token's hash to be stored, got %q", stored)
+	}
+
+	// The stored hash does not open the share, and listing never shows tokens
+	if _, err := service.GetConversationForShare(ctx, stored); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("Expected the hash to be refused as a token, got %v", err)
+	}
+	if shares, err := ListShares(ctx, conn, "user-1", "client-1", "conv-1"); err != nil || len(shares) != 1 || shares[0].Token != "" {
+		t.Errorf("Expected one share listed without its token, got %+v, %v", shares, err)
+	}
+}
+
+func TestGetConversationForShareHonoursExpiryAndRevocation(t *testing.T) {
+	conn := setupSharesDB(t)
+	ctx := context.Background()
+	service := NewChatService(conn, fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	share, err := CreateShare(ctx, conn, "user-1", "client-1", "conv-1", false, &expiresAt)
+	if err != nil {
+		t.Fatalf("Failed to create share: %v", err)
+	}
+	if _, err := service.GetConversationForShare(ctx, share.Token); err != nil {
+		t.Errorf("Expected the share to work before it expires, got %v", err)
+	}
+	service.now = func() time.Time { return expiresAt.Add(time.Second) }
+	if _, err := service.GetConversationForShare(ctx, share.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("Expected an expired share to be refused, got %v", err)
+	}
+	service.now = time.Now
+
+	// Only the owner within their client may manage shares
+	if _, err := RevokeShares(ctx, conn, "user-1", "client-2", "conv-1", share.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected another client to be refused, got %v", err)
+	}
+	if _, err := RevokeShares(ctx, conn, "user-1", "client-1", "conv-1", share.ID); err != nil {
+		t.Fatalf("Failed to revoke share: %v", err)
+	}
+	if _, err := service.GetConversationForShare(ctx, share.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("Expected a revoked share to be refused, got %v", err)
+	}
+	if _, err := RevokeShares(ctx, conn, "user-1", "client-1", "conv-1", share.ID); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("Expected revoking twice to report not found, got %v", err)
+	}
+	if shares, err := ListShares(ctx, conn, "user-1", "client-1", "conv-1"); err != nil || len(shares) != 0 {
+		t.Errorf("Expected revoked shares to be unlisted, got %v, %v", shares, err)
+	}
+}
@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StreamState tracks an assistant reply while it streams and for a short
+// retention window after. The identifying fields never change once created;
+// everything else is guarded by mu and is read through the accessors or a
+// Snapshot, never directly from outside this file.
+type StreamState struct {
+	conversationID string
+	userID         string
+	projectID      string
+	messageID      string
+	startTime      time.Time
+
+	mu        sync.RWMutex
+	content   string
+	lastChunk time.Time
+	active    bool
+	// Connections receiving the stream, and every connection that ever joined it
+	activeConnections map[string]bool
+	allConnections    map[string]bool
+	// The user of each connection that joined, and the users the reply is for
+	connectionUsers map[string]string
+	participants    map[string]bool
+	// Closed and replaced whenever a connection attaches or detaches
+	attachChanged chan struct{}
+
+	// Sequence number of the last assistant_response frame, and of the final one once sent
+	seq     int64
+	doneSeq int64
+	// Last sequence each connection acknowledged through resume_stream
+	ackedSeqs map[string]int64
+	// Content length after each frame, indexed by sequence number, and the content of the last frame
+	seqOffsets  []int
+	sentContent string
+}
+
+// StreamStateSnapshot is a point-in-time copy of a StreamState. It shares
+// nothing with the live state, so it can be read and serialized without locking.
+type StreamStateSnapshot struct {
+	ConversationID      string           `json:"conversation_id"`
+	UserID              string           `json:"user_id"`
+	ProjectID           string           `json:"project_id"`
+	MessageID           string           `json:"message_id"`
+	CurrentContent      string           `json:"current_content"`
+	StartTime           time.Time        `json:"start_time"`
+	LastChunk           time.Time        `json:"last_chunk"`
+	IsActive            bool             `json:"is_active"`
+	ActiveConnectionIDs []string         `json:"active_connection_ids"`
+	AllConnectionIDs    []string         `json:"all_connection_ids"`
+	ParticipantIDs      []string         `json:"participant_ids"`
+	Seq                 int64            `json:"seq"`
+	DoneSeq             int64            `json:"done_seq,omitempty"`
+	AckedSeqs           map[string]int64 `json:"acked_seqs"`
+}
+
+func newStreamState(conversationID, userID, projectID, messageID string) *StreamState {
+	return &StreamState{
+		conversationID:    conversationID,
+		userID:            userID,
+		projectID:         projectID,
+		messageID:         messageID,
+		startTime:         time.Now(),
+		active:            true,
+		activeConnections: make(map[string]bool),
+		allConnections:    make(map[string]bool),
+		connectionUsers:   make(map[string]string),
+		participants:      map[string]bool{userID: true},
+		ackedSeqs:         make(map[string]int64),
+		attachChanged:     make(chan struct{}),
+	}
+}
+
+func (st *StreamState) ConversationID() string { return st.conversationID }
+func (st *StreamState) UserID() string         { return st.userID }
+func (st *StreamState) ProjectID() string      { return st.projectID }
+func (st *StreamState) MessageID() string      { return st.messageID }
+func (st *StreamState) StartTime() time.Time   { return st.startTime }
+
+// Content returns the content streamed so far
+func (st *StreamState) Content() string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.content
+}
+
+// IsActive reports whether the reply is still being generated
+func (st *StreamState) IsActive() bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.active
+}
+
+// Snapshot copies the state, including its connection IDs
+func (st *StreamState) Snapshot() StreamStateSnapshot {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	ackedSeqs := make(map[string]int64, len(st.ackedSeqs))
+	for connID, seq := range st.ackedSeqs {
+		ackedSeqs[connID] = seq
+	}
+	return StreamStateSnapshot{
+		ConversationID:      st.conversationID,
+		UserID:              st.userID,
+		ProjectID:           st.projectID,
+		MessageID:           st.messageID,
+		CurrentContent:      st.content,
+		StartTime:           st.startTime,
+		LastChunk:           st.lastChunk,
+		IsActive:            st.active,
+		ActiveConnectionIDs: connectionIDs(st.activeConnections),
+		AllConnectionIDs:    connectionIDs(st.allConnections),
+		ParticipantIDs:      connectionIDs(st.participants),
+		Seq:                 st.seq,
+		DoneSeq:             st.doneSeq,
+		AckedSeqs:           ackedSeqs,
+	}
+}
+
+// HasParticipant reports whether the user takes part in the snapshot's conversation
+func (s StreamStateSnapshot) HasParticipant(userID string) bool {
+	for _, id := range s.ParticipantIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// setParticipants replaces the users the reply is for; the requester always stays one
+func (st *StreamState) setParticipants(userIDs []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.participants = map[string]bool{st.userID: true}
+	for _, id := range userIDs {
+		st.participants[id] = true
+	}
+}
+
+// hasParticipant reports whether the user may receive and resume the stream
+func (st *StreamState) hasParticipant(userID string) bool {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.participants[userID]
+}
+
+// participantIDs returns the users the reply is for
+func (st *StreamState) participantIDs() []string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return connectionIDs(st.participants)
+}
+
+// appendContent adds a streamed chunk and returns the accumulated content
+func (st *StreamState) appendContent(delta string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.content += delta
+	st.lastChunk = time.Now()
+	return st.content
+}
+
+// markCompleted records that the reply has finished; the state stays readable for resume
+func (st *StreamState) markCompleted() {
+	st.mu.Lock()
+	st.active = false
+	st.mu.Unlock()
+}
+
+// attach adds a user's connection to the stream's recipients
+func (st *StreamState) attach(connectionID, userID string) {
+	st.mu.Lock()
+	st.attachLocked(connectionID, userID)
+	st.mu.Unlock()
+}
+
+func (st *StreamState) attachLocked(connectionID, userID string) {
+	st.activeConnections[connectionID] = true
+	st.allConnections[connectionID] = true
+	st.connectionUsers[connectionID] = userID
+	st.notifyAttachmentLocked()
+}
+
+// detach removes a connection from the stream's recipients and returns how many remain
+func (st *StreamState) detach(connectionID string) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.activeConnections[connectionID] {
+		delete(st.activeConnections, connectionID)
+		st.notifyAttachmentLocked()
+	}
+	return len(st.activeConnections)
+}
+
+func (st *StreamState) notifyAttachmentLocked() {
+	close(st.attachChanged)
+	st.attachChanged = make(chan struct{})
+}
+
+// attachment returns how many connections receive the stream, and a channel
+// closed the next time one attaches or detaches
+func (st *StreamState) attachment() (int, <-chan struct{}) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.activeConnections), st.attachChanged
+}
+
+// activeConnectionIDs returns the connections currently receiving the stream
+func (st *StreamState) activeConnectionIDs() []string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return connectionIDs(st.activeConnections)
+}
+
+// connectionUser returns the user whose connection joined the stream
+func (st *StreamState) connectionUser(connectionID string) string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.connectionUsers[connectionID]
+}
+
+// allConnectionIDs returns every connection that ever joined the stream
+func (st *StreamState) allConnectionIDs() []string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return connectionIDs(st.allConnections)
+}
+
+func connectionIDs(set map[string]bool) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// streamState returns the live state for a conversation
+func (s *chatService) streamState(conversationID string) (*StreamState, bool) {
+	s.streamingMutex.RLock()
+	defer s.streamingMutex.RUnlock()
+	streamState, exists := s.activeStreams[conversationID]
+	return streamState, exists
+}
+
+// streamSnapshot returns a copy of a conversation's stream state
+func (s *chatService) streamSnapshot(conversationID string) (StreamStateSnapshot, bool) {
+	streamState, exists := s.streamState(conversationID)
+	if !exists {
+		return StreamStateSnapshot{}, false
+	}
+	return streamState.Snapshot(), true
+}
+
+// activeStreamKeys returns the conversation IDs with a stream in memory
+func (s *chatService) activeStreamKeys() []string {
+	s.streamingMutex.RLock()
+	defer s.streamingMutex.RUnlock()
+	keys := make([]string, 0, len(s.activeStreams))
+	for conversationID := range s.activeStreams {
+		keys = append(keys, conversationID)
+	}
+	return keys
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"zlay-backend/internal/tools"
+)
+
+// Run with -race: chunk updates, frame bookkeeping and connection churn race
+// against every way handlers read stream state
+func TestStreamStateSnapshotsUnderConcurrentUpdates(t *testing.T) {
+	service := NewChatService(nil, fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+	other := service.WithLLMClient(&fakeLLMClient{})
+
+	state := newStreamState("conv-1", "user-1", "project-1", "msg-1")
+	service.streamingMutex.Lock()
+	service.activeStreams["conv-1"] = state
+	service.streamingMutex.Unlock()
+
+	const chunks = 500
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < chunks; i++ {
+			state.recordFrame(state.appendContent("x"), i == chunks-1)
+		}
+		state.markCompleted()
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < chunks; i++ {
+			connID := fmt.Sprintf("conn-%d", i%5)
+			if err := service.AttachConnectionToStream("conv-1", connID, "user-1"); err != nil {
+				t.Errorf("AttachConnectionToStream failed: %v", err)
+				return
+			}
+			if i%3 == 0 {
+				state.detach(connID)
+			}
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// WithLLMClient copies share the same streams
+				for _, snapshot := range other.GetAllActiveStreams() {
+					if _, err := json.Marshal(snapshot); err != nil {
+						t.Errorf("Failed to marshal snapshot: %v", err)
+						return
+					}
+				}
+				if snapshot, err := service.GetStreamState("conv-1"); err == nil {
+					_ = len(snapshot.ActiveConnectionIDs) + len(snapshot.AckedSeqs) + len(snapshot.CurrentContent)
+				}
+				if _, err := service.GetActiveStreamingMessage("conv-1", "user-1"); err != nil {
+					t.Errorf("GetActiveStreamingMessage failed: %v", err)
+					return
+				}
+				service.GetActiveConnectionsForStream("conv-1")
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	snapshot, err := other.GetStreamState("conv-1")
+	if err != nil {
+		t.Fatalf("GetStreamState failed: %v", err)
+	}
+	if len(snapshot.CurrentContent) != chunks || snapshot.Seq != chunks || snapshot.DoneSeq != chunks || snapshot.IsActive {
+		t.Errorf("Unexpected final state: content %d, seq %d, done_seq %d, active %t",
+			len(snapshot.CurrentContent), snapshot.Seq, snapshot.DoneSeq, snapshot.IsActive)
+	}
+
+	// Snapshots are copies: changing one does not reach the live state
+	snapshot.ActiveConnectionIDs = append(snapshot.ActiveConnectionIDs[:0], "forged")
+	snapshot.AckedSeqs["forged"] = 1
+	if again, _ := service.GetStreamState("conv-1"); len(again.AckedSeqs) != 0 {
+		t.Errorf("Expected snapshot changes not to leak into the stream state, got %v", again.AckedSeqs)
+	}
+}
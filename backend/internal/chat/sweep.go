@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultAbandonedAfter is how long a conversation may stay processing
+	// without a stream in this process before it is marked interrupted
+	DefaultAbandonedAfter = 5 * time.Minute
+	// DefaultAbandonedSweepInterval is how often abandoned conversations are looked for
+	DefaultAbandonedSweepInterval = time.Minute
+
+	// abandonedNote is added to the metadata of the last assistant message
+	abandonedNote = "The response was interrupted because the server stopped processing it."
+)
+
+// abandonedConversation is a processing conversation found by the sweep
+type abandonedConversation struct {
+	id        string
+	projectID string
+}
+
+// RunAbandonedSweep marks abandoned conversations interrupted right away, to
+// clean up after the previous process, and then every interval until ctx is
+// cancelled
+func (s *chatService) RunAbandonedSweep(ctx context.Context, interval, abandonedAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if swept, err := s.SweepAbandoned(ctx, abandonedAfter); err != nil {
+			log.Printf("Abandoned conversation sweep failed after %d conversations: %v", swept, err)
+		} else if swept > 0 {
+			log.Printf("Marked %d abandoned conversations as interrupted", swept)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepAbandoned marks conversations interrupted that are still processing,
+// have no stream in this service and were last updated longer than
+// abandonedAfter ago. The project room is told of each one. It returns how
+// many conversations were changed.
+func (s *chatService) SweepAbandoned(ctx context.Context, abandonedAfter time.Duration) (int, error) {
+	if abandonedAfter <= 0 {
+		abandonedAfter = DefaultAbandonedAfter
+	}
+	now := s.now()
+	cutoff := now.Add(-abandonedAfter)
+
+	candidates, err := s.findAbandoned(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for _, conversation := range candidates {
+		if s.hasStream(conversation.id) {
+			continue
+		}
+
+		// Re-check the status and age so a conversation that resumed since the select is kept
+		result, err := s.db.Exec(ctx,
+			"UPDATE conversations SET status = 'interrupted', updated_at = $1 WHERE id = $2 AND status = 'processing' AND updated_at < $3",
+			now, conversation.id, cutoff)
+		if err != nil {
+			return swept, fmt.Errorf("failed to interrupt conversation %s: %w", conversation.id, err)
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+		swept++
+
+		if err := s.noteInterruptedReply(ctx, conversation.id, now); err != nil {
+			log.Printf("Failed to annotate interrupted reply of conversation %s: %v", conversation.id, err)
+		}
+		if s.hub != nil {
+			s.hub.BroadcastToProject(conversation.projectID, WebSocketMessage{
+				Type: "conversation_status_updated",
+				Data: gin.H{
+					"conversation_id": conversation.id,
+					"status":          "interrupted",
+					"reason":          "abandoned",
+				},
+				Timestamp: now.UnixMilli(),
+			})
+		}
+	}
+	return swept, nil
+}
+
+// findAbandoned lists processing conversations last updated before cutoff
+func (s *chatService) findAbandoned(ctx context.Context, cutoff time.Time) ([]abandonedConversation, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT id, project_id FROM conversations WHERE status = 'processing' AND updated_at < $1 AND deleted_at IS NULL",
+		cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find abandoned conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []abandonedConversation
+	for rows.Next() {
+		var conversation abandonedConversation
+		if err := rows.Scan(&conversation.id, &conversation.projectID); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// hasStream reports whether this service is processing or streaming the conversation
+func (s *chatService) hasStream(conversationID string) bool {
+	s.streamingMutex.RLock()
+	defer s.streamingMutex.RUnlock()
+
+	if s.pendingStreams[conversationID] {
+		return true
+	}
+	streamState, exists := s.activeStreams[conversationID]
+	return exists && streamState.IsActive()
+}
+
+// noteInterruptedReply records in the metadata of the conversation's last
+// assistant message, if it has one, that the reply was cut short
+func (s *chatService) noteInterruptedReply(ctx context.Context, conversationID string, at time.Time) error {
+	var messageID string
+	var metadataJSON []byte
+	err := s.db.QueryRow(ctx,
+		"SELECT id, metadata FROM messages WHERE conversation_id = $1 AND role = 'assistant' ORDER BY created_at DESC LIMIT 1",
+		conversationID).Scan(&messageID, &metadataJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	metadata, _, err := UnmarshalMetadata(metadataJSON)
+	if err != nil {
+		log.Printf("Message %s: %v", messageID, err)
+	}
+	metadata["interrupted"] = true
+	metadata["interrupted_at"] = at.UTC().Format(time.RFC3339)
+	metadata["system_note"] = abandonedNote
+
+	updated, err := MarshalMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, "UPDATE messages SET metadata = $1 WHERE id = $2", updated, messageID)
+	s.history.Invalidate(conversationID)
+	return err
+}
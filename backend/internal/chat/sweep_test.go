@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+func TestSweepAbandonedInterruptsStaleProcessingConversations(t *testing.T) {
+	conn := setupRetentionDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, seed := range []struct {
+		id, status string
+		age        time.Duration
+	}{
+		{"conv-stale", "processing", 10 * time.Minute},
+		{"conv-recent", "processing", time.Minute},
+		{"conv-streaming", "processing", 10 * time.Minute},
+		{"conv-done", "completed", 10 * time.Minute},
+	} {
+		if _, err := conn.Exec(ctx,
+			"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ($1, 'Test', 'user-1', 'project-1', $2, $3, $3)",
+			seed.id, seed.status, now.Add(-seed.age)); err != nil {
+			t.Fatalf("Failed to seed %s: %v", seed.id, err)
+		}
+	}
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, metadata, created_at) VALUES ('msg-1', 'conv-stale', 'assistant', 'Partial', '{\"model\":\"m\"}', $1)",
+		now.Add(-10*time.Minute)); err != nil {
+		t.Fatalf("Failed to seed message: %v", err)
+	}
+
+	hub := &recordingHub{}
+	service := NewChatService(conn, hub, &fakeLLMClient{}, tools.NewToolRegistry())
+	service.now = func() time.Time { return now }
+	service.pendingStreams["conv-streaming"] = true
+
+	swept, err := service.SweepAbandoned(ctx, 5*time.Minute)
+	if err != nil || swept != 1 {
+		t.Fatalf("Expected one conversation swept, got %d, %v", swept, err)
+	}
+	for id, want := range map[string]string{
+		"conv-stale":     "interrupted",
+		"conv-recent":    "processing",
+		"conv-streaming": "processing",
+		"conv-done":      "completed",
+	} {
+		var status string
+		if err := conn.QueryRow(ctx, "SELECT status FROM conversations WHERE id = $1", id).Scan(&status); err != nil || status != want {
+			t.Errorf("%s: expected %s, got %q, %v", id, want, status, err)
+		}
+	}
+
+	var metadataJSON []byte
+	if err := conn.QueryRow(ctx, "SELECT metadata FROM messages WHERE id = 'msg-1'").Scan(&metadataJSON); err != nil {
+		t.Fatalf("Failed to load message: %v", err)
+	}
+	var metadata map[string]interface{}
+	json.Unmarshal(metadataJSON, &metadata)
+	if metadata["interrupted"] != true || metadata["system_note"] == nil || metadata["model"] != "m" {
+		t.Errorf("Expected the reply to be annotated, got %v", metadata)
+	}
+
+	events := hub.eventsOfType("conversation_status_updated")
+	if len(events) != 1 || events[0].Data["conversation_id"] != "conv-stale" || events[0].Data["status"] != "interrupted" {
+		t.Errorf("Expected one status update for conv-stale, got %+v", events)
+	}
+
+	// Once the threshold passes the recent conversation is swept too; the
+	// conversation without an assistant reply only changes status
+	service.now = func() time.Time { return now.Add(5 * time.Minute) }
+	if swept, err := service.SweepAbandoned(ctx, 5*time.Minute); err != nil || swept != 1 {
+		t.Errorf("Expected conv-recent to be swept later, got %d, %v", swept, err)
+	}
+}
@@ -0,0 +1,235 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// ErrToolCallNotFound is returned when a tool call is not in the conversation
+var ErrToolCallNotFound = errors.New("tool call not found")
+
+// rerunMutex serializes the read-modify-write of a message's tool calls so
+// concurrent reruns do not drop each other
+var rerunMutex sync.Mutex
+
+// ToolCallRerun is one re-execution of a tool call, kept alongside the original result
+type ToolCallRerun struct {
+	Status     string      `json:"status"` // completed or failed
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int         `json:"duration_ms"`
+	Forced     bool        `json:"forced,omitempty"`
+	RerunBy    string      `json:"rerun_by"`
+	RerunAt    time.Time   `json:"rerun_at"`
+}
+
+// ToolCallRecord is a tool call flattened out of the assistant message that made it
+type ToolCallRecord struct {
+	MessageID  string          `json:"message_id"`
+	ToolCallID string          `json:"tool_call_id"`
+	ToolName   string          `json:"tool_name"`
+	Arguments  interface{}     `json:"arguments"`
+	Status     string          `json:"status"`
+	DurationMs int             `json:"duration_ms"`
+	ResultSize int             `json:"result_size"` // Bytes of the JSON-encoded result
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Reruns     []ToolCallRerun `json:"reruns,omitempty"`
+}
+
+// ParsedArguments returns the call's arguments as a map. Providers send them
+// either as an object or as a JSON-encoded string; anything else is empty.
+func (tc ToolCall) ParsedArguments() map[string]interface{} {
+	switch args := tc.Function.Arguments.(type) {
+	case map[string]interface{}:
+		return args
+	case string:
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(args), &parsed); err == nil && parsed != nil {
+			return parsed
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// newToolCallRecord flattens a stored tool call. Failed calls are saved with
+// {"error": "..."} as their result, which is reported as the error.
+func newToolCallRecord(messageID string, createdAt time.Time, call ToolCall) ToolCallRecord {
+	record := ToolCallRecord{
+		MessageID:  messageID,
+		ToolCallID: call.ID,
+		ToolName:   call.Function.Name,
+		Arguments:  call.Function.Arguments,
+		Status:     call.Status,
+		Error:      call.Error,
+		CreatedAt:  createdAt,
+		Reruns:     call.Reruns,
+	}
+	if record.Status == "" {
+		record.Status = "pending"
+	}
+	if call.Result != nil {
+		if encoded, err := json.Marshal(call.Result); err == nil {
+			record.ResultSize = len(encoded)
+		}
+		if timeMs, ok := call.Result["time_ms"].(float64); ok {
+			record.DurationMs = int(timeMs)
+		}
+		if message, ok := call.Result["error"].(string); ok && record.Error == "" && record.Status == "failed" {
+			record.Error = message
+		}
+	}
+	return record
+}
+
+// conversationProject checks that a conversation is one of the user's own and returns its project
+func conversationProject(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) (string, error) {
+	if err := ownsConversation(ctx, db, userID, clientID, conversationID); err != nil {
+		return "", err
+	}
+	var projectID string
+	if err := db.QueryRow(ctx, "SELECT project_id FROM conversations WHERE id = $1", conversationID).Scan(&projectID); err != nil {
+		return "", fmt.Errorf("failed to load conversation: %w", err)
+	}
+	return projectID, nil
+}
+
+// ListToolCalls returns every tool call in one of the user's conversations,
+// oldest first, with the outcome of its execution
+func ListToolCalls(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID string) ([]ToolCallRecord, error) {
+	if err := ownsConversation(ctx, db, userID, clientID, conversationID); err != nil {
+		return nil, err
+	}
+	executions, err := LoadToolExecutions(ctx, db, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT id, tool_calls, created_at
+		FROM messages
+		WHERE conversation_id = $1 AND tool_calls IS NOT NULL
+		ORDER BY created_at ASC`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	records := []ToolCallRecord{}
+	for rows.Next() {
+		var messageID string
+		var toolCallsJSON []byte
+		var createdAt time.Time
+		if err := rows.Scan(&messageID, &toolCallsJSON, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		calls, _, err := UnmarshalToolCalls(toolCallsJSON)
+		if err != nil {
+			log.Printf("Message %s: %v", messageID, err)
+			continue
+		}
+		executions.Apply(messageID, calls)
+		for _, call := range calls {
+			record := newToolCallRecord(messageID, createdAt, call)
+			if execution := executions.Get(messageID, call.ID); execution != nil && execution.FinishedAt != nil {
+				record.DurationMs = execution.DurationMs
+			}
+			records = append(records, record)
+		}
+	}
+	return records, rows.Err()
+}
+
+// FindToolCall returns a tool call from one of the user's conversations and the
+// project the conversation belongs to
+func FindToolCall(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID, toolCallID string) (*ToolCall, string, error) {
+	projectID, err := conversationProject(ctx, db, userID, clientID, conversationID)
+	if err != nil {
+		return nil, "", err
+	}
+	messageID, calls, err := loadToolCallMessage(ctx, db, conversationID, toolCallID)
+	if err != nil {
+		return nil, "", err
+	}
+	executions, err := LoadToolExecutions(ctx, db, conversationID)
+	if err != nil {
+		return nil, "", err
+	}
+	executions.Apply(messageID, calls)
+	for i := range calls {
+		if calls[i].ID == toolCallID {
+			return &calls[i], projectID, nil
+		}
+	}
+	return nil, "", ErrToolCallNotFound
+}
+
+// loadToolCallMessage returns the ID and tool calls of the message holding a tool call
+func loadToolCallMessage(ctx context.Context, db tools.DBConnection, conversationID, toolCallID string) (string, []ToolCall, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, tool_calls
+		FROM messages
+		WHERE conversation_id = $1 AND tool_calls IS NOT NULL
+		ORDER BY created_at ASC`,
+		conversationID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var toolCallsJSON []byte
+		if err := rows.Scan(&messageID, &toolCallsJSON); err != nil {
+			return "", nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		calls, _, err := UnmarshalToolCalls(toolCallsJSON)
+		if err != nil {
+			log.Printf("Message %s: %v", messageID, err)
+			continue
+		}
+		for _, call := range calls {
+			if call.ID == toolCallID {
+				return messageID, calls, nil
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, ErrToolCallNotFound
+}
+
+// AppendToolCallRerun stores a rerun with the tool call it repeated, leaving
+// the original result and the message's other tool calls unchanged
+func AppendToolCallRerun(ctx context.Context, db tools.DBConnection, conversationID, toolCallID string, rerun ToolCallRerun) error {
+	rerunMutex.Lock()
+	defer rerunMutex.Unlock()
+
+	messageID, calls, err := loadToolCallMessage(ctx, db, conversationID, toolCallID)
+	if err != nil {
+		return err
+	}
+	for i := range calls {
+		if calls[i].ID == toolCallID {
+			calls[i].Reruns = append(calls[i].Reruns, rerun)
+		}
+	}
+
+	toolCallsJSON, err := MarshalToolCalls(calls)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, "UPDATE messages SET tool_calls = $1 WHERE id = $2", toolCallsJSON, messageID); err != nil {
+		return fmt.Errorf("failed to save rerun: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListToolCallsFlattensMessages(t *testing.T) {
+	conn := setupSharesDB(t)
+	ctx := context.Background()
+	toolCalls := `[{"id":"call-2","type":"function","function":{"name":"api_request","arguments":{"method":"GET"}},"status":"completed","result":{"status":"completed","time_ms":42}},` +
+		`{"id":"call-3","type":"function","function":{"name":"database_query","arguments":"{}"},"status":"failed","result":{"error":"boom"}}]`
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ('conv-1-m4', 'conv-1', 'assistant', 'Again.', $1, $2)",
+		toolCalls, time.Now().UTC().Add(3*time.Second)); err != nil {
+		t.Fatalf("Failed to insert message: %v", err)
+	}
+
+	records, err := ListToolCalls(ctx, conn, "user-1", "client-1", "conv-1")
+	if err != nil {
+		t.Fatalf("Failed to list tool calls: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 tool calls, got %+v", records)
+	}
+	if records[0].ToolCallID != "call-1" || records[0].MessageID != "conv-1-m2" || records[0].ResultSize == 0 {
+		t.Errorf("Expected the first call first with its result size, got %+v", records[0])
+	}
+	if records[1].ToolName != "api_request" || records[1].DurationMs != 42 {
+		t.Errorf("Expected the duration from time_ms, got %+v", records[1])
+	}
+	if records[2].Status != "failed" || records[2].Error != "boom" {
+		t.Errorf("Expected the stored error to be reported, got %+v", records[2])
+	}
+
+	if _, err := ListToolCalls(ctx, conn, "user-2", "client-1", "conv-1"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Expected ErrConversationNotFound for another user, got %v", err)
+	}
+}
+
+func TestAppendToolCallRerunKeepsOriginal(t *testing.T) {
+	conn := setupSharesDB(t)
+	ctx := context.Background()
+	toolCalls := `[{"id":"call-a","type":"function","function":{"name":"system_info","arguments":{}},"status":"completed","result":{"status":"completed"}},` +
+		`{"id":"call-b","type":"function","function":{"name":"system_info","arguments":{}},"status":"completed","result":{"status":"completed"}}]`
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ('conv-1-m4', 'conv-1', 'assistant', 'Again.', $1, $2)",
+		toolCalls, time.Now().UTC().Add(3*time.Second)); err != nil {
+		t.Fatalf("Failed to insert message: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rerun := ToolCallRerun{Status: "completed", Result: map[string]interface{}{"run": i}, RerunBy: "user-1", RerunAt: time.Now().UTC()}
+		if err := AppendToolCallRerun(ctx, conn, "conv-1", "call-b", rerun); err != nil {
+			t.Fatalf("Failed to append rerun: %v", err)
+		}
+	}
+
+	var stored string
+	if err := conn.QueryRow(ctx, "SELECT tool_calls FROM messages WHERE id = 'conv-1-m4'").Scan(&stored); err != nil {
+		t.Fatalf("Failed to load tool calls: %v", err)
+	}
+	var calls []ToolCall
+	if err := json.Unmarshal([]byte(stored), &calls); err != nil {
+		t.Fatalf("Failed to decode tool calls: %v", err)
+	}
+	if len(calls) != 2 || len(calls[0].Reruns) != 0 {
+		t.Fatalf("Expected the other tool call to be untouched, got %+v", calls)
+	}
+	if calls[1].Result["status"] != "completed" || len(calls[1].Reruns) != 2 {
+		t.Errorf("Expected the original result and two reruns, got %+v", calls[1])
+	}
+
+	if err := AppendToolCallRerun(ctx, conn, "conv-1", "missing", ToolCallRerun{}); !errors.Is(err, ErrToolCallNotFound) {
+		t.Errorf("Expected ErrToolCallNotFound, got %v", err)
+	}
+}
+
+func TestToolCallParsedArguments(t *testing.T) {
+	fromString := ToolCall{Function: ToolCallFunction{Arguments: `{"query":"SELECT 1"}`}}
+	if fromString.ParsedArguments()["query"] != "SELECT 1" {
+		t.Errorf("Expected string arguments to be decoded, got %v", fromString.ParsedArguments())
+	}
+	invalid := ToolCall{Function: ToolCallFunction{Arguments: "not json"}}
+	if args := invalid.ParsedArguments(); args == nil || len(args) != 0 {
+		t.Errorf("Expected empty arguments for invalid JSON, got %v", args)
+	}
+}
@@ -0,0 +1,315 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// ToolExecution is one execution of a tool call, kept in tool_executions. The
+// message's tool_calls only reference it by tool call ID and status.
+type ToolExecution struct {
+	ID             string                 `json:"id"` // The tool call's ID
+	MessageID      string                 `json:"message_id"`
+	ConversationID string                 `json:"conversation_id"`
+	ToolName       string                 `json:"tool_name"`
+	Arguments      interface{}            `json:"arguments"`
+	Status         string                 `json:"status"` // executing, completed, failed
+	Result         map[string]interface{} `json:"result,omitempty"`
+	ResultFilePath string                 `json:"-"` // Set instead of Result for results over the inline limit
+	Error          string                 `json:"error,omitempty"`
+	StartedAt      time.Time              `json:"started_at"`
+	FinishedAt     *time.Time             `json:"finished_at,omitempty"`
+	DurationMs     int                    `json:"duration_ms"`
+}
+
+// ToolResultStorage is where results too large to keep in tool_executions are
+// written. With no Dir every result is kept inline.
+type ToolResultStorage struct {
+	Dir            string
+	MaxInlineBytes int
+}
+
+// SetToolResultStorage sets where large tool results are written; call before serving requests
+func (s *chatService) SetToolResultStorage(storage ToolResultStorage) {
+	s.toolResults = storage
+}
+
+// StartToolExecution records a tool call as executing
+func StartToolExecution(ctx context.Context, db tools.DBConnection, execution *ToolExecution) error {
+	arguments, err := json.Marshal(execution.Arguments)
+	if err != nil {
+		return fmt.Errorf("failed to encode tool arguments: %w", err)
+	}
+	if _, err := db.Exec(ctx,
+		`INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		execution.ID, execution.MessageID, execution.ConversationID, execution.ToolName,
+		arguments, execution.Status, execution.StartedAt); err != nil {
+		return fmt.Errorf("failed to record tool execution: %w", err)
+	}
+	return nil
+}
+
+// FinishToolExecution records the outcome of an execution started with
+// StartToolExecution. result is the encoded result of a completed call; when it
+// is larger than the storage's inline limit it is written to a file instead.
+func FinishToolExecution(ctx context.Context, db tools.DBConnection, storage ToolResultStorage, execution *ToolExecution, result json.RawMessage) error {
+	finishedAt := time.Now()
+	execution.FinishedAt = &finishedAt
+	execution.DurationMs = int(finishedAt.Sub(execution.StartedAt).Milliseconds())
+
+	var inline, path, errorText interface{}
+	if len(result) > 0 {
+		if storage.Dir != "" && storage.MaxInlineBytes > 0 && len(result) > storage.MaxInlineBytes {
+			written, err := writeToolResult(storage.Dir, result)
+			if err != nil {
+				return err
+			}
+			execution.ResultFilePath = written
+			path = written
+		} else {
+			inline = []byte(result)
+		}
+	}
+	if execution.Error != "" {
+		errorText = execution.Error
+	}
+
+	if _, err := db.Exec(ctx,
+		`UPDATE tool_executions SET status = $1, result = $2, result_file_path = $3, error = $4, finished_at = $5, duration_ms = $6
+		WHERE message_id = $7 AND id = $8`,
+		execution.Status, inline, path, errorText, finishedAt, execution.DurationMs,
+		execution.MessageID, execution.ID); err != nil {
+		if execution.ResultFilePath != "" {
+			removeToolResult(execution.ResultFilePath)
+		}
+		return fmt.Errorf("failed to record tool execution result: %w", err)
+	}
+	return nil
+}
+
+// writeToolResult writes a result to a new file in dir and returns its path
+func writeToolResult(dir string, result json.RawMessage) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create tool results directory: %w", err)
+	}
+	path := filepath.Join(dir, uuid.New().String()+".json")
+	if err := os.WriteFile(path, result, 0o640); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write tool result: %w", err)
+	}
+	return path, nil
+}
+
+// removeToolResult deletes a result file, logging rather than failing when it cannot
+func removeToolResult(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove tool result %s: %v", path, err)
+	}
+}
+
+// ToolExecutions holds the executions of a conversation by message ID and tool call ID
+type ToolExecutions map[string]map[string]*ToolExecution
+
+// Get returns the execution of a message's tool call, or nil
+func (e ToolExecutions) Get(messageID, toolCallID string) *ToolExecution {
+	return e[messageID][toolCallID]
+}
+
+// Apply fills in the status and result of a message's tool calls from their
+// executions, in the shape tool calls embedded them before: a failed call has
+// {"error": "..."} as its result. Calls without an execution are left as stored.
+func (e ToolExecutions) Apply(messageID string, calls []ToolCall) {
+	for i := range calls {
+		execution := e.Get(messageID, calls[i].ID)
+		if execution == nil {
+			continue
+		}
+		calls[i].Status = execution.Status
+		calls[i].Result = execution.LoadResult()
+		if calls[i].Result == nil && execution.Status == "failed" && execution.Error != "" {
+			calls[i].Result = map[string]interface{}{"error": execution.Error}
+		}
+	}
+}
+
+// LoadResult returns the execution's result, reading it from its file when
+// it was too large to keep inline. A file that cannot be read is logged and
+// reported as a nil result.
+func (e *ToolExecution) LoadResult() map[string]interface{} {
+	if e.Result != nil || e.ResultFilePath == "" {
+		return e.Result
+	}
+	data, err := os.ReadFile(e.ResultFilePath)
+	if err != nil {
+		log.Printf("Tool execution %s of message %s: failed to read result: %v", e.ID, e.MessageID, err)
+		return nil
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Printf("Tool execution %s of message %s: failed to decode result: %v", e.ID, e.MessageID, err)
+		return nil
+	}
+	e.Result = result
+	return result
+}
+
+// LoadToolExecutions returns the tool executions of a conversation. Results
+// kept in files are read by LoadResult when they are needed.
+func LoadToolExecutions(ctx context.Context, db tools.DBConnection, conversationID string) (ToolExecutions, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, message_id, tool_name, arguments, status, result, result_file_path, error, started_at, finished_at, duration_ms
+		FROM tool_executions WHERE conversation_id = $1`,
+		conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tool executions: %w", err)
+	}
+	defer rows.Close()
+
+	executions := ToolExecutions{}
+	for rows.Next() {
+		execution := &ToolExecution{ConversationID: conversationID}
+		var arguments, result []byte
+		var path, errorText sql.NullString
+		var finishedAt sql.NullTime
+		var durationMs sql.NullInt64
+		if err := rows.Scan(&execution.ID, &execution.MessageID, &execution.ToolName, &arguments, &execution.Status,
+			&result, &path, &errorText, &execution.StartedAt, &finishedAt, &durationMs); err != nil {
+			return nil, fmt.Errorf("failed to scan tool execution: %w", err)
+		}
+		if len(arguments) > 0 {
+			if err := json.Unmarshal(arguments, &execution.Arguments); err != nil {
+				log.Printf("Tool execution %s of message %s: failed to decode arguments: %v", execution.ID, execution.MessageID, err)
+			}
+		}
+		if len(result) > 0 {
+			if err := json.Unmarshal(result, &execution.Result); err != nil {
+				log.Printf("Tool execution %s of message %s: failed to decode result: %v", execution.ID, execution.MessageID, err)
+			}
+		}
+		execution.ResultFilePath = path.String
+		execution.Error = errorText.String
+		if finishedAt.Valid {
+			execution.FinishedAt = &finishedAt.Time
+		}
+		execution.DurationMs = int(durationMs.Int64)
+
+		if executions[execution.MessageID] == nil {
+			executions[execution.MessageID] = map[string]*ToolExecution{}
+		}
+		executions[execution.MessageID][execution.ID] = execution
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load tool executions: %w", err)
+	}
+	return executions, nil
+}
+
+// attachToolExecutions joins the executions of a conversation's tool calls
+// into messages read from it
+func attachToolExecutions(ctx context.Context, db tools.DBConnection, conversationID string, messages []*Message) error {
+	hasToolCalls := false
+	for _, msg := range messages {
+		if len(msg.ToolCalls) > 0 {
+			hasToolCalls = true
+			break
+		}
+	}
+	if !hasToolCalls {
+		return nil
+	}
+
+	executions, err := LoadToolExecutions(ctx, db, conversationID)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		executions.Apply(msg.ID, msg.ToolCalls)
+	}
+	return nil
+}
+
+// copyToolExecutions copies the executions of a message's tool calls to a copy
+// of the message in another conversation. Results kept in files are copied to
+// new files, so purging either conversation leaves the other's intact; the
+// files written are returned so they can be removed if tx is rolled back.
+func copyToolExecutions(ctx context.Context, tx *sql.Tx, messageID, toMessageID, toConversationID string) (written []string, err error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, result_file_path FROM tool_executions WHERE message_id = $1 AND result_file_path IS NOT NULL`,
+		messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tool executions: %w", err)
+	}
+	files := map[string]string{}
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan tool execution: %w", err)
+		}
+		files[id] = path
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tool executions: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result, result_file_path, error, started_at, finished_at, duration_ms)
+		SELECT id, $1, $2, tool_name, arguments, status, result, NULL, error, started_at, finished_at, duration_ms
+		FROM tool_executions WHERE message_id = $3`,
+		toMessageID, toConversationID, messageID); err != nil {
+		return nil, fmt.Errorf("failed to copy tool executions: %w", err)
+	}
+
+	for id, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Tool execution %s of message %s: failed to read result: %v", id, messageID, err)
+			continue
+		}
+		copied, err := writeToolResult(filepath.Dir(path), data)
+		if err != nil {
+			return written, err
+		}
+		written = append(written, copied)
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE tool_executions SET result_file_path = $1 WHERE message_id = $2 AND id = $3",
+			copied, toMessageID, id); err != nil {
+			return written, fmt.Errorf("failed to copy tool execution result: %w", err)
+		}
+	}
+	return written, nil
+}
+
+// toolResultFiles returns the result files of the tool executions of the
+// conversations matching condition, a WHERE clause over conversations
+func toolResultFiles(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT result_file_path FROM tool_executions
+		WHERE result_file_path IS NOT NULL AND conversation_id IN (SELECT id FROM conversations WHERE `+condition+`)`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tool results: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan tool result path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
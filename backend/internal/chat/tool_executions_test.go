@@ -0,0 +1,235 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/tools"
+)
+
+// echoTool returns its text parameter as its data
+type echoTool struct{}
+
+func (echoTool) Name() string        { return "echo" }
+func (echoTool) Description() string { return "Echoes its text" }
+func (echoTool) GetCategory() string { return "test" }
+func (echoTool) Parameters() map[string]tools.ToolParameter {
+	return map[string]tools.ToolParameter{"text": {Type: "string", Required: true}}
+}
+func (echoTool) ValidateAccess(userID, projectID string) bool { return true }
+func (echoTool) Execute(ctx context.Context, params map[string]interface{}) (*tools.ToolResult, error) {
+	return &tools.ToolResult{Status: "completed", Data: map[string]interface{}{"text": params["text"]}}, nil
+}
+
+// runToolCalls executes a small, a large and a failing tool call for an
+// assistant reply in conv-1 and saves the reply. Results over 256 bytes are
+// written to the returned directory.
+func runToolCalls(t *testing.T) (*chatService, *tools.ZlayDBAdapter, *Message, string) {
+	t.Helper()
+
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	registry := tools.NewToolRegistry()
+	if err := registry.RegisterTool(echoTool{}); err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, &scriptedLLMClient{}, registry)
+	dir := filepath.Join(t.TempDir(), "tool_results")
+	service.SetToolResultStorage(ToolResultStorage{Dir: dir, MaxInlineBytes: 256})
+
+	msg := NewMessage("conv-1", "assistant", "Checking.", "", "project-1")
+	msg.CreatedAt = time.Now().UTC().Add(time.Second)
+	msg.ToolCalls = []ToolCall{
+		*NewToolCall("call-small", "function", "echo", map[string]interface{}{"text": "hi"}),
+		*NewToolCall("call-large", "function", "echo", map[string]interface{}{"text": strings.Repeat("x", 1024)}),
+		*NewToolCall("call-missing", "function", "missing_tool", map[string]interface{}{}),
+	}
+	if err := service.processToolCalls(context.Background(), userMessageRequest(""), msg); err != nil {
+		t.Fatalf("processToolCalls failed: %v", err)
+	}
+
+	toolCalls, err := MarshalToolCalls(msg.ToolCalls)
+	if err != nil {
+		t.Fatalf("MarshalToolCalls failed: %v", err)
+	}
+	if _, err := conn.Exec(context.Background(),
+		"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ($1, 'conv-1', 'assistant', $2, $3, $4)",
+		msg.ID, msg.Content, toolCalls, msg.CreatedAt); err != nil {
+		t.Fatalf("Failed to save reply: %v", err)
+	}
+	return service, conn, msg, dir
+}
+
+func TestProcessToolCallsRecordsExecutions(t *testing.T) {
+	_, conn, msg, dir := runToolCalls(t)
+	ctx := context.Background()
+
+	// The reply keeps only the status of each call
+	for _, call := range msg.ToolCalls {
+		if call.Result != nil {
+			t.Errorf("%s: expected the result to stay out of the message, got %v", call.ID, call.Result)
+		}
+	}
+	if msg.ToolCalls[0].Status != "completed" || msg.ToolCalls[2].Status != "failed" {
+		t.Errorf("Expected the statuses to be kept, got %+v", msg.ToolCalls)
+	}
+
+	executions, err := LoadToolExecutions(ctx, conn, "conv-1")
+	if err != nil {
+		t.Fatalf("LoadToolExecutions failed: %v", err)
+	}
+	small := executions.Get(msg.ID, "call-small")
+	if small == nil || small.Status != "completed" || small.ToolName != "echo" || small.ResultFilePath != "" || small.Result == nil || small.FinishedAt == nil {
+		t.Fatalf("Expected the small result inline, got %+v", small)
+	}
+	large := executions.Get(msg.ID, "call-large")
+	if large == nil || large.Result != nil || filepath.Dir(large.ResultFilePath) != dir {
+		t.Fatalf("Expected the large result in a file under %s, got %+v", dir, large)
+	}
+	if data, _ := large.LoadResult()["data"].(map[string]interface{}); data["text"] != strings.Repeat("x", 1024) {
+		t.Errorf("Expected the large result to load from its file, got %v", large.Result)
+	}
+	missing := executions.Get(msg.ID, "call-missing")
+	if missing == nil || missing.Status != "failed" || missing.Error == "" || missing.Result != nil {
+		t.Errorf("Expected the failed call with its error, got %+v", missing)
+	}
+}
+
+func TestReadsJoinToolExecutions(t *testing.T) {
+	service, conn, msg, _ := runToolCalls(t)
+	ctx := context.Background()
+
+	details, err := service.GetConversation("conv-1", "user-1")
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	var calls []ToolCall
+	for _, m := range details.Messages {
+		if m.ID == msg.ID {
+			calls = m.ToolCalls
+		}
+	}
+	if len(calls) != 3 {
+		t.Fatalf("Expected the reply's three tool calls, got %+v", calls)
+	}
+	if data, _ := calls[1].Result["data"].(map[string]interface{}); data["text"] != strings.Repeat("x", 1024) {
+		t.Errorf("Expected the large result to be joined in, got %v", calls[1].Result)
+	}
+	if calls[2].Status != "failed" || calls[2].Result["error"] == nil {
+		t.Errorf("Expected the failed call to report its error as its result, got %+v", calls[2])
+	}
+
+	records, err := ListToolCalls(ctx, conn, "user-1", "client-1", "conv-1")
+	if err != nil {
+		t.Fatalf("ListToolCalls failed: %v", err)
+	}
+	if len(records) != 3 || records[1].ResultSize <= 1024 || records[2].Error == "" {
+		t.Errorf("Expected the listed calls to carry their results, got %+v", records)
+	}
+}
+
+func TestForkAndPurgeKeepToolResultFilesApart(t *testing.T) {
+	_, conn, msg, _ := runToolCalls(t)
+	ctx := context.Background()
+
+	fork, err := ForkConversation(ctx, conn, "user-1", "client-1", "conv-1", msg.ID, 0)
+	if err != nil {
+		t.Fatalf("ForkConversation failed: %v", err)
+	}
+	original, _ := LoadToolExecutions(ctx, conn, "conv-1")
+	copied, err := LoadToolExecutions(ctx, conn, fork.ID)
+	if err != nil {
+		t.Fatalf("LoadToolExecutions failed: %v", err)
+	}
+	var copy *ToolExecution
+	for _, byCall := range copied {
+		copy = byCall["call-large"]
+	}
+	source := original.Get(msg.ID, "call-large")
+	if copy == nil || copy.ResultFilePath == "" || copy.ResultFilePath == source.ResultFilePath {
+		t.Fatalf("Expected the fork to get its own result file, got %+v", copy)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM tool_executions WHERE conversation_id = $1", fork.ID); n != 3 {
+		t.Errorf("Expected the fork to copy 3 executions, got %d", n)
+	}
+
+	if err := PurgeConversation(ctx, conn, fork.ID); err != nil {
+		t.Fatalf("PurgeConversation failed: %v", err)
+	}
+	if _, err := os.Stat(copy.ResultFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the fork's result file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(source.ResultFilePath); err != nil {
+		t.Errorf("Expected the original result file to be kept: %v", err)
+	}
+
+	if err := PurgeConversation(ctx, conn, "conv-1"); err != nil {
+		t.Fatalf("PurgeConversation failed: %v", err)
+	}
+	if _, err := os.Stat(source.ResultFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the original result file to be removed, got %v", err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM tool_executions"); n != 0 {
+		t.Errorf("Expected no executions to be left, got %d", n)
+	}
+}
+
+func TestWithLLMClientKeepsToolResultStorage(t *testing.T) {
+	service := NewChatService(setupRetentionDB(t), fakeHub{}, &fakeLLMClient{}, tools.NewToolRegistry())
+	storage := ToolResultStorage{Dir: t.TempDir(), MaxInlineBytes: 10}
+	service.SetToolResultStorage(storage)
+	service.SetMessageJSONMigration(true)
+
+	copy := service.WithLLMClient(&fakeLLMClient{}).(*chatService)
+	if copy.toolResults != storage || !copy.migrateMessageJSON {
+		t.Errorf("Expected the copy to keep the service's settings, got %+v and %t", copy.toolResults, copy.migrateMessageJSON)
+	}
+}
+
+// contextTool records the context it is executed with
+type contextTool struct {
+	echoTool
+	executed chan context.Context
+}
+
+func (contextTool) Name() string { return "capture_context" }
+func (c contextTool) Execute(ctx context.Context, params map[string]interface{}) (*tools.ToolResult, error) {
+	c.executed <- ctx
+	return &tools.ToolResult{Status: "completed"}, nil
+}
+
+func TestToolCallsRunWithTheRequestID(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	insertConversation(t, conn, "conv-1", nil)
+	tool := contextTool{executed: make(chan context.Context, 1)}
+	registry := tools.NewToolRegistry()
+	if err := registry.RegisterTool(tool); err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, &scriptedLLMClient{}, registry)
+
+	// The stream context derives from the request's, as streamReply's does
+	req := userMessageRequest("")
+	req.Context = requestid.With(context.Background(), "chat-1")
+	streamCtx, stop := service.headlessContext(req.Context, newStreamState("conv-1", "user-1", "project-1", ""))
+	defer stop()
+
+	msg := NewMessage("conv-1", "assistant", "Checking.", "", "project-1")
+	msg.ToolCalls = []ToolCall{*NewToolCall("call-1", "function", "capture_context", map[string]interface{}{"text": "hi"})}
+	if err := service.processToolCalls(streamCtx, req, msg); err != nil {
+		t.Fatalf("processToolCalls failed: %v", err)
+	}
+
+	ctx := <-tool.executed
+	if id := requestid.From(ctx); id != "chat-1" {
+		t.Errorf("Expected the tool to run with the request ID, got %q", id)
+	}
+	if execCtx, ok := tools.ExecutionContextFrom(ctx); !ok || execCtx.UserID != "user-1" || execCtx.ProjectID != "project-1" {
+		t.Errorf("Expected the execution context kept too, got %+v", execCtx)
+	}
+}
@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// maxToolRunContentChars caps the result a tool run adds to a conversation, so
+// one large result does not fill the model's context
+const maxToolRunContentChars = 16000
+
+// ErrToolRunSideEffects is returned when a tool that can modify data is run without force
+var ErrToolRunSideEffects = errors.New("tool can modify data")
+
+// ToolRunRequest is a tool a user runs themselves in a project, outside of
+// any reply
+type ToolRunRequest struct {
+	UserID         string
+	ClientID       string
+	ProjectID      string
+	ToolName       string
+	Params         map[string]interface{}
+	ConversationID string // Optional; the result is attached to it as a system message
+	Force          bool   // Run a tool that can modify data; editors and above only
+}
+
+// ToolRun is the outcome of a user-invoked tool run
+type ToolRun struct {
+	Tool           string                 `json:"tool"`
+	Params         map[string]interface{} `json:"params"` // With defaults filled in
+	Result         *tools.ToolResult      `json:"result"`
+	DurationMs     int                    `json:"duration_ms"`
+	ConversationID string                 `json:"conversation_id,omitempty"`
+	MessageID      string                 `json:"message_id,omitempty"` // The system message holding the result
+}
+
+// ToolRunErrorCode maps a RunTool error to its apierror code and details
+func ToolRunErrorCode(err error, toolName string) (string, map[string]interface{}) {
+	var paramErr *tools.ParameterError
+	switch {
+	case errors.As(err, &paramErr):
+		return apierror.CodeToolParametersInvalid, map[string]interface{}{
+			"tool": toolName, "parameter": paramErr.Parameter, "reason": paramErr.Reason,
+		}
+	case errors.Is(err, tools.ErrToolNotFound), errors.Is(err, tools.ErrToolDisabled):
+		return apierror.CodeToolUnavailable, map[string]interface{}{"tool": toolName}
+	case errors.Is(err, ErrToolRunSideEffects):
+		return apierror.CodeToolHasSideEffects, map[string]interface{}{"tool": toolName}
+	case errors.Is(err, tools.ErrToolAccessDenied):
+		return apierror.CodeForbidden, nil
+	case errors.Is(err, ErrConversationNotFound):
+		return apierror.CodeConversationNotFound, nil
+	default:
+		return apierror.CodeDatabaseError, nil
+	}
+}
+
+// RunTool validates the parameters against the tool's schema and runs the
+// tool in the project without a conversation. Tools that can modify data need
+// Force and the editor role. With a conversation the user takes part in, the
+// result is saved to it as a system message the model sees in later replies.
+func RunTool(ctx context.Context, db tools.DBConnection, registry tools.ToolRegistry, req ToolRunRequest) (*ToolRun, error) {
+	tool, exists := registry.GetTool(req.ToolName)
+	if !exists {
+		return nil, tools.ErrToolNotFound
+	}
+	if !registry.IsToolEnabled(req.ProjectID, req.ToolName) {
+		return nil, tools.ErrToolDisabled
+	}
+	params, err := tools.ValidateParameters(req.Params, tool.Parameters())
+	if err != nil {
+		return nil, err
+	}
+	if tools.HasSideEffects(tool, params) {
+		if !req.Force {
+			return nil, ErrToolRunSideEffects
+		}
+		role, err := tools.NewDBPermissionChecker(db).GetProjectRole(ctx, req.UserID, req.ProjectID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load project role: %w", err)
+		}
+		if role < tools.RoleEditor {
+			return nil, &tools.PermissionError{ToolName: req.ToolName, UserID: req.UserID, ProjectID: req.ProjectID}
+		}
+	}
+	if req.ConversationID != "" {
+		if err := checkToolRunConversation(ctx, db, req); err != nil {
+			return nil, err
+		}
+	}
+
+	startTime := time.Now()
+	result, err := registry.ExecuteTool(tools.WithExecutionSource(ctx, req.ClientID, tools.SourceUser), req.UserID, req.ProjectID, req.ToolName, params)
+	if err != nil {
+		return nil, err
+	}
+	run := &ToolRun{
+		Tool:       req.ToolName,
+		Params:     params,
+		Result:     result,
+		DurationMs: int(time.Since(startTime).Milliseconds()),
+	}
+	if result.TimeMs > 0 {
+		run.DurationMs = result.TimeMs
+	}
+
+	if req.ConversationID != "" {
+		msg := NewMessage(req.ConversationID, "system", toolRunContent(run), req.UserID, req.ProjectID)
+		msg.Metadata["tool_run"] = map[string]interface{}{
+			"tool":   run.Tool,
+			"status": result.Status,
+			"run_by": req.UserID,
+		}
+		metadataJSON, err := MarshalMetadata(msg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		toolCallsJSON, err := MarshalToolCalls(msg.ToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec(ctx,
+			`INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			msg.ID, msg.ConversationID, msg.Role, msg.Content, metadataJSON, toolCallsJSON, msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to attach tool run: %w", err)
+		}
+		run.ConversationID = req.ConversationID
+		run.MessageID = msg.ID
+	}
+	return run, nil
+}
+
+// checkToolRunConversation checks that the conversation is in the run's
+// project and that the user takes part in it
+func checkToolRunConversation(ctx context.Context, db tools.DBConnection, req ToolRunRequest) error {
+	var exists int
+	err := db.QueryRow(ctx,
+		`SELECT 1 FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.project_id = $3 AND c.deleted_at IS NULL AND `+isParticipantCondition(4),
+		req.ConversationID, req.ClientID, req.ProjectID, req.UserID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	return nil
+}
+
+// toolRunResultLabel separates the description of a tool run from its result
+const toolRunResultLabel = ". Result: "
+
+// toolRunContent describes a tool run for the model. A result over
+// maxToolRunContentChars is replaced by its digest, and cut when that is still
+// too long.
+func toolRunContent(run *ToolRun) string {
+	params, _ := json.Marshal(run.Params)
+	result, _ := json.Marshal(run.Result)
+	content := string(result)
+	if utf8.RuneCountInString(content) > maxToolRunContentChars {
+		if digested, ok := tools.DigestToolResult(result, tools.DefaultDigestSampleRows); ok {
+			content = string(digested)
+		}
+	}
+	if runes := []rune(content); len(runes) > maxToolRunContentChars {
+		content = string(runes[:maxToolRunContentChars]) + " ... (truncated)"
+	}
+	return fmt.Sprintf("The user ran the %s tool with parameters %s%s%s", run.Tool, params, toolRunResultLabel, content)
+}
+
+// splitToolRunContent splits a message a tool run added to a conversation into
+// the description of the run and its result. It reports false for other messages.
+func splitToolRunContent(msg *Message) (description, result string, ok bool) {
+	if msg.Role != "system" {
+		return "", "", false
+	}
+	if _, isRun := msg.Metadata["tool_run"]; !isRun {
+		return "", "", false
+	}
+	i := strings.Index(msg.Content, toolRunResultLabel)
+	if i < 0 {
+		return "", "", false
+	}
+	i += len(toolRunResultLabel)
+	return msg.Content[:i], msg.Content[i:], true
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"zlay-backend/internal/tools"
+)
+
+// writeTool counts its runs; it modifies data when write is true
+type writeTool struct {
+	runs int
+}
+
+func (t *writeTool) Name() string        { return "write_rows" }
+func (t *writeTool) Description() string { return "Writes rows" }
+func (t *writeTool) GetCategory() string { return "database" }
+func (t *writeTool) Parameters() map[string]tools.ToolParameter {
+	return map[string]tools.ToolParameter{"write": {Type: "boolean", Default: false}}
+}
+func (t *writeTool) ValidateAccess(userID, projectID string) bool { return true }
+func (t *writeTool) HasSideEffects(params map[string]interface{}) bool {
+	write, _ := params["write"].(bool)
+	return write
+}
+func (t *writeTool) Execute(ctx context.Context, params map[string]interface{}) (*tools.ToolResult, error) {
+	t.runs++
+	return tools.NewToolSuccess(map[string]interface{}{"rows": 3}, 1), nil
+}
+
+func TestRunTool(t *testing.T) {
+	conn := setupParticipantsDB(t)
+	ctx := context.Background()
+	conversation, err := InsertConversation(ctx, conn, "user-1", "project-1", "Shared", ModelSettings{})
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+	if _, err := AddParticipant(ctx, conn, "user-1", "client-1", conversation.ID, "user-2"); err != nil {
+		t.Fatalf("AddParticipant failed: %v", err)
+	}
+	other, err := InsertConversation(ctx, conn, "user-2", "project-2", "Elsewhere", ModelSettings{})
+	if err != nil {
+		t.Fatalf("InsertConversation failed: %v", err)
+	}
+
+	tool := &writeTool{}
+	registry := tools.NewDefaultToolRegistry()
+	if err := registry.RegisterTool(tool); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  ToolRunRequest
+		want error
+	}{
+		{"read", ToolRunRequest{UserID: "user-2"}, nil},
+		{"write without force", ToolRunRequest{UserID: "user-1", Params: map[string]interface{}{"write": true}}, ErrToolRunSideEffects},
+		{"forced write by a non-editor", ToolRunRequest{UserID: "user-2", Params: map[string]interface{}{"write": true}, Force: true}, tools.ErrToolAccessDenied},
+		{"forced write by the owner", ToolRunRequest{UserID: "user-1", Params: map[string]interface{}{"write": true}, Force: true}, nil},
+		{"invalid parameters", ToolRunRequest{UserID: "user-1", Params: map[string]interface{}{"write": "yes"}}, tools.ErrInvalidParameters},
+		{"conversation of another project", ToolRunRequest{UserID: "user-2", ConversationID: other.ID}, ErrConversationNotFound},
+		{"conversation of another client", ToolRunRequest{UserID: "user-3", ClientID: "client-2", ConversationID: conversation.ID}, ErrConversationNotFound},
+	}
+	for _, tt := range tests {
+		tt.req.ToolName, tt.req.ProjectID = "write_rows", "project-1"
+		if tt.req.ClientID == "" {
+			tt.req.ClientID = "client-1"
+		}
+		if _, err := RunTool(ctx, conn, registry, tt.req); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if tool.runs != 2 {
+		t.Errorf("Expected only the allowed runs to execute, got %d", tool.runs)
+	}
+
+	// A participant attaches the result to the shared conversation
+	run, err := RunTool(ctx, conn, registry, ToolRunRequest{
+		UserID: "user-2", ClientID: "client-1", ProjectID: "project-1", ToolName: "write_rows", ConversationID: conversation.ID,
+	})
+	if err != nil {
+		t.Fatalf("RunTool failed: %v", err)
+	}
+	service := NewChatService(conn, &recordingHub{connections: map[string]bool{}}, &scriptedLLMClient{}, tools.NewToolRegistry())
+	details, err := service.GetConversation(conversation.ID, "user-1")
+	if err != nil {
+		t.Fatalf("GetConversation failed: %v", err)
+	}
+	if len(details.Messages) != 1 || details.Messages[0].ID != run.MessageID || details.Messages[0].Role != "system" ||
+		details.Messages[0].Metadata["tool_run"] == nil {
+		t.Errorf("Expected the run as a system message, got %+v", details.Messages)
+	}
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"zlay-backend/internal/tools"
+)
+
+// VersionConflictError is returned when a conversation changed after the
+// version an edit was based on. Current is the conversation as it is now.
+type VersionConflictError struct {
+	Current *Conversation
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("conversation %s is at version %d", e.Current.ID, e.Current.Version)
+}
+
+// conversationColumns lists the columns scanConversation reads, each prefixed
+// with the table alias prefix
+func conversationColumns(prefix string) string {
+	columns := []string{"id", "project_id", "user_id", "title", "status", "pinned", "pinned_at", "language", "drafts_enabled", "created_at", "updated_at", "version"}
+	for i := range columns {
+		columns[i] = prefix + columns[i]
+	}
+	return strings.Join(columns, ", ")
+}
+
+// updateConversation sets one column of a non-deleted conversation the user
+// takes part in within their client, incrementing its version, and returns the
+// updated conversation. allow, when set, may refuse the change by returning an
+// error. A version other than 0 is the one the change was based on; when the
+// conversation has moved past it a *VersionConflictError is returned.
+func updateConversation(ctx context.Context, db tools.DBConnection, userID, clientID, conversationID, column string, value interface{}, version int64, allow func(*Conversation) error) (*Conversation, error) {
+	current, err := scanConversation(db.QueryRow(ctx,
+		`SELECT `+conversationColumns("c.")+`
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND u.client_id = $2 AND c.deleted_at IS NULL AND `+isParticipantCondition(3),
+		conversationID, clientID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	if allow != nil {
+		if err := allow(current); err != nil {
+			return nil, err
+		}
+	}
+	if version == 0 {
+		version = current.Version
+	}
+
+	result, err := db.Exec(ctx,
+		"UPDATE conversations SET "+column+" = $1, version = version + 1 WHERE id = $2 AND version = $3",
+		value, conversationID, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation %s: %w", column, err)
+	}
+
+	conv, err := scanConversation(db.QueryRow(ctx,
+		"SELECT "+conversationColumns("")+" FROM conversations WHERE id = $1", conversationID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, &VersionConflictError{Current: conv}
+	}
+	return conv, nil
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv func backed by a map
+func env(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := LoadFrom(env(nil))
+	if err != nil {
+		t.Fatalf("Expected an empty environment to load, got %v", err)
+	}
+
+	defaults := Default()
+	if cfg.Port != "8080" || cfg.WSPort != "6070" || !cfg.WSStandalone || cfg.Release {
+		t.Errorf("Unexpected listener defaults: %+v", cfg)
+	}
+	if cfg.DatabaseURL != DefaultDatabaseURL {
+		t.Errorf("Expected the development database outside release mode, got %q", cfg.DatabaseURL)
+	}
+	if cfg.SessionTTL != 24*time.Hour || cfg.ImpersonationTTL != time.Hour {
+		t.Errorf("Unexpected session TTLs: %s, %s", cfg.SessionTTL, cfg.ImpersonationTTL)
+	}
+	if cfg.ConversationRetention != 30*24*time.Hour || cfg.StreamFlushChars != 200 || cfg.StreamFlushInterval != 250*time.Millisecond || cfg.StreamRetention != 30*time.Second ||
+		cfg.StreamHeadlessGrace != 2*time.Minute {
+		t.Errorf("Unexpected chat defaults: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg, defaults) {
+		t.Errorf("Expected LoadFrom with no environment to equal Default()")
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	cfg, err := LoadFrom(env(map[string]string{
+		"GIN_MODE":                    "release",
+		"DATABASE_URL":                "postgresql://db/zlay",
+		"WS_STANDALONE":               "false",
+		"SESSION_TTL":                 "8h",
+		"DB_QUERY_TIMEOUT_MS":         "2500",
+		"DB_SLOW_QUERY_MS":            "1s",
+		"CONVERSATION_RETENTION_DAYS": "7",
+		"STREAM_FLUSH_CHARS":          "10",
+		"STREAM_FLUSH_INTERVAL_MS":    "100",
+		"STREAM_HEADLESS_GRACE":       "30s",
+		"TOOL_API_MAX_CONCURRENT":     "2",
+		"FILES_MAX_UPLOAD_BYTES":      "2048",
+		"COOKIE_SECURE":               "true",
+		"TRUSTED_PROXIES":             "10.0.0.0/8, 192.168.1.5,",
+	}))
+	if err != nil {
+		t.Fatalf("Expected the environment to load, got %v", err)
+	}
+
+	if !cfg.Release || cfg.DatabaseURL != "postgresql://db/zlay" || cfg.WSStandalone {
+		t.Errorf("Unexpected overrides: %+v", cfg)
+	}
+	if cfg.SessionTTL != 8*time.Hour {
+		t.Errorf("Expected SESSION_TTL=8h, got %s", cfg.SessionTTL)
+	}
+	// Unit-named variables take a count of units or a Go duration
+	if cfg.DBQueryTimeout != 2500*time.Millisecond || cfg.DBSlowQueryThreshold != time.Second {
+		t.Errorf("Unexpected query guard durations: %s, %s", cfg.DBQueryTimeout, cfg.DBSlowQueryThreshold)
+	}
+	if cfg.ConversationRetention != 7*24*time.Hour {
+		t.Errorf("Expected 7 days of retention, got %s", cfg.ConversationRetention)
+	}
+	if cfg.StreamFlushInterval != 100*time.Millisecond {
+		t.Errorf("Expected a 100ms flush interval, got %s", cfg.StreamFlushInterval)
+	}
+	if cfg.StreamHeadlessGrace != 30*time.Second {
+		t.Errorf("Expected a 30s headless grace, got %s", cfg.StreamHeadlessGrace)
+	}
+	if cfg.StreamFlushChars != 10 || cfg.ToolAPIMaxConcurrent != 2 || cfg.MaxUploadBytes != 2048 || !cfg.CookieSecure {
+		t.Errorf("Unexpected numeric overrides: %+v", cfg)
+	}
+	if strings.Join(cfg.TrustedProxies, " ") != "10.0.0.0/8 192.168.1.5" {
+		t.Errorf("Unexpected trusted proxies %q", cfg.TrustedProxies)
+	}
+}
+
+func TestLoadParseErrors(t *testing.T) {
+	_, err := LoadFrom(env(map[string]string{
+		"SESSION_TTL":                     "a day",
+		"DB_QUERY_TIMEOUT_MS":             "soon",
+		"WS_STANDALONE":                   "maybe",
+		"WEBHOOK_WORKERS":                 "four",
+		"PORT":                            "http",
+		"STREAM_FLUSH_CHARS":              "0",
+		"LLM_REQUEST_TIMEOUT":             "-5s",
+		"WIDGET_CLEANUP_INTERVAL_MINUTES": "-1",
+		"SCHEMA_SNAPSHOT_MAX_CONCURRENT":  "0",
+		"TRUSTED_PROXIES":                 "10.0.0.1, proxy.internal",
+		"SMTP_HOST":                       "mail.example.com",
+		"SMTP_TLS":                        "ssl",
+		"CONVERSATION_SEARCH":             "true",
+		"EMBEDDING_MODEL":                 " ",
+	}))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		`SESSION_TTL: invalid duration "a day"`,
+		`DB_QUERY_TIMEOUT_MS: invalid duration "soon"`,
+		`WS_STANDALONE: invalid boolean "maybe"`,
+		`WEBHOOK_WORKERS: invalid integer "four"`,
+		`PORT: invalid port "http"`,
+		`STREAM_FLUSH_CHARS: must be at least 1, got 0`,
+		`LLM_REQUEST_TIMEOUT: must be positive, got -5s`,
+		`WIDGET_CLEANUP_INTERVAL_MINUTES: must not be negative, got -1m0s`,
+		`SCHEMA_SNAPSHOT_MAX_CONCURRENT: must be at least 1, got 0`,
+		`TRUSTED_PROXIES: invalid proxy address "proxy.internal"`,
+		`SMTP_FROM: is required when SMTP_HOST is set`,
+		`SMTP_TLS: must be starttls, tls or none, got "ssl"`,
+		`EMBEDDING_MODEL: is required when CONVERSATION_SEARCH is enabled`,
+	}
+	if len(validationErr.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %q", len(expected), validationErr.Problems)
+	}
+	for _, problem := range expected {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %q", problem, err.Error())
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "invalid configuration: ") {
+		t.Errorf("Unexpected error format: %q", err.Error())
+	}
+}
+
+func TestLoadRequiresDatabaseURLInRelease(t *testing.T) {
+	_, err := LoadFrom(env(map[string]string{"GIN_MODE": "release"}))
+	if err == nil || err.Error() != "invalid configuration: DATABASE_URL: is required in release mode" {
+		t.Errorf("Expected the missing DATABASE_URL to fail, got %v", err)
+	}
+
+	if _, err := LoadFrom(env(map[string]string{"GIN_MODE": "debug"})); err != nil {
+		t.Errorf("Expected the default database outside release mode, got %v", err)
+	}
+}
+
+func TestLoadDatabaseType(t *testing.T) {
+	cfg, err := LoadFrom(env(map[string]string{"DB_TYPE": "SQLite"}))
+	if err != nil || cfg.DatabaseType != "sqlite" || cfg.DatabaseURL != DefaultSQLitePath {
+		t.Errorf("Expected SQLite to default to a local file, got %+v (%v)", cfg, err)
+	}
+
+	_, err = LoadFrom(env(map[string]string{"DB_TYPE": "mysql"}))
+	if err == nil || !strings.Contains(err.Error(), "DATABASE_URL: is required when DB_TYPE is mysql") {
+		t.Errorf("Expected MySQL without DATABASE_URL to fail, got %v", err)
+	}
+
+	_, err = LoadFrom(env(map[string]string{"DB_TYPE": "oracle"}))
+	if err == nil || !strings.Contains(err.Error(), `DB_TYPE: must be postgresql, mysql or sqlite, got "oracle"`) {
+		t.Errorf("Expected an unsupported database to fail, got %v", err)
+	}
+}
+
+func TestPublicRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.WidgetTokenSecret = "widget-secret"
+
+	public := cfg.Public()
+	for _, secret := range []string{"database_url", "openai_api_key", "widget_token_secret", "export_signing_secret"} {
+		if _, exists := public[secret]; exists {
+			t.Errorf("Expected %s to be redacted", secret)
+		}
+	}
+	if public["widget_token_secret_set"] != true || public["export_signing_secret_set"] != false {
+		t.Errorf("Expected secrets reported as set or unset, got %v", public)
+	}
+	if public["session_ttl"] != "24h0m0s" || public["port"] != "8080" || public["stream_flush_chars"] != 200 {
+		t.Errorf("Unexpected public values: %v", public)
+	}
+}
@@ -0,0 +1,262 @@
+// Package contentfilter redacts text from assistant replies before it is
+// streamed to clients or saved. Each client configures an ordered list of
+// rules: regular expressions with a replacement, and the built-in credit card
+// and email detectors. See Stream for how replies are filtered chunk by chunk.
+package contentfilter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// Rule kinds
+const (
+	KindRegex      = "regex"
+	KindCreditCard = "credit_card"
+	KindEmail      = "email"
+)
+
+// DefaultReplacement is inserted for each match when a rule does not set its own
+const DefaultReplacement = "[REDACTED]"
+
+// Field limits
+const (
+	MaxNameLength        = 255
+	MaxReplacementLength = 255
+)
+
+var (
+	// ErrRuleNotFound is returned for rules missing from the client
+	ErrRuleNotFound = errors.New("content filter not found")
+)
+
+// Rule is a redaction rule of a client
+type Rule struct {
+	ID          string    `json:"id"`
+	ClientID    string    `json:"client_id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	Pattern     string    `json:"pattern,omitempty"` // Regex rules only
+	Replacement string    `json:"replacement"`
+	Enabled     bool      `json:"enabled"`
+	Position    int       `json:"position"` // Rules run in ascending position
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Input is the editable part of a rule; nil fields take their defaults
+type Input struct {
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"`
+	Pattern     string  `json:"pattern"`
+	Replacement *string `json:"replacement"`
+	Enabled     *bool   `json:"enabled"`
+	Position    *int    `json:"position"`
+}
+
+// ValidationError reports an input field that is missing or invalid
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Normalize trims the input, fills in the defaults and checks its fields,
+// including that a regex pattern is safe to run on every reply
+func (in *Input) Normalize() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Kind = strings.TrimSpace(in.Kind)
+	if in.Kind == "" {
+		in.Kind = KindRegex
+	}
+	if in.Replacement == nil {
+		replacement := DefaultReplacement
+		in.Replacement = &replacement
+	}
+	if in.Enabled == nil {
+		enabled := true
+		in.Enabled = &enabled
+	}
+	if in.Position == nil {
+		position := 0
+		in.Position = &position
+	}
+
+	switch {
+	case in.Name == "":
+		return &ValidationError{Field: "name", Reason: "is required"}
+	case utf8.RuneCountInString(in.Name) > MaxNameLength:
+		return &ValidationError{Field: "name", Reason: fmt.Sprintf("must be at most %d characters", MaxNameLength)}
+	case in.Kind != KindRegex && in.Kind != KindCreditCard && in.Kind != KindEmail:
+		return &ValidationError{Field: "kind", Reason: fmt.Sprintf("must be %s, %s or %s", KindRegex, KindCreditCard, KindEmail)}
+	case utf8.RuneCountInString(*in.Replacement) > MaxReplacementLength:
+		return &ValidationError{Field: "replacement", Reason: fmt.Sprintf("must be at most %d characters", MaxReplacementLength)}
+	case *in.Position < 0:
+		return &ValidationError{Field: "position", Reason: "must not be negative"}
+	}
+
+	if in.Kind != KindRegex {
+		if in.Pattern != "" {
+			return &ValidationError{Field: "pattern", Reason: "must be empty for built-in detectors"}
+		}
+		return nil
+	}
+	if in.Pattern == "" {
+		return &ValidationError{Field: "pattern", Reason: "is required"}
+	}
+	if err := ValidatePattern(in.Pattern); err != nil {
+		return &ValidationError{Field: "pattern", Reason: err.Error()}
+	}
+	return nil
+}
+
+const selectRule = `SELECT id, client_id, name, kind, pattern, replacement, enabled, position, created_at, updated_at
+	FROM content_filters`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRule(row scanner) (*Rule, error) {
+	var r Rule
+	var pattern sql.NullString
+	if err := row.Scan(&r.ID, &r.ClientID, &r.Name, &r.Kind, &pattern, &r.Replacement, &r.Enabled, &r.Position, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.Pattern = pattern.String
+	return &r, nil
+}
+
+// List returns the rules of a client in the order they run
+func List(ctx context.Context, db tools.DBConnection, clientID string) ([]Rule, error) {
+	rows, err := db.Query(ctx, selectRule+" WHERE client_id = $1 ORDER BY position, created_at, id", clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list content filters: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan content filter: %w", err)
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// Get returns a rule of a client
+func Get(ctx context.Context, db tools.DBConnection, clientID, ruleID string) (*Rule, error) {
+	if _, err := uuid.Parse(ruleID); err != nil {
+		return nil, ErrRuleNotFound
+	}
+	r, err := scanRule(db.QueryRow(ctx, selectRule+" WHERE id = $1 AND client_id = $2", ruleID, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load content filter: %w", err)
+	}
+	return r, nil
+}
+
+// Create saves a new rule; the input must already be normalized
+func Create(ctx context.Context, db tools.DBConnection, clientID string, in Input) (*Rule, error) {
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	_, err := db.Exec(ctx,
+		`INSERT INTO content_filters (id, client_id, name, kind, pattern, replacement, enabled, position, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)`,
+		id, clientID, in.Name, in.Kind, nullablePattern(in.Pattern), *in.Replacement, *in.Enabled, *in.Position, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content filter: %w", err)
+	}
+	return Get(ctx, db, clientID, id)
+}
+
+// Update replaces a rule; the input must already be normalized
+func Update(ctx context.Context, db tools.DBConnection, clientID, ruleID string, in Input) (*Rule, error) {
+	if _, err := Get(ctx, db, clientID, ruleID); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(ctx,
+		`UPDATE content_filters SET name = $1, kind = $2, pattern = $3, replacement = $4, enabled = $5, position = $6, updated_at = $7
+		WHERE id = $8 AND client_id = $9`,
+		in.Name, in.Kind, nullablePattern(in.Pattern), *in.Replacement, *in.Enabled, *in.Position, time.Now().UTC(), ruleID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update content filter: %w", err)
+	}
+	return Get(ctx, db, clientID, ruleID)
+}
+
+// Delete removes a rule of a client
+func Delete(ctx context.Context, db tools.DBConnection, clientID, ruleID string) error {
+	if _, err := uuid.Parse(ruleID); err != nil {
+		return ErrRuleNotFound
+	}
+	result, err := db.Exec(ctx, "DELETE FROM content_filters WHERE id = $1 AND client_id = $2", ruleID, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete content filter: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// Load builds the pipeline of a client's enabled rules. A stored pattern that
+// no longer compiles is skipped rather than failing every reply.
+func Load(ctx context.Context, db tools.DBConnection, clientID string) (*Pipeline, error) {
+	rules, err := List(ctx, db, clientID)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := &Pipeline{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		f, err := compile(rule)
+		if err != nil {
+			log.Printf("Skipping content filter %s of client %s: %v", rule.ID, clientID, err)
+			continue
+		}
+		pipeline.filters = append(pipeline.filters, f)
+	}
+	return pipeline, nil
+}
+
+func nullablePattern(pattern string) interface{} {
+	if pattern == "" {
+		return nil
+	}
+	return pattern
+}
+
+// ErrorCode maps an error of this package to an API error code and its details
+func ErrorCode(err error) (string, map[string]interface{}) {
+	var invalid *ValidationError
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		return apierror.CodeContentFilterNotFound, nil
+	case errors.As(err, &invalid):
+		return apierror.CodeContentFilterInvalid, map[string]interface{}{"field": invalid.Field, "reason": invalid.Reason}
+	default:
+		return apierror.CodeDatabaseError, nil
+	}
+}
@@ -0,0 +1,271 @@
+package contentfilter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func testPipeline(t *testing.T, rules ...Rule) *Pipeline {
+	t.Helper()
+	for i := range rules {
+		rules[i].Enabled = true
+		if rules[i].Replacement == "" {
+			rules[i].Replacement = DefaultReplacement
+		}
+	}
+	pipeline, err := NewPipeline(rules)
+	if err != nil {
+		t.Fatalf("NewPipeline failed: %v", err)
+	}
+	return pipeline
+}
+
+// streamChunks filters chunks one at a time and returns every piece written
+func streamChunks(pipeline *Pipeline, chunks []string) ([]string, *Stream) {
+	stream := pipeline.NewStream()
+	var pieces []string
+	for _, chunk := range chunks {
+		pieces = append(pieces, stream.Write(chunk))
+	}
+	return append(pieces, stream.Flush()), stream
+}
+
+// splitAt returns text cut into two chunks at every byte offset, and into
+// one chunk per character
+func splitAt(text string) [][]string {
+	var splits [][]string
+	for i := 0; i <= len(text); i++ {
+		if utf8.RuneStart(text[i%len(text)]) || i == len(text) {
+			splits = append(splits, []string{text[:i], text[i:]})
+		}
+	}
+	var perChar []string
+	for _, r := range text {
+		perChar = append(perChar, string(r))
+	}
+	return append(splits, perChar)
+}
+
+func TestStreamRedactsMatchesSplitAcrossChunks(t *testing.T) {
+	pipeline := testPipeline(t,
+		Rule{ID: "codes", Kind: KindRegex, Pattern: `ACCT-\d{6}`, Replacement: "[ACCOUNT]"},
+		Rule{ID: "cards", Kind: KindCreditCard, Replacement: "[CARD]"},
+		Rule{ID: "emails", Kind: KindEmail, Replacement: "[EMAIL]"},
+	)
+	text := "Your account ACCT-123456 is billed to 4111 1111 1111 1111; questions go to jane.doe@example.co.uk, thanks – ünïcödé."
+	want := "Your account [ACCOUNT] is billed to [CARD]; questions go to [EMAIL], thanks – ünïcödé."
+
+	for _, chunks := range splitAt(text) {
+		pieces, stream := streamChunks(pipeline, chunks)
+		if got := strings.Join(pieces, ""); got != want {
+			t.Fatalf("Split %q: expected %q, got %q", chunks, want, got)
+		}
+		for _, piece := range pieces {
+			for _, secret := range []string{"123456", "1111", "jane.doe", "example.co"} {
+				if strings.Contains(piece, secret) {
+					t.Fatalf("Split %q: piece %q leaks %q", chunks, piece, secret)
+				}
+			}
+		}
+		if counts := stream.Redactions(); counts["codes"] != 1 || counts["cards"] != 1 || counts["emails"] != 1 || stream.Total() != 3 {
+			t.Fatalf("Split %q: expected one redaction per rule, got %v", chunks, counts)
+		}
+	}
+}
+
+func TestStreamDoesNotRedactWhatTheNextChunkRulesOut(t *testing.T) {
+	pipeline := testPipeline(t, Rule{ID: "word", Kind: KindRegex, Pattern: `\bdarn\b`})
+
+	pieces, _ := streamChunks(pipeline, []string{"well darn", "ed fine", " and darn."})
+	if got := strings.Join(pieces, ""); got != "well darned fine and [REDACTED]." {
+		t.Errorf("Expected only the whole word to be redacted, got %q", got)
+	}
+}
+
+func TestStreamReleasesTextBeyondTheHoldback(t *testing.T) {
+	pipeline := testPipeline(t,
+		Rule{ID: "codes", Kind: KindRegex, Pattern: `ACCT-\d{6}`},
+		Rule{ID: "emails", Kind: KindEmail},
+	)
+	stream := pipeline.NewStream()
+
+	if got := stream.Write("Hi"); got != "" {
+		t.Errorf("Expected a short start to be held back, got %q", got)
+	}
+	long := strings.Repeat("plain words ", 30)
+	got := stream.Write(long)
+	if got == "" || !strings.HasPrefix("Hi"+long, got) {
+		t.Errorf("Expected the start of the text to be released, got %q", got)
+	}
+	if held := len("Hi"+long) - len(got); held > 2*MaxHoldbackChars {
+		t.Errorf("Expected at most %d characters held back, got %d", 2*MaxHoldbackChars, held)
+	}
+	if rest := stream.Flush(); got+rest != "Hi"+long {
+		t.Errorf("Expected the flush to release the rest, got %q", got+rest)
+	}
+}
+
+func TestRulesRunInOrderOnEachOthersOutput(t *testing.T) {
+	pipeline := testPipeline(t,
+		Rule{ID: "names", Kind: KindRegex, Pattern: `Alice|Bob`, Replacement: "NAME"},
+		Rule{ID: "pairs", Kind: KindRegex, Pattern: `NAME and NAME`, Replacement: "[PEOPLE]"},
+	)
+
+	filtered, counts := pipeline.Apply("Alice and Bob met.")
+	if filtered != "[PEOPLE] met." || counts["names"] != 2 || counts["pairs"] != 1 {
+		t.Errorf("Expected the second rule to see the first one's output, got %q %v", filtered, counts)
+	}
+}
+
+func TestCreditCardDetectorChecksLuhn(t *testing.T) {
+	pipeline := testPipeline(t, Rule{ID: "cards", Kind: KindCreditCard, Replacement: "[CARD]"})
+
+	tests := map[string]string{
+		"card 4111-1111-1111-1111 ok":            "card [CARD] ok",
+		"card 5500005555555559 ok":               "card [CARD] ok",
+		"order 1234 5678 9012 3456 ok":           "order 1234 5678 9012 3456 ok",
+		"phone 555 0100 ok":                      "phone 555 0100 ok",
+		"two 4111111111111111, 4012888888881881": "two [CARD], [CARD]",
+	}
+	for input, want := range tests {
+		if got, _ := pipeline.Apply(input); got != want {
+			t.Errorf("%q: expected %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestEmptyPipelinePassesChunksThrough(t *testing.T) {
+	var pipeline *Pipeline
+	stream := pipeline.NewStream()
+	if got := stream.Write("a"); got != "a" || stream.Flush() != "" || stream.Total() != 0 || !pipeline.Empty() {
+		t.Errorf("Expected a nil pipeline to change nothing, got %q", got)
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, pattern := range []string{`ACCT-\d{6}`, `(?i)\bconfidential\b`, `\d{3}-\d{2}-\d{4}`, `[A-Z]{2}\d{2}[A-Z0-9]{10,30}`} {
+		if err := ValidatePattern(pattern); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", pattern, err)
+		}
+	}
+
+	tests := map[string]string{
+		`(`:                                     "does not compile",
+		`(a+)+$`:                                "too complex",
+		`(\w*\s?)*x`:                            "too complex",
+		`(?:x{2,}y){3}`:                         "too complex",
+		`[a-z]{900}[0-9]{900}[A-Z]{900}`:        "too complex",
+		`a*`:                                    "empty text",
+		`x?`:                                    "empty text",
+		strings.Repeat("a", MaxPatternLength+1): "at most",
+	}
+	for pattern, reason := range tests {
+		err := ValidatePattern(pattern)
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("Expected %.40q to be refused as %q, got %v", pattern, reason, err)
+		}
+	}
+}
+
+func TestInputNormalize(t *testing.T) {
+	in := Input{Name: " Emails ", Kind: KindEmail}
+	if err := in.Normalize(); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if in.Name != "Emails" || *in.Replacement != DefaultReplacement || !*in.Enabled || *in.Position != 0 {
+		t.Errorf("Expected defaults to be filled in, got %+v", in)
+	}
+
+	tests := map[string]Input{
+		"name":    {Kind: KindEmail},
+		"kind":    {Name: "x", Kind: "phone"},
+		"pattern": {Name: "x", Kind: KindRegex},
+	}
+	for field, in := range tests {
+		var invalid *ValidationError
+		if err := in.Normalize(); !errors.As(err, &invalid) || invalid.Field != field {
+			t.Errorf("Expected %s to be refused, got %v", field, err)
+		}
+	}
+	builtin := Input{Name: "x", Kind: KindCreditCard, Pattern: `\d+`}
+	if err := builtin.Normalize(); err == nil {
+		t.Error("Expected a pattern on a built-in detector to be refused")
+	}
+	slow := Input{Name: "x", Pattern: `(a+|b)+`}
+	var invalid *ValidationError
+	if err := slow.Normalize(); !errors.As(err, &invalid) || invalid.Field != "pattern" {
+		t.Errorf("Expected the pattern to be refused, got %v", err)
+	}
+}
+
+func TestStoreAndLoad(t *testing.T) {
+	zdb := dbtest.Open(t)
+	ctx := context.Background()
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	for _, id := range []string{"client-a", "client-b"} {
+		if _, err := conn.Exec(ctx, "INSERT INTO clients (id, name, slug) VALUES ($1, $1, $1)", id); err != nil {
+			t.Fatalf("Failed to insert client: %v", err)
+		}
+	}
+
+	create := func(in Input) *Rule {
+		t.Helper()
+		if err := in.Normalize(); err != nil {
+			t.Fatalf("Normalize failed: %v", err)
+		}
+		rule, err := Create(ctx, conn, "client-a", in)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		return rule
+	}
+	second := 2
+	disabled := false
+	codes := create(Input{Name: "Codes", Pattern: `ACCT-\d{6}`, Position: &second})
+	emails := create(Input{Name: "Emails", Kind: KindEmail})
+	cards := create(Input{Name: "Cards", Kind: KindCreditCard, Enabled: &disabled})
+
+	rules, err := List(ctx, conn, "client-a")
+	if err != nil || len(rules) != 3 || rules[0].ID != emails.ID || rules[2].ID != codes.ID {
+		t.Fatalf("Expected the rules in position order, got %+v (%v)", rules, err)
+	}
+	if rules, _ := List(ctx, conn, "client-b"); len(rules) != 0 {
+		t.Errorf("Expected another client to have no rules, got %+v", rules)
+	}
+	if _, err := Get(ctx, conn, "client-b", codes.ID); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Expected another client's rule to be hidden, got %v", err)
+	}
+
+	pipeline, err := Load(ctx, conn, "client-a")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	filtered, _ := pipeline.Apply("ACCT-123456 jane@example.com 4111111111111111")
+	if filtered != "[REDACTED] [REDACTED] 4111111111111111" {
+		t.Errorf("Expected the enabled rules to run, got %q", filtered)
+	}
+
+	update := Input{Name: "Cards", Kind: KindCreditCard}
+	update.Normalize()
+	if rule, err := Update(ctx, conn, "client-a", cards.ID, update); err != nil || !rule.Enabled {
+		t.Errorf("Expected the rule to be enabled, got %+v (%v)", rule, err)
+	}
+	if _, err := Update(ctx, conn, "client-b", cards.ID, update); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Expected another client's update to be refused, got %v", err)
+	}
+	if err := Delete(ctx, conn, "client-b", codes.ID); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Expected another client's delete to be refused, got %v", err)
+	}
+	if err := Delete(ctx, conn, "client-a", codes.ID); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if rules, _ := List(ctx, conn, "client-a"); len(rules) != 2 {
+		t.Errorf("Expected two rules left, got %d", len(rules))
+	}
+}
@@ -0,0 +1,144 @@
+package contentfilter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxPatternLength is the longest regex a rule may have
+	MaxPatternLength = 1000
+	// maxProgramSize caps the compiled size of a pattern; counted repetitions
+	// such as (a{50}){20} expand far beyond their source length
+	maxProgramSize = 2000
+	// patternCheckTimeout is how long a pattern may take to scan patternProbe
+	patternCheckTimeout = 100 * time.Millisecond
+)
+
+// patternProbe is text a pattern is run against before it is saved: long
+// runs of the characters patterns usually repeat, without a final match
+var patternProbe = strings.Repeat("a", 8192) + strings.Repeat("1 ", 4096) + strings.Repeat("ab@x.", 2048) + "!"
+
+// ValidatePattern checks that a regex compiles and is cheap enough to run on
+// every chunk of every reply: Go's engine never backtracks, but a pattern
+// with stacked repetitions or a huge compiled program still costs time per
+// character, and one that matches empty text would redact between every one.
+func ValidatePattern(pattern string) error {
+	if utf8.RuneCountInString(pattern) > MaxPatternLength {
+		return fmt.Errorf("must be at most %d characters", MaxPatternLength)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		var syntaxErr *syntax.Error
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("does not compile: %s", syntaxErr.Code)
+		}
+		return fmt.Errorf("does not compile: %v", err)
+	}
+	if nestedRepetition(parsed, false) {
+		return errors.New("is too complex: it repeats a group that itself repeats")
+	}
+	program, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return fmt.Errorf("does not compile: %v", err)
+	}
+	if len(program.Inst) > maxProgramSize {
+		return errors.New("is too complex: its repetitions expand too far")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("does not compile: %v", err)
+	}
+	if re.MatchString("") {
+		return errors.New("must not match empty text")
+	}
+	done := make(chan struct{})
+	go func() {
+		re.FindAllStringIndex(patternProbe, -1)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(patternCheckTimeout):
+		return errors.New("is too slow to run on replies")
+	}
+}
+
+// nestedRepetition reports whether a repetition appears inside another, as in
+// (a+)+ or (\w*\s?)*
+func nestedRepetition(re *syntax.Regexp, inRepeat bool) bool {
+	repeats := isRepetition(re)
+	if repeats && inRepeat {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if nestedRepetition(sub, inRepeat || repeats) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepetition reports whether re repeats its operand more than once
+func isRepetition(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		return true
+	case syntax.OpRepeat:
+		return re.Max == -1 || re.Max > 1
+	}
+	return false
+}
+
+// maxMatchChars returns the most characters a match of re can span, or -1
+// when its repetitions are unbounded
+func maxMatchChars(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchChars(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return -1
+	case syntax.OpRepeat:
+		sub := maxMatchChars(re.Sub[0])
+		if re.Max == -1 || sub == -1 {
+			return -1
+		}
+		return sub * re.Max
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			n := maxMatchChars(sub)
+			if n == -1 {
+				return -1
+			}
+			total += n
+		}
+		return total
+	case syntax.OpAlternate:
+		longest := 0
+		for _, sub := range re.Sub {
+			n := maxMatchChars(sub)
+			if n == -1 {
+				return -1
+			}
+			if n > longest {
+				longest = n
+			}
+		}
+		return longest
+	default:
+		// Empty matches, anchors and word boundaries consume nothing
+		return 0
+	}
+}
@@ -0,0 +1,225 @@
+package contentfilter
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxHoldbackChars is the most characters a filter keeps back while a reply
+// streams. A rule whose matches can be longer, like one with * or +, only
+// catches the matches that fit within it.
+const MaxHoldbackChars = 128
+
+// Built-in detectors. Card numbers are 13 to 19 digits, optionally grouped
+// with spaces or dashes, and must pass the Luhn check.
+var (
+	creditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	emailPattern      = regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9\-]+(?:\.[a-z0-9\-]+)*\.[a-z]{2,}\b`)
+)
+
+// filter is a compiled rule
+type filter struct {
+	id          string
+	re          *regexp.Regexp
+	replacement string
+	accept      func(match string) bool // Confirms a match; nil accepts all
+	holdback    int                     // Characters kept back so a match is never split
+}
+
+func compile(rule Rule) (filter, error) {
+	f := filter{id: rule.ID, replacement: rule.Replacement}
+	switch rule.Kind {
+	case KindCreditCard:
+		f.re, f.accept = creditCardPattern, luhnValid
+	case KindEmail:
+		f.re = emailPattern
+	default:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return f, err
+		}
+		f.re = re
+	}
+
+	f.holdback = MaxHoldbackChars
+	if parsed, err := syntax.Parse(f.re.String(), syntax.Perl); err == nil {
+		// One extra character lets word boundaries and $ see what follows a match
+		if n := maxMatchChars(parsed); n >= 0 && n+1 < MaxHoldbackChars {
+			f.holdback = n + 1
+		}
+	}
+	return f, nil
+}
+
+// luhnValid reports whether the digits of a card number candidate pass the Luhn check
+func luhnValid(match string) bool {
+	sum, digits := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// Pipeline is a client's enabled rules in the order they run
+type Pipeline struct {
+	filters []filter
+}
+
+// NewPipeline compiles rules into a pipeline, skipping disabled ones
+func NewPipeline(rules []Rule) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		f, err := compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.filters = append(pipeline.filters, f)
+	}
+	return pipeline, nil
+}
+
+// Empty reports whether the pipeline changes nothing; a nil pipeline is empty
+func (p *Pipeline) Empty() bool {
+	return p == nil || len(p.filters) == 0
+}
+
+// Apply filters a complete text and returns it with the redactions made per rule ID
+func (p *Pipeline) Apply(text string) (string, map[string]int) {
+	stream := p.NewStream()
+	filtered := stream.Write(text) + stream.Flush()
+	return filtered, stream.Redactions()
+}
+
+// NewStream starts filtering one reply
+func (p *Pipeline) NewStream() *Stream {
+	stream := &Stream{counts: make(map[string]int)}
+	if p != nil {
+		for i := range p.filters {
+			stream.stages = append(stream.stages, &stage{filter: &p.filters[i]})
+		}
+	}
+	return stream
+}
+
+// Stream filters a reply as it arrives in chunks. Each rule runs on the
+// output of the previous one and keeps back the tail of its input that a
+// match could still extend into, so a match split across chunks is redacted
+// whole. Write returns the text that is safe to send; Flush releases the rest
+// once the reply is complete.
+type Stream struct {
+	stages []*stage
+	counts map[string]int
+}
+
+// Write adds a chunk and returns the filtered text that can be sent now
+func (s *Stream) Write(chunk string) string {
+	text := chunk
+	for _, st := range s.stages {
+		text = st.write(text, false, s.counts)
+	}
+	return text
+}
+
+// Flush returns the filtered text still held back; call it when the reply ends
+func (s *Stream) Flush() string {
+	text := ""
+	for _, st := range s.stages {
+		text = st.write(text, true, s.counts)
+	}
+	return text
+}
+
+// Redactions returns how many matches each rule has replaced so far, by rule ID
+func (s *Stream) Redactions() map[string]int {
+	counts := make(map[string]int, len(s.counts))
+	for id, n := range s.counts {
+		counts[id] = n
+	}
+	return counts
+}
+
+// Total returns how many matches have been replaced so far
+func (s *Stream) Total() int {
+	total := 0
+	for _, n := range s.counts {
+		total += n
+	}
+	return total
+}
+
+// stage runs one filter over the stream, holding back its unsent input
+type stage struct {
+	filter  *filter
+	pending string
+}
+
+// write adds input and returns the filtered text before the safe cut: at
+// least holdback characters from the end and outside every match. Matches are
+// found on all the pending text, so what follows the cut is taken into account.
+func (st *stage) write(input string, final bool, counts map[string]int) string {
+	text := st.pending + input
+	if text == "" {
+		return ""
+	}
+	matches := st.filter.re.FindAllStringIndex(text, -1)
+
+	cut := len(text)
+	if !final {
+		cut = holdbackStart(text, st.filter.holdback)
+		for _, m := range matches {
+			if m[0] < cut && cut < m[1] {
+				cut = m[0]
+				break
+			}
+		}
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m[1] > cut {
+			break
+		}
+		if m[0] == m[1] || (st.filter.accept != nil && !st.filter.accept(text[m[0]:m[1]])) {
+			continue
+		}
+		out.WriteString(text[last:m[0]])
+		out.WriteString(st.filter.replacement)
+		counts[st.filter.id]++
+		last = m[1]
+	}
+	out.WriteString(text[last:cut])
+	st.pending = text[cut:]
+	return out.String()
+}
+
+// holdbackStart returns the byte offset of the last n characters of text, or
+// 0 when it is shorter
+func holdbackStart(text string, n int) int {
+	offset := len(text)
+	for i := 0; i < n; i++ {
+		if offset == 0 {
+			return 0
+		}
+		_, size := utf8.DecodeLastRuneInString(text[:offset])
+		offset -= size
+	}
+	return offset
+}
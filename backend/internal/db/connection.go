@@ -48,6 +48,9 @@ type Database struct {
 	db     *sql.DB
 	config ConnectionConfig
 	trinoAdapter *TrinoAdapter
+	guard        *queryGuard // Optional timeout and slow-query logging, see SetQueryGuard
+	reader       *Database       // Optional read replica, see SetReader
+	fallback     *readerFallback // Set on a read replica
 }
 
 // ConnectionBuilder provides a fluent interface for building connections
@@ -202,6 +205,11 @@ func Connect(config ConnectionConfig) (*Database, error) {
 		}, nil
 	}
 
+	return open(config, driverName, dsn)
+}
+
+// open opens and pings a pooled connection with the given driver
+func open(config ConnectionConfig, driverName, dsn string) (*Database, error) {
 	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -293,6 +301,9 @@ func buildMySQLDSN(config ConnectionConfig) string {
 
 // Close closes the database connection
 func (db *Database) Close() error {
+	if db.reader != nil {
+		db.reader.Close()
+	}
 	return db.db.Close()
 }
 
@@ -0,0 +1,63 @@
+// Package dbtest opens application databases for tests. They are built by the
+// real migrations, so fixtures pick up every schema change without edits and
+// only insert the rows a test needs.
+package dbtest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/migrations"
+)
+
+// Open returns a new SQLite database in the test's temporary directory with
+// every migration applied, connected the way the application connects, so
+// foreign keys are enforced. It is closed when the test ends.
+func Open(t testing.TB) *db.Database {
+	t.Helper()
+
+	zdb, err := db.ConnectApp(db.DatabaseTypeSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { zdb.Close() })
+	if _, err := migrations.Up(context.Background(), zdb); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return zdb
+}
+
+// Seed runs statements in order, failing the test on the first error
+func Seed(t testing.TB, zdb *db.Database, statements ...string) {
+	t.Helper()
+
+	for _, statement := range statements {
+		if _, err := zdb.Execute(context.Background(), statement); err != nil {
+			t.Fatalf("Failed to seed %q: %v", statement, err)
+		}
+	}
+}
+
+// SeedProject inserts a client, an active user of it and active projects the
+// user owns, the rows most other tables reference
+func SeedProject(t testing.TB, zdb *db.Database, clientID, userID string, projectIDs ...string) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := zdb.Execute(ctx,
+		"INSERT INTO clients (id, name, slug) VALUES ($1, $1, $1)", clientID); err != nil {
+		t.Fatalf("Failed to seed client %s: %v", clientID, err)
+	}
+	if _, err := zdb.Execute(ctx,
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ($1, $2, $1, 'x')", userID, clientID); err != nil {
+		t.Fatalf("Failed to seed user %s: %v", userID, err)
+	}
+	for _, projectID := range projectIDs {
+		if _, err := zdb.Execute(ctx,
+			"INSERT INTO projects (id, user_id, name) VALUES ($1, $2, $1)", projectID, userID); err != nil {
+			t.Fatalf("Failed to seed project %s: %v", projectID, err)
+		}
+	}
+}
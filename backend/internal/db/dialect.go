@@ -0,0 +1,388 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The application's own queries are written once, in PostgreSQL syntax with
+// $n placeholders, and kept to the subset SQLite and MySQL understand as well:
+// no RETURNING, no ON CONFLICT and no :: casts. SQLite accepts $n natively
+// but numbers them by first appearance, so each $n must first appear in order;
+// MySQL connections opened by ConnectApp go through a driver that rewrites
+// them to ? and runs with ANSI_QUOTES so "quoted" identifiers work everywhere.
+
+// mysqlAppDriver is the MySQL driver with $n placeholder support
+const mysqlAppDriver = "zlay-mysql"
+
+func init() {
+	sql.Register(mysqlAppDriver, rebindDriver{mysql.MySQLDriver{}})
+}
+
+// memoryDatabases numbers in-memory SQLite databases so each ConnectApp call gets its own
+var memoryDatabases atomic.Int64
+
+// ConnectApp opens the application database. Unlike datasource connections,
+// the type is given rather than guessed from the connection string, and the
+// connection is set up for the application's queries: foreign keys on SQLite,
+// placeholder rewriting on MySQL.
+func ConnectApp(dbType DatabaseType, connStr string) (*Database, error) {
+	config := NewConnectionBuilder(dbType).ConnectionString(connStr).config
+
+	switch dbType {
+	case DatabaseTypePostgreSQL:
+		return Connect(config)
+	case DatabaseTypeSQLite:
+		dsn, memory := sqliteAppDSN(connStr)
+		database, err := open(config, "sqlite3", dsn)
+		if err != nil {
+			return nil, err
+		}
+		if memory {
+			// The database lives as long as one of its connections does
+			database.db.SetConnMaxLifetime(0)
+			database.db.SetConnMaxIdleTime(0)
+		}
+		return database, nil
+	case DatabaseTypeMySQL:
+		dsn, err := mysqlAppDSN(connStr)
+		if err != nil {
+			return nil, err
+		}
+		return open(config, mysqlAppDriver, dsn)
+	default:
+		return nil, fmt.Errorf("unsupported application database type: %s", dbType)
+	}
+}
+
+// sqliteAppDSN turns a file path, file: URI or ":memory:" into a DSN with
+// foreign keys enforced. ":memory:" becomes a named shared-cache database so
+// every pooled connection sees the same tables; it reports whether it did.
+// Transactions begin immediately: one that reads before writing would otherwise
+// fail with "database is locked" instead of waiting when another writer commits
+// in between.
+func sqliteAppDSN(connStr string) (string, bool) {
+	dsn := strings.TrimPrefix(connStr, "sqlite://")
+	memory := dsn == ":memory:"
+	if memory {
+		dsn = fmt.Sprintf("file:zlay-%d?mode=memory&cache=shared", memoryDatabases.Add(1))
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	dsn += separator + "_foreign_keys=1&_busy_timeout=5000&_txlock=immediate"
+	if !memory {
+		dsn += "&_journal_mode=WAL"
+	}
+	return dsn, memory
+}
+
+// mysqlAppDSN converts a mysql:// URL, or takes a native DSN, and adds the
+// options the application relies on
+func mysqlAppDSN(connStr string) (string, error) {
+	var config *mysql.Config
+	if strings.HasPrefix(connStr, "mysql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", fmt.Errorf("invalid MySQL URL: %w", err)
+		}
+		config = mysql.NewConfig()
+		config.User = u.User.Username()
+		config.Passwd, _ = u.User.Password()
+		config.Net = "tcp"
+		config.Addr = u.Host
+		if u.Port() == "" {
+			config.Addr = net.JoinHostPort(u.Hostname(), "3306")
+		}
+		config.DBName = strings.TrimPrefix(u.Path, "/")
+		for key, values := range u.Query() {
+			if config.Params == nil {
+				config.Params = map[string]string{}
+			}
+			config.Params[key] = values[len(values)-1]
+		}
+	} else {
+		var err error
+		if config, err = mysql.ParseDSN(connStr); err != nil {
+			return "", fmt.Errorf("invalid MySQL DSN: %w", err)
+		}
+	}
+
+	config.ParseTime = true
+	// Migrations run each script as one statement batch
+	config.MultiStatements = true
+	// UPDATE reports matched rows like PostgreSQL, not only changed ones
+	config.ClientFoundRows = true
+	if config.Params == nil {
+		config.Params = map[string]string{}
+	}
+	config.Params["sql_mode"] = "CONCAT(@@sql_mode, ',ANSI_QUOTES')"
+	return config.FormatDSN(), nil
+}
+
+// IsUniqueViolation reports whether err is a unique or primary key violation
+// on any of the supported application databases
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	return false
+}
+
+// Rebind rewrites $n placeholders to ?, skipping quoted strings, quoted
+// identifiers and comments. It returns the rewritten query and, for each ?,
+// the number of the argument it stands for, or nil when the query has no $n.
+func Rebind(query string) (string, []int) {
+	var out strings.Builder
+	var order []int
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(query) && query[end] != c {
+				if query[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) {
+				end = len(query) - 1
+			}
+			out.WriteString(query[i : end+1])
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			out.WriteString(query[i : i+end+1])
+			i += end
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				out.WriteString(query[i:])
+				i = len(query)
+				continue
+			}
+			out.WriteString(query[i : i+end+4])
+			i += end + 3
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			end := i + 1
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(query[i+1 : end])
+			order = append(order, n)
+			out.WriteByte('?')
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	if order == nil {
+		return query, nil
+	}
+	return out.String(), order
+}
+
+// reorder arranges arguments for the placeholders listed by Rebind, repeating
+// an argument whose $n appears more than once
+func reorder(args []driver.NamedValue, order []int) ([]driver.NamedValue, error) {
+	if order == nil {
+		return args, nil
+	}
+	ordered := make([]driver.NamedValue, len(order))
+	for i, n := range order {
+		if n < 1 || n > len(args) {
+			return nil, fmt.Errorf("placeholder $%d has no argument, got %d", n, len(args))
+		}
+		ordered[i] = driver.NamedValue{Ordinal: i + 1, Value: args[n-1].Value}
+	}
+	return ordered, nil
+}
+
+// rebindDriver wraps a driver that only understands ? placeholders
+type rebindDriver struct {
+	driver.Driver
+}
+
+func (d rebindDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &rebindConn{conn}, nil
+}
+
+type rebindConn struct {
+	driver.Conn
+}
+
+func (c *rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	query, order := Rebind(query)
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rebindStmt{Stmt: stmt, order: order}, nil
+}
+
+func (c *rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query, order := Rebind(query)
+	args, err := reorder(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	query, order := Rebind(query)
+	args, err := reorder(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *rebindConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *rebindConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *rebindConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *rebindConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *rebindConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type rebindStmt struct {
+	driver.Stmt
+	order []int
+}
+
+// NumInput is unknown since a $n may be used more than once
+func (s *rebindStmt) NumInput() int {
+	if s.order == nil {
+		return s.Stmt.NumInput()
+	}
+	return -1
+}
+
+func (s *rebindStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *rebindStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *rebindStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	args, err := reorder(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(plainValues(args))
+}
+
+func (s *rebindStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	args, err := reorder(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, args)
+	}
+	return s.Stmt.Query(plainValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, value := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return named
+}
+
+func plainValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
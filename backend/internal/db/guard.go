@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/requestid"
+)
+
+const (
+	// DefaultQueryTimeout bounds a query whose context has no deadline
+	DefaultQueryTimeout = 10 * time.Second
+	// DefaultSlowQueryThreshold is the duration above which a query is logged as slow
+	DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+	// MetricQueryDuration is the metrics recorder fed with every guarded query
+	MetricQueryDuration = "db_query"
+
+	// maxLoggedQueryLength caps the SQL text in slow-query log lines
+	maxLoggedQueryLength = 200
+)
+
+// ErrQueryTimeout is returned when a query runs past the guard's default timeout.
+// It also matches context.DeadlineExceeded.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// QueryGuardConfig configures the timeout and slow-query logging applied to
+// Execute, Query and QueryRow and to the database/sql helpers used by adapters
+type QueryGuardConfig struct {
+	Timeout       time.Duration // applied when the context has no deadline; negative disables
+	SlowThreshold time.Duration // queries slower than this are logged; negative disables
+	Logf          func(format string, args ...interface{})
+}
+
+// QueryGuardStats counts the queries seen by the guard since start
+type QueryGuardStats struct {
+	Queries  int64 `json:"queries"`
+	Slow     int64 `json:"slow"`
+	TimedOut int64 `json:"timed_out"`
+	Failed   int64 `json:"failed"`
+}
+
+type queryGuard struct {
+	timeout       time.Duration
+	slowThreshold time.Duration
+	logf          func(format string, args ...interface{})
+	latency       *metrics.LatencyRecorder
+
+	queries  atomic.Int64
+	slow     atomic.Int64
+	timedOut atomic.Int64
+	failed   atomic.Int64
+}
+
+// SetQueryGuard enables the query guard; zero durations fall back to the defaults.
+// Call it before the database is shared, typically right after connecting.
+func (db *Database) SetQueryGuard(config QueryGuardConfig) {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultQueryTimeout
+	}
+	if config.SlowThreshold == 0 {
+		config.SlowThreshold = DefaultSlowQueryThreshold
+	}
+	if config.Logf == nil {
+		config.Logf = log.Printf
+	}
+	db.guard = &queryGuard{
+		timeout:       config.Timeout,
+		slowThreshold: config.SlowThreshold,
+		logf:          config.Logf,
+		latency:       metrics.Latency(MetricQueryDuration),
+	}
+}
+
+// QueryGuardStats returns the guard's counters; all zero when no guard is set
+func (db *Database) QueryGuardStats() QueryGuardStats {
+	if db.guard == nil {
+		return QueryGuardStats{}
+	}
+	return QueryGuardStats{
+		Queries:  db.guard.queries.Load(),
+		Slow:     db.guard.slow.Load(),
+		TimedOut: db.guard.timedOut.Load(),
+		Failed:   db.guard.failed.Load(),
+	}
+}
+
+// QueryContext runs a query on the underlying *sql.DB through the guard. The rows
+// are read after it returns, so the timeout context is released at its deadline.
+// On a read replica that cannot be reached the query runs on the primary.
+func (db *Database) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if db.fallback.down() {
+		return db.fallback.primary.QueryContext(ctx, query, args...)
+	}
+	guarded, cancel, start := db.guard.begin(ctx)
+	rows, err := db.db.QueryContext(guarded, query, args...)
+	err = db.guard.finish(guarded, query, start, err)
+	if err != nil {
+		cancel()
+		if db.fallback.failed(ctx, err) {
+			return db.fallback.primary.QueryContext(ctx, query, args...)
+		}
+		return nil, err
+	}
+	db.guard.releaseAtDeadline(cancel)
+	return rows, nil
+}
+
+// QueryRowContext runs a single-row query on the underlying *sql.DB through the
+// guard, on the primary when it is a read replica that cannot be reached
+func (db *Database) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if db.fallback.down() {
+		return db.fallback.primary.QueryRowContext(ctx, query, args...)
+	}
+	guarded, cancel, start := db.guard.begin(ctx)
+	row := db.db.QueryRowContext(guarded, query, args...)
+	if err := db.guard.finish(guarded, query, start, row.Err()); err != nil {
+		cancel()
+		if db.fallback.failed(ctx, err) {
+			return db.fallback.primary.QueryRowContext(ctx, query, args...)
+		}
+		return row
+	}
+	db.guard.releaseAtDeadline(cancel)
+	return row
+}
+
+// ExecContext runs a statement on the underlying *sql.DB through the guard
+func (db *Database) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel, start := db.guard.begin(ctx)
+	defer cancel()
+	result, err := db.db.ExecContext(ctx, query, args...)
+	return result, db.guard.finish(ctx, query, start, err)
+}
+
+// begin applies the default timeout when the context has no deadline. A nil guard
+// returns the context unchanged.
+func (g *queryGuard) begin(ctx context.Context) (context.Context, context.CancelFunc, time.Time) {
+	start := time.Now()
+	if g == nil || g.timeout < 0 {
+		return ctx, func() {}, start
+	}
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return ctx, func() {}, start
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	return withGuardDeadline(ctx), cancel, start
+}
+
+// finish records the query and turns a deadline set by the guard into ErrQueryTimeout
+func (g *queryGuard) finish(ctx context.Context, query string, start time.Time, err error) error {
+	if g == nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	g.queries.Add(1)
+	g.latency.Observe(elapsed)
+
+	if g.slowThreshold >= 0 && elapsed > g.slowThreshold {
+		g.slow.Add(1)
+		g.logf("🐢 Slow query (%dms) from %s: %s%s", elapsed.Milliseconds(), callerLabel(), truncateQuery(query), requestid.Field(ctx))
+	}
+	if err == nil {
+		return nil
+	}
+
+	g.failed.Add(1)
+	if isGuardDeadline(ctx) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		g.timedOut.Add(1)
+		return fmt.Errorf("%w after %s: %w", ErrQueryTimeout, g.timeout, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// releaseAtDeadline keeps a rows-returning query's context alive until its deadline
+func (g *queryGuard) releaseAtDeadline(cancel context.CancelFunc) {
+	if g == nil || g.timeout < 0 {
+		return
+	}
+	time.AfterFunc(g.timeout, cancel)
+}
+
+type guardDeadlineKey struct{}
+
+// withGuardDeadline marks a context whose deadline was set by the guard, so a
+// caller's own deadline is not reported as ErrQueryTimeout
+func withGuardDeadline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, guardDeadlineKey{}, true)
+}
+
+func isGuardDeadline(ctx context.Context) bool {
+	marked, _ := ctx.Value(guardDeadlineKey{}).(bool)
+	return marked
+}
+
+// truncateQuery collapses whitespace and caps the SQL text for logging
+func truncateQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxLoggedQueryLength {
+		return query[:maxLoggedQueryLength] + "…"
+	}
+	return query
+}
+
+// callerLabel names the first function outside this package and the
+// DBConnection adapter, e.g. "main.(*App).getProjectsHandler:42"
+func callerLabel() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		name := frame.Function
+		inGuard := strings.HasPrefix(name, "zlay-backend/internal/db.") && !strings.HasSuffix(frame.File, "_test.go")
+		if !inGuard && !strings.Contains(name, "ZlayDBAdapter") {
+			if slash := strings.LastIndex(name, "/"); slash >= 0 {
+				name = name[slash+1:]
+			}
+			return fmt.Sprintf("%s:%d", name, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
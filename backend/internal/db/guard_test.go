@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/requestid"
+)
+
+// slowDriver answers "SLEEP <ms>" after waiting that long, or fails early when
+// the context is done. Every query returns a single row with the value 1.
+type slowDriver struct{}
+
+func (slowDriver) Open(string) (driver.Conn, error) { return slowConn{}, nil }
+
+type slowConn struct{}
+
+func (slowConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (slowConn) Close() error                        { return nil }
+func (slowConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (slowConn) sleep(ctx context.Context, query string) error {
+	ms, err := strconv.Atoi(strings.TrimPrefix(query, "SLEEP "))
+	if err != nil {
+		return fmt.Errorf("bad query %q", query)
+	}
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c slowConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.sleep(ctx, query); err != nil {
+		return nil, err
+	}
+	return &slowRows{}, nil
+}
+
+func (c slowConn) ExecContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.sleep(ctx, query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type slowRows struct{ done bool }
+
+func (r *slowRows) Columns() []string { return []string{"value"} }
+func (r *slowRows) Close() error      { return nil }
+func (r *slowRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+var registerSlowDriver sync.Once
+
+func newGuardedTestDB(t *testing.T, config QueryGuardConfig) *Database {
+	t.Helper()
+
+	registerSlowDriver.Do(func() { sql.Register("zlay-slow-test", slowDriver{}) })
+	sqlDB, err := sql.Open("zlay-slow-test", "")
+	if err != nil {
+		t.Fatalf("Failed to open fake driver: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	database := &Database{db: sqlDB}
+	database.SetQueryGuard(config)
+	return database
+}
+
+func TestQueryGuardAppliesDefaultTimeout(t *testing.T) {
+	database := newGuardedTestDB(t, QueryGuardConfig{
+		Timeout:       50 * time.Millisecond,
+		SlowThreshold: -1,
+		Logf:          func(string, ...interface{}) {},
+	})
+	ctx := context.Background()
+
+	if _, err := database.Query(ctx, "SLEEP 1"); err != nil {
+		t.Fatalf("Expected a fast query to succeed, got %v", err)
+	}
+
+	calls := map[string]func() error{
+		"Query":        func() error { _, err := database.Query(ctx, "SLEEP 1000"); return err },
+		"QueryRow":     func() error { _, err := database.QueryRow(ctx, "SLEEP 1000"); return err },
+		"Execute":      func() error { _, err := database.Execute(ctx, "SLEEP 1000"); return err },
+		"QueryContext": func() error { _, err := database.QueryContext(ctx, "SLEEP 1000"); return err },
+		"ExecContext":  func() error { _, err := database.ExecContext(ctx, "SLEEP 1000"); return err },
+	}
+	for name, call := range calls {
+		start := time.Now()
+		err := call()
+		if !errors.Is(err, ErrQueryTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected ErrQueryTimeout, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: expected the query to be cut off, took %s", name, elapsed)
+		}
+	}
+
+	// A caller's own deadline wins and is not reported as a guard timeout
+	callerCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := database.Query(callerCtx, "SLEEP 1000"); err == nil || errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected the caller's deadline error, got %v", err)
+	}
+
+	stats := database.QueryGuardStats()
+	if stats.Queries != 7 || stats.TimedOut != 5 || stats.Failed != 6 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueryGuardLogsSlowQueries(t *testing.T) {
+	var mutex sync.Mutex
+	var logged []string
+	database := newGuardedTestDB(t, QueryGuardConfig{
+		Timeout:       time.Second,
+		SlowThreshold: 30 * time.Millisecond,
+		Logf: func(format string, args ...interface{}) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			logged = append(logged, fmt.Sprintf(format, args...))
+		},
+	})
+	ctx := context.Background()
+
+	if _, err := database.Query(ctx, "SLEEP 1"); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logged) != 0 {
+		t.Fatalf("Expected no slow-query log for a fast query, got %v", logged)
+	}
+
+	if _, err := database.Query(requestid.With(ctx, "req-1"), "SLEEP 60"); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logged) != 1 {
+		t.Fatalf("Expected one slow-query log line, got %v", logged)
+	}
+	if !strings.Contains(logged[0], "SLEEP 60") || !strings.Contains(logged[0], "TestQueryGuardLogsSlowQueries") ||
+		!strings.HasSuffix(logged[0], " request_id=req-1") {
+		t.Errorf("Expected the log line to name the query, caller and request, got %q", logged[0])
+	}
+	if stats := database.QueryGuardStats(); stats.Slow != 1 || stats.Queries != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	long := "SLEEP " + strings.Repeat(" ", 10) + strings.Repeat("x", 500)
+	if got := truncateQuery(long); len(got) > maxLoggedQueryLength+len("…") || strings.Contains(got, "  ") {
+		t.Errorf("Expected a collapsed, truncated query, got %q", got)
+	}
+}
+
+func TestQueryGuardIsOptional(t *testing.T) {
+	registerSlowDriver.Do(func() { sql.Register("zlay-slow-test", slowDriver{}) })
+	sqlDB, err := sql.Open("zlay-slow-test", "")
+	if err != nil {
+		t.Fatalf("Failed to open fake driver: %v", err)
+	}
+	defer sqlDB.Close()
+
+	database := &Database{db: sqlDB}
+	if _, err := database.Query(context.Background(), "SLEEP 1"); err != nil {
+		t.Fatalf("Expected an unguarded query to succeed, got %v", err)
+	}
+	if stats := database.QueryGuardStats(); stats != (QueryGuardStats{}) {
+		t.Errorf("Expected empty stats without a guard, got %+v", stats)
+	}
+}
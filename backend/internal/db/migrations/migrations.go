@@ -0,0 +1,363 @@
+// Package migrations applies the versioned SQL migrations embedded in the binary.
+//
+// Migrations live in sql/ as NNNN_name.up.sql with an optional NNNN_name.down.sql.
+// SQLite and MySQL application databases use their own series in sql/sqlite/
+// and sql/mysql/, which start from the current schema instead of replaying the
+// PostgreSQL history.
+// Applied versions are recorded in the schema_migrations table. Each migration runs
+// in its own transaction unless the dialect cannot roll back DDL (MySQL) or the file
+// starts with the "-- migrate:no-transaction" directive.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+//go:embed sql/*.sql sql/sqlite/*.sql sql/mysql/*.sql
+var embedded embed.FS
+
+// noTransactionDirective opts a migration out of running inside a transaction,
+// e.g. for CREATE INDEX CONCURRENTLY
+const noTransactionDirective = "-- migrate:no-transaction"
+
+// advisoryLockKey serializes migration runs across processes on PostgreSQL
+const advisoryLockKey = 727011
+
+// ErrIrreversible is returned by Down for a migration without a down script
+var ErrIrreversible = errors.New("migration has no down script")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version       int64
+	Name          string
+	Up            string
+	Down          string
+	NoTransaction bool
+}
+
+// State reports whether a migration has been applied
+type State struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// Runner applies and rolls back migrations against a database
+type Runner struct {
+	db         *db.Database
+	dbType     db.DatabaseType
+	migrations []Migration
+}
+
+// New creates a runner for the migrations embedded in the binary for the
+// database's dialect
+func New(database *db.Database) (*Runner, error) {
+	return NewFromFS(database, embedded, Dir(database.GetConfig().DatabaseType))
+}
+
+// Dir returns the embedded migrations directory of a dialect
+func Dir(dbType db.DatabaseType) string {
+	switch dbType {
+	case db.DatabaseTypeSQLite:
+		return "sql/sqlite"
+	case db.DatabaseTypeMySQL:
+		return "sql/mysql"
+	default:
+		return "sql"
+	}
+}
+
+// NewFromFS creates a runner for the migrations found in dir of source
+func NewFromFS(database *db.Database, source fs.FS, dir string) (*Runner, error) {
+	migrations, err := load(source, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{
+		db:         database,
+		dbType:     database.GetConfig().DatabaseType,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies the embedded migrations that have not been applied yet
+func Up(ctx context.Context, database *db.Database) ([]Migration, error) {
+	runner, err := New(database)
+	if err != nil {
+		return nil, err
+	}
+	return runner.Up(ctx)
+}
+
+// Down rolls back the most recent steps embedded migrations
+func Down(ctx context.Context, database *db.Database, steps int) ([]Migration, error) {
+	runner, err := New(database)
+	if err != nil {
+		return nil, err
+	}
+	return runner.Down(ctx, steps)
+}
+
+// Status lists every embedded migration with its applied state
+func Status(ctx context.Context, database *db.Database) ([]State, error) {
+	runner, err := New(database)
+	if err != nil {
+		return nil, err
+	}
+	return runner.Status(ctx)
+}
+
+// Migrations returns the known migrations in version order
+func (r *Runner) Migrations() []Migration {
+	return r.migrations
+}
+
+// Up applies pending migrations in version order and returns the ones it applied.
+// It stops at the first failure; earlier migrations stay applied.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	if err := r.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range r.migrations {
+		done, err := r.apply(ctx, m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if done {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back up to steps of the most recently applied migrations
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := r.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	appliedAt, err := r.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(r.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		m := r.migrations[i]
+		if _, ok := appliedAt[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return rolledBack, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, ErrIrreversible)
+		}
+		if _, err := r.apply(ctx, m, false); err != nil {
+			return rolledBack, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		rolledBack = append(rolledBack, m)
+	}
+	return rolledBack, nil
+}
+
+// Status lists every known migration with its applied state
+func (r *Runner) Status(ctx context.Context) ([]State, error) {
+	if err := r.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	appliedAt, err := r.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]State, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		at, ok := appliedAt[m.Version]
+		statuses = append(statuses, State{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+func (r *Runner) ensureTable(ctx context.Context) error {
+	_, err := r.db.Execute(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func (r *Runner) appliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	resultSet, err := r.db.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	applied := make(map[int64]time.Time, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		version, ok := row.Values[0].AsInt64()
+		if !ok {
+			return nil, fmt.Errorf("failed to parse migration version")
+		}
+		var appliedAt time.Time
+		if ts, ok := row.Values[1].AsTimestamp(); ok {
+			appliedAt = ts.Time
+		}
+		applied[version] = appliedAt
+	}
+	return applied, nil
+}
+
+// executor is satisfied by both *db.Database and *db.Transaction
+type executor interface {
+	Execute(ctx context.Context, query string, args ...interface{}) (*db.Result, error)
+	Query(ctx context.Context, query string, args ...interface{}) (*db.ResultSet, error)
+}
+
+// apply runs the up or down script of m and records the change. It reports false
+// when there was nothing to do because another run got there first.
+func (r *Runner) apply(ctx context.Context, m Migration, up bool) (bool, error) {
+	if !r.transactional(m) {
+		return r.run(ctx, r.db, m, up)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if r.dbType == db.DatabaseTypePostgreSQL {
+		if _, err := tx.Execute(ctx, "SELECT pg_advisory_xact_lock($1)", advisoryLockKey); err != nil {
+			return false, fmt.Errorf("failed to take migration lock: %w", err)
+		}
+	}
+
+	changed, err := r.run(ctx, tx, m, up)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *Runner) run(ctx context.Context, conn executor, m Migration, up bool) (bool, error) {
+	existing, err := conn.Query(ctx, "SELECT version FROM schema_migrations WHERE version = "+r.placeholder(1), m.Version)
+	if err != nil {
+		return false, err
+	}
+	if applied := len(existing.Rows) > 0; applied == up {
+		return false, nil
+	}
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	if _, err := conn.Execute(ctx, script); err != nil {
+		return false, err
+	}
+
+	if up {
+		_, err = conn.Execute(ctx,
+			fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)",
+				r.placeholder(1), r.placeholder(2), r.placeholder(3)),
+			m.Version, m.Name, time.Now().UTC())
+	} else {
+		_, err = conn.Execute(ctx, "DELETE FROM schema_migrations WHERE version = "+r.placeholder(1), m.Version)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration: %w", err)
+	}
+	return true, nil
+}
+
+// transactional reports whether m can run inside a transaction on this dialect.
+// MySQL commits implicitly on DDL, so a transaction would not protect anything.
+func (r *Runner) transactional(m Migration) bool {
+	return !m.NoTransaction && r.dbType != db.DatabaseTypeMySQL
+}
+
+func (r *Runner) placeholder(n int) string {
+	if r.dbType == db.DatabaseTypeMySQL {
+		return "?"
+	}
+	return "$" + strconv.Itoa(n)
+}
+
+// load reads NNNN_name.up.sql / NNNN_name.down.sql pairs from dir
+func load(source fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, ".sql")
+		base, direction := strings.TrimSuffix(base, path.Ext(base)), strings.TrimPrefix(path.Ext(base), ".")
+		if direction != "up" && direction != "down" {
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", filename)
+		}
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must start with a positive version number", filename)
+		}
+
+		content, err := fs.ReadFile(source, path.Join(dir, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, name)
+		}
+
+		script := string(content)
+		if direction == "up" {
+			if m.Up != "" {
+				return nil, fmt.Errorf("duplicate up migration for version %d", version)
+			}
+			m.Up = script
+			m.NoTransaction = strings.HasPrefix(strings.TrimSpace(script), noTransactionDirective)
+		} else {
+			m.Down = script
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
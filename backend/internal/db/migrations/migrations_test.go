@@ -0,0 +1,282 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"zlay-backend/internal/db"
+)
+
+func newTestDatabase(t *testing.T) *db.Database {
+	t.Helper()
+
+	database, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "migrations.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func newTestRunner(t *testing.T, database *db.Database, files fstest.MapFS) *Runner {
+	t.Helper()
+
+	runner, err := NewFromFS(database, files, "sql")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	return runner
+}
+
+func tableExists(t *testing.T, database *db.Database, table string) bool {
+	t.Helper()
+
+	var count int
+	if err := database.GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count); err != nil {
+		t.Fatalf("Failed to inspect sqlite_master: %v", err)
+	}
+	return count == 1
+}
+
+func versions(migrations []Migration) []int64 {
+	var out []int64
+	for _, m := range migrations {
+		out = append(out, m.Version)
+	}
+	return out
+}
+
+// Versions are numeric, so 2 sorts before 10 even though "10_" < "2_"
+var orderedFiles = fstest.MapFS{
+	"sql/10_add_column.up.sql":    {Data: []byte("ALTER TABLE notes ADD COLUMN pinned BOOLEAN;")},
+	"sql/2_add_index.up.sql":      {Data: []byte("CREATE INDEX idx_notes_body ON notes(body);")},
+	"sql/2_add_index.down.sql":    {Data: []byte("DROP INDEX idx_notes_body;")},
+	"sql/1_create_notes.up.sql":   {Data: []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);\nINSERT INTO notes (body) VALUES ('first');")},
+	"sql/1_create_notes.down.sql": {Data: []byte("DROP TABLE notes;")},
+}
+
+func TestUpAppliesInVersionOrderAndIsIdempotent(t *testing.T) {
+	database := newTestDatabase(t)
+	runner := newTestRunner(t, database, orderedFiles)
+	ctx := context.Background()
+
+	applied, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if got := versions(applied); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 10 {
+		t.Fatalf("Expected versions 1, 2, 10 in order, got %v", got)
+	}
+
+	// A second run finds nothing to do
+	applied, err = runner.Up(ctx)
+	if err != nil {
+		t.Fatalf("Second Up failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations on the second run, got %v", versions(applied))
+	}
+
+	var rows int
+	database.GetDB().QueryRow("SELECT COUNT(*) FROM notes").Scan(&rows)
+	if rows != 1 {
+		t.Errorf("Expected the seed row to be inserted once, got %d", rows)
+	}
+
+	states, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, state := range states {
+		if !state.Applied || state.AppliedAt.IsZero() {
+			t.Errorf("Expected version %d to be applied with a timestamp, got %+v", state.Version, state)
+		}
+	}
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	database := newTestDatabase(t)
+	runner := newTestRunner(t, database, fstest.MapFS{
+		"sql/0001_create_notes.up.sql": {Data: []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY);")},
+		// The first statement succeeds, the second fails: neither may stick
+		"sql/0002_broken.up.sql":       {Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY);\nALTER TABLE missing ADD COLUMN x TEXT;")},
+		"sql/0003_after_broken.up.sql": {Data: []byte("CREATE TABLE later (id INTEGER PRIMARY KEY);")},
+	})
+	ctx := context.Background()
+
+	applied, err := runner.Up(ctx)
+	if err == nil {
+		t.Fatal("Expected Up to fail")
+	}
+	if got := versions(applied); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Expected only version 1 to be applied, got %v", got)
+	}
+	if tableExists(t, database, "tags") {
+		t.Error("Expected the partial migration to be rolled back")
+	}
+	if tableExists(t, database, "later") {
+		t.Error("Migrations after a failure must not run")
+	}
+
+	states, _ := runner.Status(ctx)
+	if !states[0].Applied || states[1].Applied || states[2].Applied {
+		t.Errorf("Unexpected status after failure: %+v", states)
+	}
+}
+
+func TestDownRollsBackMostRecentFirst(t *testing.T) {
+	database := newTestDatabase(t)
+	runner := newTestRunner(t, database, orderedFiles)
+	ctx := context.Background()
+
+	if _, err := runner.Up(ctx); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	// Version 10 has no down script, so nothing is rolled back
+	if _, err := runner.Down(ctx, 1); !errors.Is(err, ErrIrreversible) {
+		t.Fatalf("Expected ErrIrreversible, got %v", err)
+	}
+
+	database.GetDB().Exec("DELETE FROM schema_migrations WHERE version = 10")
+	rolledBack, err := runner.Down(ctx, 2)
+	if err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if got := versions(rolledBack); len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Errorf("Expected versions 2 then 1, got %v", got)
+	}
+	if tableExists(t, database, "notes") {
+		t.Error("Expected the notes table to be dropped")
+	}
+}
+
+func TestLoadRejectsMalformedFiles(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing version":   {"sql/create.up.sql": {Data: []byte("SELECT 1;")}},
+		"bad direction":     {"sql/1_create.sql": {Data: []byte("SELECT 1;")}},
+		"down without up":   {"sql/1_create.down.sql": {Data: []byte("SELECT 1;")}},
+		"duplicate version": {"sql/1_a.up.sql": {Data: []byte("SELECT 1;")}, "sql/1_b.up.sql": {Data: []byte("SELECT 1;")}},
+	}
+	for name, files := range tests {
+		if _, err := load(files, "sql"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsAreSequentialAndReversible(t *testing.T) {
+	for _, dbType := range []db.DatabaseType{db.DatabaseTypePostgreSQL, db.DatabaseTypeSQLite, db.DatabaseTypeMySQL} {
+		migrations, err := load(embedded, Dir(dbType))
+		if err != nil {
+			t.Fatalf("Failed to load embedded %s migrations: %v", dbType, err)
+		}
+		if len(migrations) == 0 {
+			t.Errorf("No embedded %s migrations", dbType)
+		}
+		for i, m := range migrations {
+			if m.Version != int64(i+1) {
+				t.Errorf("Expected %s version %d, got %04d_%s", dbType, i+1, m.Version, m.Name)
+			}
+			if m.Down == "" {
+				t.Errorf("%s migration %04d_%s has no down script", dbType, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestSQLiteMigrationsApplyAndRollBack(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDatabase(t)
+
+	applied, err := Up(ctx, database)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) == 0 || !tableExists(t, database, "conversations") || !tableExists(t, database, "message_embeddings") {
+		t.Fatalf("Expected the SQLite schema, applied %v", versions(applied))
+	}
+
+	if _, err := Down(ctx, database, len(applied)); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if tableExists(t, database, "clients") || tableExists(t, database, "conversations") {
+		t.Error("Expected every table to be dropped")
+	}
+}
+
+func TestSQLiteToolExecutionsBackfill(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDatabase(t)
+	applied, err := Up(ctx, database)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	// Roll back to before tool_executions so it is backfilled from these messages
+	steps := 0
+	for i := len(applied) - 1; i >= 0; i-- {
+		steps++
+		if applied[i].Name == "add_tool_executions" {
+			break
+		}
+	}
+	if _, err := Down(ctx, database, steps); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO clients (id, name, slug) VALUES ('client-1', 'Acme', 'acme')",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-1', 'client-1', 'alice', 'hash')",
+		"INSERT INTO projects (id, user_id, name) VALUES ('project-1', 'user-1', 'Main')",
+		"INSERT INTO conversations (id, title, user_id, project_id) VALUES ('conv-1', 'Revenue', 'user-1', 'project-1')",
+		`INSERT INTO messages (id, conversation_id, role, content, tool_calls) VALUES ('m1', 'conv-1', 'assistant', '',
+			'[{"id":"call-1","type":"function","function":{"name":"database_query","arguments":{"query":"SELECT 1"}},"status":"completed","result":{"rows":[[1]],"time_ms":12}},
+			{"id":"call-2","type":"function","function":{"name":"http_request","arguments":{}},"status":"failed","result":{"error":"timed out"}}]')`,
+		"INSERT INTO messages (id, conversation_id, role, content, tool_calls) VALUES ('m2', 'conv-1', 'assistant', '', 'not json')",
+	} {
+		if _, err := database.GetDB().ExecContext(ctx, statement); err != nil {
+			t.Fatalf("Failed to seed %q: %v", statement, err)
+		}
+	}
+	if _, err := Up(ctx, database); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+
+	rows, err := database.GetDB().QueryContext(ctx,
+		"SELECT id, tool_name, status, COALESCE(result, ''), COALESCE(error, ''), COALESCE(duration_ms, 0) FROM tool_executions WHERE message_id = 'm1' ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to read tool executions: %v", err)
+	}
+	defer rows.Close()
+	type execution struct {
+		id, tool, status, result, error string
+		durationMs                      int
+	}
+	var got []execution
+	for rows.Next() {
+		var e execution
+		if err := rows.Scan(&e.id, &e.tool, &e.status, &e.result, &e.error, &e.durationMs); err != nil {
+			t.Fatalf("Failed to scan tool execution: %v", err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected two backfilled executions, got %+v", got)
+	}
+	if got[0].id != "call-1" || got[0].tool != "database_query" || got[0].status != "completed" || got[0].durationMs != 12 || got[0].result == "" {
+		t.Errorf("Unexpected completed execution %+v", got[0])
+	}
+	if got[1].id != "call-2" || got[1].status != "failed" || got[1].error != "timed out" {
+		t.Errorf("Unexpected failed execution %+v", got[1])
+	}
+
+	var skipped int
+	database.GetDB().QueryRowContext(ctx, "SELECT COUNT(*) FROM tool_executions WHERE message_id = 'm2'").Scan(&skipped)
+	if skipped != 0 {
+		t.Errorf("Expected malformed tool_calls to be skipped, got %d rows", skipped)
+	}
+}
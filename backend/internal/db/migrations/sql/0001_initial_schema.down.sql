@@ -0,0 +1,9 @@
+-- Drop the initial schema, children before parents
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS datasources;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS clients;
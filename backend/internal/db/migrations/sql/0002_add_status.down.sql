@@ -0,0 +1 @@
+ALTER TABLE conversations DROP COLUMN IF EXISTS status;
@@ -0,0 +1,8 @@
+-- Add project_tools table for per-project tool enable/disable
+CREATE TABLE IF NOT EXISTS project_tools (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    tool_name VARCHAR(100) NOT NULL,
+    enabled BOOLEAN DEFAULT true NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, tool_name)
+);
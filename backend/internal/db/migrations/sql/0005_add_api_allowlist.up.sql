@@ -0,0 +1,9 @@
+-- Add api_allowlist table for restricting api_request tool targets per project
+CREATE TABLE IF NOT EXISTS api_allowlist (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    pattern VARCHAR(500) NOT NULL,
+    allow_private BOOLEAN DEFAULT false NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_allowlist_project_id ON api_allowlist(project_id);
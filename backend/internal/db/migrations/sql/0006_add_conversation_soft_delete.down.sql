@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_conversations_deleted_at;
+ALTER TABLE conversations DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+-- Soft delete for conversations; rows are purged after the retention period
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_conversations_deleted_at ON conversations(deleted_at) WHERE deleted_at IS NOT NULL;
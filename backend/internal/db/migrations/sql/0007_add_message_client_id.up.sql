@@ -0,0 +1,3 @@
+-- Client-generated message IDs so resent user messages can be deduplicated
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_message_id UUID;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_message_id ON messages(conversation_id, client_message_id) WHERE client_message_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Per-client cap on concurrent LLM streams; requests over the cap are queued
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS max_concurrent_streams INTEGER NOT NULL DEFAULT 3;
@@ -0,0 +1,8 @@
+-- Rolling summaries of messages dropped from the model context window
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    last_message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    summary TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, last_message_id)
+);
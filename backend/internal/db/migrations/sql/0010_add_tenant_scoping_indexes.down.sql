@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_id_client_id;
+DROP INDEX IF EXISTS idx_projects_user_id_active;
+DROP INDEX IF EXISTS idx_datasources_project_id_active;
@@ -0,0 +1,4 @@
+-- Composite indexes for client-scoped project, datasource and conversation lookups
+CREATE INDEX IF NOT EXISTS idx_users_id_client_id ON users(id, client_id);
+CREATE INDEX IF NOT EXISTS idx_projects_user_id_active ON projects(user_id, is_active, id);
+CREATE INDEX IF NOT EXISTS idx_datasources_project_id_active ON datasources(project_id, is_active);
@@ -0,0 +1,14 @@
+-- Thumbs up/down ratings on messages, one per user and message
+CREATE TABLE IF NOT EXISTS message_feedback (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+    comment TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_feedback_conversation_id ON message_feedback(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_message_feedback_updated_at ON message_feedback(updated_at);
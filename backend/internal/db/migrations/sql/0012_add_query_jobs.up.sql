@@ -0,0 +1,20 @@
+-- Background database_query runs started with async: true
+CREATE TABLE IF NOT EXISTS query_jobs (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    datasource_id UUID REFERENCES datasources(id) ON DELETE SET NULL,
+    query TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    result TEXT,
+    result_path TEXT,
+    timeout_seconds INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_jobs_user_id ON query_jobs(user_id);
+CREATE INDEX IF NOT EXISTS idx_query_jobs_status ON query_jobs(status);
@@ -0,0 +1,7 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS widget_token_limit;
+ALTER TABLE clients DROP COLUMN IF EXISTS widget_rate_limit;
+ALTER TABLE clients DROP COLUMN IF EXISTS widget_project_id;
+
+DROP INDEX IF EXISTS idx_users_visitor_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS is_visitor;
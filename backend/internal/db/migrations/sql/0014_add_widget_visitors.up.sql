@@ -0,0 +1,9 @@
+-- Anonymous widget visitors are short-lived users; the cleanup job deletes them after expires_at
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_visitor BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_users_visitor_expires_at ON users(expires_at) WHERE is_visitor = true;
+
+-- Per-client widget project and the stricter limits applied to visitor connections
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS widget_project_id UUID;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS widget_rate_limit INTEGER NOT NULL DEFAULT 10;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS widget_token_limit BIGINT NOT NULL DEFAULT 20000;
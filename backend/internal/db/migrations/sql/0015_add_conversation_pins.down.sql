@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_conversations_user_project_pinned;
+ALTER TABLE conversations DROP COLUMN IF EXISTS pinned_at;
+ALTER TABLE conversations DROP COLUMN IF EXISTS pinned;
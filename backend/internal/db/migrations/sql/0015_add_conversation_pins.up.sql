@@ -0,0 +1,4 @@
+-- Pinned conversations are listed first, most recently pinned on top
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_conversations_user_project_pinned ON conversations(user_id, project_id) WHERE pinned = true;
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS idx_audit_log_action_created;
+DROP INDEX IF EXISTS idx_audit_log_client_created;
+DROP TABLE IF EXISTS audit_log;
+
+DELETE FROM sessions WHERE impersonated_by IS NOT NULL;
+DROP INDEX IF EXISTS idx_sessions_impersonated_by;
+ALTER TABLE sessions DROP COLUMN IF EXISTS impersonated_by;
+
+UPDATE users SET client_id = (SELECT id FROM clients WHERE slug = 'dev')
+WHERE username = 'root'
+AND client_id = (SELECT id FROM clients WHERE slug = 'system')
+AND EXISTS (SELECT 1 FROM clients WHERE slug = 'dev')
+AND NOT EXISTS (
+    SELECT 1 FROM users u JOIN clients c ON c.id = u.client_id
+    WHERE c.slug = 'dev' AND u.username = 'root'
+);
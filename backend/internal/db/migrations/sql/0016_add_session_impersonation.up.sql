@@ -0,0 +1,30 @@
+-- Root binds to a dedicated system client instead of whichever client was created first
+INSERT INTO clients (name, slug, is_active)
+VALUES ('System', 'system', true)
+ON CONFLICT (slug) DO NOTHING;
+
+UPDATE users SET client_id = (SELECT id FROM clients WHERE slug = 'system')
+WHERE username = 'root'
+AND client_id = (SELECT id FROM clients WHERE slug = 'dev')
+AND NOT EXISTS (
+    SELECT 1 FROM users u JOIN clients c ON c.id = u.client_id
+    WHERE c.slug = 'system' AND u.username = 'root'
+);
+
+-- Sessions issued by POST /api/admin/impersonate record the root user who asked for them
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonated_by UUID REFERENCES users(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_sessions_impersonated_by ON sessions(impersonated_by) WHERE impersonated_by IS NOT NULL;
+
+-- Administrative actions, newest first per client
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor_id UUID,
+    client_id UUID,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    details JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_client_created ON audit_log(client_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_created ON audit_log(action, created_at DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_datasource_schema_snapshots_datasource_created;
+DROP TABLE IF EXISTS datasource_schema_snapshots;
+ALTER TABLE datasources DROP COLUMN IF EXISTS schema_snapshot_interval_minutes;
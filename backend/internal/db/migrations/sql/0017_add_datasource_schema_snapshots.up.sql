@@ -0,0 +1,14 @@
+-- Per-datasource snapshot interval in minutes; NULL uses SCHEMA_SNAPSHOT_INTERVAL and 0 disables snapshots
+ALTER TABLE datasources ADD COLUMN IF NOT EXISTS schema_snapshot_interval_minutes INTEGER;
+
+-- Serialized datasource schemas with the diff against the previous snapshot
+CREATE TABLE IF NOT EXISTS datasource_schema_snapshots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    datasource_id UUID NOT NULL REFERENCES datasources(id) ON DELETE CASCADE,
+    schema JSONB NOT NULL,
+    diff JSONB,
+    changed BOOLEAN NOT NULL DEFAULT false,
+    table_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_datasource_schema_snapshots_datasource_created ON datasource_schema_snapshots(datasource_id, created_at DESC);
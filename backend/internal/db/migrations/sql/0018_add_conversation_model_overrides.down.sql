@@ -0,0 +1,5 @@
+ALTER TABLE conversations DROP COLUMN IF EXISTS max_tokens;
+ALTER TABLE conversations DROP COLUMN IF EXISTS temperature;
+ALTER TABLE conversations DROP COLUMN IF EXISTS model;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS allowed_models;
@@ -0,0 +1,7 @@
+-- Models a client's users may pick per conversation, besides the client's own ai_api_model
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS allowed_models JSONB NOT NULL DEFAULT '[]';
+
+-- Per-conversation overrides of the client's LLM settings; NULL uses the client default
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS model VARCHAR(100);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS max_tokens INTEGER;
@@ -0,0 +1,15 @@
+-- API keys for calling the chat and conversations API from other services.
+-- project_id NULL makes a client-level key; only the hash of the key is stored.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(255) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_client_project ON api_keys(client_id, project_id);
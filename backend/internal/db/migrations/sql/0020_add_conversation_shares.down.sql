@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_conversation_shares_conversation;
+DROP TABLE IF EXISTS conversation_shares;
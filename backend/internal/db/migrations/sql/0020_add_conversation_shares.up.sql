@@ -0,0 +1,13 @@
+-- Read-only links to a conversation that work without signing in.
+-- include_tools exposes tool call arguments and results to link holders.
+CREATE TABLE IF NOT EXISTS conversation_shares (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    include_tools BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_conversation_shares_conversation ON conversation_shares(conversation_id);
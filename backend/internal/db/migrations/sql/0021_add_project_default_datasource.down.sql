@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN IF EXISTS default_datasource_id;
@@ -0,0 +1,2 @@
+-- Datasource the database tools use when a tool call names none
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS default_datasource_id UUID REFERENCES datasources(id) ON DELETE SET NULL;
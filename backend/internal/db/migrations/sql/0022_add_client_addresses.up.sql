@@ -0,0 +1,5 @@
+-- Client address and browser behind each session and audit entry, derived
+-- from X-Forwarded-For only when the request came through a trusted proxy
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS ip VARCHAR(45);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_prompt_templates_project_name;
+DROP TABLE IF EXISTS prompt_templates;
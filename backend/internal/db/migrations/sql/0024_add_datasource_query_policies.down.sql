@@ -0,0 +1 @@
+ALTER TABLE datasources DROP COLUMN IF EXISTS query_policies;
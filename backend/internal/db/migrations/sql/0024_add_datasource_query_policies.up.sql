@@ -0,0 +1,2 @@
+-- Ordered allow/deny rules limiting the tables the database tools may touch
+ALTER TABLE datasources ADD COLUMN IF NOT EXISTS query_policies JSONB NOT NULL DEFAULT '[]';
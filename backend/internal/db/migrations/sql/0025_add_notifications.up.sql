@@ -0,0 +1,23 @@
+-- Where a client's operator emails go; event_types is a JSON array, empty for
+-- every event type
+CREATE TABLE IF NOT EXISTS notification_settings (
+    client_id UUID PRIMARY KEY REFERENCES clients(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every email sent or attempted; also used to send at most one per client and
+-- event type per hour
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_client_event ON notifications(client_id, event_type, created_at);
@@ -0,0 +1,26 @@
+-- Embeddings of assistant messages for the conversation_search tool.
+-- embedding is the vector as a JSON array of floats, compared by brute force;
+-- embedding_vector holds the same vector when pgvector is available and is
+-- searched by the database instead
+CREATE TABLE IF NOT EXISTS message_embeddings (
+    message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    model VARCHAR(100) NOT NULL,
+    dimensions INTEGER NOT NULL,
+    embedding JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_embeddings_project ON message_embeddings(project_id, model, created_at);
+
+-- pgvector is optional: servers without the extension, or roles that may not
+-- create it, keep the brute-force search
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS vector;
+    ALTER TABLE message_embeddings ADD COLUMN IF NOT EXISTS embedding_vector vector;
+EXCEPTION WHEN OTHERS THEN
+    RAISE NOTICE 'pgvector is not available, conversation search falls back to brute force: %', SQLERRM;
+END
+$$;
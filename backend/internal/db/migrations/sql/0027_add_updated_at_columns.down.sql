@@ -0,0 +1,4 @@
+ALTER TABLE datasources DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE projects DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE domains DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE clients DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS user_id;
+DROP INDEX IF EXISTS idx_conversation_participants_user;
+DROP TABLE IF EXISTS conversation_participants;
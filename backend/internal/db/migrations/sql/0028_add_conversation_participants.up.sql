@@ -0,0 +1,19 @@
+-- Users who can read and post to a conversation besides its creator.
+-- The creator is recorded as the owner; only the owner adds participants
+-- and deletes the conversation.
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'participant',
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user ON conversation_participants(user_id);
+
+INSERT INTO conversation_participants (conversation_id, user_id, role, added_at)
+SELECT id, user_id, 'owner', created_at FROM conversations
+ON CONFLICT DO NOTHING;
+
+-- Which participant sent a user message; NULL for assistant and tool messages
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+UPDATE messages SET user_id = c.user_id FROM conversations c WHERE c.id = messages.conversation_id AND messages.role = 'user';
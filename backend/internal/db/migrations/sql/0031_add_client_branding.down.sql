@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS branding_updated_at;
+ALTER TABLE clients DROP COLUMN IF EXISTS branding;
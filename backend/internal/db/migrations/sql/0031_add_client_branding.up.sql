@@ -0,0 +1,4 @@
+-- Public branding served to the embedded widget by GET /api/widget/config;
+-- branding_updated_at is its ETag and stays NULL until the branding is edited
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS branding JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS branding_updated_at TIMESTAMP;
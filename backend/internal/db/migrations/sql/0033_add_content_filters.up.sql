@@ -0,0 +1,17 @@
+-- Redaction rules applied, in position order, to a client's assistant replies
+-- before they are streamed or saved. kind is regex, with pattern and
+-- replacement, or one of the built-in credit_card and email detectors.
+CREATE TABLE IF NOT EXISTS content_filters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'regex',
+    pattern TEXT,
+    replacement VARCHAR(255) NOT NULL DEFAULT '[REDACTED]',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_filters_client_id ON content_filters(client_id);
@@ -0,0 +1,3 @@
+-- The conversation a fork was copied from; NULL for conversations that are not forks
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS forked_from_conversation_id UUID REFERENCES conversations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_conversations_forked_from ON conversations(forked_from_conversation_id) WHERE forked_from_conversation_id IS NOT NULL;
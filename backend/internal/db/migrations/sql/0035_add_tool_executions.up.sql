@@ -0,0 +1,39 @@
+-- One row per execution of a tool call made by an assistant reply. id is the
+-- tool call's ID, which providers only keep unique within a message. Rows are
+-- written before the reply is saved, so message_id has no foreign key. Results
+-- over the inline limit are written to result_file_path instead of result.
+CREATE TABLE IF NOT EXISTS tool_executions (
+    id VARCHAR(255) NOT NULL,
+    message_id UUID NOT NULL,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    tool_name VARCHAR(255) NOT NULL,
+    arguments JSONB,
+    status VARCHAR(20) NOT NULL,
+    result JSONB,
+    result_file_path TEXT,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    duration_ms INTEGER,
+    PRIMARY KEY (message_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tool_executions_conversation_id ON tool_executions(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_tool_name ON tool_executions(tool_name, status, started_at);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_started_at ON tool_executions(started_at);
+
+-- Backfill from the tool calls embedded in messages; failed calls kept their
+-- error as {"error": "..."} in result. The message JSON is left as it is.
+INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result, error, started_at, duration_ms)
+SELECT call->>'id', m.id, m.conversation_id,
+    COALESCE(call->'function'->>'name', ''),
+    call->'function'->'arguments',
+    COALESCE(NULLIF(call->>'status', ''), 'pending'),
+    CASE WHEN jsonb_typeof(call->'result') = 'object' THEN call->'result' END,
+    COALESCE(NULLIF(call->>'error', ''), CASE WHEN call->>'status' = 'failed' THEN call->'result'->>'error' END),
+    COALESCE(m.created_at, CURRENT_TIMESTAMP),
+    CASE WHEN jsonb_typeof(call->'result'->'time_ms') = 'number' THEN (call->'result'->>'time_ms')::numeric::integer END
+FROM messages m
+CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(m.tool_calls) = 'array' THEN m.tool_calls ELSE '[]'::jsonb END) AS call
+WHERE jsonb_typeof(call) = 'object' AND COALESCE(call->>'id', '') <> ''
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,15 @@
+-- Project activity feed: who did what to which entity. Written in the background
+-- by activity.Recorder and pruned after ACTIVITY_RETENTION_DAYS.
+CREATE TABLE IF NOT EXISTS project_events (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    actor_user_id UUID,
+    event_type VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50),
+    entity_id VARCHAR(255),
+    payload JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_events_project_created ON project_events(project_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_project_events_created_at ON project_events(created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_domains_normalized_domain;
+ALTER TABLE domains DROP COLUMN IF EXISTS normalized_domain;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS project_retention_runs;
+ALTER TABLE conversations DROP COLUMN IF EXISTS retention_note;
+ALTER TABLE projects DROP COLUMN IF EXISTS retention_exempt_pinned;
+ALTER TABLE projects DROP COLUMN IF EXISTS retention_mode;
+ALTER TABLE projects DROP COLUMN IF EXISTS retention_days;
@@ -0,0 +1,3 @@
+-- Replies of projects with citations_enabled are asked to mark the tool results
+-- they rely on; the chat service keeps the valid markers as message citations.
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS citations_enabled BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- Client admins manage the users of their own client through /api/users. Each
+-- client's root account becomes its first admin.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member'));
+UPDATE users SET role = 'admin' WHERE username = 'root' AND is_visitor = false;
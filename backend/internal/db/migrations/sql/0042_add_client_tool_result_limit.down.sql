@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS tool_result_token_limit;
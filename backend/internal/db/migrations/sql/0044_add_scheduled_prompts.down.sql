@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_scheduled_prompts_project_id;
+DROP INDEX IF EXISTS idx_scheduled_prompts_due;
+DROP TABLE IF EXISTS scheduled_prompts;
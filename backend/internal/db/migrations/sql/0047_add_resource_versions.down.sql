@@ -0,0 +1,5 @@
+ALTER TABLE conversations DROP COLUMN IF EXISTS version;
+ALTER TABLE projects DROP COLUMN IF EXISTS version;
+ALTER TABLE datasources DROP COLUMN IF EXISTS version;
+ALTER TABLE domains DROP COLUMN IF EXISTS version;
+ALTER TABLE clients DROP COLUMN IF EXISTS version;
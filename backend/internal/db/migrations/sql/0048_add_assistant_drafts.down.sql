@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS status;
+ALTER TABLE conversations DROP COLUMN IF EXISTS drafts_enabled;
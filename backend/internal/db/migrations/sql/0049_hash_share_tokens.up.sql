@@ -0,0 +1,4 @@
+-- Share links are stored as the SHA-256 of their token, base64 encoded like
+-- session and API key hashes, so the table alone does not open any link.
+ALTER TABLE conversation_shares RENAME COLUMN token TO token_hash;
+UPDATE conversation_shares SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'base64');
@@ -0,0 +1,344 @@
+-- MySQL 8.0.16+ version of the schema the PostgreSQL migrations build up to.
+-- IDs are UUID strings generated by the application, partial indexes are
+-- plain ones and foreign keys are table constraints, since MySQL ignores
+-- REFERENCES on a column. Later schema changes get a migration here as well
+-- as in sql/.
+
+CREATE TABLE IF NOT EXISTS clients (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(255) UNIQUE NOT NULL,
+    ai_api_key VARCHAR(500),
+    ai_api_url VARCHAR(500),
+    ai_api_model VARCHAR(100),
+    ai_api_type VARCHAR(50),
+    max_concurrent_streams INTEGER NOT NULL DEFAULT 3,
+    widget_project_id CHAR(36),
+    widget_rate_limit INTEGER NOT NULL DEFAULT 10,
+    widget_token_limit BIGINT NOT NULL DEFAULT 20000,
+    allowed_models JSON NOT NULL DEFAULT ('[]'),
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE TABLE IF NOT EXISTS domains (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    domain VARCHAR(255) NOT NULL UNIQUE,
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_domains_client_id (client_id),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    is_visitor BOOLEAN NOT NULL DEFAULT false,
+    expires_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE(client_id, username),
+    INDEX idx_users_id_client_id (id, client_id),
+    INDEX idx_users_visitor_expires_at (expires_at)
+);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    is_active BOOLEAN DEFAULT true,
+    default_datasource_id CHAR(36),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_projects_user_id (user_id),
+    INDEX idx_projects_user_id_active (user_id, is_active, id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS datasources (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    config JSON NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    schema_snapshot_interval_minutes INTEGER,
+    query_policies JSON NOT NULL DEFAULT ('[]'),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_datasources_project_id (project_id),
+    INDEX idx_datasources_project_id_active (project_id, is_active),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+-- datasources is created after projects
+ALTER TABLE projects ADD CONSTRAINT fk_projects_default_datasource
+    FOREIGN KEY (default_datasource_id) REFERENCES datasources(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS project_tools (
+    project_id CHAR(36) NOT NULL,
+    tool_name VARCHAR(100) NOT NULL,
+    enabled BOOLEAN DEFAULT true NOT NULL,
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (project_id, tool_name),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS project_files (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_project_files_project_id (project_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_allowlist (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    pattern VARCHAR(500) NOT NULL,
+    allow_private BOOLEAN DEFAULT false NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_api_allowlist_project_id (project_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    impersonated_by CHAR(36),
+    ip VARCHAR(45),
+    user_agent TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_sessions_token_hash (token_hash),
+    INDEX idx_sessions_expires_at (expires_at),
+    INDEX idx_sessions_user_token_expires (user_id, token_hash, expires_at),
+    INDEX idx_sessions_impersonated_by (impersonated_by),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (impersonated_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id CHAR(36) PRIMARY KEY,
+    title TEXT NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    project_id CHAR(36) NOT NULL,
+    status VARCHAR(20) DEFAULT 'completed' NOT NULL,
+    pinned BOOLEAN NOT NULL DEFAULT false,
+    pinned_at DATETIME(6),
+    model VARCHAR(100),
+    temperature DOUBLE PRECISION,
+    max_tokens INTEGER,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    deleted_at DATETIME(6),
+    INDEX idx_conversations_user_project (user_id, project_id),
+    INDEX idx_conversations_updated_at (updated_at DESC),
+    INDEX idx_conversations_deleted_at (deleted_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id CHAR(36) PRIMARY KEY,
+    conversation_id CHAR(36) NOT NULL,
+    role VARCHAR(50) NOT NULL,
+    content MEDIUMTEXT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    metadata JSON,
+    tool_calls JSON,
+    client_message_id CHAR(36),
+    UNIQUE INDEX idx_messages_client_message_id (conversation_id, client_message_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_embeddings (
+    message_id CHAR(36) PRIMARY KEY,
+    conversation_id CHAR(36) NOT NULL,
+    project_id CHAR(36) NOT NULL,
+    model VARCHAR(100) NOT NULL,
+    dimensions INTEGER NOT NULL,
+    embedding JSON NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_message_embeddings_project (project_id, model, created_at),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    conversation_id CHAR(36) NOT NULL,
+    last_message_id CHAR(36) NOT NULL,
+    summary MEDIUMTEXT NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (conversation_id, last_message_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (last_message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_feedback (
+    message_id CHAR(36) NOT NULL,
+    conversation_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+    comment TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (message_id, user_id),
+    INDEX idx_message_feedback_conversation_id (conversation_id),
+    INDEX idx_message_feedback_updated_at (updated_at),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS query_jobs (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    datasource_id CHAR(36),
+    query TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    result MEDIUMTEXT,
+    result_path TEXT,
+    timeout_seconds INTEGER NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    started_at DATETIME(6),
+    completed_at DATETIME(6),
+    INDEX idx_query_jobs_user_id (user_id),
+    INDEX idx_query_jobs_status (status),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (datasource_id) REFERENCES datasources(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types JSON NOT NULL DEFAULT ('[]'),
+    is_active BOOLEAN DEFAULT true,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_webhooks_client_id (client_id),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id CHAR(36) PRIMARY KEY,
+    webhook_id CHAR(36) NOT NULL,
+    event_id CHAR(36) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    success BOOLEAN NOT NULL DEFAULT false,
+    error TEXT,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_webhook_deliveries_webhook_id (webhook_id, created_at),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notification_settings (
+    client_id CHAR(36) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    event_types JSON NOT NULL DEFAULT ('[]'),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_notifications_client_event (client_id, event_type, created_at),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id CHAR(36) PRIMARY KEY,
+    actor_id CHAR(36),
+    client_id CHAR(36),
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    details JSON,
+    ip VARCHAR(45),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_audit_log_client_created (client_id, created_at DESC),
+    INDEX idx_audit_log_action_created (action, created_at DESC)
+);
+
+CREATE TABLE IF NOT EXISTS datasource_schema_snapshots (
+    id CHAR(36) PRIMARY KEY,
+    datasource_id CHAR(36) NOT NULL,
+    `schema` JSON NOT NULL,
+    diff JSON,
+    changed BOOLEAN NOT NULL DEFAULT false,
+    table_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_datasource_schema_snapshots_datasource_created (datasource_id, created_at DESC),
+    FOREIGN KEY (datasource_id) REFERENCES datasources(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    project_id CHAR(36),
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(255) NOT NULL UNIQUE,
+    last_used_at DATETIME(6),
+    created_by CHAR(36) NOT NULL,
+    revoked_at DATETIME(6),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_api_keys_client_project (client_id, project_id),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS conversation_shares (
+    id CHAR(36) PRIMARY KEY,
+    conversation_id CHAR(36) NOT NULL,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    include_tools BOOLEAN NOT NULL DEFAULT false,
+    expires_at DATETIME(6),
+    created_by CHAR(36) NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_conversation_shares_conversation (conversation_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    content MEDIUMTEXT NOT NULL,
+    created_by CHAR(36) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE INDEX idx_prompt_templates_project_name (project_id, (LOWER(name))),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+-- The admin, project and datasource handlers stamp updated_at on every change
+ALTER TABLE clients ADD COLUMN updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6);
+ALTER TABLE domains ADD COLUMN updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6);
+ALTER TABLE projects ADD COLUMN updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6);
+ALTER TABLE datasources ADD COLUMN updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6);
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP FOREIGN KEY fk_messages_user;
+ALTER TABLE messages DROP COLUMN user_id;
+DROP TABLE IF EXISTS conversation_participants;
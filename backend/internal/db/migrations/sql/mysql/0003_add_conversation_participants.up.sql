@@ -0,0 +1,19 @@
+-- Users who can read and post to a conversation besides its creator
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'participant',
+    added_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (conversation_id, user_id),
+    INDEX idx_conversation_participants_user (user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT IGNORE INTO conversation_participants (conversation_id, user_id, role, added_at)
+SELECT id, user_id, 'owner', created_at FROM conversations;
+
+-- Which participant sent a user message; NULL for assistant and tool messages
+ALTER TABLE messages ADD COLUMN user_id CHAR(36) NULL,
+    ADD CONSTRAINT fk_messages_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+UPDATE messages m JOIN conversations c ON c.id = m.conversation_id SET m.user_id = c.user_id WHERE m.role = 'user';
@@ -0,0 +1,15 @@
+-- Timing of each assistant reply for GET /api/analytics/latency. day is the
+-- UTC date of created_at, stored so grouping by day needs no date functions.
+CREATE TABLE IF NOT EXISTS message_metrics (
+    message_id CHAR(36) PRIMARY KEY,
+    conversation_id CHAR(36) NOT NULL,
+    model VARCHAR(100) NOT NULL,
+    ttft_ms INTEGER NOT NULL,
+    total_ms INTEGER NOT NULL,
+    chunk_count INTEGER NOT NULL,
+    day CHAR(10) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_message_metrics_created_at (created_at),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+);
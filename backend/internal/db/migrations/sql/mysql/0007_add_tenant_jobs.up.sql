@@ -0,0 +1,18 @@
+-- Tenant data exports and purges started from the admin API. A job keeps the
+-- client_id after the client is purged, so there is no foreign key; step is
+-- how many purge steps have finished, so an interrupted purge resumes there
+CREATE TABLE IF NOT EXISTS tenant_jobs (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    step INTEGER NOT NULL DEFAULT 0,
+    counts TEXT,
+    error TEXT,
+    result_path TEXT,
+    created_by CHAR(36),
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    started_at DATETIME(6),
+    completed_at DATETIME(6),
+    INDEX idx_tenant_jobs_status (status)
+);
@@ -0,0 +1,17 @@
+-- Redaction rules applied, in position order, to a client's assistant replies
+-- before they are streamed or saved. kind is regex, with pattern and
+-- replacement, or one of the built-in credit_card and email detectors.
+CREATE TABLE IF NOT EXISTS content_filters (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'regex',
+    pattern TEXT,
+    replacement VARCHAR(255) NOT NULL DEFAULT '[REDACTED]',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_content_filters_client_id (client_id),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE conversations DROP FOREIGN KEY fk_conversations_forked_from;
+ALTER TABLE conversations DROP INDEX idx_conversations_forked_from;
+ALTER TABLE conversations DROP COLUMN forked_from_conversation_id;
@@ -0,0 +1,4 @@
+-- The conversation a fork was copied from; NULL for conversations that are not forks
+ALTER TABLE conversations ADD COLUMN forked_from_conversation_id CHAR(36) NULL,
+    ADD INDEX idx_conversations_forked_from (forked_from_conversation_id),
+    ADD CONSTRAINT fk_conversations_forked_from FOREIGN KEY (forked_from_conversation_id) REFERENCES conversations(id) ON DELETE SET NULL;
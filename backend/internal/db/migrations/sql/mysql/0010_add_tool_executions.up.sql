@@ -0,0 +1,47 @@
+-- One row per execution of a tool call made by an assistant reply. id is the
+-- tool call's ID, which providers only keep unique within a message. Rows are
+-- written before the reply is saved, so message_id has no foreign key. Results
+-- over the inline limit are written to result_file_path instead of result.
+CREATE TABLE IF NOT EXISTS tool_executions (
+    id VARCHAR(255) NOT NULL,
+    message_id CHAR(36) NOT NULL,
+    conversation_id CHAR(36) NOT NULL,
+    tool_name VARCHAR(255) NOT NULL,
+    arguments JSON,
+    status VARCHAR(20) NOT NULL,
+    result JSON,
+    result_file_path TEXT,
+    error TEXT,
+    started_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    finished_at DATETIME(6),
+    duration_ms INTEGER,
+    PRIMARY KEY (message_id, id),
+    INDEX idx_tool_executions_conversation_id (conversation_id),
+    INDEX idx_tool_executions_tool_name (tool_name, status, started_at),
+    INDEX idx_tool_executions_started_at (started_at),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+);
+
+-- Backfill from the tool calls embedded in messages; failed calls kept their
+-- error as {"error": "..."} in result. The message JSON is left as it is.
+INSERT IGNORE INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result, error, started_at, duration_ms)
+SELECT calls.id, m.id, m.conversation_id,
+    COALESCE(calls.tool_name, ''),
+    calls.arguments,
+    COALESCE(NULLIF(calls.status, ''), 'pending'),
+    CASE WHEN JSON_TYPE(calls.result) = 'OBJECT' THEN calls.result END,
+    COALESCE(NULLIF(calls.error, ''), CASE WHEN calls.status = 'failed' THEN calls.result_error END),
+    COALESCE(m.created_at, CURRENT_TIMESTAMP(6)),
+    ROUND(calls.time_ms)
+FROM messages m,
+JSON_TABLE(CASE WHEN JSON_TYPE(m.tool_calls) = 'ARRAY' THEN m.tool_calls ELSE JSON_ARRAY() END, '$[*]' COLUMNS (
+    id VARCHAR(255) PATH '$.id',
+    tool_name VARCHAR(255) PATH '$.function.name',
+    arguments JSON PATH '$.function.arguments',
+    status VARCHAR(20) PATH '$.status',
+    result JSON PATH '$.result',
+    error TEXT PATH '$.error',
+    result_error TEXT PATH '$.result.error',
+    time_ms DOUBLE PATH '$.result.time_ms' NULL ON ERROR
+)) AS calls
+WHERE calls.id IS NOT NULL AND calls.id <> '';
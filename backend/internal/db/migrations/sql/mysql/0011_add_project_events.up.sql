@@ -0,0 +1,15 @@
+-- Project activity feed: who did what to which entity. Written in the background
+-- by activity.Recorder and pruned after ACTIVITY_RETENTION_DAYS.
+CREATE TABLE IF NOT EXISTS project_events (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    actor_user_id CHAR(36),
+    event_type VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50),
+    entity_id VARCHAR(255),
+    payload JSON,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_project_events_project_created (project_id, created_at, id),
+    INDEX idx_project_events_created_at (created_at),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
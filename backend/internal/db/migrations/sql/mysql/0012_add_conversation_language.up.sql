@@ -0,0 +1,3 @@
+-- Language the assistant is told to respond in; set from the first user message
+-- unless chosen explicitly. NULL until one is detected or chosen.
+ALTER TABLE conversations ADD COLUMN language VARCHAR(10) NULL;
@@ -0,0 +1,2 @@
+DROP INDEX idx_domains_normalized_domain ON domains;
+ALTER TABLE domains DROP COLUMN normalized_domain;
@@ -0,0 +1,6 @@
+-- Normalized form of domain that request hosts are looked up by. Stored domains
+-- are already normalized on save; rows written before that are rewritten at
+-- startup, which fills in this column for them as well.
+ALTER TABLE domains ADD COLUMN normalized_domain VARCHAR(255) NULL;
+UPDATE domains SET normalized_domain = domain WHERE normalized_domain IS NULL;
+CREATE UNIQUE INDEX idx_domains_normalized_domain ON domains(normalized_domain);
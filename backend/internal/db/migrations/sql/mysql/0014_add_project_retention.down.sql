@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS project_retention_runs;
+ALTER TABLE conversations DROP COLUMN retention_note;
+ALTER TABLE projects DROP COLUMN retention_exempt_pinned;
+ALTER TABLE projects DROP COLUMN retention_mode;
+ALTER TABLE projects DROP COLUMN retention_days;
@@ -0,0 +1,26 @@
+-- Per-project message retention: after retention_days, messages are deleted or
+-- have their content redacted by chat.RetentionEnforcer. NULL days keeps
+-- messages forever. Pinned conversations are skipped with retention_exempt_pinned.
+ALTER TABLE projects ADD COLUMN retention_days INT NULL;
+ALTER TABLE projects ADD COLUMN retention_mode VARCHAR(10) NULL;
+ALTER TABLE projects ADD COLUMN retention_exempt_pinned BOOLEAN NOT NULL DEFAULT false;
+
+-- Why a conversation was archived, e.g. every message removed by retention
+ALTER TABLE conversations ADD COLUMN retention_note TEXT NULL;
+
+-- One row per retention run of a project, served by GET /api/projects/:id/retention/status
+CREATE TABLE IF NOT EXISTS project_retention_runs (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    mode VARCHAR(10) NOT NULL,
+    retention_days INT NOT NULL,
+    cutoff DATETIME(6) NOT NULL,
+    messages_processed INT NOT NULL DEFAULT 0,
+    conversations_archived INT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at DATETIME(6) NOT NULL,
+    finished_at DATETIME(6) NULL,
+    INDEX idx_project_retention_runs_project_started (project_id, started_at),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN citations_enabled;
@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN tool_result_token_limit;
@@ -0,0 +1 @@
+DROP INDEX idx_conversations_project_updated_at ON conversations;
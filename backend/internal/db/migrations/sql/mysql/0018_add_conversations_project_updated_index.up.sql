@@ -0,0 +1,2 @@
+-- Serves the per-project conversation counts and last activity of GET /api/projects
+CREATE INDEX idx_conversations_project_updated_at ON conversations(project_id, updated_at);
@@ -0,0 +1,30 @@
+-- Prompts run on a cron schedule. Each run posts the prompt, or the rendered
+-- template_id, to conversation_id as created_by and delivers the reply to
+-- target. next_run_at is NULL while disabled; running_since is set while a run
+-- is in progress so the next one does not overlap it.
+CREATE TABLE IF NOT EXISTS scheduled_prompts (
+    id CHAR(36) PRIMARY KEY,
+    project_id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    cron_expression VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    prompt TEXT,
+    template_id CHAR(36),
+    variables JSON NOT NULL DEFAULT ('{}'),
+    target VARCHAR(20) NOT NULL DEFAULT 'conversation',
+    conversation_id CHAR(36),
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    next_run_at DATETIME(6),
+    running_since DATETIME(6),
+    last_run_at DATETIME(6),
+    last_status VARCHAR(20),
+    last_error TEXT,
+    created_by CHAR(36) NOT NULL,
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_scheduled_prompts_due (enabled, next_run_at),
+    INDEX idx_scheduled_prompts_project_id (project_id),
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE SET NULL,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
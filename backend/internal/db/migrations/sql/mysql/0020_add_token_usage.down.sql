@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS token_usage;
+ALTER TABLE clients DROP COLUMN daily_token_budget;
@@ -0,0 +1,22 @@
+-- Tokens used through the OpenAI-compatible API, one row per completion, and
+-- the per-client daily budget they are checked against. NULL is unlimited.
+ALTER TABLE clients ADD COLUMN daily_token_budget BIGINT;
+
+CREATE TABLE IF NOT EXISTS token_usage (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    user_id CHAR(36),
+    api_key_id CHAR(36),
+    project_id CHAR(36),
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    estimated BOOLEAN NOT NULL DEFAULT false, -- the provider reported no usage
+    created_at DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_token_usage_client_created (client_id, created_at),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE SET NULL,
+    FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tool_execution_audit;
+ALTER TABLE clients DROP COLUMN redact_sql_literals;
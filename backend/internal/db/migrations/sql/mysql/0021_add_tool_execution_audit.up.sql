@@ -0,0 +1,26 @@
+-- Who ran which tool against which datasource, kept for security review. Ids
+-- other than the client are not foreign keys, so the record outlives the users,
+-- projects and datasources it names. sql_text is the query of database_query
+-- executions, truncated and, with redact_sql_literals, stripped of string literals.
+ALTER TABLE clients ADD COLUMN redact_sql_literals BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS tool_execution_audit (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    user_id CHAR(36),
+    project_id CHAR(36),
+    conversation_id CHAR(36),
+    tool_call_id VARCHAR(255),
+    tool_name VARCHAR(100) NOT NULL,
+    datasource_id CHAR(36),
+    source VARCHAR(20) NOT NULL, -- llm, user, rerun or schedule
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    sql_text TEXT,
+    sql_truncated BOOLEAN NOT NULL DEFAULT false,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    started_at DATETIME(6) NOT NULL,
+    INDEX idx_tool_execution_audit_client_started (client_id, started_at),
+    INDEX idx_tool_execution_audit_started (started_at),
+    FOREIGN KEY (client_id) REFERENCES clients(id) ON DELETE CASCADE
+);
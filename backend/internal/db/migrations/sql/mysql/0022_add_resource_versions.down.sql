@@ -0,0 +1,5 @@
+ALTER TABLE conversations DROP COLUMN version;
+ALTER TABLE projects DROP COLUMN version;
+ALTER TABLE datasources DROP COLUMN version;
+ALTER TABLE domains DROP COLUMN version;
+ALTER TABLE clients DROP COLUMN version;
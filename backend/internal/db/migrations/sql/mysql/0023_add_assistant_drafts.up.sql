@@ -0,0 +1,4 @@
+-- Assistant drafts: replies in a conversation with drafts_enabled are saved with
+-- status 'draft' and only join the history later turns see once approved.
+ALTER TABLE conversations ADD COLUMN drafts_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE messages ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'final';
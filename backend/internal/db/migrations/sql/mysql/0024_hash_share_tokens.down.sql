@@ -0,0 +1,3 @@
+-- Tokens cannot be recovered from their hashes, so existing links are revoked
+UPDATE conversation_shares SET revoked = true;
+ALTER TABLE conversation_shares RENAME COLUMN token_hash TO token;
@@ -0,0 +1,25 @@
+-- Drop the schema, children before parents
+DROP TABLE IF EXISTS prompt_templates;
+DROP TABLE IF EXISTS conversation_shares;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS datasource_schema_snapshots;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS notification_settings;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS query_jobs;
+DROP TABLE IF EXISTS message_feedback;
+DROP TABLE IF EXISTS conversation_summaries;
+DROP TABLE IF EXISTS message_embeddings;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS api_allowlist;
+DROP TABLE IF EXISTS project_files;
+DROP TABLE IF EXISTS project_tools;
+DROP TABLE IF EXISTS datasources;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS clients;
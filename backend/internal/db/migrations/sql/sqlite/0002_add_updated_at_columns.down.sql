@@ -0,0 +1,4 @@
+ALTER TABLE datasources DROP COLUMN updated_at;
+ALTER TABLE projects DROP COLUMN updated_at;
+ALTER TABLE domains DROP COLUMN updated_at;
+ALTER TABLE clients DROP COLUMN updated_at;
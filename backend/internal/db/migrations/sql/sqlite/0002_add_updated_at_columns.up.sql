@@ -0,0 +1,7 @@
+-- The admin, project and datasource handlers stamp updated_at on every change.
+-- SQLite cannot add a column with a CURRENT_TIMESTAMP default, so existing rows
+-- start out NULL.
+ALTER TABLE clients ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE domains ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE projects ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE datasources ADD COLUMN updated_at TIMESTAMP;
@@ -0,0 +1,17 @@
+-- Users who can read and post to a conversation besides its creator
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'participant',
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user ON conversation_participants(user_id);
+
+INSERT OR IGNORE INTO conversation_participants (conversation_id, user_id, role, added_at)
+SELECT id, user_id, 'owner', created_at FROM conversations;
+
+-- Which participant sent a user message; NULL for assistant and tool messages.
+-- Left without a foreign key since SQLite cannot drop a column that has one.
+ALTER TABLE messages ADD COLUMN user_id TEXT;
+UPDATE messages SET user_id = (SELECT c.user_id FROM conversations c WHERE c.id = messages.conversation_id) WHERE role = 'user';
@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN stream_flush_interval_ms;
+ALTER TABLE clients DROP COLUMN stream_flush_chars;
@@ -0,0 +1,3 @@
+-- Per-client streaming cadence; NULL uses STREAM_FLUSH_CHARS and STREAM_FLUSH_INTERVAL_MS
+ALTER TABLE clients ADD COLUMN stream_flush_chars INTEGER;
+ALTER TABLE clients ADD COLUMN stream_flush_interval_ms INTEGER;
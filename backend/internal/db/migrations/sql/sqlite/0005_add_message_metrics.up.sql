@@ -0,0 +1,14 @@
+-- Timing of each assistant reply for GET /api/analytics/latency. day is the
+-- UTC date of created_at, stored so grouping by day needs no date functions.
+CREATE TABLE IF NOT EXISTS message_metrics (
+    message_id TEXT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    model VARCHAR(100) NOT NULL,
+    ttft_ms INTEGER NOT NULL,
+    total_ms INTEGER NOT NULL,
+    chunk_count INTEGER NOT NULL,
+    day CHAR(10) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_metrics_created_at ON message_metrics(created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN branding_updated_at;
+ALTER TABLE clients DROP COLUMN branding;
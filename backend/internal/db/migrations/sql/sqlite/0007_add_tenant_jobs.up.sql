@@ -0,0 +1,19 @@
+-- Tenant data exports and purges started from the admin API. A job keeps the
+-- client_id after the client is purged, so there is no foreign key; step is
+-- how many purge steps have finished, so an interrupted purge resumes there
+CREATE TABLE IF NOT EXISTS tenant_jobs (
+    id TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    step INTEGER NOT NULL DEFAULT 0,
+    counts TEXT,
+    error TEXT,
+    result_path TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_jobs_status ON tenant_jobs(status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_conversations_forked_from;
+ALTER TABLE conversations DROP COLUMN forked_from_conversation_id;
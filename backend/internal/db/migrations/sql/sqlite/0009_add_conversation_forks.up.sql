@@ -0,0 +1,5 @@
+-- The conversation a fork was copied from; NULL for conversations that are not forks.
+-- Left without a foreign key since SQLite cannot drop a column that has one, so
+-- a fork keeps the id of a source conversation that has since been purged.
+ALTER TABLE conversations ADD COLUMN forked_from_conversation_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_conversations_forked_from ON conversations(forked_from_conversation_id) WHERE forked_from_conversation_id IS NOT NULL;
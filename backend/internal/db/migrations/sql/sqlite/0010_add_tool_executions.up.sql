@@ -0,0 +1,40 @@
+-- One row per execution of a tool call made by an assistant reply. id is the
+-- tool call's ID, which providers only keep unique within a message. Rows are
+-- written before the reply is saved, so message_id has no foreign key. Results
+-- over the inline limit are written to result_file_path instead of result.
+CREATE TABLE IF NOT EXISTS tool_executions (
+    id VARCHAR(255) NOT NULL,
+    message_id TEXT NOT NULL,
+    conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    tool_name VARCHAR(255) NOT NULL,
+    arguments TEXT,
+    status VARCHAR(20) NOT NULL,
+    result TEXT,
+    result_file_path TEXT,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    duration_ms INTEGER,
+    PRIMARY KEY (message_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tool_executions_conversation_id ON tool_executions(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_tool_name ON tool_executions(tool_name, status, started_at);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_started_at ON tool_executions(started_at);
+
+-- Backfill from the tool calls embedded in messages; failed calls kept their
+-- error as {"error": "..."} in result. The message JSON is left as it is.
+INSERT OR IGNORE INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result, error, started_at, duration_ms)
+SELECT json_extract(call.value, '$.id'), m.id, m.conversation_id,
+    COALESCE(json_extract(call.value, '$.function.name'), ''),
+    call.value -> '$.function.arguments',
+    COALESCE(NULLIF(json_extract(call.value, '$.status'), ''), 'pending'),
+    CASE WHEN json_type(call.value, '$.result') = 'object' THEN call.value -> '$.result' END,
+    COALESCE(NULLIF(json_extract(call.value, '$.error'), ''),
+        CASE WHEN json_extract(call.value, '$.status') = 'failed' AND json_type(call.value, '$.result') = 'object'
+        THEN json_extract(call.value, '$.result.error') END),
+    COALESCE(m.created_at, CURRENT_TIMESTAMP),
+    CASE WHEN json_type(call.value, '$.result') = 'object' AND json_type(call.value, '$.result.time_ms') IN ('integer', 'real')
+    THEN CAST(ROUND(json_extract(call.value, '$.result.time_ms')) AS INTEGER) END
+FROM messages m, json_each(CASE WHEN json_valid(m.tool_calls) THEN CASE WHEN json_type(m.tool_calls) = 'array' THEN m.tool_calls END END) AS call
+WHERE call.type = 'object' AND COALESCE(json_extract(call.value, '$.id'), '') <> '';
@@ -0,0 +1,6 @@
+-- Normalized form of domain that request hosts are looked up by. Stored domains
+-- are already normalized on save; rows written before that are rewritten at
+-- startup, which fills in this column for them as well.
+ALTER TABLE domains ADD COLUMN normalized_domain TEXT;
+UPDATE domains SET normalized_domain = domain WHERE normalized_domain IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_normalized_domain ON domains(normalized_domain);
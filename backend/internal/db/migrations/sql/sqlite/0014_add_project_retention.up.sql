@@ -0,0 +1,26 @@
+-- Per-project message retention: after retention_days, messages are deleted or
+-- have their content redacted by chat.RetentionEnforcer. NULL days keeps
+-- messages forever. Pinned conversations are skipped with retention_exempt_pinned.
+ALTER TABLE projects ADD COLUMN retention_days INTEGER;
+ALTER TABLE projects ADD COLUMN retention_mode TEXT;
+ALTER TABLE projects ADD COLUMN retention_exempt_pinned BOOLEAN NOT NULL DEFAULT false;
+
+-- Why a conversation was archived, e.g. every message removed by retention
+ALTER TABLE conversations ADD COLUMN retention_note TEXT;
+
+-- One row per retention run of a project, served by GET /api/projects/:id/retention/status
+CREATE TABLE IF NOT EXISTS project_retention_runs (
+    id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    mode VARCHAR(10) NOT NULL,
+    retention_days INTEGER NOT NULL,
+    cutoff TIMESTAMP NOT NULL,
+    messages_processed INTEGER NOT NULL DEFAULT 0,
+    conversations_archived INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_retention_runs_project_started ON project_retention_runs(project_id, started_at);
@@ -0,0 +1,3 @@
+-- Tokens a tool result may take in the LLM prompt before it is replaced by a
+-- digest; NULL uses the server default
+ALTER TABLE clients ADD COLUMN tool_result_token_limit INTEGER;
@@ -0,0 +1,28 @@
+-- Prompts run on a cron schedule. Each run posts the prompt, or the rendered
+-- template_id, to conversation_id as created_by and delivers the reply to
+-- target. next_run_at is NULL while disabled; running_since is set while a run
+-- is in progress so the next one does not overlap it.
+CREATE TABLE IF NOT EXISTS scheduled_prompts (
+    id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    cron_expression VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    prompt TEXT,
+    template_id TEXT,
+    variables TEXT NOT NULL DEFAULT '{}',
+    target VARCHAR(20) NOT NULL DEFAULT 'conversation',
+    conversation_id TEXT REFERENCES conversations(id) ON DELETE SET NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    next_run_at TIMESTAMP,
+    running_since TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_status VARCHAR(20),
+    last_error TEXT,
+    created_by TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_due ON scheduled_prompts(enabled, next_run_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_project_id ON scheduled_prompts(project_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_token_usage_client_created;
+DROP TABLE IF EXISTS token_usage;
+ALTER TABLE clients DROP COLUMN daily_token_budget;
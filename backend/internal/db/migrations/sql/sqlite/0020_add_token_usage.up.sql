@@ -0,0 +1,19 @@
+-- Tokens used through the OpenAI-compatible API, one row per completion, and
+-- the per-client daily budget they are checked against. NULL is unlimited.
+ALTER TABLE clients ADD COLUMN daily_token_budget BIGINT;
+
+CREATE TABLE IF NOT EXISTS token_usage (
+    id TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    api_key_id TEXT REFERENCES api_keys(id) ON DELETE SET NULL,
+    project_id TEXT REFERENCES projects(id) ON DELETE SET NULL,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    estimated BOOLEAN NOT NULL DEFAULT false, -- the provider reported no usage
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_usage_client_created ON token_usage(client_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tool_execution_audit_started;
+DROP INDEX IF EXISTS idx_tool_execution_audit_client_started;
+DROP TABLE IF EXISTS tool_execution_audit;
+ALTER TABLE clients DROP COLUMN redact_sql_literals;
@@ -0,0 +1,26 @@
+-- Who ran which tool against which datasource, kept for security review. Ids
+-- other than the client are not foreign keys, so the record outlives the users,
+-- projects and datasources it names. sql_text is the query of database_query
+-- executions, truncated and, with redact_sql_literals, stripped of string literals.
+ALTER TABLE clients ADD COLUMN redact_sql_literals BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS tool_execution_audit (
+    id TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    user_id TEXT,
+    project_id TEXT,
+    conversation_id TEXT,
+    tool_call_id VARCHAR(255),
+    tool_name VARCHAR(100) NOT NULL,
+    datasource_id TEXT,
+    source VARCHAR(20) NOT NULL, -- llm, user, rerun or schedule
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    sql_text TEXT,
+    sql_truncated BOOLEAN NOT NULL DEFAULT false,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tool_execution_audit_client_started ON tool_execution_audit(client_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_tool_execution_audit_started ON tool_execution_audit(started_at);
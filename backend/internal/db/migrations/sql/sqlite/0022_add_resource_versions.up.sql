@@ -0,0 +1,8 @@
+-- Optimistic locking: every edit of a client, domain, datasource, project or
+-- conversation increments version, and an update names the version it was
+-- based on, so a concurrent edit is refused instead of silently overwritten.
+ALTER TABLE clients ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE domains ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE datasources ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE projects ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE conversations ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN status;
+ALTER TABLE conversations DROP COLUMN drafts_enabled;
@@ -0,0 +1,5 @@
+-- Share links are stored as the SHA-256 of their token, base64 encoded like
+-- session and API key hashes, so the table alone does not open any link.
+-- SQLite cannot hash the existing tokens, so those links are revoked.
+ALTER TABLE conversation_shares RENAME COLUMN token TO token_hash;
+UPDATE conversation_shares SET revoked = true;
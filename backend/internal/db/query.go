@@ -2,17 +2,24 @@ package db
 
 import (
 	"context"
-	"fmt"
+	"errors"
 )
 
+// ErrNoRows is returned by QueryRow when the query matches no rows
+var ErrNoRows = errors.New("no rows found")
+
 // Execute executes a non-query SQL statement
 func (db *Database) Execute(ctx context.Context, query string, args ...interface{}) (*Result, error) {
+	ctx, cancel, start := db.guard.begin(ctx)
+	defer cancel()
+
 	if db.trinoAdapter != nil {
-		return db.trinoAdapter.Execute(ctx, query, args...)
+		result, err := db.trinoAdapter.Execute(ctx, query, args...)
+		return result, db.guard.finish(ctx, query, start, err)
 	}
 
 	result, err := db.db.ExecContext(ctx, query, args...)
-	if err != nil {
+	if err := db.guard.finish(ctx, query, start, err); err != nil {
 		return nil, err
 	}
 
@@ -25,26 +32,65 @@ func (db *Database) Execute(ctx context.Context, query string, args ...interface
 	}, nil
 }
 
-// Query executes a query and returns result set
+// Query executes a query and returns result set. On a read replica that cannot
+// be reached the query runs on the primary.
 func (db *Database) Query(ctx context.Context, query string, args ...interface{}) (*ResultSet, error) {
+	if db.fallback.down() {
+		return db.fallback.primary.Query(ctx, query, args...)
+	}
+	guarded, cancel, start := db.guard.begin(ctx)
+	defer cancel()
+
 	if db.trinoAdapter != nil {
-		return db.trinoAdapter.Query(ctx, query, args...)
+		resultSet, err := db.trinoAdapter.Query(guarded, query, args...)
+		return resultSet, db.guard.finish(guarded, query, start, err)
 	}
 
-	rows, err := db.db.QueryContext(ctx, query, args...)
+	rows, err := db.db.QueryContext(guarded, query, args...)
 	if err != nil {
+		err = db.guard.finish(guarded, query, start, err)
+		if db.fallback.failed(ctx, err) {
+			return db.fallback.primary.Query(ctx, query, args...)
+		}
 		return nil, err
 	}
 	defer rows.Close()
 
-	return ConvertSQLRowToResultSet(rows)
+	resultSet, err := ConvertSQLRowToResultSet(rows)
+	return resultSet, db.guard.finish(guarded, query, start, err)
 }
 
-// QueryRow executes a query that returns a single row
+// QueryRow executes a query that returns a single row, on the primary when it
+// is a read replica that cannot be reached
 func (db *Database) QueryRow(ctx context.Context, query string, args ...interface{}) (*Row, error) {
+	if db.fallback.down() {
+		return db.fallback.primary.QueryRow(ctx, query, args...)
+	}
+	guarded, cancel, start := db.guard.begin(ctx)
+	defer cancel()
+
 	if db.trinoAdapter != nil {
-		return db.trinoAdapter.QueryRow(ctx, query, args...)
+		row, err := db.trinoAdapter.QueryRow(guarded, query, args...)
+		if errors.Is(err, ErrNoRows) {
+			db.guard.finish(guarded, query, start, nil)
+			return nil, err
+		}
+		return row, db.guard.finish(guarded, query, start, err)
+	}
+
+	row, err := db.queryRow(guarded, query, args...)
+	if errors.Is(err, ErrNoRows) {
+		db.guard.finish(guarded, query, start, nil)
+		return nil, err
 	}
+	err = db.guard.finish(guarded, query, start, err)
+	if err != nil && db.fallback.failed(ctx, err) {
+		return db.fallback.primary.QueryRow(ctx, query, args...)
+	}
+	return row, err
+}
+
+func (db *Database) queryRow(ctx context.Context, query string, args ...interface{}) (*Row, error) {
 
 	// Execute the query with regular Query to get column information
 	rows, err := db.db.QueryContext(ctx, query, args...)
@@ -61,7 +107,10 @@ func (db *Database) QueryRow(ctx context.Context, query string, args ...interfac
 
 	// Check if we have any rows
 	if !rows.Next() {
-		return nil, fmt.Errorf("no rows found")
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoRows
 	}
 
 	// Prepare scan values
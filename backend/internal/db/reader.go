@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ReaderRetryInterval is how long reads stay on the primary after the read
+// replica's connection failed, before the replica is tried again
+const ReaderRetryInterval = 30 * time.Second
+
+// ReaderStats counts the queries served by the read replica
+type ReaderStats struct {
+	QueryGuardStats
+	Fallbacks int64 `json:"fallbacks"` // Reads sent to the primary because the replica's connection failed
+}
+
+// readerFallback is set on a read replica: the primary its reads go to while
+// its connection is failing
+type readerFallback struct {
+	primary   *Database
+	downUntil atomic.Int64 // Unix nanoseconds
+	fallbacks atomic.Int64
+	now       func() time.Time
+}
+
+// SetReader makes replica the database returned by Reader. Reads on it that
+// fail to connect are retried on db, which then serves them for
+// ReaderRetryInterval. Call it before the database is shared; Close closes both.
+func (db *Database) SetReader(replica *Database) {
+	replica.fallback = &readerFallback{primary: db, now: time.Now}
+	db.reader = replica
+}
+
+// Reader returns the read replica for read-only queries that may lag behind
+// writes, or db itself when no replica is set. Query, QueryRow, QueryContext
+// and QueryRowContext fall back to the primary; Execute and transactions do not.
+func (db *Database) Reader() *Database {
+	if db.reader != nil {
+		return db.reader
+	}
+	return db
+}
+
+// ReaderStats returns the read replica's counters, nil when no replica is set
+func (db *Database) ReaderStats() *ReaderStats {
+	if db.reader == nil {
+		return nil
+	}
+	return &ReaderStats{
+		QueryGuardStats: db.reader.QueryGuardStats(),
+		Fallbacks:       db.reader.fallback.fallbacks.Load(),
+	}
+}
+
+// down reports whether the replica's connection failed recently, so reads go
+// straight to the primary. A nil fallback, as on a primary, is never down.
+func (f *readerFallback) down() bool {
+	return f != nil && f.now().UnixNano() < f.downUntil.Load()
+}
+
+// failed reports whether a replica read that returned err should be retried on
+// the primary, marking the replica down when it should. The caller's own
+// cancellation is not a connection failure.
+func (f *readerFallback) failed(ctx context.Context, err error) bool {
+	if f == nil || ctx.Err() != nil || !isConnectionError(err) {
+		return false
+	}
+	f.downUntil.Store(f.now().Add(ReaderRetryInterval).UnixNano())
+	f.fallbacks.Add(1)
+	log.Printf("Read replica failed, reading from the primary for %s: %v", ReaderRetryInterval, err)
+	return true
+}
+
+// isConnectionError reports whether err means the database could not be
+// reached, rather than that the query itself failed
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	var sqliteErr sqlite3.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Code == sqlite3.ErrCantOpen
+	}
+	// database/sql does not export the error for a closed *sql.DB
+	return strings.Contains(err.Error(), "sql: database is closed")
+}
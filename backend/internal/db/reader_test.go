@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newLabelledSQLite opens an in-memory SQLite database whose only row names it
+func newLabelledSQLite(t *testing.T, label string) *Database {
+	t.Helper()
+
+	database, err := ConnectApp(DatabaseTypeSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("ConnectApp failed: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	database.SetQueryGuard(QueryGuardConfig{Logf: func(string, ...interface{}) {}})
+
+	ctx := context.Background()
+	if _, err := database.ExecContext(ctx, "CREATE TABLE source (label TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := database.ExecContext(ctx, "INSERT INTO source (label) VALUES ($1)", label); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+	return database
+}
+
+// readLabel reads the label of the database that served the query, through
+// QueryRowContext, QueryContext and QueryRow
+func readLabel(t *testing.T, database *Database) string {
+	t.Helper()
+
+	var label string
+	if err := database.QueryRowContext(context.Background(), "SELECT label FROM source").Scan(&label); err != nil {
+		t.Fatalf("QueryRowContext failed: %v", err)
+	}
+	rows, err := database.QueryContext(context.Background(), "SELECT label FROM source")
+	if err != nil {
+		t.Fatalf("QueryContext failed: %v", err)
+	}
+	defer rows.Close()
+	var again string
+	for rows.Next() {
+		rows.Scan(&again)
+	}
+	row, err := database.QueryRow(context.Background(), "SELECT label FROM source")
+	if err != nil {
+		t.Fatalf("QueryRow failed: %v", err)
+	}
+	if value, _ := row.Values[0].AsString(); again != label || value != label {
+		t.Fatalf("Expected every query to be served by %s, got %s and %s", label, again, value)
+	}
+	return label
+}
+
+func TestReaderDefaultsToThePrimary(t *testing.T) {
+	primary := newLabelledSQLite(t, "primary")
+
+	if primary.Reader() != primary || primary.ReaderStats() != nil {
+		t.Fatal("Expected the primary to serve reads when no replica is set")
+	}
+}
+
+func TestReaderFallsBackWhenTheReplicaFails(t *testing.T) {
+	primary := newLabelledSQLite(t, "primary")
+	replica := newLabelledSQLite(t, "replica")
+	primary.SetReader(replica)
+	now := time.Now()
+	replica.fallback.now = func() time.Time { return now }
+
+	if label := readLabel(t, primary.Reader()); label != "replica" {
+		t.Fatalf("Expected the replica to serve reads, got %s", label)
+	}
+	if label := readLabel(t, primary); label != "primary" {
+		t.Fatalf("Expected the primary to keep serving its own queries, got %s", label)
+	}
+
+	// A failing query is not a connection failure
+	if _, err := replica.QueryContext(context.Background(), "SELECT missing FROM source"); err == nil {
+		t.Fatal("Expected the bad query to fail on the replica")
+	}
+	// Two setup statements, three reads and the bad query
+	if stats := primary.ReaderStats(); stats.Fallbacks != 0 || stats.Queries != 6 || stats.Failed != 1 {
+		t.Fatalf("Expected 6 replica queries and no fallback, got %+v", stats)
+	}
+
+	replica.db.Close()
+	if label := readLabel(t, primary.Reader()); label != "primary" {
+		t.Fatalf("Expected reads to fall back to the primary, got %s", label)
+	}
+	if stats := primary.ReaderStats(); stats.Fallbacks != 1 {
+		t.Errorf("Expected the replica to be skipped once it failed, got %d fallbacks", stats.Fallbacks)
+	}
+
+	// After ReaderRetryInterval the replica is tried again
+	now = now.Add(ReaderRetryInterval + time.Second)
+	readLabel(t, primary.Reader())
+	if stats := primary.ReaderStats(); stats.Fallbacks != 2 {
+		t.Errorf("Expected the replica to be retried, got %d fallbacks", stats.Fallbacks)
+	}
+}
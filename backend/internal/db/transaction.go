@@ -72,4 +72,6 @@ func (tx *Transaction) Rollback() error {
 type Result struct {
 	RowsAffected int64
 	LastInsertID int64
+	QueryID      string      // Set by engines that identify queries, e.g. Trino
+	Stats        *QueryStats // Execution statistics when the engine reports them
 }
@@ -3,273 +3,637 @@ package db
 import (
 	"bytes"
 	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// TrinoAdapter provides basic Trino support using HTTP API
+const (
+	// trinoPreparedStatement is the name parameterized queries are prepared under.
+	// Prepared statements travel in a request header, so nothing is kept on the server.
+	trinoPreparedStatement = "zlay_stmt"
+	// trinoMaxRetries bounds retries of 429/502/503/504 responses for one request
+	trinoMaxRetries = 5
+	// trinoCancelTimeout bounds the DELETE sent to cancel an abandoned query
+	trinoCancelTimeout = 5 * time.Second
+)
+
+// TrinoAdapter runs queries through Trino's client REST protocol: the statement is
+// POSTed to /v1/statement and nextUri is followed until the query finishes.
+// Parameters are sent as a prepared statement executed with typed literals, so
+// argument values can never change the shape of the SQL.
 type TrinoAdapter struct {
 	serverURL  string
 	username   string
 	password   string
+	httpClient *http.Client
+
+	// Session state, updated from X-Trino-Set-* response headers
+	mutex      sync.Mutex
 	catalog    string
 	schema     string
-	httpClient *http.Client
+	properties map[string]string
+}
+
+// TrinoError is an error reported by Trino for a query
+type TrinoError struct {
+	QueryID   string
+	Message   string
+	ErrorCode int
+	ErrorName string
+	ErrorType string
+}
+
+func (e *TrinoError) Error() string {
+	if e.ErrorName != "" {
+		return fmt.Sprintf("Trino error (%s): %s", e.ErrorName, e.Message)
+	}
+	return fmt.Sprintf("Trino error: %s", e.Message)
+}
+
+// QueryStats are the execution statistics Trino reports for a query
+type QueryStats struct {
+	State           string `json:"state"`
+	Queued          bool   `json:"queued"`
+	Nodes           int    `json:"nodes"`
+	TotalSplits     int    `json:"totalSplits"`
+	CompletedSplits int    `json:"completedSplits"`
+	ElapsedTimeMs   int64  `json:"elapsedTimeMillis"`
+	CPUTimeMs       int64  `json:"cpuTimeMillis"`
+	QueuedTimeMs    int64  `json:"queuedTimeMillis"`
+	ProcessedRows   int64  `json:"processedRows"`
+	ProcessedBytes  int64  `json:"processedBytes"`
+	PeakMemoryBytes int64  `json:"peakMemoryBytes"`
 }
 
-// NewTrinoAdapter creates a new Trino adapter
+// trinoResponse is one page of the statement protocol
+type trinoResponse struct {
+	ID      string `json:"id"`
+	NextURI string `json:"nextUri"`
+	Columns []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"columns"`
+	Data        [][]interface{} `json:"data"`
+	Stats       QueryStats      `json:"stats"`
+	UpdateType  string          `json:"updateType"`
+	UpdateCount *int64          `json:"updateCount"`
+	Error       *struct {
+		Message   string `json:"message"`
+		ErrorCode int    `json:"errorCode"`
+		ErrorName string `json:"errorName"`
+		ErrorType string `json:"errorType"`
+	} `json:"error"`
+}
+
+// trinoResult accumulates every page of a finished query
+type trinoResult struct {
+	queryID     string
+	stats       QueryStats
+	columns     []Column
+	rows        [][]interface{}
+	updateCount int64
+}
+
+// NewTrinoAdapter creates a new Trino adapter. Catalog and schema given as query
+// parameters of serverURL are used when the arguments are empty.
 func NewTrinoAdapter(serverURL, username, password, catalog, schema string) *TrinoAdapter {
+	if parsed, err := url.Parse(serverURL); err == nil && parsed.RawQuery != "" {
+		if catalog == "" {
+			catalog = parsed.Query().Get("catalog")
+		}
+		if schema == "" {
+			schema = parsed.Query().Get("schema")
+		}
+		parsed.RawQuery = ""
+		serverURL = parsed.String()
+	}
+
 	return &TrinoAdapter{
-		serverURL: serverURL,
-		username:  username,
-		password:  password,
-		catalog:   catalog,
-		schema:    schema,
+		serverURL:  strings.TrimRight(serverURL, "/"),
+		username:   username,
+		password:   password,
+		catalog:    catalog,
+		schema:     schema,
+		properties: make(map[string]string),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 }
 
+// SetSessionProperty sets a Trino session property sent with every later query,
+// e.g. query_max_execution_time
+func (ta *TrinoAdapter) SetSessionProperty(name, value string) {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+	ta.properties[name] = value
+}
+
 // Execute executes a statement using Trino HTTP API
 func (ta *TrinoAdapter) Execute(ctx context.Context, query string, args ...interface{}) (*Result, error) {
-	// Prepare query
-	finalQuery := query
-	for i, arg := range args {
-		placeholder := fmt.Sprintf("$%d", i+1)
-		finalQuery = strings.ReplaceAll(finalQuery, placeholder, fmt.Sprintf("'%v'", arg))
+	res, err := ta.run(ctx, query, args)
+	if err != nil {
+		return nil, err
 	}
 
-	// Prepare request body
-	requestBody := map[string]interface{}{
-		"query": finalQuery,
-	}
-	
-	if ta.catalog != "" {
-		requestBody["catalog"] = ta.catalog
-	}
-	if ta.schema != "" {
-		requestBody["schema"] = ta.schema
+	result := &Result{
+		RowsAffected: res.updateCount,
+		LastInsertID: 0, // Trino doesn't have auto-increment IDs
+		QueryID:      res.queryID,
+		Stats:        &res.stats,
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	// If this was a SELECT that returned data, treat it as if it affected those rows
+	if len(res.rows) > 0 {
+		result.RowsAffected = int64(len(res.rows))
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", ta.serverURL+"/v1/statement", bytes.NewBuffer(jsonBody))
+	return result, nil
+}
+
+// Query executes a query using Trino HTTP API and returns every page of rows
+func (ta *TrinoAdapter) Query(ctx context.Context, query string, args ...interface{}) (*ResultSet, error) {
+	res, err := ta.run(ctx, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Trino-User", "zlay-db")
-	
-	// Set authentication if provided
-	if ta.username != "" && ta.password != "" {
-		req.SetBasicAuth(ta.username, ta.password)
+	result := &ResultSet{
+		Columns: res.columns,
+		Rows:    make([]Row, 0, len(res.rows)),
+		QueryID: res.queryID,
+		Stats:   &res.stats,
 	}
+	for _, data := range res.rows {
+		row := Row{Values: make([]Value, len(res.columns))}
+		for i, col := range res.columns {
+			if i < len(data) {
+				row.Values[i] = convertInterfaceToTrinoValue(data[i], col.Type)
+			} else {
+				row.Values[i] = NewNullValue()
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	result.RowCount = len(result.Rows)
+	return result, nil
+}
 
-	// Send request
-	resp, err := ta.httpClient.Do(req)
+// QueryRow executes a query that returns a single row using Trino HTTP API
+func (ta *TrinoAdapter) QueryRow(ctx context.Context, query string, args ...interface{}) (*Row, error) {
+	result, err := ta.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Trino returned status: %d", resp.StatusCode)
+	if result.RowCount == 0 {
+		return nil, ErrNoRows
 	}
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
+	return &result.Rows[0], nil
+}
+
+// TestConnection tests Trino connection
+func (ta *TrinoAdapter) TestConnection(ctx context.Context) error {
+	_, err := ta.Query(ctx, "SELECT 1")
+	return err
+}
+
+// run submits a statement and follows nextUri until the query has finished.
+// If ctx ends first, the query is cancelled on the server.
+func (ta *TrinoAdapter) run(ctx context.Context, query string, args []interface{}) (*trinoResult, error) {
+	body, prepared, err := buildTrinoStatement(query, args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	// Parse response for DML results
-	var trinoResponse struct {
-		ID          string        `json:"id"`
-		UpdateCount int64         `json:"updateCount"`
-		Rows        []map[string]interface{} `json:"data"`
-		Error       *struct {
-			Message string `json:"message"`
-			Code    string `json:"errorCode"`
-		} `json:"error"`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ta.serverURL+"/v1/statement", strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	ta.setSessionHeaders(req)
+	if prepared != "" {
+		req.Header.Set("X-Trino-Prepared-Statement", trinoPreparedStatement+"="+url.QueryEscape(prepared))
 	}
 
-	if err := json.Unmarshal(body, &trinoResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	page, err := ta.send(ctx, req, body)
+	if err != nil {
+		return nil, err
 	}
 
-	if trinoResponse.Error != nil {
-		return nil, fmt.Errorf("Trino error (%s): %s", trinoResponse.Error.Code, trinoResponse.Error.Message)
+	result := &trinoResult{}
+	for {
+		if err := result.add(page); err != nil {
+			return nil, err
+		}
+		if page.NextURI == "" {
+			return result, nil
+		}
+		// The credentials go along with every follow-up request, so they only go to the server itself
+		if err := ta.checkNextURI(page.NextURI); err != nil {
+			return nil, err
+		}
+
+		if ctx.Err() != nil {
+			ta.cancel(page.NextURI)
+			return nil, ctx.Err()
+		}
+		next, err := http.NewRequestWithContext(ctx, http.MethodGet, page.NextURI, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		ta.setAuth(next)
+
+		nextPage, err := ta.send(ctx, next, "")
+		if err != nil {
+			if ctx.Err() != nil {
+				ta.cancel(page.NextURI)
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		page = nextPage
 	}
+}
 
-	// Return proper result based on what Trino reports
-	result := &Result{
-		RowsAffected: trinoResponse.UpdateCount,
-		LastInsertID: 0, // Trino doesn't have auto-increment IDs
+// add merges a page into the result and converts a reported failure into an error
+func (r *trinoResult) add(page *trinoResponse) error {
+	if page.ID != "" {
+		r.queryID = page.ID
 	}
+	r.stats = page.Stats
 
-	// If this was a SELECT that returned data, treat it as if it affected those rows
-	if len(trinoResponse.Rows) > 0 {
-		result.RowsAffected = int64(len(trinoResponse.Rows))
+	if page.Error != nil {
+		return &TrinoError{
+			QueryID:   r.queryID,
+			Message:   page.Error.Message,
+			ErrorCode: page.Error.ErrorCode,
+			ErrorName: page.Error.ErrorName,
+			ErrorType: page.Error.ErrorType,
+		}
+	}
+	if page.NextURI == "" && page.Stats.State == "FAILED" {
+		return &TrinoError{QueryID: r.queryID, Message: "query failed"}
 	}
 
-	return result, nil
+	if r.columns == nil && len(page.Columns) > 0 {
+		r.columns = make([]Column, 0, len(page.Columns))
+		for _, col := range page.Columns {
+			r.columns = append(r.columns, Column{
+				Name:     col.Name,
+				Type:     mapTrinoTypeToValueType(col.Type),
+				Nullable: true,
+			})
+		}
+	}
+	r.rows = append(r.rows, page.Data...)
+	if page.UpdateCount != nil {
+		r.updateCount = *page.UpdateCount
+	}
+	return nil
 }
 
-// Query executes a query using Trino HTTP API
-func (ta *TrinoAdapter) Query(ctx context.Context, query string, args ...interface{}) (*ResultSet, error) {
-	// Prepare the query
-	finalQuery := query
-	for i, arg := range args {
-		placeholder := fmt.Sprintf("$%d", i+1)
-		finalQuery = strings.ReplaceAll(finalQuery, placeholder, fmt.Sprintf("'%v'", arg))
-	}
+// send performs one protocol request, retrying the statuses Trino uses to ask
+// clients to come back later
+func (ta *TrinoAdapter) send(ctx context.Context, req *http.Request, body string) (*trinoResponse, error) {
+	backoff := 50 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && body != "" {
+			req.Body = io.NopCloser(strings.NewReader(body))
+		}
+
+		resp, err := ta.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
 
-	// Prepare request body
-	requestBody := map[string]interface{}{
-		"query": finalQuery,
+		switch resp.StatusCode {
+		case http.StatusOK:
+			defer resp.Body.Close()
+			ta.updateSession(resp.Header)
+
+			var page trinoResponse
+			decoder := json.NewDecoder(resp.Body)
+			decoder.UseNumber() // keep bigint values exact
+			if err := decoder.Decode(&page); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+			}
+			return &page, nil
+
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			resp.Body.Close()
+			if attempt >= trinoMaxRetries {
+				return nil, fmt.Errorf("Trino returned status: %d", resp.StatusCode)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+
+		default:
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("Trino returned status: %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+		}
 	}
-	
-	if ta.catalog != "" {
-		requestBody["catalog"] = ta.catalog
+}
+
+// cancel asks Trino to stop a query that is no longer wanted. It uses its own
+// context because the caller's has already ended.
+func (ta *TrinoAdapter) cancel(nextURI string) {
+	ctx, cancel := context.WithTimeout(context.Background(), trinoCancelTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, nextURI, nil)
+	if err != nil {
+		return
 	}
-	if ta.schema != "" {
-		requestBody["schema"] = ta.schema
+	ta.setAuth(req)
+	if resp, err := ta.httpClient.Do(req); err == nil {
+		resp.Body.Close()
 	}
+}
 
-	jsonBody, err := json.Marshal(requestBody)
+// checkNextURI refuses a nextUri whose scheme or host differ from the server's
+func (ta *TrinoAdapter) checkNextURI(nextURI string) error {
+	next, err := url.Parse(nextURI)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return fmt.Errorf("invalid nextUri from Trino: %w", err)
 	}
-
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", ta.serverURL+"/v1/statement", bytes.NewBuffer(jsonBody))
+	server, err := url.Parse(ta.serverURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("invalid Trino server URL: %w", err)
+	}
+	if trinoOrigin(next) != trinoOrigin(server) {
+		return fmt.Errorf("trino returned a nextUri on another server: %s://%s", next.Scheme, next.Host)
+	}
+	return nil
+}
+
+// trinoOrigin returns the scheme, host and port of u, with the default port filled in
+func trinoOrigin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
 	}
+	return scheme + "://" + strings.ToLower(u.Hostname()) + ":" + port
+}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Trino-User", "zlay-db")
-	
-	// Set authentication if provided
+func (ta *TrinoAdapter) setAuth(req *http.Request) {
+	user := ta.username
+	if user == "" {
+		user = "zlay-db"
+	}
+	req.Header.Set("X-Trino-User", user)
 	if ta.username != "" && ta.password != "" {
 		req.SetBasicAuth(ta.username, ta.password)
 	}
+}
 
-	// Send request
-	resp, err := ta.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+func (ta *TrinoAdapter) setSessionHeaders(req *http.Request) {
+	ta.setAuth(req)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Trino returned status: %d", resp.StatusCode)
-	}
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if ta.catalog != "" {
+		req.Header.Set("X-Trino-Catalog", ta.catalog)
 	}
-
-	// Parse response
-	var trinoResponse struct {
-		ID     string                 `json:"id"`
-		Data   []map[string]interface{} `json:"data"`
-		Columns []struct {
-			Name string `json:"name"`
-			Type string `json:"type"`
-		} `json:"columns"`
-		Error *struct {
-			Message string `json:"message"`
-		} `json:"error"`
+	if ta.schema != "" {
+		req.Header.Set("X-Trino-Schema", ta.schema)
+	}
+	for name, value := range ta.properties {
+		req.Header.Add("X-Trino-Session", name+"="+url.QueryEscape(value))
 	}
+}
 
-	if err := json.Unmarshal(body, &trinoResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// updateSession applies USE and SET/RESET SESSION results reported by Trino
+func (ta *TrinoAdapter) updateSession(header http.Header) {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	if catalog := header.Get("X-Trino-Set-Catalog"); catalog != "" {
+		ta.catalog = catalog
+	}
+	if schema := header.Get("X-Trino-Set-Schema"); schema != "" {
+		ta.schema = schema
 	}
+	for _, property := range header.Values("X-Trino-Set-Session") {
+		name, value, ok := strings.Cut(property, "=")
+		if !ok {
+			continue
+		}
+		if decoded, err := url.QueryUnescape(value); err == nil {
+			value = decoded
+		}
+		ta.properties[strings.TrimSpace(name)] = value
+	}
+	for _, name := range header.Values("X-Trino-Clear-Session") {
+		delete(ta.properties, strings.TrimSpace(name))
+	}
+}
 
-	if trinoResponse.Error != nil {
-		return nil, fmt.Errorf("Trino error: %s", trinoResponse.Error.Message)
+// buildTrinoStatement returns the statement to POST and, when there are
+// arguments, the prepared statement it executes. $n placeholders become ? in the
+// prepared statement and the arguments are passed as literals to EXECUTE ... USING.
+func buildTrinoStatement(query string, args []interface{}) (body, prepared string, err error) {
+	if len(args) == 0 {
+		return query, "", nil
 	}
 
-	// Convert to our ResultSet
-	result := &ResultSet{}
+	prepared, order, err := rewriteTrinoPlaceholders(query, len(args))
+	if err != nil {
+		return "", "", err
+	}
+	if len(order) == 0 {
+		return "", "", fmt.Errorf("query has %d arguments but no $n placeholders", len(args))
+	}
 
-	// Convert columns
-	for _, col := range trinoResponse.Columns {
-		result.Columns = append(result.Columns, Column{
-			Name:     col.Name,
-			Type:     mapTrinoTypeToValueType(col.Type),
-			Nullable: true,
-		})
+	literals := make([]string, len(order))
+	for i, index := range order {
+		literal, err := trinoLiteral(args[index])
+		if err != nil {
+			return "", "", fmt.Errorf("parameter $%d: %w", index+1, err)
+		}
+		literals[i] = literal
 	}
 
-	// Convert rows
-	for _, rowData := range trinoResponse.Data {
-		row := Row{Values: make([]Value, len(trinoResponse.Columns))}
-		
-		for i, col := range trinoResponse.Columns {
-			if val, exists := rowData[col.Name]; exists {
-				row.Values[i] = convertInterfaceToTrinoValue(val, result.Columns[i].Type)
+	return "EXECUTE " + trinoPreparedStatement + " USING " + strings.Join(literals, ", "), prepared, nil
+}
+
+// rewriteTrinoPlaceholders replaces $n placeholders with ? and returns the zero-based
+// argument index for each ? in order. Placeholders inside string literals, quoted
+// identifiers and comments are left alone.
+func rewriteTrinoPlaceholders(query string, argCount int) (string, []int, error) {
+	var out strings.Builder
+	var order []int
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := skipQuoted(query, i, c)
+			out.WriteString(query[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
 			} else {
-				row.Values[i] = NewNullValue()
+				end += 4
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			n, err := strconv.Atoi(query[i+1 : j])
+			if err != nil || n < 1 || n > argCount {
+				return "", nil, fmt.Errorf("placeholder %s has no matching argument", query[i:j])
 			}
+			order = append(order, n-1)
+			out.WriteByte('?')
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
 		}
-		
-		result.Rows = append(result.Rows, row)
 	}
-
-	result.RowCount = len(result.Rows)
-	return result, nil
+	return out.String(), order, nil
 }
 
-// QueryRow executes a query that returns a single row using Trino HTTP API
-func (ta *TrinoAdapter) QueryRow(ctx context.Context, query string, args ...interface{}) (*Row, error) {
-	result, err := ta.Query(ctx, query, args...)
-	if err != nil {
-		return nil, err
+// skipQuoted returns the index just past the quoted section starting at start,
+// treating a doubled quote character as an escaped quote
+func skipQuoted(query string, start int, quote byte) int {
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != quote {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
 	}
+	return len(query)
+}
 
-	if result.RowCount == 0 {
-		return nil, fmt.Errorf("no rows found")
+// trinoLiteral renders an argument as a Trino SQL literal
+func trinoLiteral(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return trinoDouble(float64(v)), nil
+	case float64:
+		return trinoDouble(v), nil
+	case time.Time:
+		return "TIMESTAMP '" + v.UTC().Format("2006-01-02 15:04:05.000000") + " UTC'", nil
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return "", err
+		}
+		if _, again := value.(driver.Valuer); again {
+			return "", fmt.Errorf("unsupported parameter type %T", arg)
+		}
+		return trinoLiteral(value)
+	default:
+		return "", fmt.Errorf("unsupported parameter type %T", arg)
 	}
+}
 
-	return &result.Rows[0], nil
+func trinoDouble(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan()"
+	case math.IsInf(f, 1):
+		return "infinity()"
+	case math.IsInf(f, -1):
+		return "-infinity()"
+	}
+	// Exponent notation is always parsed as DOUBLE, never as DECIMAL
+	return strconv.FormatFloat(f, 'E', -1, 64)
 }
 
 // mapTrinoTypeToValueType maps Trino data types to our ValueType
 func mapTrinoTypeToValueType(trinoType string) ValueType {
 	switch {
-	case containsIgnoreCase(trinoType, "integer"), containsIgnoreCase(trinoType, "bigint"), containsIgnoreCase(trinoType, "smallint"):
+	case containsIgnoreCase(trinoType, "integer"), containsIgnoreCase(trinoType, "bigint"), containsIgnoreCase(trinoType, "smallint"), containsIgnoreCase(trinoType, "tinyint"):
 		return ValueTypeInteger
 	case containsIgnoreCase(trinoType, "double"), containsIgnoreCase(trinoType, "real"), containsIgnoreCase(trinoType, "decimal"):
 		return ValueTypeFloat
 	case containsIgnoreCase(trinoType, "boolean"):
 		return ValueTypeBoolean
+	case containsIgnoreCase(trinoType, "timestamp"):
+		return ValueTypeTimestamp
 	case containsIgnoreCase(trinoType, "date"):
 		return ValueTypeDate
 	case containsIgnoreCase(trinoType, "time"):
 		return ValueTypeTime
-	case containsIgnoreCase(trinoType, "timestamp"):
-		return ValueTypeTimestamp
-	case containsIgnoreCase(trinoType, "varchar"), containsIgnoreCase(trinoType, "char"):
-		return ValueTypeText
 	case containsIgnoreCase(trinoType, "varbinary"), containsIgnoreCase(trinoType, "binary"):
 		return ValueTypeBinary
+	case containsIgnoreCase(trinoType, "varchar"), containsIgnoreCase(trinoType, "char"):
+		return ValueTypeText
 	default:
 		return ValueTypeText
 	}
@@ -285,7 +649,11 @@ func convertInterfaceToTrinoValue(val interface{}, expectedType ValueType) Value
 	switch expectedType {
 	case ValueTypeInteger:
 		// Parse as integer
-		if intVal, ok := val.(int64); ok {
+		if numVal, ok := val.(json.Number); ok {
+			if intVal, err := numVal.Int64(); err == nil {
+				return NewIntegerValue(intVal)
+			}
+		} else if intVal, ok := val.(int64); ok {
 			return NewIntegerValue(intVal)
 		} else if intVal, ok := val.(int); ok {
 			return NewIntegerValue(int64(intVal))
@@ -297,8 +665,12 @@ func convertInterfaceToTrinoValue(val interface{}, expectedType ValueType) Value
 			}
 		}
 	case ValueTypeFloat:
-		// Parse as float
-		if floatVal, ok := val.(float64); ok {
+		// Parse as float; decimals arrive as strings
+		if numVal, ok := val.(json.Number); ok {
+			if floatVal, err := numVal.Float64(); err == nil {
+				return NewFloatValue(floatVal)
+			}
+		} else if floatVal, ok := val.(float64); ok {
 			return NewFloatValue(floatVal)
 		} else if intVal, ok := val.(int64); ok {
 			return NewFloatValue(float64(intVal))
@@ -323,14 +695,20 @@ func convertInterfaceToTrinoValue(val interface{}, expectedType ValueType) Value
 		} else if strVal, ok := val.(string); ok {
 			if tsVal, err := time.Parse(time.RFC3339, strVal); err == nil {
 				return NewTimestampValue(tsVal)
-			} else if tsVal, err := time.Parse("2006-01-02 15:04:05", strVal); err == nil {
+			} else if tsVal, err := time.Parse("2006-01-02 15:04:05.999999999", strVal); err == nil {
+				return NewTimestampValue(tsVal)
+			} else if tsVal, err := time.Parse("2006-01-02 15:04:05.999999999 MST", strVal); err == nil {
 				return NewTimestampValue(tsVal)
 			}
 		}
 	case ValueTypeBinary:
-		// Parse as binary
+		// Trino sends varbinary base64 encoded
 		if bytesVal, ok := val.([]byte); ok {
 			return NewBinaryValue(bytesVal)
+		} else if strVal, ok := val.(string); ok {
+			if bytesVal, err := base64.StdEncoding.DecodeString(strVal); err == nil {
+				return NewBinaryValue(bytesVal)
+			}
 		}
 	default:
 		// Default to text
@@ -344,9 +722,3 @@ func convertInterfaceToTrinoValue(val interface{}, expectedType ValueType) Value
 	// Fallback to text
 	return NewTextValue(fmt.Sprintf("%v", val))
 }
-
-// TestConnection tests Trino connection
-func (ta *TrinoAdapter) TestConnection(ctx context.Context) error {
-	_, err := ta.Query(ctx, "SELECT 1")
-	return err
-}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTrino serves a scripted sequence of statement pages
+type fakeTrino struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	pages     []map[string]interface{}
+	served    int
+	statement string
+	headers   http.Header
+	cancelled chan struct{}
+	// block makes page requests wait until the client gives up
+	block     bool
+	throttled int
+}
+
+func newFakeTrino(t *testing.T, pages ...map[string]interface{}) *fakeTrino {
+	f := &fakeTrino{t: t, pages: pages, cancelled: make(chan struct{}, 1)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeTrino) handle(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/statement":
+		body, _ := io.ReadAll(r.Body)
+		f.statement = string(body)
+		f.headers = r.Header.Clone()
+		if f.throttled > 0 {
+			f.throttled--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	case r.Method == http.MethodDelete:
+		f.cancelled <- struct{}{}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/statement/executing/"):
+		if f.block {
+			f.mutex.Unlock()
+			<-r.Context().Done()
+			f.mutex.Lock()
+			return
+		}
+	default:
+		f.t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	page := map[string]interface{}{"id": "query-1", "stats": map[string]interface{}{"state": "RUNNING"}}
+	if f.served < len(f.pages) {
+		for k, v := range f.pages[f.served] {
+			page[k] = v
+		}
+	}
+	f.served++
+	if f.served < len(f.pages) {
+		page["nextUri"] = fmt.Sprintf("%s/v1/statement/executing/query-1/%d", f.server.URL, f.served)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+var trinoColumns = []map[string]interface{}{
+	{"name": "id", "type": "bigint"},
+	{"name": "name", "type": "varchar"},
+	{"name": "created", "type": "timestamp(3)"},
+}
+
+func TestTrinoQueryFollowsNextURI(t *testing.T) {
+	fake := newFakeTrino(t,
+		map[string]interface{}{"stats": map[string]interface{}{"state": "QUEUED", "queued": true}},
+		map[string]interface{}{"columns": trinoColumns, "data": [][]interface{}{{9007199254740993, "a", "2024-01-02 03:04:05.678"}}},
+		map[string]interface{}{"columns": trinoColumns, "data": [][]interface{}{{2, "b", nil}, {3, "c", nil}}},
+		map[string]interface{}{"stats": map[string]interface{}{"state": "FINISHED", "processedRows": 3, "elapsedTimeMillis": 42}},
+	)
+	adapter := NewTrinoAdapter(fake.server.URL+"?catalog=hive&schema=sales", "analyst", "", "", "")
+
+	result, err := adapter.Query(context.Background(), "SELECT id, name, created FROM orders")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if result.RowCount != 3 || len(result.Columns) != 3 {
+		t.Fatalf("Expected 3 rows from every page, got %d rows and %d columns", result.RowCount, len(result.Columns))
+	}
+	if id, _ := result.Rows[0].Values[0].AsInt64(); id != 9007199254740993 {
+		t.Errorf("Expected bigint to be kept exact, got %d", id)
+	}
+	if ts, ok := result.Rows[0].Values[2].AsTimestamp(); !ok || ts.Time.Nanosecond() != 678000000 {
+		t.Errorf("Expected timestamp with milliseconds, got %+v", result.Rows[0].Values[2])
+	}
+	if name, _ := result.Rows[2].Values[1].AsString(); name != "c" {
+		t.Errorf("Expected last row from the final data page, got %q", name)
+	}
+	if result.QueryID != "query-1" || result.Stats == nil || result.Stats.State != "FINISHED" || result.Stats.ProcessedRows != 3 {
+		t.Errorf("Expected query ID and final stats, got %q %+v", result.QueryID, result.Stats)
+	}
+
+	if fake.headers.Get("X-Trino-User") != "analyst" || fake.headers.Get("X-Trino-Catalog") != "hive" || fake.headers.Get("X-Trino-Schema") != "sales" {
+		t.Errorf("Unexpected session headers: %v", fake.headers)
+	}
+}
+
+func TestTrinoQueryReportsFailure(t *testing.T) {
+	fake := newFakeTrino(t,
+		map[string]interface{}{},
+		map[string]interface{}{
+			"stats": map[string]interface{}{"state": "FAILED"},
+			"error": map[string]interface{}{"message": "line 1:15: Table 'orders' does not exist", "errorCode": 46, "errorName": "TABLE_NOT_FOUND", "errorType": "USER_ERROR"},
+		},
+	)
+	adapter := NewTrinoAdapter(fake.server.URL, "", "", "", "")
+
+	_, err := adapter.Query(context.Background(), "SELECT * FROM orders")
+	var trinoErr *TrinoError
+	if !errors.As(err, &trinoErr) {
+		t.Fatalf("Expected a TrinoError, got %v", err)
+	}
+	if trinoErr.ErrorName != "TABLE_NOT_FOUND" || trinoErr.QueryID != "query-1" {
+		t.Errorf("Unexpected error details: %+v", trinoErr)
+	}
+}
+
+func TestTrinoQueryRetriesServiceUnavailable(t *testing.T) {
+	fake := newFakeTrino(t, map[string]interface{}{"updateCount": 7, "stats": map[string]interface{}{"state": "FINISHED"}})
+	fake.throttled = 2
+	adapter := NewTrinoAdapter(fake.server.URL, "", "", "", "")
+
+	result, err := adapter.Execute(context.Background(), "DELETE FROM orders WHERE id = $1", 1)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.RowsAffected != 7 || result.QueryID != "query-1" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if fake.statement != "EXECUTE zlay_stmt USING 1" {
+		t.Errorf("Expected the retried request to resend the statement, got %q", fake.statement)
+	}
+}
+
+func TestTrinoQueryCancelsOnContextDone(t *testing.T) {
+	fake := newFakeTrino(t, map[string]interface{}{}, map[string]interface{}{})
+	fake.block = true
+	adapter := NewTrinoAdapter(fake.server.URL, "", "", "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := adapter.Query(ctx, "SELECT * FROM big_table"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline error, got %v", err)
+	}
+
+	select {
+	case <-fake.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the query to be cancelled with DELETE")
+	}
+}
+
+func TestTrinoQueryRefusesNextURIOnAnotherServer(t *testing.T) {
+	var requests int
+	var mutex sync.Mutex
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+	}))
+	t.Cleanup(other.Close)
+
+	otherURL, _ := url.Parse(other.URL)
+	fake := newFakeTrino(t, map[string]interface{}{})
+	serverURL, _ := url.Parse(fake.server.URL)
+	for _, nextURI := range []string{
+		other.URL + "/v1/statement/executing/query-1/1",
+		"https://" + serverURL.Host + "/v1/statement/executing/query-1/1",
+		"http://" + serverURL.Hostname() + ":" + otherURL.Port() + "/v1/statement/executing/query-1/1",
+	} {
+		fake.mutex.Lock()
+		fake.pages = []map[string]interface{}{{"nextUri": nextURI}}
+		fake.served = 0
+		fake.mutex.Unlock()
+
+		adapter := NewTrinoAdapter(fake.server.URL, "analyst", "secret", "", "")
+		if _, err := adapter.Query(context.Background(), "SELECT 1"); err == nil || !strings.Contains(err.Error(), "another server") {
+			t.Errorf("%s: expected the nextUri to be refused, got %v", nextURI, err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if requests != 0 {
+		t.Errorf("Expected no request to the other server, got %d", requests)
+	}
+}
+
+func TestTrinoParametersArePreparedAndEscaped(t *testing.T) {
+	fake := newFakeTrino(t, map[string]interface{}{"columns": trinoColumns, "data": [][]interface{}{}})
+	adapter := NewTrinoAdapter(fake.server.URL, "", "", "", "")
+
+	_, err := adapter.Query(context.Background(),
+		"SELECT * FROM orders WHERE name = $1 AND note <> '$2 stays' AND id IN ($2, $2) -- $1\nAND created > $3",
+		"O'Brien'; DROP TABLE orders; --", int64(42), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	prepared, err := url.QueryUnescape(strings.TrimPrefix(fake.headers.Get("X-Trino-Prepared-Statement"), "zlay_stmt="))
+	if err != nil {
+		t.Fatalf("Invalid prepared statement header: %v", err)
+	}
+	wantPrepared := "SELECT * FROM orders WHERE name = ? AND note <> '$2 stays' AND id IN (?, ?) -- $1\nAND created > ?"
+	if prepared != wantPrepared {
+		t.Errorf("Unexpected prepared statement:\n got %q\nwant %q", prepared, wantPrepared)
+	}
+	wantStatement := "EXECUTE zlay_stmt USING 'O''Brien''; DROP TABLE orders; --', 42, 42, TIMESTAMP '2024-01-02 03:04:05.000000 UTC'"
+	if fake.statement != wantStatement {
+		t.Errorf("Unexpected statement:\n got %q\nwant %q", fake.statement, wantStatement)
+	}
+}
+
+func TestTrinoParameterErrors(t *testing.T) {
+	tests := map[string]struct {
+		query string
+		args  []interface{}
+	}{
+		"placeholder without argument":   {"SELECT $2", []interface{}{1}},
+		"arguments without placeholders": {"SELECT 1", []interface{}{1}},
+		"unsupported type":               {"SELECT $1", []interface{}{struct{}{}}},
+	}
+	for name, tt := range tests {
+		if _, _, err := buildTrinoStatement(tt.query, tt.args); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTrinoSessionHeadersArePersisted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Trino-Session") {
+		case "":
+			w.Header().Add("X-Trino-Set-Session", "query_max_run_time=5m")
+			w.Header().Set("X-Trino-Set-Schema", "archive")
+		case "query_max_run_time=5m":
+			if r.Header.Get("X-Trino-Schema") != "archive" {
+				t.Errorf("Expected the schema from USE, got %q", r.Header.Get("X-Trino-Schema"))
+			}
+			w.Header().Add("X-Trino-Clear-Session", "query_max_run_time")
+		default:
+			t.Errorf("Unexpected session header %q", r.Header.Get("X-Trino-Session"))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "q", "stats": map[string]interface{}{"state": "FINISHED"}})
+	}))
+	defer server.Close()
+
+	adapter := NewTrinoAdapter(server.URL, "", "", "hive", "default")
+	for _, stmt := range []string{"SET SESSION query_max_run_time = '5m'", "RESET SESSION query_max_run_time", "SELECT 1"} {
+		if _, err := adapter.Execute(context.Background(), stmt); err != nil {
+			t.Fatalf("%s failed: %v", stmt, err)
+		}
+	}
+}
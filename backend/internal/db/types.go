@@ -2,9 +2,12 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -220,6 +223,15 @@ func (v Value) AsBool() (bool, bool) {
 	if v.Type == ValueTypeBoolean && v.Valid {
 		return v.Data.(bool), true
 	}
+	// MySQL stores BOOLEAN as TINYINT(1)
+	if v.Type == ValueTypeInteger && v.Valid {
+		switch v.Data.(int64) {
+		case 0:
+			return false, true
+		case 1:
+			return true, true
+		}
+	}
 	return false, false
 }
 
@@ -228,6 +240,9 @@ func (v Value) AsBytes() ([]byte, bool) {
 	if v.Type == ValueTypeBinary && v.Valid {
 		return v.Data.([]byte), true
 	}
+	if v.Type == ValueTypeText && v.Valid {
+		return []byte(v.Data.(string)), true
+	}
 	return nil, false
 }
 
@@ -247,22 +262,97 @@ func (v Value) AsTime() (Time, bool) {
 	return Time{}, false
 }
 
-// AsTimestamp returns value as Timestamp
+// timestampLayouts are the text forms drivers return for timestamp and
+// timestamptz columns; fractional seconds are optional in each
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// AsTimestamp returns value as Timestamp. Besides native timestamps it accepts
+// time.Time and the text forms in timestampLayouts, as text or bytes, so
+// callers need no fallback parsing; text without a zone is read as UTC.
 func (v Value) AsTimestamp() (Timestamp, bool) {
-	if v.Type == ValueTypeTimestamp && v.Valid {
-		return v.Data.(Timestamp), true
+	if !v.Valid {
+		return Timestamp{}, false
+	}
+
+	switch data := v.Data.(type) {
+	case Timestamp:
+		return data, true
+	case time.Time:
+		return Timestamp{Time: data}, true
+	case string:
+		return parseTimestamp(data)
+	case []byte:
+		return parseTimestamp(string(data))
+	}
+	return Timestamp{}, false
+}
+
+func parseTimestamp(text string) (Timestamp, bool) {
+	text = strings.TrimSpace(text)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return Timestamp{Time: t}, true
+		}
 	}
 	return Timestamp{}, false
 }
 
-// AsUUID returns value as UUID
+// AsUUID returns value as UUID. It accepts native UUIDs, text, 16-byte binary
+// and the ASCII bytes PostgreSQL sends for uuid columns read as binary.
 func (v Value) AsUUID() (uuid.UUID, bool) {
-	if v.Type == ValueTypeUUID && v.Valid {
-		return v.Data.(uuid.UUID), true
+	if !v.Valid {
+		return uuid.Nil, false
+	}
+
+	switch data := v.Data.(type) {
+	case uuid.UUID:
+		return data, true
+	case string:
+		if parsed, err := uuid.Parse(strings.TrimSpace(data)); err == nil {
+			return parsed, true
+		}
+	case []byte:
+		if len(data) == 16 {
+			parsed, err := uuid.FromBytes(data)
+			return parsed, err == nil
+		}
+		if parsed, err := uuid.ParseBytes(data); err == nil {
+			return parsed, true
+		}
 	}
 	return uuid.Nil, false
 }
 
+// AsJSON returns the raw JSON of a JSON/JSONB or JSON array column, which
+// drivers return as bytes or as text depending on the database
+func (v Value) AsJSON() (json.RawMessage, bool) {
+	if !v.Valid {
+		return nil, false
+	}
+
+	var raw []byte
+	switch data := v.Data.(type) {
+	case []byte:
+		raw = data
+	case string:
+		raw = []byte(data)
+	default:
+		return nil, false
+	}
+	if !json.Valid(raw) {
+		return nil, false
+	}
+	return json.RawMessage(raw), true
+}
+
 // IsNull returns true if value is null
 func (v Value) IsNull() bool {
 	return v.Type == ValueTypeNull || !v.Valid
@@ -273,6 +363,8 @@ type ResultSet struct {
 	Rows     []Row
 	Columns  []Column
 	RowCount int
+	QueryID  string      // Set by engines that identify queries, e.g. Trino
+	Stats    *QueryStats // Execution statistics when the engine reports them
 }
 
 // Row represents a database row
@@ -353,7 +445,7 @@ func mapSQLTypeToValueType(sqlType string) ValueType {
 		return ValueTypeTimestamp
 	case containsIgnoreCase(sqlType, "uuid"):
 		return ValueTypeUUID
-	case containsIgnoreCase(sqlType, "blob"), containsIgnoreCase(sqlType, "binary"):
+	case containsIgnoreCase(sqlType, "blob"), containsIgnoreCase(sqlType, "binary"), containsIgnoreCase(sqlType, "bytea"):
 		// Special case: if SQL type name contains "uuid" but detected as binary, treat as UUID
 		if containsIgnoreCase(sqlType, "uuid") {
 			return ValueTypeUUID
@@ -405,6 +497,22 @@ func convertSQLValueToValue(val interface{}, expectedType ValueType) Value {
 				}
 			}
 		}
+		// MySQL returns every column of a query without arguments as text
+		// bytes, and character columns always; pgx returns JSON as bytes
+		switch expectedType {
+		case ValueTypeText:
+			if utf8.Valid(v) {
+				return NewTextValue(string(v))
+			}
+		case ValueTypeInteger:
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				return NewIntegerValue(n)
+			}
+		case ValueTypeFloat:
+			if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+				return NewFloatValue(f)
+			}
+		}
 		// Any 16-byte array is a UUID - convert it regardless of expected type
 		if len(v) == 16 {
 			var parsedUUID uuid.UUID
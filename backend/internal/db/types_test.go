@@ -0,0 +1,130 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValueAsUUID(t *testing.T) {
+	id := uuid.MustParse("6f1c2d3e-4a5b-4c6d-8e9f-0a1b2c3d4e5f")
+
+	tests := []struct {
+		name  string
+		value Value
+		ok    bool
+	}{
+		{"native", NewUUIDValue(id), true},
+		{"text", NewTextValue(id.String()), true},
+		{"text with braces", NewTextValue("{" + id.String() + "}"), true},
+		{"16-byte binary", NewBinaryValue(id[:]), true},
+		{"ASCII binary", NewBinaryValue([]byte(id.String())), true},
+		{"invalid text", NewTextValue("client-1"), false},
+		{"invalid binary", NewBinaryValue([]byte{1, 2, 3}), false},
+		{"integer", NewIntegerValue(7), false},
+		{"null", NewNullValue(), false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.value.AsUUID()
+		if ok != tt.ok {
+			t.Errorf("%s: expected ok=%t, got %t", tt.name, tt.ok, ok)
+			continue
+		}
+		if ok && got != id {
+			t.Errorf("%s: expected %s, got %s", tt.name, id, got)
+		}
+		if !ok && got != uuid.Nil {
+			t.Errorf("%s: expected uuid.Nil on failure, got %s", tt.name, got)
+		}
+	}
+}
+
+func TestValueAsTimestamp(t *testing.T) {
+	want := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value Value
+		ok    bool
+	}{
+		{"native", NewTimestampValue(want), true},
+		{"time.Time", Value{Type: ValueTypeTimestamp, Data: want, Valid: true}, true},
+		{"RFC3339", NewTextValue("2024-01-15T10:30:00Z"), true},
+		{"RFC3339 with offset", NewTextValue("2024-01-15T12:30:00+02:00"), true},
+		{"PostgreSQL with numeric zone", NewTextValue("2024-01-15 10:30:00 +0000"), true},
+		{"without zone", NewTextValue("2024-01-15 10:30:00"), true},
+		{"timestamptz text", NewTextValue("2024-01-15 11:30:00.000000+01"), true},
+		{"Go time string", NewTextValue("2024-01-15 10:30:00 +0000 UTC"), true},
+		{"as bytes", NewBinaryValue([]byte("2024-01-15 10:30:00")), true},
+		{"not a time", NewTextValue("yesterday"), false},
+		{"integer", NewIntegerValue(1705314600), false},
+		{"null", NewNullValue(), false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.value.AsTimestamp()
+		if ok != tt.ok {
+			t.Errorf("%s: expected ok=%t, got %t", tt.name, tt.ok, ok)
+			continue
+		}
+		if ok && !got.Time.Equal(want) {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, got.Time)
+		}
+	}
+}
+
+func TestValueAsJSON(t *testing.T) {
+	for _, value := range []Value{NewBinaryValue([]byte(`{"a":1}`)), NewTextValue(`[1,2]`)} {
+		if raw, ok := value.AsJSON(); !ok || len(raw) == 0 {
+			t.Errorf("Expected %v to be JSON", value.Data)
+		}
+	}
+	for _, value := range []Value{NewTextValue("{a,b}"), NewIntegerValue(1), NewNullValue()} {
+		if _, ok := value.AsJSON(); ok {
+			t.Errorf("Expected %v not to be JSON", value.Data)
+		}
+	}
+}
+
+func TestConvertMySQLTextBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		val      []byte
+		sqlType  string
+		expected Value
+	}{
+		{"varchar", []byte("Development Client"), "VARCHAR", NewTextValue("Development Client")},
+		{"16-character text is not a UUID", []byte("abcdefghijklmnop"), "VARCHAR", NewTextValue("abcdefghijklmnop")},
+		{"json", []byte(`["gpt-4o"]`), "JSON", NewTextValue(`["gpt-4o"]`)},
+		{"integer without arguments", []byte("42"), "BIGINT", NewIntegerValue(42)},
+		{"float without arguments", []byte("0.5"), "DOUBLE", NewFloatValue(0.5)},
+		{"blob", []byte{0xff, 0x00}, "BLOB", NewBinaryValue([]byte{0xff, 0x00})},
+		{"bytea", []byte("raw"), "BYTEA", NewBinaryValue([]byte("raw"))},
+	}
+	for _, tt := range tests {
+		got := convertSQLValueToValue(tt.val, mapSQLTypeToValueType(tt.sqlType))
+		if got.Type != tt.expected.Type || fmt.Sprint(got.Data) != fmt.Sprint(tt.expected.Data) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestValueAsBoolAcceptsTinyInt(t *testing.T) {
+	for _, tt := range []struct {
+		value    Value
+		expected bool
+		ok       bool
+	}{
+		{NewBooleanValue(true), true, true},
+		{NewIntegerValue(1), true, true},
+		{NewIntegerValue(0), false, true},
+		{NewIntegerValue(2), false, false},
+		{NewTextValue("true"), false, false},
+	} {
+		got, ok := tt.value.AsBool()
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("%+v: expected (%t, %t), got (%t, %t)", tt.value, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
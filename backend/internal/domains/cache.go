@@ -0,0 +1,125 @@
+package domains
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// DefaultNegativeTTL is how long a host no active domain matches is remembered
+	DefaultNegativeTTL = 60 * time.Second
+	// maxMisses bounds how many unknown hosts are remembered at once
+	maxMisses = 10000
+)
+
+// Cache remembers which client request hosts resolve to. Found clients are
+// kept by the normalized domain that matched, so one wildcard entry serves all
+// of its subdomains, until Clear. Hosts no domain matches are kept for the
+// negative TTL, so repeated requests from unknown hosts do not reach the
+// database.
+type Cache struct {
+	mutex       sync.RWMutex
+	entries     map[string]uuid.UUID // normalized_domain -> client
+	misses      map[string]time.Time // host -> when it is looked up again
+	negativeTTL time.Duration
+	now         func() time.Time
+}
+
+// NewCache creates an empty cache; a non-positive negativeTTL uses DefaultNegativeTTL
+func NewCache(negativeTTL time.Duration) *Cache {
+	if negativeTTL <= 0 {
+		negativeTTL = DefaultNegativeTTL
+	}
+	return &Cache{
+		entries:     map[string]uuid.UUID{},
+		misses:      map[string]time.Time{},
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+	}
+}
+
+// Get returns the cached client of a normalized host. cached is false when
+// the database has to be asked; a cached miss returns found false.
+func (c *Cache) Get(host string) (clientID uuid.UUID, found, cached bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if clientID, ok := Match(c.entries, host); ok {
+		return clientID, true, true
+	}
+	if expires, ok := c.misses[host]; ok && c.now().Before(expires) {
+		return uuid.Nil, false, true
+	}
+	return uuid.Nil, false, false
+}
+
+// Put caches the client of a normalized domain
+func (c *Cache) Put(domain string, clientID uuid.UUID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[domain] = clientID
+}
+
+// putMiss remembers that no domain matches host. When too many hosts are
+// remembered, expired ones are dropped, and all of them if none had expired.
+func (c *Cache) putMiss(host string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	if len(c.misses) >= maxMisses {
+		for missed, expires := range c.misses {
+			if !now.Before(expires) {
+				delete(c.misses, missed)
+			}
+		}
+		if len(c.misses) >= maxMisses {
+			c.misses = map[string]time.Time{}
+		}
+	}
+	c.misses[host] = now.Add(c.negativeTTL)
+}
+
+// Resolve returns the client of a normalized host from the cache, looking it
+// up in the database on a miss and caching the answer. Errors are not cached.
+func (c *Cache) Resolve(ctx context.Context, conn tools.DBConnection, host string) (uuid.UUID, bool, error) {
+	if clientID, found, cached := c.Get(host); cached {
+		return clientID, found, nil
+	}
+
+	clientID, domain, found, err := Lookup(ctx, conn, host)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if !found {
+		c.putMiss(host)
+		return uuid.Nil, false, nil
+	}
+	c.Put(domain, clientID)
+	return clientID, true, nil
+}
+
+// Clear forgets every cached client and miss, for when domains or clients change
+func (c *Cache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = map[string]uuid.UUID{}
+	c.misses = map[string]time.Time{}
+}
+
+// Len returns how many domains have a cached client
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
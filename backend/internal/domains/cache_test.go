@@ -0,0 +1,234 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+// countingConn counts the queries that reach the database
+type countingConn struct {
+	tools.DBConnection
+	queries int
+}
+
+func (c *countingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.queries++
+	return c.DBConnection.Query(ctx, query, args...)
+}
+
+// newDomainsDB returns a migrated database holding the given active domains,
+// plus count generated ones spread over a hundred other clients
+func newDomainsDB(t testing.TB, entries map[string]uuid.UUID, count int) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	ctx := context.Background()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	clients := map[uuid.UUID]bool{}
+	insert := func(domain string, clientID uuid.UUID) {
+		if !clients[clientID] {
+			clients[clientID] = true
+			if _, err := tx.ExecContext(ctx, "INSERT INTO clients (id, name, slug) VALUES ($1, $1, $1)", clientID.String()); err != nil {
+				t.Fatalf("Failed to insert client: %v", err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO domains (id, client_id, domain, normalized_domain, is_active) VALUES ($1, $2, $3, $3, true)",
+			uuid.NewString(), clientID.String(), domain); err != nil {
+			t.Fatalf("Failed to insert domain: %v", err)
+		}
+	}
+	for domain, clientID := range entries {
+		insert(domain, clientID)
+	}
+	others := make([]uuid.UUID, 100)
+	for i := range others {
+		others[i] = uuid.New()
+	}
+	for i := 0; i < count; i++ {
+		domain := fmt.Sprintf("shop-%d.tenant-%d.example", i, i%100)
+		if i%10 == 0 {
+			domain = fmt.Sprintf("*.tenant-%d.brand-%d.example", i, i%100)
+		}
+		insert(domain, others[i%100])
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return conn
+}
+
+func TestLookupUsesNormalizedDomain(t *testing.T) {
+	apex, wildcard, eu := uuid.New(), uuid.New(), uuid.New()
+	conn := newDomainsDB(t, map[string]uuid.UUID{
+		"example.com":      apex,
+		"*.example.com":    wildcard,
+		"*.eu.example.com": eu,
+	}, 0)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "INSERT INTO domains (id, client_id, domain, normalized_domain, is_active) VALUES ('off', $1, 'off.example.org', 'off.example.org', false)", apex.String()); err != nil {
+		t.Fatalf("Failed to insert domain: %v", err)
+	}
+
+	tests := []struct {
+		host   string
+		want   uuid.UUID
+		domain string
+	}{
+		{"example.com", apex, "example.com"},
+		{"app.example.com", wildcard, "*.example.com"},
+		{"de.eu.example.com", eu, "*.eu.example.com"},
+		{"off.example.org", uuid.Nil, ""},
+		{"unknown.test", uuid.Nil, ""},
+	}
+	for _, tt := range tests {
+		clientID, domain, found, err := Lookup(ctx, conn, tt.host)
+		if err != nil {
+			t.Fatalf("Lookup(%q) failed: %v", tt.host, err)
+		}
+		if found != (tt.want != uuid.Nil) || clientID != tt.want || domain != tt.domain {
+			t.Errorf("Lookup(%q) = %s, %q, %t; want %s, %q", tt.host, clientID, domain, found, tt.want, tt.domain)
+		}
+	}
+}
+
+func TestCacheRemembersHostsAndMisses(t *testing.T) {
+	wildcard := uuid.New()
+	conn := &countingConn{DBConnection: newDomainsDB(t, map[string]uuid.UUID{"*.example.com": wildcard}, 0)}
+	cache := NewCache(time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	resolve := func(host string) (uuid.UUID, bool) {
+		t.Helper()
+		clientID, found, err := cache.Resolve(ctx, conn, host)
+		if err != nil {
+			t.Fatalf("Resolve(%q) failed: %v", host, err)
+		}
+		return clientID, found
+	}
+
+	// A wildcard found for one host serves its other subdomains from the cache
+	if clientID, found := resolve("a.example.com"); !found || clientID != wildcard {
+		t.Fatalf("Expected the wildcard's client, got %s, %t", clientID, found)
+	}
+	if clientID, found := resolve("b.example.com"); !found || clientID != wildcard || conn.queries != 1 {
+		t.Errorf("Expected a cached hit, got %s, %t after %d queries", clientID, found, conn.queries)
+	}
+
+	// An unknown host is asked for once while its miss is remembered
+	for i := 0; i < 3; i++ {
+		if _, found := resolve("unknown.test"); found {
+			t.Fatal("Expected no client for an unknown host")
+		}
+	}
+	if conn.queries != 2 {
+		t.Errorf("Expected one query for the repeated miss, got %d queries", conn.queries)
+	}
+
+	// Once the miss expires, a domain added since is found
+	if _, err := conn.Exec(ctx, "INSERT INTO domains (id, client_id, domain, normalized_domain, is_active) VALUES ('new', $1, 'unknown.test', 'unknown.test', true)", wildcard.String()); err != nil {
+		t.Fatalf("Failed to insert domain: %v", err)
+	}
+	now = now.Add(59 * time.Second)
+	if _, found := resolve("unknown.test"); found || conn.queries != 2 {
+		t.Errorf("Expected the miss to still be cached, got %t after %d queries", found, conn.queries)
+	}
+	now = now.Add(time.Second)
+	if clientID, found := resolve("unknown.test"); !found || clientID != wildcard || conn.queries != 3 {
+		t.Errorf("Expected the expired miss to be looked up again, got %s, %t after %d queries", clientID, found, conn.queries)
+	}
+
+	// Clear forgets both kinds of entries
+	cache.Clear()
+	if _, _, cached := cache.Get("a.example.com"); cached || cache.Len() != 0 {
+		t.Error("Expected an empty cache after Clear")
+	}
+}
+
+func TestCacheBoundsMisses(t *testing.T) {
+	cache := NewCache(time.Minute)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < maxMisses; i++ {
+		cache.putMiss(fmt.Sprintf("old-%d.test", i))
+	}
+	now = now.Add(time.Minute)
+	cache.putMiss("new.test")
+	if len(cache.misses) != 1 {
+		t.Errorf("Expected expired misses to be dropped, %d remain", len(cache.misses))
+	}
+}
+
+// fullScanLookup is how hosts were resolved before normalized_domain: every
+// active domain read and matched in Go
+func fullScanLookup(ctx context.Context, conn tools.DBConnection, host string) (uuid.UUID, bool, error) {
+	rows, err := conn.Query(ctx, "SELECT client_id, domain FROM domains WHERE is_active = true")
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer rows.Close()
+
+	entries := map[string]uuid.UUID{}
+	for rows.Next() {
+		var clientID, domain string
+		if err := rows.Scan(&clientID, &domain); err != nil {
+			return uuid.Nil, false, err
+		}
+		if parsed, err := uuid.Parse(clientID); err == nil {
+			entries[domain] = parsed
+		}
+	}
+	clientID, found := Match(entries, host)
+	return clientID, found, rows.Err()
+}
+
+const benchmarkDomains = 10000
+
+func BenchmarkLookupFullScan(b *testing.B) {
+	conn := newDomainsDB(b, nil, benchmarkDomains)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := fullScanLookup(ctx, conn, "www.unknown-host.test"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLookupIndexed(b *testing.B) {
+	conn := newDomainsDB(b, nil, benchmarkDomains)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := Lookup(ctx, conn, "www.unknown-host.test"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCacheNegative(b *testing.B) {
+	conn := newDomainsDB(b, nil, benchmarkDomains)
+	cache := NewCache(DefaultNegativeTTL)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := cache.Resolve(ctx, conn, "www.unknown-host.test"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
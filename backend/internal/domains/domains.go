@@ -0,0 +1,232 @@
+// Package domains normalizes the domains clients are resolved by and matches
+// request hosts against them. Domains are stored lowercased and in punycode,
+// without scheme, port or path, so a host taken from an Origin, Referer or
+// Host header can be compared as a string. A stored "*.example.com" matches
+// every subdomain of example.com, at any depth, but not example.com itself.
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/idna"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+// WildcardPrefix marks a domain that matches the subdomains of the rest
+const WildcardPrefix = "*."
+
+// ErrInvalidDomain is matched by every InvalidError
+var ErrInvalidDomain = errors.New("invalid domain")
+
+// InvalidError reports why a domain was refused
+type InvalidError struct {
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return "invalid domain: " + e.Reason
+}
+
+// Is makes errors.Is(err, ErrInvalidDomain) match invalid domains
+func (e *InvalidError) Is(target error) bool {
+	return target == ErrInvalidDomain
+}
+
+// profile converts IDNs to punycode and enforces hostname syntax and DNS lengths
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+)
+
+// Normalize turns what an admin typed, such as "https://App.Example.com:8443/",
+// into the stored form "app.example.com". A leading "*." is kept for wildcard
+// domains, which need at least two labels after it.
+func Normalize(input string) (string, error) {
+	host := strings.TrimSpace(input)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if strings.HasPrefix(host, "[") {
+		return "", &InvalidError{Reason: "IP addresses are not supported"}
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		if port := host[i+1:]; port == "" || strings.Trim(port, "0123456789") != "" {
+			return "", &InvalidError{Reason: "the port is not a number"}
+		}
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", &InvalidError{Reason: "no host name given"}
+	}
+
+	wildcard := strings.HasPrefix(host, WildcardPrefix)
+	host = strings.TrimPrefix(host, WildcardPrefix)
+	if strings.Contains(host, "*") {
+		return "", &InvalidError{Reason: "a wildcard is only allowed as the first label"}
+	}
+
+	ascii, err := profile.ToASCII(host)
+	if err != nil {
+		return "", &InvalidError{Reason: "not a valid host name"}
+	}
+	if !wildcard {
+		return ascii, nil
+	}
+	if !strings.Contains(ascii, ".") {
+		return "", &InvalidError{Reason: "a wildcard needs a parent domain of at least two labels"}
+	}
+	return WildcardPrefix + ascii, nil
+}
+
+// Host returns the normalized host of an Origin or Referer URL or a Host
+// header, or "" when there is none. Wildcards are not hosts.
+func Host(value string) string {
+	host, err := Normalize(value)
+	if err != nil || strings.HasPrefix(host, WildcardPrefix) {
+		return ""
+	}
+	return host
+}
+
+// Match looks a normalized host up in entries keyed by normalized domain. An
+// exact entry wins; otherwise the wildcard with the longest matching suffix
+// does, so "*.eu.example.com" is preferred to "*.example.com".
+func Match[V any](entries map[string]V, host string) (V, bool) {
+	_, value, ok := match(entries, host)
+	return value, ok
+}
+
+// match is Match that also returns the normalized domain of the entry found
+func match[V any](entries map[string]V, host string) (string, V, bool) {
+	for _, domain := range Candidates(host) {
+		if value, ok := entries[domain]; ok {
+			return domain, value, true
+		}
+	}
+	var zero V
+	return "", zero, false
+}
+
+// Candidates lists the normalized domains that can match a host, in order of
+// preference: the host itself, then the wildcards of its parents from the
+// longest suffix down
+func Candidates(host string) []string {
+	candidates := []string{host}
+	for rest := host; ; {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			return candidates
+		}
+		rest = rest[i+1:]
+		candidates = append(candidates, WildcardPrefix+rest)
+	}
+}
+
+// CandidateCondition returns an SQL condition, numbering its placeholders
+// from $1, that column holds one of the Candidates of host, with its arguments
+func CandidateCondition(column, host string) (string, []interface{}) {
+	candidates := Candidates(host)
+	args := make([]interface{}, len(candidates))
+	for i, candidate := range candidates {
+		args[i] = candidate
+	}
+	return column + " IN (" + strings.Join(db.Placeholders(len(candidates), 1), ", ") + ")", args
+}
+
+// Lookup finds the client of the active domain matching a normalized host with
+// one query on the indexed normalized_domain column, and returns the domain
+// that matched. Rows whose client_id is not a UUID are skipped.
+func Lookup(ctx context.Context, conn tools.DBConnection, host string) (uuid.UUID, string, bool, error) {
+	condition, args := CandidateCondition("normalized_domain", host)
+	rows, err := conn.Query(ctx,
+		"SELECT client_id, normalized_domain FROM domains WHERE "+condition+" AND is_active = true", args...)
+	if err != nil {
+		return uuid.Nil, "", false, fmt.Errorf("failed to look up domain: %w", err)
+	}
+	defer rows.Close()
+
+	entries := map[string]uuid.UUID{}
+	for rows.Next() {
+		var clientID, domain string
+		if err := rows.Scan(&clientID, &domain); err != nil {
+			return uuid.Nil, "", false, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		if parsed, err := uuid.Parse(clientID); err == nil {
+			entries[domain] = parsed
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return uuid.Nil, "", false, fmt.Errorf("failed to look up domain: %w", err)
+	}
+
+	domain, clientID, found := match(entries, host)
+	return clientID, domain, found, nil
+}
+
+// NormalizeStored rewrites stored domains that are not in normalized form, or
+// whose normalized_domain is missing or stale, and returns how many were
+// changed. Domains that cannot be normalized, or whose normalized form is
+// already taken, are logged and left as they are.
+func NormalizeStored(ctx context.Context, conn tools.DBConnection) (int, error) {
+	rows, err := conn.Query(ctx, "SELECT id, domain, normalized_domain FROM domains")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list domains: %w", err)
+	}
+	type storedDomain struct {
+		domain     string
+		normalized sql.NullString
+	}
+	stored := map[string]storedDomain{}
+	for rows.Next() {
+		var id string
+		var row storedDomain
+		if err := rows.Scan(&id, &row.domain, &row.normalized); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		stored[id] = row
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	updated := 0
+	for id, row := range stored {
+		domain := row.domain
+		normalized, err := Normalize(domain)
+		if err != nil {
+			log.Printf("Domain %q (%s) cannot be normalized: %v", domain, id, err)
+			continue
+		}
+		if normalized == domain && row.normalized.String == normalized {
+			continue
+		}
+		if _, err := conn.Exec(ctx, "UPDATE domains SET domain = $1, normalized_domain = $1, version = version + 1 WHERE id = $2", normalized, id); err != nil {
+			if db.IsUniqueViolation(err) {
+				log.Printf("Domain %q (%s) normalizes to %q, which another row already has", domain, id, normalized)
+				continue
+			}
+			return updated, fmt.Errorf("failed to normalize domain %s: %w", id, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
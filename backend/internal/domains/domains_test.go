@@ -0,0 +1,168 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // "" expects an error
+	}{
+		{"example.com", "example.com"},
+		{"https://App.Example.com:8443/", "app.example.com"},
+		{"http://example.com/path?q=1#top", "example.com"},
+		{"  EXAMPLE.com.  ", "example.com"},
+		{"user:secret@example.com", "example.com"},
+		{"ws://chat.example.com", "chat.example.com"},
+		{"localhost:3000", "localhost"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"Bücher.example", "xn--bcher-kva.example"},
+		{"https://münchen.DE", "xn--mnchen-3ya.de"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"*.Example.com", "*.example.com"},
+		{"https://*.eu.example.com/", "*.eu.example.com"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+
+		{"", ""},
+		{"   ", ""},
+		{"https://", ""},
+		{"example.com:http", ""},
+		{"[::1]:8080", ""},
+		{"exa mple.com", ""},
+		{"under_score.example.com", ""},
+		{"-leading.example.com", ""},
+		{"a..b.example.com", ""},
+		{"*.com", ""},
+		{"*", ""},
+		{"app.*.example.com", ""},
+		{"*example.com", ""},
+		{"a123456789012345678901234567890123456789012345678901234567890123.example.com", ""},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.input)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidDomain) {
+				t.Errorf("Normalize(%q) = %q, %v; expected an invalid domain", tt.input, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := map[string]string{
+		"https://App.Example.com:8443":    "app.example.com",
+		"https://example.com/page?x=1":    "example.com",
+		"example.com:8080":                "example.com",
+		"https://Bücher.example":          "xn--bcher-kva.example",
+		"https://*.example.com":           "",
+		"null":                            "null",
+		"https://under_score.example.com": "",
+	}
+	for value, want := range tests {
+		if got := Host(value); got != want {
+			t.Errorf("Host(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	entries := map[string]string{
+		"example.com":             "apex",
+		"*.example.com":           "wildcard",
+		"*.eu.example.com":        "eu",
+		"shop.eu.example.com":     "shop",
+		"*.xn--bcher-kva.example": "idn",
+		"other.org":               "other",
+	}
+	tests := []struct {
+		host string
+		want string // "" expects no match
+	}{
+		{"example.com", "apex"},
+		{"app.example.com", "wildcard"},
+		{"a.b.c.example.com", "wildcard"},
+		{"eu.example.com", "wildcard"},
+		{"de.eu.example.com", "eu"},
+		{"x.y.eu.example.com", "eu"},
+		{"shop.eu.example.com", "shop"},
+		{"cart.shop.eu.example.com", "eu"},
+		{Host("https://Laden.Bücher.example"), "idn"},
+		{"xn--bcher-kva.example", ""},
+		{"other.org", "other"},
+		{"www.other.org", ""},
+		{"notexample.com", ""},
+		{"example.com.evil.net", ""},
+		{"com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, ok := Match(entries, tt.host)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("Match(%q) = %q, %t; want %q", tt.host, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNormalizeStored(t *testing.T) {
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb,
+		"INSERT INTO clients (id, name, slug) VALUES ('client-1', 'Client', 'client-1')",
+		`INSERT INTO domains (id, client_id, domain) VALUES
+			('d1', 'client-1', 'https://App.Example.com:8443/'),
+			('d2', 'client-1', 'clean.example.com'),
+			('d3', 'client-1', 'not a domain'),
+			('d4', 'client-1', 'CLEAN.example.com'),
+			('d5', 'client-1', '*.Bücher.example')`,
+	)
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	ctx := context.Background()
+
+	updated, err := NormalizeStored(ctx, conn)
+	if err != nil {
+		t.Fatalf("NormalizeStored failed: %v", err)
+	}
+	if updated != 3 {
+		t.Errorf("Expected 3 domains to be rewritten, got %d", updated)
+	}
+
+	// Invalid domains and duplicates of a normalized domain are left alone
+	want := map[string]string{
+		"d1": "app.example.com",
+		"d2": "clean.example.com",
+		"d3": "not a domain",
+		"d4": "CLEAN.example.com",
+		"d5": "*.xn--bcher-kva.example",
+	}
+	for id, domain := range want {
+		var got string
+		if err := conn.QueryRow(ctx, "SELECT domain FROM domains WHERE id = $1", id).Scan(&got); err != nil || got != domain {
+			t.Errorf("%s: expected %q, got %q, %v", id, domain, got, err)
+		}
+	}
+
+	// normalized_domain is filled in for every domain that could be normalized
+	normalized := map[string]string{
+		"d1": "app.example.com",
+		"d2": "clean.example.com",
+		"d3": "",
+		"d4": "",
+		"d5": "*.xn--bcher-kva.example",
+	}
+	for id, domain := range normalized {
+		var got sql.NullString
+		if err := conn.QueryRow(ctx, "SELECT normalized_domain FROM domains WHERE id = $1", id).Scan(&got); err != nil || got.String != domain {
+			t.Errorf("%s: expected normalized_domain %q, got %q, %v", id, domain, got.String, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1", "project-2")
+	dbtest.Seed(t, zdb, "INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-2', 'client-1', 'user-2', 'x')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+// fakeEmbedder maps texts onto one axis per topic word, so texts about the
+// same topic point the same way
+type fakeEmbedder struct {
+	model string
+	err   error
+
+	mutex sync.Mutex
+	texts []string
+}
+
+var topics = []string{"invoice", "deploy", "weather"}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.mutex.Lock()
+	e.texts = append(e.texts, texts...)
+	e.mutex.Unlock()
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, len(topics))
+		for j, topic := range topics {
+			vector[j] = float32(strings.Count(strings.ToLower(text), topic))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func (e *fakeEmbedder) EmbeddingModel() string {
+	if e.model == "" {
+		return "fake-embedding"
+	}
+	return e.model
+}
+
+func exec(t *testing.T, conn tools.DBConnection, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := conn.Exec(context.Background(), query, args...); err != nil {
+		t.Fatalf("Failed to run %q: %v", query, err)
+	}
+}
+
+// seed creates conversations and assistant messages and indexes the messages
+func seed(t *testing.T, conn tools.DBConnection, indexer *Indexer, embedder llm.Embedder) {
+	t.Helper()
+	exec(t, conn, `INSERT INTO conversations (id, title, user_id, project_id) VALUES
+		('conv-billing', 'Billing', 'user-1', 'project-1'),
+		('conv-ops', 'Ops', 'user-1', 'project-1'),
+		('conv-other-user', 'Theirs', 'user-2', 'project-1'),
+		('conv-other-project', 'Elsewhere', 'user-1', 'project-2')`)
+	exec(t, conn, `INSERT INTO conversations (id, title, user_id, project_id, deleted_at) VALUES
+		('conv-deleted', 'Deleted', 'user-1', 'project-1', CURRENT_TIMESTAMP)`)
+
+	for _, m := range []Message{
+		{ID: "msg-invoice", ConversationID: "conv-billing", ProjectID: "project-1", Content: "The invoice total includes tax; the invoice is due monthly."},
+		{ID: "msg-deploy", ConversationID: "conv-ops", ProjectID: "project-1", Content: "Run the deploy script, then check the deploy logs and the invoice service."},
+		{ID: "msg-weather", ConversationID: "conv-ops", ProjectID: "project-1", Content: "The weather API is rate limited."},
+		{ID: "msg-other-user", ConversationID: "conv-other-user", ProjectID: "project-1", Content: "Their invoice."},
+		{ID: "msg-other-project", ConversationID: "conv-other-project", ProjectID: "project-2", Content: "Another invoice."},
+		{ID: "msg-deleted", ConversationID: "conv-deleted", ProjectID: "project-1", Content: "A deleted invoice."},
+	} {
+		exec(t, conn, "INSERT INTO messages (id, conversation_id, role, content) VALUES ($1, $2, 'assistant', $3)",
+			m.ID, m.ConversationID, m.Content)
+		if err := indexer.index(context.Background(), job{embedder: embedder, message: m}); err != nil {
+			t.Fatalf("Failed to index %s: %v", m.ID, err)
+		}
+	}
+}
+
+func TestBruteForceSearchRanksAndScopes(t *testing.T) {
+	conn := newTestDB(t)
+	store := NewStore(conn)
+	if store.DetectPGVector(context.Background()) {
+		t.Fatal("Expected SQLite to use brute force")
+	}
+	embedder := &fakeEmbedder{}
+	seed(t, conn, NewIndexer(store, Options{}), embedder)
+
+	searcher := NewSearcher(store, func(ctx context.Context, userID string) (llm.Embedder, error) {
+		return embedder, nil
+	})
+	matches, err := searcher.SearchConversations(context.Background(), "user-1", "project-1", "unpaid invoice", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, m.MessageID)
+	}
+	// Other users, other projects and deleted conversations are never returned
+	if strings.Join(ids, ",") != "msg-invoice,msg-deploy,msg-weather" {
+		t.Fatalf("Expected the user's project messages by similarity, got %v", ids)
+	}
+	if matches[0].ConversationID != "conv-billing" || matches[0].ConversationTitle != "Billing" ||
+		!strings.HasPrefix(matches[0].Snippet, "The invoice total") || math.Abs(matches[0].Score-1) > 1e-6 {
+		t.Errorf("Unexpected top match: %+v", matches[0])
+	}
+	if matches[2].Score != 0 {
+		t.Errorf("Expected an unrelated message to score 0, got %v", matches[2].Score)
+	}
+
+	limited, err := searcher.SearchConversations(context.Background(), "user-1", "project-1", "invoice", 1)
+	if err != nil || len(limited) != 1 || limited[0].MessageID != "msg-invoice" {
+		t.Errorf("Expected only the best match, got %+v (%v)", limited, err)
+	}
+}
+
+func TestSearchIgnoresOtherEmbeddingModels(t *testing.T) {
+	conn := newTestDB(t)
+	store := NewStore(conn)
+	seed(t, conn, NewIndexer(store, Options{}), &fakeEmbedder{model: "old-model"})
+
+	searcher := NewSearcher(store, func(ctx context.Context, userID string) (llm.Embedder, error) {
+		return &fakeEmbedder{}, nil
+	})
+	matches, err := searcher.SearchConversations(context.Background(), "user-1", "project-1", "invoice", 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Expected vectors of another model to be skipped, got %+v", matches)
+	}
+}
+
+func TestIndexerNeverBlocks(t *testing.T) {
+	conn := newTestDB(t)
+	indexer := NewIndexer(NewStore(conn), Options{QueueSize: 1})
+	embedder := &fakeEmbedder{err: errors.New("provider down")}
+
+	// Without a running worker the second message finds the queue full and is dropped
+	indexer.Enqueue(embedder, Message{ID: "msg-1", Content: "first"})
+	indexer.Enqueue(embedder, Message{ID: "msg-2", Content: "second"})
+	indexer.Enqueue(embedder, Message{ID: "msg-3", Content: "   "})
+	if len(indexer.queue) != 1 {
+		t.Fatalf("Expected one queued message, got %d", len(indexer.queue))
+	}
+
+	j := <-indexer.queue
+	if err := indexer.index(context.Background(), j); err == nil {
+		t.Error("Expected the embedding failure to be reported to the worker")
+	}
+	var count int
+	conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM message_embeddings").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected nothing stored for a failed embedding, got %d", count)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []float32
+		expected float64
+	}{
+		{"same direction", []float32{1, 2}, []float32{2, 4}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 3}, 0},
+		{"opposite", []float32{1, 1}, []float32{-1, -1}, -1},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+		{"length mismatch", []float32{1}, []float32{1, 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosineSimilarity(tt.a, tt.b); math.Abs(got-tt.expected) > 1e-6 {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"zlay-backend/internal/llm"
+)
+
+// Indexer defaults
+const (
+	DefaultQueueSize = 500
+	// DefaultTimeout bounds one embedding request
+	DefaultTimeout = 30 * time.Second
+	// MaxTextChars is how much of a message is embedded; the rest is cut off
+	MaxTextChars = 8000
+)
+
+// Options configures an Indexer; zero values use the defaults
+type Options struct {
+	QueueSize int
+	Timeout   time.Duration
+}
+
+// Message is a saved message waiting to be embedded
+type Message struct {
+	ID             string
+	ConversationID string
+	ProjectID      string
+	Content        string
+}
+
+// job is a message together with the client's embedder
+type job struct {
+	embedder llm.Embedder
+	message  Message
+}
+
+// Indexer embeds saved messages in the background. Enqueue never blocks, so a
+// slow or failing embeddings endpoint cannot hold up saving messages: jobs
+// wait in a bounded queue for a single worker and are dropped when it is full.
+type Indexer struct {
+	store   *Store
+	options Options
+
+	queue chan job
+	stop  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// NewIndexer creates an indexer; call Start to begin embedding
+func NewIndexer(store *Store, options Options) *Indexer {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Indexer{
+		store:   store,
+		options: options,
+		queue:   make(chan job, options.QueueSize),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start launches the worker
+func (i *Indexer) Start() {
+	i.wg.Add(1)
+	go i.worker()
+}
+
+// Stop stops the worker after its current message; queued messages are discarded
+func (i *Indexer) Stop() {
+	i.once.Do(func() { close(i.stop) })
+	i.wg.Wait()
+}
+
+// Enqueue queues a message for embedding with the client's embedder. Messages
+// without text are skipped and a full queue drops the message.
+func (i *Indexer) Enqueue(embedder llm.Embedder, message Message) {
+	if embedder == nil || strings.TrimSpace(message.Content) == "" {
+		return
+	}
+	select {
+	case i.queue <- job{embedder: embedder, message: message}:
+	default:
+		log.Printf("Embedding queue full, dropping message %s", message.ID)
+	}
+}
+
+func (i *Indexer) worker() {
+	defer i.wg.Done()
+	for {
+		select {
+		case <-i.stop:
+			return
+		case j := <-i.queue:
+			if err := i.index(context.Background(), j); err != nil {
+				log.Printf("Failed to embed message %s: %v", j.message.ID, err)
+			}
+		}
+	}
+}
+
+// index embeds one message and stores the vector
+func (i *Indexer) index(ctx context.Context, j job) error {
+	ctx, cancel := context.WithTimeout(ctx, i.options.Timeout)
+	defer cancel()
+
+	vectors, err := j.embedder.Embed(ctx, []string{truncate(j.message.Content, MaxTextChars)})
+	if err != nil {
+		return err
+	}
+	if len(vectors) != 1 {
+		return fmt.Errorf("expected one embedding, got %d", len(vectors))
+	}
+	return i.store.Save(ctx, Record{
+		MessageID:      j.message.ID,
+		ConversationID: j.message.ConversationID,
+		ProjectID:      j.message.ProjectID,
+		Model:          j.embedder.EmbeddingModel(),
+		Vector:         vectors[0],
+	})
+}
+
+// truncate cuts text to at most max characters
+func truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max])
+}
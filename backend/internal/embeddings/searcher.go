@@ -0,0 +1,48 @@
+package embeddings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// ErrEmbeddingsUnsupported is returned when the user's LLM provider cannot embed text
+var ErrEmbeddingsUnsupported = errors.New("the LLM provider does not support embeddings")
+
+// EmbedderResolver returns the embedder of the client a user belongs to
+type EmbedderResolver func(ctx context.Context, userID string) (llm.Embedder, error)
+
+// Searcher implements tools.ConversationSearcher by embedding the query with
+// the user's embedder and searching the store
+type Searcher struct {
+	store   *Store
+	resolve EmbedderResolver
+}
+
+// NewSearcher creates a searcher over the store
+func NewSearcher(store *Store, resolve EmbedderResolver) *Searcher {
+	return &Searcher{store: store, resolve: resolve}
+}
+
+// SearchConversations implements tools.ConversationSearcher
+func (s *Searcher) SearchConversations(ctx context.Context, userID, projectID, query string, limit int) ([]tools.ConversationMatch, error) {
+	embedder, err := s.resolve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if embedder == nil {
+		return nil, ErrEmbeddingsUnsupported
+	}
+
+	vectors, err := embedder.Embed(ctx, []string{truncate(query, MaxTextChars)})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("expected one embedding, got %d", len(vectors))
+	}
+	return s.store.Search(ctx, userID, projectID, embedder.EmbeddingModel(), vectors[0], limit)
+}
@@ -0,0 +1,195 @@
+// Package embeddings indexes assistant messages as vectors and finds the ones
+// closest to a query for the conversation_search tool. Vectors are searched by
+// pgvector when migrations could add the embedding_vector column, and by brute
+// force over the stored JSON float arrays otherwise.
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// MaxCandidates caps how many of a project's most recent embeddings a brute-force search compares
+	MaxCandidates = 5000
+	// snippetChars is how much of a matching message is returned
+	snippetChars = 300
+)
+
+// Record is the embedding of one message
+type Record struct {
+	MessageID      string
+	ConversationID string
+	ProjectID      string
+	Model          string
+	Vector         []float32
+}
+
+// Store keeps message embeddings in the message_embeddings table
+type Store struct {
+	db       tools.DBConnection
+	pgvector bool
+}
+
+// NewStore creates a store that searches by brute force until DetectPGVector finds pgvector
+func NewStore(db tools.DBConnection) *Store {
+	return &Store{db: db}
+}
+
+// DetectPGVector switches to pgvector search when the embedding_vector column
+// exists, and reports whether it does. Databases without information_schema,
+// such as SQLite, keep brute force.
+func (s *Store) DetectPGVector(ctx context.Context) bool {
+	var count int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM information_schema.columns WHERE table_name = 'message_embeddings' AND column_name = 'embedding_vector'`).Scan(&count)
+	s.pgvector = err == nil && count > 0
+	return s.pgvector
+}
+
+// Save stores a message's embedding; a message that already has one keeps it
+func (s *Store) Save(ctx context.Context, record Record) error {
+	if len(record.Vector) == 0 {
+		return fmt.Errorf("empty embedding for message %s", record.MessageID)
+	}
+	// The JSON array doubles as pgvector's text format
+	encoded, err := json.Marshal(record.Vector)
+	if err != nil {
+		return fmt.Errorf("failed to encode embedding: %w", err)
+	}
+
+	columns := "message_id, conversation_id, project_id, model, dimensions, embedding, created_at"
+	values := "$1, $2, $3, $4, $5, $6, $7"
+	args := []interface{}{record.MessageID, record.ConversationID, record.ProjectID, record.Model,
+		len(record.Vector), string(encoded), time.Now().UTC()}
+	if s.pgvector {
+		columns += ", embedding_vector"
+		values += ", $8::vector"
+		args = append(args, string(encoded))
+	}
+
+	_, err = s.db.Exec(ctx, fmt.Sprintf("INSERT INTO message_embeddings (%s) VALUES (%s)", columns, values), args...)
+	if err != nil && !db.IsUniqueViolation(err) {
+		return fmt.Errorf("failed to save embedding for message %s: %w", record.MessageID, err)
+	}
+	return nil
+}
+
+// Search returns up to limit messages of the user's conversations in the
+// project whose embeddings, made by the same model, are closest to the query
+func (s *Store) Search(ctx context.Context, userID, projectID, model string, query []float32, limit int) ([]tools.ConversationMatch, error) {
+	if len(query) == 0 || limit <= 0 {
+		return []tools.ConversationMatch{}, nil
+	}
+	if s.pgvector {
+		return s.searchPGVector(ctx, userID, projectID, model, query, limit)
+	}
+	return s.searchBruteForce(ctx, userID, projectID, model, query, limit)
+}
+
+// scope joins the conversation and message of each embedding and keeps those
+// the user can see: their own, not deleted, in the project
+const scope = `FROM message_embeddings e
+	JOIN conversations c ON c.id = e.conversation_id
+	JOIN messages m ON m.id = e.message_id
+	WHERE e.project_id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL AND e.model = $3 AND e.dimensions = $4`
+
+func (s *Store) searchPGVector(ctx context.Context, userID, projectID, model string, query []float32, limit int) ([]tools.ConversationMatch, error) {
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query embedding: %w", err)
+	}
+	rows, err := s.db.Query(ctx,
+		`SELECT e.message_id, e.conversation_id, c.title, m.content, m.created_at, 1 - (e.embedding_vector <=> $5::vector) `+
+			scope+` AND e.embedding_vector IS NOT NULL ORDER BY e.embedding_vector <=> $5::vector LIMIT $6`,
+		projectID, userID, model, len(query), string(encoded), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	matches := []tools.ConversationMatch{}
+	for rows.Next() {
+		var match tools.ConversationMatch
+		var content string
+		if err := rows.Scan(&match.MessageID, &match.ConversationID, &match.ConversationTitle, &content, &match.CreatedAt, &match.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding match: %w", err)
+		}
+		match.Snippet = snippet(content)
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+func (s *Store) searchBruteForce(ctx context.Context, userID, projectID, model string, query []float32, limit int) ([]tools.ConversationMatch, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT e.message_id, e.conversation_id, c.title, m.content, m.created_at, e.embedding `+
+			scope+` ORDER BY e.created_at DESC LIMIT $5`,
+		projectID, userID, model, len(query), MaxCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	matches := []tools.ConversationMatch{}
+	for rows.Next() {
+		var match tools.ConversationMatch
+		var content string
+		var encoded []byte
+		if err := rows.Scan(&match.MessageID, &match.ConversationID, &match.ConversationTitle, &content, &match.CreatedAt, &encoded); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		var vector []float32
+		if err := json.Unmarshal(encoded, &vector); err != nil {
+			return nil, fmt.Errorf("invalid embedding for message %s: %w", match.MessageID, err)
+		}
+		match.Score = cosineSimilarity(query, vector)
+		match.Snippet = snippet(content)
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors of the
+// same length, or 0 when either is all zeros
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// snippet shortens a message to its first snippetChars characters
+func snippet(content string) string {
+	content = strings.TrimSpace(content)
+	if utf8.RuneCountInString(content) <= snippetChars {
+		return content
+	}
+	return string([]rune(content)[:snippetChars]) + "..."
+}
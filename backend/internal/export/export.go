@@ -0,0 +1,77 @@
+// Package export renders conversation transcripts for download.
+//
+// Writers are streaming: the header is written first and messages are then
+// written one at a time, so large conversations never have to be held in memory.
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"zlay-backend/internal/chat"
+)
+
+// SchemaVersion is the version of the JSON export schema
+const SchemaVersion = 1
+
+// Supported export formats
+const (
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// Participant is someone (or something) that took part in a conversation
+type Participant struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Role string `json:"role"` // user, assistant, system, tool
+}
+
+// Writer streams a conversation export
+type Writer interface {
+	// WriteHeader writes the conversation details; it must be called once before any message
+	WriteHeader(conv *chat.Conversation, participants []Participant) error
+
+	// WriteMessage writes a single message
+	WriteMessage(msg *chat.Message) error
+
+	// Close finishes the document
+	Close() error
+}
+
+// NewWriter returns a writer for the requested format
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return NewJSONWriter(w), nil
+	case FormatMarkdown:
+		return NewMarkdownWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ContentType returns the HTTP content type for an export format
+func ContentType(format string) string {
+	if format == FormatMarkdown {
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// FileExtension returns the download file extension for an export format
+func FileExtension(format string) string {
+	if format == FormatMarkdown {
+		return ".md"
+	}
+	return ".json"
+}
+
+// formatTime renders timestamps consistently across formats
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
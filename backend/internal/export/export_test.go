@@ -0,0 +1,205 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/chat"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func sampleConversation() (*chat.Conversation, []Participant, []*chat.Message) {
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	conv := &chat.Conversation{
+		ID:        "0f8a1c3e-1111-4d2b-9a6e-5b7c8d9e0f10",
+		ProjectID: "a1b2c3d4-2222-4e5f-8a9b-0c1d2e3f4a5b",
+		Title:     "Quarterly revenue",
+		Status:    "completed",
+		CreatedAt: created,
+		UpdatedAt: created.Add(5 * time.Minute),
+	}
+	participants := []Participant{
+		{Name: "Assistant", Role: "assistant"},
+		{ID: "user-1", Name: "alice", Role: "user"},
+	}
+
+	largeRows := make([]interface{}, 0, 200)
+	for i := 0; i < 200; i++ {
+		largeRows = append(largeRows, map[string]interface{}{"region": "north", "revenue": i})
+	}
+
+	messages := []*chat.Message{
+		{
+			ID:        "m1",
+			Role:      "user",
+			Content:   "What was revenue by region last quarter?",
+			CreatedAt: created,
+		},
+		{
+			ID:        "m2",
+			Role:      "assistant",
+			Content:   "Let me check the sales table.",
+			CreatedAt: created.Add(time.Minute),
+			ToolCalls: []chat.ToolCall{
+				{
+					ID:   "call-1",
+					Type: "function",
+					Function: chat.ToolCallFunction{
+						Name:      "database_query",
+						Arguments: `{"query":"SELECT region, SUM(revenue) FROM sales GROUP BY region"}`,
+					},
+					Status: "completed",
+					Result: map[string]interface{}{
+						"rows":  []interface{}{map[string]interface{}{"region": "north", "sum": 1200}},
+						"count": 1,
+					},
+				},
+				{
+					ID:   "call-2",
+					Type: "function",
+					Function: chat.ToolCallFunction{
+						Name:      "database_query",
+						Arguments: map[string]interface{}{"query": "SELECT * FROM sales"},
+					},
+					Status: "completed",
+					Result: map[string]interface{}{"rows": largeRows},
+				},
+				{
+					ID:       "call-3",
+					Type:     "function",
+					Function: chat.ToolCallFunction{Name: "api_request", Arguments: map[string]interface{}{"url": "https://example.com"}},
+					Status:   "failed",
+					Error:    "URL not allowed",
+				},
+			},
+		},
+		{
+			ID:        "m3",
+			Role:      "assistant",
+			Content:   "North led with 1200. Example:\n\n```sql\nSELECT 1;\n```",
+			CreatedAt: created.Add(2 * time.Minute),
+		},
+	}
+
+	return conv, participants, messages
+}
+
+func renderExport(t *testing.T, format string) []byte {
+	t.Helper()
+
+	conv, participants, messages := sampleConversation()
+	var buf bytes.Buffer
+	writer, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := writer.WriteHeader(conv, participants); err != nil {
+		t.Fatalf("WriteHeader failed: %v", err)
+	}
+	for _, msg := range messages {
+		if err := writer.WriteMessage(msg); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMarkdownWriterGolden(t *testing.T) {
+	got := renderExport(t, FormatMarkdown)
+
+	golden := filepath.Join("testdata", "conversation.golden.md")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Markdown export does not match %s (run with -update to regenerate)\n--- got ---\n%s", golden, got)
+	}
+}
+
+func TestMarkdownWriterTruncatesLargeResults(t *testing.T) {
+	out := string(renderExport(t, FormatMarkdown))
+	if !strings.Contains(out, "_Result truncated: showing the first 4096 of") {
+		t.Error("Expected truncation note for large tool result")
+	}
+	// Message content containing a fence must not break the surrounding document
+	if strings.Count(out, "<details>") != strings.Count(out, "</details>") {
+		t.Error("Unbalanced details blocks")
+	}
+}
+
+func TestJSONWriterSchema(t *testing.T) {
+	var doc struct {
+		Version      int              `json:"version"`
+		Conversation jsonConversation `json:"conversation"`
+		Participants []Participant    `json:"participants"`
+		Messages     []jsonMessage    `json:"messages"`
+	}
+	if err := json.Unmarshal(renderExport(t, FormatJSON), &doc); err != nil {
+		t.Fatalf("Export is not valid JSON: %v", err)
+	}
+
+	if doc.Version != SchemaVersion {
+		t.Errorf("Expected version %d, got %d", SchemaVersion, doc.Version)
+	}
+	if doc.Conversation.Title != "Quarterly revenue" || doc.Conversation.CreatedAt != "2024-03-01T09:30:00Z" {
+		t.Errorf("Unexpected conversation: %+v", doc.Conversation)
+	}
+	if len(doc.Participants) != 2 || len(doc.Messages) != 3 {
+		t.Fatalf("Expected 2 participants and 3 messages, got %d and %d", len(doc.Participants), len(doc.Messages))
+	}
+	if calls := doc.Messages[1].ToolCalls; len(calls) != 3 || calls[2].Error != "URL not allowed" {
+		t.Errorf("Unexpected tool calls: %+v", calls)
+	}
+	if doc.Messages[0].ToolCalls == nil {
+		t.Error("tool_calls should be an empty array, not null")
+	}
+}
+
+func TestDownloadSigner(t *testing.T) {
+	signer := NewDownloadSigner("secret", time.Minute)
+
+	token, _ := signer.Sign("conv-1", "user-1", FormatMarkdown)
+	claims, err := signer.Redeem(token)
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	if claims.ConversationID != "conv-1" || claims.UserID != "user-1" || claims.Format != FormatMarkdown {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	// Tokens are single use
+	if _, err := signer.Redeem(token); !errors.Is(err, ErrDownloadTokenUsed) {
+		t.Errorf("Expected ErrDownloadTokenUsed, got %v", err)
+	}
+
+	// Tampered and foreign tokens are rejected
+	other, _ := NewDownloadSigner("other-secret", time.Minute).Sign("conv-1", "user-1", FormatMarkdown)
+	if _, err := signer.Redeem(other); !errors.Is(err, ErrInvalidDownloadToken) {
+		t.Errorf("Expected ErrInvalidDownloadToken, got %v", err)
+	}
+
+	// Expired tokens are rejected
+	token, _ = signer.Sign("conv-1", "user-1", FormatJSON)
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := signer.Redeem(token); !errors.Is(err, ErrDownloadTokenExpired) {
+		t.Errorf("Expected ErrDownloadTokenExpired, got %v", err)
+	}
+}
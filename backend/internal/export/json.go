@@ -0,0 +1,138 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"zlay-backend/internal/chat"
+)
+
+// jsonConversation is the stable conversation schema of a JSON export
+type jsonConversation struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	ProjectID string `json:"project_id"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// jsonMessage is the stable message schema of a JSON export
+type jsonMessage struct {
+	ID        string         `json:"id"`
+	Role      string         `json:"role"`
+	Content   string         `json:"content"`
+	CreatedAt string         `json:"created_at"`
+	ToolCalls []jsonToolCall `json:"tool_calls"`
+	Feedback  []jsonFeedback `json:"feedback,omitempty"`
+}
+
+// jsonFeedback is the stable feedback schema of a JSON export
+type jsonFeedback struct {
+	UserID    string `json:"user_id"`
+	Rating    int    `json:"rating"`
+	Comment   string `json:"comment,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// jsonToolCall is the stable tool call schema of a JSON export
+type jsonToolCall struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Arguments interface{}            `json:"arguments"`
+	Status    string                 `json:"status,omitempty"`
+	Result    map[string]interface{} `json:"result,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// JSONWriter streams a conversation as a versioned JSON document:
+// {"version":1,"conversation":{...},"participants":[...],"messages":[...]}
+type JSONWriter struct {
+	w        io.Writer
+	messages int
+}
+
+// NewJSONWriter creates a JSON export writer
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+// WriteHeader writes the document preamble and opens the messages array
+func (jw *JSONWriter) WriteHeader(conv *chat.Conversation, participants []Participant) error {
+	if participants == nil {
+		participants = []Participant{}
+	}
+
+	conversation, err := json.Marshal(jsonConversation{
+		ID:        conv.ID,
+		Title:     conv.Title,
+		ProjectID: conv.ProjectID,
+		Status:    conv.Status,
+		CreatedAt: formatTime(conv.CreatedAt),
+		UpdatedAt: formatTime(conv.UpdatedAt),
+	})
+	if err != nil {
+		return err
+	}
+	participantsJSON, err := json.Marshal(participants)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(jw.w, `{"version":%d,"conversation":%s,"participants":%s,"messages":[`,
+		SchemaVersion, conversation, participantsJSON)
+	return err
+}
+
+// WriteMessage appends a message to the messages array
+func (jw *JSONWriter) WriteMessage(msg *chat.Message) error {
+	toolCalls := make([]jsonToolCall, 0, len(msg.ToolCalls))
+	for _, tc := range msg.ToolCalls {
+		toolCalls = append(toolCalls, jsonToolCall{
+			ID:        tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+			Status:    tc.Status,
+			Result:    tc.Result,
+			Error:     tc.Error,
+		})
+	}
+
+	var feedback []jsonFeedback
+	for _, f := range msg.Feedback {
+		feedback = append(feedback, jsonFeedback{
+			UserID:    f.UserID,
+			Rating:    f.Rating,
+			Comment:   f.Comment,
+			UpdatedAt: formatTime(f.UpdatedAt),
+		})
+	}
+
+	data, err := json.Marshal(jsonMessage{
+		ID:        msg.ID,
+		Role:      msg.Role,
+		Content:   msg.Content,
+		CreatedAt: formatTime(msg.CreatedAt),
+		ToolCalls: toolCalls,
+		Feedback:  feedback,
+	})
+	if err != nil {
+		return err
+	}
+
+	if jw.messages > 0 {
+		if _, err := io.WriteString(jw.w, ","); err != nil {
+			return err
+		}
+	}
+	jw.messages++
+	_, err = jw.w.Write(data)
+	return err
+}
+
+// Close terminates the messages array and the document
+func (jw *JSONWriter) Close() error {
+	_, err := io.WriteString(jw.w, "]}\n")
+	return err
+}
@@ -0,0 +1,209 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"zlay-backend/internal/chat"
+)
+
+// maxToolResultBytes is the size above which tool results are truncated in Markdown exports
+const maxToolResultBytes = 4096
+
+// MarkdownWriter streams a conversation as a Markdown transcript.
+// Tool arguments and results are wrapped in <details> blocks so viewers can collapse them.
+type MarkdownWriter struct {
+	w *bufio.Writer
+}
+
+// NewMarkdownWriter creates a Markdown export writer
+func NewMarkdownWriter(w io.Writer) *MarkdownWriter {
+	return &MarkdownWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteHeader writes the title and conversation details
+func (mw *MarkdownWriter) WriteHeader(conv *chat.Conversation, participants []Participant) error {
+	title := conv.Title
+	if title == "" {
+		title = "Untitled conversation"
+	}
+
+	fmt.Fprintf(mw.w, "# %s\n\n", title)
+	fmt.Fprintf(mw.w, "- **Conversation ID:** %s\n", conv.ID)
+	fmt.Fprintf(mw.w, "- **Project ID:** %s\n", conv.ProjectID)
+	if created := formatTime(conv.CreatedAt); created != "" {
+		fmt.Fprintf(mw.w, "- **Created:** %s\n", created)
+	}
+	if updated := formatTime(conv.UpdatedAt); updated != "" {
+		fmt.Fprintf(mw.w, "- **Updated:** %s\n", updated)
+	}
+
+	if len(participants) > 0 {
+		names := make([]string, 0, len(participants))
+		for _, p := range participants {
+			names = append(names, fmt.Sprintf("%s (%s)", p.Name, p.Role))
+		}
+		fmt.Fprintf(mw.w, "- **Participants:** %s\n", strings.Join(names, ", "))
+	}
+
+	_, err := mw.w.WriteString("\n---\n")
+	return err
+}
+
+// WriteMessage writes one message with its tool calls
+func (mw *MarkdownWriter) WriteMessage(msg *chat.Message) error {
+	fmt.Fprintf(mw.w, "\n## %s", roleHeading(msg.Role))
+	if created := formatTime(msg.CreatedAt); created != "" {
+		fmt.Fprintf(mw.w, " · %s", created)
+	}
+	mw.w.WriteString("\n\n")
+
+	if content := strings.TrimSpace(msg.Content); content != "" {
+		mw.w.WriteString(content)
+		mw.w.WriteString("\n")
+	}
+
+	for _, tc := range msg.ToolCalls {
+		mw.writeToolCall(tc)
+	}
+
+	for _, f := range msg.Feedback {
+		mw.writeFeedback(f)
+	}
+
+	// Flush per message so output is streamed in chunks
+	return mw.w.Flush()
+}
+
+// Close flushes any buffered output
+func (mw *MarkdownWriter) Close() error {
+	return mw.w.Flush()
+}
+
+func (mw *MarkdownWriter) writeToolCall(tc chat.ToolCall) {
+	status := tc.Status
+	if status == "" {
+		status = "unknown"
+	}
+	fmt.Fprintf(mw.w, "\n**Tool call:** `%s` (%s)\n", tc.Function.Name, status)
+
+	if tc.Function.Arguments != nil {
+		args := prettyJSON(tc.Function.Arguments)
+		lang := "json"
+		if !json.Valid([]byte(args)) {
+			lang = "text"
+		}
+		mw.writeDetails("Arguments", lang, args, "")
+	}
+
+	if tc.Error != "" {
+		mw.writeDetails("Error", "text", tc.Error, "")
+	}
+
+	if tc.Result != nil {
+		result := prettyJSON(tc.Result)
+		note := ""
+		if len(result) > maxToolResultBytes {
+			note = fmt.Sprintf("_Result truncated: showing the first %d of %d bytes._", maxToolResultBytes, len(result))
+			result = truncateUTF8(result, maxToolResultBytes)
+		}
+		mw.writeDetails("Result", "json", result, note)
+	}
+}
+
+func (mw *MarkdownWriter) writeFeedback(f chat.MessageFeedback) {
+	rating := "👍"
+	if f.Rating < 0 {
+		rating = "👎"
+	}
+	fmt.Fprintf(mw.w, "\n**Feedback:** %s", rating)
+	if f.Comment != "" {
+		fmt.Fprintf(mw.w, " %s", strings.ReplaceAll(strings.TrimSpace(f.Comment), "\n", " "))
+	}
+	mw.w.WriteString("\n")
+}
+
+// writeDetails writes a collapsible block containing a fenced code block
+func (mw *MarkdownWriter) writeDetails(summary, lang, body, note string) {
+	fence := codeFence(body)
+
+	fmt.Fprintf(mw.w, "\n<details>\n<summary>%s</summary>\n\n", summary)
+	fmt.Fprintf(mw.w, "%s%s\n%s\n%s\n", fence, lang, body, fence)
+	if note != "" {
+		fmt.Fprintf(mw.w, "\n%s\n", note)
+	}
+	mw.w.WriteString("\n</details>\n")
+}
+
+// roleHeading returns a display heading for a message role
+func roleHeading(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	case "tool":
+		return "Tool"
+	default:
+		if role == "" {
+			return "Unknown"
+		}
+		return role
+	}
+}
+
+// prettyJSON renders a value as indented JSON; strings holding JSON are re-indented
+func prettyJSON(value interface{}) string {
+	if s, ok := value.(string); ok {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(s), &parsed); err != nil {
+			return s
+		}
+		value = parsed
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// codeFence returns a backtick fence longer than any backtick run in the body
+func codeFence(body string) string {
+	longest, run := 0, 0
+	for _, r := range body {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
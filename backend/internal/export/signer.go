@@ -0,0 +1,145 @@
+package export
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDownloadTTL is how long a signed download URL stays valid
+const DefaultDownloadTTL = 5 * time.Minute
+
+var (
+	ErrInvalidDownloadToken = errors.New("invalid download token")
+	ErrDownloadTokenExpired = errors.New("download token expired")
+	ErrDownloadTokenUsed    = errors.New("download token already used")
+)
+
+// DownloadClaims identifies the export a download token grants access to
+type DownloadClaims struct {
+	ConversationID string
+	UserID         string
+	Format         string
+	ExpiresAt      time.Time
+	nonce          string
+}
+
+// DownloadSigner issues and redeems one-time signed export download tokens
+type DownloadSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+
+	mutex sync.Mutex
+	used  map[string]time.Time // nonce -> expiry, pruned as tokens expire
+}
+
+// NewDownloadSigner creates a signer; an empty secret generates a random one,
+// which means outstanding URLs stop working when the process restarts
+func NewDownloadSigner(secret string, ttl time.Duration) *DownloadSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	if ttl <= 0 {
+		ttl = DefaultDownloadTTL
+	}
+	return &DownloadSigner{
+		secret: key,
+		ttl:    ttl,
+		now:    time.Now,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Sign issues a token for downloading a conversation export
+func (s *DownloadSigner) Sign(conversationID, userID, format string) (string, time.Time) {
+	nonceBytes := make([]byte, 16)
+	rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+
+	expiresAt := s.now().Add(s.ttl)
+	payload := strings.Join([]string{conversationID, userID, format, strconv.FormatInt(expiresAt.Unix(), 10), nonce}, "|")
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.signature(payload)
+	return token, expiresAt
+}
+
+// Redeem verifies a token and marks it as used; each token can be redeemed once
+func (s *DownloadSigner) Redeem(token string) (*DownloadClaims, error) {
+	claims, err := s.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	for nonce, expiry := range s.used {
+		if now.After(expiry) {
+			delete(s.used, nonce)
+		}
+	}
+	if _, used := s.used[claims.nonce]; used {
+		return nil, ErrDownloadTokenUsed
+	}
+	s.used[claims.nonce] = claims.ExpiresAt
+
+	return claims, nil
+}
+
+func (s *DownloadSigner) verify(token string) (*DownloadClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidDownloadToken
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidDownloadToken
+	}
+	payload := string(payloadBytes)
+	if !hmac.Equal([]byte(signature), []byte(s.signature(payload))) {
+		return nil, ErrInvalidDownloadToken
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 5 {
+		return nil, ErrInvalidDownloadToken
+	}
+	expiresUnix, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad expiry", ErrInvalidDownloadToken)
+	}
+	claims := &DownloadClaims{
+		ConversationID: parts[0],
+		UserID:         parts[1],
+		Format:         parts[2],
+		ExpiresAt:      time.Unix(expiresUnix, 0),
+		nonce:          parts[4],
+	}
+	if s.now().After(claims.ExpiresAt) {
+		return nil, ErrDownloadTokenExpired
+	}
+
+	return claims, nil
+}
+
+func (s *DownloadSigner) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DownloadPath returns the relative URL for downloading an export with a signed token
+func DownloadPath(conversationID, format, token string) string {
+	return fmt.Sprintf("/api/conversations/%s/export?format=%s&token=%s", conversationID, format, token)
+}
@@ -0,0 +1,154 @@
+// Package health runs dependency checks for liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+// DefaultCacheTTL is how long a readiness report is reused, so frequent probes
+// do not turn into database load
+const DefaultCacheTTL = 2 * time.Second
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded" // only non-critical checks failed
+	StatusFail     = "fail"
+)
+
+// Check is a single named dependency check
+type Check struct {
+	Name string
+	// Critical checks make the instance unready when they fail
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one check
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the combined outcome of every check
+type Report struct {
+	Status    string        `json:"status"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Cached    bool          `json:"cached"`
+	Checks    []CheckResult `json:"checks"`
+}
+
+// Ready reports whether every critical check passed
+func (r *Report) Ready() bool {
+	return r.Status != StatusFail
+}
+
+// Checker runs checks and caches the last report for a short time
+type Checker struct {
+	checks []Check
+	ttl    time.Duration
+	now    func() time.Time
+
+	mutex sync.Mutex
+	last  *Report
+}
+
+// NewChecker creates a checker; a non-positive ttl disables caching
+func NewChecker(ttl time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, ttl: ttl, now: time.Now}
+}
+
+// Check returns the cached report if it is fresh, otherwise runs every check.
+// Concurrent callers wait for a single run instead of starting their own.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.last != nil && c.now().Sub(c.last.CheckedAt) < c.ttl {
+		report := *c.last
+		report.Cached = true
+		return report
+	}
+
+	report := c.run(ctx)
+	c.last = &report
+	return report
+}
+
+// Run runs every check now, bypassing and refreshing the cache
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	report := c.run(ctx)
+	c.last = &report
+	return report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	status := StatusOK
+	for _, result := range results {
+		if result.Status == StatusOK {
+			continue
+		}
+		if result.Critical {
+			status = StatusFail
+			break
+		}
+		status = StatusDegraded
+	}
+
+	return Report{Status: status, CheckedAt: c.now(), Checks: results}
+}
+
+func runCheck(ctx context.Context, check Check) CheckResult {
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{
+		Name:      check.Name,
+		Status:    StatusOK,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Querier is the part of *db.Database the database check needs
+type Querier interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) (*db.Row, error)
+}
+
+// DatabaseCheck runs SELECT 1 with a timeout
+func DatabaseCheck(database Querier, timeout time.Duration) Check {
+	return Check{
+		Name:     "database",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			_, err := database.QueryRow(ctx, "SELECT 1")
+			return err
+		},
+	}
+}
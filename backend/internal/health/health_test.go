@@ -0,0 +1,55 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func staticCheck(name string, critical bool, err error) Check {
+	return Check{Name: name, Critical: critical, Run: func(ctx context.Context) error { return err }}
+}
+
+func TestCheckerStatus(t *testing.T) {
+	failure := errors.New("down")
+	tests := []struct {
+		name   string
+		checks []Check
+		status string
+		ready  bool
+	}{
+		{"all pass", []Check{staticCheck("a", true, nil), staticCheck("b", false, nil)}, StatusOK, true},
+		{"optional fails", []Check{staticCheck("a", true, nil), staticCheck("b", false, failure)}, StatusDegraded, true},
+		{"critical fails", []Check{staticCheck("a", true, failure), staticCheck("b", false, nil)}, StatusFail, false},
+	}
+	for _, tt := range tests {
+		report := NewChecker(0, tt.checks...).Check(context.Background())
+		if report.Status != tt.status || report.Ready() != tt.ready {
+			t.Errorf("%s: expected %s (ready=%v), got %s (ready=%v)", tt.name, tt.status, tt.ready, report.Status, report.Ready())
+		}
+		if len(report.Checks) != len(tt.checks) || report.Checks[0].Name != "a" {
+			t.Errorf("%s: expected results in check order, got %+v", tt.name, report.Checks)
+		}
+	}
+}
+
+func TestCheckerCachesUntilTTL(t *testing.T) {
+	runs := 0
+	checker := NewChecker(2*time.Second, Check{Name: "count", Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}})
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+
+	checker.Check(context.Background())
+	if report := checker.Check(context.Background()); !report.Cached || runs != 1 {
+		t.Fatalf("Expected a cached report after one run, got cached=%v runs=%d", report.Cached, runs)
+	}
+
+	now = now.Add(2 * time.Second)
+	if report := checker.Check(context.Background()); report.Cached || runs != 2 {
+		t.Errorf("Expected the cache to expire, got cached=%v runs=%d", report.Cached, runs)
+	}
+}
@@ -0,0 +1,169 @@
+// Package langdetect guesses the language of a chat message from its character
+// trigrams. The language profiles are built at startup from small embedded
+// corpora, so detection needs no external service and takes microseconds.
+package langdetect
+
+import (
+	"embed"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Languages that can be detected and pinned on a conversation
+const (
+	English    = "en"
+	Indonesian = "id"
+)
+
+// Languages lists every supported language code
+var Languages = []string{English, Indonesian}
+
+var names = map[string]string{
+	English:    "English",
+	Indonesian: "Bahasa Indonesia",
+}
+
+const (
+	// maxRunes bounds the work done on long messages; their start is enough to tell
+	maxRunes = 2000
+	// minTrigrams is the least a message must have for a guess to be made
+	minTrigrams = 12
+	// minMargin is how much higher the winner's average log-probability per
+	// trigram must be than the runner-up's
+	minMargin = 0.15
+)
+
+//go:embed corpus/*.txt
+var corpora embed.FS
+
+var (
+	fencedCode = regexp.MustCompile("(?s)```.*?(```|$)")
+	inlineCode = regexp.MustCompile("`[^`\n]*`")
+)
+
+// profile holds the log-probability of every trigram seen in a corpus
+type profile struct {
+	logProb map[string]float64
+	unseen  float64
+}
+
+var profiles = buildProfiles()
+
+// Supported reports whether code is one of Languages
+func Supported(code string) bool {
+	_, ok := names[code]
+	return ok
+}
+
+// Name returns the English name of a language code, or "" when it is not supported
+func Name(code string) string {
+	return names[code]
+}
+
+// Detect returns the language code text is most likely written in, or "" when
+// it is too short or too evenly mixed to tell. Code blocks are skipped.
+func Detect(text string) string {
+	grams := trigrams(StripCode(text), maxRunes)
+	if len(grams) < minTrigrams {
+		return ""
+	}
+
+	best, bestScore, secondScore := "", math.Inf(-1), math.Inf(-1)
+	for _, code := range Languages {
+		p := profiles[code]
+		score := 0.0
+		for _, gram := range grams {
+			if logProb, ok := p.logProb[gram]; ok {
+				score += logProb
+			} else {
+				score += p.unseen
+			}
+		}
+		if score > bestScore {
+			best, bestScore, secondScore = code, score, bestScore
+		} else if score > secondScore {
+			secondScore = score
+		}
+	}
+
+	if (bestScore-secondScore)/float64(len(grams)) < minMargin {
+		return ""
+	}
+	return best
+}
+
+// StripCode removes fenced and inline code from markdown text
+func StripCode(text string) string {
+	if !strings.Contains(text, "`") {
+		return text
+	}
+	text = fencedCode.ReplaceAllString(text, " ")
+	return inlineCode.ReplaceAllString(text, " ")
+}
+
+// trigrams splits the first limit runes of text into lower-cased words of
+// letters and returns the trigrams of each word padded with a space on both
+// sides; a limit of 0 reads all of text
+func trigrams(text string, limit int) []string {
+	var grams []string
+	word := []rune{' '}
+	count := 0
+
+	flush := func() {
+		if len(word) > 1 {
+			word = append(word, ' ')
+			for i := 0; i+3 <= len(word); i++ {
+				grams = append(grams, string(word[i:i+3]))
+			}
+		}
+		word = word[:1]
+	}
+
+	for _, r := range text {
+		if count++; limit > 0 && count > limit {
+			break
+		}
+		if unicode.IsLetter(r) {
+			word = append(word, unicode.ToLower(r))
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return grams
+}
+
+// buildProfiles counts the trigrams of every embedded corpus. Counts are
+// add-one smoothed over the trigrams seen in any corpus, so a trigram missing
+// from one language still scores.
+func buildProfiles() map[string]*profile {
+	counts := map[string]map[string]int{}
+	totals := map[string]int{}
+	vocabulary := map[string]bool{}
+
+	for _, code := range Languages {
+		data, err := corpora.ReadFile("corpus/" + code + ".txt")
+		if err != nil {
+			panic("langdetect: missing corpus for " + code)
+		}
+		counts[code] = map[string]int{}
+		for _, gram := range trigrams(string(data), 0) {
+			counts[code][gram]++
+			totals[code]++
+			vocabulary[gram] = true
+		}
+	}
+
+	result := map[string]*profile{}
+	for _, code := range Languages {
+		denominator := float64(totals[code] + len(vocabulary))
+		p := &profile{logProb: map[string]float64{}, unseen: math.Log(1 / denominator)}
+		for gram, count := range counts[code] {
+			p.logProb[gram] = math.Log(float64(count+1) / denominator)
+		}
+		result[code] = p
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package langdetect
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fixture struct {
+	category string
+	expected string
+	text     string
+}
+
+func loadFixtures(t *testing.T) []fixture {
+	t.Helper()
+
+	file, err := os.Open("testdata/fixtures.tsv")
+	if err != nil {
+		t.Fatalf("Failed to open fixtures: %v", err)
+	}
+	defer file.Close()
+
+	var fixtures []fixture
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			t.Fatalf("Malformed fixture: %q", line)
+		}
+		fixtures = append(fixtures, fixture{fields[0], fields[1], strings.ReplaceAll(fields[2], `\n`, "\n")})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read fixtures: %v", err)
+	}
+	return fixtures
+}
+
+func TestDetectFixtureCorpus(t *testing.T) {
+	for _, f := range loadFixtures(t) {
+		if got := Detect(f.text); got != f.expected {
+			t.Errorf("%s: expected %q, got %q for %q", f.category, f.expected, got, f.text)
+		}
+	}
+}
+
+func TestStripCodeSkipsCodeBlocks(t *testing.T) {
+	text := "Kenapa ini error? ```go\nfunc main() { fmt.Println(\"hello world\") }\n``` pakai `go run` juga gagal"
+	stripped := StripCode(text)
+	if strings.Contains(stripped, "Println") || strings.Contains(stripped, "go run") {
+		t.Errorf("Expected code to be removed, got %q", stripped)
+	}
+	if !strings.Contains(stripped, "Kenapa ini error?") || !strings.Contains(stripped, "juga gagal") {
+		t.Errorf("Expected prose to be kept, got %q", stripped)
+	}
+
+	// An unterminated fence runs to the end of the message
+	if stripped := StripCode("Look at this:\n```\nSELECT 1"); strings.Contains(stripped, "SELECT") {
+		t.Errorf("Expected an unterminated fence to be removed, got %q", stripped)
+	}
+}
+
+func TestNameAndSupported(t *testing.T) {
+	if Name(Indonesian) != "Bahasa Indonesia" || Name(English) != "English" || Name("fr") != "" {
+		t.Error("Unexpected language names")
+	}
+	if !Supported(English) || Supported("") || Supported("fr") {
+		t.Error("Unexpected supported languages")
+	}
+}
+
+func BenchmarkDetect(b *testing.B) {
+	text := "Tolong tampilkan total penjualan per wilayah untuk kuartal terakhir dan bandingkan dengan tahun lalu, " +
+		"lalu jelaskan kenapa wilayah timur turun cukup jauh dibandingkan wilayah lainnya."
+	for i := 0; i < b.N; i++ {
+		Detect(text)
+	}
+}
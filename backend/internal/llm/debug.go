@@ -0,0 +1,15 @@
+package llm
+
+import (
+	"log"
+)
+
+// DebugStream enables per-chunk stream logging; main sets it from LLM_DEBUG_STREAM
+var DebugStream bool
+
+// debugf logs only when stream debugging is enabled
+func debugf(format string, args ...interface{}) {
+	if DebugStream {
+		log.Printf(format, args...)
+	}
+}
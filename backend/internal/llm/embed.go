@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
+
+// DefaultEmbeddingModel is used by Embed unless SetEmbeddingModel picks another
+const DefaultEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+
+// Embedder is implemented by LLM clients whose provider can embed text. It is
+// optional: callers check for it with a type assertion on an LLMClient.
+type Embedder interface {
+	// Embed returns one vector per text, in the order of texts
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+
+	// EmbeddingModel names the model Embed uses; vectors of different models
+	// must not be compared
+	EmbeddingModel() string
+}
+
+// Embed implements Embedder through the OpenAI embeddings endpoint
+func (c *OpenAIClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	resp, err := c.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: c.embeddingModel,
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || int(data.Index) >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		vector := make([]float32, len(data.Embedding))
+		for i, v := range data.Embedding {
+			vector[i] = float32(v)
+		}
+		vectors[data.Index] = vector
+	}
+	return vectors, nil
+}
+
+// EmbeddingModel returns the model used by Embed
+func (c *OpenAIClient) EmbeddingModel() string {
+	return c.embeddingModel
+}
+
+// SetEmbeddingModel changes the model used by Embed; an empty model keeps the current one
+func (c *OpenAIClient) SetEmbeddingModel(model string) {
+	if model != "" {
+		c.embeddingModel = model
+	}
+}
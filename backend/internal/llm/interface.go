@@ -21,6 +21,7 @@ type StreamingChunk struct {
 	ToolCalls interface{} `json:"tool_calls,omitempty"`
 	Done      bool    `json:"done"`
 	TokensUsed int     `json:"tokens_used,omitempty"`
+	Estimated  bool    `json:"estimated,omitempty"` // TokensUsed was estimated because the provider reported no usage
 }
 
 // LLMClient defines the interface for LLM providers
@@ -38,6 +39,22 @@ type LLMClient interface {
 	GetModel() string
 }
 
+// AssistantPrefixer is implemented by clients whose provider can continue a
+// reply from a trailing AssistantPrefix message instead of answering after it
+type AssistantPrefixer interface {
+	SupportsAssistantPrefix() bool
+}
+
+// AssistantPrefix builds the trailing assistant message a reply is continued
+// from. It carries the "prefix" flag of OpenAI-compatible providers such as
+// DeepSeek and Mistral; providers without it would answer after the message.
+func AssistantPrefix(content string) openai.ChatCompletionMessageParamUnion {
+	var assistant openai.ChatCompletionAssistantMessageParam
+	assistant.Content.OfString = openai.String(content)
+	assistant.SetExtraFields(map[string]any{"prefix": true})
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
+}
+
 // LLMResponse represents a complete LLM response
 type LLMResponse struct {
 	Content    string        `json:"content"`
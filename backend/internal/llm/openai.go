@@ -2,13 +2,16 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"zlay-backend/internal/requestid"
 )
 
 // OpenAIClient implements LLMClient for OpenAI
@@ -17,12 +20,20 @@ type OpenAIClient struct {
 	model     string
 	apiKey    string
 	baseURL   string
+	// Model used by Embed
+	embeddingModel string
+	// Whether the provider continues AssistantPrefix messages
+	assistantPrefix bool
+
+	// streamUsageUnsupported is set once the provider rejects stream_options
+	streamUsageUnsupported atomic.Bool
 }
 
 // NewOpenAIClient creates a new OpenAI client
 func NewOpenAIClient(apiKey, baseURL, model string) *OpenAIClient {
 	opts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
+		option.WithMiddleware(noRateLimitRetries),
 	}
 	if baseURL != "" {
 		opts = append(opts, option.WithBaseURL(baseURL))
@@ -34,10 +45,25 @@ func NewOpenAIClient(apiKey, baseURL, model string) *OpenAIClient {
 		model:  model,
 		apiKey: apiKey,
 		baseURL: baseURL,
+		embeddingModel: DefaultEmbeddingModel,
 	}
 }
 
-// StreamChat implements LLMClient interface with real streaming
+// SupportsAssistantPrefix implements AssistantPrefixer
+func (c *OpenAIClient) SupportsAssistantPrefix() bool {
+	return c.assistantPrefix
+}
+
+// SetAssistantPrefix declares whether the provider continues AssistantPrefix messages
+func (c *OpenAIClient) SetAssistantPrefix(supported bool) {
+	c.assistantPrefix = supported
+}
+
+// StreamChat implements LLMClient interface with real streaming. Content and tool call
+// deltas are passed to the callback as they arrive, followed by exactly one Done chunk
+// carrying the token usage. When the provider reports no usage, TokensUsed on the Done
+// chunk is estimated and Estimated is set. A rate-limited request fails with a
+// *RateLimitedError.
 func (c *OpenAIClient) StreamChat(ctx context.Context, req *LLMRequest, callback func(*StreamingChunk) error) error {
 	// Set default model if not specified
 	model := req.Model
@@ -46,6 +72,7 @@ func (c *OpenAIClient) StreamChat(ctx context.Context, req *LLMRequest, callback
 	}
 
 	log.Printf("🚀 StreamChat CALLED:")
+	log.Printf("   • Request ID: %s", requestid.From(ctx))
 	log.Printf("   • Model: %s", model)
 	log.Printf("   • Messages Count: %d", len(req.Messages))
 	log.Printf("   • Max Tokens: %d", req.MaxTokens)
@@ -55,117 +82,141 @@ func (c *OpenAIClient) StreamChat(ctx context.Context, req *LLMRequest, callback
 
 	// Log all messages for debugging
 	for i, msg := range req.Messages {
-		log.Printf("   • Message %d: Role=%s, Content=%.100s", i+1, msg.GetRole(), msg.GetContent())
-	}
-
-	// Create OpenAI streaming request using the correct API
-	log.Printf("📡 Creating OpenAI streaming request...")
-	stream := (*c.client).Chat.Completions.NewStreaming(ctx, 
-		openai.ChatCompletionNewParams{
-			Model:       model,
-			Messages:    req.Messages,
-			MaxTokens:   openai.Int(int64(req.MaxTokens)),
-			Temperature: openai.Float(float64(req.Temperature)),
-			Tools:       req.Tools,
-		},
-	)
+		role := ""
+		if r := msg.GetRole(); r != nil {
+			role = *r
+		}
+		debugf("   • Message %d: Role=%s, Content=%.100s", i+1, role, msg.GetContent())
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model:       model,
+		Messages:    req.Messages,
+		MaxTokens:   openai.Int(int64(req.MaxTokens)),
+		Temperature: openai.Float(float64(req.Temperature)),
+		Tools:       req.Tools,
+	}
+	includeUsage := !c.streamUsageUnsupported.Load()
+	if includeUsage {
+		params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+	}
 
-	log.Printf("📡 OpenAI streaming request created, waiting for first chunk...")
-	chunkCount := 0
-	totalContent := ""
+	result, err := c.streamCompletion(ctx, params, callback)
+	if err != nil && includeUsage && result.chunks == 0 && isStreamOptionsError(err) {
+		// Some OpenAI-compatible servers reject stream_options; remember and retry without it
+		requestid.Logf(ctx, "⚠️ Provider at %s does not support stream_options, retrying without usage reporting", c.baseURL)
+		c.streamUsageUnsupported.Store(true)
+		params.StreamOptions = openai.ChatCompletionStreamOptionsParam{}
+		result, err = c.streamCompletion(ctx, params, callback)
+	}
+	if err != nil {
+		requestid.Logf(ctx, "❌ OPENAI STREAMING ERROR:")
+		log.Printf("   • Total Chunks Processed: %d", result.chunks)
+		log.Printf("   • Total Content Length: %d", result.content.Len())
+		log.Printf("   • Error: %v", err)
+		return asRateLimited(err)
+	}
+
+	finalChunk := &StreamingChunk{
+		Done:       true,
+		TokensUsed: int(result.totalTokens),
+	}
+	if finalChunk.TokensUsed == 0 {
+		finalChunk.TokensUsed = c.estimateUsage(req.Messages, result.content.String())
+		finalChunk.Estimated = true
+	}
+
+	requestid.Logf(ctx, "🏁 OPENAI STREAMING COMPLETED SUCCESSFULLY:")
+	log.Printf("   • Total Chunks: %d", result.chunks)
+	log.Printf("   • Final Content Length: %d", result.content.Len())
+	log.Printf("   • Finish Reason: %s", result.finishReason)
+	log.Printf("   • Tokens Used: %d (estimated: %t)", finalChunk.TokensUsed, finalChunk.Estimated)
+	debugf("   • Final Content: \"%s\"", result.content.String())
+
+	return callback(finalChunk)
+}
+
+// streamResult collects what a stream produced besides the chunks already delivered
+type streamResult struct {
+	chunks       int
+	content      strings.Builder
+	finishReason string
+	totalTokens  int64
+}
+
+// streamCompletion runs one streaming request, forwarding every non-empty delta to the
+// callback. It never sends the Done chunk; StreamChat does that once usage is known.
+func (c *OpenAIClient) streamCompletion(ctx context.Context, params openai.ChatCompletionNewParams, callback func(*StreamingChunk) error) (*streamResult, error) {
+	result := &streamResult{}
+	stream := (*c.client).Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
 
-	// Process streaming response
-	log.Printf("📡 STARTING OPENAI STREAMING PROCESSING...")
 	for stream.Next() {
 		chunk := stream.Current()
-		chunkCount++
-		
+
+		// With include_usage the totals arrive on a last chunk with no choices
+		if chunk.Usage.TotalTokens > 0 {
+			result.totalTokens = chunk.Usage.TotalTokens
+		}
 		if len(chunk.Choices) == 0 {
-			log.Printf("⚠️ Chunk #%d: No choices available", chunkCount)
 			continue
 		}
 
 		choice := chunk.Choices[0]
-		content := choice.Delta.Content
-		totalContent += content
-		
-		// 🔥 DETAILED LOGGING: Log every chunk for debugging
-		log.Printf("📦 OPENAI CHUNK #%d:", chunkCount)
-		log.Printf("   • Content: \"%s\"", content)
-		log.Printf("   • Content Length: %d", len(content))
-		log.Printf("   • Finish Reason: %s", choice.FinishReason)
-		log.Printf("   • Total Content Length: %d", len(totalContent))
-		log.Printf("   • Has Tool Calls: %t", len(choice.Delta.ToolCalls) > 0)
-		
-		// Log first chunk and every 10th chunk
-		if chunkCount == 1 {
-			log.Printf("📥 First chunk received from OpenAI: content='%s', finish_reason='%s'", content, choice.FinishReason)
-		} else if chunkCount%10 == 0 {
-			log.Printf("📦 Chunk #%d received: content='%s', total_length=%d", chunkCount, content, len(totalContent))
+		if choice.FinishReason != "" {
+			result.finishReason = choice.FinishReason
 		}
-		
-		// Create streaming chunk
-		streamingChunk := &StreamingChunk{
-			Content:   content,
-			Done:      choice.FinishReason != "",
-			TokensUsed: 0, // Will be calculated from final usage
+		if choice.Delta.Content == "" && len(choice.Delta.ToolCalls) == 0 {
+			continue
 		}
 
-		// Handle tool calls in streaming
+		result.chunks++
+		result.content.WriteString(choice.Delta.Content)
+		debugf("📦 OPENAI CHUNK #%d: content=%q finish_reason=%q tool_calls=%d",
+			result.chunks, choice.Delta.Content, choice.FinishReason, len(choice.Delta.ToolCalls))
+
+		streamingChunk := &StreamingChunk{Content: choice.Delta.Content}
 		if len(choice.Delta.ToolCalls) > 0 {
-			log.Printf("🔧 Tool calls received in chunk: %+v", choice.Delta.ToolCalls)
 			streamingChunk.ToolCalls = choice.Delta.ToolCalls
 		}
-
-		// 🔥 DETAILED LOGGING: Log chunk being sent to callback
-		log.Printf("📤 SENDING CHUNK TO CALLBACK:")
-		log.Printf("   • Content: \"%s\"", streamingChunk.Content)
-		log.Printf("   • Content Length: %d", len(streamingChunk.Content))
-		log.Printf("   • Done: %t", streamingChunk.Done)
-		log.Printf("   • Tokens Used: %d", streamingChunk.TokensUsed)
-
-		// Send chunk to callback
 		if err := callback(streamingChunk); err != nil {
-			log.Printf("❌ ERROR SENDING CHUNK TO CALLBACK: %v", err)
-			return err
-		}
-		log.Printf("✅ CHUNK SENT TO CALLBACK SUCCESSFULLY")
-
-		// If this is the final chunk, include usage information
-		if streamingChunk.Done && chunk.Usage.TotalTokens > 0 {
-			log.Printf("✅ Final chunk received! Total chunks: %d, total_content_length: %d, tokens_used: %d", 
-				chunkCount, len(totalContent), chunk.Usage.TotalTokens)
-				
-			finalChunk := &StreamingChunk{
-				Content:    "",
-				Done:       true,
-				TokensUsed: int(chunk.Usage.TotalTokens),
-			}
-			if err := callback(finalChunk); err != nil {
-				log.Printf("❌ Error sending final chunk to callback: %v", err)
-				return err
-			}
+			return result, err
 		}
 	}
 
-	// Check for streaming errors
 	if err := stream.Err(); err != nil {
-		log.Printf("❌ OPENAI STREAMING ERROR:")
-		log.Printf("   • Total Chunks Processed: %d", chunkCount)
-		log.Printf("   • Total Content Length: %d", len(totalContent))
-		log.Printf("   • Error: %v", err)
-		return fmt.Errorf("OpenAI streaming error: %w", err)
+		return result, fmt.Errorf("OpenAI streaming error: %w", err)
 	}
+	return result, nil
+}
 
-	log.Printf("🏁 OPENAI STREAMING COMPLETED SUCCESSFULLY:")
-	log.Printf("   • Total Chunks: %d", chunkCount)
-	log.Printf("   • Final Content Length: %d", len(totalContent))
-	log.Printf("   • Final Content: \"%s\"", totalContent)
-	log.Printf("   • Stream Finished Without Errors")
-	return nil
+// estimateUsage approximates prompt plus completion tokens for providers that report no usage
+func (c *OpenAIClient) estimateUsage(messages []openai.ChatCompletionMessageParamUnion, completion string) int {
+	total := 0
+	for _, msg := range messages {
+		raw, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		if tokens, err := c.EstimateTokens(string(raw)); err == nil {
+			total += tokens
+		}
+	}
+	if completion != "" {
+		if tokens, err := c.EstimateTokens(completion); err == nil {
+			total += tokens
+		}
+	}
+	return total
+}
+
+// isStreamOptionsError reports whether a provider rejected the stream_options field
+func isStreamOptionsError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "stream_options")
 }
 
-// Chat implements LLMClient interface for non-streaming
+// Chat implements LLMClient interface for non-streaming; a rate-limited request
+// fails with a *RateLimitedError
 func (c *OpenAIClient) Chat(ctx context.Context, req *LLMRequest) (*LLMResponse, error) {
 	// Set default model if not specified
 	model := req.Model
@@ -186,7 +237,8 @@ func (c *OpenAIClient) Chat(ctx context.Context, req *LLMRequest) (*LLMResponse,
 	// Make request
 	resp, err := chatService(ctx, openaiReq)
 	if err != nil {
-		return nil, fmt.Errorf("OpenAI API error: %w", err)
+		requestid.Logf(ctx, "OpenAI chat request to %s failed: %v", c.baseURL, err)
+		return nil, asRateLimited(fmt.Errorf("OpenAI API error: %w", err))
 	}
 
 	if len(resp.Choices) == 0 {
@@ -273,7 +325,7 @@ func (c *OpenAIClient) GetContextWindow() int {
 		return 16385
 	case "gpt-4", "gpt-4-32k":
 		return 8192
-	case "gpt-4-turbo", "gpt-4-1106-preview":
+	case "gpt-4-turbo", "gpt-4-1106-preview", "gpt-4o", "gpt-4o-mini":
 		return 128000
 	default:
 		// Default to 4096 for unknown models
@@ -350,7 +402,7 @@ func (c *OpenAIClient) retryWithBackoff(ctx context.Context, operation func() er
 			case <-ctx.Done():
 				return ctx.Err()
 			case <-time.After(time.Duration(backoffMs) * time.Millisecond):
-				log.Printf("OpenAI operation failed (attempt %d/%d), retrying in %dms: %v", attempt+1, maxRetries, backoffMs, err)
+				requestid.Logf(ctx, "OpenAI operation failed (attempt %d/%d), retrying in %dms: %v", attempt+1, maxRetries, backoffMs, err)
 			}
 		}
 	}
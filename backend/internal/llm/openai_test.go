@@ -0,0 +1,280 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// scriptedStream serves a fixed sequence of SSE events and records request bodies
+type scriptedStream struct {
+	mutex  sync.Mutex
+	bodies []map[string]interface{}
+
+	// reject makes the server fail requests that carry stream_options
+	reject bool
+	events []string
+}
+
+func (s *scriptedStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	json.Unmarshal(raw, &body)
+
+	s.mutex.Lock()
+	s.bodies = append(s.bodies, body)
+	s.mutex.Unlock()
+
+	if _, ok := body["stream_options"]; ok && s.reject {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"Unrecognized request argument supplied: stream_options","type":"invalid_request_error"}}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range s.events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func contentEvent(content, finishReason string) string {
+	finish := "null"
+	if finishReason != "" {
+		finish = `"` + finishReason + `"`
+	}
+	return fmt.Sprintf(`{"id":"c1","object":"chat.completion.chunk","created":1,"model":"test","choices":[{"index":0,"delta":{"content":%q},"finish_reason":%s}]}`, content, finish)
+}
+
+func usageEvent(prompt, completion int) string {
+	return fmt.Sprintf(`{"id":"c1","object":"chat.completion.chunk","created":1,"model":"test","choices":[],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`, prompt, completion, prompt+completion)
+}
+
+func newScriptedClient(t *testing.T, stream *scriptedStream) *OpenAIClient {
+	t.Helper()
+	server := httptest.NewServer(stream)
+	t.Cleanup(server.Close)
+	return NewOpenAIClient("test-key", server.URL, "test")
+}
+
+func collectChunks(t *testing.T, client *OpenAIClient) []*StreamingChunk {
+	t.Helper()
+
+	var chunks []*StreamingChunk
+	err := client.StreamChat(context.Background(), &LLMRequest{
+		Messages:  []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Say hello")},
+		MaxTokens: 100,
+	}, func(chunk *StreamingChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamChat failed: %v", err)
+	}
+	return chunks
+}
+
+func assertSingleDone(t *testing.T, chunks []*StreamingChunk) *StreamingChunk {
+	t.Helper()
+
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if chunk.Done {
+			t.Errorf("Chunk %d was marked done before the end of the stream", i)
+		}
+	}
+	last := chunks[len(chunks)-1]
+	if !last.Done {
+		t.Fatalf("Expected the last chunk to be done, got %+v", last)
+	}
+	return last
+}
+
+func TestStreamChatReportsProviderUsageOnce(t *testing.T) {
+	stream := &scriptedStream{events: []string{
+		contentEvent("Hel", ""),
+		contentEvent("lo", ""),
+		contentEvent("", "stop"),
+		usageEvent(30, 12),
+	}}
+	chunks := collectChunks(t, newScriptedClient(t, stream))
+
+	if len(chunks) != 3 || chunks[0].Content != "Hel" || chunks[1].Content != "lo" {
+		t.Fatalf("Unexpected callback sequence: %+v", chunks)
+	}
+	done := assertSingleDone(t, chunks)
+	if done.TokensUsed != 42 || done.Estimated {
+		t.Errorf("Expected provider usage of 42, got %d (estimated %t)", done.TokensUsed, done.Estimated)
+	}
+
+	options, _ := stream.bodies[0]["stream_options"].(map[string]interface{})
+	if options["include_usage"] != true {
+		t.Errorf("Expected stream_options.include_usage in the request, got %v", stream.bodies[0]["stream_options"])
+	}
+}
+
+func TestStreamChatEstimatesUsageWhenMissing(t *testing.T) {
+	stream := &scriptedStream{events: []string{
+		contentEvent("Hello there, how can I help?", ""),
+		contentEvent("", "stop"),
+	}}
+	client := newScriptedClient(t, stream)
+	chunks := collectChunks(t, client)
+
+	if len(chunks) != 2 {
+		t.Fatalf("Expected one content chunk and one done chunk, got %+v", chunks)
+	}
+	done := assertSingleDone(t, chunks)
+	if !done.Estimated {
+		t.Error("Expected the done chunk to be marked as estimated")
+	}
+	completion, _ := client.EstimateTokens("Hello there, how can I help?")
+	if done.TokensUsed <= completion {
+		t.Errorf("Expected the estimate to cover prompt and completion, got %d", done.TokensUsed)
+	}
+}
+
+func TestStreamChatRetriesWithoutStreamOptions(t *testing.T) {
+	stream := &scriptedStream{reject: true, events: []string{
+		contentEvent("Hi", "stop"),
+	}}
+	client := newScriptedClient(t, stream)
+
+	chunks := collectChunks(t, client)
+	if len(chunks) != 2 || chunks[0].Content != "Hi" {
+		t.Fatalf("Unexpected callback sequence: %+v", chunks)
+	}
+	assertSingleDone(t, chunks)
+
+	// The rejection is remembered, so later streams skip stream_options entirely
+	collectChunks(t, client)
+	if len(stream.bodies) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(stream.bodies))
+	}
+	for i, body := range stream.bodies[1:] {
+		if _, ok := body["stream_options"]; ok {
+			t.Errorf("Request %d should not carry stream_options", i+2)
+		}
+	}
+}
+
+func TestStreamChatStopsWhenCallbackFails(t *testing.T) {
+	stream := &scriptedStream{events: []string{
+		contentEvent("one", ""),
+		contentEvent("two", ""),
+		contentEvent("", "stop"),
+		usageEvent(5, 2),
+	}}
+	client := newScriptedClient(t, stream)
+
+	stop := errors.New("client went away")
+	var chunks []string
+	err := client.StreamChat(context.Background(), &LLMRequest{
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("count")},
+	}, func(chunk *StreamingChunk) error {
+		chunks = append(chunks, chunk.Content)
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+	if strings.Join(chunks, ",") != "one" {
+		t.Errorf("Expected the stream to stop after the first chunk, got %v", chunks)
+	}
+}
+
+func TestEmbedOrdersVectorsByIndex(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		json.Unmarshal(raw, &body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","model":"text-embedding-3-small","usage":{"prompt_tokens":4,"total_tokens":4},"data":[
+			{"object":"embedding","index":1,"embedding":[0,1]},
+			{"object":"embedding","index":0,"embedding":[1,0]}]}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewOpenAIClient("test-key", server.URL, "test")
+	vectors, err := client.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if body["model"] != DefaultEmbeddingModel {
+		t.Errorf("Expected the default embedding model, got %v", body["model"])
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Expected vectors in input order, got %v", vectors)
+	}
+}
+
+func TestRateLimitedRequestsReportRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    string
+		want    time.Duration // -1 for an error that is not a rate limit
+	}{
+		{"retry-after seconds", map[string]string{"Retry-After": "7"}, "rate_limit_exceeded", 7 * time.Second},
+		{"retry-after-ms", map[string]string{"Retry-After-Ms": "1500", "Retry-After": "9"}, "rate_limit_exceeded", 1500 * time.Millisecond},
+		{"reset headers", map[string]string{"X-Ratelimit-Reset-Requests": "6s", "X-Ratelimit-Reset-Tokens": "1m0s"}, "rate_limit_exceeded", time.Minute},
+		{"no retry-after", nil, "rate_limit_exceeded", 0},
+		{"exhausted quota", map[string]string{"Retry-After": "7"}, "insufficient_quota", -1},
+	}
+	for _, tt := range tests {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			for name, value := range tt.headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, `{"error":{"message":"Slow down","type":"requests","code":%q}}`, tt.code)
+		}))
+		client := NewOpenAIClient("test-key", server.URL, "test")
+		req := &LLMRequest{Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("hi")}}
+
+		streamErr := client.StreamChat(context.Background(), req, func(*StreamingChunk) error { return nil })
+		_, chatErr := client.Chat(context.Background(), req)
+		server.Close()
+
+		for kind, err := range map[string]error{"StreamChat": streamErr, "Chat": chatErr} {
+			var limited *RateLimitedError
+			if tt.want < 0 {
+				if err == nil || errors.As(err, &limited) {
+					t.Errorf("%s, %s: expected a plain error, got %v", tt.name, kind, err)
+				}
+				continue
+			}
+			if !errors.As(err, &limited) {
+				t.Errorf("%s, %s: expected a RateLimitedError, got %v", tt.name, kind, err)
+				continue
+			}
+			if limited.RetryAfter != tt.want {
+				t.Errorf("%s, %s: expected a retry delay of %s, got %s", tt.name, kind, tt.want, limited.RetryAfter)
+			}
+		}
+		if tt.want >= 0 && requests != 2 {
+			t.Errorf("%s: expected rate-limited requests not to be retried, got %d requests", tt.name, requests)
+		}
+	}
+
+	unknown := &RateLimitedError{}
+	if unknown.Delay() != DefaultRateLimitRetryAfter || unknown.RetryAfterSeconds() != 20 {
+		t.Errorf("Expected the default delay without Retry-After, got %s", unknown.Delay())
+	}
+	if seconds := (&RateLimitedError{RetryAfter: 1500 * time.Millisecond}).RetryAfterSeconds(); seconds != 2 {
+		t.Errorf("Expected the delay to round up to 2 seconds, got %d", seconds)
+	}
+}
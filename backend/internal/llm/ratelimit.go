@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// DefaultRateLimitRetryAfter is the delay suggested for a rate-limited request
+// when the provider did not say how long to wait
+const DefaultRateLimitRetryAfter = 20 * time.Second
+
+// RateLimitedError is returned when the provider refused a request because
+// the API key is over its rate limit. RetryAfter is the delay the provider
+// asked for, zero when it sent none.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by the LLM provider, retry after %s: %v", e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("rate limited by the LLM provider: %v", e.Err)
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// Delay returns RetryAfter, or DefaultRateLimitRetryAfter when the provider sent none
+func (e *RateLimitedError) Delay() time.Duration {
+	if e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+	return DefaultRateLimitRetryAfter
+}
+
+// RetryAfterSeconds returns Delay in whole seconds, rounded up
+func (e *RateLimitedError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.Delay().Seconds()))
+}
+
+// asRateLimited wraps err in a *RateLimitedError when it is a provider
+// response refusing the request for its rate, and returns it unchanged
+// otherwise. A 429 for an exhausted quota is not a rate limit: waiting does
+// not help.
+func asRateLimited(err error) error {
+	var apiErr *openai.Error
+	if err == nil || !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.Code == "insufficient_quota" {
+		return err
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests && apiErr.Code != "rate_limit_exceeded" && apiErr.Type != "rate_limit_error" {
+		return err
+	}
+
+	limited := &RateLimitedError{Err: err}
+	if apiErr.Response != nil {
+		limited.RetryAfter = retryAfter(apiErr.Response.Header, time.Now())
+	}
+	return limited
+}
+
+// retryAfter reads how long a rate-limited client should wait from the
+// response headers: Retry-After-Ms, Retry-After in seconds or as a date, or
+// else the longest of OpenAI's x-ratelimit-reset-* durations. It returns zero
+// when none is present.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if at, err := http.ParseTime(value); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+
+	var longest time.Duration
+	for _, name := range []string{"X-Ratelimit-Reset-Requests", "X-Ratelimit-Reset-Tokens"} {
+		if reset, err := time.ParseDuration(header.Get(name)); err == nil && reset > longest {
+			longest = reset
+		}
+	}
+	return longest
+}
+
+// noRateLimitRetries stops the SDK from retrying rate-limited requests itself,
+// so the wait is reported to the user rather than spent holding the request
+func noRateLimitRetries(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	resp, err := next(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		resp.Header.Set("X-Should-Retry", "false")
+	}
+	return resp, err
+}
@@ -0,0 +1,61 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+
+	"zlay-backend/internal/requestid"
+)
+
+// RequestMessage is a message sent while answering a request, such as a
+// user_message. Its frame carries the request's ID as request_id, so clients
+// can quote it when reporting an error.
+type RequestMessage struct {
+	Message   interface{}
+	RequestID string
+}
+
+// ForRequest stamps message with the request ID carried by ctx; without one the
+// message is returned as is
+func ForRequest(ctx context.Context, message interface{}) interface{} {
+	id := requestid.From(ctx)
+	if id == "" {
+		return message
+	}
+	return RequestMessage{Message: message, RequestID: id}
+}
+
+// MarshalJSON encodes the wrapped message with request_id added to its envelope
+func (m RequestMessage) MarshalJSON() ([]byte, error) {
+	payload, err := json.Marshal(m.Message)
+	if err != nil {
+		return nil, err
+	}
+	return AddEnvelopeField(payload, "request_id", m.RequestID), nil
+}
+
+// ForRecipient lets a wrapped RecipientMessage adapt its payload, keeping the stamp
+func (m RequestMessage) ForRecipient(hasCapability func(capability string) bool) interface{} {
+	if recipientMessage, ok := m.Message.(RecipientMessage); ok {
+		return RequestMessage{Message: recipientMessage.ForRecipient(hasCapability), RequestID: m.RequestID}
+	}
+	return m
+}
+
+// AddEnvelopeField adds a string field to the front of an encoded frame. Frames
+// that are not JSON objects, and empty values, are left unchanged.
+func AddEnvelopeField(payload []byte, key, value string) []byte {
+	if value == "" || len(payload) < 2 || payload[0] != '{' {
+		return payload
+	}
+	field, err := json.Marshal(map[string]string{key: value})
+	if err != nil {
+		return payload
+	}
+	stamped := make([]byte, 0, len(payload)+len(field))
+	stamped = append(stamped, field[:len(field)-1]...)
+	if payload[1] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, payload[1:]...)
+}
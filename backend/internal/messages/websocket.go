@@ -28,4 +28,20 @@ func NewWebSocketMessage(messageType string, data interface{}, tokensUsed, token
 		TokensLimit:    tokensLimit,
 		TokensRemaining: tokensRemaining,
 	}
-}
\ No newline at end of file
+}
+
+// CapabilityStreamDelta is negotiated at connection_established by clients that
+// rebuild assistant_response content from seq and delta, so the full content is
+// no longer sent to them
+const CapabilityStreamDelta = "stream_delta"
+
+// CapabilityAcks is negotiated at connection_established by clients that answer
+// critical frames, which then carry a message_id, with an ack; unacknowledged
+// frames are sent again
+const CapabilityAcks = "acks"
+
+// RecipientMessage is implemented by messages whose payload depends on the
+// capabilities negotiated by the receiving connection
+type RecipientMessage interface {
+	ForRecipient(hasCapability func(capability string) bool) interface{}
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// CountSnapshot is how often something happened for one key, and when it last did
+type CountSnapshot struct {
+	Count  int64     `json:"count"`
+	LastAt time.Time `json:"last_at"`
+}
+
+// KeyedCounter counts occurrences per key, such as per client
+type KeyedCounter struct {
+	mutex  sync.Mutex
+	counts map[string]*CountSnapshot
+}
+
+// NewKeyedCounter creates an empty counter
+func NewKeyedCounter() *KeyedCounter {
+	return &KeyedCounter{counts: make(map[string]*CountSnapshot)}
+}
+
+// Inc records one occurrence for key
+func (c *KeyedCounter) Inc(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count, exists := c.counts[key]
+	if !exists {
+		count = &CountSnapshot{}
+		c.counts[key] = count
+	}
+	count.Count++
+	count.LastAt = time.Now().UTC()
+}
+
+// Snapshot returns the counts by key
+func (c *KeyedCounter) Snapshot() map[string]CountSnapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	snapshot := make(map[string]CountSnapshot, len(c.counts))
+	for key, count := range c.counts {
+		snapshot[key] = *count
+	}
+	return snapshot
+}
+
+var (
+	countersMutex sync.Mutex
+	counters      = make(map[string]*KeyedCounter)
+)
+
+// Counter returns the named process-wide counter, creating it on first use
+func Counter(name string) *KeyedCounter {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+
+	if c, exists := counters[name]; exists {
+		return c
+	}
+	c := NewKeyedCounter()
+	counters[name] = c
+	return c
+}
+
+// CounterSnapshots returns snapshots of every named counter
+func CounterSnapshots() map[string]map[string]CountSnapshot {
+	countersMutex.Lock()
+	named := make(map[string]*KeyedCounter, len(counters))
+	for name, c := range counters {
+		named[name] = c
+	}
+	countersMutex.Unlock()
+
+	snapshots := make(map[string]map[string]CountSnapshot, len(named))
+	for name, c := range named {
+		snapshots[name] = c.Snapshot()
+	}
+	return snapshots
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import "testing"
+
+func TestKeyedCounterCountsPerKey(t *testing.T) {
+	c := NewKeyedCounter()
+	c.Inc("client-1")
+	c.Inc("client-1")
+	c.Inc("client-2")
+
+	s := c.Snapshot()
+	if s["client-1"].Count != 2 || s["client-2"].Count != 1 || s["client-1"].LastAt.IsZero() {
+		t.Errorf("Unexpected snapshot: %+v", s)
+	}
+}
+
+func TestCounterRegistry(t *testing.T) {
+	if Counter("test_counter") != Counter("test_counter") {
+		t.Error("Expected the same counter for the same name")
+	}
+	Counter("test_counter").Inc("client-1")
+	if s, ok := CounterSnapshots()["test_counter"]; !ok || s["client-1"].Count != 1 {
+		t.Errorf("Expected the registered counter in snapshots, got %v", s)
+	}
+}
@@ -0,0 +1,118 @@
+// Package metrics keeps lightweight in-process latency statistics.
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultWindow is how many recent samples a recorder keeps for percentiles
+const defaultWindow = 1000
+
+// LatencySnapshot summarizes the samples held by a recorder
+type LatencySnapshot struct {
+	Count int64   `json:"count"` // total observations since start
+	AvgMs float64 `json:"avg_ms"`
+	P50Ms int64   `json:"p50_ms"`
+	P95Ms int64   `json:"p95_ms"`
+	MaxMs int64   `json:"max_ms"`
+}
+
+// LatencyRecorder records durations in a fixed-size ring of recent samples
+type LatencyRecorder struct {
+	mutex   sync.Mutex
+	samples []int64 // milliseconds
+	next    int
+	count   int64
+}
+
+// NewLatencyRecorder creates a recorder keeping the last window samples
+func NewLatencyRecorder(window int) *LatencyRecorder {
+	if window <= 0 {
+		window = defaultWindow
+	}
+	return &LatencyRecorder{samples: make([]int64, 0, window)}
+}
+
+// Observe records one duration
+func (r *LatencyRecorder) Observe(d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ms := d.Milliseconds()
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, ms)
+	} else {
+		r.samples[r.next] = ms
+		r.next = (r.next + 1) % len(r.samples)
+	}
+	r.count++
+}
+
+// Snapshot returns statistics over the recent samples
+func (r *LatencyRecorder) Snapshot() LatencySnapshot {
+	r.mutex.Lock()
+	sorted := append([]int64(nil), r.samples...)
+	count := r.count
+	r.mutex.Unlock()
+
+	snapshot := LatencySnapshot{Count: count}
+	if len(sorted) == 0 {
+		return snapshot
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum int64
+	for _, v := range sorted {
+		sum += v
+	}
+	snapshot.AvgMs = float64(sum) / float64(len(sorted))
+	snapshot.P50Ms = percentile(sorted, 50)
+	snapshot.P95Ms = percentile(sorted, 95)
+	snapshot.MaxMs = sorted[len(sorted)-1]
+	return snapshot
+}
+
+// percentile uses the nearest-rank method on sorted samples
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]*LatencyRecorder)
+)
+
+// Latency returns the named process-wide recorder, creating it on first use
+func Latency(name string) *LatencyRecorder {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if r, exists := registry[name]; exists {
+		return r
+	}
+	r := NewLatencyRecorder(defaultWindow)
+	registry[name] = r
+	return r
+}
+
+// LatencySnapshots returns snapshots of every named recorder
+func LatencySnapshots() map[string]LatencySnapshot {
+	registryMutex.Lock()
+	recorders := make(map[string]*LatencyRecorder, len(registry))
+	for name, r := range registry {
+		recorders[name] = r
+	}
+	registryMutex.Unlock()
+
+	snapshots := make(map[string]LatencySnapshot, len(recorders))
+	for name, r := range recorders {
+		snapshots[name] = r.Snapshot()
+	}
+	return snapshots
+}
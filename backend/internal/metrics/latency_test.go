@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorderSnapshot(t *testing.T) {
+	r := NewLatencyRecorder(100)
+	for i := 1; i <= 100; i++ {
+		r.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	s := r.Snapshot()
+	if s.Count != 100 || s.P50Ms != 50 || s.P95Ms != 95 || s.MaxMs != 100 || s.AvgMs != 50.5 {
+		t.Errorf("Unexpected snapshot: %+v", s)
+	}
+}
+
+func TestLatencyRecorderKeepsRecentWindow(t *testing.T) {
+	r := NewLatencyRecorder(3)
+	for _, ms := range []int{1000, 1, 2, 3} {
+		r.Observe(time.Duration(ms) * time.Millisecond)
+	}
+
+	s := r.Snapshot()
+	if s.Count != 4 {
+		t.Errorf("Expected total count 4, got %d", s.Count)
+	}
+	if s.MaxMs != 3 {
+		t.Errorf("Expected oldest sample to be evicted, max is %d", s.MaxMs)
+	}
+}
+
+func TestLatencyRegistry(t *testing.T) {
+	if Latency("test_latency") != Latency("test_latency") {
+		t.Error("Expected the same recorder for the same name")
+	}
+	Latency("test_latency").Observe(5 * time.Millisecond)
+	if s, ok := LatencySnapshots()["test_latency"]; !ok || s.Count != 1 {
+		t.Errorf("Expected registered snapshot, got %+v", s)
+	}
+}
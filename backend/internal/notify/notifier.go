@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// Notifier defaults
+const (
+	DefaultQueueSize        = 100
+	DefaultFailureThreshold = 3
+	DefaultFailureWindow    = 10 * time.Minute
+	// MinInterval is the shortest time between two emails to a client about the same event type
+	MinInterval = time.Hour
+)
+
+// Options configures a Notifier; zero values use the defaults
+type Options struct {
+	QueueSize        int
+	FailureThreshold int           // Consecutive LLM failures that trigger an email
+	FailureWindow    time.Duration // The failures must all fall within this window
+}
+
+// ErrQueueFull is returned when an email cannot be queued
+var ErrQueueFull = errors.New("notification queue is full")
+
+// pending is an email waiting for the worker
+type pending struct {
+	clientID  string
+	eventType string
+	data      interface{}
+	// Emails the client asked for, such as scheduled reports, are not rate limited
+	unthrottled bool
+}
+
+// Notifier turns failures into emails. Producers never block: emails wait in
+// a bounded queue for a single worker, which drops them when the client has
+// no settings for the event type or was emailed about it within MinInterval.
+type Notifier struct {
+	db      tools.DBConnection
+	sender  Sender
+	options Options
+
+	mutex    sync.Mutex
+	failures *failureWindow
+
+	queue chan pending
+	stop  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+
+	now func() time.Time
+}
+
+// NewNotifier creates a notifier; call Start to begin sending
+func NewNotifier(db tools.DBConnection, sender Sender, options Options) *Notifier {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = DefaultFailureThreshold
+	}
+	if options.FailureWindow <= 0 {
+		options.FailureWindow = DefaultFailureWindow
+	}
+	return &Notifier{
+		db:       db,
+		sender:   sender,
+		options:  options,
+		failures: newFailureWindow(options.FailureThreshold, options.FailureWindow),
+		queue:    make(chan pending, options.QueueSize),
+		stop:     make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// Start launches the worker
+func (n *Notifier) Start() {
+	n.wg.Add(1)
+	go n.worker()
+}
+
+// Stop stops the worker after its current email; queued emails are discarded
+func (n *Notifier) Stop() {
+	n.once.Do(func() { close(n.stop) })
+	n.wg.Wait()
+}
+
+// LLMFailed records a failed LLM stream. Once FailureThreshold failures in a
+// row fall within FailureWindow, one email listing them all is queued.
+func (n *Notifier) LLMFailed(clientID string, failure LLMFailure) {
+	if clientID == "" {
+		return
+	}
+	if failure.At.IsZero() {
+		failure.At = n.now()
+	}
+	n.mutex.Lock()
+	batch := n.failures.add(clientID, failure)
+	n.mutex.Unlock()
+
+	if batch != nil {
+		n.enqueue(pending{clientID: clientID, eventType: EventLLMFailures, data: LLMFailures{
+			ClientID: clientID,
+			Window:   n.options.FailureWindow,
+			Failures: batch,
+		}})
+	}
+}
+
+// LLMSucceeded ends a client's run of LLM failures
+func (n *Notifier) LLMSucceeded(clientID string) {
+	n.mutex.Lock()
+	n.failures.reset(clientID)
+	n.mutex.Unlock()
+}
+
+// WebhookFailed queues an email about a webhook that exhausted its retries
+func (n *Notifier) WebhookFailed(failure WebhookFailure) {
+	if failure.ClientID == "" {
+		return
+	}
+	n.enqueue(pending{clientID: failure.ClientID, eventType: EventWebhookFailed, data: failure})
+}
+
+// SaveAbandoned queues an email about a conversation write that was given up on
+func (n *Notifier) SaveAbandoned(failure SaveFailure) {
+	if failure.ClientID == "" {
+		return
+	}
+	n.enqueue(pending{clientID: failure.ClientID, eventType: EventSaveAbandoned, data: failure})
+}
+
+// ScheduledReport queues an email with the reply to a scheduled prompt. Unlike
+// failure emails it is checked against the client's settings right away, so
+// the schedule can record why a report was not sent, and it is never rate limited.
+func (n *Notifier) ScheduledReport(ctx context.Context, report ScheduledReport) error {
+	settings, err := GetSettings(ctx, n.db, report.ClientID)
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled(EventScheduledReport) {
+		return fmt.Errorf("%s emails are turned off in the client's notification settings", EventScheduledReport)
+	}
+	if !n.enqueue(pending{clientID: report.ClientID, eventType: EventScheduledReport, data: report, unthrottled: true}) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+func (n *Notifier) enqueue(p pending) bool {
+	select {
+	case n.queue <- p:
+		return true
+	default:
+		log.Printf("Notification queue full, dropping %s email for client %s", p.eventType, p.clientID)
+		return false
+	}
+}
+
+func (n *Notifier) worker() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case p := <-n.queue:
+			if err := n.deliver(context.Background(), p); err != nil {
+				log.Printf("Failed to notify client %s of %s: %v", p.clientID, p.eventType, err)
+			}
+		}
+	}
+}
+
+// deliver sends one email unless the client does not want it or was emailed
+// about the same event type within MinInterval, and records the attempt
+func (n *Notifier) deliver(ctx context.Context, p pending) error {
+	settings, err := GetSettings(ctx, n.db, p.clientID)
+	if errors.Is(err, ErrSettingsNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !settings.Enabled(p.eventType) {
+		return nil
+	}
+
+	now := n.now().UTC()
+	if !p.unthrottled {
+		recent, err := sentSince(ctx, n.db, p.clientID, p.eventType, now.Add(-MinInterval))
+		if err != nil {
+			return err
+		}
+		if recent {
+			log.Printf("Rate limited %s email for client %s", p.eventType, p.clientID)
+			return nil
+		}
+	}
+
+	subject, body, err := Render(p.eventType, p.data)
+	if err != nil {
+		return err
+	}
+	notification := &Notification{
+		ClientID:  p.clientID,
+		EventType: p.eventType,
+		Recipient: settings.Email,
+		Subject:   subject,
+		Status:    StatusSent,
+		CreatedAt: now,
+	}
+	if err := n.sender.Send(ctx, Message{To: []string{settings.Email}, Subject: subject, Body: body}); err != nil {
+		notification.Status = StatusFailed
+		notification.Error = err.Error()
+	}
+	return recordNotification(ctx, n.db, notification)
+}
+
+// failureWindow counts each client's consecutive LLM failures. Failures older
+// than the window no longer count towards the threshold.
+type failureWindow struct {
+	threshold int
+	window    time.Duration
+	clients   map[string][]LLMFailure
+}
+
+func newFailureWindow(threshold int, window time.Duration) *failureWindow {
+	return &failureWindow{threshold: threshold, window: window, clients: make(map[string][]LLMFailure)}
+}
+
+// add records a failure and returns the failures to report once threshold of
+// them fall within the window, starting a new run
+func (w *failureWindow) add(clientID string, failure LLMFailure) []LLMFailure {
+	var run []LLMFailure
+	for _, f := range w.clients[clientID] {
+		if failure.At.Sub(f.At) < w.window {
+			run = append(run, f)
+		}
+	}
+	run = append(run, failure)
+
+	if len(run) >= w.threshold {
+		delete(w.clients, clientID)
+		return run
+	}
+	w.clients[clientID] = run
+	return nil
+}
+
+// reset forgets a client's failures after a success
+func (w *failureWindow) reset(clientID string) {
+	delete(w.clients, clientID)
+}
@@ -0,0 +1,256 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb,
+		"INSERT INTO clients (id, name, slug) VALUES ('client-1', 'One', 'one')",
+		"INSERT INTO clients (id, name, slug) VALUES ('client-2', 'Two', 'two')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+// fakeSender records messages instead of sending them
+type fakeSender struct {
+	mutex    sync.Mutex
+	messages []Message
+	err      error
+}
+
+func (s *fakeSender) Send(ctx context.Context, msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, msg)
+	return s.err
+}
+
+func (s *fakeSender) sent() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// newTestNotifier returns a notifier with a fake sender and a clock tests can move
+func newTestNotifier(t *testing.T, options Options) (*Notifier, *fakeSender, *time.Time) {
+	t.Helper()
+	database := newTestDB(t)
+	if err := SaveSettings(context.Background(), database, &Settings{ClientID: "client-1", Email: "Ops <ops@example.com>"}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	sender := &fakeSender{}
+	notifier := NewNotifier(database, sender, options)
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	notifier.now = func() time.Time { return now }
+	return notifier, sender, &now
+}
+
+// drain delivers every queued email on the calling goroutine
+func drain(t *testing.T, n *Notifier) {
+	t.Helper()
+	for {
+		select {
+		case p := <-n.queue:
+			if err := n.deliver(context.Background(), p); err != nil {
+				t.Fatalf("Failed to deliver %s: %v", p.eventType, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	failure := func(minutes int) LLMFailure {
+		return LLMFailure{At: start.Add(time.Duration(minutes) * time.Minute), ConversationID: "conv"}
+	}
+
+	tests := []struct {
+		name     string
+		steps    []int // Minutes after start; -1 is a success
+		reported []int // Number of failures in each report, in order
+	}{
+		{"below threshold", []int{0, 1}, nil},
+		{"threshold within window", []int{0, 1, 2}, []int{3}},
+		{"one report per run", []int{0, 1, 2, 3, 4}, []int{3}},
+		{"next run reported again", []int{0, 1, 2, 3, 4, 5}, []int{3, 3}},
+		{"success resets the run", []int{0, 1, -1, 2, 3}, nil},
+		{"old failures expire", []int{0, 1, 11, 12}, nil},
+		{"expired failures leave the rest", []int{0, 5, 11, 12}, []int{3}},
+		{"other clients are separate", []int{0, 1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newFailureWindow(3, 10*time.Minute)
+			var reported []int
+			for _, step := range tt.steps {
+				if step < 0 {
+					w.reset("client-1")
+					continue
+				}
+				if batch := w.add("client-1", failure(step)); batch != nil {
+					reported = append(reported, len(batch))
+				}
+				w.add("client-2", failure(step))
+				w.reset("client-2")
+			}
+			if len(reported) != len(tt.reported) {
+				t.Fatalf("Expected reports %v, got %v", tt.reported, reported)
+			}
+			for i := range reported {
+				if reported[i] != tt.reported[i] {
+					t.Errorf("Expected reports %v, got %v", tt.reported, reported)
+				}
+			}
+		})
+	}
+}
+
+func TestLLMFailuresSendOneAggregatedEmail(t *testing.T) {
+	notifier, sender, now := newTestNotifier(t, Options{FailureThreshold: 3, FailureWindow: 10 * time.Minute})
+
+	for i := 0; i < 5; i++ {
+		notifier.LLMFailed("client-1", LLMFailure{ConversationID: "conv", Error: "upstream timeout"})
+		*now = now.Add(time.Minute)
+	}
+	drain(t, notifier)
+
+	sent := sender.sent()
+	if len(sent) != 1 {
+		t.Fatalf("Expected one email for five failures, got %d", len(sent))
+	}
+	if sent[0].To[0] != "ops@example.com" || strings.Count(sent[0].Body, "upstream timeout") != 3 {
+		t.Errorf("Expected the three failures of the run mailed to ops@example.com, got %+v", sent[0])
+	}
+
+	notifications, err := ListNotifications(context.Background(), notifier.db, "client-1", 10)
+	if err != nil {
+		t.Fatalf("Failed to list notifications: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Status != StatusSent || notifications[0].EventType != EventLLMFailures {
+		t.Errorf("Expected the send recorded, got %+v", notifications)
+	}
+}
+
+func TestNotificationsAreRateLimited(t *testing.T) {
+	notifier, sender, now := newTestNotifier(t, Options{})
+	failure := WebhookFailure{ClientID: "client-1", WebhookID: "webhook-1", URL: "https://example.com", Attempts: 5}
+
+	notifier.WebhookFailed(failure)
+	drain(t, notifier)
+	*now = now.Add(59 * time.Minute)
+	notifier.WebhookFailed(failure)
+	drain(t, notifier)
+	if len(sender.sent()) != 1 {
+		t.Fatalf("Expected a second email within the hour to be dropped, got %d", len(sender.sent()))
+	}
+
+	// Other event types have their own limit
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		notifier.LLMFailed("client-1", LLMFailure{Error: "boom"})
+	}
+	drain(t, notifier)
+	if len(sender.sent()) != 2 {
+		t.Fatalf("Expected the LLM failure email despite the webhook email, got %d", len(sender.sent()))
+	}
+
+	*now = now.Add(2 * time.Minute)
+	notifier.WebhookFailed(failure)
+	drain(t, notifier)
+	if len(sender.sent()) != 3 {
+		t.Errorf("Expected an email once the hour passed, got %d", len(sender.sent()))
+	}
+}
+
+func TestFailedSendsAreRecordedAndNotRateLimited(t *testing.T) {
+	notifier, sender, _ := newTestNotifier(t, Options{})
+	sender.err = errors.New("connection refused")
+	failure := WebhookFailure{ClientID: "client-1", WebhookID: "webhook-1", URL: "https://example.com", Attempts: 5}
+
+	notifier.WebhookFailed(failure)
+	drain(t, notifier)
+	sender.err = nil
+	notifier.WebhookFailed(failure)
+	drain(t, notifier)
+
+	notifications, err := ListNotifications(context.Background(), notifier.db, "client-1", 10)
+	if err != nil {
+		t.Fatalf("Failed to list notifications: %v", err)
+	}
+	if len(notifications) != 2 {
+		t.Fatalf("Expected both attempts recorded, got %+v", notifications)
+	}
+	statuses := notifications[0].Status + "," + notifications[1].Status
+	if !strings.Contains(statuses, StatusFailed) || !strings.Contains(statuses, StatusSent) {
+		t.Errorf("Expected a failed and a sent notification, got %s", statuses)
+	}
+}
+
+func TestScheduledReportsAreNotRateLimited(t *testing.T) {
+	notifier, sender, _ := newTestNotifier(t, Options{})
+	ctx := context.Background()
+	report := ScheduledReport{ClientID: "client-1", ScheduleID: "schedule-1", Name: "Hourly sales", Content: "Up 4%"}
+
+	for i := 0; i < 2; i++ {
+		if err := notifier.ScheduledReport(ctx, report); err != nil {
+			t.Fatalf("ScheduledReport failed: %v", err)
+		}
+		drain(t, notifier)
+	}
+	if len(sender.sent()) != 2 {
+		t.Fatalf("Expected every report emailed, got %d", len(sender.sent()))
+	}
+
+	// Reports that cannot be sent are refused right away
+	if err := notifier.ScheduledReport(ctx, ScheduledReport{ClientID: "client-2"}); !errors.Is(err, ErrSettingsNotFound) {
+		t.Errorf("Expected ErrSettingsNotFound for a client without settings, got %v", err)
+	}
+	if err := SaveSettings(ctx, notifier.db, &Settings{ClientID: "client-1", Email: "ops@example.com", EventTypes: []string{EventLLMFailures}}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	if err := notifier.ScheduledReport(ctx, report); err == nil || !strings.Contains(err.Error(), "turned off") {
+		t.Errorf("Expected reports turned off in the settings refused, got %v", err)
+	}
+}
+
+func TestNotificationsFollowSettings(t *testing.T) {
+	notifier, sender, _ := newTestNotifier(t, Options{})
+	ctx := context.Background()
+
+	// Clients without settings are not emailed
+	notifier.WebhookFailed(WebhookFailure{ClientID: "client-2", URL: "https://example.com"})
+	drain(t, notifier)
+
+	// Disabled event types are not emailed
+	if err := SaveSettings(ctx, notifier.db, &Settings{ClientID: "client-1", Email: "ops@example.com", EventTypes: []string{EventLLMFailures}}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	notifier.WebhookFailed(WebhookFailure{ClientID: "client-1", URL: "https://example.com"})
+	drain(t, notifier)
+
+	if len(sender.sent()) != 0 {
+		t.Errorf("Expected no emails, got %+v", sender.sent())
+	}
+
+	for _, settings := range []Settings{
+		{ClientID: "client-1", Email: "not an address"},
+		{ClientID: "client-1", Email: "ops@example.com", EventTypes: []string{"conversation_created"}},
+	} {
+		if err := SaveSettings(ctx, notifier.db, &settings); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected ErrInvalidSettings for %+v, got %v", settings, err)
+		}
+	}
+}
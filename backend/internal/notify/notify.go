@@ -0,0 +1,239 @@
+// Package notify emails a client's operators when something breaks while
+// nobody is watching: repeated LLM stream failures, webhooks that exhaust
+// their retries and conversation writes the database never accepted. It also
+// emails the replies of scheduled prompts delivered by email. Emails are
+// rendered from embedded templates, sent over SMTP by a background worker and
+// recorded in the notifications table.
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Event types a client can be notified about
+const (
+	EventLLMFailures     = "llm_failures"
+	EventWebhookFailed   = "webhook_failed"
+	EventSaveAbandoned   = "save_abandoned"
+	EventScheduledReport = "scheduled_report"
+)
+
+// EventTypes lists every event type that can be emailed
+var EventTypes = []string{
+	EventLLMFailures,
+	EventWebhookFailed,
+	EventSaveAbandoned,
+	EventScheduledReport,
+}
+
+// Notification statuses
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+var (
+	// ErrSettingsNotFound is returned when a client has no notification settings
+	ErrSettingsNotFound = errors.New("notification settings not found")
+	// ErrInvalidSettings is returned for a missing or malformed email or an unknown event type
+	ErrInvalidSettings = errors.New("invalid notification settings")
+)
+
+// SettingsError reports an invalid field of notification settings
+type SettingsError struct {
+	Field  string
+	Reason string
+}
+
+func (e *SettingsError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrInvalidSettings, e.Field, e.Reason)
+}
+
+func (e *SettingsError) Unwrap() error {
+	return ErrInvalidSettings
+}
+
+// Settings says where a client's notifications go. An empty EventTypes
+// enables every event type.
+type Settings struct {
+	ClientID   string    `json:"client_id"`
+	Email      string    `json:"email"`
+	EventTypes []string  `json:"event_types"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Enabled reports whether the settings want emails for the given event type
+func (s *Settings) Enabled(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is one email sent, or attempted, to a client
+type Notification struct {
+	ID        string    `json:"id"`
+	ClientID  string    `json:"client_id"`
+	EventType string    `json:"event_type"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetSettings returns a client's notification settings
+func GetSettings(ctx context.Context, db tools.DBConnection, clientID string) (*Settings, error) {
+	rows, err := db.Query(ctx,
+		`SELECT client_id, email, event_types, updated_at FROM notification_settings WHERE client_id = $1`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification settings: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrSettingsNotFound
+	}
+	var s Settings
+	var eventTypes []byte
+	if err := rows.Scan(&s.ClientID, &s.Email, &eventTypes, &s.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan notification settings: %w", err)
+	}
+	if len(eventTypes) > 0 {
+		if err := json.Unmarshal(eventTypes, &s.EventTypes); err != nil {
+			return nil, fmt.Errorf("invalid event types for client %s: %w", clientID, err)
+		}
+	}
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	return &s, nil
+}
+
+// SaveSettings validates and stores a client's notification settings, replacing any previous ones
+func SaveSettings(ctx context.Context, db tools.DBConnection, settings *Settings) error {
+	address, err := mail.ParseAddress(strings.TrimSpace(settings.Email))
+	if err != nil {
+		return &SettingsError{Field: "email", Reason: "must be a valid address"}
+	}
+	settings.Email = address.Address
+	for _, t := range settings.EventTypes {
+		if !knownEventType(t) {
+			return &SettingsError{Field: "event_types", Reason: fmt.Sprintf("has unknown event type %q", t)}
+		}
+	}
+	if settings.EventTypes == nil {
+		settings.EventTypes = []string{}
+	}
+	eventTypes, err := json.Marshal(settings.EventTypes)
+	if err != nil {
+		return fmt.Errorf("failed to encode event types: %w", err)
+	}
+
+	settings.UpdatedAt = time.Now().UTC()
+	if _, err := db.Exec(ctx, "DELETE FROM notification_settings WHERE client_id = $1", settings.ClientID); err != nil {
+		return fmt.Errorf("failed to replace notification settings: %w", err)
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO notification_settings (client_id, email, event_types, updated_at) VALUES ($1, $2, $3, $4)`,
+		settings.ClientID, settings.Email, string(eventTypes), settings.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save notification settings: %w", err)
+	}
+	return nil
+}
+
+// DeleteSettings turns a client's notifications off
+func DeleteSettings(ctx context.Context, db tools.DBConnection, clientID string) error {
+	result, err := db.Exec(ctx, "DELETE FROM notification_settings WHERE client_id = $1", clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification settings: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrSettingsNotFound
+	}
+	return nil
+}
+
+// ListNotifications returns the most recent notifications, newest first, optionally for one client
+func ListNotifications(ctx context.Context, db tools.DBConnection, clientID string, limit int) ([]Notification, error) {
+	query := `SELECT id, client_id, event_type, recipient, subject, status, error, created_at FROM notifications`
+	var args []interface{}
+	if clientID != "" {
+		args = append(args, clientID)
+		query += " WHERE client_id = $1"
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var sendErr sql.NullString
+		if err := rows.Scan(&n.ID, &n.ClientID, &n.EventType, &n.Recipient, &n.Subject, &n.Status, &sendErr, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		n.Error = sendErr.String
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// recordNotification stores one send attempt
+func recordNotification(ctx context.Context, db tools.DBConnection, n *Notification) error {
+	var sendErr interface{}
+	if n.Error != "" {
+		sendErr = n.Error
+	}
+	_, err := db.Exec(ctx,
+		`INSERT INTO notifications (id, client_id, event_type, recipient, subject, status, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		uuid.New().String(), n.ClientID, n.EventType, n.Recipient, n.Subject, n.Status, sendErr, n.CreatedAt)
+	return err
+}
+
+// sentSince reports whether a client was emailed about an event type after the given time
+func sentSince(ctx context.Context, db tools.DBConnection, clientID, eventType string, since time.Time) (bool, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id FROM notifications WHERE client_id = $1 AND event_type = $2 AND status = $3 AND created_at > $4 LIMIT 1`,
+		clientID, eventType, StatusSent, since)
+	if err != nil {
+		return false, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+	found := rows.Next()
+	return found, rows.Err()
+}
+
+func knownEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
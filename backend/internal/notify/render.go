@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// One template per event type, named after it, each defining "subject" and "body"
+var emailTemplates = func() map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(EventTypes))
+	for _, eventType := range EventTypes {
+		parsed[eventType] = template.Must(template.ParseFS(templateFiles, "templates/"+eventType+".tmpl"))
+	}
+	return parsed
+}()
+
+// LLMFailure is one failed LLM stream
+type LLMFailure struct {
+	At             time.Time
+	ConversationID string
+	ProjectID      string
+	Model          string
+	Error          string
+}
+
+// LLMFailures is the data of an EventLLMFailures email
+type LLMFailures struct {
+	ClientID string
+	Window   time.Duration
+	Failures []LLMFailure
+}
+
+// WebhookFailure is the data of an EventWebhookFailed email: a webhook that
+// exhausted its retries for one event
+type WebhookFailure struct {
+	ClientID   string
+	WebhookID  string
+	URL        string
+	EventID    string
+	EventType  string
+	Attempts   int
+	StatusCode int
+	Error      string
+}
+
+// SaveFailure is the data of an EventSaveAbandoned email: a message or
+// conversation status write retried until it was too old
+type SaveFailure struct {
+	ClientID       string
+	ConversationID string
+	Kind           string
+	FailedAt       time.Time
+	Error          string
+}
+
+// ScheduledReport is the data of an EventScheduledReport email: the reply to
+// a scheduled prompt delivered by email
+type ScheduledReport struct {
+	ClientID       string
+	ProjectID      string
+	ScheduleID     string
+	Name           string
+	ConversationID string
+	Content        string
+	RanAt          time.Time
+}
+
+// Render returns the subject and body of the email for an event type
+func Render(eventType string, data interface{}) (string, string, error) {
+	tmpl, ok := emailTemplates[eventType]
+	if !ok {
+		return "", "", fmt.Errorf("no email template for event type %q", eventType)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s subject: %w", eventType, err)
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s body: %w", eventType, err)
+	}
+	// Headers cannot span lines
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderLLMFailures(t *testing.T) {
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	subject, body, err := Render(EventLLMFailures, LLMFailures{
+		ClientID: "client-1",
+		Window:   10 * time.Minute,
+		Failures: []LLMFailure{
+			{At: at, ConversationID: "conv-1", Model: "gpt-4o", Error: "429 Too Many Requests"},
+			{At: at.Add(time.Minute), ConversationID: "conv-2", Error: "connection reset"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "[Zlay] 2 AI responses failed in a row" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	for _, want := range []string{
+		"client client-1 failed within 10m0s",
+		"- 2024-03-01 09:30:00 UTC (gpt-4o), conversation conv-1\n  429 Too Many Requests",
+		"- 2024-03-01 09:31:00 UTC, conversation conv-2\n  connection reset",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected body to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderWebhookFailed(t *testing.T) {
+	failure := WebhookFailure{
+		ClientID:  "client-1",
+		WebhookID: "webhook-1",
+		URL:       "https://example.com/hook",
+		EventID:   "event-1",
+		EventType: "conversation_completed",
+		Attempts:  5,
+		Error:     "dial tcp: connection refused",
+	}
+	subject, body, err := Render(EventWebhookFailed, failure)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "[Zlay] Webhook delivery failed: https://example.com/hook" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "after 5 attempts") || strings.Contains(body, "Status:") ||
+		!strings.Contains(body, "/api/admin/webhooks/webhook-1/deliveries") {
+		t.Errorf("Unexpected body without a status code:\n%s", body)
+	}
+
+	failure.StatusCode = 503
+	if _, body, _ := Render(EventWebhookFailed, failure); !strings.Contains(body, "Status:   503") {
+		t.Errorf("Expected the status code in the body:\n%s", body)
+	}
+}
+
+func TestRenderSaveAbandoned(t *testing.T) {
+	subject, body, err := Render(EventSaveAbandoned, SaveFailure{
+		ClientID:       "client-1",
+		ConversationID: "conv-1",
+		Kind:           "insert_message",
+		FailedAt:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Error:          "database is locked",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "[Zlay] A conversation write was lost after retries" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	for _, want := range []string{"insert_message write for client client-1", "2024-05-01 12:00:00 UTC", "conv-1", "database is locked"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the body:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderScheduledReport(t *testing.T) {
+	subject, body, err := Render(EventScheduledReport, ScheduledReport{
+		ClientID:       "client-1",
+		ProjectID:      "project-1",
+		ScheduleID:     "schedule-1",
+		Name:           "Weekly sales",
+		ConversationID: "conv-1",
+		Content:        "Sales were up 4% on last week.",
+		RanAt:          time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "[Zlay] Scheduled report: Weekly sales" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if !strings.HasPrefix(body, "Sales were up 4% on last week.") {
+		t.Errorf("Expected the reply to open the body:\n%s", body)
+	}
+	for _, want := range []string{"2024-05-06 09:00:00 UTC", "project-1", "schedule-1", "conv-1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the body:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderKeepsSubjectOnOneLine(t *testing.T) {
+	subject, _, err := Render(EventWebhookFailed, WebhookFailure{URL: "https://example.com/a\r\nBcc: x@example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.ContainsAny(subject, "\r\n") {
+		t.Errorf("Expected a single-line subject, got %q", subject)
+	}
+}
+
+func TestRenderUnknownEventType(t *testing.T) {
+	if _, _, err := Render("unknown", nil); err == nil {
+		t.Error("Expected an error for an event type without a template")
+	}
+}
+
+func TestComposeMessage(t *testing.T) {
+	raw := string(composeMessage("alerts@example.com", Message{
+		To:      []string{"ops@example.com"},
+		Subject: "Gagal kirim ✉",
+		Body:    "line one\nline two",
+	}, time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: alerts@example.com\r\n",
+		"To: ops@example.com\r\n",
+		"Subject: =?utf-8?q?Gagal_kirim_=E2=9C=89?=\r\n",
+		"Date: Fri, 01 Mar 2024 09:30:00 +0000\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("Expected message to contain %q, got:\n%s", want, raw)
+		}
+	}
+}
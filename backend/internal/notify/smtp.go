@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTP connection security modes
+const (
+	TLSStartTLS = "starttls" // Plain connection upgraded with STARTTLS; the server must support it
+	TLSImplicit = "tls"      // TLS from the first byte, usually port 465
+	TLSNone     = "none"     // No encryption, for local relays only
+)
+
+// DefaultSMTPTimeout bounds one whole SMTP conversation
+const DefaultSMTPTimeout = 30 * time.Second
+
+// Message is a plain-text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails. Send must honour the context's deadline.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPConfig configures an SMTPSender; Username may be empty for relays
+// without authentication
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	TLS      string // One of TLSStartTLS, TLSImplicit or TLSNone; empty means TLSStartTLS
+	Timeout  time.Duration
+}
+
+// SMTPSender sends email through an SMTP server
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPSender creates a sender; the server is only contacted when sending
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	if config.TLS == "" {
+		config.TLS = TLSStartTLS
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultSMTPTimeout
+	}
+	return &SMTPSender{config: config}
+}
+
+// Send delivers one message over a new connection
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	tlsConfig := &tls.Config{ServerName: s.config.Host}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if s.config.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if s.config.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.config.From); err != nil {
+		return fmt.Errorf("SMTP server refused sender: %w", err)
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP server refused recipient %s: %w", to, err)
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := data.Write(composeMessage(s.config.From, msg, time.Now())); err != nil {
+		data.Close()
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// composeMessage builds the RFC 5322 message: UTF-8 headers are Q-encoded and
+// the body is quoted-printable with CRLF line endings
+func composeMessage(from string, msg Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", from)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+uuid.New().String()+"@zlay>")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	writer := quotedprintable.NewWriter(&buf)
+	writer.Write([]byte(body))
+	writer.Close()
+	return buf.Bytes()
+}
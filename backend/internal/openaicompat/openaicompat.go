@@ -0,0 +1,281 @@
+// Package openaicompat translates between the OpenAI chat completions API and
+// zlay's LLM requests for POST /v1/chat/completions, so tooling written for
+// OpenAI can run through a client's configured provider. Request fields zlay
+// cannot honour are rejected with an OpenAI error instead of being ignored,
+// and responses follow the OpenAI envelope for both plain and streamed
+// completions.
+package openaicompat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"zlay-backend/internal/llm"
+)
+
+const (
+	// DefaultTemperature is OpenAI's default, used when a request sets none
+	DefaultTemperature = 1.0
+	// MaxTemperature is the highest temperature OpenAI accepts
+	MaxTemperature = 2.0
+)
+
+// Error types of the OpenAI error envelope
+const (
+	TypeInvalidRequest    = "invalid_request_error"
+	TypeAuthentication    = "authentication_error"
+	TypePermission        = "permission_error"
+	TypeNotFound          = "not_found_error"
+	TypeRateLimit         = "rate_limit_error"
+	TypeInsufficientQuota = "insufficient_quota"
+	TypeServer            = "server_error"
+)
+
+// supportedFields are the request fields an LLM request can carry. Anything
+// else with a non-null value is rejected.
+var supportedFields = map[string]bool{
+	"model":                 true,
+	"messages":              true,
+	"tools":                 true,
+	"temperature":           true,
+	"max_tokens":            true,
+	"max_completion_tokens": true,
+	"stream":                true,
+	"stream_options":        true,
+	"n":                     true, // Only 1
+	"user":                  true, // Accepted for compatibility; usage is attributed to the API key
+}
+
+// contentRoles are the roles whose content must be a string or an array of parts
+var contentRoles = map[string]bool{"system": true, "developer": true, "user": true, "tool": true}
+
+// Error is an OpenAI API error
+type Error struct {
+	Status  int     `json:"-"`
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Envelope wraps the error the way OpenAI responds with it
+func (e *Error) Envelope() map[string]*Error {
+	return map[string]*Error{"error": e}
+}
+
+// NewError builds an error; empty param and code are sent as null
+func NewError(status int, errorType, param, code, message string) *Error {
+	e := &Error{Status: status, Message: message, Type: errorType}
+	if param != "" {
+		e.Param = &param
+	}
+	if code != "" {
+		e.Code = &code
+	}
+	return e
+}
+
+// TypeForStatus is the error type OpenAI uses for an HTTP status
+func TypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return TypeAuthentication
+	case status == http.StatusForbidden:
+		return TypePermission
+	case status == http.StatusNotFound:
+		return TypeNotFound
+	case status == http.StatusTooManyRequests:
+		return TypeRateLimit
+	case status >= 500:
+		return TypeServer
+	default:
+		return TypeInvalidRequest
+	}
+}
+
+func invalidRequest(param, code, message string) *Error {
+	return NewError(http.StatusBadRequest, TypeInvalidRequest, param, code, message)
+}
+
+// rawRequest holds the supported fields as sent, before they are checked
+type rawRequest struct {
+	Model               string
+	Messages            []json.RawMessage
+	Tools               []json.RawMessage
+	Temperature         *float64
+	MaxTokens           *int
+	MaxCompletionTokens *int
+	Stream              *bool
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	}
+	N *int
+}
+
+// Request is a parsed chat completion request
+type Request struct {
+	Model        string // Empty uses the client's model
+	Messages     []openai.ChatCompletionMessageParamUnion
+	Tools        []openai.ChatCompletionToolParam
+	Temperature  float64
+	MaxTokens    int // 0 leaves it to the provider
+	Stream       bool
+	IncludeUsage bool // stream_options.include_usage
+}
+
+// ParseRequest decodes and checks a request body. The error is ready to send.
+func ParseRequest(body []byte) (*Request, *Error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, invalidRequest("", "invalid_json", "The request body is not a valid JSON object.")
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !supportedFields[name] && !isNull(fields[name]) {
+			return nil, invalidRequest(name, "unsupported_parameter",
+				fmt.Sprintf("Unsupported parameter: '%s' is not supported by this server.", name))
+		}
+	}
+
+	var raw rawRequest
+	targets := map[string]interface{}{
+		"model":                 &raw.Model,
+		"messages":              &raw.Messages,
+		"tools":                 &raw.Tools,
+		"temperature":           &raw.Temperature,
+		"max_tokens":            &raw.MaxTokens,
+		"max_completion_tokens": &raw.MaxCompletionTokens,
+		"stream":                &raw.Stream,
+		"stream_options":        &raw.StreamOptions,
+		"n":                     &raw.N,
+	}
+	for _, name := range names {
+		target, decoded := targets[name]
+		if !decoded {
+			continue
+		}
+		if err := json.Unmarshal(fields[name], target); err != nil {
+			return nil, invalidRequest(name, "invalid_type", fmt.Sprintf("Invalid type for '%s'.", name))
+		}
+	}
+
+	if raw.N != nil && *raw.N != 1 {
+		return nil, invalidRequest("n", "unsupported_value", "Only n=1 is supported.")
+	}
+	req := &Request{
+		Model:       strings.TrimSpace(raw.Model),
+		Temperature: DefaultTemperature,
+		Stream:      raw.Stream != nil && *raw.Stream,
+	}
+	if raw.Temperature != nil {
+		if *raw.Temperature < 0 || *raw.Temperature > MaxTemperature {
+			return nil, invalidRequest("temperature", "invalid_value",
+				fmt.Sprintf("Invalid 'temperature': must be between 0 and %g.", MaxTemperature))
+		}
+		req.Temperature = *raw.Temperature
+	}
+	for _, limit := range []struct {
+		name  string
+		value *int
+	}{{"max_tokens", raw.MaxTokens}, {"max_completion_tokens", raw.MaxCompletionTokens}} {
+		if limit.value == nil {
+			continue
+		}
+		if *limit.value < 1 {
+			return nil, invalidRequest(limit.name, "invalid_value", fmt.Sprintf("Invalid '%s': must be at least 1.", limit.name))
+		}
+		req.MaxTokens = *limit.value
+	}
+	if raw.StreamOptions != nil {
+		if !req.Stream {
+			return nil, invalidRequest("stream_options", "invalid_value", "The 'stream_options' parameter is only allowed when 'stream' is enabled.")
+		}
+		req.IncludeUsage = raw.StreamOptions.IncludeUsage
+	}
+
+	if len(raw.Messages) == 0 {
+		return nil, invalidRequest("messages", "invalid_value", "Invalid 'messages': at least one message is required.")
+	}
+	for i, data := range raw.Messages {
+		message, err := parseMessage(data)
+		if err != "" {
+			param := fmt.Sprintf("messages[%d]", i)
+			return nil, invalidRequest(param, "invalid_value", fmt.Sprintf("Invalid '%s': %s.", param, err))
+		}
+		req.Messages = append(req.Messages, message)
+	}
+	for i, data := range raw.Tools {
+		var tool openai.ChatCompletionToolParam
+		var shape struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		param := fmt.Sprintf("tools[%d]", i)
+		if json.Unmarshal(data, &shape) != nil || shape.Type != "function" || shape.Function.Name == "" || json.Unmarshal(data, &tool) != nil {
+			return nil, invalidRequest(param, "invalid_value", fmt.Sprintf("Invalid '%s': expected a function with a name.", param))
+		}
+		req.Tools = append(req.Tools, tool)
+	}
+	return req, nil
+}
+
+// parseMessage decodes one message, returning why it is invalid otherwise
+func parseMessage(data json.RawMessage) (openai.ChatCompletionMessageParamUnion, string) {
+	var message openai.ChatCompletionMessageParamUnion
+	var shape struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if json.Unmarshal(data, &shape) != nil {
+		return message, "expected an object"
+	}
+	if err := json.Unmarshal(data, &message); err != nil || message.GetRole() == nil {
+		return message, fmt.Sprintf("unknown role '%s'", shape.Role)
+	}
+	if contentRoles[shape.Role] {
+		content := bytes.TrimSpace(shape.Content)
+		if len(content) == 0 || (content[0] != '"' && content[0] != '[') {
+			return message, "content must be a string or an array of content parts"
+		}
+	}
+	return message, ""
+}
+
+func isNull(value json.RawMessage) bool {
+	return string(bytes.TrimSpace(value)) == "null"
+}
+
+// LLMRequest is the request sent to the client's provider. model is empty to
+// use the client's default.
+func (r *Request) LLMRequest(model string) *llm.LLMRequest {
+	return &llm.LLMRequest{
+		Messages:    r.Messages,
+		Tools:       r.Tools,
+		Model:       model,
+		MaxTokens:   r.MaxTokens,
+		Temperature: float32(r.Temperature),
+	}
+}
+
+// EstimateTokens approximates the prompt and reply tokens at about four
+// characters each, for providers that report no usage and streams cut short
+func (r *Request) EstimateTokens(reply string) int {
+	prompt, _ := json.Marshal(r.Messages)
+	return (len(prompt)+len(reply))/4 + 1
+}
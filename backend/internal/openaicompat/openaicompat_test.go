@@ -0,0 +1,184 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"zlay-backend/internal/llm"
+)
+
+func TestParseRequest(t *testing.T) {
+	req, err := ParseRequest([]byte(`{
+		"model": "gpt-4o",
+		"messages": [
+			{"role": "system", "content": "Be brief"},
+			{"role": "user", "content": [{"type": "text", "text": "Weather?"}]},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "weather", "parameters": {"type": "object"}}}],
+		"temperature": 0.2,
+		"max_completion_tokens": 300,
+		"stream": true,
+		"stream_options": {"include_usage": true},
+		"n": 1,
+		"user": "alice",
+		"top_p": null
+	}`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	if req.Model != "gpt-4o" || len(req.Messages) != 4 || len(req.Tools) != 1 || req.Temperature != 0.2 ||
+		req.MaxTokens != 300 || !req.Stream || !req.IncludeUsage {
+		t.Errorf("Unexpected request %+v", req)
+	}
+	if role := req.Messages[3].GetRole(); role == nil || *role != "tool" {
+		t.Errorf("Expected the tool message kept, got %v", role)
+	}
+
+	llmReq := req.LLMRequest("")
+	if llmReq.Model != "" || llmReq.MaxTokens != 300 || llmReq.Temperature != 0.2 || len(llmReq.Tools) != 1 {
+		t.Errorf("Unexpected LLM request %+v", llmReq)
+	}
+
+	req, err = ParseRequest([]byte(`{"messages": [{"role": "user", "content": "Hi"}]}`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	if req.Model != "" || req.Temperature != DefaultTemperature || req.MaxTokens != 0 || req.Stream {
+		t.Errorf("Expected the defaults, got %+v", req)
+	}
+}
+
+func TestParseRequestRejectsWhatCannotBeHonoured(t *testing.T) {
+	cases := []struct {
+		body, param, code string
+	}{
+		{`{"messages": [{"role": "user", "content": "Hi"}], "logit_bias": {"50256": -100}}`, "logit_bias", "unsupported_parameter"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "n": 2}`, "n", "unsupported_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "top_p": 0.5}`, "top_p", "unsupported_parameter"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "response_format": {"type": "json_object"}}`, "response_format", "unsupported_parameter"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "temperature": 3}`, "temperature", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "max_tokens": 0}`, "max_tokens", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "stream_options": {"include_usage": true}}`, "stream_options", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "stream": "yes"}`, "stream", "invalid_type"},
+		{`{"messages": []}`, "messages", "invalid_value"},
+		{`{"model": "gpt-4o"}`, "messages", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}, {"role": "wizard", "content": "Hi"}]}`, "messages[1]", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": 5}]}`, "messages[0]", "invalid_value"},
+		{`{"messages": [{"role": "user"}]}`, "messages[0]", "invalid_value"},
+		{`{"messages": [{"role": "user", "content": "Hi"}], "tools": [{"type": "function", "function": {}}]}`, "tools[0]", "invalid_value"},
+		{`[]`, "", "invalid_json"},
+		{`not json`, "", "invalid_json"},
+	}
+	for _, c := range cases {
+		_, err := ParseRequest([]byte(c.body))
+		if err == nil {
+			t.Errorf("%s: expected an error", c.body)
+			continue
+		}
+		param := ""
+		if err.Param != nil {
+			param = *err.Param
+		}
+		if err.Status != http.StatusBadRequest || err.Type != TypeInvalidRequest || param != c.param || err.Code == nil || *err.Code != c.code {
+			t.Errorf("%s: expected %s for %q, got %+v (param %q)", c.body, c.code, c.param, err, param)
+		}
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	encoded, _ := json.Marshal(NewError(http.StatusUnauthorized, TypeForStatus(http.StatusUnauthorized), "", "api_key_invalid", "Invalid API key").Envelope())
+	if want := `{"error":{"message":"Invalid API key","type":"authentication_error","param":null,"code":"api_key_invalid"}}`; string(encoded) != want {
+		t.Errorf("Expected %s, got %s", want, encoded)
+	}
+	for status, want := range map[int]string{
+		http.StatusBadRequest:            TypeInvalidRequest,
+		http.StatusForbidden:             TypePermission,
+		http.StatusNotFound:              TypeNotFound,
+		http.StatusTooManyRequests:       TypeRateLimit,
+		http.StatusServiceUnavailable:    TypeServer,
+		http.StatusRequestEntityTooLarge: TypeInvalidRequest,
+	} {
+		if got := TypeForStatus(status); got != want {
+			t.Errorf("TypeForStatus(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestNewCompletion(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	completion := NewCompletion("chatcmpl-1", "gpt-4o", created, &llm.LLMResponse{
+		Content:    "Hello",
+		Usage:      openai.CompletionUsage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+		TokensUsed: 12,
+	})
+	encoded, _ := json.Marshal(completion)
+	want := `{"id":"chatcmpl-1","object":"chat.completion","created":1700000000,"model":"gpt-4o",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello","refusal":null},"finish_reason":"stop","logprobs":null}],` +
+		`"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`
+	if string(encoded) != want {
+		t.Errorf("Expected %s, got %s", want, encoded)
+	}
+
+	// A reply that only calls tools has null content
+	completion = NewCompletion("chatcmpl-2", "gpt-4o", created, &llm.LLMResponse{
+		ToolCalls:  []map[string]interface{}{{"id": "call_1", "type": "function", "function": map[string]string{"name": "weather", "arguments": "{}"}}},
+		TokensUsed: 9,
+	})
+	message := completion.Choices[0].Message
+	if message.Content != nil || !strings.Contains(string(message.ToolCalls), `"call_1"`) || completion.Choices[0].FinishReason != FinishToolCalls {
+		t.Errorf("Expected a tool call with null content, got %+v", completion.Choices[0])
+	}
+	if completion.Usage != (Usage{TotalTokens: 9}) {
+		t.Errorf("Expected only the total when the provider did not split it, got %+v", completion.Usage)
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	stream := NewStream("chatcmpl-1", "gpt-4o", time.Unix(1700000000, 0), true)
+	var chunks []Chunk
+	for _, chunk := range []*llm.StreamingChunk{
+		{Content: "Hel"},
+		{},
+		{Content: "lo"},
+		{Done: true, TokensUsed: 12},
+	} {
+		chunks = append(chunks, stream.Chunks(chunk)...)
+	}
+
+	var lines []string
+	for _, chunk := range chunks {
+		encoded, _ := json.Marshal(chunk)
+		lines = append(lines, string(encoded))
+	}
+	prefix := `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":`
+	want := []string{
+		prefix + `[{"index":0,"delta":{"role":"assistant","content":"Hel"},"finish_reason":null,"logprobs":null}]}`,
+		prefix + `[{"index":0,"delta":{"content":"lo"},"finish_reason":null,"logprobs":null}]}`,
+		prefix + `[{"index":0,"delta":{},"finish_reason":"stop","logprobs":null}]}`,
+		prefix + `[],"usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":12}}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected chunks\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(lines, "\n"))
+	}
+
+	// Without include_usage there is no usage chunk, and an empty reply still names the role
+	stream = NewStream("chatcmpl-2", "gpt-4o", time.Unix(1700000000, 0), false)
+	chunks = stream.Chunks(&llm.StreamingChunk{Done: true, TokensUsed: 3})
+	if len(chunks) != 2 || chunks[0].Choices[0].Delta.Role != "assistant" || *chunks[1].Choices[0].FinishReason != FinishStop {
+		t.Errorf("Unexpected chunks for an empty reply: %+v", chunks)
+	}
+
+	// Tool call deltas finish with tool_calls
+	stream = NewStream("chatcmpl-3", "gpt-4o", time.Unix(1700000000, 0), false)
+	stream.Chunks(&llm.StreamingChunk{ToolCalls: []map[string]interface{}{{"index": 0, "id": "call_1"}}})
+	chunks = stream.Chunks(&llm.StreamingChunk{Done: true})
+	if len(chunks) != 1 || *chunks[0].Choices[0].FinishReason != FinishToolCalls {
+		t.Errorf("Expected the stream to finish with tool_calls, got %+v", chunks)
+	}
+}
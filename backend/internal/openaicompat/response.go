@@ -0,0 +1,186 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openai/openai-go"
+	"zlay-backend/internal/llm"
+)
+
+// Finish reasons of a choice
+const (
+	FinishStop      = "stop"
+	FinishToolCalls = "tool_calls"
+)
+
+// Completion is the response to a request without stream
+type Completion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   Usage    `json:"usage"`
+}
+
+// Choice is the single choice of a completion
+type Choice struct {
+	Index        int       `json:"index"`
+	Message      Message   `json:"message"`
+	FinishReason string    `json:"finish_reason"`
+	Logprobs     *struct{} `json:"logprobs"` // Always null
+}
+
+// Message is the assistant's reply; content is null when it only calls tools
+type Message struct {
+	Role      string          `json:"role"`
+	Content   *string         `json:"content"`
+	Refusal   *string         `json:"refusal"` // Always null
+	ToolCalls json.RawMessage `json:"tool_calls,omitempty"`
+}
+
+// Usage counts the tokens of a completion. Providers that report only a
+// total, and streams, leave the prompt and completion split at zero.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Chunk is one server-sent event of a streamed completion
+type Chunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"` // Only on the last chunk, with include_usage
+}
+
+// ChunkChoice is the change a chunk makes to the single choice
+type ChunkChoice struct {
+	Index        int       `json:"index"`
+	Delta        Delta     `json:"delta"`
+	FinishReason *string   `json:"finish_reason"`
+	Logprobs     *struct{} `json:"logprobs"` // Always null
+}
+
+// Delta is the content or tool call fragment a chunk adds
+type Delta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   *string         `json:"content,omitempty"`
+	ToolCalls json.RawMessage `json:"tool_calls,omitempty"`
+}
+
+// NewID returns a completion ID in OpenAI's chatcmpl- form
+func NewID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// NewCompletion builds the response for a provider's complete answer
+func NewCompletion(id, model string, created time.Time, response *llm.LLMResponse) *Completion {
+	message := Message{Role: "assistant", ToolCalls: toolCalls(response.ToolCalls)}
+	if response.Content != "" || message.ToolCalls == nil {
+		message.Content = &response.Content
+	}
+	finishReason := FinishStop
+	if message.ToolCalls != nil {
+		finishReason = FinishToolCalls
+	}
+	return &Completion{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created.Unix(),
+		Model:   model,
+		Choices: []Choice{{Message: message, FinishReason: finishReason}},
+		Usage:   UsageOf(response),
+	}
+}
+
+// UsageOf returns the usage of a complete answer, split into prompt and
+// completion when the provider reported it
+func UsageOf(response *llm.LLMResponse) Usage {
+	if usage, ok := response.Usage.(openai.CompletionUsage); ok && usage.TotalTokens > 0 {
+		return Usage{
+			PromptTokens:     int(usage.PromptTokens),
+			CompletionTokens: int(usage.CompletionTokens),
+			TotalTokens:      int(usage.TotalTokens),
+		}
+	}
+	return Usage{TotalTokens: response.TokensUsed}
+}
+
+// Stream turns provider chunks into OpenAI chunks. The first chunk carries the
+// assistant role; the last one the finish reason, followed by a usage chunk
+// when the request asked for it.
+type Stream struct {
+	id           string
+	model        string
+	created      int64
+	includeUsage bool
+	started      bool
+	calledTools  bool
+}
+
+// NewStream starts a streamed completion
+func NewStream(id, model string, created time.Time, includeUsage bool) *Stream {
+	return &Stream{id: id, model: model, created: created.Unix(), includeUsage: includeUsage}
+}
+
+// Chunks returns the chunks to send for a provider chunk, none for an empty one
+func (s *Stream) Chunks(chunk *llm.StreamingChunk) []Chunk {
+	var chunks []Chunk
+	delta := Delta{ToolCalls: toolCalls(chunk.ToolCalls)}
+	if chunk.Content != "" {
+		delta.Content = &chunk.Content
+	}
+	if delta.ToolCalls != nil {
+		s.calledTools = true
+	}
+	if delta.Content != nil || delta.ToolCalls != nil || (chunk.Done && !s.started) {
+		if !s.started {
+			s.started = true
+			delta.Role = "assistant"
+			if delta.Content == nil && delta.ToolCalls == nil {
+				empty := ""
+				delta.Content = &empty
+			}
+		}
+		chunks = append(chunks, s.chunk(ChunkChoice{Delta: delta}))
+	}
+	if !chunk.Done {
+		return chunks
+	}
+
+	finishReason := FinishStop
+	if s.calledTools {
+		finishReason = FinishToolCalls
+	}
+	chunks = append(chunks, s.chunk(ChunkChoice{FinishReason: &finishReason}))
+	if s.includeUsage {
+		final := s.chunk()
+		final.Choices = []ChunkChoice{}
+		final.Usage = &Usage{TotalTokens: chunk.TokensUsed}
+		chunks = append(chunks, final)
+	}
+	return chunks
+}
+
+func (s *Stream) chunk(choices ...ChunkChoice) Chunk {
+	return Chunk{ID: s.id, Object: "chat.completion.chunk", Created: s.created, Model: s.model, Choices: choices}
+}
+
+// toolCalls encodes a provider's tool calls, nil when there are none
+func toolCalls(calls interface{}) json.RawMessage {
+	if calls == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(calls)
+	if err != nil || string(encoded) == "null" || string(encoded) == "[]" {
+		return nil
+	}
+	return encoded
+}
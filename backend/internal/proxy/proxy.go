@@ -0,0 +1,116 @@
+// Package proxy derives the client address and scheme of a request that may
+// have passed through reverse proxies. X-Forwarded-For and X-Forwarded-Proto
+// are only believed when the peer that sent them is a trusted proxy, so a
+// client connecting directly cannot forge its address or claim HTTPS.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Trust is the set of proxies whose forwarding headers are believed. A nil
+// Trust believes none, so requests are attributed to the connecting peer.
+type Trust struct {
+	networks []*net.IPNet
+}
+
+// New parses trusted proxies given as IP addresses or CIDR ranges
+func New(proxies []string) (*Trust, error) {
+	trust := &Trust{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trust.networks = append(trust.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", proxy)
+		}
+		trust.networks = append(trust.networks, network)
+	}
+	return trust, nil
+}
+
+// Trusted reports whether ip is a trusted proxy
+func (t *Trust) Trusted(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client behind any trusted proxies. The
+// X-Forwarded-For chain is walked from the nearest hop, and the first address
+// that is not a trusted proxy is the client; when every hop is trusted the
+// leftmost address is used.
+func (t *Trust) ClientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	if !t.Trusted(net.ParseIP(remote)) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// A malformed hop ends the chain that can be believed
+			return remote
+		}
+		if !t.Trusted(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	return remote
+}
+
+// Scheme returns "https" when the client reached the first proxy, or this
+// server, over TLS and "http" otherwise
+func (t *Trust) Scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if t.Trusted(net.ParseIP(remoteIP(r))) {
+		// The leftmost value was set by the proxy nearest the client
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if strings.EqualFold(strings.TrimSpace(proto), "https") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// remoteIP is the address of the peer connected to this server
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
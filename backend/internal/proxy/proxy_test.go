@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPAndScheme(t *testing.T) {
+	trust, err := New([]string{"10.0.0.0/8", " 192.168.1.5 ", "::1"})
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		trust      *Trust
+		remoteAddr string
+		forwarded  string
+		proto      string
+		wantIP     string
+		wantScheme string
+	}{
+		{"direct client", trust, "203.0.113.7:5000", "", "", "203.0.113.7", "http"},
+		{"forged headers from an untrusted peer", trust, "203.0.113.7:5000", "1.2.3.4", "https", "203.0.113.7", "http"},
+		{"trusted proxy", trust, "10.1.2.3:443", "198.51.100.9", "https", "198.51.100.9", "https"},
+		{"chain through trusted proxies", trust, "10.1.2.3:443", "198.51.100.9, 192.168.1.5", "https,http", "198.51.100.9", "https"},
+		// A client may prepend anything; only the hop appended by a trusted proxy counts
+		{"forged hop before the real client", trust, "10.1.2.3:443", "1.2.3.4, 198.51.100.9", "", "198.51.100.9", "http"},
+		{"every hop trusted", trust, "10.1.2.3:443", "10.9.9.9, 192.168.1.5", "", "10.9.9.9", "http"},
+		{"malformed hop", trust, "10.1.2.3:443", "198.51.100.9, bogus", "", "10.1.2.3", "http"},
+		{"trusted proxy without headers", trust, "[::1]:8080", "", "", "::1", "http"},
+		{"nil trust believes no one", nil, "10.1.2.3:443", "198.51.100.9", "https", "10.1.2.3", "http"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			if got := tc.trust.ClientIP(r); got != tc.wantIP {
+				t.Errorf("Expected client IP %s, got %s", tc.wantIP, got)
+			}
+			if got := tc.trust.Scheme(r); got != tc.wantScheme {
+				t.Errorf("Expected scheme %s, got %s", tc.wantScheme, got)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	if got := trust.Scheme(r); got != "https" {
+		t.Errorf("Expected TLS requests to be https, got %s", got)
+	}
+}
+
+func TestNewRejectsInvalidProxies(t *testing.T) {
+	for _, proxies := range [][]string{{"not-an-ip"}, {"10.0.0.0/33"}} {
+		if _, err := New(proxies); err == nil {
+			t.Errorf("Expected %v to be rejected", proxies)
+		}
+	}
+	if trust, err := New([]string{"", " "}); err != nil || trust.Trusted(nil) {
+		t.Errorf("Expected blank entries to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package requestid carries the ID of the request a piece of work was started
+// by, so the access log, chat service, tool, LLM and query logs of one request
+// can be correlated. REST requests take the ID from X-Request-ID or get a new
+// one; each WebSocket chat request gets its own.
+package requestid
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Header is the request and response header carrying the ID
+	Header = "X-Request-ID"
+	// ContextKey is the gin context key the middleware stores the ID under
+	ContextKey = "request_id"
+	// maxLength bounds incoming IDs; longer ones are replaced
+	maxLength = 128
+)
+
+type requestIDKey struct{}
+
+// New generates a request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// With returns a context carrying the request ID
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// From returns the request ID carried by ctx, or ""
+func From(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Valid reports whether an incoming ID can be used as is: printable ASCII
+// without spaces, so it is safe in headers and log lines
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware takes the request ID from X-Request-ID, generating one when it is
+// missing or invalid, and stores it in the gin context, the request's context
+// and the X-Request-ID response header
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		c.Set(ContextKey, id)
+		c.Request = c.Request.WithContext(With(c.Request.Context(), id))
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+// FromGin returns the request ID the middleware stored for c, or ""
+func FromGin(c *gin.Context) string {
+	if id := c.GetString(ContextKey); id != "" {
+		return id
+	}
+	if c.Request == nil {
+		return ""
+	}
+	return From(c.Request.Context())
+}
+
+// Field returns " request_id=<id>" for the ID carried by ctx, to end a log
+// line with, or "" without one
+func Field(ctx context.Context) string {
+	if id := From(ctx); id != "" {
+		return " request_id=" + id
+	}
+	return ""
+}
+
+// Logf logs like log.Printf, ending the line with the request ID carried by ctx
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("%s%s", fmt.Sprintf(format, args...), Field(ctx))
+}
+
+// LogFormatter is gin's default access log line with the request ID added
+func LogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	id, _ := param.Keys[ContextKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v request_id=%s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		id,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/", func(c *gin.Context) {
+		// The handler sees the same ID in the gin context and the request's context
+		if FromGin(c) != From(c.Request.Context()) {
+			t.Errorf("Expected one ID, got %q and %q", FromGin(c), From(c.Request.Context()))
+		}
+		c.String(http.StatusOK, From(c.Request.Context()))
+	})
+
+	for _, tt := range []struct {
+		name, header string
+		kept         bool
+	}{
+		{"incoming ID", "support-ticket-42", true},
+		{"missing ID", "", false},
+		{"ID with spaces", "two words", false},
+		{"overlong ID", strings.Repeat("x", maxLength+1), false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set(Header, tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(Header)
+		if id == "" || w.Body.String() != id {
+			t.Errorf("%s: expected the response header to match the handler's ID, got %q and %q", tt.name, id, w.Body.String())
+		}
+		if (id == tt.header) != tt.kept {
+			t.Errorf("%s: expected kept=%t, got ID %q", tt.name, tt.kept, id)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	if field := Field(context.Background()); field != "" {
+		t.Errorf("Expected no field without an ID, got %q", field)
+	}
+	if field := Field(With(context.Background(), "abc")); field != " request_id=abc" {
+		t.Errorf("Unexpected field %q", field)
+	}
+	if From(nil) != "" {
+		t.Error("Expected no ID from a nil context")
+	}
+}
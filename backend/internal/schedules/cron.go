@@ -0,0 +1,204 @@
+package schedules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds how far ahead Next looks for a matching minute
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// cronMacros are the shorthands accepted in place of five fields
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var dayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// cronField describes the values one of the five fields may take
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: dayNames}, // 7 is Sunday too
+}
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week. Each field is a set of values held as a bit mask.
+type Cron struct {
+	minutes, hours, days, months, weekdays uint64
+	// When both day fields are restricted, a day matching either one fires,
+	// as in Vixie cron
+	anyDay bool
+}
+
+// ParseCron parses a standard five-field cron expression. Fields take *,
+// numbers, ranges (1-5), steps (*/15, 1-30/5), comma-separated lists and, for
+// months and weekdays, three-letter English names. @hourly, @daily,
+// @midnight, @weekly, @monthly, @yearly and @annually are accepted too.
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+
+	var masks [5]uint64
+	for i, field := range fields {
+		mask, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		masks[i] = mask
+	}
+	// Sunday may be written 0 or 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] = masks[4]&^(1<<7) | 1
+	}
+
+	cron := &Cron{
+		minutes:  masks[0],
+		hours:    masks[1],
+		days:     masks[2],
+		months:   masks[3],
+		weekdays: masks[4],
+		anyDay:   !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*"),
+	}
+	if cron.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("the expression never fires")
+	}
+	return cron, nil
+}
+
+// parseCronField turns one field into the bit mask of the values it allows
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if slash := strings.IndexByte(part, '/'); slash >= 0 {
+			rangePart = part[:slash]
+			n, err := strconv.Atoi(part[slash+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", part[slash+1:], spec.name)
+			}
+			step = n
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = spec.min, spec.max
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], spec); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(bounds[1], spec); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("range %q in %s field is backwards", rangePart, spec.name)
+			}
+		default:
+			value, err := cronValue(rangePart, spec)
+			if err != nil {
+				return 0, err
+			}
+			low, high = value, value
+			if step > 1 {
+				high = spec.max // 5/15 means from 5 to the end, every 15
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			mask |= 1 << uint(value)
+		}
+	}
+	return mask, nil
+}
+
+// cronValue parses a number or name within a field's bounds
+func cronValue(text string, spec cronField) (int, error) {
+	if value, ok := spec.names[strings.ToUpper(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", text, spec.name)
+	}
+	if value < spec.min || value > spec.max {
+		return 0, fmt.Errorf("%s value %d is outside %d-%d", spec.name, value, spec.min, spec.max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after after, to the minute, that the expression
+// matches, in after's location. It returns the zero time when nothing matches
+// within five years, such as for February 30th.
+func (c *Cron) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if c.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hours&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// A DST change repeats the hour; step past it in absolute time
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if c.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the day fields allow t's date
+func (c *Cron) matchesDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return day || weekday
+	}
+	return day && weekday
+}
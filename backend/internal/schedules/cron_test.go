@@ -0,0 +1,106 @@
+package schedules
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	cases := map[string]string{
+		"":                "expected 5 fields",
+		"* * * *":         "expected 5 fields",
+		"* * * * * *":     "expected 5 fields",
+		"60 * * * *":      "minute value 60 is outside 0-59",
+		"* 24 * * *":      "hour value 24 is outside 0-23",
+		"* * 0 * *":       "day of month value 0 is outside 1-31",
+		"* * * 13 *":      "month value 13 is outside 1-12",
+		"* * * * 8":       "day of week value 8 is outside 0-7",
+		"*/0 * * * *":     `invalid step "0"`,
+		"10-5 * * * *":    "is backwards",
+		"* * * FOO *":     `invalid value "FOO"`,
+		"0 0 30 FEB *":    "never fires",
+		"@every 5m":       "expected 5 fields",
+		"a,b * * * *":     `invalid value "a"`,
+		"5-x * * * *":     `invalid value "x"`,
+		"* * * * MON-FOO": `invalid value "FOO"`,
+	}
+	for expr, want := range cases {
+		if _, err := ParseCron(expr); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseCron(%q): expected an error containing %q, got %v", expr, want, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 3, 4, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 4, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, 3, 4, 10, 25, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC)},
+		{"30 8-18/2 * * *", time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * sat,sun", time.Date(2026, 3, 7, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@YEARLY", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted either one matching fires
+		{"0 0 13 * FRI", time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 5 * SUN", time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)},
+		// A step over every day still restricts only the weekday
+		{"0 0 */1 * FRI", time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %v", c.expr, err)
+			continue
+		}
+		if got := cron.Next(from); !got.Equal(c.want) {
+			t.Errorf("Next(%q) = %s, want %s", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestCronNextFollowsTimeZone(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	next, err := NextRun("0 9 * * *", "Asia/Jakarta", time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("NextRun failed: %v", err)
+	}
+	// 09:00 in Jakarta is 02:00 UTC
+	if want := time.Date(2026, 3, 4, 9, 0, 0, 0, jakarta); !next.Equal(want) || next.Location() != time.UTC {
+		t.Errorf("Expected %s in UTC, got %s", want.UTC(), next)
+	}
+
+	// 02:30 does not exist on the day New York springs forward, so it runs the day after
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation failed: %v", err)
+	}
+	cron, _ := ParseCron("30 2 * * *")
+	if got, want := cron.Next(time.Date(2026, 3, 8, 0, 0, 0, 0, newYork)), time.Date(2026, 3, 9, 2, 30, 0, 0, newYork); !got.Equal(want) {
+		t.Errorf("Expected the nonexistent time skipped to %s, got %s", want, got)
+	}
+	// The hour repeated when clocks fall back still fires, once per wall clock hour
+	cron, _ = ParseCron("0 * * * *")
+	first := cron.Next(time.Date(2026, 11, 1, 0, 30, 0, 0, newYork))
+	second := cron.Next(first)
+	third := cron.Next(second)
+	if second.Sub(first) != time.Hour || third.Sub(second) != time.Hour {
+		t.Errorf("Expected hourly runs an hour apart across the fall back, got %s, %s, %s", first, second, third)
+	}
+}
@@ -0,0 +1,409 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/notify"
+	"zlay-backend/internal/templates"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+const (
+	// DefaultMaxConcurrent is how many schedules run at once
+	DefaultMaxConcurrent = 4
+	// DefaultMaxPerClient is how many of a client's schedules run at once
+	DefaultMaxPerClient = 1
+	// DefaultTimeout bounds one run of a schedule
+	DefaultTimeout = 15 * time.Minute
+
+	// MetricRunsFailed names the counter of failed scheduled runs, per client
+	MetricRunsFailed = "schedule_runs_failed"
+	// MetricRunsSkipped names the counter of scheduled runs skipped because the previous run was still going, per client
+	MetricRunsSkipped = "schedule_runs_skipped"
+
+	// maxErrorLength bounds the error kept in last_error
+	maxErrorLength = 1000
+)
+
+// Turn is one scheduled prompt to answer. An empty ConversationID asks for a
+// new conversation titled Title.
+type Turn struct {
+	ScheduleID     string
+	ClientID       string
+	ProjectID      string
+	UserID         string
+	ConversationID string
+	Title          string
+	Content        string
+}
+
+// Reply is the assistant's answer to a Turn
+type Reply struct {
+	ConversationID string
+	MessageID      string
+	Content        string
+}
+
+// RunFunc answers a turn through the chat pipeline with nobody connected. It
+// may return a Reply naming the conversation it used together with an error,
+// so a conversation created by a failed run is still kept on the schedule.
+type RunFunc func(ctx context.Context, turn Turn) (*Reply, error)
+
+// Reporter emails the reply of a schedule delivered by email
+type Reporter interface {
+	ScheduledReport(ctx context.Context, report notify.ScheduledReport) error
+}
+
+// Options configures a Scheduler; zero values use the defaults
+type Options struct {
+	MaxConcurrent int
+	MaxPerClient  int
+	// Jitter delays each run by up to this long, so schedules sharing a cron
+	// expression do not all hit the LLM provider in the same second
+	Jitter  time.Duration
+	Timeout time.Duration
+}
+
+// Scheduler runs enabled schedules whose next run is due
+type Scheduler struct {
+	db       tools.DBConnection
+	run      RunFunc
+	events   webhooks.Publisher
+	reporter Reporter
+	options  Options
+	slots    chan struct{}
+	now      func() time.Time
+	jitter   func() time.Duration
+
+	mutex   sync.Mutex
+	running map[string]int // Runs in progress by client
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler. events and reporter may be nil, in which
+// case schedules delivered by webhook or email fail to deliver.
+func NewScheduler(db tools.DBConnection, run RunFunc, events webhooks.Publisher, reporter Reporter, options Options) *Scheduler {
+	if options.MaxConcurrent <= 0 {
+		options.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if options.MaxPerClient <= 0 {
+		options.MaxPerClient = DefaultMaxPerClient
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	s := &Scheduler{
+		db:       db,
+		run:      run,
+		events:   events,
+		reporter: reporter,
+		options:  options,
+		slots:    make(chan struct{}, options.MaxConcurrent),
+		now:      time.Now,
+		running:  make(map[string]int),
+	}
+	s.jitter = func() time.Duration {
+		if s.options.Jitter <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(s.options.Jitter)))
+	}
+	return s
+}
+
+// Run starts due schedules every checkInterval until ctx is cancelled, then
+// waits for the runs in progress
+func (s *Scheduler) Run(ctx context.Context, checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		if started, err := s.RunOnce(ctx); err != nil {
+			log.Printf("Schedule check failed after starting %d runs: %v", started, err)
+		} else if started > 0 {
+			log.Printf("Started %d scheduled prompts", started)
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce starts every due schedule there is room for and returns how many
+// were started; it does not wait for them. A schedule whose previous run is
+// still going skips this run. Schedules left waiting for a free slot start on
+// a later check.
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	due, err := s.due(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	started := 0
+	for _, schedule := range due {
+		if ctx.Err() != nil {
+			return started, ctx.Err()
+		}
+		if schedule.RunningSince != nil && schedule.RunningSince.After(s.staleBefore()) {
+			s.skip(ctx, schedule)
+			continue
+		}
+		if !s.reserve(schedule.clientID) {
+			continue
+		}
+		claimed, err := s.claim(ctx, schedule)
+		if err != nil || !claimed {
+			s.release(schedule.clientID)
+			if err != nil {
+				log.Printf("Failed to claim schedule %s: %v", schedule.ID, err)
+			}
+			continue
+		}
+
+		started++
+		s.wg.Add(1)
+		go func(schedule dueSchedule) {
+			defer s.wg.Done()
+			defer s.release(schedule.clientID)
+			s.execute(ctx, schedule)
+		}(schedule)
+	}
+	return started, nil
+}
+
+// Wait blocks until every run started by RunOnce has finished
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// dueSchedule is a schedule whose next run has come, with the client it runs for
+type dueSchedule struct {
+	Schedule
+	clientID string
+}
+
+// due returns the enabled schedules of active projects whose next run has
+// come, oldest first
+func (s *Scheduler) due(ctx context.Context) ([]dueSchedule, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT "+scheduleColumns+`, u.client_id
+		FROM scheduled_prompts s
+		JOIN projects p ON p.id = s.project_id
+		JOIN users u ON u.id = p.user_id
+		WHERE s.enabled = true AND p.is_active = true AND s.next_run_at <= $1
+		ORDER BY s.next_run_at`,
+		s.now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var due []dueSchedule
+	for rows.Next() {
+		var schedule dueSchedule
+		scanned, err := scanSchedule(rows, &schedule.clientID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schedule: %w", err)
+		}
+		schedule.Schedule = *scanned
+		due = append(due, schedule)
+	}
+	return due, rows.Err()
+}
+
+// staleBefore is when a run must have started to still count as in progress;
+// older ones were cut short by a restart and no longer block the schedule
+func (s *Scheduler) staleBefore() time.Time {
+	return s.now().UTC().Add(-2 * s.options.Timeout)
+}
+
+// reserve takes one of the client's run slots and one of the scheduler's
+func (s *Scheduler) reserve(clientID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running[clientID] >= s.options.MaxPerClient {
+		return false
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return false
+	}
+	s.running[clientID]++
+	return true
+}
+
+func (s *Scheduler) release(clientID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	<-s.slots
+	if s.running[clientID]--; s.running[clientID] <= 0 {
+		delete(s.running, clientID)
+	}
+}
+
+// nextRun is when a schedule runs after now, jitter included
+func (s *Scheduler) nextRun(schedule dueSchedule, now time.Time) interface{} {
+	next, err := NextRun(schedule.CronExpression, schedule.Timezone, now)
+	if err != nil || next == nil {
+		return nil
+	}
+	return next.Add(s.jitter())
+}
+
+// claim marks a schedule as running and moves its next run on. It reports
+// false when another scheduler claimed the run first.
+func (s *Scheduler) claim(ctx context.Context, schedule dueSchedule) (bool, error) {
+	now := s.now().UTC()
+	result, err := s.db.Exec(ctx,
+		`UPDATE scheduled_prompts SET running_since = $1, next_run_at = $2
+		WHERE id = $3 AND enabled = true AND next_run_at <= $1 AND (running_since IS NULL OR running_since < $4)`,
+		now, s.nextRun(schedule, now), schedule.ID, s.staleBefore())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// skip moves the next run of a schedule whose previous run is still going on,
+// so runs do not pile up behind a slow one
+func (s *Scheduler) skip(ctx context.Context, schedule dueSchedule) {
+	now := s.now().UTC()
+	result, err := s.db.Exec(ctx,
+		`UPDATE scheduled_prompts SET next_run_at = $1
+		WHERE id = $2 AND next_run_at <= $3 AND running_since >= $4`,
+		s.nextRun(schedule, now), schedule.ID, now, s.staleBefore())
+	if err != nil {
+		log.Printf("Failed to skip run of schedule %s: %v", schedule.ID, err)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		metrics.Counter(MetricRunsSkipped).Inc(schedule.clientID)
+		log.Printf("Skipped run of schedule %s: its previous run started at %s is still going",
+			schedule.ID, schedule.RunningSince.Format(time.RFC3339))
+	}
+}
+
+// execute runs a claimed schedule, delivers its reply and records the outcome
+func (s *Scheduler) execute(ctx context.Context, schedule dueSchedule) {
+	ranAt := s.now().UTC()
+	reply, err := s.answer(ctx, schedule)
+	if err == nil {
+		err = s.deliver(ctx, schedule, reply, ranAt)
+	}
+
+	status, lastError := StatusSucceeded, ""
+	if err != nil {
+		status, lastError = StatusFailed, err.Error()
+		if len(lastError) > maxErrorLength {
+			lastError = lastError[:maxErrorLength]
+		}
+		metrics.Counter(MetricRunsFailed).Inc(schedule.clientID)
+		log.Printf("Scheduled prompt %s failed: %v", schedule.ID, err)
+	}
+	var conversationID interface{}
+	if reply != nil && reply.ConversationID != "" {
+		conversationID = reply.ConversationID
+	}
+
+	// Record the outcome even when the scheduler is shutting down
+	if _, err := s.db.Exec(context.WithoutCancel(ctx),
+		`UPDATE scheduled_prompts SET running_since = NULL, last_run_at = $1, last_status = $2, last_error = $3,
+			conversation_id = COALESCE($4, conversation_id)
+		WHERE id = $5`,
+		ranAt, status, nullIfEmpty(lastError), conversationID, schedule.ID); err != nil {
+		log.Printf("Failed to record run of schedule %s: %v", schedule.ID, err)
+	}
+}
+
+// answer renders the schedule's prompt and has it answered
+func (s *Scheduler) answer(ctx context.Context, schedule dueSchedule) (*Reply, error) {
+	content := schedule.Prompt
+	if schedule.TemplateID != nil {
+		rendered, err := templates.Use(ctx, s.db, schedule.ProjectID, *schedule.TemplateID, schedule.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		content = rendered
+	}
+
+	turn := Turn{
+		ScheduleID: schedule.ID,
+		ClientID:   schedule.clientID,
+		ProjectID:  schedule.ProjectID,
+		UserID:     schedule.CreatedBy,
+		Title:      schedule.Name,
+		Content:    content,
+	}
+	if schedule.ConversationID != nil {
+		turn.ConversationID = *schedule.ConversationID
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+	defer cancel()
+	reply, err := s.run(runCtx, turn)
+	if err != nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return reply, fmt.Errorf("run timed out after %s: %w", s.options.Timeout, err)
+	}
+	if err == nil && reply == nil {
+		err = errors.New("the run produced no reply")
+	}
+	return reply, err
+}
+
+// deliver sends the reply to the schedule's target; a conversation target
+// already has it
+func (s *Scheduler) deliver(ctx context.Context, schedule dueSchedule, reply *Reply, ranAt time.Time) error {
+	switch schedule.Target {
+	case TargetWebhook:
+		if s.events == nil {
+			return errors.New("webhooks are not configured")
+		}
+		event := webhooks.NewEvent(webhooks.EventScheduledPromptCompleted, schedule.clientID, schedule.ProjectID, map[string]interface{}{
+			"schedule_id":     schedule.ID,
+			"schedule_name":   schedule.Name,
+			"conversation_id": reply.ConversationID,
+			"message_id":      reply.MessageID,
+			"content":         reply.Content,
+			"ran_at":          ranAt,
+		})
+		if !s.events.Publish(event) {
+			return errors.New("webhook event was dropped")
+		}
+	case TargetEmail:
+		if s.reporter == nil {
+			return errors.New("email notifications are not configured")
+		}
+		report := notify.ScheduledReport{
+			ClientID:       schedule.clientID,
+			ProjectID:      schedule.ProjectID,
+			ScheduleID:     schedule.ID,
+			Name:           schedule.Name,
+			ConversationID: reply.ConversationID,
+			Content:        reply.Content,
+			RanAt:          ranAt,
+		}
+		if err := s.reporter.ScheduledReport(ctx, report); err != nil {
+			return fmt.Errorf("failed to email report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package schedules
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/notify"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+const (
+	testClientID       = "11111111-1111-1111-1111-111111111111"
+	testUserID         = "22222222-2222-2222-2222-222222222222"
+	testProjectID      = "33333333-3333-3333-3333-333333333333"
+	testConversationID = "44444444-4444-4444-4444-444444444444"
+)
+
+type recordingPublisher struct {
+	mutex  sync.Mutex
+	events []webhooks.Event
+	refuse bool
+}
+
+func (p *recordingPublisher) Publish(event webhooks.Event) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.refuse {
+		return false
+	}
+	p.events = append(p.events, event)
+	return true
+}
+
+type recordingReporter struct {
+	mutex   sync.Mutex
+	reports []notify.ScheduledReport
+}
+
+func (r *recordingReporter) ScheduledReport(ctx context.Context, report notify.ScheduledReport) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+// fakeRun answers turns with a canned reply, or holds them until released
+type fakeRun struct {
+	mutex   sync.Mutex
+	turns   []Turn
+	err     error
+	hold    chan struct{}
+	started chan struct{}
+}
+
+func (f *fakeRun) run(ctx context.Context, turn Turn) (*Reply, error) {
+	f.mutex.Lock()
+	f.turns = append(f.turns, turn)
+	hold, err := f.hold, f.err
+	f.mutex.Unlock()
+
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if hold != nil {
+		<-hold
+	}
+	conversationID := turn.ConversationID
+	if conversationID == "" {
+		conversationID = testConversationID
+	}
+	if err != nil {
+		return &Reply{ConversationID: conversationID}, err
+	}
+	return &Reply{ConversationID: conversationID, MessageID: "msg-1", Content: "Sales were up 4%"}, nil
+}
+
+func (f *fakeRun) calls() []Turn {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]Turn(nil), f.turns...)
+}
+
+// setupScheduleDB returns a migrated database with a client, its user and
+// project, and a conversation in the project
+func setupScheduleDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	for _, statement := range []string{
+		"INSERT INTO clients (id, name, slug) VALUES ('" + testClientID + "', 'Acme', 'acme')",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('" + testUserID + "', '" + testClientID + "', 'alice', 'hash')",
+		"INSERT INTO projects (id, user_id, name) VALUES ('" + testProjectID + "', '" + testUserID + "', 'Sales')",
+		"INSERT INTO conversations (id, title, user_id, project_id) VALUES ('" + testConversationID + "', 'Reports', '" + testUserID + "', '" + testProjectID + "')",
+	} {
+		if _, err := conn.Exec(context.Background(), statement); err != nil {
+			t.Fatalf("Failed to seed %q: %v", statement, err)
+		}
+	}
+	return conn
+}
+
+// newTestScheduler returns a scheduler without jitter whose clock the test moves
+func newTestScheduler(t *testing.T, conn tools.DBConnection, run RunFunc, events webhooks.Publisher, reporter Reporter, options Options) (*Scheduler, *time.Time) {
+	t.Helper()
+	scheduler := NewScheduler(conn, run, events, reporter, options)
+	clock := time.Now().UTC()
+	scheduler.now = func() time.Time { return clock }
+	scheduler.jitter = func() time.Duration { return 0 }
+	return scheduler, &clock
+}
+
+func createSchedule(t *testing.T, conn tools.DBConnection, in Input) *Schedule {
+	t.Helper()
+	if err := in.Normalize(); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	schedule, err := Create(context.Background(), conn, testProjectID, testUserID, in)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return schedule
+}
+
+func getSchedule(t *testing.T, conn tools.DBConnection, id string) *Schedule {
+	t.Helper()
+	schedule, err := Get(context.Background(), conn, testProjectID, id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return schedule
+}
+
+func TestRunOnceRunsDueScheduleAndDeliversWebhook(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{}
+	publisher := &recordingPublisher{}
+	scheduler, clock := newTestScheduler(t, conn, run.run, publisher, nil, Options{})
+	schedule := createSchedule(t, conn, Input{Name: "Weekly sales", CronExpression: "*/5 * * * *", Prompt: "How were sales?", Target: TargetWebhook})
+	if schedule.NextRunAt == nil {
+		t.Fatal("Expected an enabled schedule to have a next run")
+	}
+
+	// Not due yet
+	*clock = schedule.NextRunAt.Add(-time.Second)
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 0 {
+		t.Fatalf("Expected nothing started before the next run, got %d, %v", started, err)
+	}
+
+	*clock = schedule.NextRunAt.Add(10 * time.Second)
+	scheduler.jitter = func() time.Duration { return 20 * time.Second }
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 1 {
+		t.Fatalf("Expected the due schedule started, got %d, %v", started, err)
+	}
+	scheduler.Wait()
+
+	turns := run.calls()
+	if len(turns) != 1 {
+		t.Fatalf("Expected one run, got %d", len(turns))
+	}
+	want := Turn{ScheduleID: schedule.ID, ClientID: testClientID, ProjectID: testProjectID, UserID: testUserID, Title: "Weekly sales", Content: "How were sales?"}
+	if turns[0] != want {
+		t.Errorf("Expected turn %+v, got %+v", want, turns[0])
+	}
+
+	updated := getSchedule(t, conn, schedule.ID)
+	if updated.LastStatus == nil || *updated.LastStatus != StatusSucceeded || updated.LastError != nil {
+		t.Errorf("Expected a successful run recorded, got %v, %v", updated.LastStatus, updated.LastError)
+	}
+	if updated.RunningSince != nil {
+		t.Errorf("Expected the run finished, still running since %s", updated.RunningSince)
+	}
+	if updated.LastRunAt == nil || !updated.LastRunAt.Equal(*clock) {
+		t.Errorf("Expected the run time recorded as %s, got %v", *clock, updated.LastRunAt)
+	}
+	if updated.ConversationID == nil || *updated.ConversationID != testConversationID {
+		t.Errorf("Expected the created conversation kept on the schedule, got %v", updated.ConversationID)
+	}
+	if want := schedule.NextRunAt.Add(5*time.Minute + 20*time.Second); updated.NextRunAt == nil || !updated.NextRunAt.Equal(want) {
+		t.Errorf("Expected the next run at %s with jitter, got %v", want, updated.NextRunAt)
+	}
+
+	if len(publisher.events) != 1 {
+		t.Fatalf("Expected one webhook event, got %d", len(publisher.events))
+	}
+	event := publisher.events[0]
+	if event.Type != webhooks.EventScheduledPromptCompleted || event.ClientID != testClientID || event.ProjectID != testProjectID {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if event.Data["schedule_id"] != schedule.ID || event.Data["content"] != "Sales were up 4%" || event.Data["conversation_id"] != testConversationID {
+		t.Errorf("Unexpected event data %+v", event.Data)
+	}
+
+	// The next run posts to the conversation the first one created
+	*clock = updated.NextRunAt.Add(time.Second)
+	if started, _ := scheduler.RunOnce(context.Background()); started != 1 {
+		t.Fatalf("Expected the second run started, got %d", started)
+	}
+	scheduler.Wait()
+	if turns := run.calls(); len(turns) != 2 || turns[1].ConversationID != testConversationID {
+		t.Errorf("Expected the second run in conversation %s, got %+v", testConversationID, turns)
+	}
+}
+
+func TestRunOnceSkipsRunWhilePreviousIsGoing(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{hold: make(chan struct{}), started: make(chan struct{}, 1)}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{Timeout: 10 * time.Minute})
+	schedule := createSchedule(t, conn, Input{Name: "Every minute", CronExpression: "* * * * *", Prompt: "Status?"})
+	before := metrics.Counter(MetricRunsSkipped).Snapshot()[testClientID].Count
+
+	*clock = *schedule.NextRunAt
+	if started, _ := scheduler.RunOnce(context.Background()); started != 1 {
+		t.Fatalf("Expected the first run started, got %d", started)
+	}
+	<-run.started
+
+	// The next minute comes while the first run is still going
+	*clock = clock.Add(time.Minute)
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 0 {
+		t.Fatalf("Expected the overlapping run skipped, got %d, %v", started, err)
+	}
+	if after := metrics.Counter(MetricRunsSkipped).Snapshot()[testClientID].Count; after != before+1 {
+		t.Errorf("Expected 1 skipped run counted, got %d", after-before)
+	}
+	running := getSchedule(t, conn, schedule.ID)
+	if running.RunningSince == nil {
+		t.Error("Expected the schedule marked running")
+	}
+	if want := clock.Add(time.Minute).Truncate(time.Minute); running.NextRunAt == nil || !running.NextRunAt.Equal(want) {
+		t.Errorf("Expected the skipped run moved to %s, got %v", want, running.NextRunAt)
+	}
+
+	close(run.hold)
+	scheduler.Wait()
+	if turns := run.calls(); len(turns) != 1 {
+		t.Errorf("Expected a single run, got %d", len(turns))
+	}
+	if finished := getSchedule(t, conn, schedule.ID); finished.RunningSince != nil || finished.LastStatus == nil || *finished.LastStatus != StatusSucceeded {
+		t.Errorf("Expected the run finished successfully, got %+v", finished)
+	}
+}
+
+func TestRunOnceReclaimsStaleRun(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{Timeout: 10 * time.Minute})
+	schedule := createSchedule(t, conn, Input{Name: "Hourly", CronExpression: "@hourly", Prompt: "Status?"})
+
+	// A run started by a process that died long ago no longer blocks the schedule
+	*clock = *schedule.NextRunAt
+	if _, err := conn.Exec(context.Background(), "UPDATE scheduled_prompts SET running_since = $1 WHERE id = $2",
+		clock.Add(-time.Hour), schedule.ID); err != nil {
+		t.Fatalf("Failed to mark schedule running: %v", err)
+	}
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 1 {
+		t.Fatalf("Expected the stale run reclaimed, got %d, %v", started, err)
+	}
+	scheduler.Wait()
+	if finished := getSchedule(t, conn, schedule.ID); finished.RunningSince != nil || finished.LastStatus == nil || *finished.LastStatus != StatusSucceeded {
+		t.Errorf("Expected the run finished successfully, got %+v", finished)
+	}
+}
+
+func TestRunOnceLimitsRunsPerClient(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{hold: make(chan struct{}), started: make(chan struct{}, 2)}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{MaxPerClient: 1})
+	first := createSchedule(t, conn, Input{Name: "First", CronExpression: "0 9 * * *", Prompt: "One?"})
+	second := createSchedule(t, conn, Input{Name: "Second", CronExpression: "0 9 * * *", Prompt: "Two?"})
+
+	*clock = *first.NextRunAt
+	if started, _ := scheduler.RunOnce(context.Background()); started != 1 {
+		t.Fatalf("Expected one run for the client, got %d", started)
+	}
+	<-run.started
+
+	// The other schedule waits for the client's run to end rather than skipping
+	if started, _ := scheduler.RunOnce(context.Background()); started != 0 {
+		t.Fatalf("Expected no second run while the client's first is going, got %d", started)
+	}
+	waiting := second
+	if turns := run.calls(); turns[0].ScheduleID == second.ID {
+		waiting = first
+	}
+	if still := getSchedule(t, conn, waiting.ID); still.NextRunAt == nil || !still.NextRunAt.Equal(*waiting.NextRunAt) || still.RunningSince != nil {
+		t.Errorf("Expected the waiting schedule left due, got %+v", still)
+	}
+
+	close(run.hold)
+	scheduler.Wait()
+	if started, _ := scheduler.RunOnce(context.Background()); started != 1 {
+		t.Fatalf("Expected the waiting schedule started once a slot freed, got %d", started)
+	}
+	scheduler.Wait()
+	if turns := run.calls(); len(turns) != 2 || turns[0].ScheduleID == turns[1].ScheduleID {
+		t.Errorf("Expected both schedules run once, got %+v", turns)
+	}
+}
+
+func TestFailedRunsAreRecorded(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{err: errors.New("LLM provider is down")}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{})
+	schedule := createSchedule(t, conn, Input{Name: "Daily", CronExpression: "@daily", Prompt: "Status?"})
+	before := metrics.Counter(MetricRunsFailed).Snapshot()[testClientID].Count
+
+	*clock = *schedule.NextRunAt
+	if started, _ := scheduler.RunOnce(context.Background()); started != 1 {
+		t.Fatalf("Expected the run started, got %d", started)
+	}
+	scheduler.Wait()
+
+	failed := getSchedule(t, conn, schedule.ID)
+	if failed.LastStatus == nil || *failed.LastStatus != StatusFailed || failed.LastError == nil || *failed.LastError != "LLM provider is down" {
+		t.Errorf("Expected the failure recorded, got %v, %v", failed.LastStatus, failed.LastError)
+	}
+	if failed.ConversationID == nil || *failed.ConversationID != testConversationID {
+		t.Errorf("Expected the conversation of the failed run kept, got %v", failed.ConversationID)
+	}
+	if failed.RunningSince != nil || failed.NextRunAt == nil || !failed.NextRunAt.After(*clock) {
+		t.Errorf("Expected the schedule to run again later, got %+v", failed)
+	}
+	if after := metrics.Counter(MetricRunsFailed).Snapshot()[testClientID].Count; after != before+1 {
+		t.Errorf("Expected 1 failed run counted, got %d", after-before)
+	}
+}
+
+func TestDeliveryFailuresAreRecorded(t *testing.T) {
+	conn := setupScheduleDB(t)
+	publisher := &recordingPublisher{refuse: true}
+	scheduler, clock := newTestScheduler(t, conn, (&fakeRun{}).run, publisher, nil, Options{})
+	webhook := createSchedule(t, conn, Input{Name: "Webhook", CronExpression: "0 9 * * *", Prompt: "One?", Target: TargetWebhook})
+	email := createSchedule(t, conn, Input{Name: "Email", CronExpression: "0 9 * * *", Prompt: "Two?", Target: TargetEmail})
+
+	*clock = *webhook.NextRunAt
+	scheduler.options.MaxPerClient = 2
+	if started, _ := scheduler.RunOnce(context.Background()); started != 2 {
+		t.Fatalf("Expected both runs started, got %d", started)
+	}
+	scheduler.Wait()
+
+	for id, want := range map[string]string{webhook.ID: "webhook event was dropped", email.ID: "email notifications are not configured"} {
+		s := getSchedule(t, conn, id)
+		if s.LastStatus == nil || *s.LastStatus != StatusFailed || s.LastError == nil || *s.LastError != want {
+			t.Errorf("Expected schedule %s to fail with %q, got %v, %v", s.Name, want, s.LastStatus, s.LastError)
+		}
+	}
+}
+
+func TestEmailScheduleSendsReport(t *testing.T) {
+	conn := setupScheduleDB(t)
+	reporter := &recordingReporter{}
+	scheduler, clock := newTestScheduler(t, conn, (&fakeRun{}).run, nil, reporter, Options{})
+	schedule := createSchedule(t, conn, Input{Name: "Morning report", CronExpression: "0 9 * * *", Timezone: "Asia/Jakarta",
+		Prompt: "Sales?", Target: TargetEmail, ConversationID: testConversationID})
+
+	*clock = *schedule.NextRunAt
+	scheduler.RunOnce(context.Background())
+	scheduler.Wait()
+
+	if len(reporter.reports) != 1 {
+		t.Fatalf("Expected one report, got %d", len(reporter.reports))
+	}
+	want := notify.ScheduledReport{ClientID: testClientID, ProjectID: testProjectID, ScheduleID: schedule.ID, Name: "Morning report",
+		ConversationID: testConversationID, Content: "Sales were up 4%", RanAt: *clock}
+	if got := reporter.reports[0]; got != want {
+		t.Errorf("Expected report %+v, got %+v", want, got)
+	}
+}
+
+func TestDisabledSchedulesAndInactiveProjectsDoNotRun(t *testing.T) {
+	conn := setupScheduleDB(t)
+	run := &fakeRun{}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{})
+	disabled := false
+	createSchedule(t, conn, Input{Name: "Off", CronExpression: "* * * * *", Prompt: "One?", Enabled: &disabled})
+	active := createSchedule(t, conn, Input{Name: "On", CronExpression: "* * * * *", Prompt: "Two?"})
+
+	if _, err := conn.Exec(context.Background(), "UPDATE projects SET is_active = false WHERE id = $1", testProjectID); err != nil {
+		t.Fatalf("Failed to deactivate project: %v", err)
+	}
+	*clock = active.NextRunAt.Add(time.Hour)
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 0 {
+		t.Errorf("Expected nothing started, got %d, %v", started, err)
+	}
+	if turns := run.calls(); len(turns) != 0 {
+		t.Errorf("Expected no runs, got %+v", turns)
+	}
+}
+
+func TestTemplateSchedulesRenderAtRunTime(t *testing.T) {
+	conn := setupScheduleDB(t)
+	templateID := "55555555-5555-5555-5555-555555555555"
+	if _, err := conn.Exec(context.Background(),
+		"INSERT INTO prompt_templates (id, project_id, name, content, created_by) VALUES ($1, $2, 'Sales', 'Sales in {{region}}?', $3)",
+		templateID, testProjectID, testUserID); err != nil {
+		t.Fatalf("Failed to seed template: %v", err)
+	}
+	run := &fakeRun{}
+	scheduler, clock := newTestScheduler(t, conn, run.run, nil, nil, Options{})
+
+	// The template must render when the schedule is saved
+	missing := Input{Name: "Regional", CronExpression: "@daily", TemplateID: templateID}
+	if err := missing.Normalize(); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if _, err := Create(context.Background(), conn, testProjectID, testUserID, missing); err == nil {
+		t.Fatal("Expected a template missing its variables to be refused")
+	}
+	schedule := createSchedule(t, conn, Input{Name: "Regional", CronExpression: "@daily", TemplateID: templateID, Variables: map[string]string{"region": "Java"}})
+
+	*clock = *schedule.NextRunAt
+	scheduler.RunOnce(context.Background())
+	scheduler.Wait()
+	if turns := run.calls(); len(turns) != 1 || turns[0].Content != "Sales in Java?" {
+		t.Fatalf("Expected the rendered template posted, got %+v", turns)
+	}
+
+	// A template deleted later fails the run instead of posting nothing
+	if _, err := conn.Exec(context.Background(), "DELETE FROM prompt_templates WHERE id = $1", templateID); err != nil {
+		t.Fatalf("Failed to delete template: %v", err)
+	}
+	*clock = getSchedule(t, conn, schedule.ID).NextRunAt.Add(time.Second)
+	scheduler.RunOnce(context.Background())
+	scheduler.Wait()
+	failed := getSchedule(t, conn, schedule.ID)
+	if failed.LastStatus == nil || *failed.LastStatus != StatusFailed || len(run.calls()) != 1 {
+		t.Errorf("Expected the run to fail without posting, got %v", failed.LastStatus)
+	}
+}
+
+func TestNormalizeValidatesInput(t *testing.T) {
+	cases := map[string]Input{
+		"name":            {CronExpression: "@daily", Prompt: "Hi"},
+		"cron_expression": {Name: "Bad", CronExpression: "every day", Prompt: "Hi"},
+		"timezone":        {Name: "Bad", CronExpression: "@daily", Timezone: "Mars/Olympus", Prompt: "Hi"},
+		"prompt":          {Name: "Empty", CronExpression: "@daily"},
+		"target":          {Name: "Bad", CronExpression: "@daily", Prompt: "Hi", Target: "sms"},
+	}
+	for field, in := range cases {
+		var invalid *ValidationError
+		if err := in.Normalize(); !errors.As(err, &invalid) || invalid.Field != field {
+			t.Errorf("Expected a %s validation error, got %v", field, err)
+		}
+	}
+
+	both := Input{Name: "Both", CronExpression: "@daily", Prompt: "Hi", TemplateID: "55555555-5555-5555-5555-555555555555"}
+	if err := both.Normalize(); err == nil {
+		t.Error("Expected a prompt and a template together to be refused")
+	}
+
+	in := Input{Name: " Daily ", CronExpression: "@daily", Prompt: "Hi"}
+	if err := in.Normalize(); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if in.Name != "Daily" || in.Timezone != "UTC" || in.Target != TargetConversation || in.Enabled == nil || !*in.Enabled {
+		t.Errorf("Expected defaults filled in, got %+v", in)
+	}
+}
+
+func TestUpdateRecomputesNextRun(t *testing.T) {
+	conn := setupScheduleDB(t)
+	schedule := createSchedule(t, conn, Input{Name: "Daily", CronExpression: "0 9 * * *", Prompt: "Hi"})
+
+	disabled := false
+	in := Input{Name: "Daily", CronExpression: "0 9 * * *", Prompt: "Hi", Enabled: &disabled}
+	if err := in.Normalize(); err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	updated, err := Update(context.Background(), conn, testProjectID, schedule.ID, in)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Enabled || updated.NextRunAt != nil {
+		t.Errorf("Expected a disabled schedule without a next run, got %+v", updated)
+	}
+
+	if _, err := Update(context.Background(), conn, "66666666-6666-6666-6666-666666666666", schedule.ID, in); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("Expected another project's schedule not found, got %v", err)
+	}
+	if err := Delete(context.Background(), conn, testProjectID, schedule.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	var count int
+	if err := conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM scheduled_prompts").Scan(&count); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Failed to count schedules: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected the schedule deleted, %d left", count)
+	}
+}
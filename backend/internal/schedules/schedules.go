@@ -0,0 +1,409 @@
+// Package schedules runs a project's prompts on a cron schedule. At each
+// trigger a schedule's prompt, typed in or rendered from a prompt template, is
+// posted to its conversation as the schedule's creator and answered by the
+// full chat pipeline with nobody connected; the reply is then delivered to the
+// schedule's target. Schedules are kept in scheduled_prompts together with the
+// outcome of their last run.
+package schedules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Schedules name IANA time zones, which hosts may lack
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/templates"
+	"zlay-backend/internal/tools"
+)
+
+// Targets a schedule's reply can be delivered to
+const (
+	// TargetConversation leaves the reply in the schedule's conversation
+	TargetConversation = "conversation"
+	// TargetWebhook also sends it to the client's webhooks as a scheduled_prompt_completed event
+	TargetWebhook = "webhook"
+	// TargetEmail also emails it to the client's notification address
+	TargetEmail = "email"
+)
+
+// Targets lists every delivery target
+var Targets = []string{TargetConversation, TargetWebhook, TargetEmail}
+
+// Outcomes of a run, kept in last_status
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Field limits
+const (
+	MaxNameLength     = 255
+	MaxPromptLength   = templates.MaxContentLength
+	MaxCronLength     = 255
+	MaxTimezoneLength = 64
+)
+
+// ErrScheduleNotFound is returned for schedules missing from the project
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule is a prompt run on a cron schedule. Exactly one of Prompt and
+// TemplateID is set; Variables fill in the template's placeholders.
+type Schedule struct {
+	ID             string            `json:"id"`
+	ProjectID      string            `json:"project_id"`
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cron_expression"`
+	Timezone       string            `json:"timezone"` // IANA name the cron expression is read in
+	Prompt         string            `json:"prompt,omitempty"`
+	TemplateID     *string           `json:"template_id"`
+	Variables      map[string]string `json:"variables"`
+	Target         string            `json:"target"`
+	ConversationID *string           `json:"conversation_id"` // Created by the first run when not chosen
+	Enabled        bool              `json:"enabled"`
+	NextRunAt      *time.Time        `json:"next_run_at"`   // nil while disabled
+	RunningSince   *time.Time        `json:"running_since"` // Start of the run in progress
+	LastRunAt      *time.Time        `json:"last_run_at"`
+	LastStatus     *string           `json:"last_status"` // succeeded or failed
+	LastError      *string           `json:"last_error"`
+	CreatedBy      string            `json:"created_by"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// Input is the editable part of a schedule
+type Input struct {
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cron_expression"`
+	Timezone       string            `json:"timezone"` // Defaults to UTC
+	Prompt         string            `json:"prompt"`
+	TemplateID     string            `json:"template_id"`
+	Variables      map[string]string `json:"variables"`
+	Target         string            `json:"target"`          // Defaults to conversation
+	ConversationID string            `json:"conversation_id"` // Optional conversation of the project to append to
+	Enabled        *bool             `json:"enabled"`         // Defaults to true
+}
+
+// ValidationError reports an input field that is missing or invalid
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Normalize trims the input, fills in defaults and checks its fields,
+// including that the cron expression parses and the time zone exists
+func (in *Input) Normalize() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.CronExpression = strings.TrimSpace(in.CronExpression)
+	in.Timezone = strings.TrimSpace(in.Timezone)
+	in.TemplateID = strings.TrimSpace(in.TemplateID)
+	in.ConversationID = strings.TrimSpace(in.ConversationID)
+	in.Target = strings.TrimSpace(in.Target)
+	if in.Timezone == "" {
+		in.Timezone = "UTC"
+	}
+	if in.Target == "" {
+		in.Target = TargetConversation
+	}
+	if in.Enabled == nil {
+		enabled := true
+		in.Enabled = &enabled
+	}
+	if in.Variables == nil {
+		in.Variables = map[string]string{}
+	}
+
+	switch {
+	case in.Name == "":
+		return &ValidationError{Field: "name", Reason: "is required"}
+	case utf8.RuneCountInString(in.Name) > MaxNameLength:
+		return &ValidationError{Field: "name", Reason: fmt.Sprintf("must be at most %d characters", MaxNameLength)}
+	case in.CronExpression == "":
+		return &ValidationError{Field: "cron_expression", Reason: "is required"}
+	case len(in.CronExpression) > MaxCronLength:
+		return &ValidationError{Field: "cron_expression", Reason: fmt.Sprintf("must be at most %d characters", MaxCronLength)}
+	case len(in.Timezone) > MaxTimezoneLength:
+		return &ValidationError{Field: "timezone", Reason: fmt.Sprintf("must be at most %d characters", MaxTimezoneLength)}
+	case strings.TrimSpace(in.Prompt) == "" && in.TemplateID == "":
+		return &ValidationError{Field: "prompt", Reason: "or template_id is required"}
+	case strings.TrimSpace(in.Prompt) != "" && in.TemplateID != "":
+		return &ValidationError{Field: "prompt", Reason: "cannot be combined with template_id"}
+	case utf8.RuneCountInString(in.Prompt) > MaxPromptLength:
+		return &ValidationError{Field: "prompt", Reason: fmt.Sprintf("must be at most %d characters", MaxPromptLength)}
+	case !validTarget(in.Target):
+		return &ValidationError{Field: "target", Reason: "must be one of " + strings.Join(Targets, ", ")}
+	}
+	if _, err := ParseCron(in.CronExpression); err != nil {
+		return &ValidationError{Field: "cron_expression", Reason: err.Error()}
+	}
+	if _, err := time.LoadLocation(in.Timezone); err != nil {
+		return &ValidationError{Field: "timezone", Reason: "is not a known time zone"}
+	}
+	if in.TemplateID != "" {
+		in.Prompt = ""
+	}
+	return nil
+}
+
+func validTarget(target string) bool {
+	for _, t := range Targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// NextRun returns when a schedule fires next after now, or nil when its
+// expression no longer fires
+func NextRun(cronExpression, timezone string, now time.Time) (*time.Time, error) {
+	cron, err := ParseCron(cronExpression)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := cron.Next(now.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+const scheduleColumns = `s.id, s.project_id, s.name, s.cron_expression, s.timezone, s.prompt, s.template_id, s.variables, s.target,
+	s.conversation_id, s.enabled, s.next_run_at, s.running_since, s.last_run_at, s.last_status, s.last_error, s.created_by, s.created_at, s.updated_at`
+
+const selectSchedule = "SELECT " + scheduleColumns + " FROM scheduled_prompts s"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSchedule reads the schedule columns followed by any extra ones
+func scanSchedule(row scanner, extra ...interface{}) (*Schedule, error) {
+	var s Schedule
+	var prompt, templateID, conversationID, lastStatus, lastError sql.NullString
+	var variables []byte
+	var nextRunAt, runningSince, lastRunAt sql.NullTime
+	dest := []interface{}{&s.ID, &s.ProjectID, &s.Name, &s.CronExpression, &s.Timezone, &prompt, &templateID, &variables, &s.Target,
+		&conversationID, &s.Enabled, &nextRunAt, &runningSince, &lastRunAt, &lastStatus, &lastError, &s.CreatedBy, &s.CreatedAt, &s.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	s.Prompt = prompt.String
+	s.TemplateID = nullString(templateID)
+	s.ConversationID = nullString(conversationID)
+	s.LastStatus = nullString(lastStatus)
+	s.LastError = nullString(lastError)
+	s.NextRunAt = nullTime(nextRunAt)
+	s.LastRunAt = nullTime(lastRunAt)
+	s.RunningSince = nullTime(runningSince)
+	if len(variables) > 0 {
+		if err := json.Unmarshal(variables, &s.Variables); err != nil {
+			return nil, fmt.Errorf("invalid variables for schedule %s: %w", s.ID, err)
+		}
+	}
+	if s.Variables == nil {
+		s.Variables = map[string]string{}
+	}
+	return &s, nil
+}
+
+func nullString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := value.Time.UTC()
+	return &t
+}
+
+// List returns the schedules of a project ordered by name
+func List(ctx context.Context, db tools.DBConnection, projectID string) ([]Schedule, error) {
+	rows, err := db.Query(ctx, selectSchedule+" WHERE s.project_id = $1 ORDER BY LOWER(s.name)", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan schedule: %w", err)
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, rows.Err()
+}
+
+// Get returns a schedule of a project
+func Get(ctx context.Context, db tools.DBConnection, projectID, scheduleID string) (*Schedule, error) {
+	if _, err := uuid.Parse(scheduleID); err != nil {
+		return nil, ErrScheduleNotFound
+	}
+	s, err := scanSchedule(db.QueryRow(ctx, selectSchedule+" WHERE s.id = $1 AND s.project_id = $2", scheduleID, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedule: %w", err)
+	}
+	return s, nil
+}
+
+// Create saves a new schedule; the input must already be normalized. Its
+// first run is at the next time the cron expression matches.
+func Create(ctx context.Context, db tools.DBConnection, projectID, userID string, in Input) (*Schedule, error) {
+	if err := checkReferences(ctx, db, projectID, in); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	nextRunAt, err := nextRunOf(in, now)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := json.Marshal(in.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode variables: %w", err)
+	}
+
+	id := uuid.New().String()
+	_, err = db.Exec(ctx,
+		`INSERT INTO scheduled_prompts (id, project_id, name, cron_expression, timezone, prompt, template_id, variables, target,
+			conversation_id, enabled, next_run_at, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14)`,
+		id, projectID, in.Name, in.CronExpression, in.Timezone, nullIfEmpty(in.Prompt), nullIfEmpty(in.TemplateID), string(variables), in.Target,
+		nullIfEmpty(in.ConversationID), *in.Enabled, nextRunAt, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return Get(ctx, db, projectID, id)
+}
+
+// Update replaces a schedule's settings; the input must already be
+// normalized. The next run is worked out again from now, so a schedule that
+// is re-enabled does not catch up on the runs it missed.
+func Update(ctx context.Context, db tools.DBConnection, projectID, scheduleID string, in Input) (*Schedule, error) {
+	if _, err := Get(ctx, db, projectID, scheduleID); err != nil {
+		return nil, err
+	}
+	if err := checkReferences(ctx, db, projectID, in); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	nextRunAt, err := nextRunOf(in, now)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := json.Marshal(in.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode variables: %w", err)
+	}
+
+	_, err = db.Exec(ctx,
+		`UPDATE scheduled_prompts SET name = $1, cron_expression = $2, timezone = $3, prompt = $4, template_id = $5, variables = $6,
+			target = $7, conversation_id = $8, enabled = $9, next_run_at = $10, updated_at = $11
+		WHERE id = $12 AND project_id = $13`,
+		in.Name, in.CronExpression, in.Timezone, nullIfEmpty(in.Prompt), nullIfEmpty(in.TemplateID), string(variables),
+		in.Target, nullIfEmpty(in.ConversationID), *in.Enabled, nextRunAt, now, scheduleID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+	return Get(ctx, db, projectID, scheduleID)
+}
+
+// Delete removes a schedule of a project; a run in progress still finishes
+func Delete(ctx context.Context, db tools.DBConnection, projectID, scheduleID string) error {
+	if _, err := uuid.Parse(scheduleID); err != nil {
+		return ErrScheduleNotFound
+	}
+	result, err := db.Exec(ctx, "DELETE FROM scheduled_prompts WHERE id = $1 AND project_id = $2", scheduleID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// nextRunOf returns the first run of an enabled schedule, nil for a disabled one
+func nextRunOf(in Input, now time.Time) (interface{}, error) {
+	if !*in.Enabled {
+		return nil, nil
+	}
+	next, err := NextRun(in.CronExpression, in.Timezone, now)
+	if err != nil {
+		return nil, &ValidationError{Field: "cron_expression", Reason: err.Error()}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	return *next, nil
+}
+
+// checkReferences checks that the template renders with the variables and
+// that the conversation belongs to the project
+func checkReferences(ctx context.Context, db tools.DBConnection, projectID string, in Input) error {
+	if in.TemplateID != "" {
+		if _, err := templates.Use(ctx, db, projectID, in.TemplateID, in.Variables); err != nil {
+			return err
+		}
+	}
+	if in.ConversationID != "" {
+		if _, err := uuid.Parse(in.ConversationID); err != nil {
+			return &ValidationError{Field: "conversation_id", Reason: "is not a conversation of the project"}
+		}
+		var count int
+		if err := db.QueryRow(ctx,
+			"SELECT COUNT(*) FROM conversations WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL",
+			in.ConversationID, projectID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check conversation: %w", err)
+		}
+		if count == 0 {
+			return &ValidationError{Field: "conversation_id", Reason: "is not a conversation of the project"}
+		}
+	}
+	return nil
+}
+
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// ErrorCode maps an error of this package, or of the templates it renders, to
+// an API error code and its details
+func ErrorCode(err error) (string, map[string]interface{}) {
+	var invalid *ValidationError
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		return apierror.CodeScheduleNotFound, nil
+	case errors.As(err, &invalid):
+		return apierror.CodeScheduleInvalid, map[string]interface{}{"field": invalid.Field, "reason": invalid.Reason}
+	default:
+		return templates.ErrorCode(err)
+	}
+}
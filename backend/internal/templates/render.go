@@ -0,0 +1,131 @@
+package templates
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variableName is what may appear between {{ and }}, surrounding spaces aside
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SyntaxError reports template content that cannot be parsed
+type SyntaxError struct {
+	Offset int // Byte offset of the offending {{
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid template at byte %d: %s", e.Offset, e.Reason)
+}
+
+// MissingVariablesError lists the variables a render was not given
+type MissingVariablesError struct {
+	Names []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return "missing template variables: " + strings.Join(e.Names, ", ")
+}
+
+// segment is a run of literal text or, when variable is set, a placeholder
+type segment struct {
+	text     string
+	variable string
+}
+
+// parse splits content into literal text and {{variable}} placeholders. \{{
+// writes a literal {{, and placeholders cannot contain braces.
+func parse(content string) ([]segment, error) {
+	var segments []segment
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			segments = append(segments, segment{text: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(content); {
+		rest := content[i:]
+		switch {
+		case strings.HasPrefix(rest, `\{{`):
+			literal.WriteString("{{")
+			i += 3
+		case strings.HasPrefix(rest, "{{"):
+			end := strings.Index(rest[2:], "}}")
+			if end < 0 {
+				return nil, &SyntaxError{Offset: i, Reason: "unclosed {{"}
+			}
+			inner := rest[2 : 2+end]
+			if strings.ContainsAny(inner, "{}") {
+				return nil, &SyntaxError{Offset: i, Reason: "placeholders cannot be nested"}
+			}
+			name := strings.TrimSpace(inner)
+			if !variableName.MatchString(name) {
+				return nil, &SyntaxError{Offset: i, Reason: fmt.Sprintf("invalid variable name %q", name)}
+			}
+			flush()
+			segments = append(segments, segment{variable: name})
+			i += 2 + end + 2
+		default:
+			literal.WriteByte(content[i])
+			i++
+		}
+	}
+	flush()
+	return segments, nil
+}
+
+// Variables returns the distinct variables used by template content, sorted
+func Variables(content string) ([]string, error) {
+	segments, err := parse(content)
+	if err != nil {
+		return nil, err
+	}
+	return variablesOf(segments), nil
+}
+
+func variablesOf(segments []segment) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, seg := range segments {
+		if seg.variable != "" && !seen[seg.variable] {
+			seen[seg.variable] = true
+			names = append(names, seg.variable)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render substitutes every placeholder with its variable. Every variable the
+// content uses is required and must not be blank; values are inserted as is,
+// so placeholders inside values are not expanded. Extra variables are ignored.
+func Render(content string, variables map[string]string) (string, error) {
+	segments, err := parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for _, name := range variablesOf(segments) {
+		if strings.TrimSpace(variables[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", &MissingVariablesError{Names: missing}
+	}
+
+	var rendered strings.Builder
+	for _, seg := range segments {
+		if seg.variable != "" {
+			rendered.WriteString(variables[seg.variable])
+		} else {
+			rendered.WriteString(seg.text)
+		}
+	}
+	return rendered.String(), nil
+}
@@ -0,0 +1,86 @@
+package templates
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		variables map[string]string
+		expected  string
+	}{
+		{"plain text", "Summarize last week", nil, "Summarize last week"},
+		{"placeholder", "Sales for {{region}}", map[string]string{"region": "EMEA"}, "Sales for EMEA"},
+		{"spaces inside braces", "Sales for {{ region }}", map[string]string{"region": "EMEA"}, "Sales for EMEA"},
+		{"repeated placeholder", "{{a}} and {{a}}", map[string]string{"a": "x"}, "x and x"},
+		{"escaped braces", `Use \{{name}} literally, {{name}}`, map[string]string{"name": "Ann"}, "Use {{name}} literally, Ann"},
+		{"values are not expanded", "Hi {{name}}", map[string]string{"name": "{{other}}"}, "Hi {{other}}"},
+		{"extra variables ignored", "Hi {{name}}", map[string]string{"name": "Ann", "unused": "x"}, "Hi Ann"},
+		{"single braces are text", "a {b} c}}", nil, "a {b} c}}"},
+		{"unicode kept", "Halo {{nama}} 👋", map[string]string{"nama": "Budi"}, "Halo Budi 👋"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := Render(tt.content, tt.variables)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rendered != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, rendered)
+			}
+		})
+	}
+}
+
+func TestRenderMissingVariables(t *testing.T) {
+	_, err := Render("{{region}} sales from {{start}} to {{end}}", map[string]string{"start": "Monday", "end": "  "})
+	var missing *MissingVariablesError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected MissingVariablesError, got %v", err)
+	}
+	if !reflect.DeepEqual(missing.Names, []string{"end", "region"}) {
+		t.Errorf("Expected blank and absent variables sorted, got %v", missing.Names)
+	}
+}
+
+func TestRenderSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		offset  int
+	}{
+		{"unclosed", "Hello {{name", 6},
+		{"nested braces", "{{outer {{inner}} }}", 0},
+		{"brace inside placeholder", "x {{a}b}}", 2},
+		{"empty placeholder", "{{ }}", 0},
+		{"invalid name", "{{first-name}}", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Render(tt.content, map[string]string{"name": "x", "inner": "x"})
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Fatalf("Expected SyntaxError, got %v", err)
+			}
+			if syntax.Offset != tt.offset {
+				t.Errorf("Expected offset %d, got %d", tt.offset, syntax.Offset)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	names, err := Variables(`{{b}} {{a}} {{ b }} \{{c}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Expected distinct sorted names without escaped ones, got %v", names)
+	}
+}
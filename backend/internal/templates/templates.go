@@ -0,0 +1,238 @@
+// Package templates stores a project's saved prompts. Template content may
+// hold {{variable}} placeholders that are filled in when the template is used
+// to start a conversation; see Render.
+package templates
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// Field limits
+const (
+	MaxNameLength        = 255
+	MaxDescriptionLength = 1000
+	MaxContentLength     = 20000
+)
+
+var (
+	// ErrTemplateNotFound is returned for templates missing from the project
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrNameTaken is returned when another template of the project has the name
+	ErrNameTaken = errors.New("template name already in use")
+)
+
+// Template is a saved prompt of a project
+type Template struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Content     string    `json:"content"`
+	Variables   []string  `json:"variables"` // Placeholders in content, sorted
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Input is the editable part of a template
+type Input struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+}
+
+// ValidationError reports an input field that is missing or invalid
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Normalize trims the input and checks its fields, including that content parses
+func (in *Input) Normalize() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Description = strings.TrimSpace(in.Description)
+	switch {
+	case in.Name == "":
+		return &ValidationError{Field: "name", Reason: "is required"}
+	case utf8.RuneCountInString(in.Name) > MaxNameLength:
+		return &ValidationError{Field: "name", Reason: fmt.Sprintf("must be at most %d characters", MaxNameLength)}
+	case utf8.RuneCountInString(in.Description) > MaxDescriptionLength:
+		return &ValidationError{Field: "description", Reason: fmt.Sprintf("must be at most %d characters", MaxDescriptionLength)}
+	case strings.TrimSpace(in.Content) == "":
+		return &ValidationError{Field: "content", Reason: "is required"}
+	case utf8.RuneCountInString(in.Content) > MaxContentLength:
+		return &ValidationError{Field: "content", Reason: fmt.Sprintf("must be at most %d characters", MaxContentLength)}
+	}
+	if _, err := parse(in.Content); err != nil {
+		return err
+	}
+	return nil
+}
+
+const selectTemplate = `SELECT id, project_id, name, description, content, created_by, created_at, updated_at
+	FROM prompt_templates`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplate(row scanner) (*Template, error) {
+	var t Template
+	var description sql.NullString
+	if err := row.Scan(&t.ID, &t.ProjectID, &t.Name, &description, &t.Content, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	t.Description = description.String
+	// Content is validated when saved, so a parse failure only leaves variables empty
+	t.Variables, _ = Variables(t.Content)
+	if t.Variables == nil {
+		t.Variables = []string{}
+	}
+	return &t, nil
+}
+
+// List returns the templates of a project ordered by name
+func List(ctx context.Context, db tools.DBConnection, projectID string) ([]Template, error) {
+	rows, err := db.Query(ctx, selectTemplate+" WHERE project_id = $1 ORDER BY LOWER(name)", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []Template{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+// Get returns a template of a project
+func Get(ctx context.Context, db tools.DBConnection, projectID, templateID string) (*Template, error) {
+	if _, err := uuid.Parse(templateID); err != nil {
+		return nil, ErrTemplateNotFound
+	}
+	t, err := scanTemplate(db.QueryRow(ctx, selectTemplate+" WHERE id = $1 AND project_id = $2", templateID, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	return t, nil
+}
+
+// Create saves a new template; the input must already be normalized
+func Create(ctx context.Context, db tools.DBConnection, projectID, userID string, in Input) (*Template, error) {
+	if err := checkNameFree(ctx, db, projectID, in.Name, ""); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	_, err := db.Exec(ctx,
+		`INSERT INTO prompt_templates (id, project_id, name, description, content, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+		id, projectID, in.Name, in.Description, in.Content, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create template: %w", err)
+	}
+	return Get(ctx, db, projectID, id)
+}
+
+// Update replaces a template's name, description and content; the input must already be normalized
+func Update(ctx context.Context, db tools.DBConnection, projectID, templateID string, in Input) (*Template, error) {
+	if _, err := Get(ctx, db, projectID, templateID); err != nil {
+		return nil, err
+	}
+	if err := checkNameFree(ctx, db, projectID, in.Name, templateID); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(ctx,
+		`UPDATE prompt_templates SET name = $1, description = $2, content = $3, updated_at = $4
+		WHERE id = $5 AND project_id = $6`,
+		in.Name, in.Description, in.Content, time.Now().UTC(), templateID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update template: %w", err)
+	}
+	return Get(ctx, db, projectID, templateID)
+}
+
+// Use renders a template of a project with the given variables
+func Use(ctx context.Context, db tools.DBConnection, projectID, templateID string, variables map[string]string) (string, error) {
+	t, err := Get(ctx, db, projectID, templateID)
+	if err != nil {
+		return "", err
+	}
+	return Render(t.Content, variables)
+}
+
+// Delete removes a template of a project
+func Delete(ctx context.Context, db tools.DBConnection, projectID, templateID string) error {
+	if _, err := uuid.Parse(templateID); err != nil {
+		return ErrTemplateNotFound
+	}
+	result, err := db.Exec(ctx, "DELETE FROM prompt_templates WHERE id = $1 AND project_id = $2", templateID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// checkNameFree reports ErrNameTaken when another template of the project has
+// the name, compared case-insensitively
+func checkNameFree(ctx context.Context, db tools.DBConnection, projectID, name, exceptID string) error {
+	var id string
+	err := db.QueryRow(ctx,
+		"SELECT id FROM prompt_templates WHERE project_id = $1 AND LOWER(name) = LOWER($2) AND CAST(id AS TEXT) <> $3",
+		projectID, name, exceptID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check template name: %w", err)
+	}
+	return ErrNameTaken
+}
+
+// ErrorCode maps an error of this package to an API error code and its details
+func ErrorCode(err error) (string, map[string]interface{}) {
+	var invalid *ValidationError
+	var syntax *SyntaxError
+	var missing *MissingVariablesError
+	switch {
+	case errors.Is(err, ErrTemplateNotFound):
+		return apierror.CodeTemplateNotFound, nil
+	case errors.Is(err, ErrNameTaken):
+		return apierror.CodeTemplateNameTaken, nil
+	case errors.As(err, &invalid):
+		return apierror.CodeTemplateInvalid, map[string]interface{}{"field": invalid.Field, "reason": invalid.Reason}
+	case errors.As(err, &syntax):
+		return apierror.CodeTemplateInvalid, map[string]interface{}{"field": "content", "reason": syntax.Reason, "offset": syntax.Offset}
+	case errors.As(err, &missing):
+		return apierror.CodeTemplateVariablesMissing, map[string]interface{}{"variables": strings.Join(missing.Names, ", ")}
+	default:
+		return apierror.CodeDatabaseError, nil
+	}
+}
@@ -0,0 +1,307 @@
+package tenantdata
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zlay-backend/internal/analytics"
+)
+
+// ArchiveVersion is the version of the export archive layout
+const ArchiveVersion = 1
+
+// Redacted replaces secrets in exported datasource configs
+const Redacted = "[REDACTED]"
+
+// exportSection is one array of rows in an export archive
+type exportSection struct {
+	name        string
+	query       string          // $1 is the client id
+	jsonColumns map[string]bool // Columns holding JSON documents, embedded as JSON rather than strings
+	transform   func(row map[string]interface{})
+}
+
+// exportSections are written in this order. Password hashes, API keys and
+// datasource secrets are left out.
+var exportSections = []exportSection{
+	{
+		name:  "users",
+		query: "SELECT id, username, is_active, is_visitor, expires_at, created_at FROM users WHERE client_id = $1 ORDER BY created_at, id",
+	},
+	{
+		name:  "projects",
+		query: "SELECT id, user_id, name, description, is_active, default_datasource_id, created_at, updated_at FROM projects WHERE id IN (" + clientProjects + ") ORDER BY created_at, id",
+	},
+	{
+		name:        "datasources",
+		query:       "SELECT id, project_id, name, type, config, query_policies, is_active, created_at, updated_at FROM datasources WHERE id IN (" + clientDatasources + ") ORDER BY created_at, id",
+		jsonColumns: map[string]bool{"config": true, "query_policies": true},
+		transform: func(row map[string]interface{}) {
+			row["config"] = redactSecrets(row["config"])
+		},
+	},
+	{
+		name:  "conversations",
+		query: "SELECT id, project_id, user_id, title, status, model, temperature, max_tokens, created_at, updated_at, deleted_at FROM conversations WHERE id IN (" + clientConversations + ") ORDER BY created_at, id",
+	},
+	{
+		name:        "messages",
+		query:       "SELECT id, conversation_id, user_id, role, content, metadata, tool_calls, created_at FROM messages WHERE conversation_id IN (" + clientConversations + ") ORDER BY conversation_id, created_at, id",
+		jsonColumns: map[string]bool{"metadata": true, "tool_calls": true},
+	},
+	{
+		name:        "tool_executions",
+		query:       "SELECT id, message_id, conversation_id, tool_name, arguments, status, result, result_file_path, error, started_at, finished_at, duration_ms FROM tool_executions WHERE conversation_id IN (" + clientConversations + ") ORDER BY conversation_id, started_at, message_id, id",
+		jsonColumns: map[string]bool{"arguments": true, "result": true},
+		transform:   inlineToolResult,
+	},
+}
+
+// inlineToolResult replaces the path of a result kept in a file with the
+// result itself, so the archive does not depend on the server's files
+func inlineToolResult(row map[string]interface{}) {
+	path, _ := row["result_file_path"].(string)
+	delete(row, "result_file_path")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err == nil {
+		row["result"] = result
+	}
+}
+
+// clientQuery selects the client itself, without its LLM API key
+const clientQuery = `SELECT id, name, slug, ai_api_url, ai_api_model, ai_api_type, allowed_models, branding, is_active, created_at, updated_at
+	FROM clients WHERE id = $1`
+
+// export writes the client's archive to a temporary file and moves it into
+// place once complete, so a half-written archive is never served
+func (m *Manager) export(ctx context.Context, job *Job) error {
+	if err := os.MkdirAll(m.opts.ExportsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	path := filepath.Join(m.opts.ExportsDir, job.ID+".json.gz")
+	partial := path + ".partial"
+
+	file, err := os.Create(partial)
+	if err != nil {
+		return fmt.Errorf("failed to create export archive: %w", err)
+	}
+	defer os.Remove(partial)
+	defer file.Close()
+
+	job.Counts = map[string]int64{}
+	buffered := bufio.NewWriter(file)
+	archive := gzip.NewWriter(buffered)
+	if err := m.writeArchive(ctx, archive, job); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to compress export archive: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return fmt.Errorf("failed to store export archive: %w", err)
+	}
+
+	job.ResultPath = path
+	return m.saveProgress(ctx, job)
+}
+
+// writeArchive writes the archive document: the client, one array per
+// section and the client's token usage
+func (m *Manager) writeArchive(ctx context.Context, w io.Writer, job *Job) error {
+	client, err := m.queryRows(ctx, exportSection{name: "client", query: clientQuery,
+		jsonColumns: map[string]bool{"allowed_models": true, "branding": true}}, job.ClientID, nil)
+	if err != nil {
+		return err
+	}
+	if len(client) == 0 {
+		return fmt.Errorf("client %s not found", job.ClientID)
+	}
+
+	exportedAt := m.now().UTC()
+	header, err := json.Marshal(map[string]interface{}{
+		"schema_version": ArchiveVersion,
+		"exported_at":    exportedAt,
+		"client":         client[0],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode export header: %w", err)
+	}
+	// The sections follow the header's fields in the same object
+	if _, err := w.Write(header[:len(header)-1]); err != nil {
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+
+	for _, section := range exportSections {
+		if _, err := fmt.Fprintf(w, ",%q:[", section.name); err != nil {
+			return fmt.Errorf("failed to write export archive: %w", err)
+		}
+		count := int64(0)
+		_, err := m.queryRows(ctx, section, job.ClientID, func(row map[string]interface{}) error {
+			encoded, err := json.Marshal(row)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s row: %w", section.name, err)
+			}
+			if count > 0 {
+				if _, err := w.Write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			count++
+			_, err = w.Write(encoded)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		job.Counts[section.name] = count
+		if _, err := w.Write([]byte("]")); err != nil {
+			return fmt.Errorf("failed to write export archive: %w", err)
+		}
+	}
+
+	// Token usage is estimated from message lengths, as on the admin dashboard
+	overview, err := analytics.NewReporter(m.db, 0).Overview(ctx, analytics.Filter{To: exportedAt.Add(time.Second), ClientID: job.ClientID})
+	if err != nil {
+		return fmt.Errorf("failed to compute token usage: %w", err)
+	}
+	usage, err := json.Marshal(map[string]interface{}{
+		"tokens":           overview.Tokens,
+		"messages_by_role": overview.MessagesByRole,
+		"tool_executions":  overview.ToolExecutions,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode token usage: %w", err)
+	}
+	if _, err := fmt.Fprintf(w, `,"token_usage":%s}`, usage); err != nil {
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+	return nil
+}
+
+// queryRows runs a section's query and passes each row to emit as a map of
+// column name to value. Without emit the rows are collected and returned.
+func (m *Manager) queryRows(ctx context.Context, section exportSection, clientID string, emit func(map[string]interface{}) error) ([]map[string]interface{}, error) {
+	rows, err := m.db.Query(ctx, section.query, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+	}
+	var collected []map[string]interface{}
+	for rows.Next() {
+		row, err := scanRow(rows, columns, section.jsonColumns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", section.name, err)
+		}
+		if section.transform != nil {
+			section.transform(row)
+		}
+		if emit == nil {
+			collected = append(collected, row)
+		} else if err := emit(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to export %s: %w", section.name, err)
+	}
+	return collected, nil
+}
+
+// scanRow reads the current row into a map; byte values become strings and
+// JSON columns are decoded so the archive embeds them as JSON
+func scanRow(rows *sql.Rows, columns []string, jsonColumns map[string]bool) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		value := values[i]
+		if raw, ok := value.([]byte); ok {
+			value = string(raw)
+		}
+		if text, ok := value.(string); ok && jsonColumns[column] {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(text), &decoded); err == nil {
+				value = decoded
+			}
+		}
+		row[column] = value
+	}
+	return row, nil
+}
+
+// secretKeys are fragments of config keys whose values are never exported
+var secretKeys = []string{"password", "secret", "token", "key", "credential", "private"}
+
+// redactSecrets replaces the values of secret-looking keys in a decoded JSON
+// document with Redacted, and masks the password of connection URLs
+func redactSecrets(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range typed {
+			if IsSecretKey(key) && nested != nil && nested != "" {
+				typed[key] = Redacted
+			} else {
+				typed[key] = redactSecrets(nested)
+			}
+		}
+		return typed
+	case []interface{}:
+		for i, nested := range typed {
+			typed[i] = redactSecrets(nested)
+		}
+		return typed
+	case string:
+		if parsed, err := url.Parse(typed); err == nil && parsed.User != nil {
+			return parsed.Redacted()
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+// IsSecretKey reports whether a config key holds a secret, going by secretKeys
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, fragment := range secretKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
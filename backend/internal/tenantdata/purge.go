@@ -0,0 +1,233 @@
+package tenantdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"zlay-backend/internal/tools"
+)
+
+// Subqueries selecting the ids of a client's rows; $1 is the client id
+const (
+	clientUsers         = "SELECT id FROM users WHERE client_id = $1"
+	clientProjects      = "SELECT id FROM projects WHERE user_id IN (" + clientUsers + ")"
+	clientConversations = "SELECT id FROM conversations WHERE project_id IN (" + clientProjects + ") OR user_id IN (" + clientUsers + ")"
+	clientDatasources   = "SELECT id FROM datasources WHERE project_id IN (" + clientProjects + ")"
+	clientWebhooks      = "SELECT id FROM webhooks WHERE client_id = $1"
+)
+
+// purgeStep deletes the rows of table whose column is in scope, a batch of
+// distinct values at a time. With set, the rows are updated instead and
+// scope must leave out the rows already updated, so every batch makes progress.
+type purgeStep struct {
+	table  string
+	column string
+	scope  string
+	set    string
+
+	// beforeDelete runs in the batch's transaction before its rows are deleted
+	beforeDelete func(ctx context.Context, m *Manager, tx *sql.Tx, values []interface{}) error
+}
+
+// purgeSteps lists every table holding a client's data. Steps are persisted by
+// index, so a job resumed after an upgrade must find the same step at the same
+// place: new steps are only appended, never inserted or reordered. The first
+// steps delete the rows that reference a table before the table itself; tables
+// added later reference their parents with ON DELETE CASCADE, so their steps
+// at the end only clean up what the cascades left. Audit entries are kept for
+// the record with the caller's address and details dropped.
+var purgeSteps = []purgeStep{
+	{table: "sessions", column: "id", scope: "SELECT id FROM sessions WHERE client_id = $1 OR user_id IN (" + clientUsers + ")"},
+	{table: "message_embeddings", column: "conversation_id", scope: clientConversations},
+	{table: "message_metrics", column: "conversation_id", scope: clientConversations},
+	{table: "message_feedback", column: "conversation_id", scope: clientConversations},
+	{table: "conversation_summaries", column: "conversation_id", scope: clientConversations},
+	{table: "conversation_shares", column: "conversation_id", scope: clientConversations},
+	{table: "conversation_participants", column: "conversation_id", scope: clientConversations},
+	{table: "messages", column: "id", scope: "SELECT id FROM messages WHERE conversation_id IN (" + clientConversations + ")"},
+	{table: "conversations", column: "id", scope: clientConversations, beforeDelete: removeToolResults},
+	{table: "query_jobs", column: "id", scope: "SELECT id FROM query_jobs WHERE project_id IN (" + clientProjects + ")", beforeDelete: removeQueryJobResults},
+	{table: "datasource_schema_snapshots", column: "datasource_id", scope: clientDatasources},
+	{table: "projects", column: "id", scope: "SELECT id FROM projects WHERE default_datasource_id IN (" + clientDatasources + ")", set: "default_datasource_id = NULL"},
+	{table: "datasources", column: "id", scope: clientDatasources},
+	{table: "project_tools", column: "project_id", scope: clientProjects},
+	{table: "project_files", column: "id", scope: "SELECT id FROM project_files WHERE project_id IN (" + clientProjects + ")", beforeDelete: removeProjectFiles},
+	{table: "api_allowlist", column: "project_id", scope: clientProjects},
+	{table: "prompt_templates", column: "project_id", scope: clientProjects},
+	{table: "api_keys", column: "id", scope: "SELECT id FROM api_keys WHERE client_id = $1 OR project_id IN (" + clientProjects + ")"},
+	{table: "projects", column: "id", scope: clientProjects},
+	{table: "webhook_deliveries", column: "webhook_id", scope: clientWebhooks},
+	{table: "webhooks", column: "id", scope: clientWebhooks},
+	{table: "notifications", column: "id", scope: "SELECT id FROM notifications WHERE client_id = $1"},
+	{table: "notification_settings", column: "client_id", scope: "SELECT id FROM clients WHERE id = $1"},
+	{table: "domains", column: "id", scope: "SELECT id FROM domains WHERE client_id = $1"},
+	{table: "users", column: "id", scope: clientUsers},
+	{table: "audit_log", column: "id", scope: "SELECT id FROM audit_log WHERE client_id = $1 AND (ip IS NOT NULL OR details IS NOT NULL)", set: "ip = NULL, details = NULL"},
+	{table: "clients", column: "id", scope: "SELECT id FROM clients WHERE id = $1"},
+	{table: "content_filters", column: "id", scope: "SELECT id FROM content_filters WHERE client_id = $1"},
+	{table: "tool_executions", column: "conversation_id", scope: clientConversations, beforeDelete: removeToolResults},
+	{table: "project_events", column: "project_id", scope: clientProjects},
+	{table: "project_retention_runs", column: "project_id", scope: clientProjects},
+	{table: "scheduled_prompts", column: "project_id", scope: clientProjects},
+	{table: "token_usage", column: "id", scope: "SELECT id FROM token_usage WHERE client_id = $1"},
+	{table: "tool_execution_audit", column: "id", scope: "SELECT id FROM tool_execution_audit WHERE client_id = $1"},
+}
+
+// purge runs the steps the job has not finished yet, saving progress after
+// every batch so a restart continues where it stopped
+func (m *Manager) purge(ctx context.Context, job *Job) error {
+	for job.Step < len(purgeSteps) {
+		step := purgeSteps[job.Step]
+		for {
+			found, affected, err := m.purgeBatch(ctx, job.ClientID, step)
+			if err != nil {
+				return fmt.Errorf("failed to purge %s: %w", step.table, err)
+			}
+			if affected > 0 {
+				job.Counts[step.table] += affected
+			}
+			if found < m.opts.BatchSize {
+				break
+			}
+			if err := m.saveProgress(ctx, job); err != nil {
+				return err
+			}
+		}
+		job.Step++
+		if err := m.saveProgress(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeBatch deletes or updates the rows of up to batchSize values of the
+// step's column in one transaction. It returns how many values were found
+// and how many rows were changed.
+func (m *Manager) purgeBatch(ctx context.Context, clientID string, step purgeStep) (int, int64, error) {
+	rows, err := m.db.Query(ctx,
+		fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IN (%s) LIMIT $2", step.column, step.table, step.column, step.scope),
+		clientID, m.opts.BatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to select rows: %w", err)
+	}
+	var values []interface{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan %s: %w", step.column, err)
+		}
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(values) == 0 {
+		return 0, 0, nil
+	}
+
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	in := strings.Join(placeholders, ", ")
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return len(values), 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if step.beforeDelete != nil {
+		if err := step.beforeDelete(ctx, m, tx, values); err != nil {
+			return len(values), 0, err
+		}
+	}
+
+	statement := fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", step.table, step.column, in)
+	if step.set != "" {
+		statement = fmt.Sprintf("UPDATE %s SET %s WHERE %s IN (%s)", step.table, step.set, step.column, in)
+	}
+	result, err := tx.ExecContext(ctx, statement, values...)
+	if err != nil {
+		return len(values), 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return len(values), 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return len(values), affected, nil
+}
+
+// removeQueryJobResults deletes the result files of query jobs about to be purged
+func removeQueryJobResults(ctx context.Context, m *Manager, tx *sql.Tx, ids []interface{}) error {
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT result_path FROM query_jobs WHERE result_path IS NOT NULL AND id IN ("+strings.Join(placeholders, ", ")+")", ids...)
+	if err != nil {
+		return fmt.Errorf("failed to find query job results: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return fmt.Errorf("failed to scan query job result path: %w", err)
+		}
+		removeFile(path)
+	}
+	return rows.Err()
+}
+
+// removeToolResults deletes the result files of the tool executions of
+// conversations about to be purged
+func removeToolResults(ctx context.Context, m *Manager, tx *sql.Tx, conversationIDs []interface{}) error {
+	placeholders := make([]string, len(conversationIDs))
+	for i := range conversationIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT result_file_path FROM tool_executions WHERE result_file_path IS NOT NULL AND conversation_id IN ("+strings.Join(placeholders, ", ")+")", conversationIDs...)
+	if err != nil {
+		return fmt.Errorf("failed to find tool results: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return fmt.Errorf("failed to scan tool result path: %w", err)
+		}
+		removeFile(path)
+	}
+	return rows.Err()
+}
+
+// removeProjectFiles deletes the uploads of project files about to be purged
+func removeProjectFiles(ctx context.Context, m *Manager, tx *sql.Tx, ids []interface{}) error {
+	if m.opts.FilesDir == "" {
+		return nil
+	}
+	for _, id := range ids {
+		path, err := tools.ProjectFilePath(m.opts.FilesDir, id.(string))
+		if err != nil {
+			continue
+		}
+		removeFile(path)
+	}
+	return nil
+}
+
+// removeFile deletes a file, logging rather than failing the purge when it cannot
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %v", path, err)
+	}
+}
@@ -0,0 +1,344 @@
+// Package tenantdata exports and purges everything stored for a client, for
+// data portability and right-to-be-forgotten requests. Both run as background
+// jobs tracked in the tenant_jobs table, so a job interrupted by a restart is
+// picked up again by Resume.
+package tenantdata
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Job kinds
+const (
+	KindExport = "export"
+	KindPurge  = "purge"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+const (
+	// DefaultBatchSize limits how many rows one purge transaction deletes
+	DefaultBatchSize = 500
+	// ConfirmationTTL is how long a purge confirmation token can be redeemed
+	ConfirmationTTL = 10 * time.Minute
+)
+
+var (
+	// ErrJobNotFound is returned when a job ID does not exist
+	ErrJobNotFound = errors.New("tenant job not found")
+	// ErrInvalidConfirmation is returned when a purge is started with a token
+	// that was not issued for the client and admin, was used or has expired
+	ErrInvalidConfirmation = errors.New("invalid purge confirmation token")
+)
+
+// Job is an export or purge of one client's data
+type Job struct {
+	ID          string           `json:"id"`
+	ClientID    string           `json:"client_id"`
+	Kind        string           `json:"kind"`
+	Status      string           `json:"status"`
+	Counts      map[string]int64 `json:"counts"` // Rows exported or purged per table
+	Error       string           `json:"error,omitempty"`
+	CreatedBy   string           `json:"created_by,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	StartedAt   *time.Time       `json:"started_at,omitempty"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	Step        int              `json:"-"` // Purge steps finished
+	ResultPath  string           `json:"-"`
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed
+}
+
+// Options configure a Manager
+type Options struct {
+	ExportsDir string         // Where export archives are written
+	FilesDir   string         // Project file uploads, removed from disk by a purge
+	BatchSize  int            // Rows per purge transaction; DefaultBatchSize when not positive
+	OnPurged   func(job *Job) // Called once a purge completes, e.g. to drop cached client settings
+}
+
+// confirmation is an outstanding purge confirmation token
+type confirmation struct {
+	clientID  string
+	actorID   string
+	expiresAt time.Time
+}
+
+// Manager starts tenant jobs and tracks them in the tenant_jobs table
+type Manager struct {
+	db   tools.DBConnection
+	opts Options
+	now  func() time.Time
+	wg   sync.WaitGroup
+
+	mutex         sync.Mutex
+	confirmations map[string]confirmation
+}
+
+// NewManager creates a tenant job manager
+func NewManager(db tools.DBConnection, opts Options) *Manager {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	return &Manager{
+		db:            db,
+		opts:          opts,
+		now:           time.Now,
+		confirmations: make(map[string]confirmation),
+	}
+}
+
+// StartExport records an export of the client's data and builds the archive in the background
+func (m *Manager) StartExport(ctx context.Context, clientID, actorID string) (*Job, error) {
+	return m.start(ctx, KindExport, clientID, actorID)
+}
+
+// RequestPurge issues the token StartPurge must be given to purge the client.
+// Tokens are kept in memory, so a restart invalidates them.
+func (m *Manager) RequestPurge(clientID, actorID string) (string, time.Time) {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	token := hex.EncodeToString(raw)
+	expiresAt := m.now().Add(ConfirmationTTL)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for issued, pending := range m.confirmations {
+		if m.now().After(pending.expiresAt) {
+			delete(m.confirmations, issued)
+		}
+	}
+	m.confirmations[token] = confirmation{clientID: clientID, actorID: actorID, expiresAt: expiresAt}
+	return token, expiresAt
+}
+
+// StartPurge redeems a token from RequestPurge and purges the client in the
+// background. The client is deactivated before StartPurge returns, so its
+// users can no longer sign in or connect while its rows are deleted.
+func (m *Manager) StartPurge(ctx context.Context, clientID, actorID, token string) (*Job, error) {
+	m.mutex.Lock()
+	pending, exists := m.confirmations[token]
+	if exists && pending.clientID == clientID && pending.actorID == actorID && !m.now().After(pending.expiresAt) {
+		delete(m.confirmations, token)
+	} else {
+		exists = false
+	}
+	m.mutex.Unlock()
+	if !exists {
+		return nil, ErrInvalidConfirmation
+	}
+
+	if err := m.deactivateClient(ctx, clientID); err != nil {
+		return nil, err
+	}
+	return m.start(ctx, KindPurge, clientID, actorID)
+}
+
+func (m *Manager) deactivateClient(ctx context.Context, clientID string) error {
+	if _, err := m.db.Exec(ctx, "UPDATE clients SET is_active = false, version = version + 1 WHERE id = $1", clientID); err != nil {
+		return fmt.Errorf("failed to deactivate client: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) start(ctx context.Context, kind, clientID, actorID string) (*Job, error) {
+	job := &Job{
+		ID:        uuid.New().String(),
+		ClientID:  clientID,
+		Kind:      kind,
+		Status:    StatusPending,
+		Counts:    map[string]int64{},
+		CreatedBy: actorID,
+		CreatedAt: m.now().UTC(),
+	}
+	_, err := m.db.Exec(ctx,
+		"INSERT INTO tenant_jobs (id, client_id, kind, status, step, created_by, created_at) VALUES ($1, $2, $3, $4, 0, $5, $6)",
+		job.ID, job.ClientID, job.Kind, job.Status, nullableString(job.CreatedBy), job.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tenant job: %w", err)
+	}
+
+	m.wg.Add(1)
+	go m.run(*job)
+	return job, nil
+}
+
+// Resume restarts the jobs a previous process left pending or running. An
+// export is built again from the start; a purge continues after its last
+// finished step. It returns how many jobs were restarted.
+func (m *Manager) Resume(ctx context.Context) (int, error) {
+	rows, err := m.db.Query(ctx, "SELECT id FROM tenant_jobs WHERE status IN ($1, $2) ORDER BY created_at",
+		StatusPending, StatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find interrupted tenant jobs: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan tenant job id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		job, err := m.Get(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if job.Kind == KindPurge {
+			// The client may have been reactivated by hand since; a purge is never undone
+			if err := m.deactivateClient(ctx, job.ClientID); err != nil {
+				return 0, err
+			}
+		}
+		m.wg.Add(1)
+		go m.run(*job)
+	}
+	return len(ids), nil
+}
+
+// Wait blocks until every started job has finished
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Manager) run(job Job) {
+	defer m.wg.Done()
+
+	// Jobs outlive the request that started them, so they get a fresh context
+	ctx := context.Background()
+	started := m.now().UTC()
+	if _, err := m.db.Exec(ctx, "UPDATE tenant_jobs SET status = $1, started_at = $2 WHERE id = $3",
+		StatusRunning, started, job.ID); err != nil {
+		log.Printf("Failed to mark tenant job %s running: %v", job.ID, err)
+	}
+	job.Status = StatusRunning
+	job.StartedAt = &started
+
+	err := m.execute(ctx, &job)
+
+	completed := m.now().UTC()
+	job.CompletedAt = &completed
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		log.Printf("Tenant %s of client %s failed: %v", job.Kind, job.ClientID, err)
+		_, err = m.db.Exec(ctx, "UPDATE tenant_jobs SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			job.Status, job.Error, completed, job.ID)
+	} else {
+		job.Status = StatusCompleted
+		log.Printf("Tenant %s of client %s completed", job.Kind, job.ClientID)
+		_, err = m.db.Exec(ctx, "UPDATE tenant_jobs SET status = $1, result_path = $2, completed_at = $3 WHERE id = $4",
+			job.Status, nullableString(job.ResultPath), completed, job.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to record tenant job %s outcome: %v", job.ID, err)
+	}
+
+	if job.Kind == KindPurge && job.Status == StatusCompleted && m.opts.OnPurged != nil {
+		m.opts.OnPurged(&job)
+	}
+}
+
+// execute runs the job, turning panics into job failures
+func (m *Manager) execute(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("tenant job panicked: %v", r)
+		}
+	}()
+
+	switch job.Kind {
+	case KindExport:
+		return m.export(ctx, job)
+	case KindPurge:
+		return m.purge(ctx, job)
+	default:
+		return fmt.Errorf("unknown tenant job kind %q", job.Kind)
+	}
+}
+
+// saveProgress records the steps finished and the rows counted so far
+func (m *Manager) saveProgress(ctx context.Context, job *Job) error {
+	counts, err := json.Marshal(job.Counts)
+	if err != nil {
+		return fmt.Errorf("failed to encode counts: %w", err)
+	}
+	if _, err := m.db.Exec(ctx, "UPDATE tenant_jobs SET step = $1, counts = $2 WHERE id = $3",
+		job.Step, string(counts), job.ID); err != nil {
+		return fmt.Errorf("failed to save tenant job progress: %w", err)
+	}
+	return nil
+}
+
+// Get loads a job by ID
+func (m *Manager) Get(ctx context.Context, id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	job := &Job{ID: id, Counts: map[string]int64{}}
+	var counts, jobError, resultPath, createdBy sql.NullString
+	var startedAt, completedAt sql.NullTime
+	err := m.db.QueryRow(ctx,
+		`SELECT client_id, kind, status, step, counts, error, result_path, created_by, created_at, started_at, completed_at
+		FROM tenant_jobs WHERE id = $1`, id).
+		Scan(&job.ClientID, &job.Kind, &job.Status, &job.Step, &counts, &jobError, &resultPath, &createdBy,
+			&job.CreatedAt, &startedAt, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant job: %w", err)
+	}
+
+	if counts.Valid && counts.String != "" {
+		if err := json.Unmarshal([]byte(counts.String), &job.Counts); err != nil {
+			return nil, fmt.Errorf("failed to decode tenant job counts: %w", err)
+		}
+	}
+	job.Error = jobError.String
+	job.ResultPath = resultPath.String
+	job.CreatedBy = createdBy.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, nil
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
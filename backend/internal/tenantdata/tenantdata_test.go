@@ -0,0 +1,434 @@
+package tenantdata
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+// fixture is a client seeded with a row in every table that holds client data
+type fixture struct {
+	clientID    string
+	fileID      string
+	filePath    string
+	resultPath  string
+	toolPath    string
+	passwordRaw string
+}
+
+func setupTenantDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+func newTestManager(t *testing.T, conn tools.DBConnection, filesDir string, onPurged func(*Job)) *Manager {
+	t.Helper()
+	return NewManager(conn, Options{ExportsDir: filepath.Join(t.TempDir(), "exports"), FilesDir: filesDir, BatchSize: 2, OnPurged: onPurged})
+}
+
+// seedClient inserts a client named prefix whose every id starts with prefix
+func seedClient(t *testing.T, conn tools.DBConnection, prefix, filesDir string) fixture {
+	t.Helper()
+
+	f := fixture{
+		clientID:    prefix + "-client",
+		fileID:      fmt.Sprintf("%08d-0000-4000-8000-000000000000", len(prefix)),
+		passwordRaw: prefix + "-db-password",
+	}
+	f.filePath = filepath.Join(filesDir, f.fileID)
+	f.resultPath = filepath.Join(filesDir, prefix+"-result.jsonl")
+	for _, path := range []string{f.filePath, f.resultPath} {
+		if err := os.WriteFile(path, []byte(prefix), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	f.toolPath = filepath.Join(filesDir, prefix+"-tool-result.json")
+	if err := os.WriteFile(f.toolPath, []byte(`{"rows":[["`+prefix+`"]]}`), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", f.toolPath, err)
+	}
+
+	now := time.Now().UTC().Add(-time.Hour)
+	config := fmt.Sprintf(`{"host":"db.%s.example","username":"app","password":%q,"dsn":"postgres://app:%s@db/app","options":{"api_key":"%s-key"}}`,
+		prefix, f.passwordRaw, f.passwordRaw, prefix)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO clients (id, name, slug, ai_api_key) VALUES ($1, $2, $3, $4)", []interface{}{f.clientID, prefix, prefix, prefix + "-llm-key"}},
+		{"INSERT INTO domains (id, client_id, domain) VALUES ($1, $2, $3)", []interface{}{prefix + "-domain", f.clientID, prefix + ".example"}},
+		{"INSERT INTO users (id, client_id, username, password_hash, created_at) VALUES ($1, $2, 'alice', $3, $4)", []interface{}{prefix + "-u1", f.clientID, prefix + "-hash-1", now}},
+		{"INSERT INTO users (id, client_id, username, password_hash, created_at) VALUES ($1, $2, 'bob', $3, $4)", []interface{}{prefix + "-u2", f.clientID, prefix + "-hash-2", now}},
+		{"INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5)", []interface{}{prefix + "-session", f.clientID, prefix + "-u1", prefix + "-token", now.Add(48 * time.Hour)}},
+		{"INSERT INTO projects (id, user_id, name, created_at) VALUES ($1, $2, 'Main', $3)", []interface{}{prefix + "-p1", prefix + "-u1", now}},
+		{"INSERT INTO datasources (id, project_id, name, type, config) VALUES ($1, $2, 'Warehouse', 'postgres', $3)", []interface{}{prefix + "-ds1", prefix + "-p1", config}},
+		{"UPDATE projects SET default_datasource_id = $1 WHERE id = $2", []interface{}{prefix + "-ds1", prefix + "-p1"}},
+		{"INSERT INTO datasource_schema_snapshots (id, datasource_id, \"schema\") VALUES ($1, $2, '{}')", []interface{}{prefix + "-snapshot", prefix + "-ds1"}},
+		{"INSERT INTO project_tools (project_id, tool_name) VALUES ($1, 'database_query')", []interface{}{prefix + "-p1"}},
+		{"INSERT INTO project_files (id, project_id, user_id, filename, content_type, size_bytes) VALUES ($1, $2, $3, 'notes.txt', 'text/plain', 5)", []interface{}{f.fileID, prefix + "-p1", prefix + "-u1"}},
+		{"INSERT INTO api_allowlist (id, project_id, pattern) VALUES ($1, $2, 'https://api.example.com/*')", []interface{}{prefix + "-allow", prefix + "-p1"}},
+		{"INSERT INTO prompt_templates (id, project_id, name, content, created_by) VALUES ($1, $2, 'Greeting', 'Hello', $3)", []interface{}{prefix + "-template", prefix + "-p1", prefix + "-u1"}},
+		{"INSERT INTO api_keys (id, client_id, project_id, name, key_prefix, key_hash, created_by) VALUES ($1, $2, $3, 'CI', 'zk_', $4, $5)", []interface{}{prefix + "-apikey", f.clientID, prefix + "-p1", prefix + "-key-hash", prefix + "-u1"}},
+		{"INSERT INTO token_usage (id, client_id, user_id, api_key_id, project_id, model, total_tokens) VALUES ($1, $2, $3, $4, $5, 'gpt', 42)", []interface{}{prefix + "-usage", f.clientID, prefix + "-u1", prefix + "-apikey", prefix + "-p1"}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, created_at, updated_at) VALUES ($1, 'Revenue', $2, $3, $4, $4)", []interface{}{prefix + "-c1", prefix + "-u1", prefix + "-p1", now}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, created_at, updated_at) VALUES ($1, 'Churn', $2, $3, $4, $4)", []interface{}{prefix + "-c2", prefix + "-u2", prefix + "-p1", now}},
+		{"INSERT INTO conversation_participants (conversation_id, user_id, role) VALUES ($1, $2, 'owner')", []interface{}{prefix + "-c1", prefix + "-u1"}},
+		{"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2)", []interface{}{prefix + "-c1", prefix + "-u2"}},
+		{"INSERT INTO conversation_shares (id, conversation_id, token_hash, created_by) VALUES ($1, $2, $3, $4)", []interface{}{prefix + "-share", prefix + "-c1", prefix + "-share-token", prefix + "-u1"}},
+		{"INSERT INTO scheduled_prompts (id, project_id, name, cron_expression, prompt, conversation_id, created_by) VALUES ($1, $2, 'Daily', '0 9 * * *', 'Sales?', $3, $4)", []interface{}{prefix + "-schedule", prefix + "-p1", prefix + "-c1", prefix + "-u1"}},
+	}
+	for i := 1; i <= 5; i++ {
+		statements = append(statements, struct {
+			query string
+			args  []interface{}
+		}{"INSERT INTO messages (id, conversation_id, role, content, metadata, tool_calls, user_id, created_at) VALUES ($1, $2, $3, $4, '{\"model\":\"gpt\"}', '[]', $5, $6)",
+			[]interface{}{fmt.Sprintf("%s-m%d", prefix, i), prefix + "-c1", []string{"user", "assistant"}[i%2], fmt.Sprintf("%s message %d", prefix, i), prefix + "-u1", now.Add(time.Duration(i) * time.Second)}})
+	}
+	statements = append(statements, []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, 'user', 'hi', $3)", []interface{}{prefix + "-m6", prefix + "-c2", now}},
+		{"INSERT INTO message_embeddings (message_id, conversation_id, project_id, model, dimensions, embedding) VALUES ($1, $2, $3, 'embed', 2, '[0.1,0.2]')", []interface{}{prefix + "-m2", prefix + "-c1", prefix + "-p1"}},
+		{"INSERT INTO message_metrics (message_id, conversation_id, model, ttft_ms, total_ms, chunk_count, day) VALUES ($1, $2, 'gpt', 10, 20, 3, '2026-01-01')", []interface{}{prefix + "-m2", prefix + "-c1"}},
+		{"INSERT INTO tool_executions (id, message_id, conversation_id, tool_name, arguments, status, result_file_path, duration_ms) VALUES ('call-1', $1, $2, 'database_query', '{\"query\":\"SELECT 1\"}', 'completed', $3, 12)", []interface{}{prefix + "-m2", prefix + "-c1", f.toolPath}},
+		{"INSERT INTO message_feedback (message_id, conversation_id, user_id, rating) VALUES ($1, $2, $3, 1)", []interface{}{prefix + "-m2", prefix + "-c1", prefix + "-u1"}},
+		{"INSERT INTO conversation_summaries (conversation_id, last_message_id, summary) VALUES ($1, $2, 'Summary')", []interface{}{prefix + "-c1", prefix + "-m3"}},
+		{"INSERT INTO query_jobs (id, project_id, user_id, datasource_id, query, status, result_path, timeout_seconds) VALUES ($1, $2, $3, $4, 'SELECT 1', 'completed', $5, 60)", []interface{}{prefix + "-job", prefix + "-p1", prefix + "-u1", prefix + "-ds1", f.resultPath}},
+		{"INSERT INTO webhooks (id, client_id, url, secret) VALUES ($1, $2, 'https://hooks.example.com', 'secret')", []interface{}{prefix + "-webhook", f.clientID}},
+		{"INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, attempt) VALUES ($1, $2, 'event-1', 'conversation_created', 1)", []interface{}{prefix + "-delivery", prefix + "-webhook"}},
+		{"INSERT INTO notification_settings (client_id, email) VALUES ($1, $2)", []interface{}{f.clientID, prefix + "@example.com"}},
+		{"INSERT INTO notifications (id, client_id, event_type, recipient, subject, status) VALUES ($1, $2, 'token_budget_exceeded', $3, 'Budget', 'sent')", []interface{}{prefix + "-notification", f.clientID, prefix + "@example.com"}},
+		{"INSERT INTO content_filters (id, client_id, name, kind) VALUES ($1, $2, 'Emails', 'email')", []interface{}{prefix + "-filter", f.clientID}},
+		{"INSERT INTO tool_execution_audit (id, client_id, user_id, project_id, conversation_id, tool_call_id, tool_name, datasource_id, source, status, sql_text, started_at) VALUES ($1, $2, $3, $4, $5, 'call-1', 'database_query', $6, 'llm', 'completed', 'SELECT 1', $7)", []interface{}{prefix + "-tool-audit", f.clientID, prefix + "-u1", prefix + "-p1", prefix + "-c1", prefix + "-ds1", now}},
+		{"INSERT INTO audit_log (id, actor_id, client_id, action, details, ip) VALUES ($1, $2, $3, 'session.revoke', $4, '203.0.113.7')", []interface{}{prefix + "-audit", prefix + "-u1", f.clientID, `{"username":"alice"}`}},
+	}...)
+
+	ctx := context.Background()
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to seed %q: %v", stmt.query, err)
+		}
+	}
+	return f
+}
+
+// rowsMentioning counts the rows of every application table whose values
+// mention prefix, by table
+func rowsMentioning(t *testing.T, conn tools.DBConnection, prefix string) map[string]int {
+	t.Helper()
+
+	ctx := context.Background()
+	rows, err := conn.Query(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT IN ('schema_migrations', 'tenant_jobs')")
+	if err != nil {
+		t.Fatalf("Failed to list tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	counts := map[string]int{}
+	for _, table := range tables {
+		tableRows, err := conn.Query(ctx, "SELECT * FROM "+table)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", table, err)
+		}
+		columns, _ := tableRows.Columns()
+		for tableRows.Next() {
+			row, err := scanRow(tableRows, columns, nil)
+			if err != nil {
+				t.Fatalf("Failed to scan %s: %v", table, err)
+			}
+			if strings.Contains(fmt.Sprint(row), prefix) {
+				counts[table]++
+			}
+		}
+		tableRows.Close()
+	}
+	return counts
+}
+
+func waitForJob(t *testing.T, manager *Manager, id string) *Job {
+	t.Helper()
+	manager.Wait()
+	job, err := manager.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return job
+}
+
+func readArchive(t *testing.T, path string) map[string]json.RawMessage {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Archive is not gzip'd: %v", err)
+	}
+	var archive map[string]json.RawMessage
+	if err := json.NewDecoder(reader).Decode(&archive); err != nil {
+		t.Fatalf("Archive is not JSON: %v", err)
+	}
+	return archive
+}
+
+func TestExportArchivesEveryClientRow(t *testing.T) {
+	conn := setupTenantDB(t)
+	filesDir := t.TempDir()
+	acme := seedClient(t, conn, "acme", filesDir)
+	seedClient(t, conn, "globex", filesDir)
+	manager := newTestManager(t, conn, filesDir, nil)
+
+	started, err := manager.StartExport(context.Background(), acme.clientID, "root")
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	job := waitForJob(t, manager, started.ID)
+	if job.Status != StatusCompleted || job.ResultPath == "" {
+		t.Fatalf("Expected a completed export with an archive, got %+v", job)
+	}
+	want := map[string]int64{"users": 2, "projects": 1, "datasources": 1, "conversations": 2, "messages": 6}
+	for section, count := range want {
+		if job.Counts[section] != count {
+			t.Errorf("Expected %d %s, got %d", count, section, job.Counts[section])
+		}
+	}
+
+	archive := readArchive(t, job.ResultPath)
+	for _, section := range []string{"schema_version", "exported_at", "client", "users", "projects", "datasources", "conversations", "messages", "token_usage"} {
+		if _, exists := archive[section]; !exists {
+			t.Errorf("Expected the archive to hold %s", section)
+		}
+	}
+	var messages []map[string]interface{}
+	json.Unmarshal(archive["messages"], &messages)
+	if len(messages) != 6 || messages[0]["metadata"].(map[string]interface{})["model"] != "gpt" {
+		t.Errorf("Expected six messages with decoded metadata, got %v", messages)
+	}
+	var executions []map[string]interface{}
+	json.Unmarshal(archive["tool_executions"], &executions)
+	if len(executions) != 1 || executions[0]["result"] == nil || executions[0]["result_file_path"] != nil {
+		t.Errorf("Expected the tool execution with its result read from its file, got %v", executions)
+	}
+	var datasources []map[string]interface{}
+	json.Unmarshal(archive["datasources"], &datasources)
+	config, _ := datasources[0]["config"].(map[string]interface{})
+	if config["host"] != "db.acme.example" || config["password"] != Redacted || config["options"].(map[string]interface{})["api_key"] != Redacted {
+		t.Errorf("Expected the config with its secrets redacted, got %v", config)
+	}
+	var usage struct {
+		Tokens struct {
+			Total int64 `json:"total"`
+		} `json:"tokens"`
+	}
+	json.Unmarshal(archive["token_usage"], &usage)
+	if usage.Tokens.Total == 0 {
+		t.Errorf("Expected the client's token usage, got %s", archive["token_usage"])
+	}
+
+	raw, _ := json.Marshal(archive)
+	for _, secret := range []string{acme.passwordRaw, "acme-hash-1", "acme-llm-key", "password_hash", "globex"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("Expected the archive not to contain %q", secret)
+		}
+	}
+}
+
+func TestPurgeRemovesEveryClientRow(t *testing.T) {
+	conn := setupTenantDB(t)
+	filesDir := t.TempDir()
+	acme := seedClient(t, conn, "acme", filesDir)
+	globex := seedClient(t, conn, "globex", filesDir)
+	before := rowsMentioning(t, conn, "globex")
+
+	var purged *Job
+	manager := newTestManager(t, conn, filesDir, func(job *Job) { purged = job })
+	token, _ := manager.RequestPurge(acme.clientID, "root")
+	started, err := manager.StartPurge(context.Background(), acme.clientID, "root", token)
+	if err != nil {
+		t.Fatalf("StartPurge failed: %v", err)
+	}
+	job := waitForJob(t, manager, started.ID)
+	if job.Status != StatusCompleted || job.Step != len(purgeSteps) {
+		t.Fatalf("Expected a completed purge, got %+v", job)
+	}
+	if purged == nil || purged.ID != job.ID {
+		t.Error("Expected OnPurged to be called with the job")
+	}
+	if job.Counts["messages"] != 6 || job.Counts["users"] != 2 || job.Counts["clients"] != 1 {
+		t.Errorf("Expected the purged rows to be counted, got %v", job.Counts)
+	}
+
+	// Audit entries stay on record without the caller's address or details
+	remaining := rowsMentioning(t, conn, "acme")
+	if remaining["audit_log"] != 1 {
+		t.Errorf("Expected the audit entry to be kept, got %d", remaining["audit_log"])
+	}
+	delete(remaining, "audit_log")
+	if len(remaining) != 0 {
+		t.Errorf("Expected no rows of the purged client, got %v", remaining)
+	}
+	var ip, details interface{}
+	if err := conn.QueryRow(context.Background(), "SELECT ip, details FROM audit_log WHERE id = 'acme-audit'").Scan(&ip, &details); err != nil || ip != nil || details != nil {
+		t.Errorf("Expected the audit entry to be anonymized, got ip %v, details %v (%v)", ip, details, err)
+	}
+
+	for _, path := range []string{acme.filePath, acme.resultPath, acme.toolPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	for _, path := range []string{globex.filePath, globex.resultPath, globex.toolPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s of the other client to be kept: %v", path, err)
+		}
+	}
+	after := rowsMentioning(t, conn, "globex")
+	for table, count := range before {
+		if after[table] != count {
+			t.Errorf("Expected %d %s rows of the other client, got %d", count, table, after[table])
+		}
+	}
+}
+
+func TestPurgeConfirmation(t *testing.T) {
+	conn := setupTenantDB(t)
+	filesDir := t.TempDir()
+	acme := seedClient(t, conn, "acme", filesDir)
+	globex := seedClient(t, conn, "globex", filesDir)
+	manager := newTestManager(t, conn, filesDir, nil)
+	ctx := context.Background()
+
+	token, _ := manager.RequestPurge(acme.clientID, "root")
+	for name, attempt := range map[string]struct{ clientID, actorID, token string }{
+		"unknown token": {acme.clientID, "root", "not-a-token"},
+		"other client":  {globex.clientID, "root", token},
+		"other admin":   {acme.clientID, "someone", token},
+	} {
+		if _, err := manager.StartPurge(ctx, attempt.clientID, attempt.actorID, attempt.token); !errors.Is(err, ErrInvalidConfirmation) {
+			t.Errorf("%s: expected ErrInvalidConfirmation, got %v", name, err)
+		}
+	}
+
+	manager.now = func() time.Time { return time.Now().Add(ConfirmationTTL + time.Minute) }
+	if _, err := manager.StartPurge(ctx, acme.clientID, "root", token); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("Expected an expired token to be refused, got %v", err)
+	}
+	manager.now = time.Now
+
+	token, _ = manager.RequestPurge(acme.clientID, "root")
+	if _, err := manager.StartPurge(ctx, acme.clientID, "root", token); err != nil {
+		t.Fatalf("StartPurge failed: %v", err)
+	}
+	if _, err := manager.StartPurge(ctx, acme.clientID, "root", token); !errors.Is(err, ErrInvalidConfirmation) {
+		t.Errorf("Expected a used token to be refused, got %v", err)
+	}
+	manager.Wait()
+
+	if counts := rowsMentioning(t, conn, "acme"); counts["clients"] != 0 {
+		t.Errorf("Expected the client to be purged, got %v", counts)
+	}
+}
+
+func TestResumeContinuesInterruptedJobs(t *testing.T) {
+	conn := setupTenantDB(t)
+	filesDir := t.TempDir()
+	acme := seedClient(t, conn, "acme", filesDir)
+	globex := seedClient(t, conn, "globex", filesDir)
+	ctx := context.Background()
+
+	// A purge that stopped after deleting the sessions, and an export that never started
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO tenant_jobs (id, client_id, kind, status, step, counts, created_at) VALUES ('11111111-1111-4111-8111-111111111111', $1, 'purge', 'running', 1, '{\"sessions\":1}', $2)",
+		acme.clientID, time.Now().UTC()); err != nil {
+		t.Fatalf("Failed to insert purge job: %v", err)
+	}
+	if _, err := conn.Exec(ctx, "DELETE FROM sessions WHERE client_id = $1", acme.clientID); err != nil {
+		t.Fatalf("Failed to delete sessions: %v", err)
+	}
+	if _, err := conn.Exec(ctx,
+		"INSERT INTO tenant_jobs (id, client_id, kind, status, created_at) VALUES ('22222222-2222-4222-8222-222222222222', $1, 'export', 'pending', $2)",
+		globex.clientID, time.Now().UTC()); err != nil {
+		t.Fatalf("Failed to insert export job: %v", err)
+	}
+
+	manager := newTestManager(t, conn, filesDir, nil)
+	resumed, err := manager.Resume(ctx)
+	if err != nil || resumed != 2 {
+		t.Fatalf("Expected two jobs to resume, got %d (%v)", resumed, err)
+	}
+
+	purge := waitForJob(t, manager, "11111111-1111-4111-8111-111111111111")
+	if purge.Status != StatusCompleted || purge.Counts["sessions"] != 1 || purge.Counts["users"] != 2 {
+		t.Errorf("Expected the purge to finish from its last step, got %+v", purge)
+	}
+	if remaining := rowsMentioning(t, conn, "acme"); len(remaining) != 1 {
+		t.Errorf("Expected only the audit entry of the purged client, got %v", remaining)
+	}
+
+	export := waitForJob(t, manager, "22222222-2222-4222-8222-222222222222")
+	if export.Status != StatusCompleted || export.Counts["messages"] != 6 {
+		t.Fatalf("Expected the export to be built, got %+v", export)
+	}
+	if archive := readArchive(t, export.ResultPath); archive["client"] == nil {
+		t.Error("Expected the resumed export to hold the client")
+	}
+
+	if resumed, err := manager.Resume(ctx); err != nil || resumed != 0 {
+		t.Errorf("Expected nothing left to resume, got %d (%v)", resumed, err)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	var config interface{}
+	json.Unmarshal([]byte(`{"host":"db","port":5432,"password":"pw","auth":{"client_secret":"s","token":""},"url":"mysql://app:pw@db:3306/app","hosts":["postgres://u:p@a/b"]}`), &config)
+
+	redacted, _ := json.Marshal(redactSecrets(config))
+	want := `{"auth":{"client_secret":"[REDACTED]","token":""},"host":"db","hosts":["postgres://u:xxxxx@a/b"],"password":"[REDACTED]","port":5432,"url":"mysql://app:xxxxx@db:3306/app"}`
+	if string(redacted) != want {
+		t.Errorf("Expected %s, got %s", want, redacted)
+	}
+}
+
+// persistedPurgeSteps is the order of purgeSteps jobs have saved progress
+// against; steps may only be added at the end
+var persistedPurgeSteps = []string{
+	"sessions", "message_embeddings", "message_metrics", "message_feedback", "conversation_summaries",
+	"conversation_shares", "conversation_participants", "messages", "conversations", "query_jobs",
+	"datasource_schema_snapshots", "projects", "datasources", "project_tools", "project_files", "api_allowlist",
+	"prompt_templates", "api_keys", "projects", "webhook_deliveries", "webhooks", "notifications",
+	"notification_settings", "domains", "users", "audit_log", "clients",
+	"content_filters", "tool_executions", "project_events", "project_retention_runs", "scheduled_prompts",
+	"token_usage", "tool_execution_audit",
+}
+
+func TestPurgeStepsAreOnlyAppended(t *testing.T) {
+	if len(purgeSteps) < len(persistedPurgeSteps) {
+		t.Fatalf("Expected at least %d purge steps, got %d", len(persistedPurgeSteps), len(purgeSteps))
+	}
+	for i, table := range persistedPurgeSteps {
+		if purgeSteps[i].table != table {
+			t.Errorf("Expected step %d to purge %s, got %s", i, table, purgeSteps[i].table)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package toolaudit
+
+import "strings"
+
+// redactedLiteral replaces every string literal of a redacted query
+const redactedLiteral = "'?'"
+
+// RedactSQLLiterals replaces the string literals of a query, single-quoted or
+// PostgreSQL dollar-quoted, with '?'. Quoted identifiers, numbers and comments
+// are kept; quotes inside comments do not start a literal. A backslash escapes
+// the next character, as in MySQL, so a PostgreSQL literal ending in one hides
+// the rest of the query rather than leaking it. An unterminated literal is
+// redacted to the end of the query.
+func RedactSQLLiterals(query string) string {
+	var out strings.Builder
+	out.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		switch {
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case query[i] == '"' || query[i] == '`':
+			end := strings.IndexByte(query[i+1:], query[i])
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 2
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case query[i] == '\'':
+			out.WriteString(redactedLiteral)
+			i = quotedLiteralEnd(query, i+1)
+		case query[i] == '$' && (i == 0 || !identifierByte(query[i-1])):
+			if tag, ok := dollarQuoteTag(query[i:]); ok {
+				out.WriteString(redactedLiteral)
+				end := strings.Index(query[i+len(tag):], tag)
+				if end < 0 {
+					i = len(query)
+				} else {
+					i += 2*len(tag) + end
+				}
+				continue
+			}
+			out.WriteByte(query[i])
+			i++
+		default:
+			out.WriteByte(query[i])
+			i++
+		}
+	}
+	return out.String()
+}
+
+// quotedLiteralEnd returns the index just past the single-quoted literal whose
+// content starts at start. A doubled quote or a backslash-escaped one does not
+// end it.
+func quotedLiteralEnd(query string, start int) int {
+	for i := start; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(query) && query[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarQuoteTag returns the opening $tag$ of a dollar-quoted literal at the
+// start of text. Positional parameters such as $1 are not one.
+func dollarQuoteTag(text string) (string, bool) {
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '$':
+			return text[:i+1], true
+		case !identifierByte(c) || i == 1 && c >= '0' && c <= '9':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// identifierByte reports whether c may be part of an unquoted identifier, in
+// which a $ does not start a dollar quote
+func identifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
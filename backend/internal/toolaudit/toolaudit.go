@@ -0,0 +1,269 @@
+// Package toolaudit keeps the audit log of tool executions: who ran which tool,
+// against which datasource and with which SQL, for security review.
+package toolaudit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// DefaultPageSize is how many executions a page holds when no limit is asked for
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page of executions that may be asked for
+	MaxPageSize = 200
+	// DefaultMaxSQLChars is how many characters of a query are kept
+	DefaultMaxSQLChars = 4000
+)
+
+// ErrInvalidCursor is returned when a cursor is not an execution the listing may show
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Execution is a recorded tool execution
+type Execution struct {
+	ID             string    `json:"id"`
+	ClientID       string    `json:"client_id"`
+	UserID         string    `json:"user_id,omitempty"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	ToolCallID     string    `json:"tool_call_id,omitempty"`
+	ToolName       string    `json:"tool_name"`
+	DatasourceID   string    `json:"datasource_id,omitempty"`
+	Source         string    `json:"source"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	SQL            string    `json:"sql,omitempty"`
+	SQLTruncated   bool      `json:"sql_truncated"`
+	DurationMs     int64     `json:"duration_ms"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// Auditor saves the executions of a tool registry. It implements tools.ExecutionAuditor.
+type Auditor struct {
+	db          tools.DBConnection
+	maxSQLChars int
+}
+
+// NewAuditor creates an auditor keeping at most maxSQLChars characters of each
+// query; 0 uses DefaultMaxSQLChars
+func NewAuditor(db tools.DBConnection, maxSQLChars int) *Auditor {
+	if maxSQLChars <= 0 {
+		maxSQLChars = DefaultMaxSQLChars
+	}
+	return &Auditor{db: db, maxSQLChars: maxSQLChars}
+}
+
+// RecordExecution saves an execution. Without a client in the record it is
+// taken from the user. The query is stripped of string literals when the
+// client asks for it, then truncated.
+func (a *Auditor) RecordExecution(ctx context.Context, record tools.ExecutionRecord) error {
+	clientID := record.ClientID
+	if clientID == "" && record.UserID != "" {
+		if err := a.db.QueryRow(ctx, "SELECT client_id FROM users WHERE id = $1", record.UserID).Scan(&clientID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to look up the client of user %s: %w", record.UserID, err)
+		}
+	}
+	if clientID == "" {
+		return fmt.Errorf("no client to record the execution of tool %s for", record.ToolName)
+	}
+
+	query, truncated := record.SQL, false
+	if query != "" {
+		var redact bool
+		err := a.db.QueryRow(ctx, "SELECT redact_sql_literals FROM clients WHERE id = $1", clientID).Scan(&redact)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to load the SQL privacy setting of client %s: %w", clientID, err)
+		}
+		if redact {
+			query = RedactSQLLiterals(query)
+		}
+		query, truncated = truncate(query, a.maxSQLChars)
+	}
+
+	source := record.Source
+	if source == "" {
+		source = tools.SourceLLM
+	}
+	_, err := a.db.Exec(ctx,
+		`INSERT INTO tool_execution_audit (id, client_id, user_id, project_id, conversation_id, tool_call_id, tool_name,
+			datasource_id, source, status, error, sql_text, sql_truncated, duration_ms, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		uuid.New().String(), clientID, nullable(record.UserID), nullable(record.ProjectID), nullable(record.ConversationID),
+		nullable(record.ToolCallID), record.ToolName, datasourceID(record.DatasourceID), source, record.Status,
+		nullable(record.Error), nullable(query), truncated, record.Duration.Milliseconds(), record.StartedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save tool execution: %w", err)
+	}
+	return nil
+}
+
+// Filter selects executions; empty fields match every execution
+type Filter struct {
+	ClientID     string
+	UserID       string
+	ToolName     string
+	DatasourceID string
+	Status       string
+	From         time.Time // Inclusive
+	To           time.Time // Exclusive
+}
+
+// ListOptions selects a page of executions, newest first
+type ListOptions struct {
+	Filter
+	Cursor string // NextCursor of the previous page; empty starts with the newest execution
+	Limit  int    // 0 uses DefaultPageSize
+}
+
+// Size returns the number of executions the page holds at most
+func (o ListOptions) Size() int {
+	switch {
+	case o.Limit <= 0:
+		return DefaultPageSize
+	case o.Limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return o.Limit
+	}
+}
+
+// Page is one page of executions. NextCursor is set when older executions remain.
+type Page struct {
+	Executions []Execution `json:"executions"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasMore    bool        `json:"has_more"`
+}
+
+// List returns a page of executions, newest first. Executions sharing a
+// started_at are ordered by id, so a page boundary between them neither skips
+// nor repeats any. A cursor must be an execution of the filter's client.
+func List(ctx context.Context, db tools.DBConnection, options ListOptions) (*Page, error) {
+	if options.Cursor != "" {
+		lookup := "SELECT COUNT(*) FROM tool_execution_audit WHERE id = $1"
+		args := []interface{}{options.Cursor}
+		if options.ClientID != "" {
+			lookup += " AND client_id = $2"
+			args = append(args, options.ClientID)
+		}
+		var found int
+		if err := db.QueryRow(ctx, lookup, args...).Scan(&found); err != nil {
+			return nil, fmt.Errorf("failed to look up cursor: %w", err)
+		}
+		if found == 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	query, args := buildListQuery(options)
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tool executions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &Page{Executions: []Execution{}}
+	for rows.Next() {
+		var execution Execution
+		var userID, projectID, conversationID, toolCallID, datasourceID, errorText, sqlText sql.NullString
+		if err := rows.Scan(&execution.ID, &execution.ClientID, &userID, &projectID, &conversationID, &toolCallID,
+			&execution.ToolName, &datasourceID, &execution.Source, &execution.Status, &errorText, &sqlText,
+			&execution.SQLTruncated, &execution.DurationMs, &execution.StartedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tool execution: %w", err)
+		}
+		execution.UserID = userID.String
+		execution.ProjectID = projectID.String
+		execution.ConversationID = conversationID.String
+		execution.ToolCallID = toolCallID.String
+		execution.DatasourceID = datasourceID.String
+		execution.Error = errorText.String
+		execution.SQL = sqlText.String
+		page.Executions = append(page.Executions, execution)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tool executions: %w", err)
+	}
+
+	if len(page.Executions) > options.Size() {
+		page.Executions = page.Executions[:options.Size()]
+		page.HasMore = true
+		page.NextCursor = page.Executions[len(page.Executions)-1].ID
+	}
+	return page, nil
+}
+
+// buildListQuery returns the query selecting one more execution than the page
+// holds, so List can tell whether more remain
+func buildListQuery(options ListOptions) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	for _, equal := range []struct{ column, value string }{
+		{"client_id", options.ClientID},
+		{"user_id", options.UserID},
+		{"tool_name", options.ToolName},
+		{"datasource_id", options.DatasourceID},
+		{"status", options.Status},
+	} {
+		if equal.value != "" {
+			add(equal.column+" = ?", equal.value)
+		}
+	}
+	if !options.From.IsZero() {
+		add("started_at >= ?", options.From.UTC())
+	}
+	if !options.To.IsZero() {
+		add("started_at < ?", options.To.UTC())
+	}
+	if options.Cursor != "" {
+		add(`(started_at < (SELECT b.started_at FROM tool_execution_audit b WHERE b.id = ?)
+			OR (started_at = (SELECT b.started_at FROM tool_execution_audit b WHERE b.id = ?) AND id < ?))`, options.Cursor)
+	}
+
+	query := `SELECT id, client_id, user_id, project_id, conversation_id, tool_call_id, tool_name, datasource_id,
+		source, status, error, sql_text, sql_truncated, duration_ms, started_at
+		FROM tool_execution_audit`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, options.Size()+1)
+	query += fmt.Sprintf(" ORDER BY started_at DESC, id DESC LIMIT $%d", len(args))
+	return query, args
+}
+
+// truncate keeps the first max characters of text, reporting whether any were cut
+func truncate(text string, max int) (string, bool) {
+	if utf8.RuneCountInString(text) <= max {
+		return text, false
+	}
+	runes := []rune(text)
+	return string(runes[:max]), true
+}
+
+// datasourceID stores a datasource reference that is not an id, such as the
+// name of one that could not be resolved, as NULL
+func datasourceID(ref string) interface{} {
+	if _, err := uuid.Parse(ref); err != nil {
+		return nil
+	}
+	return ref
+}
+
+// nullable stores empty strings as NULL
+func nullable(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,213 @@
+package toolaudit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func TestRedactSQLLiterals(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"plain", "SELECT * FROM users WHERE email = 'a@b.example' AND id = 7", "SELECT * FROM users WHERE email = '?' AND id = 7"},
+		{"doubled quote", "SELECT 'O''Brien', 'x'", "SELECT '?', '?'"},
+		{"backslash escape", `SELECT 'it\'s' FROM t`, "SELECT '?' FROM t"},
+		{"dollar quoted", "SELECT $$secret$$, $tag$a 'b' $$ c$tag$ FROM t", "SELECT '?', '?' FROM t"},
+		{"parameters kept", "SELECT * FROM t WHERE id = $1 AND name = $2", "SELECT * FROM t WHERE id = $1 AND name = $2"},
+		{"dollar in identifier", "SELECT a$b$ FROM t", "SELECT a$b$ FROM t"},
+		{"quoted identifiers kept", "SELECT \"it's\", `col` FROM t WHERE x = 'y'", "SELECT \"it's\", `col` FROM t WHERE x = '?'"},
+		{"line comment", "SELECT 1 -- don't\nWHERE a = 'b'", "SELECT 1 -- don't\nWHERE a = '?'"},
+		{"block comment", "SELECT /* it's */ 'v'", "SELECT /* it's */ '?'"},
+		{"unterminated", "SELECT 'abc FROM t", "SELECT '?'"},
+		{"unterminated dollar", "SELECT $q$abc", "SELECT '?'"},
+		{"escaped prefix", "SELECT E'\\n' || 'x'", "SELECT E'?' || '?'"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := RedactSQLLiterals(tc.query); got != tc.want {
+				t.Errorf("RedactSQLLiterals(%q) = %q, want %q", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildListQuery(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+
+	query, args := buildListQuery(ListOptions{})
+	if strings.Contains(query, "WHERE") || !strings.HasSuffix(query, "ORDER BY started_at DESC, id DESC LIMIT $1") {
+		t.Errorf("Expected an unfiltered query, got %s", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{DefaultPageSize + 1}) {
+		t.Errorf("Expected only the limit as argument, got %v", args)
+	}
+
+	query, args = buildListQuery(ListOptions{
+		Filter: Filter{ClientID: "client-1", ToolName: "database_query", Status: "failed", From: from, To: to},
+		Cursor: "exec-9",
+		Limit:  MaxPageSize + 50,
+	})
+	for _, condition := range []string{
+		"client_id = $1", "tool_name = $2", "status = $3", "started_at >= $4", "started_at < $5",
+		"b.id = $6)", "AND id < $6))", "LIMIT $7",
+	} {
+		if !strings.Contains(query, condition) {
+			t.Errorf("Expected %q in %s", condition, query)
+		}
+	}
+	for _, unused := range []string{"user_id =", "datasource_id ="} {
+		if strings.Contains(query, unused) {
+			t.Errorf("Expected no %q condition without that filter, got %s", unused, query)
+		}
+	}
+	want := []interface{}{"client-1", "database_query", "failed", from, to, "exec-9", MaxPageSize + 1}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Expected arguments %v, got %v", want, args)
+	}
+}
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb,
+		"INSERT INTO clients (id, name, slug, redact_sql_literals) VALUES ('client-plain', 'client-plain', 'client-plain', false)",
+		"INSERT INTO clients (id, name, slug, redact_sql_literals) VALUES ('client-private', 'client-private', 'client-private', true)",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-private', 'client-private', 'private', 'x')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+func TestRecordExecutionRedactsAndTruncatesSQL(t *testing.T) {
+	conn := newTestDB(t)
+	auditor := NewAuditor(conn, 30)
+	ctx := context.Background()
+	startedAt := time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+	query := "SELECT * FROM customers WHERE email = 'jane@example.com'"
+
+	records := []tools.ExecutionRecord{
+		{ExecutionContext: tools.ExecutionContext{UserID: "user-plain", ClientID: "client-plain", Source: tools.SourceUser}, ToolName: "database_query", SQL: query, Status: "completed", StartedAt: startedAt},
+		// The client is taken from the user when the caller did not give one
+		{ExecutionContext: tools.ExecutionContext{UserID: "user-private"}, ToolName: "database_query", SQL: query,
+			DatasourceID: "sales", Status: "failed", Error: "boom", StartedAt: startedAt.Add(time.Second), Duration: 1500 * time.Millisecond},
+	}
+	for _, record := range records {
+		if err := auditor.RecordExecution(ctx, record); err != nil {
+			t.Fatalf("Failed to record execution: %v", err)
+		}
+	}
+
+	page, err := List(ctx, conn, ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list executions: %v", err)
+	}
+	if len(page.Executions) != 2 {
+		t.Fatalf("Expected 2 executions, got %+v", page.Executions)
+	}
+	private, plain := page.Executions[0], page.Executions[1]
+	if plain.SQL != query[:30] || !plain.SQLTruncated || plain.Source != tools.SourceUser {
+		t.Errorf("Expected the plain client's query truncated to 30 characters, got %+v", plain)
+	}
+	if private.ClientID != "client-private" || private.SQL != "SELECT * FROM customers WHERE " || !private.SQLTruncated {
+		t.Errorf("Expected the private client's query redacted then truncated, got %+v", private)
+	}
+	if private.Source != tools.SourceLLM || private.DatasourceID != "" || private.DurationMs != 1500 || private.Error != "boom" {
+		t.Errorf("Expected the default source, no datasource name and the duration kept, got %+v", private)
+	}
+
+	short := NewAuditor(conn, 0)
+	if err := short.RecordExecution(ctx, tools.ExecutionRecord{ExecutionContext: tools.ExecutionContext{ClientID: "client-private"},
+		ToolName: "database_query", SQL: query, Status: "completed", StartedAt: startedAt.Add(2 * time.Second)}); err != nil {
+		t.Fatalf("Failed to record execution: %v", err)
+	}
+	page, err = List(ctx, conn, ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed to list executions: %v", err)
+	}
+	if got := page.Executions[0]; got.SQL != "SELECT * FROM customers WHERE email = '?'" || got.SQLTruncated {
+		t.Errorf("Expected the query redacted in full, got %+v", got)
+	}
+
+	if err := auditor.RecordExecution(ctx, tools.ExecutionRecord{ToolName: "web_search", Status: "completed", StartedAt: startedAt}); err == nil {
+		t.Error("Expected an execution without a client to be refused")
+	}
+}
+
+func TestListPagesThroughFilteredExecutions(t *testing.T) {
+	conn := newTestDB(t)
+	auditor := NewAuditor(conn, 0)
+	ctx := context.Background()
+	at := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+
+	for i := 0; i < 5; i++ {
+		for _, clientID := range []string{"client-plain", "client-private"} {
+			record := tools.ExecutionRecord{
+				ExecutionContext: tools.ExecutionContext{ClientID: clientID, UserID: "user-" + clientID},
+				ToolName:         "database_query",
+				Status:           "completed",
+				// Pairs share a start time so pages split between executions of the same second
+				StartedAt: at.Add(time.Duration(i/2) * time.Second),
+			}
+			if err := auditor.RecordExecution(ctx, record); err != nil {
+				t.Fatalf("Failed to record execution: %v", err)
+			}
+		}
+	}
+
+	options := ListOptions{Filter: Filter{ClientID: "client-plain"}, Limit: 2}
+	seen := map[string]bool{}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Expected paging to end")
+		}
+		page, err := List(ctx, conn, options)
+		if err != nil {
+			t.Fatalf("Failed to list executions: %v", err)
+		}
+		for _, execution := range page.Executions {
+			if execution.ClientID != "client-plain" {
+				t.Errorf("Expected only the filtered client, got %+v", execution)
+			}
+			if seen[execution.ID] {
+				t.Errorf("Execution %s listed twice", execution.ID)
+			}
+			seen[execution.ID] = true
+		}
+		if !page.HasMore {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Errorf("Expected 5 executions across pages, got %d", len(seen))
+	}
+
+	page, err := List(ctx, conn, ListOptions{Filter: Filter{ClientID: "client-plain", From: at.Add(2 * time.Second)}})
+	if err != nil {
+		t.Fatalf("Failed to list executions: %v", err)
+	}
+	if len(page.Executions) != 1 {
+		t.Errorf("Expected the executions from the last second, got %d", len(page.Executions))
+	}
+
+	// A cursor of another client is not accepted
+	other, err := List(ctx, conn, ListOptions{Filter: Filter{ClientID: "client-private"}, Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed to list executions: %v", err)
+	}
+	_, err = List(ctx, conn, ListOptions{Filter: Filter{ClientID: "client-plain"}, Cursor: other.NextCursor})
+	if err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err = List(ctx, conn, ListOptions{Cursor: "missing"}); err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor for an unknown cursor, got %v", err)
+	}
+}
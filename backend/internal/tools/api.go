@@ -5,22 +5,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"zlay-backend/internal/db"
 )
 
-// APITool executes HTTP requests to REST/GraphQL endpoints
+const (
+	defaultAPITimeoutSeconds = 30
+	maxAPITimeoutSeconds     = 120
+	defaultMaxResponseBytes  = 1024 * 1024
+	maxAPIRedirects          = 5
+)
+
+// APITool executes HTTP requests to REST/GraphQL endpoints.
+// Requests are only sent to URLs matching the project's allowlist, and
+// private/loopback/link-local addresses are refused unless a rule allows them.
 type APITool struct {
-	zdb *db.Database
+	zdb              *db.Database
+	permissions      PermissionChecker
+	allowlist        AllowlistStore
+	resolver         HostResolver
+	maxResponseBytes int64
 }
 
 // NewAPITool creates a new API tool
-func NewAPITool(zdb *db.Database) *APITool {
+func NewAPITool(zdb *db.Database, permissions PermissionChecker, allowlist AllowlistStore) *APITool {
 	return &APITool{
-		zdb: zdb,
+		zdb:              zdb,
+		permissions:      permissions,
+		allowlist:        allowlist,
+		resolver:         net.DefaultResolver,
+		maxResponseBytes: defaultMaxResponseBytes,
 	}
 }
 
@@ -31,7 +50,7 @@ func (t *APITool) Name() string {
 
 // Description returns tool description
 func (t *APITool) Description() string {
-	return "Execute HTTP requests to REST/REST API endpoints. Supports GET, POST, PUT, DELETE methods with custom headers and authentication."
+	return "Execute HTTP requests to REST/REST API endpoints. Supports GET, POST, PUT, DELETE methods with custom headers and authentication. Only URLs on the project's API allowlist can be called."
 }
 
 // Parameters returns tool parameters
@@ -44,8 +63,9 @@ func (t *APITool) Parameters() map[string]ToolParameter {
 		},
 		"method": {
 			Type:        "string",
-			Description: "HTTP method: GET, POST, PUT, DELETE, PATCH",
+			Description: "HTTP method",
 			Required:    true,
+			Enum:        []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
 		},
 		"url": {
 			Type:        "string",
@@ -64,18 +84,28 @@ func (t *APITool) Parameters() map[string]ToolParameter {
 		},
 		"timeout_seconds": {
 			Type:        "number",
-			Description: "Request timeout in seconds (default: 30)",
+			Description: fmt.Sprintf("Request timeout in seconds (default: %d, max: %d)", defaultAPITimeoutSeconds, maxAPITimeoutSeconds),
 			Required:    false,
-			Default:     30,
+			Default:     defaultAPITimeoutSeconds,
 		},
 	}
 }
 
 // ValidateAccess checks if user has access to this tool
 func (t *APITool) ValidateAccess(userID, projectID string) bool {
-	// For now, allow all authenticated users
-	// TODO: Implement project-based access control
-	return true
+	// Requests can have side effects on external systems, so require editor or above
+	return hasProjectRole(t.permissions, userID, projectID, RoleEditor)
+}
+
+// HasSideEffects reports whether the request uses a method other than GET, HEAD or OPTIONS
+func (t *APITool) HasSideEffects(params map[string]interface{}) bool {
+	method, _ := params["method"].(string)
+	switch strings.ToUpper(strings.TrimSpace(method)) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
 }
 
 // GetCategory returns the tool category
@@ -93,17 +123,20 @@ func (t *APITool) Execute(ctx context.Context, params map[string]interface{}) (*
 	if !ok {
 		return NewToolError("Missing required parameter: method", nil), nil
 	}
-	url, ok := params["url"].(string)
+	rawURL, ok := params["url"].(string)
 	if !ok {
 		return NewToolError("Missing required parameter: url", nil), nil
 	}
 
-	timeoutSecs := 30
+	timeoutSecs := defaultAPITimeoutSeconds
 	if timeout, hasTimeout := params["timeout_seconds"]; hasTimeout {
-		if ts, ok := timeout.(float64); ok {
+		if ts, ok := timeout.(float64); ok && ts > 0 {
 			timeoutSecs = int(ts)
 		}
 	}
+	if timeoutSecs > maxAPITimeoutSeconds {
+		timeoutSecs = maxAPITimeoutSeconds
+	}
 
 	// Create context with timeout
 	reqCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSecs)*time.Second)
@@ -116,9 +149,23 @@ func (t *APITool) Execute(ctx context.Context, params map[string]interface{}) (*
 	}
 
 	// Prepare full URL
-	fullURL := url
-	if baseURL != "" && !strings.HasPrefix(url, "http") {
-		fullURL = strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(url, "/")
+	fullURL := rawURL
+	if baseURL != "" && !strings.HasPrefix(rawURL, "http") {
+		fullURL = strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(rawURL, "/")
+	}
+
+	parsedURL, err := url.Parse(fullURL)
+	if err != nil {
+		return NewToolError("Invalid URL", err), nil
+	}
+
+	// Check the target against the project's allowlist before sending anything
+	guard, err := t.newRequestGuard(reqCtx)
+	if err != nil {
+		return NewToolError("Failed to load API allowlist", err), nil
+	}
+	if _, err := guard.checkURL(parsedURL); err != nil {
+		return urlNotAllowedResult(err), nil
 	}
 
 	// Prepare headers
@@ -159,19 +206,38 @@ func (t *APITool) Execute(ctx context.Context, params map[string]interface{}) (*
 		req.Header.Set(k, v)
 	}
 
-	// Execute request
-	client := &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second}
+	// Execute request; the dialer and redirect policy re-check every connection and hop
+	client := &http.Client{
+		Timeout: time.Duration(timeoutSecs) * time.Second,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           guard.dialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Duration(timeoutSecs) * time.Second,
+		},
+		CheckRedirect: guard.checkRedirect,
+	}
 	resp, err := client.Do(req)
 	if err != nil {
+		if _, denied := asURLNotAllowed(err); denied {
+			return urlNotAllowedResult(err), nil
+		}
+		if reqCtx.Err() == context.DeadlineExceeded {
+			return NewToolError(fmt.Sprintf("Request timed out after %d seconds", timeoutSecs), nil), nil
+		}
 		return NewToolError("Request failed", err), nil
 	}
 	defer resp.Body.Close()
 
-	// Read response body
-	respBody, err := io.ReadAll(resp.Body)
+	// Read response body, capped at maxResponseBytes
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, t.maxResponseBytes+1))
 	if err != nil {
 		return NewToolError("Failed to read response", err), nil
 	}
+	truncated := int64(len(respBody)) > t.maxResponseBytes
+	if truncated {
+		respBody = respBody[:t.maxResponseBytes]
+	}
 
 	// Prepare response data
 	responseData := map[string]interface{}{
@@ -180,30 +246,60 @@ func (t *APITool) Execute(ctx context.Context, params map[string]interface{}) (*
 		"headers":     t.getResponseHeaders(resp),
 		"body":        string(respBody),
 		"url":         fullURL,
+		"final_url":   resp.Request.URL.String(),
 		"method":      method,
+		"truncated":   truncated,
 	}
 
 	// Try to parse JSON response
 	var jsonBody interface{}
-	if err := json.Unmarshal(respBody, &jsonBody); err == nil {
-		responseData["json"] = jsonBody
+	if !truncated {
+		if err := json.Unmarshal(respBody, &jsonBody); err == nil {
+			responseData["json"] = jsonBody
+		}
 	}
 
 	return NewToolSuccess(responseData, int(time.Since(startTime).Milliseconds())), nil
 }
 
+// newRequestGuard loads the allowlist for the project in the execution context
+func (t *APITool) newRequestGuard(ctx context.Context) (*requestGuard, error) {
+	var rules []AllowlistRule
+	execCtx, _ := ExecutionContextFrom(ctx)
+	if t.allowlist != nil && execCtx.ProjectID != "" {
+		loaded, err := t.allowlist.GetAllowlist(ctx, execCtx.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		rules = loaded
+	}
+	return newRequestGuard(rules, t.resolver), nil
+}
+
+// urlNotAllowedResult converts an allowlist denial into a URL_NOT_ALLOWED tool error
+func urlNotAllowedResult(err error) *ToolResult {
+	notAllowed, ok := asURLNotAllowed(err)
+	if !ok {
+		return NewToolError("Request failed", err)
+	}
+	return NewToolErrorWithCode("URL_NOT_ALLOWED", notAllowed.Error(), map[string]interface{}{
+		"url":  notAllowed.URL,
+		"rule": notAllowed.Rule,
+	})
+}
+
 func (t *APITool) getDatasourceConfig(ctx context.Context, datasourceID string) (string, map[string]string, error) {
 	// If no datasource ID, return empty config (user must provide full URL)
 	if datasourceID == "" {
 		return "", nil, nil
 	}
 
-	// Get datasource details from database with project validation
+	// Get datasource details from database, only of the calling project
 	row, err := t.zdb.QueryRow(ctx,
 		`SELECT d.config FROM datasources d 
 		 JOIN projects p ON d.project_id = p.id 
-		 WHERE d.id = $1 AND d.is_active = true AND p.is_active = true`,
-		datasourceID)
+		 WHERE d.id = $1 AND d.project_id = $2 AND d.is_active = true AND p.is_active = true`,
+		datasourceID, executionProjectID(ctx))
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to fetch datasource: %w", err)
 	}
@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AllowlistRule is a URL pattern that api_request may call for a project.
+// Patterns look like "https://api.example.com/v1/*" or "*://*.example.com/*";
+// the host part supports path.Match globs and the path part treats * as "anything".
+type AllowlistRule struct {
+	ID           string `json:"id,omitempty"`
+	Pattern      string `json:"pattern"`
+	AllowPrivate bool   `json:"allow_private"` // permit private/loopback/link-local targets
+}
+
+// AllowlistStore loads the API allowlist for a project
+type AllowlistStore interface {
+	GetAllowlist(ctx context.Context, projectID string) ([]AllowlistRule, error)
+}
+
+// DBAllowlistStore implements AllowlistStore on top of the api_allowlist table
+type DBAllowlistStore struct {
+	db DBConnection
+}
+
+// NewDBAllowlistStore creates a new database-backed allowlist store
+func NewDBAllowlistStore(db DBConnection) *DBAllowlistStore {
+	return &DBAllowlistStore{db: db}
+}
+
+// GetAllowlist returns the allowlist rules for a project
+func (s *DBAllowlistStore) GetAllowlist(ctx context.Context, projectID string) ([]AllowlistRule, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT id, pattern, allow_private FROM api_allowlist WHERE project_id = $1 ORDER BY created_at",
+		projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api allowlist: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []AllowlistRule
+	for rows.Next() {
+		var rule AllowlistRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.AllowPrivate); err != nil {
+			return nil, fmt.Errorf("failed to scan api allowlist rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// HostResolver resolves host names to IP addresses (net.Resolver satisfies it)
+type HostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// URLNotAllowedError is returned when a URL fails the allowlist or address checks
+type URLNotAllowedError struct {
+	URL  string
+	Rule string // the rule that matched or the reason the request was denied
+}
+
+func (e *URLNotAllowedError) Error() string {
+	return fmt.Sprintf("URL not allowed: %s (%s)", e.URL, e.Rule)
+}
+
+// ValidateAllowlistPattern checks that a pattern can be used as an allowlist rule
+func ValidateAllowlistPattern(pattern string) error {
+	scheme, host, _, err := splitAllowlistPattern(pattern)
+	if err != nil {
+		return err
+	}
+	if scheme != "*" && scheme != "http" && scheme != "https" {
+		return fmt.Errorf("unsupported scheme in pattern: %s", scheme)
+	}
+	if _, err := path.Match(host, ""); err != nil {
+		return fmt.Errorf("invalid host pattern: %w", err)
+	}
+	return nil
+}
+
+// splitAllowlistPattern splits a pattern into scheme, host and path parts
+func splitAllowlistPattern(pattern string) (string, string, string, error) {
+	pattern = strings.TrimSpace(pattern)
+	scheme := "*"
+	if idx := strings.Index(pattern, "://"); idx != -1 {
+		scheme = strings.ToLower(pattern[:idx])
+		pattern = pattern[idx+3:]
+	}
+
+	host, pathPattern := pattern, "/*"
+	if idx := strings.Index(pattern, "/"); idx != -1 {
+		host, pathPattern = pattern[:idx], pattern[idx:]
+	}
+	if host == "" {
+		return "", "", "", fmt.Errorf("pattern must include a host")
+	}
+
+	return scheme, strings.ToLower(host), pathPattern, nil
+}
+
+// matchAllowlistRule reports whether a URL matches an allowlist pattern
+func matchAllowlistRule(pattern string, u *url.URL) bool {
+	scheme, host, pathPattern, err := splitAllowlistPattern(pattern)
+	if err != nil {
+		return false
+	}
+
+	if scheme != "*" && scheme != strings.ToLower(u.Scheme) {
+		return false
+	}
+	if ok, err := path.Match(host, strings.ToLower(u.Host)); err != nil || !ok {
+		return false
+	}
+
+	urlPath := u.EscapedPath()
+	if urlPath == "" {
+		urlPath = "/"
+	}
+	if hasDotSegment(urlPath) {
+		// Servers resolve these to a path the pattern never saw
+		return false
+	}
+	return wildcardMatch(pathPattern, urlPath)
+}
+
+// hasDotSegment reports whether an escaped URL path has a . or .. segment,
+// including percent-encoded dots and segments split by encoded slashes or backslashes
+func hasDotSegment(escapedPath string) bool {
+	normalized := strings.NewReplacer("%2e", ".", "%2f", "/", "%5c", "/", "\\", "/").Replace(strings.ToLower(escapedPath))
+	for _, segment := range strings.Split(normalized, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches s against a pattern where * matches any sequence of characters
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx == -1 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// cgnatRange is the carrier-grade NAT block, which net.IP.IsPrivate does not cover
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isRestrictedIP reports whether an address is private, loopback, link-local or otherwise internal
+func isRestrictedIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		cgnatRange.Contains(ip)
+}
+
+// requestGuard enforces the allowlist and address policy for one api_request execution
+type requestGuard struct {
+	rules    []AllowlistRule
+	resolver HostResolver
+	dialer   *net.Dialer
+
+	mutex        sync.Mutex
+	privateHosts map[string]bool // hosts whose matching rule permits private addresses
+}
+
+func newRequestGuard(rules []AllowlistRule, resolver HostResolver) *requestGuard {
+	return &requestGuard{
+		rules:        rules,
+		resolver:     resolver,
+		dialer:       &net.Dialer{Timeout: 10 * time.Second},
+		privateHosts: make(map[string]bool),
+	}
+}
+
+// checkURL validates a URL against the allowlist and returns the matching rule
+func (g *requestGuard) checkURL(u *url.URL) (*AllowlistRule, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, &URLNotAllowedError{URL: u.String(), Rule: "unsupported scheme: " + u.Scheme}
+	}
+	if u.User != nil {
+		return nil, &URLNotAllowedError{URL: u.String(), Rule: "credentials in URL are not allowed"}
+	}
+
+	var matched *AllowlistRule
+	for i := range g.rules {
+		if matchAllowlistRule(g.rules[i].Pattern, u) {
+			matched = &g.rules[i]
+			break
+		}
+	}
+	if matched == nil {
+		return nil, &URLNotAllowedError{URL: u.String(), Rule: "no matching allowlist rule"}
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil && isRestrictedIP(ip) && !matched.AllowPrivate {
+		return nil, &URLNotAllowedError{URL: u.String(), Rule: fmt.Sprintf("%s (private address %s)", matched.Pattern, ip)}
+	}
+
+	g.mutex.Lock()
+	if matched.AllowPrivate {
+		g.privateHosts[strings.ToLower(host)] = true
+	}
+	g.mutex.Unlock()
+
+	return matched, nil
+}
+
+// checkRedirect re-validates every redirect hop
+func (g *requestGuard) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxAPIRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxAPIRedirects)
+	}
+	_, err := g.checkURL(req.URL)
+	return err
+}
+
+// dialContext resolves the target host and refuses restricted addresses at connection time,
+// so DNS answers that change between validation and dialing cannot reach internal hosts
+func (g *requestGuard) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mutex.Lock()
+	allowPrivate := g.privateHosts[strings.ToLower(host)]
+	g.mutex.Unlock()
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := g.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	// Every resolved address must pass, otherwise a mixed answer could be used to rebind
+	for _, ip := range ips {
+		if isRestrictedIP(ip) && !allowPrivate {
+			return nil, &URLNotAllowedError{URL: host, Rule: fmt.Sprintf("resolved to private address %s", ip)}
+		}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// asURLNotAllowed unwraps a URLNotAllowedError from an HTTP client error
+func asURLNotAllowed(err error) (*URLNotAllowedError, bool) {
+	var notAllowed *URLNotAllowedError
+	if errors.As(err, &notAllowed) {
+		return notAllowed, true
+	}
+	return nil, false
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"zlay-backend/internal/requestid"
+)
+
+// auditTimeout bounds saving one execution record
+const auditTimeout = 5 * time.Second
+
+// ExecutionRecord describes a tool execution for the audit log
+type ExecutionRecord struct {
+	ExecutionContext
+	ConversationID string
+	ToolCallID     string
+	ToolName       string
+	DatasourceID   string // The datasource the tool ran against, if any
+	SQL            string // The query of a database_query execution
+	Status         string // The ToolResult status, or failed when the tool returned an error
+	Error          string
+	StartedAt      time.Time
+	Duration       time.Duration
+}
+
+// ExecutionAuditor keeps a record of every tool execution
+type ExecutionAuditor interface {
+	RecordExecution(ctx context.Context, record ExecutionRecord) error
+}
+
+// SetAuditor configures where ExecuteTool records the executions it runs
+func (r *DefaultToolRegistry) SetAuditor(auditor ExecutionAuditor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.auditor = auditor
+}
+
+// audit records an execution that ran. It uses its own deadline, so an
+// execution that was cancelled or timed out is still recorded; failing to
+// record it is logged rather than failing the execution.
+func (r *DefaultToolRegistry) audit(ctx context.Context, tool Tool, params map[string]interface{}, startedAt time.Time, result *ToolResult, err error) {
+	r.mutex.RLock()
+	auditor := r.auditor
+	r.mutex.RUnlock()
+	if auditor == nil {
+		return
+	}
+
+	execCtx, _ := ExecutionContextFrom(ctx)
+	record := ExecutionRecord{
+		ExecutionContext: execCtx,
+		ToolName:         tool.Name(),
+		StartedAt:        startedAt,
+		Duration:         time.Since(startedAt),
+	}
+	record.ConversationID, _ = ConversationFrom(ctx)
+	record.ToolCallID, _ = ToolCallFrom(ctx)
+	record.DatasourceID, _ = params["datasource_id"].(string)
+	if _, ok := tool.(*DatabaseQueryTool); ok {
+		record.SQL, _ = params["query"].(string)
+	}
+	switch {
+	case err != nil:
+		record.Status = "failed"
+		record.Error = err.Error()
+	case result != nil:
+		record.Status = result.Status
+		record.Error = result.Error
+		// Tools that resolve a datasource name or default report the id they used
+		if datasourceID, ok := result.Data["datasource_id"].(string); ok && datasourceID != "" {
+			record.DatasourceID = datasourceID
+		}
+	}
+
+	auditCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	if err := auditor.RecordExecution(auditCtx, record); err != nil {
+		requestid.Logf(ctx, "Failed to record the execution of tool %s: %v", record.ToolName, err)
+	}
+}
@@ -0,0 +1,481 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxChartPoints caps how many data points a chart returns; larger datasets are downsampled
+const MaxChartPoints = 1000
+
+// Chart error codes
+const (
+	ChartErrInvalidData        = "INVALID_DATA"
+	ChartErrResultNotFound     = "RESULT_NOT_FOUND"
+	ChartErrInvalidField       = "INVALID_FIELD"
+	ChartErrInvalidChartType   = "INVALID_CHART_TYPE"
+	ChartErrInvalidAggregation = "INVALID_AGGREGATION"
+)
+
+var (
+	chartTypes   = []string{"bar", "line", "pie", "scatter"}
+	aggregations = []string{"sum", "avg", "count", "min", "max"}
+
+	// Vega-Lite marks for each chart type
+	chartMarks = map[string]string{"bar": "bar", "line": "line", "pie": "arc", "scatter": "point"}
+)
+
+// ChartTool turns rows, typically from database_query, into a Vega-Lite style spec
+// and the dataset to render it with
+type ChartTool struct {
+	results *ResultStore
+}
+
+// NewChartTool creates a chart tool; results lets data reference earlier tool calls
+func NewChartTool(results *ResultStore) *ChartTool {
+	return &ChartTool{results: results}
+}
+
+// Name returns tool name
+func (t *ChartTool) Name() string {
+	return "create_chart"
+}
+
+// Description returns tool description
+func (t *ChartTool) Description() string {
+	return "Create a bar, line, pie or scatter chart from rows. Pass the rows as a JSON array of objects, " +
+		"or the tool_call_id of an earlier tool call in this conversation (such as database_query) to chart its rows. " +
+		"Optionally aggregate y by x with sum, avg, count, min or max. Returns a chart spec the user's browser renders."
+}
+
+// Parameters returns tool parameters
+func (t *ChartTool) Parameters() map[string]ToolParameter {
+	return map[string]ToolParameter{
+		"data": {
+			Type:        "string",
+			Description: "Rows as a JSON array of objects, or the tool_call_id of an earlier tool call whose rows to chart",
+			Required:    true,
+		},
+		"chart_type": {
+			Type:        "string",
+			Description: "Kind of chart to draw",
+			Required:    true,
+			Enum:        chartTypes,
+		},
+		"x": {
+			Type:        "string",
+			Description: "Field for the x axis (the slice category for pie charts)",
+			Required:    true,
+		},
+		"y": {
+			Type:        "string",
+			Description: "Numeric field for the y axis (the slice size for pie charts); optional with aggregation count",
+			Required:    false,
+		},
+		"aggregation": {
+			Type:        "string",
+			Description: "Aggregate y per distinct x",
+			Required:    false,
+			Enum:        aggregations,
+		},
+		"title": {
+			Type:        "string",
+			Description: "Chart title",
+			Required:    false,
+		},
+	}
+}
+
+// Execute builds the chart spec
+func (t *ChartTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
+	startTime := time.Now()
+
+	chartType := strings.ToLower(stringParam(params, "chart_type"))
+	if !containsString(chartTypes, chartType) {
+		return NewToolErrorWithCode(ChartErrInvalidChartType,
+			fmt.Sprintf("chart_type must be one of %s", strings.Join(chartTypes, ", ")), nil), nil
+	}
+	aggregation := strings.ToLower(stringParam(params, "aggregation"))
+	if aggregation != "" && !containsString(aggregations, aggregation) {
+		return NewToolErrorWithCode(ChartErrInvalidAggregation,
+			fmt.Sprintf("aggregation must be one of %s", strings.Join(aggregations, ", ")), nil), nil
+	}
+
+	rows, failure := t.resolveRows(ctx, params["data"])
+	if failure != nil {
+		return failure, nil
+	}
+
+	x, y := stringParam(params, "x"), stringParam(params, "y")
+	if failure := validateChartFields(rows, chartType, x, y, aggregation); failure != nil {
+		return failure, nil
+	}
+
+	var points []map[string]interface{}
+	if aggregation != "" {
+		if y == "" {
+			y = "count"
+		}
+		points = aggregateRows(rows, x, y, aggregation)
+	} else {
+		points = projectRows(rows, x, y)
+	}
+
+	originalCount := len(points)
+	points = downsample(points, MaxChartPoints)
+
+	return NewToolSuccess(map[string]interface{}{
+		"chart_type":           chartType,
+		"spec":                 chartSpec(chartType, stringParam(params, "title"), x, y, points),
+		"data":                 points,
+		"aggregation":          aggregation,
+		"point_count":          len(points),
+		"original_point_count": originalCount,
+		"downsampled":          len(points) < originalCount,
+	}, int(time.Since(startTime).Milliseconds())), nil
+}
+
+// ValidateAccess checks if user has access to this tool
+func (t *ChartTool) ValidateAccess(userID, projectID string) bool {
+	// Charts only reshape data the caller already has
+	return true
+}
+
+// GetCategory returns tool category
+func (t *ChartTool) GetCategory() string {
+	return "visualization"
+}
+
+// resolveRows reads the data parameter: rows, a JSON array of rows, or a tool_call_id
+func (t *ChartTool) resolveRows(ctx context.Context, data interface{}) ([]map[string]interface{}, *ToolResult) {
+	if s, ok := data.(string); ok {
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, "[") {
+			return t.referencedRows(ctx, s)
+		}
+		var decoded []interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, NewToolErrorWithCode(ChartErrInvalidData, "data is not a valid JSON array of rows", nil)
+		}
+		data = decoded
+	}
+
+	rows, ok := toRows(data)
+	if !ok {
+		return nil, NewToolErrorWithCode(ChartErrInvalidData, "data must be an array of objects or a tool_call_id", nil)
+	}
+	if len(rows) == 0 {
+		return nil, NewToolErrorWithCode(ChartErrInvalidData, "data has no rows to chart", nil)
+	}
+	return rows, nil
+}
+
+// referencedRows loads the rows of an earlier tool call in the same conversation
+func (t *ChartTool) referencedRows(ctx context.Context, toolCallID string) ([]map[string]interface{}, *ToolResult) {
+	conversationID, ok := ConversationFrom(ctx)
+	if !ok || t.results == nil || toolCallID == "" {
+		return nil, NewToolErrorWithCode(ChartErrResultNotFound, "data references a tool result, but no earlier results are available", nil)
+	}
+	result, ok := t.results.Get(conversationID, toolCallID)
+	if !ok {
+		return nil, NewToolErrorWithCode(ChartErrResultNotFound,
+			fmt.Sprintf("no result found for tool_call_id %q in this conversation", toolCallID), nil)
+	}
+
+	rows, ok := rowsFromResult(result.Data)
+	if !ok || len(rows) == 0 {
+		return nil, NewToolErrorWithCode(ChartErrInvalidData,
+			fmt.Sprintf("the result of tool_call_id %q has no rows to chart", toolCallID), nil)
+	}
+	return rows, nil
+}
+
+// rowsFromResult finds rows in a tool result: top-level rows, a database_query
+// result, or the last statement of a multi-statement query that returned rows
+func rowsFromResult(data map[string]interface{}) ([]map[string]interface{}, bool) {
+	if rows, ok := toRows(data["rows"]); ok {
+		return rows, true
+	}
+	if result, ok := data["result"].(map[string]interface{}); ok {
+		if rows, ok := toRows(result["rows"]); ok {
+			return rows, true
+		}
+	}
+	if statements, ok := data["statements"].([]map[string]interface{}); ok {
+		for i := len(statements) - 1; i >= 0; i-- {
+			if rows, ok := rowsFromResult(statements[i]); ok {
+				return rows, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func toRows(data interface{}) ([]map[string]interface{}, bool) {
+	switch v := data.(type) {
+	case []map[string]interface{}:
+		return v, true
+	case []interface{}:
+		rows := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			rows = append(rows, row)
+		}
+		return rows, true
+	}
+	return nil, false
+}
+
+// validateChartFields checks that x and y exist in the data and that y is numeric where it must be
+func validateChartFields(rows []map[string]interface{}, chartType, x, y, aggregation string) *ToolResult {
+	fields := rowFields(rows)
+	fieldError := func(field, message string) *ToolResult {
+		return NewToolErrorWithCode(ChartErrInvalidField, message, map[string]interface{}{
+			"field":            field,
+			"available_fields": fields,
+		})
+	}
+
+	if x == "" {
+		return fieldError("x", "x is required")
+	}
+	if !containsString(fields, x) {
+		return fieldError("x", fmt.Sprintf("field %q is not in the data", x))
+	}
+	if y == "" {
+		if aggregation == "count" {
+			return nil
+		}
+		return fieldError("y", fmt.Sprintf("y is required for %s charts unless aggregation is count", chartType))
+	}
+	if !containsString(fields, y) {
+		return fieldError("y", fmt.Sprintf("field %q is not in the data", y))
+	}
+	if aggregation == "count" {
+		return nil
+	}
+	for i, row := range rows {
+		if value, present := row[y]; present && value != nil {
+			if _, ok := toFloat(value); !ok {
+				return fieldError("y", fmt.Sprintf("field %q must be numeric, row %d has %v", y, i+1, value))
+			}
+		}
+	}
+	return nil
+}
+
+// rowFields returns every field name present in the rows, sorted
+func rowFields(rows []map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, row := range rows {
+		for field := range row {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// projectRows keeps only the charted fields, converting y values to numbers
+func projectRows(rows []map[string]interface{}, x, y string) []map[string]interface{} {
+	points := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		value, ok := toFloat(row[y])
+		if !ok {
+			continue
+		}
+		points = append(points, map[string]interface{}{x: row[x], y: value})
+	}
+	return points
+}
+
+// aggregateRows groups rows by x, in order of first appearance, and aggregates y per group.
+// Rows without a numeric y are ignored, except by count.
+func aggregateRows(rows []map[string]interface{}, x, y, aggregation string) []map[string]interface{} {
+	type group struct {
+		key   interface{}
+		count int
+		sum   float64
+		min   float64
+		max   float64
+	}
+	groups := make(map[string]*group)
+	var order []string
+
+	for _, row := range rows {
+		key := fmt.Sprint(row[x])
+		g, exists := groups[key]
+		if !exists {
+			g = &group{key: row[x], min: math.Inf(1), max: math.Inf(-1)}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if aggregation == "count" {
+			g.count++
+			continue
+		}
+		value, ok := toFloat(row[y])
+		if !ok {
+			continue
+		}
+		g.count++
+		g.sum += value
+		g.min = math.Min(g.min, value)
+		g.max = math.Max(g.max, value)
+	}
+
+	points := make([]map[string]interface{}, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		var value interface{}
+		switch aggregation {
+		case "count":
+			value = g.count
+		case "sum":
+			value = g.sum
+		case "avg", "min", "max":
+			if g.count == 0 {
+				value = nil
+			} else if aggregation == "avg" {
+				value = g.sum / float64(g.count)
+			} else if aggregation == "min" {
+				value = g.min
+			} else {
+				value = g.max
+			}
+		}
+		points = append(points, map[string]interface{}{x: g.key, y: value})
+	}
+	return points
+}
+
+// downsample keeps at most max points, evenly spaced and always including the first and last
+func downsample(points []map[string]interface{}, max int) []map[string]interface{} {
+	if len(points) <= max || max < 2 {
+		return points
+	}
+	sampled := make([]map[string]interface{}, max)
+	last := len(points) - 1
+	for i := 0; i < max; i++ {
+		sampled[i] = points[i*last/(max-1)]
+	}
+	return sampled
+}
+
+// chartSpec builds a Vega-Lite style spec; the dataset is passed separately as the "table" data source
+func chartSpec(chartType, title, x, y string, points []map[string]interface{}) map[string]interface{} {
+	spec := map[string]interface{}{
+		"$schema": "https://vega.github.io/schema/vega-lite/v5.json",
+		"data":    map[string]interface{}{"name": "table"},
+		"mark":    map[string]interface{}{"type": chartMarks[chartType], "tooltip": true},
+	}
+	if title != "" {
+		spec["title"] = title
+	}
+
+	if chartType == "pie" {
+		spec["encoding"] = map[string]interface{}{
+			"theta": map[string]interface{}{"field": y, "type": "quantitative"},
+			"color": map[string]interface{}{"field": x, "type": "nominal"},
+		}
+		return spec
+	}
+
+	xType := fieldType(points, x)
+	if chartType == "bar" && xType == "quantitative" {
+		xType = "ordinal"
+	}
+	spec["encoding"] = map[string]interface{}{
+		"x": map[string]interface{}{"field": x, "type": xType},
+		"y": map[string]interface{}{"field": y, "type": "quantitative"},
+	}
+	return spec
+}
+
+// fieldType infers the Vega-Lite type of a field from its values
+func fieldType(points []map[string]interface{}, field string) string {
+	numeric, temporal, total := 0, 0, 0
+	for _, point := range points {
+		value := point[field]
+		if value == nil {
+			continue
+		}
+		total++
+		if s, ok := value.(string); ok {
+			if isTimestamp(s) {
+				temporal++
+			}
+			continue
+		}
+		if _, ok := toFloat(value); ok {
+			numeric++
+		}
+	}
+	switch {
+	case total > 0 && numeric == total:
+		return "quantitative"
+	case total > 0 && temporal == total:
+		return "temporal"
+	default:
+		return "nominal"
+	}
+}
+
+func isTimestamp(s string) bool {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat converts numbers and numeric strings, as returned by database drivers, to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
+
+func stringParam(params map[string]interface{}, name string) string {
+	s, _ := params[name].(string)
+	return strings.TrimSpace(s)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
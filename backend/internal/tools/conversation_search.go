@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConversationSearchResults = 5
+	maxConversationSearchResults     = 20
+)
+
+// ConversationMatch is a past message similar to a search query
+type ConversationMatch struct {
+	ConversationID    string    `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	MessageID         string    `json:"message_id"`
+	Snippet           string    `json:"snippet"`
+	Score             float64   `json:"score"` // Cosine similarity, higher is closer
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ConversationSearcher finds the messages of a user's conversations in a
+// project that are most similar to a natural-language query
+type ConversationSearcher interface {
+	SearchConversations(ctx context.Context, userID, projectID, query string, limit int) ([]ConversationMatch, error)
+}
+
+// ConversationSearchTool lets the model look up what was said in the user's
+// earlier conversations of the project
+type ConversationSearchTool struct {
+	searcher    ConversationSearcher
+	permissions PermissionChecker
+}
+
+// NewConversationSearchTool creates a new conversation search tool
+func NewConversationSearchTool(searcher ConversationSearcher, permissions PermissionChecker) *ConversationSearchTool {
+	return &ConversationSearchTool{
+		searcher:    searcher,
+		permissions: permissions,
+	}
+}
+
+// Name returns tool name
+func (t *ConversationSearchTool) Name() string {
+	return "conversation_search"
+}
+
+// Description returns tool description
+func (t *ConversationSearchTool) Description() string {
+	return "Search the user's past conversations in this project by meaning. Returns the most similar assistant messages as snippets with the conversation they belong to."
+}
+
+// Parameters returns tool parameters
+func (t *ConversationSearchTool) Parameters() map[string]ToolParameter {
+	return map[string]ToolParameter{
+		"query": {
+			Type:        "string",
+			Description: "What to look for, in natural language",
+			Required:    true,
+		},
+		"limit": {
+			Type:        "number",
+			Description: fmt.Sprintf("Maximum number of messages to return (default: %d, max: %d)", defaultConversationSearchResults, maxConversationSearchResults),
+			Required:    false,
+			Default:     defaultConversationSearchResults,
+		},
+	}
+}
+
+// Execute embeds the query and returns the closest past messages
+func (t *ConversationSearchTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
+	startTime := time.Now()
+
+	query, _ := params["query"].(string)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return NewToolError("query parameter is required", nil), nil
+	}
+
+	limit := defaultConversationSearchResults
+	if l, ok := params["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	if limit > maxConversationSearchResults {
+		limit = maxConversationSearchResults
+	}
+
+	execCtx, _ := ExecutionContextFrom(ctx)
+	if execCtx.UserID == "" || execCtx.ProjectID == "" {
+		return NewToolError("conversation_search requires a user and project context", nil), nil
+	}
+
+	// The current conversation is already in context; ask for one more in case it matches
+	matches, err := t.searcher.SearchConversations(ctx, execCtx.UserID, execCtx.ProjectID, query, limit+1)
+	if err != nil {
+		return NewToolError("Failed to search conversations", err), nil
+	}
+	current, _ := ConversationFrom(ctx)
+	results := make([]ConversationMatch, 0, limit)
+	for _, match := range matches {
+		if match.ConversationID == current || len(results) == limit {
+			continue
+		}
+		results = append(results, match)
+	}
+
+	return NewToolSuccess(map[string]interface{}{
+		"query":   query,
+		"matches": results,
+		"count":   len(results),
+	}, int(time.Since(startTime).Milliseconds())), nil
+}
+
+// ValidateAccess checks if user has access to this tool
+func (t *ConversationSearchTool) ValidateAccess(userID, projectID string) bool {
+	// Searching only reads the caller's own conversations, so any project viewer may use it
+	return hasProjectRole(t.permissions, userID, projectID, RoleViewer)
+}
+
+// GetCategory returns the tool category
+func (t *ConversationSearchTool) GetCategory() string {
+	return "search"
+}
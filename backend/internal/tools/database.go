@@ -5,26 +5,69 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools/jobs"
 )
 
+// defaultMaxStatements caps how many statements a single database_query call may run
+const defaultMaxStatements = 10
+
 // DatabaseQueryTool executes SQL queries
 type DatabaseQueryTool struct {
-	db DBConnection
-	zdb *db.Database
+	db            DBConnection // System database for calls without a datasource_id; nil refuses them
+	zdb           *db.Database
+	permissions   PermissionChecker
+	maxStatements int
+	jobs          *jobs.Manager // Runs async queries; nil disables async
 }
 
 // NewDatabaseQueryTool creates a new database query tool
-func NewDatabaseQueryTool(zdb *db.Database) *DatabaseQueryTool {
+func NewDatabaseQueryTool(zdb *db.Database, permissions PermissionChecker) *DatabaseQueryTool {
 	return &DatabaseQueryTool{
-		zdb: zdb,
+		zdb:           zdb,
+		permissions:   permissions,
+		maxStatements: defaultMaxStatements,
 	}
 }
 
+// AllowSystemDatabase lets calls without a datasource_id read the allowlisted
+// tables of the application database through conn. Only SELECT statements are
+// run and their rows are capped.
+func (t *DatabaseQueryTool) AllowSystemDatabase(conn DBConnection) {
+	t.db = conn
+}
+
+// SetJobManager enables async: true by running such queries as background jobs
+func (t *DatabaseQueryTool) SetJobManager(manager *jobs.Manager) {
+	t.jobs = manager
+}
+
+// sqlExecutor is the part of DBConnection used to run statements; transactions satisfy it via txExecutor
+type sqlExecutor interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (sql.Result, error)
+}
+
+// txExecutor runs statements inside a database transaction
+type txExecutor struct {
+	tx *sql.Tx
+}
+
+func (e txExecutor) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return e.tx.QueryContext(ctx, query, args...)
+}
+
+func (e txExecutor) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.tx.ExecContext(ctx, query, args...)
+}
+
 // Name returns tool name
 func (t *DatabaseQueryTool) Name() string {
 	return "database_query"
@@ -32,7 +75,7 @@ func (t *DatabaseQueryTool) Name() string {
 
 // Description returns tool description
 func (t *DatabaseQueryTool) Description() string {
-	return "Execute SQL queries on project datasources. Supports SELECT, INSERT, UPDATE, DELETE operations with proper security checks."
+	return "Execute SQL queries on project datasources. Supports SELECT, INSERT, UPDATE, DELETE operations with proper security checks. Multiple statements separated by semicolons run in order, optionally inside a single transaction."
 }
 
 // Parameters returns tool parameters
@@ -40,31 +83,90 @@ func (t *DatabaseQueryTool) Parameters() map[string]ToolParameter {
 	return map[string]ToolParameter{
 		"datasource_id": {
 			Type:        "string",
-			Description: "ID of the datasource to query (optional - uses default project database)",
+			Description: "ID or name of the datasource to query; defaults to the project's default datasource",
 			Required:    false,
 		},
 		"query": {
 			Type:        "string",
-			Description: "SQL query to execute (must be a valid SQL statement)",
+			Description: fmt.Sprintf("SQL query to execute; multiple statements may be separated by semicolons (max: %d)", defaultMaxStatements),
 			Required:    true,
 		},
+		"transactional": {
+			Type:        "boolean",
+			Description: "Run all statements in one transaction and roll back if any fails (default: false)",
+			Required:    false,
+			Default:     false,
+		},
+		"explain_only": {
+			Type:        "boolean",
+			Description: "Return the query plan for SELECT statements instead of executing them (default: false)",
+			Required:    false,
+			Default:     false,
+		},
+		"row_limit_guard": {
+			Type:        "number",
+			Description: "Wrap SELECT statements without a LIMIT so they return at most this many rows",
+			Required:    false,
+		},
 		"timeout_seconds": {
 			Type:        "number",
-			Description: "Query timeout in seconds (default: 30)",
+			Description: fmt.Sprintf("Query timeout in seconds (default: 30, or %d for async queries; async max: %d)", int(jobs.DefaultTimeout/time.Second), int(jobs.MaxTimeout/time.Second)),
 			Required:    false,
 			Default:     30,
 		},
+		"async": {
+			Type:        "boolean",
+			Description: "Run the query in the background and return a query_job_id immediately; poll it with query_job_status (default: false)",
+			Required:    false,
+			Default:     false,
+		},
 	}
 }
 
 // Execute runs the database query
 func (t *DatabaseQueryTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
 	// Get parameters
-	datasourceID, hasDS := params["datasource_id"].(string)
+	datasourceRef, _ := params["datasource_id"].(string)
 	query, ok := params["query"].(string)
 	if !ok {
 		return NewToolError("Missing required parameter: query", nil), nil
 	}
+	datasourceID, failed := resolveDatasourceID(ctx, t.zdb, datasourceRef)
+	if failed != nil {
+		return failed, nil
+	}
+
+	// Without a datasource the query reads the system database, if that is allowed at all
+	// The dialect decides how the query splits into statements, so it is known before any check
+	systemDB := datasourceID == ""
+	var dialect string
+	if systemDB {
+		if t.db == nil {
+			return NewToolErrorWithCode(ErrCodeDatasourceRequired, "datasource_id is required", nil), nil
+		}
+		dialect = t.resolveDialect(ctx, t.db, "")
+		for _, stmt := range splitSQLStatements(dialect, query) {
+			if err := checkSystemQuery(stmt); err != nil {
+				return err.toolResult(), nil
+			}
+		}
+	} else {
+		dialect = t.resolveDialect(ctx, nil, datasourceID)
+		// The datasource's query policy is checked before anything runs, async jobs included
+		policy, err := loadQueryPolicy(ctx, t.zdb, datasourceID)
+		if err != nil {
+			return NewToolError("Failed to load the datasource's query policy", err), nil
+		}
+		for _, stmt := range splitSQLStatements(dialect, query) {
+			if violation := policy.CheckStatement(stmt); violation != nil {
+				return violation.toolResult(), nil
+			}
+		}
+	}
+
+	if async, _ := params["async"].(bool); async {
+		return t.submitAsync(ctx, params, query, datasourceID, dialect), nil
+	}
 
 	timeoutSecs := 30
 	if timeout, hasTimeout := params["timeout_seconds"]; hasTimeout {
@@ -81,9 +183,7 @@ func (t *DatabaseQueryTool) Execute(ctx context.Context, params map[string]inter
 	var db DBConnection
 	var err error
 
-	if hasDS && datasourceID != "" {
-		// TODO: Implement datasource-specific connections
-		// For now, use default project database
+	if !systemDB {
 		db, err = t.getDatasourceConnection(queryCtx, datasourceID)
 	} else {
 		// Use default connection
@@ -93,29 +193,193 @@ func (t *DatabaseQueryTool) Execute(ctx context.Context, params map[string]inter
 	if err != nil {
 		return NewToolError("Failed to get database connection", err), nil
 	}
+	if db == nil {
+		return NewToolError("No database connection available", nil), nil
+	}
 
-	// Execute query based on query type
-	result, err := t.executeQuery(queryCtx, db, query)
-	if err != nil {
-		return NewToolError("Query execution failed", err), nil
+	transactional, _ := params["transactional"].(bool)
+	explainOnly, _ := params["explain_only"].(bool)
+	rowLimit := 0
+	if rl, ok := params["row_limit_guard"].(float64); ok && rl > 0 {
+		rowLimit = int(rl)
+	}
+
+	statements := splitSQLStatements(dialect, query)
+	if len(statements) == 0 {
+		return NewToolError("Query is empty", nil), nil
+	}
+	maxStatements := t.maxStatements
+	if maxStatements <= 0 {
+		maxStatements = defaultMaxStatements
+	}
+	if len(statements) > maxStatements {
+		return NewToolError(fmt.Sprintf("Too many statements: %d (max %d)", len(statements), maxStatements), nil), nil
+	}
+
+	// Reject the whole script before running anything if any statement is forbidden
+	for i, stmt := range statements {
+		if err := checkForbiddenOperation(stmt); err != nil {
+			return NewToolError(fmt.Sprintf("Statement %d rejected", i+1), err), nil
+		}
+	}
+
+	if explainOnly || rowLimit > 0 || systemDB {
+		if explainOnly {
+			return t.explainStatements(queryCtx, db, dialect, statements, query, datasourceID), nil
+		}
+		for i, stmt := range statements {
+			if systemDB {
+				limit := systemRowLimit
+				if rowLimit > 0 && rowLimit < limit {
+					limit = rowLimit
+				}
+				statements[i] = wrapRowLimit(dialect, stmt, limit)
+				continue
+			}
+			statements[i] = applyRowLimitGuard(dialect, stmt, rowLimit)
+		}
+	}
+
+	// Single statements keep the original result shape
+	if len(statements) == 1 && !transactional {
+		result, err := t.executeQuery(queryCtx, db, statements[0])
+		if err != nil {
+			return NewToolError("Query execution failed", err), nil
+		}
+
+		data := map[string]interface{}{
+			"query":         query,
+			"result":        result,
+			"rows_affected": t.getRowsAffected(result),
+			"datasource_id": datasourceID,
+		}
+		return NewToolSuccess(data, 0), nil
 	}
 
-	// Format result
+	results, failedIndex, err := t.executeStatements(queryCtx, db, statements, transactional)
 	data := map[string]interface{}{
 		"query":         query,
-		"result":        result,
-		"rows_affected": t.getRowsAffected(result),
+		"statements":    results,
+		"transactional": transactional,
 		"datasource_id": datasourceID,
 	}
+	if err != nil {
+		result := NewToolError(fmt.Sprintf("Statement %d failed", failedIndex+1), err)
+		if transactional {
+			data["rolled_back"] = true
+		}
+		result.Data = data
+		return result, nil
+	}
+
+	var rowsAffected int64
+	for _, r := range results {
+		rowsAffected += t.getRowsAffected(r["result"])
+	}
+	data["rows_affected"] = rowsAffected
 
 	return NewToolSuccess(data, 0), nil
 }
 
+// explainStatements returns query plans for SELECT statements without executing them
+func (t *DatabaseQueryTool) explainStatements(ctx context.Context, db DBConnection, dialect string, statements []string, query, datasourceID string) *ToolResult {
+	// Build every EXPLAIN first so a non-SELECT statement is refused before anything runs
+	explainQueries := make([]string, len(statements))
+	for i, stmt := range statements {
+		explainQuery, err := buildExplainQuery(dialect, stmt)
+		if err != nil {
+			return NewToolError(fmt.Sprintf("Statement %d cannot be explained", i+1), err)
+		}
+		explainQueries[i] = explainQuery
+	}
+
+	plans := make([]map[string]interface{}, 0, len(statements))
+	for i, explainQuery := range explainQueries {
+		plan, err := t.executeSelect(ctx, db, explainQuery)
+		if err != nil {
+			return NewToolError(fmt.Sprintf("Failed to explain statement %d", i+1), err)
+		}
+		plans = append(plans, map[string]interface{}{
+			"index":         i,
+			"statement":     statements[i],
+			"explain_query": explainQuery,
+			"plan":          plan,
+		})
+	}
+
+	return NewToolSuccess(map[string]interface{}{
+		"query":         query,
+		"explain_only":  true,
+		"dialect":       dialect,
+		"plans":         plans,
+		"datasource_id": datasourceID,
+	}, 0)
+}
+
+// executeStatements runs statements in order and returns one result per executed statement.
+// In transactional mode all statements share a transaction that is rolled back on the first failure.
+func (t *DatabaseQueryTool) executeStatements(ctx context.Context, db DBConnection, statements []string, transactional bool) ([]map[string]interface{}, int, error) {
+	var executor sqlExecutor = db
+	var tx *sql.Tx
+	if transactional {
+		var err error
+		tx, err = db.Begin(ctx)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		executor = txExecutor{tx: tx}
+	}
+
+	results := make([]map[string]interface{}, 0, len(statements))
+	for i, stmt := range statements {
+		result, err := t.executeQuery(ctx, executor, stmt)
+		if err != nil {
+			results = append(results, map[string]interface{}{
+				"index":     i,
+				"statement": stmt,
+				"error":     err.Error(),
+			})
+			if tx != nil {
+				tx.Rollback()
+			}
+			return results, i, err
+		}
+
+		results = append(results, map[string]interface{}{
+			"index":     i,
+			"statement": stmt,
+			"result":    result,
+		})
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return results, len(statements) - 1, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+
+	return results, 0, nil
+}
+
 // ValidateAccess checks if user has access to database tools
 func (t *DatabaseQueryTool) ValidateAccess(userID, projectID string) bool {
-	// TODO: Implement proper permission checking
-	// For now, allow all authenticated users
-	return true
+	// Queries can modify data, so require editor or above
+	return hasProjectRole(t.permissions, userID, projectID, RoleEditor)
+}
+
+// HasSideEffects reports whether the query contains any statement other than a SELECT.
+// The datasource's dialect is not known here, so the query is split both with and
+// without backslash escapes and counts as a write if either finds one.
+func (t *DatabaseQueryTool) HasSideEffects(params map[string]interface{}) bool {
+	query, _ := params["query"].(string)
+	for _, dialect := range []string{dialectGeneric, dialectMySQL} {
+		for _, statement := range splitSQLStatements(dialect, query) {
+			if !isSelectStatement(statement) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // GetCategory returns tool category
@@ -125,16 +389,25 @@ func (t *DatabaseQueryTool) GetCategory() string {
 
 // Helper methods
 
-func (t *DatabaseQueryTool) executeQuery(ctx context.Context, db DBConnection, query string) (interface{}, error) {
+// checkForbiddenOperation rejects destructive statements
+func checkForbiddenOperation(statement string) error {
+	statementLower := strings.ToLower(statement)
+	forbiddenOps := []string{"drop", "truncate", "alter database", "create database"}
+	for _, op := range forbiddenOps {
+		if strings.Contains(statementLower, op) {
+			return fmt.Errorf("forbidden operation detected: %s", op)
+		}
+	}
+	return nil
+}
+
+func (t *DatabaseQueryTool) executeQuery(ctx context.Context, db sqlExecutor, query string) (interface{}, error) {
 	// Parse query to determine type (simplified)
 	queryLower := strings.ToLower(strings.TrimSpace(query))
 
 	// Check for forbidden operations
-	forbiddenOps := []string{"drop", "truncate", "alter database", "create database"}
-	for _, op := range forbiddenOps {
-		if strings.Contains(queryLower, op) {
-			return nil, fmt.Errorf("forbidden operation detected: %s", op)
-		}
+	if err := checkForbiddenOperation(query); err != nil {
+		return nil, err
 	}
 
 	// Execute based on query type
@@ -153,7 +426,7 @@ func (t *DatabaseQueryTool) executeQuery(ctx context.Context, db DBConnection, q
 	}
 }
 
-func (t *DatabaseQueryTool) executeSelect(ctx context.Context, db DBConnection, query string) (interface{}, error) {
+func (t *DatabaseQueryTool) executeSelect(ctx context.Context, db sqlExecutor, query string) (interface{}, error) {
 	startTime := time.Now()
 
 	rows, err := db.Query(ctx, query)
@@ -174,8 +447,12 @@ func (t *DatabaseQueryTool) executeSelect(ctx context.Context, db DBConnection,
 		// Create a map for this row
 		row := make(map[string]interface{})
 		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
 
-		if err := rows.Scan(values...); err != nil {
+		if err := rows.Scan(valuePtrs...); err != nil {
 			return nil, err
 		}
 
@@ -223,7 +500,7 @@ func (t *DatabaseQueryTool) executeSelect(ctx context.Context, db DBConnection,
 	}, nil
 }
 
-func (t *DatabaseQueryTool) executeUpdate(ctx context.Context, db DBConnection, query string) (interface{}, error) {
+func (t *DatabaseQueryTool) executeUpdate(ctx context.Context, db sqlExecutor, query string) (interface{}, error) {
 	startTime := time.Now()
 
 	result, err := db.Exec(ctx, query)
@@ -244,18 +521,32 @@ func (t *DatabaseQueryTool) executeUpdate(ctx context.Context, db DBConnection,
 	}, nil
 }
 
+// OpenDatasourceConnection opens a new connection to an active datasource of an
+// active project, which must be the project ctx runs in when it runs in one.
+// The caller closes it when done.
+func OpenDatasourceConnection(ctx context.Context, zdb *db.Database, datasourceID string) (DBConnection, error) {
+	if datasourceID == "" {
+		return nil, fmt.Errorf("datasource ID is required")
+	}
+	ctx, err := withDatasourceProject(ctx, zdb, datasourceID)
+	if err != nil {
+		return nil, err
+	}
+	return (&DatabaseQueryTool{zdb: zdb}).getDatasourceConnection(ctx, datasourceID)
+}
+
 func (t *DatabaseQueryTool) getDatasourceConnection(ctx context.Context, datasourceID string) (DBConnection, error) {
 	// If no datasource ID, use default connection
 	if datasourceID == "" {
 		return t.db, nil
 	}
 
-	// Get datasource details from database with project validation
+	// Get datasource details from database, only of the calling project
 	row, err := t.zdb.QueryRow(ctx, 
 		`SELECT d.type, d.config FROM datasources d 
 		 JOIN projects p ON d.project_id = p.id 
-		 WHERE d.id = $1 AND d.is_active = true AND p.is_active = true`, 
-		datasourceID)
+		 WHERE d.id = $1 AND d.project_id = $2 AND d.is_active = true AND p.is_active = true`, 
+		datasourceID, executionProjectID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch datasource: %w", err)
 	}
@@ -274,6 +565,24 @@ func (t *DatabaseQueryTool) getDatasourceConnection(ctx context.Context, datasou
 		return nil, fmt.Errorf("invalid datasource config")
 	}
 
+	return t.openDatasourceConfig(dsType, configBytes)
+}
+
+// TestDatasourceConfig checks that a datasource of the given type and config
+// can be connected to, without saving it
+func TestDatasourceConfig(dsType string, config []byte) error {
+	conn, err := (&DatabaseQueryTool{}).openDatasourceConfig(dsType, config)
+	if err != nil {
+		return err
+	}
+	if closer, ok := conn.(io.Closer); ok {
+		closer.Close()
+	}
+	return nil
+}
+
+// openDatasourceConfig connects to a datasource of the given type and config
+func (t *DatabaseQueryTool) openDatasourceConfig(dsType string, configBytes []byte) (DBConnection, error) {
 	// Parse config based on datasource type
 	switch strings.ToLower(dsType) {
 	case "postgres", "postgresql":
@@ -549,17 +858,23 @@ type IndexInfo struct {
 
 // DatasourceInspector provides unified database inspection capabilities
 type DatasourceInspector struct {
-	db DBConnection
+	db         DBConnection
+	dbType     string
+	detectOnce sync.Once // Guards probing for dbType when the caller did not know it
 }
 
-// NewDatasourceInspector creates a new datasource inspector
-func NewDatasourceInspector(db DBConnection) *DatasourceInspector {
-	return &DatasourceInspector{db: db}
+// NewDatasourceInspector creates a new datasource inspector. dbType is the
+// datasource type when known; leave it empty to detect it from the connection.
+func NewDatasourceInspector(db DBConnection, dbType string) *DatasourceInspector {
+	return &DatasourceInspector{db: db, dbType: dbType}
 }
 
 // InspectDatasource returns comprehensive information about the datasource
 func (i *DatasourceInspector) InspectDatasource(ctx context.Context, dbType string) (*DatasourceInfo, error) {
 	startTime := time.Now()
+	if dbType == "" {
+		dbType = i.detectDatabaseType(ctx)
+	}
 	
 	info := &DatasourceInfo{
 		Type:       dbType,
@@ -732,7 +1047,7 @@ func (i *DatasourceInspector) getTables(ctx context.Context, dbType string) ([]T
 // getPostgresTables retrieves tables from PostgreSQL
 func (i *DatasourceInspector) getPostgresTables(ctx context.Context) ([]TableInfo, error) {
 	query := `
-		SELECT table_name, table_type 
+		SELECT table_name, table_type, table_schema 
 		FROM information_schema.tables 
 		WHERE table_schema NOT IN ('information_schema', 'pg_catalog')
 		ORDER BY table_name`
@@ -745,14 +1060,15 @@ func (i *DatasourceInspector) getPostgresTables(ctx context.Context) ([]TableInf
 	
 	var tables []TableInfo
 	for rows.Next() {
-		var tableName, tableType string
-		if err := rows.Scan(&tableName, &tableType); err != nil {
+		var tableName, tableType, tableSchema string
+		if err := rows.Scan(&tableName, &tableType, &tableSchema); err != nil {
 			continue
 		}
 		
 		tables = append(tables, TableInfo{
-			Name: tableName,
-			Type: tableType,
+			Name:       tableName,
+			Type:       tableType,
+			Properties: map[string]interface{}{"schema": tableSchema}, // Lets query policies match schema-qualified rules
 		})
 	}
 	
@@ -863,275 +1179,275 @@ func (i *DatasourceInspector) getAllRelations(ctx context.Context, dbType string
 	}
 }
 
-// getPostgresRelations retrieves foreign key relationships from PostgreSQL
-func (i *DatasourceInspector) getPostgresRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
-	query := `
-		SELECT 
+// Relation directions reported for the relations of one table
+const (
+	RelationOutgoing = "outgoing" // The table references another table
+	RelationIncoming = "incoming" // Another table references the table
+)
+
+// postgresRelationsQuery lists foreign key column pairs in key order. The
+// referenced column is matched by position, so the columns of a composite key
+// pair up instead of multiplying. filter narrows the rows, e.g. to one table.
+func postgresRelationsQuery(filter string) string {
+	return `
+		SELECT
+			kcu.table_name,
+			kcu.column_name,
+			ref.table_name AS foreign_table_name,
+			ref.column_name AS foreign_column_name,
+			kcu.constraint_name,
+			rc.delete_rule,
+			rc.update_rule
+		FROM information_schema.referential_constraints AS rc
+		JOIN information_schema.key_column_usage AS kcu
+		  ON kcu.constraint_name = rc.constraint_name
+		  AND kcu.constraint_schema = rc.constraint_schema
+		JOIN information_schema.key_column_usage AS ref
+		  ON ref.constraint_name = rc.unique_constraint_name
+		  AND ref.constraint_schema = rc.unique_constraint_schema
+		  AND ref.ordinal_position = kcu.position_in_unique_constraint
+		WHERE kcu.table_schema NOT IN ('information_schema', 'pg_catalog')` + filter + `
+		ORDER BY kcu.table_name, kcu.constraint_name, kcu.ordinal_position`
+}
+
+// mysqlRelationsQuery lists foreign key column pairs in key order; filter narrows the rows
+func mysqlRelationsQuery(filter string) string {
+	return `
+		SELECT
+			kcu.TABLE_NAME,
+			kcu.COLUMN_NAME,
+			kcu.REFERENCED_TABLE_NAME,
+			kcu.REFERENCED_COLUMN_NAME,
+			kcu.CONSTRAINT_NAME,
+			rc.DELETE_RULE,
+			rc.UPDATE_RULE
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE kcu
+		LEFT JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS rc
+		  ON rc.CONSTRAINT_SCHEMA = kcu.CONSTRAINT_SCHEMA
+		  AND rc.CONSTRAINT_NAME = kcu.CONSTRAINT_NAME
+		  AND rc.TABLE_NAME = kcu.TABLE_NAME
+		WHERE kcu.TABLE_SCHEMA = DATABASE()
+		  AND kcu.REFERENCED_TABLE_NAME IS NOT NULL` + filter + `
+		ORDER BY kcu.TABLE_NAME, kcu.CONSTRAINT_NAME, kcu.ORDINAL_POSITION`
+}
+
+// genericRelationsQuery lists foreign key columns through the standard
+// information_schema views. constraint_column_usage does not say which
+// referenced column belongs to which referencing column, so a composite key
+// returns every pairing; groupRelations lists each column once.
+func genericRelationsQuery(filter string) string {
+	return `
+		SELECT
 			tc.table_name,
 			kcu.column_name,
 			ccu.table_name AS foreign_table_name,
 			ccu.column_name AS foreign_column_name,
-			tc.constraint_name,
-			rc.delete_rule,
-			rc.update_rule
-		FROM information_schema.table_constraints AS tc 
+			tc.constraint_name
+		FROM information_schema.table_constraints AS tc
 		JOIN information_schema.key_column_usage AS kcu
 		  ON tc.constraint_name = kcu.constraint_name
 		  AND tc.table_schema = kcu.table_schema
 		JOIN information_schema.constraint_column_usage AS ccu
 		  ON ccu.constraint_name = tc.constraint_name
 		  AND ccu.table_schema = tc.table_schema
-		LEFT JOIN information_schema.referential_constraints rc
-		  ON tc.constraint_name = rc.constraint_name
-		  AND tc.constraint_schema = rc.constraint_schema
-		WHERE tc.constraint_type = 'FOREIGN KEY' 
-		  AND tc.table_name = $1
-		  AND tc.table_schema NOT IN ('information_schema', 'pg_catalog')`
-	
-	rows, err := i.db.Query(ctx, query, tableName)
+		WHERE tc.constraint_type = 'FOREIGN KEY'` + filter + `
+		ORDER BY tc.table_name, tc.constraint_name, kcu.ordinal_position`
+}
+
+// queryRelations runs a relations query returning (from_table, from_column,
+// to_table, to_column, constraint_name) and, withRules, the delete and update
+// rules. Rows are grouped into one RelationInfo per constraint and tagged with
+// direction.
+func (i *DatasourceInspector) queryRelations(ctx context.Context, query string, withRules bool, direction string, args ...interface{}) ([]RelationInfo, error) {
+	rows, err := i.db.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query postgres relations: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var relations []RelationInfo
 	for rows.Next() {
 		var fromTable, fromCol, toTable, toCol, constraintName string
 		var onDelete, onUpdate sql.NullString
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName, &onDelete, &onUpdate); err != nil {
+		dest := []interface{}{&fromTable, &fromCol, &toTable, &toCol, &constraintName}
+		if withRules {
+			dest = append(dest, &onDelete, &onUpdate)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			continue
 		}
-		
-		relation := RelationInfo{
+
+		relations = append(relations, RelationInfo{
 			FromTable:      fromTable,
 			FromColumns:    []string{fromCol},
 			ToTable:        toTable,
 			ToColumns:      []string{toCol},
 			RelationType:   "foreign_key",
 			ConstraintName: constraintName,
+			OnDeleteAction: onDelete.String,
+			OnUpdateAction: onUpdate.String,
+			Direction:      direction,
+		})
+	}
+	return groupRelations(relations), rows.Err()
+}
+
+// tableRelations runs query with the table name for its outgoing relations
+// and, with includeReverse, reverseQuery for the relations pointing at it
+func (i *DatasourceInspector) tableRelations(ctx context.Context, query, reverseQuery string, withRules bool, tableName string, includeReverse bool) ([]RelationInfo, error) {
+	relations, err := i.queryRelations(ctx, query, withRules, RelationOutgoing, tableName)
+	if err != nil || !includeReverse {
+		return relations, err
+	}
+	incoming, err := i.queryRelations(ctx, reverseQuery, withRules, RelationIncoming, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return append(relations, incoming...), nil
+}
+
+// groupRelations merges the single-column entries of multi-column foreign
+// keys into one RelationInfo per constraint, in the order the constraints
+// first appear. Columns keep the order of the entries; a column already
+// listed is not repeated.
+func groupRelations(relations []RelationInfo) []RelationInfo {
+	grouped := make([]RelationInfo, 0, len(relations))
+	index := make(map[string]int)
+	for _, rel := range relations {
+		key := strings.Join([]string{rel.FromTable, rel.ToTable, rel.ConstraintName, rel.Direction}, "\x00")
+		at, exists := index[key]
+		if !exists {
+			index[key] = len(grouped)
+			rel.FromColumns = appendMissing(nil, rel.FromColumns...)
+			rel.ToColumns = appendMissing(nil, rel.ToColumns...)
+			grouped = append(grouped, rel)
+			continue
 		}
-		
-		if onDelete.Valid {
-			relation.OnDeleteAction = onDelete.String
+		grouped[at].FromColumns = appendMissing(grouped[at].FromColumns, rel.FromColumns...)
+		grouped[at].ToColumns = appendMissing(grouped[at].ToColumns, rel.ToColumns...)
+	}
+	return grouped
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
 		}
-		if onUpdate.Valid {
-			relation.OnUpdateAction = onUpdate.String
+		if !found {
+			list = append(list, value)
 		}
-		
-		relations = append(relations, relation)
 	}
-	
+	return list
+}
+
+// getPostgresRelations retrieves foreign key relationships from PostgreSQL
+func (i *DatasourceInspector) getPostgresRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
+	relations, err := i.tableRelations(ctx,
+		postgresRelationsQuery("\n\t\t  AND kcu.table_name = $1"),
+		postgresRelationsQuery("\n\t\t  AND ref.table_name = $1"),
+		true, tableName, includeReverse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query postgres relations: %w", err)
+	}
 	return relations, nil
 }
 
 // getPostgresAllRelations retrieves all foreign key relationships from PostgreSQL
 func (i *DatasourceInspector) getPostgresAllRelations(ctx context.Context) ([]RelationInfo, error) {
-	query := `
-		SELECT 
-			tc.table_name,
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name,
-			tc.constraint_name,
-			rc.delete_rule,
-			rc.update_rule
-		FROM information_schema.table_constraints AS tc 
-		JOIN information_schema.key_column_usage AS kcu
-		  ON tc.constraint_name = kcu.constraint_name
-		  AND tc.table_schema = kcu.table_schema
-		JOIN information_schema.constraint_column_usage AS ccu
-		  ON ccu.constraint_name = tc.constraint_name
-		  AND ccu.table_schema = tc.table_schema
-		LEFT JOIN information_schema.referential_constraints rc
-		  ON tc.constraint_name = rc.constraint_name
-		  AND tc.constraint_schema = rc.constraint_schema
-		WHERE tc.constraint_type = 'FOREIGN KEY' 
-		  AND tc.table_schema NOT IN ('information_schema', 'pg_catalog')`
-	
-	rows, err := i.db.Query(ctx, query)
+	relations, err := i.queryRelations(ctx, postgresRelationsQuery(""), true, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query all postgres relations: %w", err)
 	}
-	defer rows.Close()
-	
-	var relations []RelationInfo
-	for rows.Next() {
-		var fromTable, fromCol, toTable, toCol, constraintName string
-		var onDelete, onUpdate sql.NullString
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName, &onDelete, &onUpdate); err != nil {
-			continue
-		}
-		
-		relation := RelationInfo{
-			FromTable:      fromTable,
-			FromColumns:    []string{fromCol},
-			ToTable:        toTable,
-			ToColumns:      []string{toCol},
-			RelationType:   "foreign_key",
-			ConstraintName: constraintName,
-		}
-		
-		if onDelete.Valid {
-			relation.OnDeleteAction = onDelete.String
-		}
-		if onUpdate.Valid {
-			relation.OnUpdateAction = onUpdate.String
-		}
-		
-		relations = append(relations, relation)
-	}
-	
 	return relations, nil
 }
 
 // getMySQLRelations retrieves foreign key relationships from MySQL
 func (i *DatasourceInspector) getMySQLRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
-	query := `
-		SELECT 
-			TABLE_NAME,
-			COLUMN_NAME,
-			REFERENCED_TABLE_NAME,
-			REFERENCED_COLUMN_NAME,
-			CONSTRAINT_NAME,
-			ON_DELETE,
-			ON_UPDATE
-		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
-		WHERE REFERENCED_TABLE_SCHEMA = DATABASE()
-		  AND REFERENCED_TABLE_NAME IS NOT NULL
-		  AND TABLE_NAME = ?
-		  AND REFERENCED_TABLE_SCHEMA = DATABASE()`
-	
-	rows, err := i.db.Query(ctx, query, tableName)
+	relations, err := i.tableRelations(ctx,
+		mysqlRelationsQuery("\n\t\t  AND kcu.TABLE_NAME = ?"),
+		mysqlRelationsQuery("\n\t\t  AND kcu.REFERENCED_TABLE_NAME = ?"),
+		true, tableName, includeReverse)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query mysql relations: %w", err)
 	}
-	defer rows.Close()
-	
-	var relations []RelationInfo
-	for rows.Next() {
-		var fromTable, fromCol, toTable, toCol, constraintName string
-		var onDelete, onUpdate sql.NullString
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName, &onDelete, &onUpdate); err != nil {
-			continue
-		}
-		
-		relation := RelationInfo{
-			FromTable:      fromTable,
-			FromColumns:    []string{fromCol},
-			ToTable:        toTable,
-			ToColumns:      []string{toCol},
-			RelationType:   "foreign_key",
-			ConstraintName: constraintName,
-		}
-		
-		if onDelete.Valid {
-			relation.OnDeleteAction = onDelete.String
-		}
-		if onUpdate.Valid {
-			relation.OnUpdateAction = onUpdate.String
-		}
-		
-		relations = append(relations, relation)
-	}
-	
 	return relations, nil
 }
 
 // getMySQLAllRelations retrieves all foreign key relationships from MySQL
 func (i *DatasourceInspector) getMySQLAllRelations(ctx context.Context) ([]RelationInfo, error) {
-	query := `
-		SELECT 
-			TABLE_NAME,
-			COLUMN_NAME,
-			REFERENCED_TABLE_NAME,
-			REFERENCED_COLUMN_NAME,
-			CONSTRAINT_NAME,
-			ON_DELETE,
-			ON_UPDATE
-		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
-		WHERE REFERENCED_TABLE_SCHEMA = DATABASE()
-		  AND REFERENCED_TABLE_NAME IS NOT NULL`
-	
-	rows, err := i.db.Query(ctx, query)
+	relations, err := i.queryRelations(ctx, mysqlRelationsQuery(""), true, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query all mysql relations: %w", err)
 	}
-	defer rows.Close()
-	
-	var relations []RelationInfo
-	for rows.Next() {
-		var fromTable, fromCol, toTable, toCol, constraintName string
-		var onDelete, onUpdate sql.NullString
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName, &onDelete, &onUpdate); err != nil {
+	return relations, nil
+}
+
+// getSQLiteRelations retrieves foreign key relationships from SQLite. SQLite
+// has no catalog of incoming references, so with includeReverse the foreign
+// keys of every table are read and those pointing at tableName kept.
+func (i *DatasourceInspector) getSQLiteRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
+	relations, err := i.getSQLiteForeignKeys(ctx, tableName, RelationOutgoing)
+	if err != nil || !includeReverse {
+		return relations, err
+	}
+
+	tables, err := i.getSQLiteTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		foreignKeys, err := i.getSQLiteForeignKeys(ctx, table.Name, RelationIncoming)
+		if err != nil {
 			continue
 		}
-		
-		relation := RelationInfo{
-			FromTable:      fromTable,
-			FromColumns:    []string{fromCol},
-			ToTable:        toTable,
-			ToColumns:      []string{toCol},
-			RelationType:   "foreign_key",
-			ConstraintName: constraintName,
-		}
-		
-		if onDelete.Valid {
-			relation.OnDeleteAction = onDelete.String
-		}
-		if onUpdate.Valid {
-			relation.OnUpdateAction = onUpdate.String
+		for _, rel := range foreignKeys {
+			if strings.EqualFold(rel.ToTable, tableName) {
+				relations = append(relations, rel)
+			}
 		}
-		
-		relations = append(relations, relation)
 	}
-	
 	return relations, nil
 }
 
-// getSQLiteRelations retrieves foreign key relationships from SQLite
-func (i *DatasourceInspector) getSQLiteRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
-	query := `PRAGMA foreign_key_list(` + tableName + `)`
-	
+// getSQLiteForeignKeys reads the foreign keys declared by one table. The
+// columns of a composite key share an id and come in key order.
+func (i *DatasourceInspector) getSQLiteForeignKeys(ctx context.Context, tableName, direction string) ([]RelationInfo, error) {
+	query := `PRAGMA foreign_key_list("` + strings.ReplaceAll(tableName, `"`, `""`) + `")`
+
 	rows, err := i.db.Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query sqlite relations: %w", err)
 	}
 	defer rows.Close()
-	
+
 	var relations []RelationInfo
 	for rows.Next() {
-		var id int
-		var seq int
-		var table string
-		var fromCol string
-		var toCol string
-		var onUpdate string
-		var onDelete string
-		var match string
-		
+		var id, seq int
+		var table, fromCol, onUpdate, onDelete, match string
+		var toCol sql.NullString // NULL when the key references the primary key implicitly
+
 		if err := rows.Scan(&id, &seq, &table, &fromCol, &toCol, &onUpdate, &onDelete, &match); err != nil {
 			continue
 		}
-		
-		relation := RelationInfo{
+
+		relations = append(relations, RelationInfo{
 			FromTable:      tableName,
 			FromColumns:    []string{fromCol},
 			ToTable:        table,
-			ToColumns:      []string{toCol},
+			ToColumns:      []string{toCol.String},
 			RelationType:   "foreign_key",
 			ConstraintName: fmt.Sprintf("fk_%d", id),
 			OnDeleteAction: onDelete,
 			OnUpdateAction: onUpdate,
-		}
-		
-		relations = append(relations, relation)
+			Direction:      direction,
+		})
 	}
-	
-	return relations, nil
+	return groupRelations(relations), rows.Err()
 }
 
 // getSQLiteAllRelations retrieves all foreign key relationships from SQLite
@@ -1141,143 +1457,119 @@ func (i *DatasourceInspector) getSQLiteAllRelations(ctx context.Context) ([]Rela
 	if err != nil {
 		return nil, err
 	}
-	
+
 	var allRelations []RelationInfo
 	for _, table := range tables {
-		relations, err := i.getSQLiteRelations(ctx, table.Name, false)
+		relations, err := i.getSQLiteForeignKeys(ctx, table.Name, "")
 		if err != nil {
 			continue
 		}
 		allRelations = append(allRelations, relations...)
 	}
-	
+
 	return allRelations, nil
 }
 
 // getGenericRelations fallback implementation
 func (i *DatasourceInspector) getGenericRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
-	query := `
-		SELECT 
-			tc.table_name,
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name,
-			tc.constraint_name
-		FROM information_schema.table_constraints AS tc 
-		JOIN information_schema.key_column_usage AS kcu
-		  ON tc.constraint_name = kcu.constraint_name
-		  AND tc.table_schema = kcu.table_schema
-		JOIN information_schema.constraint_column_usage AS ccu
-		  ON ccu.constraint_name = tc.constraint_name
-		  AND ccu.table_schema = tc.table_schema
-		WHERE tc.constraint_type = 'FOREIGN KEY' 
-		  AND tc.table_name = $1`
-	
-	rows, err := i.db.Query(ctx, query, tableName)
+	relations, err := i.tableRelations(ctx,
+		genericRelationsQuery("\n\t\t  AND tc.table_name = $1"),
+		genericRelationsQuery("\n\t\t  AND ccu.table_name = $1"),
+		false, tableName, includeReverse)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query generic relations: %w", err)
 	}
-	defer rows.Close()
-	
-	var relations []RelationInfo
-	for rows.Next() {
-		var fromTable, fromCol, toTable, toCol, constraintName string
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName); err != nil {
-			continue
-		}
-		
-		relations = append(relations, RelationInfo{
-			FromTable:      fromTable,
-			FromColumns:    []string{fromCol},
-			ToTable:        toTable,
-			ToColumns:      []string{toCol},
-			RelationType:   "foreign_key",
-			ConstraintName: constraintName,
-		})
-	}
-	
 	return relations, nil
 }
 
 // getGenericAllRelations fallback implementation
 func (i *DatasourceInspector) getGenericAllRelations(ctx context.Context) ([]RelationInfo, error) {
-	query := `
-		SELECT 
-			tc.table_name,
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name,
-			tc.constraint_name
-		FROM information_schema.table_constraints AS tc 
-		JOIN information_schema.key_column_usage AS kcu
-		  ON tc.constraint_name = kcu.constraint_name
-		  AND tc.table_schema = kcu.table_schema
-		JOIN information_schema.constraint_column_usage AS ccu
-		  ON ccu.constraint_name = tc.constraint_name
-		  AND ccu.table_schema = tc.table_schema
-		WHERE tc.constraint_type = 'FOREIGN KEY'`
-	
-	rows, err := i.db.Query(ctx, query)
+	relations, err := i.queryRelations(ctx, genericRelationsQuery(""), false, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query all generic relations: %w", err)
 	}
-	defer rows.Close()
-	
-	var relations []RelationInfo
-	for rows.Next() {
-		var fromTable, fromCol, toTable, toCol, constraintName string
-		
-		if err := rows.Scan(&fromTable, &fromCol, &toTable, &toCol, &constraintName); err != nil {
-			continue
-		}
-		
-		relations = append(relations, RelationInfo{
-			FromTable:      fromTable,
-			FromColumns:    []string{fromCol},
-			ToTable:        toTable,
-			ToColumns:      []string{toCol},
-			RelationType:   "foreign_key",
-			ConstraintName: constraintName,
-		})
-	}
-	
 	return relations, nil
 }
 
-// buildRelationGraph creates a graph representation of relationships
-func (i *DatasourceInspector) buildRelationGraph(ctx context.Context, relations []RelationInfo, depth int) (*RelationGraph, error) {
+// buildRelationGraph creates a graph of the relations with one edge per
+// (from, to, constraint). Without a startTable every related table is a node;
+// with one, the graph grows from it one relation at a time up to depth hops,
+// following relations towards the tables they reference and, with
+// followIncoming, back to the tables referencing them.
+func (i *DatasourceInspector) buildRelationGraph(ctx context.Context, relations []RelationInfo, startTable string, depth int, followIncoming bool) (*RelationGraph, error) {
 	graph := &RelationGraph{
 		Nodes: []RelationNode{},
 		Edges: []RelationEdge{},
 	}
-	
-	// Build table set
-	tables := make(map[string]bool)
+
+	// Depth of each included table, keyed case-insensitively
+	depths := make(map[string]int)
+	names := make(map[string]string)
 	for _, rel := range relations {
-		tables[rel.FromTable] = true
-		tables[rel.ToTable] = true
+		names[strings.ToLower(rel.FromTable)] = rel.FromTable
+		names[strings.ToLower(rel.ToTable)] = rel.ToTable
 	}
-	
-	// Create nodes
-	for table := range tables {
+	if startTable == "" {
+		for key := range names {
+			depths[key] = 0
+		}
+	} else {
+		start := strings.ToLower(startTable)
+		depths[start] = 0
+		if _, exists := names[start]; !exists {
+			names[start] = startTable
+		}
+		for hop := 1; hop <= depth; hop++ {
+			for _, rel := range relations {
+				from, to := strings.ToLower(rel.FromTable), strings.ToLower(rel.ToTable)
+				if d, ok := depths[from]; ok && d == hop-1 {
+					if _, seen := depths[to]; !seen {
+						depths[to] = hop
+					}
+				}
+				if d, ok := depths[to]; ok && d == hop-1 && followIncoming {
+					if _, seen := depths[from]; !seen {
+						depths[from] = hop
+					}
+				}
+			}
+		}
+	}
+
+	for key, d := range depths {
 		graph.Nodes = append(graph.Nodes, RelationNode{
-			Table: table,
+			Table: names[key],
 			Type:  "table",
+			Depth: d,
 		})
 	}
-	
-	// Create edges
+	sort.Slice(graph.Nodes, func(a, b int) bool {
+		if graph.Nodes[a].Depth != graph.Nodes[b].Depth {
+			return graph.Nodes[a].Depth < graph.Nodes[b].Depth
+		}
+		return graph.Nodes[a].Table < graph.Nodes[b].Table
+	})
+
+	// The same constraint can appear twice, e.g. as outgoing and incoming relation of a self reference
+	seen := make(map[string]bool)
 	for _, rel := range relations {
-		edge := RelationEdge{
-			From:    rel.FromTable,
-			To:      rel.ToTable,
-			Type:    rel.RelationType,
-			Columns: rel.FromColumns,
+		_, fromIncluded := depths[strings.ToLower(rel.FromTable)]
+		_, toIncluded := depths[strings.ToLower(rel.ToTable)]
+		key := strings.Join([]string{rel.FromTable, rel.ToTable, rel.ConstraintName}, "\x00")
+		if !fromIncluded || !toIncluded || seen[key] {
+			continue
 		}
-		graph.Edges = append(graph.Edges, edge)
+		seen[key] = true
+		graph.Edges = append(graph.Edges, RelationEdge{
+			From:       rel.FromTable,
+			To:         rel.ToTable,
+			Type:       rel.RelationType,
+			Columns:    rel.FromColumns,
+			ToColumns:  rel.ToColumns,
+			Constraint: rel.ConstraintName,
+		})
 	}
-	
+
 	return graph, nil
 }
 
@@ -1306,12 +1598,14 @@ func (i *DatasourceInspector) getTrinoAllRelations(ctx context.Context) ([]Relat
 	return i.getGenericAllRelations(ctx)
 }
 
+// ClickHouse has no foreign keys, and its information_schema has no constraint
+// views to query, so there are never relations to report
 func (i *DatasourceInspector) getClickHouseRelations(ctx context.Context, tableName string, includeReverse bool) ([]RelationInfo, error) {
-	return i.getGenericRelations(ctx, tableName, includeReverse)
+	return []RelationInfo{}, nil
 }
 
 func (i *DatasourceInspector) getClickHouseAllRelations(ctx context.Context) ([]RelationInfo, error) {
-	return i.getGenericAllRelations(ctx)
+	return []RelationInfo{}, nil
 }
 
 // getSQLServerTables retrieves tables from SQL Server
@@ -1400,11 +1694,66 @@ func (i *DatasourceInspector) getTrinoTables(ctx context.Context) ([]TableInfo,
 	return tables, nil
 }
 
-// getClickHouseTables retrieves tables from ClickHouse
+// ClickHouse catalog queries. system.tables lists every database on the server,
+// including system and INFORMATION_SCHEMA, so each is scoped to the current one.
+const (
+	clickHouseTablesQuery = `
+		SELECT name, engine
+		FROM system.tables
+		WHERE database = currentDatabase()
+		ORDER BY name`
+	clickHouseTableTypeQuery = `
+		SELECT engine
+		FROM system.tables
+		WHERE database = currentDatabase() AND name = ?`
+	clickHouseColumnsQuery = `
+		SELECT name, type, default_expression, is_in_primary_key
+		FROM system.columns
+		WHERE database = currentDatabase() AND table = ?
+		ORDER BY position`
+	// Row counts and sizes come from the active data parts rather than a
+	// COUNT(*), which would scan the whole table
+	clickHouseTableStatsQuery = `
+		SELECT sum(rows), sum(bytes_on_disk)
+		FROM system.parts
+		WHERE database = currentDatabase() AND table = ? AND active`
+)
+
+// clickHouseTableType maps a ClickHouse table engine to the generic table type
+func clickHouseTableType(engine string) string {
+	switch engine {
+	case "MaterializedView":
+		return "materialized_view"
+	case "View":
+		return "view"
+	default:
+		return "table"
+	}
+}
+
+// unwrapClickHouseType strips the LowCardinality and Nullable wrappers from a
+// ClickHouse column type, returning the base type and whether it is nullable.
+// Wrappers inside other types, such as Array(Nullable(String)), describe the
+// elements and are kept.
+func unwrapClickHouseType(columnType string) (string, bool) {
+	columnType = strings.TrimSpace(columnType)
+	nullable := false
+	for {
+		switch {
+		case strings.HasPrefix(columnType, "Nullable(") && strings.HasSuffix(columnType, ")"):
+			columnType = strings.TrimSpace(columnType[len("Nullable(") : len(columnType)-1])
+			nullable = true
+		case strings.HasPrefix(columnType, "LowCardinality(") && strings.HasSuffix(columnType, ")"):
+			columnType = strings.TrimSpace(columnType[len("LowCardinality(") : len(columnType)-1])
+		default:
+			return columnType, nullable
+		}
+	}
+}
+
+// getClickHouseTables retrieves the current database's tables from ClickHouse
 func (i *DatasourceInspector) getClickHouseTables(ctx context.Context) ([]TableInfo, error) {
-	query := `SELECT name, engine FROM system.tables ORDER BY name`
-	
-	rows, err := i.db.Query(ctx, query)
+	rows, err := i.db.Query(ctx, clickHouseTablesQuery)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query clickhouse tables: %w", err)
 	}
@@ -1418,8 +1767,9 @@ func (i *DatasourceInspector) getClickHouseTables(ctx context.Context) ([]TableI
 		}
 		
 		tables = append(tables, TableInfo{
-			Name: tableName,
-			Type: engine, // ClickHouse uses engine as table type
+			Name:       tableName,
+			Type:       clickHouseTableType(engine),
+			Properties: map[string]interface{}{"engine": engine},
 		})
 	}
 	
@@ -1455,30 +1805,63 @@ func (i *DatasourceInspector) getGenericTables(ctx context.Context) ([]TableInfo
 	return tables, nil
 }
 
-// detectDatabaseType tries to detect the database type from the connection
+// detectDatabaseType returns the database type given to the inspector, probing
+// the connection once when it was not known
 func (i *DatasourceInspector) detectDatabaseType(ctx context.Context) string {
-	// Try to detect database type by running version queries
-	if row := i.db.QueryRow(ctx, "SELECT version()"); row != nil {
-		var version string
-		if row.Scan(&version) == nil {
-			if strings.Contains(strings.ToLower(version), "postgresql") {
+	i.detectOnce.Do(func() {
+		if i.dbType == "" {
+			i.dbType = i.probeDatabaseType(ctx)
+		}
+	})
+	return i.dbType
+}
+
+// probeDatabaseType tries to detect the database type by running version queries
+func (i *DatasourceInspector) probeDatabaseType(ctx context.Context) string {
+	probes := []struct {
+		query  string
+		detect func(version string) string
+	}{
+		{"SELECT version()", func(version string) string {
+			switch {
+			case strings.Contains(version, "postgresql"):
 				return "postgresql"
-			} else if strings.Contains(strings.ToLower(version), "mysql") {
+			case strings.Contains(version, "mysql"), strings.Contains(version, "mariadb"):
 				return "mysql"
-			} else if strings.Contains(strings.ToLower(version), "clickhouse") {
+			case strings.Contains(version, "clickhouse"):
 				return "clickhouse"
 			}
-		}
+			return ""
+		}},
+		{"SELECT sqlite_version()", func(string) string { return "sqlite" }},
+		{"SELECT @@VERSION", func(version string) string {
+			if strings.Contains(version, "microsoft sql server") {
+				return "sqlserver"
+			}
+			return ""
+		}},
+		{"SELECT banner FROM v$version WHERE ROWNUM = 1", func(version string) string {
+			if strings.Contains(version, "oracle") {
+				return "oracle"
+			}
+			return ""
+		}},
 	}
-	
-	// Try SQLite detection
-	if row := i.db.QueryRow(ctx, "SELECT sqlite_version()"); row != nil {
+
+	for _, probe := range probes {
+		row := i.db.QueryRow(ctx, probe.query)
+		if row == nil {
+			continue
+		}
 		var version string
-		if row.Scan(&version) == nil {
-			return "sqlite"
+		if row.Scan(&version) != nil {
+			continue
+		}
+		if dbType := probe.detect(strings.ToLower(version)); dbType != "" {
+			return dbType
 		}
 	}
-	
+
 	// Default to generic SQL
 	return "sql"
 }
@@ -1501,6 +1884,18 @@ func (i *DatasourceInspector) getTableType(ctx context.Context, tableName string
 			WHERE table_name = ? AND table_schema NOT IN ('information_schema', 'performance_schema', 'mysql', 'sys')`
 	case "sqlite", "sqlite3":
 		query = `SELECT type FROM sqlite_master WHERE name = ?`
+	case "sqlserver", "mssql":
+		query = `
+			SELECT TABLE_TYPE
+			FROM INFORMATION_SCHEMA.TABLES
+			WHERE TABLE_NAME = @p1`
+	case "oracle":
+		query = `
+			SELECT LOWER(object_type)
+			FROM all_objects
+			WHERE object_name = :1 AND object_type IN ('TABLE', 'VIEW') AND ROWNUM = 1`
+	case "clickhouse":
+		query = clickHouseTableTypeQuery
 	default:
 		query = `
 			SELECT table_type 
@@ -1518,6 +1913,9 @@ func (i *DatasourceInspector) getTableType(ctx context.Context, tableName string
 	if err != nil {
 		return "table", nil // Default on error
 	}
+	if dbType == "clickhouse" {
+		return clickHouseTableType(tableType), nil
+	}
 	
 	return tableType, nil
 }
@@ -1525,6 +1923,9 @@ func (i *DatasourceInspector) getTableType(ctx context.Context, tableName string
 // getColumns retrieves column information for a table
 func (i *DatasourceInspector) getColumns(ctx context.Context, tableName string) ([]ColumnInfo, error) {
 	dbType := i.detectDatabaseType(ctx)
+	if dbType == "clickhouse" {
+		return i.getClickHouseColumns(ctx, tableName)
+	}
 	
 	var query string
 	switch dbType {
@@ -1541,13 +1942,19 @@ func (i *DatasourceInspector) getColumns(ctx context.Context, tableName string)
 			WHERE table_name = ? AND table_schema NOT IN ('information_schema', 'performance_schema', 'mysql', 'sys')
 			ORDER BY ordinal_position`
 	case "sqlite", "sqlite3":
-		query = `PRAGMA table_info(` + tableName + `)`
+		query = `SELECT cid, name, type, "notnull", dflt_value, pk FROM pragma_table_info(?)`
 	case "sqlserver", "mssql":
 		query = `
 			SELECT COLUMN_NAME, DATA_TYPE, IS_NULLABLE, COLUMN_DEFAULT
 			FROM INFORMATION_SCHEMA.COLUMNS 
-			WHERE TABLE_NAME = ?
+			WHERE TABLE_NAME = @p1
 			ORDER BY ORDINAL_POSITION`
+	case "oracle":
+		query = `
+			SELECT column_name, data_type, CASE nullable WHEN 'Y' THEN 'YES' ELSE 'NO' END, data_default
+			FROM all_tab_columns
+			WHERE table_name = :1
+			ORDER BY column_id`
 	default:
 		query = `
 			SELECT column_name, data_type, is_nullable, column_default
@@ -1583,7 +1990,7 @@ func (i *DatasourceInspector) getColumns(ctx context.Context, tableName string)
 				Type:         dataType,
 				Nullable:     notNull == 0,
 				DefaultValue: dfltValue,
-				PrimaryKey:   pk == 1,
+				PrimaryKey:   pk > 0, // pk is the column's position in a composite key
 			}
 		} else {
 			var name, dataType, nullable string
@@ -1605,6 +2012,11 @@ func (i *DatasourceInspector) getColumns(ctx context.Context, tableName string)
 		columns = append(columns, col)
 	}
 	
+	if dbType == "sqlite" || dbType == "sqlite3" {
+		// table_info already reports primary keys, including rowid aliases that have no index
+		return columns, nil
+	}
+
 	// Get primary key information
 	indexes, err := i.getIndexes(ctx, tableName)
 	if err == nil {
@@ -1625,146 +2037,234 @@ func (i *DatasourceInspector) getColumns(ctx context.Context, tableName string)
 	return columns, nil
 }
 
+// getClickHouseColumns reads a ClickHouse table's columns from system.columns.
+// Nullability comes from the Nullable wrapper rather than an is_nullable
+// column, and primary key membership from the sorting key.
+func (i *DatasourceInspector) getClickHouseColumns(ctx context.Context, tableName string) ([]ColumnInfo, error) {
+	rows, err := i.db.Query(ctx, clickHouseColumnsQuery, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var name, columnType, defaultExpression string
+		var inPrimaryKey int
+		if err := rows.Scan(&name, &columnType, &defaultExpression, &inPrimaryKey); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+
+		baseType, nullable := unwrapClickHouseType(columnType)
+		column := ColumnInfo{
+			Name:       name,
+			Type:       baseType,
+			Nullable:   nullable,
+			PrimaryKey: inPrimaryKey != 0,
+		}
+		if defaultExpression != "" {
+			column.DefaultValue = &defaultExpression
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
 // getIndexes retrieves index information for a table
 func (i *DatasourceInspector) getIndexes(ctx context.Context, tableName string) ([]IndexInfo, error) {
 	dbType := i.detectDatabaseType(ctx)
-	
-	var query string
+	if dbType == "sqlite" || dbType == "sqlite3" {
+		return i.getSQLiteIndexes(ctx, tableName)
+	}
+
+	query := indexQuery(dbType)
+	if query == "" {
+		// No index catalog we know how to read for this database
+		return []IndexInfo{}, nil
+	}
+
+	rows, err := i.db.Query(ctx, query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+	defer rows.Close()
+
+	indexes := []IndexInfo{}
+	for rows.Next() {
+		var name, columnsStr string
+		var unique, primary bool
+		if err := rows.Scan(&name, &columnsStr, &unique, &primary); err != nil {
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+
+		columns := strings.Split(columnsStr, ",")
+		for i, col := range columns {
+			columns[i] = strings.TrimSpace(col)
+		}
+
+		indexes = append(indexes, IndexInfo{
+			Name:    name,
+			Columns: columns,
+			Unique:  unique,
+			Primary: primary,
+		})
+	}
+
+	return indexes, rows.Err()
+}
+
+// indexQuery returns the catalog query listing a table's indexes as
+// (index name, comma-separated columns in key order, unique, primary) rows,
+// or "" when the dialect is not supported
+func indexQuery(dbType string) string {
 	switch dbType {
 	case "postgres", "postgresql":
-		query = `
-			SELECT 
-				i.relname as index_name,
-				array_agg(a.attname ORDER BY c.ordinality) as columns,
-				i.indisunique as unique,
-				i.indisprimary as primary
+		return `
+			SELECT
+				i_rel.relname AS index_name,
+				string_agg(a.attname, ',' ORDER BY c.ordinality) AS columns,
+				i.indisunique AS is_unique,
+				i.indisprimary AS is_primary
 			FROM pg_index i
 			JOIN pg_class t ON i.indrelid = t.oid
 			JOIN pg_class i_rel ON i.indexrelid = i_rel.oid
 			JOIN unnest(i.indkey) WITH ORDINALITY c(colnum, ordinality) ON true
 			JOIN pg_attribute a ON a.attnum = c.colnum AND a.attrelid = t.oid
 			WHERE t.relname = $1
-			GROUP BY i.indexrelid, i.relname, i.indisunique, i.indisprimary`
+			GROUP BY i.indexrelid, i_rel.relname, i.indisunique, i.indisprimary
+			ORDER BY i_rel.relname`
 	case "mysql":
-		query = `
-			SELECT 
-				INDEX_NAME as index_name,
-				GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX) as columns,
-				NON_UNIQUE = 0 as unique,
-				INDEX_NAME = 'PRIMARY' as primary
-			FROM INFORMATION_SCHEMA.STATISTICS 
-			WHERE TABLE_NAME = ?
-			GROUP BY INDEX_NAME, NON_UNIQUE`
-	case "sqlite", "sqlite3":
-		query = `PRAGMA index_list(` + tableName + `)`
+		// STATISTICS has one row per index column; NON_UNIQUE is 0 for unique indexes
+		return `
+			SELECT
+				INDEX_NAME AS ` + "`index_name`" + `,
+				GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX SEPARATOR ',') AS ` + "`columns`" + `,
+				MAX(NON_UNIQUE) = 0 AS ` + "`unique`" + `,
+				INDEX_NAME = 'PRIMARY' AS ` + "`primary`" + `
+			FROM INFORMATION_SCHEMA.STATISTICS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+			GROUP BY INDEX_NAME
+			ORDER BY INDEX_NAME`
+	case "sqlserver", "mssql":
+		// Included columns are not part of the key, and heaps have an unnamed index 0
+		return `
+			SELECT
+				i.name AS index_name,
+				STRING_AGG(c.name, ',') WITHIN GROUP (ORDER BY ic.key_ordinal) AS columns,
+				i.is_unique,
+				i.is_primary_key
+			FROM sys.indexes i
+			JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+			JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+			WHERE i.object_id = OBJECT_ID(@p1) AND i.name IS NOT NULL AND ic.is_included_column = 0
+			GROUP BY i.name, i.is_unique, i.is_primary_key
+			ORDER BY i.name`
 	default:
-		// Generic approach for other databases
-		query = `
-			SELECT 
-				INDEX_NAME,
-				COLUMN_NAME,
-				UNIQUE,
-				PRIMARY_KEY
-			FROM INFORMATION_SCHEMA.INDEXES 
-			WHERE TABLE_NAME = ?`
+		return ""
 	}
-	
-	if dbType == "sqlite" || dbType == "sqlite3" {
-		// Handle SQLite PRAGMA index_list
-		rows, err := i.db.Query(ctx, query)
-		if err != nil {
-			return nil, fmt.Errorf("failed to query indexes: %w", err)
+}
+
+// getSQLiteIndexes lists a SQLite table's indexes. Primary keys come from the
+// index origin, and an INTEGER PRIMARY KEY, which aliases the rowid and has no
+// index of its own, is reported as a primary index named "rowid".
+func (i *DatasourceInspector) getSQLiteIndexes(ctx context.Context, tableName string) ([]IndexInfo, error) {
+	rows, err := i.db.Query(ctx, `SELECT name, "unique", origin FROM pragma_index_list(?)`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+
+	indexes := []IndexInfo{}
+	hasPrimary := false
+	for rows.Next() {
+		var index IndexInfo
+		var origin string
+		if err := rows.Scan(&index.Name, &index.Unique, &origin); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan index: %w", err)
 		}
-		defer rows.Close()
-		
-		var indexes []IndexInfo
-		for rows.Next() {
-			var seq int
-			var name string
-			var unique int
-			var origin string
-			var partial int
-			
-			if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
-				continue
-			}
-			
-			// Get columns for this index
-			columnQuery := `PRAGMA index_info(` + name + `)`
-			colRows, err := i.db.Query(ctx, columnQuery)
-			if err != nil {
-				continue
-			}
-			
-			var columns []string
-			for colRows.Next() {
-				var cid, nid int
-				var colName string
-				if colRows.Scan(&cid, &colName, &nid) == nil {
-					columns = append(columns, colName)
-				}
-			}
-			colRows.Close()
-			
-			indexes = append(indexes, IndexInfo{
-				Name:    name,
-				Columns: columns,
-				Unique:  unique != 0,
-				Primary: name == "sqlite_autoindex_" + tableName + "_1",
-			})
+		index.Primary = origin == "pk"
+		hasPrimary = hasPrimary || index.Primary
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query indexes: %w", err)
+	}
+
+	for n := range indexes {
+		columns, err := i.sqliteIndexColumns(ctx, indexes[n].Name)
+		if err != nil {
+			return nil, err
 		}
-		
-		return indexes, nil
+		indexes[n].Columns = columns
 	}
-	
-	// Handle standard SQL databases
-	if strings.Contains(strings.ToUpper(query), "GROUP_CONCAT") {
-		// MySQL format
-		rows, err := i.db.Query(ctx, query, tableName)
+
+	if !hasPrimary {
+		pkColumns, err := i.sqlitePrimaryKey(ctx, tableName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to query indexes: %w", err)
+			return nil, err
 		}
-		defer rows.Close()
-		
-		var indexes []IndexInfo
-		for rows.Next() {
-			var name, columnsStr string
-			var unique, primary bool
-			
-			if err := rows.Scan(&name, &columnsStr, &unique, &primary); err != nil {
-				continue
-			}
-			
-			columns := strings.Split(columnsStr, ",")
-			for i, col := range columns {
-				columns[i] = strings.TrimSpace(col)
-			}
-			
+		if len(pkColumns) > 0 {
 			indexes = append(indexes, IndexInfo{
-				Name:    name,
-				Columns: columns,
-				Unique:  unique,
-				Primary: primary,
+				Name:    "rowid",
+				Columns: pkColumns,
+				Unique:  true,
+				Primary: true,
+				Type:    "rowid",
 			})
 		}
-		
-		return indexes, nil
 	}
-	
-	// Generic fallback
-	rows, err := i.db.Query(ctx, query, tableName)
+
+	return indexes, nil
+}
+
+// sqliteIndexColumns returns the key columns of a SQLite index; expression columns are skipped
+func (i *DatasourceInspector) sqliteIndexColumns(ctx context.Context, indexName string) ([]string, error) {
+	rows, err := i.db.Query(ctx, `SELECT name FROM pragma_index_info(?) ORDER BY seqno`, indexName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query indexes: %w", err)
+		return nil, fmt.Errorf("failed to query index columns: %w", err)
 	}
 	defer rows.Close()
-	
-	// For now, return empty indexes for other database types
-	return []IndexInfo{}, nil
+
+	columns := []string{}
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan index column: %w", err)
+		}
+		if name.Valid {
+			columns = append(columns, name.String)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// sqlitePrimaryKey returns a SQLite table's primary key columns in key order
+func (i *DatasourceInspector) sqlitePrimaryKey(ctx context.Context, tableName string) ([]string, error) {
+	rows, err := i.db.Query(ctx, `SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query primary key: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan primary key: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
 }
 
 // getTableStats retrieves table statistics like row count and size
 func (i *DatasourceInspector) getTableStats(ctx context.Context, tableInfo *TableInfo) error {
 	dbType := i.detectDatabaseType(ctx)
+	if dbType == "clickhouse" {
+		return i.getClickHouseTableStats(ctx, tableInfo)
+	}
 	
 	// Get row count
 	var countQuery string
@@ -1805,18 +2305,51 @@ func (i *DatasourceInspector) getTableStats(ctx context.Context, tableInfo *Tabl
 	return nil
 }
 
+// getClickHouseTableStats sums the rows and on-disk bytes of a ClickHouse
+// table's active parts. Views have no parts and report zero.
+func (i *DatasourceInspector) getClickHouseTableStats(ctx context.Context, tableInfo *TableInfo) error {
+	row := i.db.QueryRow(ctx, clickHouseTableStatsQuery, tableInfo.Name)
+	if row == nil {
+		return nil
+	}
+
+	var rowCount, sizeBytes sql.NullInt64
+	if err := row.Scan(&rowCount, &sizeBytes); err != nil {
+		return fmt.Errorf("failed to query table parts: %w", err)
+	}
+	tableInfo.RowCount = rowCount.Int64
+	tableInfo.SizeBytes = sizeBytes.Int64
+	return nil
+}
+
 // DatasourceInspectTool inspects database schemas and metadata
 type DatasourceInspectTool struct {
-	zdb *db.Database
+	zdb         *db.Database
+	permissions PermissionChecker
+	systemDB    DBConnection // System database for calls without a datasource_id; nil refuses them
+	schemaCache *SchemaCache // Serves inspections without statistics or relations; nil reads the datasource every time
 }
 
 // NewDatasourceInspectTool creates a new datasource inspection tool
-func NewDatasourceInspectTool(zdb *db.Database) *DatasourceInspectTool {
+func NewDatasourceInspectTool(zdb *db.Database, permissions PermissionChecker) *DatasourceInspectTool {
 	return &DatasourceInspectTool{
-		zdb: zdb,
+		zdb:         zdb,
+		permissions: permissions,
 	}
 }
 
+// AllowSystemDatabase lets calls without a datasource_id inspect the
+// allowlisted tables of the application database through conn
+func (t *DatasourceInspectTool) AllowSystemDatabase(conn DBConnection) {
+	t.systemDB = conn
+}
+
+// UseSchemaCache serves inspections that need neither statistics nor
+// relations from cache, shared with the datasource metadata endpoints
+func (t *DatasourceInspectTool) UseSchemaCache(cache *SchemaCache) {
+	t.schemaCache = cache
+}
+
 // Name returns tool name
 func (t *DatasourceInspectTool) Name() string {
 	return "datasource_inspect"
@@ -1832,7 +2365,7 @@ func (t *DatasourceInspectTool) Parameters() map[string]ToolParameter {
 	return map[string]ToolParameter{
 		"datasource_id": {
 			Type:        "string",
-			Description: "ID of the datasource to inspect (optional, inspects default if not provided)",
+			Description: "ID or name of the datasource to inspect; defaults to the project's default datasource",
 			Required:    false,
 		},
 		"table_name": {
@@ -1881,7 +2414,8 @@ func (t *DatasourceInspectTool) Parameters() map[string]ToolParameter {
 
 // ValidateAccess checks if user has access to this tool
 func (t *DatasourceInspectTool) ValidateAccess(userID, projectID string) bool {
-	return true
+	// Inspection is read-only, so any project viewer may use it
+	return hasProjectRole(t.permissions, userID, projectID, RoleViewer)
 }
 
 // GetCategory returns the tool category
@@ -1894,7 +2428,7 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 	startTime := time.Now()
 
 	// Get parameters
-	datasourceID, _ := params["datasource_id"].(string)
+	datasourceRef, _ := params["datasource_id"].(string)
 	tableName, _ := params["table_name"].(string)
 	includeStats, hasStats := params["include_stats"].(bool)
 	if !hasStats {
@@ -1932,25 +2466,60 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 		includeReverseRelations = true // Default to true
 	}
 
+	datasourceID, failed := resolveDatasourceID(ctx, t.zdb, datasourceRef)
+	if failed != nil {
+		return failed, nil
+	}
+
+	// Without a datasource the system database is inspected, if that is allowed at all
+	systemDB := datasourceID == ""
+	if systemDB {
+		if t.systemDB == nil {
+			return NewToolErrorWithCode(ErrCodeDatasourceRequired, "datasource_id is required", nil), nil
+		}
+		if tableName != "" {
+			if err := checkSystemTable(tableName); err != nil {
+				return err.toolResult(), nil
+			}
+		}
+	}
+
+	// Tables the datasource's query policy refuses are hidden from listings
+	policy, err := loadQueryPolicy(ctx, t.zdb, datasourceID)
+	if err != nil {
+		return NewToolError("Failed to load the datasource's query policy", err), nil
+	}
+	if tableName != "" {
+		if violation := policy.CheckTable(tableName); violation != nil {
+			return violation.toolResult(), nil
+		}
+	}
+
 	// Create context with timeout
 	inspectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	if t.schemaCache != nil && !systemDB && !includeStats && !includeRelations {
+		if result, ok := t.cachedInspection(inspectCtx, datasourceID, tableName, policy, includeColumns, includeIndexes); ok {
+			return NewToolSuccess(result, int(time.Since(startTime).Milliseconds())), nil
+		}
+	}
+
 	// Get datasource connection
 	dbConn, err := t.getDatasourceConnection(inspectCtx, datasourceID)
 	if err != nil {
 		return NewToolError("Failed to get datasource connection", err), nil
 	}
 
-	// Create inspector
-	inspector := NewDatasourceInspector(dbConn)
-
 	// Get datasource type for inspection
 	datasourceType, err := t.getDatasourceType(inspectCtx, datasourceID)
 	if err != nil {
 		return NewToolError("Failed to determine datasource type", err), nil
 	}
 
+	// Create inspector; the known type saves probing the connection
+	inspector := NewDatasourceInspector(dbConn, datasourceType)
+
 	if tableName != "" {
 		// Inspect specific table
 		tableInfo, err := inspector.InspectTable(inspectCtx, tableName, includeStats)
@@ -1978,7 +2547,20 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 			if err != nil {
 				result["relations_error"] = err.Error()
 			} else {
-				result["relations"] = relations
+				if systemDB {
+					relations = systemReadableRelations(relations)
+				}
+				result["relations"] = policy.filterRelations(relations)
+			}
+
+			// Follow relations beyond the table's own when a deeper graph is asked for
+			if relationsDepth > 1 {
+				graph, err := t.tableRelationGraph(inspectCtx, inspector, datasourceType, tableName, relationsDepth, includeReverseRelations, systemDB, policy)
+				if err != nil {
+					result["graph_error"] = err.Error()
+				} else {
+					result["relation_graph"] = graph
+				}
 			}
 		}
 
@@ -1989,6 +2571,13 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 		if err != nil {
 			return NewToolError("Failed to inspect datasource", err), nil
 		}
+		if systemDB {
+			datasourceInfo.Tables = systemReadableTableInfos(datasourceInfo.Tables)
+			datasourceInfo.TableCount = len(datasourceInfo.Tables)
+		} else if policy != nil {
+			datasourceInfo.Tables = policy.filterTables(datasourceInfo.Tables)
+			datasourceInfo.TableCount = len(datasourceInfo.Tables)
+		}
 
 		// Get detailed table information if requested
 		if includeColumns || includeIndexes || includeStats {
@@ -2017,11 +2606,15 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 			if err != nil {
 				datasourceInfo.Properties["relations_error"] = err.Error()
 			} else {
+				if systemDB {
+					relations = systemReadableRelations(relations)
+				}
+				relations = policy.filterRelations(relations)
 				datasourceInfo.Relations = relations
 
 				// Build relation graph if depth > 1
 				if relationsDepth > 1 {
-					graph, err := inspector.buildRelationGraph(inspectCtx, relations, relationsDepth)
+					graph, err := inspector.buildRelationGraph(inspectCtx, relations, "", relationsDepth, true)
 					if err != nil {
 						datasourceInfo.Properties["graph_error"] = err.Error()
 					} else {
@@ -2038,24 +2631,84 @@ func (t *DatasourceInspectTool) Execute(ctx context.Context, params map[string]i
 	}
 }
 
+// cachedInspection answers from the schema cache, with the same shape as an
+// inspection of the datasource. It declines tables the cache does not know,
+// which may have been created since it was read, and failed reads.
+func (t *DatasourceInspectTool) cachedInspection(ctx context.Context, datasourceID, tableName string, policy *QueryPolicy, includeColumns, includeIndexes bool) (map[string]interface{}, bool) {
+	schema, err := t.schemaCache.Get(ctx, datasourceID)
+	if err != nil {
+		return nil, false
+	}
+	trim := func(table TableInfo) TableInfo {
+		if !includeColumns {
+			table.Columns = nil
+		}
+		if !includeIndexes {
+			table.Indexes = nil
+		}
+		return table
+	}
+
+	if tableName != "" {
+		table, found := schema.Table(tableName)
+		if !found {
+			return nil, false
+		}
+		trimmed := trim(*table)
+		return map[string]interface{}{
+			"datasource_id":   datasourceID,
+			"datasource_type": schema.Type,
+			"table":           &trimmed,
+		}, true
+	}
+
+	allowed := policy.filterTables(schema.Tables)
+	tables := make([]TableInfo, len(allowed))
+	for i, table := range allowed {
+		tables[i] = trim(table)
+	}
+	return map[string]interface{}{
+		"datasource_id": datasourceID,
+		"datasource": &DatasourceInfo{
+			Type:       schema.Type,
+			Status:     "connected",
+			TableCount: len(tables),
+			Tables:     tables,
+		},
+	}, true
+}
+
+// tableRelationGraph builds the graph of tables within depth relations of tableName
+func (t *DatasourceInspectTool) tableRelationGraph(ctx context.Context, inspector *DatasourceInspector, datasourceType, tableName string, depth int, includeReverse, systemDB bool, policy *QueryPolicy) (*RelationGraph, error) {
+	relations, err := inspector.getAllRelations(ctx, datasourceType)
+	if err != nil {
+		return nil, err
+	}
+	if systemDB {
+		relations = systemReadableRelations(relations)
+	}
+	relations = policy.filterRelations(relations)
+	return inspector.buildRelationGraph(ctx, relations, tableName, depth, includeReverse)
+}
+
 func (t *DatasourceInspectTool) getDatasourceConnection(ctx context.Context, datasourceID string) (DBConnection, error) {
 	// Reuse database tool's connection logic
-	dbTool := &DatabaseQueryTool{zdb: t.zdb}
+	dbTool := &DatabaseQueryTool{db: t.systemDB, zdb: t.zdb}
 	return dbTool.getDatasourceConnection(ctx, datasourceID)
 }
 
 func (t *DatasourceInspectTool) getDatasourceType(ctx context.Context, datasourceID string) (string, error) {
-	// If no datasource ID, default to postgres
+	// Without a datasource ID the system database is probed for its type
 	if datasourceID == "" {
-		return "postgresql", nil
+		return NewDatasourceInspector(t.systemDB, "").detectDatabaseType(ctx), nil
 	}
 
-	// Get datasource type from database
+	// Get datasource type from database, only of the calling project
 	row, err := t.zdb.QueryRow(ctx,
 		`SELECT d.type FROM datasources d 
 		 JOIN projects p ON d.project_id = p.id 
-		 WHERE d.id = $1 AND d.is_active = true AND p.is_active = true`,
-		datasourceID)
+		 WHERE d.id = $1 AND d.project_id = $2 AND d.is_active = true AND p.is_active = true`,
+		datasourceID, executionProjectID(ctx))
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch datasource: %w", err)
 	}
@@ -2087,6 +2740,7 @@ type RelationInfo struct {
 	ConstraintName string   `json:"constraint_name"`
 	OnDeleteAction string   `json:"on_delete_action,omitempty"`
 	OnUpdateAction string   `json:"on_update_action,omitempty"`
+	Direction      string   `json:"direction,omitempty"` // outgoing or incoming in the relations of one table
 }
 
 // RelationGraph represents relationships as a graph
@@ -2097,35 +2751,45 @@ type RelationGraph struct {
 
 // RelationNode represents a table in the relation graph
 type RelationNode struct {
-	Table    string `json:"table"`
-	Type     string `json:"type"`
-	Columns  []string `json:"columns,omitempty"`
+	Table   string   `json:"table"`
+	Type    string   `json:"type"`
+	Columns []string `json:"columns,omitempty"`
+	Depth   int      `json:"depth"` // Hops from the inspected table; 0 in datasource-wide graphs
 }
 
 // RelationEdge represents a relationship between tables
 type RelationEdge struct {
-	From    string   `json:"from"`
-	To      string   `json:"to"`
-	Type     string   `json:"type"`
-	Columns  []string `json:"columns"`
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns"`
+	ToColumns  []string `json:"to_columns,omitempty"`
+	Constraint string   `json:"constraint,omitempty"`
 }
 
+// Query, QueryRow and Exec go through the database's query guard (timeout and slow-query log)
+
 func (z *ZlayDBAdapter) Query(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
-	return z.DB.GetDB().QueryContext(ctx, sql, args...)
+	return z.DB.QueryContext(ctx, sql, args...)
 }
 
 func (z *ZlayDBAdapter) QueryRow(ctx context.Context, sql string, args ...interface{}) *sql.Row {
-	return z.DB.GetDB().QueryRowContext(ctx, sql, args...)
+	return z.DB.QueryRowContext(ctx, sql, args...)
 }
 
 func (z *ZlayDBAdapter) Exec(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
-	return z.DB.GetDB().ExecContext(ctx, sql, args...)
+	return z.DB.ExecContext(ctx, sql, args...)
 }
 
 func (z *ZlayDBAdapter) Begin(ctx context.Context) (*sql.Tx, error) {
 	return z.DB.GetDB().BeginTx(ctx, nil)
 }
 
+// Close closes the underlying database; only for connections opened to a datasource
+func (z *ZlayDBAdapter) Close() error {
+	return z.DB.Close()
+}
+
 // WebSocketAdapter adapts WebSocket hub to interface
 type WebSocketAdapter struct {
 	Hub interface{} // The actual hub instance
@@ -2143,6 +2807,26 @@ func (w *WebSocketAdapter) BroadcastToProject(projectID string, message interfac
 	}
 }
 
+// SendToConnectionID sends a message to a single connection by ID
+func (w *WebSocketAdapter) SendToConnectionID(connectionID string, message interface{}) bool {
+	if hub, ok := w.Hub.(interface {
+		SendToConnectionID(string, interface{}) bool
+	}); ok {
+		return hub.SendToConnectionID(connectionID, message)
+	}
+	return false
+}
+
+// SendToUser sends a message to all of a user's connections in a project
+func (w *WebSocketAdapter) SendToUser(projectID, userID string, message interface{}) int {
+	if hub, ok := w.Hub.(interface {
+		SendToUser(string, string, interface{}) int
+	}); ok {
+		return hub.SendToUser(projectID, userID, message)
+	}
+	return 0
+}
+
 func (w *WebSocketAdapter) SendToConnection(conn interface{}, message interface{}) {
 	// Use reflection to call the SendToConnection method
 	hubValue := reflect.ValueOf(w.Hub)
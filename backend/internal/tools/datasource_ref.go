@@ -0,0 +1,137 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/db"
+)
+
+// Error codes of datasource_id values that do not name exactly one datasource
+const (
+	ErrCodeDatasourceNotFound  = "DATASOURCE_NOT_FOUND"
+	ErrCodeDatasourceAmbiguous = "DATASOURCE_AMBIGUOUS"
+)
+
+// resolveDatasourceID turns the datasource_id parameter of a tool call into a
+// datasource ID. A UUID must be an active datasource of the calling project,
+// anything else is looked up by name among them, case-insensitively, and an
+// empty reference falls back to the project's default datasource. It returns
+// "" when the project has no default, leaving the system database fallback to
+// the caller. References that match no or several datasources give a failed
+// tool result listing the candidates so the model can retry.
+func resolveDatasourceID(ctx context.Context, zdb *db.Database, ref string) (string, *ToolResult) {
+	execCtx, _ := ExecutionContextFrom(ctx)
+	if zdb == nil {
+		return ref, nil
+	}
+
+	if _, err := uuid.Parse(ref); err == nil {
+		_, err := zdb.QueryRow(ctx,
+			"SELECT id FROM datasources WHERE id = $1 AND project_id = $2 AND is_active = true",
+			ref, execCtx.ProjectID)
+		if errors.Is(err, db.ErrNoRows) {
+			// Datasources of other projects are treated as if they did not exist
+			return "", datasourceNotFound(ctx, zdb, execCtx.ProjectID, fmt.Sprintf("no datasource %s in this project", ref))
+		}
+		if err != nil {
+			return "", NewToolError("Failed to look up datasources", err)
+		}
+		return ref, nil
+	}
+
+	if execCtx.ProjectID == "" {
+		// Outside a project there are no names to resolve
+		return ref, nil
+	}
+
+	if ref == "" {
+		row, err := zdb.QueryRow(ctx,
+			"SELECT default_datasource_id FROM projects WHERE id = $1 AND is_active = true", execCtx.ProjectID)
+		if errors.Is(err, db.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			return "", NewToolError("Failed to look up the project's default datasource", err)
+		}
+		defaultID, _ := row.Values[0].AsString()
+		return defaultID, nil
+	}
+
+	matches, err := projectDatasources(ctx, zdb, execCtx.ProjectID, ref)
+	if err != nil {
+		return "", NewToolError("Failed to look up datasources", err)
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0]["id"].(string), nil
+	case 0:
+		return "", datasourceNotFound(ctx, zdb, execCtx.ProjectID, fmt.Sprintf("no datasource named %q in this project", ref))
+	default:
+		return "", NewToolErrorWithCode(ErrCodeDatasourceAmbiguous,
+			fmt.Sprintf("%d datasources are named %q; pass one of their IDs instead", len(matches), ref),
+			map[string]interface{}{"candidates": matches})
+	}
+}
+
+// datasourceNotFound is the failed tool result of a reference to no datasource
+// of the project, listing its active datasources as candidates
+func datasourceNotFound(ctx context.Context, zdb *db.Database, projectID, message string) *ToolResult {
+	candidates, err := projectDatasources(ctx, zdb, projectID, "")
+	if err != nil {
+		return NewToolError("Failed to look up datasources", err)
+	}
+	return NewToolErrorWithCode(ErrCodeDatasourceNotFound, message, map[string]interface{}{"candidates": candidates})
+}
+
+// executionProjectID returns the project whose datasources a tool call may
+// reach; none outside of a project
+func executionProjectID(ctx context.Context) string {
+	execCtx, _ := ExecutionContextFrom(ctx)
+	return execCtx.ProjectID
+}
+
+// withDatasourceProject returns ctx running in the project that owns the
+// datasource, for background work such as schema snapshots that opens
+// datasources outside of a tool call. A ctx already running in a project is
+// returned as is, so it still only reaches that project's datasources.
+func withDatasourceProject(ctx context.Context, zdb *db.Database, datasourceID string) (context.Context, error) {
+	if execCtx, _ := ExecutionContextFrom(ctx); execCtx.ProjectID != "" {
+		return ctx, nil
+	}
+	row, err := zdb.QueryRow(ctx, "SELECT project_id FROM datasources WHERE id = $1", datasourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch datasource: %w", err)
+	}
+	projectID, _ := row.Values[0].AsString()
+	return WithExecutionContext(ctx, "", projectID), nil
+}
+
+// projectDatasources lists the ID, name and type of the project's active
+// datasources, only those called name when it is not empty
+func projectDatasources(ctx context.Context, zdb *db.Database, projectID, name string) ([]map[string]interface{}, error) {
+	query := "SELECT id, name, type FROM datasources WHERE project_id = $1 AND is_active = true"
+	args := []interface{}{projectID}
+	if name != "" {
+		query += " AND LOWER(name) = LOWER($2)"
+		args = append(args, name)
+	}
+	resultSet, err := zdb.Query(ctx, query+" ORDER BY name, id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	datasources := []map[string]interface{}{}
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 3 {
+			continue
+		}
+		id, _ := row.Values[0].AsString()
+		name, _ := row.Values[1].AsString()
+		dsType, _ := row.Values[2].AsString()
+		datasources = append(datasources, map[string]interface{}{"id": id, "name": name, "type": dsType})
+	}
+	return datasources, nil
+}
@@ -0,0 +1,225 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultDigestSampleRows is how many leading rows a digest keeps verbatim
+	DefaultDigestSampleRows = 10
+
+	// Column types reported in a digest
+	ColumnTypeNumber  = "number"
+	ColumnTypeText    = "text"
+	ColumnTypeBoolean = "boolean"
+	ColumnTypeMixed   = "mixed"
+	ColumnTypeNull    = "null" // Every value is NULL
+)
+
+// ResultDigest stands in for a query result too large to hand to the LLM
+type ResultDigest struct {
+	RowCount   int                      `json:"row_count"`
+	Columns    []ColumnDigest           `json:"columns"`
+	SampleRows []map[string]interface{} `json:"sample_rows"`
+	Note       string                   `json:"note"`
+}
+
+// ColumnDigest describes one column of a digested result. Min, Max and Mean
+// are computed over every row, skipping NULLs, for number columns only.
+type ColumnDigest struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Nulls int      `json:"nulls,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Mean  *float64 `json:"mean,omitempty"`
+}
+
+// DigestRows summarizes a query result: its row count, each column's type and
+// numeric statistics, and the first sampleRows rows. Columns missing from
+// columns but present in the rows are added in name order.
+func DigestRows(columns []string, rows []map[string]interface{}, sampleRows int) ResultDigest {
+	if sampleRows < 0 {
+		sampleRows = 0
+	}
+	columns = digestColumnNames(columns, rows)
+
+	digest := ResultDigest{
+		RowCount:   len(rows),
+		Columns:    make([]ColumnDigest, 0, len(columns)),
+		SampleRows: append([]map[string]interface{}{}, rows[:min(sampleRows, len(rows))]...),
+	}
+	for _, name := range columns {
+		digest.Columns = append(digest.Columns, digestColumn(name, rows))
+	}
+	if len(rows) > len(digest.SampleRows) {
+		digest.Note = fmt.Sprintf("Only the first %d of %d rows are shown; min, max and mean cover every row. "+
+			"Issue a narrower query, with filters, aggregates or a LIMIT, to see specific rows.",
+			len(digest.SampleRows), len(rows))
+	} else {
+		digest.Note = "The result was too large to include as is. Issue a narrower query, with fewer columns or a LIMIT, to see it in full."
+	}
+	return digest
+}
+
+// DigestToolResult replaces every table in a marshaled tool result, any object
+// with "columns" and "rows", by its digest and keeps the rest of the result.
+// It reports false when the result holds no table, in which case the caller
+// has to shorten it some other way.
+func DigestToolResult(raw []byte, sampleRows int) ([]byte, bool) {
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, false
+	}
+	result, found := digestTables(result, sampleRows)
+	if !found {
+		return nil, false
+	}
+	digested, err := json.Marshal(result)
+	if err != nil {
+		return nil, false
+	}
+	return digested, true
+}
+
+// digestTables walks a decoded JSON value and replaces the tables in it
+func digestTables(value interface{}, sampleRows int) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if columns, rows, ok := decodeTable(v); ok {
+			digested := make(map[string]interface{}, len(v))
+			for key, field := range v {
+				if key != "columns" && key != "rows" {
+					digested[key] = field
+				}
+			}
+			digested["digest"] = DigestRows(columns, rows, sampleRows)
+			return digested, true
+		}
+		found := false
+		for key, field := range v {
+			var ok bool
+			if v[key], ok = digestTables(field, sampleRows); ok {
+				found = true
+			}
+		}
+		return v, found
+	case []interface{}:
+		found := false
+		for i, item := range v {
+			var ok bool
+			if v[i], ok = digestTables(item, sampleRows); ok {
+				found = true
+			}
+		}
+		return v, found
+	}
+	return value, false
+}
+
+// decodeTable reads the columns and rows of a decoded query result
+func decodeTable(value map[string]interface{}) ([]string, []map[string]interface{}, bool) {
+	rawRows, ok := value["rows"].([]interface{})
+	if !ok {
+		return nil, nil, false
+	}
+	rawColumns, ok := value["columns"].([]interface{})
+	if !ok {
+		return nil, nil, false
+	}
+
+	columns := make([]string, 0, len(rawColumns))
+	for _, column := range rawColumns {
+		name, ok := column.(string)
+		if !ok {
+			return nil, nil, false
+		}
+		columns = append(columns, name)
+	}
+	rows := make([]map[string]interface{}, 0, len(rawRows))
+	for _, rawRow := range rawRows {
+		row, ok := rawRow.(map[string]interface{})
+		if !ok {
+			return nil, nil, false
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows, true
+}
+
+// digestColumnNames returns columns followed by any other keys found in rows
+func digestColumnNames(columns []string, rows []map[string]interface{}) []string {
+	known := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		known[name] = true
+	}
+	var extra []string
+	for _, row := range rows {
+		for name := range row {
+			if !known[name] {
+				known[name] = true
+				extra = append(extra, name)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns[:len(columns):len(columns)], extra...)
+}
+
+// digestColumn infers a column's type and computes its statistics
+func digestColumn(name string, rows []map[string]interface{}) ColumnDigest {
+	column := ColumnDigest{Name: name, Type: ColumnTypeNull}
+	var sum float64
+	var numbers int
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+
+	for _, row := range rows {
+		var kind string
+		switch v := row[name].(type) {
+		case nil:
+			column.Nulls++
+			continue
+		case float64, int, int64:
+			kind = ColumnTypeNumber
+			number := digestNumber(v)
+			sum += number
+			numbers++
+			minValue = math.Min(minValue, number)
+			maxValue = math.Max(maxValue, number)
+		case bool:
+			kind = ColumnTypeBoolean
+		case string:
+			kind = ColumnTypeText
+		default:
+			kind = ColumnTypeMixed
+		}
+
+		switch column.Type {
+		case ColumnTypeNull:
+			column.Type = kind
+		case kind:
+		default:
+			column.Type = ColumnTypeMixed
+		}
+	}
+
+	if column.Type == ColumnTypeNumber && numbers > 0 {
+		mean := sum / float64(numbers)
+		column.Min, column.Max, column.Mean = &minValue, &maxValue, &mean
+	}
+	return column
+}
+
+// digestNumber converts the numeric values found in query results to float64
+func digestNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value.(float64)
+}
@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultToolTimeout bounds a tool execution, including time spent waiting for a slot
+	DefaultToolTimeout = 60 * time.Second
+	// DefaultToolMaxConcurrent is how many executions of one tool may run at once
+	DefaultToolMaxConcurrent = 8
+
+	// ToolStatusTimeout is the ToolResult status of an execution cut off by its timeout
+	ToolStatusTimeout = "timeout"
+	// ToolStatusCancelled is the ToolResult status of an execution cancelled by CancelToolExecution
+	ToolStatusCancelled = "cancelled"
+)
+
+var (
+	// ErrToolTimeout reports a ToolStatusTimeout result as an error
+	ErrToolTimeout = errors.New("tool execution timed out")
+	// ErrToolCancelled reports a ToolStatusCancelled result as an error
+	ErrToolCancelled = errors.New("tool execution cancelled")
+)
+
+// ToolConfig limits how a tool runs; zero values use the defaults
+type ToolConfig struct {
+	Timeout       time.Duration
+	MaxConcurrent int
+}
+
+func (c ToolConfig) withDefaults() ToolConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultToolTimeout
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = DefaultToolMaxConcurrent
+	}
+	return c
+}
+
+// toolLimits holds a registered tool's config and its in-flight semaphore
+type toolLimits struct {
+	config ToolConfig
+	slots  chan struct{}
+}
+
+func newToolLimits(config ToolConfig) *toolLimits {
+	config = config.withDefaults()
+	return &toolLimits{config: config, slots: make(chan struct{}, config.MaxConcurrent)}
+}
+
+// toolExecution is an in-flight execution that CancelToolExecution can stop
+type toolExecution struct {
+	conversationID string
+	cancel         context.CancelFunc
+}
+
+type toolCallKey struct{}
+
+// WithToolCall attaches the tool call ID an execution belongs to, so it can be cancelled
+func WithToolCall(ctx context.Context, toolCallID string) context.Context {
+	return context.WithValue(ctx, toolCallKey{}, toolCallID)
+}
+
+// ToolCallFrom returns the tool call ID attached by the chat service, if any
+func ToolCallFrom(ctx context.Context) (string, bool) {
+	toolCallID, ok := ctx.Value(toolCallKey{}).(string)
+	return toolCallID, ok && toolCallID != ""
+}
+
+// RegisterToolWithConfig adds a tool with its own timeout and concurrency limit
+func (r *DefaultToolRegistry) RegisterToolWithConfig(tool Tool, config ToolConfig) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := tool.Name()
+	if _, exists := r.tools[name]; exists {
+		return fmt.Errorf("tool '%s' is already registered", name)
+	}
+
+	r.tools[name] = tool
+	r.limits[name] = newToolLimits(config)
+	log.Printf("Registered tool: %s (timeout %s, max concurrent %d)", name, r.limits[name].config.Timeout, r.limits[name].config.MaxConcurrent)
+	return nil
+}
+
+// ToolConfig returns the limits a tool was registered with, defaults filled in
+func (r *DefaultToolRegistry) ToolConfig(name string) (ToolConfig, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	limits, exists := r.limits[name]
+	if !exists {
+		return ToolConfig{}, false
+	}
+	return limits.config, true
+}
+
+// InFlight returns how many executions of a tool are running
+func (r *DefaultToolRegistry) InFlight(name string) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if limits, exists := r.limits[name]; exists {
+		return len(limits.slots)
+	}
+	return 0
+}
+
+// CancelToolExecution cancels the in-flight execution of a tool call and reports whether one was running
+func (r *DefaultToolRegistry) CancelToolExecution(toolCallID string) bool {
+	r.executionsMutex.Lock()
+	execution, exists := r.executions[toolCallID]
+	r.executionsMutex.Unlock()
+
+	if !exists {
+		return false
+	}
+	execution.cancel()
+	log.Printf("Cancelled tool call %s", toolCallID)
+	return true
+}
+
+// CancelConversationTools cancels every in-flight tool call of a conversation and returns how many were running
+func (r *DefaultToolRegistry) CancelConversationTools(conversationID string) int {
+	r.executionsMutex.Lock()
+	var toolCallIDs []string
+	for toolCallID, execution := range r.executions {
+		if execution.conversationID == conversationID {
+			toolCallIDs = append(toolCallIDs, toolCallID)
+		}
+	}
+	r.executionsMutex.Unlock()
+
+	cancelled := 0
+	for _, toolCallID := range toolCallIDs {
+		if r.CancelToolExecution(toolCallID) {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// trackExecution registers a cancellable execution under its tool call ID
+func (r *DefaultToolRegistry) trackExecution(ctx context.Context, cancel context.CancelFunc) func() {
+	toolCallID, ok := ToolCallFrom(ctx)
+	if !ok {
+		return func() {}
+	}
+	conversationID, _ := ConversationFrom(ctx)
+
+	r.executionsMutex.Lock()
+	r.executions[toolCallID] = &toolExecution{conversationID: conversationID, cancel: cancel}
+	r.executionsMutex.Unlock()
+
+	return func() {
+		r.executionsMutex.Lock()
+		delete(r.executions, toolCallID)
+		r.executionsMutex.Unlock()
+	}
+}
+
+// runLimited runs a tool within its timeout and concurrency limit. A tool that
+// ignores its context keeps its slot until it returns, but the caller gets a
+// timeout or cancelled result as soon as the context is done.
+func (r *DefaultToolRegistry) runLimited(ctx context.Context, tool Tool, params map[string]interface{}) (*ToolResult, error) {
+	name := tool.Name()
+	r.mutex.RLock()
+	limits, exists := r.limits[name]
+	r.mutex.RUnlock()
+	if !exists {
+		return tool.Execute(ctx, params)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limits.config.Timeout)
+	defer cancel()
+	defer r.trackExecution(ctx, cancel)()
+
+	select {
+	case limits.slots <- struct{}{}:
+	case <-ctx.Done():
+		return interruptedResult(name, limits.config.Timeout, ctx.Err()), nil
+	}
+
+	type outcome struct {
+		result *ToolResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() { <-limits.slots }()
+		result, err := tool.Execute(ctx, params)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil && ctx.Err() != nil {
+			// A tool that honours its context fails with the context's error
+			return interruptedResult(name, limits.config.Timeout, ctx.Err()), nil
+		}
+		return out.result, out.err
+	case <-ctx.Done():
+		return interruptedResult(name, limits.config.Timeout, ctx.Err()), nil
+	}
+}
+
+// interruptedResult builds the result of an execution stopped by its context
+func interruptedResult(name string, timeout time.Duration, err error) *ToolResult {
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Tool %s timed out after %s", name, timeout)
+		return &ToolResult{
+			Status: ToolStatusTimeout,
+			Error:  fmt.Sprintf("Tool %s timed out after %s", name, timeout),
+			Code:   "TOOL_TIMEOUT",
+		}
+	}
+	return &ToolResult{
+		Status: ToolStatusCancelled,
+		Error:  fmt.Sprintf("Tool %s was cancelled", name),
+		Code:   "TOOL_CANCELLED",
+	}
+}
+
+// InterruptionError returns ErrToolTimeout or ErrToolCancelled for a result
+// stopped by its context, and nil otherwise
+func (r *ToolResult) InterruptionError() error {
+	if r == nil {
+		return nil
+	}
+	switch r.Status {
+	case ToolStatusTimeout:
+		return fmt.Errorf("%w: %s", ErrToolTimeout, r.Error)
+	case ToolStatusCancelled:
+		return fmt.Errorf("%w: %s", ErrToolCancelled, r.Error)
+	}
+	return nil
+}
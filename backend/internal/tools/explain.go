@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SQL dialect names used for dialect-specific query rewriting
+const (
+	dialectPostgres   = "postgres"
+	dialectMySQL      = "mysql"
+	dialectSQLite     = "sqlite"
+	dialectSQLServer  = "sqlserver"
+	dialectOracle     = "oracle"
+	dialectTrino      = "trino"
+	dialectClickHouse = "clickhouse"
+	dialectGeneric    = "sql"
+)
+
+// normalizeDialect maps datasource types and detected database names to a dialect
+func normalizeDialect(dbType string) string {
+	switch strings.ToLower(dbType) {
+	case "postgres", "postgresql":
+		return dialectPostgres
+	case "mysql", "mariadb":
+		return dialectMySQL
+	case "sqlite", "sqlite3":
+		return dialectSQLite
+	case "sqlserver", "mssql":
+		return dialectSQLServer
+	case "oracle":
+		return dialectOracle
+	case "trino", "presto":
+		return dialectTrino
+	case "clickhouse":
+		return dialectClickHouse
+	default:
+		return dialectGeneric
+	}
+}
+
+// resolveDialect returns the SQL dialect of the connection used for a query. Without a
+// connection a datasource whose type cannot be read is treated as generic SQL.
+func (t *DatabaseQueryTool) resolveDialect(ctx context.Context, conn DBConnection, datasourceID string) string {
+	if datasourceID != "" && t.zdb != nil {
+		inspectTool := &DatasourceInspectTool{zdb: t.zdb}
+		if dsType, err := inspectTool.getDatasourceType(ctx, datasourceID); err == nil {
+			return normalizeDialect(dsType)
+		}
+	}
+	if conn == nil {
+		return dialectGeneric
+	}
+	return normalizeDialect(NewDatasourceInspector(conn, "").detectDatabaseType(ctx))
+}
+
+// isSelectStatement reports whether a statement only reads data
+func isSelectStatement(statement string) bool {
+	statementLower := strings.ToLower(strings.TrimSpace(statement))
+	return strings.HasPrefix(statementLower, "select") || strings.HasPrefix(statementLower, "with")
+}
+
+// buildExplainQuery returns the dialect-appropriate EXPLAIN for a SELECT statement.
+// EXPLAIN ANALYZE is never used since it executes the query.
+func buildExplainQuery(dialect, statement string) (string, error) {
+	if !isSelectStatement(statement) {
+		return "", fmt.Errorf("explain_only is only supported for SELECT statements")
+	}
+
+	switch dialect {
+	case dialectPostgres:
+		return "EXPLAIN (FORMAT JSON) " + statement, nil
+	case dialectSQLite:
+		return "EXPLAIN QUERY PLAN " + statement, nil
+	case dialectMySQL, dialectTrino, dialectClickHouse:
+		return "EXPLAIN " + statement, nil
+	default:
+		return "", fmt.Errorf("explain_only is not supported for %s datasources", dialect)
+	}
+}
+
+// limitClausePattern matches clauses that already bound the number of returned rows.
+// TOP (n) is matched as TOP () since parenthesized sections are emptied first.
+var limitClausePattern = regexp.MustCompile(`(?i)\blimit\s+\d|\bfetch\s+(first|next)\b|\btop\s*(\d|\(\s*\))|\brownum\b`)
+
+// hasLimitClause reports whether a statement already limits its rows, ignoring literals,
+// comments and subqueries: a LIMIT inside a subquery or CTE bounds only that part
+func hasLimitClause(dialect, statement string) bool {
+	return limitClausePattern.MatchString(stripSubqueries(stripSQLLiterals(dialect, statement)))
+}
+
+// stripSubqueries empties every parenthesized section, leaving the clauses of the outermost query
+func stripSubqueries(statement string) string {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(statement); i++ {
+		switch ch := statement[i]; {
+		case ch == '(':
+			if depth == 0 {
+				b.WriteByte(ch)
+			}
+			depth++
+		case ch == ')' && depth > 0:
+			depth--
+			if depth == 0 {
+				b.WriteByte(ch)
+			}
+		case depth == 0:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// stripSQLLiterals blanks out quoted strings, quoted identifiers and comments
+func stripSQLLiterals(dialect, statement string) string {
+	var b strings.Builder
+	for i := 0; i < len(statement); {
+		ch := statement[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = scanQuoted(dialect, statement, i, ch)
+			b.WriteString("''")
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end == -1 {
+				return b.String()
+			}
+			i += end
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end == -1 {
+				return b.String()
+			}
+			i += end + 4
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// applyRowLimitGuard wraps a SELECT without its own limit so it returns at most limit rows.
+// The statement is placed on its own lines so a trailing line comment cannot swallow the wrapper.
+func applyRowLimitGuard(dialect, statement string, limit int) string {
+	if limit <= 0 || !isSelectStatement(statement) || hasLimitClause(dialect, statement) {
+		return statement
+	}
+	return wrapRowLimit(dialect, statement, limit)
+}
+
+// wrapRowLimit wraps a SELECT statement so it returns at most limit rows, even
+// if it has a LIMIT of its own
+func wrapRowLimit(dialect, statement string, limit int) string {
+	switch dialect {
+	case dialectSQLServer:
+		return fmt.Sprintf("SELECT TOP %d * FROM (\n%s\n) AS limited_query", limit, statement)
+	case dialectOracle:
+		return fmt.Sprintf("SELECT * FROM (\n%s\n) WHERE ROWNUM <= %d", statement, limit)
+	default:
+		return fmt.Sprintf("SELECT * FROM (\n%s\n) AS limited_query LIMIT %d", statement, limit)
+	}
+}
@@ -0,0 +1,261 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFileReadBytes = 16 * 1024
+	maxFileReadBytes     = 64 * 1024
+	csvPreviewRows       = 20
+	csvPreviewMaxBytes   = 1024 * 1024
+)
+
+// ProjectFilePath returns the on-disk location of an uploaded project file.
+// Files are keyed by their UUID so user-supplied names never reach the filesystem.
+func ProjectFilePath(dataDir, fileID string) (string, error) {
+	parsed, err := uuid.Parse(fileID)
+	if err != nil {
+		return "", fmt.Errorf("invalid file id: %s", fileID)
+	}
+	return filepath.Join(dataDir, parsed.String()), nil
+}
+
+// FileReadTool reads files uploaded to project file storage
+type FileReadTool struct {
+	db          DBConnection
+	dataDir     string
+	permissions PermissionChecker
+}
+
+// NewFileReadTool creates a new file read tool
+func NewFileReadTool(db DBConnection, dataDir string, permissions PermissionChecker) *FileReadTool {
+	return &FileReadTool{
+		db:          db,
+		dataDir:     dataDir,
+		permissions: permissions,
+	}
+}
+
+// Name returns tool name
+func (t *FileReadTool) Name() string {
+	return "file_read"
+}
+
+// Description returns tool description
+func (t *FileReadTool) Description() string {
+	return "Read the content of a file uploaded to the project. Returns text content in chunks (use offset to continue) and a parsed preview of the first rows for CSV files."
+}
+
+// Parameters returns tool parameters
+func (t *FileReadTool) Parameters() map[string]ToolParameter {
+	return map[string]ToolParameter{
+		"file_id": {
+			Type:        "string",
+			Description: "ID of the uploaded project file",
+			Required:    true,
+		},
+		"offset": {
+			Type:        "number",
+			Description: "Byte offset to start reading from (default: 0)",
+			Required:    false,
+			Default:     0,
+		},
+		"max_bytes": {
+			Type:        "number",
+			Description: fmt.Sprintf("Maximum number of bytes to return (default: %d, max: %d)", defaultFileReadBytes, maxFileReadBytes),
+			Required:    false,
+			Default:     defaultFileReadBytes,
+		},
+	}
+}
+
+// Execute reads the requested file chunk
+func (t *FileReadTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
+	startTime := time.Now()
+
+	fileID, ok := params["file_id"].(string)
+	if !ok || fileID == "" {
+		return NewToolError("file_id parameter is required", nil), nil
+	}
+
+	offset := int64(0)
+	if o, ok := params["offset"].(float64); ok && o > 0 {
+		offset = int64(o)
+	}
+
+	maxBytes := int64(defaultFileReadBytes)
+	if m, ok := params["max_bytes"].(float64); ok && m > 0 {
+		maxBytes = int64(m)
+	}
+	if maxBytes > maxFileReadBytes {
+		maxBytes = maxFileReadBytes
+	}
+
+	execCtx, _ := ExecutionContextFrom(ctx)
+	if execCtx.ProjectID == "" {
+		return NewToolError("file_read requires a project context", nil), nil
+	}
+
+	// Look up the file within the caller's project only
+	var filename, contentType string
+	var sizeBytes int64
+	err := t.db.QueryRow(ctx,
+		"SELECT filename, content_type, size_bytes FROM project_files WHERE id = $1 AND project_id = $2",
+		fileID, execCtx.ProjectID).Scan(&filename, &contentType, &sizeBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NewToolError("File not found", nil), nil
+	}
+	if err != nil {
+		return NewToolError("Failed to look up file", err), nil
+	}
+
+	path, err := ProjectFilePath(t.dataDir, fileID)
+	if err != nil {
+		return NewToolError("Invalid file", err), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return NewToolError("Failed to open file", err), nil
+	}
+	defer file.Close()
+
+	if offset > sizeBytes {
+		offset = sizeBytes
+	}
+	chunk := make([]byte, maxBytes)
+	n, err := file.ReadAt(chunk, offset)
+	if err != nil && err != io.EOF {
+		return NewToolError("Failed to read file", err), nil
+	}
+	chunk = chunk[:n]
+
+	nextOffset := offset + int64(n)
+	isText := isTextContent(chunk)
+	data := map[string]interface{}{
+		"file_id":      fileID,
+		"filename":     filename,
+		"content_type": contentType,
+		"size_bytes":   sizeBytes,
+		"offset":       offset,
+		"bytes_read":   n,
+		"truncated":    nextOffset < sizeBytes,
+		"is_text":      isText,
+	}
+	if nextOffset < sizeBytes {
+		data["next_offset"] = nextOffset
+	}
+
+	if isText {
+		data["content"] = string(trimPartialRune(chunk))
+	} else {
+		data["content"] = ""
+		data["note"] = "File appears to be binary; content is not returned"
+	}
+
+	if offset == 0 && isCSVFile(filename, contentType) {
+		if preview, err := parseCSVPreview(io.LimitReader(io.NewSectionReader(file, 0, sizeBytes), csvPreviewMaxBytes), csvPreviewRows); err == nil {
+			data["csv_preview"] = preview
+		} else {
+			data["csv_preview_error"] = err.Error()
+		}
+	}
+
+	return NewToolSuccess(data, int(time.Since(startTime).Milliseconds())), nil
+}
+
+// ValidateAccess checks if user has access to this tool
+func (t *FileReadTool) ValidateAccess(userID, projectID string) bool {
+	// Reading uploaded files is read-only, so any project viewer may use it
+	return hasProjectRole(t.permissions, userID, projectID, RoleViewer)
+}
+
+// GetCategory returns the tool category
+func (t *FileReadTool) GetCategory() string {
+	return "files"
+}
+
+// isTextContent reports whether a chunk looks like text rather than binary data
+func isTextContent(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return false
+	}
+	if utf8.Valid(trimPartialRune(data)) {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(data), "text/")
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of a chunk
+func trimPartialRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// isCSVFile reports whether a file should get a parsed CSV preview
+func isCSVFile(filename, contentType string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".csv") || strings.HasPrefix(contentType, "text/csv")
+}
+
+// parseCSVPreview parses the header and first rows of a CSV file
+func parseCSVPreview(r io.Reader, maxRows int) (map[string]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	rows := make([]map[string]string, 0, maxRows)
+	for len(rows) < maxRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The preview window may cut a record in half; keep what parsed cleanly
+			break
+		}
+
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return map[string]interface{}{
+		"columns": header,
+		"rows":    rows,
+		"count":   len(rows),
+	}, nil
+}
@@ -13,13 +13,15 @@ type ToolParameter struct {
 	Description string      `json:"description"`
 	Required    bool        `json:"required"`
 	Default     interface{} `json:"default,omitempty"`
+	Enum        []string    `json:"enum,omitempty"` // Allowed values of a string parameter
 }
 
 // ToolResult represents the result of a tool execution
 type ToolResult struct {
-	Status string                 `json:"status"` // completed, failed, error
+	Status string                 `json:"status"` // completed, failed, error, timeout, cancelled
 	Data   map[string]interface{} `json:"data,omitempty"`
 	Error  string                 `json:"error,omitempty"`
+	Code   string                 `json:"code,omitempty"` // machine-readable error code, e.g. URL_NOT_ALLOWED
 	TimeMs int                    `json:"time_ms,omitempty"`
 }
 
@@ -44,6 +46,21 @@ type Tool interface {
 	GetCategory() string
 }
 
+// SideEffectReporter is implemented by tools that may modify data depending on their parameters
+type SideEffectReporter interface {
+	// HasSideEffects reports whether running the tool with these parameters may modify data
+	HasSideEffects(params map[string]interface{}) bool
+}
+
+// HasSideEffects reports whether running a tool with these parameters may modify
+// data. Tools that do not implement SideEffectReporter are treated as read-only.
+func HasSideEffects(tool Tool, params map[string]interface{}) bool {
+	if reporter, ok := tool.(SideEffectReporter); ok {
+		return reporter.HasSideEffects(params)
+	}
+	return false
+}
+
 // ToolRegistry defines the interface for tool management
 type ToolRegistry interface {
 	// RegisterTool adds a new tool to the registry
@@ -63,6 +80,21 @@ type ToolRegistry interface {
 
 	// ListTools returns a list of all registered tools
 	ListTools() []Tool
+
+	// IsToolEnabled reports whether a tool is enabled for a project
+	IsToolEnabled(projectID, toolName string) bool
+
+	// SetToolEnabled enables or disables a tool for a project
+	SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error
+
+	// Results returns the per-conversation store of recent tool results, or nil if results are not kept
+	Results() *ResultStore
+
+	// CancelToolExecution cancels the in-flight execution of a tool call, reporting whether one was running
+	CancelToolExecution(toolCallID string) bool
+
+	// CancelConversationTools cancels every in-flight tool call of a conversation and returns how many
+	CancelConversationTools(conversationID string) int
 }
 
 // WebSocketHub defines the interface for WebSocket communication
@@ -96,8 +128,57 @@ var (
 	ErrToolAccessDenied    = errors.New("access denied for tool")
 	ErrInvalidParameters   = errors.New("invalid tool parameters")
 	ErrToolExecutionFailed = errors.New("tool execution failed")
+	ErrToolDisabled        = errors.New("tool is disabled for this project")
+)
+
+// What started a tool execution, as recorded in ExecutionContext.Source
+const (
+	SourceLLM      = "llm"      // A tool call of the model
+	SourceUser     = "user"     // A user running a tool directly
+	SourceRerun    = "rerun"    // A user re-running an earlier tool call
+	SourceSchedule = "schedule" // A tool call of the model answering a scheduled prompt
 )
 
+// ExecutionContext carries the caller identity for a tool execution
+type ExecutionContext struct {
+	UserID    string
+	ProjectID string
+	ClientID  string // Set by WithExecutionSource
+	Source    string // Set by WithExecutionSource
+}
+
+type executionContextKey struct{}
+
+type executionSourceKey struct{}
+
+// WithExecutionContext attaches the caller identity to a tool execution
+// context, along with the client and source attached by WithExecutionSource
+func WithExecutionContext(ctx context.Context, userID, projectID string) context.Context {
+	execCtx := ExecutionContext{UserID: userID, ProjectID: projectID}
+	if source, ok := ctx.Value(executionSourceKey{}).(ExecutionContext); ok {
+		execCtx.ClientID = source.ClientID
+		execCtx.Source = source.Source
+	}
+	return context.WithValue(ctx, executionContextKey{}, execCtx)
+}
+
+// WithExecutionSource records the client of the caller and what started the
+// tool executions run with ctx, one of the Source constants. An outer caller's
+// source is kept, so a scheduled prompt's tool calls are not recorded as the
+// model's.
+func WithExecutionSource(ctx context.Context, clientID, source string) context.Context {
+	if _, ok := ctx.Value(executionSourceKey{}).(ExecutionContext); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, executionSourceKey{}, ExecutionContext{ClientID: clientID, Source: source})
+}
+
+// ExecutionContextFrom returns the caller identity attached by the registry, if any
+func ExecutionContextFrom(ctx context.Context) (ExecutionContext, bool) {
+	execCtx, ok := ctx.Value(executionContextKey{}).(ExecutionContext)
+	return execCtx, ok
+}
+
 // Helper functions
 
 // NewToolResult creates a new tool result
@@ -120,6 +201,16 @@ func NewToolError(message string, err error) *ToolResult {
 	}
 }
 
+// NewToolErrorWithCode creates a tool error result carrying an error code and details
+func NewToolErrorWithCode(code, message string, data map[string]interface{}) *ToolResult {
+	return &ToolResult{
+		Status: "failed",
+		Code:   code,
+		Error:  message,
+		Data:   data,
+	}
+}
+
 // NewToolSuccess creates a new successful tool result
 func NewToolSuccess(data map[string]interface{}, timeMs int) *ToolResult {
 	return &ToolResult{
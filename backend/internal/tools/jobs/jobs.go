@@ -0,0 +1,389 @@
+// Package jobs runs long database queries in the background and keeps their
+// status and results in the query_jobs table so they can be polled later.
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/messages"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+const (
+	// DefaultTimeout applies when a job is submitted without a timeout
+	DefaultTimeout = 5 * time.Minute
+	// MaxTimeout caps how long a single job may run
+	MaxTimeout = 10 * time.Minute
+	// DefaultInlineResultBytes is the largest row payload kept in the database;
+	// bigger results are written to disk as JSON lines
+	DefaultInlineResultBytes = 256 * 1024
+	// EventCompleted is broadcast to the project when a job finishes, successfully or not
+	EventCompleted = "query_job_completed"
+)
+
+// ErrJobNotFound is returned when a job ID does not exist
+var ErrJobNotFound = errors.New("query job not found")
+
+// DB is the part of tools.DBConnection the manager uses
+type DB interface {
+	QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Notifier broadcasts job events to a project's WebSocket room
+type Notifier interface {
+	BroadcastToProject(projectID string, message interface{})
+}
+
+// Job is a background query and its outcome
+type Job struct {
+	ID             string     `json:"id"`
+	ProjectID      string     `json:"project_id"`
+	UserID         string     `json:"user_id"`
+	DatasourceID   string     `json:"datasource_id,omitempty"`
+	Query          string     `json:"query"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	RowCount       int        `json:"row_count"`
+	TimeoutSeconds int        `json:"timeout_seconds"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	ResultPath     string     `json:"-"`
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed
+}
+
+// Result is the output of a job. Rows are paged through Results; Summary holds
+// the rest of the query output, e.g. rows_affected for writes.
+type Result struct {
+	Columns []string                 `json:"columns,omitempty"`
+	Rows    []map[string]interface{} `json:"rows,omitempty"`
+	Summary map[string]interface{}   `json:"summary,omitempty"`
+}
+
+// Page is a slice of a completed job's rows
+type Page struct {
+	JobID   string                   `json:"job_id"`
+	Columns []string                 `json:"columns,omitempty"`
+	Rows    []map[string]interface{} `json:"rows"`
+	Summary map[string]interface{}   `json:"summary,omitempty"`
+	Offset  int                      `json:"offset"`
+	Limit   int                      `json:"limit"`
+	Total   int                      `json:"total"`
+	HasMore bool                     `json:"has_more"`
+}
+
+// RunFunc executes a job's query. The context carries the job timeout and is
+// independent of the request that submitted the job.
+type RunFunc func(ctx context.Context) (*Result, error)
+
+// Manager submits jobs and tracks them in the query_jobs table
+type Manager struct {
+	db          DB
+	dir         string
+	notifier    Notifier
+	inlineLimit int
+	wg          sync.WaitGroup
+}
+
+// NewManager creates a job manager that writes large results under dir
+func NewManager(db DB, dir string, notifier Notifier) *Manager {
+	return &Manager{
+		db:          db,
+		dir:         dir,
+		notifier:    notifier,
+		inlineLimit: DefaultInlineResultBytes,
+	}
+}
+
+// ClampTimeout returns the timeout a job will actually run with
+func ClampTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultTimeout
+	}
+	if timeout > MaxTimeout {
+		return MaxTimeout
+	}
+	return timeout
+}
+
+// Submit records a pending job and starts run in the background. run gets a
+// context carrying the values of ctx, such as the caller's identity, but not
+// its cancellation.
+func (m *Manager) Submit(ctx context.Context, job Job, run RunFunc) (*Job, error) {
+	timeout := ClampTimeout(time.Duration(job.TimeoutSeconds) * time.Second)
+	job.ID = uuid.New().String()
+	job.Status = StatusPending
+	job.TimeoutSeconds = int(timeout / time.Second)
+	job.CreatedAt = time.Now().UTC()
+
+	_, err := m.db.Exec(ctx,
+		`INSERT INTO query_jobs (id, project_id, user_id, datasource_id, query, status, timeout_seconds, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		job.ID, job.ProjectID, job.UserID, nullableString(job.DatasourceID), job.Query, job.Status, job.TimeoutSeconds, job.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query job: %w", err)
+	}
+
+	m.wg.Add(1)
+	go m.run(context.WithoutCancel(ctx), job, timeout, run)
+
+	return &job, nil
+}
+
+// Wait blocks until every submitted job has finished
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Manager) run(ctx context.Context, job Job, timeout time.Duration, run RunFunc) {
+	defer m.wg.Done()
+
+	started := time.Now().UTC()
+	job.Status = StatusRunning
+	job.StartedAt = &started
+	if _, err := m.db.Exec(ctx, "UPDATE query_jobs SET status = $1, started_at = $2 WHERE id = $3", job.Status, started, job.ID); err != nil {
+		log.Printf("Failed to mark query job %s running: %v", job.ID, err)
+	}
+
+	result, err := m.execute(ctx, timeout, run)
+	if err == nil {
+		err = m.store(ctx, &job, result)
+	}
+
+	completed := time.Now().UTC()
+	job.CompletedAt = &completed
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		_, err = m.db.Exec(ctx,
+			"UPDATE query_jobs SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			job.Status, job.Error, completed, job.ID)
+	} else {
+		job.Status = StatusCompleted
+		_, err = m.db.Exec(ctx,
+			"UPDATE query_jobs SET status = $1, completed_at = $2 WHERE id = $3",
+			job.Status, completed, job.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to record query job %s outcome: %v", job.ID, err)
+	}
+
+	if m.notifier != nil {
+		m.notifier.BroadcastToProject(job.ProjectID, messages.WebSocketMessage{
+			Type:      EventCompleted,
+			Data:      job,
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+}
+
+// execute runs the job under its timeout, turning panics into job failures
+func (m *Manager) execute(ctx context.Context, timeout time.Duration, run RunFunc) (result *Result, err error) {
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("query job panicked: %v", r)
+		}
+	}()
+
+	result, err = run(runCtx)
+	if err == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("query timed out after %s", timeout)
+	}
+	if err == nil && result == nil {
+		result = &Result{}
+	}
+	return result, err
+}
+
+// store saves the result inline when it is small and as a JSON lines file otherwise.
+// The result column always holds columns and summary; rows are inline only for small results.
+func (m *Manager) store(ctx context.Context, job *Job, result *Result) error {
+	var lines bytes.Buffer
+	encoder := json.NewEncoder(&lines)
+	for _, row := range result.Rows {
+		if err := encoder.Encode(row); err != nil {
+			return fmt.Errorf("failed to encode result row: %w", err)
+		}
+	}
+
+	stored := Result{Columns: result.Columns, Summary: result.Summary}
+	if lines.Len() <= m.inlineLimit {
+		stored.Rows = result.Rows
+	} else {
+		if err := os.MkdirAll(m.dir, 0755); err != nil {
+			return fmt.Errorf("failed to create result directory: %w", err)
+		}
+		job.ResultPath = filepath.Join(m.dir, job.ID+".jsonl")
+		if err := os.WriteFile(job.ResultPath, lines.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write result file: %w", err)
+		}
+	}
+
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	job.RowCount = len(result.Rows)
+	_, err = m.db.Exec(ctx,
+		"UPDATE query_jobs SET row_count = $1, result = $2, result_path = $3 WHERE id = $4",
+		job.RowCount, string(encoded), nullableString(job.ResultPath), job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save result: %w", err)
+	}
+	return nil
+}
+
+// Get loads a job by ID
+func (m *Manager) Get(ctx context.Context, id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	job := &Job{ID: id}
+	var datasourceID, jobError, resultPath sql.NullString
+	var startedAt, completedAt sql.NullTime
+	err := m.db.QueryRow(ctx,
+		`SELECT project_id, user_id, datasource_id, query, status, error, row_count, result_path,
+			timeout_seconds, created_at, started_at, completed_at
+		FROM query_jobs WHERE id = $1`, id).
+		Scan(&job.ProjectID, &job.UserID, &datasourceID, &job.Query, &job.Status, &jobError, &job.RowCount,
+			&resultPath, &job.TimeoutSeconds, &job.CreatedAt, &startedAt, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query job: %w", err)
+	}
+
+	job.DatasourceID = datasourceID.String
+	job.Error = jobError.String
+	job.ResultPath = resultPath.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, nil
+}
+
+// Results returns up to limit rows of a completed job starting at offset
+func (m *Manager) Results(ctx context.Context, job *Job, offset, limit int) (*Page, error) {
+	if job.Status != StatusCompleted {
+		return nil, fmt.Errorf("query job is %s", job.Status)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var encoded sql.NullString
+	if err := m.db.QueryRow(ctx, "SELECT result FROM query_jobs WHERE id = $1", job.ID).Scan(&encoded); err != nil {
+		return nil, fmt.Errorf("failed to load result: %w", err)
+	}
+	var stored Result
+	if encoded.Valid {
+		if err := json.Unmarshal([]byte(encoded.String), &stored); err != nil {
+			return nil, fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+
+	page := &Page{
+		JobID:   job.ID,
+		Columns: stored.Columns,
+		Summary: stored.Summary,
+		Rows:    []map[string]interface{}{},
+		Offset:  offset,
+		Limit:   limit,
+		Total:   job.RowCount,
+	}
+
+	if job.ResultPath == "" {
+		if offset < len(stored.Rows) {
+			end := offset + limit
+			if end > len(stored.Rows) {
+				end = len(stored.Rows)
+			}
+			page.Rows = stored.Rows[offset:end]
+		}
+	} else {
+		rows, err := readLines(job.ResultPath, offset, limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Rows = rows
+	}
+
+	page.HasMore = offset+len(page.Rows) < page.Total
+	return page, nil
+}
+
+// readLines decodes limit JSON lines from path after skipping offset lines
+func readLines(path string, offset, limit int) ([]map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result file: %w", err)
+	}
+	defer file.Close()
+
+	rows := []map[string]interface{}{}
+	reader := bufio.NewReader(file)
+	for line := 0; len(rows) < limit; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 && line >= offset {
+			var row map[string]interface{}
+			if err := json.Unmarshal(data, &row); err != nil {
+				return nil, fmt.Errorf("failed to decode result row: %w", err)
+			}
+			rows = append(rows, row)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// FailInterrupted marks jobs left pending or running by a previous process as failed
+func (m *Manager) FailInterrupted(ctx context.Context) (int64, error) {
+	result, err := m.db.Exec(ctx,
+		"UPDATE query_jobs SET status = $1, error = $2, completed_at = $3 WHERE status IN ($4, $5)",
+		StatusFailed, "interrupted by server restart", time.Now().UTC(), StatusPending, StatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted query jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/messages"
+)
+
+// sqlAdapter exposes a ZDB connection the way tools.ZlayDBAdapter does
+type sqlAdapter struct {
+	db *db.Database
+}
+
+func (a sqlAdapter) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return a.db.GetDB().QueryRowContext(ctx, query, args...)
+}
+
+func (a sqlAdapter) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return a.db.GetDB().ExecContext(ctx, query, args...)
+}
+
+type recordingNotifier struct {
+	mutex    sync.Mutex
+	projects []string
+	events   []messages.WebSocketMessage
+}
+
+func (n *recordingNotifier) BroadcastToProject(projectID string, message interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.projects = append(n.projects, projectID)
+	n.events = append(n.events, message.(messages.WebSocketMessage))
+}
+
+func newTestManager(t *testing.T) (*Manager, *recordingNotifier) {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+
+	notifier := &recordingNotifier{}
+	return NewManager(sqlAdapter{db: zdb}, filepath.Join(t.TempDir(), "results"), notifier), notifier
+}
+
+func rowsResult(n int) *Result {
+	result := &Result{Columns: []string{"id"}, Summary: map[string]interface{}{"query": "SELECT id FROM items"}}
+	for i := 0; i < n; i++ {
+		result.Rows = append(result.Rows, map[string]interface{}{"id": float64(i)})
+	}
+	return result
+}
+
+func submit(t *testing.T, manager *Manager, timeoutSeconds int, run RunFunc) *Job {
+	t.Helper()
+
+	job, err := manager.Submit(context.Background(), Job{ProjectID: "project-1", UserID: "user-1", Query: "SELECT id FROM items", TimeoutSeconds: timeoutSeconds}, run)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	manager.Wait()
+
+	job, err = manager.Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return job
+}
+
+func TestJobCompletesWithInlineResult(t *testing.T) {
+	manager, notifier := newTestManager(t)
+
+	job := submit(t, manager, 0, func(ctx context.Context) (*Result, error) {
+		return rowsResult(3), nil
+	})
+	if job.Status != StatusCompleted || job.RowCount != 3 || job.ResultPath != "" {
+		t.Fatalf("Expected a completed inline job, got %+v", job)
+	}
+	if job.TimeoutSeconds != int(DefaultTimeout.Seconds()) || job.StartedAt == nil || job.CompletedAt == nil {
+		t.Errorf("Expected default timeout and timestamps, got %+v", job)
+	}
+
+	page, err := manager.Results(context.Background(), job, 1, 1)
+	if err != nil {
+		t.Fatalf("Results failed: %v", err)
+	}
+	if len(page.Rows) != 1 || page.Rows[0]["id"] != float64(1) || !page.HasMore || page.Total != 3 {
+		t.Errorf("Unexpected page: %+v", page)
+	}
+	if page.Columns[0] != "id" || page.Summary["query"] != "SELECT id FROM items" {
+		t.Errorf("Expected columns and summary with the page, got %+v", page)
+	}
+
+	if len(notifier.events) != 1 || notifier.projects[0] != "project-1" || notifier.events[0].Type != EventCompleted {
+		t.Fatalf("Expected one completion event for the project, got %+v", notifier.events)
+	}
+	if event := notifier.events[0].Data.(Job); event.ID != job.ID || event.Status != StatusCompleted {
+		t.Errorf("Unexpected event payload: %+v", event)
+	}
+}
+
+func TestLargeResultIsWrittenToDisk(t *testing.T) {
+	manager, _ := newTestManager(t)
+	manager.inlineLimit = 64
+
+	job := submit(t, manager, 0, func(ctx context.Context) (*Result, error) {
+		return rowsResult(50), nil
+	})
+	if job.Status != StatusCompleted || job.ResultPath == "" {
+		t.Fatalf("Expected the result on disk, got %+v", job)
+	}
+	data, err := os.ReadFile(job.ResultPath)
+	if err != nil {
+		t.Fatalf("Failed to read result file: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 50 {
+		t.Errorf("Expected one JSON line per row, got %d", lines)
+	}
+
+	page, err := manager.Results(context.Background(), job, 45, 10)
+	if err != nil {
+		t.Fatalf("Results failed: %v", err)
+	}
+	if len(page.Rows) != 5 || page.Rows[0]["id"] != float64(45) || page.HasMore {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+}
+
+func TestJobFailures(t *testing.T) {
+	manager, notifier := newTestManager(t)
+
+	failed := submit(t, manager, 0, func(ctx context.Context) (*Result, error) {
+		return nil, errors.New("relation \"items\" does not exist")
+	})
+	if failed.Status != StatusFailed || !strings.Contains(failed.Error, "does not exist") {
+		t.Errorf("Expected the query error on the job, got %+v", failed)
+	}
+
+	timedOut := submit(t, manager, 1, func(ctx context.Context) (*Result, error) {
+		<-ctx.Done()
+		return rowsResult(1), nil
+	})
+	if timedOut.Status != StatusFailed || !strings.Contains(timedOut.Error, "timed out") {
+		t.Errorf("Expected a timeout failure, got %+v", timedOut)
+	}
+
+	panicked := submit(t, manager, 0, func(ctx context.Context) (*Result, error) {
+		panic("driver bug")
+	})
+	if panicked.Status != StatusFailed {
+		t.Errorf("Expected a panic to fail the job, got %+v", panicked)
+	}
+
+	if len(notifier.events) != 3 {
+		t.Errorf("Expected an event for every failed job, got %d", len(notifier.events))
+	}
+	if _, err := manager.Results(context.Background(), failed, 0, 10); err == nil {
+		t.Error("Expected no result for a failed job")
+	}
+}
+
+func TestClampTimeout(t *testing.T) {
+	if got := ClampTimeout(0); got != DefaultTimeout {
+		t.Errorf("Expected default timeout, got %s", got)
+	}
+	if got := ClampTimeout(2 * MaxTimeout); got != MaxTimeout {
+		t.Errorf("Expected timeout capped at %s, got %s", MaxTimeout, got)
+	}
+}
+
+func TestGetAndFailInterrupted(t *testing.T) {
+	manager, _ := newTestManager(t)
+	ctx := context.Background()
+
+	if _, err := manager.Get(ctx, "not-a-uuid"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for an invalid ID, got %v", err)
+	}
+	if _, err := manager.Get(ctx, "7b0e7c55-4d1f-4a4c-9d55-1f3c2b3f6f11"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for an unknown ID, got %v", err)
+	}
+
+	_, err := manager.db.Exec(ctx, `INSERT INTO query_jobs (id, project_id, user_id, query, status, timeout_seconds, created_at)
+		VALUES ('7b0e7c55-4d1f-4a4c-9d55-1f3c2b3f6f11', 'project-1', 'user-1', 'SELECT 1', 'running', 60, CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatalf("Failed to seed job: %v", err)
+	}
+	if n, err := manager.FailInterrupted(ctx); err != nil || n != 1 {
+		t.Fatalf("Expected one interrupted job, got %d, %v", n, err)
+	}
+	job, err := manager.Get(ctx, "7b0e7c55-4d1f-4a4c-9d55-1f3c2b3f6f11")
+	if err != nil || job.Status != StatusFailed || job.CompletedAt == nil {
+		t.Errorf("Expected the interrupted job to be failed, got %+v, %v", job, err)
+	}
+}
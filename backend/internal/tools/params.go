@@ -0,0 +1,163 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ParameterError reports a parameter that does not match a tool's schema
+type ParameterError struct {
+	Parameter string
+	Reason    string
+}
+
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("parameter %s %s", e.Parameter, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidParameters) match parameter errors
+func (e *ParameterError) Is(target error) bool {
+	return target == ErrInvalidParameters
+}
+
+// ValidateParameters checks params against a tool's schema and returns a copy
+// with the defaults of missing optional parameters filled in. Unknown
+// parameters are refused, as are values of the wrong type; numbers are the
+// float64s JSON decodes to, and integers must have no fraction. Parameters
+// without a type accept any value.
+func ValidateParameters(params map[string]interface{}, schema map[string]ToolParameter) (map[string]interface{}, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, known := schema[name]; !known {
+			return nil, &ParameterError{Parameter: name, Reason: "is not a parameter of this tool"}
+		}
+	}
+
+	names = names[:0]
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	validated := make(map[string]interface{}, len(schema))
+	for _, name := range names {
+		param := schema[name]
+		value, present := params[name]
+		if !present || value == nil {
+			if param.Required {
+				return nil, &ParameterError{Parameter: name, Reason: "is required"}
+			}
+			if param.Default != nil {
+				validated[name] = param.Default
+			}
+			continue
+		}
+		if !matchesParameterType(value, param.Type) {
+			return nil, &ParameterError{Parameter: name, Reason: "must be of type " + param.Type}
+		}
+		if len(param.Enum) > 0 && !inEnum(value, param.Enum) {
+			return nil, &ParameterError{Parameter: name, Reason: "must be one of " + strings.Join(param.Enum, ", ")}
+		}
+		validated[name] = value
+	}
+	return validated, nil
+}
+
+// ToolParameterSchema describes a tool's parameters as the JSON Schema object
+// the model is given: each property keeps its type, description, default and
+// allowed values, and required parameters are listed in sorted order.
+// Parameters without a type accept any value.
+func ToolParameterSchema(tool Tool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for name, param := range tool.Parameters() {
+		property := map[string]interface{}{}
+		if param.Type != "" {
+			property["type"] = param.Type
+		}
+		if param.Description != "" {
+			property["description"] = param.Description
+		}
+		if param.Default != nil {
+			property["default"] = param.Default
+		}
+		if len(param.Enum) > 0 {
+			property["enum"] = param.Enum
+		}
+		properties[name] = property
+		if param.Required {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// inEnum reports whether value is one of a parameter's allowed values
+func inEnum(value interface{}, enum []string) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	for _, allowed := range enum {
+		if s == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesParameterType reports whether a decoded JSON value has the schema type
+func matchesParameterType(value interface{}, paramType string) bool {
+	switch paramType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := numberValue(value)
+		return ok
+	case "integer":
+		n, ok := numberValue(value)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+// numberValue returns a number as a float64; unlike toFloat it refuses numeric strings
+func numberValue(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ProjectRole represents a user's role within a project, ordered by privilege
+type ProjectRole int
+
+const (
+	RoleNone ProjectRole = iota
+	RoleViewer
+	RoleEditor
+	RoleOwner
+)
+
+// String returns the role name
+func (r ProjectRole) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleEditor:
+		return "editor"
+	case RoleOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+// PermissionChecker resolves a user's role within a project
+type PermissionChecker interface {
+	GetProjectRole(ctx context.Context, userID, projectID string) (ProjectRole, error)
+}
+
+// PermissionError is returned when a user lacks the role required to run a tool
+type PermissionError struct {
+	ToolName  string
+	UserID    string
+	ProjectID string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("user %s is not permitted to use tool %s in project %s", e.UserID, e.ToolName, e.ProjectID)
+}
+
+// Is makes errors.Is(err, ErrToolAccessDenied) match permission errors
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrToolAccessDenied
+}
+
+// hasProjectRole checks that the user holds at least the required role.
+// A missing checker denies access for anything above RoleNone.
+func hasProjectRole(checker PermissionChecker, userID, projectID string, required ProjectRole) bool {
+	if required == RoleNone {
+		return true
+	}
+	if checker == nil || userID == "" || projectID == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, err := checker.GetProjectRole(ctx, userID, projectID)
+	if err != nil {
+		log.Printf("Failed to resolve role for user %s in project %s: %v", userID, projectID, err)
+		return false
+	}
+	return role >= required
+}
+
+// DBPermissionChecker resolves project roles from the application database.
+// Project owners (projects.user_id) are granted RoleOwner; everyone else has no role.
+type DBPermissionChecker struct {
+	db DBConnection
+}
+
+// NewDBPermissionChecker creates a new database-backed permission checker
+func NewDBPermissionChecker(db DBConnection) *DBPermissionChecker {
+	return &DBPermissionChecker{db: db}
+}
+
+// GetProjectRole returns the user's role in the project
+func (c *DBPermissionChecker) GetProjectRole(ctx context.Context, userID, projectID string) (ProjectRole, error) {
+	var ownerID string
+	err := c.db.QueryRow(ctx,
+		"SELECT user_id FROM projects WHERE id = $1 AND is_active = true",
+		projectID).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RoleNone, nil
+	}
+	if err != nil {
+		return RoleNone, fmt.Errorf("failed to load project: %w", err)
+	}
+
+	if ownerID == userID {
+		return RoleOwner, nil
+	}
+	return RoleNone, nil
+}
@@ -0,0 +1,206 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/tools/jobs"
+)
+
+const (
+	defaultJobPreviewRows = 20
+	maxJobPreviewRows     = 100
+)
+
+// submitAsync validates a database_query call and starts it as a background job
+func (t *DatabaseQueryTool) submitAsync(ctx context.Context, params map[string]interface{}, query, datasourceID, dialect string) *ToolResult {
+	if t.jobs == nil {
+		return NewToolError("Async queries are not available", nil)
+	}
+	execCtx, ok := ExecutionContextFrom(ctx)
+	if !ok || execCtx.ProjectID == "" || execCtx.UserID == "" {
+		return NewToolError("Async queries require a project context", nil)
+	}
+
+	// Refuse what the synchronous path would refuse before a job is recorded
+	statements := splitSQLStatements(dialect, query)
+	if len(statements) == 0 {
+		return NewToolError("Query is empty", nil)
+	}
+	for i, stmt := range statements {
+		if err := checkForbiddenOperation(stmt); err != nil {
+			return NewToolError(fmt.Sprintf("Statement %d rejected", i+1), err)
+		}
+	}
+
+	timeout := jobs.DefaultTimeout
+	if ts, ok := params["timeout_seconds"].(float64); ok {
+		timeout = time.Duration(ts) * time.Second
+	}
+	timeout = jobs.ClampTimeout(timeout)
+
+	// The job reruns this tool synchronously with the job's own timeout, on the
+	// datasource resolved now so it cannot change while the job waits
+	runParams := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		runParams[k] = v
+	}
+	delete(runParams, "async")
+	runParams["datasource_id"] = datasourceID
+	runParams["timeout_seconds"] = float64(timeout / time.Second)
+
+	job, err := t.jobs.Submit(ctx, jobs.Job{
+		ProjectID:      execCtx.ProjectID,
+		UserID:         execCtx.UserID,
+		DatasourceID:   datasourceID,
+		Query:          query,
+		TimeoutSeconds: int(timeout / time.Second),
+	}, func(jobCtx context.Context) (*jobs.Result, error) {
+		result, err := t.Execute(jobCtx, runParams)
+		if err != nil {
+			return nil, err
+		}
+		if result.Status != "completed" {
+			return nil, errors.New(result.Error)
+		}
+		return jobResult(result.Data), nil
+	})
+	if err != nil {
+		return NewToolError("Failed to start query job", err)
+	}
+
+	return NewToolSuccess(map[string]interface{}{
+		"query_job_id":    job.ID,
+		"status":          job.Status,
+		"timeout_seconds": job.TimeoutSeconds,
+		"datasource_id":   datasourceID,
+		"message":         "Query is running in the background; check it with query_job_status",
+	}, 0)
+}
+
+// jobResult splits the rows of a single SELECT from the rest of a tool result
+func jobResult(data map[string]interface{}) *jobs.Result {
+	summary := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		summary[k] = v
+	}
+
+	result := &jobs.Result{Summary: summary}
+	selectResult, ok := data["result"].(map[string]interface{})
+	if !ok || selectResult["type"] != "select" {
+		return result
+	}
+
+	result.Columns, _ = selectResult["columns"].([]string)
+	result.Rows, _ = selectResult["rows"].([]map[string]interface{})
+	rest := make(map[string]interface{}, len(selectResult))
+	for k, v := range selectResult {
+		if k != "rows" {
+			rest[k] = v
+		}
+	}
+	summary["result"] = rest
+	return result
+}
+
+// QueryJobStatusTool reports the progress of async database queries
+type QueryJobStatusTool struct {
+	jobs        *jobs.Manager
+	permissions PermissionChecker
+}
+
+// NewQueryJobStatusTool creates a new query job status tool
+func NewQueryJobStatusTool(manager *jobs.Manager, permissions PermissionChecker) *QueryJobStatusTool {
+	return &QueryJobStatusTool{
+		jobs:        manager,
+		permissions: permissions,
+	}
+}
+
+// Name returns tool name
+func (t *QueryJobStatusTool) Name() string {
+	return "query_job_status"
+}
+
+// Description returns tool description
+func (t *QueryJobStatusTool) Description() string {
+	return "Check an async database_query job. Returns its status and, once completed, a page of result rows."
+}
+
+// Parameters returns tool parameters
+func (t *QueryJobStatusTool) Parameters() map[string]ToolParameter {
+	return map[string]ToolParameter{
+		"query_job_id": {
+			Type:        "string",
+			Description: "ID returned by database_query with async: true",
+			Required:    true,
+		},
+		"offset": {
+			Type:        "number",
+			Description: "First result row to return (default: 0)",
+			Required:    false,
+			Default:     0,
+		},
+		"limit": {
+			Type:        "number",
+			Description: fmt.Sprintf("Number of result rows to return (default: %d, max: %d)", defaultJobPreviewRows, maxJobPreviewRows),
+			Required:    false,
+			Default:     defaultJobPreviewRows,
+		},
+	}
+}
+
+// Execute looks up the job within the caller's project
+func (t *QueryJobStatusTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
+	jobID, ok := params["query_job_id"].(string)
+	if !ok || jobID == "" {
+		return NewToolError("Missing required parameter: query_job_id", nil), nil
+	}
+	if t.jobs == nil {
+		return NewToolError("Async queries are not available", nil), nil
+	}
+
+	execCtx, _ := ExecutionContextFrom(ctx)
+	job, err := t.jobs.Get(ctx, jobID)
+	if errors.Is(err, jobs.ErrJobNotFound) || (err == nil && job.ProjectID != execCtx.ProjectID) {
+		return NewToolError("Query job not found", nil), nil
+	}
+	if err != nil {
+		return NewToolError("Failed to load query job", err), nil
+	}
+
+	data := map[string]interface{}{"job": job}
+	if job.Status == jobs.StatusCompleted {
+		offset := 0
+		if o, ok := params["offset"].(float64); ok && o > 0 {
+			offset = int(o)
+		}
+		limit := defaultJobPreviewRows
+		if l, ok := params["limit"].(float64); ok && l > 0 {
+			limit = int(l)
+		}
+		if limit > maxJobPreviewRows {
+			limit = maxJobPreviewRows
+		}
+
+		page, err := t.jobs.Results(ctx, job, offset, limit)
+		if err != nil {
+			return NewToolError("Failed to load query job result", err), nil
+		}
+		data["result"] = page
+	}
+
+	return NewToolSuccess(data, 0), nil
+}
+
+// ValidateAccess checks if user can see the project's query jobs
+func (t *QueryJobStatusTool) ValidateAccess(userID, projectID string) bool {
+	return hasProjectRole(t.permissions, userID, projectID, RoleViewer)
+}
+
+// GetCategory returns tool category
+func (t *QueryJobStatusTool) GetCategory() string {
+	return "database"
+}
@@ -0,0 +1,381 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"zlay-backend/internal/db"
+)
+
+// ErrCodeQueryPolicyViolation is returned for statements touching a table the
+// datasource's query policy does not allow
+const ErrCodeQueryPolicyViolation = "QUERY_POLICY_VIOLATION"
+
+// Query policy rule effects and pattern types
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+
+	PolicyTypeTable = "table" // Case-insensitive glob such as payroll.* or rpt_*
+	PolicyTypeRegex = "regex" // Case-insensitive regular expression
+)
+
+// QueryPolicyRule allows or denies the tables matching a pattern. Table
+// patterns without a dot match the table name alone; patterns with one match
+// the trailing parts of schema-qualified references, so payroll.* only
+// matches tables written with the payroll schema. Regexes match the
+// reference as written, schema included.
+type QueryPolicyRule struct {
+	Effect  string `json:"effect"`
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+}
+
+// QueryPolicy holds a datasource's query_policies. A table matched by any deny
+// rule is refused; when the policy has allow rules, a table must also match one
+// of them. Rules are checked in order and the first match is reported.
+type QueryPolicy struct {
+	Rules   []QueryPolicyRule
+	regexes []*regexp.Regexp // Compiled regex rules, nil for table rules
+	allows  bool
+}
+
+// QueryPolicyError reports an invalid rule of a query policy
+type QueryPolicyError struct {
+	Index  int
+	Reason string
+}
+
+func (e *QueryPolicyError) Error() string {
+	return fmt.Sprintf("query_policies[%d]: %s", e.Index, e.Reason)
+}
+
+// ParseQueryPolicy decodes and validates a query_policies JSON array. An empty
+// value gives an empty policy that allows every table.
+func ParseQueryPolicy(raw []byte) (*QueryPolicy, error) {
+	policy := &QueryPolicy{}
+	if len(strings.TrimSpace(string(raw))) == 0 || string(raw) == "null" {
+		return policy, nil
+	}
+	if err := json.Unmarshal(raw, &policy.Rules); err != nil {
+		return nil, fmt.Errorf("query_policies must be an array of rules: %w", err)
+	}
+
+	policy.regexes = make([]*regexp.Regexp, len(policy.Rules))
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		rule.Effect = strings.ToLower(strings.TrimSpace(rule.Effect))
+		rule.Type = strings.ToLower(strings.TrimSpace(rule.Type))
+		rule.Pattern = strings.TrimSpace(rule.Pattern)
+		if rule.Type == "" {
+			rule.Type = PolicyTypeTable
+		}
+
+		switch {
+		case rule.Effect != PolicyAllow && rule.Effect != PolicyDeny:
+			return nil, &QueryPolicyError{Index: i, Reason: "effect must be allow or deny"}
+		case rule.Pattern == "":
+			return nil, &QueryPolicyError{Index: i, Reason: "pattern is required"}
+		}
+		switch rule.Type {
+		case PolicyTypeTable:
+			if _, err := path.Match(strings.ToLower(rule.Pattern), ""); err != nil {
+				return nil, &QueryPolicyError{Index: i, Reason: "pattern is not a valid table pattern"}
+			}
+		case PolicyTypeRegex:
+			re, err := regexp.Compile("(?i)" + rule.Pattern)
+			if err != nil {
+				return nil, &QueryPolicyError{Index: i, Reason: "pattern is not a valid regular expression"}
+			}
+			policy.regexes[i] = re
+		default:
+			return nil, &QueryPolicyError{Index: i, Reason: "type must be table or regex"}
+		}
+		if rule.Effect == PolicyAllow {
+			policy.allows = true
+		}
+	}
+	return policy, nil
+}
+
+// QueryPolicyViolation is why a table was refused; Rule is nil when the table
+// matched no allow rule
+type QueryPolicyViolation struct {
+	Table     string
+	Rule      *QueryPolicyRule
+	RuleIndex int
+}
+
+func (v *QueryPolicyViolation) Error() string {
+	if v.Rule == nil {
+		return fmt.Sprintf("table %q is not allowed by any query policy allow rule", v.Table)
+	}
+	return fmt.Sprintf("table %q is denied by query policy rule %d (%s %s %q)",
+		v.Table, v.RuleIndex, v.Rule.Effect, v.Rule.Type, v.Rule.Pattern)
+}
+
+// toolResult converts the violation into a failed tool result naming the rule
+func (v *QueryPolicyViolation) toolResult() *ToolResult {
+	data := map[string]interface{}{"table": v.Table}
+	if v.Rule != nil {
+		data["rule"] = *v.Rule
+		data["rule_index"] = v.RuleIndex
+	}
+	return NewToolErrorWithCode(ErrCodeQueryPolicyViolation, v.Error(), data)
+}
+
+// CheckTable reports whether the policy refuses a table reference, which may be
+// schema-qualified
+func (p *QueryPolicy) CheckTable(table string) *QueryPolicyViolation {
+	if p == nil || len(p.Rules) == 0 {
+		return nil
+	}
+
+	allowed := !p.allows
+	for i, rule := range p.Rules {
+		if !p.matches(i, table) {
+			continue
+		}
+		if rule.Effect == PolicyDeny {
+			return &QueryPolicyViolation{Table: table, Rule: &p.Rules[i], RuleIndex: i}
+		}
+		allowed = true
+	}
+	if !allowed {
+		return &QueryPolicyViolation{Table: table}
+	}
+	return nil
+}
+
+// CheckStatement checks every table a statement references
+func (p *QueryPolicy) CheckStatement(statement string) *QueryPolicyViolation {
+	if p == nil || len(p.Rules) == 0 {
+		return nil
+	}
+	for _, table := range referencedTables(statement) {
+		if violation := p.CheckTable(table); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+func (p *QueryPolicy) matches(i int, table string) bool {
+	if re := p.regexes[i]; re != nil {
+		return re.MatchString(table)
+	}
+
+	pattern := strings.ToLower(p.Rules[i].Pattern)
+	parts := strings.Split(strings.ToLower(table), ".")
+	patternParts := strings.Count(pattern, ".") + 1
+	if patternParts > len(parts) {
+		return false
+	}
+	matched, _ := path.Match(pattern, strings.Join(parts[len(parts)-patternParts:], "."))
+	return matched
+}
+
+// filterTables drops the tables the policy refuses; a "schema" property
+// qualifies the name
+func (p *QueryPolicy) filterTables(tables []TableInfo) []TableInfo {
+	if p == nil || len(p.Rules) == 0 {
+		return tables
+	}
+	allowed := make([]TableInfo, 0, len(tables))
+	for _, table := range tables {
+		if p.CheckTable(qualifiedTableName(table)) == nil {
+			allowed = append(allowed, table)
+		}
+	}
+	return allowed
+}
+
+// filterRelations drops relations with either end refused by the policy
+func (p *QueryPolicy) filterRelations(relations []RelationInfo) []RelationInfo {
+	if p == nil || len(p.Rules) == 0 {
+		return relations
+	}
+	allowed := make([]RelationInfo, 0, len(relations))
+	for _, relation := range relations {
+		if p.CheckTable(relation.FromTable) == nil && p.CheckTable(relation.ToTable) == nil {
+			allowed = append(allowed, relation)
+		}
+	}
+	return allowed
+}
+
+func qualifiedTableName(table TableInfo) string {
+	if schema, _ := table.Properties["schema"].(string); schema != "" {
+		return schema + "." + table.Name
+	}
+	return table.Name
+}
+
+// loadQueryPolicy reads the query policy of a datasource. A policy that cannot
+// be parsed is an error, so a broken policy never lets queries through.
+func loadQueryPolicy(ctx context.Context, zdb *db.Database, datasourceID string) (*QueryPolicy, error) {
+	if zdb == nil || datasourceID == "" {
+		return nil, nil
+	}
+	row, err := zdb.QueryRow(ctx, "SELECT query_policies FROM datasources WHERE id = $1", datasourceID)
+	if errors.Is(err, db.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query policy: %w", err)
+	}
+	raw, ok := row.Values[0].AsBytes()
+	if !ok {
+		text, _ := row.Values[0].AsString()
+		raw = []byte(text)
+	}
+	return ParseQueryPolicy(raw)
+}
+
+// tableKeywords are followed by a list of table references
+var tableKeywords = map[string]bool{
+	"from": true, "join": true, "update": true, "into": true, "table": true, "using": true,
+}
+
+// referencedTables extracts the table references of a statement, schema
+// qualification included, in order of appearance. Names defined by WITH are
+// left out unless qualified, and FROM inside function arguments such as
+// EXTRACT(YEAR FROM x) is ignored. Subqueries are covered since every FROM and
+// JOIN of the statement is read, however deeply nested.
+func referencedTables(statement string) []string {
+	tokens := tokenizeSQL(statement)
+
+	// WITH name [(columns)] AS (...); a name after TABLE is a CREATE TABLE ... AS
+	ctes := make(map[string]bool)
+	for i := 1; i+1 < len(tokens); i++ {
+		if !tokens[i].is("as") || !tokens[i+1].is("(") {
+			continue
+		}
+		nameAt := i - 1
+		if tokens[nameAt].is(")") {
+			for depth := 0; nameAt > 0; nameAt-- {
+				if tokens[nameAt].is(")") {
+					depth++
+				} else if tokens[nameAt].is("(") {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			nameAt--
+		}
+		if nameAt > 0 && tokens[nameAt].isIdentifier() {
+			if before := tokens[nameAt-1]; before.is("with") || before.is("recursive") || before.is(",") {
+				ctes[strings.ToLower(tokens[nameAt].text)] = true
+			}
+		}
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	var inFunction []bool
+	for i, token := range tokens {
+		if token.is("(") {
+			inFunction = append(inFunction, i > 0 && (tokens[i-1].kind == tokenQuoted ||
+				tokens[i-1].kind == tokenWord && !sqlKeywords[strings.ToLower(tokens[i-1].text)] && !tableKeywords[strings.ToLower(tokens[i-1].text)]))
+			continue
+		}
+		if token.is(")") && len(inFunction) > 0 {
+			inFunction = inFunction[:len(inFunction)-1]
+			continue
+		}
+		if token.kind != tokenWord || !tableKeywords[strings.ToLower(token.text)] {
+			continue
+		}
+
+		keyword := strings.ToLower(token.text)
+		var previous string
+		if i > 0 {
+			previous = strings.ToLower(tokens[i-1].text)
+		}
+		switch {
+		case keyword == "from" && len(inFunction) > 0 && inFunction[len(inFunction)-1]:
+			continue // EXTRACT(YEAR FROM x), SUBSTRING(x FROM 2)
+		case keyword == "from" && previous == "distinct":
+			continue // IS DISTINCT FROM
+		case keyword == "update" && (previous == "for" || previous == "do" || previous == "key"):
+			continue // FOR UPDATE, ON CONFLICT DO UPDATE, ON DUPLICATE KEY UPDATE
+		}
+
+		for _, table := range tableList(tokens[i+1:], keyword) {
+			if !strings.Contains(table, ".") && ctes[strings.ToLower(table)] {
+				continue
+			}
+			if !seen[strings.ToLower(table)] {
+				seen[strings.ToLower(table)] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables
+}
+
+// tableList reads the comma-separated table references following keyword
+func tableList(tokens []sqlToken, keyword string) []string {
+	var tables []string
+	i := 0
+	for i < len(tokens) {
+		// FROM ONLY t, JOIN LATERAL, CREATE TABLE IF NOT EXISTS t
+		for i < len(tokens) && (tokens[i].is("only") || tokens[i].is("lateral") ||
+			tokens[i].is("if") || tokens[i].is("not") || tokens[i].is("exists")) {
+			i++
+		}
+		if i >= len(tokens) || !tokens[i].isIdentifier() {
+			return tables // Subqueries and anything else are read by the caller
+		}
+
+		var parts []string
+		for i < len(tokens) && tokens[i].isIdentifier() {
+			parts = append(parts, tokens[i].text)
+			i++
+			if i+1 < len(tokens) && tokens[i].is(".") {
+				i++
+				continue
+			}
+			break
+		}
+		// A name called like a function after FROM or JOIN is a table function
+		isFunction := i < len(tokens) && tokens[i].is("(") && keyword != "into" && keyword != "table"
+		if !isFunction {
+			tables = append(tables, strings.Join(parts, "."))
+		}
+		if keyword != "from" && keyword != "using" {
+			return tables
+		}
+
+		// Skip the function arguments or alias, then continue after a comma
+		if isFunction {
+			for depth := 0; i < len(tokens); i++ {
+				if tokens[i].is("(") {
+					depth++
+				} else if tokens[i].is(")") {
+					if depth--; depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+		}
+		if i < len(tokens) && tokens[i].is("as") {
+			i++
+		}
+		if i < len(tokens) && tokens[i].isIdentifier() {
+			i++
+		}
+		if i >= len(tokens) || !tokens[i].is(",") {
+			return tables
+		}
+		i++
+	}
+	return tables
+}
@@ -5,18 +5,45 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
+
+	"zlay-backend/internal/requestid"
 )
 
+// toolSettingsCacheTTL controls how long per-project tool settings are cached
+const toolSettingsCacheTTL = 30 * time.Second
+
 // DefaultToolRegistry implements ToolRegistry
 type DefaultToolRegistry struct {
 	tools map[string]Tool
 	mutex sync.RWMutex
+
+	// Per-project tool settings (optional)
+	settings      ToolSettingsStore
+	settingsCache map[string]*projectToolSettings
+	cacheMutex    sync.RWMutex
+
+	// Recent results per conversation, referenced by later tool calls
+	results *ResultStore
+
+	// Per-tool timeout and concurrency limits, guarded by mutex
+	limits map[string]*toolLimits
+	// In-flight executions by tool call ID
+	executions      map[string]*toolExecution
+	executionsMutex sync.Mutex
+
+	// Where executions are recorded for the audit log (optional), guarded by mutex
+	auditor ExecutionAuditor
 }
 
 // NewDefaultToolRegistry creates a new default tool registry
 func NewDefaultToolRegistry() *DefaultToolRegistry {
 	registry := &DefaultToolRegistry{
-		tools: make(map[string]Tool),
+		tools:         make(map[string]Tool),
+		settingsCache: make(map[string]*projectToolSettings),
+		results:       NewResultStore(DefaultResultsPerConversation, DefaultResultTTL),
+		limits:        make(map[string]*toolLimits),
+		executions:    make(map[string]*toolExecution),
 	}
 	
 	// Register built-in tools
@@ -25,19 +52,9 @@ func NewDefaultToolRegistry() *DefaultToolRegistry {
 	return registry
 }
 
-// RegisterTool adds a new tool to the registry
+// RegisterTool adds a new tool to the registry with the default limits
 func (r *DefaultToolRegistry) RegisterTool(tool Tool) error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	
-	name := tool.Name()
-	if _, exists := r.tools[name]; exists {
-		return fmt.Errorf("tool '%s' is already registered", name)
-	}
-	
-	r.tools[name] = tool
-	log.Printf("Registered tool: %s", name)
-	return nil
+	return r.RegisterToolWithConfig(tool, ToolConfig{})
 }
 
 // UnregisterTool removes a tool from the registry
@@ -50,6 +67,7 @@ func (r *DefaultToolRegistry) UnregisterTool(name string) error {
 	}
 	
 	delete(r.tools, name)
+	delete(r.limits, name)
 	log.Printf("Unregistered tool: %s", name)
 	return nil
 }
@@ -65,29 +83,110 @@ func (r *DefaultToolRegistry) GetTool(name string) (Tool, bool) {
 
 // GetAvailableTools returns all tools available for a project
 func (r *DefaultToolRegistry) GetAvailableTools(projectID string) []Tool {
+	disabled := r.getDisabledTools(projectID)
+
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	
 	var availableTools []Tool
-	for _, tool := range r.tools {
-		// For now, make all tools available to all projects
-		// TODO: Implement project-based tool restrictions
+	for name, tool := range r.tools {
+		if disabled[name] {
+			continue
+		}
 		availableTools = append(availableTools, tool)
 	}
 	
 	return availableTools
 }
 
+// SetSettingsStore configures where per-project tool settings are persisted
+func (r *DefaultToolRegistry) SetSettingsStore(store ToolSettingsStore) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	r.settings = store
+	r.settingsCache = make(map[string]*projectToolSettings)
+}
+
+// IsToolEnabled reports whether a tool is enabled for a project
+func (r *DefaultToolRegistry) IsToolEnabled(projectID, toolName string) bool {
+	return !r.getDisabledTools(projectID)[toolName]
+}
+
+// SetToolEnabled enables or disables a registered tool for a project
+func (r *DefaultToolRegistry) SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error {
+	if _, exists := r.GetTool(toolName); !exists {
+		return ErrToolNotFound
+	}
+
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if r.settings == nil {
+		return fmt.Errorf("tool settings store is not configured")
+	}
+
+	if err := r.settings.SetToolEnabled(ctx, projectID, toolName, enabled); err != nil {
+		return err
+	}
+
+	delete(r.settingsCache, projectID)
+	log.Printf("Tool %s enabled=%t for project %s", toolName, enabled, projectID)
+	return nil
+}
+
+// getDisabledTools returns the disabled tool set for a project, using the cache when fresh
+func (r *DefaultToolRegistry) getDisabledTools(projectID string) map[string]bool {
+	r.cacheMutex.RLock()
+	store := r.settings
+	cached, exists := r.settingsCache[projectID]
+	r.cacheMutex.RUnlock()
+
+	if store == nil || projectID == "" {
+		return nil
+	}
+	if exists && time.Since(cached.loadedAt) < toolSettingsCacheTTL {
+		return cached.disabled
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	disabled, err := store.GetDisabledTools(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to load tool settings for project %s: %v", projectID, err)
+		if exists {
+			// Keep serving the stale settings rather than re-enabling tools
+			return cached.disabled
+		}
+		return nil
+	}
+
+	r.cacheMutex.Lock()
+	r.settingsCache[projectID] = &projectToolSettings{
+		disabled: disabled,
+		loadedAt: time.Now(),
+	}
+	r.cacheMutex.Unlock()
+
+	return disabled
+}
+
 // ExecuteTool executes a tool by name with given parameters
 func (r *DefaultToolRegistry) ExecuteTool(ctx context.Context, userID, projectID, toolName string, params map[string]interface{}) (*ToolResult, error) {
 	tool, exists := r.GetTool(toolName)
 	if !exists {
 		return nil, ErrToolNotFound
 	}
+
+	// Refuse tools disabled for this project
+	if !r.IsToolEnabled(projectID, toolName) {
+		return nil, ErrToolDisabled
+	}
 	
 	// Validate user access
 	if !tool.ValidateAccess(userID, projectID) {
-		return nil, ErrToolAccessDenied
+		return nil, &PermissionError{ToolName: toolName, UserID: userID, ProjectID: projectID}
 	}
 	
 	// Validate parameters
@@ -95,17 +194,26 @@ func (r *DefaultToolRegistry) ExecuteTool(ctx context.Context, userID, projectID
 		return nil, fmt.Errorf("invalid parameters for tool %s: %w", toolName, err)
 	}
 	
-	// Execute tool
-	log.Printf("Executing tool %s for user %s in project %s", toolName, userID, projectID)
-	result, err := tool.Execute(ctx, params)
+	// Execute tool within its timeout and concurrency limit
+	requestid.Logf(ctx, "Executing tool %s for user %s in project %s", toolName, userID, projectID)
+	ctx = WithExecutionContext(ctx, userID, projectID)
+	startedAt := time.Now()
+	result, err := r.runLimited(ctx, tool, params)
+	r.audit(ctx, tool, params, startedAt, result, err)
 	
 	if err != nil {
+		requestid.Logf(ctx, "Tool %s failed for user %s in project %s: %v", toolName, userID, projectID, err)
 		return NewToolError(fmt.Sprintf("Tool %s failed", toolName), err), nil
 	}
 	
 	return result, nil
 }
 
+// Results returns the per-conversation store of recent tool results
+func (r *DefaultToolRegistry) Results() *ResultStore {
+	return r.results
+}
+
 // ListTools returns a list of all registered tools
 func (r *DefaultToolRegistry) ListTools() []Tool {
 	r.mutex.RLock()
@@ -168,6 +276,33 @@ func (r *EmptyToolRegistry) ExecuteTool(ctx context.Context, userID, projectID,
 	return nil, ErrToolNotFound
 }
 
+// IsToolEnabled reports whether a tool is enabled for a project
+func (r *EmptyToolRegistry) IsToolEnabled(projectID, toolName string) bool {
+	// Nothing is registered, so nothing is enabled
+	return false
+}
+
+// SetToolEnabled enables or disables a tool for a project
+func (r *EmptyToolRegistry) SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error {
+	// Always return not found for empty registry
+	return ErrToolNotFound
+}
+
+// Results returns nil; the empty registry keeps no results
+func (r *EmptyToolRegistry) Results() *ResultStore {
+	return nil
+}
+
+// CancelToolExecution reports false; the empty registry runs nothing
+func (r *EmptyToolRegistry) CancelToolExecution(toolCallID string) bool {
+	return false
+}
+
+// CancelConversationTools returns 0; the empty registry runs nothing
+func (r *EmptyToolRegistry) CancelConversationTools(conversationID string) int {
+	return 0
+}
+
 // ListTools returns a list of all registered tools
 func (r *EmptyToolRegistry) ListTools() []Tool {
 	// Always return empty for empty registry
@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultResultsPerConversation bounds how many tool results are kept per conversation
+	DefaultResultsPerConversation = 20
+	// DefaultResultTTL is how long a conversation's results are kept after its last tool call
+	DefaultResultTTL = time.Hour
+)
+
+// ResultStore keeps recent tool results in memory, per conversation and keyed by
+// tool_call_id, so a later tool call can reference an earlier result instead of
+// having the model copy rows into its arguments
+type ResultStore struct {
+	mutex           sync.Mutex
+	perConversation int
+	ttl             time.Duration
+	now             func() time.Time
+	conversations   map[string]*conversationResults
+}
+
+type conversationResults struct {
+	order   []string // tool_call_ids, oldest first
+	results map[string]*ToolResult
+	touched time.Time
+}
+
+// NewResultStore creates a result store; non-positive values fall back to the defaults
+func NewResultStore(perConversation int, ttl time.Duration) *ResultStore {
+	if perConversation <= 0 {
+		perConversation = DefaultResultsPerConversation
+	}
+	if ttl <= 0 {
+		ttl = DefaultResultTTL
+	}
+	return &ResultStore{
+		perConversation: perConversation,
+		ttl:             ttl,
+		now:             time.Now,
+		conversations:   make(map[string]*conversationResults),
+	}
+}
+
+// Put records a tool result, evicting the conversation's oldest result when it is full
+func (s *ResultStore) Put(conversationID, toolCallID string, result *ToolResult) {
+	if conversationID == "" || toolCallID == "" || result == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.pruneLocked(now)
+
+	conv, exists := s.conversations[conversationID]
+	if !exists {
+		conv = &conversationResults{results: make(map[string]*ToolResult)}
+		s.conversations[conversationID] = conv
+	}
+	if _, exists := conv.results[toolCallID]; !exists {
+		conv.order = append(conv.order, toolCallID)
+	}
+	conv.results[toolCallID] = result
+	conv.touched = now
+
+	for len(conv.order) > s.perConversation {
+		delete(conv.results, conv.order[0])
+		conv.order = conv.order[1:]
+	}
+}
+
+// Get returns a conversation's result for a tool call, if it is still kept
+func (s *ResultStore) Get(conversationID, toolCallID string) (*ToolResult, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conv, exists := s.conversations[conversationID]
+	if !exists || s.now().Sub(conv.touched) > s.ttl {
+		return nil, false
+	}
+	result, exists := conv.results[toolCallID]
+	return result, exists
+}
+
+// Forget drops all results of a conversation
+func (s *ResultStore) Forget(conversationID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conversations, conversationID)
+}
+
+func (s *ResultStore) pruneLocked(now time.Time) {
+	for id, conv := range s.conversations {
+		if now.Sub(conv.touched) > s.ttl {
+			delete(s.conversations, id)
+		}
+	}
+}
+
+type conversationKey struct{}
+
+// WithConversation attaches the conversation a tool call belongs to
+func WithConversation(ctx context.Context, conversationID string) context.Context {
+	return context.WithValue(ctx, conversationKey{}, conversationID)
+}
+
+// ConversationFrom returns the conversation attached by the chat service, if any
+func ConversationFrom(ctx context.Context) (string, bool) {
+	conversationID, ok := ctx.Value(conversationKey{}).(string)
+	return conversationID, ok && conversationID != ""
+}
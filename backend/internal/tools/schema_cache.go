@@ -0,0 +1,221 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+const (
+	// DefaultSchemaCacheTTL is how long an inspected schema is served before it is read again
+	DefaultSchemaCacheTTL = 10 * time.Minute
+	// DefaultSchemaLoadTimeout bounds reading the schema of one datasource
+	DefaultSchemaLoadTimeout = 2 * time.Minute
+)
+
+// SchemaInspector reads the tables of a datasource; *DatasourceInspector implements it
+type SchemaInspector interface {
+	InspectDatasource(ctx context.Context, dbType string) (*DatasourceInfo, error)
+	InspectTable(ctx context.Context, tableName string, includeStats bool) (*TableInfo, error)
+}
+
+// SchemaSource opens an inspector for a datasource along with its type.
+// release closes the connection once the schema has been read.
+type SchemaSource func(ctx context.Context, datasourceID string) (inspector SchemaInspector, dbType string, release func(), err error)
+
+// CachedSchema is the tables of a datasource with their columns and indexes,
+// without statistics. It is shared by every reader and must not be modified.
+type CachedSchema struct {
+	DatasourceID string
+	Type         string
+	Tables       []TableInfo
+	Generation   uint64 // Changes whenever a reload finds a different schema
+	LoadedAt     time.Time
+}
+
+// Table finds a table by name, or by schema-qualified name
+func (s *CachedSchema) Table(name string) (*TableInfo, bool) {
+	for i := range s.Tables {
+		if s.Tables[i].Name == name || qualifiedTableName(s.Tables[i]) == name {
+			return &s.Tables[i], true
+		}
+	}
+	return nil, false
+}
+
+// SchemaCache keeps the inspected schema of each datasource for the
+// datasource_inspect tool and the editor metadata endpoints, so a warehouse
+// is read once per TTL however many callers ask. Concurrent requests for a
+// datasource being read wait for that read rather than starting their own.
+type SchemaCache struct {
+	source     SchemaSource
+	ttl        time.Duration
+	timeout    time.Duration
+	generation atomic.Uint64
+	mutex      sync.Mutex
+	entries    map[string]*schemaEntry
+	now        func() time.Time
+}
+
+type schemaEntry struct {
+	loading sync.Mutex // Held while the schema is read
+	schema  *CachedSchema
+	hash    [sha256.Size]byte
+	stale   atomic.Bool // Set by Invalidate, without waiting for a read in progress
+}
+
+// NewSchemaCache creates a cache reading schemas from source. A zero ttl uses
+// DefaultSchemaCacheTTL.
+func NewSchemaCache(source SchemaSource, ttl time.Duration) *SchemaCache {
+	if ttl <= 0 {
+		ttl = DefaultSchemaCacheTTL
+	}
+	cache := &SchemaCache{
+		source:  source,
+		ttl:     ttl,
+		timeout: DefaultSchemaLoadTimeout,
+		entries: make(map[string]*schemaEntry),
+		now:     time.Now,
+	}
+	// Generations start from the clock so they are not reused across restarts
+	cache.generation.Store(uint64(time.Now().UnixNano()))
+	return cache
+}
+
+// Get returns the schema of a datasource, reading it when it is not cached or
+// has expired. When a reload fails the previous schema is served until the
+// next TTL; the error is only returned when there is nothing to serve.
+func (c *SchemaCache) Get(ctx context.Context, datasourceID string) (*CachedSchema, error) {
+	c.mutex.Lock()
+	entry, exists := c.entries[datasourceID]
+	if !exists {
+		entry = &schemaEntry{}
+		c.entries[datasourceID] = entry
+	}
+	c.mutex.Unlock()
+
+	entry.loading.Lock()
+	defer entry.loading.Unlock()
+	now := c.now()
+	if entry.schema != nil && !entry.stale.Load() && now.Sub(entry.schema.LoadedAt) < c.ttl {
+		return entry.schema, nil
+	}
+	entry.stale.Store(false)
+
+	// The read outlives a cancelled caller, since others may be waiting on it
+	loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	dbType, tables, err := c.load(loadCtx, datasourceID)
+	if err != nil {
+		if entry.schema == nil {
+			return nil, err
+		}
+		log.Printf("Failed to reload schema of datasource %s, serving the cached one: %v", datasourceID, err)
+		refreshed := *entry.schema
+		refreshed.LoadedAt = now
+		entry.schema = &refreshed
+		return entry.schema, nil
+	}
+
+	encoded, _ := json.Marshal(tables)
+	hash := sha256.Sum256([]byte(dbType + "\x00" + string(encoded)))
+	var generation uint64
+	if entry.schema != nil && hash == entry.hash {
+		generation = entry.schema.Generation
+	} else {
+		generation = c.generation.Add(1)
+	}
+	entry.schema = &CachedSchema{DatasourceID: datasourceID, Type: dbType, Tables: tables, Generation: generation, LoadedAt: now}
+	entry.hash = hash
+	return entry.schema, nil
+}
+
+// Invalidate makes the next Get read the datasource again, such as after its
+// connection settings change. The generation only changes if the schema did.
+func (c *SchemaCache) Invalidate(datasourceID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, exists := c.entries[datasourceID]; exists {
+		entry.stale.Store(true)
+	}
+}
+
+// load reads every table of a datasource with its columns and indexes
+func (c *SchemaCache) load(ctx context.Context, datasourceID string) (string, []TableInfo, error) {
+	inspector, dbType, release, err := c.source(ctx, datasourceID)
+	if err != nil {
+		return "", nil, err
+	}
+	if release != nil {
+		defer release()
+	}
+
+	info, err := inspector.InspectDatasource(ctx, dbType)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to inspect datasource: %w", err)
+	}
+	if tablesError, exists := info.Properties["tables_error"]; exists {
+		return "", nil, fmt.Errorf("failed to list tables: %v", tablesError)
+	}
+	if dbType == "" {
+		dbType = info.Type
+	}
+
+	tables := make([]TableInfo, 0, len(info.Tables))
+	for _, listed := range info.Tables {
+		table, err := inspector.InspectTable(ctx, listed.Name, false)
+		if err != nil {
+			// One unreadable table should not hide the rest
+			table = &TableInfo{Name: listed.Name, Properties: map[string]interface{}{"inspection_error": err.Error()}}
+		}
+		if table.Type == "" {
+			table.Type = listed.Type
+		}
+		if schema, _ := listed.Properties["schema"].(string); schema != "" {
+			if table.Properties == nil {
+				table.Properties = make(map[string]interface{})
+			}
+			table.Properties["schema"] = schema
+		}
+		if len(table.Properties) == 0 {
+			table.Properties = nil
+		}
+		tables = append(tables, *table)
+	}
+	return strings.ToLower(dbType), tables, nil
+}
+
+// DatasourceSchemaSource opens the datasources stored in the application
+// database, the way the datasource tools connect to them
+func DatasourceSchemaSource(zdb *db.Database) SchemaSource {
+	return func(ctx context.Context, datasourceID string) (SchemaInspector, string, func(), error) {
+		ctx, err := withDatasourceProject(ctx, zdb, datasourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		conn, err := OpenDatasourceConnection(ctx, zdb, datasourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		release := func() {
+			if closer, ok := conn.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+		dbType, err := (&DatasourceInspectTool{zdb: zdb}).getDatasourceType(ctx, datasourceID)
+		if err != nil {
+			release()
+			return nil, "", nil, err
+		}
+		return NewDatasourceInspector(conn, dbType), dbType, release, nil
+	}
+}
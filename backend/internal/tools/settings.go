@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+// ToolSettingsStore persists per-project tool configuration
+type ToolSettingsStore interface {
+	// GetDisabledTools returns the set of tool names disabled for a project
+	GetDisabledTools(ctx context.Context, projectID string) (map[string]bool, error)
+
+	// SetToolEnabled enables or disables a tool for a project
+	SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error
+}
+
+// DBToolSettingsStore implements ToolSettingsStore on top of the project_tools table
+type DBToolSettingsStore struct {
+	db DBConnection
+}
+
+// NewDBToolSettingsStore creates a new database-backed tool settings store
+func NewDBToolSettingsStore(db DBConnection) *DBToolSettingsStore {
+	return &DBToolSettingsStore{db: db}
+}
+
+// GetDisabledTools returns the set of tool names disabled for a project
+func (s *DBToolSettingsStore) GetDisabledTools(ctx context.Context, projectID string) (map[string]bool, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT tool_name FROM project_tools WHERE project_id = $1 AND enabled = false",
+		projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project tools: %w", err)
+	}
+	defer rows.Close()
+
+	disabled := make(map[string]bool)
+	for rows.Next() {
+		var toolName string
+		if err := rows.Scan(&toolName); err != nil {
+			return nil, fmt.Errorf("failed to scan project tool: %w", err)
+		}
+		disabled[toolName] = true
+	}
+
+	return disabled, rows.Err()
+}
+
+// SetToolEnabled enables or disables a tool for a project
+func (s *DBToolSettingsStore) SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error {
+	update := func() (int64, error) {
+		result, err := s.db.Exec(ctx,
+			"UPDATE project_tools SET enabled = $1, updated_at = CURRENT_TIMESTAMP WHERE project_id = $2 AND tool_name = $3",
+			enabled, projectID, toolName)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+
+	updated, err := update()
+	if err == nil && updated == 0 {
+		_, err = s.db.Exec(ctx,
+			"INSERT INTO project_tools (project_id, tool_name, enabled, updated_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP)",
+			projectID, toolName, enabled)
+		// Another request inserted the row first
+		if db.IsUniqueViolation(err) {
+			_, err = update()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update project tool: %w", err)
+	}
+	return nil
+}
+
+// projectToolSettings is a cached snapshot of a project's disabled tools
+type projectToolSettings struct {
+	disabled map[string]bool
+	loadedAt time.Time
+}
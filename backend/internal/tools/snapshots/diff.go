@@ -0,0 +1,234 @@
+package snapshots
+
+import (
+	"sort"
+	"strings"
+)
+
+// Schema is the serialized structure of a datasource at one point in time
+type Schema struct {
+	Tables []Table `json:"tables"`
+}
+
+// Table is a table or view with its columns and indexes
+type Table struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type,omitempty"`
+	Columns []Column `json:"columns"`
+	Indexes []Index  `json:"indexes,omitempty"`
+}
+
+// Column is a column definition as reported by the datasource
+type Column struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Nullable   bool   `json:"nullable"`
+	PrimaryKey bool   `json:"primary_key,omitempty"`
+}
+
+// Index is an index definition
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+}
+
+// ColumnRef names a column that was added or removed
+type ColumnRef struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Type   string `json:"type"`
+}
+
+// ColumnChange is a column whose type or nullability changed
+type ColumnChange struct {
+	Table        string `json:"table"`
+	Column       string `json:"column"`
+	FromType     string `json:"from_type"`
+	ToType       string `json:"to_type"`
+	FromNullable bool   `json:"from_nullable"`
+	ToNullable   bool   `json:"to_nullable"`
+}
+
+// IndexRef names an index that was added or dropped
+type IndexRef struct {
+	Table string `json:"table"`
+	Index string `json:"index"`
+}
+
+// Diff lists the changes between two schemas. Columns and indexes of added or
+// dropped tables are not listed separately, and a renamed column shows up as
+// one removed and one added column.
+type Diff struct {
+	AddedTables    []string       `json:"added_tables"`
+	DroppedTables  []string       `json:"dropped_tables"`
+	AddedColumns   []ColumnRef    `json:"added_columns"`
+	RemovedColumns []ColumnRef    `json:"removed_columns"`
+	RetypedColumns []ColumnChange `json:"retyped_columns"`
+	AddedIndexes   []IndexRef     `json:"added_indexes"`
+	DroppedIndexes []IndexRef     `json:"dropped_indexes"`
+}
+
+// Empty reports whether the schemas were identical
+func (d *Diff) Empty() bool {
+	return len(d.AddedTables) == 0 && len(d.DroppedTables) == 0 &&
+		len(d.AddedColumns) == 0 && len(d.RemovedColumns) == 0 && len(d.RetypedColumns) == 0 &&
+		len(d.AddedIndexes) == 0 && len(d.DroppedIndexes) == 0
+}
+
+// Changes returns how many changes the diff holds
+func (d *Diff) Changes() int {
+	return len(d.AddedTables) + len(d.DroppedTables) +
+		len(d.AddedColumns) + len(d.RemovedColumns) + len(d.RetypedColumns) +
+		len(d.AddedIndexes) + len(d.DroppedIndexes)
+}
+
+// Compare returns the changes that turn from into to. A nil schema has no tables.
+// Table and column names are matched case-insensitively, and column types are
+// compared ignoring case and surrounding whitespace.
+func Compare(from, to *Schema) *Diff {
+	diff := &Diff{
+		AddedTables:    []string{},
+		DroppedTables:  []string{},
+		AddedColumns:   []ColumnRef{},
+		RemovedColumns: []ColumnRef{},
+		RetypedColumns: []ColumnChange{},
+		AddedIndexes:   []IndexRef{},
+		DroppedIndexes: []IndexRef{},
+	}
+	fromTables := tablesByName(from)
+	toTables := tablesByName(to)
+
+	for key, table := range toTables {
+		if _, exists := fromTables[key]; !exists {
+			diff.AddedTables = append(diff.AddedTables, table.Name)
+		}
+	}
+	for key, table := range fromTables {
+		newTable, exists := toTables[key]
+		if !exists {
+			diff.DroppedTables = append(diff.DroppedTables, table.Name)
+			continue
+		}
+		compareColumns(diff, table, newTable)
+		compareIndexes(diff, table, newTable)
+	}
+
+	diff.sort()
+	return diff
+}
+
+func compareColumns(diff *Diff, from, to Table) {
+	fromColumns := make(map[string]Column, len(from.Columns))
+	for _, column := range from.Columns {
+		fromColumns[strings.ToLower(column.Name)] = column
+	}
+	toColumns := make(map[string]Column, len(to.Columns))
+	for _, column := range to.Columns {
+		toColumns[strings.ToLower(column.Name)] = column
+	}
+
+	for key, column := range toColumns {
+		old, exists := fromColumns[key]
+		if !exists {
+			diff.AddedColumns = append(diff.AddedColumns, ColumnRef{Table: to.Name, Column: column.Name, Type: column.Type})
+			continue
+		}
+		if normalizeType(old.Type) != normalizeType(column.Type) || old.Nullable != column.Nullable {
+			diff.RetypedColumns = append(diff.RetypedColumns, ColumnChange{
+				Table:        to.Name,
+				Column:       column.Name,
+				FromType:     old.Type,
+				ToType:       column.Type,
+				FromNullable: old.Nullable,
+				ToNullable:   column.Nullable,
+			})
+		}
+	}
+	for key, column := range fromColumns {
+		if _, exists := toColumns[key]; !exists {
+			diff.RemovedColumns = append(diff.RemovedColumns, ColumnRef{Table: from.Name, Column: column.Name, Type: column.Type})
+		}
+	}
+}
+
+// compareIndexes matches indexes by name; an index whose columns or uniqueness
+// changed is reported as dropped and added again
+func compareIndexes(diff *Diff, from, to Table) {
+	fromIndexes := make(map[string]Index, len(from.Indexes))
+	for _, index := range from.Indexes {
+		fromIndexes[strings.ToLower(index.Name)] = index
+	}
+	toIndexes := make(map[string]Index, len(to.Indexes))
+	for _, index := range to.Indexes {
+		toIndexes[strings.ToLower(index.Name)] = index
+	}
+
+	for key, index := range toIndexes {
+		old, exists := fromIndexes[key]
+		if !exists || !sameIndex(old, index) {
+			diff.AddedIndexes = append(diff.AddedIndexes, IndexRef{Table: to.Name, Index: index.Name})
+		}
+		if exists && !sameIndex(old, index) {
+			diff.DroppedIndexes = append(diff.DroppedIndexes, IndexRef{Table: from.Name, Index: old.Name})
+		}
+	}
+	for key, index := range fromIndexes {
+		if _, exists := toIndexes[key]; !exists {
+			diff.DroppedIndexes = append(diff.DroppedIndexes, IndexRef{Table: from.Name, Index: index.Name})
+		}
+	}
+}
+
+func sameIndex(a, b Index) bool {
+	if a.Unique != b.Unique || len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if !strings.EqualFold(a.Columns[i], b.Columns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func tablesByName(schema *Schema) map[string]Table {
+	tables := make(map[string]Table)
+	if schema == nil {
+		return tables
+	}
+	for _, table := range schema.Tables {
+		tables[strings.ToLower(table.Name)] = table
+	}
+	return tables
+}
+
+func normalizeType(columnType string) string {
+	return strings.ToLower(strings.TrimSpace(columnType))
+}
+
+// sort orders every list so equal diffs serialize identically
+func (d *Diff) sort() {
+	sort.Strings(d.AddedTables)
+	sort.Strings(d.DroppedTables)
+	sortColumnRefs(d.AddedColumns)
+	sortColumnRefs(d.RemovedColumns)
+	sort.Slice(d.RetypedColumns, func(i, j int) bool {
+		a, b := d.RetypedColumns[i], d.RetypedColumns[j]
+		return a.Table < b.Table || (a.Table == b.Table && a.Column < b.Column)
+	})
+	sortIndexRefs(d.AddedIndexes)
+	sortIndexRefs(d.DroppedIndexes)
+}
+
+func sortColumnRefs(refs []ColumnRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Table < refs[j].Table || (refs[i].Table == refs[j].Table && refs[i].Column < refs[j].Column)
+	})
+}
+
+func sortIndexRefs(refs []IndexRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Table < refs[j].Table || (refs[i].Table == refs[j].Table && refs[i].Index < refs[j].Index)
+	})
+}
@@ -0,0 +1,206 @@
+package snapshots
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func usersTable() Table {
+	return Table{
+		Name: "users",
+		Type: "table",
+		Columns: []Column{
+			{Name: "id", Type: "integer", PrimaryKey: true},
+			{Name: "email", Type: "varchar(255)"},
+			{Name: "nickname", Type: "text", Nullable: true},
+		},
+		Indexes: []Index{
+			{Name: "users_email_key", Columns: []string{"email"}, Unique: true},
+		},
+	}
+}
+
+func ordersTable() Table {
+	return Table{
+		Name: "orders",
+		Type: "table",
+		Columns: []Column{
+			{Name: "id", Type: "integer", PrimaryKey: true},
+			{Name: "user_id", Type: "integer"},
+			{Name: "total", Type: "numeric(10,2)"},
+		},
+	}
+}
+
+func schemaOf(tables ...Table) *Schema {
+	return &Schema{Tables: tables}
+}
+
+func TestCompareIdenticalSchemas(t *testing.T) {
+	diff := Compare(schemaOf(usersTable(), ordersTable()), schemaOf(ordersTable(), usersTable()))
+	if !diff.Empty() || diff.Changes() != 0 {
+		t.Errorf("Expected no changes between identical schemas, got %+v", diff)
+	}
+}
+
+func TestCompareAddedAndDroppedTables(t *testing.T) {
+	payments := Table{Name: "payments", Columns: []Column{{Name: "id", Type: "integer"}}}
+	audit := Table{Name: "audit", Columns: []Column{{Name: "id", Type: "integer"}}}
+
+	diff := Compare(schemaOf(usersTable(), ordersTable()), schemaOf(usersTable(), payments, audit))
+	if !reflect.DeepEqual(diff.AddedTables, []string{"audit", "payments"}) {
+		t.Errorf("Expected sorted added tables, got %v", diff.AddedTables)
+	}
+	if !reflect.DeepEqual(diff.DroppedTables, []string{"orders"}) {
+		t.Errorf("Expected orders dropped, got %v", diff.DroppedTables)
+	}
+	// Columns of new and dropped tables are implied by the table change
+	if len(diff.AddedColumns) != 0 || len(diff.RemovedColumns) != 0 {
+		t.Errorf("Expected no column changes for whole tables, got %+v", diff)
+	}
+	if diff.Changes() != 3 {
+		t.Errorf("Expected 3 changes, got %d", diff.Changes())
+	}
+}
+
+func TestCompareAddedAndRemovedColumns(t *testing.T) {
+	users := usersTable()
+	users.Columns = append(users.Columns[:2], Column{Name: "created_at", Type: "timestamp"}, Column{Name: "age", Type: "integer", Nullable: true})
+
+	diff := Compare(schemaOf(usersTable()), schemaOf(users))
+	expectedAdded := []ColumnRef{
+		{Table: "users", Column: "age", Type: "integer"},
+		{Table: "users", Column: "created_at", Type: "timestamp"},
+	}
+	if !reflect.DeepEqual(diff.AddedColumns, expectedAdded) {
+		t.Errorf("Expected %v added, got %v", expectedAdded, diff.AddedColumns)
+	}
+	expectedRemoved := []ColumnRef{{Table: "users", Column: "nickname", Type: "text"}}
+	if !reflect.DeepEqual(diff.RemovedColumns, expectedRemoved) {
+		t.Errorf("Expected %v removed, got %v", expectedRemoved, diff.RemovedColumns)
+	}
+	if len(diff.RetypedColumns) != 0 || len(diff.AddedTables) != 0 {
+		t.Errorf("Unexpected changes: %+v", diff)
+	}
+}
+
+func TestCompareRenamedColumnIsRemovedAndAdded(t *testing.T) {
+	users := usersTable()
+	users.Columns[2].Name = "display_name"
+
+	diff := Compare(schemaOf(usersTable()), schemaOf(users))
+	if len(diff.AddedColumns) != 1 || diff.AddedColumns[0].Column != "display_name" {
+		t.Errorf("Expected display_name added, got %v", diff.AddedColumns)
+	}
+	if len(diff.RemovedColumns) != 1 || diff.RemovedColumns[0].Column != "nickname" {
+		t.Errorf("Expected nickname removed, got %v", diff.RemovedColumns)
+	}
+}
+
+func TestCompareRetypedColumns(t *testing.T) {
+	orders := ordersTable()
+	orders.Columns[1].Type = "bigint"
+	orders.Columns[2].Nullable = true
+
+	diff := Compare(schemaOf(ordersTable()), schemaOf(orders))
+	expected := []ColumnChange{
+		{Table: "orders", Column: "total", FromType: "numeric(10,2)", ToType: "numeric(10,2)", FromNullable: false, ToNullable: true},
+		{Table: "orders", Column: "user_id", FromType: "integer", ToType: "bigint"},
+	}
+	if !reflect.DeepEqual(diff.RetypedColumns, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, diff.RetypedColumns)
+	}
+	if diff.Changes() != 2 {
+		t.Errorf("Expected 2 changes, got %d", diff.Changes())
+	}
+}
+
+func TestCompareIgnoresCaseAndWhitespace(t *testing.T) {
+	users := usersTable()
+	users.Name = "USERS"
+	users.Columns[1].Name = "Email"
+	users.Columns[1].Type = " VARCHAR(255) "
+	users.Indexes[0].Name = "USERS_EMAIL_KEY"
+	users.Indexes[0].Columns = []string{"EMAIL"}
+
+	if diff := Compare(schemaOf(usersTable()), schemaOf(users)); !diff.Empty() {
+		t.Errorf("Expected case and whitespace differences to be ignored, got %+v", diff)
+	}
+}
+
+func TestCompareIndexes(t *testing.T) {
+	orders := ordersTable()
+	orders.Indexes = []Index{{Name: "orders_user_id_idx", Columns: []string{"user_id"}}}
+	users := usersTable()
+	users.Indexes[0].Unique = false // Same name, different definition
+
+	diff := Compare(schemaOf(usersTable(), ordersTable()), schemaOf(users, orders))
+	expectedAdded := []IndexRef{{Table: "orders", Index: "orders_user_id_idx"}, {Table: "users", Index: "users_email_key"}}
+	if !reflect.DeepEqual(diff.AddedIndexes, expectedAdded) {
+		t.Errorf("Expected %v added, got %v", expectedAdded, diff.AddedIndexes)
+	}
+	expectedDropped := []IndexRef{{Table: "users", Index: "users_email_key"}}
+	if !reflect.DeepEqual(diff.DroppedIndexes, expectedDropped) {
+		t.Errorf("Expected the redefined index dropped, got %v", diff.DroppedIndexes)
+	}
+
+	users = usersTable()
+	users.Indexes = nil
+	diff = Compare(schemaOf(usersTable()), schemaOf(users))
+	if len(diff.DroppedIndexes) != 1 || len(diff.AddedIndexes) != 0 {
+		t.Errorf("Expected only a dropped index, got %+v", diff)
+	}
+}
+
+func TestCompareIndexColumnOrderMatters(t *testing.T) {
+	from := Table{Name: "t", Columns: []Column{{Name: "a"}, {Name: "b"}}, Indexes: []Index{{Name: "t_ab", Columns: []string{"a", "b"}}}}
+	to := from
+	to.Indexes = []Index{{Name: "t_ab", Columns: []string{"b", "a"}}}
+
+	diff := Compare(schemaOf(from), schemaOf(to))
+	if len(diff.AddedIndexes) != 1 || len(diff.DroppedIndexes) != 1 {
+		t.Errorf("Expected a reordered index to be replaced, got %+v", diff)
+	}
+}
+
+func TestCompareNilSchemas(t *testing.T) {
+	diff := Compare(nil, schemaOf(usersTable()))
+	if !reflect.DeepEqual(diff.AddedTables, []string{"users"}) {
+		t.Errorf("Expected every table added from a nil schema, got %v", diff.AddedTables)
+	}
+
+	diff = Compare(schemaOf(usersTable()), nil)
+	if !reflect.DeepEqual(diff.DroppedTables, []string{"users"}) {
+		t.Errorf("Expected every table dropped into a nil schema, got %v", diff.DroppedTables)
+	}
+
+	if diff := Compare(nil, nil); !diff.Empty() {
+		t.Errorf("Expected two nil schemas to be equal, got %+v", diff)
+	}
+}
+
+func TestDiffSerializesEmptyLists(t *testing.T) {
+	data, err := json.Marshal(Compare(schemaOf(usersTable()), schemaOf(usersTable())))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := `{"added_tables":[],"dropped_tables":[],"added_columns":[],"removed_columns":[],"retyped_columns":[],"added_indexes":[],"dropped_indexes":[]}`
+	if string(data) != expected {
+		t.Errorf("Expected empty lists rather than nulls, got %s", data)
+	}
+}
+
+func TestCompareIsDeterministic(t *testing.T) {
+	var tables []Table
+	for _, name := range []string{"e", "b", "d", "a", "c"} {
+		tables = append(tables, Table{Name: name, Columns: []Column{{Name: "x", Type: "int"}, {Name: "y", Type: "int"}}})
+	}
+	first, _ := json.Marshal(Compare(nil, schemaOf(tables...)))
+	for i := 0; i < 10; i++ {
+		next, _ := json.Marshal(Compare(nil, schemaOf(tables...)))
+		if string(next) != string(first) {
+			t.Fatalf("Expected the same diff every time, got %s and %s", first, next)
+		}
+	}
+}
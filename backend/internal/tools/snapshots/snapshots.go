@@ -0,0 +1,438 @@
+// Package snapshots records the schema of every active datasource on a
+// schedule and diffs each snapshot against the previous one, so a renamed or
+// retyped column is reported when it happens rather than when tool-generated
+// SQL starts failing. Snapshots and their diffs are kept in
+// datasource_schema_snapshots.
+package snapshots
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+const (
+	// DefaultInterval is how often a datasource without its own interval is snapshotted
+	DefaultInterval = 24 * time.Hour
+	// DefaultMaxConcurrent is how many datasources are inspected at once
+	DefaultMaxConcurrent = 2
+	// DefaultTimeout bounds inspecting one datasource
+	DefaultTimeout = 2 * time.Minute
+	// EventSchemaChanged is broadcast to the project when a snapshot differs from the previous one
+	EventSchemaChanged = "datasource_schema_changed"
+)
+
+// ErrSnapshotNotFound is returned when a snapshot does not exist for the datasource
+var ErrSnapshotNotFound = errors.New("schema snapshot not found")
+
+// Snapshot is one recorded schema. Diff compares it with the datasource's
+// previous snapshot and is nil for the first one.
+type Snapshot struct {
+	ID           string    `json:"id"`
+	DatasourceID string    `json:"datasource_id"`
+	Schema       *Schema   `json:"schema,omitempty"`
+	Diff         *Diff     `json:"diff,omitempty"`
+	Changed      bool      `json:"changed"`
+	TableCount   int       `json:"table_count"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Datasource is an active datasource the scheduler may snapshot. A zero
+// Interval uses the scheduler's default.
+type Datasource struct {
+	ID        string
+	ProjectID string
+	ClientID  string
+	Name      string
+	Type      string
+	Interval  time.Duration
+}
+
+// Notifier broadcasts schema change events to a project's WebSocket room
+type Notifier interface {
+	BroadcastToProject(projectID string, message interface{})
+}
+
+// ConnectFunc opens a connection to a datasource. Connections that implement
+// io.Closer are closed once the datasource has been inspected.
+type ConnectFunc func(ctx context.Context, datasourceID string) (tools.DBConnection, error)
+
+// Options configures a Scheduler; zero values use the defaults
+type Options struct {
+	Interval      time.Duration
+	MaxConcurrent int
+	Timeout       time.Duration
+}
+
+// Scheduler snapshots datasources whose last snapshot is older than their interval
+type Scheduler struct {
+	db       tools.DBConnection
+	connect  ConnectFunc
+	notifier Notifier
+	events   webhooks.Publisher
+	options  Options
+	slots    chan struct{}
+	now      func() time.Time
+}
+
+// NewScheduler creates a scheduler. notifier and events may be nil.
+func NewScheduler(db tools.DBConnection, connect ConnectFunc, notifier Notifier, events webhooks.Publisher, options Options) *Scheduler {
+	if options.Interval <= 0 {
+		options.Interval = DefaultInterval
+	}
+	if options.MaxConcurrent <= 0 {
+		options.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Scheduler{
+		db:       db,
+		connect:  connect,
+		notifier: notifier,
+		events:   events,
+		options:  options,
+		slots:    make(chan struct{}, options.MaxConcurrent),
+		now:      time.Now,
+	}
+}
+
+// Run snapshots due datasources every checkInterval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context, checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		if taken, err := s.RunOnce(ctx); err != nil {
+			log.Printf("Schema snapshot run failed after %d snapshots: %v", taken, err)
+		} else if taken > 0 {
+			log.Printf("Took %d datasource schema snapshots", taken)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce snapshots every due datasource, at most MaxConcurrent at a time, and
+// returns how many snapshots were taken. A datasource that fails is logged and
+// retried on the next run.
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	due, err := s.Due(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	taken := 0
+	for _, datasource := range due {
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return taken, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(datasource Datasource) {
+			defer wg.Done()
+			defer func() { <-s.slots }()
+
+			if _, err := s.Snapshot(ctx, datasource); err != nil {
+				log.Printf("Failed to snapshot schema of datasource %s: %v", datasource.ID, err)
+				return
+			}
+			mutex.Lock()
+			taken++
+			mutex.Unlock()
+		}(datasource)
+	}
+	wg.Wait()
+	return taken, nil
+}
+
+// Due returns the active datasources of active projects whose latest snapshot
+// is older than their interval. Datasources with snapshots disabled are skipped.
+func (s *Scheduler) Due(ctx context.Context) ([]Datasource, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT d.id, d.project_id, u.client_id, d.name, d.type, d.schema_snapshot_interval_minutes
+		FROM datasources d
+		JOIN projects p ON p.id = d.project_id
+		JOIN users u ON u.id = p.user_id
+		WHERE d.is_active = true AND p.is_active = true`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list datasources: %w", err)
+	}
+
+	var datasources []Datasource
+	for rows.Next() {
+		var datasource Datasource
+		var intervalMinutes sql.NullInt64
+		if err := rows.Scan(&datasource.ID, &datasource.ProjectID, &datasource.ClientID, &datasource.Name, &datasource.Type, &intervalMinutes); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read datasource: %w", err)
+		}
+		if intervalMinutes.Valid {
+			if intervalMinutes.Int64 <= 0 {
+				continue // Snapshots disabled for this datasource
+			}
+			datasource.Interval = time.Duration(intervalMinutes.Int64) * time.Minute
+		}
+		datasources = append(datasources, datasource)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list datasources: %w", err)
+	}
+
+	now := s.now()
+	due := datasources[:0]
+	for _, datasource := range datasources {
+		interval := datasource.Interval
+		if interval <= 0 {
+			interval = s.options.Interval
+		}
+		var lastAt time.Time
+		err := s.db.QueryRow(ctx,
+			"SELECT created_at FROM datasource_schema_snapshots WHERE datasource_id = $1 ORDER BY created_at DESC LIMIT 1",
+			datasource.ID).Scan(&lastAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to load latest snapshot of %s: %w", datasource.ID, err)
+		}
+		if err == nil && now.Sub(lastAt) < interval {
+			continue
+		}
+		due = append(due, datasource)
+	}
+	return due, nil
+}
+
+// Snapshot inspects a datasource, stores its schema with the diff against the
+// previous snapshot, and announces the change when there is one
+func (s *Scheduler) Snapshot(ctx context.Context, datasource Datasource) (*Snapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+	defer cancel()
+
+	conn, err := s.connect(ctx, datasource.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if closer, ok := conn.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	schema, err := Inspect(ctx, conn, datasource.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := Latest(ctx, s.db, datasource.ID)
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		ID:           uuid.New().String(),
+		DatasourceID: datasource.ID,
+		Schema:       schema,
+		TableCount:   len(schema.Tables),
+		CreatedAt:    s.now().UTC(),
+	}
+	if previous != nil {
+		snapshot.Diff = Compare(previous.Schema, schema)
+		snapshot.Changed = !snapshot.Diff.Empty()
+	}
+	if err := store(ctx, s.db, snapshot); err != nil {
+		return nil, err
+	}
+
+	if snapshot.Changed {
+		s.announce(datasource, previous, snapshot)
+	}
+	return snapshot, nil
+}
+
+// announce tells the project room and the client's webhooks that a schema changed
+func (s *Scheduler) announce(datasource Datasource, previous, snapshot *Snapshot) {
+	data := map[string]interface{}{
+		"datasource_id":        datasource.ID,
+		"datasource_name":      datasource.Name,
+		"project_id":           datasource.ProjectID,
+		"snapshot_id":          snapshot.ID,
+		"previous_snapshot_id": previous.ID,
+		"changes":              snapshot.Diff.Changes(),
+		"diff":                 snapshot.Diff,
+	}
+	log.Printf("Schema of datasource %s changed: %d changes", datasource.ID, snapshot.Diff.Changes())
+
+	if s.notifier != nil {
+		s.notifier.BroadcastToProject(datasource.ProjectID, messages.WebSocketMessage{
+			Type:      EventSchemaChanged,
+			Data:      data,
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+	if s.events != nil && datasource.ClientID != "" {
+		s.events.Publish(webhooks.NewEvent(webhooks.EventDatasourceSchemaChanged, datasource.ClientID, datasource.ProjectID, data))
+	}
+}
+
+// Inspect reads the tables, columns and indexes of a datasource. It fails
+// rather than returning a partial schema, which would diff as dropped tables.
+func Inspect(ctx context.Context, conn tools.DBConnection, dbType string) (*Schema, error) {
+	inspector := tools.NewDatasourceInspector(conn, dbType)
+	info, err := inspector.InspectDatasource(ctx, dbType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect datasource: %w", err)
+	}
+	if tablesError, exists := info.Properties["tables_error"]; exists {
+		return nil, fmt.Errorf("failed to list tables: %v", tablesError)
+	}
+
+	schema := &Schema{Tables: make([]Table, 0, len(info.Tables))}
+	for _, listed := range info.Tables {
+		tableInfo, err := inspector.InspectTable(ctx, listed.Name, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect table %s: %w", listed.Name, err)
+		}
+
+		table := Table{Name: listed.Name, Type: strings.ToLower(listed.Type), Columns: make([]Column, 0, len(tableInfo.Columns))}
+		for _, column := range tableInfo.Columns {
+			table.Columns = append(table.Columns, Column{
+				Name:       column.Name,
+				Type:       column.Type,
+				Nullable:   column.Nullable,
+				PrimaryKey: column.PrimaryKey,
+			})
+		}
+		for _, index := range tableInfo.Indexes {
+			table.Indexes = append(table.Indexes, Index{Name: index.Name, Columns: index.Columns, Unique: index.Unique})
+		}
+		sort.Slice(table.Indexes, func(i, j int) bool { return table.Indexes[i].Name < table.Indexes[j].Name })
+		schema.Tables = append(schema.Tables, table)
+	}
+	sort.Slice(schema.Tables, func(i, j int) bool { return schema.Tables[i].Name < schema.Tables[j].Name })
+	return schema, nil
+}
+
+func store(ctx context.Context, db tools.DBConnection, snapshot *Snapshot) error {
+	schemaJSON, err := json.Marshal(snapshot.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	var diffJSON interface{}
+	if snapshot.Diff != nil {
+		encoded, err := json.Marshal(snapshot.Diff)
+		if err != nil {
+			return fmt.Errorf("failed to encode schema diff: %w", err)
+		}
+		diffJSON = string(encoded)
+	}
+
+	// schema is a reserved word in MySQL, so the column is always quoted
+	_, err = db.Exec(ctx,
+		`INSERT INTO datasource_schema_snapshots (id, datasource_id, "schema", diff, changed, table_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		snapshot.ID, snapshot.DatasourceID, string(schemaJSON), diffJSON, snapshot.Changed, snapshot.TableCount, snapshot.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store schema snapshot: %w", err)
+	}
+	return nil
+}
+
+// Latest returns the newest snapshot of a datasource
+func Latest(ctx context.Context, db tools.DBConnection, datasourceID string) (*Snapshot, error) {
+	return scanSnapshot(db.QueryRow(ctx,
+		`SELECT id, datasource_id, "schema", diff, changed, table_count, created_at
+		FROM datasource_schema_snapshots WHERE datasource_id = $1
+		ORDER BY created_at DESC LIMIT 1`, datasourceID))
+}
+
+// Get returns one snapshot of a datasource
+func Get(ctx context.Context, db tools.DBConnection, datasourceID, snapshotID string) (*Snapshot, error) {
+	if _, err := uuid.Parse(snapshotID); err != nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return scanSnapshot(db.QueryRow(ctx,
+		`SELECT id, datasource_id, "schema", diff, changed, table_count, created_at
+		FROM datasource_schema_snapshots WHERE datasource_id = $1 AND id = $2`, datasourceID, snapshotID))
+}
+
+// Previous returns the snapshot taken just before the given one
+func Previous(ctx context.Context, db tools.DBConnection, snapshot *Snapshot) (*Snapshot, error) {
+	return scanSnapshot(db.QueryRow(ctx,
+		`SELECT id, datasource_id, "schema", diff, changed, table_count, created_at
+		FROM datasource_schema_snapshots WHERE datasource_id = $1 AND created_at < $2
+		ORDER BY created_at DESC LIMIT 1`, snapshot.DatasourceID, snapshot.CreatedAt))
+}
+
+// History returns the newest snapshots of a datasource with their diffs but
+// without the schemas themselves
+func History(ctx context.Context, db tools.DBConnection, datasourceID string, limit int) ([]Snapshot, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, datasource_id, diff, changed, table_count, created_at
+		FROM datasource_schema_snapshots WHERE datasource_id = $1
+		ORDER BY created_at DESC LIMIT $2`, datasourceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []Snapshot{}
+	for rows.Next() {
+		var snapshot Snapshot
+		var diffJSON []byte
+		if err := rows.Scan(&snapshot.ID, &snapshot.DatasourceID, &diffJSON, &snapshot.Changed, &snapshot.TableCount, &snapshot.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema snapshot: %w", err)
+		}
+		if len(diffJSON) > 0 {
+			snapshot.Diff = &Diff{}
+			if err := json.Unmarshal(diffJSON, snapshot.Diff); err != nil {
+				return nil, fmt.Errorf("failed to decode schema diff: %w", err)
+			}
+		}
+		history = append(history, snapshot)
+	}
+	return history, rows.Err()
+}
+
+func scanSnapshot(row *sql.Row) (*Snapshot, error) {
+	var snapshot Snapshot
+	var schemaJSON, diffJSON []byte
+	err := row.Scan(&snapshot.ID, &snapshot.DatasourceID, &schemaJSON, &diffJSON, &snapshot.Changed, &snapshot.TableCount, &snapshot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema snapshot: %w", err)
+	}
+
+	snapshot.Schema = &Schema{}
+	if err := json.Unmarshal(schemaJSON, snapshot.Schema); err != nil {
+		return nil, fmt.Errorf("failed to decode schema: %w", err)
+	}
+	if len(diffJSON) > 0 {
+		snapshot.Diff = &Diff{}
+		if err := json.Unmarshal(diffJSON, snapshot.Diff); err != nil {
+			return nil, fmt.Errorf("failed to decode schema diff: %w", err)
+		}
+	}
+	return &snapshot, nil
+}
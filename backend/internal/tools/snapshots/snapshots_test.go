@@ -0,0 +1,208 @@
+package snapshots
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+const (
+	testProjectID  = "11111111-1111-1111-1111-111111111111"
+	testClientID   = "22222222-2222-2222-2222-222222222222"
+	activeSourceID = "33333333-3333-3333-3333-333333333333"
+	idleSourceID   = "44444444-4444-4444-4444-444444444444"
+)
+
+type recordingNotifier struct {
+	mutex    sync.Mutex
+	projects []string
+	events   []messages.WebSocketMessage
+}
+
+func (n *recordingNotifier) BroadcastToProject(projectID string, message interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.projects = append(n.projects, projectID)
+	n.events = append(n.events, message.(messages.WebSocketMessage))
+}
+
+type recordingPublisher struct {
+	mutex  sync.Mutex
+	events []webhooks.Event
+}
+
+func (p *recordingPublisher) Publish(event webhooks.Event) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.events = append(p.events, event)
+	return true
+}
+
+func openSQLite(t *testing.T, name string) *db.Database {
+	t.Helper()
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), name)).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { zdb.Close() })
+	return zdb
+}
+
+// newTestScheduler returns a scheduler over a migrated app database with one
+// active and one inactive sqlite datasource, both backed by the returned database
+func newTestScheduler(t *testing.T) (*Scheduler, *db.Database, *recordingNotifier, *recordingPublisher) {
+	t.Helper()
+
+	app := dbtest.Open(t)
+	dbtest.SeedProject(t, app, testClientID, "user-1", testProjectID)
+	dbtest.Seed(t, app,
+		`INSERT INTO datasources (id, project_id, name, type, config, is_active)
+			VALUES ('`+activeSourceID+`', '`+testProjectID+`', 'Warehouse', 'sqlite', '{}', true)`,
+		`INSERT INTO datasources (id, project_id, name, type, config, is_active)
+			VALUES ('`+idleSourceID+`', '`+testProjectID+`', 'Retired', 'sqlite', '{}', false)`,
+	)
+
+	target := openSQLite(t, "target.db")
+	dbtest.Seed(t, target,
+		`CREATE TABLE customers (id INTEGER PRIMARY KEY, email TEXT NOT NULL)`,
+		`CREATE UNIQUE INDEX customers_email ON customers(email)`,
+	)
+
+	notifier := &recordingNotifier{}
+	publisher := &recordingPublisher{}
+	connect := func(ctx context.Context, datasourceID string) (tools.DBConnection, error) {
+		return targetConn{&tools.ZlayDBAdapter{DB: target}}, nil
+	}
+	scheduler := NewScheduler(&tools.ZlayDBAdapter{DB: app}, connect, notifier, publisher, Options{})
+	return scheduler, target, notifier, publisher
+}
+
+// targetConn hides the adapter's Close so the shared test database stays open
+type targetConn struct {
+	*tools.ZlayDBAdapter
+}
+
+func (targetConn) Close() error { return nil }
+
+func TestSchedulerRecordsAndDiffsSnapshots(t *testing.T) {
+	scheduler, target, notifier, publisher := newTestScheduler(t)
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+
+	taken, err := scheduler.RunOnce(ctx)
+	if err != nil || taken != 1 {
+		t.Fatalf("Expected one snapshot of the active datasource, got %d, %v", taken, err)
+	}
+
+	first, err := Latest(ctx, scheduler.db, activeSourceID)
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if first.Diff != nil || first.Changed || first.TableCount != 1 {
+		t.Errorf("Expected a first snapshot without a diff, got %+v", first)
+	}
+	customers := first.Schema.Tables[0]
+	if customers.Name != "customers" || len(customers.Columns) != 2 || len(customers.Indexes) == 0 ||
+		customers.Indexes[0].Name != "customers_email" || !customers.Indexes[0].Unique {
+		t.Errorf("Unexpected snapshot schema: %+v", first.Schema)
+	}
+	if _, err := Latest(ctx, scheduler.db, idleSourceID); err != ErrSnapshotNotFound {
+		t.Errorf("Expected the inactive datasource to be skipped, got %v", err)
+	}
+
+	// Not due again until the interval has passed
+	now = now.Add(time.Hour)
+	if taken, _ := scheduler.RunOnce(ctx); taken != 0 {
+		t.Errorf("Expected no snapshot within the interval, got %d", taken)
+	}
+
+	dbtest.Seed(t, target,
+		`ALTER TABLE customers ADD COLUMN name TEXT`,
+		`CREATE TABLE invoices (id INTEGER PRIMARY KEY)`,
+	)
+	now = now.Add(DefaultInterval)
+	if taken, err := scheduler.RunOnce(ctx); err != nil || taken != 1 {
+		t.Fatalf("Expected a second snapshot after the interval, got %d, %v", taken, err)
+	}
+
+	second, err := Latest(ctx, scheduler.db, activeSourceID)
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if !second.Changed || second.Diff == nil || second.Diff.Changes() != 2 {
+		t.Fatalf("Expected two changes, got %+v", second.Diff)
+	}
+	if second.Diff.AddedTables[0] != "invoices" || second.Diff.AddedColumns[0] != (ColumnRef{Table: "customers", Column: "name", Type: "TEXT"}) {
+		t.Errorf("Unexpected diff: %+v", second.Diff)
+	}
+
+	previous, err := Previous(ctx, scheduler.db, second)
+	if err != nil || previous.ID != first.ID {
+		t.Errorf("Expected the first snapshot before the second, got %+v, %v", previous, err)
+	}
+	history, err := History(ctx, scheduler.db, activeSourceID, 10)
+	if err != nil || len(history) != 2 || history[0].ID != second.ID || history[0].Schema != nil {
+		t.Errorf("Expected both snapshots newest first without schemas, got %+v, %v", history, err)
+	}
+
+	if len(notifier.events) != 1 || notifier.projects[0] != testProjectID || notifier.events[0].Type != EventSchemaChanged {
+		t.Fatalf("Expected one schema change broadcast, got %+v", notifier.events)
+	}
+	data := notifier.events[0].Data.(map[string]interface{})
+	if data["datasource_id"] != activeSourceID || data["snapshot_id"] != second.ID || data["previous_snapshot_id"] != first.ID {
+		t.Errorf("Unexpected event data: %v", data)
+	}
+	if len(publisher.events) != 1 || publisher.events[0].Type != webhooks.EventDatasourceSchemaChanged || publisher.events[0].ClientID != testClientID {
+		t.Errorf("Expected one webhook event for the client, got %+v", publisher.events)
+	}
+}
+
+func TestSchedulerUnchangedSchemaIsQuiet(t *testing.T) {
+	scheduler, _, notifier, publisher := newTestScheduler(t)
+	ctx := context.Background()
+	datasource := Datasource{ID: activeSourceID, ProjectID: testProjectID, ClientID: testClientID, Type: "sqlite"}
+
+	if _, err := scheduler.Snapshot(ctx, datasource); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	snapshot, err := scheduler.Snapshot(ctx, datasource)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if snapshot.Changed || snapshot.Diff == nil || !snapshot.Diff.Empty() {
+		t.Errorf("Expected an empty diff, got %+v", snapshot.Diff)
+	}
+	if len(notifier.events) != 0 || len(publisher.events) != 0 {
+		t.Errorf("Expected no events for an unchanged schema, got %d and %d", len(notifier.events), len(publisher.events))
+	}
+}
+
+func TestSchedulerHonoursDatasourceInterval(t *testing.T) {
+	scheduler, _, _, _ := newTestScheduler(t)
+	ctx := context.Background()
+
+	dbtest.Seed(t, scheduler.db.(*tools.ZlayDBAdapter).DB,
+		`UPDATE datasources SET schema_snapshot_interval_minutes = 0 WHERE id = '`+activeSourceID+`'`)
+	due, err := scheduler.Due(ctx)
+	if err != nil || len(due) != 0 {
+		t.Errorf("Expected a zero interval to disable snapshots, got %+v, %v", due, err)
+	}
+
+	dbtest.Seed(t, scheduler.db.(*tools.ZlayDBAdapter).DB,
+		`UPDATE datasources SET schema_snapshot_interval_minutes = 5 WHERE id = '`+activeSourceID+`'`)
+	due, err = scheduler.Due(ctx)
+	if err != nil || len(due) != 1 || due[0].Interval != 5*time.Minute || due[0].ClientID != testClientID {
+		t.Errorf("Expected the datasource due with its own interval, got %+v, %v", due, err)
+	}
+}
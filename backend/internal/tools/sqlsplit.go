@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"strings"
+)
+
+// splitSQLStatements splits a script into individual statements on top-level semicolons.
+// Semicolons inside quoted strings, quoted identifiers, comments and Postgres
+// dollar-quoted bodies ($$...$$ or $tag$...$tag$) are not treated as separators.
+// Strings are quoted the way the dialect quotes them, see scanQuoted.
+func splitSQLStatements(dialect, script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); {
+		ch := script[i]
+
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := scanQuoted(dialect, script, i, ch)
+			current.WriteString(script[i:end])
+			i = end
+		case ch == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end == -1 {
+				end = len(script) - i
+			}
+			current.WriteString(script[i : i+end])
+			i += end
+		case ch == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end == -1 {
+				end = len(script)
+			} else {
+				end = i + 2 + end + 2
+			}
+			current.WriteString(script[i:end])
+			i = end
+		case ch == '$':
+			if tag, ok := dollarQuoteTag(script[i:]); ok {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end == -1 {
+					end = len(script)
+				} else {
+					end = i + len(tag) + end + len(tag)
+				}
+				current.WriteString(script[i:end])
+				i = end
+			} else {
+				current.WriteByte(ch)
+				i++
+			}
+		case ch == ';':
+			flush()
+			i++
+		default:
+			current.WriteByte(ch)
+			i++
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// scanQuoted returns the index just past the quoted section starting at start.
+// A doubled quote character is treated as an escaped quote. A backslash only
+// escapes inside strings of dialects that support it; elsewhere 'C:\' is a
+// complete string.
+func scanQuoted(dialect, script string, start int, quote byte) int {
+	backslashEscapes := quote == '\'' && hasBackslashEscapes(dialect)
+	for i := start + 1; i < len(script); i++ {
+		if backslashEscapes && script[i] == '\\' && i+1 < len(script) {
+			i++
+			continue
+		}
+		if script[i] == quote {
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(script)
+}
+
+// hasBackslashEscapes reports whether string literals of the dialect treat a
+// backslash as an escape character, as MySQL and ClickHouse do by default
+func hasBackslashEscapes(dialect string) bool {
+	return dialect == dialectMySQL || dialect == dialectClickHouse
+}
+
+// dollarQuoteTag returns the opening tag of a Postgres dollar-quoted string, such as "$$" or "$body$"
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		ch := s[i]
+		if ch == '$' {
+			return s[:i+1], true
+		}
+		isIdent := ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (i > 1 && ch >= '0' && ch <= '9')
+		if !isIdent {
+			return "", false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,337 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error codes of database tool calls without a datasource_id
+const (
+	ErrCodeDatasourceRequired   = "DATASOURCE_REQUIRED"
+	ErrCodeSystemTableForbidden = "SYSTEM_TABLE_FORBIDDEN"
+	ErrCodeSystemQueryForbidden = "SYSTEM_QUERY_FORBIDDEN"
+)
+
+// systemRowLimit caps the rows of every query against the system database
+const systemRowLimit = 100
+
+// systemReadableTables are the only system database tables the tools may read
+var systemReadableTables = map[string]bool{
+	"projects":         true,
+	"conversations":    true,
+	"messages":         true,
+	"project_files":    true,
+	"message_feedback": true,
+}
+
+// systemForbiddenTables hold credentials and sessions; any mention of them is refused
+var systemForbiddenTables = map[string]bool{
+	"users":    true,
+	"sessions": true,
+	"clients":  true,
+	"api_keys": true,
+}
+
+// systemFunctions are the functions a system database query may call. Anything
+// else is refused since functions such as query_to_xml or dblink can run
+// arbitrary SQL from a string.
+var systemFunctions = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+	"coalesce": true, "nullif": true, "greatest": true, "least": true,
+	"lower": true, "upper": true, "length": true, "trim": true, "substring": true, "substr": true,
+	"concat": true, "replace": true, "cast": true, "round": true, "floor": true, "ceil": true, "abs": true,
+	"now": true, "date": true, "date_trunc": true, "date_part": true, "extract": true, "strftime": true,
+	"to_char": true, "age": true, "string_agg": true, "array_agg": true, "group_concat": true,
+	"row_number": true, "rank": true, "dense_rank": true, "lag": true, "lead": true,
+}
+
+// sqlKeywords may precede "(" without being a function call
+var sqlKeywords = map[string]bool{
+	"select": true, "from": true, "where": true, "and": true, "or": true, "not": true, "in": true,
+	"exists": true, "any": true, "some": true, "all": true, "as": true, "on": true, "using": true,
+	"join": true, "left": true, "right": true, "inner": true, "outer": true, "full": true, "cross": true,
+	"group": true, "by": true, "order": true, "having": true, "limit": true, "offset": true,
+	"union": true, "except": true, "intersect": true, "distinct": true, "case": true, "when": true,
+	"then": true, "else": true, "end": true, "is": true, "null": true, "like": true, "ilike": true,
+	"between": true, "asc": true, "desc": true, "with": true, "recursive": true, "over": true,
+	"partition": true, "filter": true, "within": true, "values": true, "interval": true,
+}
+
+// SystemQueryError is why a query against the system database was refused
+type SystemQueryError struct {
+	Code  string
+	Table string
+	msg   string
+}
+
+func (e *SystemQueryError) Error() string {
+	return e.msg
+}
+
+// toolResult converts the error into a failed tool result
+func (e *SystemQueryError) toolResult() *ToolResult {
+	var data map[string]interface{}
+	if e.Table != "" {
+		data = map[string]interface{}{"table": e.Table}
+	}
+	return NewToolErrorWithCode(e.Code, e.msg, data)
+}
+
+func forbiddenTable(name string) *SystemQueryError {
+	return &SystemQueryError{
+		Code:  ErrCodeSystemTableForbidden,
+		Table: name,
+		msg:   fmt.Sprintf("table %q cannot be read from the system database", name),
+	}
+}
+
+func forbiddenQuery(format string, args ...interface{}) *SystemQueryError {
+	return &SystemQueryError{Code: ErrCodeSystemQueryForbidden, msg: fmt.Sprintf(format, args...)}
+}
+
+// checkSystemTable refuses tables outside the readable allowlist
+func checkSystemTable(name string) *SystemQueryError {
+	if !systemReadableTables[strings.ToLower(name)] {
+		return forbiddenTable(name)
+	}
+	return nil
+}
+
+// checkSystemQuery refuses a statement unless it is a single SELECT that only
+// reads allowlisted tables and calls allowlisted functions. Identifiers are
+// compared unquoted and case-insensitively, and string literals are searched
+// too, so quoting, schema prefixes and SQL built from strings do not reach
+// the forbidden tables. Views are refused like any table not on the allowlist.
+func checkSystemQuery(statement string) *SystemQueryError {
+	tokens := tokenizeSQL(statement)
+	if len(tokens) == 0 {
+		return forbiddenQuery("query is empty")
+	}
+
+	// Forbidden tables are refused first, whatever else is wrong with the statement
+	for _, token := range tokens {
+		switch token.kind {
+		case tokenString:
+			for _, word := range strings.FieldsFunc(strings.ToLower(token.text), func(r rune) bool {
+				return !(r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+			}) {
+				if systemForbiddenTables[word] {
+					return forbiddenTable(word)
+				}
+			}
+		case tokenWord, tokenQuoted:
+			if systemForbiddenTables[strings.ToLower(token.text)] {
+				return forbiddenTable(token.text)
+			}
+		}
+	}
+
+	if first := strings.ToLower(tokens[0].text); tokens[0].kind != tokenWord || (first != "select" && first != "with") {
+		return forbiddenQuery("only SELECT statements may read the system database")
+	}
+
+	// Names defined by WITH name AS (...) may be read like tables
+	ctes := make(map[string]bool)
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].isIdentifier() && tokens[i+1].is("as") && tokens[i+2].is("(") {
+			ctes[strings.ToLower(tokens[i].text)] = true
+		}
+	}
+
+	// inFunction tracks, per open parenthesis, whether it holds function
+	// arguments, where FROM is part of the call as in EXTRACT(YEAR FROM x)
+	var inFunction []bool
+	for i, token := range tokens {
+		if token.is("(") {
+			inFunction = append(inFunction, i > 0 && tokens[i-1].kind == tokenWord && systemFunctions[strings.ToLower(tokens[i-1].text)])
+		} else if token.is(")") && len(inFunction) > 0 {
+			inFunction = inFunction[:len(inFunction)-1]
+		}
+
+		if token.kind != tokenWord && token.kind != tokenQuoted {
+			continue
+		}
+
+		name := strings.ToLower(token.text)
+		functionArgs := len(inFunction) > 0 && inFunction[len(inFunction)-1]
+		if token.kind == tokenWord && (name == "into" || (name == "for" && !functionArgs)) {
+			return forbiddenQuery("SELECT %s is not allowed on the system database", strings.ToUpper(name))
+		}
+		if i+1 < len(tokens) && tokens[i+1].is("(") && (token.kind == tokenQuoted || !sqlKeywords[name]) && !systemFunctions[name] {
+			return forbiddenQuery("function %s is not allowed on the system database", token.text)
+		}
+		if (token.is("from") && !functionArgs) || token.is("join") {
+			if err := checkTableList(tokens[i+1:], ctes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTableList checks the comma-separated table references after FROM or JOIN
+func checkTableList(tokens []sqlToken, ctes map[string]bool) *SystemQueryError {
+	i := 0
+	for i < len(tokens) {
+		if tokens[i].is("(") {
+			return nil // Subqueries are checked as part of the statement
+		}
+		if !tokens[i].isIdentifier() {
+			return nil
+		}
+
+		// schema.table; only the default schema may be used
+		var parts []string
+		for i < len(tokens) && tokens[i].isIdentifier() {
+			parts = append(parts, strings.ToLower(tokens[i].text))
+			i++
+			if i+1 < len(tokens) && tokens[i].is(".") {
+				i++
+				continue
+			}
+			break
+		}
+		table := parts[len(parts)-1]
+		if len(parts) > 1 && parts[0] != "public" && parts[0] != "main" {
+			return forbiddenTable(strings.Join(parts, "."))
+		}
+		if !ctes[table] && (len(parts) > 1 || !systemFunctions[table] || i >= len(tokens) || !tokens[i].is("(")) {
+			if err := checkSystemTable(table); err != nil {
+				return err
+			}
+		}
+
+		// Optional alias, then another table after a comma
+		if i < len(tokens) && tokens[i].is("as") {
+			i++
+		}
+		if i < len(tokens) && tokens[i].isIdentifier() && !sqlKeywords[strings.ToLower(tokens[i].text)] {
+			i++
+		}
+		if i >= len(tokens) || !tokens[i].is(",") {
+			return nil
+		}
+		i++
+	}
+	return nil
+}
+
+type sqlTokenKind int
+
+const (
+	tokenWord sqlTokenKind = iota
+	tokenQuoted
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+// sqlToken is a word, quoted identifier, string literal, number or symbol; the
+// text of quoted identifiers and strings is unquoted
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t sqlToken) is(text string) bool {
+	return (t.kind == tokenWord || t.kind == tokenSymbol) && strings.EqualFold(t.text, text)
+}
+
+func (t sqlToken) isIdentifier() bool {
+	return t.kind == tokenQuoted || (t.kind == tokenWord && !sqlKeywords[strings.ToLower(t.text)])
+}
+
+// tokenizeSQL splits a statement into tokens, dropping whitespace and comments
+func tokenizeSQL(statement string) []sqlToken {
+	var tokens []sqlToken
+	s := statement
+	for len(s) > 0 {
+		c := s[0]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			s = s[1:]
+		case strings.HasPrefix(s, "--"):
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				s = s[end+1:]
+			} else {
+				s = ""
+			}
+		case strings.HasPrefix(s, "/*"):
+			if end := strings.Index(s[2:], "*/"); end >= 0 {
+				s = s[end+4:]
+			} else {
+				s = ""
+			}
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			kind := tokenQuoted
+			if c == '\'' {
+				kind = tokenString
+			} else if c == '[' {
+				closing = ']'
+			}
+			text, rest := readQuoted(s[1:], closing)
+			tokens = append(tokens, sqlToken{kind: kind, text: text})
+			s = rest
+		case isWordByte(c):
+			end := 1
+			for end < len(s) && (isWordByte(s[end]) || s[end] == '$') {
+				end++
+			}
+			kind := tokenWord
+			if c >= '0' && c <= '9' {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: s[:end]})
+			s = s[end:]
+		default:
+			tokens = append(tokens, sqlToken{kind: tokenSymbol, text: s[:1]})
+			s = s[1:]
+		}
+	}
+	return tokens
+}
+
+// readQuoted reads up to the closing quote; a doubled quote is an escaped one
+func readQuoted(s string, closing byte) (string, string) {
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != closing {
+			text.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == closing {
+			text.WriteByte(closing)
+			i++
+			continue
+		}
+		return text.String(), s[i+1:]
+	}
+	return text.String(), ""
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// systemReadableTableInfos drops tables outside the readable allowlist
+func systemReadableTableInfos(tables []TableInfo) []TableInfo {
+	readable := make([]TableInfo, 0, len(tables))
+	for _, table := range tables {
+		if checkSystemTable(table.Name) == nil {
+			readable = append(readable, table)
+		}
+	}
+	return readable
+}
+
+// systemReadableRelations drops relations with either end outside the readable allowlist
+func systemReadableRelations(relations []RelationInfo) []RelationInfo {
+	readable := make([]RelationInfo, 0, len(relations))
+	for _, relation := range relations {
+		if checkSystemTable(relation.FromTable) == nil && checkSystemTable(relation.ToTable) == nil {
+			readable = append(readable, relation)
+		}
+	}
+	return readable
+}
@@ -2,7 +2,26 @@ package tools
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools/jobs"
 )
 
 func TestSystemInfoTool(t *testing.T) {
@@ -173,9 +192,9 @@ func TestDatasourceInspectTool(t *testing.T) {
 		t.Errorf("Expected include_columns default true, got %v", includeColumnsParam.Default)
 	}
 	
-	// Test access validation
-	if !tool.ValidateAccess("test_user", "test_project") {
-		t.Error("Datasource inspect tool should allow access for all users")
+	// Test access validation without a permission checker
+	if tool.ValidateAccess("test_user", "test_project") {
+		t.Error("Datasource inspect tool should deny access without a permission checker")
 	}
 }
 
@@ -317,6 +336,282 @@ func TestUnifiedIndexInfo(t *testing.T) {
 	}
 }
 
+func TestSQLiteIndexIntrospection(t *testing.T) {
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "inspect.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	defer zdb.Close()
+
+	statements := []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT)",
+		"CREATE INDEX idx_users_name ON users (name, email)",
+		"CREATE TABLE memberships (team_id TEXT, user_id TEXT, role TEXT, PRIMARY KEY (user_id, team_id))",
+		"CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT) WITHOUT ROWID",
+		"CREATE TABLE events (payload TEXT)",
+	}
+	for _, stmt := range statements {
+		if _, err := zdb.Execute(context.Background(), stmt); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+	}
+	inspector := NewDatasourceInspector(&ZlayDBAdapter{DB: zdb}, "sqlite")
+
+	tests := []struct {
+		table     string
+		primary   []string
+		pkColumns map[string]bool
+	}{
+		{"users", []string{"id"}, map[string]bool{"id": true}},
+		{"memberships", []string{"user_id", "team_id"}, map[string]bool{"user_id": true, "team_id": true}},
+		{"settings", []string{"key"}, map[string]bool{"key": true}},
+		{"events", nil, map[string]bool{}},
+	}
+	for _, tt := range tests {
+		indexes, err := inspector.getIndexes(context.Background(), tt.table)
+		if err != nil {
+			t.Fatalf("%s: getIndexes failed: %v", tt.table, err)
+		}
+		var primary []string
+		for _, index := range indexes {
+			if index.Primary {
+				if primary != nil || !index.Unique {
+					t.Errorf("%s: expected one unique primary index, got %+v", tt.table, indexes)
+				}
+				primary = index.Columns
+			}
+		}
+		if strings.Join(primary, ",") != strings.Join(tt.primary, ",") {
+			t.Errorf("%s: expected primary key %v, got %v", tt.table, tt.primary, primary)
+		}
+
+		columns, err := inspector.getColumns(context.Background(), tt.table)
+		if err != nil {
+			t.Fatalf("%s: getColumns failed: %v", tt.table, err)
+		}
+		for _, col := range columns {
+			if col.PrimaryKey != tt.pkColumns[col.Name] {
+				t.Errorf("%s.%s: expected primary_key=%v", tt.table, col.Name, tt.pkColumns[col.Name])
+			}
+		}
+	}
+
+	indexes, _ := inspector.getIndexes(context.Background(), "users")
+	found := map[string]IndexInfo{}
+	for _, index := range indexes {
+		found[index.Name] = index
+	}
+	if idx := found["idx_users_name"]; idx.Unique || strings.Join(idx.Columns, ",") != "name,email" {
+		t.Errorf("Expected a non-unique two-column index in key order, got %+v", idx)
+	}
+	if idx := found["sqlite_autoindex_users_1"]; !idx.Unique || idx.Primary || strings.Join(idx.Columns, ",") != "email" {
+		t.Errorf("Expected the UNIQUE constraint index, got %+v", idx)
+	}
+}
+
+// countingConn counts version probes sent through a real connection
+type countingConn struct {
+	DBConnection
+	probes int
+}
+
+func (c *countingConn) count(query string) {
+	if strings.Contains(strings.ToLower(query), "version") {
+		c.probes++
+	}
+}
+
+func (c *countingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.count(query)
+	return c.DBConnection.Query(ctx, query, args...)
+}
+
+func (c *countingConn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	c.count(query)
+	return c.DBConnection.QueryRow(ctx, query, args...)
+}
+
+func TestInspectorDetectsDatabaseTypeOnce(t *testing.T) {
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "detect.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	defer zdb.Close()
+	if _, err := zdb.Execute(context.Background(), "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create items: %v", err)
+	}
+
+	// Unknown type: probed on first use, then remembered
+	conn := &countingConn{DBConnection: &ZlayDBAdapter{DB: zdb}}
+	inspector := NewDatasourceInspector(conn, "")
+	for i := 0; i < 3; i++ {
+		if _, err := inspector.InspectTable(context.Background(), "items", true); err != nil {
+			t.Fatalf("InspectTable failed: %v", err)
+		}
+	}
+	if inspector.detectDatabaseType(context.Background()) != "sqlite" {
+		t.Errorf("Expected sqlite to be detected, got %s", inspector.dbType)
+	}
+	// version() fails on SQLite, then sqlite_version() answers
+	if conn.probes != 2 {
+		t.Errorf("Expected one round of probes, got %d probe queries", conn.probes)
+	}
+
+	// Known type: never probed
+	conn = &countingConn{DBConnection: &ZlayDBAdapter{DB: zdb}}
+	inspector = NewDatasourceInspector(conn, "sqlite")
+	if _, err := inspector.InspectTable(context.Background(), "items", true); err != nil {
+		t.Fatalf("InspectTable failed: %v", err)
+	}
+	if conn.probes != 0 {
+		t.Errorf("Expected no probes for a known type, got %d", conn.probes)
+	}
+}
+
+func TestIndexQueryDialects(t *testing.T) {
+	tests := []struct {
+		dbType  string
+		want    []string
+		notWant []string
+	}{
+		{"postgresql", []string{"string_agg(a.attname, ',' ORDER BY c.ordinality)", "AS is_unique", "AS is_primary", "t.relname = $1"}, []string{"as unique", "as primary"}},
+		{"mysql", []string{"MAX(NON_UNIQUE) = 0 AS `unique`", "INDEX_NAME = 'PRIMARY' AS `primary`", "TABLE_SCHEMA = DATABASE()", "TABLE_NAME = ?"}, []string{"GROUP BY INDEX_NAME, NON_UNIQUE"}},
+		{"sqlserver", []string{"sys.indexes", "sys.index_columns", "ORDER BY ic.key_ordinal", "OBJECT_ID(@p1)", "is_included_column = 0"}, nil},
+	}
+	for _, tt := range tests {
+		query := indexQuery(tt.dbType)
+		for _, want := range tt.want {
+			if !strings.Contains(query, want) {
+				t.Errorf("%s: expected query to contain %q:\n%s", tt.dbType, want, query)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(query, notWant) {
+				t.Errorf("%s: query should not contain %q", tt.dbType, notWant)
+			}
+		}
+	}
+	if indexQuery("oracle") != "" {
+		t.Error("Expected no index query for unsupported dialects")
+	}
+}
+
+func TestClickHouseCatalogQueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"tables", clickHouseTablesQuery, []string{"FROM system.tables", "WHERE database = currentDatabase()"}},
+		{"table type", clickHouseTableTypeQuery, []string{"SELECT engine", "database = currentDatabase() AND name = ?"}},
+		{"columns", clickHouseColumnsQuery, []string{"FROM system.columns", "database = currentDatabase() AND table = ?", "is_in_primary_key", "ORDER BY position"}},
+		{"stats", clickHouseTableStatsQuery, []string{"sum(rows), sum(bytes_on_disk)", "FROM system.parts", "table = ? AND active"}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			if !strings.Contains(tt.query, want) {
+				t.Errorf("%s: expected query to contain %q:\n%s", tt.name, want, tt.query)
+			}
+		}
+		if strings.Contains(strings.ToUpper(tt.query), "COUNT(") {
+			t.Errorf("%s: expected no COUNT over the table:\n%s", tt.name, tt.query)
+		}
+	}
+}
+
+func TestClickHouseTableType(t *testing.T) {
+	for engine, expected := range map[string]string{
+		"MergeTree":          "table",
+		"ReplacingMergeTree": "table",
+		"Distributed":        "table",
+		"Memory":             "table",
+		"View":               "view",
+		"MaterializedView":   "materialized_view",
+	} {
+		if got := clickHouseTableType(engine); got != expected {
+			t.Errorf("clickHouseTableType(%q) = %q, expected %q", engine, got, expected)
+		}
+	}
+}
+
+func TestUnwrapClickHouseType(t *testing.T) {
+	tests := []struct {
+		columnType string
+		baseType   string
+		nullable   bool
+	}{
+		{"String", "String", false},
+		{"Nullable(Int64)", "Int64", true},
+		{"LowCardinality(String)", "String", false},
+		{"LowCardinality(Nullable(String))", "String", true},
+		{"Nullable(DateTime64(3, 'UTC'))", "DateTime64(3, 'UTC')", true},
+		{"Array(Nullable(String))", "Array(Nullable(String))", false},
+		{"Map(String, Nullable(UInt8))", "Map(String, Nullable(UInt8))", false},
+		{" Nullable(Decimal(18, 2)) ", "Decimal(18, 2)", true},
+	}
+	for _, tt := range tests {
+		baseType, nullable := unwrapClickHouseType(tt.columnType)
+		if baseType != tt.baseType || nullable != tt.nullable {
+			t.Errorf("unwrapClickHouseType(%q) = %q, %v; expected %q, %v", tt.columnType, baseType, nullable, tt.baseType, tt.nullable)
+		}
+	}
+}
+
+// recordingConn records the queries sent through a real connection
+type recordingConn struct {
+	DBConnection
+	queries []string
+}
+
+func (c *recordingConn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.queries = append(c.queries, query)
+	return c.DBConnection.Query(ctx, query, args...)
+}
+
+func (c *recordingConn) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	c.queries = append(c.queries, query)
+	return c.DBConnection.QueryRow(ctx, query, args...)
+}
+
+func TestClickHouseInspectorQueries(t *testing.T) {
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "clickhouse.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	defer zdb.Close()
+
+	ctx := context.Background()
+	conn := &recordingConn{DBConnection: &ZlayDBAdapter{DB: zdb}}
+	inspector := NewDatasourceInspector(conn, "clickhouse")
+
+	// SQLite cannot answer the ClickHouse catalog; only the SQL sent matters
+	inspector.getClickHouseTables(ctx)
+	inspector.getColumns(ctx, "events")
+	inspector.getTableStats(ctx, &TableInfo{Name: "events"})
+	expected := []string{clickHouseTablesQuery, clickHouseColumnsQuery, clickHouseTableStatsQuery}
+	if strings.Join(conn.queries, ";") != strings.Join(expected, ";") {
+		t.Errorf("Expected only the ClickHouse catalog queries, got %q", conn.queries)
+	}
+
+	conn.queries = nil
+	relations, err := inspector.getTableRelations(ctx, "events", "clickhouse", true)
+	if err != nil || len(relations) != 0 {
+		t.Errorf("Expected no relations, got %v, %v", relations, err)
+	}
+	if relations, err := inspector.getAllRelations(ctx, "clickhouse"); err != nil || len(relations) != 0 {
+		t.Errorf("Expected no relations, got %v, %v", relations, err)
+	}
+	if len(conn.queries) != 0 {
+		t.Errorf("Expected relations without querying, got %q", conn.queries)
+	}
+}
+
 func TestRelationInfo(t *testing.T) {
 	relation := RelationInfo{
 		FromTable:      "orders",
@@ -518,6 +813,2179 @@ func TestRelationsDepthValidation(t *testing.T) {
 	}
 }
 
-func stringPtr(s string) *string {
-	return &s
-}
\ No newline at end of file
+// memoryToolSettingsStore is an in-memory ToolSettingsStore for registry tests
+type memoryToolSettingsStore struct {
+	disabled map[string]map[string]bool
+	loads    int
+}
+
+func newMemoryToolSettingsStore() *memoryToolSettingsStore {
+	return &memoryToolSettingsStore{disabled: make(map[string]map[string]bool)}
+}
+
+func (s *memoryToolSettingsStore) GetDisabledTools(ctx context.Context, projectID string) (map[string]bool, error) {
+	s.loads++
+	disabled := make(map[string]bool)
+	for name, off := range s.disabled[projectID] {
+		disabled[name] = off
+	}
+	return disabled, nil
+}
+
+func (s *memoryToolSettingsStore) SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error {
+	if s.disabled[projectID] == nil {
+		s.disabled[projectID] = make(map[string]bool)
+	}
+	s.disabled[projectID][toolName] = !enabled
+	return nil
+}
+
+func hasTool(tools []Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRegistryProjectToolSettings(t *testing.T) {
+	registry := NewDefaultToolRegistry()
+	store := newMemoryToolSettingsStore()
+	registry.SetSettingsStore(store)
+	ctx := context.Background()
+
+	if !hasTool(registry.GetAvailableTools("project-a"), "system_info") {
+		t.Fatal("system_info should be available by default")
+	}
+
+	if err := registry.SetToolEnabled(ctx, "project-a", "system_info", false); err != nil {
+		t.Fatalf("SetToolEnabled failed: %v", err)
+	}
+
+	if hasTool(registry.GetAvailableTools("project-a"), "system_info") {
+		t.Error("Disabled tool should not be advertised for project-a")
+	}
+	if registry.IsToolEnabled("project-a", "system_info") {
+		t.Error("IsToolEnabled should report false for disabled tool")
+	}
+	if !hasTool(registry.GetAvailableTools("project-b"), "system_info") {
+		t.Error("Disabling a tool in project-a should not affect project-b")
+	}
+	if len(registry.ListTools()) != 1 {
+		t.Errorf("ListTools should still include disabled tools, got %d", len(registry.ListTools()))
+	}
+
+	_, err := registry.ExecuteTool(ctx, "user", "project-a", "system_info", map[string]interface{}{})
+	if !errors.Is(err, ErrToolDisabled) {
+		t.Errorf("Expected ErrToolDisabled, got %v", err)
+	}
+
+	result, err := registry.ExecuteTool(ctx, "user", "project-b", "system_info", map[string]interface{}{})
+	if err != nil || result.Status != "completed" {
+		t.Errorf("Expected tool to run in project-b, got result=%v err=%v", result, err)
+	}
+
+	if err := registry.SetToolEnabled(ctx, "project-a", "system_info", true); err != nil {
+		t.Fatalf("SetToolEnabled failed: %v", err)
+	}
+	if !registry.IsToolEnabled("project-a", "system_info") {
+		t.Error("Re-enabled tool should be enabled immediately")
+	}
+}
+
+func TestRegistryToolSettingsCache(t *testing.T) {
+	registry := NewDefaultToolRegistry()
+	store := newMemoryToolSettingsStore()
+	registry.SetSettingsStore(store)
+
+	for i := 0; i < 5; i++ {
+		registry.GetAvailableTools("project-a")
+	}
+	if store.loads != 1 {
+		t.Errorf("Expected settings to be loaded once, got %d loads", store.loads)
+	}
+
+	// Writes through the registry invalidate the cached project
+	if err := registry.SetToolEnabled(context.Background(), "project-a", "system_info", false); err != nil {
+		t.Fatalf("SetToolEnabled failed: %v", err)
+	}
+	registry.GetAvailableTools("project-a")
+	if store.loads != 2 {
+		t.Errorf("Expected settings to be reloaded after update, got %d loads", store.loads)
+	}
+}
+
+func TestRegistrySetToolEnabledUnknownTool(t *testing.T) {
+	registry := NewDefaultToolRegistry()
+	registry.SetSettingsStore(newMemoryToolSettingsStore())
+
+	err := registry.SetToolEnabled(context.Background(), "project-a", "does_not_exist", false)
+	if !errors.Is(err, ErrToolNotFound) {
+		t.Errorf("Expected ErrToolNotFound, got %v", err)
+	}
+}
+
+func TestDBToolSettingsStore(t *testing.T) {
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-a", "alice", "project-a")
+
+	ctx := context.Background()
+
+	store := NewDBToolSettingsStore(&ZlayDBAdapter{DB: zdb})
+	if err := store.SetToolEnabled(ctx, "project-a", "database_query", false); err != nil {
+		t.Fatalf("SetToolEnabled failed: %v", err)
+	}
+	if err := store.SetToolEnabled(ctx, "project-a", "api_request", false); err != nil {
+		t.Fatalf("SetToolEnabled failed: %v", err)
+	}
+	if err := store.SetToolEnabled(ctx, "project-a", "api_request", true); err != nil {
+		t.Fatalf("SetToolEnabled upsert failed: %v", err)
+	}
+
+	disabled, err := store.GetDisabledTools(ctx, "project-a")
+	if err != nil {
+		t.Fatalf("GetDisabledTools failed: %v", err)
+	}
+	if len(disabled) != 1 || !disabled["database_query"] {
+		t.Errorf("Expected only database_query disabled, got %v", disabled)
+	}
+}
+
+// staticPermissionChecker returns a fixed role per user
+type staticPermissionChecker map[string]ProjectRole
+
+func (c staticPermissionChecker) GetProjectRole(ctx context.Context, userID, projectID string) (ProjectRole, error) {
+	return c[userID], nil
+}
+
+func TestToolRolePermissions(t *testing.T) {
+	checker := staticPermissionChecker{
+		"owner":  RoleOwner,
+		"editor": RoleEditor,
+		"viewer": RoleViewer,
+	}
+
+	testCases := []struct {
+		tool    Tool
+		allowed map[string]bool
+	}{
+		{NewDatabaseQueryTool(nil, checker), map[string]bool{"owner": true, "editor": true, "viewer": false, "stranger": false}},
+		{NewDatasourceInspectTool(nil, checker), map[string]bool{"owner": true, "editor": true, "viewer": true, "stranger": false}},
+		{NewAPITool(nil, checker, nil), map[string]bool{"owner": true, "editor": true, "viewer": false, "stranger": false}},
+		{NewSystemInfoTool(), map[string]bool{"owner": true, "editor": true, "viewer": true, "stranger": true}},
+	}
+
+	for _, tc := range testCases {
+		for userID, expected := range tc.allowed {
+			if got := tc.tool.ValidateAccess(userID, "project-a"); got != expected {
+				t.Errorf("%s as %s: expected access %t, got %t", tc.tool.Name(), userID, expected, got)
+			}
+		}
+	}
+}
+
+func TestRegistryPermissionDenied(t *testing.T) {
+	registry := NewDefaultToolRegistry()
+	checker := staticPermissionChecker{"viewer": RoleViewer}
+	if err := registry.RegisterTool(NewDatabaseQueryTool(nil, checker)); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+
+	_, err := registry.ExecuteTool(context.Background(), "viewer", "project-a", "database_query", map[string]interface{}{"query": "DELETE FROM users"})
+	if !errors.Is(err, ErrToolAccessDenied) {
+		t.Fatalf("Expected ErrToolAccessDenied, got %v", err)
+	}
+
+	var permErr *PermissionError
+	if !errors.As(err, &permErr) {
+		t.Fatalf("Expected *PermissionError, got %T", err)
+	}
+	if permErr.ToolName != "database_query" || permErr.UserID != "viewer" || permErr.ProjectID != "project-a" {
+		t.Errorf("Unexpected permission error fields: %+v", permErr)
+	}
+}
+
+func TestDBPermissionChecker(t *testing.T) {
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-a", "alice", "project-a", "project-b")
+	dbtest.Seed(t, zdb, "UPDATE projects SET is_active = false WHERE id = 'project-b'")
+
+	ctx := context.Background()
+
+	checker := NewDBPermissionChecker(&ZlayDBAdapter{DB: zdb})
+	testCases := []struct {
+		userID    string
+		projectID string
+		expected  ProjectRole
+	}{
+		{"alice", "project-a", RoleOwner},
+		{"bob", "project-a", RoleNone},
+		{"alice", "project-b", RoleNone}, // inactive project
+		{"alice", "missing", RoleNone},
+	}
+
+	for _, tc := range testCases {
+		role, err := checker.GetProjectRole(ctx, tc.userID, tc.projectID)
+		if err != nil {
+			t.Errorf("%s/%s: unexpected error %v", tc.userID, tc.projectID, err)
+		}
+		if role != tc.expected {
+			t.Errorf("%s/%s: expected role %s, got %s", tc.userID, tc.projectID, tc.expected, role)
+		}
+	}
+}
+
+// setupFileReadTool stores fixture files in a temp data dir and registers them in project_files
+func setupFileReadTool(t *testing.T, fixtures map[string]string) (*FileReadTool, map[string]string) {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-a", "alice", "project-a")
+
+	ctx := context.Background()
+
+	ids := make(map[string]string)
+	for name, contentType := range fixtures {
+		content, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("Failed to read fixture %s: %v", name, err)
+		}
+
+		fileID := uuid.New().String()
+		path, _ := ProjectFilePath(dataDir, fileID)
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatalf("Failed to write fixture %s: %v", name, err)
+		}
+		if _, err := zdb.Execute(ctx,
+			"INSERT INTO project_files (id, project_id, user_id, filename, content_type, size_bytes) VALUES ($1, $2, $3, $4, $5, $6)",
+			fileID, "project-a", "alice", name, contentType, len(content)); err != nil {
+			t.Fatalf("Failed to insert fixture %s: %v", name, err)
+		}
+		ids[name] = fileID
+	}
+
+	return NewFileReadTool(&ZlayDBAdapter{DB: zdb}, dataDir, staticPermissionChecker{"alice": RoleViewer}), ids
+}
+
+func TestFileReadToolCSV(t *testing.T) {
+	tool, ids := setupFileReadTool(t, map[string]string{"sales.csv": "text/csv"})
+	ctx := WithExecutionContext(context.Background(), "alice", "project-a")
+
+	result, err := tool.Execute(ctx, map[string]interface{}{"file_id": ids["sales.csv"]})
+	if err != nil || result.Status != "completed" {
+		t.Fatalf("Expected completed result, got %+v err=%v", result, err)
+	}
+	if result.Data["is_text"] != true {
+		t.Error("CSV file should be detected as text")
+	}
+	if !strings.HasPrefix(result.Data["content"].(string), "region,month,revenue") {
+		t.Errorf("Unexpected content: %q", result.Data["content"])
+	}
+	if result.Data["truncated"] != false {
+		t.Error("Small file should not be truncated")
+	}
+
+	preview, ok := result.Data["csv_preview"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected csv_preview, got %v", result.Data["csv_preview"])
+	}
+	columns := preview["columns"].([]string)
+	if len(columns) != 3 || columns[2] != "revenue" {
+		t.Errorf("Unexpected CSV columns: %v", columns)
+	}
+	rows := preview["rows"].([]map[string]string)
+	if len(rows) != 4 || rows[0]["region"] != "north" || rows[3]["revenue"] != "870.75" {
+		t.Errorf("Unexpected CSV rows: %v", rows)
+	}
+}
+
+func TestFileReadToolTruncation(t *testing.T) {
+	tool, ids := setupFileReadTool(t, map[string]string{"notes.txt": "text/plain"})
+	ctx := WithExecutionContext(context.Background(), "alice", "project-a")
+
+	result, _ := tool.Execute(ctx, map[string]interface{}{"file_id": ids["notes.txt"], "max_bytes": float64(5)})
+	if result.Data["content"] != "Plain" || result.Data["truncated"] != true {
+		t.Errorf("Expected truncated first chunk, got %+v", result.Data)
+	}
+	if result.Data["next_offset"] != int64(5) {
+		t.Errorf("Expected next_offset 5, got %v", result.Data["next_offset"])
+	}
+	if _, ok := result.Data["csv_preview"]; ok {
+		t.Error("Text files should not get a CSV preview")
+	}
+
+	result, _ = tool.Execute(ctx, map[string]interface{}{"file_id": ids["notes.txt"], "offset": float64(18)})
+	if result.Data["content"] != "Line two.\n" || result.Data["truncated"] != false {
+		t.Errorf("Expected final chunk, got %+v", result.Data)
+	}
+}
+
+func TestFileReadToolProjectScoping(t *testing.T) {
+	tool, ids := setupFileReadTool(t, map[string]string{"notes.txt": "text/plain"})
+
+	// Files from another project are not visible
+	ctx := WithExecutionContext(context.Background(), "alice", "project-b")
+	result, _ := tool.Execute(ctx, map[string]interface{}{"file_id": ids["notes.txt"]})
+	if result.Status != "failed" {
+		t.Errorf("Expected failure for file outside project, got %+v", result)
+	}
+
+	// Tools executed without a registry context are refused
+	result, _ = tool.Execute(context.Background(), map[string]interface{}{"file_id": ids["notes.txt"]})
+	if result.Status != "failed" {
+		t.Errorf("Expected failure without project context, got %+v", result)
+	}
+
+	if !tool.ValidateAccess("alice", "project-a") || tool.ValidateAccess("mallory", "project-a") {
+		t.Error("file_read should require viewer access")
+	}
+	if tool.GetCategory() != "files" {
+		t.Errorf("Expected category 'files', got '%s'", tool.GetCategory())
+	}
+}
+
+func TestFileTextDetection(t *testing.T) {
+	if !isTextContent([]byte("héllo")) {
+		t.Error("UTF-8 text should be detected as text")
+	}
+	if !isTextContent([]byte("h\xc3")) {
+		t.Error("Text cut in the middle of a rune should still be text")
+	}
+	if isTextContent([]byte{0x89, 'P', 'N', 'G', 0x00, 0x01}) {
+		t.Error("Binary data should not be detected as text")
+	}
+	if _, err := ProjectFilePath("/data", "../../etc/passwd"); err == nil {
+		t.Error("Non-UUID file ids should be rejected")
+	}
+}
+
+type staticAllowlistStore []AllowlistRule
+
+func (s staticAllowlistStore) GetAllowlist(ctx context.Context, projectID string) ([]AllowlistRule, error) {
+	return s, nil
+}
+
+// stubResolver maps host names to fixed addresses so tests never hit real DNS
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host: %s", host)
+	}
+	var result []net.IPAddr
+	for _, addr := range addrs {
+		result = append(result, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return result, nil
+}
+
+func newTestAPITool(rules []AllowlistRule, resolver stubResolver) *APITool {
+	tool := NewAPITool(nil, nil, staticAllowlistStore(rules))
+	tool.resolver = resolver
+	return tool
+}
+
+func executeAPIRequest(t *testing.T, tool *APITool, rawURL string) *ToolResult {
+	t.Helper()
+	ctx := WithExecutionContext(context.Background(), "user-1", "project-1")
+	result, err := tool.Execute(ctx, map[string]interface{}{"method": "GET", "url": rawURL})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	return result
+}
+
+func TestAPIToolAllowlistIPLiterals(t *testing.T) {
+	tool := newTestAPITool([]AllowlistRule{
+		{Pattern: "http://*/*"},
+	}, nil)
+
+	for _, target := range []string{
+		"http://127.0.0.1/admin",
+		"http://10.0.0.5/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/",
+		"http://100.64.1.1/",
+		"http://0.0.0.0/",
+	} {
+		result := executeAPIRequest(t, tool, target)
+		if result.Code != "URL_NOT_ALLOWED" {
+			t.Errorf("%s: expected URL_NOT_ALLOWED, got status=%s code=%q error=%q", target, result.Status, result.Code, result.Error)
+			continue
+		}
+		if rule, _ := result.Data["rule"].(string); !strings.Contains(rule, "http://*/*") {
+			t.Errorf("%s: expected matched rule in error data, got %q", target, rule)
+		}
+	}
+
+	// Nothing matches when the project has no rules
+	result := executeAPIRequest(t, newTestAPITool(nil, nil), "https://example.com/")
+	if result.Code != "URL_NOT_ALLOWED" || result.Data["rule"] != "no matching allowlist rule" {
+		t.Errorf("Expected default deny, got code=%q data=%v", result.Code, result.Data)
+	}
+}
+
+func TestAPIToolAllowlistDNSRebinding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	resolver := stubResolver{
+		"rebind.example.com":   {"93.184.216.34", "127.0.0.1"},
+		"internal.example.com": {"127.0.0.1"},
+	}
+	tool := newTestAPITool([]AllowlistRule{
+		{Pattern: "http://rebind.example.com:" + port + "/*"},
+		{Pattern: "http://internal.example.com:" + port + "/*", AllowPrivate: true},
+	}, resolver)
+
+	// The hostname passes the allowlist but resolves to loopback at dial time
+	result := executeAPIRequest(t, tool, "http://rebind.example.com:"+port+"/data")
+	if result.Code != "URL_NOT_ALLOWED" {
+		t.Fatalf("Expected URL_NOT_ALLOWED for rebinding host, got status=%s error=%q", result.Status, result.Error)
+	}
+	if rule, _ := result.Data["rule"].(string); !strings.Contains(rule, "127.0.0.1") {
+		t.Errorf("Expected resolved address in rule, got %q", rule)
+	}
+
+	// Explicitly allowlisted private targets are reachable
+	result = executeAPIRequest(t, tool, "http://internal.example.com:"+port+"/data")
+	if result.Status != "completed" {
+		t.Fatalf("Expected allowlisted private host to succeed, got error=%q", result.Error)
+	}
+	if result.Data["status_code"] != 200 {
+		t.Errorf("Expected status 200, got %v", result.Data["status_code"])
+	}
+}
+
+func TestAPIToolRedirectChain(t *testing.T) {
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Redirect target outside the allowlist should never be requested")
+	}))
+	defer forbidden.Close()
+
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/hop", http.StatusFound)
+		case "/hop":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/final":
+			w.Write([]byte("done"))
+		case "/escape":
+			http.Redirect(w, r, forbidden.URL+"/secret", http.StatusFound)
+		}
+	}))
+	defer allowed.Close()
+
+	tool := newTestAPITool([]AllowlistRule{
+		{Pattern: allowed.URL + "/*", AllowPrivate: true},
+	}, nil)
+
+	result := executeAPIRequest(t, tool, allowed.URL+"/start")
+	if result.Status != "completed" {
+		t.Fatalf("Expected redirect chain within allowlist to succeed, got error=%q", result.Error)
+	}
+	if result.Data["body"] != "done" || result.Data["final_url"] != allowed.URL+"/final" {
+		t.Errorf("Unexpected redirect result: body=%v final_url=%v", result.Data["body"], result.Data["final_url"])
+	}
+
+	result = executeAPIRequest(t, tool, allowed.URL+"/escape")
+	if result.Code != "URL_NOT_ALLOWED" {
+		t.Fatalf("Expected URL_NOT_ALLOWED for redirect outside allowlist, got status=%s error=%q", result.Status, result.Error)
+	}
+}
+
+func TestAPIToolResponseSizeCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	}))
+	defer server.Close()
+
+	tool := newTestAPITool([]AllowlistRule{{Pattern: server.URL + "/*", AllowPrivate: true}}, nil)
+	tool.maxResponseBytes = 1024
+
+	result := executeAPIRequest(t, tool, server.URL+"/big")
+	if result.Status != "completed" {
+		t.Fatalf("Expected success, got error=%q", result.Error)
+	}
+	if body, _ := result.Data["body"].(string); len(body) != 1024 {
+		t.Errorf("Expected body capped at 1024 bytes, got %d", len(body))
+	}
+	if result.Data["truncated"] != true {
+		t.Error("Expected truncated flag")
+	}
+}
+
+func TestAllowlistPatternMatching(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"https://api.example.com/*", "https://api.example.com", true},
+		{"https://api.example.com/v1/*", "https://api.example.com/v1/users", true},
+		{"https://api.example.com/v1/*", "https://api.example.com/v2/users", false},
+		{"https://api.example.com/*", "http://api.example.com/", false},
+		{"https://api.example.com/*", "https://api.example.com:8443/", false},
+		{"*://*.example.com/*", "http://a.example.com/x", true},
+		{"https://*.example.com/*", "https://attacker.com/.example.com/", false},
+		{"api.example.com", "https://api.example.com/anything", true},
+		{"https://api.example.com/public/*", "https://api.example.com/public/../admin", false},
+		{"https://api.example.com/public/*", "https://api.example.com/public/%2e%2e/admin", false},
+		{"https://api.example.com/public/*", "https://api.example.com/public/%2E./admin", false},
+		{"https://api.example.com/public/*", "https://api.example.com/public/..%2fadmin", false},
+		{"https://api.example.com/public/*", "https://api.example.com/public/./report", false},
+		{"https://api.example.com/public/*", "https://api.example.com/public/v1..2/report", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.url, err)
+		}
+		if got := matchAllowlistRule(tt.pattern, u); got != tt.match {
+			t.Errorf("matchAllowlistRule(%q, %q) = %v, want %v", tt.pattern, tt.url, got, tt.match)
+		}
+	}
+
+	if err := ValidateAllowlistPattern("ftp://example.com/*"); err == nil {
+		t.Error("Expected ftp pattern to be rejected")
+	}
+}
+
+// testDatasourceID is the datasource set up by setupDatabaseQueryTool
+const testDatasourceID = "5d0c8a52-3f0e-4c41-9a55-6a1f9f1e2b01"
+
+// testProjectContext is the context of a tool call in project-1, which owns testDatasourceID
+func testProjectContext() context.Context {
+	return WithExecutionContext(context.Background(), "user-1", "project-1")
+}
+
+// setupDatabaseQueryTool registers a sqlite database with an items table as the
+// datasource testDatasourceID of project-1. It returns the tool, the
+// application database and the datasource's database.
+func setupDatabaseQueryTool(t *testing.T) (*DatabaseQueryTool, *db.Database, *db.Database) {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+
+	path := filepath.Join(t.TempDir(), "items.db")
+	items, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).FilePath(path).Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { items.Close() })
+	if _, err := items.Execute(context.Background(), "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Failed to create items: %v", err)
+	}
+
+	config, _ := json.Marshal(map[string]string{"file_path": path})
+	if _, err := zdb.Execute(context.Background(),
+		"INSERT INTO datasources (id, project_id, name, type, config, is_active) VALUES ($1, 'project-1', 'Items', 'sqlite', $2, true)", testDatasourceID, config); err != nil {
+		t.Fatalf("Failed to register datasource: %v", err)
+	}
+
+	return NewDatabaseQueryTool(zdb, nil), zdb, items
+}
+
+func countItems(t *testing.T, items *db.Database) int64 {
+	t.Helper()
+	row, err := items.QueryRow(context.Background(), "SELECT COUNT(*) FROM items")
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	count, _ := row.Values[0].AsInt64()
+	return count
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"INSERT INTO t VALUES ('a;b'); SELECT 'it''s;fine'", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 'it''s;fine'"}},
+		{`SELECT "odd;name" FROM t; SELECT 2`, []string{`SELECT "odd;name" FROM t`, "SELECT 2"}},
+		{"SELECT 1; -- trailing; comment\nSELECT 2", []string{"SELECT 1", "-- trailing; comment\nSELECT 2"}},
+		{"SELECT /* a;b */ 1; SELECT 2", []string{"SELECT /* a;b */ 1", "SELECT 2"}},
+		{"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql; SELECT f()",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql", "SELECT f()"}},
+		{"SELECT $body$ x; y $body$; SELECT $1", []string{"SELECT $body$ x; y $body$", "SELECT $1"}},
+		{" ; ;; ", nil},
+	}
+
+	for _, tt := range tests {
+		got := splitSQLStatements(dialectGeneric, tt.script)
+		if len(got) != len(tt.want) {
+			t.Errorf("splitSQLStatements(%q) = %q, want %q", tt.script, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("splitSQLStatements(%q)[%d] = %q, want %q", tt.script, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSplitSQLStatementsBackslashEscapes(t *testing.T) {
+	tests := []struct {
+		dialect string
+		script  string
+		want    []string
+	}{
+		// A backslash is an ordinary character in standard SQL strings
+		{dialectPostgres, `SELECT 'C:\'; SELECT 2`, []string{`SELECT 'C:\'`, "SELECT 2"}},
+		{dialectSQLite, `SELECT 'C:\'; DELETE FROM t`, []string{`SELECT 'C:\'`, "DELETE FROM t"}},
+		// MySQL escapes the quote, so the string runs on past the semicolon
+		{dialectMySQL, `SELECT 'it\'s; fine'; SELECT 2`, []string{`SELECT 'it\'s; fine'`, "SELECT 2"}},
+		{dialectMySQL, `SELECT 'C:\\'; SELECT 2`, []string{`SELECT 'C:\\'`, "SELECT 2"}},
+	}
+
+	for _, tt := range tests {
+		got := splitSQLStatements(tt.dialect, tt.script)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: splitSQLStatements(%q) = %q, want %q", tt.dialect, tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestDatabaseQueryToolHasSideEffectsInEitherQuoting(t *testing.T) {
+	tool := &DatabaseQueryTool{}
+	for _, query := range []string{
+		`SELECT 'C:\'; DELETE FROM t`,
+		`SELECT '\''; DELETE FROM t; SELECT '`,
+	} {
+		if !tool.HasSideEffects(map[string]interface{}{"query": query}) {
+			t.Errorf("%s: expected side effects", query)
+		}
+	}
+	if tool.HasSideEffects(map[string]interface{}{"query": `SELECT 'C:\'; SELECT 2`}) {
+		t.Error("Expected a read-only query to have no side effects")
+	}
+}
+
+func TestDatabaseQueryToolMultiStatement(t *testing.T) {
+	tool, _, items := setupDatabaseQueryTool(t)
+
+	result, err := tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "INSERT INTO items (id, name) VALUES (1, 'semi;colon'); CREATE TEMP TABLE recent AS SELECT * FROM items; SELECT name FROM recent",
+		"transactional": true,
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if result.Status != "completed" {
+		t.Fatalf("Expected completed, got %s: %s", result.Status, result.Error)
+	}
+
+	statements, ok := result.Data["statements"].([]map[string]interface{})
+	if !ok || len(statements) != 3 {
+		t.Fatalf("Expected 3 statement results, got %v", result.Data["statements"])
+	}
+	if affected := statements[0]["result"].(map[string]interface{})["rows_affected"]; affected != int64(1) {
+		t.Errorf("Expected insert to affect 1 row, got %v", affected)
+	}
+	selectResult := statements[2]["result"].(map[string]interface{})
+	rows := selectResult["rows"].([]map[string]interface{})
+	if len(rows) != 1 || rows[0]["name"] != "semi;colon" {
+		t.Errorf("Unexpected select rows: %v", rows)
+	}
+	if countItems(t, items) != 1 {
+		t.Error("Expected committed insert")
+	}
+}
+
+func TestDatabaseQueryToolTransactionRollback(t *testing.T) {
+	tool, _, items := setupDatabaseQueryTool(t)
+
+	result, err := tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "INSERT INTO items (id, name) VALUES (1, 'first'); INSERT INTO missing_table (id) VALUES (2)",
+		"transactional": true,
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if result.Status != "failed" || !strings.Contains(result.Error, "Statement 2 failed") {
+		t.Fatalf("Expected statement 2 failure, got %s: %s", result.Status, result.Error)
+	}
+	if result.Data["rolled_back"] != true {
+		t.Error("Expected rolled_back flag")
+	}
+	if countItems(t, items) != 0 {
+		t.Error("First insert should have been rolled back")
+	}
+}
+
+func TestDatabaseQueryToolStatementChecks(t *testing.T) {
+	tool, _, items := setupDatabaseQueryTool(t)
+
+	// Forbidden operations are checked per statement before anything runs
+	result, _ := tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "INSERT INTO items (id, name) VALUES (1, 'a'); DROP TABLE items",
+	})
+	if result.Status != "failed" || !strings.Contains(result.Error, "Statement 2 rejected") {
+		t.Errorf("Expected statement 2 to be rejected, got %s: %s", result.Status, result.Error)
+	}
+	if countItems(t, items) != 0 {
+		t.Error("No statement should run when one is forbidden")
+	}
+
+	// Statement count is capped
+	result, _ = tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         strings.Repeat("SELECT 1;", defaultMaxStatements+1),
+	})
+	if result.Status != "failed" || !strings.Contains(result.Error, "Too many statements") {
+		t.Errorf("Expected statement cap error, got %s: %s", result.Status, result.Error)
+	}
+}
+
+func TestDatabaseQueryToolExplainOnly(t *testing.T) {
+	tool, _, items := setupDatabaseQueryTool(t)
+
+	result, _ := tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "SELECT * FROM items WHERE name = 'x'",
+		"explain_only":  true,
+	})
+	if result.Status != "completed" {
+		t.Fatalf("Expected completed, got %s: %s", result.Status, result.Error)
+	}
+	if result.Data["dialect"] != dialectSQLite {
+		t.Errorf("Expected sqlite dialect, got %v", result.Data["dialect"])
+	}
+	plans := result.Data["plans"].([]map[string]interface{})
+	if len(plans) != 1 || !strings.HasPrefix(plans[0]["explain_query"].(string), "EXPLAIN QUERY PLAN ") {
+		t.Errorf("Unexpected plans: %v", plans)
+	}
+	if rows := plans[0]["plan"].(map[string]interface{})["rows"].([]map[string]interface{}); len(rows) == 0 {
+		t.Error("Expected plan rows")
+	}
+
+	// Non-SELECT statements are refused and never executed
+	result, _ = tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "SELECT 1; INSERT INTO items (id, name) VALUES (1, 'a')",
+		"explain_only":  true,
+	})
+	if result.Status != "failed" || !strings.Contains(result.Error, "Statement 2 cannot be explained") {
+		t.Errorf("Expected refusal for INSERT, got %s: %s", result.Status, result.Error)
+	}
+	if countItems(t, items) != 0 {
+		t.Error("explain_only must not execute statements")
+	}
+}
+
+func TestDatabaseQueryToolRowLimitGuard(t *testing.T) {
+	tool, _, items := setupDatabaseQueryTool(t)
+	for i := 1; i <= 5; i++ {
+		if _, err := items.Execute(context.Background(), "INSERT INTO items (id, name) VALUES ($1, $2)", i, fmt.Sprintf("item-%d", i)); err != nil {
+			t.Fatalf("Failed to seed items: %v", err)
+		}
+	}
+
+	result, _ := tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id":   testDatasourceID,
+		"query":           "SELECT * FROM items ORDER BY id -- newest last",
+		"row_limit_guard": float64(2),
+	})
+	if result.Status != "completed" {
+		t.Fatalf("Expected completed, got %s: %s", result.Status, result.Error)
+	}
+	if count := result.Data["result"].(map[string]interface{})["count"]; count != 2 {
+		t.Errorf("Expected 2 rows with guard, got %v", count)
+	}
+
+	// Existing limits are left alone
+	result, _ = tool.Execute(testProjectContext(), map[string]interface{}{
+		"datasource_id":   testDatasourceID,
+		"query":           "SELECT * FROM items LIMIT 4",
+		"row_limit_guard": float64(2),
+	})
+	if count := result.Data["result"].(map[string]interface{})["count"]; count != 4 {
+		t.Errorf("Expected explicit LIMIT 4 to be kept, got %v", count)
+	}
+}
+
+func TestDatabaseQueryToolAsync(t *testing.T) {
+	tool, zdb, items := setupDatabaseQueryTool(t)
+	ctx := context.Background()
+	if _, err := items.Execute(ctx, "INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')"); err != nil {
+		t.Fatalf("Failed to set up: %v", err)
+	}
+	manager := jobs.NewManager(&ZlayDBAdapter{DB: zdb}, t.TempDir(), nil)
+	tool.SetJobManager(manager)
+	statusTool := NewQueryJobStatusTool(manager, nil)
+	projectCtx := WithExecutionContext(ctx, "user-1", "project-1")
+
+	result, _ := tool.Execute(projectCtx, map[string]interface{}{"datasource_id": testDatasourceID, "query": "DROP TABLE items", "async": true})
+	if result.Status != "failed" {
+		t.Errorf("Expected forbidden statements to be refused before a job starts, got %s", result.Status)
+	}
+
+	result, _ = tool.Execute(projectCtx, map[string]interface{}{
+		"datasource_id":   testDatasourceID,
+		"query":           "SELECT id, name FROM items ORDER BY id",
+		"async":           true,
+		"timeout_seconds": float64(3600),
+	})
+	if result.Status != "completed" {
+		t.Fatalf("Expected the job to be submitted, got %s: %s", result.Status, result.Error)
+	}
+	jobID, _ := result.Data["query_job_id"].(string)
+	if jobID == "" || result.Data["timeout_seconds"] != int(jobs.MaxTimeout.Seconds()) {
+		t.Fatalf("Expected a job ID and a capped timeout, got %+v", result.Data)
+	}
+	manager.Wait()
+
+	status, _ := statusTool.Execute(projectCtx, map[string]interface{}{"query_job_id": jobID, "limit": float64(2)})
+	if status.Status != "completed" {
+		t.Fatalf("Status check failed: %s", status.Error)
+	}
+	job := status.Data["job"].(*jobs.Job)
+	page := status.Data["result"].(*jobs.Page)
+	if job.Status != jobs.StatusCompleted || job.RowCount != 3 || len(page.Rows) != 2 || !page.HasMore {
+		t.Errorf("Unexpected job status: %+v %+v", job, page)
+	}
+	if page.Rows[0]["name"] != "a" || len(page.Columns) != 2 {
+		t.Errorf("Unexpected first page: %+v", page)
+	}
+
+	// Jobs are only visible from the project that started them
+	otherCtx := WithExecutionContext(ctx, "user-1", "project-2")
+	if status, _ := statusTool.Execute(otherCtx, map[string]interface{}{"query_job_id": jobID}); status.Status != "failed" {
+		t.Error("Expected a job from another project to be hidden")
+	}
+
+	// Jobs query the datasource resolved when they were submitted, by name or the project default
+	if _, err := zdb.Execute(ctx, "UPDATE projects SET default_datasource_id = $1 WHERE id = 'project-1'", testDatasourceID); err != nil {
+		t.Fatalf("Failed to set the default datasource: %v", err)
+	}
+	for _, params := range []map[string]interface{}{{"datasource_id": "items"}, {}} {
+		params["query"] = "SELECT COUNT(*) AS n FROM items"
+		params["async"] = true
+		result, _ := tool.Execute(projectCtx, params)
+		if result.Status != "completed" || result.Data["datasource_id"] != testDatasourceID {
+			t.Fatalf("%v: expected the job to be submitted on the items datasource, got %s: %s %v", params, result.Status, result.Error, result.Data)
+		}
+		manager.Wait()
+		job, err := manager.Get(ctx, result.Data["query_job_id"].(string))
+		if err != nil || job.Status != jobs.StatusCompleted || job.DatasourceID != testDatasourceID {
+			t.Errorf("%v: expected the job to complete on the items datasource, got %+v, %v", params, job, err)
+		}
+	}
+}
+
+func TestBuildExplainQueryDialects(t *testing.T) {
+	tests := []struct {
+		dialect string
+		want    string
+		wantErr bool
+	}{
+		{dialectPostgres, "EXPLAIN (FORMAT JSON) SELECT 1", false},
+		{dialectMySQL, "EXPLAIN SELECT 1", false},
+		{dialectSQLite, "EXPLAIN QUERY PLAN SELECT 1", false},
+		{dialectTrino, "EXPLAIN SELECT 1", false},
+		{dialectClickHouse, "EXPLAIN SELECT 1", false},
+		{dialectSQLServer, "", true},
+		{dialectOracle, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := buildExplainQuery(tt.dialect, "SELECT 1")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("buildExplainQuery(%s) = %q, %v; want %q", tt.dialect, got, err, tt.want)
+		}
+	}
+
+	if _, err := buildExplainQuery(dialectPostgres, "UPDATE items SET name = 'x'"); err == nil {
+		t.Error("Expected UPDATE to be refused")
+	}
+	if _, err := buildExplainQuery(dialectPostgres, "EXPLAIN ANALYZE SELECT 1"); err == nil {
+		t.Error("Expected EXPLAIN ANALYZE to be refused")
+	}
+}
+
+func TestApplyRowLimitGuardDialects(t *testing.T) {
+	tests := []struct {
+		dialect   string
+		statement string
+		want      string
+	}{
+		{dialectPostgres, "SELECT * FROM t", "SELECT * FROM (\nSELECT * FROM t\n) AS limited_query LIMIT 100"},
+		{dialectMySQL, "WITH x AS (SELECT 1) SELECT * FROM x", "SELECT * FROM (\nWITH x AS (SELECT 1) SELECT * FROM x\n) AS limited_query LIMIT 100"},
+		{dialectSQLServer, "SELECT * FROM t", "SELECT TOP 100 * FROM (\nSELECT * FROM t\n) AS limited_query"},
+		{dialectOracle, "SELECT * FROM t", "SELECT * FROM (\nSELECT * FROM t\n) WHERE ROWNUM <= 100"},
+		{dialectPostgres, "SELECT * FROM t LIMIT 5", "SELECT * FROM t LIMIT 5"},
+		{dialectPostgres, "SELECT * FROM t FETCH FIRST 5 ROWS ONLY", "SELECT * FROM t FETCH FIRST 5 ROWS ONLY"},
+		{dialectSQLServer, "SELECT TOP 5 * FROM t", "SELECT TOP 5 * FROM t"},
+		{dialectPostgres, "SELECT 'limit 5' AS note", "SELECT * FROM (\nSELECT 'limit 5' AS note\n) AS limited_query LIMIT 100"},
+		{dialectPostgres, "INSERT INTO t VALUES (1)", "INSERT INTO t VALUES (1)"},
+		// Only a limit of the outermost query bounds the rows returned
+		{dialectPostgres, "SELECT * FROM t WHERE id IN (SELECT id FROM u LIMIT 5)",
+			"SELECT * FROM (\nSELECT * FROM t WHERE id IN (SELECT id FROM u LIMIT 5)\n) AS limited_query LIMIT 100"},
+		{dialectMySQL, "WITH x AS (SELECT * FROM t LIMIT 5) SELECT * FROM x, u",
+			"SELECT * FROM (\nWITH x AS (SELECT * FROM t LIMIT 5) SELECT * FROM x, u\n) AS limited_query LIMIT 100"},
+		{dialectSQLServer, "SELECT * FROM (SELECT TOP 5 * FROM t) s",
+			"SELECT TOP 100 * FROM (\nSELECT * FROM (SELECT TOP 5 * FROM t) s\n) AS limited_query"},
+		{dialectPostgres, "SELECT * FROM (SELECT * FROM t) s LIMIT 5", "SELECT * FROM (SELECT * FROM t) s LIMIT 5"},
+		{dialectSQLServer, "SELECT TOP (5) * FROM t", "SELECT TOP (5) * FROM t"},
+	}
+
+	for _, tt := range tests {
+		if got := applyRowLimitGuard(tt.dialect, tt.statement, 100); got != tt.want {
+			t.Errorf("applyRowLimitGuard(%s, %q) = %q, want %q", tt.dialect, tt.statement, got, tt.want)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+func TestChartToolFieldValidation(t *testing.T) {
+	tool := NewChartTool(nil)
+	rows := `[{"region":"north","revenue":10},{"region":"south","revenue":"n/a"}]`
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		code   string
+		field  string
+	}{
+		{"unknown chart type", map[string]interface{}{"data": rows, "chart_type": "radar", "x": "region", "y": "revenue"}, ChartErrInvalidChartType, ""},
+		{"unknown aggregation", map[string]interface{}{"data": rows, "chart_type": "bar", "x": "region", "y": "revenue", "aggregation": "median"}, ChartErrInvalidAggregation, ""},
+		{"invalid json", map[string]interface{}{"data": `[{"region":`, "chart_type": "bar", "x": "region", "y": "revenue"}, ChartErrInvalidData, ""},
+		{"rows are not objects", map[string]interface{}{"data": `[1, 2, 3]`, "chart_type": "bar", "x": "region", "y": "revenue"}, ChartErrInvalidData, ""},
+		{"no rows", map[string]interface{}{"data": `[]`, "chart_type": "bar", "x": "region", "y": "revenue"}, ChartErrInvalidData, ""},
+		{"unknown x", map[string]interface{}{"data": rows, "chart_type": "bar", "x": "country", "y": "revenue"}, ChartErrInvalidField, "x"},
+		{"unknown y", map[string]interface{}{"data": rows, "chart_type": "line", "x": "region", "y": "profit"}, ChartErrInvalidField, "y"},
+		{"missing y", map[string]interface{}{"data": rows, "chart_type": "pie", "x": "region"}, ChartErrInvalidField, "y"},
+		{"non-numeric y", map[string]interface{}{"data": rows, "chart_type": "bar", "x": "region", "y": "revenue", "aggregation": "sum"}, ChartErrInvalidField, "y"},
+		{"reference without conversation", map[string]interface{}{"data": "call_1", "chart_type": "bar", "x": "region", "y": "revenue"}, ChartErrResultNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		result, err := tool.Execute(context.Background(), tt.params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+		if result.Status != "failed" || result.Code != tt.code {
+			t.Errorf("%s: expected %s, got %s %s (%s)", tt.name, tt.code, result.Status, result.Code, result.Error)
+			continue
+		}
+		if tt.field != "" && result.Data["field"] != tt.field {
+			t.Errorf("%s: expected field %s, got %v", tt.name, tt.field, result.Data["field"])
+		}
+	}
+
+	// Counting needs no numeric y
+	result, _ := tool.Execute(context.Background(), map[string]interface{}{"data": rows, "chart_type": "pie", "x": "region", "aggregation": "count"})
+	if result.Status != "completed" {
+		t.Errorf("Expected count without y to succeed, got %s", result.Error)
+	}
+}
+
+func TestChartToolAggregation(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"region": "north", "revenue": 10},
+		map[string]interface{}{"region": "south", "revenue": "4.5"},
+		map[string]interface{}{"region": "north", "revenue": 30.0},
+		map[string]interface{}{"region": "south", "revenue": nil},
+		map[string]interface{}{"region": "north", "revenue": int64(20)},
+	}
+
+	tests := []struct {
+		aggregation string
+		north       interface{}
+		south       interface{}
+	}{
+		{"sum", 60.0, 4.5},
+		{"avg", 20.0, 4.5},
+		{"min", 10.0, 4.5},
+		{"max", 30.0, 4.5},
+		{"count", 3, 2},
+	}
+	for _, tt := range tests {
+		result, err := NewChartTool(nil).Execute(context.Background(), map[string]interface{}{
+			"data": rows, "chart_type": "bar", "x": "region", "y": "revenue", "aggregation": tt.aggregation,
+		})
+		if err != nil || result.Status != "completed" {
+			t.Fatalf("%s: chart failed: %v %s", tt.aggregation, err, result.Error)
+		}
+		points := result.Data["data"].([]map[string]interface{})
+		if len(points) != 2 || points[0]["region"] != "north" || points[1]["region"] != "south" {
+			t.Fatalf("%s: expected north then south, got %v", tt.aggregation, points)
+		}
+		if points[0]["revenue"] != tt.north || points[1]["revenue"] != tt.south {
+			t.Errorf("%s: expected %v and %v, got %v and %v", tt.aggregation, tt.north, tt.south, points[0]["revenue"], points[1]["revenue"])
+		}
+	}
+
+	// Pie charts encode the value as theta and the category as color
+	result, _ := NewChartTool(nil).Execute(context.Background(), map[string]interface{}{
+		"data": rows, "chart_type": "pie", "x": "region", "aggregation": "count",
+	})
+	encoding := result.Data["spec"].(map[string]interface{})["encoding"].(map[string]interface{})
+	if encoding["theta"].(map[string]interface{})["field"] != "count" || encoding["color"].(map[string]interface{})["field"] != "region" {
+		t.Errorf("Unexpected pie encoding: %v", encoding)
+	}
+}
+
+func TestChartToolDownsampling(t *testing.T) {
+	rows := make([]map[string]interface{}, 2500)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"day": i, "visits": i * 2}
+	}
+
+	result, err := NewChartTool(nil).Execute(context.Background(), map[string]interface{}{
+		"data": rows, "chart_type": "line", "x": "day", "y": "visits",
+	})
+	if err != nil || result.Status != "completed" {
+		t.Fatalf("Chart failed: %v %s", err, result.Error)
+	}
+	points := result.Data["data"].([]map[string]interface{})
+	if len(points) != MaxChartPoints || result.Data["downsampled"] != true || result.Data["original_point_count"] != 2500 {
+		t.Fatalf("Expected %d of 2500 points, got %d (%v)", MaxChartPoints, len(points), result.Data["original_point_count"])
+	}
+	if points[0]["day"] != 0 || points[len(points)-1]["day"] != 2499 {
+		t.Errorf("Expected the first and last points to be kept, got %v and %v", points[0]["day"], points[len(points)-1]["day"])
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i]["day"].(int) <= points[i-1]["day"].(int) {
+			t.Fatalf("Expected points in order, got %v after %v", points[i]["day"], points[i-1]["day"])
+		}
+	}
+
+	x := result.Data["spec"].(map[string]interface{})["encoding"].(map[string]interface{})["x"].(map[string]interface{})
+	if x["type"] != "quantitative" {
+		t.Errorf("Expected a quantitative x axis, got %v", x["type"])
+	}
+}
+
+func TestChartToolReferencesEarlierResult(t *testing.T) {
+	registry := NewDefaultToolRegistry()
+	if err := registry.RegisterTool(NewChartTool(registry.Results())); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+
+	queryResult := NewToolSuccess(map[string]interface{}{
+		"result": map[string]interface{}{
+			"columns": []string{"month", "orders"},
+			"rows": []map[string]interface{}{
+				{"month": "2024-01-01", "orders": int64(5)},
+				{"month": "2024-02-01", "orders": int64(8)},
+			},
+		},
+	}, 0)
+	registry.Results().Put("conv-1", "call_query", queryResult)
+
+	params := map[string]interface{}{"data": "call_query", "chart_type": "line", "x": "month", "y": "orders"}
+	result, err := registry.ExecuteTool(WithConversation(context.Background(), "conv-1"), "user-1", "project-1", "create_chart", params)
+	if err != nil || result.Status != "completed" {
+		t.Fatalf("Chart failed: %v %+v", err, result)
+	}
+	if result.Data["point_count"] != 2 {
+		t.Errorf("Expected 2 points, got %v", result.Data["point_count"])
+	}
+	x := result.Data["spec"].(map[string]interface{})["encoding"].(map[string]interface{})["x"].(map[string]interface{})
+	if x["type"] != "temporal" {
+		t.Errorf("Expected a temporal x axis, got %v", x["type"])
+	}
+
+	// Results are scoped to their conversation
+	result, _ = registry.ExecuteTool(WithConversation(context.Background(), "conv-2"), "user-1", "project-1", "create_chart", params)
+	if result.Code != ChartErrResultNotFound {
+		t.Errorf("Expected %s from another conversation, got %+v", ChartErrResultNotFound, result)
+	}
+}
+
+func TestResultStoreEviction(t *testing.T) {
+	store := NewResultStore(2, time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	for _, id := range []string{"call_1", "call_2", "call_3"} {
+		store.Put("conv-1", id, NewToolSuccess(nil, 0))
+	}
+	if _, ok := store.Get("conv-1", "call_1"); ok {
+		t.Error("Expected the oldest result to be evicted")
+	}
+	if _, ok := store.Get("conv-1", "call_3"); !ok {
+		t.Error("Expected the newest result to be kept")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := store.Get("conv-1", "call_3"); ok {
+		t.Error("Expected results to expire after the TTL")
+	}
+}
+
+// slowTool blocks until release is closed; it ignores its context unless honourContext is set
+type slowTool struct {
+	release       chan struct{}
+	started       chan struct{}
+	honourContext bool
+}
+
+func newSlowTool(honourContext bool) *slowTool {
+	return &slowTool{release: make(chan struct{}), started: make(chan struct{}, 16), honourContext: honourContext}
+}
+
+func (t *slowTool) Name() string                                 { return "slow_tool" }
+func (t *slowTool) Description() string                          { return "Blocks until released" }
+func (t *slowTool) Parameters() map[string]ToolParameter         { return map[string]ToolParameter{} }
+func (t *slowTool) ValidateAccess(userID, projectID string) bool { return true }
+func (t *slowTool) GetCategory() string                          { return "test" }
+
+func (t *slowTool) Execute(ctx context.Context, params map[string]interface{}) (*ToolResult, error) {
+	t.started <- struct{}{}
+	if t.honourContext {
+		select {
+		case <-t.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		<-t.release
+	}
+	return &ToolResult{Status: "success", Data: map[string]interface{}{"done": true}}, nil
+}
+
+func TestRegistryToolTimeout(t *testing.T) {
+	tool := newSlowTool(false)
+	defer close(tool.release)
+
+	registry := NewDefaultToolRegistry()
+	if err := registry.RegisterToolWithConfig(tool, ToolConfig{Timeout: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("RegisterToolWithConfig failed: %v", err)
+	}
+
+	start := time.Now()
+	result, err := registry.ExecuteTool(context.Background(), "user-1", "project-a", "slow_tool", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ExecuteTool failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout to return promptly, took %s", elapsed)
+	}
+	if result.Status != ToolStatusTimeout || result.Code != "TOOL_TIMEOUT" {
+		t.Errorf("Expected a timeout result, got %+v", result)
+	}
+	if !errors.Is(result.InterruptionError(), ErrToolTimeout) {
+		t.Errorf("Expected ErrToolTimeout, got %v", result.InterruptionError())
+	}
+
+	// The tool ignored its context, so it keeps its slot until it returns
+	if inFlight := registry.InFlight("slow_tool"); inFlight != 1 {
+		t.Errorf("Expected 1 execution in flight, got %d", inFlight)
+	}
+}
+
+func TestRegistryToolConcurrencyLimit(t *testing.T) {
+	tool := newSlowTool(true)
+	registry := NewDefaultToolRegistry()
+	if err := registry.RegisterToolWithConfig(tool, ToolConfig{Timeout: 200 * time.Millisecond, MaxConcurrent: 1}); err != nil {
+		t.Fatalf("RegisterToolWithConfig failed: %v", err)
+	}
+
+	first := make(chan *ToolResult, 1)
+	go func() {
+		result, _ := registry.ExecuteTool(context.Background(), "user-1", "project-a", "slow_tool", map[string]interface{}{})
+		first <- result
+	}()
+	<-tool.started
+
+	// The second call waits for the only slot and times out without running
+	result, err := registry.ExecuteTool(context.Background(), "user-1", "project-a", "slow_tool", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ExecuteTool failed: %v", err)
+	}
+	if result.Status != ToolStatusTimeout {
+		t.Errorf("Expected the queued call to time out, got %+v", result)
+	}
+	select {
+	case <-tool.started:
+		t.Error("Expected the queued call not to start while the slot was taken")
+	default:
+	}
+
+	close(tool.release)
+	if result := <-first; result == nil {
+		t.Fatal("Expected a result from the first call")
+	}
+}
+
+func TestRegistryCancelConversationTools(t *testing.T) {
+	tool := newSlowTool(true)
+	defer close(tool.release)
+
+	registry := NewDefaultToolRegistry()
+	if err := registry.RegisterTool(tool); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+	if config, _ := registry.ToolConfig("slow_tool"); config.Timeout != DefaultToolTimeout || config.MaxConcurrent != DefaultToolMaxConcurrent {
+		t.Errorf("Expected default limits, got %+v", config)
+	}
+
+	results := make(chan *ToolResult, 2)
+	for _, toolCallID := range []string{"call-1", "call-2"} {
+		ctx := WithToolCall(WithConversation(context.Background(), "conv-1"), toolCallID)
+		go func() {
+			result, _ := registry.ExecuteTool(ctx, "user-1", "project-a", "slow_tool", map[string]interface{}{})
+			results <- result
+		}()
+	}
+	<-tool.started
+	<-tool.started
+
+	if registry.CancelToolExecution("call-unknown") {
+		t.Error("Expected cancelling an unknown tool call to report false")
+	}
+	if cancelled := registry.CancelConversationTools("conv-2"); cancelled != 0 {
+		t.Errorf("Expected no executions cancelled for another conversation, got %d", cancelled)
+	}
+	if cancelled := registry.CancelConversationTools("conv-1"); cancelled != 2 {
+		t.Errorf("Expected 2 executions cancelled, got %d", cancelled)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-results:
+			if result.Status != ToolStatusCancelled || result.Code != "TOOL_CANCELLED" {
+				t.Errorf("Expected a cancelled result, got %+v", result)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for cancelled executions")
+		}
+	}
+}
+
+func setupSystemDatabase(t *testing.T) (*db.Database, DBConnection) {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+	dbtest.Seed(t, zdb, "CREATE VIEW user_directory AS SELECT username, password_hash FROM users")
+	for i := 1; i <= systemRowLimit+20; i++ {
+		if _, err := zdb.Execute(context.Background(),
+			"INSERT INTO conversations (id, user_id, project_id, title) VALUES ($1, 'user-1', 'project-1', $2)",
+			fmt.Sprintf("conv-%03d", i), fmt.Sprintf("Conversation %d", i)); err != nil {
+			t.Fatalf("Failed to seed conversations: %v", err)
+		}
+	}
+	return zdb, &ZlayDBAdapter{DB: zdb}
+}
+
+func TestDatabaseToolsRequireDatasourceByDefault(t *testing.T) {
+	zdb, _ := setupSystemDatabase(t)
+
+	result, _ := NewDatabaseQueryTool(zdb, nil).Execute(context.Background(), map[string]interface{}{"query": "SELECT 1"})
+	if result.Status != "failed" || result.Code != ErrCodeDatasourceRequired {
+		t.Errorf("Expected DATASOURCE_REQUIRED from database_query, got %s %s: %s", result.Status, result.Code, result.Error)
+	}
+	result, _ = NewDatasourceInspectTool(zdb, nil).Execute(context.Background(), map[string]interface{}{})
+	if result.Status != "failed" || result.Code != ErrCodeDatasourceRequired {
+		t.Errorf("Expected DATASOURCE_REQUIRED from datasource_inspect, got %s %s: %s", result.Status, result.Code, result.Error)
+	}
+}
+
+func TestSystemDatabaseQueriesAreRestricted(t *testing.T) {
+	zdb, conn := setupSystemDatabase(t)
+	tool := NewDatabaseQueryTool(zdb, nil)
+	tool.AllowSystemDatabase(conn)
+
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{"SELECT * FROM users", ErrCodeSystemTableForbidden},
+		{`SELECT * FROM "users"`, ErrCodeSystemTableForbidden},
+		{`SELECT * FROM "main"."Users"`, ErrCodeSystemTableForbidden},
+		{"SELECT * FROM [users]", ErrCodeSystemTableForbidden},
+		{"SELECT * FROM `USERS` u", ErrCodeSystemTableForbidden},
+		{"SELECT * FROM user_directory", ErrCodeSystemTableForbidden},
+		{"SELECT title FROM conversations c JOIN sessions s ON s.user_id = c.user_id", ErrCodeSystemTableForbidden},
+		{"SELECT title FROM conversations WHERE user_id IN (SELECT user_id FROM /* hidden */ sessions)", ErrCodeSystemTableForbidden},
+		{"SELECT query_to_xml('select * from api_keys', true, true, '')", ErrCodeSystemTableForbidden},
+		{"WITH c AS (SELECT * FROM clients) SELECT * FROM c", ErrCodeSystemTableForbidden},
+		{"SELECT * FROM other_schema.conversations", ErrCodeSystemTableForbidden},
+		{"SELECT pg_read_file('/etc/passwd')", ErrCodeSystemQueryForbidden},
+		{"DELETE FROM conversations", ErrCodeSystemQueryForbidden},
+		{"SELECT 1; DROP TABLE conversations", ErrCodeSystemQueryForbidden},
+		{"SELECT * INTO stolen FROM conversations", ErrCodeSystemQueryForbidden},
+	} {
+		result, _ := tool.Execute(context.Background(), map[string]interface{}{"query": tc.query})
+		if result.Status != "failed" || result.Code != tc.code {
+			t.Errorf("%s: expected %s, got %s %s: %s", tc.query, tc.code, result.Status, result.Code, result.Error)
+		}
+	}
+	row, err := zdb.QueryRow(context.Background(), "SELECT COUNT(*) FROM conversations")
+	if err != nil {
+		t.Fatalf("Failed to count conversations: %v", err)
+	}
+	if count, _ := row.Values[0].AsInt64(); count != systemRowLimit+20 {
+		t.Errorf("Expected no conversations deleted, got %d", count)
+	}
+
+	// Allowed reads run with their rows capped
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"SELECT id, title FROM conversations ORDER BY id", systemRowLimit},
+		{"SELECT c.title, COUNT(*) AS n FROM main.conversations AS c GROUP BY c.title LIMIT 500", systemRowLimit},
+		{"WITH recent AS (SELECT * FROM conversations WHERE id > 'conv-110') SELECT title FROM recent", 10},
+		{"SELECT strftime('%Y', 'now') AS year FROM conversations LIMIT 1", 1},
+	} {
+		result, _ := tool.Execute(context.Background(), map[string]interface{}{"query": tc.query})
+		if result.Status != "completed" {
+			t.Errorf("%s: expected completed, got %s: %s", tc.query, result.Status, result.Error)
+			continue
+		}
+		if count := result.Data["result"].(map[string]interface{})["count"]; count != tc.want {
+			t.Errorf("%s: expected %d rows, got %v", tc.query, tc.want, count)
+		}
+	}
+}
+
+func TestCheckSystemQueryAllowsFunctionKeywords(t *testing.T) {
+	for _, query := range []string{
+		"SELECT EXTRACT(YEAR FROM created_at) AS year, COUNT(*) FROM conversations GROUP BY 1",
+		"SELECT SUBSTRING(title FROM 1 FOR 10) FROM messages",
+		"SELECT p.name FROM public.projects p, project_files f WHERE f.project_id = p.id",
+	} {
+		if err := checkSystemQuery(query); err != nil {
+			t.Errorf("%s: unexpected error %v", query, err)
+		}
+	}
+}
+
+func TestSystemDatabaseInspectionIsRestricted(t *testing.T) {
+	zdb, conn := setupSystemDatabase(t)
+	tool := NewDatasourceInspectTool(zdb, nil)
+	tool.AllowSystemDatabase(conn)
+
+	for _, table := range []string{"users", "Users", "main.users", "user_directory"} {
+		result, _ := tool.Execute(context.Background(), map[string]interface{}{"table_name": table})
+		if result.Status != "failed" || result.Code != ErrCodeSystemTableForbidden {
+			t.Errorf("%s: expected SYSTEM_TABLE_FORBIDDEN, got %s %s: %s", table, result.Status, result.Code, result.Error)
+		}
+	}
+
+	result, _ := tool.Execute(context.Background(), map[string]interface{}{"include_relations": true})
+	if result.Status != "completed" {
+		t.Fatalf("Expected completed, got %s: %s", result.Status, result.Error)
+	}
+	info := result.Data["datasource"].(*DatasourceInfo)
+	if info.Type != "sqlite" {
+		t.Errorf("Expected the system database type to be detected, got %q", info.Type)
+	}
+	if len(info.Tables) != len(systemReadableTables) || info.TableCount != len(systemReadableTables) {
+		t.Errorf("Expected only the readable tables to be listed, got %+v", info.Tables)
+	}
+	for _, table := range info.Tables {
+		if !systemReadableTables[table.Name] {
+			t.Errorf("Expected %s not to be listed", table.Name)
+		}
+	}
+	for _, relation := range info.Relations {
+		if !systemReadableTables[relation.FromTable] || !systemReadableTables[relation.ToTable] {
+			t.Errorf("Expected relations to forbidden tables to be dropped, got %+v", relation)
+		}
+	}
+}
+
+func TestGroupRelationsMergesCompositeKeys(t *testing.T) {
+	// One row per column pair, as the PostgreSQL and MySQL catalogs return them
+	rows := []RelationInfo{
+		{FromTable: "order_lines", FromColumns: []string{"order_id"}, ToTable: "orders", ToColumns: []string{"id"}, ConstraintName: "fk_order", Direction: RelationOutgoing},
+		{FromTable: "order_lines", FromColumns: []string{"product_id"}, ToTable: "product_versions", ToColumns: []string{"product_id"}, ConstraintName: "fk_version", Direction: RelationOutgoing},
+		{FromTable: "order_lines", FromColumns: []string{"version"}, ToTable: "product_versions", ToColumns: []string{"version"}, ConstraintName: "fk_version", Direction: RelationOutgoing},
+		// constraint_column_usage pairs every column with every referenced column
+		{FromTable: "shipments", FromColumns: []string{"product_id"}, ToTable: "product_versions", ToColumns: []string{"version"}, ConstraintName: "fk_shipment"},
+		{FromTable: "shipments", FromColumns: []string{"product_id"}, ToTable: "product_versions", ToColumns: []string{"product_id"}, ConstraintName: "fk_shipment"},
+		{FromTable: "shipments", FromColumns: []string{"version"}, ToTable: "product_versions", ToColumns: []string{"version"}, ConstraintName: "fk_shipment"},
+		{FromTable: "shipments", FromColumns: []string{"version"}, ToTable: "product_versions", ToColumns: []string{"product_id"}, ConstraintName: "fk_shipment"},
+	}
+
+	grouped := groupRelations(rows)
+	if len(grouped) != 3 {
+		t.Fatalf("Expected one relation per constraint, got %+v", grouped)
+	}
+	if got := grouped[1]; got.ConstraintName != "fk_version" ||
+		strings.Join(got.FromColumns, ",") != "product_id,version" || strings.Join(got.ToColumns, ",") != "product_id,version" {
+		t.Errorf("Expected ordered composite columns, got %+v", got)
+	}
+	if got := grouped[2]; len(got.FromColumns) != 2 || len(got.ToColumns) != 2 {
+		t.Errorf("Expected each column listed once, got %+v", got)
+	}
+}
+
+func setupRelationsDatabase(t *testing.T) *DatasourceInspector {
+	t.Helper()
+
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "relations.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { zdb.Close() })
+
+	for _, stmt := range []string{
+		"CREATE TABLE customers (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER REFERENCES customers(id))",
+		"CREATE TABLE product_versions (product_id INTEGER, version INTEGER, PRIMARY KEY (product_id, version))",
+		`CREATE TABLE order_lines (
+			id INTEGER PRIMARY KEY,
+			order_id INTEGER REFERENCES orders(id) ON DELETE CASCADE,
+			product_id INTEGER,
+			version INTEGER,
+			FOREIGN KEY (product_id, version) REFERENCES product_versions(product_id, version)
+		)`,
+		"CREATE TABLE refunds (id INTEGER PRIMARY KEY, line_id INTEGER REFERENCES order_lines(id))",
+	} {
+		if _, err := zdb.Execute(context.Background(), stmt); err != nil {
+			t.Fatalf("Failed to set up: %v", err)
+		}
+	}
+	return NewDatasourceInspector(&ZlayDBAdapter{DB: zdb}, "sqlite")
+}
+
+func TestTableRelationsGroupCompositeKeysAndIncludeReverse(t *testing.T) {
+	inspector := setupRelationsDatabase(t)
+	ctx := context.Background()
+
+	relations, err := inspector.getTableRelations(ctx, "order_lines", "sqlite", false)
+	if err != nil {
+		t.Fatalf("Failed to get relations: %v", err)
+	}
+	if len(relations) != 2 {
+		t.Fatalf("Expected two outgoing relations, got %+v", relations)
+	}
+	for _, rel := range relations {
+		if rel.Direction != RelationOutgoing {
+			t.Errorf("Expected outgoing relations, got %+v", rel)
+		}
+		if rel.ToTable == "product_versions" &&
+			(strings.Join(rel.FromColumns, ",") != "product_id,version" || strings.Join(rel.ToColumns, ",") != "product_id,version") {
+			t.Errorf("Expected the composite key as one relation, got %+v", rel)
+		}
+	}
+
+	relations, err = inspector.getTableRelations(ctx, "order_lines", "sqlite", true)
+	if err != nil || len(relations) != 3 {
+		t.Fatalf("Expected the reference from refunds as well, got %+v, %v", relations, err)
+	}
+	if incoming := relations[2]; incoming.Direction != RelationIncoming || incoming.FromTable != "refunds" || incoming.ToTable != "order_lines" {
+		t.Errorf("Expected an incoming relation from refunds, got %+v", incoming)
+	}
+}
+
+func TestBuildRelationGraphDedupesAndHonoursDepth(t *testing.T) {
+	inspector := setupRelationsDatabase(t)
+	ctx := context.Background()
+
+	all, err := inspector.getAllRelations(ctx, "sqlite")
+	if err != nil {
+		t.Fatalf("Failed to get relations: %v", err)
+	}
+	// Duplicated entries, e.g. a relation listed both outgoing and incoming, give one edge
+	graph, _ := inspector.buildRelationGraph(ctx, append(all, all...), "", 1, true)
+	if len(graph.Edges) != 4 || len(graph.Nodes) != 5 {
+		t.Errorf("Expected 4 edges between 5 tables, got %d edges and %d nodes", len(graph.Edges), len(graph.Nodes))
+	}
+
+	tablesOf := func(graph *RelationGraph) string {
+		var tables []string
+		for _, node := range graph.Nodes {
+			tables = append(tables, fmt.Sprintf("%s:%d", node.Table, node.Depth))
+		}
+		return strings.Join(tables, ",")
+	}
+	for _, tc := range []struct {
+		depth          int
+		followIncoming bool
+		want           string
+	}{
+		{1, false, "order_lines:0,orders:1,product_versions:1"},
+		{2, false, "order_lines:0,orders:1,product_versions:1,customers:2"},
+		{1, true, "order_lines:0,orders:1,product_versions:1,refunds:1"},
+		{3, true, "order_lines:0,orders:1,product_versions:1,refunds:1,customers:2"},
+	} {
+		graph, _ := inspector.buildRelationGraph(ctx, all, "order_lines", tc.depth, tc.followIncoming)
+		if got := tablesOf(graph); got != tc.want {
+			t.Errorf("depth %d, incoming %v: expected %s, got %s", tc.depth, tc.followIncoming, tc.want, got)
+		}
+		for _, edge := range graph.Edges {
+			if edge.From == "orders" && edge.To == "customers" && tc.depth < 2 {
+				t.Errorf("depth %d: unexpected edge beyond the depth %+v", tc.depth, edge)
+			}
+		}
+	}
+}
+
+func TestDatabaseToolsResolveDatasourceByNameOrDefault(t *testing.T) {
+	tool, zdb, _ := setupDatabaseQueryTool(t)
+	inspectTool := NewDatasourceInspectTool(zdb, nil)
+	ctx := WithExecutionContext(context.Background(), "user-1", "project-1")
+	for _, stmt := range []string{
+		"INSERT INTO datasources (id, project_id, name, type, config, is_active) VALUES ('ds-reports-1', 'project-1', 'Reports', 'postgres', '{}', true)",
+		"INSERT INTO datasources (id, project_id, name, type, config, is_active) VALUES ('ds-reports-2', 'project-1', 'reports', 'mysql', '{}', true)",
+		"INSERT INTO datasources (id, project_id, name, type, config, is_active) VALUES ('ds-archive', 'project-1', 'Archive', 'sqlite', '{}', false)",
+	} {
+		if _, err := zdb.Execute(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up: %v", err)
+		}
+	}
+	query := func(params map[string]interface{}) *ToolResult {
+		params["query"] = "SELECT COUNT(*) AS n FROM items"
+		result, _ := tool.Execute(ctx, params)
+		return result
+	}
+
+	// By ID, and by name regardless of case
+	for _, ref := range []string{testDatasourceID, "Items", "ITEMS"} {
+		result := query(map[string]interface{}{"datasource_id": ref})
+		if result.Status != "completed" || result.Data["datasource_id"] != testDatasourceID {
+			t.Errorf("%s: expected the items datasource, got %s: %s %v", ref, result.Status, result.Error, result.Data)
+		}
+	}
+	if result, _ := inspectTool.Execute(ctx, map[string]interface{}{"datasource_id": "items"}); result.Status != "completed" {
+		t.Errorf("Expected inspection by name to work, got %s: %s", result.Status, result.Error)
+	}
+
+	result := query(map[string]interface{}{"datasource_id": "reports"})
+	if result.Code != ErrCodeDatasourceAmbiguous || len(result.Data["candidates"].([]map[string]interface{})) != 2 {
+		t.Errorf("Expected an ambiguous name to list both candidates, got %s %v", result.Code, result.Data)
+	}
+	result = query(map[string]interface{}{"datasource_id": "archive"})
+	if result.Code != ErrCodeDatasourceNotFound || len(result.Data["candidates"].([]map[string]interface{})) != 3 {
+		t.Errorf("Expected an unknown name to list the active datasources, got %s %v", result.Code, result.Data)
+	}
+
+	// Without a project default the call needs a datasource
+	if result := query(map[string]interface{}{}); result.Code != ErrCodeDatasourceRequired {
+		t.Errorf("Expected DATASOURCE_REQUIRED without a default, got %s: %s", result.Code, result.Error)
+	}
+	if _, err := zdb.Execute(ctx, "UPDATE projects SET default_datasource_id = $1 WHERE id = 'project-1'", testDatasourceID); err != nil {
+		t.Fatalf("Failed to set the default datasource: %v", err)
+	}
+	if result := query(map[string]interface{}{}); result.Status != "completed" || result.Data["datasource_id"] != testDatasourceID {
+		t.Errorf("Expected the project default to be used, got %s: %s", result.Status, result.Error)
+	}
+}
+
+func TestDatasourceToolsRefuseOtherProjectsDatasources(t *testing.T) {
+	tool, zdb, _ := setupDatabaseQueryTool(t)
+	dbtest.SeedProject(t, zdb, "client-2", "user-2", "project-2")
+	otherCtx := WithExecutionContext(context.Background(), "user-2", "project-2")
+
+	// testDatasourceID belongs to project-1
+	result, _ := tool.Execute(otherCtx, map[string]interface{}{"datasource_id": testDatasourceID, "query": "SELECT * FROM items"})
+	if result.Code != ErrCodeDatasourceNotFound {
+		t.Errorf("Expected DATASOURCE_NOT_FOUND for another project's datasource, got %s: %s %v", result.Status, result.Error, result.Data)
+	}
+	result, _ = NewDatasourceInspectTool(zdb, nil).Execute(otherCtx, map[string]interface{}{"datasource_id": testDatasourceID})
+	if result.Code != ErrCodeDatasourceNotFound {
+		t.Errorf("Expected inspection of another project's datasource to be refused, got %s: %s", result.Status, result.Error)
+	}
+
+	// The lookups behind the tools are scoped too
+	if _, err := tool.getDatasourceConnection(otherCtx, testDatasourceID); err == nil {
+		t.Error("Expected no connection to another project's datasource")
+	}
+	if _, err := (&DatasourceInspectTool{zdb: zdb}).getDatasourceType(otherCtx, testDatasourceID); err == nil {
+		t.Error("Expected no type for another project's datasource")
+	}
+	if _, _, err := NewAPITool(zdb, nil, nil).getDatasourceConfig(otherCtx, testDatasourceID); err == nil {
+		t.Error("Expected no config for another project's datasource")
+	}
+	if _, err := tool.getDatasourceConnection(testProjectContext(), testDatasourceID); err != nil {
+		t.Errorf("Expected the owning project to connect, got %v", err)
+	}
+}
+
+func TestHasSideEffects(t *testing.T) {
+	tests := []struct {
+		tool   Tool
+		params map[string]interface{}
+		want   bool
+	}{
+		{&DatabaseQueryTool{}, map[string]interface{}{"query": "SELECT * FROM users"}, false},
+		{&DatabaseQueryTool{}, map[string]interface{}{"query": "WITH t AS (SELECT 1) SELECT * FROM t; select 2"}, false},
+		{&DatabaseQueryTool{}, map[string]interface{}{"query": "SELECT 1; INSERT INTO logs VALUES (1)"}, true},
+		{&DatabaseQueryTool{}, map[string]interface{}{"query": "UPDATE users SET name = 'x'"}, true},
+		{&APITool{}, map[string]interface{}{"method": "get"}, false},
+		{&APITool{}, map[string]interface{}{"method": "POST"}, true},
+		{&SystemInfoTool{}, map[string]interface{}{}, false},
+	}
+	for _, tt := range tests {
+		if got := HasSideEffects(tt.tool, tt.params); got != tt.want {
+			t.Errorf("HasSideEffects(%s, %v) = %t, want %t", tt.tool.Name(), tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestReferencedTables(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		want      []string
+	}{
+		{"single table", "SELECT * FROM orders", []string{"orders"}},
+		{"schema qualified", "SELECT * FROM payroll.salaries s WHERE s.id = 1", []string{"payroll.salaries"}},
+		{"quoted identifiers", `SELECT * FROM "Payroll"."Salary Bands" JOIN [dbo].[users] u ON 1=1 JOIN ` + "`shop`.`items`", []string{"Payroll.Salary Bands", "dbo.users", "shop.items"}},
+		{"comma list with aliases", "SELECT * FROM orders o, customers AS c, regions WHERE o.c = c.id", []string{"orders", "customers", "regions"}},
+		{"joins", "SELECT * FROM orders o LEFT JOIN customers c ON c.id = o.customer_id INNER JOIN public.regions r USING (region_id)", []string{"orders", "customers", "public.regions"}},
+		{"subqueries", "SELECT * FROM (SELECT id FROM payroll.salaries) s WHERE id IN (SELECT id FROM staff WHERE EXISTS (SELECT 1 FROM audit))", []string{"payroll.salaries", "staff", "audit"}},
+		{"ctes are not tables", "WITH recent AS (SELECT * FROM orders), totals(n) AS (SELECT COUNT(*) FROM recent) SELECT * FROM totals JOIN rpt_sales ON true", []string{"orders", "rpt_sales"}},
+		{"qualified name shadowing a cte", "WITH orders AS (SELECT 1) SELECT * FROM orders, archive.orders", []string{"archive.orders"}},
+		{"function arguments", "SELECT EXTRACT(YEAR FROM created_at), SUBSTRING(name FROM 2) FROM events WHERE a IS DISTINCT FROM b", []string{"events"}},
+		{"table functions", "SELECT * FROM generate_series(1, 3) g, lateral_tbl", []string{"lateral_tbl"}},
+		{"insert select", "INSERT INTO archive.orders (id, total) SELECT id, total FROM orders ON CONFLICT (id) DO UPDATE SET total = 0", []string{"archive.orders", "orders"}},
+		{"update and delete", "UPDATE accounts SET balance = 0 WHERE id IN (SELECT id FROM flagged)", []string{"accounts", "flagged"}},
+		{"delete using", "DELETE FROM sessions USING users WHERE sessions.user_id = users.id", []string{"sessions", "users"}},
+		{"for update", "SELECT * FROM jobs FOR UPDATE SKIP LOCKED", []string{"jobs"}},
+		{"strings and comments ignored", "SELECT 'FROM payroll' AS x /* FROM secret */ FROM t -- JOIN hidden", []string{"t"}},
+		{"repeated tables once", "SELECT * FROM a JOIN a ON true JOIN A ON true", []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := referencedTables(tt.statement)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("referencedTables(%q) = %q, want %q", tt.statement, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPolicyRules(t *testing.T) {
+	policy, err := ParseQueryPolicy([]byte(`[
+		{"effect":"deny","pattern":"payroll.*","type":"table"},
+		{"effect":"DENY","pattern":"^audit_","type":"regex"},
+		{"effect":"allow","pattern":"rpt_*"},
+		{"effect":"allow","pattern":"^(public\\.)?dim_[a-z]+$","type":"regex"}
+	]`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		table     string
+		ruleIndex int // -1 allowed, -2 refused by no allow rule
+	}{
+		{"rpt_sales", -1},
+		{"analytics.RPT_Sales", -1},
+		{"dim_region", -1},
+		{"public.dim_region", -1},
+		{"payroll.salaries", 0},
+		{"PAYROLL.rpt_salaries", 0},
+		{"audit_log", 1},
+		{"orders", -2},
+		{"salaries", -2}, // Unqualified references do not match schema rules
+	}
+	for _, tt := range tests {
+		violation := policy.CheckTable(tt.table)
+		switch {
+		case tt.ruleIndex == -1 && violation != nil:
+			t.Errorf("%s: expected to be allowed, got %v", tt.table, violation)
+		case tt.ruleIndex == -2 && (violation == nil || violation.Rule != nil):
+			t.Errorf("%s: expected no allow rule to match, got %v", tt.table, violation)
+		case tt.ruleIndex >= 0 && (violation == nil || violation.Rule == nil || violation.RuleIndex != tt.ruleIndex):
+			t.Errorf("%s: expected rule %d to deny it, got %v", tt.table, tt.ruleIndex, violation)
+		}
+	}
+
+	if violation := policy.CheckStatement("SELECT * FROM rpt_sales r JOIN payroll.salaries s ON true"); violation == nil || violation.Table != "payroll.salaries" {
+		t.Errorf("Expected the joined payroll table to be refused, got %v", violation)
+	}
+	denyOnly, _ := ParseQueryPolicy([]byte(`[{"effect":"deny","pattern":"secrets"}]`))
+	if violation := denyOnly.CheckTable("orders"); violation != nil {
+		t.Errorf("Expected deny-only policies to allow other tables, got %v", violation)
+	}
+
+	for _, raw := range []string{
+		`{"effect":"deny"}`,
+		`[{"effect":"block","pattern":"x"}]`,
+		`[{"effect":"deny","pattern":""}]`,
+		`[{"effect":"deny","pattern":"x","type":"glob"}]`,
+		`[{"effect":"deny","pattern":"(","type":"regex"}]`,
+		`[{"effect":"deny","pattern":"[","type":"table"}]`,
+	} {
+		if _, err := ParseQueryPolicy([]byte(raw)); err == nil {
+			t.Errorf("Expected %s to be rejected", raw)
+		}
+	}
+	if policy, err := ParseQueryPolicy(nil); err != nil || policy.CheckTable("anything") != nil {
+		t.Errorf("Expected an empty policy to allow everything, got %v", err)
+	}
+}
+
+func TestDatabaseToolsEnforceQueryPolicy(t *testing.T) {
+	tool, zdb, items := setupDatabaseQueryTool(t)
+	inspectTool := NewDatasourceInspectTool(zdb, nil)
+	ctx := WithExecutionContext(context.Background(), "user-1", "project-1")
+	if _, err := zdb.Execute(ctx, `UPDATE datasources SET query_policies = '[{"effect":"deny","pattern":"projects"},{"effect":"deny","pattern":"^data","type":"regex"}]'`); err != nil {
+		t.Fatalf("Failed to set the query policy: %v", err)
+	}
+	for _, table := range []string{"projects", "datasources"} {
+		if _, err := items.Execute(ctx, "CREATE TABLE "+table+" (id INTEGER PRIMARY KEY)"); err != nil {
+			t.Fatalf("Failed to create %s: %v", table, err)
+		}
+	}
+
+	result, _ := tool.Execute(ctx, map[string]interface{}{"datasource_id": testDatasourceID, "query": "SELECT COUNT(*) FROM items"})
+	if result.Status != "completed" {
+		t.Fatalf("Expected allowed tables to be queried, got %s: %s", result.Status, result.Error)
+	}
+
+	result, _ = tool.Execute(ctx, map[string]interface{}{
+		"datasource_id": testDatasourceID,
+		"query":         "INSERT INTO items (name) VALUES ('x'); SELECT * FROM items WHERE name IN (SELECT id FROM \"Projects\")",
+	})
+	if result.Code != ErrCodeQueryPolicyViolation || result.Data["rule_index"] != 0 || result.Data["table"] != "Projects" {
+		t.Errorf("Expected the subquery on projects to be refused by rule 0, got %s: %s %v", result.Code, result.Error, result.Data)
+	}
+	if countItems(t, items) != 0 {
+		t.Errorf("Expected nothing to run when any statement is refused")
+	}
+
+	result, _ = inspectTool.Execute(ctx, map[string]interface{}{"datasource_id": testDatasourceID, "include_columns": false, "include_indexes": false})
+	if result.Status != "completed" {
+		t.Fatalf("Expected inspection to work, got %s: %s", result.Status, result.Error)
+	}
+	info := result.Data["datasource"].(*DatasourceInfo)
+	if len(info.Tables) != 1 || info.Tables[0].Name != "items" || info.TableCount != 1 {
+		t.Errorf("Expected only the items table to be listed, got %+v", info.Tables)
+	}
+	result, _ = inspectTool.Execute(ctx, map[string]interface{}{"datasource_id": testDatasourceID, "table_name": "datasources"})
+	if result.Code != ErrCodeQueryPolicyViolation {
+		t.Errorf("Expected inspecting a denied table to be refused, got %s: %s", result.Code, result.Error)
+	}
+}
+
+func TestDatasourceInspectToolServesFromSchemaCache(t *testing.T) {
+	_, zdb, items := setupDatabaseQueryTool(t)
+	cache := NewSchemaCache(DatasourceSchemaSource(zdb), time.Hour)
+	inspectTool := NewDatasourceInspectTool(zdb, nil)
+	inspectTool.UseSchemaCache(cache)
+	ctx := WithExecutionContext(context.Background(), "user-1", "project-1")
+	if _, err := zdb.Execute(ctx, `UPDATE datasources SET query_policies = '[{"effect":"deny","pattern":"projects"}]'`); err != nil {
+		t.Fatalf("Failed to set the query policy: %v", err)
+	}
+	if _, err := items.Execute(ctx, "CREATE TABLE projects (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	tableNames := func() []string {
+		result, _ := inspectTool.Execute(ctx, map[string]interface{}{"datasource_id": testDatasourceID})
+		if result.Status != "completed" {
+			t.Fatalf("Expected inspection to work, got %s: %s", result.Status, result.Error)
+		}
+		var names []string
+		for _, table := range result.Data["datasource"].(*DatasourceInfo).Tables {
+			names = append(names, table.Name)
+		}
+		return names
+	}
+	if names := tableNames(); !reflect.DeepEqual(names, []string{"items"}) {
+		t.Errorf("Expected the allowed tables, got %v", names)
+	}
+
+	schema, err := cache.Get(ctx, testDatasourceID)
+	if err != nil {
+		t.Fatalf("Expected the schema to be cached: %v", err)
+	}
+	table, found := schema.Table("items")
+	if !found || len(table.Columns) != 2 || !table.Columns[0].PrimaryKey {
+		t.Errorf("Expected the items columns cached, got %+v", table)
+	}
+
+	// A new table is only seen once the cache is invalidated
+	if _, err := items.Execute(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if names := tableNames(); len(names) != 1 {
+		t.Errorf("Expected the cached tables, got %v", names)
+	}
+	cache.Invalidate(testDatasourceID)
+	if names := tableNames(); !reflect.DeepEqual(names, []string{"items", "orders"}) {
+		t.Errorf("Expected the new table after invalidation, got %v", names)
+	}
+	reloaded, _ := cache.Get(ctx, testDatasourceID)
+	if reloaded.Generation == schema.Generation {
+		t.Errorf("Expected a new generation for the changed schema")
+	}
+
+	// Statistics are never cached
+	result, _ := inspectTool.Execute(ctx, map[string]interface{}{"datasource_id": testDatasourceID, "table_name": "items", "include_stats": true})
+	if result.Status != "completed" || result.Data["table"].(*TableInfo).Name != "items" {
+		t.Errorf("Expected a live inspection with statistics, got %s: %s", result.Status, result.Error)
+	}
+}
+
+func TestSchemaCacheServesPreviousSchemaWhenReloadFails(t *testing.T) {
+	_, zdb, _ := setupDatabaseQueryTool(t)
+	source := DatasourceSchemaSource(zdb)
+	failing := false
+	cache := NewSchemaCache(func(ctx context.Context, datasourceID string) (SchemaInspector, string, func(), error) {
+		if failing {
+			return nil, "", nil, errors.New("connection refused")
+		}
+		return source(ctx, datasourceID)
+	}, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	first, err := cache.Get(context.Background(), testDatasourceID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	failing = true
+	now = now.Add(2 * time.Minute)
+	second, err := cache.Get(context.Background(), testDatasourceID)
+	if err != nil || second.Generation != first.Generation || len(second.Tables) != len(first.Tables) {
+		t.Errorf("Expected the previous schema when the reload fails, got %v", err)
+	}
+	if _, err := cache.Get(context.Background(), "ds-missing"); err == nil {
+		t.Error("Expected an error with nothing cached to fall back on")
+	}
+}
+
+// staticConversationSearcher returns fixed matches and records the limit asked for
+type staticConversationSearcher struct {
+	matches []ConversationMatch
+	limit   int
+}
+
+func (s *staticConversationSearcher) SearchConversations(ctx context.Context, userID, projectID, query string, limit int) ([]ConversationMatch, error) {
+	s.limit = limit
+	return s.matches, nil
+}
+
+func TestConversationSearchToolSkipsCurrentConversation(t *testing.T) {
+	searcher := &staticConversationSearcher{matches: []ConversationMatch{
+		{ConversationID: "conv-current", MessageID: "msg-1", Score: 0.9},
+		{ConversationID: "conv-a", MessageID: "msg-2", Score: 0.8},
+		{ConversationID: "conv-b", MessageID: "msg-3", Score: 0.7},
+	}}
+	tool := NewConversationSearchTool(searcher, staticPermissionChecker{"user-1": RoleViewer})
+	if !tool.ValidateAccess("user-1", "project-1") || tool.ValidateAccess("user-2", "project-1") {
+		t.Error("Expected project viewers, and only them, to search conversations")
+	}
+
+	ctx := WithConversation(WithExecutionContext(context.Background(), "user-1", "project-1"), "conv-current")
+	result, err := tool.Execute(ctx, map[string]interface{}{"query": "last invoice", "limit": float64(2)})
+	if err != nil || result.Status != "completed" {
+		t.Fatalf("Expected a completed search, got %+v (%v)", result, err)
+	}
+	if searcher.limit != 3 {
+		t.Errorf("Expected one extra match asked for in place of the current conversation, got limit %d", searcher.limit)
+	}
+	matches := result.Data["matches"].([]ConversationMatch)
+	if len(matches) != 2 || matches[0].MessageID != "msg-2" || matches[1].MessageID != "msg-3" {
+		t.Errorf("Expected the current conversation left out, got %+v", matches)
+	}
+
+	result, _ = tool.Execute(ctx, map[string]interface{}{"query": "  "})
+	if result.Status != "failed" {
+		t.Errorf("Expected an empty query to fail, got %+v", result)
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	schema := map[string]ToolParameter{
+		"query":   {Type: "string", Required: true},
+		"limit":   {Type: "integer", Default: 10},
+		"ratio":   {Type: "number"},
+		"dry_run": {Type: "boolean", Default: false},
+		"headers": {Type: "object"},
+		"columns": {Type: "array"},
+		"format":  {Type: "string", Enum: []string{"csv", "json"}},
+		"extra":   {},
+	}
+
+	tests := []struct {
+		name      string
+		params    map[string]interface{}
+		want      map[string]interface{}
+		parameter string
+	}{
+		{"defaults filled in", map[string]interface{}{"query": "SELECT 1"},
+			map[string]interface{}{"query": "SELECT 1", "limit": 10, "dry_run": false}, ""},
+		{"all types", map[string]interface{}{
+			"query": "q", "limit": float64(5), "ratio": 0.5, "dry_run": true,
+			"headers": map[string]interface{}{"a": "b"}, "columns": []interface{}{"id"}, "extra": []interface{}{1},
+		}, map[string]interface{}{
+			"query": "q", "limit": float64(5), "ratio": 0.5, "dry_run": true,
+			"headers": map[string]interface{}{"a": "b"}, "columns": []interface{}{"id"}, "extra": []interface{}{1},
+		}, ""},
+		{"null uses the default", map[string]interface{}{"query": "q", "limit": nil},
+			map[string]interface{}{"query": "q", "limit": 10, "dry_run": false}, ""},
+		{"missing required", map[string]interface{}{"limit": float64(5)}, nil, "query"},
+		{"null required", map[string]interface{}{"query": nil}, nil, "query"},
+		{"unknown parameter", map[string]interface{}{"query": "q", "drop": true}, nil, "drop"},
+		{"string for integer", map[string]interface{}{"query": "q", "limit": "5"}, nil, "limit"},
+		{"fraction for integer", map[string]interface{}{"query": "q", "limit": 2.5}, nil, "limit"},
+		{"string for number", map[string]interface{}{"query": "q", "ratio": "0.5"}, nil, "ratio"},
+		{"number for string", map[string]interface{}{"query": float64(1)}, nil, "query"},
+		{"string for boolean", map[string]interface{}{"query": "q", "dry_run": "true"}, nil, "dry_run"},
+		{"array for object", map[string]interface{}{"query": "q", "headers": []interface{}{}}, nil, "headers"},
+		{"object for array", map[string]interface{}{"query": "q", "columns": map[string]interface{}{}}, nil, "columns"},
+		{"allowed value", map[string]interface{}{"query": "q", "format": "csv"},
+			map[string]interface{}{"query": "q", "format": "csv", "limit": 10, "dry_run": false}, ""},
+		{"value outside the enum", map[string]interface{}{"query": "q", "format": "xml"}, nil, "format"},
+	}
+	for _, tt := range tests {
+		got, err := ValidateParameters(tt.params, schema)
+		if tt.parameter == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+			}
+			continue
+		}
+		var paramErr *ParameterError
+		if !errors.As(err, &paramErr) || paramErr.Parameter != tt.parameter || !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("%s: expected an error for %s, got %v", tt.name, tt.parameter, err)
+		}
+	}
+
+	// The caller's map is left untouched
+	params := map[string]interface{}{"query": "q"}
+	if _, err := ValidateParameters(params, schema); err != nil || len(params) != 1 {
+		t.Errorf("Expected the parameters to be copied, got %v, %v", params, err)
+	}
+}
+
+func TestToolParameterSchema(t *testing.T) {
+	schema := ToolParameterSchema(NewDatabaseQueryTool(nil, nil))
+	if schema["type"] != "object" {
+		t.Errorf("Expected an object schema, got %v", schema["type"])
+	}
+	if required := schema["required"].([]string); !reflect.DeepEqual(required, []string{"query"}) {
+		t.Errorf("Expected query to be required, got %v", required)
+	}
+	properties := schema["properties"].(map[string]interface{})
+	if len(properties) != len(NewDatabaseQueryTool(nil, nil).Parameters()) {
+		t.Errorf("Expected every parameter to be described, got %v", properties)
+	}
+	transactional := properties["transactional"].(map[string]interface{})
+	if transactional["type"] != "boolean" || transactional["default"] != false || transactional["description"] == "" {
+		t.Errorf("Expected transactional to keep its type, default and description, got %v", transactional)
+	}
+	if timeout := properties["timeout_seconds"].(map[string]interface{}); timeout["type"] != "number" || timeout["default"] != 30 {
+		t.Errorf("Expected timeout_seconds to be a number defaulting to 30, got %v", timeout)
+	}
+	if _, ok := properties["query"].(map[string]interface{})["default"]; ok {
+		t.Errorf("Expected no default for query, got %v", properties["query"])
+	}
+
+	schema = ToolParameterSchema(NewChartTool(nil))
+	if required := schema["required"].([]string); !reflect.DeepEqual(required, []string{"chart_type", "data", "x"}) {
+		t.Errorf("Expected chart_type, data and x to be required, got %v", required)
+	}
+	properties = schema["properties"].(map[string]interface{})
+	if enum := properties["chart_type"].(map[string]interface{})["enum"]; !reflect.DeepEqual(enum, []string{"bar", "line", "pie", "scatter"}) {
+		t.Errorf("Expected the chart types as chart_type's enum, got %v", enum)
+	}
+	if enum := properties["aggregation"].(map[string]interface{})["enum"]; !reflect.DeepEqual(enum, []string{"sum", "avg", "count", "min", "max"}) {
+		t.Errorf("Expected the aggregations as aggregation's enum, got %v", enum)
+	}
+	if _, ok := properties["title"].(map[string]interface{})["enum"]; ok {
+		t.Errorf("Expected no enum for title, got %v", properties["title"])
+	}
+
+	// No required parameters is an empty list, not null
+	schema = ToolParameterSchema(&SystemInfoTool{})
+	if required, ok := schema["required"].([]string); !ok || required == nil || len(required) != 0 {
+		t.Errorf("Expected an empty required list, got %#v", schema["required"])
+	}
+	if memory := schema["properties"].(map[string]interface{})["include_memory"].(map[string]interface{}); memory["type"] != "boolean" || memory["default"] != true {
+		t.Errorf("Expected include_memory to be a boolean defaulting to true, got %v", memory)
+	}
+
+	schema = ToolParameterSchema(&APITool{})
+	method := schema["properties"].(map[string]interface{})["method"].(map[string]interface{})
+	if !reflect.DeepEqual(method["enum"], []string{"GET", "POST", "PUT", "DELETE", "PATCH"}) {
+		t.Errorf("Expected the HTTP methods as method's enum, got %v", method["enum"])
+	}
+}
+
+func TestDigestRows(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": 1.0, "name": "alpha", "amount": 10.0, "note": nil, "mixed": 1.0},
+		{"id": 2.0, "name": "beta", "amount": nil, "note": nil, "mixed": "two"},
+		{"id": 3.0, "name": nil, "amount": 30.0, "note": nil, "mixed": true},
+		{"id": int64(4), "name": "delta", "amount": -5.0, "note": nil, "extra": "x"},
+	}
+	digest := DigestRows([]string{"id", "name", "amount", "note", "mixed"}, rows, 2)
+
+	if digest.RowCount != 4 || len(digest.SampleRows) != 2 || digest.SampleRows[1]["name"] != "beta" {
+		t.Fatalf("Expected 4 rows with the first 2 kept, got %+v", digest)
+	}
+	if !strings.Contains(digest.Note, "first 2 of 4 rows") || !strings.Contains(digest.Note, "narrower query") {
+		t.Errorf("Expected the note to suggest a narrower query, got %q", digest.Note)
+	}
+
+	columns := make(map[string]ColumnDigest)
+	var names []string
+	for _, column := range digest.Columns {
+		columns[column.Name] = column
+		names = append(names, column.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"id", "name", "amount", "note", "mixed", "extra"}) {
+		t.Errorf("Expected the listed columns then the unlisted ones, got %v", names)
+	}
+
+	// Numeric statistics skip NULLs
+	amount := columns["amount"]
+	if amount.Type != ColumnTypeNumber || amount.Nulls != 1 || *amount.Min != -5 || *amount.Max != 30 || *amount.Mean != 35.0/3 {
+		t.Errorf("Unexpected amount digest %+v", amount)
+	}
+	if id := columns["id"]; id.Type != ColumnTypeNumber || *id.Min != 1 || *id.Max != 4 || *id.Mean != 2.5 {
+		t.Errorf("Expected integers and floats counted together, got %+v", id)
+	}
+	if name := columns["name"]; name.Type != ColumnTypeText || name.Nulls != 1 || name.Min != nil || name.Mean != nil {
+		t.Errorf("Expected a text column without statistics, got %+v", name)
+	}
+	if note := columns["note"]; note.Type != ColumnTypeNull || note.Nulls != 4 {
+		t.Errorf("Expected an all-NULL column, got %+v", note)
+	}
+	if mixed := columns["mixed"]; mixed.Type != ColumnTypeMixed || mixed.Nulls != 1 || mixed.Mean != nil {
+		t.Errorf("Expected a mixed column without statistics, got %+v", mixed)
+	}
+
+	// Everything fits in the sample
+	small := DigestRows([]string{"id"}, rows[:1], 5)
+	if small.RowCount != 1 || len(small.SampleRows) != 1 || strings.Contains(small.Note, "first") {
+		t.Errorf("Expected the single row kept, got %+v", small)
+	}
+	if empty := DigestRows([]string{"id"}, nil, 5); empty.SampleRows == nil || empty.Columns[0].Type != ColumnTypeNull {
+		t.Errorf("Expected an empty digest, got %+v", empty)
+	}
+}
+
+func TestDigestToolResult(t *testing.T) {
+	result := &ToolResult{Status: "completed", Data: map[string]interface{}{
+		"query": "SELECT id, city FROM orders",
+		"result": map[string]interface{}{
+			"type":    "select",
+			"columns": []string{"id", "city"},
+			"rows":    []map[string]interface{}{{"id": 1, "city": "Jakarta"}, {"id": 2, "city": nil}, {"id": 3, "city": "Bandung"}},
+			"count":   3,
+		},
+	}}
+	raw, _ := json.Marshal(result)
+
+	digested, ok := DigestToolResult(raw, 1)
+	if !ok {
+		t.Fatal("Expected the query result digested")
+	}
+	var decoded struct {
+		Status string `json:"status"`
+		Data   struct {
+			Query  string `json:"query"`
+			Result struct {
+				Type   string        `json:"type"`
+				Count  int           `json:"count"`
+				Rows   []interface{} `json:"rows"`
+				Digest ResultDigest  `json:"digest"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(digested, &decoded); err != nil {
+		t.Fatalf("Invalid digested result %s: %v", digested, err)
+	}
+	got := decoded.Data.Result
+	if decoded.Status != "completed" || decoded.Data.Query == "" || got.Type != "select" || got.Count != 3 {
+		t.Errorf("Expected the rest of the result kept, got %s", digested)
+	}
+	if got.Rows != nil || got.Digest.RowCount != 3 || len(got.Digest.SampleRows) != 1 || got.Digest.Columns[1].Nulls != 1 {
+		t.Errorf("Expected the rows replaced by a digest, got %s", digested)
+	}
+
+	// Multi-statement results digest every table
+	statements, _ := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"statements": []interface{}{
+		map[string]interface{}{"result": map[string]interface{}{"columns": []string{"n"}, "rows": []map[string]interface{}{{"n": 1}}}},
+		map[string]interface{}{"result": map[string]interface{}{"rows_affected": 2}},
+	}}})
+	if digested, ok := DigestToolResult(statements, 1); !ok || !strings.Contains(string(digested), `"row_count":1`) {
+		t.Errorf("Expected the statement's table digested, got %s", digested)
+	}
+
+	for _, raw := range []string{`{"status":"completed","data":{"content":"long text"}}`, `not json`, `{"columns":["a"],"rows":[1,2]}`} {
+		if _, ok := DigestToolResult([]byte(raw), 1); ok {
+			t.Errorf("Expected nothing to digest in %s", raw)
+		}
+	}
+}
+
+// recordingAuditor keeps every execution record
+type recordingAuditor struct {
+	mutex   sync.Mutex
+	records []ExecutionRecord
+}
+
+func (a *recordingAuditor) RecordExecution(ctx context.Context, record ExecutionRecord) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.records = append(a.records, record)
+	return nil
+}
+
+func TestRegistryAuditsExecutions(t *testing.T) {
+	tool, _, _ := setupDatabaseQueryTool(t)
+	tool.permissions = staticPermissionChecker{"user-1": RoleEditor}
+	registry := NewDefaultToolRegistry()
+	if err := registry.RegisterTool(tool); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+	auditor := &recordingAuditor{}
+	registry.SetAuditor(auditor)
+
+	// The scheduled prompt's source is kept when the chat service adds its own
+	ctx := WithExecutionSource(context.Background(), "client-1", SourceSchedule)
+	ctx = WithExecutionSource(ctx, "client-1", SourceLLM)
+	ctx = WithToolCall(WithConversation(ctx, "conv-1"), "call-1")
+	_, err := registry.ExecuteTool(ctx, "user-1", "project-1", "database_query",
+		map[string]interface{}{"datasource_id": "Items", "query": "SELECT name FROM items WHERE name = 'secret'"})
+	if err != nil {
+		t.Fatalf("ExecuteTool failed: %v", err)
+	}
+	if _, err := registry.ExecuteTool(context.Background(), "user-2", "project-1", "system_info", map[string]interface{}{}); err != nil {
+		t.Fatalf("ExecuteTool failed: %v", err)
+	}
+
+	if len(auditor.records) != 2 {
+		t.Fatalf("Expected 2 executions recorded, got %+v", auditor.records)
+	}
+	query := auditor.records[0]
+	if query.ClientID != "client-1" || query.Source != SourceSchedule || query.UserID != "user-1" || query.ProjectID != "project-1" {
+		t.Errorf("Expected the caller recorded from the execution context, got %+v", query.ExecutionContext)
+	}
+	if query.ConversationID != "conv-1" || query.ToolCallID != "call-1" || query.Status != "completed" || query.StartedAt.IsZero() {
+		t.Errorf("Expected the conversation, tool call and status recorded, got %+v", query)
+	}
+	if query.DatasourceID != testDatasourceID || query.SQL != "SELECT name FROM items WHERE name = 'secret'" {
+		t.Errorf("Expected the resolved datasource and the query recorded, got %q and %q", query.DatasourceID, query.SQL)
+	}
+	if other := auditor.records[1]; other.ToolName != "system_info" || other.SQL != "" || other.Source != "" {
+		t.Errorf("Expected no SQL or source for a tool run without one, got %+v", other)
+	}
+}
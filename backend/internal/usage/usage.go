@@ -0,0 +1,95 @@
+// Package usage records the tokens clients use through the OpenAI-compatible
+// API, one row per completion in token_usage, and checks them against each
+// client's daily_token_budget. Budgets reset at midnight UTC.
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Entry is the usage of one completion
+type Entry struct {
+	ClientID         string
+	UserID           string
+	APIKeyID         string
+	ProjectID        string // Empty for client-level keys
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	Estimated        bool // The provider reported no usage
+}
+
+// Budget is a client's daily token budget and what is left of it today
+type Budget struct {
+	Limit     int64 // 0 is unlimited
+	Used      int64
+	ResetsAt  time.Time
+	Unlimited bool
+}
+
+// Remaining returns the tokens left today, never below zero
+func (b Budget) Remaining() int64 {
+	return max(b.Limit-b.Used, 0)
+}
+
+// Exhausted reports whether a limited budget has nothing left
+func (b Budget) Exhausted() bool {
+	return !b.Unlimited && b.Used >= b.Limit
+}
+
+// Record stores an entry. The created_at it is given is also the day its
+// tokens count against.
+func Record(ctx context.Context, db tools.DBConnection, entry Entry, createdAt time.Time) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO token_usage (id, client_id, user_id, api_key_id, project_id, model, prompt_tokens, completion_tokens, total_tokens, estimated, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		uuid.New().String(), entry.ClientID, nullIfEmpty(entry.UserID), nullIfEmpty(entry.APIKeyID), nullIfEmpty(entry.ProjectID),
+		entry.Model, entry.PromptTokens, entry.CompletionTokens, entry.TotalTokens, entry.Estimated, createdAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record token usage: %w", err)
+	}
+	return nil
+}
+
+// DailyBudget returns the client's budget for the UTC day of now. Clients
+// without a daily_token_budget are unlimited and their usage is not summed.
+func DailyBudget(ctx context.Context, db tools.DBConnection, clientID string, now time.Time) (Budget, error) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	budget := Budget{ResetsAt: dayStart.AddDate(0, 0, 1)}
+
+	var limit sql.NullInt64
+	err := db.QueryRow(ctx, "SELECT daily_token_budget FROM clients WHERE id = $1", clientID).Scan(&limit)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return budget, fmt.Errorf("failed to load token budget: %w", err)
+	}
+	if !limit.Valid || limit.Int64 <= 0 {
+		budget.Unlimited = true
+		return budget, nil
+	}
+	budget.Limit = limit.Int64
+
+	var used sql.NullInt64
+	if err := db.QueryRow(ctx,
+		"SELECT SUM(total_tokens) FROM token_usage WHERE client_id = $1 AND created_at >= $2",
+		clientID, dayStart).Scan(&used); err != nil {
+		return budget, fmt.Errorf("failed to load token usage: %w", err)
+	}
+	budget.Used = used.Int64
+	return budget, nil
+}
+
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,90 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	testClientID = "11111111-1111-1111-1111-111111111111"
+	testUserID   = "22222222-2222-2222-2222-222222222222"
+)
+
+func setupUsageDB(t *testing.T) *tools.ZlayDBAdapter {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	for _, statement := range []string{
+		"INSERT INTO clients (id, name, slug) VALUES ('" + testClientID + "', 'Acme', 'acme')",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('" + testUserID + "', '" + testClientID + "', 'alice', 'hash')",
+	} {
+		if _, err := conn.Exec(context.Background(), statement); err != nil {
+			t.Fatalf("Failed to seed %q: %v", statement, err)
+		}
+	}
+	return conn
+}
+
+func TestDailyBudgetWithoutLimitIsUnlimited(t *testing.T) {
+	conn := setupUsageDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 5, 6, 15, 0, 0, 0, time.UTC)
+
+	if err := Record(ctx, conn, Entry{ClientID: testClientID, UserID: testUserID, Model: "gpt", TotalTokens: 5000}, now); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	budget, err := DailyBudget(ctx, conn, testClientID, now)
+	if err != nil {
+		t.Fatalf("DailyBudget failed: %v", err)
+	}
+	if !budget.Unlimited || budget.Exhausted() {
+		t.Errorf("Expected an unlimited budget, got %+v", budget)
+	}
+}
+
+func TestDailyBudgetCountsTodaysUsage(t *testing.T) {
+	conn := setupUsageDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 5, 6, 15, 0, 0, 0, time.UTC)
+
+	if _, err := conn.Exec(ctx, "UPDATE clients SET daily_token_budget = 1000 WHERE id = $1", testClientID); err != nil {
+		t.Fatalf("Failed to set budget: %v", err)
+	}
+	for _, entry := range []struct {
+		tokens int
+		at     time.Time
+	}{
+		{400, now.Add(-16 * time.Hour)}, // Yesterday
+		{300, now.Add(-14 * time.Hour)},
+		{450, now.Add(-time.Minute)},
+	} {
+		if err := Record(ctx, conn, Entry{ClientID: testClientID, UserID: testUserID, Model: "gpt", TotalTokens: entry.tokens}, entry.at); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	budget, err := DailyBudget(ctx, conn, testClientID, now)
+	if err != nil {
+		t.Fatalf("DailyBudget failed: %v", err)
+	}
+	if budget.Unlimited || budget.Limit != 1000 || budget.Used != 750 || budget.Remaining() != 250 || budget.Exhausted() {
+		t.Errorf("Expected 750 of 1000 tokens used today, got %+v", budget)
+	}
+	if want := time.Date(2026, 5, 7, 0, 0, 0, 0, time.UTC); !budget.ResetsAt.Equal(want) {
+		t.Errorf("Expected the budget to reset at %s, got %s", want, budget.ResetsAt)
+	}
+
+	if err := Record(ctx, conn, Entry{ClientID: testClientID, Model: "gpt", TotalTokens: 300, Estimated: true}, now); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	budget, _ = DailyBudget(ctx, conn, testClientID, now)
+	if !budget.Exhausted() || budget.Remaining() != 0 {
+		t.Errorf("Expected the budget exhausted, got %+v", budget)
+	}
+}
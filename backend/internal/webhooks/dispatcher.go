@@ -0,0 +1,238 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Dispatcher defaults
+const (
+	DefaultQueueSize   = 1000
+	DefaultWorkers     = 4
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Event is something that happened in a client's tenant
+type Event struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	ClientID   string                 `json:"client_id"`
+	ProjectID  string                 `json:"project_id,omitempty"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// NewEvent creates an event with a fresh ID
+func NewEvent(eventType, clientID, projectID string, data map[string]interface{}) Event {
+	return Event{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		ClientID:   clientID,
+		ProjectID:  projectID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// Publisher accepts events for delivery. Publish must never block.
+type Publisher interface {
+	Publish(event Event) bool
+}
+
+// Options configures a Dispatcher; zero values use the defaults
+type Options struct {
+	QueueSize   int
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration // Wait before the second attempt; doubles for each further one
+	Timeout     time.Duration // Per-request timeout
+
+	// OnExhausted, when set, is called with the last attempt of a delivery
+	// whose retryable failures used up MaxAttempts
+	OnExhausted func(webhook *Webhook, event Event, last *Delivery)
+}
+
+// Dispatcher delivers events to webhooks from a bounded in-memory queue.
+// When the queue is full new events are dropped rather than blocking the publisher.
+type Dispatcher struct {
+	db      tools.DBConnection
+	client  *http.Client
+	options Options
+
+	queue chan Event
+	stop  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher; call Start to begin delivering
+func NewDispatcher(db tools.DBConnection, options Options) *Dispatcher {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultBackoff
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Dispatcher{
+		db:      db,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+		queue:   make(chan Event, options.QueueSize),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start launches the delivery workers
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.options.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+}
+
+// Stop stops the workers after their current delivery; queued events are discarded
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.stop) })
+	d.wg.Wait()
+}
+
+// Publish queues an event and returns false if it was dropped because the queue is full
+func (d *Dispatcher) Publish(event Event) bool {
+	if event.ClientID == "" {
+		return false
+	}
+	select {
+	case d.queue <- event:
+		return true
+	default:
+		log.Printf("Webhook queue full, dropping %s event %s", event.Type, event.ID)
+		return false
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case event := <-d.queue:
+			d.dispatch(event)
+		}
+	}
+}
+
+// dispatch delivers one event to every subscribed webhook of its client
+func (d *Dispatcher) dispatch(event Event) {
+	ctx := context.Background()
+	webhooks, err := activeWebhooks(ctx, d.db, event.ClientID)
+	if err != nil {
+		log.Printf("Failed to load webhooks for client %s: %v", event.ClientID, err)
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event %s: %v", event.Type, event.ID, err)
+		return
+	}
+	for i := range webhooks {
+		if webhooks[i].Subscribes(event.Type) {
+			d.deliver(&webhooks[i], event, payload)
+		}
+	}
+}
+
+// deliver POSTs the payload until it succeeds, fails permanently or runs out of attempts
+func (d *Dispatcher) deliver(webhook *Webhook, event Event, payload []byte) {
+	backoff := d.options.Backoff
+	for attempt := 1; attempt <= d.options.MaxAttempts; attempt++ {
+		delivery := d.attempt(webhook, event, payload, attempt)
+		if err := recordDelivery(context.Background(), d.db, delivery); err != nil {
+			log.Printf("Failed to record webhook delivery for %s: %v", webhook.ID, err)
+		}
+		if delivery.Success || !retryable(delivery.StatusCode) {
+			return
+		}
+		if attempt == d.options.MaxAttempts {
+			if d.options.OnExhausted != nil {
+				d.options.OnExhausted(webhook, event, delivery)
+			}
+			return
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) attempt(webhook *Webhook, event Event, payload []byte, attempt int) *Delivery {
+	delivery := &Delivery{
+		ID:        uuid.New().String(),
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Attempt:   attempt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Zlay-Webhooks/1.0")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, payload))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return delivery
+}
+
+// retryable reports whether a failed attempt is worth repeating. Network errors
+// (no status), 408, 429 and 5xx are retried; other 4xx responses are final.
+func retryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
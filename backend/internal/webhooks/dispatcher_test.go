@@ -0,0 +1,275 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func newTestDB(t *testing.T) tools.DBConnection {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb,
+		"INSERT INTO clients (id, name, slug) VALUES ('client-1', 'One', 'one')",
+		"INSERT INTO clients (id, name, slug) VALUES ('client-2', 'Two', 'two')")
+	return &tools.ZlayDBAdapter{DB: zdb}
+}
+
+// receiver is an httptest endpoint answering with scripted status codes
+type receiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 16)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mutex.Lock()
+		status := http.StatusOK
+		if len(r.requests) < len(r.statuses) {
+			status = r.statuses[len(r.requests)]
+		}
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.times = append(r.times, time.Now())
+		r.mutex.Unlock()
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d requests, got %d", n, i)
+		}
+	}
+}
+
+func newTestDispatcher(t *testing.T, conn tools.DBConnection) *Dispatcher {
+	d := NewDispatcher(conn, Options{Workers: 1, MaxAttempts: 3, Backoff: 10 * time.Millisecond})
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d
+}
+
+// waitForDeliveries polls until n attempts are recorded; recording follows the HTTP response
+func waitForDeliveries(t *testing.T, conn tools.DBConnection, webhookID string, n int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		deliveries, err := ListDeliveries(context.Background(), conn, webhookID, 10)
+		if err != nil {
+			t.Fatalf("ListDeliveries failed: %v", err)
+		}
+		if len(deliveries) >= n || time.Now().After(deadline) {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryIsSigned(t *testing.T) {
+	conn := newTestDB(t)
+	rcv := newReceiver(t)
+	webhook := &Webhook{ClientID: "client-1", URL: rcv.server.URL, EventTypes: []string{EventConversationCompleted}}
+	if err := CreateWebhook(context.Background(), conn, webhook); err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+
+	d := newTestDispatcher(t, conn)
+	event := NewEvent(EventConversationCompleted, "client-1", "project-1", map[string]interface{}{"conversation_id": "conv-1"})
+	if !d.Publish(event) {
+		t.Fatal("Expected the event to be queued")
+	}
+	rcv.wait(t, 1)
+
+	req, body := rcv.requests[0], rcv.bodies[0]
+	if !VerifySignature(webhook.Secret, body, req.Header.Get(SignatureHeader)) {
+		t.Errorf("Signature %q does not match the body", req.Header.Get(SignatureHeader))
+	}
+	if VerifySignature("other-secret", body, req.Header.Get(SignatureHeader)) {
+		t.Error("Expected the signature to depend on the secret")
+	}
+	if req.Header.Get(EventHeader) != EventConversationCompleted || req.Header.Get(DeliveryHeader) != event.ID {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+
+	var payload Event
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if payload.ID != event.ID || payload.ProjectID != "project-1" || payload.Data["conversation_id"] != "conv-1" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+
+	deliveries := waitForDeliveries(t, conn, webhook.ID, 1)
+	if len(deliveries) != 1 || !deliveries[0].Success || deliveries[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected one successful delivery, got %+v", deliveries)
+	}
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	conn := newTestDB(t)
+	rcv := newReceiver(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	webhook := &Webhook{ClientID: "client-1", URL: rcv.server.URL}
+	if err := CreateWebhook(context.Background(), conn, webhook); err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+
+	d := newTestDispatcher(t, conn)
+	d.Publish(NewEvent(EventToolExecutionFailed, "client-1", "", map[string]interface{}{}))
+	rcv.wait(t, 3)
+
+	deliveries := waitForDeliveries(t, conn, webhook.ID, 3)
+	if len(deliveries) != 3 {
+		t.Fatalf("Expected 3 recorded attempts, got %d", len(deliveries))
+	}
+	// Newest first
+	if !deliveries[0].Success || deliveries[0].Attempt != 3 || deliveries[2].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected attempts: %+v", deliveries)
+	}
+	// The wait doubles after each failed attempt
+	if first, second := rcv.times[1].Sub(rcv.times[0]), rcv.times[2].Sub(rcv.times[1]); first < 10*time.Millisecond || second < 20*time.Millisecond {
+		t.Errorf("Expected backoff of at least 10ms then 20ms, got %v then %v", first, second)
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	conn := newTestDB(t)
+	failing := newReceiver(t, 500, 500, 500, 500)
+	rejecting := newReceiver(t, http.StatusGone)
+	for _, url := range []string{failing.server.URL, rejecting.server.URL} {
+		if err := CreateWebhook(context.Background(), conn, &Webhook{ClientID: "client-1", URL: url}); err != nil {
+			t.Fatalf("CreateWebhook failed: %v", err)
+		}
+	}
+
+	exhausted := make(chan string, 2)
+	d := NewDispatcher(conn, Options{Workers: 1, MaxAttempts: 3, Backoff: 10 * time.Millisecond,
+		OnExhausted: func(webhook *Webhook, event Event, last *Delivery) {
+			exhausted <- fmt.Sprintf("%s %d %d", webhook.URL, last.Attempt, last.StatusCode)
+		}})
+	d.Start()
+	t.Cleanup(d.Stop)
+	d.Publish(NewEvent(EventTokenBudgetExceeded, "client-1", "", map[string]interface{}{}))
+
+	// Server errors are retried up to MaxAttempts; other 4xx responses are final
+	failing.wait(t, 3)
+	rejecting.wait(t, 1)
+	time.Sleep(100 * time.Millisecond)
+	if len(failing.requests) != 3 || len(rejecting.requests) != 1 {
+		t.Errorf("Expected 3 and 1 attempts, got %d and %d", len(failing.requests), len(rejecting.requests))
+	}
+
+	// Only the endpoint that used up its retries is reported
+	var reported []string
+	for len(exhausted) > 0 {
+		reported = append(reported, <-exhausted)
+	}
+	if len(reported) != 1 || reported[0] != failing.server.URL+" 3 500" {
+		t.Errorf("Expected the failing webhook reported after 3 attempts, got %v", reported)
+	}
+}
+
+func TestEventsAreFiltered(t *testing.T) {
+	conn := newTestDB(t)
+	rcv := newReceiver(t)
+	ctx := context.Background()
+	subscribed := &Webhook{ClientID: "client-1", URL: rcv.server.URL, EventTypes: []string{EventConversationCreated}}
+	otherType := &Webhook{ClientID: "client-1", URL: rcv.server.URL, EventTypes: []string{EventTokenBudgetExceeded}}
+	otherClient := &Webhook{ClientID: "client-2", URL: rcv.server.URL}
+	inactive := &Webhook{ClientID: "client-1", URL: rcv.server.URL}
+	for _, w := range []*Webhook{subscribed, otherType, otherClient, inactive} {
+		if err := CreateWebhook(ctx, conn, w); err != nil {
+			t.Fatalf("CreateWebhook failed: %v", err)
+		}
+	}
+	disabled := false
+	if _, err := UpdateWebhook(ctx, conn, inactive.ID, WebhookUpdate{IsActive: &disabled}); err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+
+	d := newTestDispatcher(t, conn)
+	d.Publish(NewEvent(EventConversationCreated, "client-1", "", map[string]interface{}{}))
+	rcv.wait(t, 1)
+	time.Sleep(100 * time.Millisecond)
+	if len(rcv.requests) != 1 {
+		t.Errorf("Expected only the subscribed webhook to be called, got %d requests", len(rcv.requests))
+	}
+}
+
+func TestPublishDropsWhenQueueIsFull(t *testing.T) {
+	// Not started, so nothing drains the queue
+	d := NewDispatcher(newTestDB(t), Options{QueueSize: 2})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			d.Publish(NewEvent(EventConversationCreated, "client-1", "", nil))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full queue")
+	}
+	if d.Publish(NewEvent(EventConversationCreated, "client-1", "", nil)) {
+		t.Error("Expected the event to be dropped")
+	}
+}
+
+func TestWebhookValidation(t *testing.T) {
+	conn := newTestDB(t)
+	ctx := context.Background()
+	for name, w := range map[string]*Webhook{
+		"missing client":     {URL: "https://example.com/hook"},
+		"relative url":       {ClientID: "client-1", URL: "/hook"},
+		"non-http url":       {ClientID: "client-1", URL: "ftp://example.com/hook"},
+		"unknown event type": {ClientID: "client-1", URL: "https://example.com/hook", EventTypes: []string{"message_sent"}},
+	} {
+		if err := CreateWebhook(ctx, conn, w); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	webhook := &Webhook{ClientID: "client-1", URL: "https://example.com/hook"}
+	if err := CreateWebhook(ctx, conn, webhook); err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+	if webhook.Secret == "" {
+		t.Error("Expected a generated secret")
+	}
+	listed, err := ListWebhooks(ctx, conn, "client-1")
+	if err != nil || len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("Expected one webhook without its secret, got %+v (%v)", listed, err)
+	}
+	if err := DeleteWebhook(ctx, conn, webhook.ID); err != nil {
+		t.Errorf("DeleteWebhook failed: %v", err)
+	}
+	if _, err := GetWebhook(ctx, conn, webhook.ID); err != ErrWebhookNotFound {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+// Package webhooks delivers conversation lifecycle events to tenant-configured
+// HTTP endpoints. Events are queued without blocking the publisher and POSTed
+// with an HMAC-SHA256 signature; every attempt is recorded in webhook_deliveries.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+// Event types a webhook can subscribe to
+const (
+	EventConversationCreated      = "conversation_created"
+	EventConversationCompleted    = "conversation_completed"
+	EventToolExecutionFailed      = "tool_execution_failed"
+	EventTokenBudgetExceeded      = "token_budget_exceeded"
+	EventDatasourceSchemaChanged  = "datasource_schema_changed"
+	EventScheduledPromptCompleted = "scheduled_prompt_completed"
+)
+
+// EventTypes lists every event type that can be delivered
+var EventTypes = []string{
+	EventConversationCreated,
+	EventConversationCompleted,
+	EventToolExecutionFailed,
+	EventTokenBudgetExceeded,
+	EventDatasourceSchemaChanged,
+	EventScheduledPromptCompleted,
+}
+
+// Request headers sent with every delivery
+const (
+	SignatureHeader = "X-Zlay-Signature"
+	EventHeader     = "X-Zlay-Event"
+	DeliveryHeader  = "X-Zlay-Delivery"
+)
+
+var (
+	// ErrWebhookNotFound is returned when a webhook does not exist
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrInvalidWebhook is returned for a missing or non-HTTP URL or an unknown event type
+	ErrInvalidWebhook = errors.New("invalid webhook")
+)
+
+// Webhook is an endpoint that receives a client's events. An empty EventTypes
+// subscribes to every event type.
+type Webhook struct {
+	ID         string    `json:"id"`
+	ClientID   string    `json:"client_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"` // Only returned when the webhook is created
+	EventTypes []string  `json:"event_types"`
+	IsActive   bool      `json:"is_active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type
+func (w *Webhook) Subscribes(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookUpdate holds the fields to change; nil fields are left alone
+type WebhookUpdate struct {
+	URL        *string   `json:"url"`
+	Secret     *string   `json:"secret"`
+	EventTypes *[]string `json:"event_types"`
+	IsActive   *bool     `json:"is_active"`
+}
+
+// Delivery is one attempt to deliver an event to a webhook
+type Delivery struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Sign returns the signature header value for a payload: sha256=<hex HMAC-SHA256>
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature header value in constant time
+func VerifySignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
+
+// CreateWebhook validates and stores a webhook. A secret is generated when none is given.
+func CreateWebhook(ctx context.Context, db tools.DBConnection, webhook *Webhook) error {
+	if webhook.ClientID == "" {
+		return fmt.Errorf("%w: client_id is required", ErrInvalidWebhook)
+	}
+	if err := validateURL(webhook.URL); err != nil {
+		return err
+	}
+	if err := validateEventTypes(webhook.EventTypes); err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return err
+		}
+		webhook.Secret = secret
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	eventTypes, err := json.Marshal(webhook.EventTypes)
+	if err != nil {
+		return fmt.Errorf("failed to encode event types: %w", err)
+	}
+
+	webhook.ID = uuid.New().String()
+	webhook.IsActive = true
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+	_, err = db.Exec(ctx,
+		`INSERT INTO webhooks (id, client_id, url, secret, event_types, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, true, $6, $6)`,
+		webhook.ID, webhook.ClientID, webhook.URL, webhook.Secret, string(eventTypes), webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns webhooks, newest first, optionally for one client. Secrets are not included.
+func ListWebhooks(ctx context.Context, db tools.DBConnection, clientID string) ([]Webhook, error) {
+	query := `SELECT id, client_id, url, '', event_types, is_active, created_at, updated_at FROM webhooks`
+	var args []interface{}
+	if clientID != "" {
+		query += " WHERE client_id = $1"
+		args = append(args, clientID)
+	}
+	query += " ORDER BY created_at DESC"
+	return queryWebhooks(ctx, db, query, args...)
+}
+
+// GetWebhook returns one webhook without its secret
+func GetWebhook(ctx context.Context, db tools.DBConnection, id string) (*Webhook, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	webhooks, err := queryWebhooks(ctx, db,
+		`SELECT id, client_id, url, '', event_types, is_active, created_at, updated_at FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return &webhooks[0], nil
+}
+
+// activeWebhooks returns a client's active webhooks including secrets, for delivery
+func activeWebhooks(ctx context.Context, db tools.DBConnection, clientID string) ([]Webhook, error) {
+	return queryWebhooks(ctx, db,
+		`SELECT id, client_id, url, secret, event_types, is_active, created_at, updated_at
+		FROM webhooks WHERE client_id = $1 AND is_active = true`, clientID)
+}
+
+func queryWebhooks(ctx context.Context, db tools.DBConnection, query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var eventTypes []byte
+		if err := rows.Scan(&w.ID, &w.ClientID, &w.URL, &w.Secret, &eventTypes, &w.IsActive, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		if len(eventTypes) > 0 {
+			if err := json.Unmarshal(eventTypes, &w.EventTypes); err != nil {
+				return nil, fmt.Errorf("invalid event types for webhook %s: %w", w.ID, err)
+			}
+		}
+		if w.EventTypes == nil {
+			w.EventTypes = []string{}
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook applies an update and returns the webhook without its secret
+func UpdateWebhook(ctx context.Context, db tools.DBConnection, id string, update WebhookUpdate) (*Webhook, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	query := "UPDATE webhooks SET updated_at = $1"
+	args := []interface{}{time.Now().UTC()}
+	if update.URL != nil {
+		if err := validateURL(*update.URL); err != nil {
+			return nil, err
+		}
+		args = append(args, *update.URL)
+		query += fmt.Sprintf(", url = $%d", len(args))
+	}
+	if update.Secret != nil {
+		if *update.Secret == "" {
+			return nil, fmt.Errorf("%w: secret cannot be empty", ErrInvalidWebhook)
+		}
+		args = append(args, *update.Secret)
+		query += fmt.Sprintf(", secret = $%d", len(args))
+	}
+	if update.EventTypes != nil {
+		if err := validateEventTypes(*update.EventTypes); err != nil {
+			return nil, err
+		}
+		eventTypes := *update.EventTypes
+		if eventTypes == nil {
+			eventTypes = []string{}
+		}
+		encoded, err := json.Marshal(eventTypes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event types: %w", err)
+		}
+		args = append(args, string(encoded))
+		query += fmt.Sprintf(", event_types = $%d", len(args))
+	}
+	if update.IsActive != nil {
+		args = append(args, *update.IsActive)
+		query += fmt.Sprintf(", is_active = $%d", len(args))
+	}
+	args = append(args, id)
+	query += fmt.Sprintf(" WHERE id = $%d", len(args))
+
+	result, err := db.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, ErrWebhookNotFound
+	}
+	return GetWebhook(ctx, db, id)
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(ctx context.Context, db tools.DBConnection, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrWebhookNotFound
+	}
+	if _, err := db.Exec(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	result, err := db.Exec(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent delivery attempts of a webhook, newest first
+func ListDeliveries(ctx context.Context, db tools.DBConnection, webhookID string, limit int) ([]Delivery, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, webhook_id, event_id, event_type, attempt, status_code, success, error, duration_ms, created_at
+		FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, attempt DESC LIMIT $2`,
+		webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var statusCode sql.NullInt64
+		var deliveryErr sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Attempt, &statusCode,
+			&d.Success, &deliveryErr, &d.DurationMs, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		d.StatusCode = int(statusCode.Int64)
+		d.Error = deliveryErr.String
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// recordDelivery stores one delivery attempt
+func recordDelivery(ctx context.Context, db tools.DBConnection, d *Delivery) error {
+	var statusCode interface{}
+	if d.StatusCode != 0 {
+		statusCode = d.StatusCode
+	}
+	var deliveryErr interface{}
+	if d.Error != "" {
+		deliveryErr = d.Error
+	}
+	_, err := db.Exec(ctx,
+		`INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, attempt, status_code, success, error, duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		d.ID, d.WebhookID, d.EventID, d.EventType, d.Attempt, statusCode, d.Success, deliveryErr, d.DurationMs, d.CreatedAt)
+	return err
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	return nil
+}
+
+func validateEventTypes(eventTypes []string) error {
+	for _, t := range eventTypes {
+		known := false
+		for _, k := range EventTypes {
+			if t == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,216 @@
+package websocket
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/metrics"
+)
+
+const (
+	// DefaultAckTimeout is how long a critical frame waits for its ack before it is sent again
+	DefaultAckTimeout = 5 * time.Second
+	// DefaultAckMaxAttempts is how many times an unacknowledged frame is sent again before delivery fails
+	DefaultAckMaxAttempts = 3
+	// maxPendingAcks bounds the unacknowledged frames kept per connection; the oldest gives way
+	maxPendingAcks = 64
+
+	// MetricDeliveryFailed names the counter of critical frames never acknowledged, per client
+	MetricDeliveryFailed = "ws_delivery_failed"
+)
+
+// criticalMessageTypes are the frames a client cannot recover from missing.
+// Connections that negotiate messages.CapabilityAcks get them with a
+// message_id and must answer with an ack; high-volume frames such as
+// assistant_response deltas are never tracked.
+var criticalMessageTypes = map[string]bool{
+	"conversation_created":     true,
+	"tool_execution_completed": true,
+	"tool_execution_failed":    true,
+}
+
+// pendingFrame is a critical frame sent to a connection and not acknowledged yet
+type pendingFrame struct {
+	messageID   string
+	messageType string
+	payload     []byte
+	attempts    int       // Transmissions so far; 0 while the send buffer was full
+	sentAt      time.Time // Of the latest transmission
+}
+
+// pendingAcks holds a connection's unacknowledged critical frames, oldest
+// first. The zero value is ready to use.
+type pendingAcks struct {
+	mutex  sync.Mutex
+	frames []*pendingFrame
+	nextID uint64
+}
+
+// count returns how many frames are waiting for an ack
+func (p *pendingAcks) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.frames)
+}
+
+// acknowledge drops the frame with messageID and reports whether it was pending.
+// Acks of frames already acknowledged, as after a retransmission, are ignored.
+func (p *pendingAcks) acknowledge(messageID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, frame := range p.frames {
+		if frame.messageID == messageID {
+			p.frames = append(p.frames[:i], p.frames[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// clear drops every pending frame and returns how many there were
+func (p *pendingAcks) clear() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	dropped := len(p.frames)
+	p.frames = nil
+	return dropped
+}
+
+// SetAckPolicy sets how long critical frames wait for an ack and how many
+// times they are sent again before delivery fails. Call it before Run.
+func (h *Hub) SetAckPolicy(timeout time.Duration, maxAttempts int) {
+	h.ackTimeout = timeout
+	h.ackMaxAttempts = maxAttempts
+}
+
+// frameType returns the type of an outbound message, looking through a
+// request stamp, or "" for messages of other shapes
+func frameType(message interface{}) string {
+	if stamped, ok := message.(messages.RequestMessage); ok {
+		message = stamped.Message
+	}
+	switch m := message.(type) {
+	case messages.WebSocketMessage:
+		return m.Type
+	case *messages.WebSocketMessage:
+		return m.Type
+	case chat.WebSocketMessage:
+		return m.Type
+	case *chat.WebSocketMessage:
+		return m.Type
+	}
+	return ""
+}
+
+// tracksAcks reports whether a frame of messageType sent to conn waits for an ack
+func tracksAcks(conn *Connection, messageType string) bool {
+	return criticalMessageTypes[messageType] && conn.HasCapability(messages.CapabilityAcks)
+}
+
+// sendTracked stamps a critical frame with the connection's next message_id,
+// keeps it until acknowledged and queues it. A full send buffer leaves the
+// frame for the next retransmission instead of dropping the connection.
+func (h *Hub) sendTracked(conn *Connection, messageType string, payload []byte) {
+	pending := &conn.acks
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	pending.nextID++
+	frame := &pendingFrame{
+		messageID:   strconv.FormatUint(pending.nextID, 10),
+		messageType: messageType,
+	}
+	frame.payload = messages.AddEnvelopeField(payload, "message_id", frame.messageID)
+	if len(pending.frames) >= maxPendingAcks {
+		h.deliveryFailed(conn, pending.frames[0], "pending buffer full")
+		pending.frames = pending.frames[1:]
+	}
+	pending.frames = append(pending.frames, frame)
+
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		return
+	}
+	// Frames waiting for a retransmission go first, so this one waits too
+	if len(pending.frames) > 1 && pending.frames[len(pending.frames)-2].attempts == 0 {
+		return
+	}
+	if conn.trySend(frame.payload) {
+		frame.attempts = 1
+		frame.sentAt = time.Now()
+	} else {
+		log.Printf("Send buffer of connection %s is full, %s frame %s will be retransmitted", conn.ID, messageType, frame.messageID)
+	}
+}
+
+// retransmitPending sends again the critical frames whose ack is overdue, on
+// every connection
+func (h *Hub) retransmitPending(now time.Time) {
+	h.mutex.RLock()
+	conns := make([]*Connection, 0, len(h.connections))
+	for conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	h.mutex.RUnlock()
+
+	for _, conn := range conns {
+		h.retransmit(conn, now)
+	}
+}
+
+// retransmit sends a connection's overdue frames again in their original
+// order, and gives up on frames sent ackMaxAttempts times more without an ack
+func (h *Hub) retransmit(conn *Connection, now time.Time) {
+	pending := &conn.acks
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+
+	if len(pending.frames) == 0 || atomic.LoadInt32(&conn.closed) == 1 {
+		return
+	}
+	full := false
+	kept := pending.frames[:0]
+	for _, frame := range pending.frames {
+		if full || (frame.attempts > 0 && now.Sub(frame.sentAt) < h.ackTimeout) {
+			kept = append(kept, frame)
+			continue
+		}
+		if frame.attempts > h.ackMaxAttempts {
+			h.deliveryFailed(conn, frame, "unacknowledged")
+			continue
+		}
+		if conn.trySend(frame.payload) {
+			frame.attempts++
+			frame.sentAt = now
+		} else {
+			// Later frames wait so the client still gets them in order
+			full = true
+		}
+		kept = append(kept, frame)
+	}
+	for i := len(kept); i < len(pending.frames); i++ {
+		pending.frames[i] = nil
+	}
+	pending.frames = kept
+}
+
+// deliveryFailed records a critical frame the client never acknowledged
+func (h *Hub) deliveryFailed(conn *Connection, frame *pendingFrame, reason string) {
+	log.Printf("delivery_failed: %s frame %s to connection %s after %d attempts (%s)",
+		frame.messageType, frame.messageID, conn.ID, frame.attempts, reason)
+	metrics.Counter(MetricDeliveryFailed).Inc(conn.ClientID)
+}
+
+// ackCheckInterval is how often Run looks for overdue acks
+func (h *Hub) ackCheckInterval() time.Duration {
+	if interval := h.ackTimeout / 2; interval > 0 {
+		return interval
+	}
+	return DefaultAckTimeout / 2
+}
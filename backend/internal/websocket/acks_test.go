@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/metrics"
+)
+
+// ackedFrame is a frame with the fields the ack protocol relies on
+type ackedFrame struct {
+	Type      string `json:"type"`
+	FrameID   string `json:"frame_id"`
+	MessageID string `json:"message_id"`
+}
+
+// joinAckRoom runs a hub with the given ack policy and joins a connection
+// that negotiated acks to project-1
+func joinAckRoom(t *testing.T, timeout time.Duration, maxAttempts int) (*Hub, *Connection) {
+	t.Helper()
+
+	hub := NewHub()
+	hub.SetAckPolicy(timeout, maxAttempts)
+	go hub.Run()
+	conn := joinRoom(hub, "user-a", "project-1")
+	conn.setCapabilities([]string{messages.CapabilityAcks})
+	for hub.GetProjectConnectionCount("project-1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return hub, conn
+}
+
+// readFrames reads the next n frames sent to conn, skipping presence updates
+func readFrames(t *testing.T, conn *Connection, n int) []ackedFrame {
+	t.Helper()
+
+	var frames []ackedFrame
+	deadline := time.After(time.Second)
+	for len(frames) < n {
+		select {
+		case data := <-conn.send:
+			var frame ackedFrame
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Fatalf("Invalid frame %s: %v", data, err)
+			}
+			if frame.Type != "presence_update" {
+				frames = append(frames, frame)
+			}
+		case <-deadline:
+			t.Fatalf("Expected %d frames, got %+v", n, frames)
+		}
+	}
+	return frames
+}
+
+// expectNoFrames fails if anything but a presence update reaches conn within wait
+func expectNoFrames(t *testing.T, conn *Connection, wait time.Duration) {
+	t.Helper()
+
+	deadline := time.After(wait)
+	for {
+		select {
+		case data := <-conn.send:
+			if !strings.Contains(string(data), `"presence_update"`) {
+				t.Fatalf("Expected no more frames, got %s", data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func ack(conn *Connection, messageID string) {
+	conn.dispatch([]byte(`{"type":"ack","data":{"message_id":"` + messageID + `"}}`))
+}
+
+func TestCriticalFramesAreRetransmittedUntilAcked(t *testing.T) {
+	hub, conn := joinAckRoom(t, 30*time.Millisecond, 5)
+
+	hub.BroadcastToProject("project-1", WebSocketMessage{Type: "conversation_created"})
+	hub.BroadcastToProject("project-1", WebSocketMessage{Type: "assistant_response"})
+	hub.BroadcastToProject("project-1", chat.WebSocketMessage{Type: "tool_execution_completed"})
+
+	// The first transmission is read and dropped without an ack
+	first := readFrames(t, conn, 3)
+	if first[0].MessageID == "" || first[2].MessageID == "" || first[0].MessageID == first[2].MessageID {
+		t.Fatalf("Expected distinct message IDs on the critical frames, got %+v", first)
+	}
+	if first[1].MessageID != "" {
+		t.Errorf("Expected no message ID on assistant_response, got %+v", first[1])
+	}
+
+	// Both come again in their original order, with the same IDs
+	again := readFrames(t, conn, 2)
+	if again[0] != first[0] || again[1] != first[2] {
+		t.Errorf("Expected %+v and %+v retransmitted, got %+v", first[0], first[2], again)
+	}
+
+	ack(conn, first[0].MessageID)
+	ack(conn, first[2].MessageID)
+	ack(conn, first[2].MessageID) // Duplicate acks are ignored
+	if pending := conn.acks.count(); pending != 0 {
+		t.Fatalf("Expected nothing pending after the acks, got %d", pending)
+	}
+	expectNoFrames(t, conn, 100*time.Millisecond)
+}
+
+func TestFramesToClientsWithoutAcks(t *testing.T) {
+	hub := NewHub()
+	hub.SetAckPolicy(10*time.Millisecond, 3)
+	go hub.Run()
+	conn := joinRoom(hub, "user-a", "project-1")
+	for hub.GetProjectConnectionCount("project-1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_created"})
+	if frame := readFrames(t, conn, 1)[0]; frame.MessageID != "" {
+		t.Errorf("Expected no message ID for a client without acks, got %+v", frame)
+	}
+	expectNoFrames(t, conn, 50*time.Millisecond)
+	if pending := conn.acks.count(); pending != 0 {
+		t.Errorf("Expected nothing tracked, got %d", pending)
+	}
+}
+
+func TestUnacknowledgedFramesFailAfterMaxAttempts(t *testing.T) {
+	hub, conn := joinAckRoom(t, 10*time.Millisecond, 2)
+	conn.ClientID = "client-acks"
+	before := metrics.Counter(MetricDeliveryFailed).Snapshot()["client-acks"].Count
+
+	hub.SendToConnection(conn, WebSocketMessage{Type: "tool_execution_failed"})
+	frames := readFrames(t, conn, 3)
+	if frames[0] != frames[1] || frames[1] != frames[2] {
+		t.Errorf("Expected the same frame three times, got %+v", frames)
+	}
+	expectNoFrames(t, conn, 60*time.Millisecond)
+
+	if pending := conn.acks.count(); pending != 0 {
+		t.Errorf("Expected the frame given up on, got %d pending", pending)
+	}
+	if after := metrics.Counter(MetricDeliveryFailed).Snapshot()["client-acks"].Count; after != before+1 {
+		t.Errorf("Expected one failed delivery counted, got %d", after-before)
+	}
+}
+
+func TestCriticalFramesSurviveAFullSendBuffer(t *testing.T) {
+	hub, conn := joinAckRoom(t, 20*time.Millisecond, 3)
+	for len(conn.send) < cap(conn.send) {
+		conn.send <- []byte(`{"type":"filler"}`)
+	}
+
+	hub.BroadcastToProject("project-1", WebSocketMessage{Type: "conversation_created"})
+	hub.SendToConnection(conn, WebSocketMessage{Type: "tool_execution_completed"})
+	if hub.GetConnectionByID(conn.ID) == nil || hub.GetProjectConnectionCount("project-1") != 1 {
+		t.Fatal("Expected the connection kept while its critical frames wait")
+	}
+
+	for len(conn.send) > 0 {
+		<-conn.send
+	}
+	frames := readFrames(t, conn, 2)
+	if frames[0].Type != "conversation_created" || frames[1].Type != "tool_execution_completed" {
+		t.Errorf("Expected the frames delivered in order once there is room, got %+v", frames)
+	}
+}
+
+func TestPendingAcksAreBoundedAndCleared(t *testing.T) {
+	hub, conn := joinAckRoom(t, time.Minute, 3)
+
+	for i := 0; i < maxPendingAcks+10; i++ {
+		hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_created"})
+	}
+	if pending := conn.acks.count(); pending != maxPendingAcks {
+		t.Fatalf("Expected %d frames pending, got %d", maxPendingAcks, pending)
+	}
+	// The oldest frames gave way
+	conn.acks.mutex.Lock()
+	oldest := conn.acks.frames[0].messageID
+	conn.acks.mutex.Unlock()
+	if oldest != "11" {
+		t.Errorf("Expected the oldest pending frame to be 11, got %s", oldest)
+	}
+
+	hub.unregister <- conn
+	for !conn.isUnregistered() {
+		time.Sleep(time.Millisecond)
+	}
+	if pending := conn.acks.count(); pending != 0 {
+		t.Errorf("Expected the pending frames cleared on unregister, got %d", pending)
+	}
+}
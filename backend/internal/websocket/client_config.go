@@ -2,16 +2,33 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"sync"
 	"time"
 
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
 	"zlay-backend/internal/db"
+	"zlay-backend/internal/embeddings"
 	"zlay-backend/internal/llm"
 )
 
+// ErrLLMNotConfigured is returned for clients with no API key or model, either
+// their own or the configured defaults
+var ErrLLMNotConfigured = errors.New("LLM is not configured for this client")
+
+// LLMConfigErrorCode maps a GetClientConfig error to the error code sent to users
+func LLMConfigErrorCode(err error) string {
+	if errors.Is(err, ErrLLMNotConfigured) {
+		return apierror.CodeLLMNotConfigured
+	}
+	return apierror.CodeLLMConfigUnavailable
+}
+
 // ClientConfig represents LLM configuration for a client
 type ClientConfig struct {
 	ClientID   string
@@ -20,6 +37,16 @@ type ClientConfig struct {
 	Model      string
 	LastUsed   time.Time
 	LLMClient llm.LLMClient
+	MaxConcurrentStreams int // Concurrent LLM streams allowed for this client
+	AllowedModels []string // Models users may pick per conversation besides Model
+	FlushPolicy chat.StreamFlushPolicy // Streaming cadence; zero values use the server's
+	ToolResultTokenLimit int // Tokens a tool result may take in the prompt before it is digested; 0 uses the default
+}
+
+// ValidateModelSettings checks a conversation's overrides against the client's
+// model and allowlist
+func (c *ClientConfig) ValidateModelSettings(settings chat.ModelSettings) error {
+	return settings.Validate(c.Model, c.AllowedModels)
 }
 
 // ClientConfigCache manages cached LLM configurations for clients
@@ -28,31 +55,27 @@ type ClientConfigCache struct {
 	mutex sync.RWMutex
 	db    *db.Database
 	
-	// Default configuration from environment variables
+	// Defaults from the OpenAI settings in config
 	defaultAPIKey  string
 	defaultBaseURL string
 	defaultModel   string
+	// Model used when client LLMs embed text for conversation search
+	embeddingModel string
+	// Whether client LLMs continue a trailing assistant message
+	assistantPrefix bool
 }
 
-// NewClientConfigCache creates a new client configuration cache
-func NewClientConfigCache(zdb *db.Database) *ClientConfigCache {
-	// Get default values from environment variables
-	defaultAPIKey := os.Getenv("OPENAI_API_KEY")
-	if defaultAPIKey == "" {
-		defaultAPIKey = "sk-no-key-required"
-	}
-	defaultBaseURL := os.Getenv("OPENAI_BASE_URL")
-	defaultModel := os.Getenv("OPENAI_MODEL")
-	if defaultModel == "" {
-		defaultModel = "gpt-3.5-turbo"
-	}
-
+// NewClientConfigCache creates a new client configuration cache; clients without
+// their own API settings fall back to the configured OpenAI defaults
+func NewClientConfigCache(zdb *db.Database, cfg *config.Config) *ClientConfigCache {
 	return &ClientConfigCache{
 		cache:         make(map[string]*ClientConfig),
 		db:            zdb,
-		defaultAPIKey:  defaultAPIKey,
-		defaultBaseURL: defaultBaseURL,
-		defaultModel:   defaultModel,
+		defaultAPIKey:  cfg.OpenAIAPIKey,
+		defaultBaseURL: cfg.OpenAIBaseURL,
+		defaultModel:   cfg.OpenAIModel,
+		embeddingModel: cfg.EmbeddingModel,
+		assistantPrefix: cfg.LLMAssistantPrefix,
 	}
 }
 
@@ -87,11 +110,21 @@ func (c *ClientConfigCache) GetClientConfig(ctx context.Context, clientID string
 	return config, nil
 }
 
+// SetClientConfig caches a configuration as if it had been loaded, for callers
+// that build their own LLM client
+func (c *ClientConfigCache) SetClientConfig(config *ClientConfig) {
+	config.LastUsed = time.Now()
+	c.mutex.Lock()
+	c.cache[config.ClientID] = config
+	c.mutex.Unlock()
+}
+
 // fetchClientConfig retrieves client configuration from database
 func (c *ClientConfigCache) fetchClientConfig(ctx context.Context, clientID string) (*ClientConfig, error) {
 	// Query client configuration
 	row, err := c.db.QueryRow(ctx,
-		`SELECT id, ai_api_key, ai_api_url, ai_api_model 
+		`SELECT id, ai_api_key, ai_api_url, ai_api_model, max_concurrent_streams, allowed_models,
+			stream_flush_chars, stream_flush_interval_ms, tool_result_token_limit
 		FROM clients 
 		WHERE id = $1 AND is_active = true`,
 		clientID)
@@ -100,7 +133,7 @@ func (c *ClientConfigCache) fetchClientConfig(ctx context.Context, clientID stri
 		return nil, fmt.Errorf("database query error: %w", err)
 	}
 
-	if len(row.Values) != 4 {
+	if len(row.Values) != 9 {
 		return nil, fmt.Errorf("client not found or inactive: %s", clientID)
 	}
 
@@ -125,8 +158,38 @@ func (c *ClientConfigCache) fetchClientConfig(ctx context.Context, clientID stri
 		model = c.defaultModel
 	}
 
+	maxStreams, ok := row.Values[4].AsInt64()
+	if !ok || maxStreams <= 0 {
+		maxStreams = chat.DefaultMaxConcurrentStreams
+	}
+
+	var allowedModels []string
+	if raw, ok := row.Values[5].AsJSON(); ok {
+		if err := json.Unmarshal(raw, &allowedModels); err != nil {
+			log.Printf("Ignoring invalid allowed_models for client %s: %v", clientID, err)
+			allowedModels = nil
+		}
+	}
+
+	var flushPolicy chat.StreamFlushPolicy
+	if chars, ok := row.Values[6].AsInt64(); ok {
+		flushPolicy.Chars = int(chars)
+	}
+	if intervalMs, ok := row.Values[7].AsInt64(); ok {
+		flushPolicy.Interval = time.Duration(intervalMs) * time.Millisecond
+	}
+	toolResultLimit, _ := row.Values[8].AsInt64()
+
+	// Without a key or model every request would fail at the provider; report it
+	// before a conversation is started instead
+	if apiKey == "" || model == "" {
+		return nil, ErrLLMNotConfigured
+	}
+
 	// Create LLM client with client-specific configuration
 	llmClient := llm.NewOpenAIClient(apiKey, baseURL, model)
+	llmClient.SetEmbeddingModel(c.embeddingModel)
+	llmClient.SetAssistantPrefix(c.assistantPrefix)
 
 	// Validate the connection if possible (with timeout)
 	validateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -144,9 +207,64 @@ func (c *ClientConfigCache) fetchClientConfig(ctx context.Context, clientID stri
 		Model:      model,
 		LastUsed:   time.Now(),
 		LLMClient:  llmClient,
+		MaxConcurrentStreams: int(maxStreams),
+		AllowedModels: allowedModels,
+		FlushPolicy: flushPolicy,
+		ToolResultTokenLimit: int(toolResultLimit),
 	}, nil
 }
 
+// UserEmbedder returns the embedder of the LLM configured for the user's client
+func (c *ClientConfigCache) UserEmbedder(ctx context.Context, userID string) (llm.Embedder, error) {
+	row, err := c.db.QueryRow(ctx, "SELECT client_id FROM users WHERE id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("database query error: %w", err)
+	}
+	if len(row.Values) != 1 {
+		return nil, fmt.Errorf("user not found: %s", userID)
+	}
+	clientID, ok := row.Values[0].AsString()
+	if !ok {
+		return nil, fmt.Errorf("invalid client ID for user %s", userID)
+	}
+
+	config, err := c.GetClientConfig(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := config.LLMClient.(llm.Embedder)
+	if !ok {
+		return nil, embeddings.ErrEmbeddingsUnsupported
+	}
+	return embedder, nil
+}
+
+// ValidateAnyClientConfig checks that at least one active client has a usable LLM
+// configuration. Configs that load are cached, so repeated checks stay cheap.
+func (c *ClientConfigCache) ValidateAnyClientConfig(ctx context.Context) error {
+	resultSet, err := c.db.Query(ctx, "SELECT id FROM clients WHERE is_active = true ORDER BY id LIMIT 5")
+	if err != nil {
+		return fmt.Errorf("database query error: %w", err)
+	}
+	if len(resultSet.Rows) == 0 {
+		return fmt.Errorf("no active clients")
+	}
+
+	var lastErr error
+	for _, row := range resultSet.Rows {
+		clientID, ok := row.Values[0].AsString()
+		if !ok {
+			continue
+		}
+		if _, err := c.GetClientConfig(ctx, clientID); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no client LLM config could be loaded: %v", lastErr)
+}
+
 // InvalidateClientConfig removes a client from cache (useful for configuration updates)
 func (c *ClientConfigCache) InvalidateClientConfig(clientID string) {
 	c.mutex.Lock()
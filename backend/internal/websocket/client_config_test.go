@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/tools"
+)
+
+// unusedLLMClient fails the test if a reply is attempted
+type unusedLLMClient struct{ t *testing.T }
+
+func (c unusedLLMClient) StreamChat(context.Context, *llm.LLMRequest, func(*llm.StreamingChunk) error) error {
+	c.t.Error("Expected no LLM request without a configuration")
+	return errors.New("unexpected LLM request")
+}
+
+func (c unusedLLMClient) Chat(context.Context, *llm.LLMRequest) (*llm.LLMResponse, error) {
+	c.t.Error("Expected no LLM request without a configuration")
+	return nil, errors.New("unexpected LLM request")
+}
+
+func (unusedLLMClient) SetModel(string) error { return nil }
+func (unusedLLMClient) GetModel() string      { return "" }
+
+// newUnconfiguredClientDB seeds client-1 without an API key or model and one
+// finished conversation of user-1
+func newUnconfiguredClientDB(t *testing.T) *db.Database {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+	dbtest.Seed(t, zdb,
+		"UPDATE clients SET ai_api_key = '', ai_api_url = '', ai_api_model = '', max_concurrent_streams = 3, allowed_models = '[]' WHERE id = 'client-1'",
+		"INSERT INTO conversations (id, title, user_id, project_id, status) VALUES ('conv-1', 'Chat', 'user-1', 'project-1', 'completed')")
+	return zdb
+}
+
+func TestClientConfigWithoutKeyOrModelIsNotConfigured(t *testing.T) {
+	zdb := newUnconfiguredClientDB(t)
+	cfg := testServerConfig(t)
+	cfg.OpenAIAPIKey = ""
+	cache := NewClientConfigCache(zdb, cfg)
+
+	_, err := cache.GetClientConfig(context.Background(), "client-1")
+	if !errors.Is(err, ErrLLMNotConfigured) {
+		t.Fatalf("Expected ErrLLMNotConfigured, got %v", err)
+	}
+	if code := LLMConfigErrorCode(err); code != apierror.CodeLLMNotConfigured {
+		t.Errorf("Expected %s, got %s", apierror.CodeLLMNotConfigured, code)
+	}
+	if code := LLMConfigErrorCode(errors.New("database query error")); code != apierror.CodeLLMConfigUnavailable {
+		t.Errorf("Expected other failures to stay %s, got %s", apierror.CodeLLMConfigUnavailable, code)
+	}
+
+	// The configured defaults fill in for a client's empty settings
+	cfg.OpenAIAPIKey = "sk-default"
+	cfg.OpenAIModel = ""
+	if _, err := NewClientConfigCache(zdb, cfg).GetClientConfig(context.Background(), "client-1"); !errors.Is(err, ErrLLMNotConfigured) {
+		t.Errorf("Expected ErrLLMNotConfigured without a model, got %v", err)
+	}
+}
+
+func TestUnconfiguredLLMIsReportedWithoutTouchingConversations(t *testing.T) {
+	zdb := newUnconfiguredClientDB(t)
+	cfg := testServerConfig(t)
+	cfg.OpenAIAPIKey = ""
+
+	hub := NewHub()
+	handler := NewHandler(hub, zdb, NewClientConfigCache(zdb, cfg))
+	handler.SetChatService(chat.NewChatService(&tools.ZlayDBAdapter{DB: zdb}, &tools.WebSocketAdapter{Hub: hub},
+		unusedLLMClient{t}, tools.NewToolRegistry()))
+	conn := NewConnection(nil, "user-1", "client-1", hub)
+	conn.ProjectID = "project-1"
+	conn.handler = handler
+
+	for _, frame := range []string{
+		`{"type":"user_message","data":{"conversation_id":"conv-1","content":"Hello"}}`,
+		`{"type":"create_conversation","data":{"title":"New","initial_message":"Hello"}}`,
+	} {
+		conn.dispatch([]byte(frame))
+
+		var message struct {
+			Type string    `json:"type"`
+			Data ErrorData `json:"data"`
+		}
+		select {
+		case raw := <-conn.send:
+			if err := json.Unmarshal(raw, &message); err != nil {
+				t.Fatalf("Invalid reply: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected an error reply", frame)
+		}
+		if message.Type != "error" || message.Data.Code != apierror.CodeLLMNotConfigured || message.Data.Details["error"] != nil {
+			t.Errorf("%s: expected a friendly LLM_NOT_CONFIGURED error, got %+v", frame, message)
+		}
+	}
+
+	ctx := context.Background()
+	row, err := zdb.QueryRow(ctx, "SELECT status FROM conversations WHERE id = 'conv-1'")
+	if err != nil {
+		t.Fatalf("Failed to load conversation: %v", err)
+	}
+	if status, _ := row.Values[0].AsString(); status != "completed" {
+		t.Errorf("Expected the conversation to stay completed, got %q", status)
+	}
+	for query, want := range map[string]int64{
+		"SELECT COUNT(*) FROM conversations": 1,
+		"SELECT COUNT(*) FROM messages":      0,
+	} {
+		row, err := zdb.QueryRow(ctx, query)
+		if err != nil {
+			t.Fatalf("%s failed: %v", query, err)
+		}
+		if got, _ := row.Values[0].AsInt64(); got != want {
+			t.Errorf("%s: expected %d, got %d", query, want, got)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zlay-backend/internal/metrics"
+)
+
+const (
+	// DefaultCongestionWindow is how long a connection may stay congested before it is dropped
+	DefaultCongestionWindow = 30 * time.Second
+	// streamBacklogFrames is how many frames a connection receiving a stream
+	// queues past its send buffer before it counts as congested
+	streamBacklogFrames = 1024
+
+	// MetricCongestionDropped names the counter of connections dropped for staying congested, per client
+	MetricCongestionDropped = "ws_congestion_dropped"
+)
+
+// outboundBacklog holds, in order, the frames that did not fit in a
+// connection's send buffer; WritePump moves them over as it makes room. Once
+// the backlog reaches its limit the connection is congested: intermediate
+// assistant_response frames are skipped, since the later frames carrying the
+// accumulated content supersede them, and everything else still queues. The
+// zero value is ready to use.
+type outboundBacklog struct {
+	mutex     sync.Mutex
+	frames    [][]byte
+	limit     int       // Frames queued before the connection is congested
+	congested time.Time // Since when, zero while it is not
+	skipped   int       // Frames skipped while congested
+}
+
+// SetCongestionWindow sets how long a connection may stay congested before it
+// is dropped. Call it before Run.
+func (h *Hub) SetCongestionWindow(window time.Duration) {
+	h.congestionWindow = window
+}
+
+// queueFrame puts an encoded frame on a connection's send buffer, or in its
+// backlog when the buffer is full. It returns false once the connection has
+// been congested for the whole congestion window; the caller then drops it
+// with dropCongested.
+func (h *Hub) queueFrame(conn *Connection, payload []byte) bool {
+	backlog := &conn.outbound
+	backlog.mutex.Lock()
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		backlog.mutex.Unlock()
+		return true
+	}
+	if len(backlog.frames) == 0 {
+		select {
+		case conn.send <- payload:
+			backlog.mutex.Unlock()
+			return true
+		default:
+		}
+	}
+	starting := len(backlog.frames) == 0
+	backlog.mutex.Unlock()
+
+	// Asking the chat service happens outside the lock, as it takes its own
+	limit := 0
+	if starting && h.streamAttached(conn) {
+		limit = streamBacklogFrames
+	}
+
+	backlog.mutex.Lock()
+	defer backlog.mutex.Unlock()
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		return true
+	}
+	if len(backlog.frames) == 0 {
+		// WritePump may have made room meanwhile
+		select {
+		case conn.send <- payload:
+			return true
+		default:
+		}
+		if starting {
+			backlog.limit = limit
+		}
+	}
+
+	now := time.Now()
+	if backlog.congested.IsZero() && len(backlog.frames) >= backlog.limit {
+		backlog.congested = now
+		log.Printf("Connection %s is congested with %d frames queued", conn.ID, len(conn.send)+len(backlog.frames))
+	}
+	if !backlog.congested.IsZero() {
+		if now.Sub(backlog.congested) >= h.congestionWindow {
+			return false
+		}
+		if supersededFrame(payload) {
+			backlog.skipped++
+			return true
+		}
+	}
+	backlog.frames = append(backlog.frames, payload)
+	return true
+}
+
+// refillSend moves backlogged frames into the send buffer while it has room;
+// WritePump calls it after every write
+func (c *Connection) refillSend() {
+	backlog := &c.outbound
+	backlog.mutex.Lock()
+	defer backlog.mutex.Unlock()
+
+	if len(backlog.frames) == 0 || atomic.LoadInt32(&c.closed) == 1 {
+		return
+	}
+	moved := 0
+fill:
+	for moved < len(backlog.frames) {
+		select {
+		case c.send <- backlog.frames[moved]:
+			backlog.frames[moved] = nil
+			moved++
+		default:
+			break fill
+		}
+	}
+	backlog.frames = backlog.frames[moved:]
+	if len(backlog.frames) > 0 {
+		return
+	}
+
+	backlog.frames = nil
+	if !backlog.congested.IsZero() {
+		log.Printf("Connection %s caught up after %s congested, %d frames skipped",
+			c.ID, time.Since(backlog.congested).Round(time.Millisecond), backlog.skipped)
+		backlog.congested = time.Time{}
+		backlog.skipped = 0
+	}
+}
+
+// trySend queues a frame only when nothing is backlogged and the send buffer
+// has room, for frames retransmitted until acknowledged
+func (c *Connection) trySend(payload []byte) bool {
+	backlog := &c.outbound
+	backlog.mutex.Lock()
+	defer backlog.mutex.Unlock()
+
+	if len(backlog.frames) > 0 || atomic.LoadInt32(&c.closed) == 1 {
+		return false
+	}
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// dropCongested closes a connection that stayed congested for the whole
+// window; closing the send buffer ends its WritePump, which closes the socket.
+// It is detached from its streams and unregistered right away rather than
+// once its read loop notices, so a reply nobody else receives is handled as
+// interrupted. Both happen off the caller's goroutine, which may be sending
+// on behalf of the chat service or Run.
+func (h *Hub) dropCongested(conn *Connection) {
+	if !conn.closeSendChannel() {
+		return
+	}
+	metrics.Counter(MetricCongestionDropped).Inc(conn.ClientID)
+	log.Printf("Connection %s dropped after staying congested for %s", conn.ID, h.congestionWindow)
+	go func() {
+		if conn.handler != nil {
+			conn.handler.detachFromStreams(conn)
+		}
+		h.unregister <- conn
+	}()
+}
+
+// streamAttached reports whether a connection receives an active stream
+func (h *Hub) streamAttached(conn *Connection) bool {
+	if conn.handler == nil || conn.handler.chatService == nil {
+		return false
+	}
+	for _, stream := range conn.handler.chatService.GetAllActiveStreams() {
+		for _, id := range stream.ActiveConnectionIDs {
+			if id == conn.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// supersededFrame reports whether a frame is an intermediate assistant_response,
+// which a congested connection can do without. Replays answering resume_stream
+// are kept.
+func supersededFrame(payload []byte) bool {
+	var frame struct {
+		Type string `json:"type"`
+		Data struct {
+			Done    bool `json:"done"`
+			Resumed bool `json:"resumed"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return false
+	}
+	return frame.Type == "assistant_response" && !frame.Data.Done && !frame.Data.Resumed
+}
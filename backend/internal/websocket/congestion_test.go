@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/metrics"
+)
+
+// streamedFrame is a frame with the fields congestion handling looks at
+type streamedFrame struct {
+	Type string `json:"type"`
+	Data struct {
+		Seq  int64 `json:"seq"`
+		Done bool  `json:"done"`
+	} `json:"data"`
+}
+
+func streamFrame(seq int64, done bool) WebSocketMessage {
+	return WebSocketMessage{
+		Type: "assistant_response",
+		Data: AssistantResponseData{ConversationID: "conv-1", Content: "accumulated", Seq: seq, Done: done},
+	}
+}
+
+// attachToStream gives conn a handler whose chat service reports it receiving conv-1
+func attachToStream(hub *Hub, conn *Connection) *fakeStreamService {
+	service := &fakeStreamService{streams: map[string]chat.StreamStateSnapshot{
+		"conv-1": {ActiveConnectionIDs: []string{conn.ID}},
+	}}
+	conn.handler = &Handler{hub: hub, chatService: service}
+	return service
+}
+
+// readSlowly stands in for the WritePump of a client that reads slowly: it
+// takes a frame every delay, refilling the send buffer from the backlog as
+// WritePump does, and returns what it read once nothing arrives for idle
+func readSlowly(t *testing.T, conn *Connection, delay, idle time.Duration) []streamedFrame {
+	var frames []streamedFrame
+	for {
+		select {
+		case data := <-conn.send:
+			var frame streamedFrame
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Errorf("Invalid frame %s: %v", data, err)
+			}
+			frames = append(frames, frame)
+			time.Sleep(delay)
+			conn.refillSend()
+		case <-time.After(idle):
+			return frames
+		}
+	}
+}
+
+func TestCongestedStreamSkipsDeltasButDeliversFinalFrames(t *testing.T) {
+	hub := NewHub()
+	conn := NewConnection(nil, "user-a", "client-1", hub)
+	attachToStream(hub, conn)
+
+	read := make(chan []streamedFrame)
+	go func() {
+		read <- readSlowly(t, conn, 100*time.Microsecond, 100*time.Millisecond)
+	}()
+
+	deltas := int64(cap(conn.send) + streamBacklogFrames + 500)
+	for seq := int64(1); seq <= deltas; seq++ {
+		hub.SendToConnection(conn, streamFrame(seq, false))
+	}
+	hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_status_updated"})
+	hub.SendToConnection(conn, streamFrame(deltas+1, true))
+	frames := <-read
+
+	if len(frames) < 2 {
+		t.Fatalf("Expected the final frames, got %+v", frames)
+	}
+	status, final := frames[len(frames)-2], frames[len(frames)-1]
+	if status.Type != "conversation_status_updated" || final.Type != "assistant_response" || !final.Data.Done || final.Data.Seq != deltas+1 {
+		t.Errorf("Expected the status event then the final frame last, got %+v then %+v", status, final)
+	}
+	received := frames[:len(frames)-2]
+	if int64(len(received)) >= deltas {
+		t.Errorf("Expected intermediate frames skipped while congested, got all %d", len(received))
+	}
+	if len(received) < cap(conn.send)+streamBacklogFrames {
+		t.Errorf("Expected the send buffer and backlog delivered, got %d frames", len(received))
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i].Data.Seq <= received[i-1].Data.Seq {
+			t.Fatalf("Expected frames in order, got seq %d after %d", received[i].Data.Seq, received[i-1].Data.Seq)
+		}
+	}
+
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		t.Error("Expected a connection that caught up to be kept")
+	}
+	conn.outbound.mutex.Lock()
+	defer conn.outbound.mutex.Unlock()
+	if !conn.outbound.congested.IsZero() || len(conn.outbound.frames) != 0 {
+		t.Errorf("Expected congestion cleared once the backlog drained, congested since %s", conn.outbound.congested)
+	}
+}
+
+func TestConnectionWithoutStreamIsCongestedOnceItsBufferFills(t *testing.T) {
+	hub := NewHub()
+	conn := NewConnection(nil, "user-a", "client-1", hub)
+	for len(conn.send) < cap(conn.send) {
+		conn.send <- []byte(`{"type":"filler"}`)
+	}
+
+	hub.SendToConnection(conn, streamFrame(1, false))
+	hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_status_updated"})
+	hub.SendToConnection(conn, streamFrame(2, true))
+
+	conn.outbound.mutex.Lock()
+	queued, skipped := len(conn.outbound.frames), conn.outbound.skipped
+	conn.outbound.mutex.Unlock()
+	if queued != 2 || skipped != 1 {
+		t.Errorf("Expected the delta skipped and the rest queued, got %d queued and %d skipped", queued, skipped)
+	}
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		t.Error("Expected the connection kept within the congestion window")
+	}
+}
+
+func TestConnectionIsDroppedAfterCongestionWindow(t *testing.T) {
+	hub := NewHub()
+	hub.SetCongestionWindow(50 * time.Millisecond)
+	go hub.Run()
+	conn := registerConnections(t, hub, "user-a")[0]
+	conn.ClientID = "client-congested"
+	service := attachToStream(hub, conn)
+	before := metrics.Counter(MetricCongestionDropped).Snapshot()["client-congested"].Count
+
+	// Nothing reads, so the send buffer and then the backlog fill up
+	for seq := int64(1); seq <= int64(cap(conn.send)+streamBacklogFrames+1); seq++ {
+		hub.SendToConnection(conn, streamFrame(seq, false))
+	}
+	hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_status_updated"})
+	if atomic.LoadInt32(&conn.closed) == 1 || hub.GetConnectionByID(conn.ID) == nil {
+		t.Fatal("Expected the connection kept within the congestion window")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	hub.SendToConnection(conn, streamFrame(0, true))
+	if atomic.LoadInt32(&conn.closed) != 1 {
+		t.Fatal("Expected the connection dropped after the congestion window")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for hub.GetConnectionByID(conn.ID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the dropped connection unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	service.mutex.Lock()
+	detached := service.detached
+	service.mutex.Unlock()
+	if len(detached) != 1 || detached[0] != "conv-1/"+conn.ID {
+		t.Errorf("Expected the connection detached from its stream, got %v", detached)
+	}
+	if after := metrics.Counter(MetricCongestionDropped).Snapshot()["client-congested"].Count; after != before+1 {
+		t.Errorf("Expected one dropped connection counted, got %d", after-before)
+	}
+
+	// Frames for the dropped connection are discarded
+	hub.SendToConnection(conn, WebSocketMessage{Type: "conversation_status_updated"})
+}
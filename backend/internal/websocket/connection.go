@@ -1,14 +1,20 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/widget"
 )
 
 // Connection represents a WebSocket connection
@@ -18,16 +24,41 @@ type Connection struct {
 
 	// Buffered channel of outbound messages
 	send chan []byte
+	// Frames that did not fit in send, see congestion.go
+	outbound outboundBacklog
 
 	// Connection metadata
 	ID        string
 	UserID    string
 	ClientID  string
 	ProjectID string
-
-	// Token usage tracking
+	// Root user behind an impersonated session, empty otherwise
+	ImpersonatedBy string
+	// Project a project API key is limited to, empty otherwise
+	APIKeyProject string
+
+	// Protocol version announced in the connection_established handshake
+	ProtocolVersion int
+	// Language of error messages, negotiated from the upgrade request's Accept-Language
+	Language string
+	// Client address behind any trusted proxies and browser of the upgrade request
+	RemoteIP  string
+	UserAgent string
+	// ID of the upgrade request, sent as request_id on frames that answer no chat request
+	RequestID string
+	// Capabilities negotiated in the handshake; read by the hub while encoding frames
+	capabilities atomic.Pointer[map[string]bool]
+	// Critical frames sent with a message_id and not acknowledged yet, when the client negotiated acks
+	acks pendingAcks
+
+	// Token usage tracking; replies run outside the read loop, so use the methods
 	TokensUsed int64
 	TokensLimit int64
+	tokensMu    sync.Mutex
+
+	// Cancelled when the connection closes; replies to its messages derive from it
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// Hub reference for broadcasting
 	hub *Hub
@@ -40,11 +71,24 @@ type Connection struct {
 	
 	// Track if connection is unregistered to prevent double-unregister
 	unregistered int32 // 0 = not unregistered, 1 = unregistered
+
+	// Close frame queued for WritePump after a protocol_error; further frames are ignored
+	closing   chan []byte
+	isClosing int32
+
+	// Set for anonymous widget visitors, who are pinned to the widget project and rate limited
+	visitorLimiter *widget.RateLimiter
+
+	// When the connection was opened, and the last frame or pong read from it in Unix milliseconds
+	ConnectedAt time.Time
+	lastSeen    atomic.Int64
 }
 
 // NewConnection creates a new connection instance
 func NewConnection(ws *websocket.Conn, userID, clientID string, hub *Hub) *Connection {
-	return &Connection{
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &Connection{
 		ws:          ws,
 		send:        make(chan []byte, 256),
 		ID:          uuid.New().String(),
@@ -54,12 +98,41 @@ func NewConnection(ws *websocket.Conn, userID, clientID string, hub *Hub) *Conne
 		TokensUsed:  0,
 		TokensLimit: 1000000, // Default limit of 1M tokens per connection
 		handler:     nil,
+
+		ProtocolVersion: ProtocolVersion,
+		Language:        apierror.DefaultLanguage,
+		closing:         make(chan []byte, 1),
+		ConnectedAt:     now,
+		ctx:             ctx,
+		cancel:          cancel,
 	}
+	conn.lastSeen.Store(now.UnixMilli())
+	return conn
+}
+
+// setRequestID records the ID of the upgrade request, which the connection's
+// context then carries
+func (c *Connection) setRequestID(id string) {
+	c.RequestID = id
+	c.ctx = requestid.With(c.ctx, id)
+}
+
+// Context returns a context cancelled when the connection closes
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// LastSeen returns when a frame or pong was last read from the connection
+func (c *Connection) LastSeen() time.Time {
+	return time.UnixMilli(c.lastSeen.Load())
 }
 
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Connection) ReadPump() {
 	defer func() {
+		// Replies to this connection's messages start their headless grace period
+		c.cancel()
+
 		// 🔄 NEW: Check for active streaming and mark as interrupted
 		c.hub.handleInterruptionForConnection(c)
 		
@@ -72,6 +145,7 @@ func (c *Connection) ReadPump() {
 	// Set read deadline
 	c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.ws.SetPongHandler(func(string) error {
+		c.lastSeen.Store(time.Now().UnixMilli())
 		c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
@@ -85,58 +159,80 @@ func (c *Connection) ReadPump() {
 			}
 			break
 		}
+		c.lastSeen.Store(time.Now().UnixMilli())
 
-		// Parse and handle message
-		var message WebSocketMessage
-		if err := json.Unmarshal(messageData, &message); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
+		if atomic.LoadInt32(&c.isClosing) == 1 {
 			continue
 		}
 
-		// Add connection metadata to message
-		message.Timestamp = time.Now().UnixMilli()
+		c.dispatch(messageData)
+
+		// Reset read deadline
+		c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+	}
+}
+
+// dispatch validates one frame and routes it to its handler. Frames that are
+// not valid JSON or fail validation are answered with an INVALID_MESSAGE error.
+func (c *Connection) dispatch(messageData []byte) {
+	var message WebSocketMessage
+	if err := json.Unmarshal(messageData, &message); err != nil {
+		c.sendInvalidMessage("", &ValidationError{Field: "message", Reason: "is not valid JSON"})
+		return
+	}
+
+	// Add connection metadata to message
+	message.Timestamp = time.Now().UnixMilli()
+
+	req, err := parseMessage(&message)
+	if err != nil {
+		c.sendInvalidMessage(message.Type, err)
+		return
+	}
+	if c.rejectForVisitor(message.Type, req) {
+		return
+	}
 
 	// Route message based on type
-		switch message.Type {
-		case "user_message":
-			if c.handler != nil {
-				c.handler.handleUserMessage(c, &message)
+	switch r := req.(type) {
+	case *ConnectionEstablishedRequest:
+		c.handleConnectionEstablished(r)
+	case *ProjectRequest:
+		if message.Type == "join_project" {
+			if c.APIKeyProject != "" && r.ProjectID != c.APIKeyProject {
+				c.sendError(ErrCodeAPIKeyForbidden, map[string]interface{}{"type": message.Type})
+				return
 			}
-		case "join_project":
-			c.handleProjectJoin(message)
-		case "leave_project":
-			c.handleProjectLeave(message)
+			c.JoinProject(r.ProjectID)
+		} else if r.ProjectID == c.ProjectID {
+			c.LeaveProject()
+		}
+	case *AckRequest:
+		c.acks.acknowledge(r.MessageID)
+	case *EmptyRequest:
+		switch message.Type {
 		case "ping":
 			c.handlePing()
-		// New chat-related message types routed to handler methods
+		case "get_presence":
+			c.handleGetPresence()
 		case "get_conversations":
 			if c.handler != nil {
-				c.handler.handleGetConversations(c, &message)
-			}
-		case "create_conversation":
-			if c.handler != nil {
-				c.handler.handleCreateConversation(c, &message)
-			}
-		case "get_conversation":
-			if c.handler != nil {
-				c.handler.handleGetConversation(c, &message)
+				c.handler.handleGetConversations(c)
 			}
-		case "delete_conversation":
+		case "get_all_conversation_statuses":
 			if c.handler != nil {
-				c.handler.handleDeleteConversation(c, &message)
+				c.handler.handleGetAllConversationStatuses(c)
 			}
-		case "get_streaming_conversation":
+		case "list_templates":
 			if c.handler != nil {
-				// c.handleGetStreamingConversation(conn, message)
-				c.handler.handleGetStreamingConversation(c, &message)
+				c.handler.handleListTemplates(c)
 			}
-		default:
-			// For unhandled message types, just log but don't error
-			log.Printf("Received message type: %s (no handler yet)", message.Type)
 		}
-
-		// Reset read deadline
-		c.ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+	default:
+		// Chat-related message types are routed to handler methods
+		if c.handler != nil {
+			c.handler.handleRequest(c, message.Type, req)
+		}
 	}
 }
 
@@ -150,6 +246,24 @@ func (c *Connection) WritePump() {
 
 	for {
 		select {
+		case frame := <-c.closing:
+			// Flush what was queued before the close, including the protocol_error itself
+			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			for pending := true; pending; {
+				select {
+				case message, ok := <-c.send:
+					if !ok {
+						pending = false
+						break
+					}
+					c.ws.WriteMessage(websocket.TextMessage, message)
+				default:
+					pending = false
+				}
+			}
+			c.ws.WriteMessage(websocket.CloseMessage, frame)
+			return
+
 		case message, ok := <-c.send:
 			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
@@ -158,6 +272,8 @@ func (c *Connection) WritePump() {
 				return
 			}
 
+			// permessage-deflate, when negotiated, only pays off for larger frames
+			c.ws.EnableWriteCompression(c.hub != nil && c.hub.shouldCompress(len(message)))
 			w, err := c.ws.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -166,6 +282,7 @@ func (c *Connection) WritePump() {
 			if err := w.Close(); err != nil {
 				return
 			}
+			c.refillSend()
 
 		case <-ticker.C:
 			c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -224,64 +341,118 @@ func (c *Connection) LeaveProject() {
 	}
 }
 
-// handleProjectJoin processes project join requests
-func (c *Connection) handleProjectJoin(message WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid join_project data format")
+// handleConnectionEstablished records the client's protocol version and confirms
+// the connection, or rejects an unsupported version with protocol_error and a close
+func (c *Connection) handleConnectionEstablished(req *ConnectionEstablishedRequest) {
+	version := req.Version()
+	if version < MinProtocolVersion || version > ProtocolVersion {
+		data := newErrorData(c.Language, ErrCodeUnsupportedProtocol, map[string]interface{}{
+			"protocol_version":     version,
+			"min_protocol_version": MinProtocolVersion,
+			"max_protocol_version": ProtocolVersion,
+		})
+		data.RequestID = c.RequestID
+		c.hub.SendToConnection(c, WebSocketMessage{
+			Type:      "protocol_error",
+			Data:      data,
+			Timestamp: time.Now().UnixMilli(),
+		})
+		c.closeWith(CloseUnsupportedProtocol, "unsupported protocol version")
 		return
 	}
 
-	projectID, ok := data["project_id"].(string)
-	if !ok {
-		log.Printf("Missing project_id in join_project message")
-		return
+	c.ProtocolVersion = version
+	capabilities := req.NegotiatedCapabilities()
+	c.setCapabilities(capabilities)
+	data := gin.H{
+		"connection_id":    c.ID,
+		"user_id":          c.UserID,
+		"project_id":       c.ProjectID,
+		"protocol_version": c.ProtocolVersion,
+		"capabilities":     capabilities,
+		"timestamp":        time.Now().UnixMilli(),
 	}
-
-	c.JoinProject(projectID)
-	
-	// Send project joined confirmation via hub
-	// Note: This will be handled by the WebSocket handler
+	if c.ImpersonatedBy != "" {
+		data["impersonated_by"] = c.ImpersonatedBy
+	}
+	// Send back connection confirmation for streaming state restoration
+	c.hub.SendToConnection(c, WebSocketMessage{
+		Type:      "connection_established",
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+	})
 }
 
-// handleProjectLeave processes project leave requests
-func (c *Connection) handleProjectLeave(message WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid leave_project data format")
-		return
+// setCapabilities records the capabilities negotiated in the handshake
+func (c *Connection) setCapabilities(capabilities []string) {
+	set := make(map[string]bool, len(capabilities))
+	for _, capability := range capabilities {
+		set[capability] = true
 	}
+	c.capabilities.Store(&set)
+}
+
+// HasCapability reports whether the client negotiated a capability at connection_established
+func (c *Connection) HasCapability(capability string) bool {
+	set := c.capabilities.Load()
+	return set != nil && (*set)[capability]
+}
+
+// sendInvalidMessage answers a frame that failed validation
+func (c *Connection) sendInvalidMessage(messageType string, err error) {
+	log.Printf("Rejected %q message from connection %s: %v", messageType, c.ID, err)
+	data := invalidMessageError(c.Language, messageType, err)
+	data.RequestID = c.RequestID
+	c.hub.SendToConnection(c, WebSocketMessage{
+		Type:      "error",
+		Data:      data,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
 
-	projectID, ok := data["project_id"].(string)
-	if ok && projectID == c.ProjectID {
-		c.LeaveProject()
+// closeWith asks WritePump to send a close frame once queued messages are written
+func (c *Connection) closeWith(code int, text string) {
+	if atomic.CompareAndSwapInt32(&c.isClosing, 0, 1) {
+		c.closing <- websocket.FormatCloseMessage(code, text)
 	}
 }
 
 // AddTokens adds to the token usage count and returns true if within limit
 func (c *Connection) AddTokens(tokens int64) bool {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
 	c.TokensUsed += tokens
 	return c.TokensUsed <= c.TokensLimit
 }
 
 // GetTokenUsage returns current token usage statistics
 func (c *Connection) GetTokenUsage() (used int64, limit int64, remaining int64) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
 	return c.TokensUsed, c.TokensLimit, c.TokensLimit - c.TokensUsed
 }
 
 // IsTokenLimitExceeded checks if token limit has been exceeded
 func (c *Connection) IsTokenLimitExceeded() bool {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
 	return c.TokensUsed > c.TokensLimit
 }
 
 // SetTokenLimit updates the token limit for this connection
 func (c *Connection) SetTokenLimit(limit int64) {
+	c.tokensMu.Lock()
 	c.TokensLimit = limit
+	c.tokensMu.Unlock()
 }
 
-// ResetTokenUsage resets the token usage counter
-func (c *Connection) ResetTokenUsage() {
+// ResetTokenUsage resets the token usage counter and returns what it was
+func (c *Connection) ResetTokenUsage() int64 {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	previous := c.TokensUsed
 	c.TokensUsed = 0
+	return previous
 }
 
 // handlePing processes ping messages
@@ -295,11 +466,55 @@ func (c *Connection) handlePing() {
 	})
 }
 
-// closeSendChannel safely closes the send channel if not already closed
-func (c *Connection) closeSendChannel() {
+// sendError sends an error message with a catalog code in the connection's language
+func (c *Connection) sendError(code string, details map[string]interface{}) {
+	c.sendRequestError(c.RequestID, code, details)
+}
+
+// sendRequestError sends an error for the request with the given ID, which the
+// error data and the frame both carry; without one the upgrade request's is used
+func (c *Connection) sendRequestError(requestID, code string, details map[string]interface{}) {
+	if requestID == "" {
+		requestID = c.RequestID
+	}
+	data := newErrorData(c.Language, code, details)
+	data.RequestID = requestID
+	c.hub.SendToConnection(c, messages.RequestMessage{
+		Message: WebSocketMessage{
+			Type:      "error",
+			Data:      data,
+			Timestamp: time.Now().UnixMilli(),
+		},
+		RequestID: requestID,
+	})
+}
+
+// handleGetPresence answers with who is connected to the connection's project room
+func (c *Connection) handleGetPresence() {
+	if c.ProjectID == "" {
+		c.sendError(apierror.CodeNotInProject, nil)
+		return
+	}
+
+	c.hub.SendToConnection(c, WebSocketMessage{
+		Type:      "presence_update",
+		Data:      c.hub.PresenceSnapshot(c.ProjectID),
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// closeSendChannel safely closes the send channel if not already closed,
+// discarding any backlogged frames. It reports whether this call closed it.
+func (c *Connection) closeSendChannel() bool {
+	c.outbound.mutex.Lock()
+	defer c.outbound.mutex.Unlock()
+
+	c.outbound.frames = nil
 	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		close(c.send)
+		return true
 	}
+	return false
 }
 
 // shouldUnregister checks if this connection should be unregistered
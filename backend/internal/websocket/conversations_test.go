@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+func TestConversationsListCarriesSummary(t *testing.T) {
+	zdb := newUnconfiguredClientDB(t)
+	ctx := context.Background()
+	long := strings.Repeat("é", 130)
+	for _, stmt := range []string{
+		"UPDATE conversations SET status = 'interrupted', created_at = '2026-01-01 10:00:00', updated_at = '2026-01-01 10:00:00'",
+		"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conv-2', 'Empty', 'user-1', 'project-1', 'completed', '2026-01-02 10:00:00', '2026-01-02 10:00:00')",
+		`INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES
+			('m-1', 'conv-1', 'user', 'Show sales', '2026-01-01 10:00:01'),
+			('m-2', 'conv-1', 'assistant', '` + long + `', '2026-01-01 10:00:02'),
+			('m-3', 'conv-1', 'tool', '{"rows": 3}', '2026-01-01 10:00:03'),
+			('m-4', 'conv-1', 'assistant', '', '2026-01-01 10:00:04')`,
+	} {
+		if _, err := zdb.Execute(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up conversations: %v", err)
+		}
+	}
+
+	hub := NewHub()
+	handler := NewHandler(hub, zdb, NewClientConfigCache(zdb, testServerConfig(t)))
+	handler.SetChatService(chat.NewChatService(&tools.ZlayDBAdapter{DB: zdb}, &tools.WebSocketAdapter{Hub: hub},
+		unusedLLMClient{t}, tools.NewToolRegistry()))
+	conn := NewConnection(nil, "user-1", "client-1", hub)
+	conn.ProjectID = "project-1"
+	conn.handler = handler
+
+	conn.dispatch([]byte(`{"type":"get_conversations"}`))
+	var message struct {
+		Type string                `json:"type"`
+		Data ConversationsListData `json:"data"`
+	}
+	select {
+	case raw := <-conn.send:
+		if err := json.Unmarshal(raw, &message); err != nil {
+			t.Fatalf("Invalid reply: %v", err)
+		}
+		if !strings.Contains(string(raw), `"message_count":0,"last_message_preview":""`) {
+			t.Errorf("Expected empty conversations to carry the summary fields, got %s", raw)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected conversations_list")
+	}
+
+	conversations := message.Data.Conversations
+	if message.Type != "conversations_list" || len(conversations) != 2 || conversations[0].ID != "conv-2" {
+		t.Fatalf("Expected both conversations, most recently updated first, got %s %+v", message.Type, conversations)
+	}
+	preview := strings.Repeat("é", 120)
+	summary := conversations[1]
+	if summary.Status != "interrupted" || summary.MessageCount != 3 || summary.LastMessagePreview != preview {
+		t.Errorf("Unexpected summary: status %q, %d messages, preview %q", summary.Status, summary.MessageCount, summary.LastMessagePreview)
+	}
+
+	// conversation_details carries the same summary
+	conn.dispatch([]byte(`{"type":"get_conversation","data":{"conversation_id":"conv-1"}}`))
+	var details struct {
+		Type string                  `json:"type"`
+		Data ConversationDetailsData `json:"data"`
+	}
+	select {
+	case raw := <-conn.send:
+		if err := json.Unmarshal(raw, &details); err != nil {
+			t.Fatalf("Invalid reply: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected conversation_details")
+	}
+	if conv := details.Data.Conversation; details.Type != "conversation_details" || conv.MessageCount != 3 || conv.LastMessagePreview != preview {
+		t.Errorf("Unexpected details summary: %s, %d messages, preview %q", details.Type, conv.MessageCount, conv.LastMessagePreview)
+	}
+}
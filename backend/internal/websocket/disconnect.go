@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultDisconnectReason is sent in force_disconnect when the admin gives no reason
+const DefaultDisconnectReason = "Disconnected by an administrator"
+
+// maxCloseReasonBytes is the longest reason a close frame can carry
+const maxCloseReasonBytes = 123
+
+// ForceDisconnectData is the payload of force_disconnect, sent right before the connection is closed
+type ForceDisconnectData struct {
+	Reason string `json:"reason"`
+}
+
+// ForceDisconnect closes the connection with the given ID, or every connection
+// of userID when connectionID is empty; when both are set the connection must
+// belong to the user. Each connection is sent a force_disconnect message, closed
+// with CloseForceDisconnect once its queued frames are written, and detached
+// from the streams it was attached to, so a stuck stream no longer counts it
+// as a listener. It returns how many connections were closed.
+func (h *Handler) ForceDisconnect(userID, connectionID, reason string) int {
+	var connections []*Connection
+	if connectionID != "" {
+		if conn := h.hub.GetConnectionByID(connectionID); conn != nil && (userID == "" || conn.UserID == userID) {
+			connections = append(connections, conn)
+		}
+	} else if userID != "" {
+		connections = h.hub.GetUserConnections(userID)
+	}
+	if reason == "" {
+		reason = DefaultDisconnectReason
+	}
+	closeReason := reason
+	if len(closeReason) > maxCloseReasonBytes {
+		closeReason = closeReason[:maxCloseReasonBytes]
+	}
+
+	for _, conn := range connections {
+		h.hub.SendToConnection(conn, WebSocketMessage{
+			Type:      "force_disconnect",
+			Data:      ForceDisconnectData{Reason: reason},
+			Timestamp: time.Now().UnixMilli(),
+		})
+		conn.closeWith(CloseForceDisconnect, closeReason)
+		h.detachFromStreams(conn)
+		log.Printf("Force-disconnected connection %s of user %s: %s", conn.ID, conn.UserID, reason)
+	}
+	return len(connections)
+}
+
+// detachFromStreams removes a connection from every stream it is attached to
+func (h *Handler) detachFromStreams(conn *Connection) {
+	if h.chatService == nil {
+		return
+	}
+	for conversationID, stream := range h.chatService.GetAllActiveStreams() {
+		for _, id := range stream.ActiveConnectionIDs {
+			if id == conn.ID {
+				if err := h.chatService.DetachConnectionFromStream(conversationID, conn.ID); err != nil {
+					log.Printf("Failed to detach connection %s from stream %s: %v", conn.ID, conversationID, err)
+				}
+				break
+			}
+		}
+	}
+}
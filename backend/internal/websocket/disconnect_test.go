@@ -0,0 +1,236 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/llm"
+)
+
+// fakeStreamService reports fixed active streams and records detached connections
+type fakeStreamService struct {
+	chat.ChatService
+	streams map[string]chat.StreamStateSnapshot
+
+	mutex    sync.Mutex
+	detached []string // conversationID/connectionID
+}
+
+func (f *fakeStreamService) GetAllActiveStreams() map[string]chat.StreamStateSnapshot {
+	return f.streams
+}
+
+func (f *fakeStreamService) DetachConnectionFromStream(conversationID, connectionID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.detached = append(f.detached, conversationID+"/"+connectionID)
+	return nil
+}
+
+// registerConnections registers fake connections and waits for the hub to index them
+func registerConnections(t *testing.T, hub *Hub, userIDs ...string) []*Connection {
+	t.Helper()
+
+	var connections []*Connection
+	for _, userID := range userIDs {
+		conn := NewConnection(nil, userID, "client-1", hub)
+		hub.register <- conn
+		connections = append(connections, conn)
+	}
+	deadline := time.Now().Add(time.Second)
+	for hub.GetConnectionCount() < len(userIDs) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d connections registered, got %d", len(userIDs), hub.GetConnectionCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return connections
+}
+
+// forceDisconnectOf returns the reason of the force_disconnect queued for conn
+// and the code of its close frame, or false if it was not disconnected
+func forceDisconnectOf(t *testing.T, conn *Connection) (string, int, bool) {
+	t.Helper()
+
+	var frame []byte
+	select {
+	case frame = <-conn.closing:
+	default:
+		return "", 0, false
+	}
+	var message struct {
+		Type string              `json:"type"`
+		Data ForceDisconnectData `json:"data"`
+	}
+	select {
+	case data := <-conn.send:
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Invalid message: %v", err)
+		}
+	default:
+	}
+	if message.Type != "force_disconnect" {
+		t.Errorf("Expected force_disconnect before the close frame, got %q", message.Type)
+	}
+	return message.Data.Reason, int(binary.BigEndian.Uint16(frame[:2])), true
+}
+
+func TestForceDisconnectByUser(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	connections := registerConnections(t, hub, "user-1", "user-1", "user-2")
+	first, second, other := connections[0], connections[1], connections[2]
+
+	service := &fakeStreamService{streams: map[string]chat.StreamStateSnapshot{
+		"conv-1": {ActiveConnectionIDs: []string{first.ID, other.ID}},
+		"conv-2": {ActiveConnectionIDs: []string{other.ID}},
+	}}
+	handler := &Handler{hub: hub, chatService: service}
+
+	if got := handler.ForceDisconnect("user-1", "", "stuck stream"); got != 2 {
+		t.Fatalf("Expected both of user-1's connections disconnected, got %d", got)
+	}
+	for _, conn := range []*Connection{first, second} {
+		reason, code, ok := forceDisconnectOf(t, conn)
+		if !ok || reason != "stuck stream" || code != CloseForceDisconnect {
+			t.Errorf("Expected %s closed with %d and the reason, got %v %d %q", conn.ID, CloseForceDisconnect, ok, code, reason)
+		}
+	}
+	if _, _, ok := forceDisconnectOf(t, other); ok {
+		t.Error("Expected user-2's connection to be left open")
+	}
+	// Only the streams a connection was attached to are touched
+	if len(service.detached) != 1 || service.detached[0] != "conv-1/"+first.ID {
+		t.Errorf("Unexpected detached connections %v", service.detached)
+	}
+}
+
+func TestForceDisconnectByConnectionID(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	connections := registerConnections(t, hub, "user-1", "user-2")
+	first, other := connections[0], connections[1]
+
+	service := &fakeStreamService{streams: map[string]chat.StreamStateSnapshot{
+		"conv-1": {ActiveConnectionIDs: []string{other.ID}},
+	}}
+	handler := &Handler{hub: hub, chatService: service}
+
+	if got := handler.ForceDisconnect("", "missing", ""); got != 0 {
+		t.Errorf("Expected an unknown connection to affect nothing, got %d", got)
+	}
+	if got := handler.ForceDisconnect("user-1", other.ID, ""); got != 0 {
+		t.Errorf("Expected another user's connection to be refused, got %d", got)
+	}
+	if got := handler.ForceDisconnect("", other.ID, ""); got != 1 {
+		t.Fatalf("Expected one connection disconnected, got %d", got)
+	}
+	if reason, _, ok := forceDisconnectOf(t, other); !ok || reason != DefaultDisconnectReason {
+		t.Errorf("Expected the default reason, got %v %q", ok, reason)
+	}
+	if _, _, ok := forceDisconnectOf(t, first); ok {
+		t.Error("Expected user-1's connection to be left open")
+	}
+	if len(service.detached) != 1 || service.detached[0] != "conv-1/"+other.ID {
+		t.Errorf("Unexpected detached connections %v", service.detached)
+	}
+}
+
+func TestHubIndexesConnections(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	connections := registerConnections(t, hub, "user-1", "user-1", "user-2")
+
+	if got := hub.GetConnectionByID(connections[1].ID); got != connections[1] {
+		t.Errorf("Expected to find the connection by ID, got %v", got)
+	}
+	if got := hub.GetUserConnections("user-1"); len(got) != 2 {
+		t.Errorf("Expected two connections for user-1, got %d", len(got))
+	}
+	listed := hub.ListConnections()
+	if len(listed) != 3 || listed[0].UserID == "" || listed[0].ConnectedSince.IsZero() || listed[0].LastSeen.IsZero() {
+		t.Fatalf("Unexpected connection list %+v", listed)
+	}
+
+	hub.unregister <- connections[0]
+	hub.unregister <- connections[2]
+	hub.register <- NewConnection(nil, "user-3", "client-1", hub) // Serializes after the unregisters
+	if hub.GetConnectionByID(connections[0].ID) != nil {
+		t.Error("Expected the unregistered connection to be dropped from the ID index")
+	}
+	if got := hub.GetUserConnections("user-1"); len(got) != 1 || got[0] != connections[1] {
+		t.Errorf("Expected user-1's remaining connection, got %v", got)
+	}
+	if got := hub.GetUserConnections("user-2"); len(got) != 0 {
+		t.Errorf("Expected no connections for user-2, got %d", len(got))
+	}
+}
+
+// waitingChatService holds every reply until its request's context ends
+type waitingChatService struct {
+	chat.ChatService
+	started chan *chat.ChatRequest
+}
+
+func (s *waitingChatService) WithLLMClient(llm.LLMClient) chat.ChatService { return s }
+
+func (s *waitingChatService) ProcessUserMessage(req *chat.ChatRequest) error {
+	s.started <- req
+	<-req.Context.Done()
+	return req.Context.Err()
+}
+
+func TestRepliesRunOutsideTheReadLoopAndEndWithTheConnection(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	cache := NewClientConfigCache(nil, testServerConfig(t))
+	cache.cache["client-1"] = &ClientConfig{ClientID: "client-1", LastUsed: time.Now(), LLMClient: unusedLLMClient{t}}
+	service := &waitingChatService{started: make(chan *chat.ChatRequest, 2)}
+	handler := NewHandler(hub, nil, cache)
+	handler.SetChatService(service)
+
+	conn := registerConnections(t, hub, "user-1")[0]
+	conn.ProjectID = "project-1"
+	conn.handler = handler
+
+	// dispatch returns while the reply is generating, so the next frame is read
+	done := make(chan struct{})
+	go func() {
+		conn.dispatch([]byte(`{"type":"user_message","data":{"conversation_id":"conv-1","content":"Hello"}}`))
+		conn.dispatch([]byte(`{"type":"user_message","data":{"conversation_id":"conv-2","content":"Hello"}}`))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected dispatch not to wait for the reply")
+	}
+
+	var requests []*chat.ChatRequest
+	for len(requests) < 2 {
+		select {
+		case req := <-service.started:
+			requests = append(requests, req)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected both replies to start, got %d", len(requests))
+		}
+	}
+	for _, req := range requests {
+		if req.Context.Err() != nil {
+			t.Fatalf("Expected the reply's context to be live while connected")
+		}
+	}
+
+	hub.unregister <- conn
+	for _, req := range requests {
+		select {
+		case <-req.Context.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the reply to %s to be cancelled with the connection", req.ConversationID)
+		}
+	}
+}
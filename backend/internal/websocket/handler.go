@@ -2,8 +2,7 @@ package websocket
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,9 +10,20 @@ import (
 	"strings"
 	"time"
 
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/apikeys"
+	"zlay-backend/internal/auth"
 	"zlay-backend/internal/chat"
 	"zlay-backend/internal/db"
+	"zlay-backend/internal/export"
+	"zlay-backend/internal/llm"
 	"zlay-backend/internal/messages"
+	"zlay-backend/internal/proxy"
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+	"zlay-backend/internal/widget"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -27,7 +37,8 @@ var upgrader = websocket.Upgrader{
 		// TODO: Add proper origin checking in production
 		return true
 	},
-	// Enable WebSocket compression
+	// Negotiate permessage-deflate; WritePump only compresses frames of at least
+	// the hub's compressMinBytes
 	EnableCompression: true,
 }
 
@@ -37,6 +48,16 @@ type Handler struct {
 	chatService       chat.ChatService
 	db               *db.Database
 	clientConfigCache *ClientConfigCache
+	exportSigner      *export.DownloadSigner
+	events            webhooks.Publisher // Outbound webhook events; nil disables them
+	activity          *activity.Recorder // Project activity feed events; nil drops them
+	widgetSigner      *widget.Signer     // Verifies anonymous widget visitor tokens; nil rejects them
+	toolRegistry      tools.ToolRegistry // Cancels tool executions of interrupted conversations; may be nil
+	messagePolicy     chat.MessagePolicy // Checks user message content; the zero value uses the default limit
+	sessions          *auth.Resolver     // Resolves session tokens, shared with the HTTP API
+	proxies           *proxy.Trust       // Proxies whose forwarding headers give the client address; nil trusts none
+	historyCache      *chat.HistoryCache // Dropped for conversations whose stored messages change; nil when disabled
+	maxForkMessages   int                // Most messages fork_conversation copies; 0 uses chat.DefaultMaxForkMessages
 }
 
 // NewHandler creates a new WebSocket handler
@@ -45,6 +66,7 @@ func NewHandler(hub *Hub, db *db.Database, clientConfigCache *ClientConfigCache)
 		hub:              hub,
 		db:               db,
 		clientConfigCache: clientConfigCache,
+		sessions:          auth.NewResolver(db, auth.DefaultCacheTTL),
 	}
 }
 
@@ -53,6 +75,15 @@ func (h *Handler) SetChatService(chatService chat.ChatService) {
 	h.chatService = chatService
 }
 
+// upgradeRequestID returns the ID of an upgrade request, generating one when
+// the router runs without the request ID middleware
+func upgradeRequestID(c *gin.Context) string {
+	if id := requestid.FromGin(c); id != "" {
+		return id
+	}
+	return requestid.New()
+}
+
 // HandleWebSocket handles WebSocket upgrade and connection management
 func (h *Handler) HandleWebSocket(c *gin.Context) {
 	log.Printf("WebSocket connection attempt from: %s", c.Request.RemoteAddr)
@@ -109,23 +140,49 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 
 	// Get project ID from query
 	projectID := c.Query("project")
+
+	// Widget visitors are pinned to their client's widget project, which the token carries
+	if widget.IsToken(token) {
+		claims, err := h.authenticateVisitor(token)
+		if err != nil {
+			log.Printf("Widget visitor authentication failed: %v", err)
+			apierror.Respond(c, apierror.CodeAuthTokenInvalid, nil)
+			return
+		}
+		if projectID != "" && projectID != claims.ProjectID {
+			apierror.Respond(c, apierror.CodeVisitorForbidden, nil)
+			return
+		}
+		h.serveVisitor(c, claims)
+		return
+	}
+
 	if projectID == "" {
 		log.Printf("Missing project ID")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "project_id is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "project"})
 		return
 	}
 	
 	log.Printf("Project ID: %s", projectID)
 
 	// Authenticate user and get session data
-	userID, clientID, err := h.authenticateToken(token)
+	session, err := h.authenticateToken(token)
 	if err != nil {
 		log.Printf("Authentication failed: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication token"})
+		apierror.Respond(c, apierror.CodeAuthTokenInvalid, nil)
 		return
 	}
 	
+	if session.APIKeyProject != "" && projectID != session.APIKeyProject {
+		apierror.Respond(c, apierror.CodeAPIKeyForbidden, nil)
+		return
+	}
+	
+	userID, clientID := session.UserID, session.ClientID
 	log.Printf("Authentication successful: userID=%s, clientID=%s", userID, clientID)
+	if session.ImpersonatedBy != "" {
+		log.Printf("Session of user %s is impersonated by root user %s", userID, session.ImpersonatedBy)
+	}
 
 	// Upgrade HTTP connection to WebSocket
 	log.Printf("Attempting WebSocket upgrade for %s", c.Request.URL.String())
@@ -138,6 +195,12 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 
 	// Create new connection
 	conn := NewConnection(ws, userID, clientID, h.hub)
+	conn.ImpersonatedBy = session.ImpersonatedBy
+	conn.APIKeyProject = session.APIKeyProject
+	conn.Language = apierror.Language(c)
+	conn.RemoteIP = h.proxies.ClientIP(c.Request)
+	conn.UserAgent = c.Request.UserAgent()
+	conn.setRequestID(upgradeRequestID(c))
 	// Attach the handler so the connection can route chat‑related messages
 	conn.handler = h
 
@@ -177,95 +240,47 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	}
 }
 
-// authenticateToken validates the authentication token and returns user and client IDs
-func (h *Handler) authenticateToken(token string) (string, string, error) {
-	if token == "" {
-		return "", "", fmt.Errorf("token is required")
-	}
-
-	// Hash token to match database storage format
-	tokenHash := sha256.Sum256([]byte(token))
-	tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
-
-	// Query session and user data using ZDB
-	query := `
-		SELECT u.id, u.client_id, s.expires_at
-		FROM sessions s
-		JOIN users u ON s.user_id = u.id
-		WHERE s.token_hash = $1 AND u.is_active = true
-	`
-	log.Printf("Authentication query: %s", query)
-	log.Printf("Token hash: %s", tokenHashStr)
-	
-	row, err := h.db.QueryRow(context.Background(), query, tokenHashStr)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return "", "", fmt.Errorf("database error: %w", err)
-	}
+// authenticatedSession is the identity behind a session token
+type authenticatedSession struct {
+	UserID   string
+	ClientID string
+	// ImpersonatedBy is the root user who issued the session via POST /api/admin/impersonate
+	ImpersonatedBy string
+	// APIKeyProject is the only project a project API key may join
+	APIKeyProject string
+}
 
-	if len(row.Values) != 3 {
-		return "", "", fmt.Errorf("invalid session data")
+// authenticateToken validates the authentication token and returns the session's user and client
+func (h *Handler) authenticateToken(token string) (*authenticatedSession, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token is required")
 	}
 
-	userID, ok := row.Values[0].AsString()
-	if !ok {
-		return "", "", fmt.Errorf("invalid user ID")
-	}
-	clientID, ok := row.Values[1].AsString()
-	if !ok {
-		return "", "", fmt.Errorf("invalid client ID")
-	}
-	expiresAtStr, ok := row.Values[2].AsString()
-	if !ok {
-		return "", "", fmt.Errorf("invalid expires at")
-	}
-	
-	// Check if expires_at is already a time.Time from database
-	log.Printf("expires_at type: %T", row.Values[2])
-	log.Printf("expires_at value: %v", row.Values[2])
-	
-	// Try direct time conversion first
-	if expiresAtTime, ok := row.Values[2].AsTimestamp(); ok {
-		// Check if session has expired using the parsed time
-		log.Printf("Parsed expires_at time: %v", expiresAtTime.Time)
-		if time.Now().After(expiresAtTime.Time) {
-			return "", "", fmt.Errorf("token expired")
-		}
-		return userID, clientID, nil
-	}
-	// New handling: if the value is a timestamp Value, extract the time directly
-	if row.Values[2].Type == db.ValueTypeTimestamp {
-		if ts, ok := row.Values[2].Data.(db.Timestamp); ok {
-			t := ts.Time
-			log.Printf("Extracted time.Time from db.Value Timestamp: %v", t)
-			if time.Now().After(t) {
-				return "", "", fmt.Errorf("token expired")
-			}
-			return userID, clientID, nil
+	// Widget visitor tokens are verified without a sessions row
+	if widget.IsToken(token) {
+		claims, err := h.authenticateVisitor(token)
+		if err != nil {
+			return nil, err
 		}
+		return &authenticatedSession{UserID: claims.UserID, ClientID: claims.ClientID}, nil
 	}
-	
-	// Fallback to string parsing
-	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
-	if err != nil {
-		// Try parsing as PostgreSQL timestamp format
-		expiresAt, err = time.Parse("2006-01-02 15:04:05 -0700", expiresAtStr)
+
+	// API keys act as their creator, within the key's project for project keys
+	if apikeys.IsKey(token) {
+		conn := &tools.ZlayDBAdapter{DB: h.db}
+		scope, err := apikeys.Authenticate(context.Background(), conn, token)
 		if err != nil {
-			// Try parsing without timezone
-			expiresAt, err = time.Parse("2006-01-02 15:04:05", expiresAtStr)
-			if err != nil {
-				log.Printf("Failed to parse expires_at '%s': %v", expiresAtStr, err)
-				return "", "", fmt.Errorf("invalid expires at format")
-			}
+			return nil, err
 		}
+		apikeys.TouchLastUsed(conn, scope.KeyID)
+		return &authenticatedSession{UserID: scope.UserID, ClientID: scope.ClientID, APIKeyProject: scope.ProjectID}, nil
 	}
 
-	// Check if session has expired
-	if time.Now().After(expiresAt) {
-		return "", "", fmt.Errorf("token expired")
+	session, err := h.sessions.Resolve(context.Background(), token)
+	if err != nil {
+		return nil, err
 	}
-
-	return userID, clientID, nil
+	return &authenticatedSession{UserID: session.UserID, ClientID: session.ClientID, ImpersonatedBy: session.ImpersonatedBy}, nil
 }
 
 // HandleMessage processes incoming WebSocket messages
@@ -277,68 +292,82 @@ func (h *Handler) HandleMessage(conn *Connection, message *WebSocketMessage) {
 		log.Printf("🔥 DEBUG: Found get_streaming_conversation message!")
 	}
 	
+	req, err := parseMessage(message)
+	if err != nil {
+		conn.sendInvalidMessage(message.Type, err)
+		return
+	}
+
 	switch message.Type {
 	case "connection_established":
-		// 🔄 NEW: Send back connection confirmation for streaming state restoration
-		h.hub.SendToConnection(conn, WebSocketMessage{
-			Type: "connection_established",
-			Data: gin.H{
-				"connection_id": conn.ID,
-				"user_id": conn.UserID,
-				"project_id": conn.ProjectID,
-				"timestamp": time.Now().UnixMilli(),
-			},
-			Timestamp: time.Now().UnixMilli(),
-		})
+		conn.handleConnectionEstablished(req.(*ConnectionEstablishedRequest))
+	case "get_conversations":
+		h.handleGetConversations(conn)
+	case "get_all_conversation_statuses":
+		h.handleGetAllConversationStatuses(conn)
+	default:
+		h.handleRequest(conn, message.Type, req)
+	}
+}
+
+// handleRequest routes a validated chat-related request to its handler
+func (h *Handler) handleRequest(conn *Connection, messageType string, req messageRequest) {
+	switch messageType {
 	case "user_message":
-		h.handleUserMessage(conn, message)
+		h.handleUserMessage(conn, req.(*UserMessageRequest))
 	case "create_conversation":
-		h.handleCreateConversation(conn, message)
-	case "get_conversations":
-		h.handleGetConversations(conn, message)
+		h.handleCreateConversation(conn, req.(*CreateConversationRequest))
 	case "get_conversation":
-		h.handleGetConversation(conn, message)
+		h.handleGetConversation(conn, req.(*ConversationPageRequest))
+	case "get_conversation_messages":
+		h.handleGetConversationMessages(conn, req.(*ConversationPageRequest))
 	case "get_conversation_status":
-		h.handleGetConversationStatus(conn, message)
-	case "get_all_conversation_statuses":
-		h.handleGetAllConversationStatuses(conn, message)
+		h.handleGetConversationStatus(conn, req.(*ConversationRequest))
 	case "get_streaming_conversation":
-		h.handleGetStreamingConversation(conn, message)
+		h.handleGetStreamingConversation(conn, req.(*ConversationRequest))
 	case "delete_conversation":
-		h.handleDeleteConversation(conn, message)
+		h.handleDeleteConversation(conn, req.(*ConversationRequest))
 	case "chat_interrupted":
-		h.handleChatInterrupted(conn, message)
-	default:
-		log.Printf("Unknown message type: %s", message.Type)
+		h.handleChatInterrupted(conn, req.(*ChatInterruptedRequest))
+	case "message_feedback":
+		h.handleMessageFeedback(conn, req.(*MessageFeedbackRequest))
+	case "export_conversation":
+		h.handleExportConversation(conn, req.(*ExportConversationRequest))
+	case "pin_conversation":
+		h.handlePinConversation(conn, req.(*PinConversationRequest))
+	case "set_language":
+		h.handleSetLanguage(conn, req.(*SetLanguageRequest))
+	case "set_drafts_enabled":
+		h.handleSetDraftsEnabled(conn, req.(*SetDraftsEnabledRequest))
+	case "approve_draft":
+		h.handleApproveDraft(conn, req.(*ApproveDraftRequest))
+	case "discard_draft":
+		h.handleDiscardDraft(conn, req.(*DiscardDraftRequest))
+	case "resume_stream":
+		h.handleResumeStream(conn, req.(*ResumeStreamRequest))
+	case "resume_conversation":
+		h.handleResumeConversation(conn, req.(*ResumeConversationRequest))
+	case "add_participant":
+		h.handleAddParticipant(conn, req.(*AddParticipantRequest))
+	case "fork_conversation":
+		h.handleForkConversation(conn, req.(*ForkConversationRequest))
+	case "execute_tool":
+		h.handleExecuteTool(conn, req.(*ExecuteToolRequest))
 	}
 }
 
 // handleUserMessage processes user messages and routes to LLM
-func (h *Handler) handleUserMessage(conn *Connection, message *WebSocketMessage) {
+func (h *Handler) handleUserMessage(conn *Connection, req *UserMessageRequest) {
 	// 🔥 DETAILED LOGGING: Log incoming message structure
-	log.Printf("🔥 INCOMING USER MESSAGE: %+v", message)
-	log.Printf("🔥 MESSAGE TYPE: %s", message.Type)
-	log.Printf("🔥 MESSAGE TIMESTAMP: %d", message.Timestamp)
+	log.Printf("🔥 INCOMING USER MESSAGE: %+v", req)
 	log.Printf("🔥 CONNECTION INFO: ID=%s, UserID=%s, ProjectID=%s, ClientID=%s", 
 		conn.ID, conn.UserID, conn.ProjectID, conn.ClientID)
 
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("❌ Invalid user_message data format: got %T", message.Data)
-		return
-	}
-
-	log.Printf("🔥 PARSED MESSAGE DATA: %+v", data)
-
-	conversationID, ok := data["conversation_id"].(string)
-	if !ok {
-		log.Printf("❌ Missing conversation_id in user_message. Available keys: %v", data)
-		return
-	}
-
-	content, ok := data["content"].(string)
-	if !ok {
-		log.Printf("❌ Missing content in user_message. Available keys: %v", data)
+	conversationID := req.ConversationID
+	content, err := h.messagePolicy.Prepare(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, conn.UserID, conn.ProjectID, req.Content)
+	if err != nil {
+		log.Printf("Rejected user message for conversation %s: %v", conversationID, err)
+		conn.sendError(chat.ContentErrorCode(err, "user_message", "content"))
 		return
 	}
 
@@ -351,20 +380,13 @@ func (h *Handler) handleUserMessage(conn *Connection, message *WebSocketMessage)
 	log.Printf("   • User ID: %s", conn.UserID)
 	log.Printf("   • Project ID: %s", conn.ProjectID)
 	log.Printf("   • Client ID: %s", conn.ClientID)
-	log.Printf("   • Message Timestamp: %d", message.Timestamp)
-
-	// Add connection metadata per AsyncAPI spec
-	data["connection_id"] = conn.ID
-	data["user_id"] = conn.UserID
-	data["project_id"] = conn.ProjectID
-	data["client_id"] = conn.ClientID
 
 	// Get client-specific LLM configuration
 	log.Printf("🔧 FETCHING LLM CONFIG FOR CLIENT: %s", conn.ClientID)
 	clientConfig, err := h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID)
 	if err != nil {
 		log.Printf("❌ FAILED TO GET CLIENT LLM CONFIG: %v", err)
-		h.sendErrorResponse(conn, conversationID, "Failed to load LLM configuration", err.Error())
+		h.sendLLMConfigError(conn, conversationID, err)
 		return
 	}
 
@@ -379,8 +401,15 @@ func (h *Handler) handleUserMessage(conn *Connection, message *WebSocketMessage)
 		ProjectID:      conn.ProjectID,
 		Content:        content,
 		ConnectionID:   conn.ID,
+		ClientID:       conn.ClientID,
 		AddTokensFunc:  conn.AddTokens, // Token tracking function
 		Connection:     conn,           // Connection reference for token info
+
+		MaxConcurrentStreams: clientConfig.MaxConcurrentStreams,
+		FlushPolicy:          clientConfig.FlushPolicy,
+		ToolResultTokenLimit: clientConfig.ToolResultTokenLimit,
+		// Optional client-generated ID so a resend after reconnect is not processed twice
+		ClientMessageID: req.ClientMessageID,
 	}
 
 	log.Printf("📝 CREATED CHAT REQUEST:")
@@ -398,13 +427,7 @@ func (h *Handler) handleUserMessage(conn *Connection, message *WebSocketMessage)
 		chatServiceWithClientLLM := h.chatService.WithLLMClient(clientConfig.LLMClient)
 		
 		log.Printf("🚀 STARTING MESSAGE PROCESSING WITH CLIENT-SPECIFIC LLM...")
-		err := chatServiceWithClientLLM.ProcessUserMessage(chatReq)
-		if err != nil {
-			log.Printf("❌ ERROR PROCESSING USER MESSAGE: %v", err)
-			h.sendErrorResponse(conn, conversationID, "Failed to process message", err.Error())
-		} else {
-			log.Printf("✅ MESSAGE PROCESSING COMPLETED SUCCESSFULLY")
-		}
+		h.processChatRequest(conn, chatReq, chatServiceWithClientLLM.ProcessUserMessage)
 	} else {
 		// Fallback for when chat service is not initialized
 		response := messages.WebSocketMessage{
@@ -422,52 +445,171 @@ func (h *Handler) handleUserMessage(conn *Connection, message *WebSocketMessage)
 	}
 }
 
-// sendErrorResponse sends a formatted error response
-func (h *Handler) sendErrorResponse(conn *Connection, conversationID, message, details string) {
-	errorResponse := WebSocketMessage{
-		Type: "error",
-		Data: ErrorData{
-			Error:   message,
-			Details: map[string]interface{}{"conversation_id": conversationID, "error": details},
-		},
-		Timestamp: time.Now().UnixMilli(),
+// processChatRequest generates a reply with process, such as ProcessUserMessage,
+// outside the read loop, so the connection keeps being read, and noticed
+// closing, while it streams. The request's context ends with the connection;
+// the chat service then lets the reply finish as long as another connection
+// receives it. Each chat request gets its own request ID, which its frames,
+// errors and logs carry.
+func (h *Handler) processChatRequest(conn *Connection, chatReq *chat.ChatRequest, process func(*chat.ChatRequest) error) {
+	ctx, cancel := context.WithCancel(conn.Context())
+	ctx = requestid.With(ctx, requestid.New())
+	chatReq.Context = ctx
+	requestid.Logf(ctx, "Chat request for conversation %s on connection %s (upgrade request_id=%s)", chatReq.ConversationID, conn.ID, conn.RequestID)
+	go func() {
+		defer cancel()
+		if err := process(chatReq); err != nil {
+			requestid.Logf(ctx, "❌ ERROR PROCESSING USER MESSAGE: %v", err)
+			if conn.Context().Err() == nil {
+				h.sendProcessingError(conn, chatReq, err)
+			}
+			return
+		}
+		requestid.Logf(ctx, "✅ MESSAGE PROCESSING COMPLETED SUCCESSFULLY")
+	}()
+}
+
+// sendErrorResponse sends an error with a catalog code; cause is the underlying error, if any
+func (h *Handler) sendErrorResponse(conn *Connection, conversationID, code, cause string) {
+	details := map[string]interface{}{"conversation_id": conversationID}
+	if cause != "" {
+		details["error"] = cause
 	}
-	h.hub.SendToConnection(conn, errorResponse)
+	conn.sendError(code, details)
 }
 
-// handleCreateConversation creates a new conversation
-func (h *Handler) handleCreateConversation(conn *Connection, message *WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid create_conversation data format")
+// sendLLMConfigError reports a client LLM config that could not be loaded. It is
+// sent before any reply starts, so the conversation's status is left as it was.
+func (h *Handler) sendLLMConfigError(conn *Connection, conversationID string, err error) {
+	code := LLMConfigErrorCode(err)
+	cause := ""
+	if code == apierror.CodeLLMConfigUnavailable {
+		cause = err.Error()
+	}
+	h.sendErrorResponse(conn, conversationID, code, cause)
+}
+
+// sendProcessingError reports a ProcessUserMessage failure to the sender, using
+// dedicated message types and codes for duplicates, busy conversations and queue
+// limits. Rate limits were already broadcast as a rate_limited event. Errors
+// carry the chat request's ID.
+func (h *Handler) sendProcessingError(conn *Connection, req *chat.ChatRequest, err error) {
+	requestID := requestid.From(req.Context)
+	var duplicate *chat.DuplicateMessageError
+	if errors.As(err, &duplicate) {
+		// Already accepted; tell the sender so it stops retrying
+		h.hub.SendToConnection(conn, messages.ForRequest(req.Context, WebSocketMessage{
+			Type: "message_duplicate",
+			Data: gin.H{
+				"conversation_id":   req.ConversationID,
+				"client_message_id": duplicate.ClientMessageID,
+				"message_id":        duplicate.MessageID,
+			},
+			Timestamp: time.Now().UnixMilli(),
+		}))
 		return
 	}
 
-	title, ok := data["title"].(string)
-	if !ok {
+	var rateLimited *llm.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		// Already reported to the project as a rate_limited event
+		return
+	}
+
+	var code string
+	switch {
+	case errors.Is(err, chat.ErrStreamAlreadyActive):
+		code = apierror.CodeStreamAlreadyActive
+	case errors.Is(err, chat.ErrQueueTimeout):
+		code = apierror.CodeQueueTimeout
+	case errors.Is(err, chat.ErrQueueFull):
+		code = apierror.CodeQueueFull
+	case errors.Is(err, chat.ErrConversationNotFound):
+		code = apierror.CodeConversationNotFound
+	case errors.Is(err, chat.ErrNotInterrupted):
+		code = apierror.CodeNotInterrupted
+	default:
+		conn.sendRequestError(requestID, apierror.CodeMessageProcessingFailed, map[string]interface{}{"conversation_id": req.ConversationID, "error": err.Error()})
+		return
+	}
+
+	conn.sendRequestError(requestID, code, map[string]interface{}{"conversation_id": req.ConversationID, "client_message_id": req.ClientMessageID})
+}
+
+// handleCreateConversation creates a new conversation
+func (h *Handler) handleCreateConversation(conn *Connection, req *CreateConversationRequest) {
+	title := req.Title
+	if title == "" {
 		title = "New Conversation" // Default title
 	}
 
-	// Check if an initial message is included
-	initialMessage, hasInitialMessage := data["initial_message"].(string)
+	// A template is rendered into the initial message before anything is
+	// created, so a missing variable leaves no empty conversation behind
+	initialMessage := req.InitialMessage
+	if req.TemplateID != "" {
+		rendered, ok := h.renderTemplate(conn, req.TemplateID, req.Variables)
+		if !ok {
+			return
+		}
+		initialMessage = rendered
+	}
+
+	// Check if an initial message is included; it is checked like any user message
+	if initialMessage != "" {
+		var err error
+		initialMessage, err = h.messagePolicy.Prepare(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, conn.UserID, conn.ProjectID, initialMessage)
+		if err != nil {
+			conn.sendError(chat.ContentErrorCode(err, "create_conversation", "initial_message"))
+			return
+		}
+	}
 
 	if h.chatService != nil {
+		// Model overrides must be on the client's allowlist, and an initial message
+		// needs a usable LLM; both are checked before the conversation is created
+		settings := req.settings()
+		var clientConfig *ClientConfig
+		if req.hasOverrides() || initialMessage != "" {
+			var err error
+			clientConfig, err = h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID)
+			if err != nil {
+				log.Printf("Failed to get client LLM config: %v", err)
+				h.sendLLMConfigError(conn, "", err)
+				return
+			}
+		}
+		if req.hasOverrides() {
+			if err := clientConfig.ValidateModelSettings(settings); err != nil {
+				code, details := chat.SettingsErrorCode(err, settings)
+				conn.sendError(code, details)
+				return
+			}
+		}
+
 		// Use actual chat service
-		conversation, err := h.chatService.CreateConversation(conn.UserID, conn.ProjectID, title)
+		conversation, err := h.chatService.CreateConversation(conn.UserID, conn.ProjectID, title, settings)
 		if err != nil {
 			log.Printf("Error creating conversation: %v", err)
-			errorResponse := WebSocketMessage{
-				Type: "error",
-				Data: ErrorData{
-					Error:   "Failed to create conversation",
-					Details: map[string]interface{}{"error": err.Error()},
-				},
-				Timestamp: time.Now().UnixMilli(),
-			}
-			h.hub.SendToConnection(conn, errorResponse)
+			h.sendErrorResponse(conn, "", apierror.CodeSaveFailed, err.Error())
 			return
 		}
 
+		if h.events != nil {
+			h.events.Publish(webhooks.NewEvent(webhooks.EventConversationCreated, conn.ClientID, conn.ProjectID, map[string]interface{}{
+				"conversation_id": conversation.ID,
+				"user_id":         conn.UserID,
+				"title":           conversation.Title,
+			}))
+		}
+		h.activity.Record(activity.Event{
+			ProjectID:   conn.ProjectID,
+			ActorUserID: conn.UserID,
+			EventType:   activity.EventConversationCreated,
+			EntityType:  activity.EntityConversation,
+			EntityID:    conversation.ID,
+			Payload:     map[string]interface{}{"title": conversation.Title},
+		})
+
 		// Send success response matching AsyncAPI spec
 		h.hub.SendToConnection(conn, WebSocketMessage{
 			Type: "conversation_created",
@@ -479,15 +621,7 @@ func (h *Handler) handleCreateConversation(conn *Connection, message *WebSocketM
 		})
 
 		// If there's an initial message, process it
-		if hasInitialMessage && initialMessage != "" {
-			// Get client-specific LLM configuration
-			clientConfig, err := h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID)
-			if err != nil {
-				log.Printf("Failed to get client LLM config: %v", err)
-				h.sendErrorResponse(conn, conversation.ID, "Failed to load LLM configuration", err.Error())
-				return
-			}
-
+		if initialMessage != "" {
 			// Create chat request for the initial message
 			chatReq := &chat.ChatRequest{
 				ConversationID: conversation.ID,
@@ -495,18 +629,18 @@ func (h *Handler) handleCreateConversation(conn *Connection, message *WebSocketM
 				ProjectID:      conn.ProjectID,
 				Content:        initialMessage,
 				ConnectionID:   conn.ID,
+				ClientID:       conn.ClientID,
 				AddTokensFunc:  conn.AddTokens, // Token tracking function
 				Connection:     conn,           // Connection reference for token info
+
+				MaxConcurrentStreams: clientConfig.MaxConcurrentStreams,
+				FlushPolicy:          clientConfig.FlushPolicy,
+				ToolResultTokenLimit: clientConfig.ToolResultTokenLimit,
 			}
 
 			// Process through ChatService with client-specific LLM
 			chatServiceWithClientLLM := h.chatService.WithLLMClient(clientConfig.LLMClient)
-			
-			err = chatServiceWithClientLLM.ProcessUserMessage(chatReq)
-			if err != nil {
-				log.Printf("Error processing initial message: %v", err)
-				h.sendErrorResponse(conn, conversation.ID, "Failed to process initial message", err.Error())
-			}
+			h.processChatRequest(conn, chatReq, chatServiceWithClientLLM.ProcessUserMessage)
 		}
 	} else {
 		// Fallback for when chat service is not initialized
@@ -530,7 +664,7 @@ func (h *Handler) handleCreateConversation(conn *Connection, message *WebSocketM
 		})
 
 		// If there's an initial message, send a simple response
-		if hasInitialMessage && initialMessage != "" {
+		if initialMessage != "" {
 			response := messages.WebSocketMessage{
 				Type: "assistant_response",
 				Data: AssistantResponseData{
@@ -557,21 +691,13 @@ func formatAsyncAPIMessage(messageType string, data interface{}) WebSocketMessag
 }
 
 // handleGetConversations retrieves all conversations for a project
-func (h *Handler) handleGetConversations(conn *Connection, message *WebSocketMessage) {
+func (h *Handler) handleGetConversations(conn *Connection) {
 	if h.chatService != nil {
 		// Use actual chat service
 		conversations, err := h.chatService.GetConversations(conn.UserID, conn.ProjectID)
 		if err != nil {
 			log.Printf("Error getting conversations: %v", err)
-			errorResponse := WebSocketMessage{
-				Type: "error",
-				Data: ErrorData{
-					Error:   "Failed to get conversations",
-					Details: map[string]interface{}{"error": err.Error()},
-				},
-				Timestamp: time.Now().UnixMilli(),
-			}
-			h.hub.SendToConnection(conn, errorResponse)
+			h.sendErrorResponse(conn, "", apierror.CodeDatabaseError, err.Error())
 			return
 		}
 
@@ -660,34 +786,22 @@ func (h *Handler) handleToolExecutionFailed(conn *Connection, toolName, toolCall
 	})
 }
 
-// handleGetConversation retrieves a specific conversation with messages
-func (h *Handler) handleGetConversation(conn *Connection, message *WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid get_conversation data format")
-		return
-	}
-
-	conversationID, ok := data["conversation_id"].(string)
-	if !ok {
-		log.Printf("Missing conversation_id in get_conversation")
-		return
-	}
+// handleGetConversation retrieves a specific conversation with the newest page of its messages
+func (h *Handler) handleGetConversation(conn *Connection, req *ConversationPageRequest) {
+	conversationID := req.ConversationID
 
 	if h.chatService != nil {
+		// The client's LLM resolves the model the conversation replies with
+		service := h.chatService
+		if clientConfig, err := h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID); err == nil {
+			service = service.WithLLMClient(clientConfig.LLMClient)
+		}
+
 		// Use actual chat service
-		conversation, err := h.chatService.GetConversation(conversationID, conn.UserID)
+		conversation, err := service.GetConversationPage(conversationID, conn.UserID, req.page())
 		if err != nil {
 			log.Printf("Error getting conversation: %v", err)
-			errorResponse := WebSocketMessage{
-				Type: "error",
-				Data: ErrorData{
-					Error:   "Failed to get conversation",
-					Details: map[string]interface{}{"error": err.Error()},
-				},
-				Timestamp: time.Now().UnixMilli(),
-			}
-			h.hub.SendToConnection(conn, errorResponse)
+			h.sendErrorResponse(conn, conversationID, conversationPageErrorCode(err), err.Error())
 			return
 		}
 
@@ -695,7 +809,9 @@ func (h *Handler) handleGetConversation(conn *Connection, message *WebSocketMess
 		h.hub.SendToConnection(conn, WebSocketMessage{
 			Type: "conversation_details",
 			Data: ConversationDetailsData{
-				Conversation: convertConversationDetails(conversation),
+				Conversation:      convertConversationDetails(conversation),
+				HasMore:           conversation.HasMore,
+				TotalMessageCount: conversation.TotalMessageCount,
 			},
 			Timestamp: time.Now().UnixMilli(),
 		})
@@ -730,52 +846,93 @@ func (h *Handler) handleGetConversation(conn *Connection, message *WebSocketMess
 		h.hub.SendToConnection(conn, WebSocketMessage{
 			Type: "conversation_details",
 			Data: ConversationDetailsData{
-				Conversation: conversation,
+				Conversation:      conversation,
+				TotalMessageCount: len(conversation.Messages),
 			},
 			Timestamp: time.Now().UnixMilli(),
 		})
 	}
 }
 
-// handleDeleteConversation deletes a conversation
-func (h *Handler) handleDeleteConversation(conn *Connection, message *WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid delete_conversation data format")
+// handleGetConversationMessages sends a page of older messages, for clients
+// loading history as the user scrolls back
+func (h *Handler) handleGetConversationMessages(conn *Connection, req *ConversationPageRequest) {
+	if h.chatService == nil {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeDatabaseError, "chat service is not available")
 		return
 	}
 
-	conversationID, ok := data["conversation_id"].(string)
-	if !ok {
-		log.Printf("Missing conversation_id in delete_conversation")
+	details, err := h.chatService.GetConversationPage(req.ConversationID, conn.UserID, req.page())
+	if err != nil {
+		log.Printf("Error getting conversation messages: %v", err)
+		h.sendErrorResponse(conn, req.ConversationID, conversationPageErrorCode(err), err.Error())
 		return
 	}
 
+	h.hub.SendToConnection(conn, WebSocketMessage{
+		Type: "conversation_messages",
+		Data: ConversationMessagesData{
+			ConversationID:    req.ConversationID,
+			BeforeMessageID:   req.BeforeMessageID,
+			Messages:          convertMessages(details.Messages),
+			HasMore:           details.HasMore,
+			TotalMessageCount: details.TotalMessageCount,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// conversationPageErrorCode maps a GetConversationPage error to its apierror code
+func conversationPageErrorCode(err error) string {
+	switch {
+	case errors.Is(err, chat.ErrConversationNotFound):
+		return apierror.CodeConversationNotFound
+	case errors.Is(err, chat.ErrMessageNotFound):
+		return apierror.CodeMessageNotFound
+	default:
+		return apierror.CodeDatabaseError
+	}
+}
+
+// handleDeleteConversation soft-deletes a conversation; it can be restored until purged
+func (h *Handler) handleDeleteConversation(conn *Connection, req *ConversationRequest) {
+	conversationID := req.ConversationID
+
 	if h.chatService != nil {
 		// Use actual chat service
 		err := h.chatService.DeleteConversation(conversationID, conn.UserID)
 		if err != nil {
 			log.Printf("Error deleting conversation: %v", err)
-			errorResponse := WebSocketMessage{
-				Type: "error",
-				Data: ErrorData{
-					Error:   "Failed to delete conversation",
-					Details: map[string]interface{}{"error": err.Error()},
-				},
-				Timestamp: time.Now().UnixMilli(),
+			code := apierror.CodeSaveFailed
+			if errors.Is(err, chat.ErrConversationNotFound) {
+				code = apierror.CodeConversationNotFound
+			} else if errors.Is(err, chat.ErrNotConversationOwner) {
+				code = apierror.CodeConversationOwnerOnly
 			}
-			h.hub.SendToConnection(conn, errorResponse)
+			h.sendErrorResponse(conn, conversationID, code, err.Error())
 			return
 		}
 
-		// Send success response matching AsyncAPI spec
-		h.hub.SendToConnection(conn, WebSocketMessage{
+		// Deletion is a soft delete; notify every connection of this user in the project
+		// so other tabs drop the conversation too
+		deleted := WebSocketMessage{
 			Type: "conversation_deleted",
 			Data: gin.H{
 				"conversation_id": conversationID,
 				"success":         true,
+				"soft":            true,
 			},
 			Timestamp: time.Now().UnixMilli(),
+		}
+		if h.hub.SendToUser(conn.ProjectID, conn.UserID, deleted) == 0 {
+			h.hub.SendToConnection(conn, deleted)
+		}
+		h.activity.Record(activity.Event{
+			ProjectID:   conn.ProjectID,
+			ActorUserID: conn.UserID,
+			EventType:   activity.EventConversationDeleted,
+			EntityType:  activity.EntityConversation,
+			EntityID:    conversationID,
 		})
 	} else {
 		// Fallback for when chat service is not initialized
@@ -791,13 +948,445 @@ func (h *Handler) handleDeleteConversation(conn *Connection, message *WebSocketM
 	}
 }
 
-// handleGetConversationStatus handles get_conversation_status messages
-func (h *Handler) handleGetConversationStatus(conn *Connection, message *WebSocketMessage) {
-	conversationID, ok := message.Data.(map[string]interface{})["conversation_id"].(string)
+// handlePinConversation pins or unpins a conversation and sends conversation_updated
+// to all of the user's connections in the project so other tabs re-sort their lists
+func (h *Handler) handlePinConversation(conn *Connection, req *PinConversationRequest) {
+	conversation, err := chat.SetConversationPinned(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.ConversationID, *req.Pinned, req.Version)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeConversationNotFound, "")
+		return
+	}
+	var conflict *chat.VersionConflictError
+	if errors.As(err, &conflict) {
+		conn.sendError(ErrCodeVersionConflict, map[string]interface{}{"conversation_id": req.ConversationID, "current": conflict.Current})
+		return
+	}
+	if errors.Is(err, chat.ErrPinLimitReached) {
+		conn.sendError(ErrCodePinLimitReached, map[string]interface{}{"conversation_id": req.ConversationID, "limit": chat.MaxPinnedConversations})
+		return
+	}
+	if err != nil {
+		log.Printf("Error pinning conversation: %v", err)
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeSaveFailed, "")
+		return
+	}
+
+	if BroadcastConversationUpdated(h.hub, conversation) == 0 {
+		h.hub.SendToConnection(conn, conversationUpdatedMessage(conversation))
+	}
+	h.activity.Record(activity.Event{
+		ProjectID:   conversation.ProjectID,
+		ActorUserID: conn.UserID,
+		EventType:   activity.PinEventType(conversation.Pinned),
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title},
+	})
+}
+
+// handleSetLanguage pins the language replies of a conversation are given in and
+// sends conversation_updated to all of the user's connections in the project
+func (h *Handler) handleSetLanguage(conn *Connection, req *SetLanguageRequest) {
+	conversation, err := chat.SetConversationLanguage(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.ConversationID, *req.Language, req.Version)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeConversationNotFound, "")
+		return
+	}
+	var conflict *chat.VersionConflictError
+	if errors.As(err, &conflict) {
+		conn.sendError(ErrCodeVersionConflict, map[string]interface{}{"conversation_id": req.ConversationID, "current": conflict.Current})
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting conversation language: %v", err)
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeSaveFailed, "")
+		return
+	}
+
+	if BroadcastConversationUpdated(h.hub, conversation) == 0 {
+		h.hub.SendToConnection(conn, conversationUpdatedMessage(conversation))
+	}
+}
+
+// handleSetDraftsEnabled turns draft mode on or off for a conversation and
+// sends conversation_updated to all of the user's connections in the project
+func (h *Handler) handleSetDraftsEnabled(conn *Connection, req *SetDraftsEnabledRequest) {
+	conversation, err := chat.SetConversationDraftsEnabled(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.ConversationID, *req.DraftsEnabled, req.Version)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeConversationNotFound, "")
+		return
+	}
+	if errors.Is(err, chat.ErrEditorRequired) {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeForbidden, "")
+		return
+	}
+	var conflict *chat.VersionConflictError
+	if errors.As(err, &conflict) {
+		conn.sendError(ErrCodeVersionConflict, map[string]interface{}{"conversation_id": req.ConversationID, "current": conflict.Current})
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting conversation draft mode: %v", err)
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeSaveFailed, "")
+		return
+	}
+
+	if BroadcastConversationUpdated(h.hub, conversation) == 0 {
+		h.hub.SendToConnection(conn, conversationUpdatedMessage(conversation))
+	}
+}
+
+// handleApproveDraft makes a draft reply part of its conversation, with the
+// client's LLM so the approved reply is indexed for search. The project
+// receives draft_approved with the final message.
+func (h *Handler) handleApproveDraft(conn *Connection, req *ApproveDraftRequest) {
+	service, ok := h.draftService(conn)
 	if !ok {
-		log.Printf("conversation_id is required for get_conversation_status")
 		return
 	}
+	_, err := service.ApproveDraft(context.Background(), conn.UserID, req.MessageID, req.Content)
+	h.sendDraftError(conn, req.MessageID, err)
+}
+
+// handleDiscardDraft deletes a draft reply; the project receives draft_discarded
+func (h *Handler) handleDiscardDraft(conn *Connection, req *DiscardDraftRequest) {
+	service, ok := h.draftService(conn)
+	if !ok {
+		return
+	}
+	_, err := service.DiscardDraft(context.Background(), conn.UserID, req.MessageID)
+	h.sendDraftError(conn, req.MessageID, err)
+}
+
+// draftService returns the chat service drafts are managed with, bound to the
+// client's LLM when its config loads
+func (h *Handler) draftService(conn *Connection) (chat.ChatService, bool) {
+	if h.chatService == nil {
+		conn.sendError(apierror.CodeMessageProcessingFailed, map[string]interface{}{"error": "chat service is not available"})
+		return nil, false
+	}
+	if h.clientConfigCache != nil {
+		if clientConfig, err := h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID); err == nil {
+			return h.chatService.WithLLMClient(clientConfig.LLMClient), true
+		}
+	}
+	return h.chatService, true
+}
+
+// sendDraftError reports a failed approve_draft or discard_draft to the sender
+func (h *Handler) sendDraftError(conn *Connection, messageID string, err error) {
+	details := map[string]interface{}{"message_id": messageID}
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, chat.ErrDraftNotFound):
+		conn.sendError(apierror.CodeDraftNotFound, details)
+	case errors.Is(err, chat.ErrEditorRequired):
+		conn.sendError(apierror.CodeForbidden, details)
+	default:
+		log.Printf("Error managing draft %s: %v", messageID, err)
+		conn.sendError(apierror.CodeSaveFailed, details)
+	}
+}
+
+// handleResumeStream replays the assistant_response content a reconnecting client
+// missed after last_seq and re-attaches the connection to the stream
+func (h *Handler) handleResumeStream(conn *Connection, req *ResumeStreamRequest) {
+	if h.chatService == nil {
+		return
+	}
+
+	resume, err := h.chatService.ResumeStream(req.ConversationID, conn.UserID, conn.ID, *req.LastSeq)
+	if err != nil {
+		code := ErrCodeStreamNotFound
+		if errors.Is(err, chat.ErrStreamSeqAhead) {
+			code = ErrCodeStreamSeqInvalid
+		}
+		conn.sendError(code, map[string]interface{}{"conversation_id": req.ConversationID, "last_seq": *req.LastSeq})
+		return
+	}
+
+	h.hub.SendToConnection(conn, resume.Message())
+}
+
+// handleResumeConversation generates the reply of an interrupted conversation
+// again with the client's LLM. The partial reply is continued or regenerated
+// under its own message_id, so clients replace its content in place.
+func (h *Handler) handleResumeConversation(conn *Connection, req *ResumeConversationRequest) {
+	if h.chatService == nil {
+		h.sendErrorResponse(conn, req.ConversationID, apierror.CodeMessageProcessingFailed, "chat service is not available")
+		return
+	}
+
+	clientConfig, err := h.clientConfigCache.GetClientConfig(context.Background(), conn.ClientID)
+	if err != nil {
+		h.sendLLMConfigError(conn, req.ConversationID, err)
+		return
+	}
+
+	chatReq := &chat.ChatRequest{
+		ConversationID: req.ConversationID,
+		UserID:         conn.UserID,
+		ProjectID:      conn.ProjectID,
+		ConnectionID:   conn.ID,
+		ClientID:       conn.ClientID,
+		AddTokensFunc:  conn.AddTokens,
+		Connection:     conn,
+
+		MaxConcurrentStreams: clientConfig.MaxConcurrentStreams,
+		FlushPolicy:          clientConfig.FlushPolicy,
+		ToolResultTokenLimit: clientConfig.ToolResultTokenLimit,
+	}
+	h.processChatRequest(conn, chatReq, h.chatService.WithLLMClient(clientConfig.LLMClient).ResumeConversation)
+}
+
+// handleAddParticipant lets the owner of a conversation add another user of the
+// client. The owner's connections and the added user's connections in the
+// project receive participant_added.
+func (h *Handler) handleAddParticipant(conn *Connection, req *AddParticipantRequest) {
+	participant, err := chat.AddParticipant(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.ConversationID, req.UserID)
+	if err != nil {
+		code := chat.ParticipantErrorCode(err)
+		if code == apierror.CodeDatabaseError {
+			log.Printf("Error adding participant to conversation %s: %v", req.ConversationID, err)
+		}
+		conn.sendError(code, map[string]interface{}{"conversation_id": req.ConversationID, "user_id": req.UserID})
+		return
+	}
+
+	added := WebSocketMessage{
+		Type:      "participant_added",
+		Data:      participant,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if h.hub.SendToUser(conn.ProjectID, conn.UserID, added) == 0 {
+		h.hub.SendToConnection(conn, added)
+	}
+	h.hub.SendToUser(conn.ProjectID, participant.UserID, added)
+	h.activity.Record(activity.Event{
+		ProjectID:   conn.ProjectID,
+		ActorUserID: conn.UserID,
+		EventType:   activity.EventParticipantAdded,
+		EntityType:  activity.EntityConversation,
+		EntityID:    participant.ConversationID,
+		Payload:     map[string]interface{}{"user_id": participant.UserID, "username": participant.Username},
+	})
+}
+
+// handleForkConversation copies a conversation the user takes part in into a new
+// one they own and sends conversation_created to their connections in the project
+func (h *Handler) handleForkConversation(conn *Connection, req *ForkConversationRequest) {
+	conversation, err := chat.ForkConversation(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.ConversationID, req.AtMessageID, h.maxForkMessages)
+	if err != nil {
+		code, details := chat.ForkErrorCode(err)
+		if code == apierror.CodeDatabaseError {
+			log.Printf("Error forking conversation %s: %v", req.ConversationID, err)
+		}
+		if details == nil {
+			details = map[string]interface{}{}
+		}
+		details["conversation_id"] = req.ConversationID
+		details["at_message_id"] = req.AtMessageID
+		conn.sendError(code, details)
+		return
+	}
+
+	if h.events != nil {
+		h.events.Publish(webhooks.NewEvent(webhooks.EventConversationCreated, conn.ClientID, conversation.ProjectID, map[string]interface{}{
+			"conversation_id":             conversation.ID,
+			"user_id":                     conn.UserID,
+			"title":                       conversation.Title,
+			"forked_from_conversation_id": req.ConversationID,
+		}))
+	}
+	h.activity.Record(activity.Event{
+		ProjectID:   conversation.ProjectID,
+		ActorUserID: conn.UserID,
+		EventType:   activity.EventConversationCreated,
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title, "forked_from_conversation_id": req.ConversationID},
+	})
+
+	created := WebSocketMessage{
+		Type: "conversation_created",
+		Data: ConversationCreatedData{
+			Conversation: convertConversation(conversation),
+			Success:      true,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if h.hub.SendToUser(conversation.ProjectID, conn.UserID, created) == 0 {
+		h.hub.SendToConnection(conn, created)
+	}
+}
+
+// projectRole returns the connection user's role in its current project,
+// sending the error when it is below viewer
+func (h *Handler) projectRole(conn *Connection) (tools.ProjectRole, bool) {
+	if conn.ProjectID == "" {
+		conn.sendError(apierror.CodeNotInProject, nil)
+		return tools.RoleNone, false
+	}
+	role, err := tools.NewDBPermissionChecker(&tools.ZlayDBAdapter{DB: h.db}).GetProjectRole(context.Background(), conn.UserID, conn.ProjectID)
+	if err != nil {
+		log.Printf("Failed to load project role: %v", err)
+		conn.sendError(apierror.CodeDatabaseError, nil)
+		return tools.RoleNone, false
+	}
+	if role < tools.RoleViewer {
+		conn.sendError(apierror.CodeProjectNotFound, nil)
+		return role, false
+	}
+	return role, true
+}
+
+// handleExecuteTool runs a tool of the connection's project for the user
+// without the LLM and sends tool_run_result to the connection
+func (h *Handler) handleExecuteTool(conn *Connection, req *ExecuteToolRequest) {
+	if _, ok := h.projectRole(conn); !ok {
+		return
+	}
+	if h.toolRegistry == nil {
+		conn.sendError(apierror.CodeToolUnavailable, map[string]interface{}{"tool": req.Tool})
+		return
+	}
+
+	run, err := chat.RunTool(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, h.toolRegistry, chat.ToolRunRequest{
+		UserID:         conn.UserID,
+		ClientID:       conn.ClientID,
+		ProjectID:      conn.ProjectID,
+		ToolName:       req.Tool,
+		Params:         req.Params,
+		ConversationID: req.ConversationID,
+		Force:          req.Force,
+	})
+	if err != nil {
+		code, details := chat.ToolRunErrorCode(err, req.Tool)
+		if code == apierror.CodeDatabaseError {
+			log.Printf("Error running tool %s: %v", req.Tool, err)
+		}
+		conn.sendError(code, details)
+		return
+	}
+	if run.ConversationID != "" {
+		// Replies must see the result message RunTool added
+		h.historyCache.Invalidate(run.ConversationID)
+	}
+
+	h.hub.SendToConnection(conn, WebSocketMessage{
+		Type:      "tool_run_result",
+		Data:      run,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// BroadcastConversationUpdated sends conversation_updated to every connection the
+// conversation's owner has open in its project and returns how many received it
+func BroadcastConversationUpdated(hub *Hub, conversation *chat.Conversation) int {
+	return hub.SendToUser(conversation.ProjectID, conversation.UserID, conversationUpdatedMessage(conversation))
+}
+
+func conversationUpdatedMessage(conversation *chat.Conversation) WebSocketMessage {
+	return WebSocketMessage{
+		Type:      "conversation_updated",
+		Data:      gin.H{"conversation": convertConversation(conversation)},
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// handleMessageFeedback records a thumbs up/down on a message and broadcasts
+// message_feedback_updated to the project room so dashboards update live
+func (h *Handler) handleMessageFeedback(conn *Connection, req *MessageFeedbackRequest) {
+	feedback, err := chat.SaveMessageFeedback(context.Background(), &tools.ZlayDBAdapter{DB: h.db},
+		conn.UserID, conn.ClientID, req.MessageID, *req.Rating, req.Comment)
+	if errors.Is(err, chat.ErrMessageNotFound) {
+		h.sendErrorResponse(conn, "", apierror.CodeMessageNotFound, "")
+		return
+	}
+	if errors.Is(err, chat.ErrInvalidFeedback) {
+		h.sendErrorResponse(conn, "", apierror.CodeInvalidFeedback, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving message feedback: %v", err)
+		h.sendErrorResponse(conn, "", apierror.CodeSaveFailed, "")
+		return
+	}
+
+	BroadcastFeedback(h.hub, feedback)
+}
+
+// BroadcastFeedback sends message_feedback_updated to the feedback's project room
+func BroadcastFeedback(hub *Hub, feedback *chat.MessageFeedback) {
+	hub.BroadcastToProject(feedback.ProjectID, WebSocketMessage{
+		Type:      "message_feedback_updated",
+		Data:      feedback,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// BroadcastToolRerun sends tool_execution_completed for a tool call re-run
+// through the HTTP API to the conversation's project room
+func BroadcastToolRerun(hub *Hub, projectID, conversationID string, call *chat.ToolCall, rerun chat.ToolCallRerun) {
+	hub.BroadcastToProject(projectID, WebSocketMessage{
+		Type: "tool_execution_completed",
+		Data: ToolExecutionCompletedData{
+			ToolName:        call.Function.Name,
+			ToolCallID:      call.ID,
+			ConversationID:  conversationID,
+			Success:         rerun.Status == "completed",
+			Result:          rerun.Result,
+			ExecutionTimeMs: rerun.DurationMs,
+			Rerun:           true,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// handleExportConversation replies with a signed download link for a conversation
+func (h *Handler) handleExportConversation(conn *Connection, req *ExportConversationRequest) {
+	conversationID := req.ConversationID
+	format := req.Format
+	if format == "" {
+		format = export.FormatJSON
+	}
+
+	if h.exportSigner == nil {
+		h.sendErrorResponse(conn, conversationID, apierror.CodeExportUnavailable, "")
+		return
+	}
+
+	// Only the conversation owner may export it
+	row, err := h.db.QueryRow(context.Background(),
+		"SELECT id FROM conversations WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL",
+		conversationID, conn.UserID)
+	if err != nil || len(row.Values) == 0 {
+		h.sendErrorResponse(conn, conversationID, apierror.CodeConversationNotFound, "")
+		return
+	}
+
+	token, expiresAt := h.exportSigner.Sign(conversationID, conn.UserID, format)
+	h.hub.SendToConnection(conn, WebSocketMessage{
+		Type: "conversation_export_ready",
+		Data: gin.H{
+			"conversation_id": conversationID,
+			"format":          format,
+			"url":             export.DownloadPath(conversationID, format, token),
+			"expires_at":      expiresAt.UnixMilli(),
+		},
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// handleGetConversationStatus handles get_conversation_status messages
+func (h *Handler) handleGetConversationStatus(conn *Connection, req *ConversationRequest) {
+	conversationID := req.ConversationID
 
 	userID := conn.UserID
 	if userID == "" {
@@ -845,7 +1434,7 @@ func (h *Handler) handleGetConversationStatus(conn *Connection, message *WebSock
 }
 
 // handleGetAllConversationStatuses handles get_all_conversation_statuses messages
-func (h *Handler) handleGetAllConversationStatuses(conn *Connection, message *WebSocketMessage) {
+func (h *Handler) handleGetAllConversationStatuses(conn *Connection) {
 	userID := conn.UserID
 	if userID == "" {
 		log.Printf("user_id is required for get_all_conversation_statuses")
@@ -859,7 +1448,7 @@ func (h *Handler) handleGetAllConversationStatuses(conn *Connection, message *We
 		allStreams := h.chatService.GetAllActiveStreams()
 		
 		// Filter streams for this user only
-		userStreams := make(map[string]*chat.StreamState)
+		userStreams := make(map[string]chat.StreamStateSnapshot)
 		for convID, streamState := range allStreams {
 			if streamState.UserID == userID {
 				userStreams[convID] = streamState
@@ -892,12 +1481,8 @@ func (h *Handler) handleGetAllConversationStatuses(conn *Connection, message *We
 }
 
 // handleGetStreamingConversation handles get_streaming_conversation messages
-func (h *Handler) handleGetStreamingConversation(conn *Connection, message *WebSocketMessage) {
-	conversationID, ok := message.Data.(map[string]interface{})["conversation_id"].(string)
-	if !ok {
-		log.Printf("conversation_id is required for get_streaming_conversation")
-		return
-	}
+func (h *Handler) handleGetStreamingConversation(conn *Connection, req *ConversationRequest) {
+	conversationID := req.ConversationID
 
 	userID := conn.UserID
 	if userID == "" {
@@ -999,16 +1584,11 @@ func getCurrentTimestamp() int64 {
 }
 
 // handleChatInterrupted processes chat interruption events
-func (h *Handler) handleChatInterrupted(conn *Connection, message *WebSocketMessage) {
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid chat_interrupted data format")
-		return
-	}
-
-	userID, _ := data["user_id"].(string)
-	projectID, _ := data["project_id"].(string)
-	reason, _ := data["reason"].(string)
+func (h *Handler) handleChatInterrupted(conn *Connection, req *ChatInterruptedRequest) {
+	// The connection's identity is authoritative; a client cannot interrupt another user's chats
+	userID := conn.UserID
+	projectID := conn.ProjectID
+	reason := req.Reason
 
 	log.Printf("🔌 Chat interrupted: user=%s, project=%s, reason=%s", userID, projectID, reason)
 
@@ -1021,6 +1601,9 @@ func (h *Handler) handleChatInterrupted(conn *Connection, message *WebSocketMess
 				if conv.Status == "processing" {
 					log.Printf("🔌 Marking conversation as interrupted: %s", conv.ID)
 					h.chatService.UpdateConversationStatus(conv.ID, userID, "interrupted")
+					if h.toolRegistry != nil {
+						h.toolRegistry.CancelConversationTools(conv.ID)
+					}
 					
 					// Broadcast status update to all connections
 					h.hub.BroadcastToProject(projectID, WebSocketMessage{
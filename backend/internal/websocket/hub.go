@@ -1,17 +1,21 @@
 package websocket
 
 import (
-	"bytes"
-	"compress/flate"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	
+	"zlay-backend/internal/apierror"
 	"zlay-backend/internal/chat"
 	"zlay-backend/internal/messages"
 	
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // WebSocketMessage represents a message sent over WebSocket (alias for shared package)
@@ -27,14 +31,22 @@ type UserMessageData struct {
 	ClientID       string `json:"client_id,omitempty"`
 }
 
-// AssistantResponseData represents data for assistant_response type
+// AssistantResponseData represents data for assistant_response type. The chat
+// service streams the same shape; frames sent to stream_delta clients carry
+// delta instead of content, and resumed replays set from_seq and resumed.
 type AssistantResponseData struct {
-	ConversationID string      `json:"conversation_id"`
-	Content        string      `json:"content"`
-	MessageID      string      `json:"message_id"`
-	Timestamp      string      `json:"timestamp"`
-	Done           bool        `json:"done"`
-	ToolCalls      []ToolCall  `json:"tool_calls,omitempty"`
+	ConversationID  string      `json:"conversation_id"`
+	Content         string      `json:"content,omitempty"`
+	Delta           string      `json:"delta,omitempty"`
+	Seq             int64       `json:"seq,omitempty"`
+	FromSeq         int64       `json:"from_seq,omitempty"`
+	Resumed         bool        `json:"resumed,omitempty"`
+	MessageID       string      `json:"message_id"`
+	Message         interface{} `json:"message,omitempty"` // The assistant message, on the first frame
+	Timestamp       interface{} `json:"timestamp"`         // Unix milliseconds, or RFC 3339 on the final frame
+	Done            bool        `json:"done"`
+	TokensEstimated bool        `json:"tokens_estimated,omitempty"`
+	ToolCalls       []ToolCall  `json:"tool_calls,omitempty"`
 }
 
 // ToolCall represents a tool call in the assistant response
@@ -72,7 +84,19 @@ type ConversationsListData struct {
 
 // ConversationDetailsData represents data for conversation_details type
 type ConversationDetailsData struct {
-	Conversation ConversationWithMessages `json:"conversation"`
+	Conversation      ConversationWithMessages `json:"conversation"`
+	HasMore           bool                     `json:"has_more"`            // Older messages can be loaded with get_conversation_messages
+	TotalMessageCount int                      `json:"total_message_count"` // Every message of the conversation, of any role
+}
+
+// ConversationMessagesData represents data for conversation_messages type: a
+// page of older messages, oldest first
+type ConversationMessagesData struct {
+	ConversationID    string    `json:"conversation_id"`
+	BeforeMessageID   string    `json:"before_message_id,omitempty"`
+	Messages          []Message `json:"messages"`
+	HasMore           bool      `json:"has_more"`
+	TotalMessageCount int       `json:"total_message_count"`
 }
 
 // ConversationWithMessages represents a conversation with its messages
@@ -87,9 +111,16 @@ type Conversation struct {
 	Title     string    `json:"title"`
 	UserID    string    `json:"user_id"`
 	ProjectID string    `json:"project_id"`
-	Status    string    `json:"status"` // processing, completed, interrupted
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Status    string     `json:"status"` // processing, completed, interrupted
+	Pinned    bool       `json:"pinned"`
+	PinnedAt  *time.Time `json:"pinned_at,omitempty"`
+	ForkedFromConversationID *string `json:"forked_from_conversation_id,omitempty"` // Set on forks
+	Language *string `json:"language,omitempty"` // Language code responses are pinned to
+	// User and assistant messages, and the first 120 characters of the latest one with content
+	MessageCount       int    `json:"message_count"`
+	LastMessagePreview string `json:"last_message_preview"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
 }
 
 // Message represents a chat message
@@ -100,13 +131,21 @@ type Message struct {
 	CreatedAt time.Time              `json:"created_at"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 	ToolCalls []ToolCall             `json:"tool_calls,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"` // Sender of a user message
 }
 
 // ErrorData represents data for error type
 type ErrorData struct {
-	Error   string                 `json:"error"`
-	Code    string                 `json:"code,omitempty"`
-	Details map[string]interface{} `json:"details,omitempty"`
+	Error     string                 `json:"error"`
+	Code      string                 `json:"code,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"` // of the request that failed, for error reports
+}
+
+// newErrorData builds an error payload from the apierror catalog in lang
+func newErrorData(lang, code string, details map[string]interface{}) ErrorData {
+	apiErr := apierror.New(code, lang, details)
+	return ErrorData{Error: apiErr.Message, Code: apiErr.Code, Details: apiErr.Details}
 }
 
 // PongData represents data for pong type
@@ -132,6 +171,8 @@ type ToolExecutionCompletedData struct {
 	Success          bool        `json:"success"`
 	Result           interface{} `json:"result"`
 	ExecutionTimeMs  int         `json:"execution_time_ms,omitempty"`
+	Rerun            bool        `json:"rerun,omitempty"`     // Set when the call was re-run through the HTTP API
+	ResultID         string      `json:"result_id,omitempty"` // Set when result is a ResultPreview of an oversized result
 }
 
 // ToolExecutionFailedData represents data for tool_execution_failed type
@@ -143,14 +184,38 @@ type ToolExecutionFailedData struct {
 	ErrorCode      string `json:"error_code,omitempty"`
 }
 
+// DefaultPresenceDebounce is how long presence changes in a room are collected
+// before a presence_update is broadcast
+const DefaultPresenceDebounce = 500 * time.Millisecond
+
+// PresenceData represents data for presence_update type
+type PresenceData struct {
+	ProjectID   string         `json:"project_id"`
+	UserIDs     []string       `json:"user_ids"`
+	Connections map[string]int `json:"connections"` // Open connections per user
+}
+
 // Hub maintains the set of active connections and broadcasts messages to them
 type Hub struct {
 	// Registered connections
 	connections map[*Connection]bool
 
+	// Registered connections by ID and by user, kept in step with connections
+	connectionsByID   map[string]*Connection
+	connectionsByUser map[string]map[*Connection]bool
+
 	// Project-based rooms for isolation
 	projects map[string]map[*Connection]bool
 
+	// Connections per user in each project room, kept in step with projects
+	projectUsers map[string]map[string]int
+
+	// Presence bookkeeping, only touched by Run: rooms with unannounced
+	// changes and the last snapshot announced to each room
+	presenceDirty    map[string]bool
+	presenceSent     map[string]string
+	presenceDebounce time.Duration
+
 	// Inbound messages from the connections
 	broadcast chan []byte
 
@@ -164,10 +229,24 @@ type Hub struct {
 	projectJoin  chan *ProjectJoin
 	projectLeave chan *ProjectLeave
 
+	// Outbound frame limits, see outbound.go
+	maxMessageBytes  int
+	compressMinBytes int
+	oversized        *OversizedResultStore
+
+	// Retransmission of unacknowledged critical frames, see acks.go
+	ackTimeout     time.Duration
+	ackMaxAttempts int
+
+	// How long a connection may stay congested before it is dropped, see congestion.go
+	congestionWindow time.Duration
+
 	// Chat service handler reference
 	handler interface{}
 	// Mutex for thread-safe operations
 	mutex sync.RWMutex
+	// Set while Run is looping, reported by readiness checks
+	running atomic.Bool
 }
 
 // ProjectJoin represents a connection joining a project room
@@ -187,21 +266,59 @@ func NewHub() *Hub {
 	return &Hub{
 		connections:  make(map[*Connection]bool),
 		projects:     make(map[string]map[*Connection]bool),
+		projectUsers: make(map[string]map[string]int),
 		broadcast:    make(chan []byte),
 		register:     make(chan *Connection),
 		unregister:   make(chan *Connection),
 		projectJoin:  make(chan *ProjectJoin),
 		projectLeave: make(chan *ProjectLeave),
+
+		connectionsByID:   make(map[string]*Connection),
+		connectionsByUser: make(map[string]map[*Connection]bool),
+
+		presenceDirty:    make(map[string]bool),
+		presenceSent:     make(map[string]string),
+		presenceDebounce: DefaultPresenceDebounce,
+
+		maxMessageBytes:  DefaultMaxMessageBytes,
+		compressMinBytes: DefaultCompressMinBytes,
+		oversized:        NewOversizedResultStore(DefaultOversizedResultTTL, DefaultOversizedResultBudget),
+
+		ackTimeout:     DefaultAckTimeout,
+		ackMaxAttempts: DefaultAckMaxAttempts,
+
+		congestionWindow: DefaultCongestionWindow,
 	}
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
+	h.running.Store(true)
+	defer h.running.Store(false)
+
+	// Fires once per debounce window while rooms have presence changes
+	var presenceFlush <-chan time.Time
+	markPresence := func(projectID string) {
+		h.presenceDirty[projectID] = true
+		if presenceFlush == nil {
+			presenceFlush = time.After(h.presenceDebounce)
+		}
+	}
+
+	// Looks for critical frames whose ack is overdue
+	ackTicker := time.NewTicker(h.ackCheckInterval())
+	defer ackTicker.Stop()
+
 	for {
 		select {
 		case conn := <-h.register:
 			h.mutex.Lock()
 			h.connections[conn] = true
+			h.connectionsByID[conn.ID] = conn
+			if h.connectionsByUser[conn.UserID] == nil {
+				h.connectionsByUser[conn.UserID] = make(map[*Connection]bool)
+			}
+			h.connectionsByUser[conn.UserID][conn] = true
 			h.mutex.Unlock()
 			log.Printf("Connection registered: %s", conn.ID)
 
@@ -213,7 +330,7 @@ func (h *Hub) Run() {
 			
 			h.mutex.Lock()
 			if _, ok := h.connections[conn]; ok {
-				delete(h.connections, conn)
+				h.removeConnection(conn)
 
 				// Remove from all project rooms
 				for projectID, conns := range h.projects {
@@ -222,10 +339,14 @@ func (h *Hub) Run() {
 						if len(conns) == 0 {
 							delete(h.projects, projectID)
 						}
+						h.removeProjectUser(projectID, conn.UserID)
+						markPresence(projectID)
 					}
 				}
 
-				// 🔄 NEW: Detach from all active streams
+				// 🔄 NEW: Detach from all active streams; replies to the connection's
+				// messages keep generating only while another connection receives them
+				conn.cancel()
 				if h.handler != nil {
 					if chatHandler, ok := h.handler.(*Handler); ok && chatHandler.chatService != nil {
 						allStreams := chatHandler.chatService.GetAllActiveStreams()
@@ -237,6 +358,10 @@ func (h *Hub) Run() {
 					}
 				}
 
+				if dropped := conn.acks.clear(); dropped > 0 {
+					log.Printf("Dropped %d unacknowledged frames of connection %s", dropped, conn.ID)
+				}
+
 				// Mark as unregistered and close send channel safely
 				conn.shouldUnregister()
 				conn.closeSendChannel()
@@ -249,7 +374,14 @@ func (h *Hub) Run() {
 			if h.projects[join.ProjectID] == nil {
 				h.projects[join.ProjectID] = make(map[*Connection]bool)
 			}
-			h.projects[join.ProjectID][join.Connection] = true
+			if !h.projects[join.ProjectID][join.Connection] {
+				h.projects[join.ProjectID][join.Connection] = true
+				if h.projectUsers[join.ProjectID] == nil {
+					h.projectUsers[join.ProjectID] = make(map[string]int)
+				}
+				h.projectUsers[join.ProjectID][join.Connection.UserID]++
+				markPresence(join.ProjectID)
+			}
 			h.mutex.Unlock()
 			log.Printf("Connection %s joined project %s", join.Connection.ID, join.ProjectID)
 
@@ -258,9 +390,9 @@ func (h *Hub) Run() {
 				if chatHandler, ok := h.handler.(*Handler); ok && chatHandler.chatService != nil {
 					allStreams := chatHandler.chatService.GetAllActiveStreams()
 					for conversationID, streamState := range allStreams {
-						// Only attach if this stream belongs to the same project and user
-						if streamState.ProjectID == join.ProjectID && streamState.UserID == join.Connection.UserID {
-							if err := chatHandler.chatService.AttachConnectionToStream(conversationID, join.Connection.ID); err == nil {
+						// Only attach if this stream belongs to the same project and the user takes part in it
+						if streamState.ProjectID == join.ProjectID && streamState.HasParticipant(join.Connection.UserID) {
+							if err := chatHandler.chatService.AttachConnectionToStream(conversationID, join.Connection.ID, join.Connection.UserID); err == nil {
 								log.Printf("Attached new connection %s to active stream %s", join.Connection.ID, conversationID)
 							}
 						}
@@ -270,31 +402,118 @@ func (h *Hub) Run() {
 
 		case leave := <-h.projectLeave:
 			h.mutex.Lock()
-			if conns, exists := h.projects[leave.ProjectID]; exists {
+			if conns, exists := h.projects[leave.ProjectID]; exists && conns[leave.Connection] {
 				delete(conns, leave.Connection)
 				if len(conns) == 0 {
 					delete(h.projects, leave.ProjectID)
 				}
+				h.removeProjectUser(leave.ProjectID, leave.Connection.UserID)
+				markPresence(leave.ProjectID)
 			}
 			h.mutex.Unlock()
 			log.Printf("Connection %s left project %s", leave.Connection.ID, leave.ProjectID)
 
 		case message := <-h.broadcast:
-			h.mutex.RLock()
-			for conn := range h.connections {
-				select {
-				case conn.send <- message:
-				default:
-					// Connection send buffer is full, skip this connection
-					conn.closeSendChannel()
-					delete(h.connections, conn)
+			for conn := range h.GetConnections() {
+				if !h.queueFrame(conn, message) {
+					h.dropCongested(conn)
 				}
 			}
-			h.mutex.RUnlock()
+
+		case <-presenceFlush:
+			presenceFlush = nil
+			h.flushPresence()
+
+		case now := <-ackTicker.C:
+			h.retransmitPending(now)
+		}
+	}
+}
+
+// removeConnection drops a connection from the registered set and its indexes; callers hold the lock
+func (h *Hub) removeConnection(conn *Connection) {
+	delete(h.connections, conn)
+	if h.connectionsByID[conn.ID] == conn {
+		delete(h.connectionsByID, conn.ID)
+	}
+	if conns := h.connectionsByUser[conn.UserID]; conns != nil {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.connectionsByUser, conn.UserID)
 		}
 	}
 }
 
+// removeProjectUser drops one of a user's connections from the presence index; callers hold the lock
+func (h *Hub) removeProjectUser(projectID, userID string) {
+	users := h.projectUsers[projectID]
+	if users == nil {
+		return
+	}
+	users[userID]--
+	if users[userID] <= 0 {
+		delete(users, userID)
+	}
+	if len(users) == 0 {
+		delete(h.projectUsers, projectID)
+	}
+}
+
+// flushPresence announces the rooms whose presence changed since the last
+// announcement. A user who drops and reconnects within the debounce window
+// leaves the snapshot unchanged, so nothing is sent.
+func (h *Hub) flushPresence() {
+	for projectID := range h.presenceDirty {
+		delete(h.presenceDirty, projectID)
+
+		snapshot := h.PresenceSnapshot(projectID)
+		if len(snapshot.UserIDs) == 0 {
+			// Nobody is left to tell
+			delete(h.presenceSent, projectID)
+			continue
+		}
+
+		key := presenceKey(snapshot)
+		if h.presenceSent[projectID] == key {
+			continue
+		}
+		h.presenceSent[projectID] = key
+
+		h.BroadcastToProject(projectID, WebSocketMessage{
+			Type:      "presence_update",
+			Data:      snapshot,
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+}
+
+// PresenceSnapshot returns the distinct users connected to a project room, sorted by ID
+func (h *Hub) PresenceSnapshot(projectID string) PresenceData {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	snapshot := PresenceData{
+		ProjectID:   projectID,
+		UserIDs:     []string{},
+		Connections: make(map[string]int),
+	}
+	for userID, count := range h.projectUsers[projectID] {
+		snapshot.UserIDs = append(snapshot.UserIDs, userID)
+		snapshot.Connections[userID] = count
+	}
+	sort.Strings(snapshot.UserIDs)
+	return snapshot
+}
+
+// presenceKey identifies a snapshot so unchanged rooms are not re-announced
+func presenceKey(snapshot PresenceData) string {
+	var key strings.Builder
+	for _, userID := range snapshot.UserIDs {
+		fmt.Fprintf(&key, "%s:%d,", userID, snapshot.Connections[userID])
+	}
+	return key.String()
+}
+
 // BroadcastToProject sends a message to all connections in a project room
 func (h *Hub) BroadcastToProject(projectID string, message interface{}) {
 	data, err := json.Marshal(message)
@@ -303,43 +522,97 @@ func (h *Hub) BroadcastToProject(projectID string, message interface{}) {
 		return
 	}
 
-	// Send uncompressed data - WebSocket compression is handled by upgrader
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
-	if conns, exists := h.projects[projectID]; exists {
-		for conn := range conns {
-			select {
-			case conn.send <- data:
-			default:
-				// Connection send buffer is full
-				conn.closeSendChannel()
-				delete(conns, conn)
-				delete(h.connections, conn)
+	_, perRecipient := message.(messages.RecipientMessage)
+	if stamped, ok := message.(messages.RequestMessage); ok {
+		_, perRecipient = stamped.Message.(messages.RecipientMessage)
+	}
+	frameID := uuid.NewString()
+	data = stampFrame(h.limitFrame(projectID, data), frameID)
+	messageType := frameType(message)
+
+	// Compression is applied per frame by WritePump
+	for _, conn := range h.GetProjectConnections(projectID) {
+		payload := data
+		if perRecipient {
+			if payload, err = encodeFor(conn, message); err != nil {
+				log.Printf("Error marshaling message: %v", err)
+				continue
 			}
+			payload = stampFrame(h.limitFrame(projectID, payload), frameID)
 		}
+		if tracksAcks(conn, messageType) {
+			h.sendTracked(conn, messageType, payload)
+			continue
+		}
+		if !h.queueFrame(conn, payload) {
+			h.dropCongested(conn)
+		}
+	}
+}
+
+// encodeFor marshals a message for one connection, letting a messages.RecipientMessage
+// adapt its payload to the capabilities the connection negotiated
+func encodeFor(conn *Connection, message interface{}) ([]byte, error) {
+	if recipientMessage, ok := message.(messages.RecipientMessage); ok {
+		message = recipientMessage.ForRecipient(conn.HasCapability)
 	}
+	return json.Marshal(message)
+}
+
+// stampFrame gives an encoded frame its frame_id
+func stampFrame(payload []byte, frameID string) []byte {
+	return messages.AddEnvelopeField(payload, "frame_id", frameID)
 }
 
-// SendToConnection sends a message to a specific connection
+// SendToConnection sends a message to a specific connection. Messages not
+// already stamped with a request carry the ID of the connection's upgrade request.
 func (h *Hub) SendToConnection(conn *Connection, message interface{}) {
-	data, err := json.Marshal(message)
+	if _, stamped := message.(messages.RequestMessage); !stamped && conn.RequestID != "" {
+		message = messages.RequestMessage{Message: message, RequestID: conn.RequestID}
+	}
+	data, err := encodeFor(conn, message)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
 		return
 	}
 
-	// Send uncompressed data - WebSocket compression is handled by the upgrader
-	select {
-	case conn.send <- data:
-	default:
-		// Connection send buffer is full
-		conn.closeSendChannel()
-		h.mutex.Lock()
-		delete(h.connections, conn)
-		h.mutex.Unlock()
-		log.Printf("Connection %s removed due to full send buffer", conn.ID)
+	data = stampFrame(h.limitFrame(conn.ProjectID, data), uuid.NewString())
+
+	// Replies can outlive the connection they were requested on
+	if atomic.LoadInt32(&conn.closed) == 1 {
+		return
 	}
+	if messageType := frameType(message); tracksAcks(conn, messageType) {
+		h.sendTracked(conn, messageType, data)
+		return
+	}
+
+	// Compression is applied per frame by WritePump
+	if !h.queueFrame(conn, data) {
+		h.dropCongested(conn)
+	}
+}
+
+// SendToConnectionID sends a message to the connection with the given ID; it returns false if the connection is gone
+func (h *Hub) SendToConnectionID(connectionID string, message interface{}) bool {
+	conn := h.GetConnectionByID(connectionID)
+	if conn == nil {
+		return false
+	}
+	h.SendToConnection(conn, message)
+	return true
+}
+
+// SendToUser sends a message to every connection a user has open in a project and returns how many received it
+func (h *Hub) SendToUser(projectID, userID string, message interface{}) int {
+	sent := 0
+	for _, conn := range h.GetProjectConnections(projectID) {
+		if conn.UserID == userID {
+			h.SendToConnection(conn, message)
+			sent++
+		}
+	}
+	return sent
 }
 
 // GetProjectConnectionCount returns the number of connections in a project room
@@ -361,6 +634,11 @@ func (h *Hub) GetConnectionCount() int {
 	return len(h.connections)
 }
 
+// IsRunning reports whether the hub's main loop is running
+func (h *Hub) IsRunning() bool {
+	return h.running.Load()
+}
+
 // GetConnections returns a copy of all active connections
 func (h *Hub) GetConnections() map[*Connection]bool {
 	h.mutex.RLock()
@@ -382,6 +660,12 @@ func convertConversation(conv *chat.Conversation) Conversation {
 		UserID:    conv.UserID,
 		ProjectID: conv.ProjectID,
 		Status:    conv.Status,
+		Pinned:    conv.Pinned,
+		PinnedAt:  conv.PinnedAt,
+		ForkedFromConversationID: conv.ForkedFromConversationID,
+		Language:                 conv.Language,
+		MessageCount:       conv.MessageCount,
+		LastMessagePreview: conv.LastMessagePreview,
 		CreatedAt: conv.CreatedAt,
 		UpdatedAt: conv.UpdatedAt,
 	}
@@ -405,6 +689,7 @@ func convertMessage(msg *chat.Message) Message {
 		CreatedAt: msg.CreatedAt,
 		Metadata:  msg.Metadata,
 		ToolCalls: convertToolCalls(msg.ToolCalls),
+		UserID:    msg.UserID,
 	}
 }
 
@@ -429,46 +714,43 @@ func convertToolCalls(toolCalls []chat.ToolCall) []ToolCall {
 
 // Convert chat conversation details to websocket format
 func convertConversationDetails(details *chat.ConversationDetails) ConversationWithMessages {
+	conversation := convertConversation(details.Conversation)
+	// A single page of history cannot be summarized; the stored summary is used instead
+	if !details.HasMore {
+		conversation.MessageCount, conversation.LastMessagePreview = summarizeMessages(details.Messages)
+	}
 	return ConversationWithMessages{
-		Conversation: convertConversation(details.Conversation),
-		Messages: func() []Message {
-			result := make([]Message, len(details.Messages))
-			for i, msg := range details.Messages {
-				result[i] = convertMessage(msg)
-			}
-			return result
-		}(),
+		Conversation: conversation,
+		Messages:     convertMessages(details.Messages),
 	}
 }
 
-// compressMessage compresses JSON data for WebSocket transmission
-func compressMessage(data interface{}) ([]byte, error) {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
-
-	var buf bytes.Buffer
-	writer, err := flate.NewWriter(&buf, flate.BestCompression)
-	if err != nil {
-		return nil, err
+// convertMessages converts chat messages to websocket messages
+func convertMessages(messages []*chat.Message) []Message {
+	result := make([]Message, len(messages))
+	for i, msg := range messages {
+		result[i] = convertMessage(msg)
 	}
+	return result
+}
 
-	if _, err := writer.Write(jsonData); err != nil {
-		return nil, err
+// summarizeMessages computes message_count and last_message_preview the way
+// chat.ConversationSummaryColumns does, for messages oldest first
+func summarizeMessages(messages []*chat.Message) (int, string) {
+	count, preview := 0, ""
+	for _, msg := range messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		count++
+		if msg.Content != "" {
+			preview = msg.Content
+		}
 	}
-
-	if err := writer.Close(); err != nil {
-		return nil, err
+	if runes := []rune(preview); len(runes) > 120 {
+		preview = string(runes[:120])
 	}
-
-	return buf.Bytes(), nil
-}
-
-// shouldCompressMessage determines if a message should be compressed
-func shouldCompressMessage(data []byte) bool {
-	// Compress messages larger than 1KB
-	return len(data) > 1024
+	return count, preview
 }
 
 // GetProjectConnections returns a copy of connections in a project room
@@ -489,13 +771,61 @@ func (h *Hub) GetProjectConnections(projectID string) []*Connection {
 func (h *Hub) GetConnectionByID(connectionID string) *Connection {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
-	
+
+	return h.connectionsByID[connectionID]
+}
+
+// GetUserConnections returns a copy of the connections a user has open, in any project
+func (h *Hub) GetUserConnections(userID string) []*Connection {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	connections := make([]*Connection, 0, len(h.connectionsByUser[userID]))
+	for conn := range h.connectionsByUser[userID] {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// ConnectionInfo describes an open connection for the admin connections endpoint
+type ConnectionInfo struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	ClientID       string    `json:"client_id"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	ImpersonatedBy string    `json:"impersonated_by,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	ConnectedSince time.Time `json:"connected_since"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// ListConnections describes every registered connection, oldest first
+func (h *Hub) ListConnections() []ConnectionInfo {
+	h.mutex.RLock()
+	connections := make([]ConnectionInfo, 0, len(h.connections))
 	for conn := range h.connections {
-		if conn.ID == connectionID {
-			return conn
-		}
+		connections = append(connections, ConnectionInfo{
+			ID:             conn.ID,
+			UserID:         conn.UserID,
+			ClientID:       conn.ClientID,
+			ProjectID:      conn.ProjectID,
+			ImpersonatedBy: conn.ImpersonatedBy,
+			IP:             conn.RemoteIP,
+			UserAgent:      conn.UserAgent,
+			ConnectedSince: conn.ConnectedAt,
+			LastSeen:       conn.LastSeen(),
+		})
 	}
-	return nil
+	h.mutex.RUnlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		if !connections[i].ConnectedSince.Equal(connections[j].ConnectedSince) {
+			return connections[i].ConnectedSince.Before(connections[j].ConnectedSince)
+		}
+		return connections[i].ID < connections[j].ID
+	})
+	return connections
 }
 
 // handleInterruptionForConnection checks if user has active streaming and marks as interrupted
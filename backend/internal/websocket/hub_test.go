@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testPresenceDebounce = 30 * time.Millisecond
+
+func newPresenceTestHub(t *testing.T) *Hub {
+	t.Helper()
+
+	hub := NewHub()
+	hub.presenceDebounce = testPresenceDebounce
+	go hub.Run()
+	return hub
+}
+
+func joinRoom(hub *Hub, userID, projectID string) *Connection {
+	conn := NewConnection(nil, userID, "client-1", hub)
+	hub.register <- conn
+	conn.ProjectID = projectID
+	hub.projectJoin <- &ProjectJoin{Connection: conn, ProjectID: projectID}
+	return conn
+}
+
+// nextPresence returns the next presence_update sent to conn, or nil if none arrives in time
+func nextPresence(t *testing.T, conn *Connection, wait time.Duration) *PresenceData {
+	t.Helper()
+
+	deadline := time.After(wait)
+	for {
+		select {
+		case data := <-conn.send:
+			var message struct {
+				Type string       `json:"type"`
+				Data PresenceData `json:"data"`
+			}
+			if err := json.Unmarshal(data, &message); err != nil {
+				t.Fatalf("Invalid message: %v", err)
+			}
+			if message.Type == "presence_update" {
+				return &message.Data
+			}
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+func TestPresenceUpdatesAreDebounced(t *testing.T) {
+	hub := newPresenceTestHub(t)
+	observer := joinRoom(hub, "user-a", "project-1")
+
+	presence := nextPresence(t, observer, time.Second)
+	if presence == nil || len(presence.UserIDs) != 1 || presence.UserIDs[0] != "user-a" {
+		t.Fatalf("Expected user-a alone, got %+v", presence)
+	}
+
+	// Two connections joining together are announced once
+	first := joinRoom(hub, "user-b", "project-1")
+	second := joinRoom(hub, "user-b", "project-1")
+	presence = nextPresence(t, observer, time.Second)
+	if presence == nil || len(presence.UserIDs) != 2 || presence.Connections["user-b"] != 2 {
+		t.Fatalf("Expected user-b with two connections, got %+v", presence)
+	}
+	if extra := nextPresence(t, observer, 3*testPresenceDebounce); extra != nil {
+		t.Fatalf("Expected a single event for both joins, got another: %+v", extra)
+	}
+
+	// A connection that drops and comes straight back changes nothing
+	hub.projectLeave <- &ProjectLeave{Connection: second, ProjectID: "project-1"}
+	hub.projectJoin <- &ProjectJoin{Connection: second, ProjectID: "project-1"}
+	if flapped := nextPresence(t, observer, 3*testPresenceDebounce); flapped != nil {
+		t.Fatalf("Expected no event for a flapping connection, got %+v", flapped)
+	}
+
+	// Unregistering drops the connection from every room
+	hub.unregister <- first
+	hub.unregister <- second
+	presence = nextPresence(t, observer, time.Second)
+	if presence == nil || len(presence.UserIDs) != 1 || presence.Connections["user-b"] != 0 {
+		t.Fatalf("Expected user-b to be gone, got %+v", presence)
+	}
+
+	// Other rooms are unaffected
+	if snapshot := hub.PresenceSnapshot("project-2"); len(snapshot.UserIDs) != 0 {
+		t.Errorf("Expected an empty room, got %+v", snapshot)
+	}
+}
+
+func TestGetPresenceReturnsSnapshot(t *testing.T) {
+	hub := newPresenceTestHub(t)
+	conn := joinRoom(hub, "user-a", "project-1")
+	joinRoom(hub, "user-b", "project-1")
+	nextPresence(t, conn, time.Second)
+
+	conn.handleGetPresence()
+	presence := nextPresence(t, conn, time.Second)
+	if presence == nil || presence.ProjectID != "project-1" || len(presence.UserIDs) != 2 ||
+		presence.UserIDs[0] != "user-a" || presence.Connections["user-b"] != 1 {
+		t.Fatalf("Unexpected snapshot: %+v", presence)
+	}
+}
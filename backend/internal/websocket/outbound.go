@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Outbound frame limits
+const (
+	// DefaultMaxMessageBytes is the largest frame sent as is; larger frames carrying
+	// a tool result have the result replaced by a preview
+	DefaultMaxMessageBytes = 256 * 1024
+	// DefaultCompressMinBytes is the smallest frame compressed with permessage-deflate
+	DefaultCompressMinBytes = 1024
+	// DefaultOversizedResultTTL is how long withheld results stay retrievable
+	DefaultOversizedResultTTL = 15 * time.Minute
+	// DefaultOversizedResultBudget caps the bytes of withheld results kept in memory
+	DefaultOversizedResultBudget = 256 * 1024 * 1024
+
+	// resultPreviewBytes is how much of a withheld result's JSON is sent as its preview
+	resultPreviewBytes = 4 * 1024
+)
+
+// ResultPreview replaces a tool result too large to send over WebSocket. The
+// full result is served by GET /api/tool-results/:result_id until it expires.
+type ResultPreview struct {
+	Truncated bool   `json:"truncated"`
+	ResultID  string `json:"result_id"`
+	SizeBytes int    `json:"size_bytes"`
+	Preview   string `json:"preview"` // Leading bytes of the result's JSON
+}
+
+// OversizedResult is a withheld tool result and the project room it was sent to
+type OversizedResult struct {
+	ID        string
+	ProjectID string
+	Payload   json.RawMessage
+	StoredAt  time.Time
+}
+
+// OversizedResultStore keeps withheld tool results in memory for a while. The
+// oldest results are dropped once the byte budget is exceeded.
+type OversizedResultStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	budget  int
+	used    int
+	entries map[string]*OversizedResult
+	order   []string // Oldest first
+	now     func() time.Time
+}
+
+// NewOversizedResultStore creates a store keeping results for ttl within budget bytes
+func NewOversizedResultStore(ttl time.Duration, budget int) *OversizedResultStore {
+	return &OversizedResultStore{
+		ttl:     ttl,
+		budget:  budget,
+		entries: make(map[string]*OversizedResult),
+		now:     time.Now,
+	}
+}
+
+// Put stores a result sent to a project room and returns its ID
+func (s *OversizedResultStore) Put(projectID string, payload []byte) string {
+	id := newResultID()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+	s.entries[id] = &OversizedResult{
+		ID:        id,
+		ProjectID: projectID,
+		Payload:   append(json.RawMessage(nil), payload...),
+		StoredAt:  s.now(),
+	}
+	s.order = append(s.order, id)
+	s.used += len(payload)
+	for s.used > s.budget && len(s.order) > 1 {
+		s.drop()
+	}
+	return id
+}
+
+// Get returns a stored result that has not expired
+func (s *OversizedResultStore) Get(id string) (*OversizedResult, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+	result, exists := s.entries[id]
+	return result, exists
+}
+
+// expire drops results older than the TTL; callers hold the lock
+func (s *OversizedResultStore) expire() {
+	for len(s.order) > 0 && s.now().Sub(s.entries[s.order[0]].StoredAt) > s.ttl {
+		s.drop()
+	}
+}
+
+// drop removes the oldest result; callers hold the lock
+func (s *OversizedResultStore) drop() {
+	id := s.order[0]
+	s.order = s.order[1:]
+	s.used -= len(s.entries[id].Payload)
+	delete(s.entries, id)
+}
+
+func newResultID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetOutboundLimits sets the largest frame sent with its tool result and the
+// smallest frame compressed, zero or less disabling either. Call it before Run.
+func (h *Hub) SetOutboundLimits(maxMessageBytes, compressMinBytes int) {
+	h.maxMessageBytes = maxMessageBytes
+	h.compressMinBytes = compressMinBytes
+}
+
+// OversizedResults returns the store serving tool results withheld from oversized frames
+func (h *Hub) OversizedResults() *OversizedResultStore {
+	return h.oversized
+}
+
+// shouldCompress reports whether a frame is large enough to be worth compressing
+func (h *Hub) shouldCompress(size int) bool {
+	return h.compressMinBytes > 0 && size >= h.compressMinBytes
+}
+
+// limitFrame replaces the data.result of a frame larger than the limit with a
+// ResultPreview and keeps the full result for GET /api/tool-results/:id. Frames
+// without a result are sent unchanged.
+func (h *Hub) limitFrame(projectID string, payload []byte) []byte {
+	if h.maxMessageBytes <= 0 || len(payload) <= h.maxMessageBytes || h.oversized == nil {
+		return payload
+	}
+
+	var frame map[string]json.RawMessage
+	var data map[string]json.RawMessage
+	if json.Unmarshal(payload, &frame) != nil || json.Unmarshal(frame["data"], &data) != nil {
+		return payload
+	}
+	result, exists := data["result"]
+	if !exists {
+		log.Printf("Sending %d byte %s frame without a result to withhold", len(payload), frame["type"])
+		return payload
+	}
+
+	id := h.oversized.Put(projectID, result)
+	preview, err := json.Marshal(ResultPreview{
+		Truncated: true,
+		ResultID:  id,
+		SizeBytes: len(result),
+		Preview:   truncateUTF8(result, resultPreviewBytes),
+	})
+	if err != nil {
+		return payload
+	}
+	data["result"] = preview
+	data["result_id"], _ = json.Marshal(id)
+	if frame["data"], err = json.Marshal(data); err != nil {
+		return payload
+	}
+	limited, err := json.Marshal(frame)
+	if err != nil {
+		return payload
+	}
+	log.Printf("Withheld %d byte result %s from %s frame", len(result), id, frame["type"])
+	return limited
+}
+
+// truncateUTF8 returns at most limit bytes of b without splitting a character
+func truncateUTF8(b []byte, limit int) string {
+	if len(b) <= limit {
+		return string(b)
+	}
+	b = b[:limit]
+	for len(b) > 0 && !utf8.Valid(b) {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextFrame returns the next frame sent to conn of the given type
+func nextFrame(t *testing.T, conn *Connection, messageType string) []byte {
+	t.Helper()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case data := <-conn.send:
+			var message struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(data, &message); err != nil {
+				t.Fatalf("Invalid message: %v", err)
+			}
+			if message.Type == messageType {
+				return data
+			}
+		case <-deadline:
+			t.Fatalf("Expected a %s frame", messageType)
+			return nil
+		}
+	}
+}
+
+func TestOversizedToolResultIsReplacedByPreview(t *testing.T) {
+	hub := NewHub()
+	hub.SetOutboundLimits(16*1024, DefaultCompressMinBytes)
+	go hub.Run()
+	conn := joinRoom(hub, "user-a", "project-1")
+	for hub.GetProjectConnectionCount("project-1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rows := make([]map[string]interface{}, 2000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"id": i, "name": strings.Repeat("é", 20)}
+	}
+	result := map[string]interface{}{"status": "completed", "data": map[string]interface{}{"rows": rows}}
+	fullResult, _ := json.Marshal(result)
+	hub.BroadcastToProject("project-1", WebSocketMessage{
+		Type: "tool_execution_completed",
+		Data: ToolExecutionCompletedData{
+			ToolName:       "database_query",
+			ToolCallID:     "call-1",
+			ConversationID: "conversation-1",
+			Success:        true,
+			Result:         result,
+		},
+	})
+
+	frame := nextFrame(t, conn, "tool_execution_completed")
+	if len(frame) > 16*1024 {
+		t.Fatalf("Expected the frame to fit the limit, got %d bytes", len(frame))
+	}
+	var message struct {
+		Data struct {
+			ToolCallID string        `json:"tool_call_id"`
+			ResultID   string        `json:"result_id"`
+			Result     ResultPreview `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(frame, &message); err != nil {
+		t.Fatalf("Invalid frame: %v", err)
+	}
+	preview := message.Data.Result
+	if message.Data.ToolCallID != "call-1" || !preview.Truncated || preview.ResultID == "" || preview.ResultID != message.Data.ResultID {
+		t.Fatalf("Expected a preview referencing the result, got %+v", message.Data)
+	}
+	if preview.SizeBytes != len(fullResult) || !strings.HasPrefix(string(fullResult), preview.Preview) || len(preview.Preview) > resultPreviewBytes {
+		t.Errorf("Expected the leading bytes of the result, got %d of %d", len(preview.Preview), preview.SizeBytes)
+	}
+
+	stored, exists := hub.OversizedResults().Get(preview.ResultID)
+	if !exists || stored.ProjectID != "project-1" {
+		t.Fatalf("Expected the full result to be retrievable, got %+v", stored)
+	}
+	if !bytes.Equal(stored.Payload, fullResult) {
+		t.Errorf("Expected the stored result to match the original")
+	}
+}
+
+func TestSmallFramesAreSentUnchanged(t *testing.T) {
+	hub := NewHub()
+	hub.SetOutboundLimits(1024, DefaultCompressMinBytes)
+	conn := NewConnection(nil, "user-a", "client-1", hub)
+	conn.ProjectID = "project-1"
+
+	hub.SendToConnection(conn, WebSocketMessage{Type: "tool_execution_completed", Data: ToolExecutionCompletedData{Result: "ok"}})
+	if frame := <-conn.send; strings.Contains(string(frame), "result_id") {
+		t.Errorf("Expected a small frame to be sent as is, got %s", frame)
+	}
+
+	// Large frames without a result cannot be shortened and are sent whole
+	large := WebSocketMessage{Type: "assistant_response", Data: map[string]string{"content": strings.Repeat("x", 2048)}}
+	hub.SendToConnection(conn, large)
+	if frame := <-conn.send; len(frame) < 2048 || strings.Contains(string(frame), "result_id") {
+		t.Errorf("Expected the frame to be sent whole, got %d bytes", len(frame))
+	}
+
+	if hub.shouldCompress(DefaultCompressMinBytes-1) || !hub.shouldCompress(DefaultCompressMinBytes) {
+		t.Errorf("Expected compression from %d bytes", DefaultCompressMinBytes)
+	}
+}
+
+func TestOversizedResultStoreExpiresAndEvicts(t *testing.T) {
+	store := NewOversizedResultStore(time.Minute, 10)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	first := store.Put("project-1", []byte(`"12345678"`))
+	second := store.Put("project-1", []byte(`"1234"`))
+	if _, exists := store.Get(first); exists {
+		t.Errorf("Expected the oldest result to be evicted over budget")
+	}
+	if _, exists := store.Get(second); !exists {
+		t.Fatalf("Expected the newest result to be kept")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, exists := store.Get(second); exists {
+		t.Errorf("Expected the result to expire")
+	}
+}
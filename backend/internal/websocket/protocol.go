@@ -0,0 +1,510 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/langdetect"
+	"zlay-backend/internal/messages"
+)
+
+const (
+	// ProtocolVersion is the WebSocket protocol version spoken by this server
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest client protocol version still accepted
+	MinProtocolVersion = 1
+
+	// CloseUnsupportedProtocol is the close code sent after a protocol_error
+	CloseUnsupportedProtocol = 4001
+	// CloseForceDisconnect is the close code sent after a force_disconnect
+	CloseForceDisconnect = 4002
+)
+
+// supportedCapabilities lists the client capabilities this server can honour
+var supportedCapabilities = map[string]bool{
+	messages.CapabilityStreamDelta: true,
+	messages.CapabilityAcks:        true,
+}
+
+// Error codes carried in ErrorData.Code for protocol failures
+const (
+	ErrCodeInvalidMessage      = apierror.CodeInvalidMessage
+	ErrCodeUnsupportedProtocol = apierror.CodeUnsupportedProtocol
+)
+
+// ErrCodeAPIKeyForbidden is sent when a project API key tries to join another project
+const ErrCodeAPIKeyForbidden = apierror.CodeAPIKeyForbidden
+
+// ErrCodePinLimitReached is sent when pin_conversation would exceed chat.MaxPinnedConversations
+const ErrCodePinLimitReached = apierror.CodePinLimitReached
+
+// ErrCodeVersionConflict is sent when pin_conversation, set_language or
+// set_drafts_enabled names a version the conversation has moved past; details
+// carry the current one
+const ErrCodeVersionConflict = apierror.CodeVersionConflict
+
+// Error codes sent when resume_stream cannot replay; the client reloads the conversation instead
+const (
+	ErrCodeStreamNotFound   = apierror.CodeStreamNotFound
+	ErrCodeStreamSeqInvalid = apierror.CodeStreamSeqInvalid
+)
+
+// ValidationError names the field that made an incoming message invalid
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// messageRequest is implemented by the typed payload of every client message
+type messageRequest interface {
+	validate() error
+}
+
+// ConnectionEstablishedRequest is the client handshake; a missing
+// protocol_version is treated as version 1
+type ConnectionEstablishedRequest struct {
+	ProtocolVersion *int     `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"` // Optional features the client supports, e.g. stream_delta
+}
+
+func (r *ConnectionEstablishedRequest) validate() error { return nil }
+
+// Version returns the protocol version the client announced
+func (r *ConnectionEstablishedRequest) Version() int {
+	if r.ProtocolVersion == nil {
+		return 1
+	}
+	return *r.ProtocolVersion
+}
+
+// NegotiatedCapabilities returns the announced capabilities the server supports, without duplicates
+func (r *ConnectionEstablishedRequest) NegotiatedCapabilities() []string {
+	negotiated := []string{}
+	seen := make(map[string]bool)
+	for _, capability := range r.Capabilities {
+		if supportedCapabilities[capability] && !seen[capability] {
+			seen[capability] = true
+			negotiated = append(negotiated, capability)
+		}
+	}
+	return negotiated
+}
+
+// UserMessageRequest is the payload of user_message
+type UserMessageRequest struct {
+	ConversationID  string `json:"conversation_id"`
+	Content         string `json:"content"`
+	ClientMessageID string `json:"client_message_id"` // Optional; makes resends after a reconnect idempotent
+}
+
+func (r *UserMessageRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	return requireString("content", r.Content)
+}
+
+// ProjectRequest is the payload of join_project and leave_project
+type ProjectRequest struct {
+	ProjectID string `json:"project_id"`
+}
+
+func (r *ProjectRequest) validate() error {
+	return requireString("project_id", r.ProjectID)
+}
+
+// CreateConversationRequest is the payload of create_conversation. Model,
+// temperature and max_tokens override the client's LLM settings for the
+// conversation and are checked against its model allowlist. A template_id
+// renders one of the project's prompt templates with variables and uses the
+// result as the initial message.
+type CreateConversationRequest struct {
+	Title          string            `json:"title"`
+	InitialMessage string            `json:"initial_message"`
+	TemplateID     string            `json:"template_id,omitempty"`
+	Variables      map[string]string `json:"variables,omitempty"`
+	Model          *string           `json:"model,omitempty"`
+	Temperature    *float64          `json:"temperature,omitempty"`
+	MaxTokens      *int              `json:"max_tokens,omitempty"`
+}
+
+// settings returns the model overrides the request asks for
+func (r *CreateConversationRequest) settings() chat.ModelSettings {
+	return chat.ModelSettings{Model: r.Model, Temperature: r.Temperature, MaxTokens: r.MaxTokens}
+}
+
+// hasOverrides reports whether the request overrides any of the client's LLM settings
+func (r *CreateConversationRequest) hasOverrides() bool {
+	return r.Model != nil || r.Temperature != nil || r.MaxTokens != nil
+}
+
+func (r *CreateConversationRequest) validate() error {
+	if r.TemplateID != "" && r.InitialMessage != "" {
+		return &ValidationError{Field: "initial_message", Reason: "cannot be combined with template_id"}
+	}
+	return nil
+}
+
+// ConversationRequest is the payload of messages addressing one conversation
+type ConversationRequest struct {
+	ConversationID string `json:"conversation_id"`
+}
+
+func (r *ConversationRequest) validate() error {
+	return requireString("conversation_id", r.ConversationID)
+}
+
+// ConversationPageRequest is the payload of get_conversation and
+// get_conversation_messages: a page of limit messages (default
+// chat.DefaultMessagePageSize) older than before_message_id, or the newest
+// page without it
+type ConversationPageRequest struct {
+	ConversationID  string `json:"conversation_id"`
+	BeforeMessageID string `json:"before_message_id,omitempty"`
+	Limit           *int   `json:"limit,omitempty"`
+}
+
+func (r *ConversationPageRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.Limit != nil && (*r.Limit < 1 || *r.Limit > chat.MaxMessagePageSize) {
+		return &ValidationError{Field: "limit", Reason: fmt.Sprintf("must be between 1 and %d", chat.MaxMessagePageSize)}
+	}
+	return nil
+}
+
+// page returns the page of history the request asks for
+func (r *ConversationPageRequest) page() chat.MessagePage {
+	page := chat.MessagePage{BeforeMessageID: r.BeforeMessageID}
+	if r.Limit != nil {
+		page.Limit = *r.Limit
+	}
+	return page
+}
+
+// ExportConversationRequest is the payload of export_conversation
+type ExportConversationRequest struct {
+	ConversationID string `json:"conversation_id"`
+	Format         string `json:"format"` // json (default) or markdown
+}
+
+func (r *ExportConversationRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.Format != "" && r.Format != "json" && r.Format != "markdown" {
+		return &ValidationError{Field: "format", Reason: "must be json or markdown"}
+	}
+	return nil
+}
+
+// MessageFeedbackRequest is the payload of message_feedback
+type MessageFeedbackRequest struct {
+	MessageID string `json:"message_id"`
+	Rating    *int   `json:"rating"`
+	Comment   string `json:"comment"`
+}
+
+func (r *MessageFeedbackRequest) validate() error {
+	if err := requireString("message_id", r.MessageID); err != nil {
+		return err
+	}
+	if r.Rating == nil {
+		return &ValidationError{Field: "rating", Reason: "is required"}
+	}
+	return nil
+}
+
+// PinConversationRequest is the payload of pin_conversation; pinned false unpins.
+// A version, when given, must be the conversation's current one.
+type PinConversationRequest struct {
+	ConversationID string `json:"conversation_id"`
+	Pinned         *bool  `json:"pinned"`
+	Version        int64  `json:"version,omitempty"`
+}
+
+func (r *PinConversationRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.Pinned == nil {
+		return &ValidationError{Field: "pinned", Reason: "is required"}
+	}
+	return nil
+}
+
+// SetLanguageRequest is the payload of set_language; an empty language unpins
+// the conversation's language so it is detected again from the next message.
+// A version, when given, must be the conversation's current one.
+type SetLanguageRequest struct {
+	ConversationID string  `json:"conversation_id"`
+	Language       *string `json:"language"`
+	Version        int64   `json:"version,omitempty"`
+}
+
+func (r *SetLanguageRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.Language == nil {
+		return &ValidationError{Field: "language", Reason: "is required"}
+	}
+	if *r.Language != "" && !langdetect.Supported(*r.Language) {
+		return &ValidationError{Field: "language", Reason: "must be one of " + strings.Join(langdetect.Languages, ", ")}
+	}
+	return nil
+}
+
+// SetDraftsEnabledRequest is the payload of set_drafts_enabled, which turns
+// draft mode on or off; project editors only. A version, when given, must be
+// the conversation's current one.
+type SetDraftsEnabledRequest struct {
+	ConversationID string `json:"conversation_id"`
+	DraftsEnabled  *bool  `json:"drafts_enabled"`
+	Version        int64  `json:"version,omitempty"`
+}
+
+func (r *SetDraftsEnabledRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.DraftsEnabled == nil {
+		return &ValidationError{Field: "drafts_enabled", Reason: "is required"}
+	}
+	return nil
+}
+
+// ApproveDraftRequest is the payload of approve_draft; content, when given,
+// replaces the draft's generated content
+type ApproveDraftRequest struct {
+	MessageID string  `json:"message_id"`
+	Content   *string `json:"content,omitempty"`
+}
+
+func (r *ApproveDraftRequest) validate() error {
+	if err := requireString("message_id", r.MessageID); err != nil {
+		return err
+	}
+	if r.Content != nil {
+		return requireString("content", *r.Content)
+	}
+	return nil
+}
+
+// DiscardDraftRequest is the payload of discard_draft
+type DiscardDraftRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+func (r *DiscardDraftRequest) validate() error {
+	return requireString("message_id", r.MessageID)
+}
+
+// AddParticipantRequest is the payload of add_participant; only the
+// conversation's owner may add users of the same client
+type AddParticipantRequest struct {
+	ConversationID string `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+}
+
+func (r *AddParticipantRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	return requireString("user_id", r.UserID)
+}
+
+// ForkConversationRequest is the payload of fork_conversation, which copies a
+// conversation up to and including at_message_id into a new one
+type ForkConversationRequest struct {
+	ConversationID string `json:"conversation_id"`
+	AtMessageID    string `json:"at_message_id"`
+}
+
+func (r *ForkConversationRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	return requireString("at_message_id", r.AtMessageID)
+}
+
+// ExecuteToolRequest is the payload of execute_tool, which runs a tool of the
+// connection's project without the LLM
+type ExecuteToolRequest struct {
+	Tool           string                 `json:"tool"`
+	Params         map[string]interface{} `json:"params"`
+	ConversationID string                 `json:"conversation_id"` // Attach the result to this conversation
+	Force          bool                   `json:"force"`           // Run a tool that can modify data; editors and above only
+}
+
+func (r *ExecuteToolRequest) validate() error {
+	return requireString("tool", r.Tool)
+}
+
+// ResumeStreamRequest is the payload of resume_stream; last_seq is the seq of the
+// last assistant_response frame the client received, 0 for none
+type ResumeStreamRequest struct {
+	ConversationID string `json:"conversation_id"`
+	LastSeq        *int64 `json:"last_seq"`
+}
+
+func (r *ResumeStreamRequest) validate() error {
+	if err := requireString("conversation_id", r.ConversationID); err != nil {
+		return err
+	}
+	if r.LastSeq == nil {
+		return &ValidationError{Field: "last_seq", Reason: "is required"}
+	}
+	if *r.LastSeq < 0 {
+		return &ValidationError{Field: "last_seq", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// ResumeConversationRequest is the payload of resume_conversation, which
+// generates the reply of an interrupted conversation again
+type ResumeConversationRequest struct {
+	ConversationID string `json:"conversation_id"`
+}
+
+func (r *ResumeConversationRequest) validate() error {
+	return requireString("conversation_id", r.ConversationID)
+}
+
+// ChatInterruptedRequest is the payload of chat_interrupted. The user and
+// project always come from the connection, never from the payload.
+type ChatInterruptedRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *ChatInterruptedRequest) validate() error { return nil }
+
+// AckRequest is the payload of ack, which confirms a critical frame by the
+// message_id it was sent with
+type AckRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+func (r *AckRequest) validate() error {
+	return requireString("message_id", r.MessageID)
+}
+
+// EmptyRequest is the payload of messages that carry no parameters; any data is ignored
+type EmptyRequest struct{}
+
+func (r *EmptyRequest) validate() error { return nil }
+
+// messageRequests maps every message type a client may send to its payload
+var messageRequests = map[string]func() messageRequest{
+	"connection_established":        func() messageRequest { return &ConnectionEstablishedRequest{} },
+	"user_message":                  func() messageRequest { return &UserMessageRequest{} },
+	"join_project":                  func() messageRequest { return &ProjectRequest{} },
+	"leave_project":                 func() messageRequest { return &ProjectRequest{} },
+	"ping":                          func() messageRequest { return &EmptyRequest{} },
+	"pong":                          func() messageRequest { return &EmptyRequest{} },
+	"get_presence":                  func() messageRequest { return &EmptyRequest{} },
+	"get_conversations":             func() messageRequest { return &EmptyRequest{} },
+	"get_all_conversation_statuses": func() messageRequest { return &EmptyRequest{} },
+	"create_conversation":           func() messageRequest { return &CreateConversationRequest{} },
+	"get_conversation":              func() messageRequest { return &ConversationPageRequest{} },
+	"get_conversation_messages":     func() messageRequest { return &ConversationPageRequest{} },
+	"delete_conversation":           func() messageRequest { return &ConversationRequest{} },
+	"get_conversation_status":       func() messageRequest { return &ConversationRequest{} },
+	"get_streaming_conversation":    func() messageRequest { return &ConversationRequest{} },
+	"resume_stream":                 func() messageRequest { return &ResumeStreamRequest{} },
+	"resume_conversation":           func() messageRequest { return &ResumeConversationRequest{} },
+	"export_conversation":           func() messageRequest { return &ExportConversationRequest{} },
+	"pin_conversation":              func() messageRequest { return &PinConversationRequest{} },
+	"set_language":                  func() messageRequest { return &SetLanguageRequest{} },
+	"set_drafts_enabled":            func() messageRequest { return &SetDraftsEnabledRequest{} },
+	"approve_draft":                 func() messageRequest { return &ApproveDraftRequest{} },
+	"discard_draft":                 func() messageRequest { return &DiscardDraftRequest{} },
+	"add_participant":               func() messageRequest { return &AddParticipantRequest{} },
+	"fork_conversation":             func() messageRequest { return &ForkConversationRequest{} },
+	"message_feedback":              func() messageRequest { return &MessageFeedbackRequest{} },
+	"chat_interrupted":              func() messageRequest { return &ChatInterruptedRequest{} },
+	"list_templates":                func() messageRequest { return &EmptyRequest{} },
+	"execute_tool":                  func() messageRequest { return &ExecuteToolRequest{} },
+	"ack":                           func() messageRequest { return &AckRequest{} },
+}
+
+// parseMessage decodes message.Data into the typed payload for message.Type and validates it
+func parseMessage(message *WebSocketMessage) (messageRequest, error) {
+	newRequest, ok := messageRequests[message.Type]
+	if !ok {
+		return nil, &ValidationError{Field: "type", Reason: fmt.Sprintf("unknown message type %q", message.Type)}
+	}
+	req := newRequest()
+
+	if _, isEmpty := req.(*EmptyRequest); isEmpty {
+		return req, nil
+	}
+	if message.Data != nil {
+		// Data was decoded generically with the envelope; round-trip it into the typed struct
+		raw, err := json.Marshal(message.Data)
+		if err != nil {
+			return nil, &ValidationError{Field: "data", Reason: "cannot be encoded"}
+		}
+		if err := json.Unmarshal(raw, req); err != nil {
+			return nil, decodeError(err)
+		}
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodeError turns a json decoding failure into a ValidationError naming the field
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return &ValidationError{Field: "data", Reason: "must be an object"}
+		}
+		return &ValidationError{Field: typeErr.Field, Reason: "must be " + jsonTypeName(typeErr.Type.Kind().String())}
+	}
+	return &ValidationError{Field: "data", Reason: err.Error()}
+}
+
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "int", "int64", "int32", "float64":
+		return "an integer"
+	case "bool":
+		return "a boolean"
+	default:
+		return "a " + kind
+	}
+}
+
+func requireString(field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return &ValidationError{Field: field, Reason: "is required"}
+	}
+	return nil
+}
+
+// invalidMessageError builds the error frame sent when a message fails validation
+func invalidMessageError(lang, messageType string, err error) ErrorData {
+	details := map[string]interface{}{"type": messageType}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		details["field"] = validationErr.Field
+		details["reason"] = validationErr.Reason
+	} else {
+		details["reason"] = err.Error()
+	}
+	return newErrorData(lang, ErrCodeInvalidMessage, details)
+}
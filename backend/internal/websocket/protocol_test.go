@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestParseMessageValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string
+		wantType  interface{}
+		wantField string // empty when the message is valid
+	}{
+		{"handshake without version", `{"type":"connection_established"}`, &ConnectionEstablishedRequest{}, ""},
+		{"handshake with version", `{"type":"connection_established","data":{"protocol_version":1}}`, &ConnectionEstablishedRequest{}, ""},
+		{"handshake version not a number", `{"type":"connection_established","data":{"protocol_version":"1"}}`, nil, "protocol_version"},
+		{"handshake with capabilities", `{"type":"connection_established","data":{"capabilities":["stream_delta"]}}`, &ConnectionEstablishedRequest{}, ""},
+		{"handshake capabilities not a list", `{"type":"connection_established","data":{"capabilities":"stream_delta"}}`, nil, "capabilities"},
+
+		{"user message", `{"type":"user_message","data":{"conversation_id":"c1","content":"hi","client_message_id":"m1"}}`, &UserMessageRequest{}, ""},
+		{"user message without content", `{"type":"user_message","data":{"conversation_id":"c1"}}`, nil, "content"},
+		{"user message blank conversation", `{"type":"user_message","data":{"conversation_id":" ","content":"hi"}}`, nil, "conversation_id"},
+		{"user message content not a string", `{"type":"user_message","data":{"conversation_id":"c1","content":42}}`, nil, "content"},
+		{"user message without data", `{"type":"user_message"}`, nil, "conversation_id"},
+		{"user message data not an object", `{"type":"user_message","data":"hello"}`, nil, "data"},
+
+		{"join project", `{"type":"join_project","data":{"project_id":"p1"}}`, &ProjectRequest{}, ""},
+		{"join project without id", `{"type":"join_project","data":{}}`, nil, "project_id"},
+		{"leave project id not a string", `{"type":"leave_project","data":{"project_id":7}}`, nil, "project_id"},
+
+		{"ping", `{"type":"ping"}`, &EmptyRequest{}, ""},
+		{"pong ignores data", `{"type":"pong","data":"anything"}`, &EmptyRequest{}, ""},
+		{"get presence", `{"type":"get_presence"}`, &EmptyRequest{}, ""},
+		{"get conversations", `{"type":"get_conversations","data":{}}`, &EmptyRequest{}, ""},
+		{"get all statuses", `{"type":"get_all_conversation_statuses"}`, &EmptyRequest{}, ""},
+
+		{"create conversation", `{"type":"create_conversation","data":{"title":"T","initial_message":"hi"}}`, &CreateConversationRequest{}, ""},
+		{"create conversation without data", `{"type":"create_conversation"}`, &CreateConversationRequest{}, ""},
+		{"create conversation title not a string", `{"type":"create_conversation","data":{"title":true}}`, nil, "title"},
+		{"create conversation from template", `{"type":"create_conversation","data":{"template_id":"t1","variables":{"name":"Ann"}}}`, &CreateConversationRequest{}, ""},
+		{"create conversation template and message", `{"type":"create_conversation","data":{"template_id":"t1","initial_message":"hi"}}`, nil, "initial_message"},
+		{"create conversation variable not a string", `{"type":"create_conversation","data":{"template_id":"t1","variables":{"n":1}}}`, nil, "variables.n"},
+		{"list templates", `{"type":"list_templates"}`, &EmptyRequest{}, ""},
+
+		{"get conversation", `{"type":"get_conversation","data":{"conversation_id":"c1"}}`, &ConversationPageRequest{}, ""},
+		{"get conversation without id", `{"type":"get_conversation","data":{}}`, nil, "conversation_id"},
+		{"get conversation page", `{"type":"get_conversation","data":{"conversation_id":"c1","before_message_id":"m1","limit":20}}`, &ConversationPageRequest{}, ""},
+		{"get conversation limit too large", `{"type":"get_conversation","data":{"conversation_id":"c1","limit":201}}`, nil, "limit"},
+		{"conversation messages zero limit", `{"type":"get_conversation_messages","data":{"conversation_id":"c1","limit":0}}`, nil, "limit"},
+		{"conversation messages limit not a number", `{"type":"get_conversation_messages","data":{"conversation_id":"c1","limit":"20"}}`, nil, "limit"},
+		{"delete conversation without id", `{"type":"delete_conversation","data":{"id":"c1"}}`, nil, "conversation_id"},
+		{"conversation status", `{"type":"get_conversation_status","data":{"conversation_id":"c1"}}`, &ConversationRequest{}, ""},
+		{"streaming conversation id not a string", `{"type":"get_streaming_conversation","data":{"conversation_id":["c1"]}}`, nil, "conversation_id"},
+
+		{"export markdown", `{"type":"export_conversation","data":{"conversation_id":"c1","format":"markdown"}}`, &ExportConversationRequest{}, ""},
+		{"export unknown format", `{"type":"export_conversation","data":{"conversation_id":"c1","format":"pdf"}}`, nil, "format"},
+
+		{"feedback", `{"type":"message_feedback","data":{"message_id":"m1","rating":-1,"comment":"meh"}}`, &MessageFeedbackRequest{}, ""},
+		{"feedback without rating", `{"type":"message_feedback","data":{"message_id":"m1"}}`, nil, "rating"},
+		{"feedback fractional rating", `{"type":"message_feedback","data":{"message_id":"m1","rating":0.5}}`, nil, "rating"},
+		{"feedback without message", `{"type":"message_feedback","data":{"rating":1}}`, nil, "message_id"},
+
+		{"resume stream", `{"type":"resume_stream","data":{"conversation_id":"c1","last_seq":3}}`, &ResumeStreamRequest{}, ""},
+		{"resume stream from start", `{"type":"resume_stream","data":{"conversation_id":"c1","last_seq":0}}`, &ResumeStreamRequest{}, ""},
+		{"resume stream without seq", `{"type":"resume_stream","data":{"conversation_id":"c1"}}`, nil, "last_seq"},
+		{"resume stream negative seq", `{"type":"resume_stream","data":{"conversation_id":"c1","last_seq":-1}}`, nil, "last_seq"},
+		{"resume conversation", `{"type":"resume_conversation","data":{"conversation_id":"c1"}}`, &ResumeConversationRequest{}, ""},
+		{"resume conversation without id", `{"type":"resume_conversation","data":{}}`, nil, "conversation_id"},
+
+		{"set language", `{"type":"set_language","data":{"conversation_id":"c1","language":"id"}}`, &SetLanguageRequest{}, ""},
+		{"set language clears", `{"type":"set_language","data":{"conversation_id":"c1","language":""}}`, &SetLanguageRequest{}, ""},
+		{"set language without language", `{"type":"set_language","data":{"conversation_id":"c1"}}`, nil, "language"},
+		{"set language unsupported", `{"type":"set_language","data":{"conversation_id":"c1","language":"fr"}}`, nil, "language"},
+		{"set drafts enabled", `{"type":"set_drafts_enabled","data":{"conversation_id":"c1","drafts_enabled":false}}`, &SetDraftsEnabledRequest{}, ""},
+		{"set drafts enabled without flag", `{"type":"set_drafts_enabled","data":{"conversation_id":"c1"}}`, nil, "drafts_enabled"},
+		{"approve draft", `{"type":"approve_draft","data":{"message_id":"m1"}}`, &ApproveDraftRequest{}, ""},
+		{"approve draft with edits", `{"type":"approve_draft","data":{"message_id":"m1","content":"Edited"}}`, &ApproveDraftRequest{}, ""},
+		{"approve draft with empty content", `{"type":"approve_draft","data":{"message_id":"m1","content":"  "}}`, nil, "content"},
+		{"discard draft", `{"type":"discard_draft","data":{"message_id":"m1"}}`, &DiscardDraftRequest{}, ""},
+
+		{"add participant", `{"type":"add_participant","data":{"conversation_id":"c1","user_id":"u2"}}`, &AddParticipantRequest{}, ""},
+		{"add participant without user", `{"type":"add_participant","data":{"conversation_id":"c1"}}`, nil, "user_id"},
+
+		{"fork conversation", `{"type":"fork_conversation","data":{"conversation_id":"c1","at_message_id":"m2"}}`, &ForkConversationRequest{}, ""},
+		{"fork conversation without message", `{"type":"fork_conversation","data":{"conversation_id":"c1"}}`, nil, "at_message_id"},
+
+		{"execute tool", `{"type":"execute_tool","data":{"tool":"system_info","params":{"include_disk":true}}}`, &ExecuteToolRequest{}, ""},
+		{"execute tool params not an object", `{"type":"execute_tool","data":{"tool":"system_info","params":[1]}}`, nil, "params"},
+		{"execute tool without tool", `{"type":"execute_tool","data":{"params":{}}}`, nil, "tool"},
+
+		{"chat interrupted", `{"type":"chat_interrupted","data":{"reason":"page_unload"}}`, &ChatInterruptedRequest{}, ""},
+
+		{"unknown type", `{"type":"launch_missiles","data":{}}`, nil, "type"},
+		{"missing type", `{"data":{}}`, nil, "type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message WebSocketMessage
+			if err := json.Unmarshal([]byte(tt.frame), &message); err != nil {
+				t.Fatalf("Invalid test frame: %v", err)
+			}
+
+			req, err := parseMessage(&message)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("Expected a valid message, got %v", err)
+				}
+				if got, want := typeName(req), typeName(tt.wantType); got != want {
+					t.Errorf("Expected %s, got %s", want, got)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			if validationErr.Field != tt.wantField {
+				t.Errorf("Expected field %q, got %q (%s)", tt.wantField, validationErr.Field, validationErr.Reason)
+			}
+		})
+	}
+}
+
+func TestEveryRequestTypeIsValidated(t *testing.T) {
+	// Types that need a field must reject an empty payload
+	required := map[string]bool{
+		"user_message": true, "join_project": true, "leave_project": true,
+		"get_conversation": true, "get_conversation_messages": true, "delete_conversation": true, "get_conversation_status": true,
+		"get_streaming_conversation": true, "export_conversation": true, "message_feedback": true, "pin_conversation": true, "set_language": true,
+		"set_drafts_enabled": true, "approve_draft": true, "discard_draft": true,
+		"resume_stream": true, "resume_conversation": true, "add_participant": true, "fork_conversation": true, "execute_tool": true, "ack": true,
+	}
+	for messageType := range messageRequests {
+		_, err := parseMessage(&WebSocketMessage{Type: messageType, Data: map[string]interface{}{}})
+		if required[messageType] && err == nil {
+			t.Errorf("%s: expected an empty payload to be rejected", messageType)
+		}
+		if !required[messageType] && err != nil {
+			t.Errorf("%s: expected an empty payload to be accepted, got %v", messageType, err)
+		}
+	}
+}
+
+func TestInvalidFrameIsAnsweredWithError(t *testing.T) {
+	conn := NewConnection(nil, "user-1", "client-1", NewHub())
+
+	for _, frame := range []string{`not json`, `{"type":"get_conversation","data":{"conversation_id":1}}`} {
+		conn.dispatch([]byte(frame))
+
+		var message struct {
+			Type string    `json:"type"`
+			Data ErrorData `json:"data"`
+		}
+		select {
+		case raw := <-conn.send:
+			if err := json.Unmarshal(raw, &message); err != nil {
+				t.Fatalf("Invalid reply: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected an error reply", frame)
+		}
+		if message.Type != "error" || message.Data.Code != ErrCodeInvalidMessage || message.Data.Details["field"] == nil {
+			t.Errorf("%s: unexpected reply %+v", frame, message)
+		}
+	}
+}
+
+func TestUnsupportedProtocolVersionClosesConnection(t *testing.T) {
+	server := newMountedTestServer(t)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + MountedPath + "?project=project-1"
+
+	header := http.Header{}
+	header.Set("Cookie", "auth_token=valid-token")
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("Failed to read project_joined: %v", err)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{
+		"type": "connection_established",
+		"data": map[string]interface{}{"protocol_version": ProtocolVersion + 1},
+	}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var protocolErr struct {
+		Type string    `json:"type"`
+		Data ErrorData `json:"data"`
+	}
+	if err := conn.ReadJSON(&protocolErr); err != nil {
+		t.Fatalf("Failed to read protocol_error: %v", err)
+	}
+	if protocolErr.Type != "protocol_error" || protocolErr.Data.Code != ErrCodeUnsupportedProtocol {
+		t.Errorf("Unexpected reply: %+v", protocolErr)
+	}
+
+	_, _, err = conn.ReadMessage()
+	if !gorilla.IsCloseError(err, CloseUnsupportedProtocol) {
+		t.Errorf("Expected close code %d, got %v", CloseUnsupportedProtocol, err)
+	}
+}
+
+func TestHandshakeNegotiatesStreamDelta(t *testing.T) {
+	hub := NewHub()
+	legacy := NewConnection(nil, "user-1", "client-1", hub)
+	delta := NewConnection(nil, "user-1", "client-1", hub)
+
+	delta.dispatch([]byte(`{"type":"connection_established","data":{"capabilities":["stream_delta","telepathy","stream_delta"]}}`))
+	var established struct {
+		Data struct {
+			Capabilities []string `json:"capabilities"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(<-delta.send, &established); err != nil {
+		t.Fatalf("Invalid reply: %v", err)
+	}
+	if len(established.Data.Capabilities) != 1 || established.Data.Capabilities[0] != messages.CapabilityStreamDelta {
+		t.Errorf("Expected only stream_delta to be negotiated, got %v", established.Data.Capabilities)
+	}
+
+	frame := (&chat.StreamResume{ConversationID: "c1", Seq: 2, Delta: "lo", Content: "Hello"}).Message()
+	for _, tt := range []struct {
+		conn        *Connection
+		wantContent bool
+	}{{legacy, true}, {delta, false}} {
+		hub.SendToConnection(tt.conn, frame)
+		var response struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(<-tt.conn.send, &response); err != nil {
+			t.Fatalf("Invalid frame: %v", err)
+		}
+		if _, hasContent := response.Data["content"]; hasContent != tt.wantContent || response.Data["delta"] != "lo" {
+			t.Errorf("Connection with stream_delta=%t: unexpected frame %+v", !tt.wantContent, response.Data)
+		}
+	}
+}
+
+func typeName(v interface{}) string {
+	return fmt.Sprintf("%T", v)
+}
+
+func TestUserMessageContentIsValidatedBeforeProcessing(t *testing.T) {
+	zdb := newUnconfiguredClientDB(t)
+	hub := NewHub()
+	handler := NewHandler(hub, zdb, NewClientConfigCache(zdb, testServerConfig(t)))
+	handler.messagePolicy = chat.MessagePolicy{MaxChars: 5}
+	handler.SetChatService(chat.NewChatService(&tools.ZlayDBAdapter{DB: zdb}, &tools.WebSocketAdapter{Hub: hub},
+		unusedLLMClient{t}, tools.NewToolRegistry()))
+	conn := NewConnection(nil, "user-1", "client-1", hub)
+	conn.ProjectID = "project-1"
+	conn.handler = handler
+
+	for frame, code := range map[string]string{
+		`{"type":"user_message","data":{"conversation_id":"conv-1","content":"\u0000\u0001 "}}`: ErrCodeInvalidMessage,
+		`{"type":"user_message","data":{"conversation_id":"conv-1","content":"héllo!"}}`:        apierror.CodeMessageTooLong,
+		`{"type":"create_conversation","data":{"title":"New","initial_message":"日本語日本語"}}`:      apierror.CodeMessageTooLong,
+		`{"type":"create_conversation","data":{"title":"New","initial_message":" \u0000 "}}`:    ErrCodeInvalidMessage,
+	} {
+		conn.dispatch([]byte(frame))
+
+		var message struct {
+			Type string    `json:"type"`
+			Data ErrorData `json:"data"`
+		}
+		select {
+		case raw := <-conn.send:
+			if err := json.Unmarshal(raw, &message); err != nil {
+				t.Fatalf("Invalid reply: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected an error reply", frame)
+		}
+		if message.Type != "error" || message.Data.Code != code {
+			t.Errorf("%s: expected %s, got %+v", frame, code, message)
+		}
+		if code == apierror.CodeMessageTooLong && message.Data.Details["limit"] != float64(5) {
+			t.Errorf("%s: expected the limit in the details, got %+v", frame, message.Data.Details)
+		}
+	}
+
+	row, err := zdb.QueryRow(context.Background(), "SELECT (SELECT COUNT(*) FROM conversations) + (SELECT COUNT(*) FROM messages)")
+	if err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	if count, _ := row.Values[0].AsInt64(); count != 1 {
+		t.Errorf("Expected rejected messages to leave no rows, got %d", count)
+	}
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/requestid"
+)
+
+// stampedFrame is a frame with the IDs of its envelope
+type stampedFrame struct {
+	Type      string    `json:"type"`
+	FrameID   string    `json:"frame_id"`
+	RequestID string    `json:"request_id"`
+	Data      ErrorData `json:"data"`
+}
+
+func decodeEnvelope(t *testing.T, frame []byte) stampedFrame {
+	t.Helper()
+
+	var envelope stampedFrame
+	if err := json.Unmarshal(frame, &envelope); err != nil {
+		t.Fatalf("Invalid frame %s: %v", frame, err)
+	}
+	return envelope
+}
+
+func TestFramesCarryFrameAndRequestIDs(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	conn := joinRoom(hub, "user-a", "project-1")
+	conn.setRequestID("upgrade-1")
+	for hub.GetProjectConnectionCount("project-1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Replies on the connection carry its upgrade request's ID
+	hub.SendToConnection(conn, WebSocketMessage{Type: "pong"})
+	pong := decodeEnvelope(t, nextFrame(t, conn, "pong"))
+	if pong.FrameID == "" || pong.RequestID != "upgrade-1" {
+		t.Errorf("Expected a frame ID and the upgrade request's ID, got %+v", pong)
+	}
+
+	// Frames of a chat request carry its ID instead
+	ctx := requestid.With(context.Background(), "chat-1")
+	hub.BroadcastToProject("project-1", messages.ForRequest(ctx, WebSocketMessage{Type: "assistant_response"}))
+	response := decodeEnvelope(t, nextFrame(t, conn, "assistant_response"))
+	if response.FrameID == "" || response.FrameID == pong.FrameID || response.RequestID != "chat-1" {
+		t.Errorf("Expected a new frame ID and the chat request's ID, got %+v", response)
+	}
+
+	// Broadcasts that answer no request carry only a frame ID
+	hub.BroadcastToProject("project-1", WebSocketMessage{Type: "conversation_updated"})
+	if updated := decodeEnvelope(t, nextFrame(t, conn, "conversation_updated")); updated.FrameID == "" || updated.RequestID != "" {
+		t.Errorf("Expected only a frame ID, got %+v", updated)
+	}
+}
+
+func TestErrorsEchoTheRequestID(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	conn := NewConnection(nil, "user-a", "client-1", hub)
+	conn.setRequestID("upgrade-1")
+	handler := &Handler{hub: hub}
+
+	// A failed chat request reports its own ID
+	req := &chat.ChatRequest{ConversationID: "conversation-1", Context: requestid.With(conn.Context(), "chat-1")}
+	handler.sendProcessingError(conn, req, chat.ErrQueueFull)
+	failed := decodeEnvelope(t, nextFrame(t, conn, "error"))
+	if failed.Data.Code != apierror.CodeQueueFull || failed.Data.RequestID != "chat-1" || failed.RequestID != "chat-1" {
+		t.Errorf("Expected the chat request's ID in the error, got %+v", failed)
+	}
+
+	// Other errors report the upgrade request's
+	conn.sendError(apierror.CodeNotInProject, nil)
+	other := decodeEnvelope(t, nextFrame(t, conn, "error"))
+	if other.Data.RequestID != "upgrade-1" || other.RequestID != "upgrade-1" {
+		t.Errorf("Expected the upgrade request's ID in the error, got %+v", other)
+	}
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/schedules"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+// RunScheduledTurn answers a scheduled prompt through the chat pipeline with
+// nobody connected, using the client's LLM configuration and the same
+// message checks as a typed message. Connections watching the project still
+// see the reply stream. A conversation the schedule's creator can no longer
+// use is replaced by a new one, which the returned reply names even when the
+// run fails.
+func (s *Server) RunScheduledTurn(ctx context.Context, turn schedules.Turn) (*schedules.Reply, error) {
+	content, err := s.handler.messagePolicy.Prepare(ctx, &tools.ZlayDBAdapter{DB: s.db}, turn.UserID, turn.ProjectID, turn.Content)
+	if err != nil {
+		return nil, fmt.Errorf("prompt rejected: %w", err)
+	}
+	clientConfig, err := s.clientConfigCache.GetClientConfig(ctx, turn.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load LLM configuration: %w", err)
+	}
+
+	reply := &schedules.Reply{ConversationID: turn.ConversationID}
+	if reply.ConversationID == "" {
+		if reply.ConversationID, err = s.createScheduledConversation(turn); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx = requestid.With(ctx, requestid.New())
+	ctx = tools.WithExecutionSource(ctx, turn.ClientID, tools.SourceSchedule)
+	requestid.Logf(ctx, "Scheduled prompt %s for conversation %s", turn.ScheduleID, reply.ConversationID)
+	service := s.chatService.WithLLMClient(clientConfig.LLMClient)
+	newRequest := func() *chat.ChatRequest {
+		return &chat.ChatRequest{
+			ConversationID:       reply.ConversationID,
+			UserID:               turn.UserID,
+			ProjectID:            turn.ProjectID,
+			Content:              content,
+			ClientID:             turn.ClientID,
+			MaxConcurrentStreams: clientConfig.MaxConcurrentStreams,
+			FlushPolicy:          clientConfig.FlushPolicy,
+			ToolResultTokenLimit: clientConfig.ToolResultTokenLimit,
+			Context:              ctx,
+		}
+	}
+
+	// Stored times may be rounded, so the reply is looked for from the second the run started
+	startedAt := time.Now().UTC().Truncate(time.Second)
+	err = service.ProcessUserMessage(newRequest())
+	if errors.Is(err, chat.ErrConversationNotFound) && turn.ConversationID != "" {
+		// The conversation was deleted or its creator left it
+		if reply.ConversationID, err = s.createScheduledConversation(turn); err != nil {
+			return nil, err
+		}
+		err = service.ProcessUserMessage(newRequest())
+	}
+	if err != nil {
+		return reply, err
+	}
+
+	var createdAt time.Time
+	if err := (&tools.ZlayDBAdapter{DB: s.db}).QueryRow(ctx,
+		`SELECT id, content, created_at FROM messages
+		WHERE conversation_id = $1 AND role = 'assistant'
+		ORDER BY created_at DESC LIMIT 1`,
+		reply.ConversationID).Scan(&reply.MessageID, &reply.Content, &createdAt); err != nil {
+		return reply, fmt.Errorf("failed to load the reply: %w", err)
+	}
+	if createdAt.Before(startedAt) {
+		return reply, errors.New("the run produced no reply")
+	}
+	return reply, nil
+}
+
+// createScheduledConversation starts the conversation a schedule posts to,
+// titled after the schedule
+func (s *Server) createScheduledConversation(turn schedules.Turn) (string, error) {
+	conversation, err := s.chatService.CreateConversation(turn.UserID, turn.ProjectID, turn.Title, chat.ModelSettings{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	if s.webhooks != nil {
+		s.webhooks.Publish(webhooks.NewEvent(webhooks.EventConversationCreated, turn.ClientID, turn.ProjectID, map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"user_id":         turn.UserID,
+			"title":           conversation.Title,
+			"schedule_id":     turn.ScheduleID,
+		}))
+	}
+	s.activity.Record(activity.Event{
+		ProjectID:   turn.ProjectID,
+		ActorUserID: turn.UserID,
+		EventType:   activity.EventConversationCreated,
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title, "schedule_id": turn.ScheduleID},
+	})
+	return conversation.ID, nil
+}
@@ -0,0 +1,232 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/messages"
+	"zlay-backend/internal/tools/jobs"
+	"zlay-backend/internal/tools/snapshots"
+)
+
+// SchemaDraft is the JSON Schema dialect of the document built by Schema
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// serverMessages maps every message type the server sends to its payload. A
+// nil payload is sent as a free-form object built in place.
+var serverMessages = map[string]interface{}{
+	"connection_established":      nil,
+	"force_disconnect":            ForceDisconnectData{},
+	"protocol_error":              ErrorData{},
+	"error":                       ErrorData{},
+	"pong":                        PongData{},
+	"presence_update":             PresenceData{},
+	"project_joined":              ProjectJoinedData{},
+	"project_left":                nil,
+	"user_message_sent":           nil,
+	"message_queued":              chat.MessageQueuedData{},
+	"rate_limited":                chat.RateLimitedData{},
+	"message_duplicate":           nil,
+	"assistant_thinking":          chat.AssistantThinkingData{},
+	"assistant_first_token":       chat.AssistantFirstTokenData{},
+	"assistant_response":          AssistantResponseData{},
+	"tool_execution":              nil,
+	"tool_execution_started":      ToolExecutionStartedData{},
+	"tool_execution_completed":    ToolExecutionCompletedData{},
+	"tool_execution_failed":       ToolExecutionFailedData{},
+	"chat_interrupted":            nil,
+	"conversation_created":        ConversationCreatedData{},
+	"conversations_list":          ConversationsListData{},
+	"conversation_details":        ConversationDetailsData{},
+	"conversation_messages":       ConversationMessagesData{},
+	"conversation_updated":        nil,
+	"conversation_deleted":        nil,
+	"conversation_status":         nil,
+	"conversation_status_updated": nil,
+	"all_conversation_statuses":   nil,
+	"get_streaming_conversation":  nil,
+	"conversation_export_ready":   nil,
+	"message_feedback_updated":    chat.MessageFeedback{},
+	"participant_added":           chat.ConversationParticipant{},
+	"templates_list":              TemplatesListData{},
+	"tool_run_result":             chat.ToolRun{},
+	"draft_ready":                 chat.DraftReadyData{},
+	"draft_approved":              chat.DraftApprovedData{},
+	"draft_discarded":             chat.DraftDiscardedData{},
+	jobs.EventCompleted:           jobs.Job{},
+	snapshots.EventSchemaChanged:  nil,
+	activity.MessageType:          activity.Event{},
+}
+
+// frameEnvelope describes the envelope of frames as sent: the hub stamps every
+// frame with a frame_id, frames answering a request with its request_id, and
+// critical frames to clients that negotiated acks with a message_id
+type frameEnvelope struct {
+	messages.WebSocketMessage
+	FrameID   string `json:"frame_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Schema returns a JSON Schema document describing the envelope and the
+// payload of every message type, built from messageRequests and
+// serverMessages. Client payloads list no required fields since the server
+// validates them itself; server payloads require every field sent without
+// omitempty.
+func Schema() map[string]interface{} {
+	g := &schemaGenerator{defs: make(map[string]interface{})}
+
+	clientMessages := make(map[string]interface{}, len(messageRequests))
+	for messageType, newRequest := range messageRequests {
+		clientMessages[messageType] = g.messageSchema(messageType, reflect.TypeOf(newRequest()), false)
+	}
+	serverSchemas := make(map[string]interface{}, len(serverMessages))
+	for messageType, payload := range serverMessages {
+		serverSchemas[messageType] = g.messageSchema(messageType, reflect.TypeOf(payload), true)
+	}
+
+	return map[string]interface{}{
+		"$schema":          SchemaDraft,
+		"title":            "Zlay WebSocket messages",
+		"protocol_version": ProtocolVersion,
+		"envelope":         g.structSchema(reflect.TypeOf(frameEnvelope{}), true),
+		"client_messages":  clientMessages,
+		"server_messages":  serverSchemas,
+		"$defs":            g.defs,
+	}
+}
+
+// HandleSchema serves the message schema document
+func HandleSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, Schema())
+}
+
+// WriteSchema writes the message schema document, indented, to path
+func WriteSchema(path string) error {
+	document, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(document, '\n'), 0644)
+}
+
+// schemaGenerator collects the named structs it meets into $defs
+type schemaGenerator struct {
+	defs map[string]interface{}
+}
+
+// messageSchema describes one message: its type constant and its payload
+func (g *schemaGenerator) messageSchema(messageType string, payload reflect.Type, required bool) map[string]interface{} {
+	data := map[string]interface{}{"type": "object"}
+	if payload != nil && payload.Kind() == reflect.Ptr {
+		payload = payload.Elem()
+	}
+	if payload != nil && payload != reflect.TypeOf(EmptyRequest{}) {
+		data = g.schemaFor(payload, required)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"type": map[string]interface{}{"const": messageType}, "data": data},
+		"required":   []string{"type"},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes a Go type the way encoding/json encodes it
+func (g *schemaGenerator) schemaFor(t reflect.Type, required bool) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schemaFor(t.Elem(), required)
+		if kind, ok := schema["type"].(string); ok {
+			schema["type"] = []string{kind, "null"}
+		}
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem(), required)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem(), required)}
+	case reflect.Struct:
+		return g.structRef(t, required)
+	default:
+		// interface{} and other types may hold any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+// structRef adds a named struct to $defs once, as package.Type, and refers to
+// it. No struct is both a client and a server payload, so each definition
+// either lists its required fields or never does.
+func (g *schemaGenerator) structRef(t reflect.Type, required bool) map[string]interface{} {
+	if t.Name() == "" {
+		return g.structSchema(t, required)
+	}
+	name := t.String()
+	if _, exists := g.defs[name]; !exists {
+		g.defs[name] = nil // Placeholder so recursive types terminate
+		g.defs[name] = g.structSchema(t, required)
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+// structSchema describes the fields of a struct, inlining embedded structs
+func (g *schemaGenerator) structSchema(t reflect.Type, required bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	requiredFields := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schemaFor(field.Type, required)
+			if required && !strings.Contains(options, "omitempty") {
+				requiredFields = append(requiredFields, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(requiredFields) > 0 {
+		sort.Strings(requiredFields)
+		schema["required"] = requiredFields
+	}
+	return schema
+}
@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseSources parses the non-test Go files of the given package directories
+func parseSources(t *testing.T, dirs ...string) []*ast.File {
+	t.Helper()
+
+	var files []*ast.File
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			t.Fatalf("Failed to list %s: %v", dir, err)
+		}
+		for _, path := range paths {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				t.Fatalf("Failed to parse %s: %v", path, err)
+			}
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	return value, err == nil
+}
+
+// isMessageType matches message.Type and messageType, the expressions the handlers switch on
+func isMessageType(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.SelectorExpr:
+		ident, ok := e.X.(*ast.Ident)
+		return ok && ident.Name == "message" && e.Sel.Name == "Type"
+	case *ast.Ident:
+		return e.Name == "messageType"
+	}
+	return false
+}
+
+func TestSchemaCoversHandledMessageTypes(t *testing.T) {
+	handled := make(map[string]bool)
+	for _, file := range parseSources(t, ".") {
+		ast.Inspect(file, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.SwitchStmt:
+				if n.Tag == nil || !isMessageType(n.Tag) {
+					return true
+				}
+				for _, stmt := range n.Body.List {
+					for _, expr := range stmt.(*ast.CaseClause).List {
+						if value, ok := stringLiteral(expr); ok {
+							handled[value] = true
+						}
+					}
+				}
+			case *ast.BinaryExpr:
+				if n.Op == token.EQL && isMessageType(n.X) {
+					if value, ok := stringLiteral(n.Y); ok {
+						handled[value] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	if !handled["user_message"] || !handled["join_project"] {
+		t.Fatalf("Expected to find the handled message types, got %v", handled)
+	}
+	for messageType := range handled {
+		if messageRequests[messageType] == nil {
+			t.Errorf("%s is handled but missing from messageRequests, so it has no schema", messageType)
+		}
+	}
+}
+
+func TestSchemaCoversSentMessageTypes(t *testing.T) {
+	sent := make(map[string]bool)
+	for _, file := range parseSources(t, ".", "../chat", "../tools/jobs", "../tools/snapshots") {
+		ast.Inspect(file, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.CompositeLit:
+				var typeName string
+				switch typ := n.Type.(type) {
+				case *ast.Ident:
+					typeName = typ.Name
+				case *ast.SelectorExpr:
+					typeName = typ.Sel.Name
+				}
+				if typeName != "WebSocketMessage" {
+					return true
+				}
+				for _, elt := range n.Elts {
+					kv, ok := elt.(*ast.KeyValueExpr)
+					if key, isIdent := kv.Key.(*ast.Ident); !ok || !isIdent || key.Name != "Type" {
+						continue
+					}
+					if value, ok := stringLiteral(kv.Value); ok {
+						sent[value] = true
+					}
+				}
+			case *ast.CallExpr:
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "NewWebSocketMessage" && len(n.Args) > 0 {
+					if value, ok := stringLiteral(n.Args[0]); ok {
+						sent[value] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	if !sent["assistant_response"] || !sent["conversation_created"] {
+		t.Fatalf("Expected to find the sent message types, got %v", sent)
+	}
+	for messageType := range sent {
+		if _, ok := serverMessages[messageType]; !ok {
+			t.Errorf("%s is sent but missing from serverMessages, so it has no schema", messageType)
+		}
+	}
+}
+
+func TestSchemaDocument(t *testing.T) {
+	// No struct may be both a client and a server payload, see structRef
+	for _, newRequest := range messageRequests {
+		requestType := reflect.TypeOf(newRequest()).Elem()
+		for messageType, payload := range serverMessages {
+			if payload != nil && reflect.TypeOf(payload) == requestType {
+				t.Errorf("%s is both a client payload and the payload of %s", requestType, messageType)
+			}
+		}
+	}
+
+	raw, err := json.Marshal(Schema())
+	if err != nil {
+		t.Fatalf("Failed to encode schema: %v", err)
+	}
+	var document struct {
+		Schema         string                     `json:"$schema"`
+		ClientMessages map[string]json.RawMessage `json:"client_messages"`
+		ServerMessages map[string]json.RawMessage `json:"server_messages"`
+		Defs           map[string]struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+			Required   []string                          `json:"required"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		t.Fatalf("Failed to decode schema: %v", err)
+	}
+	if document.Schema != SchemaDraft || len(document.ClientMessages) != len(messageRequests) || len(document.ServerMessages) != len(serverMessages) {
+		t.Fatalf("Expected every message type described, got %d client and %d server messages", len(document.ClientMessages), len(document.ServerMessages))
+	}
+	if !strings.Contains(string(document.ClientMessages["user_message"]), `"$ref":"#/$defs/websocket.UserMessageRequest"`) {
+		t.Errorf("Expected user_message to refer to its payload, got %s", document.ClientMessages["user_message"])
+	}
+
+	// Server payloads require the fields sent without omitempty
+	if got := document.Defs["websocket.ToolExecutionStartedData"].Required; strings.Join(got, ",") != "conversation_id,tool_call_id,tool_name" {
+		t.Errorf("Unexpected required fields %v", got)
+	}
+	if got := document.Defs["websocket.UserMessageRequest"].Required; len(got) != 0 {
+		t.Errorf("Expected client payloads to list no required fields, got %v", got)
+	}
+
+	// Pointers are nullable, times are date-times and embedded structs are inlined
+	if got := document.Defs["websocket.CreateConversationRequest"].Properties["model"]["type"]; !reflect.DeepEqual(got, []interface{}{"string", "null"}) {
+		t.Errorf("Expected a nullable string, got %v", got)
+	}
+	conversation := document.Defs["websocket.ConversationWithMessages"].Properties
+	if conversation["created_at"]["format"] != "date-time" || conversation["messages"]["type"] != "array" {
+		t.Errorf("Unexpected conversation properties %v", conversation)
+	}
+}
+
+func TestHandleSchema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET(MountedPath+"/schema", HandleSchema)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", MountedPath+"/schema", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"server_messages"`) {
+		t.Errorf("Expected the schema document, got %d: %.200s", w.Code, w.Body.String())
+	}
+}
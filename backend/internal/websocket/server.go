@@ -1,18 +1,34 @@
 package websocket
 
 import (
+	"context"
 	"log"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/auth"
 	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
 	"zlay-backend/internal/db"
+	"zlay-backend/internal/embeddings"
+	"zlay-backend/internal/export"
 	"zlay-backend/internal/llm"
+	"zlay-backend/internal/notify"
+	"zlay-backend/internal/proxy"
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/toolaudit"
 	"zlay-backend/internal/tools"
+	"zlay-backend/internal/tools/jobs"
+	"zlay-backend/internal/webhooks"
+	"zlay-backend/internal/widget"
 )
 
+// MountedPath is where Mount registers the WebSocket endpoint on the main HTTP router
+const MountedPath = "/api/ws"
+
 // Server handles WebSocket server
 type Server struct {
 	hub              *Hub
@@ -21,49 +37,103 @@ type Server struct {
 	db                *db.Database
 	port              string
 	clientConfigCache *ClientConfigCache
+	toolRegistry      tools.ToolRegistry
+	exportSigner      *export.DownloadSigner
+	handler           *Handler  // Shared by the standalone port and the mounted route
+	hubOnce           sync.Once // The hub runs once, however many modes are enabled
+	streamLimiter     *chat.StreamLimiter
+	schemaCache       *tools.SchemaCache
+	historyCache      *chat.HistoryCache // nil when disabled
+	jobManager        *jobs.Manager
+	webhooks          *webhooks.Dispatcher
+	activity          *activity.Recorder
+	notifier          *notify.Notifier // nil without SMTP
+	widgetSigner      *widget.Signer
+	sessions          *auth.Resolver
+	proxies           *proxy.Trust
+	trustedProxies    []string // For the standalone router's own client IP
 }
 
 // NewServer creates a new WebSocket server
-func NewServer(zdb *db.Database, port string) *Server {
+func NewServer(zdb *db.Database, cfg *config.Config) *Server {
 	// Create hub
 	hub := NewHub()
+	hub.SetOutboundLimits(cfg.WSMaxMessageBytes, cfg.WSCompressMinBytes)
+	hub.SetCongestionWindow(cfg.WSCongestionWindow)
 
 	// Create client configuration cache
-	clientConfigCache := NewClientConfigCache(zdb)
+	clientConfigCache := NewClientConfigCache(zdb, cfg)
 	
 	// Initialize default LLM client for fallback
-	defaultAPIKey := os.Getenv("OPENAI_API_KEY")
-	if defaultAPIKey == "" {
-		defaultAPIKey = "sk-no-key-required"
-	}
-	defaultBaseURL := os.Getenv("OPENAI_BASE_URL")
-	defaultModel := os.Getenv("OPENAI_MODEL")
-	if defaultModel == "" {
-		defaultModel = "gpt-3.5-turbo"
-	}
-	defaultLLMClient := llm.NewOpenAIClient(defaultAPIKey, defaultBaseURL, defaultModel)
+	defaultLLMClient := llm.NewOpenAIClient(cfg.OpenAIAPIKey, cfg.OpenAIBaseURL, cfg.OpenAIModel)
+	defaultLLMClient.SetAssistantPrefix(cfg.LLMAssistantPrefix)
 
 	// Initialize tool registry with built-in tools
 	toolRegistry := tools.NewDefaultToolRegistry()
 
+	// Persist per-project tool enable/disable settings in project_tools
+	toolRegistry.SetSettingsStore(tools.NewDBToolSettingsStore(&tools.ZlayDBAdapter{DB: zdb}))
+	toolRegistry.SetAuditor(toolaudit.NewAuditor(&tools.ZlayDBAdapter{DB: zdb}, cfg.ToolAuditSQLMaxChars))
+
+	// Tools check project roles through a shared permission checker
+	permissionChecker := tools.NewDBPermissionChecker(&tools.ZlayDBAdapter{DB: zdb})
+
 	// Register database tool (requires ZDB instance)
-	dbTool := tools.NewDatabaseQueryTool(zdb)
-	if err := toolRegistry.RegisterTool(dbTool); err != nil {
+	dbTool := tools.NewDatabaseQueryTool(zdb, permissionChecker)
+	if err := toolRegistry.RegisterToolWithConfig(dbTool, tools.ToolConfig{
+		Timeout:       cfg.ToolDatabaseTimeout,
+		MaxConcurrent: cfg.ToolDatabaseMaxConcurrent,
+	}); err != nil {
 		log.Printf("Failed to register database tool: %v", err)
 	}
 
+	// Async database queries run as background jobs; large results are spilled to disk
+	jobManager := jobs.NewManager(&tools.ZlayDBAdapter{DB: zdb}, cfg.QueryJobsDir, &tools.WebSocketAdapter{Hub: hub})
+	if failed, err := jobManager.FailInterrupted(context.Background()); err != nil {
+		log.Printf("Failed to clean up interrupted query jobs: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted query jobs as failed", failed)
+	}
+	dbTool.SetJobManager(jobManager)
+	if cfg.AllowSystemDBTool {
+		dbTool.AllowSystemDatabase(&tools.ZlayDBAdapter{DB: zdb})
+	}
+	if err := toolRegistry.RegisterTool(tools.NewQueryJobStatusTool(jobManager, permissionChecker)); err != nil {
+		log.Printf("Failed to register query job status tool: %v", err)
+	}
+
 	// Register API tool (requires ZDB instance)
-	apiTool := tools.NewAPITool(zdb)
-	if err := toolRegistry.RegisterTool(apiTool); err != nil {
+	apiTool := tools.NewAPITool(zdb, permissionChecker, tools.NewDBAllowlistStore(&tools.ZlayDBAdapter{DB: zdb}))
+	if err := toolRegistry.RegisterToolWithConfig(apiTool, tools.ToolConfig{
+		Timeout:       cfg.ToolAPITimeout,
+		MaxConcurrent: cfg.ToolAPIMaxConcurrent,
+	}); err != nil {
 		log.Printf("Failed to register API tool: %v", err)
 	}
 
 	// Register datasource inspection tool (requires ZDB instance)
-	inspectTool := tools.NewDatasourceInspectTool(zdb)
+	// Inspected schemas are cached for it and the datasource metadata endpoints alike
+	schemaCache := tools.NewSchemaCache(tools.DatasourceSchemaSource(zdb), cfg.SchemaCacheTTL)
+	inspectTool := tools.NewDatasourceInspectTool(zdb, permissionChecker)
+	inspectTool.UseSchemaCache(schemaCache)
+	if cfg.AllowSystemDBTool {
+		inspectTool.AllowSystemDatabase(&tools.ZlayDBAdapter{DB: zdb})
+	}
 	if err := toolRegistry.RegisterTool(inspectTool); err != nil {
 		log.Printf("Failed to register datasource inspection tool: %v", err)
 	}
 
+	// Register file read tool (reads uploads from the project file storage dir)
+	fileTool := tools.NewFileReadTool(&tools.ZlayDBAdapter{DB: zdb}, cfg.FilesDir, permissionChecker)
+	if err := toolRegistry.RegisterTool(fileTool); err != nil {
+		log.Printf("Failed to register file read tool: %v", err)
+	}
+
+	// Register chart tool; it can chart the rows of earlier tool calls kept by the registry
+	if err := toolRegistry.RegisterTool(tools.NewChartTool(toolRegistry.Results())); err != nil {
+		log.Printf("Failed to register chart tool: %v", err)
+	}
+
 	// Create chat service with default LLM (will be replaced per-client)
 	chatService := chat.NewChatService(
 		&tools.ZlayDBAdapter{DB: zdb},
@@ -71,13 +141,169 @@ func NewServer(zdb *db.Database, port string) *Server {
 		defaultLLMClient,
 		toolRegistry,
 	)
+	// Requests over a client's concurrent stream limit wait in a bounded queue
+	streamLimiter := chat.NewStreamLimiter(cfg.StreamQueueMaxDepth, cfg.StreamQueueTimeout)
+	chatService.SetStreamLimiter(streamLimiter)
+	chatService.SetRateLimitCooldown(cfg.LLMRateLimitCooldown)
+	chatService.SetStreamOptions(chat.StreamOptions{
+		Flush: chat.StreamFlushPolicy{
+			Chars:    cfg.StreamFlushChars,
+			Interval: cfg.StreamFlushInterval,
+		},
+		Retention:     cfg.StreamRetention,
+		HeadlessGrace: cfg.StreamHeadlessGrace,
+	})
+	chatService.SetReadConnection(&tools.ZlayDBAdapter{DB: zdb.Reader()})
+	// Replies reuse the decoded history of active conversations instead of reading it every turn
+	historyCache := chat.NewHistoryCache(cfg.HistoryCacheConversations, cfg.HistoryCacheMaxBytes)
+	chatService.SetHistoryCache(historyCache)
+	chatService.SetMessageJSONMigration(cfg.MigrateMessageJSON)
+	chatService.SetToolResultStorage(chat.ToolResultStorage{
+		Dir:            cfg.ToolResultsDir,
+		MaxInlineBytes: cfg.ToolResultMaxInlineBytes,
+	})
+
+	// Assistant messages are embedded in the background for the conversation_search tool
+	if cfg.ConversationSearch {
+		embeddingStore := embeddings.NewStore(&tools.ZlayDBAdapter{DB: zdb})
+		if embeddingStore.DetectPGVector(context.Background()) {
+			log.Printf("Conversation search uses pgvector")
+		} else {
+			log.Printf("Conversation search uses brute-force similarity; install pgvector for large projects")
+		}
+		indexer := embeddings.NewIndexer(embeddingStore, embeddings.Options{QueueSize: cfg.EmbeddingQueueSize})
+		indexer.Start()
+		chatService.SetEmbeddingIndexer(indexer)
+
+		// Searches read from the replica, if any; the indexer writes to the primary
+		searchStore := embeddingStore
+		if reader := zdb.Reader(); reader != zdb {
+			searchStore = embeddings.NewStore(&tools.ZlayDBAdapter{DB: reader})
+			searchStore.DetectPGVector(context.Background())
+		}
+		searcher := embeddings.NewSearcher(searchStore, clientConfigCache.UserEmbedder)
+		if err := toolRegistry.RegisterTool(tools.NewConversationSearchTool(searcher, permissionChecker)); err != nil {
+			log.Printf("Failed to register conversation search tool: %v", err)
+		}
+	}
+
+	// Operators are emailed about repeated LLM failures and exhausted webhooks
+	var notifier *notify.Notifier
+	if cfg.SMTPHost != "" {
+		notifier = notify.NewNotifier(&tools.ZlayDBAdapter{DB: zdb}, notify.NewSMTPSender(notify.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			TLS:      cfg.SMTPTLS,
+		}), notify.Options{
+			QueueSize:        cfg.NotifyQueueSize,
+			FailureThreshold: cfg.NotifyLLMFailureThreshold,
+			FailureWindow:    cfg.NotifyLLMFailureWindow,
+		})
+		notifier.Start()
+		chatService.SetNotifier(notifier)
+	}
+
+	// Lifecycle events are delivered to tenant webhooks in the background
+	webhookOptions := webhooks.Options{
+		QueueSize:   cfg.WebhookQueueSize,
+		Workers:     cfg.WebhookWorkers,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+	}
+	if notifier != nil {
+		webhookOptions.OnExhausted = func(webhook *webhooks.Webhook, event webhooks.Event, last *webhooks.Delivery) {
+			notifier.WebhookFailed(notify.WebhookFailure{
+				ClientID:   webhook.ClientID,
+				WebhookID:  webhook.ID,
+				URL:        webhook.URL,
+				EventID:    event.ID,
+				EventType:  event.Type,
+				Attempts:   last.Attempt,
+				StatusCode: last.StatusCode,
+				Error:      last.Error,
+			})
+		}
+	}
+	webhookDispatcher := webhooks.NewDispatcher(&tools.ZlayDBAdapter{DB: zdb}, webhookOptions)
+	webhookDispatcher.Start()
+	chatService.SetEventPublisher(webhookDispatcher)
+
+	// Project activity events are saved in the background and broadcast to the project room
+	activityRecorder := activity.NewRecorder(&tools.ZlayDBAdapter{DB: zdb}, hub, cfg.ActivityQueueSize)
+	activityRecorder.Start()
+	chatService.SetActivityRecorder(activityRecorder)
+
+	// Writes the database refuses are journaled to disk; those left by the
+	// previous process are replayed before any request is served
+	if cfg.SaveJournalPath != "" {
+		if journal, err := chat.OpenSaveJournal(cfg.SaveJournalPath, cfg.SaveJournalMaxAge); err != nil {
+			log.Printf("Failed to open save journal, failed message saves will be lost: %v", err)
+		} else {
+			chatService.SetSaveJournal(journal)
+			if replayed, remaining := chatService.ReplaySaveJournal(context.Background()); replayed+remaining > 0 {
+				log.Printf("Replayed %d journaled writes at startup, %d still waiting", replayed, remaining)
+			}
+			go chatService.RunSaveRetrier(context.Background(), chat.SaveRetryInterval)
+		}
+	}
+
+	// Conversations left processing by a crash or a missed status update are
+	// marked interrupted, starting with those of the previous process
+	if cfg.AbandonedSweepInterval > 0 {
+		go chatService.RunAbandonedSweep(context.Background(), cfg.AbandonedSweepInterval, cfg.AbandonedConversationAfter)
+	}
+
+	// TRUSTED_PROXIES was validated when the configuration was loaded
+	proxies, err := proxy.New(cfg.TrustedProxies)
+	if err != nil {
+		log.Printf("Ignoring trusted proxies: %v", err)
+	}
 
 	server := &Server{
 		hub:              hub,
 		chatService:       chatService,
 		db:                zdb,
-		port:              port,
+		port:              cfg.WSPort,
 		clientConfigCache: clientConfigCache,
+		toolRegistry:      toolRegistry,
+		jobManager:        jobManager,
+		webhooks:          webhookDispatcher,
+		activity:          activityRecorder,
+		notifier:          notifier,
+		streamLimiter:     streamLimiter,
+		schemaCache:       schemaCache,
+		historyCache:      historyCache,
+		// Signs one-time conversation export download URLs redeemed by the HTTP API
+		exportSigner: export.NewDownloadSigner(cfg.ExportSigningSecret, export.DefaultDownloadTTL),
+		// Signs anonymous widget visitor tokens issued by POST /api/widget/session
+		widgetSigner: widget.NewSigner(cfg.WidgetTokenSecret, cfg.WidgetTokenTTL),
+		// Resolves session cookies for the handshake and, through GetSessionResolver, the HTTP API
+		sessions: auth.NewResolver(zdb, cfg.SessionCacheTTL),
+		// Forwarding headers are believed only from TRUSTED_PROXIES
+		proxies:        proxies,
+		trustedProxies: cfg.TrustedProxies,
+	}
+	server.handler = &Handler{
+		hub:              server.hub,
+		chatService:       server.chatService,
+		db:                server.db,
+		clientConfigCache: server.clientConfigCache,
+		exportSigner:      server.exportSigner,
+		events:            server.webhooks,
+		activity:          server.activity,
+		widgetSigner:      server.widgetSigner,
+		toolRegistry:      server.toolRegistry,
+		sessions:          server.sessions,
+		proxies:           server.proxies,
+		historyCache:      server.historyCache,
+		maxForkMessages:   cfg.MaxForkMessages,
+		messagePolicy: chat.MessagePolicy{
+			MaxChars:        cfg.MaxMessageChars,
+			AttachOversized: cfg.AttachOversizedMessages,
+			FilesDir:        cfg.FilesDir,
+		},
 	}
 
 	// Start cache cleanup routine
@@ -86,12 +312,151 @@ func NewServer(zdb *db.Database, port string) *Server {
 	return server
 }
 
-// Start starts the WebSocket server
+// GetToolRegistry returns the tool registry shared with the chat service
+func (s *Server) GetToolRegistry() tools.ToolRegistry {
+	return s.toolRegistry
+}
+
+// GetChatService returns the chat service, for the REST endpoints that share its logic
+func (s *Server) GetChatService() chat.ChatService {
+	return s.chatService
+}
+
+// GetJobManager returns the manager running async database queries
+func (s *Server) GetJobManager() *jobs.Manager {
+	return s.jobManager
+}
+
+// GetExportSigner returns the signer used for conversation export download URLs
+func (s *Server) GetExportSigner() *export.DownloadSigner {
+	return s.exportSigner
+}
+
+// GetWidgetSigner returns the signer for widget visitor tokens accepted by the WebSocket handler
+func (s *Server) GetWidgetSigner() *widget.Signer {
+	return s.widgetSigner
+}
+
+// GetSessionResolver returns the session resolver shared by the handshake and the HTTP API, so
+// logging out or revoking a session through either drops it from the one cache
+func (s *Server) GetSessionResolver() *auth.Resolver {
+	return s.sessions
+}
+
+// GetStreamLimiter returns the per-client stream slots and rate-limit
+// cooldowns shared by WebSocket chat and the OpenAI-compatible API
+func (s *Server) GetStreamLimiter() *chat.StreamLimiter {
+	return s.streamLimiter
+}
+
+// GetSchemaCache returns the datasource schemas cached for the inspect tool,
+// which the datasource metadata endpoints serve from too
+func (s *Server) GetSchemaCache() *tools.SchemaCache {
+	return s.schemaCache
+}
+
+// GetHistoryCache returns the conversation history cache of the chat service,
+// which the HTTP API invalidates after changing stored messages; nil when disabled
+func (s *Server) GetHistoryCache() *chat.HistoryCache {
+	return s.historyCache
+}
+
+// GetClientConfigCache returns the per-client LLM configuration cache used by the chat handler
+func (s *Server) GetClientConfigCache() *ClientConfigCache {
+	return s.clientConfigCache
+}
+
+// HubRunning reports whether the hub loop has been started and is still running
+func (s *Server) HubRunning() bool {
+	return s.hub.IsRunning()
+}
+
+// Stats returns connection, stream and cache counts for the admin status page
+func (s *Server) Stats() map[string]interface{} {
+	activeStreams, queuedStreams := s.streamLimiter.Totals()
+	return map[string]interface{}{
+		"hub_running":         s.hub.IsRunning(),
+		"connections":         s.hub.GetConnectionCount(),
+		"active_streams":      activeStreams,
+		"queued_streams":      queuedStreams,
+		"client_config_cache": s.clientConfigCache.GetCacheStats(),
+		"history_cache":       s.historyCache.Stats(),
+	}
+}
+
+// ListConnections describes the open connections for the admin connections endpoint
+func (s *Server) ListConnections() []ConnectionInfo {
+	return s.hub.ListConnections()
+}
+
+// ForceDisconnect closes a user's connections, or a single connection, see Handler.ForceDisconnect
+func (s *Server) ForceDisconnect(userID, connectionID, reason string) int {
+	return s.handler.ForceDisconnect(userID, connectionID, reason)
+}
+
+// BroadcastToProject sends a message to every connection in a project room
+func (s *Server) BroadcastToProject(projectID string, message interface{}) {
+	s.hub.BroadcastToProject(projectID, message)
+}
+
+// GetEventPublisher returns the dispatcher delivering events to tenant webhooks
+func (s *Server) GetEventPublisher() webhooks.Publisher {
+	return s.webhooks
+}
+
+// GetNotifier returns the notifier emailing client operators, or nil when
+// SMTP is not configured
+func (s *Server) GetNotifier() *notify.Notifier {
+	return s.notifier
+}
+
+// GetActivityRecorder returns the recorder of project activity events
+func (s *Server) GetActivityRecorder() *activity.Recorder {
+	return s.activity
+}
+
+// BroadcastFeedback notifies the project room of feedback saved through the HTTP API
+func (s *Server) BroadcastFeedback(feedback *chat.MessageFeedback) {
+	BroadcastFeedback(s.hub, feedback)
+}
+
+// BroadcastConversationUpdated notifies the owner's other tabs of a conversation changed through the HTTP API
+func (s *Server) BroadcastConversationUpdated(conversation *chat.Conversation) {
+	BroadcastConversationUpdated(s.hub, conversation)
+}
+
+// BroadcastToolRerun notifies the project room of a tool call re-run through the HTTP API
+func (s *Server) BroadcastToolRerun(projectID, conversationID string, call *chat.ToolCall, rerun chat.ToolCallRerun) {
+	BroadcastToolRerun(s.hub, projectID, conversationID, call, rerun)
+}
+
+// OversizedResults returns the tool results withheld from oversized frames
+func (s *Server) OversizedResults() *OversizedResultStore {
+	return s.hub.OversizedResults()
+}
+
+// Mount registers the WebSocket endpoint at MountedPath on an existing router, so the
+// HTTP API and WebSocket share one port, one TLS termination and one cookie scope
+func (s *Server) Mount(router gin.IRoutes) {
+	s.startHub()
+	router.GET(MountedPath, s.handler.HandleWebSocket)
+	router.GET(MountedPath+"/schema", HandleSchema)
+	log.Printf("WebSocket endpoint mounted at %s", MountedPath)
+}
+
+// startHub runs the hub loop once for both the standalone and mounted modes
+func (s *Server) startHub() {
+	s.hubOnce.Do(func() {
+		go s.hub.Run()
+	})
+}
+
+// Start starts the standalone WebSocket server on its own port
 func (s *Server) Start() error {
 	log.Printf("WebSocket server starting on port %s", s.port)
 
 	// Start hub in separate goroutine
-	go s.hub.Run()
+	s.startHub()
 
 	// Setup routes
 	s.setupRoutes()
@@ -111,8 +476,14 @@ func (s *Server) Start() error {
 func (s *Server) Stop() error {
 	log.Printf("Stopping WebSocket server...")
 
-	// TODO: Implement graceful shutdown logic
-	// For now, just log
+	// Replies still generating are cancelled; what they produced is saved
+	if stopper, ok := s.chatService.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+	// Activity events already recorded are still saved
+	if s.activity != nil {
+		s.activity.Stop()
+	}
 	log.Printf("WebSocket server stopped")
 	return nil
 }
@@ -121,6 +492,12 @@ func (s *Server) Stop() error {
 func (s *Server) setupRoutes() {
 	// Create router
 	s.router = gin.Default()
+	s.router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	if err := s.router.SetTrustedProxies(s.trustedProxies); err != nil {
+		log.Printf("Failed to set trusted proxies: %v", err)
+	}
+
+	s.router.Use(requestid.Middleware())
 
 	// Enable CORS
 	s.router.Use(func(c *gin.Context) {
@@ -137,16 +514,9 @@ func (s *Server) setupRoutes() {
 		c.Next()
 	})
 
-	// Create handler with chat service and client config cache
-	handler := &Handler{
-		hub:              s.hub,
-		chatService:       s.chatService,
-		db:                s.db,
-		clientConfigCache: s.clientConfigCache,
-	}
-
 	// WebSocket endpoint
-	s.router.GET("/ws/chat", handler.HandleWebSocket)
+	s.router.GET("/ws/chat", s.handler.HandleWebSocket)
+	s.router.GET("/ws/schema", HandleSchema)
 
 	// Health check endpoint
 	s.router.GET("/ws/health", func(c *gin.Context) {
@@ -231,8 +601,7 @@ func (s *Server) setupRoutes() {
 			// Find connection in hub
 			for conn := range s.hub.GetConnections() {
 				if conn.ID == connectionID {
-					previous := conn.TokensUsed
-					conn.ResetTokenUsage()
+					previous := conn.ResetTokenUsage()
 					c.JSON(200, gin.H{
 						"connection_id": connectionID,
 						"previous_tokens": previous,
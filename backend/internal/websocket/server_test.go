@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db/dbtest"
+)
+
+// testServerConfig is the default config with an ephemeral port and files directory
+func testServerConfig(t *testing.T) *config.Config {
+	cfg := config.Default()
+	cfg.WSPort, cfg.FilesDir = "0", t.TempDir()
+	cfg.SaveJournalPath = filepath.Join(t.TempDir(), "save_journal.jsonl")
+	cfg.AbandonedSweepInterval = 0
+	return cfg
+}
+
+func newMountedTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+	dbtest.Seed(t, zdb,
+		"UPDATE users SET username = 'alice' WHERE id = 'user-1'",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('root-1', 'client-1', 'root', 'x')",
+	)
+
+	tokenHash := sha256.Sum256([]byte("valid-token"))
+	ctx := context.Background()
+	if _, err := zdb.Execute(ctx, "INSERT INTO sessions (id, client_id, token_hash, user_id, expires_at) VALUES ('session-1', 'client-1', $1, 'user-1', $2)",
+		base64.StdEncoding.EncodeToString(tokenHash[:]), time.Now().Add(time.Hour).UTC().Format(time.RFC3339)); err != nil {
+		t.Fatalf("Failed to insert session: %v", err)
+	}
+	impersonatedHash := sha256.Sum256([]byte("impersonated-token"))
+	if _, err := zdb.Execute(ctx, "INSERT INTO sessions (id, client_id, token_hash, user_id, expires_at, impersonated_by) VALUES ('session-2', 'client-1', $1, 'user-1', $2, 'root-1')",
+		base64.StdEncoding.EncodeToString(impersonatedHash[:]), time.Now().Add(time.Hour).UTC().Format(time.RFC3339)); err != nil {
+		t.Fatalf("Failed to insert impersonated session: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	NewServer(zdb, testServerConfig(t)).Mount(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMountedRouteUpgradesOnSharedRouter(t *testing.T) {
+	server := newMountedTestServer(t)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + MountedPath + "?project=project-1"
+
+	header := http.Header{}
+	header.Set("Cookie", "auth_token=valid-token")
+	conn, resp, err := gorilla.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Upgrade on %s failed: %v", MountedPath, err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected 101 Switching Protocols, got %d", resp.StatusCode)
+	}
+
+	// The connection is live on the hub and joined to the requested project
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read project_joined: %v", err)
+	}
+	var message struct {
+		Type string `json:"type"`
+		Data struct {
+			ProjectID string `json:"project_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		t.Fatalf("Invalid message %q: %v", raw, err)
+	}
+	if message.Type != "project_joined" || message.Data.ProjectID != "project-1" {
+		t.Errorf("Expected project_joined for project-1, got %s", raw)
+	}
+}
+
+func TestMountedRouteRejectsUnauthenticated(t *testing.T) {
+	server := newMountedTestServer(t)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + MountedPath + "?project=project-1&token=wrong-token"
+
+	_, resp, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected the upgrade to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", resp)
+	}
+}
+
+func TestMountedRouteAcceptsImpersonatedSessions(t *testing.T) {
+	server := newMountedTestServer(t)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + MountedPath + "?project=project-1&token=impersonated-token"
+
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Upgrade with an impersonated session failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "connection_established", "data": map[string]interface{}{}}); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+
+	// The handshake reply tells the client it is being impersonated
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read connection_established: %v", err)
+		}
+		var message struct {
+			Type string `json:"type"`
+			Data struct {
+				UserID         string `json:"user_id"`
+				ImpersonatedBy string `json:"impersonated_by"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			t.Fatalf("Invalid message %q: %v", raw, err)
+		}
+		if message.Type != "connection_established" {
+			continue
+		}
+		if message.Data.UserID != "user-1" || message.Data.ImpersonatedBy != "root-1" {
+			t.Errorf("Expected user-1 impersonated by root-1, got %s", raw)
+		}
+		return
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/templates"
+	"zlay-backend/internal/tools"
+)
+
+// TemplatesListData represents data for templates_list type
+type TemplatesListData struct {
+	Templates []templates.Template `json:"templates"`
+}
+
+// handleListTemplates sends the prompt templates of the connection's project
+func (h *Handler) handleListTemplates(conn *Connection) {
+	if _, ok := h.projectRole(conn); !ok {
+		return
+	}
+
+	list, err := templates.List(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, conn.ProjectID)
+	if err != nil {
+		log.Printf("Error listing templates: %v", err)
+		conn.sendError(apierror.CodeDatabaseError, nil)
+		return
+	}
+	h.hub.SendToConnection(conn, WebSocketMessage{
+		Type:      "templates_list",
+		Data:      TemplatesListData{Templates: list},
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+// renderTemplate renders a template of the connection's project for
+// create_conversation, sending the error and returning false when it cannot be used
+func (h *Handler) renderTemplate(conn *Connection, templateID string, variables map[string]string) (string, bool) {
+	if _, ok := h.projectRole(conn); !ok {
+		return "", false
+	}
+
+	rendered, err := templates.Use(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, conn.ProjectID, templateID, variables)
+	if err != nil {
+		code, details := templates.ErrorCode(err)
+		if code == apierror.CodeDatabaseError {
+			log.Printf("Error rendering template %s: %v", templateID, err)
+		}
+		conn.sendError(code, details)
+		return "", false
+	}
+	return rendered, true
+}
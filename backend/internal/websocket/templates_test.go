@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+const testTemplateID = "6f1c1f0e-8c3a-4d59-9f57-0b6a3c1d2e4f"
+
+func TestTemplateErrorsLeaveNoConversation(t *testing.T) {
+	zdb := newUnconfiguredClientDB(t)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"INSERT INTO prompt_templates (id, project_id, name, description, content, created_by, created_at, updated_at) VALUES ('" + testTemplateID + "', 'project-1', 'Sales', '', 'Sales for {{region}}', 'user-1', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+	} {
+		if _, err := zdb.Execute(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up templates: %v", err)
+		}
+	}
+
+	hub := NewHub()
+	handler := NewHandler(hub, zdb, NewClientConfigCache(zdb, testServerConfig(t)))
+	handler.SetChatService(chat.NewChatService(&tools.ZlayDBAdapter{DB: zdb}, &tools.WebSocketAdapter{Hub: hub},
+		unusedLLMClient{t}, tools.NewToolRegistry()))
+	member := NewConnection(nil, "user-1", "client-1", hub)
+	member.ProjectID = "project-1"
+	member.handler = handler
+	stranger := NewConnection(nil, "user-2", "client-1", hub)
+	stranger.ProjectID = "project-1"
+	stranger.handler = handler
+
+	read := func(conn *Connection) (string, ErrorData, []byte) {
+		t.Helper()
+		select {
+		case raw := <-conn.send:
+			var message struct {
+				Type string    `json:"type"`
+				Data ErrorData `json:"data"`
+			}
+			if err := json.Unmarshal(raw, &message); err != nil {
+				t.Fatalf("Invalid reply: %v", err)
+			}
+			return message.Type, message.Data, raw
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a reply")
+		}
+		return "", ErrorData{}, nil
+	}
+
+	member.dispatch([]byte(`{"type":"list_templates"}`))
+	if messageType, _, raw := read(member); messageType != "templates_list" || !strings.Contains(string(raw), `"variables":["region"]`) {
+		t.Fatalf("Expected the template in templates_list, got %s", raw)
+	}
+
+	for _, tt := range []struct {
+		conn  *Connection
+		frame string
+		code  string
+	}{
+		{member, `{"type":"create_conversation","data":{"template_id":"` + testTemplateID + `","variables":{"other":"x"}}}`, apierror.CodeTemplateVariablesMissing},
+		{member, `{"type":"create_conversation","data":{"template_id":"` + testTemplateID + `","variables":{"region":" "}}}`, apierror.CodeTemplateVariablesMissing},
+		{member, `{"type":"create_conversation","data":{"template_id":"not-a-template"}}`, apierror.CodeTemplateNotFound},
+		{stranger, `{"type":"create_conversation","data":{"template_id":"` + testTemplateID + `","variables":{"region":"EMEA"}}}`, apierror.CodeProjectNotFound},
+		{stranger, `{"type":"list_templates"}`, apierror.CodeProjectNotFound},
+	} {
+		tt.conn.dispatch([]byte(tt.frame))
+		if messageType, data, _ := read(tt.conn); messageType != "error" || data.Code != tt.code {
+			t.Errorf("%s: expected %s, got %s %+v", tt.frame, tt.code, messageType, data)
+		}
+	}
+
+	row, err := zdb.QueryRow(ctx, "SELECT COUNT(*) FROM conversations")
+	if err != nil {
+		t.Fatalf("Failed to count conversations: %v", err)
+	}
+	if count, _ := row.Values[0].AsInt64(); count != 1 {
+		t.Errorf("Expected template errors to create no conversation, got %d", count)
+	}
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/widget"
+)
+
+// Error codes sent to widget visitors when a message is refused
+const (
+	ErrCodeRateLimited      = apierror.CodeRateLimited
+	ErrCodeVisitorForbidden = apierror.CodeVisitorForbidden
+)
+
+// authenticateVisitor verifies a widget visitor token by HMAC and expiry, then
+// checks that the visitor still exists for the client the token is bound to
+func (h *Handler) authenticateVisitor(token string) (*widget.Claims, error) {
+	if h.widgetSigner == nil {
+		return nil, widget.ErrInvalidToken
+	}
+	claims, err := h.widgetSigner.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := widget.ValidateVisitor(context.Background(), &tools.ZlayDBAdapter{DB: h.db}, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// serveVisitor upgrades an authenticated widget visitor, applying the client's
+// visitor rate and token limits and joining the widget project
+func (h *Handler) serveVisitor(c *gin.Context, claims *widget.Claims) {
+	limits, err := widget.ClientLimits(c.Request.Context(), &tools.ZlayDBAdapter{DB: h.db}, claims.ClientID)
+	if err != nil {
+		log.Printf("Failed to load widget limits for client %s: %v", claims.ClientID, err)
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	conn := NewConnection(ws, claims.UserID, claims.ClientID, h.hub)
+	conn.Language = apierror.Language(c)
+	conn.setRequestID(upgradeRequestID(c))
+	conn.handler = h
+	conn.SetTokenLimit(limits.TokenLimit)
+	conn.visitorLimiter = widget.NewRateLimiter(limits.RateLimit, time.Minute)
+
+	h.hub.register <- conn
+	go conn.WritePump()
+	go conn.ReadPump()
+	conn.JoinProject(claims.ProjectID)
+
+	log.Printf("Widget visitor %s connected for client %s", claims.UserID, claims.ClientID)
+}
+
+// isVisitor reports whether the connection belongs to an anonymous widget visitor
+func (c *Connection) isVisitor() bool {
+	return c.visitorLimiter != nil
+}
+
+// rejectForVisitor enforces the widget restrictions on a validated message and
+// reports whether it was refused. Visitors stay in the widget project, and every
+// message that starts a model reply counts against the per-minute rate limit.
+func (c *Connection) rejectForVisitor(messageType string, req messageRequest) bool {
+	if !c.isVisitor() {
+		return false
+	}
+
+	startsReply := false
+	switch r := req.(type) {
+	case *ProjectRequest:
+		c.sendVisitorError(messageType, ErrCodeVisitorForbidden, nil)
+		return true
+	case *UserMessageRequest, *ResumeConversationRequest:
+		startsReply = true
+	case *CreateConversationRequest:
+		startsReply = r.InitialMessage != "" || r.TemplateID != ""
+	}
+
+	if startsReply && !c.visitorLimiter.Allow() {
+		c.sendVisitorError(messageType, ErrCodeRateLimited,
+			map[string]interface{}{"limit_per_minute": c.visitorLimiter.Limit()})
+		return true
+	}
+	return false
+}
+
+func (c *Connection) sendVisitorError(messageType, code string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["type"] = messageType
+	c.sendError(code, details)
+}
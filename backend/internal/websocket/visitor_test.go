@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/widget"
+)
+
+func TestVisitorTokenConnectsToWidgetProject(t *testing.T) {
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "owner", "widget-project")
+	dbtest.Seed(t, zdb, "UPDATE clients SET widget_project_id = 'widget-project', widget_rate_limit = 3, widget_token_limit = 500 WHERE id = 'client-1'")
+
+	ctx := context.Background()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	server := NewServer(zdb, testServerConfig(t))
+	server.Mount(router)
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+
+	signer := server.GetWidgetSigner()
+	expiresAt := signer.ExpiresAt()
+	visitorID, err := widget.CreateVisitor(ctx, &tools.ZlayDBAdapter{DB: zdb}, "client-1", expiresAt)
+	if err != nil {
+		t.Fatalf("CreateVisitor failed: %v", err)
+	}
+	token := signer.Issue(visitorID, "client-1", "widget-project", expiresAt)
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + MountedPath
+	refused := map[string]int{
+		"?token=" + token + "&project=other-project":                                                              http.StatusForbidden,
+		"?token=" + widget.NewSigner("other-secret", 0).Issue(visitorID, "client-1", "widget-project", expiresAt): http.StatusUnauthorized,
+		"?token=" + signer.Issue("unknown-visitor", "client-1", "widget-project", expiresAt):                      http.StatusUnauthorized,
+	}
+	for query, status := range refused {
+		_, resp, err := gorilla.DefaultDialer.Dial(wsURL+query, nil)
+		if err == nil || resp == nil || resp.StatusCode != status {
+			t.Errorf("Expected %d, got %v (%v)", status, resp, err)
+		}
+	}
+
+	// No project parameter is needed; the token carries the widget project
+	conn, _, err := gorilla.DefaultDialer.Dial(wsURL+"?token="+token, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var joined struct {
+		Type string            `json:"type"`
+		Data ProjectJoinedData `json:"data"`
+	}
+	if err := conn.ReadJSON(&joined); err != nil {
+		t.Fatalf("Failed to read project_joined: %v", err)
+	}
+	if joined.Type != "project_joined" || joined.Data.ProjectID != "widget-project" {
+		t.Errorf("Expected to join the widget project, got %+v", joined)
+	}
+}
+
+func TestVisitorConnectionRestrictions(t *testing.T) {
+	conn := NewConnection(nil, "visitor-1", "client-1", NewHub())
+	conn.ProjectID = "widget-project"
+	conn.visitorLimiter = widget.NewRateLimiter(1, time.Minute)
+
+	readError := func(frame string) ErrorData {
+		t.Helper()
+		conn.dispatch([]byte(frame))
+		var message struct {
+			Type string    `json:"type"`
+			Data ErrorData `json:"data"`
+		}
+		select {
+		case raw := <-conn.send:
+			if err := json.Unmarshal(raw, &message); err != nil {
+				t.Fatalf("Invalid reply: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected an error reply", frame)
+		}
+		if message.Type != "error" {
+			t.Errorf("%s: expected an error, got %s", frame, message.Type)
+		}
+		return message.Data
+	}
+
+	if got := readError(`{"type":"join_project","data":{"project_id":"project-1"}}`); got.Code != ErrCodeVisitorForbidden {
+		t.Errorf("Expected %s for join_project, got %+v", ErrCodeVisitorForbidden, got)
+	}
+	if got := readError(`{"type":"leave_project","data":{"project_id":"widget-project"}}`); got.Code != ErrCodeVisitorForbidden {
+		t.Errorf("Expected %s for leave_project, got %+v", ErrCodeVisitorForbidden, got)
+	}
+
+	// The first message uses the allowance (there is no handler, so nothing is sent); the next is refused
+	conn.dispatch([]byte(`{"type":"user_message","data":{"conversation_id":"c1","content":"hi"}}`))
+	if got := readError(`{"type":"create_conversation","data":{"initial_message":"again"}}`); got.Code != ErrCodeRateLimited {
+		t.Errorf("Expected %s, got %+v", ErrCodeRateLimited, got)
+	}
+	if conn.ProjectID != "widget-project" {
+		t.Errorf("Expected the visitor to stay in the widget project, got %q", conn.ProjectID)
+	}
+}
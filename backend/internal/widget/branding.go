@@ -0,0 +1,155 @@
+package widget
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"zlay-backend/internal/tools"
+)
+
+// Branding limits
+const (
+	MaxDisplayNameChars    = 100
+	MaxWelcomeMessageChars = 2000
+	MaxBrandingColors      = 16
+	MaxBrandingFeatures    = 32
+)
+
+var (
+	// ErrClientNotFound is returned when branding is loaded for a missing or inactive client
+	ErrClientNotFound = errors.New("client not found")
+
+	colorNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	colorPattern     = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+	featurePattern   = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+)
+
+// Branding is how the embedded widget presents a client before anyone signs
+// in. It is public: whatever is stored here is served to any allowed origin.
+type Branding struct {
+	DisplayName    string            `json:"display_name"`
+	Colors         map[string]string `json:"colors"` // Theme colors by name, such as "primary", as #rgb, #rrggbb or #rrggbbaa
+	WelcomeMessage string            `json:"welcome_message"`
+	Features       []string          `json:"features"` // Widget features the client allows, such as "file_upload"
+}
+
+// BrandingError reports the branding field that was refused and why
+type BrandingError struct {
+	Field  string
+	Reason string
+}
+
+func (e *BrandingError) Error() string {
+	return fmt.Sprintf("invalid branding %s: %s", e.Field, e.Reason)
+}
+
+// Normalize trims the text fields, lowercases colors and de-duplicates
+// features, and checks every field against the branding limits
+func (b Branding) Normalize() (Branding, error) {
+	normalized := Branding{
+		DisplayName:    strings.TrimSpace(b.DisplayName),
+		Colors:         map[string]string{},
+		WelcomeMessage: strings.TrimSpace(b.WelcomeMessage),
+		Features:       []string{},
+	}
+	if utf8.RuneCountInString(normalized.DisplayName) > MaxDisplayNameChars {
+		return Branding{}, &BrandingError{Field: "display_name", Reason: fmt.Sprintf("longer than %d characters", MaxDisplayNameChars)}
+	}
+	if utf8.RuneCountInString(normalized.WelcomeMessage) > MaxWelcomeMessageChars {
+		return Branding{}, &BrandingError{Field: "welcome_message", Reason: fmt.Sprintf("longer than %d characters", MaxWelcomeMessageChars)}
+	}
+
+	if len(b.Colors) > MaxBrandingColors {
+		return Branding{}, &BrandingError{Field: "colors", Reason: fmt.Sprintf("more than %d colors", MaxBrandingColors)}
+	}
+	for name, color := range b.Colors {
+		if !colorNamePattern.MatchString(name) {
+			return Branding{}, &BrandingError{Field: "colors", Reason: fmt.Sprintf("%q is not a valid color name", name)}
+		}
+		color = strings.TrimSpace(color)
+		if !colorPattern.MatchString(color) {
+			return Branding{}, &BrandingError{Field: "colors." + name, Reason: "not a hex color"}
+		}
+		normalized.Colors[name] = strings.ToLower(color)
+	}
+
+	seen := map[string]bool{}
+	for _, feature := range b.Features {
+		feature = strings.TrimSpace(feature)
+		if !featurePattern.MatchString(feature) {
+			return Branding{}, &BrandingError{Field: "features", Reason: fmt.Sprintf("%q is not a valid feature name", feature)}
+		}
+		if !seen[feature] {
+			seen[feature] = true
+			normalized.Features = append(normalized.Features, feature)
+		}
+	}
+	if len(normalized.Features) > MaxBrandingFeatures {
+		return Branding{}, &BrandingError{Field: "features", Reason: fmt.Sprintf("more than %d features", MaxBrandingFeatures)}
+	}
+	return normalized, nil
+}
+
+// DecodeBranding reads a stored branding column; an empty or malformed value
+// is the empty branding
+func DecodeBranding(raw []byte) Branding {
+	branding := Branding{}
+	if len(raw) > 0 {
+		json.Unmarshal(raw, &branding)
+	}
+	if branding.Colors == nil {
+		branding.Colors = map[string]string{}
+	}
+	if branding.Features == nil {
+		branding.Features = []string{}
+	}
+	return branding
+}
+
+// LoadBranding returns an active client's name, branding and when the
+// branding last changed, or its creation time if it never did
+func LoadBranding(ctx context.Context, db tools.DBConnection, clientID string) (string, Branding, time.Time, error) {
+	var name string
+	var raw []byte
+	var updatedAt, createdAt sql.NullTime
+	err := db.QueryRow(ctx,
+		"SELECT name, branding, branding_updated_at, created_at FROM clients WHERE id = $1 AND is_active = true",
+		clientID).Scan(&name, &raw, &updatedAt, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", Branding{}, time.Time{}, ErrClientNotFound
+	}
+	if err != nil {
+		return "", Branding{}, time.Time{}, fmt.Errorf("failed to load branding: %w", err)
+	}
+
+	changed := createdAt.Time
+	if updatedAt.Valid {
+		changed = updatedAt.Time
+	}
+	return name, DecodeBranding(raw), changed.UTC(), nil
+}
+
+// SaveBranding replaces an active client's branding, which must already be normalized
+func SaveBranding(ctx context.Context, db tools.DBConnection, clientID string, branding Branding) error {
+	encoded, err := json.Marshal(branding)
+	if err != nil {
+		return fmt.Errorf("failed to encode branding: %w", err)
+	}
+	result, err := db.Exec(ctx,
+		"UPDATE clients SET branding = $1, branding_updated_at = $2, version = version + 1 WHERE id = $3 AND is_active = true",
+		string(encoded), time.Now().UTC(), clientID)
+	if err != nil {
+		return fmt.Errorf("failed to save branding: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package widget
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRateLimit is how many messages a visitor may send per window when the client sets no limit
+const DefaultRateLimit = 10
+
+// RateLimiter allows at most limit events in any sliding window
+type RateLimiter struct {
+	mutex  sync.Mutex
+	limit  int
+	window time.Duration
+	events []time.Time
+	now    func() time.Time
+}
+
+// NewRateLimiter creates a limiter; a non-positive limit falls back to DefaultRateLimit
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	if limit <= 0 {
+		limit = DefaultRateLimit
+	}
+	return &RateLimiter{limit: limit, window: window, now: time.Now}
+}
+
+// Limit returns how many events are allowed per window
+func (r *RateLimiter) Limit() int {
+	return r.limit
+}
+
+// Allow records an event and reports whether it is within the limit.
+// Rejected events are not counted.
+func (r *RateLimiter) Allow() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	cutoff := now.Add(-r.window)
+	kept := r.events[:0]
+	for _, t := range r.events {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	r.events = kept
+
+	if len(r.events) >= r.limit {
+		return false
+	}
+	r.events = append(r.events, now)
+	return true
+}
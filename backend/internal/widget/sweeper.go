@@ -0,0 +1,129 @@
+package widget
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"zlay-backend/internal/tools"
+)
+
+// DefaultSweepBatchSize limits how many visitors one cleanup transaction deletes
+const DefaultSweepBatchSize = 100
+
+// VisitorSweeper deletes expired visitor users together with their conversations
+type VisitorSweeper struct {
+	db        tools.DBConnection
+	batchSize int
+	now       func() time.Time
+}
+
+// NewVisitorSweeper creates a sweeper; a non-positive batch size uses the default
+func NewVisitorSweeper(db tools.DBConnection, batchSize int) *VisitorSweeper {
+	if batchSize <= 0 {
+		batchSize = DefaultSweepBatchSize
+	}
+	return &VisitorSweeper{db: db, batchSize: batchSize, now: time.Now}
+}
+
+// Run deletes expired visitors every interval until ctx is cancelled
+func (s *VisitorSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if deleted, err := s.SweepOnce(ctx); err != nil {
+			log.Printf("Widget visitor cleanup failed after %d visitors: %v", deleted, err)
+		} else if deleted > 0 {
+			log.Printf("Deleted %d expired widget visitors", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepOnce deletes all expired visitors, batch by batch, and returns how many were removed
+func (s *VisitorSweeper) SweepOnce(ctx context.Context) (int, error) {
+	cutoff := s.now().UTC()
+	total := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		found, deleted, err := s.sweepBatch(ctx, cutoff)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if found < s.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// sweepBatch deletes up to batchSize expired visitors with their conversations and messages.
+// It returns how many candidates were found and how many were actually deleted.
+func (s *VisitorSweeper) sweepBatch(ctx context.Context, cutoff time.Time) (int, int, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT id FROM users WHERE is_visitor = true AND expires_at < $1 ORDER BY expires_at LIMIT $2",
+		cutoff, s.batchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find expired visitors: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan visitor id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	for i := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	in := strings.Join(placeholders, ", ")
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return len(ids), 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE user_id IN ("+in+"))", ids...); err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete messages: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM conversations WHERE user_id IN ("+in+")", ids...); err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete conversations: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE is_visitor = true AND id IN ("+in+")", ids...)
+	if err != nil {
+		return len(ids), 0, fmt.Errorf("failed to delete visitors: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return len(ids), 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	return len(ids), int(affected), nil
+}
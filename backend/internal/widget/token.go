@@ -0,0 +1,118 @@
+package widget
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenPrefix marks widget visitor tokens so they can be told apart from session tokens
+const TokenPrefix = "wv1."
+
+// DefaultTokenTTL is how long a visitor token, and the visitor user behind it, stays valid
+const DefaultTokenTTL = 30 * time.Minute
+
+var (
+	ErrInvalidToken = errors.New("invalid widget token")
+	ErrTokenExpired = errors.New("widget token expired")
+)
+
+// Claims identifies the visitor, client and widget project a token was issued for
+type Claims struct {
+	UserID    string
+	ClientID  string
+	ProjectID string
+	ExpiresAt time.Time
+}
+
+// Signer issues and verifies widget visitor tokens. Tokens are verified by HMAC
+// and expiry alone; no sessions row is written for them.
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewSigner creates a signer; an empty secret generates a random one, which
+// means outstanding visitor tokens stop working when the process restarts
+func NewSigner(secret string, ttl time.Duration) *Signer {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	return &Signer{secret: key, ttl: ttl, now: time.Now}
+}
+
+// TTL returns how long issued tokens stay valid
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// ExpiresAt returns the expiry of a token issued now
+func (s *Signer) ExpiresAt() time.Time {
+	return s.now().Add(s.ttl).Truncate(time.Second)
+}
+
+// Issue signs a token for a visitor that expires at expiresAt
+func (s *Signer) Issue(userID, clientID, projectID string, expiresAt time.Time) string {
+	payload := strings.Join([]string{userID, clientID, projectID, strconv.FormatInt(expiresAt.Unix(), 10)}, "|")
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.signature(payload)
+}
+
+// Verify checks a token's signature and expiry and returns its claims
+func (s *Signer) Verify(token string) (*Claims, error) {
+	if !IsToken(token) {
+		return nil, ErrInvalidToken
+	}
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, TokenPrefix), ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	payload := string(payloadBytes)
+	if !hmac.Equal([]byte(signature), []byte(s.signature(payload))) {
+		return nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, ErrInvalidToken
+	}
+	expiresUnix, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{
+		UserID:    parts[0],
+		ClientID:  parts[1],
+		ProjectID: parts[2],
+		ExpiresAt: time.Unix(expiresUnix, 0),
+	}
+	if s.now().After(claims.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// IsToken reports whether a bearer token is a widget visitor token
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, TokenPrefix)
+}
+
+func (s *Signer) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(TokenPrefix + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
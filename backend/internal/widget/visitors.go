@@ -0,0 +1,195 @@
+package widget
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"zlay-backend/internal/domains"
+	"zlay-backend/internal/tools"
+)
+
+// DefaultTokenLimit is the per-connection token budget of a visitor when the client sets none
+const DefaultTokenLimit = 20000
+
+var (
+	// ErrOriginNotAllowed is returned when an Origin does not belong to an active domain of an active client
+	ErrOriginNotAllowed = errors.New("origin not allowed")
+	// ErrVisitorNotFound is returned when a token's visitor was deleted, expired or moved to another client
+	ErrVisitorNotFound = errors.New("widget visitor not found")
+)
+
+// Limits are the stricter limits a client applies to visitor connections
+type Limits struct {
+	RateLimit  int   // User messages per minute
+	TokenLimit int64 // Tokens per connection
+}
+
+// NormalizeOrigin returns the normalized host of a browser Origin header, as
+// domains stores it, or "" when the value is not a bare http(s) origin. The
+// port is dropped, like the domain lookup for regular requests does.
+func NormalizeOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return ""
+	}
+	return domains.Host(u.Host)
+}
+
+// ClientForOrigin resolves the client with an active domain matching the
+// origin's host: an exact domain, or else the wildcard with the longest suffix
+func ClientForOrigin(ctx context.Context, db tools.DBConnection, origin string) (string, error) {
+	host := NormalizeOrigin(origin)
+	if host == "" {
+		return "", ErrOriginNotAllowed
+	}
+
+	condition, args := domains.CandidateCondition("d.normalized_domain", host)
+	rows, err := db.Query(ctx,
+		`SELECT d.normalized_domain, d.client_id FROM domains d
+		JOIN clients c ON c.id = d.client_id
+		WHERE `+condition+` AND d.is_active = true AND c.is_active = true`,
+		args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve origin: %w", err)
+	}
+	defer rows.Close()
+
+	entries := map[string]string{}
+	for rows.Next() {
+		var domain, clientID string
+		if err := rows.Scan(&domain, &clientID); err != nil {
+			return "", fmt.Errorf("failed to resolve origin: %w", err)
+		}
+		entries[domain] = clientID
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to resolve origin: %w", err)
+	}
+
+	clientID, ok := domains.Match(entries, host)
+	if !ok {
+		return "", ErrOriginNotAllowed
+	}
+	return clientID, nil
+}
+
+// ClientLimits loads a client's visitor limits; unset values use the defaults
+func ClientLimits(ctx context.Context, db tools.DBConnection, clientID string) (Limits, error) {
+	var rateLimit, tokenLimit sql.NullInt64
+	err := db.QueryRow(ctx,
+		"SELECT widget_rate_limit, widget_token_limit FROM clients WHERE id = $1",
+		clientID).Scan(&rateLimit, &tokenLimit)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Limits{}, fmt.Errorf("failed to load widget limits: %w", err)
+	}
+
+	limits := Limits{RateLimit: DefaultRateLimit, TokenLimit: DefaultTokenLimit}
+	if rateLimit.Valid && rateLimit.Int64 > 0 {
+		limits.RateLimit = int(rateLimit.Int64)
+	}
+	if tokenLimit.Valid && tokenLimit.Int64 > 0 {
+		limits.TokenLimit = tokenLimit.Int64
+	}
+	return limits, nil
+}
+
+// EnsureProject returns the client's widget project, creating it on first use.
+// The project is owned by an inactive service user that cannot sign in, so it
+// does not show up in any member's project list and visitors get no tool access.
+func EnsureProject(ctx context.Context, db tools.DBConnection, clientID string) (string, error) {
+	if projectID, err := widgetProject(ctx, db, clientID); err != nil || projectID != "" {
+		return projectID, err
+	}
+
+	ownerID := uuid.New().String()
+	projectID := uuid.New().String()
+	now := time.Now().UTC()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO users (id, client_id, username, password_hash, is_active, is_visitor, created_at)
+		VALUES ($1, $2, $3, '!', false, false, $4)`,
+		ownerID, clientID, "widget-"+ownerID, now); err != nil {
+		return "", fmt.Errorf("failed to create widget owner: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO projects (id, user_id, name, description, is_active, created_at)
+		VALUES ($1, $2, 'Widget', 'Conversations from the embedded chat widget', true, $3)`,
+		projectID, ownerID, now); err != nil {
+		return "", fmt.Errorf("failed to create widget project: %w", err)
+	}
+	// Only the first concurrent request claims the client; the others roll back
+	result, err := tx.ExecContext(ctx,
+		"UPDATE clients SET widget_project_id = $1 WHERE id = $2 AND widget_project_id IS NULL",
+		projectID, clientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to assign widget project: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		tx.Rollback()
+		return widgetProject(ctx, db, clientID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return projectID, nil
+}
+
+func widgetProject(ctx context.Context, db tools.DBConnection, clientID string) (string, error) {
+	var projectID sql.NullString
+	err := db.QueryRow(ctx, "SELECT widget_project_id FROM clients WHERE id = $1", clientID).Scan(&projectID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to load widget project: %w", err)
+	}
+	return projectID.String, nil
+}
+
+// CreateVisitor inserts an anonymous visitor user that expires at expiresAt
+func CreateVisitor(ctx context.Context, db tools.DBConnection, clientID string, expiresAt time.Time) (string, error) {
+	userID := uuid.New().String()
+	_, err := db.Exec(ctx,
+		`INSERT INTO users (id, client_id, username, password_hash, is_active, is_visitor, expires_at, created_at)
+		VALUES ($1, $2, $3, '!', true, true, $4, $5)`,
+		userID, clientID, "visitor-"+userID, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("failed to create visitor: %w", err)
+	}
+	return userID, nil
+}
+
+// ValidateVisitor checks that a verified token still matches an active, unexpired
+// visitor of the same client and that the project is that client's widget project
+func ValidateVisitor(ctx context.Context, db tools.DBConnection, claims *Claims) error {
+	var expiresAt time.Time
+	err := db.QueryRow(ctx,
+		`SELECT u.expires_at FROM users u
+		JOIN clients c ON c.id = u.client_id
+		WHERE u.id = $1 AND u.client_id = $2 AND u.is_visitor = true AND u.is_active = true
+			AND c.is_active = true AND c.widget_project_id = $3`,
+		claims.UserID, claims.ClientID, claims.ProjectID).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrVisitorNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load visitor: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return ErrVisitorNotFound
+	}
+	return nil
+}
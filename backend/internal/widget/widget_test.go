@@ -0,0 +1,195 @@
+package widget
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	signer := NewSigner("secret", time.Minute)
+	expiresAt := signer.ExpiresAt()
+	token := signer.Issue("visitor-1", "client-1", "project-1", expiresAt)
+
+	if !IsToken(token) {
+		t.Fatalf("Expected %q to carry the %s prefix", token, TokenPrefix)
+	}
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.UserID != "visitor-1" || claims.ClientID != "client-1" || claims.ProjectID != "project-1" || !claims.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestTokenRejectsTamperingAndExpiry(t *testing.T) {
+	signer := NewSigner("secret", time.Minute)
+	token := signer.Issue("visitor-1", "client-1", "project-1", signer.ExpiresAt())
+	encoded, signature, _ := strings.Cut(strings.TrimPrefix(token, TokenPrefix), ".")
+
+	// Claims re-encoded for another client keep the original signature
+	other := NewSigner("secret", time.Minute).Issue("visitor-1", "client-2", "project-1", signer.ExpiresAt())
+	otherEncoded, _, _ := strings.Cut(strings.TrimPrefix(other, TokenPrefix), ".")
+
+	for name, tampered := range map[string]string{
+		"other client":    TokenPrefix + otherEncoded + "." + signature,
+		"bad signature":   TokenPrefix + encoded + "." + signature[:len(signature)-2] + "AA",
+		"missing prefix":  encoded + "." + signature,
+		"no signature":    TokenPrefix + encoded,
+		"other secret":    NewSigner("other", time.Minute).Issue("visitor-1", "client-1", "project-1", signer.ExpiresAt()),
+		"session token":   "3f2a9c0e-session-token",
+		"garbled payload": TokenPrefix + "!!!." + signature,
+	} {
+		if _, err := signer.Verify(tampered); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := signer.Verify(token); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	tests := map[string]string{
+		"https://shop.example.com":          "shop.example.com",
+		"http://Shop.Example.COM:8080":      "shop.example.com",
+		"https://shop.example.com/":         "shop.example.com",
+		"https://shop.example.com.":         "shop.example.com",
+		"null":                              "",
+		"":                                  "",
+		"shop.example.com":                  "",
+		"ftp://shop.example.com":            "",
+		"https://user@shop.example.com":     "",
+		"https://shop.example.com/path":     "",
+		"https://shop.example.com?x=1":      "",
+		"https://shop.example.com#fragment": "",
+	}
+	for origin, want := range tests {
+		if got := NormalizeOrigin(origin); got != want {
+			t.Errorf("NormalizeOrigin(%q) = %q, want %q", origin, got, want)
+		}
+	}
+}
+
+func TestRateLimiterSlidingWindow(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if !limiter.Allow() || !limiter.Allow() {
+		t.Fatal("Expected the first two events to be allowed")
+	}
+	if limiter.Allow() {
+		t.Error("Expected the third event within the window to be rejected")
+	}
+
+	now = now.Add(61 * time.Second)
+	if !limiter.Allow() {
+		t.Error("Expected an event to be allowed once the window has passed")
+	}
+}
+
+func TestSweeperDeletesExpiredVisitors(t *testing.T) {
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "member", "project-1")
+	dbtest.Seed(t, zdb,
+		"INSERT INTO conversations (id, user_id, project_id, title) VALUES ('member-chat', 'member', 'project-1', 'Chat')",
+		"INSERT INTO messages (id, conversation_id, role, content) VALUES ('member-message', 'member-chat', 'user', 'Hi')",
+	)
+	conn := &tools.ZlayDBAdapter{DB: zdb}
+	ctx := context.Background()
+
+	expired, err := CreateVisitor(ctx, conn, "client-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CreateVisitor failed: %v", err)
+	}
+	active, err := CreateVisitor(ctx, conn, "client-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateVisitor failed: %v", err)
+	}
+	for _, user := range []string{expired, active} {
+		if _, err := conn.Exec(ctx, "INSERT INTO conversations (id, user_id, project_id, title) VALUES ($1, $2, 'project-1', 'Chat')", user+"-chat", user); err != nil {
+			t.Fatalf("Failed to seed conversation: %v", err)
+		}
+		if _, err := conn.Exec(ctx, "INSERT INTO messages (id, conversation_id, role, content) VALUES ($1, $2, 'user', 'Hi')", user+"-message", user+"-chat"); err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+	}
+
+	deleted, err := NewVisitorSweeper(conn, 1).SweepOnce(ctx)
+	if err != nil || deleted != 1 {
+		t.Fatalf("Expected 1 deleted visitor, got %d (%v)", deleted, err)
+	}
+
+	for table, want := range map[string]int{"users": 2, "conversations": 2, "messages": 2} {
+		var count int
+		if err := conn.QueryRow(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		if count != want {
+			t.Errorf("Expected %d %s, got %d", want, table, count)
+		}
+	}
+	var remaining int
+	conn.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE id = $1", expired).Scan(&remaining)
+	if remaining != 0 {
+		t.Error("Expected the expired visitor to be deleted")
+	}
+}
+
+func TestBrandingNormalize(t *testing.T) {
+	branding, err := Branding{
+		DisplayName:    "  Shop  ",
+		Colors:         map[string]string{"primary": "#1A2B3C", "accent": " #fff "},
+		WelcomeMessage: " Hi! ",
+		Features:       []string{"file_upload", " file_upload", "history"},
+	}.Normalize()
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if branding.DisplayName != "Shop" || branding.WelcomeMessage != "Hi!" {
+		t.Errorf("Expected trimmed text, got %+v", branding)
+	}
+	if branding.Colors["primary"] != "#1a2b3c" || branding.Colors["accent"] != "#fff" {
+		t.Errorf("Expected lowercased colors, got %v", branding.Colors)
+	}
+	if len(branding.Features) != 2 || branding.Features[0] != "file_upload" || branding.Features[1] != "history" {
+		t.Errorf("Expected de-duplicated features, got %v", branding.Features)
+	}
+
+	tooManyColors := map[string]string{}
+	for i := 0; i <= MaxBrandingColors; i++ {
+		tooManyColors[string(rune('a'+i))] = "#000"
+	}
+	for name, tt := range map[string]struct {
+		branding Branding
+		field    string
+	}{
+		"long display name":  {Branding{DisplayName: strings.Repeat("a", MaxDisplayNameChars+1)}, "display_name"},
+		"long welcome":       {Branding{WelcomeMessage: strings.Repeat("a", MaxWelcomeMessageChars+1)}, "welcome_message"},
+		"named color":        {Branding{Colors: map[string]string{"primary": "red"}}, "colors.primary"},
+		"script in color":    {Branding{Colors: map[string]string{"primary": "#fff;background:url(x)"}}, "colors.primary"},
+		"bad color name":     {Branding{Colors: map[string]string{"Primary Color": "#fff"}}, "colors"},
+		"too many colors":    {Branding{Colors: tooManyColors}, "colors"},
+		"bad feature name":   {Branding{Features: []string{"file upload"}}, "features"},
+		"empty feature name": {Branding{Features: []string{""}}, "features"},
+	} {
+		_, err := tt.branding.Normalize()
+		invalid, ok := err.(*BrandingError)
+		if !ok || invalid.Field != tt.field {
+			t.Errorf("%s: expected an error for %s, got %v", name, tt.field, err)
+		}
+	}
+
+	// Names are counted in characters, not bytes
+	if _, err := (Branding{DisplayName: strings.Repeat("é", MaxDisplayNameChars)}).Normalize(); err != nil {
+		t.Errorf("Expected %d characters to be allowed, got %v", MaxDisplayNameChars, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// getProjectEventsHandler lists a project's activity feed, newest first. Pages
+// continue from ?cursor=, the next_cursor of the previous page, and
+// ?event_type= may be repeated to keep only those types.
+func (app *App) getProjectEventsHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	options := activity.ListOptions{Cursor: c.Query("cursor"), EventTypes: c.QueryArray("event_type")}
+	for _, eventType := range options.EventTypes {
+		if !activity.IsEventType(eventType) {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "event_type"})
+			return
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "limit", "min": 1})
+			return
+		}
+		options.Limit = parsed
+	}
+
+	page, err := activity.List(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.readDB()}, projectID, options)
+	if errors.Is(err, activity.ErrInvalidCursor) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "cursor"})
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// recordActivity hands an event to the project activity feed without blocking
+func (app *App) recordActivity(event activity.Event) {
+	if app.WSServer == nil {
+		return
+	}
+	app.WSServer.GetActivityRecorder().Record(event)
+}
+
+// conversationProjectID returns the project of a conversation for its activity
+// events, or "" when it cannot be found, which drops them
+func (app *App) conversationProjectID(ctx context.Context, conversationID string) string {
+	var projectID string
+	err := (&tools.ZlayDBAdapter{DB: app.ZDB}).QueryRow(ctx,
+		"SELECT project_id FROM conversations WHERE id = $1", conversationID).Scan(&projectID)
+	if err != nil {
+		return ""
+	}
+	return projectID
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/tools"
+)
+
+func TestProjectEventsEndpoint(t *testing.T) {
+	app := newTenancyTestApp(t)
+	ctx := context.Background()
+	start := time.Now().UTC().Add(-time.Hour)
+	for i, eventType := range []string{activity.EventConversationCreated, activity.EventToolExecutionFailed, activity.EventConversationPinned} {
+		err := activity.Insert(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, activity.Event{
+			ID:          "event-" + eventType,
+			ProjectID:   "project-a",
+			ActorUserID: "user-a",
+			EventType:   eventType,
+			CreatedAt:   start.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Failed to insert event: %v", err)
+		}
+	}
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects/:id/events", app.authMiddleware(), app.getProjectEventsHandler)
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/events?limit=2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page activity.Page
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	if len(page.Events) != 2 || page.Events[0].EventType != activity.EventConversationPinned || !page.HasMore {
+		t.Fatalf("Expected the two newest events and more, got %+v", page)
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/events?limit=2&cursor="+page.NextCursor, "")
+	page = activity.Page{}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || len(page.Events) != 1 || page.Events[0].EventType != activity.EventConversationCreated || page.HasMore {
+		t.Errorf("Expected the oldest event on the last page, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "GET",
+		"/api/projects/project-a/events?event_type=tool_execution_failed&event_type=conversation_created", "")
+	page = activity.Page{}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || len(page.Events) != 2 || page.Events[0].EventType != activity.EventToolExecutionFailed {
+		t.Errorf("Expected the filtered events, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/api/projects/project-a/events?event_type=unknown",
+		"/api/projects/project-a/events?cursor=missing",
+		"/api/projects/project-a/events?limit=0",
+	} {
+		if w := tenancyRequest(router, "token-a", "GET", path, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	// Another tenant's feed is hidden
+	if w := tenancyRequest(router, "token-b", "GET", "/api/projects/project-a/events", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another tenant, got %d: %s", w.Code, w.Body.String())
+	}
+}
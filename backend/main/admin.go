@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/domains"
+	"zlay-backend/internal/widget"
 	"github.com/google/uuid"
 )
 
@@ -16,8 +24,23 @@ type Client struct {
 	AIAPIKey  *string `json:"ai_api_key"`
 	AIAPIURL  *string `json:"ai_api_url"`
 	APIModel  *string `json:"ai_api_model"`
+	MaxConcurrentStreams int `json:"max_concurrent_streams"`
+	WidgetRateLimit  int   `json:"widget_rate_limit"`
+	WidgetTokenLimit int64 `json:"widget_token_limit"`
+	AllowedModels []string `json:"allowed_models"`
+	// Streaming cadence; null uses STREAM_FLUSH_CHARS and STREAM_FLUSH_INTERVAL_MS
+	StreamFlushChars      *int `json:"stream_flush_chars"`
+	StreamFlushIntervalMs *int `json:"stream_flush_interval_ms"`
+	// Tokens a tool result may take in the LLM prompt before it is digested; null uses the default
+	ToolResultTokenLimit *int `json:"tool_result_token_limit"`
+	// Tokens the OpenAI-compatible API may use per UTC day; null is unlimited
+	DailyTokenBudget *int64 `json:"daily_token_budget"`
+	// String literals are stripped from the SQL kept in the tool execution audit log
+	RedactSQLLiterals bool `json:"redact_sql_literals"`
+	Branding  widget.Branding `json:"branding"`
 	IsActive  bool    `json:"is_active"`
 	CreatedAt string  `json:"created_at"`
+	Version   int64   `json:"version"` // Sent back in If-Match by updates
 }
 
 type Domain struct {
@@ -26,6 +49,7 @@ type Domain struct {
 	Domain    string `json:"domain"`
 	IsActive  bool   `json:"is_active"`
 	CreatedAt string `json:"created_at"`
+	Version   int64  `json:"version"`
 }
 
 type CreateClientRequest struct {
@@ -42,7 +66,21 @@ type UpdateClientRequest struct {
 	AIAPIKey *string `json:"ai_api_key"`
 	AIAPIURL *string `json:"ai_api_url"`
 	APIModel *string `json:"ai_api_model"`
+	MaxConcurrentStreams *int `json:"max_concurrent_streams"`
+	WidgetRateLimit  *int   `json:"widget_rate_limit"`
+	WidgetTokenLimit *int64 `json:"widget_token_limit"`
+	AllowedModels *[]string `json:"allowed_models"`
+	// 0 returns to the server default
+	StreamFlushChars      *int `json:"stream_flush_chars"`
+	StreamFlushIntervalMs *int `json:"stream_flush_interval_ms"`
+	ToolResultTokenLimit  *int `json:"tool_result_token_limit"`
+	// 0 removes the budget
+	DailyTokenBudget *int64 `json:"daily_token_budget"`
+	RedactSQLLiterals *bool `json:"redact_sql_literals"`
+	// Replaces the whole branding served by GET /api/widget/config
+	Branding *widget.Branding `json:"branding"`
 	IsActive *bool   `json:"is_active"`
+	Version  *int64  `json:"version"` // Used when no If-Match header is sent
 }
 
 type CreateDomainRequest struct {
@@ -53,54 +91,107 @@ type CreateDomainRequest struct {
 type UpdateDomainRequest struct {
 	Domain   *string `json:"domain"`
 	IsActive *bool   `json:"is_active"`
+	Version  *int64  `json:"version"` // Used when no If-Match header is sent
 }
 
+// clientColumns are the columns scanClient reads, in order
+const clientColumns = "id, name, slug, ai_api_key, ai_api_url, ai_api_model, is_active, created_at, max_concurrent_streams, widget_rate_limit, widget_token_limit, allowed_models, stream_flush_chars, stream_flush_interval_ms, branding, tool_result_token_limit, daily_token_budget, redact_sql_literals, version"
+
 func (app *App) getClientsHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 
 	resultSet, err := app.ZDB.Query(ctx,
-		"SELECT id, name, slug, ai_api_key, ai_api_url, ai_api_model, is_active, created_at FROM clients ORDER BY created_at DESC")
+		"SELECT "+clientColumns+" FROM clients ORDER BY created_at DESC")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch clients"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	var clients []Client
 	for _, row := range resultSet.Rows {
-		if len(row.Values) < 8 {
+		if len(row.Values) < 19 {
 			continue
 		}
+		clients = append(clients, scanClient(row.Values))
+	}
 
-		var client Client
-		if id, ok := row.Values[0].AsString(); ok {
-			client.ID = id
-		}
-		if name, ok := row.Values[1].AsString(); ok {
-			client.Name = name
-		}
-		if slug, ok := row.Values[2].AsString(); ok {
-			client.Slug = slug
-		}
-		if aiAPIKey, ok := row.Values[3].AsString(); ok {
-			client.AIAPIKey = &aiAPIKey
-		}
-		if aiAPIURL, ok := row.Values[4].AsString(); ok {
-			client.AIAPIURL = &aiAPIURL
-		}
-		if aiAPIModel, ok := row.Values[5].AsString(); ok {
-			client.APIModel = &aiAPIModel
-		}
-		if isActive, ok := row.Values[6].AsBool(); ok {
-			client.IsActive = isActive
-		}
-		if createdAt, ok := row.Values[7].AsTimestamp(); ok {
-			client.CreatedAt = createdAt.Time.Format(time.RFC3339)
-		}
+	c.JSON(http.StatusOK, clients)
+}
 
-		clients = append(clients, client)
+// loadClient reads a client as the admin API shows it; db.ErrNoRows when there is none
+func (app *App) loadClient(ctx context.Context, clientID string) (*Client, error) {
+	row, err := app.ZDB.QueryRow(ctx, "SELECT "+clientColumns+" FROM clients WHERE id = $1", clientID)
+	if err != nil {
+		return nil, err
 	}
+	client := scanClient(row.Values)
+	return &client, nil
+}
 
-	c.JSON(http.StatusOK, clients)
+// scanClient reads a row of clientColumns
+func scanClient(values []db.Value) Client {
+	client := Client{AllowedModels: []string{}, Branding: widget.DecodeBranding(nil)}
+	if id, ok := values[0].AsString(); ok {
+		client.ID = id
+	}
+	if name, ok := values[1].AsString(); ok {
+		client.Name = name
+	}
+	if slug, ok := values[2].AsString(); ok {
+		client.Slug = slug
+	}
+	if aiAPIKey, ok := values[3].AsString(); ok {
+		client.AIAPIKey = &aiAPIKey
+	}
+	if aiAPIURL, ok := values[4].AsString(); ok {
+		client.AIAPIURL = &aiAPIURL
+	}
+	if aiAPIModel, ok := values[5].AsString(); ok {
+		client.APIModel = &aiAPIModel
+	}
+	if isActive, ok := values[6].AsBool(); ok {
+		client.IsActive = isActive
+	}
+	if createdAt, ok := values[7].AsTimestamp(); ok {
+		client.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	}
+	if maxStreams, ok := values[8].AsInt64(); ok {
+		client.MaxConcurrentStreams = int(maxStreams)
+	}
+	if rateLimit, ok := values[9].AsInt64(); ok {
+		client.WidgetRateLimit = int(rateLimit)
+	}
+	if tokenLimit, ok := values[10].AsInt64(); ok {
+		client.WidgetTokenLimit = tokenLimit
+	}
+	if allowedModels, ok := values[11].AsJSON(); ok {
+		json.Unmarshal(allowedModels, &client.AllowedModels)
+	}
+	if flushChars, ok := values[12].AsInt64(); ok {
+		n := int(flushChars)
+		client.StreamFlushChars = &n
+	}
+	if flushInterval, ok := values[13].AsInt64(); ok {
+		n := int(flushInterval)
+		client.StreamFlushIntervalMs = &n
+	}
+	if branding, ok := values[14].AsJSON(); ok {
+		client.Branding = widget.DecodeBranding(branding)
+	}
+	if toolResultLimit, ok := values[15].AsInt64(); ok {
+		n := int(toolResultLimit)
+		client.ToolResultTokenLimit = &n
+	}
+	if budget, ok := values[16].AsInt64(); ok {
+		client.DailyTokenBudget = &budget
+	}
+	if redact, ok := values[17].AsBool(); ok {
+		client.RedactSQLLiterals = redact
+	}
+	if version, ok := values[18].AsInt64(); ok {
+		client.Version = version
+	}
+	return client
 }
 
 func (app *App) createClientHandler(c *gin.Context) {
@@ -108,17 +199,17 @@ func (app *App) createClientHandler(c *gin.Context) {
 
 	var req CreateClientRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
 		return
 	}
 
 	if req.Name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Client name is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "name"})
 		return
 	}
 
 	if req.Slug == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Client slug is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "slug"})
 		return
 	}
 
@@ -127,18 +218,18 @@ func (app *App) createClientHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM clients WHERE slug = $1)",
 		req.Slug)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	exists, ok := row.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Client slug already exists"})
+		apierror.Respond(c, apierror.CodeClientSlugTaken, nil)
 		return
 	}
 
@@ -147,7 +238,7 @@ func (app *App) createClientHandler(c *gin.Context) {
 		"INSERT INTO clients (id, name, slug, ai_api_key, ai_api_url, ai_api_model, is_active, created_at) VALUES ($1, $2, $3, $4, $5, $6, true, CURRENT_TIMESTAMP)",
 		clientID, req.Name, req.Slug, req.AIAPIKey, req.AIAPIURL, req.APIModel)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create client"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
@@ -156,13 +247,13 @@ func (app *App) createClientHandler(c *gin.Context) {
 		"SELECT created_at FROM clients WHERE id = $1",
 		clientID)
 	if err != nil || len(row.Values) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get client details"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	createdAt, ok := row.Values[0].AsTimestamp()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse timestamp"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
@@ -173,10 +264,14 @@ func (app *App) createClientHandler(c *gin.Context) {
 		AIAPIKey:  req.AIAPIKey,
 		AIAPIURL:  req.AIAPIURL,
 		APIModel:  req.APIModel,
+		AllowedModels: []string{},
+		Branding:  widget.DecodeBranding(nil),
 		IsActive:  true,
 		CreatedAt: createdAt.Time.Format(time.RFC3339),
+		Version:   1,
 	}
 
+	setETag(c, client.Version)
 	c.JSON(http.StatusCreated, client)
 }
 
@@ -186,7 +281,11 @@ func (app *App) updateClientHandler(c *gin.Context) {
 
 	var req UpdateClientRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	version, ok := requestVersion(c, req.Version)
+	if !ok {
 		return
 	}
 
@@ -195,18 +294,18 @@ func (app *App) updateClientHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1)",
 		clientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	exists, ok := existsRow.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
 		return
 	}
 
@@ -216,24 +315,24 @@ func (app *App) updateClientHandler(c *gin.Context) {
 			"SELECT EXISTS(SELECT 1 FROM clients WHERE slug = $1 AND id != $2)",
 			*req.Slug, clientID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
 			return
 		}
 
 		slugExists, ok := row.Values[0].AsBool()
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+			apierror.Respond(c, apierror.CodeInternal, nil)
 			return
 		}
 
 		if slugExists {
-			c.JSON(http.StatusConflict, gin.H{"error": "Client slug already exists"})
+			apierror.Respond(c, apierror.CodeClientSlugTaken, nil)
 			return
 		}
 	}
 
 	// Build dynamic update query
-	query := "UPDATE clients SET updated_at = CURRENT_TIMESTAMP"
+	query := "UPDATE clients SET updated_at = CURRENT_TIMESTAMP" + versionIncrement
 	args := []interface{}{}
 	argIndex := 1
 
@@ -267,22 +366,155 @@ func (app *App) updateClientHandler(c *gin.Context) {
 		argIndex++
 	}
 
+	if req.MaxConcurrentStreams != nil {
+		if *req.MaxConcurrentStreams < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "max_concurrent_streams", "min": 1})
+			return
+		}
+		query += fmt.Sprintf(", max_concurrent_streams = $%d", argIndex)
+		args = append(args, *req.MaxConcurrentStreams)
+		argIndex++
+	}
+
+	if req.WidgetRateLimit != nil {
+		if *req.WidgetRateLimit < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "widget_rate_limit", "min": 1})
+			return
+		}
+		query += fmt.Sprintf(", widget_rate_limit = $%d", argIndex)
+		args = append(args, *req.WidgetRateLimit)
+		argIndex++
+	}
+
+	if req.WidgetTokenLimit != nil {
+		if *req.WidgetTokenLimit < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "widget_token_limit", "min": 1})
+			return
+		}
+		query += fmt.Sprintf(", widget_token_limit = $%d", argIndex)
+		args = append(args, *req.WidgetTokenLimit)
+		argIndex++
+	}
+
+	if req.AllowedModels != nil {
+		allowedModels, ok := normalizeAllowedModels(*req.AllowedModels)
+		if !ok {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "allowed_models"})
+			return
+		}
+		encoded, _ := json.Marshal(allowedModels)
+		query += fmt.Sprintf(", allowed_models = $%d", argIndex)
+		args = append(args, string(encoded))
+		argIndex++
+	}
+
+	for _, setting := range []struct {
+		column string
+		value  *int
+	}{
+		{"stream_flush_chars", req.StreamFlushChars},
+		{"stream_flush_interval_ms", req.StreamFlushIntervalMs},
+		{"tool_result_token_limit", req.ToolResultTokenLimit},
+	} {
+		if setting.value == nil {
+			continue
+		}
+		if *setting.value < 0 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": setting.column, "min": 0})
+			return
+		}
+		query += fmt.Sprintf(", %s = $%d", setting.column, argIndex)
+		if *setting.value == 0 {
+			args = append(args, nil)
+		} else {
+			args = append(args, *setting.value)
+		}
+		argIndex++
+	}
+
+	if req.DailyTokenBudget != nil {
+		if *req.DailyTokenBudget < 0 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "daily_token_budget", "min": 0})
+			return
+		}
+		query += fmt.Sprintf(", daily_token_budget = $%d", argIndex)
+		if *req.DailyTokenBudget == 0 {
+			args = append(args, nil)
+		} else {
+			args = append(args, *req.DailyTokenBudget)
+		}
+		argIndex++
+	}
+
+	if req.RedactSQLLiterals != nil {
+		query += fmt.Sprintf(", redact_sql_literals = $%d", argIndex)
+		args = append(args, *req.RedactSQLLiterals)
+		argIndex++
+	}
+
+	if req.Branding != nil {
+		branding, ok := normalizeBranding(c, *req.Branding)
+		if !ok {
+			return
+		}
+		encoded, _ := json.Marshal(branding)
+		query += fmt.Sprintf(", branding = $%d, branding_updated_at = $%d", argIndex, argIndex+1)
+		args = append(args, string(encoded), time.Now().UTC())
+		argIndex += 2
+	}
+
 	if req.IsActive != nil {
 		query += fmt.Sprintf(", is_active = $%d", argIndex)
 		args = append(args, *req.IsActive)
 		argIndex++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d", argIndex)
-	args = append(args, clientID)
+	query += fmt.Sprintf(" WHERE id = $%d"+versionCondition, argIndex, argIndex+1)
+	args = append(args, clientID, version)
 
-	_, err = app.ZDB.Execute(ctx, query, args...)
+	result, err := app.ZDB.Execute(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	if result.RowsAffected == 0 {
+		current, err := app.loadClient(ctx, clientID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Respond(c, apierror.CodeClientNotFound, nil)
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		respondVersionConflict(c, current, current.Version)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Client updated successfully"})
+	// Model, allowlist and streaming changes apply to the next conversation, not after the cache expires
+	if app.ClientConfigCache != nil {
+		app.ClientConfigCache.InvalidateClientConfig(clientID)
+	}
+
+	setETag(c, version+1)
+	c.JSON(http.StatusOK, gin.H{"message": "Client updated successfully", "version": version + 1})
+}
+
+// normalizeAllowedModels trims and de-duplicates model names, rejecting empty ones
+func normalizeAllowedModels(models []string) ([]string, bool) {
+	normalized := make([]string, 0, len(models))
+	seen := make(map[string]bool, len(models))
+	for _, model := range models {
+		model = strings.TrimSpace(model)
+		if model == "" {
+			return nil, false
+		}
+		if !seen[model] {
+			seen[model] = true
+			normalized = append(normalized, model)
+		}
+	}
+	return normalized, true
 }
 
 func (app *App) deleteClientHandler(c *gin.Context) {
@@ -291,16 +523,16 @@ func (app *App) deleteClientHandler(c *gin.Context) {
 
 	// Soft delete by setting is_active to false using ZDB
 	result, err := app.ZDB.Execute(ctx,
-		"UPDATE clients SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE id = $1",
+		"UPDATE clients SET is_active = false, updated_at = CURRENT_TIMESTAMP"+versionIncrement+" WHERE id = $1",
 		clientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
 	rowsAffected := result.RowsAffected
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
 		return
 	}
 
@@ -315,46 +547,79 @@ func (app *App) getDomainsHandler(c *gin.Context) {
 	var args []interface{}
 
 	if clientID != "" {
-		query = "SELECT id, client_id, domain, is_active, created_at FROM domains WHERE client_id = $1 ORDER BY created_at DESC"
+		query = "SELECT " + domainColumns + " FROM domains WHERE client_id = $1 ORDER BY created_at DESC"
 		args = []interface{}{clientID}
 	} else {
-		query = "SELECT id, client_id, domain, is_active, created_at FROM domains ORDER BY created_at DESC"
+		query = "SELECT " + domainColumns + " FROM domains ORDER BY created_at DESC"
 		args = []interface{}{}
 	}
 
 	resultSet, err := app.ZDB.Query(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch domains"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	var domains []Domain
 	for _, row := range resultSet.Rows {
-		if len(row.Values) < 5 {
+		if len(row.Values) < 6 {
 			continue
 		}
+		domains = append(domains, scanDomain(row.Values))
+	}
 
-		var domain Domain
-		if id, ok := row.Values[0].AsString(); ok {
-			domain.ID = id
-		}
-		if clientID, ok := row.Values[1].AsString(); ok {
-			domain.ClientID = clientID
-		}
-		if domainName, ok := row.Values[2].AsString(); ok {
-			domain.Domain = domainName
-		}
-		if isActive, ok := row.Values[3].AsBool(); ok {
-			domain.IsActive = isActive
-		}
-		if createdAt, ok := row.Values[4].AsTimestamp(); ok {
-			domain.CreatedAt = createdAt.Time.Format(time.RFC3339)
-		}
+	c.JSON(http.StatusOK, domains)
+}
 
-		domains = append(domains, domain)
+// domainColumns are the columns scanDomain reads, in order
+const domainColumns = "id, client_id, domain, is_active, created_at, version"
+
+// loadDomain reads a domain as the admin API shows it; db.ErrNoRows when there is none
+func (app *App) loadDomain(ctx context.Context, domainID string) (*Domain, error) {
+	row, err := app.ZDB.QueryRow(ctx, "SELECT "+domainColumns+" FROM domains WHERE id = $1", domainID)
+	if err != nil {
+		return nil, err
 	}
+	domain := scanDomain(row.Values)
+	return &domain, nil
+}
 
-	c.JSON(http.StatusOK, domains)
+// scanDomain reads a row of domainColumns
+func scanDomain(values []db.Value) Domain {
+	var domain Domain
+	if id, ok := values[0].AsString(); ok {
+		domain.ID = id
+	}
+	if clientID, ok := values[1].AsString(); ok {
+		domain.ClientID = clientID
+	}
+	if domainName, ok := values[2].AsString(); ok {
+		domain.Domain = domainName
+	}
+	if isActive, ok := values[3].AsBool(); ok {
+		domain.IsActive = isActive
+	}
+	if createdAt, ok := values[4].AsTimestamp(); ok {
+		domain.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	}
+	if version, ok := values[5].AsInt64(); ok {
+		domain.Version = version
+	}
+	return domain
+}
+
+// normalizeDomainField replaces a requested domain with its stored form,
+// responding with DOMAIN_INVALID when it cannot be normalized
+func normalizeDomainField(c *gin.Context, domain *string) bool {
+	normalized, err := domains.Normalize(*domain)
+	if err != nil {
+		var invalid *domains.InvalidError
+		errors.As(err, &invalid)
+		apierror.Respond(c, apierror.CodeDomainInvalid, map[string]interface{}{"domain": *domain, "reason": invalid.Reason})
+		return false
+	}
+	*domain = normalized
+	return true
 }
 
 func (app *App) createDomainHandler(c *gin.Context) {
@@ -362,17 +627,20 @@ func (app *App) createDomainHandler(c *gin.Context) {
 
 	var req CreateDomainRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
 		return
 	}
 
 	if req.ClientID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Client ID is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "client_id"})
 		return
 	}
 
 	if req.Domain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Domain is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "domain"})
+		return
+	}
+	if !normalizeDomainField(c, &req.Domain) {
 		return
 	}
 
@@ -381,18 +649,18 @@ func (app *App) createDomainHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1)",
 		req.ClientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	clientExists, ok := row.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	if !clientExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
+		apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 		return
 	}
 
@@ -401,44 +669,43 @@ func (app *App) createDomainHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM domains WHERE domain = $1)",
 		req.Domain)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	domainExists, ok := row.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	if domainExists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Domain already exists"})
+		apierror.Respond(c, apierror.CodeDomainTaken, nil)
 		return
 	}
 
 	domainID := uuid.New().String()
-	row, err = app.ZDB.QueryRow(ctx,
-		"INSERT INTO domains (id, client_id, domain, is_active, created_at) VALUES ($1, $2, $3, true, CURRENT_TIMESTAMP) RETURNING created_at",
-		domainID, req.ClientID, req.Domain)
+	createdAt := time.Now().UTC()
+	_, err = app.ZDB.Execute(ctx,
+		"INSERT INTO domains (id, client_id, domain, normalized_domain, is_active, created_at) VALUES ($1, $2, $3, $3, true, $4)",
+		domainID, req.ClientID, req.Domain, createdAt)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create domain"})
-		return
-	}
-
-	createdAt, ok := row.Values[0].AsTimestamp()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse timestamp"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
+	// Hosts remembered as unknown may match the new domain
+	app.DomainCache.Clear()
 
 	domain := Domain{
 		ID:        domainID,
 		ClientID:  req.ClientID,
 		Domain:    req.Domain,
 		IsActive:  true,
-		CreatedAt: createdAt.Time.Format(time.RFC3339),
+		CreatedAt: createdAt.Format(time.RFC3339),
+		Version:   1,
 	}
 
+	setETag(c, domain.Version)
 	c.JSON(http.StatusCreated, domain)
 }
 
@@ -448,7 +715,11 @@ func (app *App) updateDomainHandler(c *gin.Context) {
 
 	var req UpdateDomainRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	version, ok := requestVersion(c, req.Version)
+	if !ok {
 		return
 	}
 
@@ -457,50 +728,53 @@ func (app *App) updateDomainHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM domains WHERE id = $1)",
 		domainID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	exists, ok := row.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		apierror.Respond(c, apierror.CodeDomainNotFound, nil)
 		return
 	}
 
 	// If updating domain, check for uniqueness
 	if req.Domain != nil {
+		if !normalizeDomainField(c, req.Domain) {
+			return
+		}
 		row, err := app.ZDB.QueryRow(ctx,
 			"SELECT EXISTS(SELECT 1 FROM domains WHERE domain = $1 AND id != $2)",
 			*req.Domain, domainID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
 			return
 		}
 
 		domainExists, ok := row.Values[0].AsBool()
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+			apierror.Respond(c, apierror.CodeInternal, nil)
 			return
 		}
 
 		if domainExists {
-			c.JSON(http.StatusConflict, gin.H{"error": "Domain already exists"})
+			apierror.Respond(c, apierror.CodeDomainTaken, nil)
 			return
 		}
 	}
 
 	// Build dynamic update query
-	query := "UPDATE domains SET updated_at = CURRENT_TIMESTAMP"
+	query := "UPDATE domains SET updated_at = CURRENT_TIMESTAMP" + versionIncrement
 	args := []interface{}{}
 	argIndex := 1
 
 	if req.Domain != nil {
-		query += fmt.Sprintf(", domain = $%d", argIndex)
+		query += fmt.Sprintf(", domain = $%d, normalized_domain = $%d", argIndex, argIndex)
 		args = append(args, *req.Domain)
 		argIndex++
 	}
@@ -511,16 +785,31 @@ func (app *App) updateDomainHandler(c *gin.Context) {
 		argIndex++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d", argIndex)
-	args = append(args, domainID)
+	query += fmt.Sprintf(" WHERE id = $%d"+versionCondition, argIndex, argIndex+1)
+	args = append(args, domainID, version)
 
-	_, err = app.ZDB.Execute(ctx, query, args...)
+	result, err := app.ZDB.Execute(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update domain"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	if result.RowsAffected == 0 {
+		current, err := app.loadDomain(ctx, domainID)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Respond(c, apierror.CodeDomainNotFound, nil)
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		respondVersionConflict(c, current, current.Version)
 		return
 	}
+	app.DomainCache.Clear()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Domain updated successfully"})
+	setETag(c, version+1)
+	c.JSON(http.StatusOK, gin.H{"message": "Domain updated successfully", "version": version + 1})
 }
 
 func (app *App) deleteDomainHandler(c *gin.Context) {
@@ -529,18 +818,19 @@ func (app *App) deleteDomainHandler(c *gin.Context) {
 
 	// Soft delete by setting is_active to false
 	result, err := app.ZDB.Execute(ctx,
-		"UPDATE domains SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE id = $1",
+		"UPDATE domains SET is_active = false, updated_at = CURRENT_TIMESTAMP"+versionIncrement+" WHERE id = $1",
 		domainID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
 	rowsAffected := result.RowsAffected
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		apierror.Respond(c, apierror.CodeDomainNotFound, nil)
 		return
 	}
+	app.DomainCache.Clear()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted successfully"})
 }
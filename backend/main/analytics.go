@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/analytics"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/tools"
+)
+
+// defaultAnalyticsRange is the period covered when from is not given
+const defaultAnalyticsRange = 30 * 24 * time.Hour
+
+// adminStatsHandler returns platform activity, optionally for one client. The
+// time-to-first-token percentiles cover this process's recent replies
+// across every client, so they are only included without client_id.
+func (app *App) adminStatsHandler(c *gin.Context) {
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	if clientID := c.Query("client_id"); clientID != "" {
+		if _, err := uuid.Parse(clientID); err != nil {
+			apierror.Respond(c, apierror.CodeClientIDInvalid, nil)
+			return
+		}
+		filter.ClientID = clientID
+	}
+
+	overview, err := app.Analytics.Overview(c.Request.Context(), filter)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	response := gin.H{"stats": overview}
+	if filter.ClientID == "" {
+		response["time_to_first_token"] = metrics.Latency(chat.MetricTimeToFirstToken).Snapshot()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// analyticsOverviewHandler returns the activity of the current user's client
+func (app *App) analyticsOverviewHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	filter.ClientID = user.ClientID
+
+	overview, err := app.Analytics.Overview(c.Request.Context(), filter)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stats": overview})
+}
+
+// latencyAnalyticsHandler returns p50 and p95 reply latency of the current
+// user's client, optionally grouped by model or day
+func (app *App) latencyAnalyticsHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	groupBy := c.Query("group_by")
+	switch groupBy {
+	case chat.LatencyGroupNone, chat.LatencyGroupModel, chat.LatencyGroupDay:
+	default:
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "group_by"})
+		return
+	}
+
+	summary, err := chat.LatencyStats(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.readDB()}, user.ClientID, filter.From, filter.To, groupBy)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"latency": summary})
+}
+
+// analyticsFilter reads from and to as RFC 3339 times or dates. to defaults to
+// the end of the current minute, so repeated requests share a cache entry, and
+// from to 30 days before to.
+func analyticsFilter(c *gin.Context) (analytics.Filter, bool) {
+	var filter analytics.Filter
+	var err error
+
+	filter.To = time.Now().UTC().Truncate(time.Minute).Add(time.Minute)
+	if value := c.Query("to"); value != "" {
+		if filter.To, err = parseAnalyticsTime(value); err != nil {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "to"})
+			return filter, false
+		}
+	}
+	filter.From = filter.To.Add(-defaultAnalyticsRange)
+	if value := c.Query("from"); value != "" {
+		if filter.From, err = parseAnalyticsTime(value); err != nil {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "from"})
+			return filter, false
+		}
+	}
+	if !filter.From.Before(filter.To) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "from"})
+		return filter, false
+	}
+	return filter, true
+}
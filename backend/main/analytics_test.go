@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/analytics"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+// newAnalyticsTestRouter seeds one conversation for alice in the tenant client
+// and one for root in the system client
+func newAnalyticsTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	app := newSessionsTestApp(t)
+	app.Analytics = analytics.NewReporter(&tools.ZlayDBAdapter{DB: app.ZDB}, analytics.DefaultCacheTTL)
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO projects (id, user_id, name) VALUES ('project-alice', 'alice', 'Alice'), ('project-root', 'root-system', 'Root')", nil},
+		{"INSERT INTO conversations (id, title, user_id, project_id, created_at) VALUES ('conv-alice', 'Alice', 'alice', 'project-alice', $1), ('conv-root', 'Root', 'root-system', 'project-root', $1)",
+			[]interface{}{now}},
+		{`INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m1', 'conv-alice', 'user', 'hello', $1), ('m2', 'conv-root', 'user', 'hi', $1),
+			('r1', 'conv-alice', 'assistant', 'hello', $1), ('r2', 'conv-root', 'assistant', 'hi', $1)`,
+			[]interface{}{now}},
+		{"INSERT INTO message_metrics (message_id, conversation_id, model, ttft_ms, total_ms, chunk_count, day, created_at) VALUES ('r1', 'conv-alice', 'gpt', 120, 900, 3, $1, $2), ('r2', 'conv-root', 'gpt', 5000, 9000, 3, $1, $2)",
+			[]interface{}{now.Format("2006-01-02"), now}},
+	} {
+		if _, err := app.ZDB.Execute(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to seed analytics: %v", err)
+		}
+	}
+
+	router := newSessionsTestRouter(app)
+	router.GET("/api/admin/stats", app.adminMiddleware(), app.adminStatsHandler)
+	router.GET("/api/analytics/overview", app.authMiddleware(), app.analyticsOverviewHandler)
+	router.GET("/api/analytics/latency", app.authMiddleware(), app.latencyAnalyticsHandler)
+	return router
+}
+
+func decodeStats(t *testing.T, body []byte) analytics.Overview {
+	t.Helper()
+
+	var resp struct {
+		Stats analytics.Overview `json:"stats"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Failed to decode stats %q: %v", body, err)
+	}
+	return resp.Stats
+}
+
+func TestAdminStatsRequiresSystemRoot(t *testing.T) {
+	router := newAnalyticsTestRouter(t)
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+
+	w := tenancyRequest(router, rootToken, "GET", "/api/admin/stats", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := decodeStats(t, w.Body.Bytes()); stats.Conversations != 2 || stats.ActiveUsers != 2 {
+		t.Errorf("Expected activity across clients, got %+v", stats)
+	}
+
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/stats?client_id="+tenantClientID, "")
+	if stats := decodeStats(t, w.Body.Bytes()); stats.Conversations != 1 || stats.ClientID != tenantClientID {
+		t.Errorf("Expected the tenant's activity, got %+v", stats)
+	}
+	if w := tenancyRequest(router, rootToken, "GET", "/api/admin/stats?client_id=tenant", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid client_id, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "GET", "/api/admin/stats?from=2026-02-01&to=2026-01-01", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for from after to, got %d", w.Code)
+	}
+
+	tenantRoot, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	if w := tenancyRequest(router, tenantRoot, "GET", "/api/admin/stats", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a tenant root to be refused, got %d", w.Code)
+	}
+}
+
+func TestAnalyticsOverviewIsLimitedToOwnClient(t *testing.T) {
+	router := newAnalyticsTestRouter(t)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	// client_id is not a parameter of the tenant view
+	w := tenancyRequest(router, aliceToken, "GET", "/api/analytics/overview?client_id="+systemClientID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	stats := decodeStats(t, w.Body.Bytes())
+	if stats.ClientID != tenantClientID || stats.Conversations != 1 || stats.MessagesByRole["user"] != 1 {
+		t.Errorf("Expected only the tenant's activity, got %+v", stats)
+	}
+
+	if w := tenancyRequest(router, "", "GET", "/api/analytics/overview", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a session, got %d", w.Code)
+	}
+}
+
+func TestLatencyAnalyticsIsLimitedToOwnClient(t *testing.T) {
+	router := newAnalyticsTestRouter(t)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	w := tenancyRequest(router, aliceToken, "GET", "/api/analytics/latency?group_by=model", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Latency chat.LatencySummary `json:"latency"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode latency %q: %v", w.Body.String(), err)
+	}
+	want := chat.LatencyBucket{Group: "gpt", Count: 1, TTFT: chat.LatencyPercentiles{P50: 120, P95: 120}, Total: chat.LatencyPercentiles{P50: 900, P95: 900}}
+	if len(resp.Latency.Buckets) != 1 || resp.Latency.Buckets[0] != want {
+		t.Errorf("Expected only the tenant's replies, got %+v", resp.Latency.Buckets)
+	}
+
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/analytics/latency?group_by=user", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported group_by, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "", "GET", "/api/analytics/latency", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a session, got %d", w.Code)
+	}
+}
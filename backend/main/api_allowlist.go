@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+type CreateAPIAllowlistRuleRequest struct {
+	Pattern      string `json:"pattern" binding:"required"`
+	AllowPrivate bool   `json:"allow_private"`
+}
+
+func (app *App) getAPIAllowlistHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	rules, err := tools.NewDBAllowlistStore(&tools.ZlayDBAdapter{DB: app.ZDB}).GetAllowlist(ctx, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API allowlist"})
+		return
+	}
+	if rules == nil {
+		rules = []tools.AllowlistRule{}
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (app *App) createAPIAllowlistRuleHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var req CreateAPIAllowlistRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if err := tools.ValidateAllowlistPattern(req.Pattern); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := tools.AllowlistRule{
+		ID:           uuid.New().String(),
+		Pattern:      req.Pattern,
+		AllowPrivate: req.AllowPrivate,
+	}
+	_, err = app.ZDB.Execute(ctx,
+		"INSERT INTO api_allowlist (id, project_id, pattern, allow_private, created_at) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)",
+		rule.ID, projectID, rule.Pattern, rule.AllowPrivate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create allowlist rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (app *App) deleteAPIAllowlistRuleHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+	ruleID := c.Param("rule_id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if _, err := uuid.Parse(ruleID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Allowlist rule not found"})
+		return
+	}
+
+	result, err := app.ZDB.Execute(ctx,
+		"DELETE FROM api_allowlist WHERE id = $1 AND project_id = $2",
+		ruleID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete allowlist rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Allowlist rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Allowlist rule deleted successfully"})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/apikeys"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+// apiKeyRoutes are the routes an API key may call, by method and route
+// pattern. Everything else, including admin, key and user management, needs a
+// session.
+var apiKeyRoutes = map[string]bool{
+	"POST /api/chat":                      true,
+	"POST /v1/chat/completions":           true,
+	"GET /api/conversations":              true,
+	"POST /api/conversations":             true,
+	"GET /api/conversations/:id/messages": true,
+	"POST /api/conversations/:id/restore": true,
+	"PUT /api/conversations/:id/pin":      true,
+	"POST /api/messages/:id/feedback":     true,
+	"GET /api/projects/:id":               true,
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// bearerAPIKey returns the API key sent as `Authorization: Bearer zlay_...`
+func bearerAPIKey(c *gin.Context) (string, bool) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || !apikeys.IsKey(token) {
+		return "", false
+	}
+	return token, true
+}
+
+// apiKeyScope returns the scope of a request authenticated with an API key
+func apiKeyScope(c *gin.Context) (*apikeys.Scope, bool) {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return nil, false
+	}
+	scope, ok := value.(*apikeys.Scope)
+	return scope, ok
+}
+
+// authenticateAPIKey is the part of authMiddleware handling API keys. The
+// request runs as the key's creator, limited to apiKeyRoutes and, for project
+// keys, to the key's project. Failures are reported through abort, which
+// apierror.Abort does for the zlay API.
+func (app *App) authenticateAPIKey(c *gin.Context, secret string, abort func(*gin.Context, string, map[string]interface{})) {
+	ctx := c.Request.Context()
+	adapter := &tools.ZlayDBAdapter{DB: app.ZDB}
+
+	scope, err := apikeys.Authenticate(ctx, adapter, secret)
+	if errors.Is(err, apikeys.ErrInvalidKey) {
+		abort(c, apierror.CodeAPIKeyInvalid, nil)
+		return
+	}
+	if err != nil {
+		abort(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if !apiKeyRoutes[c.Request.Method+" "+c.FullPath()] {
+		abort(c, apierror.CodeAPIKeyForbidden, nil)
+		return
+	}
+
+	code, err := app.apiKeyScopeViolation(c, scope)
+	if err != nil {
+		abort(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if code != "" {
+		abort(c, code, nil)
+		return
+	}
+
+	user := User{
+		ID:            scope.UserID,
+		ClientID:      scope.ClientID,
+		Username:      scope.Username,
+		IsActive:      true,
+		APIKeyProject: scope.ProjectID,
+	}
+	c.Set("user", user)
+	c.Set("user_id", user.ID)
+	c.Set("client_id", user.ClientID)
+	c.Set("username", user.Username)
+	c.Set("api_key", scope)
+
+	apikeys.TouchLastUsed(adapter, scope.KeyID)
+	c.Next()
+}
+
+// apiKeyScopeViolation checks the project a request targets against a project
+// key's scope. Resources of other projects are reported as not found, the same
+// as resources of other users.
+func (app *App) apiKeyScopeViolation(c *gin.Context, scope *apikeys.Scope) (string, error) {
+	if scope.ProjectID == "" {
+		return "", nil
+	}
+	ctx := c.Request.Context()
+
+	var query, code string
+	switch c.FullPath() {
+	case "/api/projects/:id":
+		if !scope.AllowsProject(c.Param("id")) {
+			return apierror.CodeProjectNotFound, nil
+		}
+		return "", nil
+	case "/api/conversations":
+		if projectID := c.Query("project_id"); projectID != "" && !scope.AllowsProject(projectID) {
+			return apierror.CodeProjectNotFound, nil
+		}
+		return "", nil
+	case "/api/conversations/:id/messages", "/api/conversations/:id/restore", "/api/conversations/:id/pin":
+		query = "SELECT project_id FROM conversations WHERE id = $1"
+		code = apierror.CodeConversationNotFound
+	case "/api/messages/:id/feedback":
+		query = `SELECT c.project_id FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id
+			WHERE m.id = $1`
+		code = apierror.CodeMessageNotFound
+	default:
+		// Other routes check projects through userOwnsProject
+		return "", nil
+	}
+
+	row, err := app.ZDB.QueryRow(ctx, query, c.Param("id"))
+	if errors.Is(err, db.ErrNoRows) {
+		// Let the handler report the missing resource
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	projectID, _ := row.Values[0].AsString()
+	if !scope.AllowsProject(projectID) {
+		return code, nil
+	}
+	return "", nil
+}
+
+// defaultProjectID is the project used when a request names none: a project
+// key's own project, otherwise the configured default
+func (app *App) defaultProjectID(c *gin.Context) string {
+	if scope, ok := apiKeyScope(c); ok && scope.ProjectID != "" {
+		return scope.ProjectID
+	}
+	return app.Config.DefaultProjectID
+}
+
+// getProjectAPIKeysHandler lists the active keys of a project
+func (app *App) getProjectAPIKeysHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, ok := app.apiKeyProjectOwner(c)
+	if !ok {
+		return
+	}
+
+	keys, err := apikeys.ListProject(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// createProjectAPIKeyHandler issues a key for a project
+func (app *App) createProjectAPIKeyHandler(c *gin.Context) {
+	user, ok := app.apiKeyProjectOwner(c)
+	if !ok {
+		return
+	}
+	app.createAPIKey(c, user, c.Param("id"))
+}
+
+// revokeProjectAPIKeyHandler revokes a key of a project
+func (app *App) revokeProjectAPIKeyHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, ok := app.apiKeyProjectOwner(c)
+	if !ok {
+		return
+	}
+
+	keyID := c.Param("key_id")
+	err := apikeys.RevokeProject(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, c.Param("id"), keyID)
+	app.respondAPIKeyRevoked(c, user, keyID, err)
+}
+
+// getClientAPIKeysHandler lists the current user's client-level keys
+func (app *App) getClientAPIKeysHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	keys, err := apikeys.ListClient(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, user.ID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// createClientAPIKeyHandler issues a key covering every project the user owns
+func (app *App) createClientAPIKeyHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	app.createAPIKey(c, user, "")
+}
+
+// revokeClientAPIKeyHandler revokes one of the current user's client-level keys
+func (app *App) revokeClientAPIKeyHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	keyID := c.Param("key_id")
+	err = apikeys.RevokeClient(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, user.ID, keyID)
+	app.respondAPIKeyRevoked(c, user, keyID, err)
+}
+
+// apiKeyProjectOwner returns the current user when they own the :id project,
+// otherwise it responds with the error
+func (app *App) apiKeyProjectOwner(c *gin.Context) (*User, bool) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return nil, false
+	}
+
+	owned, err := app.userOwnsProject(c.Request.Context(), c.Param("id"), user)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return nil, false
+	}
+	if !owned {
+		apierror.Respond(c, apierror.CodeProjectNotFound, nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// createAPIKey issues a key and returns its secret, which is not shown again
+func (app *App) createAPIKey(c *gin.Context, user *User, projectID string) {
+	ctx := c.Request.Context()
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "name"})
+		return
+	}
+
+	key, secret, err := apikeys.Create(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, projectID, req.Name, user.ID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    user.ID,
+		IP:         app.clientIP(c),
+		ClientID:   user.ClientID,
+		Action:     AuditActionAPIKeyCreate,
+		TargetType: "api_key",
+		TargetID:   key.ID,
+		Details:    map[string]interface{}{"name": key.Name, "project_id": projectID},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": secret})
+}
+
+func (app *App) respondAPIKeyRevoked(c *gin.Context, user *User, keyID string, err error) {
+	if errors.Is(err, apikeys.ErrKeyNotFound) {
+		apierror.Respond(c, apierror.CodeAPIKeyNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	app.recordAudit(c.Request.Context(), AuditEntry{
+		ActorID:    user.ID,
+		IP:         app.clientIP(c),
+		ClientID:   user.ClientID,
+		Action:     AuditActionAPIKeyRevoke,
+		TargetType: "api_key",
+		TargetID:   keyID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apikeys"
+	"zlay-backend/internal/config"
+)
+
+// newAPIKeyTestApp extends the tenancy fixtures with a second project of
+// user A
+func newAPIKeyTestApp(t *testing.T) *App {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ('project-a2', 'user-a', 'Other', '', true, $1)",
+			[]interface{}{now}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conversation-a2', 'Other', 'user-a', 'project-a2', 'completed', $1, $1)",
+			[]interface{}{now}},
+	} {
+		if _, err := app.ZDB.Execute(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to set up API keys: %v", err)
+		}
+	}
+	return app
+}
+
+func newAPIKeyTestRouter(app *App) *gin.Engine {
+	router := newTenancyTestRouter(app)
+	router.GET("/api/conversations", app.authMiddleware(), app.getConversationsHandler)
+	router.GET("/api/projects/:id/api-keys", app.authMiddleware(), app.getProjectAPIKeysHandler)
+	router.POST("/api/projects/:id/api-keys", app.authMiddleware(), app.createProjectAPIKeyHandler)
+	router.DELETE("/api/projects/:id/api-keys/:key_id", app.authMiddleware(), app.revokeProjectAPIKeyHandler)
+	router.POST("/api/settings/api-keys", app.authMiddleware(), app.createClientAPIKeyHandler)
+	return router
+}
+
+func apiKeyRequest(router *gin.Engine, key, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createTestAPIKey creates a key through the API with user A's session
+func createTestAPIKey(t *testing.T, router *gin.Engine, path string) (id, secret string) {
+	t.Helper()
+
+	w := tenancyRequest(router, "token-a", "POST", path, `{"name":"CI"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating key, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		APIKey apikeys.Key `json:"api_key"`
+		Key    string      `json:"key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !apikeys.IsKey(resp.Key) || !strings.HasPrefix(resp.Key, resp.APIKey.KeyPrefix) {
+		t.Fatalf("Unexpected key in response: %s", w.Body.String())
+	}
+	return resp.APIKey.ID, resp.Key
+}
+
+func TestProjectAPIKeyScope(t *testing.T) {
+	app := newAPIKeyTestApp(t)
+	router := newAPIKeyTestRouter(app)
+	_, key := createTestAPIKey(t, router, "/api/projects/project-a/api-keys")
+
+	// Without project_id the key's own project is listed
+	w := apiKeyRequest(router, key, "GET", "/api/conversations", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"conversation-a"`) {
+		t.Errorf("Expected the key's conversations, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := apiKeyRequest(router, key, "GET", "/api/conversations/conversation-a/messages", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the key's conversation, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The creator's other project is out of the key's scope
+	outOfScope := []struct {
+		method, path, body, code string
+	}{
+		{"GET", "/api/projects/project-a2", "", "PROJECT_NOT_FOUND"},
+		{"GET", "/api/conversations?project_id=project-a2", "", "PROJECT_NOT_FOUND"},
+		{"POST", "/api/conversations", `{"project_id":"project-a2"}`, "PROJECT_NOT_FOUND"},
+		{"GET", "/api/conversations/conversation-a2/messages", "", "CONVERSATION_NOT_FOUND"},
+		{"PUT", "/api/conversations/conversation-a2/pin", "", "CONVERSATION_NOT_FOUND"},
+	}
+	for _, tc := range outOfScope {
+		w := apiKeyRequest(router, key, tc.method, tc.path, tc.body)
+		if w.Code != http.StatusNotFound || responseErrorCode(t, w.Body.Bytes()) != tc.code {
+			t.Errorf("%s %s: expected 404 %s, got %d: %s", tc.method, tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	// Keys cannot manage keys or reach routes outside their permission set
+	for _, tc := range []struct{ method, path string }{
+		{"POST", "/api/projects/project-a/api-keys"},
+		{"PUT", "/api/projects/project-a"},
+		{"GET", "/api/datasources"},
+	} {
+		w := apiKeyRequest(router, key, tc.method, tc.path, `{"name":"Escalated"}`)
+		if w.Code != http.StatusForbidden || responseErrorCode(t, w.Body.Bytes()) != "API_KEY_FORBIDDEN" {
+			t.Errorf("%s %s: expected 403 API_KEY_FORBIDDEN, got %d: %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+
+	// Client-level keys reach every project of their creator
+	_, clientKey := createTestAPIKey(t, router, "/api/settings/api-keys")
+	if w := apiKeyRequest(router, clientKey, "GET", "/api/conversations/conversation-a2/messages", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a client key, got %d: %s", w.Code, w.Body.String())
+	}
+	// but no other client's
+	if w := apiKeyRequest(router, clientKey, "GET", "/api/projects/project-b", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another client's project, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRevokedAPIKeyIsRejectedImmediately(t *testing.T) {
+	app := newAPIKeyTestApp(t)
+	router := newAPIKeyTestRouter(app)
+	keyID, key := createTestAPIKey(t, router, "/api/projects/project-a/api-keys")
+
+	if w := apiKeyRequest(router, key, "GET", "/api/projects/project-a", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 before revoking, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Use is recorded in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for countTenancyRows(t, app, "SELECT COUNT(*) FROM api_keys WHERE last_used_at IS NOT NULL") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected last_used_at to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Another user cannot revoke it
+	if w := tenancyRequest(router, "token-b", "DELETE", "/api/projects/project-a/api-keys/"+keyID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking another client's key, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := tenancyRequest(router, "token-a", "DELETE", "/api/projects/project-a/api-keys/"+keyID, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 revoking, got %d: %s", w.Code, w.Body.String())
+	}
+	w := apiKeyRequest(router, key, "GET", "/api/projects/project-a", "")
+	if w.Code != http.StatusUnauthorized || responseErrorCode(t, w.Body.Bytes()) != "API_KEY_INVALID" {
+		t.Errorf("Expected 401 API_KEY_INVALID after revoking, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/api-keys", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), keyID) {
+		t.Errorf("Expected the revoked key to be hidden, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := countTenancyRows(t, app, "SELECT COUNT(*) FROM api_keys WHERE key_hash = '"+apikeys.Hash(key)+"'"); got != 1 {
+		t.Errorf("Expected the key stored by hash, got %d rows", got)
+	}
+}
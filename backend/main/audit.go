@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditLogEntries = 100
+	maxAuditLogEntries     = 500
+)
+
+// Audit actions recorded by the admin, API key and user management endpoints and authMiddleware
+const (
+	AuditActionImpersonationStart   = "impersonation.start"
+	AuditActionImpersonatedWrite    = "impersonation.write"
+	AuditActionSessionRevoke        = "session.revoke"
+	AuditActionAPIKeyCreate         = "api_key.create"
+	AuditActionAPIKeyRevoke         = "api_key.revoke"
+	AuditActionConnectionDisconnect = "connection.disconnect"
+	AuditActionClientExport         = "client.export"
+	AuditActionClientPurge          = "client.purge"
+	AuditActionUserCreate           = "user.create"
+	AuditActionUserUpdate           = "user.update"
+	AuditActionUserPasswordReset    = "user.password_reset"
+)
+
+// AuditEntry is one row of the audit log
+type AuditEntry struct {
+	ID         string                 `json:"id"`
+	ActorID    string                 `json:"actor_id"`
+	ClientID   string                 `json:"client_id,omitempty"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   string                 `json:"target_id,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	IP         string                 `json:"ip,omitempty"` // Client address of the request, see App.clientIP
+	CreatedAt  string                 `json:"created_at"`
+}
+
+// recordAudit appends an entry to the audit log. Failures are logged rather than
+// returned so auditing never fails the request it describes.
+func (app *App) recordAudit(ctx context.Context, entry AuditEntry) {
+	var details interface{}
+	if len(entry.Details) > 0 {
+		encoded, err := json.Marshal(entry.Details)
+		if err != nil {
+			log.Printf("Failed to encode audit details for %s: %v", entry.Action, err)
+		} else {
+			details = string(encoded)
+		}
+	}
+
+	_, err := app.ZDB.Execute(ctx,
+		`INSERT INTO audit_log (id, actor_id, client_id, action, target_type, target_id, details, ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		uuid.New().String(), nullableString(entry.ActorID), nullableString(entry.ClientID), entry.Action,
+		nullableString(entry.TargetType), nullableString(entry.TargetID), details, nullableString(entry.IP), time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to record audit entry %s by %s: %v", entry.Action, entry.ActorID, err)
+	}
+}
+
+// nullableString stores empty strings as NULL
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// getAuditLogHandler returns the newest audit entries, optionally filtered by
+// client_id, actor_id and action
+func (app *App) getAuditLogHandler(c *gin.Context) {
+	limit := defaultAuditLogEntries
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxAuditLogEntries {
+		limit = maxAuditLogEntries
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, filter := range []string{"client_id", "actor_id", "action"} {
+		if value := c.Query(filter); value != "" {
+			args = append(args, value)
+			conditions = append(conditions, filter+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	query := "SELECT id, actor_id, client_id, action, target_type, target_id, details, created_at, ip FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += " ORDER BY created_at DESC LIMIT $" + strconv.Itoa(len(args))
+
+	resultSet, err := app.ZDB.Query(c.Request.Context(), query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+
+	entries := make([]AuditEntry, 0, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 9 {
+			continue
+		}
+		var entry AuditEntry
+		entry.ID, _ = row.Values[0].AsString()
+		entry.ActorID, _ = row.Values[1].AsString()
+		entry.ClientID, _ = row.Values[2].AsString()
+		entry.Action, _ = row.Values[3].AsString()
+		entry.TargetType, _ = row.Values[4].AsString()
+		entry.TargetID, _ = row.Values[5].AsString()
+		if raw, ok := row.Values[6].AsJSON(); ok {
+			json.Unmarshal(raw, &entry.Details)
+		}
+		if createdAt, ok := row.Values[7].AsTimestamp(); ok {
+			entry.CreatedAt = createdAt.Time.Format(time.RFC3339)
+		}
+		entry.IP, _ = row.Values[8].AsString()
+		entries = append(entries, entry)
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/chat"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
 )
 
 type RegisterRequest struct {
@@ -21,9 +25,10 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-	ClientID string `json:"client_id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	ClientID   string `json:"client_id"`
+	ClientSlug string `json:"client_slug"` // alternative to client_id
+	Username   string `json:"username"`
+	Password   string `json:"password"`
 }
 
 type LoginResponse struct {
@@ -39,6 +44,45 @@ type User struct {
 	PasswordHash string `json:"-"`
 	IsActive     bool   `json:"is_active"`
 	CreatedAt    string `json:"created_at"`
+	// APIKeyProject is the only project a project API key may act on
+	APIKeyProject string `json:"-"`
+}
+
+const (
+	// rootUsername is the administrator account; only the one in the system client has admin rights
+	rootUsername = "root"
+	// systemClientSlug is the client root logs in to unless another client is named
+	systemClientSlug = "system"
+)
+
+var (
+	errInvalidClientID = errors.New("invalid client ID format")
+	errUnknownClient   = errors.New("client not found")
+)
+
+// resolveClient looks up an active client by ID or, when no ID is given, by slug
+func (app *App) resolveClient(ctx context.Context, clientID, slug string) (uuid.UUID, error) {
+	query, arg := "SELECT id FROM clients WHERE slug = $1 AND is_active = true", interface{}(slug)
+	if clientID != "" {
+		id, err := uuid.Parse(clientID)
+		if err != nil {
+			return uuid.Nil, errInvalidClientID
+		}
+		query, arg = "SELECT id FROM clients WHERE id = $1 AND is_active = true", id
+	}
+
+	row, err := app.ZDB.QueryRow(ctx, query, arg)
+	if errors.Is(err, db.ErrNoRows) || (err == nil && len(row.Values) == 0) {
+		return uuid.Nil, errUnknownClient
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to look up client: %w", err)
+	}
+	id, ok := row.Values[0].AsUUID()
+	if !ok {
+		return uuid.Nil, fmt.Errorf("failed to parse client ID")
+	}
+	return id, nil
 }
 
 func (app *App) registerHandler(c *gin.Context) {
@@ -46,7 +90,7 @@ func (app *App) registerHandler(c *gin.Context) {
 
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
 		return
 	}
 
@@ -56,14 +100,14 @@ func (app *App) registerHandler(c *gin.Context) {
 		var err error
 		clientID, err = app.getClientID(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
+			apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 			return
 		}
 	} else {
 		var err error
 		clientID, err = uuid.Parse(req.ClientID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID format"})
+			apierror.Respond(c, apierror.CodeClientIDInvalid, nil)
 			return
 		}
 		// Validate client exists using ZDB
@@ -71,18 +115,18 @@ func (app *App) registerHandler(c *gin.Context) {
 			"SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1 AND is_active = true)",
 			clientID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Database error"})
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
 			return
 		}
 
 		exists, ok := row.Values[0].AsBool()
 		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse result"})
+			apierror.Respond(c, apierror.CodeInternal, nil)
 			return
 		}
 
 		if !exists {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
+			apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 			return
 		}
 	}
@@ -92,24 +136,24 @@ func (app *App) registerHandler(c *gin.Context) {
 		"SELECT EXISTS(SELECT 1 FROM users WHERE client_id = $1 AND username = $2)",
 		clientID, req.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	exists, ok := row.Values[0].AsBool()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		apierror.Respond(c, apierror.CodeUserAlreadyExists, nil)
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
@@ -117,9 +161,9 @@ func (app *App) registerHandler(c *gin.Context) {
 	userID := uuid.New()
 	_, err = app.ZDB.Execute(ctx,
 		"INSERT INTO users (id, client_id, username, password_hash, is_active, created_at) VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP)",
-		userID, clientID, req.Username, string(hashedPassword))
+		userID, clientID, req.Username, hashedPassword)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
@@ -128,13 +172,13 @@ func (app *App) registerHandler(c *gin.Context) {
 		"SELECT created_at FROM users WHERE id = $1",
 		userID)
 	if err != nil || len(row.Values) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user details"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	createdAt, ok := row.Values[0].AsTimestamp()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse timestamp"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
@@ -163,64 +207,36 @@ func (app *App) loginHandler(c *gin.Context) {
 
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
 		return
 	}
 
-	// Special handling for root user
-	isRoot := req.Username == "root"
-
-	// Get client ID
+	// Get client ID: an explicit client wins, root defaults to the system client
+	// and everyone else to the client serving the request's domain
 	var clientID uuid.UUID
-	if req.ClientID == "" {
-		if isRoot {
-			// For root user, get default client using ZDB
-			row, err := app.ZDB.QueryRow(ctx,
-				"SELECT id FROM clients WHERE is_active = true ORDER BY created_at ASC LIMIT 1")
-			if err != nil || len(row.Values) == 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
-				return
-			}
-
-			var ok bool
-			clientIDStr, ok := row.Values[0].AsString()
-			if !ok {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse client ID"})
-				return
-			}
-			clientID = uuid.MustParse(clientIDStr)
-		} else {
-			var err error
-			clientID, err = app.getClientID(c)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
-				return
-			}
-		}
-	} else if !isRoot {
+	if req.ClientID != "" || req.ClientSlug != "" {
 		var err error
-		clientID, err = uuid.Parse(req.ClientID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID format"})
+		clientID, err = app.resolveClient(ctx, req.ClientID, req.ClientSlug)
+		if errors.Is(err, errInvalidClientID) {
+			apierror.Respond(c, apierror.CodeClientIDInvalid, nil)
 			return
 		}
-		// Validate client exists for non-root users using ZDB
-		row, err := app.ZDB.QueryRow(ctx,
-			"SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1 AND is_active = true)",
-			clientID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Database error"})
+			apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 			return
 		}
-
-		exists, ok := row.Values[0].AsBool()
-		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse result"})
+	} else if req.Username == rootUsername {
+		var err error
+		clientID, err = app.resolveClient(ctx, "", systemClientSlug)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 			return
 		}
-
-		if !exists {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client"})
+	} else {
+		var err error
+		clientID, err = app.getClientID(c)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeClientNotResolved, nil)
 			return
 		}
 	}
@@ -230,7 +246,7 @@ func (app *App) loginHandler(c *gin.Context) {
 		"SELECT id, client_id, username, password_hash, is_active, created_at FROM users WHERE client_id = $1 AND username = $2 AND is_active = true",
 		clientID, req.Username)
 	if err != nil || len(row.Values) < 6 {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		apierror.Respond(c, apierror.CodeAuthInvalidCredentials, nil)
 		return
 	}
 
@@ -244,42 +260,37 @@ func (app *App) loginHandler(c *gin.Context) {
 	user.IsActive, _ = row.Values[4].AsBool()
 	createdAt, _ := row.Values[5].AsTimestamp()
 	if createdAt.IsZero() {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse created date"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 	user.CreatedAt = createdAt.Time.Format(time.RFC3339)
 
 	// Verify password
-	if bcryptErr := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); bcryptErr != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if !auth.CheckPassword(user.PasswordHash, req.Password) {
+		apierror.Respond(c, apierror.CodeAuthInvalidCredentials, nil)
 		return
 	}
 
-	// Generate session token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	// Generate session token and the hash stored for it
+	token, tokenHashStr, err := newSessionToken()
+	if err != nil {
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
-	token := base64.URLEncoding.EncodeToString(tokenBytes)
-
-	// Hash token for storage
-	tokenHash := sha256.Sum256([]byte(token))
-	tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
 
 	// Create session using ZDB
 	sessionID := uuid.New().String()
-	expiresAt := time.Now().Add(24 * time.Hour) // 24 hours
+	expiresAt := time.Now().Add(app.Config.SessionTTL)
 	_, err = app.ZDB.Execute(ctx,
-		"INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)",
-		sessionID, clientID, user.ID, tokenHashStr, expiresAt)
+		"INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at, ip, user_agent, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)",
+		sessionID, clientID, user.ID, tokenHashStr, expiresAt, nullableString(app.clientIP(c)), nullableString(c.Request.UserAgent()))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	// Set secure cookie - use domain without port for proxy forwarding
-	c.SetCookie("session_token", token, int(24*time.Hour/time.Second), "/", "localhost", false, false)
+	app.setSessionCookie(c, token)
 
 	response := gin.H{
 		"success": true,
@@ -300,23 +311,22 @@ func (app *App) logoutHandler(c *gin.Context) {
 	// Get session token from cookie
 	token, err := c.Cookie("session_token")
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
 
-	// Hash token to match database
-	tokenHash := sha256.Sum256([]byte(token))
-	tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
-
 	// Delete session using ZDB
-	_, err = app.ZDB.Execute(ctx, "DELETE FROM sessions WHERE token_hash = $1", tokenHashStr)
+	_, err = app.ZDB.Execute(ctx, "DELETE FROM sessions WHERE token_hash = $1", auth.HashToken(token))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
+	if app.Sessions != nil {
+		app.Sessions.InvalidateToken(token)
+	}
 
 	// Clear cookie
-	c.SetCookie("session_token", "", -1, "/", "localhost", false, false)
+	app.setSessionCookie(c, "")
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out successfully"})
 }
@@ -324,13 +334,13 @@ func (app *App) logoutHandler(c *gin.Context) {
 func (app *App) profileHandler(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found in context"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
 	u := user.(User)
 	
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"user": gin.H{
 			"id":         u.ID,
@@ -339,66 +349,65 @@ func (app *App) profileHandler(c *gin.Context) {
 			"is_active":  u.IsActive,
 			"created_at": u.CreatedAt,
 		},
-	})
+	}
+	// Lets the UI show that root is acting as this user
+	if rootID, ok := impersonatedBy(c); ok {
+		response["impersonated_by"] = rootID
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (app *App) getCurrentUser(c *gin.Context) (*User, error) {
-	ctx := c.Request.Context()
-
-	// Get session token from cookie
-	token, err := c.Cookie("session_token")
-	if err != nil {
-		return nil, fmt.Errorf("no session found")
+// resolveSession looks up a session token through the resolver shared with
+// the WebSocket handshake; see internal/auth for what makes a session valid
+func (app *App) resolveSession(ctx context.Context, token string) (*auth.SessionUser, error) {
+	if app.Sessions == nil {
+		return auth.ResolveSession(ctx, app.ZDB, token)
 	}
+	return app.Sessions.Resolve(ctx, token)
+}
 
-	// Hash token to match database
-	tokenHash := sha256.Sum256([]byte(token))
-	tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
-
-	// Get session and user using ZDB
-	row, err := app.ZDB.QueryRow(ctx,
-		`SELECT u.id, u.client_id, u.username, u.password_hash, u.is_active, u.created_at 
-		FROM sessions s 
-		JOIN users u ON u.id::text = s.user_id::text
-		WHERE s.token_hash = $1::text AND s.expires_at > CURRENT_TIMESTAMP`,
-		tokenHashStr)
-	if err != nil || len(row.Values) < 6 {
-		return nil, fmt.Errorf("invalid or expired session")
+// sessionErrorCode is the API error reported for an error of resolveSession
+func sessionErrorCode(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrNoSession), errors.Is(err, auth.ErrExpired):
+		return apierror.CodeAuthSessionExpired
+	case errors.Is(err, auth.ErrInactive):
+		return apierror.CodeAuthAccountInactive
+	default:
+		return apierror.CodeInternal
 	}
+}
 
-	var user User
-	var ok bool
-	user.ID, ok = row.Values[0].AsString()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse user ID")
-	}
-	user.ClientID, ok = row.Values[1].AsString()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse client ID")
-	}
-	user.Username, ok = row.Values[2].AsString()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse username")
-	}
-	user.PasswordHash, ok = row.Values[3].AsString()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse password hash")
-	}
-	user.IsActive, ok = row.Values[4].AsBool()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse active status")
+// sessionUser converts a resolved session to the User set by authMiddleware
+func sessionUser(session *auth.SessionUser) User {
+	return User{
+		ID:        session.UserID,
+		ClientID:  session.ClientID,
+		Username:  session.Username,
+		IsActive:  true,
+		CreatedAt: session.CreatedAt.Format(time.RFC3339),
 	}
-	createdAt, ok := row.Values[5].AsTimestamp()
-	if !ok {
-		return nil, fmt.Errorf("failed to parse created date")
+}
+
+func (app *App) getCurrentUser(c *gin.Context) (*User, error) {
+	// Reuse the user loaded by authMiddleware when the route is protected
+	if value, exists := c.Get("user"); exists {
+		if user, ok := value.(User); ok {
+			return &user, nil
+		}
 	}
-	user.CreatedAt = createdAt.Time.Format(time.RFC3339)
 
-	// Check if user is active
-	if !user.IsActive {
-		return nil, fmt.Errorf("user account is inactive")
+	// Get session token from cookie
+	token, err := c.Cookie("session_token")
+	if err != nil {
+		return nil, auth.ErrNoSession
 	}
 
+	session, err := app.resolveSession(c.Request.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	user := sessionUser(session)
 	return &user, nil
 }
 
@@ -406,93 +415,78 @@ func (app *App) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 
-		// Get session token from cookie
-		token, err := c.Cookie("session_token")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
-			c.Abort()
+		// API keys are sent as bearer tokens instead of the session cookie
+		if secret, ok := bearerAPIKey(c); ok {
+			app.authenticateAPIKey(c, secret, apierror.Abort)
 			return
 		}
 
-		// Hash token to match database
-		tokenHash := sha256.Sum256([]byte(token))
-		tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
-
-		// Get session and user using ZDB
-		row, err := app.ZDB.QueryRow(ctx,
-			`SELECT u.id, u.client_id, u.username, u.password_hash, u.is_active, u.created_at 
-			FROM sessions s 
-			JOIN users u ON u.id = s.user_id
-			WHERE s.token_hash = $1 AND s.expires_at > CURRENT_TIMESTAMP`,
-			tokenHashStr)
+		// Get session token from cookie
+		token, err := c.Cookie("session_token")
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
-			c.Abort()
-			return
-		}
-		if len(row.Values) < 6 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
-			c.Abort()
-			return
-		}
-
-		var user User
-		var ok bool
-		user.ID, ok = row.Values[0].AsString()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user ID"})
-			c.Abort()
-			return
-		}
-		user.ClientID, ok = row.Values[1].AsString()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse client ID"})
-			c.Abort()
-			return
-		}
-		user.Username, ok = row.Values[2].AsString()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse username"})
-			c.Abort()
-			return
-		}
-		user.PasswordHash, ok = row.Values[3].AsString()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse password hash"})
-			c.Abort()
-			return
-		}
-		user.IsActive, ok = row.Values[4].AsBool()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse active status"})
-			c.Abort()
+			apierror.Abort(c, apierror.CodeAuthRequired, nil)
 			return
 		}
-		createdAt, ok := row.Values[5].AsTimestamp()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse created date"})
-			c.Abort()
-			return
-		}
-		user.CreatedAt = createdAt.Time.Format(time.RFC3339)
 
-		// Check if user is active
-		if !user.IsActive {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is inactive"})
-			c.Abort()
+		session, err := app.resolveSession(ctx, token)
+		if err != nil {
+			apierror.Abort(c, sessionErrorCode(err), nil)
 			return
 		}
+		user := sessionUser(session)
 
 		// Set user in context
 		c.Set("user", user)
 		c.Set("user_id", user.ID)
 		c.Set("client_id", user.ClientID)
 		c.Set("username", user.Username)
+		sessionID := session.SessionID
+		c.Set("session_id", sessionID)
+
+		// Sessions issued by POST /api/admin/impersonate carry the root user behind them
+		rootID := session.ImpersonatedBy
+		if rootID == "" {
+			c.Next()
+			return
+		}
+		c.Set("impersonated_by", rootID)
 
 		c.Next()
+
+		// Writes made while impersonating are attributed to the root user
+		if isWriteMethod(c.Request.Method) {
+			app.recordAudit(context.Background(), AuditEntry{
+				ActorID:    rootID,
+				IP:         app.clientIP(c),
+				ClientID:   user.ClientID,
+				Action:     AuditActionImpersonatedWrite,
+				TargetType: "user",
+				TargetID:   user.ID,
+				Details: map[string]interface{}{
+					"session_id": sessionID,
+					"method":     c.Request.Method,
+					"path":       c.FullPath(),
+					"status":     c.Writer.Status(),
+				},
+			})
+		}
 	}
 }
 
+// impersonatedBy returns the root user behind an impersonated session, set by authMiddleware
+func impersonatedBy(c *gin.Context) (string, bool) {
+	rootID := c.GetString("impersonated_by")
+	return rootID, rootID != ""
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
 func (app *App) adminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
@@ -500,38 +494,33 @@ func (app *App) adminMiddleware() gin.HandlerFunc {
 		// Get session token from cookie
 		token, err := c.Cookie("session_token")
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
-			c.Abort()
+			apierror.Abort(c, apierror.CodeAuthRequired, nil)
 			return
 		}
 
-		// Hash token to match database
-		tokenHash := sha256.Sum256([]byte(token))
-		tokenHashStr := base64.StdEncoding.EncodeToString(tokenHash[:])
-
-		// Get session and user using ZDB
-		row, err := app.ZDB.QueryRow(ctx,
-			`SELECT u.id, u.client_id, u.username, u.password_hash, u.is_active, u.created_at 
-			FROM sessions s 
-			JOIN users u ON u.id = s.user_id
-			WHERE s.token_hash = $1 AND s.expires_at > CURRENT_TIMESTAMP`,
-			tokenHashStr)
-		if err != nil || len(row.Values) < 6 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
-			c.Abort()
+		session, err := app.resolveSession(ctx, token)
+		if err != nil {
+			apierror.Abort(c, sessionErrorCode(err), nil)
 			return
 		}
+		row, err := app.ZDB.QueryRow(ctx, "SELECT slug FROM clients WHERE id = $1", session.ClientID)
+		if err != nil {
+			apierror.Abort(c, apierror.CodeAdminRequired, nil)
+			return
+		}
+		clientSlug, _ := row.Values[0].AsString()
+		userID, username := session.UserID, session.Username
 
-		username, _ := row.Values[2].AsString()
-		isActive, _ := row.Values[4].AsBool()
-
-		// Check if user is root and active
-		if !isActive || username != "root" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
+		// Check if user is the system client's root; a tenant's own root account
+		// is an ordinary user, and impersonated sessions never carry admin rights
+		if username != rootUsername || clientSlug != systemClientSlug || session.ImpersonatedBy != "" {
+			apierror.Abort(c, apierror.CodeAdminRequired, nil)
 			return
 		}
 
+		c.Set("user_id", userID)
+		c.Set("username", username)
+
 		c.Next()
 	}
 }
@@ -543,6 +532,14 @@ type Conversation struct {
 	UserID    string `json:"user_id"`
 	ProjectID string `json:"project_id"`
 	Status    string `json:"status"` // processing, completed, interrupted
+	Pinned    bool   `json:"pinned"`
+	PinnedAt  string `json:"pinned_at,omitempty"`
+	ForkedFromConversationID string `json:"forked_from_conversation_id,omitempty"` // Set on forks
+	Language string `json:"language,omitempty"` // Language code responses are pinned to
+	Version  int64  `json:"version"`             // Sent back in If-Match by pin and settings changes
+	DraftsEnabled bool `json:"drafts_enabled"` // Replies wait as drafts for an editor's approval
+	MessageCount       int    `json:"message_count"`
+	LastMessagePreview string `json:"last_message_preview"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
 }
@@ -552,28 +549,30 @@ func (app *App) getConversationsHandler(c *gin.Context) {
 	
 	// Get user ID from auth middleware
 	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
 	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
 	
-	// Get project ID from current session (this is a simplification - 
-	// in production you might want to filter by project_id from query params)
-	projectID := "d3eb9ece-48e7-45d0-a281-6b780351dedd" // Default project for now
+	// Filter by the requested project, falling back to the default project
+	projectID := c.DefaultQuery("project_id", app.defaultProjectID(c))
 	
-	// Query conversations using ZDB
-	resultSet, err := app.ZDB.Query(ctx, `
-		SELECT id, title, user_id, project_id, status, created_at, updated_at 
-		FROM conversations 
-		WHERE user_id = $1 AND project_id = $2 
-		ORDER BY updated_at DESC
-	`, userID, projectID)
+	// Listings tolerate replica lag, so they are read from the replica when one is set
+	resultSet, err := app.readDB().Query(ctx, `
+		SELECT c.id, c.title, c.user_id, c.project_id, c.status, c.created_at, c.updated_at, c.pinned, c.pinned_at,
+			c.forked_from_conversation_id, c.language, c.version, c.drafts_enabled, `+chat.ConversationSummaryColumns+`
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		`+chat.ConversationSummaryJoin+`
+		WHERE (c.user_id = $1 OR EXISTS (
+			SELECT 1 FROM conversation_participants cp WHERE cp.conversation_id = c.id AND cp.user_id = $1))
+		AND c.project_id = $2 AND u.client_id = $3 AND c.deleted_at IS NULL
+		ORDER BY c.pinned DESC, c.pinned_at DESC, c.updated_at DESC
+	`, userID, projectID, clientID)
 	
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to query conversations",
-			"details": err.Error(),
-		})
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
 		return
 	}
 	
@@ -581,7 +580,7 @@ func (app *App) getConversationsHandler(c *gin.Context) {
 	for _, row := range resultSet.Rows {
 		conv := Conversation{}
 		// Map row values to struct
-		if len(row.Values) >= 7 {
+		if len(row.Values) >= 15 {
 			conv.ID, _ = row.Values[0].AsString()
 			conv.Title, _ = row.Values[1].AsString()
 			conv.UserID, _ = row.Values[2].AsString()
@@ -589,6 +588,16 @@ func (app *App) getConversationsHandler(c *gin.Context) {
 			conv.Status, _ = row.Values[4].AsString()
 			conv.CreatedAt, _ = row.Values[5].AsString()
 			conv.UpdatedAt, _ = row.Values[6].AsString()
+			conv.Pinned, _ = row.Values[7].AsBool()
+			conv.PinnedAt = formatTimestamp(row.Values[8])
+			conv.ForkedFromConversationID, _ = row.Values[9].AsString()
+			conv.Language, _ = row.Values[10].AsString()
+			conv.Version, _ = row.Values[11].AsInt64()
+			conv.DraftsEnabled, _ = row.Values[12].AsBool()
+			if count, ok := row.Values[13].AsInt64(); ok {
+				conv.MessageCount = int(count)
+			}
+			conv.LastMessagePreview, _ = row.Values[14].AsString()
 		}
 		conversations = append(conversations, conv)
 	}
@@ -608,6 +617,8 @@ type Message struct {
 	Metadata  map[string]interface{}  `json:"metadata,omitempty"`
 	ToolCalls []ToolCall             `json:"tool_calls,omitempty"`
 	CreatedAt string                 `json:"created_at"`
+	UserID    string                 `json:"user_id,omitempty"` // Sender of a user message
+	Status    string                 `json:"status,omitempty"`  // final, or draft until approved
 }
 
 type ToolCall struct {
@@ -617,6 +628,7 @@ type ToolCall struct {
 	Status   string                 `json:"status,omitempty"`
 	Result   interface{}            `json:"result,omitempty"`
 	Error    string                 `json:"error,omitempty"`
+	Reruns   []chat.ToolCallRerun   `json:"reruns,omitempty"`
 }
 
 type ToolCallFunction struct {
@@ -630,88 +642,101 @@ func (app *App) getConversationMessagesHandler(c *gin.Context) {
 	
 	// Get user ID from auth middleware
 	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
 	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
 	
-	// Validate conversation belongs to user
-	convResult, err := app.ZDB.QueryRow(ctx, `
-		SELECT id FROM conversations 
-		WHERE id = $1 AND user_id = $2
-	`, conversationID, userID)
+	// History is read from the replica when one is set; only legacy JSON
+	// rewrites go to the primary
+	reader := app.readDB()
+
+	// Validate the user takes part in the conversation within their client
+	convResult, err := reader.QueryRow(ctx, `
+		SELECT c.id FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND (c.user_id = $2 OR EXISTS (
+			SELECT 1 FROM conversation_participants cp WHERE cp.conversation_id = c.id AND cp.user_id = $2))
+		AND u.client_id = $3 AND c.deleted_at IS NULL
+	`, conversationID, userID, clientID)
 	
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to validate conversation",
-			"details": err.Error(),
-		})
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
 		return
 	}
 	
 	if convResult.Values == nil || len(convResult.Values) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
 		return
 	}
 	
-	// Query messages for this conversation
-	resultSet, err := app.ZDB.Query(ctx, `
-		SELECT id, conversation_id, role, content, metadata, tool_calls, created_at 
-		FROM messages 
-		WHERE conversation_id = $1 
-		ORDER BY created_at ASC
-	`, conversationID)
-	
+	// Page through the messages the way get_conversation does over WebSocket
+	page := chat.MessagePage{BeforeMessageID: c.Query("before_message_id")}
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "limit", "min": 1})
+			return
+		}
+		page.Limit = parsed
+	}
+
+	query, args := chat.MessageTotalsQuery(conversationID, page)
+	totals, err := reader.QueryRow(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to query messages",
-			"details": err.Error(),
-		})
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
 		return
 	}
-	
+	total, _ := totals.Values[1].AsInt64()
+	if cursorFound, _ := totals.Values[0].AsInt64(); page.BeforeMessageID != "" && cursorFound == 0 {
+		apierror.Respond(c, apierror.CodeMessageNotFound, nil)
+		return
+	}
+
+	query, args = chat.MessagePageQuery(conversationID, page)
+	resultSet, err := reader.Query(ctx, query, args...)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	rows, hasMore := chat.PageRows(resultSet.Rows, page)
+
+	executions, err := chat.LoadToolExecutions(ctx, &tools.ZlayDBAdapter{DB: reader}, conversationID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
 	messages := []Message{}
-	for _, row := range resultSet.Rows {
-		msg := Message{}
-		if len(row.Values) >= 6 {
-			msg.ID, _ = row.Values[0].AsString()
-			msg.ConversationID, _ = row.Values[1].AsString()
-			msg.Role, _ = row.Values[2].AsString()
-			msg.Content, _ = row.Values[3].AsString()
-			
-			// Parse metadata JSON
-			metadataStr, _ := row.Values[4].AsString()
-			if metadataStr != "" {
-				if err := json.Unmarshal([]byte(metadataStr), &msg.Metadata); err != nil {
-					msg.Metadata = make(map[string]interface{})
-				}
-			}
-			
-			// Parse tool_calls JSON
-			toolCallsStr, _ := row.Values[5].AsString()
-			if toolCallsStr != "" {
-				if err := json.Unmarshal([]byte(toolCallsStr), &msg.ToolCalls); err != nil {
-					msg.ToolCalls = []ToolCall{}
-				}
+	for _, row := range rows {
+		msg, legacy, ok := messageFromRow(row)
+		if !ok {
+			continue
+		}
+		if legacy != nil && app.Config.MigrateMessageJSON {
+			if err := chat.RewriteMessageJSON(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, legacy); err != nil {
+				log.Printf("Failed to migrate message JSON: %v", err)
 			}
-			
-			msg.CreatedAt, _ = row.Values[6].AsString()
 		}
+		applyToolExecutions(executions, &msg)
+		app.enrichToolCalls(conversationID, msg.ToolCalls)
 		messages = append(messages, msg)
 	}
 	
 	// Also get conversation details
-	convResultSet, err := app.ZDB.Query(ctx, `
+	convResultSet, err := reader.Query(ctx, `
 		SELECT id, title, user_id, project_id, status, created_at, updated_at 
 		FROM conversations 
 		WHERE id = $1
 	`, conversationID)
 	
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get conversation details",
-			"details": err.Error(),
-		})
+		apierror.Respond(c, apierror.CodeDatabaseError, map[string]interface{}{"error": err.Error()})
 		return
 	}
 	
@@ -735,5 +760,7 @@ func (app *App) getConversationMessagesHandler(c *gin.Context) {
 			"conversation": conversation,
 			"messages": messages,
 		},
+		"has_more":            hasMore,
+		"total_message_count": total,
 	})
 }
@@ -4,6 +4,9 @@ import (
 	"context"
 	"testing"
 	"time"
+
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/websocket"
 )
 
 func TestContextCancellation(t *testing.T) {
@@ -33,12 +36,10 @@ func TestContextCancellation(t *testing.T) {
 
 func TestMutexLocking(t *testing.T) {
 	// Simple test to verify proper mutex usage
-	cache := &ClientConfigCache{
-		cache: make(map[string]*ClientConfig),
-	}
+	cache := websocket.NewClientConfigCache(nil, config.Default())
 	
 	// This is just a basic structure test
-	if cache.cache == nil {
+	if cache.GetCacheStats()["cached_clients"] != 0 {
 		t.Error("❌ Cache map not initialized")
 	} else {
 		t.Log("✅ Cache map properly initialized")
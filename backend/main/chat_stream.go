@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/websocket"
+)
+
+// errStreamIdle cancels a streamed chat whose provider has gone quiet
+var errStreamIdle = errors.New("no data from the LLM provider within the request timeout")
+
+// chatStreamEvent is the JSON payload of each SSE data event sent by POST /api/chat
+type chatStreamEvent struct {
+	Content    string `json:"content,omitempty"`
+	Done       bool   `json:"done"`
+	TokensUsed int    `json:"tokens_used,omitempty"`
+	Estimated  bool   `json:"estimated,omitempty"`
+	Model      string `json:"model,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Set with Error when the provider rate limited the request
+	Code              string `json:"code,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+	// Set on the done event; its model is the same as Model
+	*chat.MessageTiming
+}
+
+// wantsEventStream reports whether a /api/chat caller asked for SSE
+func wantsEventStream(c *gin.Context) bool {
+	return c.Query("stream") == "true" || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// streamChatHandler writes the LLM response as server-sent events, one per
+// streaming chunk, ending with a done event that carries the token usage and
+// timing. The
+// LLM request is cancelled when the client disconnects, and when the provider
+// sends nothing for LLMRequestTimeout; that timeout bounds idle time rather than
+// the whole answer, which may take much longer to stream.
+func (app *App) streamChatHandler(c *gin.Context, clientConfig *websocket.ClientConfig, llmReq *llm.LLMRequest) {
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	defer cancel(nil)
+
+	idle := time.AfterFunc(app.Config.LLMRequestTimeout, func() { cancel(errStreamIdle) })
+	defer idle.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	tokensUsed := 0
+	model := clientConfig.LLMClient.GetModel()
+	finished := false
+	timer := chat.NewStreamTimer(time.Now)
+	err := clientConfig.LLMClient.StreamChat(ctx, llmReq, func(chunk *llm.StreamingChunk) error {
+		idle.Reset(app.Config.LLMRequestTimeout)
+		timer.Chunk(chunk)
+
+		event := chatStreamEvent{Content: chunk.Content, Done: chunk.Done}
+		if chunk.Done {
+			tokensUsed = chunk.TokensUsed
+			event.TokensUsed = chunk.TokensUsed
+			event.Estimated = chunk.Estimated
+			event.Model = model
+			timing := timer.Finish(model)
+			event.MessageTiming = &timing
+			finished = true
+		} else if chunk.Content == "" {
+			return nil // Tool call deltas have no meaning for a one-shot chat
+		}
+		return writeChatStreamEvent(c, event)
+	})
+
+	if err == nil && finished {
+		return
+	}
+	if c.Request.Context().Err() != nil {
+		log.Printf("Chat stream cancelled by client after %d tokens", tokensUsed)
+		return
+	}
+	if err == nil {
+		err = errors.New("stream ended without a final chunk")
+	}
+	if errors.Is(context.Cause(ctx), errStreamIdle) {
+		err = errStreamIdle
+	}
+	log.Printf("Chat stream failed for client %s: %v", clientConfig.ClientID, err)
+	// Headers are already sent, so the failure is reported as a final event
+	event := chatStreamEvent{Done: true, Error: "LLM call failed: " + err.Error()}
+	if rateLimited, ok := llmRateLimited(clientConfig.ClientID, err); ok {
+		event.Code = apierror.CodeLLMRateLimited
+		event.RetryAfterSeconds = rateLimited.RetryAfterSeconds()
+	}
+	writeChatStreamEvent(c, event)
+}
+
+// llmRateLimited reports whether err is the LLM provider rate limiting the
+// client, counting it in the client's rate-limit metric when it is
+func llmRateLimited(clientID string, err error) (*llm.RateLimitedError, bool) {
+	var rateLimited *llm.RateLimitedError
+	if !errors.As(err, &rateLimited) {
+		return nil, false
+	}
+	metrics.Counter(chat.MetricLLMRateLimited).Inc(clientID)
+	return rateLimited, true
+}
+
+// writeChatStreamEvent writes one SSE data event and flushes it to the client
+func writeChatStreamEvent(c *gin.Context, event chatStreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
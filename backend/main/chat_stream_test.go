@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/domains"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/metrics"
+	"zlay-backend/internal/websocket"
+)
+
+const streamTestClientID = "00000000-0000-0000-0000-00000000000a"
+
+// streamingLLMClient streams fixed deltas, or blocks after the first one until
+// its context is cancelled when block is set. Every call fails with err when set.
+// The max_tokens of every request is recorded in maxTokens.
+type streamingLLMClient struct {
+	deltas    []string
+	block     bool
+	cancelled chan struct{}
+	err       error
+
+	mu        sync.Mutex
+	maxTokens []int
+}
+
+func (f *streamingLLMClient) record(req *llm.LLMRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxTokens = append(f.maxTokens, req.MaxTokens)
+}
+
+func (f *streamingLLMClient) StreamChat(ctx context.Context, req *llm.LLMRequest, callback func(*llm.StreamingChunk) error) error {
+	f.record(req)
+	if f.err != nil {
+		return f.err
+	}
+	for i, delta := range f.deltas {
+		if err := callback(&llm.StreamingChunk{Content: delta}); err != nil {
+			return err
+		}
+		if f.block && i == 0 {
+			<-ctx.Done()
+			close(f.cancelled)
+			return ctx.Err()
+		}
+	}
+	return callback(&llm.StreamingChunk{Done: true, TokensUsed: 7, Estimated: true})
+}
+
+func (f *streamingLLMClient) Chat(ctx context.Context, req *llm.LLMRequest) (*llm.LLMResponse, error) {
+	f.record(req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &llm.LLMResponse{Content: strings.Join(f.deltas, ""), TokensUsed: 7, Model: "fake-model"}, nil
+}
+
+func (f *streamingLLMClient) SetModel(model string) error { return nil }
+func (f *streamingLLMClient) GetModel() string            { return "fake-model" }
+
+// newChatStreamTestServer serves /api/chat for a client resolved from the
+// chat.example origin, whose LLM client is the given fake
+func newChatStreamTestServer(t *testing.T, client *streamingLLMClient) *httptest.Server {
+	t.Helper()
+
+	clientID := uuid.MustParse(streamTestClientID)
+	app := &App{Config: config.Default(), DomainCache: domains.NewCache(domains.DefaultNegativeTTL)}
+	app.DomainCache.Put("chat.example", clientID)
+	app.ClientConfigCache = websocket.NewClientConfigCache(nil, app.Config)
+	app.ClientConfigCache.SetClientConfig(&websocket.ClientConfig{ClientID: streamTestClientID, LLMClient: client})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", app.chatHandler)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func postChat(t *testing.T, url, accept string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(`{"message": "Hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://chat.example")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	return resp
+}
+
+// readChatStreamEvent reads the next SSE data event
+func readChatStreamEvent(t *testing.T, reader *bufio.Reader) chatStreamEvent {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended early: %v", err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			var event chatStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Invalid event %q: %v", data, err)
+			}
+			return event
+		}
+	}
+}
+
+func TestChatHandlerStreamsServerSentEvents(t *testing.T) {
+	server := newChatStreamTestServer(t, &streamingLLMClient{deltas: []string{"Hel", "lo ", "there"}})
+
+	for _, tc := range []struct{ name, path, accept string }{
+		{"accept header", "/api/chat", "text/event-stream"},
+		{"query parameter", "/api/chat?stream=true", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := postChat(t, server.URL+tc.path, tc.accept)
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Expected an event stream, got %d %q", resp.StatusCode, ct)
+			}
+
+			reader := bufio.NewReader(resp.Body)
+			var content strings.Builder
+			for i := 0; i < 3; i++ {
+				event := readChatStreamEvent(t, reader)
+				if event.Done {
+					t.Fatalf("Unexpected early done event: %+v", event)
+				}
+				content.WriteString(event.Content)
+			}
+			if content.String() != "Hello there" {
+				t.Errorf("Expected the deltas in order, got %q", content.String())
+			}
+
+			final := readChatStreamEvent(t, reader)
+			if !final.Done || final.TokensUsed != 7 || !final.Estimated || final.Model != "fake-model" || final.Error != "" ||
+				final.MessageTiming == nil || final.ChunkCount != 3 || final.TotalMs < final.TTFTMs {
+				t.Errorf("Unexpected final event: %+v", final)
+			}
+		})
+	}
+}
+
+func TestChatHandlerKeepsJSONForNonStreamingCallers(t *testing.T) {
+	server := newChatStreamTestServer(t, &streamingLLMClient{deltas: []string{"Hello"}})
+
+	resp := postChat(t, server.URL+"/api/chat", "application/json")
+	defer resp.Body.Close()
+
+	var body struct {
+		Response   string `json:"response"`
+		TokensUsed int    `json:"tokens_used"`
+		ChunkCount int    `json:"chunk_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a JSON response, got %d: %v", resp.StatusCode, err)
+	}
+	if body.Response != "Hello" || body.TokensUsed != 7 || body.ChunkCount != 1 {
+		t.Errorf("Unexpected response: %+v", body)
+	}
+}
+
+func TestChatHandlerStreamCancelledOnDisconnect(t *testing.T) {
+	client := &streamingLLMClient{deltas: []string{"Hel", "lo"}, block: true, cancelled: make(chan struct{})}
+	server := newChatStreamTestServer(t, client)
+
+	resp := postChat(t, server.URL+"/api/chat?stream=true", "")
+	if event := readChatStreamEvent(t, bufio.NewReader(resp.Body)); event.Content != "Hel" {
+		t.Fatalf("Expected the first delta, got %+v", event)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-client.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the LLM context to be cancelled when the client disconnects")
+	}
+}
+
+func TestChatHandlerValidatesMessageContent(t *testing.T) {
+	server := newChatStreamTestServer(t, &streamingLLMClient{deltas: []string{"Hello"}})
+
+	for _, tc := range []struct {
+		message string
+		status  int
+		code    string
+	}{
+		{"\u0000 \u0007", http.StatusBadRequest, "INVALID_MESSAGE"},
+		{strings.Repeat("é", chat.DefaultMaxMessageChars+1), http.StatusRequestEntityTooLarge, "MESSAGE_TOO_LONG"},
+		{" " + strings.Repeat("é", chat.DefaultMaxMessageChars) + " ", http.StatusOK, ""},
+	} {
+		body, _ := json.Marshal(map[string]string{"message": tc.message})
+		req, _ := http.NewRequest("POST", server.URL+"/api/chat", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "https://chat.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var result struct {
+			Code    string                 `json:"code"`
+			Details map[string]interface{} `json:"details"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status || result.Code != tc.code {
+			t.Errorf("%d characters: expected %d %q, got %d %q", len(tc.message), tc.status, tc.code, resp.StatusCode, result.Code)
+		}
+		if tc.code == "MESSAGE_TOO_LONG" && result.Details["limit"] != float64(chat.DefaultMaxMessageChars) {
+			t.Errorf("Expected the limit in the details, got %+v", result.Details)
+		}
+	}
+}
+
+func TestChatHandlerReportsProviderRateLimits(t *testing.T) {
+	rateLimited := &llm.RateLimitedError{RetryAfter: 1500 * time.Millisecond, Err: errors.New("429 Too Many Requests")}
+	server := newChatStreamTestServer(t, &streamingLLMClient{err: rateLimited})
+	before := metrics.Counter(chat.MetricLLMRateLimited).Snapshot()[streamTestClientID].Count
+
+	resp := postChat(t, server.URL+"/api/chat", "application/json")
+	defer resp.Body.Close()
+	var body struct {
+		Code    string                 `json:"code"`
+		Details map[string]interface{} `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if body.Code != apierror.CodeLLMRateLimited || body.Details["retry_after_seconds"] != float64(2) {
+		t.Errorf("Unexpected response: %+v", body)
+	}
+
+	stream := postChat(t, server.URL+"/api/chat", "text/event-stream")
+	defer stream.Body.Close()
+	final := readChatStreamEvent(t, bufio.NewReader(stream.Body))
+	if !final.Done || final.Code != apierror.CodeLLMRateLimited || final.RetryAfterSeconds != 2 {
+		t.Errorf("Expected a rate-limited final event, got %+v", final)
+	}
+
+	if after := metrics.Counter(chat.MetricLLMRateLimited).Snapshot()[streamTestClientID].Count; after != before+2 {
+		t.Errorf("Expected both rate limits to be counted, got %d then %d", before, after)
+	}
+}
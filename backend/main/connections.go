@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type disconnectRequest struct {
+	UserID       string `json:"user_id"`
+	ConnectionID string `json:"connection_id"`
+	Reason       string `json:"reason"`
+}
+
+// getConnectionsHandler lists the open WebSocket connections, optionally only
+// those of user_id
+func (app *App) getConnectionsHandler(c *gin.Context) {
+	if app.WSServer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WebSocket server is not running"})
+		return
+	}
+
+	connections := app.WSServer.ListConnections()
+	if userID := c.Query("user_id"); userID != "" {
+		filtered := connections[:0]
+		for _, conn := range connections {
+			if conn.UserID == userID {
+				filtered = append(filtered, conn)
+			}
+		}
+		connections = filtered
+	}
+	c.JSON(http.StatusOK, gin.H{"connections": connections, "count": len(connections)})
+}
+
+// disconnectConnectionsHandler force-disconnects every connection of user_id,
+// or the single connection_id, and detaches them from their streams so a
+// client stuck on a zombie stream can start over
+func (app *App) disconnectConnectionsHandler(c *gin.Context) {
+	var req disconnectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if req.UserID == "" && req.ConnectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id or connection_id is required"})
+		return
+	}
+	if app.WSServer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WebSocket server is not running"})
+		return
+	}
+
+	disconnected := app.WSServer.ForceDisconnect(req.UserID, req.ConnectionID, req.Reason)
+	if disconnected > 0 {
+		entry := AuditEntry{
+			ActorID:    c.GetString("user_id"),
+			IP:         app.clientIP(c),
+			Action:     AuditActionConnectionDisconnect,
+			TargetType: "user",
+			TargetID:   req.UserID,
+			Details:    map[string]interface{}{"connections": disconnected},
+		}
+		if req.ConnectionID != "" {
+			entry.TargetType, entry.TargetID = "connection", req.ConnectionID
+		}
+		if req.Reason != "" {
+			entry.Details["reason"] = req.Reason
+		}
+		app.recordAudit(c.Request.Context(), entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "disconnected": disconnected})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"zlay-backend/internal/websocket"
+)
+
+func TestAdminForceDisconnect(t *testing.T) {
+	app := newSessionsTestApp(t)
+	app.Config.WSPort, app.Config.FilesDir = "0", t.TempDir()
+	app.Config.AbandonedSweepInterval, app.Config.SaveJournalPath = 0, ""
+	app.WSServer = websocket.NewServer(app.ZDB, app.Config)
+	router := newSessionsTestRouter(app)
+	router.GET("/api/admin/connections", app.adminMiddleware(), app.getConnectionsHandler)
+	router.POST("/api/admin/connections/disconnect", app.adminMiddleware(), app.disconnectConnectionsHandler)
+	app.WSServer.Mount(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + websocket.MountedPath + "?project=project-1&token=" + aliceToken
+	ws, _, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer ws.Close()
+
+	var listed struct {
+		Connections []websocket.ConnectionInfo `json:"connections"`
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(listed.Connections) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected alice's connection to be listed")
+		}
+		w := tenancyRequest(router, rootToken, "GET", "/api/admin/connections?user_id=alice", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("Invalid connections response: %s", w.Body.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if conn := listed.Connections[0]; conn.UserID != "alice" || conn.ClientID != tenantClientID || conn.IP != "127.0.0.1" || conn.ConnectedSince.IsZero() {
+		t.Errorf("Unexpected connection %+v", conn)
+	}
+
+	// Only root may list or disconnect, and a target is required
+	if w := tenancyRequest(router, aliceToken, "POST", "/api/admin/connections/disconnect", `{"user_id": "alice"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a regular user to be refused, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "POST", "/api/admin/connections/disconnect", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a target, got %d", w.Code)
+	}
+
+	w := tenancyRequest(router, rootToken, "POST", "/api/admin/connections/disconnect", `{"user_id": "alice", "reason": "stuck stream"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"disconnected":1`) {
+		t.Fatalf("Expected one connection disconnected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The client is told why, then closed with a close frame
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var forced bool
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if !gorilla.IsCloseError(err, websocket.CloseForceDisconnect) {
+				t.Errorf("Expected close code %d, got %v", websocket.CloseForceDisconnect, err)
+			}
+			break
+		}
+		if strings.Contains(string(data), `"type":"force_disconnect"`) && strings.Contains(string(data), "stuck stream") {
+			forced = true
+		}
+	}
+	if !forced {
+		t.Error("Expected a force_disconnect message before the close")
+	}
+
+	var entries []AuditEntry
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/audit-log?action="+AuditActionConnectionDisconnect, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].TargetID != "alice" {
+		t.Errorf("Expected the disconnect to be audited, got %s", w.Body.String())
+	}
+}
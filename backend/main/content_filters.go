@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/contentfilter"
+	"zlay-backend/internal/tools"
+)
+
+// bindContentFilterInput reads and normalizes a content filter from the request body
+func bindContentFilterInput(c *gin.Context) (contentfilter.Input, bool) {
+	var in contentfilter.Input
+	if err := c.ShouldBindJSON(&in); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return in, false
+	}
+	if err := in.Normalize(); err != nil {
+		code, details := contentfilter.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return in, false
+	}
+	return in, true
+}
+
+// getContentFiltersHandler lists the current user's client content filters in the order they run
+func (app *App) getContentFiltersHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	rules, err := contentfilter.List(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"content_filters": rules})
+}
+
+// getContentFilterHandler returns one content filter of the current user's client
+func (app *App) getContentFilterHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	rule, err := contentfilter.Get(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, c.Param("filter_id"))
+	if err != nil {
+		code, details := contentfilter.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// createContentFilterHandler adds a content filter to the current user's client
+func (app *App) createContentFilterHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	in, ok := bindContentFilterInput(c)
+	if !ok {
+		return
+	}
+
+	rule, err := contentfilter.Create(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, in)
+	if err != nil {
+		code, details := contentfilter.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// updateContentFilterHandler replaces a content filter of the current user's client
+func (app *App) updateContentFilterHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	in, ok := bindContentFilterInput(c)
+	if !ok {
+		return
+	}
+
+	rule, err := contentfilter.Update(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, c.Param("filter_id"), in)
+	if err != nil {
+		code, details := contentfilter.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// deleteContentFilterHandler removes a content filter of the current user's client
+func (app *App) deleteContentFilterHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	if err := contentfilter.Delete(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, c.Param("filter_id")); err != nil {
+		code, details := contentfilter.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Content filter deleted successfully"})
+}
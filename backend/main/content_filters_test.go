@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/contentfilter"
+)
+
+func newContentFiltersTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/settings/content-filters", app.authMiddleware(), app.getContentFiltersHandler)
+	router.POST("/api/settings/content-filters", app.authMiddleware(), app.createContentFilterHandler)
+	router.GET("/api/settings/content-filters/:filter_id", app.authMiddleware(), app.getContentFilterHandler)
+	router.PUT("/api/settings/content-filters/:filter_id", app.authMiddleware(), app.updateContentFilterHandler)
+	router.DELETE("/api/settings/content-filters/:filter_id", app.authMiddleware(), app.deleteContentFilterHandler)
+	return router
+}
+
+func TestContentFiltersCRUD(t *testing.T) {
+	router := newContentFiltersTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/settings/content-filters",
+		`{"name":" Account numbers ","pattern":"ACCT-\\d{6}","replacement":"[ACCOUNT]"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created contentfilter.Rule
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	if created.Name != "Account numbers" || created.Kind != contentfilter.KindRegex || created.ClientID != "client-a" || !created.Enabled {
+		t.Errorf("Expected a trimmed, enabled regex filter of the client, got %+v", created)
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, "token-a", "POST", "/api/settings/content-filters",
+		`{"name":"Emails","kind":"email","position":-1}`), http.StatusBadRequest, nil)
+
+	for body, field := range map[string]string{
+		`{"name":"Nested","pattern":"(a+)+b"}`:                   "pattern",
+		`{"name":"Empty","pattern":"x*"}`:                        "pattern",
+		`{"name":"Broken","pattern":"("}`:                        "pattern",
+		`{"name":"Phones","kind":"phone"}`:                       "kind",
+		`{"name":"Cards","kind":"credit_card","pattern":"\\d+"}`: "pattern",
+	} {
+		w := tenancyRequest(router, "token-a", "POST", "/api/settings/content-filters", body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "CONTENT_FILTER_INVALID") || !strings.Contains(w.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("%s: expected CONTENT_FILTER_INVALID on %s, got %d: %s", body, field, w.Code, w.Body.String())
+		}
+	}
+
+	path := "/api/settings/content-filters/" + created.ID
+	w = tenancyRequest(router, "token-a", "PUT", path, `{"name":"Account numbers","pattern":"ACCT-\\d{8}","enabled":false}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"enabled":false`) || !strings.Contains(w.Body.String(), `"replacement":"[REDACTED]"`) {
+		t.Errorf("Expected the filter to be replaced, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, "token-a", "GET", "/api/settings/content-filters", ""); w.Code != http.StatusOK || strings.Count(w.Body.String(), `"id"`) != 1 {
+		t.Errorf("Expected one filter, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Another client's user sees none of it
+	if w := tenancyRequest(router, "token-b", "GET", "/api/settings/content-filters", ""); !strings.Contains(w.Body.String(), `"content_filters":[]`) {
+		t.Errorf("Expected no filters for another client, got %s", w.Body.String())
+	}
+	for _, method := range []string{"GET", "DELETE"} {
+		if w := tenancyRequest(router, "token-b", method, path, ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "CONTENT_FILTER_NOT_FOUND") {
+			t.Errorf("%s: expected 404 for another client's filter, got %d: %s", method, w.Code, w.Body.String())
+		}
+	}
+
+	decodeSQLiteResponse(t, tenancyRequest(router, "token-a", "DELETE", path, ""), http.StatusOK, nil)
+	if w := tenancyRequest(router, "token-a", "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the filter to be gone, got %d", w.Code)
+	}
+}
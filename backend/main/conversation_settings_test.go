@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/websocket"
+)
+
+// responseErrorCode decodes the code of an apierror response body
+func responseErrorCode(t *testing.T, body []byte) string {
+	t.Helper()
+
+	var decoded apiErrorBody
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Invalid JSON body %q: %v", body, err)
+	}
+	return decoded.Code
+}
+
+func TestCreateConversationModelOverrides(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	app.ClientConfigCache = websocket.NewClientConfigCache(nil, app.Config)
+	app.ClientConfigCache.SetClientConfig(&websocket.ClientConfig{
+		ClientID:      "client-a",
+		Model:         "default-model",
+		AllowedModels: []string{"gpt-4o"},
+	})
+	router := newTenancyTestRouter(app)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/conversations", `{"project_id":"project-a","model":"o1-pro"}`)
+	if w.Code != http.StatusForbidden || responseErrorCode(t, w.Body.Bytes()) != "MODEL_NOT_ALLOWED" {
+		t.Errorf("Expected 403 MODEL_NOT_ALLOWED, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "POST", "/api/conversations", `{"project_id":"project-a","temperature":3}`)
+	if w.Code != http.StatusBadRequest || responseErrorCode(t, w.Body.Bytes()) != "FIELD_INVALID" {
+		t.Errorf("Expected 400 FIELD_INVALID for temperature, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "POST", "/api/conversations",
+		`{"project_id":"project-a","title":"Tuned","model":"gpt-4o","temperature":0.2,"max_tokens":512}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Conversation struct {
+			ID          string   `json:"id"`
+			Title       string   `json:"title"`
+			Model       *string  `json:"model"`
+			Temperature *float64 `json:"temperature"`
+			MaxTokens   *int     `json:"max_tokens"`
+		} `json:"conversation"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	conv := resp.Conversation
+	if conv.Title != "Tuned" || conv.Model == nil || *conv.Model != "gpt-4o" ||
+		conv.Temperature == nil || *conv.Temperature != 0.2 || conv.MaxTokens == nil || *conv.MaxTokens != 512 {
+		t.Errorf("Unexpected conversation: %s", w.Body.String())
+	}
+
+	// Without overrides the client config is not needed and the columns stay empty
+	w = tenancyRequest(router, "token-a", "POST", "/api/conversations", `{"project_id":"project-a"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 without overrides, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := countTenancyRows(t, app, "SELECT COUNT(*) FROM conversations WHERE project_id = 'project-a' AND model IS NULL AND title = 'New Conversation'"); got != 1 {
+		t.Errorf("Expected one conversation using the defaults, got %d", got)
+	}
+}
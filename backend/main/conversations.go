@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+	"zlay-backend/internal/websocket"
+)
+
+type createConversationRequest struct {
+	ProjectID   string   `json:"project_id"`
+	Title       string   `json:"title"`
+	Model       *string  `json:"model"`
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   *int     `json:"max_tokens"`
+}
+
+// createConversationHandler creates a conversation in one of the caller's
+// projects. model, temperature and max_tokens override the client's LLM
+// settings for the conversation; the model must be the client's own or on its
+// allowed_models list.
+func (app *App) createConversationHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req createConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if req.ProjectID == "" {
+		req.ProjectID = app.defaultProjectID(c)
+	}
+	if req.ProjectID == "" {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "project_id"})
+		return
+	}
+	if req.Title == "" {
+		req.Title = "New Conversation"
+	}
+
+	owned, err := app.userOwnsProject(ctx, req.ProjectID, user)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if !owned {
+		apierror.Respond(c, apierror.CodeProjectNotFound, nil)
+		return
+	}
+
+	settings := chat.ModelSettings{Model: req.Model, Temperature: req.Temperature, MaxTokens: req.MaxTokens}
+	if req.Model != nil || req.Temperature != nil || req.MaxTokens != nil {
+		if app.ClientConfigCache == nil {
+			apierror.Respond(c, apierror.CodeLLMConfigUnavailable, nil)
+			return
+		}
+		configCtx, cancel := context.WithTimeout(ctx, app.Config.LLMConfigTimeout)
+		clientConfig, err := app.ClientConfigCache.GetClientConfig(configCtx, user.ClientID)
+		cancel()
+		if err != nil {
+			apierror.Respond(c, websocket.LLMConfigErrorCode(err), nil)
+			return
+		}
+		if err := clientConfig.ValidateModelSettings(settings); err != nil {
+			code, details := chat.SettingsErrorCode(err, settings)
+			apierror.Respond(c, code, details)
+			return
+		}
+	}
+
+	conversation, err := chat.InsertConversation(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, user.ID, req.ProjectID, req.Title, settings)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+
+	if app.WSServer != nil {
+		if events := app.WSServer.GetEventPublisher(); events != nil {
+			events.Publish(webhooks.NewEvent(webhooks.EventConversationCreated, user.ClientID, req.ProjectID, map[string]interface{}{
+				"conversation_id": conversation.ID,
+				"user_id":         user.ID,
+				"title":           conversation.Title,
+			}))
+		}
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   req.ProjectID,
+		ActorUserID: user.ID,
+		EventType:   activity.EventConversationCreated,
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "conversation": conversation})
+}
+
+// restoreConversationHandler undoes a soft delete while the conversation is still within the retention period
+func (app *App) restoreConversationHandler(c *gin.Context) {
+	conversationID := c.Param("id")
+
+	// Get user ID from auth middleware
+	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	err := app.ChatService.RestoreConversation(conversationID, userID, clientID, app.Config.ConversationRetention)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted conversation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"conversation_id": conversationID,
+	})
+}
+
+type pinConversationRequest struct {
+	Pinned  *bool  `json:"pinned"`
+	Version *int64 `json:"version"` // Used when no If-Match header is sent
+}
+
+// pinConversationHandler pins a conversation to the top of the caller's list, or
+// unpins it with {"pinned": false}; an empty body pins. The version the change
+// is based on comes in If-Match or the body.
+func (app *App) pinConversationHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req pinConversationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	pinned := req.Pinned == nil || *req.Pinned
+	version, ok := requestVersion(c, req.Version)
+	if !ok {
+		return
+	}
+
+	conversation, err := chat.SetConversationPinned(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		userID, clientID, c.Param("id"), pinned, version)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	var conflict *chat.VersionConflictError
+	if errors.As(err, &conflict) {
+		respondVersionConflict(c, conflict.Current, conflict.Current.Version)
+		return
+	}
+	if errors.Is(err, chat.ErrPinLimitReached) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "limit": chat.MaxPinnedConversations})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin conversation"})
+		return
+	}
+
+	if app.WSServer != nil {
+		app.WSServer.BroadcastConversationUpdated(conversation)
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   conversation.ProjectID,
+		ActorUserID: userID,
+		EventType:   activity.PinEventType(conversation.Pinned),
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title},
+	})
+	setETag(c, conversation.Version)
+	c.JSON(http.StatusOK, gin.H{"success": true, "conversation": conversation})
+}
+
+type updateConversationRequest struct {
+	Language      *string `json:"language"`
+	DraftsEnabled *bool   `json:"drafts_enabled"` // Project editors only
+	Version       *int64  `json:"version"`        // Used when no If-Match header is sent
+}
+
+// updateConversationHandler changes a conversation's settings for any of its
+// participants. {"language": "id"} pins the language replies are given in and
+// {"language": ""} unpins it, so it is detected again from the next message.
+// {"drafts_enabled": true} saves replies as drafts until an editor approves
+// them. The version the change is based on comes in If-Match or the body.
+func (app *App) updateConversationHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
+	if userID == "" {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req updateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if req.Language == nil && req.DraftsEnabled == nil {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "language"})
+		return
+	}
+	version, ok := requestVersion(c, req.Version)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	conn := &tools.ZlayDBAdapter{DB: app.ZDB}
+	var conversation *chat.Conversation
+	var err error
+	if req.DraftsEnabled != nil {
+		conversation, err = chat.SetConversationDraftsEnabled(ctx, conn, userID, clientID, c.Param("id"), *req.DraftsEnabled, version)
+		if err == nil {
+			// The second change is based on the version the first produced
+			version = conversation.Version
+		}
+	}
+	if err == nil && req.Language != nil {
+		conversation, err = chat.SetConversationLanguage(ctx, conn, userID, clientID, c.Param("id"), *req.Language, version)
+	}
+	if errors.Is(err, chat.ErrUnsupportedLanguage) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "language"})
+		return
+	}
+	if errors.Is(err, chat.ErrEditorRequired) {
+		apierror.Respond(c, apierror.CodeForbidden, nil)
+		return
+	}
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	}
+	var conflict *chat.VersionConflictError
+	if errors.As(err, &conflict) {
+		respondVersionConflict(c, conflict.Current, conflict.Current.Version)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+
+	if app.WSServer != nil {
+		app.WSServer.BroadcastConversationUpdated(conversation)
+	}
+	setETag(c, conversation.Version)
+	c.JSON(http.StatusOK, gin.H{"success": true, "conversation": conversation})
+}
+
+type addParticipantRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// addParticipantHandler lets the owner of a conversation add another user of
+// their client, who can then read it and post to it
+func (app *App) addParticipantHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req addParticipantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if req.UserID == "" {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "user_id"})
+		return
+	}
+
+	participant, err := chat.AddParticipant(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"), req.UserID)
+	if err != nil {
+		apierror.Respond(c, chat.ParticipantErrorCode(err), nil)
+		return
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   app.conversationProjectID(c.Request.Context(), participant.ConversationID),
+		ActorUserID: user.ID,
+		EventType:   activity.EventParticipantAdded,
+		EntityType:  activity.EntityConversation,
+		EntityID:    participant.ConversationID,
+		Payload:     map[string]interface{}{"user_id": participant.UserID, "username": participant.Username},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "participant": participant})
+}
+
+// getParticipantsHandler lists the participants of a conversation the caller takes part in
+func (app *App) getParticipantsHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	participants, err := chat.ListParticipants(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, chat.ParticipantErrorCode(err), nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "participants": participants})
+}
+
+// adminDeleteConversationHandler deletes any user's conversation.
+// With ?purge=true the conversation and its messages are removed permanently.
+func (app *App) adminDeleteConversationHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	conversationID := c.Param("id")
+	deleted := activity.Event{
+		ProjectID:   app.conversationProjectID(ctx, conversationID),
+		ActorUserID: c.GetString("user_id"),
+		EventType:   activity.EventConversationDeleted,
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversationID,
+	}
+
+	if c.Query("purge") == "true" {
+		err := chat.PurgeConversation(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, conversationID)
+		if errors.Is(err, chat.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge conversation"})
+			return
+		}
+		app.HistoryCache.Invalidate(conversationID)
+		if app.ToolRegistry != nil {
+			app.ToolRegistry.CancelConversationTools(conversationID)
+		}
+		deleted.Payload = map[string]interface{}{"purged": true}
+		app.recordActivity(deleted)
+		c.JSON(http.StatusOK, gin.H{"success": true, "purged": true})
+		return
+	}
+
+	result, err := app.ZDB.Execute(ctx,
+		"UPDATE conversations SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL",
+		time.Now(), conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	app.HistoryCache.Invalidate(conversationID)
+	if app.ToolRegistry != nil {
+		app.ToolRegistry.CancelConversationTools(conversationID)
+	}
+	app.recordActivity(deleted)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "purged": false})
+}
+
+type forkConversationRequest struct {
+	AtMessageID string `json:"at_message_id"`
+}
+
+// forkConversationHandler copies a conversation the caller takes part in, up to
+// and including at_message_id, into a new conversation the caller owns
+func (app *App) forkConversationHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req forkConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if req.AtMessageID == "" {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "at_message_id"})
+		return
+	}
+
+	conversation, err := chat.ForkConversation(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"), req.AtMessageID, app.Config.MaxForkMessages)
+	if err != nil {
+		code, details := chat.ForkErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+
+	if app.WSServer != nil {
+		if events := app.WSServer.GetEventPublisher(); events != nil {
+			events.Publish(webhooks.NewEvent(webhooks.EventConversationCreated, user.ClientID, conversation.ProjectID, map[string]interface{}{
+				"conversation_id":             conversation.ID,
+				"user_id":                     user.ID,
+				"title":                       conversation.Title,
+				"forked_from_conversation_id": c.Param("id"),
+			}))
+		}
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   conversation.ProjectID,
+		ActorUserID: user.ID,
+		EventType:   activity.EventConversationCreated,
+		EntityType:  activity.EntityConversation,
+		EntityID:    conversation.ID,
+		Payload:     map[string]interface{}{"title": conversation.Title, "forked_from_conversation_id": c.Param("id")},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "conversation": conversation})
+}
+
+// getForksHandler lists the forks of a conversation that the caller takes part in
+func (app *App) getForksHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	forks, err := chat.ListForks(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"))
+	if err != nil {
+		code, details := chat.ForkErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "forks": forks})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db"
+)
+
+func TestConversationsListCarriesSummary(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	router := newTenancyTestRouter(app)
+	router.GET("/api/conversations", app.authMiddleware(), app.getConversationsHandler)
+
+	// conversation-a already holds an assistant "Hi"; add a later long question,
+	// a tool result and an assistant turn with only tool calls
+	now := time.Now().UTC()
+	long := strings.Repeat("x", 200)
+	for i, msg := range []struct{ role, content string }{
+		{"user", long},
+		{"tool", `{"rows": 3}`},
+		{"assistant", ""},
+	} {
+		if _, err := app.ZDB.Execute(context.Background(),
+			"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, 'conversation-a', $2, $3, $4)",
+			"summary-"+msg.role, msg.role, msg.content, now.Add(time.Duration(i+1)*time.Second)); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+	if _, err := app.ZDB.Execute(context.Background(), "UPDATE conversations SET status = 'interrupted' WHERE id = 'conversation-a'"); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/conversations?project_id=project-a", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Conversations []Conversation `json:"conversations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(response.Conversations) != 1 {
+		t.Fatalf("Expected one conversation, got %+v", response.Conversations)
+	}
+	conv := response.Conversations[0]
+	if conv.Status != "interrupted" || conv.MessageCount != 3 || conv.LastMessagePreview != long[:120] {
+		t.Errorf("Unexpected summary: status %q, %d messages, preview %q", conv.Status, conv.MessageCount, conv.LastMessagePreview)
+	}
+}
+
+func TestForkConversationEndpoints(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	router := newTenancyTestRouter(app)
+	router.POST("/api/conversations/:id/fork", app.authMiddleware(), app.forkConversationHandler)
+	router.GET("/api/conversations/:id/forks", app.authMiddleware(), app.getForksHandler)
+
+	// Another client's user cannot fork or list forks
+	if w := tenancyRequest(router, "token-b", "POST", "/api/conversations/conversation-a/fork", `{"at_message_id":"message-a"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 forking another client's conversation, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, "token-b", "GET", "/api/conversations/conversation-a/forks", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 listing another client's forks, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/fork", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without at_message_id, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/fork", `{"at_message_id":"message-b"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a message of another conversation, got %d", w.Code)
+	}
+
+	app.Config.MaxForkMessages = 1
+	if _, err := app.ZDB.Execute(context.Background(),
+		"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('message-a2', 'conversation-a', 'user', 'More', $1)",
+		time.Now().UTC().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to insert message: %v", err)
+	}
+	w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/fork", `{"at_message_id":"message-a2"}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "FORK_TOO_LARGE") {
+		t.Errorf("Expected 409 FORK_TOO_LARGE over the limit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/fork", `{"at_message_id":"message-a"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Conversation struct {
+			ID                       string `json:"id"`
+			ForkedFromConversationID string `json:"forked_from_conversation_id"`
+			MessageCount             int    `json:"message_count"`
+		} `json:"conversation"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if created.Conversation.ForkedFromConversationID != "conversation-a" || created.Conversation.MessageCount != 1 {
+		t.Errorf("Unexpected fork %+v", created.Conversation)
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/conversations/conversation-a/forks", "")
+	var listed struct {
+		Forks []struct {
+			ID string `json:"id"`
+		} `json:"forks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed.Forks) != 1 || listed.Forks[0].ID != created.Conversation.ID {
+		t.Errorf("Expected the fork to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpdateConversationLanguage(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	router := newTenancyTestRouter(app)
+	router.PATCH("/api/conversations/:id", app.authMiddleware(), app.updateConversationHandler)
+	router.GET("/api/conversations", app.authMiddleware(), app.getConversationsHandler)
+
+	for _, tc := range []struct {
+		token, body string
+		want        int
+	}{
+		{"token-b", `{"language":"id","version":1}`, http.StatusNotFound},
+		{"token-a", `{}`, http.StatusBadRequest},
+		{"token-a", `{"language":"fr","version":1}`, http.StatusBadRequest},
+	} {
+		if w := tenancyRequest(router, tc.token, "PATCH", "/api/conversations/conversation-a", tc.body); w.Code != tc.want {
+			t.Errorf("Expected %d for %s with %s, got %d: %s", tc.want, tc.token, tc.body, w.Code, w.Body.String())
+		}
+	}
+
+	w := tenancyRequest(router, "token-a", "PATCH", "/api/conversations/conversation-a", `{"language":"id","version":1}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"language":"id"`) {
+		t.Fatalf("Expected the updated conversation, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/conversations?project_id=project-a", "")
+	var response struct {
+		Conversations []Conversation `json:"conversations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Conversations) != 1 || response.Conversations[0].Language != "id" {
+		t.Errorf("Expected the list to carry the language, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConversationReadsUseTheReadReplica(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	router := newTenancyTestRouter(app)
+	router.GET("/api/conversations", app.authMiddleware(), app.getConversationsHandler)
+
+	// The replica is a second connection to the same file
+	replica, err := db.ConnectApp(db.DatabaseTypeSQLite, app.ZDB.GetConfig().ConnectionString)
+	if err != nil {
+		t.Fatalf("Failed to open replica: %v", err)
+	}
+	replica.SetQueryGuard(db.QueryGuardConfig{Logf: func(string, ...interface{}) {}})
+	app.ZDB.SetReader(replica)
+
+	for _, path := range []string{"/api/conversations?project_id=project-a", "/api/conversations/conversation-a/messages"} {
+		before := app.ZDB.ReaderStats().Queries
+		if w := tenancyRequest(router, "token-a", "GET", path, ""); w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if app.ZDB.ReaderStats().Queries == before {
+			t.Errorf("GET %s: expected the replica to serve the reads", path)
+		}
+	}
+
+	// Writes stay on the primary
+	before := app.ZDB.ReaderStats().Queries
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/conversations/conversation-a/pin", `{"pinned":true,"version":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Pin failed: %d %s", w.Code, w.Body.String())
+	}
+	if app.ZDB.ReaderStats().Queries != before {
+		t.Error("Expected the pin to leave the replica alone")
+	}
+
+	// A replica that cannot be reached falls back to the primary
+	replica.GetDB().Close()
+	w := tenancyRequest(router, "token-a", "GET", "/api/conversations?project_id=project-a", "")
+	var body struct {
+		Conversations []Conversation `json:"conversations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK || len(body.Conversations) != 1 || !body.Conversations[0].Pinned {
+		t.Fatalf("Expected the primary to list the pinned conversation, got %d: %s", w.Code, w.Body.String())
+	}
+	if stats := app.ZDB.ReaderStats(); stats.Fallbacks != 1 {
+		t.Errorf("Expected one fallback, got %+v", stats)
+	}
+}
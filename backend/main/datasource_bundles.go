@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tenantdata"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	// datasourceBundleVersion is the bundle format written by exports and read by imports
+	datasourceBundleVersion = 1
+	// maxBundledDatasources bounds the datasources one import may bring in
+	maxBundledDatasources = 200
+	// secretRefPrefix starts the environment variables a bundle's secret
+	// placeholders name; no other variable can be read through an import
+	secretRefPrefix = "DATASOURCE_SECRET_"
+)
+
+// Strategies for bundled datasources whose name is taken in the project
+const (
+	ImportStrategyFail      = "fail"      // Reject the import
+	ImportStrategySkip      = "skip"      // Keep the existing datasource
+	ImportStrategyOverwrite = "overwrite" // Replace its type, config and query policies
+)
+
+// Actions reported for each bundled datasource
+const (
+	ImportActionCreate   = "create"
+	ImportActionUpdate   = "update"
+	ImportActionSkip     = "skip"
+	ImportActionConflict = "conflict"
+	ImportActionInvalid  = "invalid"
+)
+
+// Audit actions of datasource bundles
+const (
+	AuditActionDatasourceExport = "datasource.export"
+	AuditActionDatasourceImport = "datasource.import"
+)
+
+// secretRefPattern matches a ${NAME} placeholder in a bundled config
+var secretRefPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// DatasourceBundle is a portable copy of a project's datasources
+type DatasourceBundle struct {
+	Version         int                 `json:"version"`
+	ExportedAt      string              `json:"exported_at,omitempty"`
+	SourceProjectID string              `json:"source_project_id,omitempty"`
+	SecretsIncluded bool                `json:"secrets_included"`
+	Datasources     []BundledDatasource `json:"datasources"`
+}
+
+// BundledDatasource is one datasource of a bundle. Without secrets, secret
+// config values are ${NAME} placeholders for the environment variables listed
+// in SecretRefs.
+type BundledDatasource struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	Config        json.RawMessage `json:"config"`
+	QueryPolicies json.RawMessage `json:"query_policies,omitempty"`
+	SecretRefs    []string        `json:"secret_refs,omitempty"`
+}
+
+// DatasourceImportResult is what an import did, or would do, with one bundled datasource
+type DatasourceImportResult struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Action       string   `json:"action"`
+	DatasourceID string   `json:"datasource_id,omitempty"` // The datasource updated, skipped or conflicting, or the one created
+	Errors       []string `json:"errors,omitempty"`        // Why an invalid datasource cannot be imported
+
+	config        string // Resolved config to save
+	queryPolicies string // Normalized query policies to save; "" keeps the existing ones
+}
+
+// DatasourceImportResponse reports an import: what was done, or with dry_run
+// what would be
+type DatasourceImportResponse struct {
+	DryRun    bool                     `json:"dry_run"`
+	Strategy  string                   `json:"strategy"`
+	Results   []DatasourceImportResult `json:"results"`
+	Created   int                      `json:"created"`
+	Updated   int                      `json:"updated"`
+	Skipped   int                      `json:"skipped"`
+	Conflicts int                      `json:"conflicts"`
+	Invalid   int                      `json:"invalid"`
+}
+
+// exportDatasourcesHandler returns the project's active datasources as a
+// bundle. Secrets are replaced by placeholders unless an owner passes
+// include_secrets=true.
+func (app *App) exportDatasourcesHandler(c *gin.Context) {
+	includeSecrets := c.Query("include_secrets") == "true"
+	minimum := tools.RoleEditor
+	if includeSecrets {
+		minimum = tools.RoleOwner
+	}
+	user, projectID, ok := app.authorizeProject(c, minimum)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	resultSet, err := app.ZDB.Query(ctx,
+		"SELECT name, type, config, query_policies FROM datasources WHERE project_id = $1 AND is_active = true ORDER BY name",
+		projectID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	bundle := DatasourceBundle{
+		Version:         datasourceBundleVersion,
+		ExportedAt:      time.Now().UTC().Format(time.RFC3339),
+		SourceProjectID: projectID,
+		SecretsIncluded: includeSecrets,
+		Datasources:     []BundledDatasource{},
+	}
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 4 {
+			continue
+		}
+		var datasource BundledDatasource
+		datasource.Name, _ = row.Values[0].AsString()
+		datasource.Type, _ = row.Values[1].AsString()
+		config, _ := row.Values[2].AsBytes()
+		if policies, ok := row.Values[3].AsBytes(); ok && len(policies) > 0 {
+			datasource.QueryPolicies = policies
+		}
+
+		if includeSecrets || len(config) == 0 {
+			datasource.Config = config
+		} else {
+			var decoded interface{}
+			if err := json.Unmarshal(config, &decoded); err != nil {
+				// A config that cannot be inspected could hold anything
+				apierror.Respond(c, apierror.CodeInternal, nil)
+				return
+			}
+			decoded = placeholdSecrets(decoded, secretRefPrefix+envName(datasource.Name), &datasource.SecretRefs)
+			if datasource.Config, err = json.Marshal(decoded); err != nil {
+				apierror.Respond(c, apierror.CodeInternal, nil)
+				return
+			}
+		}
+		bundle.Datasources = append(bundle.Datasources, datasource)
+	}
+
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    user.ID,
+		ClientID:   user.ClientID,
+		IP:         app.clientIP(c),
+		Action:     AuditActionDatasourceExport,
+		TargetType: "project",
+		TargetID:   projectID,
+		Details:    map[string]interface{}{"datasources": len(bundle.Datasources), "include_secrets": includeSecrets},
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="datasources-%s.json"`, projectID))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// importDatasourcesHandler brings a bundle's datasources into the project.
+// Every datasource is checked, including a connection test, before anything is
+// saved; with conflicts or invalid datasources nothing is. dry_run=true only
+// reports what would be done.
+func (app *App) importDatasourcesHandler(c *gin.Context) {
+	user, projectID, ok := app.authorizeProject(c, tools.RoleOwner)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	dryRun := c.Query("dry_run") == "true"
+	strategy := c.DefaultQuery("strategy", ImportStrategyFail)
+	if strategy != ImportStrategyFail && strategy != ImportStrategySkip && strategy != ImportStrategyOverwrite {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "strategy"})
+		return
+	}
+
+	var bundle DatasourceBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if bundle.Version != datasourceBundleVersion {
+		apierror.Respond(c, apierror.CodeDatasourceBundleInvalid, map[string]interface{}{
+			"reason": fmt.Sprintf("unsupported version %d", bundle.Version)})
+		return
+	}
+	if len(bundle.Datasources) > maxBundledDatasources {
+		apierror.Respond(c, apierror.CodeDatasourceBundleInvalid, map[string]interface{}{
+			"reason": fmt.Sprintf("more than %d datasources", maxBundledDatasources)})
+		return
+	}
+
+	existing, err := app.ZDB.Query(ctx,
+		"SELECT id, name FROM datasources WHERE project_id = $1 AND is_active = true", projectID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	existingIDs := make(map[string]string, len(existing.Rows))
+	for _, row := range existing.Rows {
+		if len(row.Values) < 2 {
+			continue
+		}
+		id, _ := row.Values[0].AsString()
+		name, _ := row.Values[1].AsString()
+		existingIDs[name] = id
+	}
+
+	response := DatasourceImportResponse{DryRun: dryRun, Strategy: strategy, Results: make([]DatasourceImportResult, 0, len(bundle.Datasources))}
+	seen := make(map[string]bool, len(bundle.Datasources))
+	for _, datasource := range bundle.Datasources {
+		result := planDatasourceImport(datasource, seen, existingIDs, strategy)
+		seen[datasource.Name] = true
+		switch result.Action {
+		case ImportActionCreate:
+			response.Created++
+		case ImportActionUpdate:
+			response.Updated++
+		case ImportActionSkip:
+			response.Skipped++
+		case ImportActionConflict:
+			response.Conflicts++
+		case ImportActionInvalid:
+			response.Invalid++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if response.Conflicts > 0 || response.Invalid > 0 {
+		apierror.Respond(c, apierror.CodeDatasourceImportRejected, map[string]interface{}{
+			"conflicts": response.Conflicts,
+			"invalid":   response.Invalid,
+			"results":   response.Results,
+		})
+		return
+	}
+
+	if err := app.applyDatasourceImport(c, projectID, response.Results); err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+
+	for _, result := range response.Results {
+		if result.Action != ImportActionCreate && result.Action != ImportActionUpdate {
+			continue
+		}
+		app.recordAudit(ctx, AuditEntry{
+			ActorID:    user.ID,
+			ClientID:   user.ClientID,
+			IP:         app.clientIP(c),
+			Action:     AuditActionDatasourceImport,
+			TargetType: "datasource",
+			TargetID:   result.DatasourceID,
+			Details:    map[string]interface{}{"project_id": projectID, "name": result.Name, "action": result.Action},
+		})
+		eventType := activity.EventDatasourceCreated
+		if result.Action == ImportActionUpdate {
+			eventType = activity.EventDatasourceUpdated
+		}
+		app.recordActivity(activity.Event{
+			ProjectID:   projectID,
+			ActorUserID: user.ID,
+			EventType:   eventType,
+			EntityType:  activity.EntityDatasource,
+			EntityID:    result.DatasourceID,
+			Payload:     map[string]interface{}{"name": result.Name, "type": result.Type, "imported": true},
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// planDatasourceImport validates a bundled datasource, resolving its secret
+// placeholders and testing its connection, and decides what to do with it.
+// seen holds the names earlier in the bundle and existingIDs the project's
+// datasources by name.
+func planDatasourceImport(datasource BundledDatasource, seen map[string]bool, existingIDs map[string]string, strategy string) DatasourceImportResult {
+	result := DatasourceImportResult{Name: datasource.Name, Type: datasource.Type}
+	invalid := func(format string, args ...interface{}) {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	if strings.TrimSpace(datasource.Name) == "" {
+		invalid("name is required")
+	} else if seen[datasource.Name] {
+		invalid("name appears more than once in the bundle")
+	}
+	if datasource.Type == "" {
+		invalid("type is required")
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(datasource.Config, &config); err != nil || config == nil {
+		invalid("config must be a JSON object")
+	} else {
+		var unresolved []string
+		resolved, err := json.Marshal(resolveSecretRefs(config, &unresolved))
+		for _, problem := range unresolved {
+			invalid("%s", problem)
+		}
+		if err != nil {
+			invalid("config cannot be encoded: %v", err)
+		}
+		result.config = string(resolved)
+	}
+
+	if len(datasource.QueryPolicies) > 0 && string(datasource.QueryPolicies) != "null" {
+		policy, err := tools.ParseQueryPolicy(datasource.QueryPolicies)
+		if err != nil {
+			invalid("query_policies: %v", err)
+		} else {
+			rules := policy.Rules
+			if rules == nil {
+				rules = []tools.QueryPolicyRule{}
+			}
+			encoded, _ := json.Marshal(rules)
+			result.queryPolicies = string(encoded)
+		}
+	}
+
+	// Only well-formed configs are worth connecting with
+	if len(result.Errors) == 0 {
+		if err := tools.TestDatasourceConfig(datasource.Type, []byte(result.config)); err != nil {
+			invalid("connection test failed: %v", err)
+		}
+	}
+
+	id, exists := existingIDs[datasource.Name]
+	switch {
+	case len(result.Errors) > 0:
+		result.Action = ImportActionInvalid
+	case !exists:
+		result.Action = ImportActionCreate
+	case strategy == ImportStrategyOverwrite:
+		result.Action, result.DatasourceID = ImportActionUpdate, id
+	case strategy == ImportStrategySkip:
+		result.Action, result.DatasourceID = ImportActionSkip, id
+	default:
+		result.Action, result.DatasourceID = ImportActionConflict, id
+	}
+	return result
+}
+
+// applyDatasourceImport saves the planned creates and updates in one
+// transaction, filling in the IDs of created datasources
+func (app *App) applyDatasourceImport(c *gin.Context, projectID string, results []DatasourceImportResult) error {
+	ctx := c.Request.Context()
+	tx, err := (&tools.ZlayDBAdapter{DB: app.ZDB}).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i := range results {
+		result := &results[i]
+		var queryPolicies interface{}
+		if result.queryPolicies != "" {
+			queryPolicies = result.queryPolicies
+		}
+
+		switch result.Action {
+		case ImportActionCreate:
+			result.DatasourceID = uuid.New().String()
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO datasources (id, project_id, name, type, config, query_policies, is_active, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, COALESCE($6, '[]'), true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+				result.DatasourceID, projectID, result.Name, result.Type, result.config, queryPolicies); err != nil {
+				return fmt.Errorf("failed to create datasource %s: %w", result.Name, err)
+			}
+		case ImportActionUpdate:
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE datasources SET type = $1, config = $2, query_policies = COALESCE($3, query_policies), updated_at = CURRENT_TIMESTAMP,
+					version = version + 1
+				WHERE id = $4`,
+				result.Type, result.config, queryPolicies, result.DatasourceID); err != nil {
+				return fmt.Errorf("failed to update datasource %s: %w", result.Name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+	return nil
+}
+
+// placeholdSecrets replaces the secret values of a decoded config with ${NAME}
+// placeholders, NAME being prefix and the value's key path. Connection URLs
+// with a password are replaced as a whole. The names are added to refs.
+func placeholdSecrets(value interface{}, prefix string, refs *[]string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		// Sorted so the refs come out in a stable order
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := prefix + "_" + envName(key)
+			if nested := typed[key]; tenantdata.IsSecretKey(key) && nested != nil && nested != "" {
+				typed[key] = secretPlaceholder(name, refs)
+			} else {
+				typed[key] = placeholdSecrets(nested, name, refs)
+			}
+		}
+		return typed
+	case []interface{}:
+		for i, nested := range typed {
+			typed[i] = placeholdSecrets(nested, fmt.Sprintf("%s_%d", prefix, i), refs)
+		}
+		return typed
+	case string:
+		if parsed, err := url.Parse(typed); err == nil && parsed.User != nil {
+			if _, hasPassword := parsed.User.Password(); hasPassword {
+				return secretPlaceholder(prefix, refs)
+			}
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+// secretPlaceholder returns the placeholder for the environment variable name,
+// adding it to refs
+func secretPlaceholder(name string, refs *[]string) string {
+	*refs = append(*refs, name)
+	return "${" + name + "}"
+}
+
+// resolveSecretRefs replaces the ${NAME} placeholders in a decoded config with
+// the values of their environment variables. Placeholders naming variables
+// outside secretRefPrefix, or unset ones, are left in place and reported in
+// problems.
+func resolveSecretRefs(value interface{}, problems *[]string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range typed {
+			typed[key] = resolveSecretRefs(nested, problems)
+		}
+		return typed
+	case []interface{}:
+		for i, nested := range typed {
+			typed[i] = resolveSecretRefs(nested, problems)
+		}
+		return typed
+	case string:
+		return secretRefPattern.ReplaceAllStringFunc(typed, func(placeholder string) string {
+			name := secretRefPattern.FindStringSubmatch(placeholder)[1]
+			if !strings.HasPrefix(name, secretRefPrefix) {
+				*problems = append(*problems, fmt.Sprintf("secret reference %s must start with %s", name, secretRefPrefix))
+				return placeholder
+			}
+			resolved, ok := os.LookupEnv(name)
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("secret reference %s is not set", name))
+				return placeholder
+			}
+			return resolved
+		})
+	default:
+		return value
+	}
+}
+
+// envName turns a name into an environment variable fragment: upper case
+// letters and digits, everything else collapsed into underscores
+func envName(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/tools"
+)
+
+// newDatasourceBundleTestRouter serves the bundle endpoints for project-a,
+// which starts with two SQLite datasources instead of the seeded one
+func newDatasourceBundleTestRouter(t *testing.T) (*App, *gin.Engine) {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	if _, err := app.ZDB.Execute(ctx, "DELETE FROM datasources WHERE project_id = 'project-a'"); err != nil {
+		t.Fatalf("Failed to clear datasources: %v", err)
+	}
+	seed := []struct{ id, name, config, policies string }{
+		{"ds-orders", "Orders", `{"file_path":"` + filepath.Join(dir, "orders.db") + `","password":"hunter2"}`, `[{"effect":"deny","pattern":"payroll.*","type":"table"}]`},
+		{"ds-events", "Events", `{"file_path":"` + filepath.Join(dir, "events.db") + `"}`, "[]"},
+	}
+	for _, ds := range seed {
+		if _, err := app.ZDB.Execute(ctx,
+			"INSERT INTO datasources (id, project_id, name, type, config, is_active, query_policies, created_at) VALUES ($1, 'project-a', $2, 'sqlite', $3, true, $4, CURRENT_TIMESTAMP)",
+			ds.id, ds.name, ds.config, ds.policies); err != nil {
+			t.Fatalf("Failed to seed datasource: %v", err)
+		}
+	}
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects/:id/datasources/export", app.authMiddleware(), app.exportDatasourcesHandler)
+	router.POST("/api/projects/:id/datasources/import", app.authMiddleware(), app.importDatasourcesHandler)
+	return app, router
+}
+
+func exportBundle(t *testing.T, router *gin.Engine, query string) DatasourceBundle {
+	t.Helper()
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/datasources/export"+query, "")
+	var bundle DatasourceBundle
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &bundle) != nil {
+		t.Fatalf("Failed to export: %d %s", w.Code, w.Body.String())
+	}
+	return bundle
+}
+
+func importBundle(t *testing.T, router *gin.Engine, query string, bundle DatasourceBundle) (int, DatasourceImportResponse, string) {
+	t.Helper()
+
+	body, _ := json.Marshal(bundle)
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/datasources/import"+query, string(body))
+	var response DatasourceImportResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response, w.Body.String()
+}
+
+// storedConfigs returns the configs of project-a's active datasources by name
+func storedConfigs(t *testing.T, app *App) map[string]map[string]interface{} {
+	t.Helper()
+
+	resultSet, err := app.ZDB.Query(context.Background(), "SELECT name, config FROM datasources WHERE project_id = 'project-a' AND is_active = true")
+	if err != nil {
+		t.Fatalf("Failed to load datasources: %v", err)
+	}
+	configs := make(map[string]map[string]interface{})
+	for _, row := range resultSet.Rows {
+		name, _ := row.Values[0].AsString()
+		raw, _ := row.Values[1].AsBytes()
+		var config map[string]interface{}
+		json.Unmarshal(raw, &config)
+		configs[name] = config
+	}
+	return configs
+}
+
+func TestDatasourceBundleRoundTrip(t *testing.T) {
+	app, router := newDatasourceBundleTestRouter(t)
+	ctx := context.Background()
+	conn := &tools.ZlayDBAdapter{DB: app.ZDB}
+	original := storedConfigs(t, app)
+
+	// Secrets are left out unless asked for
+	bundle := exportBundle(t, router, "")
+	if bundle.SecretsIncluded || len(bundle.Datasources) != 2 || bundle.Datasources[1].Name != "Orders" {
+		t.Fatalf("Unexpected bundle %+v", bundle)
+	}
+	orders := bundle.Datasources[1]
+	if strings.Contains(string(orders.Config), "hunter2") || !strings.Contains(string(orders.Config), "${DATASOURCE_SECRET_ORDERS_PASSWORD}") {
+		t.Errorf("Expected a placeholder for the password, got %s", orders.Config)
+	}
+	if len(orders.SecretRefs) != 1 || orders.SecretRefs[0] != "DATASOURCE_SECRET_ORDERS_PASSWORD" {
+		t.Errorf("Expected the secret's variable listed, got %v", orders.SecretRefs)
+	}
+
+	if _, err := app.ZDB.Execute(ctx, "DELETE FROM datasources WHERE project_id = 'project-a'"); err != nil {
+		t.Fatalf("Failed to wipe datasources: %v", err)
+	}
+
+	// Placeholders must resolve before anything is saved
+	if code, response, body := importBundle(t, router, "", bundle); code != http.StatusConflict || !strings.Contains(body, "DATASOURCE_IMPORT_REJECTED") {
+		t.Fatalf("Expected the import rejected with the secret unset, got %d: %s", code, body)
+	} else if len(storedConfigs(t, app)) != 0 {
+		t.Fatalf("Expected nothing saved, got %+v", response)
+	}
+
+	t.Setenv("DATASOURCE_SECRET_ORDERS_PASSWORD", "hunter2")
+	code, response, body := importBundle(t, router, "", bundle)
+	if code != http.StatusOK || response.Created != 2 || response.Results[1].DatasourceID == "" {
+		t.Fatalf("Expected both datasources created, got %d: %s", code, body)
+	}
+	if restored := storedConfigs(t, app); restored["Orders"]["password"] != "hunter2" || restored["Events"]["file_path"] != original["Events"]["file_path"] {
+		t.Errorf("Expected the configs restored, got %+v", restored)
+	}
+	var policies string
+	if err := conn.QueryRow(ctx, "SELECT query_policies FROM datasources WHERE name = 'Orders'").Scan(&policies); err != nil || !strings.Contains(policies, "payroll.*") {
+		t.Errorf("Expected the query policies restored, got %q (%v)", policies, err)
+	}
+
+	// An owner's bundle with secrets imports as is
+	withSecrets := exportBundle(t, router, "?include_secrets=true")
+	if !withSecrets.SecretsIncluded || !strings.Contains(string(withSecrets.Datasources[1].Config), "hunter2") {
+		t.Errorf("Expected the secrets included, got %+v", withSecrets)
+	}
+
+	var audited int
+	if err := conn.QueryRow(ctx, "SELECT COUNT(*) FROM audit_log WHERE action = $1", AuditActionDatasourceImport).Scan(&audited); err != nil || audited != 2 {
+		t.Errorf("Expected an audit entry per imported datasource, got %d (%v)", audited, err)
+	}
+	if err := conn.QueryRow(ctx, "SELECT COUNT(*) FROM audit_log WHERE action = $1", AuditActionDatasourceExport).Scan(&audited); err != nil || audited != 2 {
+		t.Errorf("Expected an audit entry per export, got %d (%v)", audited, err)
+	}
+}
+
+func TestDatasourceImportConflicts(t *testing.T) {
+	app, router := newDatasourceBundleTestRouter(t)
+	bundle := exportBundle(t, router, "?include_secrets=true")
+	changed := filepath.Join(t.TempDir(), "moved.db")
+	bundle.Datasources[1].Config = json.RawMessage(`{"file_path":"` + changed + `"}`)
+	bundle.Datasources = append(bundle.Datasources, BundledDatasource{Name: "Archive", Type: "sqlite",
+		Config: json.RawMessage(`{"file_path":"` + filepath.Join(t.TempDir(), "archive.db") + `"}`)})
+
+	// A dry run reports without saving
+	code, response, body := importBundle(t, router, "?dry_run=true", bundle)
+	if code != http.StatusOK || !response.DryRun || response.Conflicts != 2 || response.Created != 1 ||
+		response.Results[0].Action != ImportActionConflict || response.Results[0].DatasourceID != "ds-events" {
+		t.Fatalf("Expected two conflicts and one create, got %d: %s", code, body)
+	}
+	if len(storedConfigs(t, app)) != 2 {
+		t.Fatal("Expected a dry run to save nothing")
+	}
+
+	// The default strategy rejects the whole import
+	if code, _, body := importBundle(t, router, "", bundle); code != http.StatusConflict {
+		t.Fatalf("Expected 409 for conflicts, got %d: %s", code, body)
+	}
+	if _, exists := storedConfigs(t, app)["Archive"]; exists {
+		t.Fatal("Expected nothing saved when the import is rejected")
+	}
+
+	code, response, body = importBundle(t, router, "?strategy=skip", bundle)
+	if code != http.StatusOK || response.Skipped != 2 || response.Created != 1 {
+		t.Fatalf("Expected the existing datasources skipped, got %d: %s", code, body)
+	}
+	if configs := storedConfigs(t, app); configs["Orders"]["file_path"] == changed || configs["Archive"] == nil {
+		t.Errorf("Expected Orders kept and Archive created, got %+v", configs)
+	}
+
+	code, response, body = importBundle(t, router, "?strategy=overwrite", bundle)
+	if code != http.StatusOK || response.Updated != 3 || response.Results[1].DatasourceID != "ds-orders" {
+		t.Fatalf("Expected every datasource updated, got %d: %s", code, body)
+	}
+	if configs := storedConfigs(t, app); len(configs) != 3 || configs["Orders"]["file_path"] != changed {
+		t.Errorf("Expected Orders overwritten in place, got %+v", configs)
+	}
+
+	if code, _, _ := importBundle(t, router, "?strategy=merge", bundle); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown strategy, got %d", code)
+	}
+}
+
+func TestDatasourceImportValidation(t *testing.T) {
+	_, router := newDatasourceBundleTestRouter(t)
+
+	bundle := DatasourceBundle{Version: datasourceBundleVersion, Datasources: []BundledDatasource{
+		{Name: "Broken", Type: "sqlite", Config: json.RawMessage(`{"file_path":"` + filepath.Join(t.TempDir(), "missing", "x.db") + `"}`)},
+		{Name: "Leaky", Type: "sqlite", Config: json.RawMessage(`{"file_path":"${HOME}"}`)},
+		{Name: "Unknown", Type: "mongodb", Config: json.RawMessage(`{}`)},
+		{Name: "", Type: "sqlite", Config: json.RawMessage(`[]`)},
+		{Name: "Policies", Type: "sqlite", Config: json.RawMessage(`{"file_path":"` + filepath.Join(t.TempDir(), "p.db") + `"}`),
+			QueryPolicies: json.RawMessage(`[{"effect":"maybe","pattern":"x"}]`)},
+	}}
+	code, response, body := importBundle(t, router, "?dry_run=true", bundle)
+	if code != http.StatusOK || response.Invalid != 5 {
+		t.Fatalf("Expected every datasource invalid, got %d: %s", code, body)
+	}
+	for _, want := range []struct {
+		index int
+		error string
+	}{
+		{0, "connection test failed"},
+		{1, "must start with DATASOURCE_SECRET_"},
+		{2, "unsupported datasource type"},
+		{3, "name is required"},
+		{4, "query_policies"},
+	} {
+		if errors := strings.Join(response.Results[want.index].Errors, "; "); !strings.Contains(errors, want.error) {
+			t.Errorf("Expected %q for %s, got %q", want.error, response.Results[want.index].Name, errors)
+		}
+	}
+
+	if code, _, body := importBundle(t, router, "", DatasourceBundle{Version: 2}); code != http.StatusBadRequest || !strings.Contains(body, "DATASOURCE_BUNDLE_INVALID") {
+		t.Errorf("Expected 400 for an unknown version, got %d: %s", code, body)
+	}
+	// Another tenant cannot import into the project
+	if w := tenancyRequest(router, "token-b", "POST", "/api/projects/project-a/datasources/import", `{"version":1}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another tenant, got %d", w.Code)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+)
+
+// metadataColumn is a column as the SQL editor completes it
+type metadataColumn struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	PrimaryKey bool   `json:"pk,omitempty"`
+}
+
+// metadataTable is a table with its columns, without indexes or statistics
+type metadataTable struct {
+	Name    string           `json:"name"`
+	View    bool             `json:"view,omitempty"`
+	Columns []metadataColumn `json:"columns"`
+}
+
+// metadataSchema groups tables by schema; tables of databases without
+// schemas are under an empty name
+type metadataSchema struct {
+	Name   string          `json:"name"`
+	Tables []metadataTable `json:"tables"`
+}
+
+// getDatasourceMetadataHandler returns the schemas, tables and columns of a
+// datasource for the SQL editor's autocomplete, trimmed to names, types and
+// primary keys. It is served from the schema cache shared with the inspect
+// tool and carries an ETag of the cache generation, so polling is cheap.
+func (app *App) getDatasourceMetadataHandler(c *gin.Context) {
+	datasourceID := c.Param("id")
+	schema, ok := app.datasourceMetadata(c, datasourceID)
+	if !ok {
+		return
+	}
+
+	bySchema := make(map[string][]metadataTable)
+	for _, table := range schema.Tables {
+		name, _ := table.Properties["schema"].(string)
+		bySchema[name] = append(bySchema[name], trimTableMetadata(table))
+	}
+	schemas := make([]metadataSchema, 0, len(bySchema))
+	for name, tables := range bySchema {
+		sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+		schemas = append(schemas, metadataSchema{Name: name, Tables: tables})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+
+	respondJSONGzip(c, http.StatusOK, gin.H{
+		"datasource_id": datasourceID,
+		"type":          schema.Type,
+		"generation":    strconv.FormatUint(schema.Generation, 10),
+		"schemas":       schemas,
+	})
+}
+
+// getDatasourceTableMetadataHandler returns one table of the metadata, for
+// editors that expand tables as they are opened. The table may be schema-qualified.
+func (app *App) getDatasourceTableMetadataHandler(c *gin.Context) {
+	datasourceID := c.Param("id")
+	schema, ok := app.datasourceMetadata(c, datasourceID)
+	if !ok {
+		return
+	}
+
+	table, found := schema.Table(c.Param("table"))
+	if !found {
+		apierror.Respond(c, apierror.CodeTableNotFound, map[string]interface{}{"table": c.Param("table")})
+		return
+	}
+	schemaName, _ := table.Properties["schema"].(string)
+	respondJSONGzip(c, http.StatusOK, gin.H{
+		"datasource_id": datasourceID,
+		"generation":    strconv.FormatUint(schema.Generation, 10),
+		"schema":        schemaName,
+		"table":         trimTableMetadata(*table),
+	})
+}
+
+// datasourceMetadata checks the current user owns the datasource, the same
+// way GET /api/datasources/:id does, and loads its cached schema. It answers
+// 304 itself when If-None-Match names the current generation.
+func (app *App) datasourceMetadata(c *gin.Context, datasourceID string) (*tools.CachedSchema, bool) {
+	if !app.ownedDatasource(c, datasourceID) {
+		return nil, false
+	}
+	if app.SchemaCache == nil {
+		apierror.Respond(c, apierror.CodeDatasourceUnavailable, nil)
+		return nil, false
+	}
+
+	schema, err := app.SchemaCache.Get(c.Request.Context(), datasourceID)
+	if err != nil {
+		log.Printf("Failed to inspect datasource %s for metadata: %v", datasourceID, err)
+		apierror.Respond(c, apierror.CodeDatasourceUnavailable, nil)
+		return nil, false
+	}
+
+	// The ETag is weak since the body is the same whether or not it is gzip'd
+	etag := `"` + strconv.FormatUint(schema.Generation, 36) + `"`
+	c.Header("ETag", "W/"+etag)
+	c.Header("Cache-Control", "private, no-cache")
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return nil, false
+	}
+	return schema, true
+}
+
+func trimTableMetadata(table tools.TableInfo) metadataTable {
+	trimmed := metadataTable{
+		Name:    table.Name,
+		View:    strings.Contains(strings.ToLower(table.Type), "view"),
+		Columns: make([]metadataColumn, 0, len(table.Columns)),
+	}
+	for _, column := range table.Columns {
+		trimmed.Columns = append(trimmed.Columns, metadataColumn{Name: column.Name, Type: column.Type, PrimaryKey: column.PrimaryKey})
+	}
+	return trimmed
+}
+
+// respondJSONGzip writes a JSON body, gzip'd when the client accepts it;
+// large warehouses make metadata bodies of hundreds of kilobytes
+func respondJSONGzip(c *gin.Context, status int, body interface{}) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeInternal, nil)
+		return
+	}
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Data(status, "application/json; charset=utf-8", encoded)
+		return
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(encoded)
+	if err := writer.Close(); err != nil {
+		c.Data(status, "application/json; charset=utf-8", encoded)
+		return
+	}
+	c.Header("Content-Encoding", "gzip")
+	c.Data(status, "application/json; charset=utf-8", compressed.Bytes())
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip,
+// honouring q=0 as a refusal
+func acceptsGzip(header string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		refused := false
+		if q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				refused = true
+			}
+		}
+		if coding == "gzip" {
+			return !refused
+		}
+		accepted = !refused
+	}
+	return accepted
+}
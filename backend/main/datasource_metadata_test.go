@@ -0,0 +1,257 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/tools"
+)
+
+// fakeSchemaInspector serves a fixed schema and counts how often it is read
+type fakeSchemaInspector struct {
+	mutex  sync.Mutex
+	tables map[string]*tools.TableInfo
+	order  []string
+	reads  int
+	err    error
+}
+
+func (f *fakeSchemaInspector) InspectDatasource(ctx context.Context, dbType string) (*tools.DatasourceInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.reads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	info := &tools.DatasourceInfo{Type: dbType, Properties: map[string]interface{}{}}
+	for _, name := range f.order {
+		info.Tables = append(info.Tables, tools.TableInfo{
+			Name:       name,
+			Type:       f.tables[name].Type,
+			Properties: map[string]interface{}{"schema": f.tables[name].Properties["schema"]},
+		})
+	}
+	return info, nil
+}
+
+func (f *fakeSchemaInspector) InspectTable(ctx context.Context, tableName string, includeStats bool) (*tools.TableInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	table := *f.tables[tableName]
+	table.Properties = map[string]interface{}{}
+	return &table, nil
+}
+
+func newFakeWarehouse() *fakeSchemaInspector {
+	defaultValue := "now()"
+	return &fakeSchemaInspector{
+		order: []string{"orders", "customers", "order_totals"},
+		tables: map[string]*tools.TableInfo{
+			"orders": {
+				Name: "orders", Type: "BASE TABLE", RowCount: 1200, SizeBytes: 65536,
+				Properties: map[string]interface{}{"schema": "sales"},
+				Columns: []tools.ColumnInfo{
+					{Name: "id", Type: "bigint", PrimaryKey: true},
+					{Name: "customer_id", Type: "bigint", Nullable: true, Description: "Buyer"},
+					{Name: "created_at", Type: "timestamp", DefaultValue: &defaultValue},
+				},
+				Indexes: []tools.IndexInfo{{Name: "orders_pkey", Columns: []string{"id"}, Unique: true, Primary: true}},
+			},
+			"customers": {
+				Name: "customers", Type: "BASE TABLE",
+				Properties: map[string]interface{}{"schema": "public"},
+				Columns:    []tools.ColumnInfo{{Name: "id", Type: "uuid", PrimaryKey: true}, {Name: "email", Type: "text"}},
+			},
+			"order_totals": {
+				Name: "order_totals", Type: "VIEW",
+				Properties: map[string]interface{}{"schema": "sales"},
+				Columns:    []tools.ColumnInfo{{Name: "total", Type: "numeric"}},
+			},
+		},
+	}
+}
+
+func newMetadataTestRouter(t *testing.T, inspector *fakeSchemaInspector) (*App, *gin.Engine) {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+	app.SchemaCache = tools.NewSchemaCache(func(ctx context.Context, datasourceID string) (tools.SchemaInspector, string, func(), error) {
+		return inspector, "postgres", nil, nil
+	}, 0)
+	router := newTenancyTestRouter(app)
+	router.GET("/api/datasources/:id/metadata", app.authMiddleware(), app.getDatasourceMetadataHandler)
+	router.GET("/api/datasources/:id/metadata/tables/:table", app.authMiddleware(), app.getDatasourceTableMetadataHandler)
+	return app, router
+}
+
+func metadataRequest(router *gin.Engine, token, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDatasourceMetadataIsTrimmed(t *testing.T) {
+	_, router := newMetadataTestRouter(t, newFakeWarehouse())
+
+	w := metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		DatasourceID string           `json:"datasource_id"`
+		Type         string           `json:"type"`
+		Generation   string           `json:"generation"`
+		Schemas      []metadataSchema `json:"schemas"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	encoded, _ := json.Marshal(resp.Schemas)
+	want := `[{"name":"public","tables":[{"name":"customers","columns":[{"name":"id","type":"uuid","pk":true},{"name":"email","type":"text"}]}]},` +
+		`{"name":"sales","tables":[{"name":"order_totals","view":true,"columns":[{"name":"total","type":"numeric"}]},` +
+		`{"name":"orders","columns":[{"name":"id","type":"bigint","pk":true},{"name":"customer_id","type":"bigint"},{"name":"created_at","type":"timestamp"}]}]}]`
+	if string(encoded) != want {
+		t.Errorf("Expected schemas\n%s\ngot\n%s", want, encoded)
+	}
+	if resp.DatasourceID != "datasource-a" || resp.Type != "postgres" || resp.Generation == "" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	for _, dropped := range []string{"row_count", "size_bytes", "indexes", "nullable", "default_value", "Buyer"} {
+		if strings.Contains(w.Body.String(), dropped) {
+			t.Errorf("Expected %s trimmed: %s", dropped, w.Body.String())
+		}
+	}
+
+	w = metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata/tables/sales.orders", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"schema":"sales"`) ||
+		!strings.Contains(w.Body.String(), `{"name":"id","type":"bigint","pk":true}`) {
+		t.Errorf("Expected the orders table, got %d: %s", w.Code, w.Body.String())
+	}
+	w = metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata/tables/customers", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"customers"`) {
+		t.Errorf("Expected the customers table by its bare name, got %d: %s", w.Code, w.Body.String())
+	}
+	w = metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata/tables/invoices", nil)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "TABLE_NOT_FOUND") {
+		t.Errorf("Expected 404 for an unknown table, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDatasourceMetadataETag(t *testing.T) {
+	inspector := newFakeWarehouse()
+	app, router := newMetadataTestRouter(t, inspector)
+	path := "/api/datasources/datasource-a/metadata"
+
+	w := metadataRequest(router, "token-a", path, nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected 200 with a weak ETag, got %d %q", w.Code, etag)
+	}
+
+	w = metadataRequest(router, "token-a", path, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("Expected 304 for the current generation, got %d: %s", w.Code, w.Body.String())
+	}
+	w = metadataRequest(router, "token-a", path+"/tables/orders", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a table of the current generation, got %d", w.Code)
+	}
+
+	// Rereading an unchanged schema keeps the generation
+	app.SchemaCache.Invalidate("datasource-a")
+	w = metadataRequest(router, "token-a", path, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || inspector.reads != 2 {
+		t.Errorf("Expected 304 after rereading an unchanged schema, got %d after %d reads", w.Code, inspector.reads)
+	}
+
+	inspector.mutex.Lock()
+	inspector.tables["customers"].Columns = append(inspector.tables["customers"].Columns, tools.ColumnInfo{Name: "name", Type: "text"})
+	inspector.mutex.Unlock()
+	app.SchemaCache.Invalidate("datasource-a")
+	w = metadataRequest(router, "token-a", path, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag || !strings.Contains(w.Body.String(), `"name":"name"`) {
+		t.Errorf("Expected the changed schema with a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// Without an invalidation the cache answers without reading the datasource
+	reads := inspector.reads
+	for i := 0; i < 3; i++ {
+		metadataRequest(router, "token-a", path, nil)
+	}
+	if inspector.reads != reads {
+		t.Errorf("Expected cached metadata, got %d more reads", inspector.reads-reads)
+	}
+}
+
+func TestDatasourceMetadataGzip(t *testing.T) {
+	_, router := newMetadataTestRouter(t, newFakeWarehouse())
+	path := "/api/datasources/datasource-a/metadata"
+
+	plain := metadataRequest(router, "token-a", path, nil)
+	w := metadataRequest(router, "token-a", path, map[string]string{"Accept-Encoding": "br, gzip"})
+	if w.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("Expected a gzip'd body, got headers %v", w.Header())
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != plain.Body.String() {
+		t.Errorf("Expected the gzip'd body to match the plain one")
+	}
+
+	w = metadataRequest(router, "token-a", path, map[string]string{"Accept-Encoding": "gzip;q=0, identity"})
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != plain.Body.String() {
+		t.Errorf("Expected gzip;q=0 to be honoured, got %q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestDatasourceMetadataAuthorization(t *testing.T) {
+	inspector := newFakeWarehouse()
+	app, router := newMetadataTestRouter(t, inspector)
+
+	for _, path := range []string{"/api/datasources/datasource-b/metadata", "/api/datasources/datasource-b/metadata/tables/orders"} {
+		if w := metadataRequest(router, "token-a", path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for another client's datasource, got %d", path, w.Code)
+		}
+		if w := metadataRequest(router, "", path, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 without a session, got %d", path, w.Code)
+		}
+	}
+
+	if _, err := app.ZDB.Execute(context.Background(), "UPDATE datasources SET is_active = false WHERE id = 'datasource-a'"); err != nil {
+		t.Fatalf("Failed to deactivate datasource: %v", err)
+	}
+	if w := metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted datasource, got %d", w.Code)
+	}
+	if inspector.reads != 0 {
+		t.Errorf("Expected no inspection for refused requests, got %d", inspector.reads)
+	}
+}
+
+func TestDatasourceMetadataUnreachable(t *testing.T) {
+	inspector := newFakeWarehouse()
+	inspector.err = errors.New("connection refused")
+	_, router := newMetadataTestRouter(t, inspector)
+
+	w := metadataRequest(router, "token-a", "/api/datasources/datasource-a/metadata", nil)
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "DATASOURCE_UNAVAILABLE") {
+		t.Errorf("Expected 502 for an unreachable datasource, got %d: %s", w.Code, w.Body.String())
+	}
+}
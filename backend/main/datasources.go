@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/activity"
+	"zlay-backend/internal/apierror"
 	"github.com/google/uuid"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
 )
 
 type Datasource struct {
@@ -18,6 +24,9 @@ type Datasource struct {
 	Config    json.RawMessage `json:"config"`
 	IsActive  bool            `json:"is_active"`
 	CreatedAt string          `json:"created_at"`
+	Version   int64           `json:"version"` // Sent back in If-Match by updates
+	// Allow/deny rules for the tables the database tools may touch
+	QueryPolicies json.RawMessage `json:"query_policies,omitempty"`
 }
 
 type CreateDatasourceRequest struct {
@@ -32,6 +41,11 @@ type UpdateDatasourceRequest struct {
 	Type     *string          `json:"type"`
 	Config   *json.RawMessage `json:"config"`
 	IsActive *bool            `json:"is_active"`
+	// Minutes between schema snapshots; 0 disables them
+	SchemaSnapshotIntervalMinutes *int `json:"schema_snapshot_interval_minutes"`
+	// Ordered [{effect: allow|deny, pattern, type: table|regex}] rules; replaces the existing ones
+	QueryPolicies *json.RawMessage `json:"query_policies"`
+	Version       *int64           `json:"version"` // Used when no If-Match header is sent
 }
 
 func (app *App) getDatasourcesHandler(c *gin.Context) {
@@ -40,10 +54,9 @@ func (app *App) getDatasourcesHandler(c *gin.Context) {
 	// Get current user
 	user, err := app.getCurrentUser(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
-	userID := user.ID
 
 	// Get project ID from query param or check if user has access to all projects
 	projectID := c.Query("project_id")
@@ -52,123 +65,113 @@ func (app *App) getDatasourcesHandler(c *gin.Context) {
 	var args []interface{}
 
 	if projectID != "" {
-		// Check if user owns the project using ZDB
-		row, err := app.ZDB.QueryRow(ctx,
-			"SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND user_id = $2 AND is_active = true)",
-			projectID, userID)
+		// Check if user owns the project
+		owned, err := app.userOwnsProject(ctx, projectID, user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
 			return
 		}
 
-		exists, ok := row.Values[0].AsBool()
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+		if !owned {
+			apierror.Respond(c, apierror.CodeProjectNotFound, nil)
 			return
 		}
 
-		if !exists {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found or no access"})
-			return
-		}
-
-		query = "SELECT id, project_id, name, type, config, is_active, created_at FROM datasources WHERE project_id = $1 AND is_active = true ORDER BY created_at DESC"
+		query = "SELECT id, project_id, name, type, config, is_active, created_at, version FROM datasources WHERE project_id = $1 AND is_active = true ORDER BY created_at DESC"
 		args = []interface{}{projectID}
 	} else {
 		// Get all datasources for user's projects
-		query = `SELECT d.id, d.project_id, d.name, d.type, d.config, d.is_active, d.created_at 
+		query = `SELECT d.id, d.project_id, d.name, d.type, d.config, d.is_active, d.created_at, d.version
 				 FROM datasources d 
 				 JOIN projects p ON d.project_id = p.id 
-				 WHERE p.user_id = $1 AND d.is_active = true AND p.is_active = true 
+				 JOIN users u ON u.id = p.user_id 
+				 WHERE p.user_id = $1 AND u.client_id = $2 AND d.is_active = true AND p.is_active = true 
 				 ORDER BY d.created_at DESC`
-		args = []interface{}{userID}
+		args = []interface{}{user.ID, user.ClientID}
 	}
 
 	resultSet, err := app.ZDB.Query(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch datasources"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	var datasources []Datasource
 	for _, row := range resultSet.Rows {
-		if len(row.Values) < 7 {
+		if len(row.Values) < 8 {
 			continue
 		}
-
-		var datasource Datasource
-		if id, ok := row.Values[0].AsString(); ok {
-			datasource.ID = id
-		}
-		if projectID, ok := row.Values[1].AsString(); ok {
-			datasource.ProjectID = projectID
-		}
-		if name, ok := row.Values[2].AsString(); ok {
-			datasource.Name = name
-		}
-		if datasourceType, ok := row.Values[3].AsString(); ok {
-			datasource.Type = datasourceType
-		}
-		if config, ok := row.Values[4].AsBytes(); ok {
-			datasource.Config = config
-		}
-		if isActive, ok := row.Values[5].AsBool(); ok {
-			datasource.IsActive = isActive
-		}
-		if createdAt, ok := row.Values[6].AsTimestamp(); ok {
-			datasource.CreatedAt = createdAt.Time.Format(time.RFC3339)
-		}
-
-		datasources = append(datasources, datasource)
+		datasources = append(datasources, scanDatasource(row.Values))
 	}
 
 	c.JSON(http.StatusOK, datasources)
 }
 
+// scanDatasource reads id, project_id, name, type, config, is_active,
+// created_at and version
+func scanDatasource(values []db.Value) Datasource {
+	var datasource Datasource
+	if id, ok := values[0].AsString(); ok {
+		datasource.ID = id
+	}
+	if projectID, ok := values[1].AsString(); ok {
+		datasource.ProjectID = projectID
+	}
+	if name, ok := values[2].AsString(); ok {
+		datasource.Name = name
+	}
+	if datasourceType, ok := values[3].AsString(); ok {
+		datasource.Type = datasourceType
+	}
+	if config, ok := values[4].AsBytes(); ok {
+		datasource.Config = config
+	}
+	if isActive, ok := values[5].AsBool(); ok {
+		datasource.IsActive = isActive
+	}
+	if createdAt, ok := values[6].AsTimestamp(); ok {
+		datasource.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	}
+	if version, ok := values[7].AsInt64(); ok {
+		datasource.Version = version
+	}
+	return datasource
+}
+
 func (app *App) createDatasourceHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 	
 	// Get current user
 	user, err := app.getCurrentUser(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
-	userID := user.ID
-
 	var req CreateDatasourceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
 		return
 	}
 
 	if req.Name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Datasource name is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "name"})
 		return
 	}
 
 	if req.Type == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Datasource type is required"})
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "type"})
 		return
 	}
 
-	// Check if user owns the project using ZDB
-	row, err := app.ZDB.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM projects p JOIN user_projects up ON p.id = up.project_id WHERE p.id = $1 AND up.user_id = $2)",
-		req.ProjectID, userID)
+	// Check if user owns the project
+	owned, err := app.userOwnsProject(ctx, req.ProjectID, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
-	exists, ok := row.Values[0].AsBool()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
-		return
-	}
-
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found or no access"})
+	if !owned {
+		apierror.Respond(c, apierror.CodeProjectNotFound, nil)
 		return
 	}
 
@@ -177,22 +180,22 @@ func (app *App) createDatasourceHandler(c *gin.Context) {
 		"INSERT INTO datasources (id, project_id, name, type, config, is_active, created_at) VALUES ($1, $2, $3, $4, $5, true, CURRENT_TIMESTAMP)",
 		datasourceID, req.ProjectID, req.Name, req.Type, req.Config)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create datasource"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
 	// Get created timestamp using ZDB
-	row, err = app.ZDB.QueryRow(ctx,
+	row, err := app.ZDB.QueryRow(ctx,
 		"SELECT created_at FROM datasources WHERE id = $1",
 		datasourceID)
 	if err != nil || len(row.Values) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get datasource details"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
 	createdAt, ok := row.Values[0].AsTimestamp()
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse timestamp"})
+		apierror.Respond(c, apierror.CodeInternal, nil)
 		return
 	}
 
@@ -204,8 +207,18 @@ func (app *App) createDatasourceHandler(c *gin.Context) {
 		Config:    req.Config,
 		IsActive:  true,
 		CreatedAt: createdAt.Time.Format(time.RFC3339),
-	}
-
+		Version:   1,
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   req.ProjectID,
+		ActorUserID: user.ID,
+		EventType:   activity.EventDatasourceCreated,
+		EntityType:  activity.EntityDatasource,
+		EntityID:    datasourceID,
+		Payload:     map[string]interface{}{"name": req.Name, "type": req.Type},
+	})
+
+	setETag(c, datasource.Version)
 	c.JSON(http.StatusCreated, datasource)
 }
 
@@ -215,47 +228,50 @@ func (app *App) getDatasourceHandler(c *gin.Context) {
 	// Get current user
 	user, err := app.getCurrentUser(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
-	userID := user.ID
 	datasourceID := c.Param("id")
 
-	row, err := app.ZDB.QueryRow(ctx,
-		`SELECT d.id, d.project_id, d.name, d.type, d.config, d.is_active, d.created_at 
-		 FROM datasources d 
-		 JOIN projects p ON d.project_id = p.id 
-		 WHERE d.id = $1 AND p.user_id = $2 AND d.is_active = true AND p.is_active = true`,
-		datasourceID, userID)
-	if err != nil || len(row.Values) < 7 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Datasource not found"})
+	datasource, err := app.loadDatasource(ctx, datasourceID, user)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeDatasourceNotFound, nil)
 		return
 	}
-
-	var datasource Datasource
-	if id, ok := row.Values[0].AsString(); ok {
-		datasource.ID = id
-	}
-	if projectID, ok := row.Values[1].AsString(); ok {
-		datasource.ProjectID = projectID
-	}
-	if name, ok := row.Values[2].AsString(); ok {
-		datasource.Name = name
-	}
-	if datasourceType, ok := row.Values[3].AsString(); ok {
-		datasource.Type = datasourceType
-	}
-	if config, ok := row.Values[4].AsBytes(); ok {
-		datasource.Config = config
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
 	}
-	if isActive, ok := row.Values[5].AsBool(); ok {
-		datasource.IsActive = isActive
+
+	setETag(c, datasource.Version)
+	c.JSON(http.StatusOK, datasource)
+}
+
+// loadDatasource reads an active datasource of one of the user's active
+// projects, with its query policies; db.ErrNoRows when there is none
+func (app *App) loadDatasource(ctx context.Context, datasourceID string, user *User) (*Datasource, error) {
+	row, err := app.ZDB.QueryRow(ctx,
+		`SELECT d.id, d.project_id, d.name, d.type, d.config, d.is_active, d.created_at, d.version, d.query_policies 
+		 FROM datasources d 
+		 JOIN projects p ON d.project_id = p.id 
+		 JOIN users u ON u.id = p.user_id 
+		 WHERE d.id = $1 AND p.user_id = $2 AND u.client_id = $3 AND d.is_active = true AND p.is_active = true`,
+		datasourceID, user.ID, user.ClientID)
+	if err != nil {
+		return nil, err
 	}
-	if createdAt, ok := row.Values[6].AsTimestamp(); ok {
-		datasource.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	if len(row.Values) < 9 {
+		return nil, db.ErrNoRows
 	}
 
-	c.JSON(http.StatusOK, datasource)
+	datasource := scanDatasource(row.Values)
+	datasource.QueryPolicies = json.RawMessage("[]")
+	if policies, ok := row.Values[8].AsBytes(); ok && len(policies) > 0 {
+		datasource.QueryPolicies = policies
+	} else if policies, ok := row.Values[8].AsString(); ok && policies != "" {
+		datasource.QueryPolicies = json.RawMessage(policies)
+	}
+	return &datasource, nil
 }
 
 func (app *App) updateDatasourceHandler(c *gin.Context) {
@@ -264,42 +280,62 @@ func (app *App) updateDatasourceHandler(c *gin.Context) {
 	// Get current user
 	user, err := app.getCurrentUser(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
-	userID := user.ID
 	datasourceID := c.Param("id")
 
-	var req UpdateDatasourceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
-		return
-	}
-
 	// Check if datasource exists and user has access using ZDB
-	row, err := app.ZDB.QueryRow(ctx,
-		`SELECT EXISTS(SELECT 1 FROM datasources d 
+	existing, err := app.ZDB.QueryRow(ctx,
+		`SELECT d.project_id, d.name FROM datasources d 
 		 JOIN projects p ON d.project_id = p.id 
-		 WHERE d.id = $1 AND p.user_id = $2 AND d.is_active = true AND p.is_active = true)`,
-		datasourceID, userID)
+		 JOIN users u ON u.id = p.user_id 
+		 WHERE d.id = $1 AND p.user_id = $2 AND u.client_id = $3 AND d.is_active = true AND p.is_active = true`,
+		datasourceID, user.ID, user.ClientID)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeDatasourceNotFound, nil)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
 		return
 	}
 
-	exists, ok := row.Values[0].AsBool()
+	var req UpdateDatasourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	version, ok := requestVersion(c, req.Version)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
 		return
 	}
 
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Datasource not found"})
+	if req.SchemaSnapshotIntervalMinutes != nil && *req.SchemaSnapshotIntervalMinutes < 0 {
+		apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "schema_snapshot_interval_minutes", "min": 0})
 		return
 	}
 
+	// Query policies are stored normalized, with effects and types lower-cased
+	var queryPolicies []byte
+	if req.QueryPolicies != nil {
+		policy, err := tools.ParseQueryPolicy(*req.QueryPolicies)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "query_policies", "reason": err.Error()})
+			return
+		}
+		rules := policy.Rules
+		if rules == nil {
+			rules = []tools.QueryPolicyRule{}
+		}
+		if queryPolicies, err = json.Marshal(rules); err != nil {
+			apierror.Respond(c, apierror.CodeInternal, nil)
+			return
+		}
+	}
+
 	// Build dynamic update query
-	query := "UPDATE datasources SET updated_at = CURRENT_TIMESTAMP"
+	query := "UPDATE datasources SET updated_at = CURRENT_TIMESTAMP" + versionIncrement
 	args := []interface{}{}
 	argIndex := 1
 
@@ -327,16 +363,61 @@ func (app *App) updateDatasourceHandler(c *gin.Context) {
 		argIndex++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d", argIndex)
-	args = append(args, datasourceID)
+	if req.SchemaSnapshotIntervalMinutes != nil {
+		query += fmt.Sprintf(", schema_snapshot_interval_minutes = $%d", argIndex)
+		args = append(args, *req.SchemaSnapshotIntervalMinutes)
+		argIndex++
+	}
 
-	_, err = app.ZDB.Execute(ctx, query, args...)
+	if queryPolicies != nil {
+		query += fmt.Sprintf(", query_policies = $%d", argIndex)
+		args = append(args, string(queryPolicies))
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" WHERE id = $%d"+versionCondition, argIndex, argIndex+1)
+	args = append(args, datasourceID, version)
+
+	result, err := app.ZDB.Execute(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update datasource"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	if result.RowsAffected == 0 {
+		current, err := app.loadDatasource(ctx, datasourceID, user)
+		if errors.Is(err, db.ErrNoRows) {
+			apierror.Respond(c, apierror.CodeDatasourceNotFound, nil)
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		respondVersionConflict(c, current, current.Version)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Datasource updated successfully"})
+	// Another connection may reach another schema
+	if app.SchemaCache != nil && (req.Type != nil || req.Config != nil) {
+		app.SchemaCache.Invalidate(datasourceID)
+	}
+
+	projectID, _ := existing.Values[0].AsString()
+	name, _ := existing.Values[1].AsString()
+	if req.Name != nil {
+		name = *req.Name
+	}
+	app.recordActivity(activity.Event{
+		ProjectID:   projectID,
+		ActorUserID: user.ID,
+		EventType:   activity.EventDatasourceUpdated,
+		EntityType:  activity.EntityDatasource,
+		EntityID:    datasourceID,
+		Payload:     map[string]interface{}{"name": name},
+	})
+
+	setETag(c, version+1)
+	c.JSON(http.StatusOK, gin.H{"message": "Datasource updated successfully", "version": version + 1})
 }
 
 func (app *App) deleteDatasourceHandler(c *gin.Context) {
@@ -345,27 +426,28 @@ func (app *App) deleteDatasourceHandler(c *gin.Context) {
 	// Get current user
 	user, err := app.getCurrentUser(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
 		return
 	}
-	userID := user.ID
 	datasourceID := c.Param("id")
 
 	// Soft delete by setting is_active to false using ZDB
 	result, err := app.ZDB.Execute(ctx,
-		`UPDATE datasources d 
-		 SET is_active = false, updated_at = CURRENT_TIMESTAMP 
-		 FROM projects p 
-		 WHERE d.id = $1 AND p.id = d.project_id AND p.user_id = $2 AND d.is_active = true`,
-		datasourceID, userID)
+		`UPDATE datasources 
+		 SET is_active = false, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		 WHERE id = $1 AND is_active = true AND project_id IN (
+		 	SELECT p.id FROM projects p 
+		 	JOIN users u ON u.id = p.user_id 
+		 	WHERE p.user_id = $2 AND u.client_id = $3)`,
+		datasourceID, user.ID, user.ClientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete datasource"})
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
 		return
 	}
 
 	rowsAffected := result.RowsAffected
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Datasource not found"})
+		apierror.Respond(c, apierror.CodeDatasourceNotFound, nil)
 		return
 	}
 
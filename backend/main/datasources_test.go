@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUpdateDatasourceQueryPolicies(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	w := tenancyRequest(router, "token-a", "PUT", "/api/datasources/datasource-a",
+		`{"query_policies":[{"effect":"DENY","pattern":"payroll.*"},{"effect":"allow","pattern":"^rpt_","type":"regex"}],"version":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/datasources/datasource-a", "")
+	var datasource Datasource
+	if err := json.Unmarshal(w.Body.Bytes(), &datasource); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	expected := `[{"effect":"deny","pattern":"payroll.*","type":"table"},{"effect":"allow","pattern":"^rpt_","type":"regex"}]`
+	if string(datasource.QueryPolicies) != expected {
+		t.Errorf("Expected the normalized policy %s, got %s", expected, datasource.QueryPolicies)
+	}
+
+	for _, body := range []string{
+		`{"query_policies":{"effect":"deny"},"version":2}`,
+		`{"query_policies":[{"effect":"deny","pattern":"(","type":"regex"}],"version":2}`,
+		`{"query_policies":[{"effect":"maybe","pattern":"x"}],"version":2}`,
+	} {
+		w := tenancyRequest(router, "token-a", "PUT", "/api/datasources/datasource-a", body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "query_policies") {
+			t.Errorf("%s: expected 400 naming query_policies, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/apierror"
+)
+
+type apiErrorBody struct {
+	Error   string                 `json:"error"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details"`
+}
+
+func TestHandlersEmitCatalogErrorCodes(t *testing.T) {
+	router := newTenancyTestRouter(newTenancyTestApp(t))
+
+	for _, tc := range []struct {
+		name, token, method, path, body, language string
+		status                                    int
+		code, message                             string
+	}{
+		{"missing session", "", "GET", "/api/datasources/datasource-a", "", "",
+			http.StatusUnauthorized, apierror.CodeAuthRequired, "Authentication required"},
+		{"other tenant's datasource", "token-a", "GET", "/api/datasources/datasource-b", "", "",
+			http.StatusNotFound, apierror.CodeDatasourceNotFound, "Datasource not found"},
+		{"translated", "token-a", "GET", "/api/datasources/datasource-b", "", "id-ID,id;q=0.9,en;q=0.8",
+			http.StatusNotFound, apierror.CodeDatasourceNotFound, "Sumber data tidak ditemukan"},
+		{"unsupported language", "token-a", "GET", "/api/datasources/datasource-b", "", "fr-FR",
+			http.StatusNotFound, apierror.CodeDatasourceNotFound, "Datasource not found"},
+		{"malformed body", "token-a", "PUT", "/api/datasources/datasource-a", "{", "",
+			http.StatusBadRequest, apierror.CodeInvalidRequestBody, "Invalid JSON format"},
+		{"missing field", "token-a", "POST", "/api/datasources", `{"project_id": "project-a", "type": "postgres"}`, "id",
+			http.StatusBadRequest, apierror.CodeFieldRequired, "name wajib diisi"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: tc.token})
+			}
+			if tc.language != "" {
+				req.Header.Set("Accept-Language", tc.language)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var body apiErrorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Invalid JSON body %q: %v", w.Body.String(), err)
+			}
+			if w.Code != tc.status || body.Code != tc.code {
+				t.Fatalf("Expected %d %s, got %d %+v", tc.status, tc.code, w.Code, body)
+			}
+			if body.Message != tc.message || body.Error != tc.message {
+				t.Errorf("Expected message %q in message and error, got %+v", tc.message, body)
+			}
+			if w.Code != apierror.Status(body.Code) {
+				t.Errorf("Expected the catalog status for %s, got %d", body.Code, w.Code)
+			}
+		})
+	}
+}
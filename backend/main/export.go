@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/export"
+	"zlay-backend/internal/tools"
+)
+
+// errConversationNotFound is returned when a conversation does not exist or belongs to someone else
+var errConversationNotFound = errors.New("conversation not found")
+
+// exportConversationHandler streams a conversation transcript as JSON or Markdown.
+// Callers authenticate with their session cookie or with a one-time signed token
+// issued over the WebSocket export_conversation message.
+func (app *App) exportConversationHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	conversationID := c.Param("id")
+
+	format := c.DefaultQuery("format", export.FormatJSON)
+	if format != export.FormatJSON && format != export.FormatMarkdown {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or markdown"})
+		return
+	}
+
+	var userID, clientID string
+	if token := c.Query("token"); token != "" {
+		if app.ExportSigner == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download link"})
+			return
+		}
+		claims, err := app.ExportSigner.Redeem(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download link"})
+			return
+		}
+		if claims.ConversationID != conversationID || claims.Format != format {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Download link does not match this export"})
+			return
+		}
+		userID = claims.UserID
+
+		// Signed links carry no client, so scope to the client the user belongs to
+		row, err := app.ZDB.QueryRow(ctx, "SELECT client_id FROM users WHERE id = $1 AND is_active = true", userID)
+		if err != nil || len(row.Values) == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired download link"})
+			return
+		}
+		clientID, _ = row.Values[0].AsString()
+	} else {
+		user, err := app.getCurrentUser(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		userID = user.ID
+		clientID = user.ClientID
+	}
+
+	conv, participants, err := app.loadExportConversation(ctx, conversationID, userID, clientID)
+	if errors.Is(err, errConversationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversation"})
+		return
+	}
+
+	filename := fmt.Sprintf("conversation-%s%s", conversationID, export.FileExtension(format))
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	writer, _ := export.NewWriter(format, c.Writer)
+	if err := app.writeConversationExport(ctx, writer, conv, participants, c.Writer.Flush); err != nil {
+		// Headers are already sent, so the truncated body is all the client gets
+		c.Error(err)
+	}
+}
+
+// loadExportConversation loads conversation details and participants after checking ownership
+func (app *App) loadExportConversation(ctx context.Context, conversationID, userID, clientID string) (*chat.Conversation, []export.Participant, error) {
+	row, err := app.ZDB.QueryRow(ctx,
+		`SELECT c.id, c.title, c.project_id, c.status, c.created_at, c.updated_at, u.username
+		FROM conversations c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND c.user_id = $2 AND u.client_id = $3 AND c.deleted_at IS NULL`,
+		conversationID, userID, clientID)
+	if err != nil || len(row.Values) < 7 {
+		return nil, nil, errConversationNotFound
+	}
+
+	conv := &chat.Conversation{UserID: userID}
+	conv.ID, _ = row.Values[0].AsString()
+	conv.Title, _ = row.Values[1].AsString()
+	conv.ProjectID, _ = row.Values[2].AsString()
+	conv.Status, _ = row.Values[3].AsString()
+	if createdAt, ok := row.Values[4].AsTimestamp(); ok {
+		conv.CreatedAt = createdAt.Time
+	}
+	if updatedAt, ok := row.Values[5].AsTimestamp(); ok {
+		conv.UpdatedAt = updatedAt.Time
+	}
+	username, _ := row.Values[6].AsString()
+
+	resultSet, err := app.ZDB.Query(ctx,
+		"SELECT DISTINCT role FROM messages WHERE conversation_id = $1 AND status <> 'draft' ORDER BY role",
+		conversationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load participants: %w", err)
+	}
+
+	participants := []export.Participant{}
+	for _, r := range resultSet.Rows {
+		role, _ := r.Values[0].AsString()
+		switch role {
+		case "user":
+			participants = append(participants, export.Participant{ID: userID, Name: username, Role: role})
+		case "assistant":
+			participants = append(participants, export.Participant{Name: "Assistant", Role: role})
+		default:
+			participants = append(participants, export.Participant{Name: role, Role: role})
+		}
+	}
+
+	return conv, participants, nil
+}
+
+// writeConversationExport streams every message of a conversation through the export writer.
+// Rows are read one at a time and flush is called after each message so the response is chunked.
+func (app *App) writeConversationExport(ctx context.Context, writer export.Writer, conv *chat.Conversation, participants []export.Participant, flush func()) error {
+	if err := writer.WriteHeader(conv, participants); err != nil {
+		return err
+	}
+
+	// Ratings are few per conversation, so they are loaded up front and attached per message
+	feedback, err := chat.ConversationFeedback(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, conv.ID)
+	if err != nil {
+		return err
+	}
+	executions, err := chat.LoadToolExecutions(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, conv.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := app.ZDB.GetDB().QueryContext(ctx,
+		"SELECT id, role, content, created_at, tool_calls FROM messages WHERE conversation_id = $1 AND status <> 'draft' ORDER BY created_at ASC",
+		conv.ID)
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg chat.Message
+		var createdAt time.Time
+		var toolCalls []byte
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &createdAt, &toolCalls); err != nil {
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		msg.ConversationID = conv.ID
+		msg.CreatedAt = createdAt
+		msg.Feedback = feedback[msg.ID]
+		if calls, _, err := chat.UnmarshalToolCalls(toolCalls); err != nil {
+			log.Printf("Message %s: %v", msg.ID, err)
+		} else {
+			executions.Apply(msg.ID, calls)
+			msg.ToolCalls = calls
+		}
+
+		if err := writer.WriteMessage(&msg); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/export"
+)
+
+func newExportTestApp(t *testing.T) *App {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.SeedProject(t, zdb, "client-1", "user-1", "project-1")
+	dbtest.Seed(t, zdb,
+		"UPDATE users SET username = 'alice' WHERE id = 'user-1'",
+		"INSERT INTO users (id, client_id, username, password_hash) VALUES ('user-2', 'client-1', 'bob', 'x')")
+
+	ctx := context.Background()
+	created := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	if _, err := zdb.Execute(ctx,
+		"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		"conv-1", "Revenue", "user-1", "project-1", "completed", created, created); err != nil {
+		t.Fatalf("Failed to insert conversation: %v", err)
+	}
+	toolCalls := `[{"id":"call-1","type":"function","function":{"name":"database_query","arguments":"{\"query\":\"SELECT 1\"}"},"status":"completed","result":{"count":1}}]`
+	messages := []struct {
+		id, role, content, toolCalls string
+		offset                       time.Duration
+	}{
+		{"m1", "user", "How much revenue?", "", 0},
+		{"m2", "assistant", "Checking.", toolCalls, time.Minute},
+		{"m3", "assistant", "About 1200.", "", 2 * time.Minute},
+	}
+	for _, m := range messages {
+		var tc interface{}
+		if m.toolCalls != "" {
+			tc = m.toolCalls
+		}
+		if _, err := zdb.Execute(ctx,
+			"INSERT INTO messages (id, conversation_id, role, content, created_at, tool_calls) VALUES ($1, $2, $3, $4, $5, $6)",
+			m.id, "conv-1", m.role, m.content, created.Add(m.offset), tc); err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+	}
+
+	if _, err := zdb.Execute(ctx,
+		"INSERT INTO message_feedback (message_id, conversation_id, user_id, rating, comment, created_at, updated_at) VALUES ('m3', 'conv-1', 'user-1', 1, 'Spot on', $1, $1)",
+		created.Add(3*time.Minute)); err != nil {
+		t.Fatalf("Failed to insert feedback: %v", err)
+	}
+
+	return &App{ZDB: zdb, ExportSigner: export.NewDownloadSigner("test-secret", time.Minute)}
+}
+
+func newExportTestRouter(app *App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/conversations/:id/export", app.exportConversationHandler)
+	return router
+}
+
+func TestExportConversationWithSignedToken(t *testing.T) {
+	app := newExportTestApp(t)
+	router := newExportTestRouter(app)
+
+	token, _ := app.ExportSigner.Sign("conv-1", "user-1", export.FormatMarkdown)
+	req := httptest.NewRequest("GET", export.DownloadPath("conv-1", export.FormatMarkdown, token), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") {
+		t.Errorf("Unexpected content type: %s", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "conversation-conv-1.md") {
+		t.Errorf("Unexpected content disposition: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"# Revenue", "alice (user)", "How much revenue?", "`database_query` (completed)", "About 1200.", "**Feedback:** 👍 Spot on"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected export to contain %q", want)
+		}
+	}
+	if strings.Index(body, "How much revenue?") > strings.Index(body, "About 1200.") {
+		t.Error("Messages should be exported in chronological order")
+	}
+
+	// The same link cannot be used twice
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", export.DownloadPath("conv-1", export.FormatMarkdown, token), nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 on reused token, got %d", w.Code)
+	}
+}
+
+func TestExportConversationJSON(t *testing.T) {
+	app := newExportTestApp(t)
+	router := newExportTestRouter(app)
+
+	token, _ := app.ExportSigner.Sign("conv-1", "user-1", export.FormatJSON)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", export.DownloadPath("conv-1", export.FormatJSON, token), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var doc struct {
+		Version  int `json:"version"`
+		Messages []struct {
+			Role      string `json:"role"`
+			ToolCalls []struct {
+				Name string `json:"name"`
+			} `json:"tool_calls"`
+			Feedback []struct {
+				Rating  int    `json:"rating"`
+				Comment string `json:"comment"`
+			} `json:"feedback"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid JSON export: %v", err)
+	}
+	if doc.Version != export.SchemaVersion || len(doc.Messages) != 3 {
+		t.Fatalf("Unexpected export: %+v", doc)
+	}
+	if len(doc.Messages[1].ToolCalls) != 1 || doc.Messages[1].ToolCalls[0].Name != "database_query" {
+		t.Errorf("Expected tool call on second message, got %+v", doc.Messages[1].ToolCalls)
+	}
+	if len(doc.Messages[0].Feedback) != 0 || len(doc.Messages[2].Feedback) != 1 || doc.Messages[2].Feedback[0].Comment != "Spot on" {
+		t.Errorf("Expected feedback on the last message only, got %+v", doc.Messages)
+	}
+}
+
+func TestExportConversationAccessChecks(t *testing.T) {
+	app := newExportTestApp(t)
+	router := newExportTestRouter(app)
+
+	tests := []struct {
+		name   string
+		path   func() string
+		status int
+	}{
+		{"no session or token", func() string { return "/api/conversations/conv-1/export" }, http.StatusUnauthorized},
+		{"bad format", func() string { return "/api/conversations/conv-1/export?format=pdf" }, http.StatusBadRequest},
+		{"forged token", func() string { return "/api/conversations/conv-1/export?format=json&token=abc.def" }, http.StatusUnauthorized},
+		{"token for another conversation", func() string {
+			token, _ := app.ExportSigner.Sign("conv-2", "user-1", export.FormatJSON)
+			return export.DownloadPath("conv-1", export.FormatJSON, token)
+		}, http.StatusForbidden},
+		{"token for another user", func() string {
+			token, _ := app.ExportSigner.Sign("conv-1", "user-2", export.FormatJSON)
+			return export.DownloadPath("conv-1", export.FormatJSON, token)
+		}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", tt.path(), nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+// defaultFeedbackRange is the analytics window when from is omitted
+const defaultFeedbackRange = 30 * 24 * time.Hour
+
+type messageFeedbackRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+// messageFeedbackHandler rates a message; posting again replaces the caller's earlier rating
+func (app *App) messageFeedbackHandler(c *gin.Context) {
+	userID := c.GetString("user_id")
+	clientID := c.GetString("client_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req messageFeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	feedback, err := chat.SaveMessageFeedback(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		userID, clientID, c.Param("id"), req.Rating, req.Comment)
+	if errors.Is(err, chat.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if errors.Is(err, chat.ErrInvalidFeedback) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feedback"})
+		return
+	}
+
+	if app.WSServer != nil {
+		app.WSServer.BroadcastFeedback(feedback)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "feedback": feedback})
+}
+
+// feedbackAnalyticsHandler returns rating counts for the caller's client.
+// from and to accept RFC 3339 or YYYY-MM-DD; to defaults to now and from to 30 days earlier.
+func (app *App) feedbackAnalyticsHandler(c *gin.Context) {
+	clientID := c.GetString("client_id")
+	if clientID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		parsed, err := parseAnalyticsTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to: use RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-defaultFeedbackRange)
+	if value := c.Query("from"); value != "" {
+		parsed, err := parseAnalyticsTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: use RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	groupBy := c.Query("group_by")
+	if groupBy != chat.FeedbackGroupNone && groupBy != chat.FeedbackGroupDay {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be day or omitted"})
+		return
+	}
+
+	summary, err := chat.FeedbackStats(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.readDB()}, clientID, from, to, groupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feedback stats"})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+func parseAnalyticsTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
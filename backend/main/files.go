@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/tools"
+)
+
+type ProjectFile struct {
+	ID          string `json:"id"`
+	ProjectID   string `json:"project_id"`
+	UserID      string `json:"user_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// errFileTooLarge is returned when an upload exceeds the configured size limit
+var errFileTooLarge = errors.New("file exceeds maximum upload size")
+
+func (app *App) getProjectFilesHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	resultSet, err := app.ZDB.Query(ctx,
+		"SELECT id, project_id, user_id, filename, content_type, size_bytes, created_at FROM project_files WHERE project_id = $1 ORDER BY created_at DESC",
+		projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return
+	}
+
+	files := []ProjectFile{}
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 7 {
+			continue
+		}
+
+		var file ProjectFile
+		if id, ok := row.Values[0].AsString(); ok {
+			file.ID = id
+		}
+		if projectID, ok := row.Values[1].AsString(); ok {
+			file.ProjectID = projectID
+		}
+		if userID, ok := row.Values[2].AsString(); ok {
+			file.UserID = userID
+		}
+		if filename, ok := row.Values[3].AsString(); ok {
+			file.Filename = filename
+		}
+		if contentType, ok := row.Values[4].AsString(); ok {
+			file.ContentType = contentType
+		}
+		if sizeBytes, ok := row.Values[5].AsInt64(); ok {
+			file.SizeBytes = sizeBytes
+		}
+		if createdAt, ok := row.Values[6].AsTimestamp(); ok {
+			file.CreatedAt = createdAt.Time.Format(time.RFC3339)
+		}
+
+		files = append(files, file)
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+func (app *App) uploadProjectFileHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	fileID := uuid.New().String()
+	file, status, err := app.storeUploadedFile(c, fileID)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	file.ProjectID = projectID
+	file.UserID = user.ID
+
+	createdAt := time.Now().UTC()
+	_, err = app.ZDB.Execute(ctx,
+		"INSERT INTO project_files (id, project_id, user_id, filename, content_type, size_bytes, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		file.ID, file.ProjectID, file.UserID, file.Filename, file.ContentType, file.SizeBytes, createdAt)
+	if err != nil {
+		app.removeStoredFile(fileID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	file.CreatedAt = createdAt.Format(time.RFC3339)
+
+	c.JSON(http.StatusCreated, file)
+}
+
+func (app *App) downloadProjectFileHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+	fileID := c.Param("file_id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	path, err := tools.ProjectFilePath(app.Config.FilesDir, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT filename, content_type FROM project_files WHERE id = $1 AND project_id = $2",
+		fileID, projectID)
+	if err != nil || len(row.Values) < 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	filename, _ := row.Values[0].AsString()
+	contentType, _ := row.Values[1].AsString()
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.FileAttachment(path, filename)
+}
+
+func (app *App) deleteProjectFileHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+	fileID := c.Param("file_id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if _, err := uuid.Parse(fileID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	result, err := app.ZDB.Execute(ctx,
+		"DELETE FROM project_files WHERE id = $1 AND project_id = $2",
+		fileID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	app.removeStoredFile(fileID)
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+}
+
+// storeUploadedFile reads the multipart "file" field and writes it to the files dir.
+// It returns the HTTP status to use when storing fails.
+func (app *App) storeUploadedFile(c *gin.Context, fileID string) (*ProjectFile, int, error) {
+	maxBytes := app.Config.MaxUploadBytes
+
+	// Leave headroom for the multipart envelope; the file itself is checked below
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1024*1024)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, http.StatusRequestEntityTooLarge, errFileTooLarge
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("file is required")
+	}
+	if header.Size > maxBytes {
+		return nil, http.StatusRequestEntityTooLarge, errFileTooLarge
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read upload")
+	}
+	defer src.Close()
+
+	size, err := writeProjectFile(app.Config.FilesDir, fileID, src, maxBytes)
+	if err != nil {
+		if errors.Is(err, errFileTooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to store file")
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &ProjectFile{
+		ID:          fileID,
+		Filename:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		SizeBytes:   size,
+	}, http.StatusCreated, nil
+}
+
+// writeProjectFile copies at most maxBytes from src into the file stored for fileID
+func writeProjectFile(dataDir, fileID string, src io.Reader, maxBytes int64) (int64, error) {
+	path, err := tools.ProjectFilePath(dataDir, fileID)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		return 0, err
+	}
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(dst, io.LimitReader(src, maxBytes+1))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBytes {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// removeStoredFile deletes a stored upload, ignoring files that are already gone
+func (app *App) removeStoredFile(fileID string) {
+	path, err := tools.ProjectFilePath(app.Config.FilesDir, fileID)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove stored file %s: %v", fileID, err)
+	}
+}
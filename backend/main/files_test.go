@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/tools"
+)
+
+func newMultipartUpload(t *testing.T, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func newUploadContext(t *testing.T, body *bytes.Buffer, contentType string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/api/projects/p1/files", body)
+	c.Request.Header.Set("Content-Type", contentType)
+	return c, w
+}
+
+func TestStoreUploadedFile(t *testing.T) {
+	app := &App{Config: &config.Config{FilesDir: t.TempDir(), MaxUploadBytes: 1024}}
+	body, contentType := newMultipartUpload(t, "../../sales.csv", "region,revenue\nnorth,10\n")
+	c, _ := newUploadContext(t, body, contentType)
+
+	fileID := uuid.New().String()
+	file, _, err := app.storeUploadedFile(c, fileID)
+	if err != nil {
+		t.Fatalf("storeUploadedFile failed: %v", err)
+	}
+
+	// Client supplied paths are reduced to a base name
+	if file.Filename != "sales.csv" {
+		t.Errorf("Expected filename 'sales.csv', got '%s'", file.Filename)
+	}
+	if file.SizeBytes != 24 {
+		t.Errorf("Expected size 24, got %d", file.SizeBytes)
+	}
+
+	path, _ := tools.ProjectFilePath(app.Config.FilesDir, fileID)
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Stored file not found: %v", err)
+	}
+	if !strings.HasPrefix(string(stored), "region,revenue") {
+		t.Errorf("Unexpected stored content: %q", stored)
+	}
+}
+
+func TestStoreUploadedFileTooLarge(t *testing.T) {
+	app := &App{Config: &config.Config{FilesDir: t.TempDir(), MaxUploadBytes: 10}}
+	body, contentType := newMultipartUpload(t, "big.txt", strings.Repeat("x", 100))
+	c, _ := newUploadContext(t, body, contentType)
+
+	fileID := uuid.New().String()
+	_, status, err := app.storeUploadedFile(c, fileID)
+	if err == nil || status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got status=%d err=%v", status, err)
+	}
+
+	path, _ := tools.ProjectFilePath(app.Config.FilesDir, fileID)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Oversized upload should not be left on disk")
+	}
+}
+
+func TestStoreUploadedFileMissingField(t *testing.T) {
+	app := &App{Config: &config.Config{FilesDir: t.TempDir(), MaxUploadBytes: 1024}}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("name", "no file here")
+	writer.Close()
+	c, _ := newUploadContext(t, body, writer.FormDataContentType())
+
+	_, status, err := app.storeUploadedFile(c, uuid.New().String())
+	if err == nil || status != http.StatusBadRequest {
+		t.Errorf("Expected 400, got status=%d err=%v", status, err)
+	}
+}
+
+func TestProjectFileHandlersRequireAuth(t *testing.T) {
+	app := &App{Config: &config.Config{FilesDir: t.TempDir(), MaxUploadBytes: 1024}}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/projects/:id/files", app.getProjectFilesHandler)
+	router.POST("/api/projects/:id/files", app.uploadProjectFileHandler)
+	router.GET("/api/projects/:id/files/:file_id", app.downloadProjectFileHandler)
+	router.DELETE("/api/projects/:id/files/:file_id", app.deleteProjectFileHandler)
+
+	body, contentType := newMultipartUpload(t, "notes.txt", "hello")
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/api/projects/p1/files", nil),
+		httptest.NewRequest("POST", "/api/projects/p1/files", body),
+		httptest.NewRequest("GET", "/api/projects/p1/files/"+uuid.New().String(), nil),
+		httptest.NewRequest("DELETE", "/api/projects/p1/files/"+uuid.New().String(), nil),
+	}
+	requests[1].Header.Set("Content-Type", contentType)
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected 401, got %d", req.Method, req.URL.Path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/health"
+	"zlay-backend/internal/metrics"
+)
+
+// databaseCheckTimeout bounds the readiness ping so a hung database fails fast
+const databaseCheckTimeout = time.Second
+
+// newHealthChecker builds the readiness checks: the database and the WebSocket hub
+// are critical, the client LLM config check only runs when HEALTH_CHECK_LLM is set
+func (app *App) newHealthChecker(database health.Querier) *health.Checker {
+	checks := []health.Check{
+		health.DatabaseCheck(database, databaseCheckTimeout),
+		{
+			Name:     "websocket_hub",
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				if app.WSServer == nil || !app.WSServer.HubRunning() {
+					return errors.New("hub is not running")
+				}
+				return nil
+			},
+		},
+	}
+
+	if app.Config != nil && app.Config.HealthCheckLLM {
+		checks = append(checks, health.Check{
+			Name:     "llm_config",
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				if app.ClientConfigCache == nil {
+					return errors.New("client config cache is not initialized")
+				}
+				return app.ClientConfigCache.ValidateAnyClientConfig(ctx)
+			},
+		})
+	}
+
+	return health.NewChecker(health.DefaultCacheTTL, checks...)
+}
+
+// healthLiveHandler reports that the process is up; it never touches dependencies
+func (app *App) healthLiveHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    health.StatusOK,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// healthReadyHandler runs the cached dependency checks and returns 503 when a
+// critical one fails, so load balancers stop routing to this instance
+func (app *App) healthReadyHandler(c *gin.Context) {
+	report := app.Health.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// adminStatusHandler returns a fresh health report with connection, stream and cache counts
+func (app *App) adminStatusHandler(c *gin.Context) {
+	report := app.Health.Run(c.Request.Context())
+
+	response := gin.H{
+		"health":       report,
+		"domain_cache": gin.H{"domains": app.DomainCache.Len()},
+	}
+	if app.WSServer != nil {
+		response["websocket"] = app.WSServer.Stats()
+	}
+	if app.ZDB != nil {
+		stats := app.ZDB.GetDB().Stats()
+		response["database_pool"] = gin.H{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+			"wait_count":       stats.WaitCount,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// adminMetricsHandler returns the in-process latency recorders, event counters
+// such as LLM rate limits per client, and the query guard counters of the
+// database and its read replica
+func (app *App) adminMetricsHandler(c *gin.Context) {
+	response := gin.H{"latency": metrics.LatencySnapshots(), "counters": metrics.CounterSnapshots()}
+	if app.ZDB != nil {
+		response["database"] = app.ZDB.QueryGuardStats()
+		if reader := app.ZDB.ReaderStats(); reader != nil {
+			response["database_reader"] = reader
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// adminConfigHandler returns the effective configuration with secrets reduced to whether they are set
+func (app *App) adminConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.Config.Public())
+}
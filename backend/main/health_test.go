@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/health"
+	"zlay-backend/internal/websocket"
+)
+
+// fakeQuerier stands in for ZDB in readiness checks
+type fakeQuerier struct {
+	err   error
+	calls atomic.Int32
+}
+
+func (f *fakeQuerier) QueryRow(ctx context.Context, query string, args ...interface{}) (*db.Row, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &db.Row{}, nil
+}
+
+// newHealthTestRouter starts a real WebSocket hub on sqlite and wires the health
+// checks to database, so only the database check varies between tests
+func newHealthTestRouter(t *testing.T, database health.Querier) *gin.Engine {
+	t.Helper()
+
+	zdb, err := db.NewConnectionBuilder(db.DatabaseTypeSQLite).
+		FilePath(filepath.Join(t.TempDir(), "health.db")).
+		Build()
+	if err != nil {
+		t.Fatalf("Failed to open sqlite database: %v", err)
+	}
+	t.Cleanup(func() { zdb.Close() })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	cfg := config.Default()
+	cfg.WSPort, cfg.FilesDir = "0", t.TempDir()
+	cfg.SaveJournalPath = ""
+	app := &App{Config: cfg, ZDB: zdb}
+	app.WSServer = websocket.NewServer(zdb, cfg)
+	app.WSServer.Mount(router)
+	app.Health = app.newHealthChecker(database)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !app.WSServer.HubRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("Hub did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	router.GET("/api/health/live", app.healthLiveHandler)
+	router.GET("/api/health/ready", app.healthReadyHandler)
+	router.GET("/api/admin/status", app.adminStatusHandler)
+	return router
+}
+
+func getHealth(t *testing.T, router *gin.Engine, path string) (int, health.Report) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	var report health.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, report
+}
+
+func TestReadyReturnsServiceUnavailableWhenDatabaseFails(t *testing.T) {
+	database := &fakeQuerier{err: errors.New("connection refused")}
+	router := newHealthTestRouter(t, database)
+
+	code, report := getHealth(t, router, "/api/health/ready")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", code)
+	}
+	if report.Status != health.StatusFail {
+		t.Errorf("Expected status fail, got %q", report.Status)
+	}
+	for _, check := range report.Checks {
+		switch check.Name {
+		case "database":
+			if check.Status != health.StatusFail || check.Error != "connection refused" {
+				t.Errorf("Expected the database check to fail with its error, got %+v", check)
+			}
+		case "websocket_hub":
+			if check.Status != health.StatusOK {
+				t.Errorf("Expected the hub check to pass, got %+v", check)
+			}
+		}
+	}
+
+	// Liveness does not depend on the database
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/health/live", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected live to return 200, got %d", w.Code)
+	}
+}
+
+func TestReadyCachesResults(t *testing.T) {
+	database := &fakeQuerier{}
+	router := newHealthTestRouter(t, database)
+
+	code, report := getHealth(t, router, "/api/health/ready")
+	if code != http.StatusOK || report.Status != health.StatusOK || report.Cached {
+		t.Fatalf("Expected a fresh passing report, got %d %+v", code, report)
+	}
+	_, report = getHealth(t, router, "/api/health/ready")
+	if !report.Cached {
+		t.Error("Expected the second probe to be served from cache")
+	}
+	if calls := database.calls.Load(); calls != 1 {
+		t.Errorf("Expected one database ping, got %d", calls)
+	}
+
+	// The admin status page always runs the checks
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected admin status to return 200, got %d", w.Code)
+	}
+	if calls := database.calls.Load(); calls != 2 {
+		t.Errorf("Expected admin status to ping the database, got %d pings", calls)
+	}
+	var status struct {
+		Websocket struct {
+			HubRunning  bool `json:"hub_running"`
+			Connections int  `json:"connections"`
+		} `json:"websocket"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid admin status %q: %v", w.Body.String(), err)
+	}
+	if !status.Websocket.HubRunning || status.Websocket.Connections != 0 {
+		t.Errorf("Unexpected websocket stats: %s", w.Body.String())
+	}
+}
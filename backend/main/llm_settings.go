@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/websocket"
+)
+
+// llmValidateTimeout bounds the test request made by validateLLMSettingsHandler
+const llmValidateTimeout = 15 * time.Second
+
+// llmValidator is implemented by LLM clients that can test their connection
+type llmValidator interface {
+	ValidateConnection(ctx context.Context) error
+}
+
+// validateLLMSettingsHandler sends a test request with the current user's
+// client LLM configuration and reports whether it succeeded, so tenants can
+// diagnose a misconfigured key, URL or model themselves
+func (app *App) validateLLMSettingsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	if app.ClientConfigCache == nil {
+		apierror.Respond(c, apierror.CodeLLMConfigUnavailable, nil)
+		return
+	}
+
+	configCtx, cancel := context.WithTimeout(ctx, app.Config.LLMConfigTimeout)
+	clientConfig, err := app.ClientConfigCache.GetClientConfig(configCtx, user.ClientID)
+	cancel()
+	if err != nil {
+		apierror.Respond(c, websocket.LLMConfigErrorCode(err), nil)
+		return
+	}
+
+	response := gin.H{
+		"model":    clientConfig.Model,
+		"base_url": clientConfig.BaseURL,
+	}
+	validator, ok := clientConfig.LLMClient.(llmValidator)
+	if !ok {
+		response["valid"] = false
+		response["error"] = "connection test is not supported by this LLM client"
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	validateCtx, cancel := context.WithTimeout(ctx, llmValidateTimeout)
+	defer cancel()
+	start := time.Now()
+	err = validator.ValidateConnection(validateCtx)
+	response["latency_ms"] = time.Since(start).Milliseconds()
+	response["valid"] = err == nil
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/websocket"
+)
+
+// validatingLLMClient answers connection tests with err
+type validatingLLMClient struct {
+	llm.LLMClient
+	err error
+}
+
+func (c validatingLLMClient) ValidateConnection(context.Context) error { return c.err }
+
+func TestValidateLLMSettings(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	app.Config.OpenAIAPIKey = ""
+	if _, err := app.ZDB.Execute(context.Background(),
+		"UPDATE clients SET ai_api_key = '', ai_api_url = '', ai_api_model = '', max_concurrent_streams = 3, allowed_models = '[]' WHERE id = 'client-a'"); err != nil {
+		t.Fatalf("Failed to seed client: %v", err)
+	}
+	app.ClientConfigCache = websocket.NewClientConfigCache(app.ZDB, app.Config)
+	router := newTenancyTestRouter(app)
+	router.GET("/api/settings/llm/validate", app.authMiddleware(), app.validateLLMSettingsHandler)
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/settings/llm/validate", "")
+	if w.Code != http.StatusServiceUnavailable || responseErrorCode(t, w.Body.Bytes()) != "LLM_NOT_CONFIGURED" {
+		t.Errorf("Expected 503 LLM_NOT_CONFIGURED, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Valid     bool   `json:"valid"`
+		Model     string `json:"model"`
+		LatencyMs *int64 `json:"latency_ms"`
+		Error     string `json:"error"`
+	}
+	for _, tc := range []struct {
+		err   error
+		valid bool
+	}{
+		{nil, true},
+		{errors.New("OpenAI connection test failed: 401 Unauthorized"), false},
+	} {
+		app.ClientConfigCache.SetClientConfig(&websocket.ClientConfig{
+			ClientID:  "client-a",
+			Model:     "gpt-4o",
+			LLMClient: validatingLLMClient{err: tc.err},
+		})
+		w := tenancyRequest(router, "token-a", "GET", "/api/settings/llm/validate", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		resp.Error = ""
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Valid != tc.valid || resp.Model != "gpt-4o" || resp.LatencyMs == nil || (resp.Error != "") == tc.valid {
+			t.Errorf("Unexpected result for %v: %s", tc.err, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"time"
+
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/db"
+)
+
+// messageColumns is the number of columns messageFromRow expects:
+// id, conversation_id, role, content, metadata, tool_calls, created_at,
+// optionally followed by the sender's user_id and the message's status
+const messageColumns = 7
+
+// messageFromRow maps a messages row to the API payload. legacy reports
+// metadata or tool_calls stored in a shape chat.RewriteMessageJSON would
+// update, with the decoded message to rewrite.
+func messageFromRow(row db.Row) (msg Message, legacy *chat.Message, ok bool) {
+	if len(row.Values) < messageColumns {
+		return msg, nil, false
+	}
+
+	msg.ID, _ = row.Values[0].AsString()
+	msg.ConversationID, _ = row.Values[1].AsString()
+	msg.Role, _ = row.Values[2].AsString()
+	msg.Content, _ = row.Values[3].AsString()
+
+	decoded := &chat.Message{ID: msg.ID}
+	if chat.DecodeMessageJSON(decoded, storedJSON(row.Values[4]), storedJSON(row.Values[5])) {
+		legacy = decoded
+	}
+	msg.Metadata = decoded.Metadata
+	msg.ToolCalls = make([]ToolCall, len(decoded.ToolCalls))
+	for i, call := range decoded.ToolCalls {
+		msg.ToolCalls[i] = ToolCall{
+			ID:   call.ID,
+			Type: call.Type,
+			Function: ToolCallFunction{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+			Status: call.Status,
+			Error:  call.Error,
+			Reruns: call.Reruns,
+		}
+		if call.Result != nil {
+			msg.ToolCalls[i].Result = call.Result
+		}
+	}
+
+	msg.CreatedAt = formatTimestamp(row.Values[6])
+	if len(row.Values) > messageColumns {
+		msg.UserID, _ = row.Values[7].AsString()
+	}
+	if len(row.Values) > messageColumns+1 {
+		msg.Status, _ = row.Values[8].AsString()
+	}
+	return msg, legacy, true
+}
+
+// storedJSON returns a JSON column as stored, nil for NULL. Drivers return
+// JSON as bytes or as text depending on the database.
+func storedJSON(value db.Value) []byte {
+	if !value.Valid {
+		return nil
+	}
+	switch data := value.Data.(type) {
+	case []byte:
+		return data
+	case string:
+		return []byte(data)
+	default:
+		return nil
+	}
+}
+
+// formatTimestamp formats a timestamp column as RFC3339, returning text the
+// driver gave in an unrecognised form unchanged
+func formatTimestamp(value db.Value) string {
+	if ts, ok := value.AsTimestamp(); ok {
+		return ts.Time.UTC().Format(time.RFC3339)
+	}
+	text, _ := value.AsString()
+	return text
+}
+
+// applyToolExecutions fills in the status and result of a message's tool
+// calls from their executions, as chat.ToolExecutions.Apply does
+func applyToolExecutions(executions chat.ToolExecutions, msg *Message) {
+	for i := range msg.ToolCalls {
+		call := &msg.ToolCalls[i]
+		execution := executions.Get(msg.ID, call.ID)
+		if execution == nil {
+			continue
+		}
+		call.Status = execution.Status
+		if result := execution.LoadResult(); result != nil {
+			call.Result = result
+		} else if execution.Status == "failed" && execution.Error != "" {
+			call.Result = map[string]interface{}{"error": execution.Error}
+		}
+	}
+}
+
+// enrichToolCalls fills in the execution status and result of each tool call
+// the way the WebSocket events report them. Calls saved before they ran are
+// matched against the conversation's kept tool results.
+func (app *App) enrichToolCalls(conversationID string, calls []ToolCall) {
+	for i := range calls {
+		call := &calls[i]
+
+		if call.Status == "" || call.Status == "pending" || call.Status == "executing" {
+			if app.ToolRegistry != nil {
+				if store := app.ToolRegistry.Results(); store != nil {
+					if result, found := store.Get(conversationID, call.ID); found {
+						call.Status = "completed"
+						if result.Status == "failed" || result.Status == "error" {
+							call.Status = "failed"
+							call.Error = result.Error
+						}
+						call.Result = result
+						continue
+					}
+				}
+			}
+			if call.Status == "" {
+				call.Status = "pending"
+			}
+			continue
+		}
+
+		// Failed calls are saved with {"error": "..."} as their result
+		if call.Status == "failed" && call.Error == "" {
+			if result, ok := call.Result.(map[string]interface{}); ok {
+				if message, ok := result["error"].(string); ok {
+					call.Error = message
+					call.Result = nil
+				}
+			}
+		}
+	}
+}
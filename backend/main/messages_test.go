@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+func TestMessageFromRowDecodesJSONBAndTimestamps(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("WIB", 7*3600))
+	toolCalls := `[
+		{"id":"call-done","type":"function","function":{"name":"database_query","arguments":{"query":"SELECT 1"}},"status":"completed","result":{"success":true}},
+		{"id":"call-failed","type":"function","function":{"name":"database_query","arguments":{}},"status":"failed","result":{"error":"permission denied"}},
+		{"id":"call-kept","type":"function","function":{"name":"create_chart","arguments":{}},"status":"pending"},
+		{"id":"call-unknown","type":"function","function":{"name":"system_info","arguments":{}}}
+	]`
+
+	rows := map[string]db.Row{
+		"jsonb bytes and native timestamp": {Values: []db.Value{
+			db.NewTextValue("message-1"),
+			db.NewTextValue("conversation-1"),
+			db.NewTextValue("assistant"),
+			db.NewTextValue("Here you go"),
+			db.NewBinaryValue([]byte(`{"model":"gpt-4o"}`)),
+			db.NewBinaryValue([]byte(toolCalls)),
+			db.NewTimestampValue(createdAt),
+		}},
+		"text json and text timestamp": {Values: []db.Value{
+			db.NewTextValue("message-1"),
+			db.NewTextValue("conversation-1"),
+			db.NewTextValue("assistant"),
+			db.NewTextValue("Here you go"),
+			db.NewTextValue(`{"model":"gpt-4o"}`),
+			db.NewTextValue(toolCalls),
+			db.NewTextValue("2024-03-01 05:30:00"),
+		}},
+	}
+
+	registry := tools.NewDefaultToolRegistry()
+	registry.Results().Put("conversation-1", "call-kept", &tools.ToolResult{Status: "completed", Data: map[string]interface{}{"point_count": 3}})
+	app := &App{ToolRegistry: registry}
+
+	for name, row := range rows {
+		msg, _, ok := messageFromRow(row)
+		if !ok {
+			t.Fatalf("%s: expected the row to map", name)
+		}
+		app.enrichToolCalls(msg.ConversationID, msg.ToolCalls)
+
+		if msg.CreatedAt != "2024-03-01T05:30:00Z" {
+			t.Errorf("%s: expected RFC3339 created_at, got %q", name, msg.CreatedAt)
+		}
+		if msg.Metadata["model"] != "gpt-4o" {
+			t.Errorf("%s: expected metadata to decode, got %v", name, msg.Metadata)
+		}
+		if len(msg.ToolCalls) != 4 {
+			t.Fatalf("%s: expected 4 tool calls, got %d", name, len(msg.ToolCalls))
+		}
+
+		want := []struct{ status, err string }{
+			{"completed", ""},
+			{"failed", "permission denied"},
+			{"completed", ""},
+			{"pending", ""},
+		}
+		for i, call := range msg.ToolCalls {
+			if call.Status != want[i].status || call.Error != want[i].err {
+				t.Errorf("%s: %s: expected %s %q, got %s %q", name, call.ID, want[i].status, want[i].err, call.Status, call.Error)
+			}
+		}
+		if result, _ := json.Marshal(msg.ToolCalls[2].Result); string(result) != `{"status":"completed","data":{"point_count":3}}` {
+			t.Errorf("%s: expected the kept result to be attached, got %s", name, result)
+		}
+	}
+
+	if _, _, ok := messageFromRow(db.Row{Values: rows["text json and text timestamp"].Values[:6]}); ok {
+		t.Error("Expected a row without created_at to be rejected")
+	}
+}
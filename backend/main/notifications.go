@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/notify"
+	"zlay-backend/internal/tools"
+)
+
+const (
+	defaultNotifications = 50
+	maxNotifications     = 500
+)
+
+type notificationSettingsRequest struct {
+	Email      string   `json:"email"`
+	EventTypes []string `json:"event_types"`
+}
+
+// getNotificationSettingsHandler returns where a client's operator emails go
+func (app *App) getNotificationSettingsHandler(c *gin.Context) {
+	settings, err := notify.GetSettings(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Param("id"))
+	if errors.Is(err, notify.ErrSettingsNotFound) {
+		apierror.Respond(c, apierror.CodeNotificationSettingsNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// putNotificationSettingsHandler sets a client's notification email and event types
+func (app *App) putNotificationSettingsHandler(c *gin.Context) {
+	var req notificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+
+	row, err := app.ZDB.QueryRow(c.Request.Context(), "SELECT COUNT(*) FROM clients WHERE id = $1", c.Param("id"))
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if count, _ := row.Values[0].AsInt64(); count == 0 {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return
+	}
+
+	settings := &notify.Settings{ClientID: c.Param("id"), Email: req.Email, EventTypes: req.EventTypes}
+	err = notify.SaveSettings(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, settings)
+	var invalid *notify.SettingsError
+	if errors.As(err, &invalid) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": invalid.Field, "reason": invalid.Reason})
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// deleteNotificationSettingsHandler turns a client's operator emails off
+func (app *App) deleteNotificationSettingsHandler(c *gin.Context) {
+	err := notify.DeleteSettings(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Param("id"))
+	if errors.Is(err, notify.ErrSettingsNotFound) {
+		apierror.Respond(c, apierror.CodeNotificationSettingsNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification settings deleted successfully"})
+}
+
+// getNotificationsHandler returns the most recent operator emails, optionally filtered by client_id
+func (app *App) getNotificationsHandler(c *gin.Context) {
+	limit := defaultNotifications
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "limit", "min": 1})
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxNotifications {
+		limit = maxNotifications
+	}
+
+	notifications, err := notify.ListNotifications(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Query("client_id"), limit)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+}
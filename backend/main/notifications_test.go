@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAdminNotificationSettings(t *testing.T) {
+	app := newSessionsTestApp(t)
+	router := newSessionsTestRouter(app)
+	router.GET("/api/admin/clients/:id/notification-settings", app.adminMiddleware(), app.getNotificationSettingsHandler)
+	router.PUT("/api/admin/clients/:id/notification-settings", app.adminMiddleware(), app.putNotificationSettingsHandler)
+	router.DELETE("/api/admin/clients/:id/notification-settings", app.adminMiddleware(), app.deleteNotificationSettingsHandler)
+	router.GET("/api/admin/notifications", app.adminMiddleware(), app.getNotificationsHandler)
+
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+	path := "/api/admin/clients/" + tenantClientID + "/notification-settings"
+
+	if w := tenancyRequest(router, rootToken, "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 before any settings, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, aliceToken, "PUT", path, `{"email": "ops@example.com"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a regular user to be refused, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "PUT", "/api/admin/clients/unknown/notification-settings", `{"email": "ops@example.com"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown client, got %d", w.Code)
+	}
+	for body, field := range map[string]string{
+		`{"email": "not an address"}`:                                  "email",
+		`{"email": "ops@example.com", "event_types": ["llm_failure"]}`: "event_types",
+	} {
+		w := tenancyRequest(router, rootToken, "PUT", path, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("%s: expected 400 for %s, got %d: %s", body, field, w.Code, w.Body.String())
+		}
+	}
+
+	w := tenancyRequest(router, rootToken, "PUT", path, `{"email": "Ops <ops@example.com>", "event_types": ["webhook_failed"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = tenancyRequest(router, rootToken, "GET", path, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"email":"ops@example.com"`) ||
+		!strings.Contains(w.Body.String(), `"event_types":["webhook_failed"]`) {
+		t.Errorf("Expected the saved settings, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := tenancyRequest(router, rootToken, "GET", "/api/admin/notifications?client_id="+tenantClientID, ""); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"notifications":[]`) {
+		t.Errorf("Expected an empty notification log, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := tenancyRequest(router, rootToken, "DELETE", path, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 deleting, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "DELETE", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting twice, got %d", w.Code)
+	}
+}
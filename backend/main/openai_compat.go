@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/apikeys"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/llm"
+	"zlay-backend/internal/openaicompat"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/usage"
+	"zlay-backend/internal/websocket"
+)
+
+// openAIErrorTypes are the codes OpenAI reports with a type other than the
+// one of their status
+var openAIErrorTypes = map[string]string{
+	apierror.CodeTokenBudgetExhausted: openaicompat.TypeInsufficientQuota,
+}
+
+// openAIError converts an error code to the OpenAI envelope, with the
+// lower-cased code and the message in the request's language
+func openAIError(c *gin.Context, code string, details map[string]interface{}) *openaicompat.Error {
+	status := apierror.Status(code)
+	errorType, found := openAIErrorTypes[code]
+	if !found {
+		errorType = openaicompat.TypeForStatus(status)
+	}
+	message := apierror.New(code, apierror.Language(c), details).Message
+	return openaicompat.NewError(status, errorType, "", strings.ToLower(code), message)
+}
+
+// abortOpenAI is apierror.Abort for the OpenAI-compatible API
+func abortOpenAI(c *gin.Context, code string, details map[string]interface{}) {
+	err := openAIError(c, code, details)
+	c.AbortWithStatusJSON(err.Status, err.Envelope())
+}
+
+// openAIAuthMiddleware authenticates /v1 requests. Only API keys are accepted,
+// and failures are reported in the OpenAI error envelope.
+func (app *App) openAIAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret, ok := bearerAPIKey(c)
+		if !ok {
+			abortOpenAI(c, apierror.CodeAPIKeyInvalid, nil)
+			return
+		}
+		app.authenticateAPIKey(c, secret, abortOpenAI)
+	}
+}
+
+// chatCompletionsHandler serves POST /v1/chat/completions with the key's
+// client LLM. A request must fit the client's daily token budget and takes
+// one of its concurrent stream slots, like a WebSocket reply; its tokens are
+// recorded in token_usage whether or not it completes.
+func (app *App) chatCompletionsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	scope, ok := apiKeyScope(c)
+	if !ok {
+		abortOpenAI(c, apierror.CodeAPIKeyInvalid, nil)
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortOpenAI(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	req, invalid := openaicompat.ParseRequest(body)
+	if invalid != nil {
+		c.JSON(invalid.Status, invalid.Envelope())
+		return
+	}
+
+	configCtx, cancel := context.WithTimeout(ctx, app.Config.LLMConfigTimeout)
+	clientConfig, err := app.ClientConfigCache.GetClientConfig(configCtx, scope.ClientID)
+	cancel()
+	if err != nil {
+		abortOpenAI(c, websocket.LLMConfigErrorCode(err), nil)
+		return
+	}
+
+	// Other models than the client's own must be on its allowlist
+	model, override := clientConfig.LLMClient.GetModel(), ""
+	if req.Model != "" && req.Model != model {
+		if err := clientConfig.ValidateModelSettings(chat.ModelSettings{Model: &req.Model}); err != nil {
+			notFound := openaicompat.NewError(http.StatusNotFound, openaicompat.TypeInvalidRequest, "model", "model_not_found",
+				fmt.Sprintf("The model '%s' does not exist or you do not have access to it.", req.Model))
+			c.JSON(notFound.Status, notFound.Envelope())
+			return
+		}
+		model, override = req.Model, req.Model
+	}
+	llmReq := req.LLMRequest(override)
+
+	budget, err := usage.DailyBudget(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, scope.ClientID, time.Now())
+	if err != nil {
+		log.Printf("Failed to check the token budget of client %s: %v", scope.ClientID, err)
+		abortOpenAI(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if budget.Exhausted() {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(budget.ResetsAt).Seconds()))))
+		abortOpenAI(c, apierror.CodeTokenBudgetExhausted, map[string]interface{}{
+			"limit":     budget.Limit,
+			"resets_at": budget.ResetsAt.Format(time.RFC3339),
+		})
+		return
+	}
+	// A request without max_tokens could otherwise run past the budget
+	if !budget.Unlimited && (llmReq.MaxTokens == 0 || int64(llmReq.MaxTokens) > budget.Remaining()) {
+		llmReq.MaxTokens = int(budget.Remaining())
+	}
+
+	release, err := app.acquireCompletionSlot(ctx, scope.ClientID, clientConfig.MaxConcurrentStreams)
+	if err != nil {
+		app.abortCompletion(c, scope.ClientID, err)
+		return
+	}
+	defer release()
+
+	if req.Stream {
+		app.streamChatCompletion(c, scope, clientConfig, req, llmReq, model)
+		return
+	}
+
+	llmCtx, llmCancel := context.WithTimeout(ctx, app.Config.LLMRequestTimeout)
+	defer llmCancel()
+
+	created := time.Now()
+	response, err := clientConfig.LLMClient.Chat(llmCtx, llmReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Chat completion cancelled by client %s", scope.ClientID)
+			return
+		}
+		log.Printf("Chat completion failed for client %s: %v", scope.ClientID, err)
+		app.abortCompletion(c, scope.ClientID, err)
+		return
+	}
+
+	completion := openaicompat.NewCompletion(openaicompat.NewID(), model, created, response)
+	estimated := completion.Usage.TotalTokens == 0
+	if estimated {
+		completion.Usage.TotalTokens = req.EstimateTokens(response.Content)
+	}
+	app.recordCompletionUsage(ctx, scope, model, completion.Usage, estimated)
+	c.JSON(http.StatusOK, completion)
+}
+
+// streamChatCompletion sends the completion as OpenAI chunks, one server-sent
+// event each, ending with data: [DONE]. Headers wait for the first chunk, so
+// a request the provider refuses outright still gets an error status. Like
+// POST /api/chat, LLMRequestTimeout bounds idle time rather than the stream.
+func (app *App) streamChatCompletion(c *gin.Context, scope *apikeys.Scope, clientConfig *websocket.ClientConfig, req *openaicompat.Request, llmReq *llm.LLMRequest, model string) {
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	defer cancel(nil)
+
+	idle := time.AfterFunc(app.Config.LLMRequestTimeout, func() { cancel(errStreamIdle) })
+	defer idle.Stop()
+
+	stream := openaicompat.NewStream(openaicompat.NewID(), model, time.Now(), req.IncludeUsage)
+	var reply strings.Builder
+	started, finished := false, false
+	completionUsage := openaicompat.Usage{}
+	estimated := false
+	err := clientConfig.LLMClient.StreamChat(ctx, llmReq, func(chunk *llm.StreamingChunk) error {
+		idle.Reset(app.Config.LLMRequestTimeout)
+		if !started {
+			started = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Header("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+			c.Status(http.StatusOK)
+		}
+
+		reply.WriteString(chunk.Content)
+		if chunk.Done {
+			finished = true
+			completionUsage.TotalTokens = chunk.TokensUsed
+			estimated = chunk.Estimated
+		}
+		for _, out := range stream.Chunks(chunk) {
+			if err := writeSSEData(c, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err == nil && finished {
+		if completionUsage.TotalTokens == 0 {
+			completionUsage.TotalTokens, estimated = req.EstimateTokens(reply.String()), true
+		}
+		app.recordCompletionUsage(ctx, scope, model, completionUsage, estimated)
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+		c.Writer.Flush()
+		return
+	}
+	// A stream cut short still used what it generated
+	if started {
+		app.recordCompletionUsage(ctx, scope, model, openaicompat.Usage{TotalTokens: req.EstimateTokens(reply.String())}, true)
+	}
+	if c.Request.Context().Err() != nil {
+		log.Printf("Chat completion stream cancelled by client %s", scope.ClientID)
+		return
+	}
+	if err == nil {
+		err = errors.New("stream ended without a final chunk")
+	}
+	if errors.Is(context.Cause(ctx), errStreamIdle) {
+		err = errStreamIdle
+	}
+	log.Printf("Chat completion stream failed for client %s: %v", scope.ClientID, err)
+	if !started {
+		app.abortCompletion(c, scope.ClientID, err)
+		return
+	}
+	// Headers are already sent, so the failure is reported as an error event
+	code, details := app.completionErrorCode(scope.ClientID, err)
+	writeSSEData(c, openAIError(c, code, details).Envelope())
+}
+
+// acquireCompletionSlot takes one of the client's concurrent stream slots,
+// refusing with a *llm.RateLimitedError during its rate-limit cooldown
+func (app *App) acquireCompletionSlot(ctx context.Context, clientID string, limit int) (func(), error) {
+	if app.StreamLimiter == nil {
+		return func() {}, nil
+	}
+	if remaining := app.StreamLimiter.CooldownRemaining(clientID); remaining > 0 {
+		return nil, &llm.RateLimitedError{RetryAfter: remaining, Err: errors.New("waiting out the LLM provider's rate limit")}
+	}
+	return app.StreamLimiter.Acquire(ctx, clientID, limit, nil)
+}
+
+// completionErrorCode maps a failed completion to an error code. A provider
+// rate limit starts the client's cooldown when LLM_RATE_LIMIT_COOLDOWN is set.
+func (app *App) completionErrorCode(clientID string, err error) (string, map[string]interface{}) {
+	switch {
+	case errors.Is(err, chat.ErrQueueFull):
+		return apierror.CodeQueueFull, nil
+	case errors.Is(err, chat.ErrQueueTimeout):
+		return apierror.CodeQueueTimeout, nil
+	}
+	if rateLimited, ok := llmRateLimited(clientID, err); ok {
+		if app.Config.LLMRateLimitCooldown && app.StreamLimiter != nil {
+			app.StreamLimiter.Cooldown(clientID, rateLimited.Delay())
+		}
+		return apierror.CodeLLMRateLimited, map[string]interface{}{"retry_after_seconds": rateLimited.RetryAfterSeconds()}
+	}
+	return apierror.CodeLLMRequestFailed, nil
+}
+
+// abortCompletion responds to a completion that failed before any output
+func (app *App) abortCompletion(c *gin.Context, clientID string, err error) {
+	code, details := app.completionErrorCode(clientID, err)
+	if retryAfter, ok := details["retry_after_seconds"]; ok {
+		c.Header("Retry-After", fmt.Sprint(retryAfter))
+	}
+	abortOpenAI(c, code, details)
+}
+
+// recordCompletionUsage stores a completion's tokens against the key's
+// client, even when the request that used them was cancelled
+func (app *App) recordCompletionUsage(ctx context.Context, scope *apikeys.Scope, model string, completionUsage openaicompat.Usage, estimated bool) {
+	entry := usage.Entry{
+		ClientID:         scope.ClientID,
+		UserID:           scope.UserID,
+		APIKeyID:         scope.KeyID,
+		ProjectID:        scope.ProjectID,
+		Model:            model,
+		PromptTokens:     completionUsage.PromptTokens,
+		CompletionTokens: completionUsage.CompletionTokens,
+		TotalTokens:      completionUsage.TotalTokens,
+		Estimated:        estimated,
+	}
+	if err := usage.Record(context.WithoutCancel(ctx), &tools.ZlayDBAdapter{DB: app.ZDB}, entry, time.Now()); err != nil {
+		log.Printf("Failed to record %d tokens of client %s: %v", entry.TotalTokens, scope.ClientID, err)
+	}
+}
+
+// writeSSEData writes one server-sent event with a JSON payload and flushes it
+func writeSSEData(c *gin.Context, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/websocket"
+)
+
+type openAITestServer struct {
+	app    *App
+	db     tools.DBConnection
+	server *httptest.Server
+	keyID  string
+	secret string
+}
+
+// newOpenAITestServer serves /v1/chat/completions for client A, whose LLM
+// client is the given fake, with a client-wide API key of user A
+func newOpenAITestServer(t *testing.T, client *streamingLLMClient) *openAITestServer {
+	t.Helper()
+
+	app := newAPIKeyTestApp(t)
+	app.ClientConfigCache = websocket.NewClientConfigCache(nil, app.Config)
+	app.ClientConfigCache.SetClientConfig(&websocket.ClientConfig{
+		ClientID:      "client-a",
+		LLMClient:     client,
+		Model:         "fake-model",
+		AllowedModels: []string{"fake-model-mini"},
+	})
+	app.StreamLimiter = chat.NewStreamLimiter(0, 0)
+
+	router := newAPIKeyTestRouter(app)
+	router.POST("/v1/chat/completions", app.openAIAuthMiddleware(), app.chatCompletionsHandler)
+	keyID, secret := createTestAPIKey(t, router, "/api/settings/api-keys")
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &openAITestServer{app: app, db: &tools.ZlayDBAdapter{DB: app.ZDB}, server: server, keyID: keyID, secret: secret}
+}
+
+// client is an official OpenAI SDK client pointed at the server
+func (s *openAITestServer) client() *openai.Client {
+	client := openai.NewClient(
+		option.WithBaseURL(s.server.URL+"/v1/"),
+		option.WithAPIKey(s.secret),
+		option.WithMaxRetries(0),
+	)
+	return &client
+}
+
+func (s *openAITestServer) post(t *testing.T, key, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", s.server.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeOpenAIError decodes an error response, checking it has the envelope
+func decodeOpenAIError(t *testing.T, resp *http.Response) (errorType, code string, param *string) {
+	t.Helper()
+	var envelope struct {
+		Error *struct {
+			Message string  `json:"message"`
+			Type    string  `json:"type"`
+			Param   *string `json:"param"`
+			Code    string  `json:"code"`
+		} `json:"error"`
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil || envelope.Error.Message == "" {
+		t.Fatalf("Expected an OpenAI error envelope, got %s", body)
+	}
+	return envelope.Error.Type, envelope.Error.Code, envelope.Error.Param
+}
+
+func TestChatCompletionsWithOpenAIClient(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{deltas: []string{"Hello", " world"}})
+
+	var raw *http.Response
+	completion, err := s.client().Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "fake-model",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("Be brief"), openai.UserMessage("Hi")},
+	}, option.WithResponseInto(&raw))
+	if err != nil {
+		t.Fatalf("Completion failed: %v", err)
+	}
+	if completion.Object != "chat.completion" || !strings.HasPrefix(completion.ID, "chatcmpl-") || completion.Model != "fake-model" ||
+		len(completion.Choices) != 1 || completion.Choices[0].Message.Content != "Hello world" ||
+		completion.Choices[0].Message.Role != "assistant" || completion.Choices[0].FinishReason != "stop" || completion.Usage.TotalTokens != 7 {
+		t.Errorf("Unexpected completion %+v", completion)
+	}
+	for _, field := range []string{"id", "object", "created", "model", "choices", "usage"} {
+		if !rawFieldPresent(completion.RawJSON(), field) {
+			t.Errorf("Expected %q in the completion: %s", field, completion.RawJSON())
+		}
+	}
+	if raw.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", raw.StatusCode)
+	}
+
+	var keyID, model string
+	var total int
+	var estimated bool
+	if err := s.db.QueryRow(context.Background(), "SELECT api_key_id, model, total_tokens, estimated FROM token_usage").
+		Scan(&keyID, &model, &total, &estimated); err != nil {
+		t.Fatalf("Expected a token usage row: %v", err)
+	}
+	if keyID != s.keyID || model != "fake-model" || total != 7 || estimated {
+		t.Errorf("Unexpected usage row: key %s, model %s, %d tokens, estimated %v", keyID, model, total, estimated)
+	}
+}
+
+func rawFieldPresent(raw, field string) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal([]byte(raw), &fields) != nil {
+		return false
+	}
+	_, found := fields[field]
+	return found
+}
+
+func TestChatCompletionsStreamWithOpenAIClient(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{deltas: []string{"Hel", "lo"}})
+
+	stream := s.client().Chat.Completions.NewStreaming(context.Background(), openai.ChatCompletionNewParams{
+		Messages:      []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
+	})
+	var reply strings.Builder
+	var roles, finishReasons []string
+	var usage int64
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunk.Object != "chat.completion.chunk" || chunk.Model != "fake-model" {
+			t.Errorf("Unexpected chunk %s", chunk.RawJSON())
+		}
+		for _, choice := range chunk.Choices {
+			reply.WriteString(choice.Delta.Content)
+			if choice.Delta.Role != "" {
+				roles = append(roles, choice.Delta.Role)
+			}
+			if choice.FinishReason != "" {
+				finishReasons = append(finishReasons, choice.FinishReason)
+			}
+		}
+		if len(chunk.Choices) == 0 {
+			usage = chunk.Usage.TotalTokens
+		}
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if reply.String() != "Hello" || strings.Join(roles, ",") != "assistant" || strings.Join(finishReasons, ",") != "stop" || usage != 7 {
+		t.Errorf("Unexpected stream: reply %q, roles %v, finish reasons %v, usage %d", reply.String(), roles, finishReasons, usage)
+	}
+
+	resp := s.post(t, s.secret, `{"messages": [{"role": "user", "content": "Hi"}], "stream": true}`)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/event-stream" || !strings.HasSuffix(string(body), "data: [DONE]\n\n") {
+		t.Errorf("Expected an event stream ending with [DONE], got %q: %s", resp.Header.Get("Content-Type"), body)
+	}
+	if strings.Contains(string(body), `"usage"`) {
+		t.Errorf("Expected no usage chunk without include_usage: %s", body)
+	}
+
+	var rows int
+	s.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM token_usage WHERE client_id = 'client-a' AND estimated").Scan(&rows)
+	if rows != 2 {
+		t.Errorf("Expected both streams recorded as estimated, got %d", rows)
+	}
+}
+
+func TestChatCompletionsRejectsUnsupportedParameters(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{deltas: []string{"Hello"}})
+
+	_, err := s.client().Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:     "fake-model",
+		Messages:  []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+		LogitBias: map[string]int64{"50256": -100},
+	})
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "unsupported_parameter" ||
+		apiErr.Param != "logit_bias" || apiErr.Type != "invalid_request_error" {
+		t.Errorf("Expected logit_bias rejected, got %v", err)
+	}
+
+	_, err = s.client().Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "fake-model",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+		N:        openai.Int(2),
+	})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "unsupported_value" || apiErr.Param != "n" {
+		t.Errorf("Expected n=2 rejected, got %v", err)
+	}
+
+	var rows int
+	s.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM token_usage").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("Expected no usage for rejected requests, got %d rows", rows)
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{deltas: []string{"Hello"}})
+	hi := `{"messages": [{"role": "user", "content": "Hi"}]}`
+
+	for _, tc := range []struct {
+		name, key string
+	}{
+		{"no key", ""},
+		{"session token", "token-a"},
+		{"unknown key", "zlay_" + strings.Repeat("0", 40)},
+	} {
+		resp := s.post(t, tc.key, hi)
+		if errorType, _, _ := decodeOpenAIError(t, resp); resp.StatusCode != http.StatusUnauthorized || errorType != "authentication_error" {
+			t.Errorf("%s: expected 401 authentication_error, got %d %s", tc.name, resp.StatusCode, errorType)
+		}
+	}
+
+	resp := s.post(t, s.secret, `{"model": "gpt-5", "messages": [{"role": "user", "content": "Hi"}]}`)
+	if _, code, param := decodeOpenAIError(t, resp); resp.StatusCode != http.StatusNotFound || code != "model_not_found" || param == nil || *param != "model" {
+		t.Errorf("Expected a model off the allowlist not found, got %d %s", resp.StatusCode, code)
+	}
+	resp = s.post(t, s.secret, `{"model": "fake-model-mini", "messages": [{"role": "user", "content": "Hi"}]}`)
+	var completion struct {
+		Model string `json:"model"`
+	}
+	json.NewDecoder(resp.Body).Decode(&completion)
+	if resp.StatusCode != http.StatusOK || completion.Model != "fake-model-mini" {
+		t.Errorf("Expected an allowed model served, got %d %s", resp.StatusCode, completion.Model)
+	}
+}
+
+func TestChatCompletionsDailyTokenBudget(t *testing.T) {
+	client := &streamingLLMClient{deltas: []string{"Hello"}}
+	s := newOpenAITestServer(t, client)
+	ctx := context.Background()
+	hi := `{"messages": [{"role": "user", "content": "Hi"}]}`
+
+	if _, err := s.app.ZDB.Execute(ctx, "UPDATE clients SET daily_token_budget = 10 WHERE id = 'client-a'"); err != nil {
+		t.Fatalf("Failed to set budget: %v", err)
+	}
+	if resp := s.post(t, s.secret, hi); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 within budget, got %d", resp.StatusCode)
+	}
+	// 7 of 10 tokens used; the next completion is still allowed and reaches the limit
+	if resp := s.post(t, s.secret, `{"max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 with budget left, got %d", resp.StatusCode)
+	}
+	// Replies are capped to what is left, with or without max_tokens
+	if len(client.maxTokens) != 2 || client.maxTokens[0] != 10 || client.maxTokens[1] != 3 {
+		t.Errorf("Expected max_tokens capped to the remaining budget, got %v", client.maxTokens)
+	}
+
+	resp := s.post(t, s.secret, hi)
+	errorType, code, _ := decodeOpenAIError(t, resp)
+	if resp.StatusCode != http.StatusTooManyRequests || errorType != "insufficient_quota" || code != "token_budget_exhausted" {
+		t.Errorf("Expected 429 insufficient_quota, got %d %s %s", resp.StatusCode, errorType, code)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After until the budget resets")
+	}
+
+	// Other clients' usage does not count
+	if _, err := s.app.ZDB.Execute(ctx, "UPDATE token_usage SET client_id = 'client-b'"); err != nil {
+		t.Fatalf("Failed to move usage: %v", err)
+	}
+	if resp := s.post(t, s.secret, hi); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 once client A's usage is gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestChatCompletionsRateLimits(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{deltas: []string{"Hello"}})
+
+	s.app.StreamLimiter.Cooldown("client-a", time.Minute)
+	resp := s.post(t, s.secret, `{"messages": [{"role": "user", "content": "Hi"}]}`)
+	errorType, code, _ := decodeOpenAIError(t, resp)
+	if resp.StatusCode != http.StatusTooManyRequests || errorType != "rate_limit_error" || code != "llm_rate_limited" {
+		t.Errorf("Expected 429 rate_limit_error during the cooldown, got %d %s %s", resp.StatusCode, errorType, code)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After during the cooldown")
+	}
+}
+
+func TestChatCompletionsStreamFailsBeforeOutput(t *testing.T) {
+	s := newOpenAITestServer(t, &streamingLLMClient{err: errors.New("upstream exploded")})
+
+	resp := s.post(t, s.secret, `{"messages": [{"role": "user", "content": "Hi"}], "stream": true}`)
+	errorType, code, _ := decodeOpenAIError(t, resp)
+	if resp.StatusCode != http.StatusBadGateway || errorType != "server_error" || code != "llm_request_failed" {
+		t.Errorf("Expected 502 before any output, got %d %s %s", resp.StatusCode, errorType, code)
+	}
+}
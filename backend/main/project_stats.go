@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"zlay-backend/internal/db"
+)
+
+// projectStatsTTL is how long a user's project stats are reused by GET /api/projects
+const projectStatsTTL = 30 * time.Second
+
+// ProjectStats are the activity counts shown next to a project in the project picker
+type ProjectStats struct {
+	ConversationCount int64   `json:"conversation_count"`
+	MessageCount      int64   `json:"message_count"`    // User and assistant messages
+	LastActivityAt    *string `json:"last_activity_at"` // Latest conversation update or message; nil without conversations
+}
+
+// projectStatsCache keeps each user's project stats for a short while, keyed by
+// user and client, so switching projects does not aggregate messages every time
+type projectStatsCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]projectStatsEntry
+}
+
+type projectStatsEntry struct {
+	stats    map[string]ProjectStats // By project ID
+	loadedAt time.Time
+}
+
+func newProjectStatsCache(ttl time.Duration) *projectStatsCache {
+	return &projectStatsCache{ttl: ttl, now: time.Now, entries: make(map[string]projectStatsEntry)}
+}
+
+// get returns the cached stats of the user's projects if they are fresh
+func (c *projectStatsCache) get(userID, clientID string) (map[string]ProjectStats, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.entries[userID+"|"+clientID]
+	if !exists || c.now().Sub(entry.loadedAt) >= c.ttl {
+		return nil, false
+	}
+	return entry.stats, true
+}
+
+// put caches the stats of the user's projects, dropping expired entries
+func (c *projectStatsCache) put(userID, clientID string, stats map[string]ProjectStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.loadedAt) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[userID+"|"+clientID] = projectStatsEntry{stats: stats, loadedAt: now}
+}
+
+// projectStats returns the stats of every active project the user owns in the
+// client, from the cache when it is fresh. Projects without conversations are
+// missing from the map. A nil App.ProjectStats loads them uncached.
+func (app *App) projectStats(ctx context.Context, userID, clientID string) (map[string]ProjectStats, error) {
+	if app.ProjectStats != nil {
+		if stats, ok := app.ProjectStats.get(userID, clientID); ok {
+			return stats, nil
+		}
+	}
+
+	stats, err := loadProjectStats(ctx, app.ZDB, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if app.ProjectStats != nil {
+		app.ProjectStats.put(userID, clientID, stats)
+	}
+	return stats, nil
+}
+
+// loadProjectStats aggregates the conversations and messages of all the user's
+// projects in one grouped query rather than a query per project
+func loadProjectStats(ctx context.Context, zdb *db.Database, userID, clientID string) (map[string]ProjectStats, error) {
+	resultSet, err := zdb.Query(ctx,
+		`SELECT c.project_id, COUNT(DISTINCT c.id), COUNT(m.id), MAX(c.updated_at), MAX(m.created_at)
+		FROM projects p
+		JOIN users u ON u.id = p.user_id
+		JOIN conversations c ON c.project_id = p.id AND c.deleted_at IS NULL
+		LEFT JOIN messages m ON m.conversation_id = c.id AND m.role IN ('user', 'assistant')
+		WHERE p.user_id = $1 AND u.client_id = $2 AND p.is_active = true
+		GROUP BY c.project_id`,
+		userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]ProjectStats, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 5 {
+			continue
+		}
+		projectID, ok := row.Values[0].AsString()
+		if !ok {
+			continue
+		}
+		var projectStats ProjectStats
+		projectStats.ConversationCount, _ = row.Values[1].AsInt64()
+		projectStats.MessageCount, _ = row.Values[2].AsInt64()
+
+		// The later of the latest conversation update and the latest message
+		var lastActivity time.Time
+		for _, value := range row.Values[3:5] {
+			if at, ok := value.AsTimestamp(); ok && at.Time.After(lastActivity) {
+				lastActivity = at.Time
+			}
+		}
+		if !lastActivity.IsZero() {
+			formatted := lastActivity.UTC().Format(time.RFC3339)
+			projectStats.LastActivityAt = &formatted
+		}
+		stats[projectID] = projectStats
+	}
+	return stats, nil
+}
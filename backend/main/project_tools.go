@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+)
+
+type ProjectTool struct {
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Category    string                         `json:"category"`
+	Parameters  map[string]tools.ToolParameter `json:"parameters"`
+	Enabled     bool                           `json:"enabled"`
+}
+
+type UpdateProjectToolRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type ExecuteProjectToolRequest struct {
+	Params         map[string]interface{} `json:"params"`
+	ConversationID string                 `json:"conversation_id"` // Attach the result to this conversation
+	Force          bool                   `json:"force"`           // Run a tool that can modify data; editors and above only
+}
+
+func (app *App) getProjectToolsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, buildProjectTools(app.ToolRegistry, projectID))
+}
+
+func (app *App) updateProjectToolHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get current user
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+	toolName := c.Param("name")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var req UpdateProjectToolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+
+	if err := app.ToolRegistry.SetToolEnabled(ctx, projectID, toolName, *req.Enabled); err != nil {
+		if errors.Is(err, tools.ErrToolNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tool not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tool"})
+		return
+	}
+
+	tool, _ := app.ToolRegistry.GetTool(toolName)
+	c.JSON(http.StatusOK, newProjectTool(tool, *req.Enabled))
+}
+
+// executeProjectToolHandler runs a tool of the project for the caller without
+// the LLM. Any project member may run tools; their parameters are checked
+// against the tool's schema first.
+func (app *App) executeProjectToolHandler(c *gin.Context) {
+	user, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var req ExecuteProjectToolRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+			return
+		}
+	}
+
+	toolName := c.Param("name")
+	run, err := chat.RunTool(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, app.ToolRegistry, chat.ToolRunRequest{
+		UserID:         user.ID,
+		ClientID:       user.ClientID,
+		ProjectID:      projectID,
+		ToolName:       toolName,
+		Params:         req.Params,
+		ConversationID: req.ConversationID,
+		Force:          req.Force,
+	})
+	if err != nil {
+		code, details := chat.ToolRunErrorCode(err, toolName)
+		apierror.Respond(c, code, details)
+		return
+	}
+	if run.ConversationID != "" {
+		// Replies must see the result message RunTool added
+		app.HistoryCache.Invalidate(run.ConversationID)
+	}
+	c.JSON(http.StatusOK, gin.H{"run": run})
+}
+
+// authorizeProject checks the caller's role in the :id project. Callers without
+// any role get a 404 so the project's existence is not revealed; viewers asking
+// for more get a 403.
+func (app *App) authorizeProject(c *gin.Context, minimum tools.ProjectRole) (*User, string, bool) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return nil, "", false
+	}
+	projectID := c.Param("id")
+
+	role := tools.RoleNone
+	if user.APIKeyProject == "" || user.APIKeyProject == projectID {
+		role, err = tools.NewDBPermissionChecker(&tools.ZlayDBAdapter{DB: app.ZDB}).GetProjectRole(c.Request.Context(), user.ID, projectID)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return nil, "", false
+		}
+	}
+	switch {
+	case role < tools.RoleViewer:
+		apierror.Respond(c, apierror.CodeProjectNotFound, nil)
+		return nil, "", false
+	case role < minimum:
+		apierror.Respond(c, apierror.CodeForbidden, nil)
+		return nil, "", false
+	}
+	return user, projectID, true
+}
+
+// userOwnsProject checks that an active project belongs to the given user within their client
+func (app *App) userOwnsProject(ctx context.Context, projectID string, user *User) (bool, error) {
+	if user.APIKeyProject != "" && user.APIKeyProject != projectID {
+		return false, nil
+	}
+	_, err := app.ZDB.QueryRow(ctx,
+		`SELECT p.id FROM projects p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.id = $1 AND p.user_id = $2 AND u.client_id = $3 AND p.is_active = true`,
+		projectID, user.ID, user.ClientID)
+	if errors.Is(err, db.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// buildProjectTools lists every registered tool with its enabled flag for a project
+func buildProjectTools(registry tools.ToolRegistry, projectID string) []ProjectTool {
+	registered := registry.ListTools()
+	projectTools := make([]ProjectTool, 0, len(registered))
+	for _, tool := range registered {
+		projectTools = append(projectTools, newProjectTool(tool, registry.IsToolEnabled(projectID, tool.Name())))
+	}
+
+	sort.Slice(projectTools, func(i, j int) bool {
+		return projectTools[i].Name < projectTools[j].Name
+	})
+	return projectTools
+}
+
+func newProjectTool(tool tools.Tool, enabled bool) ProjectTool {
+	return ProjectTool{
+		Name:        tool.Name(),
+		Description: tool.Description(),
+		Category:    tool.GetCategory(),
+		Parameters:  tool.Parameters(),
+		Enabled:     enabled,
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/requestid"
+	"zlay-backend/internal/tools"
+)
+
+type staticToolSettingsStore struct {
+	disabled map[string]bool
+}
+
+func (s *staticToolSettingsStore) GetDisabledTools(ctx context.Context, projectID string) (map[string]bool, error) {
+	return s.disabled, nil
+}
+
+func (s *staticToolSettingsStore) SetToolEnabled(ctx context.Context, projectID, toolName string, enabled bool) error {
+	s.disabled[toolName] = !enabled
+	return nil
+}
+
+func newProjectToolsTestRouter(app *App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/projects/:id/tools", app.getProjectToolsHandler)
+	router.PUT("/api/projects/:id/tools/:name", app.updateProjectToolHandler)
+	return router
+}
+
+func TestProjectToolsHandlersRequireAuth(t *testing.T) {
+	app := &App{ToolRegistry: tools.NewDefaultToolRegistry()}
+	router := newProjectToolsTestRouter(app)
+
+	req, _ := http.NewRequest("GET", "/api/projects/p1/tools", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET tools: expected status 401, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("PUT", "/api/projects/p1/tools/system_info", bytes.NewBufferString(`{"enabled": false}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("PUT tool: expected status 401, got %d", w.Code)
+	}
+}
+
+func TestBuildProjectTools(t *testing.T) {
+	registry := tools.NewDefaultToolRegistry()
+	if err := registry.RegisterTool(&tools.APITool{}); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+	registry.SetSettingsStore(&staticToolSettingsStore{disabled: map[string]bool{"api_request": true}})
+
+	projectTools := buildProjectTools(registry, "p1")
+	if len(projectTools) != 2 {
+		t.Fatalf("Expected 2 tools, got %d", len(projectTools))
+	}
+
+	// Tools are sorted by name
+	if projectTools[0].Name != "api_request" || projectTools[1].Name != "system_info" {
+		t.Errorf("Unexpected tool order: %s, %s", projectTools[0].Name, projectTools[1].Name)
+	}
+	if projectTools[0].Enabled {
+		t.Error("api_request should be reported as disabled")
+	}
+	if !projectTools[1].Enabled {
+		t.Error("system_info should be reported as enabled")
+	}
+	if projectTools[0].Category != "api" || projectTools[0].Description == "" {
+		t.Errorf("Unexpected tool metadata: %+v", projectTools[0])
+	}
+	if _, ok := projectTools[0].Parameters["url"]; !ok {
+		t.Error("Parameter schema should include url")
+	}
+}
+
+func TestExecuteProjectTool(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var project Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Ops"}`), http.StatusCreated, &project)
+	var created struct {
+		Conversation chat.Conversation `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/conversations", `{"project_id": "`+project.ID+`"}`), http.StatusCreated, &created)
+	path := "/api/projects/" + project.ID + "/tools/system_info/execute"
+
+	// Without a conversation the result is only returned
+	var resp struct {
+		Run chat.ToolRun `json:"run"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", path, ""), http.StatusOK, &resp)
+	if resp.Run.Result == nil || resp.Run.Result.Status != "completed" || resp.Run.Result.Data["memory"] == nil ||
+		resp.Run.Params["include_memory"] != true || resp.Run.MessageID != "" {
+		t.Fatalf("Unexpected run: %+v", resp.Run)
+	}
+
+	// With one it is attached as a system message
+	var attached struct {
+		Run chat.ToolRun `json:"run"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", path,
+		`{"params": {"include_memory": false}, "conversation_id": "`+created.Conversation.ID+`"}`), http.StatusOK, &attached)
+	if attached.Run.Result.Data["memory"] != nil || attached.Run.MessageID == "" {
+		t.Fatalf("Unexpected run: %+v", attached.Run)
+	}
+	var history struct {
+		Conversation struct {
+			Messages []Message `json:"messages"`
+		} `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+created.Conversation.ID+"/messages", ""), http.StatusOK, &history)
+	if len(history.Conversation.Messages) != 1 || history.Conversation.Messages[0].Role != "system" ||
+		!strings.Contains(history.Conversation.Messages[0].Content, "system_info") {
+		t.Errorf("Expected the result as a system message, got %+v", history.Conversation.Messages)
+	}
+
+	for _, tc := range []struct {
+		name, path, body, code string
+	}{
+		{"wrong type", path, `{"params": {"include_disk": "yes"}}`, "TOOL_PARAMETERS_INVALID"},
+		{"unknown parameter", path, `{"params": {"verbose": true}}`, "TOOL_PARAMETERS_INVALID"},
+		{"unknown tool", "/api/projects/" + project.ID + "/tools/launch/execute", "", "TOOL_UNAVAILABLE"},
+		{"other project", "/api/projects/missing/tools/system_info/execute", "", "PROJECT_NOT_FOUND"},
+		{"unknown conversation", path, `{"conversation_id": "missing"}`, "CONVERSATION_NOT_FOUND"},
+	} {
+		if w := tenancyRequest(router, token, "POST", tc.path, tc.body); !strings.Contains(w.Body.String(), `"code":"`+tc.code+`"`) {
+			t.Errorf("%s: expected %s, got %d: %s", tc.name, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	// Disabled tools cannot be run
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/projects/"+project.ID+"/tools/system_info", `{"enabled": false}`), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "POST", path, ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected a disabled tool to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// contextCapturingTool records the context each execution runs with
+type contextCapturingTool struct {
+	executed chan context.Context
+}
+
+func (contextCapturingTool) Name() string        { return "capture_context" }
+func (contextCapturingTool) Description() string { return "Records its context" }
+func (contextCapturingTool) GetCategory() string { return "test" }
+func (contextCapturingTool) Parameters() map[string]tools.ToolParameter {
+	return map[string]tools.ToolParameter{}
+}
+func (contextCapturingTool) ValidateAccess(userID, projectID string) bool { return true }
+func (c contextCapturingTool) Execute(ctx context.Context, params map[string]interface{}) (*tools.ToolResult, error) {
+	c.executed <- ctx
+	return &tools.ToolResult{Status: "completed"}, nil
+}
+
+func TestExecuteProjectToolPropagatesRequestID(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	tool := contextCapturingTool{executed: make(chan context.Context, 1)}
+	if err := app.ToolRegistry.RegisterTool(tool); err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var project Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Ops"}`), http.StatusCreated, &project)
+
+	execute := func(path, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set(requestid.Header, requestID)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The ID sent with the request reaches the tool through the chat service and registry
+	w = execute("/api/projects/"+project.ID+"/tools/capture_context/execute", "ticket-1432")
+	if w.Code != http.StatusOK || w.Header().Get(requestid.Header) != "ticket-1432" {
+		t.Fatalf("Expected the ID echoed on success, got %d %q: %s", w.Code, w.Header().Get(requestid.Header), w.Body.String())
+	}
+	if id := requestid.From(<-tool.executed); id != "ticket-1432" {
+		t.Errorf("Expected the tool to run with the request ID, got %q", id)
+	}
+
+	// Error bodies quote it too
+	w = execute("/api/projects/missing/tools/capture_context/execute", "ticket-1433")
+	if !strings.Contains(w.Body.String(), `"request_id":"ticket-1433"`) {
+		t.Errorf("Expected the error to echo the request ID, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestExecuteProjectToolRefusesOtherProjectsDatasources(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var owner, other Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Reports"}`), http.StatusCreated, &owner)
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Ops"}`), http.StatusCreated, &other)
+	var datasource Datasource
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/datasources",
+		`{"project_id": "`+owner.ID+`", "name": "Warehouse", "type": "sqlite", "config": {"path": "warehouse.db"}}`), http.StatusCreated, &datasource)
+
+	// A datasource ID passed straight to a tool of another project is not found there
+	for _, tc := range []struct{ tool, body string }{
+		{"datasource_inspect", `{"params": {"datasource_id": "` + datasource.ID + `"}}`},
+		{"database_query", `{"params": {"datasource_id": "` + datasource.ID + `", "query": "SELECT 1"}}`},
+	} {
+		var resp struct {
+			Run chat.ToolRun `json:"run"`
+		}
+		decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects/"+other.ID+"/tools/"+tc.tool+"/execute", tc.body), http.StatusOK, &resp)
+		if resp.Run.Result == nil || resp.Run.Result.Code != tools.ErrCodeDatasourceNotFound {
+			t.Errorf("%s: expected DATASOURCE_NOT_FOUND, got %+v", tc.tool, resp.Run.Result)
+		}
+	}
+}
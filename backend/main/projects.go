@@ -1,21 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
 )
 
 type Project struct {
-	ID          string `json:"id"`
-	UserID      string `json:"user_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	IsActive    bool   `json:"is_active"`
-	CreatedAt   string `json:"created_at"`
+	ID                  string  `json:"id"`
+	UserID              string  `json:"user_id"`
+	Name                string  `json:"name"`
+	Description         string  `json:"description"`
+	IsActive            bool    `json:"is_active"`
+	DefaultDatasourceID *string `json:"default_datasource_id"` // Used by database tools when a call names no datasource
+	// Messages older than RetentionDays are deleted or redacted as RetentionMode says; nil keeps them
+	RetentionDays         *int    `json:"retention_days"`
+	RetentionMode         *string `json:"retention_mode"`
+	RetentionExemptPinned bool    `json:"retention_exempt_pinned"`
+	CitationsEnabled      bool    `json:"citations_enabled"` // Replies cite the tool results they rely on
+	CreatedAt             string  `json:"created_at"`
+	Version               int64   `json:"version"` // Sent back in If-Match by updates
+	*ProjectStats // Set by the project list unless include_stats=false
 }
 
 type CreateProjectRequest struct {
@@ -24,9 +37,15 @@ type CreateProjectRequest struct {
 }
 
 type UpdateProjectRequest struct {
-	Name        *string `json:"name"`
-	Description *string `json:"description"`
-	IsActive    *bool   `json:"is_active"`
+	Name                *string `json:"name"`
+	Description         *string `json:"description"`
+	IsActive            *bool   `json:"is_active"`
+	DefaultDatasourceID *string `json:"default_datasource_id"` // "" clears the default
+	RetentionDays         *int    `json:"retention_days"` // 0 removes the retention policy
+	RetentionMode         *string `json:"retention_mode"` // delete or redact
+	RetentionExemptPinned *bool   `json:"retention_exempt_pinned"`
+	CitationsEnabled      *bool   `json:"citations_enabled"`
+	Version               *int64  `json:"version"` // Used when no If-Match header is sent
 }
 
 func (app *App) getProjectsHandler(c *gin.Context) {
@@ -38,11 +57,13 @@ func (app *App) getProjectsHandler(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 		return
 	}
-	userID := user.ID
-
 	resultSet, err := app.ZDB.Query(ctx,
-		"SELECT id, user_id, name, description, is_active, created_at FROM projects WHERE user_id = $1 AND is_active = true ORDER BY created_at DESC",
-		userID)
+		`SELECT `+projectColumns+`
+		FROM projects p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.user_id = $1 AND u.client_id = $2 AND p.is_active = true
+		ORDER BY p.created_at DESC`,
+		user.ID, user.ClientID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
 		return
@@ -50,31 +71,23 @@ func (app *App) getProjectsHandler(c *gin.Context) {
 
 	var projects []Project
 	for _, row := range resultSet.Rows {
-		if len(row.Values) < 6 {
+		if len(row.Values) < 12 {
 			continue
 		}
+		projects = append(projects, scanProject(row.Values))
+	}
 
-		var project Project
-		if id, ok := row.Values[0].AsString(); ok {
-			project.ID = id
-		}
-		if userID, ok := row.Values[1].AsString(); ok {
-			project.UserID = userID
-		}
-		if name, ok := row.Values[2].AsString(); ok {
-			project.Name = name
-		}
-		if description, ok := row.Values[3].AsString(); ok {
-			project.Description = description
+	// The picker shows activity per project; callers that do not can skip the aggregation
+	if c.Query("include_stats") != "false" {
+		stats, err := app.projectStats(ctx, user.ID, user.ClientID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project stats"})
+			return
 		}
-		if isActive, ok := row.Values[4].AsBool(); ok {
-			project.IsActive = isActive
+		for i := range projects {
+			projectStats := stats[projects[i].ID]
+			projects[i].ProjectStats = &projectStats
 		}
-		if createdAt, ok := row.Values[5].AsTimestamp(); ok {
-			project.CreatedAt = createdAt.Time.Format(time.RFC3339)
-		}
-
-		projects = append(projects, project)
 	}
 
 	c.JSON(http.StatusOK, projects)
@@ -103,29 +116,26 @@ func (app *App) createProjectHandler(c *gin.Context) {
 	}
 
 	projectID := uuid.New().String()
-	row, err := app.ZDB.QueryRow(ctx,
-		"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP) RETURNING created_at",
-		projectID, userID, req.Name, req.Description)
+	createdAt := time.Now().UTC()
+	_, err = app.ZDB.Execute(ctx,
+		"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ($1, $2, $3, $4, true, $5)",
+		projectID, userID, req.Name, req.Description, createdAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
 		return
 	}
 
-	createdAt, ok := row.Values[0].AsTimestamp()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse timestamp"})
-		return
-	}
-
 	project := Project{
 		ID:          projectID,
 		UserID:      userID,
 		Name:        req.Name,
 		Description: req.Description,
 		IsActive:    true,
-		CreatedAt:   createdAt.Time.Format(time.RFC3339),
+		CreatedAt:   createdAt.Format(time.RFC3339),
+		Version:     1,
 	}
 
+	setETag(c, project.Version)
 	c.JSON(http.StatusCreated, project)
 }
 
@@ -138,54 +148,74 @@ func (app *App) getProjectHandler(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 		return
 	}
-	userID := user.ID
 	projectID := c.Param("id")
 
-	row, err := app.ZDB.QueryRow(ctx,
-		"SELECT id, user_id, name, description, is_active, created_at FROM projects WHERE id = $1 AND user_id = $2 AND is_active = true",
-		projectID, userID)
+	project, err := app.loadProject(ctx, projectID, user)
+	if errors.Is(err, db.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	if len(row.Values) < 6 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
+	setETag(c, project.Version)
+	c.JSON(http.StatusOK, project)
+}
+
+// projectColumns are the columns of projects p that scanProject reads, in order
+const projectColumns = `p.id, p.user_id, p.name, p.description, p.is_active, p.created_at, p.default_datasource_id,
+			p.retention_days, p.retention_mode, p.retention_exempt_pinned, p.citations_enabled, p.version`
+
+// loadProject reads an active project of the user; db.ErrNoRows when there is none
+func (app *App) loadProject(ctx context.Context, projectID string, user *User) (*Project, error) {
+	row, err := app.ZDB.QueryRow(ctx,
+		`SELECT `+projectColumns+`
+		FROM projects p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.id = $1 AND p.user_id = $2 AND u.client_id = $3 AND p.is_active = true`,
+		projectID, user.ID, user.ClientID)
+	if err != nil {
+		return nil, err
 	}
+	if len(row.Values) < 12 {
+		return nil, db.ErrNoRows
+	}
+	project := scanProject(row.Values)
+	return &project, nil
+}
 
+// scanProject reads a row of projectColumns
+func scanProject(values []db.Value) Project {
 	var project Project
-	var ok bool
-	project.ID, ok = row.Values[0].AsString()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse project ID"})
-		return
+	if id, ok := values[0].AsString(); ok {
+		project.ID = id
 	}
-	project.UserID, ok = row.Values[1].AsString()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user ID"})
-		return
+	if userID, ok := values[1].AsString(); ok {
+		project.UserID = userID
 	}
-	project.Name, ok = row.Values[2].AsString()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse project name"})
-		return
+	if name, ok := values[2].AsString(); ok {
+		project.Name = name
 	}
-	project.Description, ok = row.Values[3].AsString()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse project description"})
-		return
+	if description, ok := values[3].AsString(); ok {
+		project.Description = description
 	}
-	project.IsActive, ok = row.Values[4].AsBool()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse active status"})
-		return
+	if isActive, ok := values[4].AsBool(); ok {
+		project.IsActive = isActive
 	}
-	if createdAt, ok := row.Values[5].AsTimestamp(); ok {
+	if createdAt, ok := values[5].AsTimestamp(); ok {
 		project.CreatedAt = createdAt.Time.Format(time.RFC3339)
 	}
-
-	c.JSON(http.StatusOK, project)
+	if datasourceID, ok := values[6].AsString(); ok && datasourceID != "" {
+		project.DefaultDatasourceID = &datasourceID
+	}
+	setProjectRetention(&project, values)
+	project.CitationsEnabled, _ = values[10].AsBool()
+	if version, ok := values[11].AsInt64(); ok {
+		project.Version = version
+	}
+	return project
 }
 
 func (app *App) updateProjectHandler(c *gin.Context) {
@@ -197,37 +227,31 @@ func (app *App) updateProjectHandler(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 		return
 	}
-	userID := user.ID
 	projectID := c.Param("id")
 
-	var req UpdateProjectRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
-		return
-	}
-
 	// Check if project exists and belongs to user
-	row, err := app.ZDB.QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND user_id = $2 AND is_active = true)",
-		projectID, userID)
+	owned, err := app.userOwnsProject(ctx, projectID, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-
-	exists, ok := row.Values[0].AsBool()
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse result"})
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
 
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	var req UpdateProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	version, ok := requestVersion(c, req.Version)
+	if !ok {
 		return
 	}
 
 	// Build dynamic update query
-	query := "UPDATE projects SET updated_at = CURRENT_TIMESTAMP"
+	query := "UPDATE projects SET updated_at = CURRENT_TIMESTAMP" + versionIncrement
 	args := []interface{}{}
 	argIndex := 1
 
@@ -249,16 +273,98 @@ func (app *App) updateProjectHandler(c *gin.Context) {
 		argIndex++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d AND user_id = $%d", argIndex, argIndex+1)
-	args = append(args, projectID, userID)
+	if req.DefaultDatasourceID != nil {
+		var datasourceID interface{}
+		if *req.DefaultDatasourceID != "" {
+			// The default must be one of the project's own active datasources
+			_, err := app.ZDB.QueryRow(ctx,
+				"SELECT id FROM datasources WHERE id = $1 AND project_id = $2 AND is_active = true",
+				*req.DefaultDatasourceID, projectID)
+			if errors.Is(err, db.ErrNoRows) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Default datasource must be an active datasource of this project"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			datasourceID = *req.DefaultDatasourceID
+		}
+		query += fmt.Sprintf(", default_datasource_id = $%d", argIndex)
+		args = append(args, datasourceID)
+		argIndex++
+	}
+
+	if req.RetentionDays != nil || req.RetentionMode != nil || req.RetentionExemptPinned != nil {
+		days, mode, err := app.projectRetention(ctx, projectID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if req.RetentionDays != nil {
+			if *req.RetentionDays < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Retention days cannot be negative"})
+				return
+			}
+			days = *req.RetentionDays
+		}
+		if req.RetentionMode != nil {
+			if *req.RetentionMode != "" && !chat.ValidRetentionMode(*req.RetentionMode) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Retention mode must be delete or redact"})
+				return
+			}
+			mode = *req.RetentionMode
+		}
+		if days > 0 && mode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A retention mode is required with retention days"})
+			return
+		}
+
+		var daysValue, modeValue interface{}
+		if days > 0 {
+			daysValue, modeValue = days, mode
+		}
+		query += fmt.Sprintf(", retention_days = $%d, retention_mode = $%d", argIndex, argIndex+1)
+		args = append(args, daysValue, modeValue)
+		argIndex += 2
+
+		if req.RetentionExemptPinned != nil {
+			query += fmt.Sprintf(", retention_exempt_pinned = $%d", argIndex)
+			args = append(args, *req.RetentionExemptPinned)
+			argIndex++
+		}
+	}
+
+	if req.CitationsEnabled != nil {
+		query += fmt.Sprintf(", citations_enabled = $%d", argIndex)
+		args = append(args, *req.CitationsEnabled)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" WHERE id = $%d AND user_id = $%d"+versionCondition, argIndex, argIndex+1, argIndex+2)
+	args = append(args, projectID, user.ID, version)
 
-	_, err = app.ZDB.Execute(ctx, query, args...)
+	result, err := app.ZDB.Execute(ctx, query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		current, err := app.loadProject(ctx, projectID, user)
+		if errors.Is(err, db.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		respondVersionConflict(c, current, current.Version)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Project updated successfully"})
+	setETag(c, version+1)
+	c.JSON(http.StatusOK, gin.H{"message": "Project updated successfully", "version": version + 1})
 }
 
 func (app *App) deleteProjectHandler(c *gin.Context) {
@@ -270,13 +376,14 @@ func (app *App) deleteProjectHandler(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 		return
 	}
-	userID := user.ID
 	projectID := c.Param("id")
 
 	// Soft delete by setting is_active to false
 	result, err := app.ZDB.Execute(ctx,
-		"UPDATE projects SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND is_active = true",
-		projectID, userID)
+		`UPDATE projects SET is_active = false, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND is_active = true
+		AND user_id IN (SELECT id FROM users WHERE client_id = $3)`,
+		projectID, user.ID, user.ClientID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
@@ -290,3 +397,83 @@ func (app *App) deleteProjectHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
 }
+
+// setProjectRetention reads the retention_days, retention_mode and
+// retention_exempt_pinned columns following the first seven of a project row
+func setProjectRetention(project *Project, values []db.Value) {
+	if days, ok := values[7].AsInt64(); ok && days > 0 {
+		retentionDays := int(days)
+		project.RetentionDays = &retentionDays
+	}
+	if mode, ok := values[8].AsString(); ok && mode != "" {
+		project.RetentionMode = &mode
+	}
+	if exempt, ok := values[9].AsBool(); ok {
+		project.RetentionExemptPinned = exempt
+	}
+}
+
+// projectRetention returns the retention days and mode a project has now; 0
+// and "" when it has no policy
+func (app *App) projectRetention(ctx context.Context, projectID string) (int, string, error) {
+	row, err := app.ZDB.QueryRow(ctx, "SELECT retention_days, retention_mode FROM projects WHERE id = $1", projectID)
+	if err != nil {
+		return 0, "", err
+	}
+	days, _ := row.Values[0].AsInt64()
+	mode, _ := row.Values[1].AsString()
+	return int(days), mode, nil
+}
+
+// getProjectRetentionStatusHandler returns a project's retention policy and
+// its latest retention run, which is null until the job has run for it
+func (app *App) getProjectRetentionStatusHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	projectID := c.Param("id")
+
+	owned, err := app.userOwnsProject(ctx, projectID, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT retention_days, retention_mode, retention_exempt_pinned FROM projects WHERE id = $1", projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var days *int
+	if value, ok := row.Values[0].AsInt64(); ok && value > 0 {
+		retentionDays := int(value)
+		days = &retentionDays
+	}
+	var mode *string
+	if value, ok := row.Values[1].AsString(); ok && value != "" {
+		mode = &value
+	}
+	exemptPinned, _ := row.Values[2].AsBool()
+
+	run, err := chat.LatestRetentionRun(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"retention_days":          days,
+		"retention_mode":          mode,
+		"retention_exempt_pinned": exemptPinned,
+		"last_run":                run,
+	})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProjectDefaultDatasource(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	defaultOf := func() *string {
+		t.Helper()
+		w := tenancyRequest(router, "token-a", "GET", "/api/projects/project-a", "")
+		var project Project
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &project) != nil {
+			t.Fatalf("Failed to get project: %d %s", w.Code, w.Body.String())
+		}
+		return project.DefaultDatasourceID
+	}
+
+	if got := defaultOf(); got != nil {
+		t.Fatalf("Expected no default datasource, got %s", *got)
+	}
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"default_datasource_id":"datasource-a","version":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 setting the default, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := defaultOf(); got == nil || *got != "datasource-a" {
+		t.Errorf("Expected datasource-a as the default, got %v", got)
+	}
+
+	// Another project's datasource cannot be the default
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"default_datasource_id":"datasource-b","version":2}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another project's datasource, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"default_datasource_id":"","version":2}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 clearing the default, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := defaultOf(); got != nil {
+		t.Errorf("Expected the default to be cleared, got %s", *got)
+	}
+}
+
+func TestProjectRetentionPolicy(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	status := func(token string) (int, map[string]interface{}) {
+		t.Helper()
+		w := tenancyRequest(router, token, "GET", "/api/projects/project-a/retention/status", "")
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	if code, body := status("token-a"); code != http.StatusOK || body["retention_days"] != nil || body["last_run"] != nil {
+		t.Fatalf("Expected no policy and no run yet, got %d %v", code, body)
+	}
+
+	for _, body := range []string{
+		`{"retention_days":-1,"retention_mode":"delete","version":1}`,
+		`{"retention_days":180,"retention_mode":"archive","version":1}`,
+		`{"retention_days":180,"version":1}`,
+	} {
+		if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a",
+		`{"retention_days":180,"retention_mode":"redact","retention_exempt_pinned":true,"version":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 setting the policy, got %d: %s", w.Code, w.Body.String())
+	}
+	w := tenancyRequest(router, "token-a", "GET", "/api/projects/project-a", "")
+	var project Project
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &project) != nil {
+		t.Fatalf("Failed to get project: %d %s", w.Code, w.Body.String())
+	}
+	if project.RetentionDays == nil || *project.RetentionDays != 180 || project.RetentionMode == nil ||
+		*project.RetentionMode != "redact" || !project.RetentionExemptPinned {
+		t.Errorf("Expected the policy on the project, got %v %v %t", project.RetentionDays, project.RetentionMode, project.RetentionExemptPinned)
+	}
+
+	// Changing only the days keeps the mode
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"retention_days":90,"version":2}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 changing the days, got %d: %s", w.Code, w.Body.String())
+	}
+	if code, body := status("token-a"); code != http.StatusOK || body["retention_days"] != float64(90) || body["retention_mode"] != "redact" {
+		t.Errorf("Expected 90 days of redaction, got %d %v", code, body)
+	}
+
+	// Another tenant cannot see the status
+	if code, _ := status("token-b"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another tenant, got %d", code)
+	}
+
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"retention_days":0,"version":3}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 removing the policy, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, body := status("token-a"); body["retention_days"] != nil || body["retention_mode"] != nil {
+		t.Errorf("Expected the policy removed, got %v", body)
+	}
+}
+
+func TestProjectCitationsToggle(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	citationsOf := func() bool {
+		t.Helper()
+		w := tenancyRequest(router, "token-a", "GET", "/api/projects/project-a", "")
+		var project Project
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &project) != nil {
+			t.Fatalf("Failed to get project: %d %s", w.Code, w.Body.String())
+		}
+		return project.CitationsEnabled
+	}
+
+	if citationsOf() {
+		t.Fatal("Expected citations off by default")
+	}
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"citations_enabled":true,"version":1}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 enabling citations, got %d: %s", w.Code, w.Body.String())
+	}
+	if !citationsOf() {
+		t.Error("Expected citations on")
+	}
+
+	// Other updates leave the setting alone
+	if w := tenancyRequest(router, "token-a", "PUT", "/api/projects/project-a", `{"name":"Renamed","version":2}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 renaming, got %d: %s", w.Code, w.Body.String())
+	}
+	if !citationsOf() {
+		t.Error("Expected citations to stay on")
+	}
+}
+
+func TestProjectListStats(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects", app.authMiddleware(), app.getProjectsHandler)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	later := now.Add(time.Hour).Truncate(time.Second)
+	seed := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ('project-a2', 'user-a', 'Empty', '', true, $1)", []interface{}{now}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conversation-a2', 'Chat', 'user-a', 'project-a', 'completed', $1, $1)", []interface{}{now.Add(-2 * time.Hour)}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at, deleted_at) VALUES ('conversation-a3', 'Gone', 'user-a', 'project-a', 'completed', $1, $1, $1)", []interface{}{now}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m-user', 'conversation-a2', 'user', 'Hello', $1)", []interface{}{now.Add(-2 * time.Hour)}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m-reply', 'conversation-a2', 'assistant', 'Hi', $1)", []interface{}{later}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m-tool', 'conversation-a2', 'tool', '{}', $1)", []interface{}{now}},
+		{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ('m-deleted', 'conversation-a3', 'user', 'Bye', $1)", []interface{}{now}},
+	}
+	for _, s := range seed {
+		if _, err := app.ZDB.Execute(ctx, s.query, s.args...); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+	}
+
+	list := func(query string) (map[string]Project, string) {
+		t.Helper()
+		w := tenancyRequest(router, "token-a", "GET", "/api/projects"+query, "")
+		var projects []Project
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &projects) != nil {
+			t.Fatalf("Failed to list projects: %d %s", w.Code, w.Body.String())
+		}
+		byID := make(map[string]Project)
+		for _, project := range projects {
+			byID[project.ID] = project
+		}
+		return byID, w.Body.String()
+	}
+
+	projects, _ := list("")
+	stats := projects["project-a"].ProjectStats
+	if stats == nil || stats.ConversationCount != 2 || stats.MessageCount != 3 {
+		t.Fatalf("Expected 2 conversations and 3 messages, got %+v", stats)
+	}
+	if stats.LastActivityAt == nil || *stats.LastActivityAt != later.Format(time.RFC3339) {
+		t.Errorf("Expected the latest message as the last activity, got %v", stats.LastActivityAt)
+	}
+	if empty := projects["project-a2"].ProjectStats; empty == nil || empty.ConversationCount != 0 || empty.LastActivityAt != nil {
+		t.Errorf("Expected zero stats for a project without conversations, got %+v", empty)
+	}
+	if len(projects) != 2 {
+		t.Errorf("Expected only client A's projects, got %v", projects)
+	}
+
+	// Skipping the stats leaves the fields out
+	if projects, body := list("?include_stats=false"); projects["project-a"].ProjectStats != nil || strings.Contains(body, "conversation_count") {
+		t.Errorf("Expected no stats, got %s", body)
+	}
+}
+
+func TestProjectStatsAreCached(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects", app.authMiddleware(), app.getProjectsHandler)
+	now := time.Now()
+	app.ProjectStats = newProjectStatsCache(projectStatsTTL)
+	app.ProjectStats.now = func() time.Time { return now }
+
+	conversations := func() int64 {
+		t.Helper()
+		w := tenancyRequest(router, "token-a", "GET", "/api/projects", "")
+		var projects []Project
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &projects) != nil || len(projects) != 1 || projects[0].ProjectStats == nil {
+			t.Fatalf("Failed to list projects: %d %s", w.Code, w.Body.String())
+		}
+		return projects[0].ConversationCount
+	}
+
+	if got := conversations(); got != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", got)
+	}
+	if _, err := app.ZDB.Execute(context.Background(),
+		"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conversation-new', 'Chat', 'user-a', 'project-a', 'completed', $1, $1)", now); err != nil {
+		t.Fatalf("Failed to add a conversation: %v", err)
+	}
+	if got := conversations(); got != 1 {
+		t.Errorf("Expected the cached count within the TTL, got %d", got)
+	}
+	now = now.Add(projectStatsTTL)
+	if got := conversations(); got != 2 {
+		t.Errorf("Expected the stats reloaded after the TTL, got %d", got)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIP is the address of the client behind any TRUSTED_PROXIES, used for
+// rate limits, the audit log and session rows
+func (app *App) clientIP(c *gin.Context) string {
+	return app.Proxies.ClientIP(c.Request)
+}
+
+// secureCookies reports whether the session cookie gets the Secure flag:
+// always with COOKIE_SECURE, otherwise when the client used HTTPS, which
+// behind a trusted proxy is read from X-Forwarded-Proto
+func (app *App) secureCookies(c *gin.Context) bool {
+	return app.Config.CookieSecure || app.Proxies.Scheme(c.Request) == "https"
+}
+
+// setSessionCookie sets the session cookie, or clears it when token is empty
+func (app *App) setSessionCookie(c *gin.Context, token string) {
+	maxAge := int(app.Config.SessionTTL / time.Second)
+	if token == "" {
+		maxAge = -1
+	}
+	c.SetCookie("session_token", token, maxAge, "/", app.Config.CookieDomain, app.secureCookies(c), app.Config.CookieHTTPOnly)
+}
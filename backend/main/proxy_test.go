@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/proxy"
+)
+
+func TestLoginRecordsClientBehindTrustedProxies(t *testing.T) {
+	app := newSessionsTestApp(t)
+	proxies, err := proxy.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+	app.Proxies = proxies
+	router := newSessionsTestRouter(app)
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		wantIP     string
+		wantSecure bool
+	}{
+		{"through a trusted proxy", "10.0.0.2:40000", "198.51.100.9", true},
+		{"forged headers sent directly", "203.0.113.7:40000", "203.0.113.7", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username": "root", "password": "secret"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "test-browser/1.0")
+			req.Header.Set("X-Forwarded-For", "198.51.100.9")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.RemoteAddr = tc.remoteAddr
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var token string
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == "session_token" {
+					token, _ = url.QueryUnescape(cookie.Value)
+					if cookie.Secure != tc.wantSecure {
+						t.Errorf("Expected Secure=%v, got %v", tc.wantSecure, cookie.Secure)
+					}
+				}
+			}
+			if token == "" {
+				t.Fatalf("Expected to log in, got %d: %s", w.Code, w.Body.String())
+			}
+
+			row, err := app.ZDB.QueryRow(context.Background(),
+				"SELECT ip, user_agent FROM sessions WHERE token_hash = $1", tenancyTokenHash(token))
+			if err != nil {
+				t.Fatalf("Failed to load session: %v", err)
+			}
+			ip, _ := row.Values[0].AsString()
+			userAgent, _ := row.Values[1].AsString()
+			if ip != tc.wantIP || userAgent != "test-browser/1.0" {
+				t.Errorf("Expected the session from %s, got %q %q", tc.wantIP, ip, userAgent)
+			}
+		})
+	}
+
+	// COOKIE_SECURE sets the flag whatever the scheme
+	app.Config.CookieSecure = true
+	if _, w := loginAs(t, router, `{"username": "root", "password": "secret"}`); !w.Result().Cookies()[0].Secure {
+		t.Error("Expected COOKIE_SECURE to always set Secure")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/tools/jobs"
+)
+
+const (
+	defaultQueryJobPageSize = 100
+	maxQueryJobPageSize     = 1000
+)
+
+// loadQueryJob returns the job when it was submitted by the caller, writing the error response otherwise
+func (app *App) loadQueryJob(c *gin.Context) (*jobs.Job, bool) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+	if app.QueryJobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Query jobs are not available"})
+		return nil, false
+	}
+
+	job, err := app.QueryJobs.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, jobs.ErrJobNotFound) || (err == nil && job.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query job not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load query job"})
+		return nil, false
+	}
+	return job, true
+}
+
+// getQueryJobHandler returns the status of one of the caller's async queries
+func (app *App) getQueryJobHandler(c *gin.Context) {
+	job, ok := app.loadQueryJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// getQueryJobResultHandler pages through a completed job's rows with offset and limit
+func (app *App) getQueryJobResultHandler(c *gin.Context) {
+	job, ok := app.loadQueryJob(c)
+	if !ok {
+		return
+	}
+	if job.Status != jobs.StatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Query job has no result", "status": job.Status, "job_error": job.Error})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultQueryJobPageSize)))
+	if err != nil || limit <= 0 || limit > maxQueryJobPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	page, err := app.QueryJobs.Results(c.Request.Context(), job, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load query job result"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
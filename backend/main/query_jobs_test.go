@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/tools/jobs"
+)
+
+func TestQueryJobEndpoints(t *testing.T) {
+	app := newTenancyTestApp(t)
+	ctx := context.Background()
+	app.QueryJobs = jobs.NewManager(&tools.ZlayDBAdapter{DB: app.ZDB}, t.TempDir(), nil)
+
+	job, err := app.QueryJobs.Submit(ctx, jobs.Job{ProjectID: "project-a", UserID: "user-a", Query: "SELECT n"},
+		func(ctx context.Context) (*jobs.Result, error) {
+			result := &jobs.Result{Columns: []string{"n"}}
+			for i := 0; i < 5; i++ {
+				result.Rows = append(result.Rows, map[string]interface{}{"n": i})
+			}
+			return result, nil
+		})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	app.QueryJobs.Wait()
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/query-jobs/:id", app.authMiddleware(), app.getQueryJobHandler)
+	router.GET("/api/query-jobs/:id/result", app.authMiddleware(), app.getQueryJobResultHandler)
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/query-jobs/"+job.ID+"/result?offset=3&limit=10", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page jobs.Page
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if page.Total != 5 || len(page.Rows) != 2 || page.HasMore {
+		t.Errorf("Unexpected page: %+v", page)
+	}
+
+	tests := []struct {
+		token, path string
+		status      int
+	}{
+		{"token-a", "/api/query-jobs/" + job.ID, http.StatusOK},
+		{"token-a", "/api/query-jobs/" + job.ID + "/result?limit=0", http.StatusBadRequest},
+		{"token-b", "/api/query-jobs/" + job.ID, http.StatusNotFound},
+		{"token-b", "/api/query-jobs/" + job.ID + "/result", http.StatusNotFound},
+		{"token-a", "/api/query-jobs/unknown", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := tenancyRequest(router, tt.token, "GET", tt.path, ""); w.Code != tt.status {
+			t.Errorf("%s as %s: expected %d, got %d: %s", tt.path, tt.token, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/schedules"
+	"zlay-backend/internal/tools"
+)
+
+// bindScheduleInput reads and normalizes a schedule from the request body
+func bindScheduleInput(c *gin.Context) (schedules.Input, bool) {
+	var in schedules.Input
+	if err := c.ShouldBindJSON(&in); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return in, false
+	}
+	if err := in.Normalize(); err != nil {
+		code, details := schedules.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return in, false
+	}
+	return in, true
+}
+
+// getSchedulesHandler lists the scheduled prompts of a project
+func (app *App) getSchedulesHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	list, err := schedules.List(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": list})
+}
+
+// getScheduleHandler returns one scheduled prompt of a project
+func (app *App) getScheduleHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	schedule, err := schedules.Get(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("schedule_id"))
+	if err != nil {
+		code, details := schedules.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// createScheduleHandler saves a new scheduled prompt run as the caller; editors and above only
+func (app *App) createScheduleHandler(c *gin.Context) {
+	user, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+	in, ok := bindScheduleInput(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := schedules.Create(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, user.ID, in)
+	if err != nil {
+		code, details := schedules.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// updateScheduleHandler replaces a scheduled prompt's settings; editors and above only
+func (app *App) updateScheduleHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+	in, ok := bindScheduleInput(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := schedules.Update(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("schedule_id"), in)
+	if err != nil {
+		code, details := schedules.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// deleteScheduleHandler removes a scheduled prompt; editors and above only
+func (app *App) deleteScheduleHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := schedules.Delete(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("schedule_id")); err != nil {
+		code, details := schedules.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
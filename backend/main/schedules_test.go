@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/schedules"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+	"zlay-backend/internal/websocket"
+)
+
+func newSchedulesTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects/:id/schedules", app.authMiddleware(), app.getSchedulesHandler)
+	router.POST("/api/projects/:id/schedules", app.authMiddleware(), app.createScheduleHandler)
+	router.GET("/api/projects/:id/schedules/:schedule_id", app.authMiddleware(), app.getScheduleHandler)
+	router.PUT("/api/projects/:id/schedules/:schedule_id", app.authMiddleware(), app.updateScheduleHandler)
+	router.DELETE("/api/projects/:id/schedules/:schedule_id", app.authMiddleware(), app.deleteScheduleHandler)
+	return router
+}
+
+func TestSchedulesCRUD(t *testing.T) {
+	router := newSchedulesTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/schedules",
+		`{"name":" Morning sales ","cron_expression":"0 9 * * MON-FRI","timezone":"Asia/Jakarta","prompt":"How were sales yesterday?"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created schedules.Schedule
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	if created.Name != "Morning sales" || created.CreatedBy != "user-a" || created.Target != schedules.TargetConversation || !created.Enabled {
+		t.Errorf("Expected a trimmed name, the creator and the defaults, got %+v", created)
+	}
+	if created.NextRunAt == nil || created.NextRunAt.In(time.FixedZone("WIB", 7*3600)).Hour() != 9 {
+		t.Errorf("Expected the next run at 09:00 Jakarta time, got %v", created.NextRunAt)
+	}
+
+	path := "/api/projects/project-a/schedules/" + created.ID
+	w = tenancyRequest(router, "token-a", "PUT", path,
+		`{"name":"Morning sales","cron_expression":"@daily","prompt":"How were sales yesterday?","target":"webhook","enabled":false}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated schedules.Schedule
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	if updated.Enabled || updated.NextRunAt != nil || updated.Target != schedules.TargetWebhook || updated.Timezone != "UTC" {
+		t.Errorf("Expected a disabled webhook schedule without a next run, got %+v", updated)
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/schedules", "")
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), `"cron_expression"`) != 1 {
+		t.Errorf("Expected one schedule, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := tenancyRequest(router, "token-a", "DELETE", path, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 deleting, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "GET", path, ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "SCHEDULE_NOT_FOUND") {
+		t.Errorf("Expected SCHEDULE_NOT_FOUND after deleting, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSchedulesRejectInvalidInput(t *testing.T) {
+	router := newSchedulesTestRouter(t)
+
+	for body, field := range map[string]string{
+		`{"cron_expression":"@daily","prompt":"Hi"}`:                                                   "name",
+		`{"name":"Daily","cron_expression":"61 * * * *","prompt":"Hi"}`:                                "cron_expression",
+		`{"name":"Daily","cron_expression":"0 0 31 2 *","prompt":"Hi"}`:                                "cron_expression",
+		`{"name":"Daily","cron_expression":"@daily","timezone":"Mars/Olympus","prompt":"Hi"}`:          "timezone",
+		`{"name":"Daily","cron_expression":"@daily"}`:                                                  "prompt",
+		`{"name":"Daily","cron_expression":"@daily","prompt":"Hi","target":"sms"}`:                     "target",
+		`{"name":"Daily","cron_expression":"@daily","prompt":"Hi","conversation_id":"conversation-b"}`: "conversation_id",
+	} {
+		w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/schedules", body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "SCHEDULE_INVALID") ||
+			!strings.Contains(w.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("%s: expected SCHEDULE_INVALID for %s, got %d: %s", body, field, w.Code, w.Body.String())
+		}
+	}
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/schedules",
+		`{"name":"Daily","cron_expression":"@daily","template_id":"00000000-0000-0000-0000-000000000001"}`)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "TEMPLATE_NOT_FOUND") {
+		t.Errorf("Expected TEMPLATE_NOT_FOUND for a missing template, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSchedulesRequireProjectRole(t *testing.T) {
+	router := newSchedulesTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/schedules", `{"name":"Daily","cron_expression":"@daily","prompt":"Hi"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created schedules.Schedule
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// user-b has no role in project-a
+	for _, r := range []struct{ method, path, body string }{
+		{"GET", "/api/projects/project-a/schedules", ""},
+		{"POST", "/api/projects/project-a/schedules", `{"name":"Other","cron_expression":"@daily","prompt":"Hi"}`},
+		{"GET", "/api/projects/project-a/schedules/" + created.ID, ""},
+		{"PUT", "/api/projects/project-a/schedules/" + created.ID, `{"name":"Other","cron_expression":"@daily","prompt":"Hi"}`},
+		{"DELETE", "/api/projects/project-a/schedules/" + created.ID, ""},
+	} {
+		if w := tenancyRequest(router, "token-b", r.method, r.path, r.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404 without a project role, got %d", r.method, r.path, w.Code)
+		}
+	}
+
+	// Schedules of another project are not found through this one
+	for _, method := range []string{"GET", "DELETE"} {
+		if w := tenancyRequest(router, "token-b", method, "/api/projects/project-b/schedules/"+created.ID, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for another project's schedule, got %d", method, w.Code)
+		}
+	}
+}
+
+// capturingPublisher keeps the events it is given
+type capturingPublisher struct {
+	mutex  sync.Mutex
+	events []webhooks.Event
+}
+
+func (p *capturingPublisher) Publish(event webhooks.Event) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.events = append(p.events, event)
+	return true
+}
+
+func TestScheduledPromptRunsWithoutConnection(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var profile struct {
+		User struct {
+			ClientID string `json:"client_id"`
+		} `json:"user"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/auth/profile", ""), http.StatusOK, &profile)
+	var project Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Reports"}`), http.StatusCreated, &project)
+
+	llmClient := &streamingLLMClient{deltas: []string{"Sales were ", "up 4%"}}
+	app.ClientConfigCache.SetClientConfig(&websocket.ClientConfig{ClientID: profile.User.ClientID, LLMClient: llmClient})
+
+	var schedule schedules.Schedule
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects/"+project.ID+"/schedules",
+		`{"name": "Daily sales", "cron_expression": "0 9 * * *", "prompt": "How were sales yesterday?", "target": "webhook"}`), http.StatusCreated, &schedule)
+
+	// Bring the run forward instead of waiting until 09:00
+	conn := &tools.ZlayDBAdapter{DB: app.ZDB}
+	due := func() {
+		t.Helper()
+		if _, err := conn.Exec(context.Background(), "UPDATE scheduled_prompts SET next_run_at = $1 WHERE id = $2",
+			time.Now().UTC().Add(-time.Minute), schedule.ID); err != nil {
+			t.Fatalf("Failed to make the schedule due: %v", err)
+		}
+	}
+	publisher := &capturingPublisher{}
+	scheduler := schedules.NewScheduler(conn, app.WSServer.RunScheduledTurn, publisher, nil, schedules.Options{})
+	due()
+	if started, err := scheduler.RunOnce(context.Background()); err != nil || started != 1 {
+		t.Fatalf("Expected the schedule to run, got %d, %v", started, err)
+	}
+	scheduler.Wait()
+
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/projects/"+project.ID+"/schedules/"+schedule.ID, ""), http.StatusOK, &schedule)
+	if schedule.LastStatus == nil || *schedule.LastStatus != schedules.StatusSucceeded || schedule.ConversationID == nil {
+		t.Fatalf("Expected a successful run in a new conversation, got %+v (error %v)", schedule, schedule.LastError)
+	}
+	var history struct {
+		Conversation struct {
+			Conversation struct {
+				Title string `json:"title"`
+			} `json:"conversation"`
+			Messages []Message `json:"messages"`
+		} `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+*schedule.ConversationID+"/messages", ""), http.StatusOK, &history)
+	messages := history.Conversation.Messages
+	if len(messages) != 2 || messages[0].Content != "How were sales yesterday?" || messages[1].Content != "Sales were up 4%" {
+		t.Fatalf("Expected the scheduled exchange stored, got %+v", messages)
+	}
+	if history.Conversation.Conversation.Title != "Daily sales" {
+		t.Errorf("Expected the conversation named after the schedule, got %q", history.Conversation.Conversation.Title)
+	}
+
+	if len(publisher.events) != 1 {
+		t.Fatalf("Expected one webhook event, got %d", len(publisher.events))
+	}
+	event := publisher.events[0]
+	if event.Type != webhooks.EventScheduledPromptCompleted || event.ClientID != profile.User.ClientID ||
+		event.Data["content"] != "Sales were up 4%" || event.Data["message_id"] != messages[1].ID {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// A provider failure is recorded on the schedule and the same conversation is kept
+	llmClient.err = errors.New("provider unavailable")
+	conversationID := *schedule.ConversationID
+	due()
+	scheduler.RunOnce(context.Background())
+	scheduler.Wait()
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/projects/"+project.ID+"/schedules/"+schedule.ID, ""), http.StatusOK, &schedule)
+	if schedule.LastStatus == nil || *schedule.LastStatus != schedules.StatusFailed || schedule.LastError == nil ||
+		!strings.Contains(*schedule.LastError, "provider unavailable") {
+		t.Errorf("Expected the failure recorded, got %v, %v", schedule.LastStatus, schedule.LastError)
+	}
+	if schedule.ConversationID == nil || *schedule.ConversationID != conversationID {
+		t.Errorf("Expected the schedule to keep conversation %s, got %v", conversationID, schedule.ConversationID)
+	}
+	if len(publisher.events) != 1 {
+		t.Errorf("Expected no event for the failed run, got %d", len(publisher.events))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/tools/snapshots"
+)
+
+const (
+	defaultSchemaHistoryPageSize = 50
+	maxSchemaHistoryPageSize     = 500
+)
+
+// ownedDatasource reports whether the current user owns an active datasource,
+// writing the error response when they do not
+func (app *App) ownedDatasource(c *gin.Context, datasourceID string) bool {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return false
+	}
+
+	_, err = app.ZDB.QueryRow(c.Request.Context(),
+		`SELECT d.id FROM datasources d
+		 JOIN projects p ON d.project_id = p.id
+		 JOIN users u ON u.id = p.user_id
+		 WHERE d.id = $1 AND p.user_id = $2 AND u.client_id = $3 AND d.is_active = true AND p.is_active = true`,
+		datasourceID, user.ID, user.ClientID)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeDatasourceNotFound, nil)
+		return false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return false
+	}
+	return true
+}
+
+// getDatasourceSchemaHistoryHandler lists a datasource's schema snapshots,
+// newest first, with the diff of each against the one before it
+func (app *App) getDatasourceSchemaHistoryHandler(c *gin.Context) {
+	datasourceID := c.Param("id")
+	if !app.ownedDatasource(c, datasourceID) {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSchemaHistoryPageSize)))
+	if err != nil || limit <= 0 || limit > maxSchemaHistoryPageSize {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "limit", "min": 1, "max": maxSchemaHistoryPageSize})
+		return
+	}
+
+	history, err := snapshots.History(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, datasourceID, limit)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": history})
+}
+
+// getDatasourceSchemaDiffHandler compares two snapshots of a datasource. to
+// defaults to the latest snapshot and from to the snapshot taken before to.
+func (app *App) getDatasourceSchemaDiffHandler(c *gin.Context) {
+	datasourceID := c.Param("id")
+	if !app.ownedDatasource(c, datasourceID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	store := &tools.ZlayDBAdapter{DB: app.ZDB}
+
+	var to *snapshots.Snapshot
+	var err error
+	if toID := c.Query("to"); toID != "" {
+		to, err = snapshots.Get(ctx, store, datasourceID, toID)
+	} else {
+		to, err = snapshots.Latest(ctx, store, datasourceID)
+	}
+	if errors.Is(err, snapshots.ErrSnapshotNotFound) {
+		apierror.Respond(c, apierror.CodeSchemaSnapshotNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	var from *snapshots.Snapshot
+	if fromID := c.Query("from"); fromID != "" {
+		from, err = snapshots.Get(ctx, store, datasourceID, fromID)
+	} else {
+		from, err = snapshots.Previous(ctx, store, to)
+	}
+	if errors.Is(err, snapshots.ErrSnapshotNotFound) {
+		apierror.Respond(c, apierror.CodeSchemaSnapshotNotFound, map[string]interface{}{"reason": "no earlier snapshot"})
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"datasource_id": datasourceID,
+		"from":          gin.H{"id": from.ID, "created_at": from.CreatedAt, "table_count": from.TableCount},
+		"to":            gin.H{"id": to.ID, "created_at": to.CreatedAt, "table_count": to.TableCount},
+		"diff":          snapshots.Compare(from.Schema, to.Schema),
+	})
+}
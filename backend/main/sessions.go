@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/db"
+)
+
+type impersonateRequest struct {
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+}
+
+// Session is an active session as listed by the admin sessions endpoints; the
+// token itself is never returned after it is issued
+type Session struct {
+	ID             string `json:"id"`
+	ClientID       string `json:"client_id"`
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+	IP             string `json:"ip,omitempty"`         // Client address at login
+	UserAgent      string `json:"user_agent,omitempty"` // Browser at login
+	ExpiresAt      string `json:"expires_at"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// newSessionToken returns a random session token and the hash stored for it
+func newSessionToken() (string, string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", err
+	}
+	token := base64.URLEncoding.EncodeToString(tokenBytes)
+	return token, auth.HashToken(token), nil
+}
+
+// impersonateHandler issues root a session as another client's user. The token is
+// returned in the body rather than as a cookie so root's own session is kept.
+func (app *App) impersonateHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	rootID := c.GetString("user_id")
+
+	var req impersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if req.ClientID == "" || req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id and username are required"})
+		return
+	}
+
+	clientID, err := app.resolveClient(ctx, req.ClientID, "")
+	if errors.Is(err, errInvalidClientID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID format"})
+		return
+	}
+	if errors.Is(err, errUnknownClient) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up client"})
+		return
+	}
+
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT id FROM users WHERE client_id = $1 AND username = $2 AND is_active = true AND is_visitor = false",
+		clientID, req.Username)
+	if errors.Is(err, db.ErrNoRows) || (err == nil && len(row.Values) == 0) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	userID, _ := row.Values[0].AsString()
+
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	sessionID := uuid.New().String()
+	expiresAt := time.Now().Add(app.Config.ImpersonationTTL)
+	_, err = app.ZDB.Execute(ctx,
+		"INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at, impersonated_by, ip, user_agent, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)",
+		sessionID, clientID, userID, tokenHash, expiresAt, rootID, nullableString(app.clientIP(c)), nullableString(c.Request.UserAgent()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    rootID,
+		IP:         app.clientIP(c),
+		ClientID:   clientID.String(),
+		Action:     AuditActionImpersonationStart,
+		TargetType: "user",
+		TargetID:   userID,
+		Details: map[string]interface{}{
+			"session_id": sessionID,
+			"username":   req.Username,
+			"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":         true,
+		"session_id":      sessionID,
+		"token":           token,
+		"expires_at":      expiresAt.UTC().Format(time.RFC3339),
+		"impersonated_by": rootID,
+		"user": gin.H{
+			"id":        userID,
+			"client_id": clientID.String(),
+			"username":  req.Username,
+		},
+	})
+}
+
+// getSessionsHandler lists unexpired sessions, optionally filtered by client_id,
+// user_id, or impersonated=true for impersonation sessions only
+func (app *App) getSessionsHandler(c *gin.Context) {
+	conditions := []string{"s.expires_at > CURRENT_TIMESTAMP"}
+	var args []interface{}
+	for _, filter := range []string{"client_id", "user_id"} {
+		if value := c.Query(filter); value != "" {
+			args = append(args, value)
+			conditions = append(conditions, "s."+filter+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	if c.Query("impersonated") == "true" {
+		conditions = append(conditions, "s.impersonated_by IS NOT NULL")
+	}
+
+	resultSet, err := app.ZDB.Query(c.Request.Context(),
+		`SELECT s.id, s.client_id, s.user_id, u.username, s.impersonated_by, s.expires_at, s.created_at, s.ip, s.user_agent
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY s.created_at DESC`,
+		args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	sessions := make([]Session, 0, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 9 {
+			continue
+		}
+		var session Session
+		session.ID, _ = row.Values[0].AsString()
+		session.ClientID, _ = row.Values[1].AsString()
+		session.UserID, _ = row.Values[2].AsString()
+		session.Username, _ = row.Values[3].AsString()
+		session.ImpersonatedBy, _ = row.Values[4].AsString()
+		if expiresAt, ok := row.Values[5].AsTimestamp(); ok {
+			session.ExpiresAt = expiresAt.Time.Format(time.RFC3339)
+		}
+		if createdAt, ok := row.Values[6].AsTimestamp(); ok {
+			session.CreatedAt = createdAt.Time.Format(time.RFC3339)
+		}
+		session.IP, _ = row.Values[7].AsString()
+		session.UserAgent, _ = row.Values[8].AsString()
+		sessions = append(sessions, session)
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// revokeSessionHandler ends a session, which logs its user out of REST and
+// rejects new WebSocket connections made with its token
+func (app *App) revokeSessionHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	sessionID := c.Param("id")
+
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT client_id, user_id, impersonated_by FROM sessions WHERE id = $1",
+		sessionID)
+	if errors.Is(err, db.ErrNoRows) || (err == nil && len(row.Values) < 3) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch session"})
+		return
+	}
+	clientID, _ := row.Values[0].AsString()
+	userID, _ := row.Values[1].AsString()
+	rootID, _ := row.Values[2].AsString()
+
+	if _, err := app.ZDB.Execute(ctx, "DELETE FROM sessions WHERE id = $1", sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if app.Sessions != nil {
+		app.Sessions.InvalidateSession(sessionID)
+	}
+
+	details := map[string]interface{}{"session_id": sessionID}
+	if rootID != "" {
+		details["impersonated_by"] = rootID
+	}
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    c.GetString("user_id"),
+		IP:         app.clientIP(c),
+		ClientID:   clientID,
+		Action:     AuditActionSessionRevoke,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    details,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Session revoked"})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db/dbtest"
+)
+
+const (
+	systemClientID = "00000000-0000-0000-0000-000000000001"
+	tenantClientID = "00000000-0000-0000-0000-000000000002"
+)
+
+// newSessionsTestApp seeds the system client with root and a tenant client with
+// its own root account and a regular user, all with password "secret"
+func newSessionsTestApp(t *testing.T) *App {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	ctx := context.Background()
+	dbtest.Seed(t, zdb,
+		// The tenant client is older, which used to make it root's default
+		"INSERT INTO clients (id, name, slug, is_active, created_at) VALUES ('"+tenantClientID+"', 'Tenant', 'tenant', true, '2020-01-01 00:00:00')",
+		"INSERT INTO clients (id, name, slug, is_active, created_at) VALUES ('"+systemClientID+"', 'System', 'system', true, '2024-01-01 00:00:00')",
+	)
+	for _, user := range []struct{ id, clientID, username string }{
+		{"root-system", systemClientID, "root"},
+		{"root-tenant", tenantClientID, "root"},
+		{"alice", tenantClientID, "alice"},
+	} {
+		if _, err := zdb.Execute(ctx,
+			"INSERT INTO users (id, client_id, username, password_hash, is_active, created_at) VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP)",
+			user.id, user.clientID, user.username, string(hash)); err != nil {
+			t.Fatalf("Failed to seed user %s: %v", user.username, err)
+		}
+	}
+
+	return &App{Config: config.Default(), ZDB: zdb}
+}
+
+func newSessionsTestRouter(app *App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/auth/login", app.loginHandler)
+	router.GET("/api/auth/profile", app.authMiddleware(), app.profileHandler)
+	router.POST("/api/projects", app.authMiddleware(), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	router.POST("/api/admin/impersonate", app.adminMiddleware(), app.impersonateHandler)
+	router.GET("/api/admin/sessions", app.adminMiddleware(), app.getSessionsHandler)
+	router.DELETE("/api/admin/sessions/:id", app.adminMiddleware(), app.revokeSessionHandler)
+	router.GET("/api/admin/audit-log", app.adminMiddleware(), app.getAuditLogHandler)
+	return router
+}
+
+// loginAs logs in and returns the session cookie's token
+func loginAs(t *testing.T, router *gin.Engine, body string) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	w := tenancyRequest(router, "", "POST", "/api/auth/login", body)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			token, _ := url.QueryUnescape(cookie.Value)
+			return token, w
+		}
+	}
+	return "", w
+}
+
+func sessionClientOf(t *testing.T, app *App, token string) string {
+	t.Helper()
+	row, err := app.ZDB.QueryRow(context.Background(), "SELECT client_id FROM sessions WHERE token_hash = $1", tenancyTokenHash(token))
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	clientID, _ := row.Values[0].AsString()
+	return clientID
+}
+
+func TestRootLoginBindsToSystemClient(t *testing.T) {
+	app := newSessionsTestApp(t)
+	router := newSessionsTestRouter(app)
+
+	token, w := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in by default, got %d: %s", w.Code, w.Body.String())
+	}
+	if clientID := sessionClientOf(t, app, token); clientID != systemClientID {
+		t.Errorf("Expected root to bind to the system client, got %s", clientID)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/sessions", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected system root to have admin access, got %d", w.Code)
+	}
+
+	// Naming a client logs in to that client's own root account, which is not an admin
+	tenantToken, w := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	if tenantToken == "" {
+		t.Fatalf("Expected the tenant root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	if clientID := sessionClientOf(t, app, tenantToken); clientID != tenantClientID {
+		t.Errorf("Expected the tenant binding, got %s", clientID)
+	}
+	if w := tenancyRequest(router, tenantToken, "GET", "/api/admin/sessions", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a tenant root to be refused admin access, got %d", w.Code)
+	}
+
+	if token, _ := loginAs(t, router, `{"client_id": "`+tenantClientID+`", "username": "root", "password": "secret"}`); token == "" {
+		t.Error("Expected client_id to select the client like client_slug")
+	}
+	if _, w := loginAs(t, router, `{"client_slug": "missing", "username": "root", "password": "secret"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown client to be refused, got %d", w.Code)
+	}
+	if _, w := loginAs(t, router, `{"client_id": "not-a-uuid", "username": "root", "password": "secret"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid client ID to be refused, got %d", w.Code)
+	}
+}
+
+func TestImpersonationSessionLifecycle(t *testing.T) {
+	app := newSessionsTestApp(t)
+	router := newSessionsTestRouter(app)
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+
+	// Only the system root can impersonate
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+	body := `{"client_id": "` + tenantClientID + `", "username": "alice"}`
+	if w := tenancyRequest(router, aliceToken, "POST", "/api/admin/impersonate", body); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a regular user to be refused, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "POST", "/api/admin/impersonate", `{"client_id": "`+tenantClientID+`", "username": "bob"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown user to return 404, got %d", w.Code)
+	}
+
+	w := tenancyRequest(router, rootToken, "POST", "/api/admin/impersonate", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var issued struct {
+		SessionID      string `json:"session_id"`
+		Token          string `json:"token"`
+		ImpersonatedBy string `json:"impersonated_by"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if issued.Token == "" || issued.ImpersonatedBy != "root-system" {
+		t.Fatalf("Unexpected impersonation response: %s", w.Body.String())
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session_token" {
+			t.Error("Impersonating must not replace root's own session cookie")
+		}
+	}
+
+	// The session acts as alice and says so, but carries no admin rights
+	w = tenancyRequest(router, issued.Token, "GET", "/api/auth/profile", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"impersonated_by":"root-system"`) || !strings.Contains(w.Body.String(), `"username":"alice"`) {
+		t.Errorf("Expected alice's profile flagged as impersonated, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, issued.Token, "GET", "/api/admin/sessions", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected impersonated sessions to be refused admin access, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, issued.Token, "POST", "/api/projects", `{}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected the write to succeed, got %d", w.Code)
+	}
+
+	var sessions []Session
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/sessions?impersonated=true", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("Invalid sessions response: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != issued.SessionID || sessions[0].Username != "alice" || sessions[0].ImpersonatedBy != "root-system" {
+		t.Errorf("Expected only the impersonation session, got %+v", sessions)
+	}
+
+	if w := tenancyRequest(router, rootToken, "DELETE", "/api/admin/sessions/"+issued.SessionID, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the session to be revoked, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, issued.Token, "GET", "/api/auth/profile", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked session to be refused, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, rootToken, "DELETE", "/api/admin/sessions/"+issued.SessionID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking twice to return 404, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "impersonated_by") {
+		t.Errorf("Expected alice's own session to be untouched, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestImpersonationAuditEntries(t *testing.T) {
+	app := newSessionsTestApp(t)
+	router := newSessionsTestRouter(app)
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+
+	w := tenancyRequest(router, rootToken, "POST", "/api/admin/impersonate", `{"client_id": "`+tenantClientID+`", "username": "alice"}`)
+	var issued struct {
+		SessionID string `json:"session_id"`
+		Token     string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &issued)
+
+	// Reads are not audited, writes are
+	tenancyRequest(router, issued.Token, "GET", "/api/auth/profile", "")
+	tenancyRequest(router, issued.Token, "POST", "/api/projects", `{}`)
+	time.Sleep(10 * time.Millisecond) // Distinct created_at for ordering
+	tenancyRequest(router, rootToken, "DELETE", "/api/admin/sessions/"+issued.SessionID, "")
+
+	var entries []AuditEntry
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/audit-log?client_id="+tenantClientID, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Invalid audit log response: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %+v", entries)
+	}
+
+	actions := map[string]AuditEntry{}
+	for _, entry := range entries {
+		actions[entry.Action] = entry
+		if entry.ActorID != "root-system" || entry.TargetID != "alice" {
+			t.Errorf("Expected root acting on alice, got %+v", entry)
+		}
+	}
+	if start := actions[AuditActionImpersonationStart]; start.Details["session_id"] != issued.SessionID || start.Details["username"] != "alice" {
+		t.Errorf("Unexpected impersonation.start entry: %+v", start)
+	}
+	if write := actions[AuditActionImpersonatedWrite]; write.Details["method"] != "POST" || write.Details["path"] != "/api/projects" || write.Details["status"] != float64(http.StatusCreated) {
+		t.Errorf("Unexpected impersonation.write entry: %+v", write)
+	}
+	if revoke := actions[AuditActionSessionRevoke]; revoke.Details["impersonated_by"] != "root-system" {
+		t.Errorf("Unexpected session.revoke entry: %+v", revoke)
+	}
+
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/audit-log?action="+AuditActionSessionRevoke, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 1 {
+		t.Errorf("Expected the action filter to return 1 entry, got %s", w.Body.String())
+	}
+}
+
+func TestSessionSemanticsWithCache(t *testing.T) {
+	app := newSessionsTestApp(t)
+	app.Sessions = auth.NewResolver(app.ZDB, time.Minute)
+	router := newSessionsTestRouter(app)
+	router.POST("/api/auth/logout", app.logoutHandler)
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	expectCode := func(token, path, code string) {
+		t.Helper()
+		w := tenancyRequest(router, token, "GET", path, "")
+		if !strings.Contains(w.Body.String(), `"code":"`+code+`"`) {
+			t.Errorf("Expected %s on %s, got %d: %s", code, path, w.Code, w.Body.String())
+		}
+	}
+	expectCode("unknown-token", "/api/auth/profile", apierror.CodeAuthSessionExpired)
+	expectCode("unknown-token", "/api/admin/sessions", apierror.CodeAuthSessionExpired)
+
+	// Revoking and logging out drop the cached session at once
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected alice's profile, got %d", w.Code)
+	}
+	row, err := app.ZDB.QueryRow(context.Background(), "SELECT id FROM sessions WHERE token_hash = $1", auth.HashToken(aliceToken))
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	sessionID, _ := row.Values[0].AsString()
+	if w := tenancyRequest(router, rootToken, "DELETE", "/api/admin/sessions/"+sessionID, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the session to be revoked, got %d", w.Code)
+	}
+	expectCode(aliceToken, "/api/auth/profile", apierror.CodeAuthSessionExpired)
+
+	aliceToken, _ = loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected alice's profile, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, aliceToken, "POST", "/api/auth/logout", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected logout to succeed, got %d", w.Code)
+	}
+	expectCode(aliceToken, "/api/auth/profile", apierror.CodeAuthSessionExpired)
+
+	// Inactive users are told so by every middleware, admin routes included
+	if _, err := app.ZDB.Execute(context.Background(), "UPDATE users SET is_active = false WHERE id = 'root-system'"); err != nil {
+		t.Fatalf("Failed to deactivate root: %v", err)
+	}
+	app.Sessions.InvalidateUser("root-system")
+	expectCode(rootToken, "/api/auth/profile", apierror.CodeAuthAccountInactive)
+	expectCode(rootToken, "/api/admin/sessions", apierror.CodeAuthAccountInactive)
+}
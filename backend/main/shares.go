@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/widget"
+)
+
+type createShareRequest struct {
+	IncludeTools bool       `json:"include_tools"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// ipRateLimiter keeps a sliding window limiter per client IP. Limiters idle for
+// longer than the window are dropped so the map does not grow without bound;
+// the map is swept at most once per window so a request costs O(1) amortized.
+type ipRateLimiter struct {
+	mutex     sync.Mutex
+	limit     int
+	window    time.Duration
+	limiters  map[string]*widget.RateLimiter
+	lastSeen  map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newIPRateLimiter(limit int, window time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:     limit,
+		window:    window,
+		limiters:  make(map[string]*widget.RateLimiter),
+		lastSeen:  make(map[string]time.Time),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow records a request from ip and reports whether it is within the limit
+func (l *ipRateLimiter) Allow(ip string) bool {
+	l.mutex.Lock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > l.window {
+		l.sweep(now)
+	}
+	limiter, exists := l.limiters[ip]
+	if !exists {
+		limiter = widget.NewRateLimiter(l.limit, l.window)
+		l.limiters[ip] = limiter
+	}
+	l.lastSeen[ip] = now
+	l.mutex.Unlock()
+
+	return limiter.Allow()
+}
+
+// sweep drops the limiters idle for longer than the window; the caller holds the mutex
+func (l *ipRateLimiter) sweep(now time.Time) {
+	for key, seen := range l.lastSeen {
+		if now.Sub(seen) > l.window {
+			delete(l.limiters, key)
+			delete(l.lastSeen, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// createShareHandler creates a read-only link to one of the caller's conversations
+func (app *App) createShareHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req createShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "expires_at"})
+		return
+	}
+
+	share, err := chat.CreateShare(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"), req.IncludeTools, req.ExpiresAt)
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"share": share, "path": "/api/shared/" + share.Token})
+}
+
+// getSharesHandler lists the shares of one of the caller's conversations that have not been revoked
+func (app *App) getSharesHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	shares, err := chat.ListShares(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"))
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+// revokeSharesHandler revokes the share named by :share_id, or every share of
+// the conversation when the route has none
+func (app *App) revokeSharesHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	revoked, err := chat.RevokeShares(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"), c.Param("share_id"))
+	switch {
+	case errors.Is(err, chat.ErrConversationNotFound):
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+	case errors.Is(err, chat.ErrShareNotFound):
+		apierror.Respond(c, apierror.CodeShareNotFound, nil)
+	case err != nil:
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{"success": true, "revoked": revoked})
+	}
+}
+
+// getSharedConversationHandler shows a shared conversation to anyone holding
+// its token. It needs no authentication and is rate limited per IP.
+func (app *App) getSharedConversationHandler(c *gin.Context) {
+	if app.ShareLimiter != nil && !app.ShareLimiter.Allow(app.clientIP(c)) {
+		apierror.Respond(c, apierror.CodeRateLimited, map[string]interface{}{"limit_per_minute": app.ShareLimiter.limit})
+		return
+	}
+
+	conversation, err := app.ChatService.GetConversationForShare(c.Request.Context(), c.Param("token"))
+	if errors.Is(err, chat.ErrShareNotFound) {
+		apierror.Respond(c, apierror.CodeShareNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	// Links can be revoked at any time, so responses are not cached
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+func newSharesTestRouter(t *testing.T) (*App, *gin.Engine) {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+	app.ChatService = chat.NewChatService(&tools.ZlayDBAdapter{DB: app.ZDB}, nil, nil, tools.NewToolRegistry())
+	app.ShareLimiter = newIPRateLimiter(100, time.Minute)
+
+	router := newTenancyTestRouter(app)
+	router.POST("/api/conversations/:id/share", app.authMiddleware(), app.createShareHandler)
+	router.GET("/api/conversations/:id/shares", app.authMiddleware(), app.getSharesHandler)
+	router.DELETE("/api/conversations/:id/shares", app.authMiddleware(), app.revokeSharesHandler)
+	router.DELETE("/api/conversations/:id/shares/:share_id", app.authMiddleware(), app.revokeSharesHandler)
+	router.GET("/api/shared/:token", app.getSharedConversationHandler)
+	return app, router
+}
+
+func createTestShare(t *testing.T, router *gin.Engine, body string) chat.Share {
+	t.Helper()
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/share", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating share, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Share chat.Share `json:"share"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Share.Token == "" {
+		t.Fatalf("Unexpected share response: %s", w.Body.String())
+	}
+	return resp.Share
+}
+
+func sharedRequest(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/shared/"+token, nil))
+	return w
+}
+
+func TestSharedConversationIsReadableWithoutAuthentication(t *testing.T) {
+	_, router := newSharesTestRouter(t)
+	share := createTestShare(t, router, `{}`)
+
+	w := sharedRequest(router, share.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"content":"Hi"`) {
+		t.Errorf("Expected the transcript, got %s", body)
+	}
+	for _, identifier := range []string{"user-a", "project-a", "client-a"} {
+		if strings.Contains(body, identifier) {
+			t.Errorf("Expected %s to be stripped, got %s", identifier, body)
+		}
+	}
+
+	// Other users cannot share or list someone else's conversation
+	if w := tenancyRequest(router, "token-b", "POST", "/api/conversations/conversation-a/share", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 sharing another user's conversation, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-b", "GET", "/api/conversations/conversation-a/shares", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 listing another user's shares, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/share",
+		`{"expires_at":"2000-01-01T00:00:00Z"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an expiry in the past, got %d", w.Code)
+	}
+}
+
+func TestShareTokenIsNotASession(t *testing.T) {
+	_, router := newSharesTestRouter(t)
+	share := createTestShare(t, router, `{}`)
+
+	if w := tenancyRequest(router, share.Token, "GET", "/api/conversations/conversation-a/messages", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with the share token as a session cookie, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/api/conversations/conversation-a/shares", nil)
+	req.Header.Set("Authorization", "Bearer "+share.Token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with the share token as a bearer token, got %d", w.Code)
+	}
+}
+
+func TestRevokedAndExpiredSharesAreGone(t *testing.T) {
+	app, router := newSharesTestRouter(t)
+	first := createTestShare(t, router, `{}`)
+	second := createTestShare(t, router, `{"include_tools":true}`)
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/conversations/conversation-a/shares", "")
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), `"include_tools"`) != 2 {
+		t.Fatalf("Expected two shares listed, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"token"`) || strings.Contains(w.Body.String(), first.Token) {
+		t.Errorf("Expected the list to leave tokens out, got %s", w.Body.String())
+	}
+
+	if w := tenancyRequest(router, "token-a", "DELETE", "/api/conversations/conversation-a/shares/"+first.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 revoking share, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := sharedRequest(router, first.Token); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a revoked share, got %d", w.Code)
+	}
+	if w := sharedRequest(router, second.Token); w.Code != http.StatusOK {
+		t.Errorf("Expected the other share to keep working, got %d", w.Code)
+	}
+
+	if _, err := app.ZDB.Execute(context.Background(),
+		"UPDATE conversation_shares SET expires_at = $1 WHERE id = $2", time.Now().UTC().Add(-time.Minute), second.ID); err != nil {
+		t.Fatalf("Failed to expire share: %v", err)
+	}
+	if w := sharedRequest(router, second.Token); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an expired share, got %d", w.Code)
+	}
+}
+
+func TestSharedConversationIsRateLimitedPerIP(t *testing.T) {
+	app, router := newSharesTestRouter(t)
+	app.ShareLimiter = newIPRateLimiter(2, time.Minute)
+	share := createTestShare(t, router, `{}`)
+
+	for i := 0; i < 2; i++ {
+		if w := sharedRequest(router, share.Token); w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %d", i+1, w.Code)
+		}
+	}
+	if w := sharedRequest(router, share.Token); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 over the limit, got %d", w.Code)
+	}
+}
+
+func TestIPRateLimiterSweepsIdleLimitersOncePerWindow(t *testing.T) {
+	limiter := newIPRateLimiter(2, time.Minute)
+	clock := time.Now()
+	limiter.now = func() time.Time { return clock }
+	limiter.lastSweep = clock
+
+	clock = clock.Add(50 * time.Second)
+	limiter.Allow("10.0.0.1")
+	clock = clock.Add(15 * time.Second)
+	limiter.Allow("10.0.0.2")
+
+	// 10.0.0.1 is now idle past the window, but the last sweep is too recent
+	clock = clock.Add(50 * time.Second)
+	limiter.Allow("10.0.0.2")
+	if len(limiter.limiters) != 2 {
+		t.Fatalf("Expected no sweep within the window, got %d limiters", len(limiter.limiters))
+	}
+
+	clock = clock.Add(11 * time.Second)
+	limiter.Allow("10.0.0.3")
+	if _, kept := limiter.limiters["10.0.0.1"]; kept || len(limiter.limiters) != 2 || len(limiter.lastSeen) != 2 {
+		t.Errorf("Expected 10.0.0.1 dropped by the sweep, got %v", limiter.lastSeen)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
+)
+
+// newSQLiteAppTestApp starts the application the way main does, against an
+// in-memory SQLite database: migrations, bootstrap and the full router
+func newSQLiteAppTestApp(t *testing.T) *App {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.DatabaseType = "sqlite"
+	cfg.DatabaseURL = ":memory:"
+	cfg.RootPassword = "integration-secret"
+	cfg.BootstrapDomain = "integration.example"
+	cfg.FilesDir = t.TempDir()
+	cfg.TenantExportsDir = t.TempDir()
+	cfg.SaveJournalPath = filepath.Join(t.TempDir(), "save_journal.jsonl")
+
+	app := &App{Config: cfg}
+	if err := app.InitZDB(); err != nil {
+		t.Fatalf("Failed to open the application database: %v", err)
+	}
+	t.Cleanup(func() { app.ZDB.Close() })
+	if err := runMigrations(app.ZDB); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if err := app.bootstrap(false); err != nil {
+		t.Fatalf("Failed to bootstrap: %v", err)
+	}
+	app.InitRouter()
+	gin.SetMode(gin.TestMode)
+	return app
+}
+
+// decodeSQLiteResponse checks the status and decodes the JSON body into out
+func decodeSQLiteResponse(t *testing.T, w *httptest.ResponseRecorder, status int, out interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("Expected %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("Invalid response %q: %v", w.Body.String(), err)
+		}
+	}
+}
+
+func TestSQLiteApplicationDatabase(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+
+	// Sessions
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	var profile struct {
+		User struct {
+			ID       string `json:"id"`
+			ClientID string `json:"client_id"`
+			Username string `json:"username"`
+		} `json:"user"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/auth/profile", ""), http.StatusOK, &profile)
+	if profile.User.Username != bootstrap.RootUsername {
+		t.Fatalf("Unexpected profile: %+v", profile)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/sessions", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), profile.User.ID) {
+		t.Errorf("Expected root's session to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Clients and domains
+	var client Client
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients", `{"name": "Acme", "slug": "acme"}`), http.StatusCreated, &client)
+	if w := tenancyRequest(router, token, "POST", "/api/admin/clients", `{"name": "Acme again", "slug": "acme"}`); w.Code < 400 {
+		t.Errorf("Expected a duplicate slug to be rejected, got %d", w.Code)
+	}
+	var domain Domain
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "acme.example"}`), http.StatusCreated, &domain)
+	if w := tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "acme.example"}`); w.Code < 400 {
+		t.Errorf("Expected a taken domain to be rejected, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/domains", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "acme.example") {
+		t.Errorf("Expected the domain to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/admin/clients/"+client.ID,
+		`{"name": "Acme Corp", "stream_flush_chars": 80, "stream_flush_interval_ms": 0, "tool_result_token_limit": 1500, "daily_token_budget": 250000, "redact_sql_literals": true, "version": 1}`), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "GET", "/api/admin/clients", ""); !strings.Contains(w.Body.String(), `"stream_flush_chars":80,"stream_flush_interval_ms":null`) {
+		t.Errorf("Expected the client's flush size with the default interval, got %d: %s", w.Code, w.Body.String())
+	} else if !strings.Contains(w.Body.String(), `"tool_result_token_limit":1500,"daily_token_budget":250000,"redact_sql_literals":true`) {
+		t.Errorf("Expected the client's tool result limit, token budget and SQL privacy setting, got %s", w.Body.String())
+	}
+	var shopDomain Domain
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "https://Shop.Acme.example:8443/"}`), http.StatusCreated, &shopDomain)
+	if shopDomain.Domain != "shop.acme.example" {
+		t.Errorf("Expected the domain to be normalized, got %q", shopDomain.Domain)
+	}
+	if w := tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "ACME.example"}`); w.Code < 400 {
+		t.Errorf("Expected a domain differing only in case to be rejected, got %d", w.Code)
+	}
+	for _, invalid := range []string{"*.example", "shop.*.acme.example", "acme example"} {
+		if w := tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "`+invalid+`"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "DOMAIN_INVALID") {
+			t.Errorf("Expected %q to be rejected as invalid, got %d: %s", invalid, w.Code, w.Body.String())
+		}
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/admin/domains/"+shopDomain.ID, `{"domain": "*.Acme.example", "version": 1}`), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "GET", "/api/admin/domains", ""); !strings.Contains(w.Body.String(), `"*.acme.example"`) {
+		t.Errorf("Expected the wildcard domain to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/admin/clients/"+client.ID,
+		`{"branding": {"welcome_message": "Welcome to Acme", "colors": {"primary": "#FF0000"}}, "version": 2}`), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "GET", "/api/admin/clients", ""); !strings.Contains(w.Body.String(), `"welcome_message":"Welcome to Acme"`) {
+		t.Errorf("Expected the client's branding to be listed, got %d: %s", w.Code, w.Body.String())
+	}
+	configReq := httptest.NewRequest("GET", "/api/widget/config", nil)
+	configReq.Header.Set("Origin", "https://eu.acme.example")
+	configW := httptest.NewRecorder()
+	router.ServeHTTP(configW, configReq)
+	var widgetConfig widgetConfigResponse
+	decodeSQLiteResponse(t, configW, http.StatusOK, &widgetConfig)
+	if widgetConfig.Branding.DisplayName != "Acme Corp" || widgetConfig.Branding.Colors["primary"] != "#ff0000" || configW.Header().Get("ETag") == "" {
+		t.Errorf("Expected the wildcard domain's branding, got %+v", widgetConfig.Branding)
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/admin/domains/"+domain.ID, `{"is_active": false, "version": 1}`), http.StatusOK, nil)
+
+	// Projects and datasources
+	var project Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Reports"}`), http.StatusCreated, &project)
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/projects/"+project.ID, `{"description": "Monthly numbers", "version": 1}`), http.StatusOK, nil)
+	var datasource Datasource
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/datasources",
+		`{"project_id": "`+project.ID+`", "name": "Warehouse", "type": "sqlite", "config": {"path": "warehouse.db"}}`), http.StatusCreated, &datasource)
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/datasources/"+datasource.ID, `{"name": "Warehouse v2", "version": 1}`), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "GET", "/api/datasources/"+datasource.ID, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Warehouse v2") {
+		t.Errorf("Expected the renamed datasource, got %d: %s", w.Code, w.Body.String())
+	}
+	// Disabling twice goes through the insert and then the update path
+	for i := 0; i < 2; i++ {
+		decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/projects/"+project.ID+"/tools/system_info", `{"enabled": false}`), http.StatusOK, nil)
+	}
+
+	// Conversations and messages
+	var created struct {
+		Conversation chat.Conversation `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/conversations", `{"project_id": "`+project.ID+`"}`), http.StatusCreated, &created)
+	conversationID := created.Conversation.ID
+	var participants struct {
+		Participants []chat.ConversationParticipant `json:"participants"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/participants", ""), http.StatusOK, &participants)
+	if len(participants.Participants) != 1 || participants.Participants[0].Role != chat.ParticipantRoleOwner {
+		t.Errorf("Expected root to own the conversation, got %+v", participants.Participants)
+	}
+
+	service := app.ChatService.WithLLMClient(&streamingLLMClient{deltas: []string{"Hi ", "there"}})
+	if err := service.ProcessUserMessage(&chat.ChatRequest{
+		ConversationID: conversationID,
+		Content:        "Hello",
+		UserID:         profile.User.ID,
+		ClientID:       profile.User.ClientID,
+		ProjectID:      project.ID,
+	}); err != nil {
+		t.Fatalf("Failed to process the message: %v", err)
+	}
+
+	var history struct {
+		Conversation struct {
+			Messages []Message `json:"messages"`
+		} `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/messages", ""), http.StatusOK, &history)
+	if len(history.Conversation.Messages) != 2 || history.Conversation.Messages[0].Content != "Hello" || history.Conversation.Messages[1].Content != "Hi there" {
+		t.Fatalf("Expected the exchange to be stored, got %+v", history.Conversation.Messages)
+	}
+
+	assistantID := history.Conversation.Messages[1].ID
+
+	// History pages back from the newest message
+	var page struct {
+		Conversation struct {
+			Messages []Message `json:"messages"`
+		} `json:"conversation"`
+		HasMore           bool `json:"has_more"`
+		TotalMessageCount int  `json:"total_message_count"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/messages?limit=1", ""), http.StatusOK, &page)
+	if len(page.Conversation.Messages) != 1 || page.Conversation.Messages[0].ID != assistantID || !page.HasMore || page.TotalMessageCount != 2 {
+		t.Fatalf("Expected the newest message with more to load, got %+v", page)
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/messages?limit=1&before_message_id="+assistantID, ""), http.StatusOK, &page)
+	if len(page.Conversation.Messages) != 1 || page.Conversation.Messages[0].Content != "Hello" || page.HasMore {
+		t.Fatalf("Expected the first message and nothing older, got %+v", page)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/messages?before_message_id=missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown cursor, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/conversations/"+conversationID+"/messages?limit=0", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a zero limit, got %d", w.Code)
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/messages/"+assistantID+"/feedback", `{"rating": 1}`), http.StatusOK, nil)
+	var rated struct {
+		Feedback chat.MessageFeedback `json:"feedback"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/messages/"+assistantID+"/feedback", `{"rating": -1, "comment": "Wrong"}`), http.StatusOK, &rated)
+	if rated.Feedback.Rating != -1 || rated.Feedback.Comment != "Wrong" {
+		t.Errorf("Expected the rating to be replaced, got %+v", rated.Feedback)
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "PUT", "/api/conversations/"+conversationID+"/pin", `{"version": 1}`), http.StatusOK, nil)
+
+	// Logging out ends the session
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/auth/logout", ""), http.StatusOK, nil)
+	if w := tenancyRequest(router, token, "GET", "/api/auth/profile", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to be gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/templates"
+	"zlay-backend/internal/tools"
+)
+
+// bindTemplateInput reads and normalizes a template from the request body
+func bindTemplateInput(c *gin.Context) (templates.Input, bool) {
+	var in templates.Input
+	if err := c.ShouldBindJSON(&in); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return in, false
+	}
+	if err := in.Normalize(); err != nil {
+		code, details := templates.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return in, false
+	}
+	return in, true
+}
+
+// getTemplatesHandler lists the prompt templates of a project
+func (app *App) getTemplatesHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	list, err := templates.List(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": list})
+}
+
+// getTemplateHandler returns one prompt template of a project
+func (app *App) getTemplateHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleViewer)
+	if !ok {
+		return
+	}
+
+	template, err := templates.Get(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("template_id"))
+	if err != nil {
+		code, details := templates.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// createTemplateHandler saves a new prompt template; editors and above only
+func (app *App) createTemplateHandler(c *gin.Context) {
+	user, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+	in, ok := bindTemplateInput(c)
+	if !ok {
+		return
+	}
+
+	template, err := templates.Create(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, user.ID, in)
+	if err != nil {
+		code, details := templates.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusCreated, template)
+}
+
+// updateTemplateHandler replaces a prompt template; editors and above only
+func (app *App) updateTemplateHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+	in, ok := bindTemplateInput(c)
+	if !ok {
+		return
+	}
+
+	template, err := templates.Update(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("template_id"), in)
+	if err != nil {
+		code, details := templates.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// deleteTemplateHandler removes a prompt template; editors and above only
+func (app *App) deleteTemplateHandler(c *gin.Context) {
+	_, projectID, ok := app.authorizeProject(c, tools.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := templates.Delete(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, projectID, c.Param("template_id")); err != nil {
+		code, details := templates.ErrorCode(err)
+		apierror.Respond(c, code, details)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/templates"
+)
+
+func newTemplatesTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/projects/:id/templates", app.authMiddleware(), app.getTemplatesHandler)
+	router.POST("/api/projects/:id/templates", app.authMiddleware(), app.createTemplateHandler)
+	router.GET("/api/projects/:id/templates/:template_id", app.authMiddleware(), app.getTemplateHandler)
+	router.PUT("/api/projects/:id/templates/:template_id", app.authMiddleware(), app.updateTemplateHandler)
+	router.DELETE("/api/projects/:id/templates/:template_id", app.authMiddleware(), app.deleteTemplateHandler)
+	return router
+}
+
+func TestTemplatesCRUD(t *testing.T) {
+	router := newTemplatesTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/templates",
+		`{"name":" Weekly sales ","description":"Per region","content":"Sales for {{region}} since {{start}}"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created templates.Template
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	if created.Name != "Weekly sales" || created.CreatedBy != "user-a" || strings.Join(created.Variables, ",") != "region,start" {
+		t.Errorf("Expected a trimmed name, the creator and the variables, got %+v", created)
+	}
+
+	path := "/api/projects/project-a/templates/" + created.ID
+	if w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/templates",
+		`{"name":"WEEKLY SALES","content":"Again"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate name, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "PUT", path, `{"name":"Weekly sales","content":"Sales for {{region}}"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"variables":["region"]`) {
+		t.Errorf("Expected the update to keep its own name, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = tenancyRequest(router, "token-a", "GET", "/api/projects/project-a/templates", "")
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), `"id"`) != 1 {
+		t.Errorf("Expected one template, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := tenancyRequest(router, "token-a", "DELETE", path, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 deleting, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after deleting, got %d", w.Code)
+	}
+}
+
+func TestTemplatesRejectInvalidContent(t *testing.T) {
+	router := newTemplatesTestRouter(t)
+
+	for body, field := range map[string]string{
+		`{"name":"","content":"Hi"}`:                   "name",
+		`{"name":"Greeting","content":"  "}`:           "content",
+		`{"name":"Greeting","content":"Hi {{name"}`:    "content",
+		`{"name":"Greeting","content":"{{a {{b}} }}"}`: "content",
+	} {
+		w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/templates", body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "TEMPLATE_INVALID") ||
+			!strings.Contains(w.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("%s: expected TEMPLATE_INVALID for %s, got %d: %s", body, field, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTemplatesRequireProjectRole(t *testing.T) {
+	router := newTemplatesTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/projects/project-a/templates", `{"name":"Greeting","content":"Hi"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created templates.Template
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// user-b has no role in project-a
+	for _, r := range []struct{ method, path, body string }{
+		{"GET", "/api/projects/project-a/templates", ""},
+		{"POST", "/api/projects/project-a/templates", `{"name":"Other","content":"Hi"}`},
+		{"GET", "/api/projects/project-a/templates/" + created.ID, ""},
+		{"PUT", "/api/projects/project-a/templates/" + created.ID, `{"name":"Other","content":"Hi"}`},
+		{"DELETE", "/api/projects/project-a/templates/" + created.ID, ""},
+	} {
+		if w := tenancyRequest(router, "token-b", r.method, r.path, r.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404 without a project role, got %d", r.method, r.path, w.Code)
+		}
+	}
+
+	// Templates of another project are not found through this one
+	if w := tenancyRequest(router, "token-b", "GET", "/api/projects/project-b/templates/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another project's template, got %d", w.Code)
+	}
+}
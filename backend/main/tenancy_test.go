@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/tools"
+)
+
+// newTenancyTestApp seeds two clients, each with one user owning a project,
+// a datasource and a conversation (a deleted one for client B)
+func newTenancyTestApp(t *testing.T) *App {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb, "INSERT INTO clients (id, name, slug) VALUES ('client-a', 'A', 'a'), ('client-b', 'B', 'b')")
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour).Format("2006-01-02 15:04:05")
+	for _, tenant := range []string{"a", "b"} {
+		seed := []struct {
+			query string
+			args  []interface{}
+		}{
+			{"INSERT INTO users (id, client_id, username, password_hash, is_active, created_at) VALUES ($1, $2, 'user', 'x', true, $3)",
+				[]interface{}{"user-" + tenant, "client-" + tenant, now}},
+			{"INSERT INTO sessions (id, client_id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+				[]interface{}{"session-" + tenant, "client-" + tenant, "user-" + tenant, tenancyTokenHash("token-" + tenant), expires, now}},
+			{"INSERT INTO projects (id, user_id, name, description, is_active, created_at) VALUES ($1, $2, 'Project', '', true, $3)",
+				[]interface{}{"project-" + tenant, "user-" + tenant, now}},
+			{"INSERT INTO datasources (id, project_id, name, type, config, is_active, created_at) VALUES ($1, $2, 'Warehouse', 'postgres', '{}', true, $3)",
+				[]interface{}{"datasource-" + tenant, "project-" + tenant, now}},
+			{"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ($1, 'Chat', $2, $3, 'completed', $4, $4)",
+				[]interface{}{"conversation-" + tenant, "user-" + tenant, "project-" + tenant, now}},
+			{"INSERT INTO messages (id, conversation_id, role, content, created_at) VALUES ($1, $2, 'assistant', 'Hi', $3)",
+				[]interface{}{"message-" + tenant, "conversation-" + tenant, now}},
+		}
+		for _, s := range seed {
+			if _, err := zdb.Execute(ctx, s.query, s.args...); err != nil {
+				t.Fatalf("Failed to seed tenant %s: %v", tenant, err)
+			}
+		}
+	}
+	if _, err := zdb.Execute(ctx, "UPDATE conversations SET deleted_at = $1 WHERE id = 'conversation-b'", now); err != nil {
+		t.Fatalf("Failed to soft delete conversation: %v", err)
+	}
+
+	app := &App{Config: config.Default(), ZDB: zdb, ToolRegistry: tools.NewDefaultToolRegistry()}
+	app.ChatService = chat.NewChatService(&tools.ZlayDBAdapter{DB: zdb}, nil, nil, tools.NewToolRegistry())
+	return app
+}
+
+func tenancyTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func newTenancyTestRouter(app *App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/projects/:id", app.authMiddleware(), app.getProjectHandler)
+	router.PUT("/api/projects/:id", app.authMiddleware(), app.updateProjectHandler)
+	router.GET("/api/projects/:id/retention/status", app.authMiddleware(), app.getProjectRetentionStatusHandler)
+	router.DELETE("/api/projects/:id", app.authMiddleware(), app.deleteProjectHandler)
+	router.PUT("/api/projects/:id/tools/:name", app.authMiddleware(), app.updateProjectToolHandler)
+	router.GET("/api/datasources", app.authMiddleware(), app.getDatasourcesHandler)
+	router.POST("/api/datasources", app.authMiddleware(), app.createDatasourceHandler)
+	router.GET("/api/datasources/:id", app.authMiddleware(), app.getDatasourceHandler)
+	router.PUT("/api/datasources/:id", app.authMiddleware(), app.updateDatasourceHandler)
+	router.DELETE("/api/datasources/:id", app.authMiddleware(), app.deleteDatasourceHandler)
+	router.POST("/api/conversations", app.authMiddleware(), app.createConversationHandler)
+	router.GET("/api/conversations/:id/messages", app.authMiddleware(), app.getConversationMessagesHandler)
+	router.POST("/api/conversations/:id/restore", app.authMiddleware(), app.restoreConversationHandler)
+	router.PUT("/api/conversations/:id/pin", app.authMiddleware(), app.pinConversationHandler)
+	router.GET("/api/conversations/:id/export", app.exportConversationHandler)
+	router.POST("/api/messages/:id/feedback", app.authMiddleware(), app.messageFeedbackHandler)
+	return router
+}
+
+func tenancyRequest(router *gin.Engine, token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCrossTenantAccessReturnsNotFound(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	// Client A's session against client B's resources; bodies are deliberately
+	// invalid so a late ownership check would surface as 400 instead of 404
+	tests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/api/projects/project-b", ""},
+		{"PUT", "/api/projects/project-b", "not json"},
+		{"DELETE", "/api/projects/project-b", ""},
+		{"PUT", "/api/projects/project-b/tools/system_info", "not json"},
+		{"GET", "/api/datasources?project_id=project-b", ""},
+		{"POST", "/api/datasources", `{"project_id":"project-b","name":"Stolen","type":"postgres","config":{}}`},
+		{"GET", "/api/datasources/datasource-b", ""},
+		{"PUT", "/api/datasources/datasource-b", "not json"},
+		{"DELETE", "/api/datasources/datasource-b", ""},
+		{"POST", "/api/conversations", `{"project_id":"project-b","model":"unlisted"}`},
+		{"GET", "/api/conversations/conversation-b/messages", ""},
+		{"POST", "/api/conversations/conversation-b/restore", ""},
+		{"PUT", "/api/conversations/conversation-b/pin", `{"version":1}`},
+		{"GET", "/api/conversations/conversation-b/export", ""},
+		{"POST", "/api/messages/message-b/feedback", `{"rating":1}`},
+	}
+	for _, tt := range tests {
+		w := tenancyRequest(router, "token-a", tt.method, tt.path, tt.body)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d: %s", tt.method, tt.path, w.Code, w.Body.String())
+		}
+	}
+
+	// Nothing belonging to client B was changed or created
+	for query, want := range map[string]int64{
+		"SELECT COUNT(*) FROM projects WHERE id = 'project-b' AND is_active = true":                 1,
+		"SELECT COUNT(*) FROM datasources WHERE project_id = 'project-b' AND is_active = true":      1,
+		"SELECT COUNT(*) FROM conversations WHERE id = 'conversation-b' AND deleted_at IS NOT NULL": 1,
+	} {
+		if got := countTenancyRows(t, app, query); got != want {
+			t.Errorf("Client B's data was modified: %s returned %d", query, got)
+		}
+	}
+}
+
+func TestSameTenantAccessSucceeds(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/api/projects/project-b", "", http.StatusOK},
+		{"GET", "/api/datasources", "", http.StatusOK},
+		{"GET", "/api/datasources/datasource-b", "", http.StatusOK},
+		{"POST", "/api/datasources", `{"project_id":"project-b","name":"Second","type":"postgres","config":{}}`, http.StatusCreated},
+		{"POST", "/api/conversations/conversation-b/restore", "", http.StatusOK},
+		// Restoring bumped the version
+		{"PUT", "/api/conversations/conversation-b/pin", `{"pinned":true,"version":2}`, http.StatusOK},
+		{"GET", "/api/conversations/conversation-b/messages", "", http.StatusOK},
+		{"POST", "/api/messages/message-b/feedback", `{"rating":-1,"comment":"Too vague"}`, http.StatusOK},
+		{"DELETE", "/api/datasources/datasource-b", "", http.StatusOK},
+		{"DELETE", "/api/projects/project-b", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := tenancyRequest(router, "token-b", tt.method, tt.path, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d: %s", tt.method, tt.path, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func countTenancyRows(t *testing.T, app *App, query string) int64 {
+	t.Helper()
+
+	row, err := app.ZDB.QueryRow(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Failed to count rows: %v", err)
+	}
+	n, _ := row.Values[0].AsInt64()
+	return n
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/db"
+	"zlay-backend/internal/tenantdata"
+)
+
+// purgeDisconnectReason is sent to the connections closed by a client purge
+const purgeDisconnectReason = "This account has been deleted"
+
+type purgeClientRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+// tenantClient loads the id and slug of the client in the :id parameter,
+// writing the error response when it does not exist
+func (app *App) tenantClient(c *gin.Context) (string, string, bool) {
+	clientID := c.Param("id")
+	row, err := app.ZDB.QueryRow(c.Request.Context(), "SELECT slug FROM clients WHERE id = $1", clientID)
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return "", "", false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return "", "", false
+	}
+	slug, _ := row.Values[0].AsString()
+	return clientID, slug, true
+}
+
+// exportClientHandler starts building an archive of everything stored for a client
+func (app *App) exportClientHandler(c *gin.Context) {
+	clientID, _, ok := app.tenantClient(c)
+	if !ok {
+		return
+	}
+
+	job, err := app.TenantJobs.StartExport(c.Request.Context(), clientID, c.GetString("user_id"))
+	if err != nil {
+		log.Printf("Failed to start export of client %s: %v", clientID, err)
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	app.recordAudit(c.Request.Context(), AuditEntry{
+		ActorID:    c.GetString("user_id"),
+		ClientID:   clientID,
+		IP:         app.clientIP(c),
+		Action:     AuditActionClientExport,
+		TargetType: "client",
+		TargetID:   clientID,
+		Details:    map[string]interface{}{"job_id": job.ID},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job, "status_url": "/api/admin/exports/" + job.ID})
+}
+
+// purgeClientHandler deletes everything stored for a client in two calls: the
+// first returns a confirmation token, the second redeems it and starts the purge
+func (app *App) purgeClientHandler(c *gin.Context) {
+	clientID, slug, ok := app.tenantClient(c)
+	if !ok {
+		return
+	}
+	// Purging the system client would delete root
+	if slug == bootstrap.SystemClientSlug {
+		apierror.Respond(c, apierror.CodeForbidden, nil)
+		return
+	}
+
+	var req purgeClientRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+			return
+		}
+	}
+	actorID := c.GetString("user_id")
+	if req.ConfirmationToken == "" {
+		token, expiresAt := app.TenantJobs.RequestPurge(clientID, actorID)
+		c.JSON(http.StatusOK, gin.H{
+			"client_id":          clientID,
+			"slug":               slug,
+			"confirmation_token": token,
+			"expires_at":         expiresAt.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// The users are looked up before the purge starts deleting them
+	userIDs, err := app.clientUserIDs(ctx, clientID)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	job, err := app.TenantJobs.StartPurge(ctx, clientID, actorID, req.ConfirmationToken)
+	if errors.Is(err, tenantdata.ErrInvalidConfirmation) {
+		apierror.Respond(c, apierror.CodePurgeConfirmationInvalid, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start purge of client %s: %v", clientID, err)
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	// The client is inactive from here, so nothing reconnects or refills the caches
+	disconnected := 0
+	for _, userID := range userIDs {
+		if app.WSServer != nil {
+			disconnected += app.WSServer.ForceDisconnect(userID, "", purgeDisconnectReason)
+		}
+		if app.Sessions != nil {
+			app.Sessions.InvalidateUser(userID)
+		}
+	}
+	app.forgetClient(ctx, clientID)
+
+	// The entry is not filed under the client, so the purge does not anonymize it
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    actorID,
+		IP:         app.clientIP(c),
+		Action:     AuditActionClientPurge,
+		TargetType: "client",
+		TargetID:   clientID,
+		Details:    map[string]interface{}{"job_id": job.ID, "slug": slug, "users": len(userIDs), "connections": disconnected},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job, "status_url": "/api/admin/purges/" + job.ID})
+}
+
+// clientUserIDs lists the ids of every user of a client
+func (app *App) clientUserIDs(ctx context.Context, clientID string) ([]string, error) {
+	resultSet, err := app.ZDB.Query(ctx, "SELECT id FROM users WHERE client_id = $1", clientID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		if id, ok := row.Values[0].AsString(); ok {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, nil
+}
+
+// forgetClient drops the client's cached LLM settings and domains
+func (app *App) forgetClient(ctx context.Context, clientID string) {
+	if app.ClientConfigCache != nil {
+		app.ClientConfigCache.InvalidateClientConfig(clientID)
+	}
+	app.DomainCache.Clear()
+}
+
+// onClientPurged clears the caches again once a purge has deleted the client
+func (app *App) onClientPurged(job *tenantdata.Job) {
+	app.forgetClient(context.Background(), job.ClientID)
+}
+
+// loadTenantJob returns the job of the kind in the :job_id parameter, writing
+// the error response otherwise
+func (app *App) loadTenantJob(c *gin.Context, kind string) (*tenantdata.Job, bool) {
+	job, err := app.TenantJobs.Get(c.Request.Context(), c.Param("job_id"))
+	if errors.Is(err, tenantdata.ErrJobNotFound) || (err == nil && job.Kind != kind) {
+		apierror.Respond(c, apierror.CodeTenantJobNotFound, nil)
+		return nil, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return nil, false
+	}
+	return job, true
+}
+
+// getExportHandler returns the status of an export, with a download link once it is complete
+func (app *App) getExportHandler(c *gin.Context) {
+	job, ok := app.loadTenantJob(c, tenantdata.KindExport)
+	if !ok {
+		return
+	}
+	response := gin.H{"job": job}
+	if job.Status == tenantdata.StatusCompleted {
+		response["download_url"] = "/api/admin/exports/" + job.ID + "/download"
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// downloadExportHandler serves the gzip'd JSON archive of a completed export
+func (app *App) downloadExportHandler(c *gin.Context) {
+	job, ok := app.loadTenantJob(c, tenantdata.KindExport)
+	if !ok {
+		return
+	}
+	if job.Status != tenantdata.StatusCompleted || job.ResultPath == "" {
+		apierror.Respond(c, apierror.CodeExportNotReady, map[string]interface{}{"status": job.Status})
+		return
+	}
+	c.Header("Content-Type", "application/gzip")
+	c.FileAttachment(job.ResultPath, "client-"+job.ClientID+"-export.json.gz")
+}
+
+// getPurgeHandler returns the status of a purge with the rows deleted so far
+func (app *App) getPurgeHandler(c *gin.Context) {
+	job, ok := app.loadTenantJob(c, tenantdata.KindPurge)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/tenantdata"
+)
+
+func TestClientExportAndPurge(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var client Client
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients", `{"name": "Acme", "slug": "acme"}`), http.StatusCreated, &client)
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "acme.example"}`), http.StatusCreated, nil)
+
+	// Export
+	var started struct {
+		Job       tenantdata.Job `json:"job"`
+		StatusURL string         `json:"status_url"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients/"+client.ID+"/export", ""), http.StatusAccepted, &started)
+	if started.StatusURL != "/api/admin/exports/"+started.Job.ID {
+		t.Fatalf("Unexpected export response: %+v", started)
+	}
+	app.TenantJobs.Wait()
+	var status struct {
+		Job         tenantdata.Job `json:"job"`
+		DownloadURL string         `json:"download_url"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", started.StatusURL, ""), http.StatusOK, &status)
+	if status.Job.Status != tenantdata.StatusCompleted || status.DownloadURL == "" {
+		t.Fatalf("Expected the export to complete, got %+v", status)
+	}
+	w = tenancyRequest(router, token, "GET", status.DownloadURL, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("Expected the archive, got %d: %s", w.Code, w.Header())
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Archive is not gzip'd: %v", err)
+	}
+	var archive struct {
+		Client map[string]interface{} `json:"client"`
+	}
+	if err := json.NewDecoder(reader).Decode(&archive); err != nil || archive.Client["slug"] != "acme" {
+		t.Errorf("Expected the archive of the client, got %v (%v)", archive.Client, err)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/purges/"+started.Job.ID, ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "TENANT_JOB_NOT_FOUND") {
+		t.Errorf("Expected an export not to be served as a purge, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Purge
+	row, err := app.ZDB.QueryRow(t.Context(), "SELECT id FROM clients WHERE slug = $1", bootstrap.SystemClientSlug)
+	if err != nil {
+		t.Fatalf("Failed to load the system client: %v", err)
+	}
+	systemID, _ := row.Values[0].AsString()
+	if w := tenancyRequest(router, token, "POST", "/api/admin/clients/"+systemID+"/purge", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the system client to be protected, got %d: %s", w.Code, w.Body.String())
+	}
+	var confirmation struct {
+		ConfirmationToken string `json:"confirmation_token"`
+		Slug              string `json:"slug"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients/"+client.ID+"/purge", ""), http.StatusOK, &confirmation)
+	if confirmation.ConfirmationToken == "" || confirmation.Slug != "acme" {
+		t.Fatalf("Expected a confirmation token, got %+v", confirmation)
+	}
+	if w := tenancyRequest(router, token, "POST", "/api/admin/clients/"+client.ID+"/purge", `{"confirmation_token": "wrong"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "PURGE_CONFIRMATION_INVALID") {
+		t.Errorf("Expected a wrong token to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients/"+client.ID+"/purge",
+		`{"confirmation_token": "`+confirmation.ConfirmationToken+`"}`), http.StatusAccepted, &started)
+	app.TenantJobs.Wait()
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "GET", started.StatusURL, ""), http.StatusOK, &status)
+	if status.Job.Status != tenantdata.StatusCompleted || status.Job.Counts["clients"] != 1 || status.Job.Counts["domains"] != 1 {
+		t.Fatalf("Expected the purge to complete, got %+v", status.Job)
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/clients", ""); strings.Contains(w.Body.String(), client.ID) {
+		t.Errorf("Expected the client to be gone, got %s", w.Body.String())
+	}
+	if _, found, _ := app.DomainCache.Get("acme.example"); found {
+		t.Error("Expected the client's domain to leave the cache")
+	}
+	if w := tenancyRequest(router, token, "GET", "/api/admin/audit-log?action=client.purge", ""); !strings.Contains(w.Body.String(), started.Job.ID) {
+		t.Errorf("Expected the purge to be audited, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/toolaudit"
+	"zlay-backend/internal/tools"
+)
+
+// adminToolExecutionsHandler lists the tool execution audit log of every
+// client, or of ?client_id=, newest first
+func (app *App) adminToolExecutionsHandler(c *gin.Context) {
+	options, ok := toolExecutionOptions(c)
+	if !ok {
+		return
+	}
+	if clientID := c.Query("client_id"); clientID != "" {
+		if _, err := uuid.Parse(clientID); err != nil {
+			apierror.Respond(c, apierror.CodeClientIDInvalid, nil)
+			return
+		}
+		options.ClientID = clientID
+	}
+	app.listToolExecutions(c, options)
+}
+
+// toolExecutionsAnalyticsHandler lists the tool execution audit log of the
+// current user's client, newest first
+func (app *App) toolExecutionsAnalyticsHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	options, ok := toolExecutionOptions(c)
+	if !ok {
+		return
+	}
+	options.ClientID = user.ClientID
+	app.listToolExecutions(c, options)
+}
+
+func (app *App) listToolExecutions(c *gin.Context, options toolaudit.ListOptions) {
+	page, err := toolaudit.List(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.readDB()}, options)
+	if errors.Is(err, toolaudit.ErrInvalidCursor) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "cursor"})
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// toolExecutionOptions reads the filters shared by the audit log listings:
+// ?user_id=, ?tool=, ?datasource_id= and ?status= match exactly, from and to
+// bound the start time as for analytics, and pages continue from ?cursor=,
+// the next_cursor of the previous page
+func toolExecutionOptions(c *gin.Context) (toolaudit.ListOptions, bool) {
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return toolaudit.ListOptions{}, false
+	}
+	options := toolaudit.ListOptions{
+		Filter: toolaudit.Filter{
+			UserID:       c.Query("user_id"),
+			ToolName:     c.Query("tool"),
+			DatasourceID: c.Query("datasource_id"),
+			Status:       c.Query("status"),
+			From:         filter.From,
+			To:           filter.To,
+		},
+		Cursor: c.Query("cursor"),
+	}
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "limit", "min": 1})
+			return options, false
+		}
+		options.Limit = parsed
+	}
+	return options, true
+}
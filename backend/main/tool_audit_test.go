@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/toolaudit"
+)
+
+// newToolAuditTestRouter records three tool executions by alice in the tenant
+// client, one of them failed, and one by olga in the other client
+func newToolAuditTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	app, router := newUsersTestApp(t)
+	ctx := context.Background()
+	at := time.Now().UTC().Add(-time.Hour)
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO tool_execution_audit (id, client_id, user_id, tool_name, datasource_id, source, status, sql_text, started_at)
+			VALUES ('exec-1', $1, 'alice', 'database_query', 'ds-1', 'llm', 'completed', 'SELECT 1', $2),
+			('exec-2', $1, 'alice', 'database_query', 'ds-1', 'user', 'failed', 'SELECT nope', $3),
+			('exec-3', $1, 'alice', 'web_search', NULL, 'llm', 'completed', NULL, $4),
+			('exec-4', $5, 'olga', 'database_query', 'ds-9', 'schedule', 'completed', 'SELECT 2', $4)`,
+			[]interface{}{tenantClientID, at, at.Add(time.Minute), at.Add(2 * time.Minute), otherClientID}},
+	} {
+		if _, err := app.ZDB.Execute(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to seed tool executions: %v", err)
+		}
+	}
+
+	router.GET("/api/admin/tool-executions", app.adminMiddleware(), app.adminToolExecutionsHandler)
+	router.GET("/api/analytics/tool-executions", app.authMiddleware(), app.clientAdminMiddleware(), app.toolExecutionsAnalyticsHandler)
+	return router
+}
+
+func decodeToolExecutions(t *testing.T, body []byte) toolaudit.Page {
+	t.Helper()
+
+	var page toolaudit.Page
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatalf("Failed to decode tool executions %q: %v", body, err)
+	}
+	return page
+}
+
+func executionIDs(page toolaudit.Page) []string {
+	ids := []string{}
+	for _, execution := range page.Executions {
+		ids = append(ids, execution.ID)
+	}
+	return ids
+}
+
+func TestAdminToolExecutionsRequiresSystemRoot(t *testing.T) {
+	router := newToolAuditTestRouter(t)
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	adminToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+
+	if w := tenancyRequest(router, adminToken, "GET", "/api/admin/tool-executions", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a tenant admin to be refused, got %d", w.Code)
+	}
+
+	w := tenancyRequest(router, rootToken, "GET", "/api/admin/tool-executions", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ids := executionIDs(decodeToolExecutions(t, w.Body.Bytes())); len(ids) != 4 || ids[0] != "exec-4" || ids[3] != "exec-1" {
+		t.Errorf("Expected every client's executions newest first, got %v", ids)
+	}
+
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/tool-executions?client_id="+tenantClientID+"&tool=database_query&status=failed", "")
+	if ids := executionIDs(decodeToolExecutions(t, w.Body.Bytes())); len(ids) != 1 || ids[0] != "exec-2" {
+		t.Errorf("Expected the tenant's failed query, got %v", ids)
+	}
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/tool-executions?datasource_id=ds-9&user_id=olga", "")
+	if ids := executionIDs(decodeToolExecutions(t, w.Body.Bytes())); len(ids) != 1 || ids[0] != "exec-4" {
+		t.Errorf("Expected olga's query of ds-9, got %v", ids)
+	}
+
+	for _, query := range []string{"?client_id=tenant", "?limit=0", "?from=yesterday", "?cursor=missing"} {
+		if w := tenancyRequest(router, rootToken, "GET", "/api/admin/tool-executions"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestToolExecutionsAnalyticsIsScopedToClient(t *testing.T) {
+	router := newToolAuditTestRouter(t)
+	adminToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/analytics/tool-executions", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused, got %d", w.Code)
+	}
+
+	seen := []string{}
+	path := "/api/analytics/tool-executions?limit=2"
+	for pages := 0; pages < 3; pages++ {
+		w := tenancyRequest(router, adminToken, "GET", path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		page := decodeToolExecutions(t, w.Body.Bytes())
+		seen = append(seen, executionIDs(page)...)
+		if !page.HasMore {
+			break
+		}
+		path = "/api/analytics/tool-executions?limit=2&cursor=" + page.NextCursor
+	}
+	if len(seen) != 3 || seen[0] != "exec-3" || seen[1] != "exec-2" || seen[2] != "exec-1" {
+		t.Errorf("Expected only the tenant's executions across pages, got %v", seen)
+	}
+
+	// Another client's execution is not a cursor the tenant may use
+	if w := tenancyRequest(router, adminToken, "GET", "/api/analytics/tool-executions?cursor=exec-4", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another client's cursor, got %d", w.Code)
+	}
+	w := tenancyRequest(router, adminToken, "GET", "/api/analytics/tool-executions?client_id="+otherClientID, "")
+	if ids := executionIDs(decodeToolExecutions(t, w.Body.Bytes())); len(ids) != 3 {
+		t.Errorf("Expected client_id to be ignored for tenant admins, got %v", ids)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/tools"
+)
+
+type rerunToolCallRequest struct {
+	Force bool `json:"force"` // Re-run a tool that can modify data; editors and above only
+}
+
+// getToolCallsHandler lists every tool call in one of the caller's conversations, oldest first
+func (app *App) getToolCallsHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	records, err := chat.ListToolCalls(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB},
+		user.ID, user.ClientID, c.Param("id"))
+	if errors.Is(err, chat.ErrConversationNotFound) {
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tool_calls": records})
+}
+
+// rerunToolCallHandler runs a tool call again with its original arguments under
+// the caller's current permissions and stores the result next to the original.
+// Tools that can modify data are only re-run with force=true by an editor or above.
+func (app *App) rerunToolCallHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req rerunToolCallRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+			return
+		}
+	}
+
+	conn := &tools.ZlayDBAdapter{DB: app.ZDB}
+	conversationID := c.Param("id")
+	call, projectID, err := chat.FindToolCall(ctx, conn, user.ID, user.ClientID, conversationID, c.Param("tool_call_id"))
+	switch {
+	case errors.Is(err, chat.ErrConversationNotFound):
+		apierror.Respond(c, apierror.CodeConversationNotFound, nil)
+		return
+	case errors.Is(err, chat.ErrToolCallNotFound):
+		apierror.Respond(c, apierror.CodeToolCallNotFound, nil)
+		return
+	case err != nil:
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	toolName := call.Function.Name
+	tool, exists := app.ToolRegistry.GetTool(toolName)
+	if !exists || !app.ToolRegistry.IsToolEnabled(projectID, toolName) {
+		apierror.Respond(c, apierror.CodeToolUnavailable, map[string]interface{}{"tool": toolName})
+		return
+	}
+
+	params := call.ParsedArguments()
+	if tools.HasSideEffects(tool, params) {
+		if !req.Force {
+			apierror.Respond(c, apierror.CodeToolHasSideEffects, map[string]interface{}{"tool": toolName})
+			return
+		}
+		role, err := tools.NewDBPermissionChecker(conn).GetProjectRole(ctx, user.ID, projectID)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		if role < tools.RoleEditor {
+			apierror.Respond(c, apierror.CodeForbidden, nil)
+			return
+		}
+	}
+
+	startTime := time.Now()
+	result, err := app.ToolRegistry.ExecuteTool(tools.WithExecutionSource(ctx, user.ClientID, tools.SourceRerun), user.ID, projectID, toolName, params)
+	switch {
+	case errors.Is(err, tools.ErrToolAccessDenied):
+		apierror.Respond(c, apierror.CodeForbidden, nil)
+		return
+	case errors.Is(err, tools.ErrToolNotFound), errors.Is(err, tools.ErrToolDisabled):
+		apierror.Respond(c, apierror.CodeToolUnavailable, map[string]interface{}{"tool": toolName})
+		return
+	}
+
+	rerun := chat.ToolCallRerun{
+		Status:     "completed",
+		DurationMs: int(time.Since(startTime).Milliseconds()),
+		Forced:     req.Force,
+		RerunBy:    user.ID,
+		RerunAt:    time.Now().UTC(),
+	}
+	switch {
+	case err != nil:
+		rerun.Status = "failed"
+		rerun.Error = err.Error()
+	case result.Status != "completed":
+		rerun.Status = "failed"
+		rerun.Error = result.Error
+		rerun.Result = result
+	default:
+		rerun.Result = result
+	}
+	if result != nil && result.TimeMs > 0 {
+		rerun.DurationMs = result.TimeMs
+	}
+
+	if err := chat.AppendToolCallRerun(ctx, conn, conversationID, call.ID, rerun); err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	app.HistoryCache.Invalidate(conversationID)
+	if app.WSServer != nil {
+		app.WSServer.BroadcastToolRerun(projectID, conversationID, call, rerun)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tool_call_id": call.ID, "rerun": rerun})
+}
+
+// getToolResultHandler returns a tool result that was too large to send over
+// WebSocket, to an owner of the project it was sent to
+func (app *App) getToolResultHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+	if app.WSServer == nil {
+		apierror.Respond(c, apierror.CodeToolResultNotFound, nil)
+		return
+	}
+
+	result, exists := app.WSServer.OversizedResults().Get(c.Param("id"))
+	if !exists {
+		apierror.Respond(c, apierror.CodeToolResultNotFound, nil)
+		return
+	}
+	owned, err := app.userOwnsProject(c.Request.Context(), result.ProjectID, user)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if !owned {
+		apierror.Respond(c, apierror.CodeToolResultNotFound, nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result_id": result.ID, "result": result.Payload})
+}
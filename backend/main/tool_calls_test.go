@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/chat"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/websocket"
+)
+
+// countingTool counts its executions and reports side effects for "write" operations
+type countingTool struct {
+	runs int
+}
+
+func (t *countingTool) Name() string        { return "counting_tool" }
+func (t *countingTool) Description() string { return "Counts executions" }
+func (t *countingTool) GetCategory() string { return "test" }
+func (t *countingTool) Parameters() map[string]tools.ToolParameter {
+	return map[string]tools.ToolParameter{"operation": {Type: "string", Required: true}}
+}
+func (t *countingTool) ValidateAccess(userID, projectID string) bool { return true }
+func (t *countingTool) HasSideEffects(params map[string]interface{}) bool {
+	return params["operation"] == "write"
+}
+func (t *countingTool) Execute(ctx context.Context, params map[string]interface{}) (*tools.ToolResult, error) {
+	t.runs++
+	return &tools.ToolResult{Status: "completed", Data: map[string]interface{}{"runs": t.runs}}, nil
+}
+
+// newToolCallsTestRouter seeds conversation-a with a read and a write tool call,
+// and conversation-c, owned by user-b but in project-a where user-b has no role
+func newToolCallsTestRouter(t *testing.T) (*App, *countingTool, *gin.Engine) {
+	t.Helper()
+
+	app := newTenancyTestApp(t)
+	tool := &countingTool{}
+	if err := app.ToolRegistry.RegisterTool(tool); err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	toolCalls := `[{"id":"call-read","type":"function","function":{"name":"counting_tool","arguments":"{\"operation\":\"read\"}"},"status":"completed","result":{"status":"completed"}},` +
+		`{"id":"call-write","type":"function","function":{"name":"counting_tool","arguments":{"operation":"write"}},"status":"completed","result":{"status":"completed"}}]`
+	for _, s := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ('message-a2', 'conversation-a', 'assistant', '', $1, $2)",
+			[]interface{}{toolCalls, now.Add(time.Second)}},
+		{"INSERT INTO conversations (id, title, user_id, project_id, status, created_at, updated_at) VALUES ('conversation-c', 'Chat', 'user-b', 'project-a', 'completed', $1, $1)",
+			[]interface{}{now}},
+		{"INSERT INTO messages (id, conversation_id, role, content, tool_calls, created_at) VALUES ('message-c', 'conversation-c', 'assistant', '', $1, $2)",
+			[]interface{}{toolCalls, now}},
+	} {
+		if _, err := app.ZDB.Execute(ctx, s.query, s.args...); err != nil {
+			t.Fatalf("Failed to seed tool calls: %v", err)
+		}
+	}
+
+	router := newTenancyTestRouter(app)
+	router.GET("/api/conversations/:id/tool-calls", app.authMiddleware(), app.getToolCallsHandler)
+	router.POST("/api/conversations/:id/tool-calls/:tool_call_id/rerun", app.authMiddleware(), app.rerunToolCallHandler)
+	return app, tool, router
+}
+
+func TestGetToolCallsListsConversationCalls(t *testing.T) {
+	_, _, router := newToolCallsTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/conversations/conversation-a/tool-calls", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		ToolCalls []chat.ToolCallRecord `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unexpected response: %s", w.Body.String())
+	}
+	if len(resp.ToolCalls) != 2 || resp.ToolCalls[0].ToolCallID != "call-read" || resp.ToolCalls[1].ToolCallID != "call-write" {
+		t.Errorf("Expected both tool calls in order, got %+v", resp.ToolCalls)
+	}
+
+	if w := tenancyRequest(router, "token-b", "GET", "/api/conversations/conversation-a/tool-calls", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's conversation, got %d", w.Code)
+	}
+}
+
+func TestRerunToolCallStoresResult(t *testing.T) {
+	app, tool, router := newToolCallsTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/tool-calls/call-read/rerun", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if tool.runs != 1 {
+		t.Errorf("Expected the tool to run once, ran %d times", tool.runs)
+	}
+
+	call, _, err := chat.FindToolCall(context.Background(), &tools.ZlayDBAdapter{DB: app.ZDB}, "user-a", "client-a", "conversation-a", "call-read")
+	if err != nil {
+		t.Fatalf("Failed to load tool call: %v", err)
+	}
+	if call.Result["status"] != "completed" || len(call.Reruns) != 1 || call.Reruns[0].Status != "completed" || call.Reruns[0].RerunBy != "user-a" {
+		t.Errorf("Expected the rerun stored next to the original result, got %+v", call)
+	}
+
+	if w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/tool-calls/missing/rerun", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown tool call, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-b", "POST", "/api/conversations/conversation-a/tool-calls/call-read/rerun", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 re-running another user's tool call, got %d", w.Code)
+	}
+}
+
+func TestRerunToolCallGatesSideEffects(t *testing.T) {
+	_, tool, router := newToolCallsTestRouter(t)
+
+	w := tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/tool-calls/call-write/rerun", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "TOOL_HAS_SIDE_EFFECTS") {
+		t.Errorf("Expected 409 without force, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// user-b owns conversation-c but has no role in project-a
+	if w := tenancyRequest(router, "token-b", "POST", "/api/conversations/conversation-c/tool-calls/call-write/rerun", `{"force":true}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 forcing without the editor role, got %d: %s", w.Code, w.Body.String())
+	}
+	if tool.runs != 0 {
+		t.Fatalf("Expected no executions before a permitted force, got %d", tool.runs)
+	}
+
+	w = tenancyRequest(router, "token-a", "POST", "/api/conversations/conversation-a/tool-calls/call-write/rerun", `{"force":true}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"forced":true`) {
+		t.Errorf("Expected 200 forcing as the project owner, got %d: %s", w.Code, w.Body.String())
+	}
+	if tool.runs != 1 {
+		t.Errorf("Expected one execution, got %d", tool.runs)
+	}
+}
+
+func TestGetToolResultServesOversizedResults(t *testing.T) {
+	app := newTenancyTestApp(t)
+	app.Config = config.Default()
+	app.Config.QueryJobsDir, app.Config.FilesDir = t.TempDir(), t.TempDir()
+	app.Config.AbandonedSweepInterval, app.Config.SaveJournalPath = 0, ""
+	app.WSServer = websocket.NewServer(app.ZDB, app.Config)
+	router := newTenancyTestRouter(app)
+	router.GET("/api/tool-results/:id", app.authMiddleware(), app.getToolResultHandler)
+
+	id := app.WSServer.OversizedResults().Put("project-a", []byte(`{"rows":[1,2,3]}`))
+
+	w := tenancyRequest(router, "token-a", "GET", "/api/tool-results/"+id, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"result":{"rows":[1,2,3]}`) {
+		t.Errorf("Expected the full result, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, "token-b", "GET", "/api/tool-results/"+id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another client's result, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, "token-a", "GET", "/api/tool-results/unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown result, got %d", w.Code)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/db"
+)
+
+// Roles of a user within their client; admins manage the client's users
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Reasons sent in force_disconnect to the connections of a managed user
+const (
+	deactivatedDisconnectReason   = "This account has been deactivated"
+	passwordResetDisconnectReason = "This account's password has been reset"
+)
+
+// ClientUser is a user as listed by the user management endpoints
+type ClientUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	IsActive  bool   `json:"is_active"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CreateUserRequest creates a user in the caller's client; without a password
+// a temporary one is generated and returned once
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UpdateUserRequest changes the fields that are set
+type UpdateUserRequest struct {
+	IsActive *bool   `json:"is_active"`
+	Role     *string `json:"role"`
+}
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember
+}
+
+// newTemporaryPassword returns a random password for a user to log in with once
+func newTemporaryPassword() (string, error) {
+	passwordBytes := make([]byte, 12)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(passwordBytes), nil
+}
+
+// clientAdminMiddleware lets only the admins of the caller's client through;
+// it runs after authMiddleware
+func (app *App) clientAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := app.getCurrentUser(c)
+		if err != nil {
+			apierror.Abort(c, apierror.CodeAuthRequired, nil)
+			return
+		}
+		row, err := app.ZDB.QueryRow(c.Request.Context(),
+			"SELECT role FROM users WHERE id = $1 AND client_id = $2 AND is_active = true",
+			user.ID, user.ClientID)
+		if err != nil && !errors.Is(err, db.ErrNoRows) {
+			apierror.Abort(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		if err != nil || len(row.Values) == 0 {
+			apierror.Abort(c, apierror.CodeClientAdminRequired, nil)
+			return
+		}
+		if role, _ := row.Values[0].AsString(); role != RoleAdmin {
+			apierror.Abort(c, apierror.CodeClientAdminRequired, nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+// loadClientUser returns a user of clientID; users of other clients and
+// widget visitors are reported as not found
+func (app *App) loadClientUser(ctx context.Context, clientID, userID string) (*ClientUser, error) {
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT id, username, role, is_active, created_at FROM users WHERE id = $1 AND client_id = $2 AND is_visitor = false",
+		userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if len(row.Values) < 5 {
+		return nil, db.ErrNoRows
+	}
+	return clientUserFromRow(row), nil
+}
+
+func clientUserFromRow(row *db.Row) *ClientUser {
+	var user ClientUser
+	user.ID, _ = row.Values[0].AsString()
+	user.Username, _ = row.Values[1].AsString()
+	user.Role, _ = row.Values[2].AsString()
+	user.IsActive, _ = row.Values[3].AsBool()
+	if createdAt, ok := row.Values[4].AsTimestamp(); ok {
+		user.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	}
+	return &user
+}
+
+// respondUserLookupError answers a failed loadClientUser
+func respondUserLookupError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrNoRows) {
+		apierror.Respond(c, apierror.CodeUserNotFound, nil)
+		return
+	}
+	apierror.Respond(c, apierror.CodeDatabaseError, nil)
+}
+
+// endUserSessions logs a user out everywhere: their sessions are deleted and
+// dropped from the cache, and their WebSocket connections are closed with
+// reason. It returns how many connections were closed.
+func (app *App) endUserSessions(ctx context.Context, userID, reason string) (int, error) {
+	if _, err := app.ZDB.Execute(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return 0, err
+	}
+	if app.Sessions != nil {
+		app.Sessions.InvalidateUser(userID)
+	}
+	if app.WSServer == nil {
+		return 0, nil
+	}
+	return app.WSServer.ForceDisconnect(userID, "", reason), nil
+}
+
+// otherActiveAdmins counts the active admins of a client besides userID
+func (app *App) otherActiveAdmins(ctx context.Context, clientID, userID string) (int64, error) {
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT COUNT(*) FROM users WHERE client_id = $1 AND id != $2 AND role = $3 AND is_active = true",
+		clientID, userID, RoleAdmin)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := row.Values[0].AsInt64()
+	return count, nil
+}
+
+// getUsersHandler lists the users of the caller's client, optionally those
+// whose username contains search and those with the given active state
+func (app *App) getUsersHandler(c *gin.Context) {
+	caller, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	conditions := []string{"client_id = $1", "is_visitor = false"}
+	args := []interface{}{caller.ClientID}
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		args = append(args, "%"+strings.ToLower(search)+"%")
+		conditions = append(conditions, "LOWER(username) LIKE $"+strconv.Itoa(len(args)))
+	}
+	if active := c.Query("active"); active != "" {
+		isActive, err := strconv.ParseBool(active)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "active"})
+			return
+		}
+		args = append(args, isActive)
+		conditions = append(conditions, "is_active = $"+strconv.Itoa(len(args)))
+	}
+
+	resultSet, err := app.ZDB.Query(c.Request.Context(),
+		"SELECT id, username, role, is_active, created_at FROM users WHERE "+strings.Join(conditions, " AND ")+" ORDER BY username",
+		args...)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	users := make([]ClientUser, 0, len(resultSet.Rows))
+	for _, row := range resultSet.Rows {
+		if len(row.Values) < 5 {
+			continue
+		}
+		users = append(users, *clientUserFromRow(&row))
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// createUserHandler adds a user to the caller's client
+func (app *App) createUserHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	caller, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "username"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleMember
+	}
+	if !validRole(req.Role) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "role"})
+		return
+	}
+
+	row, err := app.ZDB.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM users WHERE client_id = $1 AND username = $2)",
+		caller.ClientID, req.Username)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if exists, _ := row.Values[0].AsBool(); exists {
+		apierror.Respond(c, apierror.CodeUserAlreadyExists, nil)
+		return
+	}
+
+	password, temporary := req.Password, req.Password == ""
+	if temporary {
+		if password, err = newTemporaryPassword(); err != nil {
+			apierror.Respond(c, apierror.CodeInternal, nil)
+			return
+		}
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeInternal, nil)
+		return
+	}
+
+	userID := uuid.New().String()
+	if _, err := app.ZDB.Execute(ctx,
+		"INSERT INTO users (id, client_id, username, password_hash, role, is_active, created_at) VALUES ($1, $2, $3, $4, $5, true, CURRENT_TIMESTAMP)",
+		userID, caller.ClientID, req.Username, hashedPassword, req.Role); err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	user, err := app.loadClientUser(ctx, caller.ClientID, userID)
+	if err != nil {
+		respondUserLookupError(c, err)
+		return
+	}
+
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    caller.ID,
+		IP:         app.clientIP(c),
+		ClientID:   caller.ClientID,
+		Action:     AuditActionUserCreate,
+		TargetType: "user",
+		TargetID:   userID,
+		Details: map[string]interface{}{
+			"username":           user.Username,
+			"role":               user.Role,
+			"temporary_password": temporary,
+		},
+	})
+
+	response := gin.H{"user": user}
+	if temporary {
+		response["temporary_password"] = password
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// updateUserHandler activates or deactivates a user of the caller's client or
+// changes their role. A deactivated user is logged out everywhere at once. The
+// client's last active admin can neither be deactivated nor demoted.
+func (app *App) updateUserHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	caller, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	if req.IsActive == nil && req.Role == nil {
+		apierror.Respond(c, apierror.CodeFieldRequired, map[string]interface{}{"field": "is_active"})
+		return
+	}
+	if req.Role != nil && !validRole(*req.Role) {
+		apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "role"})
+		return
+	}
+
+	user, err := app.loadClientUser(ctx, caller.ClientID, c.Param("id"))
+	if err != nil {
+		respondUserLookupError(c, err)
+		return
+	}
+
+	deactivating := req.IsActive != nil && !*req.IsActive && user.IsActive
+	demoting := req.Role != nil && *req.Role != RoleAdmin && user.Role == RoleAdmin
+	if user.Role == RoleAdmin && user.IsActive && (deactivating || demoting) {
+		others, err := app.otherActiveAdmins(ctx, caller.ClientID, user.ID)
+		if err != nil {
+			apierror.Respond(c, apierror.CodeDatabaseError, nil)
+			return
+		}
+		if others == 0 {
+			apierror.Respond(c, apierror.CodeLastClientAdmin, nil)
+			return
+		}
+	}
+
+	changes := make(map[string]interface{})
+	var sets []string
+	var args []interface{}
+	if req.IsActive != nil && *req.IsActive != user.IsActive {
+		args = append(args, *req.IsActive)
+		sets = append(sets, "is_active = $"+strconv.Itoa(len(args)))
+		changes["is_active"] = *req.IsActive
+	}
+	if req.Role != nil && *req.Role != user.Role {
+		args = append(args, *req.Role)
+		sets = append(sets, "role = $"+strconv.Itoa(len(args)))
+		changes["role"] = *req.Role
+	}
+	if len(sets) > 0 {
+		args = append(args, user.ID, caller.ClientID)
+		if _, err := app.ZDB.Execute(ctx,
+			"UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = $"+strconv.Itoa(len(args)-1)+" AND client_id = $"+strconv.Itoa(len(args)),
+			args...); err != nil {
+			apierror.Respond(c, apierror.CodeSaveFailed, nil)
+			return
+		}
+	}
+
+	if deactivating {
+		disconnected, err := app.endUserSessions(ctx, user.ID, deactivatedDisconnectReason)
+		if err != nil {
+			// The user can no longer log in; their sessions are rejected as inactive
+			log.Printf("Failed to end the sessions of deactivated user %s: %v", user.ID, err)
+		}
+		changes["connections"] = disconnected
+	}
+	if len(sets) > 0 {
+		app.recordAudit(ctx, AuditEntry{
+			ActorID:    caller.ID,
+			IP:         app.clientIP(c),
+			ClientID:   caller.ClientID,
+			Action:     AuditActionUserUpdate,
+			TargetType: "user",
+			TargetID:   user.ID,
+			Details:    changes,
+		})
+	}
+
+	user, err = app.loadClientUser(ctx, caller.ClientID, user.ID)
+	if err != nil {
+		respondUserLookupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// resetUserPasswordHandler gives a user of the caller's client a new
+// temporary password, returned once, and logs them out everywhere
+func (app *App) resetUserPasswordHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	caller, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	user, err := app.loadClientUser(ctx, caller.ClientID, c.Param("id"))
+	if err != nil {
+		respondUserLookupError(c, err)
+		return
+	}
+
+	password, err := newTemporaryPassword()
+	if err != nil {
+		apierror.Respond(c, apierror.CodeInternal, nil)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeInternal, nil)
+		return
+	}
+	if _, err := app.ZDB.Execute(ctx,
+		"UPDATE users SET password_hash = $1 WHERE id = $2 AND client_id = $3",
+		hashedPassword, user.ID, caller.ClientID); err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	disconnected, err := app.endUserSessions(ctx, user.ID, passwordResetDisconnectReason)
+	if err != nil {
+		// The old password no longer works, but sessions issued with it still do
+		log.Printf("Failed to end the sessions of user %s after a password reset: %v", user.ID, err)
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+
+	app.recordAudit(ctx, AuditEntry{
+		ActorID:    caller.ID,
+		IP:         app.clientIP(c),
+		ClientID:   caller.ClientID,
+		Action:     AuditActionUserPasswordReset,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"username": user.Username, "connections": disconnected},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "temporary_password": password})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+	"zlay-backend/internal/auth"
+	"zlay-backend/internal/websocket"
+)
+
+const otherClientID = "00000000-0000-0000-0000-000000000003"
+
+// newUsersTestApp extends the sessions fixture with roles: the tenant's root
+// is its admin, and a second client has an admin of its own and a member
+func newUsersTestApp(t *testing.T) (*App, *gin.Engine) {
+	t.Helper()
+
+	app := newSessionsTestApp(t)
+	app.Sessions = auth.NewResolver(app.ZDB, time.Minute)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"UPDATE users SET role = 'admin' WHERE id = 'root-tenant'",
+		"INSERT INTO clients (id, name, slug, is_active, created_at) VALUES ('" + otherClientID + "', 'Other', 'other', true, CURRENT_TIMESTAMP)",
+		"INSERT INTO users (id, client_id, username, password_hash, is_active, role, created_at) SELECT 'olga', '" + otherClientID + "', 'olga', password_hash, true, 'admin', CURRENT_TIMESTAMP FROM users WHERE id = 'alice'",
+		"INSERT INTO users (id, client_id, username, password_hash, is_active, created_at) SELECT 'oscar', '" + otherClientID + "', 'oscar', password_hash, true, CURRENT_TIMESTAMP FROM users WHERE id = 'alice'",
+	} {
+		if _, err := app.ZDB.Execute(ctx, stmt); err != nil {
+			t.Fatalf("Failed to set up schema: %v", err)
+		}
+	}
+
+	router := newSessionsTestRouter(app)
+	router.GET("/api/users", app.authMiddleware(), app.clientAdminMiddleware(), app.getUsersHandler)
+	router.POST("/api/users", app.authMiddleware(), app.clientAdminMiddleware(), app.createUserHandler)
+	router.PUT("/api/users/:id", app.authMiddleware(), app.clientAdminMiddleware(), app.updateUserHandler)
+	router.POST("/api/users/:id/reset-password", app.authMiddleware(), app.clientAdminMiddleware(), app.resetUserPasswordHandler)
+	return app, router
+}
+
+func listUsers(t *testing.T, router *gin.Engine, token, query string) []ClientUser {
+	t.Helper()
+
+	w := tenancyRequest(router, token, "GET", "/api/users"+query, "")
+	var response struct {
+		Users []ClientUser `json:"users"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) != nil {
+		t.Fatalf("Failed to list users: %d %s", w.Code, w.Body.String())
+	}
+	return response.Users
+}
+
+func usernames(users []ClientUser) string {
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.Username
+	}
+	return strings.Join(names, ",")
+}
+
+func TestClientAdminsManageTheirUsers(t *testing.T) {
+	_, router := newUsersTestApp(t)
+	adminToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+
+	// Members cannot manage users
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/users", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "CLIENT_ADMIN_REQUIRED") {
+		t.Fatalf("Expected a member to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := usernames(listUsers(t, router, adminToken, "")); got != "alice,root" {
+		t.Errorf("Expected the tenant's users, got %s", got)
+	}
+
+	// Without a password a temporary one is returned once
+	w := tenancyRequest(router, adminToken, "POST", "/api/users", `{"username": "bob"}`)
+	var created struct {
+		User              ClientUser `json:"user"`
+		TemporaryPassword string     `json:"temporary_password"`
+	}
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.TemporaryPassword == "" || created.User.Role != RoleMember {
+		t.Fatalf("Expected bob created with a temporary password, got %d: %s", w.Code, w.Body.String())
+	}
+	if token, w := loginAs(t, router, `{"client_slug": "tenant", "username": "bob", "password": "`+created.TemporaryPassword+`"}`); token == "" {
+		t.Errorf("Expected bob to log in with the temporary password, got %d: %s", w.Code, w.Body.String())
+	}
+	w = tenancyRequest(router, adminToken, "POST", "/api/users", `{"username": "carol", "password": "chosen", "role": "admin"}`)
+	if w.Code != http.StatusCreated || strings.Contains(w.Body.String(), "temporary_password") {
+		t.Errorf("Expected carol created with her own password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, adminToken, "POST", "/api/users", `{"username": "bob"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a taken username, got %d", w.Code)
+	}
+	if w := tenancyRequest(router, adminToken, "POST", "/api/users", `{"username": "dave", "role": "owner"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown role, got %d", w.Code)
+	}
+
+	// Promoting alice lets her in
+	w = tenancyRequest(router, adminToken, "PUT", "/api/users/alice", `{"role": "admin"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"role":"admin"`) {
+		t.Fatalf("Expected alice promoted, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/users", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the new admin let in, got %d", w.Code)
+	}
+
+	w = tenancyRequest(router, adminToken, "PUT", "/api/users/"+created.User.ID, `{"is_active": false}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected bob deactivated, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := usernames(listUsers(t, router, adminToken, "?active=false")); got != "bob" {
+		t.Errorf("Expected only bob inactive, got %s", got)
+	}
+	if got := usernames(listUsers(t, router, adminToken, "?search=AR&active=true")); got != "carol" {
+		t.Errorf("Expected the search to match carol, got %s", got)
+	}
+
+	// The client keeps an active admin
+	for _, id := range []string{"alice", "root-tenant"} {
+		if w := tenancyRequest(router, adminToken, "PUT", "/api/users/"+id, `{"role": "member"}`); w.Code != http.StatusOK {
+			t.Fatalf("Expected %s demoted while others remain, got %d: %s", id, w.Code, w.Body.String())
+		}
+	}
+	carolToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "carol", "password": "chosen"}`)
+	var carolID string
+	for _, user := range listUsers(t, router, carolToken, "?search=carol") {
+		carolID = user.ID
+	}
+	for _, body := range []string{`{"role": "member"}`, `{"is_active": false}`} {
+		if w := tenancyRequest(router, carolToken, "PUT", "/api/users/"+carolID, body); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "LAST_CLIENT_ADMIN") {
+			t.Errorf("Expected the last admin kept for %s, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	var entries []AuditEntry
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/audit-log?client_id="+tenantClientID, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Invalid audit log: %s", w.Body.String())
+	}
+	actions := make(map[string]int)
+	for _, entry := range entries {
+		actions[entry.Action]++
+	}
+	if actions[AuditActionUserCreate] != 2 || actions[AuditActionUserUpdate] != 4 {
+		t.Errorf("Expected every change audited, got %v", actions)
+	}
+}
+
+func TestUserManagementIsScopedToTheClient(t *testing.T) {
+	_, router := newUsersTestApp(t)
+	adminToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	olgaToken, _ := loginAs(t, router, `{"client_slug": "other", "username": "olga", "password": "secret"}`)
+
+	if got := usernames(listUsers(t, router, olgaToken, "")); got != "olga,oscar" {
+		t.Errorf("Expected only the other client's users, got %s", got)
+	}
+
+	// Another client's users are not found, whatever the operation
+	for _, request := range []struct{ method, path, body string }{
+		{"PUT", "/api/users/oscar", `{"is_active": false}`},
+		{"PUT", "/api/users/oscar", `{"role": "admin"}`},
+		{"POST", "/api/users/oscar/reset-password", ""},
+	} {
+		if w := tenancyRequest(router, adminToken, request.method, request.path, request.body); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s %s across clients, got %d: %s", request.method, request.path, w.Code, w.Body.String())
+		}
+	}
+	if w := tenancyRequest(router, olgaToken, "PUT", "/api/users/alice", `{"is_active": false}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another client's user, got %d", w.Code)
+	}
+
+	// Users created by an admin join the admin's own client
+	if w := tenancyRequest(router, olgaToken, "POST", "/api/users", `{"username": "alice", "password": "x"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected a username taken in another client to be free, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := usernames(listUsers(t, router, adminToken, "")); got != "alice,root" {
+		t.Errorf("Expected the tenant's users unchanged, got %s", got)
+	}
+	if token, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "x"}`); token != "" {
+		t.Error("Expected the new user's password to work only in their own client")
+	}
+	if token, _ := loginAs(t, router, `{"client_slug": "other", "username": "oscar", "password": "secret"}`); token == "" {
+		t.Error("Expected oscar to be untouched")
+	}
+}
+
+func TestDeactivationAndPasswordResetEndSessions(t *testing.T) {
+	app, router := newUsersTestApp(t)
+	app.Config.WSPort, app.Config.FilesDir = "0", t.TempDir()
+	app.Config.AbandonedSweepInterval, app.Config.SaveJournalPath = 0, ""
+	app.WSServer = websocket.NewServer(app.ZDB, app.Config)
+	app.WSServer.Mount(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	adminToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "root", "password": "secret"}`)
+	aliceToken, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+	// The session is cached from here on
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected alice logged in, got %d", w.Code)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + websocket.MountedPath + "?project=project-1&token=" + aliceToken
+	ws, _, err := gorilla.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer ws.Close()
+	for deadline := time.Now().Add(2 * time.Second); len(app.WSServer.ListConnections()) == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected alice's connection to register")
+		}
+	}
+
+	w := tenancyRequest(router, adminToken, "PUT", "/api/users/alice", `{"is_active": false}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"is_active":false`) {
+		t.Fatalf("Expected alice deactivated, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Her open connection is closed at once
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var forced bool
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if !gorilla.IsCloseError(err, websocket.CloseForceDisconnect) {
+				t.Errorf("Expected close code %d, got %v", websocket.CloseForceDisconnect, err)
+			}
+			break
+		}
+		if strings.Contains(string(data), `"type":"force_disconnect"`) && strings.Contains(string(data), deactivatedDisconnectReason) {
+			forced = true
+		}
+	}
+	if !forced {
+		t.Error("Expected a force_disconnect message before the close")
+	}
+
+	// Her cached session no longer works, and she cannot log in again
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the deactivated user's session rejected, got %d", w.Code)
+	}
+	if token, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`); token != "" {
+		t.Error("Expected a deactivated user unable to log in")
+	}
+
+	// A reset logs the user out everywhere and replaces the password
+	if w := tenancyRequest(router, adminToken, "PUT", "/api/users/alice", `{"is_active": true}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected alice reactivated, got %d", w.Code)
+	}
+	aliceToken, _ = loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`)
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected alice back, got %d", w.Code)
+	}
+	w = tenancyRequest(router, adminToken, "POST", "/api/users/alice/reset-password", "")
+	var reset struct {
+		TemporaryPassword string `json:"temporary_password"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &reset) != nil || reset.TemporaryPassword == "" {
+		t.Fatalf("Expected a temporary password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, aliceToken, "GET", "/api/auth/profile", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session ended by the reset, got %d", w.Code)
+	}
+	if token, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "secret"}`); token != "" {
+		t.Error("Expected the old password rejected")
+	}
+	if token, _ := loginAs(t, router, `{"client_slug": "tenant", "username": "alice", "password": "`+reset.TemporaryPassword+`"}`); token == "" {
+		t.Error("Expected the temporary password accepted")
+	}
+
+	// Only alice was logged out
+	if w := tenancyRequest(router, adminToken, "GET", "/api/users", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the admin's session kept, got %d", w.Code)
+	}
+	var entries []AuditEntry
+	rootToken, _ := loginAs(t, router, `{"username": "root", "password": "secret"}`)
+	w = tenancyRequest(router, rootToken, "GET", "/api/admin/audit-log?action="+AuditActionUserPasswordReset, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].TargetID != "alice" || entries[0].ActorID != "root-tenant" {
+		t.Errorf("Expected the reset audited, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/apierror"
+)
+
+// Clients, domains, datasources, projects and conversations carry a version
+// that every edit increments. An update names the version it was based on, in
+// If-Match or the version field of its body, and only applies while the row
+// still has it; otherwise the caller gets VERSION_CONFLICT with the current
+// resource, so one of two concurrent edits is refused instead of lost.
+
+// versionCondition is appended to the WHERE clause of an update
+const versionCondition = " AND version = $%d"
+
+// versionIncrement is appended to the SET list of an update
+const versionIncrement = ", version = version + 1"
+
+// requestVersion returns the version an update was based on: the If-Match
+// header, as 3, "3" or W/"3", or else the body's version. Without either, or
+// with one that is not a positive number, it responds and returns false.
+func requestVersion(c *gin.Context, bodyVersion *int64) (int64, bool) {
+	if header := c.GetHeader("If-Match"); header != "" {
+		version, ok := parseETag(header)
+		if !ok {
+			apierror.Respond(c, apierror.CodeFieldInvalid, map[string]interface{}{"field": "If-Match"})
+			return 0, false
+		}
+		return version, true
+	}
+	if bodyVersion == nil {
+		apierror.Respond(c, apierror.CodeVersionRequired, nil)
+		return 0, false
+	}
+	if *bodyVersion < 1 {
+		apierror.Respond(c, apierror.CodeFieldOutOfRange, map[string]interface{}{"field": "version", "min": 1})
+		return 0, false
+	}
+	return *bodyVersion, true
+}
+
+// parseETag reads a version from an entity tag; a list or * is not accepted
+// since an update is based on exactly one version
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	tag = strings.TrimSuffix(strings.TrimPrefix(tag, `"`), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// setETag tells the caller which version to send back in If-Match
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// respondVersionConflict refuses an update based on a stale version with the
+// resource as it is now, which the caller can merge its edit into and retry
+func respondVersionConflict(c *gin.Context, current interface{}, version int64) {
+	setETag(c, version)
+	apierror.Respond(c, apierror.CodeVersionConflict, map[string]interface{}{"current": current})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/bootstrap"
+	"zlay-backend/internal/chat"
+)
+
+// versionedRequest is tenancyRequest with an If-Match header; an empty ifMatch sends none
+func versionedRequest(router *gin.Engine, token, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseETag(t *testing.T) {
+	for tag, want := range map[string]int64{`"3"`: 3, `W/"3"`: 3, "3": 3, ` "12" `: 12} {
+		if got, ok := parseETag(tag); !ok || got != want {
+			t.Errorf("parseETag(%q) = %d, %t; expected %d", tag, got, ok, want)
+		}
+	}
+	for _, tag := range []string{"*", `"0"`, `"-1"`, `"a"`, `"1", "2"`, ""} {
+		if _, ok := parseETag(tag); ok {
+			t.Errorf("Expected %q to be rejected", tag)
+		}
+	}
+}
+
+// TestInterleavedUpdatesConflict has two editors load each resource at the
+// same version and save in turn: the first write lands, the second gets a 409
+// with the resource as the first left it, and a retry at that version lands
+func TestInterleavedUpdatesConflict(t *testing.T) {
+	app := newSQLiteAppTestApp(t)
+	router := app.Router
+	token, w := loginAs(t, router, `{"username": "`+bootstrap.RootUsername+`", "password": "integration-secret"}`)
+	if token == "" {
+		t.Fatalf("Expected root to log in, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var client Client
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/clients", `{"name": "Acme", "slug": "acme"}`), http.StatusCreated, &client)
+	var domain Domain
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/admin/domains", `{"client_id": "`+client.ID+`", "domain": "acme.example"}`), http.StatusCreated, &domain)
+	var project Project
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/projects", `{"name": "Reports"}`), http.StatusCreated, &project)
+	var datasource Datasource
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/datasources",
+		`{"project_id": "`+project.ID+`", "name": "Warehouse", "type": "sqlite", "config": {"path": "warehouse.db"}}`), http.StatusCreated, &datasource)
+	var created struct {
+		Conversation chat.Conversation `json:"conversation"`
+	}
+	decodeSQLiteResponse(t, tenancyRequest(router, token, "POST", "/api/conversations", `{"project_id": "`+project.ID+`"}`), http.StatusCreated, &created)
+	conversationID := created.Conversation.ID
+
+	for _, version := range []int64{client.Version, domain.Version, project.Version, datasource.Version, created.Conversation.Version} {
+		if version != 1 {
+			t.Fatalf("Expected new resources at version 1, got %d", version)
+		}
+	}
+
+	tests := []struct {
+		name, method, path  string
+		first, second       string
+		field               string
+		want                interface{} // field of the current resource after the first write
+		readPath, firstMark string      // readPath lists the resource with firstMark in it
+	}{
+		{"client", "PUT", "/api/admin/clients/" + client.ID, `{"name": "Acme First"}`, `{"name": "Acme Second"}`,
+			"name", "Acme First", "/api/admin/clients", "Acme First"},
+		{"domain", "PUT", "/api/admin/domains/" + domain.ID, `{"domain": "first.acme.example"}`, `{"domain": "second.acme.example"}`,
+			"domain", "first.acme.example", "/api/admin/domains", "first.acme.example"},
+		{"project", "PUT", "/api/projects/" + project.ID, `{"name": "Reports First"}`, `{"name": "Reports Second"}`,
+			"name", "Reports First", "/api/projects/" + project.ID, "Reports First"},
+		{"datasource", "PUT", "/api/datasources/" + datasource.ID, `{"name": "Warehouse First"}`, `{"name": "Warehouse Second"}`,
+			"name", "Warehouse First", "/api/datasources/" + datasource.ID, "Warehouse First"},
+		{"conversation pin", "PUT", "/api/conversations/" + conversationID + "/pin", `{"pinned": true}`, `{"pinned": false}`,
+			"pinned", true, "/api/conversations?project_id=" + project.ID, `"pinned":true`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Both editors loaded version 1; the first sends it in If-Match
+			first := versionedRequest(router, token, tc.method, tc.path, `"1"`, tc.first)
+			if first.Code != http.StatusOK || first.Header().Get("ETag") != `"2"` {
+				t.Fatalf("Expected the first write to land at version 2, got %d %q: %s", first.Code, first.Header().Get("ETag"), first.Body.String())
+			}
+
+			// The second sends the same version in the body
+			second := tenancyRequest(router, token, tc.method, tc.path, strings.TrimSuffix(tc.second, "}")+`, "version": 1}`)
+			if second.Code != http.StatusConflict || second.Header().Get("ETag") != `"2"` {
+				t.Fatalf("Expected a 409 at version 2, got %d %q: %s", second.Code, second.Header().Get("ETag"), second.Body.String())
+			}
+			var conflict struct {
+				Code    string `json:"code"`
+				Details struct {
+					Current map[string]interface{} `json:"current"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(second.Body.Bytes(), &conflict); err != nil || conflict.Code != "VERSION_CONFLICT" {
+				t.Fatalf("Expected VERSION_CONFLICT, got %s", second.Body.String())
+			}
+			if current := conflict.Details.Current; current[tc.field] != tc.want || current["version"] != float64(2) {
+				t.Errorf("Expected the current resource after the first write, got %v", current)
+			}
+
+			if w := tenancyRequest(router, token, "GET", tc.readPath, ""); !strings.Contains(w.Body.String(), tc.firstMark) {
+				t.Errorf("Expected the first write to be kept, got %d: %s", w.Code, w.Body.String())
+			}
+
+			// Having seen the current version, the second editor can retry
+			retry := versionedRequest(router, token, tc.method, tc.path, `W/"2"`, tc.second)
+			if retry.Code != http.StatusOK || retry.Header().Get("ETag") != `"3"` {
+				t.Errorf("Expected the retry to land at version 3, got %d %q: %s", retry.Code, retry.Header().Get("ETag"), retry.Body.String())
+			}
+		})
+	}
+
+	// The language setting shares the conversation's version with pinning
+	path := "/api/conversations/" + conversationID
+	if w := tenancyRequest(router, token, "PATCH", path, `{"language": "id", "version": 2}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected a 409 for a version the pin moved past, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := tenancyRequest(router, token, "PATCH", path, `{"language": "id", "version": 3}`); w.Code != http.StatusOK || w.Header().Get("ETag") != `"4"` {
+		t.Errorf("Expected the language to be set at version 4, got %d %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+}
+
+func TestUpdatesRequireAVersion(t *testing.T) {
+	app := newTenancyTestApp(t)
+	router := newTenancyTestRouter(app)
+
+	for _, tc := range []struct {
+		ifMatch, body string
+		want          int
+		code          string
+	}{
+		{"", `{"name": "Renamed"}`, http.StatusPreconditionRequired, "VERSION_REQUIRED"},
+		{"*", `{"name": "Renamed"}`, http.StatusBadRequest, "FIELD_INVALID"},
+		{"", `{"name": "Renamed", "version": 0}`, http.StatusBadRequest, "FIELD_OUT_OF_RANGE"},
+		{`"7"`, `{"name": "Renamed"}`, http.StatusConflict, "VERSION_CONFLICT"},
+		// If-Match wins over the body
+		{`"1"`, `{"name": "Renamed", "version": 7}`, http.StatusOK, ""},
+	} {
+		w := versionedRequest(router, "token-a", "PUT", "/api/projects/project-a", tc.ifMatch, tc.body)
+		if w.Code != tc.want || !strings.Contains(w.Body.String(), tc.code) {
+			t.Errorf("If-Match %q with %s: expected %d %s, got %d: %s", tc.ifMatch, tc.body, tc.want, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	// A stale version of another tenant's resource is still not found
+	if w := versionedRequest(router, "token-a", "PUT", "/api/datasources/datasource-b", `"9"`, `{"name": "Stolen"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another tenant's datasource, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/webhooks"
+)
+
+const (
+	defaultWebhookDeliveries = 50
+	maxWebhookDeliveries     = 500
+)
+
+type createWebhookRequest struct {
+	ClientID   string   `json:"client_id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+}
+
+// getWebhooksHandler lists webhooks, optionally filtered by client_id
+func (app *App) getWebhooksHandler(c *gin.Context) {
+	list, err := webhooks.ListWebhooks(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Query("client_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// createWebhookHandler registers a webhook; the response is the only time the secret is returned
+func (app *App) createWebhookHandler(c *gin.Context) {
+	var req createWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	webhook := &webhooks.Webhook{ClientID: req.ClientID, URL: req.URL, Secret: req.Secret, EventTypes: req.EventTypes}
+	err := webhooks.CreateWebhook(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, webhook)
+	if errors.Is(err, webhooks.ErrInvalidWebhook) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// updateWebhookHandler changes a webhook's URL, secret, event types or active flag
+func (app *App) updateWebhookHandler(c *gin.Context) {
+	var req webhooks.WebhookUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	webhook, err := webhooks.UpdateWebhook(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Param("id"), req)
+	if errors.Is(err, webhooks.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if errors.Is(err, webhooks.ErrInvalidWebhook) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+// deleteWebhookHandler removes a webhook together with its delivery log
+func (app *App) deleteWebhookHandler(c *gin.Context) {
+	err := webhooks.DeleteWebhook(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, c.Param("id"))
+	if errors.Is(err, webhooks.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// getWebhookDeliveriesHandler returns a webhook's most recent delivery attempts for debugging
+func (app *App) getWebhookDeliveriesHandler(c *gin.Context) {
+	limit := defaultWebhookDeliveries
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxWebhookDeliveries {
+		limit = maxWebhookDeliveries
+	}
+
+	db := &tools.ZlayDBAdapter{DB: app.ZDB}
+	if _, err := webhooks.GetWebhook(c.Request.Context(), db, c.Param("id")); err != nil {
+		if errors.Is(err, webhooks.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook"})
+		return
+	}
+
+	deliveries, err := webhooks.ListDeliveries(c.Request.Context(), db, c.Param("id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"zlay-backend/internal/apierror"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/widget"
+)
+
+// widgetConfigMaxAge is how long browsers and CDNs may reuse a widget config
+// before revalidating it with its ETag
+const widgetConfigMaxAge = 24 * time.Hour
+
+// widgetCapabilities are the server features the widget can offer. They come
+// from the configuration and hold no secrets.
+type widgetCapabilities struct {
+	Streaming        bool `json:"streaming"`          // Widget sessions can be opened for streamed replies
+	FileUpload       bool `json:"file_upload"`        // Project file uploads are enabled
+	MaxMessageLength int  `json:"max_message_length"` // Characters per user message
+}
+
+type widgetConfigResponse struct {
+	Branding     widget.Branding    `json:"branding"`
+	Capabilities widgetCapabilities `json:"capabilities"`
+}
+
+type widgetSessionResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserID    string    `json:"user_id"`
+	ProjectID string    `json:"project_id"`
+}
+
+// createWidgetSessionHandler creates an anonymous visitor for an embedded chat widget
+// and returns a short-lived token for the WebSocket. Only the browser-set Origin header
+// is trusted: X-Client-ID, X-Original-Origin and Referer are ignored and there is no
+// fallback client, so the origin must match an active domain of an active client, either
+// exactly or through a wildcard domain.
+func (app *App) createWidgetSessionHandler(c *gin.Context) {
+	if app.WidgetSigner == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Widget sessions are not available"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	db := &tools.ZlayDBAdapter{DB: app.ZDB}
+
+	clientID, err := widget.ClientForOrigin(ctx, db, c.GetHeader("Origin"))
+	if errors.Is(err, widget.ErrOriginNotAllowed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origin is not allowed to embed the widget"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resolve widget origin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create widget session"})
+		return
+	}
+
+	projectID, err := widget.EnsureProject(ctx, db, clientID)
+	if err != nil {
+		log.Printf("Failed to prepare widget project for client %s: %v", clientID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create widget session"})
+		return
+	}
+
+	expiresAt := app.WidgetSigner.ExpiresAt()
+	userID, err := widget.CreateVisitor(ctx, db, clientID, expiresAt)
+	if err != nil {
+		log.Printf("Failed to create widget visitor for client %s: %v", clientID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create widget session"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, widgetSessionResponse{
+		Token:     app.WidgetSigner.Issue(userID, clientID, projectID, expiresAt),
+		ExpiresAt: expiresAt.UTC(),
+		UserID:    userID,
+		ProjectID: projectID,
+	})
+}
+
+// widgetConfigHandler serves the branding and capabilities of the client whose
+// domain matches the Origin header, so the widget can render before anyone
+// signs in. Every origin that does not resolve to an active client gets the
+// same 404, whether its domain is unknown, inactive or its client is.
+func (app *App) widgetConfigHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	c.Header("Vary", "Origin")
+	c.Header("Access-Control-Allow-Origin", "*")
+
+	clientID, found := uuid.Nil, false
+	if host := widget.NormalizeOrigin(c.GetHeader("Origin")); host != "" {
+		clientID, found = app.clientForDomain(ctx, host)
+	}
+	if !found {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return
+	}
+
+	name, branding, updatedAt, err := widget.LoadBranding(ctx, &tools.ZlayDBAdapter{DB: app.ZDB}, clientID.String())
+	if errors.Is(err, widget.ErrClientNotFound) {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load widget branding for client %s: %v", clientID, err)
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	if branding.DisplayName == "" {
+		branding.DisplayName = name
+	}
+
+	capabilities := widgetCapabilities{
+		Streaming:        app.WidgetSigner != nil,
+		FileUpload:       app.Config.FilesDir != "" && app.Config.MaxUploadBytes > 0,
+		MaxMessageLength: app.Config.MaxMessageChars,
+	}
+	etag := widgetConfigETag(clientID.String(), updatedAt, capabilities)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(widgetConfigMaxAge.Seconds())))
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, widgetConfigResponse{Branding: branding, Capabilities: capabilities})
+}
+
+// widgetConfigETag changes whenever the client's branding is saved, and with
+// the capabilities, which change when the server is reconfigured
+func widgetConfigETag(clientID string, brandingUpdatedAt time.Time, capabilities widgetCapabilities) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%+v", clientID, brandingUpdatedAt.UnixNano(), capabilities)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header names etag, comparing
+// weakly as RFC 9110 requires for GET
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// getBrandingHandler returns the current user's client branding as stored,
+// without the client name filled in
+func (app *App) getBrandingHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	_, branding, updatedAt, err := widget.LoadBranding(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID)
+	if errors.Is(err, widget.ErrClientNotFound) {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeDatabaseError, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": branding, "updated_at": updatedAt})
+}
+
+// putBrandingHandler replaces the current user's client branding
+func (app *App) putBrandingHandler(c *gin.Context) {
+	user, err := app.getCurrentUser(c)
+	if err != nil {
+		apierror.Respond(c, apierror.CodeAuthRequired, nil)
+		return
+	}
+
+	var req widget.Branding
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.CodeInvalidRequestBody, nil)
+		return
+	}
+	branding, ok := normalizeBranding(c, req)
+	if !ok {
+		return
+	}
+
+	err = widget.SaveBranding(c.Request.Context(), &tools.ZlayDBAdapter{DB: app.ZDB}, user.ClientID, branding)
+	if errors.Is(err, widget.ErrClientNotFound) {
+		apierror.Respond(c, apierror.CodeClientNotFound, nil)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.CodeSaveFailed, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": branding})
+}
+
+// normalizeBranding normalizes branding from a request, responding with
+// BRANDING_INVALID when a field is refused
+func normalizeBranding(c *gin.Context, branding widget.Branding) (widget.Branding, bool) {
+	normalized, err := branding.Normalize()
+	var invalid *widget.BrandingError
+	if errors.As(err, &invalid) {
+		apierror.Respond(c, apierror.CodeBrandingInvalid, map[string]interface{}{"field": invalid.Field, "reason": invalid.Reason})
+		return widget.Branding{}, false
+	}
+	return normalized, true
+}
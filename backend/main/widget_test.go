@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"zlay-backend/internal/config"
+	"zlay-backend/internal/db/dbtest"
+	"zlay-backend/internal/domains"
+	"zlay-backend/internal/tools"
+	"zlay-backend/internal/widget"
+)
+
+func newWidgetTestApp(t *testing.T) *App {
+	t.Helper()
+
+	zdb := dbtest.Open(t)
+	dbtest.Seed(t, zdb,
+		"INSERT INTO clients (id, name, slug, is_active) VALUES ('client-a', 'Shop', 'shop', true), ('client-b', 'Other', 'other', true), ('client-c', 'Suspended', 'suspended', false)",
+		`INSERT INTO domains (id, client_id, domain, normalized_domain, is_active) VALUES
+			('domain-1', 'client-a', 'shop.example.com', 'shop.example.com', true),
+			('domain-2', 'client-a', 'old.example.com', 'old.example.com', false),
+			('domain-3', 'client-b', 'other.example.com', 'other.example.com', true),
+			('domain-4', 'client-c', 'suspended.example.com', 'suspended.example.com', true),
+			('domain-5', 'client-b', '*.brand.example', '*.brand.example', true)`,
+	)
+
+	return &App{ZDB: zdb, WidgetSigner: widget.NewSigner("test-secret", time.Minute)}
+}
+
+func widgetSessionRequest(app *App, headers map[string]string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/widget/session", app.createWidgetSessionHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/widget/session", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func countVisitors(t *testing.T, app *App) int {
+	t.Helper()
+	row, err := app.ZDB.QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE is_visitor = true")
+	if err != nil {
+		t.Fatalf("Failed to count visitors: %v", err)
+	}
+	count, _ := row.Values[0].AsInt64()
+	return int(count)
+}
+
+func TestWidgetSessionRejectsSpoofedOrigins(t *testing.T) {
+	app := newWidgetTestApp(t)
+
+	tests := map[string]map[string]string{
+		"no origin":                   {},
+		"opaque origin":               {"Origin": "null"},
+		"forwarded origin header":     {"Origin": "https://evil.com", "X-Original-Origin": "https://shop.example.com"},
+		"forwarded origin only":       {"X-Original-Origin": "https://shop.example.com"},
+		"client id header":            {"Origin": "https://evil.com", "X-Client-ID": "client-a"},
+		"referer only":                {"Referer": "https://shop.example.com/checkout"},
+		"host only":                   {"Host": "shop.example.com"},
+		"allowed domain as prefix":    {"Origin": "https://shop.example.com.evil.com"},
+		"allowed domain as suffix":    {"Origin": "https://evilshop.example.com"},
+		"allowed domain as subdomain": {"Origin": "https://shop.example.com.attacker.io:443"},
+		"allowed domain as userinfo":  {"Origin": "https://shop.example.com@evil.com"},
+		"allowed domain in path":      {"Origin": "https://evil.com/shop.example.com"},
+		"missing scheme":              {"Origin": "shop.example.com"},
+		"non-http scheme":             {"Origin": "file://shop.example.com"},
+		"inactive domain":             {"Origin": "https://old.example.com"},
+		"inactive client":             {"Origin": "https://suspended.example.com"},
+		"wildcard apex":               {"Origin": "https://brand.example"},
+		"wildcard as suffix":          {"Origin": "https://otherbrand.example"},
+	}
+	for name, headers := range tests {
+		w := widgetSessionRequest(app, headers)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	if n := countVisitors(t, app); n != 0 {
+		t.Errorf("Expected no visitors to be created, got %d", n)
+	}
+}
+
+func TestWidgetSessionIssuesVisitorToken(t *testing.T) {
+	app := newWidgetTestApp(t)
+
+	var sessions []widgetSessionResponse
+	for _, origin := range []string{"https://shop.example.com", "https://Shop.Example.com:8443"} {
+		w := widgetSessionRequest(app, map[string]string{"Origin": origin})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected 201, got %d: %s", origin, w.Code, w.Body.String())
+		}
+		var session widgetSessionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Each session is a new visitor in the client's single widget project
+	if sessions[0].UserID == sessions[1].UserID || sessions[0].ProjectID != sessions[1].ProjectID {
+		t.Errorf("Expected two visitors sharing one project, got %+v", sessions)
+	}
+	if n := countVisitors(t, app); n != 2 {
+		t.Errorf("Expected 2 visitors, got %d", n)
+	}
+
+	claims, err := app.WidgetSigner.Verify(sessions[0].Token)
+	if err != nil {
+		t.Fatalf("Issued token does not verify: %v", err)
+	}
+	if claims.UserID != sessions[0].UserID || claims.ClientID != "client-a" || claims.ProjectID != sessions[0].ProjectID {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	conn := &tools.ZlayDBAdapter{DB: app.ZDB}
+	if err := widget.ValidateVisitor(context.Background(), conn, claims); err != nil {
+		t.Errorf("Expected the visitor to be valid, got %v", err)
+	}
+
+	// A validly signed token cannot be rebound to another client or project
+	for _, forged := range []widget.Claims{
+		{UserID: claims.UserID, ClientID: "client-b", ProjectID: claims.ProjectID},
+		{UserID: claims.UserID, ClientID: claims.ClientID, ProjectID: "project-other"},
+	} {
+		if err := widget.ValidateVisitor(context.Background(), conn, &forged); err != widget.ErrVisitorNotFound {
+			t.Errorf("Expected ErrVisitorNotFound for %+v, got %v", forged, err)
+		}
+	}
+}
+
+func TestWidgetSessionMatchesWildcardDomains(t *testing.T) {
+	app := newWidgetTestApp(t)
+
+	for _, origin := range []string{"https://eu.brand.example", "https://Shop.EU.brand.example:8443"} {
+		w := widgetSessionRequest(app, map[string]string{"Origin": origin})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected 201, got %d: %s", origin, w.Code, w.Body.String())
+		}
+		var session widgetSessionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		claims, err := app.WidgetSigner.Verify(session.Token)
+		if err != nil || claims.ClientID != "client-b" {
+			t.Errorf("%s: expected a token for client-b, got %+v, %v", origin, claims, err)
+		}
+	}
+}
+
+const (
+	configClientID    = "6f1c2a9e-0d4b-4c1e-9a57-3b8e2f6d1a01"
+	suspendedClientID = "6f1c2a9e-0d4b-4c1e-9a57-3b8e2f6d1a02"
+)
+
+// newWidgetConfigTestApp adds clients with UUIDs, which the domain cache keys
+// by, and a secret API key that must never reach the widget
+func newWidgetConfigTestApp(t *testing.T) *App {
+	t.Helper()
+
+	app := newWidgetTestApp(t)
+	cfg := config.Default()
+	cfg.WidgetTokenSecret = "widget-token-secret"
+	app.Config = cfg
+	app.DomainCache = domains.NewCache(domains.DefaultNegativeTTL)
+
+	ctx := context.Background()
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO clients (id, name, slug, is_active, ai_api_key, branding, created_at) VALUES ($1, 'Config Shop', 'config-shop', true, 'sk-live-secret', $2, $3)`,
+			[]interface{}{configClientID, `{"display_name":"Config Shop","colors":{"primary":"#123456"},"welcome_message":"Hello","features":["history"]}`, created}},
+		{`INSERT INTO clients (id, name, slug, is_active, ai_api_key, created_at) VALUES ($1, 'Suspended', 'config-suspended', false, 'sk-suspended', $2)`,
+			[]interface{}{suspendedClientID, created}},
+		{`INSERT INTO domains (id, client_id, domain, normalized_domain, is_active) VALUES
+			('domain-10', $1, 'config.example.com', 'config.example.com', true),
+			('domain-11', $1, '*.config.example', '*.config.example', true),
+			('domain-12', $1, 'retired.config.example.com', 'retired.config.example.com', false),
+			('domain-13', $2, 'suspended.config.example.com', 'suspended.config.example.com', true)`,
+			[]interface{}{configClientID, suspendedClientID}},
+	} {
+		if _, err := app.ZDB.Execute(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("Failed to set up clients: %v", err)
+		}
+	}
+	return app
+}
+
+func widgetConfigRouter(app *App) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/widget/config", app.widgetConfigHandler)
+	router.PUT("/api/settings/branding", func(c *gin.Context) {
+		c.Set("user", User{ID: "user-1", ClientID: configClientID, IsActive: true})
+	}, app.putBrandingHandler)
+	return router
+}
+
+func widgetConfigRequest(router *gin.Engine, origin, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/widget/config", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestWidgetConfigResolvesOrigin(t *testing.T) {
+	app := newWidgetConfigTestApp(t)
+	router := widgetConfigRouter(app)
+
+	for _, origin := range []string{"https://config.example.com", "https://Config.Example.com:8443", "https://eu.config.example"} {
+		w := widgetConfigRequest(router, origin, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", origin, w.Code, w.Body.String())
+		}
+		var config widgetConfigResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		if config.Branding.DisplayName != "Config Shop" || config.Branding.Colors["primary"] != "#123456" || config.Branding.WelcomeMessage != "Hello" {
+			t.Errorf("%s: unexpected branding %+v", origin, config.Branding)
+		}
+		if !config.Capabilities.Streaming || config.Capabilities.MaxMessageLength != app.Config.MaxMessageChars {
+			t.Errorf("%s: unexpected capabilities %+v", origin, config.Capabilities)
+		}
+		if w.Header().Get("Vary") != "Origin" || !strings.Contains(w.Header().Get("Cache-Control"), "max-age=") {
+			t.Errorf("%s: expected a cacheable response varying by origin, got %v", origin, w.Header())
+		}
+	}
+
+	// Unknown, inactive and suspended origins all look alike
+	var notFound string
+	for _, origin := range []string{
+		"", "null", "https://unknown.example.com", "https://retired.config.example.com",
+		"https://suspended.config.example.com", "https://config.example", "https://config.example.com/path",
+	} {
+		w := widgetConfigRequest(router, origin, "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%q: expected 404, got %d: %s", origin, w.Code, w.Body.String())
+			continue
+		}
+		if w.Header().Get("ETag") != "" {
+			t.Errorf("%q: expected no ETag on a 404", origin)
+		}
+		if notFound == "" {
+			notFound = w.Body.String()
+		} else if w.Body.String() != notFound {
+			t.Errorf("%q: expected the same 404 body as other origins, got %s", origin, w.Body.String())
+		}
+	}
+}
+
+func TestWidgetConfigETag(t *testing.T) {
+	app := newWidgetConfigTestApp(t)
+	router := widgetConfigRouter(app)
+	origin := "https://config.example.com"
+
+	first := widgetConfigRequest(router, origin, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected a 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	if again := widgetConfigRequest(router, origin, ""); again.Header().Get("ETag") != etag {
+		t.Errorf("Expected a stable ETag, got %q then %q", etag, again.Header().Get("ETag"))
+	}
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w := widgetConfigRequest(router, origin, header)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: expected an empty 304 with the ETag, got %d %q", header, w.Code, w.Body.String())
+		}
+	}
+	if w := widgetConfigRequest(router, origin, `"stale"`); w.Code != http.StatusOK {
+		t.Errorf("Expected a stale ETag to get the config, got %d", w.Code)
+	}
+
+	// Saving the branding changes the ETag
+	body, _ := json.Marshal(map[string]interface{}{"display_name": "Renamed", "colors": map[string]string{"primary": "#ABCDEF"}})
+	req := httptest.NewRequest(http.MethodPut, "/api/settings/branding", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the branding to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = widgetConfigRequest(router, origin, etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("Expected the edited branding under a new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	var config widgetConfigResponse
+	json.Unmarshal(w.Body.Bytes(), &config)
+	if config.Branding.DisplayName != "Renamed" || config.Branding.Colors["primary"] != "#abcdef" || config.Branding.WelcomeMessage != "" {
+		t.Errorf("Expected the branding to be replaced, got %+v", config.Branding)
+	}
+}
+
+func TestWidgetConfigRejectsInvalidBranding(t *testing.T) {
+	app := newWidgetConfigTestApp(t)
+	router := widgetConfigRouter(app)
+
+	body := []byte(`{"colors":{"primary":"javascript:alert(1)"}}`)
+	req := httptest.NewRequest(http.MethodPut, "/api/settings/branding", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BRANDING_INVALID") {
+		t.Errorf("Expected BRANDING_INVALID, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWidgetConfigHasNoSecrets(t *testing.T) {
+	app := newWidgetConfigTestApp(t)
+	router := widgetConfigRouter(app)
+
+	w := widgetConfigRequest(router, "https://config.example.com", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	payload := w.Body.String()
+	for _, secret := range []string{"sk-live-secret", "sk-suspended", "widget-token-secret", configClientID, "ai_api"} {
+		if strings.Contains(payload, secret) {
+			t.Errorf("Expected the widget config not to contain %q, got %s", secret, payload)
+		}
+	}
+
+	var fields map[string]map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &fields)
+	for key := range fields {
+		if key != "branding" && key != "capabilities" {
+			t.Errorf("Unexpected top-level field %q", key)
+		}
+	}
+}
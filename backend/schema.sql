@@ -7,8 +7,20 @@ CREATE TABLE IF NOT EXISTS clients (
     ai_api_url VARCHAR(500),
     ai_api_model VARCHAR(100),
     ai_api_type VARCHAR(50),
+    max_concurrent_streams INTEGER NOT NULL DEFAULT 3, -- concurrent LLM streams allowed; excess requests are queued
+    stream_flush_chars INTEGER, -- characters per streamed frame; NULL uses STREAM_FLUSH_CHARS
+    stream_flush_interval_ms INTEGER, -- milliseconds between streamed frames; NULL uses STREAM_FLUSH_INTERVAL_MS
+    tool_result_token_limit INTEGER, -- tokens a tool result may take in the prompt before it is digested; NULL uses the default
+    daily_token_budget BIGINT, -- tokens the OpenAI-compatible API may use per UTC day; NULL is unlimited
+    widget_project_id UUID, -- project holding widget visitor conversations, created on the first widget session
+    widget_rate_limit INTEGER NOT NULL DEFAULT 10, -- user messages per minute allowed on a visitor connection
+    widget_token_limit BIGINT NOT NULL DEFAULT 20000, -- tokens allowed per visitor connection
+    allowed_models JSONB NOT NULL DEFAULT '[]', -- models users may pick per conversation besides ai_api_model
+    branding JSONB NOT NULL DEFAULT '{}', -- display name, theme colors, welcome message and features shown by the widget
+    branding_updated_at TIMESTAMP, -- last branding change; NULL until the branding is edited
     is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Create domains table
@@ -16,8 +28,10 @@ CREATE TABLE IF NOT EXISTS domains (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
     domain VARCHAR(255) NOT NULL UNIQUE,
+    normalized_domain VARCHAR(255), -- domain in normalized form; request hosts are looked up by it
     is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Create users table
@@ -27,6 +41,9 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(255) NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     is_active BOOLEAN DEFAULT true,
+    is_visitor BOOLEAN NOT NULL DEFAULT false, -- anonymous widget visitor, deleted after expires_at
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member')), -- admins manage their client's users
+    expires_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE(client_id, username)
 );
@@ -38,7 +55,12 @@ CREATE TABLE IF NOT EXISTS projects (
     name VARCHAR(255) NOT NULL,
     description TEXT,
     is_active BOOLEAN DEFAULT true,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    retention_days INTEGER, -- messages older than this are removed; NULL keeps them forever
+    retention_mode VARCHAR(10), -- delete or redact
+    retention_exempt_pinned BOOLEAN NOT NULL DEFAULT false, -- retention skips pinned conversations
+    citations_enabled BOOLEAN NOT NULL DEFAULT false, -- replies cite the tool results they rely on
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 -- Create datasources table
@@ -49,6 +71,42 @@ CREATE TABLE IF NOT EXISTS datasources (
     type VARCHAR(50) NOT NULL, -- e.g., 'postgres', 'mysql', 'mongodb'
     config JSONB NOT NULL, -- Connection details as JSON
     is_active BOOLEAN DEFAULT true,
+    schema_snapshot_interval_minutes INTEGER, -- NULL uses SCHEMA_SNAPSHOT_INTERVAL, 0 disables snapshots
+    query_policies JSONB NOT NULL DEFAULT '[]', -- Ordered {effect, pattern, type} rules for the database tools
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Datasource the database tools use when a tool call names none; added here
+-- since datasources is created after projects
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS default_datasource_id UUID REFERENCES datasources(id) ON DELETE SET NULL;
+
+-- Create project_tools table (per-project tool enable/disable)
+CREATE TABLE IF NOT EXISTS project_tools (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    tool_name VARCHAR(100) NOT NULL,
+    enabled BOOLEAN DEFAULT true NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, tool_name)
+);
+
+-- Create project_files table (uploads stored on disk keyed by id)
+CREATE TABLE IF NOT EXISTS project_files (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create api_allowlist table (URL patterns the api_request tool may call per project)
+CREATE TABLE IF NOT EXISTS api_allowlist (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    pattern VARCHAR(500) NOT NULL,
+    allow_private BOOLEAN DEFAULT false NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -59,6 +117,9 @@ CREATE TABLE IF NOT EXISTS sessions (
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     token_hash VARCHAR(255) NOT NULL,
     expires_at TIMESTAMP NOT NULL,
+    impersonated_by UUID REFERENCES users(id) ON DELETE CASCADE, -- root user who issued the session via POST /api/admin/impersonate
+    ip VARCHAR(45), -- client address at login, behind any trusted proxies
+    user_agent TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -66,25 +127,40 @@ CREATE TABLE IF NOT EXISTS sessions (
 CREATE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions(token_hash);
 CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
 CREATE INDEX IF NOT EXISTS idx_sessions_user_token_expires ON sessions(user_id, token_hash, expires_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_impersonated_by ON sessions(impersonated_by) WHERE impersonated_by IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_users_client_id_username ON users(client_id, username);
 CREATE INDEX IF NOT EXISTS idx_projects_user_id ON projects(user_id);
 CREATE INDEX IF NOT EXISTS idx_datasources_project_id ON datasources(project_id);
+-- Tenant scoping: handlers join users on client_id when resolving projects and datasources
+CREATE INDEX IF NOT EXISTS idx_users_id_client_id ON users(id, client_id);
+CREATE INDEX IF NOT EXISTS idx_projects_user_id_active ON projects(user_id, is_active, id);
+CREATE INDEX IF NOT EXISTS idx_datasources_project_id_active ON datasources(project_id, is_active);
+CREATE INDEX IF NOT EXISTS idx_project_files_project_id ON project_files(project_id);
+CREATE INDEX IF NOT EXISTS idx_api_allowlist_project_id ON api_allowlist(project_id);
 CREATE INDEX IF NOT EXISTS idx_domains_client_id ON domains(client_id);
 CREATE INDEX IF NOT EXISTS idx_domains_domain ON domains(domain);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_normalized_domain ON domains(normalized_domain);
+CREATE INDEX IF NOT EXISTS idx_users_visitor_expires_at ON users(expires_at) WHERE is_visitor = true;
 
 -- Conversation indexes for performance
 CREATE INDEX IF NOT EXISTS idx_conversations_user_project ON conversations(user_id, project_id);
 CREATE INDEX IF NOT EXISTS idx_conversations_updated_at ON conversations(updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_conversations_project_updated_at ON conversations(project_id, updated_at);
 
 -- Insert a default client for development
 INSERT INTO clients (name, slug, is_active)
 VALUES ('Development Client', 'dev', true)
 ON CONFLICT (slug) DO NOTHING;
 
+-- Root lives in a dedicated system client and binds to other clients at login
+INSERT INTO clients (name, slug, is_active)
+VALUES ('System', 'system', true)
+ON CONFLICT (slug) DO NOTHING;
+
 -- Insert root user (password: 12345678)
-INSERT INTO users (client_id, username, password_hash, is_active)
-SELECT c.id, 'root', '2qULuXcLmuJ2JeqwuEazZbnKk/ghkyDK36dob/4kutFoart8F2thvJnylwQ5eFas', true
-FROM clients c WHERE c.slug = 'dev'
+INSERT INTO users (client_id, username, password_hash, is_active, role)
+SELECT c.id, 'root', '2qULuXcLmuJ2JeqwuEazZbnKk/ghkyDK36dob/4kutFoart8F2thvJnylwQ5eFas', true, 'admin'
+FROM clients c WHERE c.slug = 'system'
 ON CONFLICT (client_id, username) DO NOTHING;
 
 -- ------------------------------------------------------------
@@ -95,11 +171,40 @@ CREATE TABLE IF NOT EXISTS conversations (
     title TEXT NOT NULL,
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
-    status VARCHAR(20) DEFAULT 'completed' NOT NULL, -- processing, completed, interrupted
+    status VARCHAR(20) DEFAULT 'completed' NOT NULL, -- queued, processing, completed, interrupted, archived
+    pinned BOOLEAN NOT NULL DEFAULT false, -- pinned conversations are listed first
+    pinned_at TIMESTAMP,
+    model VARCHAR(100), -- overrides of the client's LLM settings; NULL uses the client default
+    temperature DOUBLE PRECISION,
+    max_tokens INTEGER,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP, -- set on soft delete; purged after the retention period
+    forked_from_conversation_id UUID REFERENCES conversations(id) ON DELETE SET NULL, -- the conversation a fork was copied from
+    language VARCHAR(10), -- language the assistant responds in; detected from the first user message or chosen
+    drafts_enabled BOOLEAN NOT NULL DEFAULT false, -- replies are saved as drafts until an editor approves them
+    retention_note TEXT -- why the conversation was archived by the project's retention policy
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_deleted_at ON conversations(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_conversations_user_project_pinned ON conversations(user_id, project_id) WHERE pinned = true;
+CREATE INDEX IF NOT EXISTS idx_conversations_forked_from ON conversations(forked_from_conversation_id) WHERE forked_from_conversation_id IS NOT NULL;
+
+-- ------------------------------------------------------------
+-- Conversation participants table
+-- ------------------------------------------------------------
+-- Users who can read and post to a conversation. The creator is the owner
+-- and is the only one who can add participants or delete the conversation.
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'participant', -- owner, participant
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
 );
 
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user ON conversation_participants(user_id);
+
 -- ------------------------------------------------------------
 -- Messages table
 -- ------------------------------------------------------------
@@ -110,5 +215,428 @@ CREATE TABLE IF NOT EXISTS messages (
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     metadata JSONB,
-    tool_calls JSONB
+    tool_calls JSONB,
+    client_message_id UUID, -- client-generated ID used to drop resent user messages
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL, -- sender of a user message
+    status VARCHAR(20) NOT NULL DEFAULT 'final' -- final, or draft for a reply awaiting approval
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_message_id ON messages(conversation_id, client_message_id) WHERE client_message_id IS NOT NULL;
+
+-- ------------------------------------------------------------
+-- Message embeddings table
+-- ------------------------------------------------------------
+-- Embeddings of assistant messages for the conversation_search tool.
+-- embedding is the vector as a JSON array of floats, compared by brute force;
+-- embedding_vector holds the same vector when pgvector is available and is
+-- searched by the database instead
+CREATE TABLE IF NOT EXISTS message_embeddings (
+    message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    model VARCHAR(100) NOT NULL,
+    dimensions INTEGER NOT NULL,
+    embedding JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_embeddings_project ON message_embeddings(project_id, model, created_at);
+
+-- pgvector is optional: servers without the extension, or roles that may not
+-- create it, keep the brute-force search
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS vector;
+    ALTER TABLE message_embeddings ADD COLUMN IF NOT EXISTS embedding_vector vector;
+EXCEPTION WHEN OTHERS THEN
+    RAISE NOTICE 'pgvector is not available, conversation search falls back to brute force: %', SQLERRM;
+END
+$$;
+
+-- ------------------------------------------------------------
+-- Conversation summaries table
+-- ------------------------------------------------------------
+-- Rolling summary of the messages that no longer fit the model context,
+-- keyed by the newest message it covers
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    last_message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    summary TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, last_message_id)
+);
+
+-- ------------------------------------------------------------
+-- Message feedback table
+-- ------------------------------------------------------------
+-- Thumbs up/down ratings on messages; a user can change their rating,
+-- so there is one row per user and message
+CREATE TABLE IF NOT EXISTS message_feedback (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+    comment TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_feedback_conversation_id ON message_feedback(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_message_feedback_updated_at ON message_feedback(updated_at);
+
+-- ------------------------------------------------------------
+-- Message metrics table
+-- ------------------------------------------------------------
+-- Timing of each assistant reply for GET /api/analytics/latency. day is the
+-- UTC date of created_at, stored so grouping by day needs no date functions.
+CREATE TABLE IF NOT EXISTS message_metrics (
+    message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    model VARCHAR(100) NOT NULL,
+    ttft_ms INTEGER NOT NULL, -- until the first content or tool call
+    total_ms INTEGER NOT NULL, -- until the stream finished
+    chunk_count INTEGER NOT NULL,
+    day CHAR(10) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_metrics_created_at ON message_metrics(created_at);
+
+-- ------------------------------------------------------------
+-- Tool executions table
+-- ------------------------------------------------------------
+-- One row per execution of a tool call made by an assistant reply; the
+-- message's tool_calls keep only the call and its status. id is the tool
+-- call's ID, unique within its message. message_id has no foreign key since
+-- rows are written before the reply is saved. Results over
+-- TOOL_RESULT_MAX_INLINE_BYTES are written to result_file_path instead.
+CREATE TABLE IF NOT EXISTS tool_executions (
+    id VARCHAR(255) NOT NULL,
+    message_id UUID NOT NULL,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    tool_name VARCHAR(255) NOT NULL,
+    arguments JSONB,
+    status VARCHAR(20) NOT NULL, -- executing, completed, failed
+    result JSONB,
+    result_file_path TEXT,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    duration_ms INTEGER,
+    PRIMARY KEY (message_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tool_executions_conversation_id ON tool_executions(conversation_id);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_tool_name ON tool_executions(tool_name, status, started_at);
+CREATE INDEX IF NOT EXISTS idx_tool_executions_started_at ON tool_executions(started_at);
+
+-- ------------------------------------------------------------
+-- Query jobs table
+-- ------------------------------------------------------------
+-- Background database_query runs started with async: true. Small results
+-- are kept inline in result; larger ones are written to result_path as JSON lines
+CREATE TABLE IF NOT EXISTS query_jobs (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    datasource_id UUID REFERENCES datasources(id) ON DELETE SET NULL,
+    query TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    result TEXT,
+    result_path TEXT,
+    timeout_seconds INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_jobs_user_id ON query_jobs(user_id);
+CREATE INDEX IF NOT EXISTS idx_query_jobs_status ON query_jobs(status);
+
+-- ------------------------------------------------------------
+-- Webhooks tables
+-- ------------------------------------------------------------
+-- Outbound webhooks for conversation lifecycle events; event_types is a
+-- JSON array, empty for every event type
+CREATE TABLE IF NOT EXISTS webhooks (
+    id UUID PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_client_id ON webhooks(client_id);
+
+-- Every delivery attempt, kept for debugging failing endpoints
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    success BOOLEAN NOT NULL DEFAULT false,
+    error TEXT,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+
+-- Where a client's operator emails go; event_types is a JSON array, empty for
+-- every event type
+CREATE TABLE IF NOT EXISTS notification_settings (
+    client_id UUID PRIMARY KEY REFERENCES clients(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    event_types JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every email sent or attempted; also used to send at most one per client and
+-- event type per hour
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_client_event ON notifications(client_id, event_type, created_at);
+
+-- ------------------------------------------------------------
+-- Audit log
+-- ------------------------------------------------------------
+-- Administrative actions such as impersonation and session revocation
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor_id UUID,
+    client_id UUID,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    details JSONB,
+    ip VARCHAR(45), -- client address of the request that made the change
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_client_created ON audit_log(client_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_created ON audit_log(action, created_at DESC);
+
+-- ------------------------------------------------------------
+-- Datasource schema snapshots
+-- ------------------------------------------------------------
+-- Serialized schemas with the diff against the previous snapshot of the same datasource
+CREATE TABLE IF NOT EXISTS datasource_schema_snapshots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    datasource_id UUID NOT NULL REFERENCES datasources(id) ON DELETE CASCADE,
+    schema JSONB NOT NULL,
+    diff JSONB,
+    changed BOOLEAN NOT NULL DEFAULT false,
+    table_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_datasource_schema_snapshots_datasource_created ON datasource_schema_snapshots(datasource_id, created_at DESC);
+
+-- ------------------------------------------------------------
+-- API keys
+-- ------------------------------------------------------------
+-- Keys for programmatic access; project_id NULL makes a client-level key.
+-- Only the SHA-256 of the key is stored, key_prefix identifies it in listings.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(255) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_client_project ON api_keys(client_id, project_id);
+
+-- ------------------------------------------------------------
+-- Conversation shares
+-- ------------------------------------------------------------
+-- Read-only links to a conversation that work without signing in.
+-- include_tools exposes tool call arguments and results to link holders.
+-- token_hash is the base64 SHA-256 of the link's token, which is only shown once.
+CREATE TABLE IF NOT EXISTS conversation_shares (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    include_tools BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMP,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_shares_conversation ON conversation_shares(conversation_id);
+
+-- ------------------------------------------------------------
+-- Prompt templates
+-- ------------------------------------------------------------
+-- Saved prompts of a project. content may hold {{variable}} placeholders that
+-- are filled in by create_conversation's template_id and variables.
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    content TEXT NOT NULL,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_templates_project_name ON prompt_templates(project_id, LOWER(name));
+
+-- ------------------------------------------------------------
+-- Scheduled prompts
+-- ------------------------------------------------------------
+-- Prompts run on a cron schedule. Each run posts the prompt, or the rendered
+-- template_id, to conversation_id as created_by and delivers the reply to
+-- target. next_run_at is NULL while disabled; running_since is set while a run
+-- is in progress so the next one does not overlap it.
+CREATE TABLE IF NOT EXISTS scheduled_prompts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    cron_expression VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    prompt TEXT,
+    template_id UUID,
+    variables JSONB NOT NULL DEFAULT '{}',
+    target VARCHAR(20) NOT NULL DEFAULT 'conversation',
+    conversation_id UUID REFERENCES conversations(id) ON DELETE SET NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    next_run_at TIMESTAMP,
+    running_since TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_status VARCHAR(20),
+    last_error TEXT,
+    created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_due ON scheduled_prompts(enabled, next_run_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_project_id ON scheduled_prompts(project_id);
+
+-- ------------------------------------------------------------
+-- Token usage
+-- ------------------------------------------------------------
+-- Tokens used through the OpenAI-compatible API, one row per completion
+CREATE TABLE IF NOT EXISTS token_usage (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE SET NULL,
+    project_id UUID REFERENCES projects(id) ON DELETE SET NULL,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    estimated BOOLEAN NOT NULL DEFAULT false, -- the provider reported no usage
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_usage_client_created ON token_usage(client_id, created_at);
+
+-- ------------------------------------------------------------
+-- Tenant jobs
+-- ------------------------------------------------------------
+-- Tenant data exports and purges started from the admin API. A job keeps the
+-- client_id after the client is purged, so there is no foreign key; step is
+-- how many purge steps have finished, so an interrupted purge resumes there
+CREATE TABLE IF NOT EXISTS tenant_jobs (
+    id UUID PRIMARY KEY,
+    client_id UUID NOT NULL,
+    kind VARCHAR(20) NOT NULL, -- export, purge
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, running, completed, failed
+    step INTEGER NOT NULL DEFAULT 0,
+    counts TEXT, -- JSON object of rows exported or purged per table
+    error TEXT,
+    result_path TEXT, -- gzip'd JSON archive of an export
+    created_by UUID,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_jobs_status ON tenant_jobs(status);
+
+-- ------------------------------------------------------------
+-- Content filters
+-- ------------------------------------------------------------
+-- Redaction rules applied, in position order, to a client's assistant replies
+-- before they are streamed or saved
+CREATE TABLE IF NOT EXISTS content_filters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id UUID NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'regex', -- regex, credit_card, email
+    pattern TEXT, -- regex kind only
+    replacement VARCHAR(255) NOT NULL DEFAULT '[REDACTED]', -- inserted literally for each match
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_filters_client_id ON content_filters(client_id);
+
+-- ------------------------------------------------------------
+-- Project activity feed
+-- ------------------------------------------------------------
+-- Who did what to which entity, written in the background and pruned after
+-- ACTIVITY_RETENTION_DAYS
+CREATE TABLE IF NOT EXISTS project_events (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    actor_user_id UUID,
+    event_type VARCHAR(50) NOT NULL, -- conversation_created, tool_execution_failed, datasource_updated, ...
+    entity_type VARCHAR(50), -- conversation or datasource
+    entity_id VARCHAR(255),
+    payload JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_events_project_created ON project_events(project_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_project_events_created_at ON project_events(created_at);
+
+-- ------------------------------------------------------------
+-- Project message retention runs
+-- ------------------------------------------------------------
+-- One row per run of a project's retention policy, newest served by
+-- GET /api/projects/:id/retention/status
+CREATE TABLE IF NOT EXISTS project_retention_runs (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL, -- running, completed, failed
+    mode VARCHAR(10) NOT NULL,
+    retention_days INTEGER NOT NULL,
+    cutoff TIMESTAMP NOT NULL,
+    messages_processed INTEGER NOT NULL DEFAULT 0,
+    conversations_archived INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_retention_runs_project_started ON project_retention_runs(project_id, started_at DESC);
@@ -98,6 +98,7 @@ class WebSocketService {
           // 🔄 NEW: Notify connection establishment
           console.log('📤 Sending connection_established message')
           this.sendMessage('connection_established', {
+            protocol_version: 1,
             timestamp: Date.now(),
           })
 